@@ -5,13 +5,13 @@ import time
 from typing import List, Dict, Any, Optional, Union, Iterator, BinaryIO
 from requests.exceptions import ConnectionError, Timeout, RequestException
 
-from .exceptions import AGFSClientError
+from .exceptions import AGFSClientError, AGFSHTTPError
 
 
 class AGFSClient:
     """Client for interacting with AGFS (Plugin-based File System) Server API"""
 
-    def __init__(self, api_base_url="http://localhost:8080", timeout=10):
+    def __init__(self, api_base_url="http://localhost:8080", timeout=10, token=None, verify=True):
         """
         Initialize AGFS client.
 
@@ -20,6 +20,8 @@ class AGFSClient:
                          If "/api/v1" is not present, it will be automatically appended.
                          e.g., "http://localhost:8080" or "http://localhost:8080/api/v1"
             timeout: Request timeout in seconds (default: 10)
+            token: Bearer token sent with every request, for servers with tenants
+            verify: TLS certificate verification: True, False, or the path of a CA bundle
         """
         api_base_url = api_base_url.rstrip("/")
         # Auto-append /api/v1 if not present
@@ -27,6 +29,9 @@ class AGFSClient:
             api_base_url = api_base_url + "/api/v1"
         self.api_base = api_base_url
         self.session = requests.Session()
+        self.session.verify = verify
+        if token:
+            self.session.headers["Authorization"] = f"Bearer {token}"
         self.timeout = timeout
 
     def _handle_request_error(self, e: Exception, operation: str = "request") -> None:
@@ -84,12 +89,31 @@ class AGFSClient:
         response.raise_for_status()
         return response.json()
 
-    def ls(self, path: str = "/") -> List[Dict[str, Any]]:
-        """List directory contents"""
+    def status(self) -> Dict[str, Any]:
+        """Get server status: version, uptime, mounts, external plugins and listeners"""
+        try:
+            response = self.session.get(f"{self.api_base}/status", timeout=self.timeout)
+            response.raise_for_status()
+            return response.json()
+        except Exception as e:
+            self._handle_request_error(e)
+
+    def ls(self, path: str = "/", fields: Optional[str] = None) -> List[Dict[str, Any]]:
+        """List directory contents
+
+        Args:
+            path: Directory path
+            fields: Comma-separated entry fields to return, e.g. "name" or "name,size"
+                (default: all); the name and isDir are always returned, and backends
+                can skip expensive metadata that is not asked for
+        """
+        params = {"path": path}
+        if fields:
+            params["fields"] = fields
         try:
             response = self.session.get(
                 f"{self.api_base}/directories",
-                params={"path": path},
+                params=params,
                 timeout=self.timeout
             )
             response.raise_for_status()
@@ -236,6 +260,134 @@ class AGFSClient:
         if last_error:
             self._handle_request_error(last_error)
 
+    def read_with_etag(self, path: str):
+        """Read a whole file with its ETag, for a later write_conditional
+
+        Args:
+            path: File path
+
+        Returns:
+            Tuple of the file content and its ETag
+        """
+        try:
+            response = self.session.get(
+                f"{self.api_base}/files",
+                params={"path": path},
+                timeout=self.timeout
+            )
+            response.raise_for_status()
+            return response.content, response.headers.get("ETag", "")
+        except Exception as e:
+            self._handle_request_error(e)
+
+    def write_conditional(self, path: str, data: bytes, if_match: Optional[str] = None,
+                          if_none_match: Optional[str] = None) -> str:
+        """Write a file only if it is unchanged, or only if it does not exist
+
+        Args:
+            path: File path
+            data: New content
+            if_match: ETag the file must still have, from read_with_etag
+            if_none_match: "*" to only create the file
+
+        Returns:
+            The ETag of the new content
+
+        Raises:
+            AGFSHTTPError: With status_code 412 if the condition failed, e.g. because
+                the file was modified since it was read
+        """
+        headers = {}
+        if if_match:
+            headers["If-Match"] = if_match
+        if if_none_match:
+            headers["If-None-Match"] = if_none_match
+        try:
+            response = self.session.put(
+                f"{self.api_base}/files",
+                params={"path": path},
+                data=data,
+                headers=headers,
+                timeout=self.timeout
+            )
+            if response.status_code == 412:
+                try:
+                    message = response.json().get("error", "precondition failed")
+                except ValueError:
+                    message = "precondition failed"
+                raise AGFSHTTPError(message, status_code=412)
+            response.raise_for_status()
+            return response.headers.get("ETag", "")
+        except AGFSHTTPError:
+            raise
+        except Exception as e:
+            self._handle_request_error(e)
+
+    def write_at(self, path: str, data: bytes, offset: int) -> Dict[str, Any]:
+        """Write data into a file at an offset, keeping the rest of the file
+
+        Writing past the end of the file fills the gap with zeros, a missing file
+        is created. Large uploads can be sent in chunks this way and resumed
+        from the size of the file after an interruption.
+
+        Args:
+            path: File path
+            data: Bytes to write
+            offset: Position of the first byte
+
+        Returns:
+            Dict with 'path', 'offset', 'bytes' (bytes written) and 'size' (size of
+            the file after the write)
+        """
+        try:
+            response = self.session.put(
+                f"{self.api_base}/files",
+                params={"path": path, "offset": str(offset)},
+                data=data,
+                timeout=self.timeout
+            )
+            response.raise_for_status()
+            return response.json()
+        except Exception as e:
+            self._handle_request_error(e)
+
+    def read_range(self, path: str, offset: int, size: int = -1):
+        """Read a byte range of a file with a Range request
+
+        Args:
+            path: File path
+            offset: Position of the first byte
+            size: Number of bytes to read (default: -1, to the end of the file)
+
+        Returns:
+            Tuple of the bytes read and the size of the whole file; the bytes are
+            empty if offset is at or past the end of the file
+        """
+        last = "" if size < 0 else str(offset + size - 1)
+        try:
+            response = self.session.get(
+                f"{self.api_base}/files",
+                params={"path": path},
+                headers={"Range": f"bytes={offset}-{last}"},
+                timeout=self.timeout
+            )
+            total = None
+            content_range = response.headers.get("Content-Range", "")
+            if "/" in content_range:
+                total = int(content_range.rsplit("/", 1)[1])
+            if response.status_code == 416 and total is not None:
+                return b"", total
+            response.raise_for_status()
+            if total is None:
+                # Servers without range support return the whole file
+                data = response.content
+                total = len(data)
+                data = data[offset:] if size < 0 else data[offset:offset + size]
+                return data, total
+            return response.content, total
+        except Exception as e:
+            self._handle_request_error(e)
+
     def create(self, path: str) -> Dict[str, Any]:
         """Create a new file"""
         try:
@@ -342,21 +494,25 @@ class AGFSClient:
         except Exception as e:
             self._handle_request_error(e)
 
-    def mount(self, fstype: str, path: str, config: Dict[str, Any]) -> Dict[str, Any]:
+    def mount(self, fstype: str, path: str, config: Dict[str, Any], dry_run: bool = False) -> Dict[str, Any]:
         """Mount a plugin dynamically
 
         Args:
             fstype: Filesystem type (e.g., 'sqlfs', 's3fs', 'memfs')
             path: Mount path
             config: Plugin configuration as dictionary
+            dry_run: Only validate the mount, without mounting it
 
         Returns:
             Response with message
         """
         try:
+            body = {"fstype": fstype, "path": path, "config": config}
+            if dry_run:
+                body["dry_run"] = True
             response = self.session.post(
                 f"{self.api_base}/mount",
-                json={"fstype": fstype, "path": path, "config": config},
+                json=body,
                 timeout=self.timeout
             )
             response.raise_for_status()
@@ -417,6 +573,28 @@ class AGFSClient:
         except Exception as e:
             self._handle_request_error(e)
 
+    def plugin_types(self, name: Optional[str] = None) -> List[Dict[str, Any]]:
+        """List the plugin types that can be mounted, with their configuration schemas
+
+        Args:
+            name: Only describe this plugin type
+
+        Returns:
+            List of {"name", "hasSchema", "schema"}; each schema entry has name, type,
+            required, default, choices, secret and description
+        """
+        try:
+            params = {"name": name} if name else None
+            response = self.session.get(
+                f"{self.api_base}/plugin-types",
+                params=params,
+                timeout=self.timeout
+            )
+            response.raise_for_status()
+            return response.json().get("types", [])
+        except Exception as e:
+            self._handle_request_error(e)
+
     def list_plugins(self) -> List[str]:
         """List all loaded external plugins
 
@@ -434,7 +612,8 @@ class AGFSClient:
         except Exception as e:
             self._handle_request_error(e)
 
-    def grep(self, path: str, pattern: str, recursive: bool = False, case_insensitive: bool = False, stream: bool = False):
+    def grep(self, path: str, pattern: str, recursive: bool = False, case_insensitive: bool = False, stream: bool = False,
+             max_files: Optional[int] = None, max_bytes: Optional[int] = None, max_time: Optional[str] = None):
         """Search for a pattern in files using regular expressions
 
         Args:
@@ -443,9 +622,13 @@ class AGFSClient:
             recursive: Whether to search recursively in directories (default: False)
             case_insensitive: Whether to perform case-insensitive matching (default: False)
             stream: Whether to stream results as NDJSON (default: False)
+            max_files: Files to scan at most (default: the server budget)
+            max_bytes: Bytes to read at most (default: the server budget)
+            max_time: Time to search at most, e.g. "10s" (default: the server budget)
 
         Returns:
-            If stream=False: Dict with 'matches' (list of match objects) and 'count'
+            If stream=False: Dict with 'matches' (list of match objects), 'count', and
+                'truncated' (with the 'limit' that ran out) if the budget was used up
             If stream=True: Iterator yielding match dicts and a final summary dict
 
         Example (non-stream):
@@ -460,16 +643,20 @@ class AGFSClient:
             ...     else:
             ...         print(f"{item['file']}:{item['line']}: {item['content']}")
         """
+        body = {
+            "path": path,
+            "pattern": pattern,
+            "recursive": recursive,
+            "case_insensitive": case_insensitive,
+            "stream": stream
+        }
+        for key, value in (("max_files", max_files), ("max_bytes", max_bytes), ("max_time", max_time)):
+            if value is not None:
+                body[key] = value
         try:
             response = self.session.post(
                 f"{self.api_base}/grep",
-                json={
-                    "path": path,
-                    "pattern": pattern,
-                    "recursive": recursive,
-                    "case_insensitive": case_insensitive,
-                    "stream": stream
-                },
+                json=body,
                 timeout=None if stream else self.timeout,
                 stream=stream
             )
@@ -495,6 +682,109 @@ class AGFSClient:
                     # Skip malformed lines
                     continue
 
+    def watch(self, path: str, recursive: bool = False, poll_interval: float = 1.0) -> Iterator[Dict[str, Any]]:
+        """Watch a file or directory for changes
+
+        Subscribes to the server-sent events of GET /watch. Servers without the
+        endpoint are polled every poll_interval seconds instead; polling reports
+        a rename as a remove and a create.
+
+        Args:
+            path: File or directory to watch
+            recursive: Whether to watch the whole tree under path (default: False)
+            poll_interval: Seconds between polls when polling (default: 1.0)
+
+        Returns:
+            Iterator yielding events such as
+            {"type": "write", "path": "/memfs/a.txt", "isDir": False, "time": "..."};
+            type is "create", "write", "remove" or "rename", and renames have "oldPath"
+
+        Example:
+            >>> for event in client.watch("/memfs/inbox", recursive=True):
+            ...     print(event["type"], event["path"])
+        """
+        # Fail on a missing path before the first event is awaited
+        snapshot = self._snapshot(path, recursive)
+        try:
+            response = self.session.get(
+                f"{self.api_base}/watch",
+                params={"path": path, "recursive": "true" if recursive else "false"},
+                timeout=(self.timeout, None),
+                stream=True
+            )
+            if response.status_code in (404, 405, 501):
+                response.close()
+                return self._poll_changes(path, recursive, poll_interval, snapshot)
+            response.raise_for_status()
+            return self._parse_sse_stream(response)
+        except Exception as e:
+            self._handle_request_error(e)
+
+    def _parse_sse_stream(self, response):
+        """Parse a server-sent events response into the JSON objects of its data fields"""
+        import json
+        data = []
+        event_type = None
+        for line in response.iter_lines(decode_unicode=True):
+            if line is None:
+                continue
+            if line == "":
+                # A blank line ends an event
+                if data:
+                    try:
+                        event = json.loads("\n".join(data))
+                        if event_type and isinstance(event, dict):
+                            event.setdefault("type", event_type)
+                        yield event
+                    except json.JSONDecodeError:
+                        pass
+                data = []
+                event_type = None
+            elif line.startswith(":"):
+                continue  # Comment, such as a keep-alive
+            elif line.startswith("data:"):
+                data.append(line[5:].lstrip(" "))
+            elif line.startswith("event:"):
+                event_type = line[6:].strip()
+
+    def _poll_changes(self, path: str, recursive: bool, interval: float, previous: Dict[str, Dict[str, Any]]):
+        """Compare snapshots of path every interval seconds with previous and yield the differences"""
+        while True:
+            time.sleep(interval)
+            try:
+                current = self._snapshot(path, recursive)
+            except AGFSClientError:
+                current = {}  # The watched path itself was removed
+            now = time.strftime("%Y-%m-%dT%H:%M:%S%z")
+            for p in sorted(set(previous) | set(current)):
+                old, new = previous.get(p), current.get(p)
+                if old is None:
+                    kind = "create"
+                elif new is None:
+                    kind = "remove"
+                elif old != new and not new["isDir"]:
+                    kind = "write"
+                else:
+                    continue
+                yield {"type": kind, "path": p, "isDir": (new or old)["isDir"], "time": now}
+            previous = current
+
+    def _snapshot(self, path: str, recursive: bool) -> Dict[str, Dict[str, Any]]:
+        """Map path and the entries under it to their type, size and modification time"""
+        info = self.stat(path)
+        is_dir = info.get("isDir", False)
+        snapshot = {path: {"isDir": is_dir, "size": info.get("size"), "modTime": info.get("modTime")}}
+        dirs = [path] if is_dir else []
+        while dirs:
+            directory = dirs.pop()
+            for entry in self.ls(directory):
+                child = directory.rstrip("/") + "/" + entry["name"]
+                entry_is_dir = entry.get("isDir", False)
+                snapshot[child] = {"isDir": entry_is_dir, "size": entry.get("size"), "modTime": entry.get("modTime")}
+                if recursive and entry_is_dir:
+                    dirs.append(child)
+        return snapshot
+
     def digest(self, path: str, algorithm: str = "xxh3") -> Dict[str, Any]:
         """Calculate the digest of a file using specified algorithm
 
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bench"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
+)
+
+// runBench implements the bench subcommand:
+//
+//	agfs-server bench -server http://localhost:8080 -path /memfs/bench [flags]
+//
+// By default the workloads run in this process through the HTTP API, so the results include
+// network and server overhead; with -server-side the server runs them against the filesystem
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "AGFS server URL")
+	dir := fs.String("path", "", "Scratch directory to create and remove, e.g. /memfs/bench (required)")
+	streamPath := fs.String("stream-path", "", "Stream file for the stream workload, e.g. /streamfs/bench")
+	workloads := fs.String("workloads", "", "Comma-separated workloads: "+strings.Join(bench.AllWorkloads, ", ")+" or all (default: all but stream)")
+	duration := fs.Duration("duration", 5*time.Second, "Duration of each workload")
+	blockSize := fs.String("block-size", "64KB", "Size of reads, random writes and stream chunks")
+	fileSize := fs.String("file-size", "16MB", "Size of the sequential files")
+	files := fs.Int("files", 100, "Files in the random write and metadata pools")
+	concurrency := fs.Int("concurrency", 4, "Concurrent workers")
+	readers := fs.Int("readers", 4, "Stream readers")
+	format := fs.String("format", "json", "Output format: json or csv")
+	serverSide := fs.Bool("server-side", false, "Run the workloads in the server instead of through the API")
+	fs.Parse(args)
+
+	fail := func(format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "bench: "+format+"\n", a...)
+		return 1
+	}
+	if *dir == "" {
+		return fail("-path is required")
+	}
+	if *format != "json" && *format != "csv" {
+		return fail("-format must be json or csv")
+	}
+
+	if *serverSide {
+		q := url.Values{}
+		q.Set("path", *dir)
+		q.Set("stream_path", *streamPath)
+		q.Set("workloads", *workloads)
+		q.Set("duration", duration.String())
+		q.Set("block_size", *blockSize)
+		q.Set("file_size", *fileSize)
+		q.Set("files", strconv.Itoa(*files))
+		q.Set("concurrency", strconv.Itoa(*concurrency))
+		q.Set("readers", strconv.Itoa(*readers))
+		q.Set("format", *format)
+		resp, err := http.Post(strings.TrimSuffix(*server, "/")+"/api/v1/bench?"+q.Encode(), "", nil)
+		if err != nil {
+			return fail("%v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(resp.Body)
+			return fail("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		}
+		io.Copy(os.Stdout, resp.Body)
+		return 0
+	}
+
+	opts := bench.Options{
+		Dir:         *dir,
+		StreamPath:  *streamPath,
+		Duration:    *duration,
+		Files:       *files,
+		Concurrency: *concurrency,
+		Readers:     *readers,
+	}
+	var err error
+	if opts.Workloads, err = bench.ParseWorkloads(*workloads); err != nil {
+		return fail("%v", err)
+	}
+	if opts.BlockSize, err = pluginconfig.ParseSize(*blockSize); err != nil {
+		return fail("invalid -block-size: %v", err)
+	}
+	if opts.FileSize, err = pluginconfig.ParseSize(*fileSize); err != nil {
+		return fail("invalid -file-size: %v", err)
+	}
+
+	remote := proxyfs.NewProxyFS(*server, "bench")
+	report, err := bench.Run(context.Background(), remote, *server, opts)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if *format == "csv" {
+		err = report.WriteCSV(os.Stdout)
+	} else {
+		err = report.WriteJSON(os.Stdout)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+	return 0
+}
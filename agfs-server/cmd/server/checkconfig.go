@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/embed"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// plannedMount is an enabled plugin instance the server would mount
+type plannedMount struct {
+	config.PluginInstance
+	Options []string // Mount option keys set in the instance config
+	Builtin bool     // The plugin is built in, so its configuration was validated
+	Err     error    // Invalid mount options or plugin configuration
+}
+
+// planMounts validates cfg and the mount options and plugin configuration of every enabled
+// instance of a built-in plugin, without mounting anything or touching backends. The error
+// reports the problems of the configuration and of all instances.
+func planMounts(cfg *config.Config) ([]plannedMount, error) {
+	errs := []error{config.ValidateConfig(cfg)}
+
+	plugins := embed.BuiltinPlugins()
+	if cfg.Server.DevMode {
+		for name, factory := range embed.DevPlugins() {
+			plugins[name] = factory
+		}
+	}
+	instances, _ := cfg.PluginInstances() // Errors are reported by ValidateConfig
+	var mounts []plannedMount
+	for _, instance := range instances {
+		if !instance.Enabled {
+			continue
+		}
+		m := plannedMount{PluginInstance: instance}
+		_, pluginConfig, err := mountablefs.SplitMountOptions(instance.Config)
+		if err == nil {
+			for k := range instance.Config {
+				if _, ok := pluginConfig[k]; !ok {
+					m.Options = append(m.Options, k)
+				}
+			}
+			sort.Strings(m.Options)
+		}
+		factory, ok := plugins[instance.Plugin]
+		if err == nil && ok {
+			m.Builtin = true
+			configWithPath := map[string]interface{}{"mount_path": instance.Path}
+			for k, v := range pluginConfig {
+				configWithPath[k] = v
+			}
+			err = factory().Validate(configWithPath)
+		}
+		if err != nil {
+			m.Err = err
+			errs = append(errs, fmt.Errorf("plugins.%s: instance '%s': %w", instance.Plugin, instance.Name, err))
+		}
+		mounts = append(mounts, m)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Path < mounts[j].Path })
+	return mounts, errors.Join(errs...)
+}
+
+// runCheckConfig implements the -check-config flag:
+//
+//	agfs-server -c config.yaml -check-config
+//
+// It validates the configuration with planMounts and reports the result
+func runCheckConfig(cfg *config.Config, file string) int {
+	mounts, err := planMounts(cfg)
+	for _, m := range mounts {
+		if !m.Builtin && m.Err == nil {
+			fmt.Printf("%s: plugin %s of instance '%s' is not built in; it is checked when loaded\n", file, m.Plugin, m.Name)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid configuration:\n%v\n", file, err)
+		return 1
+	}
+	fmt.Printf("%s: OK (%d mounts)\n", file, len(mounts))
+	return 0
+}
+
+// runDryRun implements the -dry-run flag:
+//
+//	agfs-server -c config.yaml -dry-run
+//
+// It prints the mount table the server would create and the problems of the configuration,
+// without binding the listen address
+func runDryRun(cfg *config.Config, file string) int {
+	mounts, err := planMounts(cfg)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tPLUGIN\tINSTANCE\tOPTIONS\tSTATUS")
+	for _, m := range mounts {
+		options := strings.Join(m.Options, ",")
+		if options == "" {
+			options = "-"
+		}
+		status := "ok"
+		switch {
+		case m.Err != nil:
+			status = "invalid: " + m.Err.Error()
+		case !m.Builtin:
+			status = "external, not checked"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Path, m.Plugin, m.Name, options, status)
+	}
+	w.Flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s: invalid configuration:\n%v\n", file, err)
+		return 1
+	}
+	return 0
+}
+
+// runDiff implements the -diff flag:
+//
+//	agfs-server -diff old.yaml new.yaml
+//
+// It prints the mounts that would be added (+), removed (-) or changed (~) by replacing
+// the old configuration with the new one. Like diff, it exits with 0 if nothing changes,
+// 1 if mounts change and 2 on errors.
+func runDiff(oldFile, newFile string) int {
+	oldCfg, err := config.LoadConfig(oldFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", oldFile, err)
+		return 2
+	}
+	newCfg, err := config.LoadConfig(newFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", newFile, err)
+		return 2
+	}
+	changes, err := config.DiffMounts(oldCfg, newCfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+		return 2
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
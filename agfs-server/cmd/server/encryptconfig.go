@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runEncryptConfig implements the encrypt-config subcommand:
+//
+//	agfs-server encrypt-config [-key-file config.key] [-keys regexp] [-w] config.yaml
+//	agfs-server encrypt-config -value -path plugins.sqlfs.config.password < secret.txt
+//	agfs-server encrypt-config -generate-key > config.key
+//
+// It encrypts the values of the configuration whose names match -keys, printing the result
+// or with -w replacing the file; the key comes from -key-file, else $AGFS_CONFIG_KEY or the
+// secrets section of the configuration, as when the server loads it
+func runEncryptConfig(args []string) int {
+	fs := flag.NewFlagSet("encrypt-config", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "File holding the base64 key (default: the one the configuration names)")
+	keys := fs.String("keys", config.DefaultEncryptedKeys, "Regular expression matching the names of the values to encrypt")
+	write := fs.Bool("w", false, "Write the result to the configuration file instead of standard output")
+	value := fs.Bool("value", false, "Encrypt the string read from standard input for the key -path and print it")
+	path := fs.String("path", "", "Key path the value of -value is for, e.g. plugins.sqlfs.config.password")
+	generate := fs.Bool("generate-key", false, "Print a new random key and exit")
+	fs.Parse(args)
+
+	fail := func(format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "encrypt-config: "+format+"\n", a...)
+		return 1
+	}
+	if *generate {
+		key, err := config.GenerateKey()
+		if err != nil {
+			return fail("%v", err)
+		}
+		fmt.Println(key)
+		return 0
+	}
+	pattern, err := regexp.Compile(*keys)
+	if err != nil {
+		return fail("invalid -keys: %v", err)
+	}
+	if *value && *path == "" {
+		return fail("-value needs the -path of the key the value goes to, values only decrypt there")
+	}
+	if !*value && fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: agfs-server encrypt-config [-key-file file] [-keys regexp] [-w] config.yaml")
+		return 2
+	}
+
+	var data []byte
+	var secrets config.SecretsConfig
+	if fs.NArg() == 1 {
+		if data, err = os.ReadFile(fs.Arg(0)); err != nil {
+			return fail("%v", err)
+		}
+		var head struct {
+			Secrets config.SecretsConfig `yaml:"secrets"`
+		}
+		if err := yaml.Unmarshal(data, &head); err != nil {
+			return fail("failed to parse config file: %v", err)
+		}
+		secrets = head.Secrets
+	}
+	if *keyFile != "" {
+		os.Unsetenv(config.KeyEnv)
+		secrets = config.SecretsConfig{KeyFile: *keyFile}
+	}
+	key, err := config.LoadKey(secrets)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	if *value {
+		plain, err := io.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			return fail("%v", err)
+		}
+		enc, err := config.EncryptValue(key, *path, strings.TrimRight(string(plain), "\r\n"), "str")
+		if err != nil {
+			return fail("%v", err)
+		}
+		fmt.Println(enc)
+		return 0
+	}
+
+	out, count, err := config.EncryptConfig(data, key, pattern)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if !*write {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := os.WriteFile(fs.Arg(0), out, 0644); err != nil {
+		return fail("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "encrypted %d values of %s\n", count, fs.Arg(0))
+	return 0
+}
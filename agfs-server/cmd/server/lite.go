@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+)
+
+// runLite implements the serve subcommand, which runs without a configuration file:
+//
+//	agfs-server serve -data ./agfs.db
+//
+// sqlfs holds / in the SQLite data file, which also stores the tags; memfs serves
+// /tmp and streamfs /streams, which are kept in memory
+func runLite(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	data := fs.String("data", "agfs.db", "SQLite file holding the files and tags, created if missing")
+	addr := fs.String("addr", ":8080", "Server listen address")
+	logLevel := fs.String("log-level", "info", "Log level: debug, info, warn, error")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "serve: unexpected argument %q\n", fs.Arg(0))
+		return 1
+	}
+	if dir := filepath.Dir(*data); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			return 1
+		}
+	}
+
+	runServer(liteConfig(*data, *logLevel), *addr)
+	return 0
+}
+
+// liteConfig returns the configuration of the serve subcommand with its data in dataPath
+func liteConfig(dataPath, logLevel string) *config.Config {
+	return &config.Config{
+		Server: config.ServerConfig{LogLevel: logLevel},
+		Plugins: map[string]config.PluginConfig{
+			"sqlfs": {
+				Enabled: true,
+				Path:    "/",
+				Config:  map[string]interface{}{"backend": "sqlite", "db_path": dataPath},
+			},
+			"memfs":    {Enabled: true, Path: "/tmp"},
+			"streamfs": {Enabled: true, Path: "/streams"},
+		},
+		Tags: config.TagsConfig{Enabled: true, DBPath: dataPath},
+	}
+}
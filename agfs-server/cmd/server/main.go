@@ -1,29 +1,49 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/analytics"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/auth"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/backup"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/cluster"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/diagnostics"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/embed"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filedrop"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/holds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/idempotency"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/lifecycle"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mcp"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/migrate"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mtls"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pipeline"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/heartbeatfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/hellofs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/httpfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/kvfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/s3fs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/loader"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/serverinfofs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs2"
-	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/streamfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/queuearchive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/recorder"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/smb"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tenancy"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/usage"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -34,25 +54,8 @@ var (
 	GitCommit = "unknown"
 )
 
-// PluginFactory is a function that creates a new plugin instance
-type PluginFactory func() plugin.ServicePlugin
-
 // availablePlugins maps plugin names to their factory functions
-var availablePlugins = map[string]PluginFactory{
-	"serverinfofs": func() plugin.ServicePlugin { return serverinfofs.NewServerInfoFSPlugin() },
-	"memfs":        func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() },
-	"queuefs":      func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() },
-	"kvfs":         func() plugin.ServicePlugin { return kvfs.NewKVFSPlugin() },
-	"hellofs":      func() plugin.ServicePlugin { return hellofs.NewHelloFSPlugin() },
-	"heartbeatfs":  func() plugin.ServicePlugin { return heartbeatfs.NewHeartbeatFSPlugin() },
-	"httpfs":       func() plugin.ServicePlugin { return httpfs.NewHTTPFSPlugin() },
-	"proxyfs":      func() plugin.ServicePlugin { return proxyfs.NewProxyFSPlugin("") },
-	"s3fs":         func() plugin.ServicePlugin { return s3fs.NewS3FSPlugin() },
-	"streamfs":     func() plugin.ServicePlugin { return streamfs.NewStreamFSPlugin() },
-	"sqlfs":        func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() },
-	"sqlfs2":       func() plugin.ServicePlugin { return sqlfs2.NewSQLFS2Plugin() },
-	"localfs":      func() plugin.ServicePlugin { return localfs.NewLocalFSPlugin() },
-}
+var availablePlugins = embed.BuiltinPlugins()
 
 const sampleConfig = `# AGFS Server Configuration File
 # This is a sample configuration showing all available options
@@ -60,6 +63,17 @@ const sampleConfig = `# AGFS Server Configuration File
 server:
   address: ":8080"          # Server listen address
   log_level: "info"         # Log level: debug, info, warn, error
+  dev_mode: false           # Enables development-only plugins (faultfs); never in production
+  data_dir: "data"          # Local state of plugins (caches, indexes), one directory per mount
+  cors_origins: []          # Web pages of other origins reading streams as SSE, e.g. ["https://dash.example.com"]
+  # admin_address: "unix:/run/agfs/admin.sock"  # Serve mount, plugin, job and service management only here ("host:port" or "unix:<path>")
+  # Serve HTTPS; with ca_file, clients must present a certificate signed by the CA (mutual
+  # TLS), and proxyfs and cluster connections to other servers use the same certificates
+  # tls:
+  #   cert_file: "/etc/agfs/tls/server.crt"
+  #   key_file: "/etc/agfs/tls/server.key"
+  #   ca_file: "/etc/agfs/tls/ca.crt"
+  #   reload_interval: "1m"   # Rotated files are picked up without a restart
 
 # Plugin configurations
 plugins:
@@ -73,6 +87,94 @@ plugins:
     enabled: true
     path: "/memfs"
 
+  # Temporary File System - top-level directories expire after a TTL
+  tmpfs:
+    enabled: false
+    path: "/tmpfs"
+    config:
+      default_ttl: "1h"
+
+  # Agent File System - tasks submitted as files, run by external or built-in LLM workers
+  agentfs:
+    enabled: false
+    path: "/agentfs"
+    config:
+      retention: "24h"
+      # llm_url: "https://api.openai.com/v1"   # Enables built-in workers
+      # llm_model: "gpt-4o-mini"
+      # llm_api_key: "sk-..."
+      # task_workers: 2                        # Built-in workers running tasks at once
+
+  # Vector File System - documents indexed by embedding, searched by similarity
+  vectorfs:
+    enabled: false
+    path: "/vectorfs"
+    config:
+      embedder: "hash"                       # "hash" (local) or "openai"
+      # embedding_url: "https://api.openai.com/v1"
+      # embedding_model: "text-embedding-3-small"
+      # api_key: "sk-..."
+
+  # Shard File System - one directory spread across several backing paths by file name hash
+  shardfs:
+    enabled: false
+    path: "/shardfs"
+    config:
+      shards: ["/s3a/data", "/s3b/data", "/sqlfs/data"]
+
+  # Config File System - JSON/YAML configs validated against per-directory schemas, with history
+  configfs:
+    enabled: false
+    path: "/configfs"
+    config:
+      store: "/sqlfs/configfs"
+      max_history: 20
+
+  # Stats File System - counters and timers written as files, readable as JSON or Prometheus text
+  statsfs:
+    enabled: false
+    path: "/statsfs"
+    config:
+      max_samples: 1024                      # Recent durations timer percentiles are computed from
+
+  # Time Series File System - append-only series read back by range with downsampling
+  tsfs:
+    enabled: false
+    path: "/tsfs"
+    config:
+      store: "/sqlfs/tsfs"                   # Optional, series are kept in memory only without it
+      retention: "30d"
+
+  # Inbox File System - notifications fanned out to named consumers, each with its own cursor
+  inboxfs:
+    enabled: false
+    path: "/inboxfs"
+    config:
+      ttl: "7d"                              # Age at which messages are dropped
+      max_messages: 10000                    # Messages retained per topic
+
+  # Form File System - HTML form posts stored as timestamped JSON files, e.g. for a site served by httpfs
+  formfs:
+    enabled: false
+    path: "/formfs"
+    config:
+      dir: "/sqlfs/forms/contact"            # Or queue: "/queuefs/forms"
+      fields: ["name", "email", "message"]
+      required: ["email"]
+      honeypot: "website"                    # Hidden field bots fill in
+      rate_limit: 10                         # Posts per client IP and minute
+      max_body: "64KB"
+      port: "8001"
+
+  # Script File System - virtual files computed by a Lua script with read/write/list hooks
+  scriptfs:
+    enabled: false
+    path: "/scriptfs"
+    config:
+      script_path: "/memfs/scripts/status.lua" # Or inline source in script
+      reload_interval: "2s"                  # How often script_path is checked for changes
+      timeout: "5s"                          # Longest a hook may run
+
   # Queue File System - message queue operations
   queuefs:
     enabled: true
@@ -88,6 +190,15 @@ plugins:
     enabled: true
     path: "/hellofs"
 
+  # Tutorial File System - guided tour with step directories, checkpoints and /progress
+  tutorialfs:
+    enabled: false
+    path: "/tutorial"
+    config:
+      memfs_path: "/memfs"                   # Mounts the steps use
+      queuefs_path: "/queuefs"
+      kvfs_path: "/kvfs"
+
   # Stream File System - streaming file operations
   streamfs:
     enabled: true
@@ -148,13 +259,318 @@ plugins:
         config:
           base_url: "http://remote-server-2:8080/api/v1"
           remote_path: "/memfs"
+
+# Scheduled backups - copy a snapshot of a path into a timestamped directory
+# Status is available at /serverinfofs/backups and GET /api/v1/backups
+backups:
+  - name: "nightly-sqlite"
+    enabled: false
+    source: "/sqlfs/sqlite"
+    target: "/s3fs/backups/sqlite"
+    interval: "24h"         # Time between runs
+    at: "02:00"             # Optional local time of the first run
+    retention:
+      daily: 7              # Keep the newest backup of each of the last 7 days
+      weekly: 4             # ... and of each of the last 4 weeks
+
+# Lifecycle rules moving files to a colder mount or deleting them once they are old enough
+# Status at /serverinfofs/lifecycle and GET /api/v1/lifecycle, dry runs with POST /api/v1/lifecycle/run?dry_run=true
+lifecycle:
+  interval: "1h"                     # Time between evaluations
+  audit_log: "lifecycle-audit.log"   # Local file receiving one JSON line per action
+  rules:
+    - name: "tier-logs"
+      enabled: false
+      path: "/memfs/logs"
+      older_than: "7d"               # Since the last modification
+      action: "move"                 # "move" or "delete"
+      target: "/s3fs/archive/logs"
+      include: "*.log"
+    - name: "expire-scratch"
+      enabled: false
+      path: "/memfs/scratch"
+      older_than: "30d"
+      action: "delete"
+      dry_run: true                  # Only report what would be deleted
+
+# Archivers draining queuefs queues into NDJSON files on another mount, on a schedule or threshold
+# Status at /serverinfofs/queue_archive and GET /api/v1/queue-archive, drain now with POST /api/v1/queue-archive/run
+queue_archive:
+  state_dir: "queue-archive"         # Local directory keeping the checkpoints of the batches in flight
+  archivers:
+    - name: "events"
+      enabled: false
+      queue: "/queuefs/events"
+      target: "/s3fs/archive/events"
+      interval: "5m"                 # Time between scheduled drains
+      threshold: 1000                # Drain as soon as the queue holds this many messages
+      batch_size: 1000               # Messages per file at most
+      retry_interval: "30s"          # First delay before retrying a failed drain, doubled up to interval
+
+# Watchers enqueueing an event (path, size, digest) for every file dropped in a directory
+# Status at /serverinfofs/file_drop and GET /api/v1/file-drop, scan now with POST /api/v1/file-drop/run
+file_drop:
+  state_dir: "file-drop"             # Local directory keeping the files seen by each watcher
+  watchers:
+    - name: "uploads"
+      enabled: false
+      path: "/localfs/incoming"
+      queue: "/queuefs/uploads"
+      interval: "10s"                # Time between scans
+      settle: "5s"                   # Files modified more recently are left for the next scan
+      exclude: "*.tmp,*.part"
+      digest: "sha256"               # "sha256", "xxh3" or "none"
+      ack_queue: "/queuefs/uploads-done"   # Consumers enqueue the event ID once they are done
+      processed: "/localfs/processed"      # Where acknowledged files are moved (default <path>/processed)
+
+# Pipelines taking records from a source (watch, queue or schedule) through transforms
+# (template, Lua script or WASM module) to sinks (path, queue or webhook)
+# Status at /serverinfofs/pipelines/<name>/status; write start, stop or run to .../control
+pipelines:
+  state_dir: "pipelines"             # Local directory keeping the progress of each pipeline
+  file: ""                           # Optional pipelines.yaml with a "pipelines" list of definitions
+  definitions:
+    - name: "orders"
+      enabled: false
+      source:
+        queue: "/queuefs/orders"     # Or watch: "<dir>", or schedule: "1h" with read: "<file>"
+        interval: "10s"              # Time between polls of watch and queue sources
+        batch: 100                   # Records per run at most
+      transforms:
+        - template: '{"order": {{json .Data}}, "received": "{{.Time.Format "2006-01-02T15:04:05Z07:00"}}"}'
+        - script: |
+            function transform(record)
+              if record.data == "" then return nil end   -- nil drops the record
+              return record.data
+            end
+          timeout: "5s"
+      sinks:
+        - path: "/s3fs/orders"       # One file per record, named after the record
+        - webhook: "https://example.com/hooks/orders"
+          headers:
+            Authorization: "Bearer <token>"
+
+# Jobs copying a mount to another backend, started with "agfs-server migrate" or POST /api/v1/migrations
+migrations:
+  state_dir: "migrations"   # Local directory keeping checkpoints, to resume after a restart
+
+# Full-text search over file names and text content
+# Query with GET /api/v1/search?q=... or by reading files under a searchfs mount
+search:
+  enabled: false
+  paths: ["/sqlfs", "/localfs"]
+  exclude: ["*.log", "node_modules"]
+  max_file_size: 1048576    # Larger files are indexed by name only
+  reindex_interval: "1h"    # Full recrawl, changes made through AGFS are indexed immediately
+
+# Tags attached to paths with /api/v1/tags, browsable through a tagfs mount
+tags:
+  enabled: false
+  db_path: "tags.db"
+
+# Read and write frequency and bytes per path over sliding windows, to find the hot files
+# Reports at /serverinfofs/hot_files/<window> and GET /api/v1/analytics/hot?window=1h&by=bytes
+analytics:
+  enabled: false
+  windows: ["5m", "1h", "24h"]       # Windows of the serverinfofs reports, the longest is kept
+  resolution: "1m"                   # Granularity of the windows
+  max_paths: 10000                   # Paths tracked at most, the least recently accessed are dropped
+  top: 20                            # Files listed by the serverinfofs reports
+  exclude: ["/serverinfofs"]         # Path prefixes not tracked
+
+# Authentication of the HTTP API: requests need an API key or a JWT, sent as
+# "Authorization: Bearer <token>" (or an API key in "X-API-Key"); enable one of auth and tenancy
+auth:
+  enabled: false
+  api_keys:
+    - name: "ci"
+      key: "change-me"
+      scopes: ["admin"]          # "*" grants every scope
+    - name: "reader"
+      key: "reader-secret"
+  jwt:
+    secret: ""                   # HS256/HS384/HS512 tokens
+    public_key_file: ""          # PEM RSA or ECDSA key of RS*/ES* tokens
+    issuer: ""                   # Required "iss", if set
+    audience: ""                 # Required "aud", if set
+    leeway: "30s"
+  exempt: ["/api/v1/health", "/livez", "/readyz"]  # Served without a token (default)
+  admin_scopes: ["admin"]        # Grant the admin role that mounts, plugins, jobs, acl and services require (default)
+  rules:                         # The first matching rule applies, other routes take any valid token
+    - path: "/api/v1/files"
+      methods: ["PUT", "DELETE"]
+      scopes: ["write", "admin"]
+
+# Per-path access control of the principals authenticated by auth (API key names, JWT
+# subjects); rules are edited at runtime with /api/v1/acl by principals with the admin role
+acl:
+  enabled: false
+  default: "allow"               # Paths without a matching rule: "allow" or "deny"
+  file: "/var/lib/agfs/acl.json" # Keeps the rules edited through the API across restarts
+  rules:                         # The longest matching path applies, a principal's own rule before "*"
+    - principal: "reader"
+      path: "/sqlfs"
+      allow: ["read"]
+    - principal: "*"
+      path: "/localfs"
+      allow: []                  # No access
+
+# Legal holds and retentions - held paths cannot be modified, renamed or removed by anyone
+# until legal holds are released and retentions expire; managed with /api/v1/holds
+holds:
+  enabled: false
+  file: "/var/lib/agfs/holds.json"
+  principals: ["legal"]          # May place and release holds, admins if empty
+
+# Multi-tenancy - requests with a user's bearer token only see /home/<user>
+tenancy:
+  enabled: false
+  home_root: "/memfs/home"  # Homes are directories under this path...
+  # home_plugin:            # ...or one plugin instance per user mounted at /home/<user>
+  #   type: "sqlfs"
+  #   config:
+  #     backend: "sqlite"
+  #     db_path: "/var/lib/agfs/home-{user}.db"
+  admin_token: "change-me"  # Full access; without it, requests with no token have full access
+  default_quota: "1GB"
+  users:
+    - name: "alice"
+      token: "alice-secret-token"
+      quota: "5GB"
+    - name: "bob"
+      token: "bob-secret-token"
+      read_only: true
+
+# Bandwidth limits of each client (tenant, or remote host without tenancy)
+# Per-mount limits are the read_bps/write_bps mount options
+traffic:
+  client_read_bps: ""       # e.g. "20MB", empty for unlimited
+  client_write_bps: ""
+
+# Record API requests and responses, to replay them with "agfs-server replay"
+recording:
+  enabled: false
+  file: "traffic.jsonl"     # Local file records are appended to
+  # dir: "/s3fs/traffic"    # ...or a directory of the server, one segment file per flush
+  max_body: "1MB"           # Requests with larger bodies are recorded truncated and not replayed
+  flush_interval: "5s"
+  exclude: ["/api/v1/health", "/livez", "/readyz"]
+
+# Model Context Protocol server exposing the file system to AI agents as tools
+mcp:
+  enabled: false
+  transport: "sse"          # "sse" at /mcp/sse, or "stdio" to run as an agent's MCP server
+  allow: ["/memfs"]         # Paths the tools may access
+  read_only: false          # Only offer list, stat, read and search
+  max_read_size: "256KB"    # Longer files are read in parts
+  max_write_size: "1MB"
+
+# SMB2 shares Windows machines map as network drives, users are the auth api keys
+smb:
+  enabled: false
+  address: ":445"
+  guest: false              # Accept anonymous logons and unknown users
+  require_signing: false
+  max_file_size: "64MB"     # Files written are buffered until closed
+  shares:
+    - name: "docs"
+      path: "/memfs/docs"
+      read_only: false
+
+# Cluster mode - servers behind a load balancer share the mounts made with POST /api/v1/mount
+# Requests for pinned (in-memory) plugins are forwarded to the one node serving each mount
+# Status is available at /serverinfofs/cluster
+cluster:
+  enabled: false
+  node_id: "node-1"                     # Default: host name and listen address
+  advertise: "http://10.0.0.1:8080"     # Address the other nodes forward requests to
+  backend: "tidb"                       # "tidb", "mysql" or "sqlite" (nodes on one host)
+  dsn: "user:pass@tcp(tidb:4000)/agfs"
+  sync_interval: "2s"
+  node_ttl: "15s"
+  # pinned_plugins: ["memfs", "streamfs", "queuefs", "kvfs", "heartbeatfs", "tmpfs", "agentfs", "vectorfs"]
+
+# Staging area of two-phase uploads (PUT /api/v1/uploads, then POST .../commit)
+uploads:
+  dir: "/var/lib/agfs/uploads"  # Local directory (default: system temp directory)
+  ttl: "24h"                    # Uncommitted uploads are removed after this long without a chunk
+  max_size: "1GB"
+
+# Budget of one recursive operation such as a recursive grep; when it runs out the
+# partial results are returned with "truncated": true. Requests can only lower it
+budget:
+  max_files: 100000   # Files scanned ("0" for no limit)
+  max_bytes: "1GB"    # Bytes read
+  max_time: "1m"      # Wall time
+
+# Background jobs (POST /api/v1/jobs, DELETE /api/v1/files?recursive=true&async=true):
+# remove, copy, migration and scrub. Status at GET /api/v1/jobs/<id> or under a jobsfs mount
+jobs:
+  webhook: ""                 # Notified with the status of every job that ends; requests may name their own
+  webhook_timeout: "10s"
+  retain: 100                 # Ended jobs kept for status queries
+
+# Retried writes carrying an Idempotency-Key header are applied once; retries within the
+# window get the stored response (the Go client and proxyfs send keys and retry)
+idempotency:
+  enabled: false
+  window: "10m"
+  dir: ""                     # e.g. "/kvfs/keys" to keep responses across restarts (default: memory)
+  max_entries: 10000          # Responses kept in memory
+
+# Go runtime diagnostics: pprof at /debug/pprof/, expvar at /debug/vars, and
+# POST /debug/dump writing goroutine and heap profiles to dump_dir
+debug:
+  enabled: false
+  token: "change-me"        # Required, sent as "Authorization: Bearer <token>"
+  dump_dir: "/memfs/debug"
+
+# Requests and bytes read and written per principal (auth) or tenant, in daily rollups
+# for chargeback; GET /api/v1/usage?from=2024-01-01&to=2024-01-31, this month at /serverinfofs/usage
+usage:
+  enabled: false
+  dir: "/sqlfs/usage"         # One <YYYY-MM-DD>.json rollup per day (default: memory)
+  flush_interval: "1m"
+  default_quota:              # Per calendar month (UTC), requests beyond it get 429
+    bytes: ""                 # e.g. "100GB", empty for unlimited
+    requests: 0               # 0 for unlimited
+  quotas:
+    - principal: "ci"
+      bytes: "1TB"
+
+# Key of the encrypted ENC[...] values, made with "agfs-server encrypt-config";
+# $AGFS_CONFIG_KEY takes precedence
+# secrets:
+#   key_file: "/etc/agfs/config.key"
+#   key_command: "aws kms decrypt --ciphertext-blob fileb:///etc/agfs/config.key.enc --query Plaintext --output text"
 `
 
 func main() {
+	// Subcommands come before the server flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "serve":
+			os.Exit(runLite(os.Args[2:]))
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "scaffold":
+			os.Exit(runScaffold(os.Args[2:]))
+		case "encrypt-config":
+			os.Exit(runEncryptConfig(os.Args[2:]))
+		}
+	}
+
 	configFile := flag.String("c", "config.yaml", "Path to configuration file")
 	addr := flag.String("addr", "", "Server listen address (will override addr in config file)")
 	printSampleConfig := flag.Bool("print-sample-config", false, "Print a sample configuration file and exit")
 	version := flag.Bool("version", false, "Print version information and exit")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration file and exit")
+	dryRun := flag.Bool("dry-run", false, "Print the mount table of the configuration file and exit")
+	diff := flag.Bool("diff", false, "Print the mounts changed between two configuration files (-diff old.yaml new.yaml) and exit")
 	flag.Parse()
 
 	// Handle --version
@@ -167,16 +583,40 @@ func main() {
 
 	// Handle --print-sample-config
 	if *printSampleConfig {
-		fmt.Println(sampleConfig)
+		fmt.Print(sampleConfig)
 		return
 	}
 
+	// Handle --diff
+	if *diff {
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: agfs-server -diff old.yaml new.yaml")
+			os.Exit(2)
+		}
+		os.Exit(runDiff(flag.Arg(0), flag.Arg(1)))
+	}
+
 	// Load configuration
 	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to load config file: %v", err)
 	}
 
+	// Handle --check-config
+	if *checkConfig {
+		os.Exit(runCheckConfig(cfg, *configFile))
+	}
+
+	// Handle --dry-run
+	if *dryRun {
+		os.Exit(runDryRun(cfg, *configFile))
+	}
+	runServer(cfg, *addr)
+}
+
+// runServer mounts the plugins of cfg and serves the API until the process ends;
+// a non-empty addr overrides the configured address
+func runServer(cfg *config.Config, addr string) {
 	// Configure logrus
 	logLevel := log.InfoLevel
 	if cfg.Server.LogLevel != "" {
@@ -194,10 +634,14 @@ func main() {
 	log.SetReportCaller(true)
 	log.SetLevel(logLevel)
 
+	if err := config.ValidateConfig(cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Determine server address
 	serverAddr := cfg.Server.Address
-	if *addr != "" {
-		serverAddr = *addr // Command line override
+	if addr != "" {
+		serverAddr = addr // Command line override
 	}
 	if serverAddr == "" {
 		serverAddr = ":8080" // Default
@@ -205,6 +649,26 @@ func main() {
 
 	// Create mountable file system
 	mfs := mountablefs.NewMountableFS()
+	// Serve TLS, with the same certificates for connections to other servers
+	var certs *mtls.Certs
+	services := plugin.Services{DataDir: cfg.Server.DataDir}
+	if cfg.Server.TLS.Enabled() {
+		var err error
+		if certs, err = mtls.New(cfg.Server.TLS); err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		services.ClientTLS = certs.ClientConfig("")
+	}
+	mfs.SetPluginServices(services)
+	startup := handlers.NewStartupReport()
+
+	// Development-only plugins
+	if cfg.Server.DevMode {
+		for name, factory := range embed.DevPlugins() {
+			availablePlugins[name] = factory
+		}
+		log.Warn("Dev mode enabled: the faultfs fault injection plugin is available")
+	}
 
 	// Register plugin factories for dynamic mounting
 	for pluginName, factory := range availablePlugins {
@@ -226,6 +690,7 @@ func main() {
 			p = mfs.CreatePlugin(pluginName)
 			if p == nil {
 				log.Warnf("Unknown plugin: %s, skipping instance '%s'", pluginName, instanceName)
+				startup.Failed(instanceName, fmt.Errorf("unknown plugin: %s", pluginName))
 				return
 			}
 		} else {
@@ -233,18 +698,19 @@ func main() {
 			p = factory()
 		}
 
-		// Special handling for httpfs: inject rootFS reference
-		if pluginName == "httpfs" {
-			if httpfsPlugin, ok := p.(*httpfs.HTTPFSPlugin); ok {
-				httpfsPlugin.SetRootFS(mfs)
-			}
-		}
-
 		// Mount asynchronously
 		go func() {
+			// Separate mount-level options (e.g., trash) from the plugin configuration
+			opts, pluginOnlyConfig, err := mountablefs.SplitMountOptions(pluginConfig)
+			if err != nil {
+				log.Errorf("Invalid mount options for %s instance '%s': %v", pluginName, instanceName, err)
+				startup.Failed(instanceName, err)
+				return
+			}
+
 			// Inject mount_path into config
 			configWithPath := make(map[string]interface{})
-			for k, v := range pluginConfig {
+			for k, v := range pluginOnlyConfig {
 				configWithPath[k] = v
 			}
 			configWithPath["mount_path"] = mountPath
@@ -252,27 +718,43 @@ func main() {
 			// Validate plugin configuration
 			if err := p.Validate(configWithPath); err != nil {
 				log.Errorf("Failed to validate %s instance '%s': %v", pluginName, instanceName, err)
+				startup.Failed(instanceName, err)
 				return
 			}
 
-			// Initialize plugin
-			if err := p.Initialize(configWithPath); err != nil {
+			// Initialize plugin with the server services (root file system, data directory, ...)
+			if err := plugin.Initialize(p, mfs.InitContext(pluginName, mountPath, configWithPath)); err != nil {
 				log.Errorf("Failed to initialize %s instance '%s': %v", pluginName, instanceName, err)
+				startup.Failed(instanceName, err)
 				return
 			}
 
 			// Mount plugin
-			if err := mfs.Mount(mountPath, p); err != nil {
+			if err := mfs.MountWithOptions(mountPath, p, opts); err != nil {
 				log.Errorf("Failed to mount %s instance '%s' at %s: %v", pluginName, instanceName, mountPath, err)
+				startup.Failed(instanceName, err)
 				return
 			}
 
 			// Log success
 			log.Infof("%s instance '%s' mounted at %s", pluginName, instanceName, mountPath)
+			startup.Mounted(instanceName)
 		}()
 	}
 
 	// Load external plugins if enabled
+	// Limits and signature checks also apply to plugins loaded through the API later
+	wasmLimits, wasmFileLimits, err := loader.WASMLimitsFromConfig(cfg.ExternalPlugins)
+	if err != nil {
+		log.Fatalf("Invalid external plugin configuration: %v", err)
+	}
+	mfs.GetPluginLoader().SetWASMLimits(wasmLimits, wasmFileLimits)
+	verifier, err := loader.NewVerifier(cfg.ExternalPlugins.Signing)
+	if err != nil {
+		log.Fatalf("Invalid plugin signing configuration: %v", err)
+	}
+	mfs.GetPluginLoader().SetVerifier(verifier)
+
 	if cfg.ExternalPlugins.Enabled {
 		log.Info("Loading external plugins...")
 
@@ -305,48 +787,495 @@ func main() {
 
 	// Mount all enabled plugins
 	log.Info("Mounting plugin filesytems...")
-	for pluginName, pluginCfg := range cfg.Plugins {
-		// Normalize to instance array (convert single instance to array of one)
-		instances := pluginCfg.Instances
-		if len(instances) == 0 {
-			// Single instance mode: treat as array with one instance
-			instances = []config.PluginInstance{
-				{
-					Name:    pluginName, // Use plugin name as instance name
-					Enabled: pluginCfg.Enabled,
-					Path:    pluginCfg.Path,
-					Config:  pluginCfg.Config,
-				},
+	instances, err := cfg.PluginInstances()
+	if err != nil {
+		log.Fatalf("Invalid plugin configuration: %v", err)
+	}
+	for _, instance := range instances {
+		startup.Configure(instance.Plugin, instance.Name, instance.Path, instance.Enabled)
+	}
+	for _, instance := range instances {
+		if !instance.Enabled {
+			log.Infof("%s instance '%s' is disabled, skipping", instance.Plugin, instance.Name)
+			continue
+		}
+
+		mountPlugin(instance.Plugin, instance.Name, instance.Path, instance.Config)
+	}
+
+	// Create backup scheduler
+	backupScheduler, err := backup.NewScheduler(mfs, cfg.Backups)
+	if err != nil {
+		log.Fatalf("Invalid backup configuration: %v", err)
+	}
+	serverinfofs.RegisterInfoFile("backups", func() ([]byte, error) {
+		return json.MarshalIndent(backupScheduler.Status(), "", "  ")
+	})
+	backupScheduler.Start()
+
+	// Lifecycle rules moving or deleting files by age
+	lifecycleEngine, err := lifecycle.NewEngine(mfs, cfg.Lifecycle)
+	if err != nil {
+		log.Fatalf("Invalid lifecycle configuration: %v", err)
+	}
+	serverinfofs.RegisterInfoFile("lifecycle", func() ([]byte, error) {
+		return json.MarshalIndent(lifecycleEngine.Status(), "", "  ")
+	})
+	lifecycleEngine.Start()
+
+	// Archivers draining queues into batch files on other mounts
+	queueArchive, err := queuearchive.NewManager(mfs, cfg.QueueArchive)
+	if err != nil {
+		log.Fatalf("Invalid queue archive configuration: %v", err)
+	}
+	serverinfofs.RegisterInfoFile("queue_archive", func() ([]byte, error) {
+		return json.MarshalIndent(queueArchive.Status(), "", "  ")
+	})
+	queueArchive.Start()
+
+	// Watchers turning files dropped in a directory into queue messages
+	fileDrop, err := filedrop.NewManager(mfs, cfg.FileDrop)
+	if err != nil {
+		log.Fatalf("Invalid file drop configuration: %v", err)
+	}
+	serverinfofs.RegisterInfoFile("file_drop", func() ([]byte, error) {
+		return json.MarshalIndent(fileDrop.Status(), "", "  ")
+	})
+	fileDrop.Start()
+
+	// Declarative pipelines from sources through transforms to sinks, each with a status
+	// file and a control file accepting start, stop and run
+	pipelines, err := pipeline.NewManager(mfs, cfg.Pipelines)
+	if err != nil {
+		log.Fatalf("Invalid pipelines configuration: %v", err)
+	}
+	for _, name := range pipelines.Names() {
+		serverinfofs.RegisterInfoFile("pipelines/"+name+"/status", func() ([]byte, error) {
+			status, err := pipelines.PipelineStatus(name)
+			if err != nil {
+				return nil, err
 			}
+			return json.MarshalIndent(status, "", "  ")
+		})
+		serverinfofs.RegisterControlFile("pipelines/"+name+"/control", func() ([]byte, error) {
+			state, err := pipelines.ControlState(name)
+			return []byte(state + "\n"), err
+		}, func(data []byte) error {
+			return pipelines.Control(name, strings.TrimSpace(string(data)))
+		})
+	}
+	pipelines.Start()
+
+	// Jobs moving data between mounts, resumable after a restart
+	stateDir := cfg.Migrations.StateDir
+	if stateDir == "" {
+		stateDir = migrate.DefaultStateDir
+	}
+	migrations, err := migrate.NewManager(mfs, stateDir)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	serverinfofs.RegisterInfoFile("migrations", func() ([]byte, error) {
+		return json.MarshalIndent(migrations.List(), "", "  ")
+	})
+
+	serverinfofs.RegisterInfoFile("crashes", func() ([]byte, error) {
+		return json.MarshalIndent(mfs.CrashStats(), "", "  ")
+	})
+
+	// Create search indexer
+	var searchIndexer *search.Indexer
+	if cfg.Search.Enabled {
+		searchIndexer, err = search.NewIndexer(mfs, cfg.Search)
+		if err != nil {
+			log.Fatalf("Invalid search configuration: %v", err)
+		}
+		search.SetDefault(searchIndexer)
+		serverinfofs.RegisterInfoFile("search", func() ([]byte, error) {
+			return json.MarshalIndent(searchIndexer.Status(), "", "  ")
+		})
+		searchIndexer.Start(mfs)
+	}
+
+	// Share dynamic mounts with the other nodes of the cluster
+	var clusterNode *cluster.Cluster
+	if cfg.Cluster.Enabled {
+		clusterNode, err = cluster.New(mfs, cfg.Cluster, serverAddr)
+		if err != nil {
+			log.Fatalf("Invalid cluster configuration: %v", err)
+		}
+		if certs != nil {
+			clusterNode.SetTLS(services.ClientTLS)
 		}
+		clusterNode.Start()
+		serverinfofs.RegisterInfoFile("cluster", func() ([]byte, error) {
+			return json.MarshalIndent(clusterNode.Status(), "", "  ")
+		})
+		if cfg.Tenancy.Enabled {
+			log.Warn("Cluster routing of pinned mounts is disabled with tenancy, use sticky sessions on the load balancer")
+		}
+	}
+
+	// Open tag store
+	var tagStore *tags.Store
+	if cfg.Tags.Enabled {
+		tagStore, err = tags.Open(cfg.Tags.DBPath)
+		if err != nil {
+			log.Fatalf("Failed to open tag store: %v", err)
+		}
+		tags.SetDefault(tagStore)
+		tagStore.Follow(mfs)
+	}
 
-		// Mount all instances
-		for _, instance := range instances {
-			if !instance.Enabled {
-				log.Infof("%s instance '%s' is disabled, skipping", pluginName, instance.Name)
-				continue
+	// Count reads and writes per path to report the hot files
+	var collector *analytics.Collector
+	if cfg.Analytics.Enabled {
+		collector, err = analytics.NewCollector(cfg.Analytics, func(path string) string {
+			if mount, ok := mfs.LookupMount(path); ok {
+				return mount.Path
 			}
+			return "/"
+		})
+		if err != nil {
+			log.Fatalf("Invalid analytics configuration: %v", err)
+		}
+		for _, window := range collector.Windows() {
+			serverinfofs.RegisterInfoFile("hot_files/"+analytics.FormatWindow(window), func() ([]byte, error) {
+				report, err := collector.Report(window, analytics.ByOps, "/", 0, nil)
+				if err != nil {
+					return nil, err
+				}
+				return json.MarshalIndent(report, "", "  ")
+			})
+		}
+		collector.Start(mfs)
+	}
 
-			mountPlugin(pluginName, instance.Name, instance.Path, instance.Config)
+	// Serve the file system to AI agents; with the stdio transport the process is an
+	// MCP server for a single agent and does not serve the HTTP API
+	var mcpServer *mcp.Server
+	if cfg.MCP.Enabled {
+		mcpServer, err = mcp.New(cfg.MCP, mfs, Version)
+		if err != nil {
+			log.Fatalf("Invalid MCP configuration: %v", err)
+		}
+		if mcpServer.Transport() == mcp.TransportStdio {
+			log.Info("Serving MCP on stdin/stdout")
+			if err := mcpServer.ServeStdio(context.Background(), os.Stdin, os.Stdout); err != nil {
+				log.Fatalf("MCP: %v", err)
+			}
+			return
 		}
 	}
 
 	// Create handlers
 	handler := handlers.NewHandler(mfs)
 	handler.SetVersionInfo(Version, GitCommit, BuildTime)
+	handler.SetCORSOrigins(cfg.Server.CORSOrigins)
+	handler.SetStartupReport(startup)
+	handler.SetBackupScheduler(backupScheduler)
+	handler.SetMigrations(migrations)
+	handler.SetLifecycle(lifecycleEngine)
+	handler.SetQueueArchive(queueArchive)
+	handler.SetFileDrop(fileDrop)
+	handler.SetSearchIndexer(searchIndexer)
+	handler.SetTagStore(tagStore)
+	handler.SetAnalytics(collector)
+	clientLimits, err := throttle.ClientLimitsFromConfig(cfg.Traffic)
+	if err != nil {
+		log.Fatalf("Invalid traffic configuration: %v", err)
+	}
+	handler.SetClientLimits(clientLimits)
+	uploadStore, err := handlers.UploadStoreFromConfig(cfg.Uploads)
+	if err != nil {
+		log.Fatalf("Invalid uploads configuration: %v", err)
+	}
+	handler.SetUploadStore(uploadStore)
+	budget, err := handlers.BudgetFromConfig(cfg.Budget)
+	if err != nil {
+		log.Fatalf("Invalid budget configuration: %v", err)
+	}
+	handler.SetBudget(budget)
+	jobManager, err := handlers.JobManagerFromConfig(cfg.Jobs)
+	if err != nil {
+		log.Fatalf("Invalid jobs configuration: %v", err)
+	}
+	jobs.SetDefault(jobManager)
+	handler.SetJobManager(jobManager)
+
+	// Restrict the paths the principals authenticated by auth may read and write
+	if cfg.ACL.Enabled {
+		if !cfg.Auth.Enabled {
+			log.Warn("ACL is enabled without auth, requests have no principal and are not checked")
+		}
+		accessList, err := acl.New(cfg.ACL, func(ctx context.Context) (string, bool) {
+			if p := auth.FromContext(ctx); p != nil {
+				return p.Name, true
+			}
+			return "", false
+		})
+		if err != nil {
+			log.Fatalf("Invalid acl configuration: %v", err)
+		}
+		mfs.SetAccessControl(accessList)
+		handler.SetACL(accessList)
+	}
+
+	// Make held paths undeletable and unmodifiable
+	if cfg.Holds.Enabled {
+		holdManager, err := holds.New(cfg.Holds, func(ctx context.Context) (string, bool) {
+			if p := auth.FromContext(ctx); p != nil {
+				return p.Name, true
+			}
+			return "", false
+		}, func(ctx context.Context) bool {
+			p := auth.FromContext(ctx)
+			return p != nil && p.IsAdmin()
+		})
+		if err != nil {
+			log.Fatalf("Invalid holds configuration: %v", err)
+		}
+		if !cfg.Auth.Enabled {
+			log.Warn("Holds are placed and released by admins or the configured principals, without auth nobody can")
+		}
+		mfs.SetHolds(holdManager)
+		handler.SetHolds(holdManager)
+	}
+	handler.SetHandlePrincipal(func(ctx context.Context) (string, bool) {
+		if p := auth.FromContext(ctx); p != nil {
+			return p.Name, true
+		}
+		return "", false
+	})
 	pluginHandler := handlers.NewPluginHandler(mfs)
+	if clusterNode != nil {
+		pluginHandler.SetMountTable(clusterNode)
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
 	handler.SetupRoutes(mux)
 	pluginHandler.SetupRoutes(mux)
+	if mcpServer != nil {
+		mux.Handle("/mcp/", mcpServer.Handler("/mcp"))
+		log.Infof("Serving MCP over SSE at /mcp/sse")
+		startup.AddListener("mcp-sse", serverAddr+"/mcp/sse")
+	}
+
+	// Route tenant requests to their home directories
+	var apiHandler http.Handler = mux
+	var tenants *tenancy.Manager
+	if cfg.Tenancy.Enabled {
+		tenants, err = tenancy.NewManager(mfs, mfs, cfg.Tenancy)
+		if err != nil {
+			log.Fatalf("Invalid tenancy configuration: %v", err)
+		}
+		if cfg.Tenancy.AdminToken == "" {
+			log.Warn("Tenancy is enabled without an admin_token, requests without a token have full access")
+		}
+		serverinfofs.RegisterInfoFile("tenants", func() ([]byte, error) {
+			return json.MarshalIndent(tenants.Status(), "", "  ")
+		})
+		apiHandler = handlers.TenancyMiddleware(tenants, handler, mux)
+	}
+
+	// Apply retried mutating requests once per Idempotency-Key
+	if cfg.Idempotency.Enabled {
+		dedup, err := idempotency.New(cfg.Idempotency, mfs)
+		if err != nil {
+			log.Fatalf("Invalid idempotency configuration: %v", err)
+		}
+		dedup.SetPrincipal(func(ctx context.Context) (string, bool) {
+			if p := auth.FromContext(ctx); p != nil {
+				return p.Name, true
+			}
+			return "", false
+		})
+		apiHandler = dedup.Middleware(apiHandler)
+	}
+
+	// Record API traffic for replay
+	if cfg.Recording.Enabled {
+		trafficRecorder, err := recorder.New(cfg.Recording, mfs)
+		if err != nil {
+			log.Fatalf("Invalid recording configuration: %v", err)
+		}
+		trafficRecorder.Start()
+		serverinfofs.RegisterInfoFile("recording", func() ([]byte, error) {
+			return json.MarshalIndent(trafficRecorder.Status(), "", "  ")
+		})
+		apiHandler = trafficRecorder.Middleware(apiHandler)
+		log.Warn("Recording API traffic, request and response bodies are stored unencrypted")
+	}
+
+	// Forward requests for pinned mounts to the node owning them
+	if clusterNode != nil && !cfg.Tenancy.Enabled {
+		apiHandler = clusterNode.Middleware(apiHandler)
+	}
+
+	// Meter the requests and bytes of each principal or tenant, after auth has identified them
+	if cfg.Usage.Enabled {
+		if !cfg.Auth.Enabled && !cfg.Tenancy.Enabled {
+			log.Warn("Usage metering is enabled without auth or tenancy, requests have no principal and are not metered")
+		}
+		if cfg.Usage.Dir != "" {
+			startup.Wait(30 * time.Second) // The rollups of the month quotas start from are on a mount
+		}
+		meter, err := usage.New(cfg.Usage, mfs, func(r *http.Request) (string, bool, error) {
+			if tenants != nil {
+				t, err := tenants.Authenticate(r)
+				if err != nil || t == nil {
+					return "", err == nil, err // The admin token, or no token without one
+				}
+				return t.Name, false, nil
+			}
+			if p := auth.FromContext(r.Context()); p != nil {
+				return p.Name, p.IsAdmin(), nil
+			}
+			return "", !cfg.Auth.Enabled, nil
+		})
+		if err != nil {
+			log.Fatalf("Invalid usage configuration: %v", err)
+		}
+		meter.Start()
+		apiHandler = meter.Middleware(apiHandler)
+	}
+
+	// Serve runtime profiles under /debug, ahead of tenancy and recording but behind auth,
+	// which then requires the admin role instead of the debug token
+	if cfg.Debug.Enabled {
+		diag, err := diagnostics.New(cfg.Debug, mfs)
+		if err != nil {
+			log.Fatalf("Invalid debug configuration: %v", err)
+		}
+		if cfg.Auth.Enabled {
+			diag.SetAuthorizer(func(r *http.Request) bool {
+				p := auth.FromContext(r.Context())
+				return p != nil && p.IsAdmin()
+			})
+		}
+		apiHandler = diag.Middleware(apiHandler)
+		log.Warn("Serving runtime diagnostics at /debug")
+	}
+
+	// Require API keys or JWTs on the API, after which requests are forwarded to other nodes
+	var authenticator *auth.Authenticator
+	if cfg.Auth.Enabled {
+		if cfg.Tenancy.Enabled {
+			log.Fatal("auth and tenancy both authenticate the Authorization header, enable one of them")
+		}
+		authenticator, err = auth.New(cfg.Auth)
+		if err != nil {
+			log.Fatalf("Invalid auth configuration: %v", err)
+		}
+		authenticator.SetAdminRoutes(handlers.IsAdminRoute)
+		apiHandler = authenticator.Middleware(apiHandler)
+		log.Infof("API authentication enabled with %d api keys", len(cfg.Auth.APIKeys))
+	}
+
+	// Serve subtrees to Windows machines as SMB shares, logging on with the API keys
+	if cfg.SMB.Enabled {
+		if cfg.Tenancy.Enabled {
+			log.Warn("SMB shares are not confined to tenant home directories")
+		}
+		smbServer, err := smb.New(cfg.SMB, mfs, authenticator)
+		if err != nil {
+			log.Fatalf("Invalid smb configuration: %v", err)
+		}
+		smbAddr := cfg.SMB.Address
+		if smbAddr == "" {
+			smbAddr = smb.DefaultAddress
+		}
+		go func() {
+			if err := smbServer.ListenAndServe(smbAddr); err != nil {
+				log.Fatalf("SMB: %v", err)
+			}
+		}()
+		log.Infof("Serving SMB shares %s on %s", strings.Join(smbServer.Shares(), ", "), smbAddr)
+		startup.AddListener("smb", smbAddr)
+	}
 
 	// Wrap with logging middleware
-	loggedMux := handlers.LoggingMiddleware(mux)
+	loggedMux := handlers.LoggingMiddleware(apiHandler)
+
+	// Serve the admin routes on their own listener, and only there
+	if cfg.Server.AdminAddress != "" {
+		adminListener, err := listenAdmin(cfg.Server.AdminAddress, certs)
+		if err != nil {
+			log.Fatalf("Invalid admin_address: %v", err)
+		}
+		startup.AddListener("admin", cfg.Server.AdminAddress)
+		adminServer := &http.Server{Handler: handlers.AdminListenerMiddleware(true, loggedMux)}
+		go func() {
+			if err := adminServer.Serve(adminListener); err != nil {
+				log.Fatalf("Admin listener: %v", err)
+			}
+		}()
+		log.Infof("Serving the admin API on %s", cfg.Server.AdminAddress)
+		loggedMux = handlers.AdminListenerMiddleware(false, loggedMux)
+	}
 	// Start server
 	log.Infof("Starting AGFS server on %s", serverAddr)
+	if certs != nil {
+		startup.AddListener("https", serverAddr)
+		go logStartupBanner(startup, serverAddr)
+		server := &http.Server{Addr: serverAddr, Handler: loggedMux, TLSConfig: certs.ServerConfig()}
+		if err := server.ListenAndServeTLS("", ""); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	startup.AddListener("http", serverAddr)
+	go logStartupBanner(startup, serverAddr)
 
 	if err := http.ListenAndServe(serverAddr, loggedMux); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// listenAdmin listens on the admin address, a unix socket with a "unix:" prefix, which only
+// its owner and group may connect to, else a TCP address served with TLS if certs is set
+func listenAdmin(addr string, certs *mtls.Certs) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket left behind by a previous run would fail the listen
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if certs != nil {
+		return tls.NewListener(ln, certs.ServerConfig()), nil
+	}
+	return ln, nil
+}
+
+// logStartupBanner logs a summary of the server once the configured mounts are settled
+func logStartupBanner(startup *handlers.StartupReport, addr string) {
+	startup.Wait(30 * time.Second)
+
+	counts := make(map[string]int)
+	var failed []handlers.MountState
+	for _, m := range startup.Mounts() {
+		counts[m.State]++
+		if m.State == handlers.MountFailed {
+			failed = append(failed, m)
+		}
+	}
+	log.Infof("AGFS %s (%s) serving on %s: %d mounts active, %d failed, %d pending, %d disabled; status at /api/v1/status",
+		Version, GitCommit, addr, counts[handlers.MountActive], counts[handlers.MountFailed], counts[handlers.MountPending], counts[handlers.MountDisabled])
+	for _, m := range failed {
+		log.Warnf("  %s instance '%s' at %s failed: %s", m.Plugin, m.Instance, m.Path, m.Error)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/migrate"
+)
+
+// runMigrate implements the migrate subcommand, which drives a migration job of a server:
+//
+//	agfs-server migrate -source /memfs -target /sqlfs [-rate 20MB]
+//	agfs-server migrate -resume <id>
+//
+// The job runs in the server; the command prints its progress until it ends, and the
+// final status as JSON
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "AGFS server URL")
+	source := fs.String("source", "", "Mount or directory to copy, e.g. /memfs")
+	target := fs.String("target", "", "Directory receiving the data, e.g. /sqlfs")
+	rate := fs.String("rate", "", "Bytes per second, e.g. 20MB (default unlimited)")
+	noVerify := fs.Bool("no-verify", false, "Do not read files back to compare digests")
+	resume := fs.String("resume", "", "Resume the job with this ID after its checkpoint")
+	cancel := fs.String("cancel", "", "Cancel the job with this ID")
+	list := fs.Bool("list", false, "List the jobs of the server")
+	detach := fs.Bool("detach", false, "Return once the job has started")
+	fs.Parse(args)
+
+	fail := func(format string, a ...interface{}) int {
+		fmt.Fprintf(os.Stderr, "migrate: "+format+"\n", a...)
+		return 1
+	}
+	api := strings.TrimSuffix(*server, "/") + "/api/v1/migrations"
+
+	var resp []byte
+	var err error
+	switch {
+	case *list:
+		resp, err = migrateCall(http.MethodGet, api, nil)
+		if err != nil {
+			return fail("%v", err)
+		}
+		os.Stdout.Write(resp)
+		return 0
+	case *cancel != "":
+		resp, err = migrateCall(http.MethodPost, api+"/cancel?id="+url.QueryEscape(*cancel), nil)
+	case *resume != "":
+		resp, err = migrateCall(http.MethodPost, api+"/resume?id="+url.QueryEscape(*resume), nil)
+	default:
+		if *source == "" || *target == "" {
+			return fail("-source and -target are required")
+		}
+		req := migrate.Request{Source: *source, Target: *target, Rate: *rate}
+		if *noVerify {
+			verify := false
+			req.Verify = &verify
+		}
+		body, _ := json.Marshal(req)
+		resp, err = migrateCall(http.MethodPost, api, body)
+	}
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var status migrate.Status
+	if err := json.Unmarshal(resp, &status); err != nil {
+		return fail("invalid response: %v", err)
+	}
+	if *cancel == "" && !*detach {
+		fmt.Fprintf(os.Stderr, "migration %s: %s -> %s\n", status.ID, status.Source, status.Target)
+		for status.State == migrate.StateRunning {
+			time.Sleep(time.Second)
+			resp, err := migrateCall(http.MethodGet, api+"?id="+url.QueryEscape(status.ID), nil)
+			if err != nil {
+				return fail("%v", err)
+			}
+			if err := json.Unmarshal(resp, &status); err != nil {
+				return fail("invalid response: %v", err)
+			}
+			fmt.Fprintln(os.Stderr, progressLine(status))
+		}
+	}
+
+	out, _ := json.MarshalIndent(status, "", "  ")
+	fmt.Println(string(out))
+	if status.State == migrate.StateFailed {
+		return 1
+	}
+	return 0
+}
+
+// migrateCall sends a request to the migration API and returns the response body
+func migrateCall(method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// progressLine describes the progress of a job in one line
+func progressLine(s migrate.Status) string {
+	if s.Phase == migrate.PhaseScanning {
+		return "scanning source..."
+	}
+	line := fmt.Sprintf("%s: %d/%d files, %d/%d bytes", s.State, s.Files, s.TotalFiles, s.Bytes, s.TotalBytes)
+	if s.TotalBytes > 0 {
+		line += fmt.Sprintf(" (%.1f%%)", float64(s.Bytes)*100/float64(s.TotalBytes))
+	}
+	if s.Error != "" {
+		line += ": " + s.Error
+	}
+	return line
+}
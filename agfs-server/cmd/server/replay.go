@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/recorder"
+)
+
+// runReplay implements the replay subcommand:
+//
+//	agfs-server replay -server http://localhost:8080 traffic.jsonl [more.jsonl ...]
+//
+// It re-executes recorded requests in order and reports the responses whose status
+// (or body, with -compare-body) differs from the recording; it exits with 1 on mismatches
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	server := fs.String("server", "http://localhost:8080", "AGFS server URL")
+	token := fs.String("token", "", "Bearer token sent with every request")
+	speed := fs.Float64("speed", 0, "Pacing relative to the recording: 1 for real time, 2 for twice as fast, 0 without pauses")
+	compareBody := fs.Bool("compare-body", false, "Also compare response bodies")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "replay: at least one recording file is required")
+		return 1
+	}
+
+	var readers []io.Reader
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	opts := recorder.ReplayOptions{Server: *server, Token: *token, Speed: *speed, CompareBody: *compareBody}
+	result, err := recorder.Replay(context.Background(), io.MultiReader(readers...), opts)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	if result.Mismatched > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/scaffold"
+)
+
+// runScaffold implements the scaffold subcommand:
+//
+//	agfs-server scaffold -name weatherfs [-dir pkg/plugins] [-force]
+//
+// It generates the package of a new plugin and prints the snippets registering it
+func runScaffold(args []string) int {
+	fs := flag.NewFlagSet("scaffold", flag.ExitOnError)
+	name := fs.String("name", "", "Plugin and package name, e.g. weatherfs (required)")
+	dir := fs.String("dir", "pkg/plugins", "Directory the package is created in")
+	force := fs.Bool("force", false, "Overwrite the files of an existing package")
+	fs.Parse(args)
+
+	if *name == "" && fs.NArg() == 1 {
+		*name = fs.Arg(0)
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "scaffold: -name is required")
+		return 1
+	}
+	p, err := scaffold.Generate(scaffold.Options{Name: *name, Dir: *dir, Force: *force})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scaffold: %v\n", err)
+		return 1
+	}
+	for _, file := range p.Files {
+		fmt.Printf("created %s\n", file)
+	}
+	fmt.Printf("\nRun the conformance tests with: go test ./%s/%s\n", *dir, *name)
+	fmt.Printf("\nTo build %s into the server, add:\n\n%s", p.Name, p.Registration())
+	return 0
+}
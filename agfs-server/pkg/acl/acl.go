@@ -0,0 +1,311 @@
+// Package acl restricts which paths the principals authenticated by the API may read and
+// write. Rules of a principal and a path prefix list the allowed operations; they are
+// loaded from the configuration and can be edited at runtime.
+package acl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// Defaults of paths without a matching rule
+const (
+	DefaultAllow = "allow"
+	DefaultDeny  = "deny"
+)
+
+// Operations of the allow lists of rules
+const (
+	OpRead  = string(mountablefs.AccessRead)
+	OpWrite = string(mountablefs.AccessWrite)
+)
+
+// AnyPrincipal in a rule applies it to every principal without a rule of its own
+const AnyPrincipal = "*"
+
+// Rule allows Principal the operations in Allow on Path and below
+type Rule struct {
+	Principal string   `json:"principal"`
+	Path      string   `json:"path"`
+	Allow     []string `json:"allow"`
+}
+
+// allows reports whether the rule allows op
+func (r *Rule) allows(op string) bool {
+	for _, a := range r.Allow {
+		if a == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is the default and the rules of an ACL
+type Policy struct {
+	Default string `json:"default"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Decision is what a principal may do with a path
+// Read is also true for the directories above paths the principal may read, so that they
+// can be listed; their listings only show what the principal may read.
+type Decision struct {
+	Principal string `json:"principal"`
+	Path      string `json:"path"`
+	Read      bool   `json:"read"`
+	Write     bool   `json:"write"`
+	Rule      *Rule  `json:"rule,omitempty"` // Matching rule, nil if the default applies
+}
+
+// PrincipalFunc returns the principal of the request a context belongs to, and false for
+// contexts without one, such as those of the server's own work, which are not checked
+type PrincipalFunc func(ctx context.Context) (string, bool)
+
+// ACL evaluates rules on the file system operations of principals; it implements
+// mountablefs.AccessControl
+type ACL struct {
+	mu        sync.RWMutex
+	policy    Policy
+	file      string
+	principal PrincipalFunc
+}
+
+// New creates an ACL from the acl configuration, or from its file if it exists
+func New(cfg config.ACLConfig, principal PrincipalFunc) (*ACL, error) {
+	policy := Policy{Default: cfg.Default}
+	for _, r := range cfg.Rules {
+		policy.Rules = append(policy.Rules, Rule{Principal: r.Principal, Path: r.Path, Allow: r.Allow})
+	}
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err == nil {
+			if err := json.Unmarshal(data, &policy); err != nil {
+				return nil, fmt.Errorf("acl: %s: %w", cfg.File, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("acl: %w", err)
+		}
+	}
+	if err := normalize(&policy); err != nil {
+		return nil, fmt.Errorf("acl: %w", err)
+	}
+	return &ACL{policy: policy, file: cfg.File, principal: principal}, nil
+}
+
+// normalize validates p and normalizes its paths and default
+func normalize(p *Policy) error {
+	switch p.Default {
+	case "":
+		p.Default = DefaultAllow
+	case DefaultAllow, DefaultDeny:
+	default:
+		return filesystem.NewInvalidArgumentError("default", p.Default, "must be allow or deny")
+	}
+	seen := make(map[[2]string]bool, len(p.Rules))
+	for i := range p.Rules {
+		if err := normalizeRule(&p.Rules[i]); err != nil {
+			return err
+		}
+		key := [2]string{p.Rules[i].Principal, p.Rules[i].Path}
+		if seen[key] {
+			return filesystem.NewInvalidArgumentError("rules", p.Rules[i].Path, "more than one rule for "+p.Rules[i].Principal)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func normalizeRule(r *Rule) error {
+	if r.Principal == "" {
+		return filesystem.NewInvalidArgumentError("principal", "", "required")
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return filesystem.NewInvalidArgumentError("path", r.Path, "must be absolute")
+	}
+	r.Path = filesystem.NormalizePath(r.Path)
+	if r.Allow == nil {
+		r.Allow = []string{}
+	}
+	for _, op := range r.Allow {
+		if op != OpRead && op != OpWrite {
+			return filesystem.NewInvalidArgumentError("allow", op, "must be read or write")
+		}
+	}
+	return nil
+}
+
+// under reports whether path is dir or below it
+func under(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// Decide returns what principal may do with path
+func (a *ACL) Decide(principal, path string) Decision {
+	path = filesystem.NormalizePath(path)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	d := Decision{Principal: principal, Path: path}
+	var match *Rule
+	for i := range a.policy.Rules {
+		r := &a.policy.Rules[i]
+		if (r.Principal != principal && r.Principal != AnyPrincipal) || !under(path, r.Path) {
+			continue
+		}
+		if match == nil || len(r.Path) > len(match.Path) ||
+			(len(r.Path) == len(match.Path) && r.Principal == principal) {
+			match = r
+		}
+	}
+	if match != nil {
+		rule := *match
+		d.Rule = &rule
+		d.Read, d.Write = match.allows(OpRead), match.allows(OpWrite)
+	} else {
+		d.Read = a.policy.Default == DefaultAllow
+		d.Write = d.Read
+	}
+	if !d.Read {
+		// Directories above readable paths can be traversed
+		for _, r := range a.policy.Rules {
+			if (r.Principal == principal || r.Principal == AnyPrincipal) && r.Path != path && under(r.Path, path) && r.allows(OpRead) {
+				d.Read = true
+				break
+			}
+		}
+	}
+	return d
+}
+
+// Check implements mountablefs.AccessControl
+// Contexts without a principal are allowed everything.
+func (a *ACL) Check(ctx context.Context, op mountablefs.AccessOp, path string) error {
+	principal, ok := a.principal(ctx)
+	if !ok {
+		return nil
+	}
+	d := a.Decide(principal, path)
+	if (op == mountablefs.AccessRead && d.Read) || (op == mountablefs.AccessWrite && d.Write) {
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError(string(op), d.Path, "denied by acl for "+principal)
+}
+
+// CheckTree implements mountablefs.TreeAccessControl
+// The rules of the principal below path must allow op too; reads need rules allowing them,
+// the traversal of directories above readable paths does not extend to their trees.
+func (a *ACL) CheckTree(ctx context.Context, op mountablefs.AccessOp, path string) error {
+	principal, ok := a.principal(ctx)
+	if !ok {
+		return nil
+	}
+	path = filesystem.NormalizePath(path)
+	paths := []string{path}
+	a.mu.RLock()
+	for _, r := range a.policy.Rules {
+		if (r.Principal == principal || r.Principal == AnyPrincipal) && r.Path != path && under(r.Path, path) {
+			paths = append(paths, r.Path)
+		}
+	}
+	deflt := a.policy.Default
+	a.mu.RUnlock()
+
+	for _, p := range paths {
+		d := a.Decide(principal, p)
+		allowed := d.Write
+		if op == mountablefs.AccessRead {
+			allowed = deflt == DefaultAllow
+			if d.Rule != nil {
+				allowed = d.Rule.allows(OpRead)
+			}
+		}
+		if !allowed {
+			return filesystem.NewPermissionDeniedError(string(op), p, "denied by acl for "+principal)
+		}
+	}
+	return nil
+}
+
+// Policy returns a copy of the default and the rules
+func (a *ACL) Policy() Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	p := Policy{Default: a.policy.Default, Rules: make([]Rule, len(a.policy.Rules))}
+	copy(p.Rules, a.policy.Rules)
+	return p
+}
+
+// SetPolicy replaces the default and the rules
+func (a *ACL) SetPolicy(p Policy) error {
+	if err := normalize(&p); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.update(p)
+}
+
+// SetRule adds r, replacing the rule of the same principal and path
+func (a *ACL) SetRule(r Rule) error {
+	if err := normalizeRule(&r); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := Policy{Default: a.policy.Default}
+	for _, old := range a.policy.Rules {
+		if old.Principal != r.Principal || old.Path != r.Path {
+			p.Rules = append(p.Rules, old)
+		}
+	}
+	p.Rules = append(p.Rules, r)
+	return a.update(p)
+}
+
+// RemoveRule removes the rule of principal and path
+func (a *ACL) RemoveRule(principal, path string) error {
+	path = filesystem.NormalizePath(path)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := Policy{Default: a.policy.Default, Rules: []Rule{}}
+	for _, r := range a.policy.Rules {
+		if r.Principal != principal || r.Path != path {
+			p.Rules = append(p.Rules, r)
+		}
+	}
+	if len(p.Rules) == len(a.policy.Rules) {
+		return filesystem.NewNotFoundError("acl rule", path)
+	}
+	return a.update(p)
+}
+
+// update saves p to the file, if any, and makes it the policy; the caller must hold a.mu
+func (a *ACL) update(p Policy) error {
+	if a.file != "" {
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(a.file), 0755); err != nil {
+			return err
+		}
+		tmp := a.file + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, a.file); err != nil {
+			return err
+		}
+	}
+	a.policy = p
+	return nil
+}
@@ -0,0 +1,194 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+)
+
+type principalKey struct{}
+
+func principalOf(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(principalKey{}).(string)
+	return name, ok
+}
+
+// walking hides the CheckTree of an ACL, so that contextFS walks the trees
+type walking struct{ mountablefs.AccessControl }
+
+func TestACL(t *testing.T) {
+	for _, bad := range []config.ACLConfig{
+		{Default: "maybe"},
+		{Rules: []config.ACLRuleConfig{{Path: "/sqlfs"}}},
+		{Rules: []config.ACLRuleConfig{{Principal: "alice", Path: "sqlfs"}}},
+		{Rules: []config.ACLRuleConfig{{Principal: "alice", Path: "/sqlfs", Allow: []string{"delete"}}}},
+		{Rules: []config.ACLRuleConfig{{Principal: "alice", Path: "/sqlfs"}, {Principal: "alice", Path: "/sqlfs/"}}},
+	} {
+		if _, err := New(bad, principalOf); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("sqlfs", func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() })
+	for _, path := range []string{"/sqlfs", "/localfs", "/memfs"} {
+		if err := mfs.MountPlugin("memfs", path, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(path+"/a.txt", []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(t.TempDir(), "acl.json")
+	a, err := New(config.ACLConfig{File: file, Rules: []config.ACLRuleConfig{
+		{Principal: "*", Path: "/sqlfs", Allow: []string{"read"}},
+		{Principal: "*", Path: "/localfs"},
+		{Principal: "admin", Path: "/", Allow: []string{"read", "write"}},
+		{Principal: "alice", Path: "/localfs/shared", Allow: []string{"read"}},
+	}}, principalOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetAccessControl(a)
+	defer mfs.SetAccessControl(nil)
+	as := func(principal string) filesystem.FileSystem {
+		return mfs.WithContext(context.WithValue(context.Background(), principalKey{}, principal))
+	}
+	bob, alice, admin := as("bob"), as("alice"), as("admin")
+
+	// Rules restrict reads and writes of the principals they match
+	if data, err := bob.Read("/sqlfs/a.txt", 0, -1); (err != nil && err != io.EOF) || string(data) != "data" {
+		t.Errorf("read-only read = %q, %v", data, err)
+	}
+	if _, err := bob.Write("/sqlfs/a.txt", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("read-only write: %v", err)
+	}
+	if _, err := bob.Open("/localfs/a.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("denied open: %v", err)
+	}
+	if err := bob.Rename("/memfs/a.txt", "/sqlfs/b.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("rename into a read-only tree: %v", err)
+	}
+	if _, err := bob.Write("/memfs/b.txt", []byte("x")); err != nil {
+		t.Errorf("default allow: %v", err)
+	}
+	if _, err := admin.Write("/sqlfs/b.txt", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("rule of a shorter path: %v", err)
+	}
+	if d := a.Decide("admin", "/memfs/a.txt"); !d.Write || d.Rule == nil || d.Rule.Path != "/" {
+		t.Errorf("admin decision = %+v", d)
+	}
+	// The server's own operations are not checked
+	if _, err := mfs.Read("/localfs/a.txt", 0, -1); err != nil && err != io.EOF {
+		t.Errorf("unbound read: %v", err)
+	}
+
+	// Listings leave out what cannot be read, directories above readable paths can be listed
+	if infos, err := bob.ReadDir("/"); err != nil || len(infos) != 2 {
+		t.Errorf("root listing = %+v, %v", infos, err)
+	}
+	if infos, err := alice.ReadDir("/"); err != nil || len(infos) != 3 {
+		t.Errorf("alice root listing = %+v, %v", infos, err)
+	}
+	if d := a.Decide("alice", "/localfs"); !d.Read || d.Write || d.Rule == nil || d.Rule.Principal != "*" {
+		t.Errorf("traversal decision = %+v", d)
+	}
+	if _, err := alice.Read("/localfs/a.txt", 0, -1); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("read beside the shared directory: %v", err)
+	}
+
+	// Operations on whole trees are denied when a rule below forbids them
+	if err := mfs.Mkdir("/memfs/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/memfs/dir/locked", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/memfs/dir/locked/a.txt", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetRule(Rule{Principal: "*", Path: "/memfs/dir/locked", Allow: []string{"read"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, ac := range []mountablefs.AccessControl{a, walking{a}} {
+		mfs.SetAccessControl(ac)
+		if err := bob.RemoveAll("/memfs/dir"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%T: remove a tree with a read-only directory: %v", ac, err)
+		}
+		if err := bob.Rename("/memfs/dir", "/memfs/moved"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%T: rename a tree with a read-only directory: %v", ac, err)
+		}
+		if _, err := alice.(filesystem.Snapshotter).Snapshot("/localfs"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%T: snapshot of a traversed directory: %v", ac, err)
+		}
+	}
+	mfs.SetAccessControl(a)
+	if _, err := mfs.Stat("/memfs/dir/locked/a.txt"); err != nil {
+		t.Errorf("read-only file after the denied operations: %v", err)
+	}
+	if err := bob.RemoveAll("/memfs/b.txt"); err != nil {
+		t.Errorf("remove without rules below: %v", err)
+	}
+	if _, err := admin.(filesystem.Snapshotter).Snapshot("/memfs"); err != nil {
+		t.Errorf("snapshot of a readable tree: %v", err)
+	}
+
+	// So are the entries of bulk loads
+	if err := mfs.MountPlugin("sqlfs", "/bulk", map[string]interface{}{"db_path": filepath.Join(t.TempDir(), "bulk.db")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetRule(Rule{Principal: "*", Path: "/bulk/locked", Allow: []string{"read"}}); err != nil {
+		t.Fatal(err)
+	}
+	session, err := bob.(filesystem.BulkWriter).BeginBulk("/bulk", filesystem.BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []filesystem.BulkEntry{{Path: "/bulk/a.txt", Data: []byte("x")}, {Path: "/bulk/locked/b.txt", Data: []byte("x")}}
+	if err := session.WriteBatch(batch); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("bulk load into a read-only directory: %v", err)
+	}
+	if err := session.WriteBatch(batch[:1]); err != nil {
+		t.Errorf("bulk load: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/bulk/locked/b.txt"); err == nil {
+		t.Errorf("denied bulk entry was written")
+	}
+
+	// Runtime edits are saved and loaded back
+	if err := a.SetRule(Rule{Principal: "bob", Path: "/localfs/", Allow: []string{"read", "write"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.Write("/localfs/c.txt", []byte("x")); err != nil {
+		t.Errorf("write after edit: %v", err)
+	}
+	if err := a.RemoveRule("bob", "/memfs"); !filesystem.IsNotFound(err) {
+		t.Errorf("remove missing rule: %v", err)
+	}
+	if err := a.SetPolicy(Policy{Default: DefaultDeny, Rules: []Rule{{Principal: "bob", Path: "/localfs"}}}); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := New(config.ACLConfig{File: file}, principalOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := reloaded.Policy(); p.Default != DefaultDeny || len(p.Rules) != 1 || p.Rules[0].Principal != "bob" {
+		t.Errorf("reloaded policy = %+v", p)
+	}
+	if d := reloaded.Decide("alice", "/memfs/a.txt"); d.Read || d.Write || d.Rule != nil {
+		t.Errorf("default deny decision = %+v", d)
+	}
+}
@@ -0,0 +1,428 @@
+// Package analytics counts the reads and writes of each path, and the bytes they moved,
+// over sliding windows, and reports the hot files and mounts: which paths are worth a
+// cache, and where the traffic that quotas should bound goes
+package analytics
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Defaults of the analytics configuration
+const (
+	DefaultResolution = time.Minute
+	DefaultMaxPaths   = 10000
+	DefaultTop        = 20
+)
+
+// DefaultWindows are the windows of the serverinfofs reports
+var DefaultWindows = []string{"5m", "1h", "24h"}
+
+// DefaultExclude are the path prefixes not tracked by default
+var DefaultExclude = []string{"/serverinfofs"}
+
+// Orders of the reports
+const (
+	ByOps        = "ops" // Reads and writes
+	ByReads      = "reads"
+	ByWrites     = "writes"
+	ByBytes      = "bytes" // Bytes read and written
+	ByReadBytes  = "read_bytes"
+	ByWriteBytes = "write_bytes"
+)
+
+// AccessSource publishes reads and writes, implemented by MountableFS
+type AccessSource interface {
+	ObserveAccess(fn func(mountablefs.Access)) func()
+}
+
+// Counters are the accesses of a path, a mount or the server within a window
+type Counters struct {
+	Reads      int64 `json:"reads"`
+	Writes     int64 `json:"writes"`
+	ReadBytes  int64 `json:"readBytes"`
+	WriteBytes int64 `json:"writeBytes"`
+}
+
+func (c *Counters) add(o Counters) {
+	c.Reads += o.Reads
+	c.Writes += o.Writes
+	c.ReadBytes += o.ReadBytes
+	c.WriteBytes += o.WriteBytes
+}
+
+// key returns the value of c the reports are ordered by
+func (c Counters) key(by string) int64 {
+	switch by {
+	case ByReads:
+		return c.Reads
+	case ByWrites:
+		return c.Writes
+	case ByBytes:
+		return c.ReadBytes + c.WriteBytes
+	case ByReadBytes:
+		return c.ReadBytes
+	case ByWriteBytes:
+		return c.WriteBytes
+	default:
+		return c.Reads + c.Writes
+	}
+}
+
+// FileStats are the accesses of a file within the window of a report
+type FileStats struct {
+	Path       string    `json:"path"`
+	LastAccess time.Time `json:"lastAccess"`
+	Counters
+}
+
+// MountStats are the accesses of the files of a mount within the window of a report
+type MountStats struct {
+	Mount string `json:"mount"`
+	Files int    `json:"files"` // Files accessed
+	Counters
+}
+
+// Report lists the hottest files and mounts of a window
+type Report struct {
+	Window  string       `json:"window"`
+	Since   time.Time    `json:"since"`
+	By      string       `json:"by"`
+	Prefix  string       `json:"prefix,omitempty"`
+	Tracked int          `json:"tracked"` // Paths tracked by the collector
+	Total   Counters     `json:"total"`   // All accesses of the window below the prefix
+	Mounts  []MountStats `json:"mounts"`
+	Files   []FileStats  `json:"files"`
+}
+
+// bucket counts the accesses of a path during one resolution interval
+type bucket struct {
+	slot int64 // Start of the interval, in resolutions since the epoch
+	Counters
+}
+
+// pathStats are the buckets of a path, oldest first
+type pathStats struct {
+	path       string
+	lastAccess time.Time
+	buckets    []bucket
+}
+
+// Collector counts accesses per path in buckets covering the longest window
+type Collector struct {
+	windows    []time.Duration
+	resolution time.Duration
+	slots      int64 // Buckets of the longest window
+	maxPaths   int
+	top        int
+	exclude    []string
+	mountOf    func(path string) string
+	now        func() time.Time
+
+	mu    sync.Mutex
+	paths map[string]*list.Element // Values are *pathStats
+	lru   *list.List               // Most recently accessed first
+
+	unsubscribe func()
+	done        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewCollector validates the configuration; mountOf returns the mount holding a path, for
+// the mount totals of the reports, and may be nil
+func NewCollector(cfg config.AnalyticsConfig, mountOf func(path string) string) (*Collector, error) {
+	c := &Collector{
+		resolution: DefaultResolution,
+		maxPaths:   cfg.MaxPaths,
+		top:        cfg.Top,
+		mountOf:    mountOf,
+		now:        time.Now,
+		paths:      make(map[string]*list.Element),
+		lru:        list.New(),
+		done:       make(chan struct{}),
+	}
+	if cfg.Resolution != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Resolution)
+		if err != nil {
+			return nil, fmt.Errorf("invalid analytics resolution: %w", err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("analytics resolution must be at least 1s")
+		}
+		c.resolution = d
+	}
+	windows := cfg.Windows
+	if len(windows) == 0 {
+		windows = DefaultWindows
+	}
+	longest := time.Duration(0)
+	for _, w := range windows {
+		d, err := pluginconfig.ParseDuration(w)
+		if err != nil {
+			return nil, fmt.Errorf("invalid analytics window %q: %w", w, err)
+		}
+		if d < c.resolution || d%c.resolution != 0 {
+			return nil, fmt.Errorf("analytics window %s must be a multiple of the resolution %s", w, c.resolution)
+		}
+		c.windows = append(c.windows, d)
+		longest = max(longest, d)
+	}
+	c.slots = int64(longest / c.resolution)
+	if c.maxPaths < 0 || c.top < 0 {
+		return nil, fmt.Errorf("analytics max_paths and top must not be negative")
+	}
+	if c.maxPaths == 0 {
+		c.maxPaths = DefaultMaxPaths
+	}
+	if c.top == 0 {
+		c.top = DefaultTop
+	}
+	exclude := cfg.Exclude
+	if exclude == nil {
+		exclude = DefaultExclude
+	}
+	for _, prefix := range exclude {
+		c.exclude = append(c.exclude, filesystem.NormalizePath(prefix))
+	}
+	if c.mountOf == nil {
+		c.mountOf = func(string) string { return "/" }
+	}
+	return c, nil
+}
+
+// Windows returns the windows of the serverinfofs reports
+func (c *Collector) Windows() []time.Duration {
+	return c.windows
+}
+
+// FormatWindow formats a window without its zero units, e.g. "5m" or "24h"
+func FormatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// Start counts the accesses published by source and drops expired counts periodically
+func (c *Collector) Start(source AccessSource) {
+	if source != nil {
+		c.unsubscribe = source.ObserveAccess(c.Record)
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.resolution)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				c.prune()
+			}
+		}
+	}()
+}
+
+// Stop stops counting
+func (c *Collector) Stop() {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+	close(c.done)
+	c.wg.Wait()
+}
+
+// excluded reports whether p is below a prefix that is not tracked
+func (c *Collector) excluded(p string) bool {
+	for _, prefix := range c.exclude {
+		if within(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// Record counts an access
+func (c *Collector) Record(a mountablefs.Access) {
+	if c.excluded(a.Path) {
+		return
+	}
+	slot := a.Time.UnixNano() / int64(c.resolution)
+	var delta Counters
+	if a.Op == mountablefs.AccessWrite {
+		delta.Writes, delta.WriteBytes = 1, a.Bytes
+	} else {
+		delta.Reads, delta.ReadBytes = 1, a.Bytes
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ps *pathStats
+	if e, ok := c.paths[a.Path]; ok {
+		ps = e.Value.(*pathStats)
+		c.lru.MoveToFront(e)
+	} else {
+		ps = &pathStats{path: a.Path}
+		c.paths[a.Path] = c.lru.PushFront(ps)
+		for len(c.paths) > c.maxPaths {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.paths, oldest.Value.(*pathStats).path)
+		}
+	}
+	if a.Time.After(ps.lastAccess) {
+		ps.lastAccess = a.Time
+	}
+	// Accesses are reported nearly in order; one reported late goes to the newest bucket
+	if n := len(ps.buckets); n > 0 && ps.buckets[n-1].slot >= slot {
+		ps.buckets[n-1].add(delta)
+	} else {
+		ps.buckets = append(ps.buckets, bucket{slot: slot, Counters: delta})
+	}
+	ps.buckets = expire(ps.buckets, slot-c.slots)
+}
+
+// expire drops the buckets before slot oldest
+func expire(buckets []bucket, oldest int64) []bucket {
+	i := 0
+	for i < len(buckets) && buckets[i].slot <= oldest {
+		i++
+	}
+	if i == 0 {
+		return buckets
+	}
+	return append(buckets[:0], buckets[i:]...)
+}
+
+// prune forgets the paths not accessed within the longest window
+func (c *Collector) prune() {
+	oldest := c.now().UnixNano()/int64(c.resolution) - c.slots
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.lru.Back(); e != nil; {
+		prev := e.Prev()
+		ps := e.Value.(*pathStats)
+		if ps.buckets = expire(ps.buckets, oldest); len(ps.buckets) == 0 {
+			c.lru.Remove(e)
+			delete(c.paths, ps.path)
+		}
+		e = prev
+	}
+}
+
+// Report lists the files and mounts below prefix, "/" for all, with the most accesses
+// within window, ordered by by (ByOps by default); limit bounds the files, 0 for the
+// configured top. Only the files allow accepts are reported, all of them if it is nil.
+func (c *Collector) Report(window time.Duration, by, prefix string, limit int, allow func(path string) bool) (Report, error) {
+	switch by {
+	case "":
+		by = ByOps
+	case ByOps, ByReads, ByWrites, ByBytes, ByReadBytes, ByWriteBytes:
+	default:
+		return Report{}, fmt.Errorf("invalid order %q: expected %s, %s, %s, %s, %s or %s", by, ByOps, ByReads, ByWrites, ByBytes, ByReadBytes, ByWriteBytes)
+	}
+	if window <= 0 || window > time.Duration(c.slots)*c.resolution {
+		return Report{}, fmt.Errorf("window must be between %s and %s", c.resolution, time.Duration(c.slots)*c.resolution)
+	}
+	if limit <= 0 {
+		limit = c.top
+	}
+	if prefix == "" {
+		prefix = "/"
+	}
+	prefix = filesystem.NormalizePath(prefix)
+
+	now := c.now()
+	oldest := now.UnixNano()/int64(c.resolution) - int64((window+c.resolution-1)/c.resolution)
+	report := Report{
+		Window: FormatWindow(window),
+		Since:  time.Unix(0, (oldest+1)*int64(c.resolution)),
+		By:     by,
+		Mounts: []MountStats{},
+		Files:  []FileStats{},
+	}
+	if prefix != "/" {
+		report.Prefix = prefix
+	}
+
+	c.mu.Lock()
+	report.Tracked = len(c.paths)
+	for p, e := range c.paths {
+		if !within(p, prefix) {
+			continue
+		}
+		ps := e.Value.(*pathStats)
+		st := FileStats{Path: p, LastAccess: ps.lastAccess}
+		for _, b := range ps.buckets {
+			if b.slot > oldest {
+				st.add(b.Counters)
+			}
+		}
+		if st.Reads+st.Writes > 0 {
+			report.Files = append(report.Files, st)
+		}
+	}
+	c.mu.Unlock()
+	if allow != nil {
+		allowed := report.Files[:0]
+		for _, st := range report.Files {
+			if allow(st.Path) {
+				allowed = append(allowed, st)
+			}
+		}
+		report.Files = allowed
+	}
+
+	mounts := make(map[string]*MountStats)
+	for _, st := range report.Files {
+		report.Total.add(st.Counters)
+		mount := c.mountOf(st.Path)
+		ms := mounts[mount]
+		if ms == nil {
+			ms = &MountStats{Mount: mount}
+			mounts[mount] = ms
+		}
+		ms.Files++
+		ms.add(st.Counters)
+	}
+	for _, ms := range mounts {
+		report.Mounts = append(report.Mounts, *ms)
+	}
+	sort.Slice(report.Mounts, func(i, j int) bool {
+		a, b := report.Mounts[i], report.Mounts[j]
+		if ka, kb := a.key(by), b.key(by); ka != kb {
+			return ka > kb
+		}
+		return a.Mount < b.Mount
+	})
+	sort.Slice(report.Files, func(i, j int) bool {
+		a, b := report.Files[i], report.Files[j]
+		if ka, kb := a.key(by), b.key(by); ka != kb {
+			return ka > kb
+		}
+		return a.Path < b.Path
+	})
+	if len(report.Files) > limit {
+		report.Files = report.Files[:limit]
+	}
+	return report, nil
+}
@@ -0,0 +1,119 @@
+package analytics
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestCollector(t *testing.T) {
+	for _, bad := range []config.AnalyticsConfig{
+		{Windows: []string{"90s"}},
+		{Windows: []string{"1h"}, Resolution: "100ms"},
+		{MaxPaths: -1},
+	} {
+		if _, err := NewCollector(bad, nil); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	for _, path := range []string{"/hot", "/cold"} {
+		if err := mfs.MountPlugin("memfs", path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := NewCollector(config.AnalyticsConfig{Windows: []string{"5m", "1h"}, MaxPaths: 3, Exclude: []string{"/cold/private"}}, func(path string) string {
+		mount, _ := mfs.LookupMount(path)
+		return mount.Path
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start(mfs)
+	defer c.Stop()
+
+	// Reads and writes are counted with their bytes, streamed ones when closed
+	mfs.Write("/hot/a", []byte("0123456789"))
+	for i := 0; i < 3; i++ {
+		mfs.Read("/hot/a", 0, -1)
+	}
+	r, err := mfs.Open("/hot/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(r)
+	r.Close()
+	w, err := mfs.OpenWrite("/cold/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("abc"))
+	w.Close()
+	mfs.Read("/cold/b", 0, -1)
+	mfs.Write("/cold/private/c", []byte("secret"))
+	mfs.Read("/hot/missing", 0, -1)
+
+	report, err := c.Report(5*time.Minute, "", "/", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Window != "5m" || report.By != ByOps || report.Tracked != 2 || len(report.Files) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	if f := report.Files[0]; f.Path != "/hot/a" || f.Reads != 4 || f.Writes != 1 || f.ReadBytes != 40 || f.WriteBytes != 10 {
+		t.Errorf("hot file = %+v", f)
+	}
+	if f := report.Files[1]; f.Path != "/cold/b" || f.Reads != 1 || f.Writes != 1 || f.ReadBytes != 3 {
+		t.Errorf("cold file = %+v", f)
+	}
+	if report.Total.Reads != 5 || report.Total.Writes != 2 {
+		t.Errorf("total = %+v", report.Total)
+	}
+	if len(report.Mounts) != 2 || report.Mounts[0].Mount != "/hot" || report.Mounts[0].Files != 1 {
+		t.Errorf("mounts = %+v", report.Mounts)
+	}
+
+	// Reports are filtered by prefix and by allow, ordered and limited
+	if report, err := c.Report(time.Hour, ByWrites, "/cold", 1, nil); err != nil || len(report.Files) != 1 || report.Files[0].Path != "/cold/b" || report.Prefix != "/cold" {
+		t.Errorf("cold report = %+v, %v", report, err)
+	}
+	allow := func(path string) bool { return path != "/hot/a" }
+	if report, err := c.Report(time.Hour, "", "/", 0, allow); err != nil || len(report.Files) != 1 || report.Total.Reads != 1 || len(report.Mounts) != 1 {
+		t.Errorf("allowed report = %+v, %v", report, err)
+	}
+	if _, err := c.Report(2*time.Hour, "", "/", 0, nil); err == nil {
+		t.Error("window longer than kept: no error")
+	}
+	if _, err := c.Report(time.Hour, "size", "/", 0, nil); err == nil {
+		t.Error("unknown order: no error")
+	}
+
+	// Counts leave the windows as time passes, and the least recently accessed paths are
+	// dropped beyond max_paths
+	c.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	if report, _ := c.Report(5*time.Minute, "", "/", 0, nil); len(report.Files) != 0 {
+		t.Errorf("expired report = %+v", report.Files)
+	}
+	if report, _ := c.Report(time.Hour, "", "/", 0, nil); len(report.Files) != 2 {
+		t.Errorf("hour report = %+v", report.Files)
+	}
+	for _, p := range []string{"/hot/x", "/hot/y"} {
+		mfs.Write(p, []byte("x"))
+	}
+	// /hot/a was last accessed before /cold/b
+	if report, _ := c.Report(time.Hour, "", "/", 0, nil); report.Tracked != 3 || report.Files[0].Path != "/cold/b" || len(report.Files) != 3 || report.Total.Reads != 1 {
+		t.Errorf("tracked = %d, files %+v", report.Tracked, report.Files)
+	}
+	c.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	c.prune()
+	if report, _ := c.Report(time.Hour, "", "/", 0, nil); report.Tracked != 0 {
+		t.Errorf("tracked after prune = %d", report.Tracked)
+	}
+}
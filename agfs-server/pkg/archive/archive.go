@@ -0,0 +1,258 @@
+package archive
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Format identifies an archive encoding
+type Format string
+
+// Supported archive formats
+const (
+	FormatTar   Format = "tar"
+	FormatTarGz Format = "tar.gz"
+	FormatZip   Format = "zip"
+)
+
+// ParseFormat parses a format name, accepting common aliases such as "tgz"
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "", "tar":
+		return FormatTar, nil
+	case "tar.gz", "tgz", "gz":
+		return FormatTarGz, nil
+	case "zip":
+		return FormatZip, nil
+	default:
+		return "", filesystem.NewInvalidArgumentError("format", s, "supported formats are tar, tar.gz and zip")
+	}
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatTarGz:
+		return "application/gzip"
+	case FormatZip:
+		return "application/zip"
+	default:
+		return "application/x-tar"
+	}
+}
+
+// Extension returns the file name extension of the format, including the leading dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Filter selects archive entries by their path relative to the archive root
+// A pattern matches if it matches the relative path, the base name or any parent
+// directory (path.Match syntax), so "logs" or "*.tmp" exclude whole subtrees
+type Filter struct {
+	Include []string // If non-empty, only matching entries are processed
+	Exclude []string // Matching entries are skipped; takes precedence over Include
+}
+
+// NewFilter builds a filter from comma separated include and exclude lists
+// It returns nil if both lists are empty
+func NewFilter(include, exclude string) (*Filter, error) {
+	f := &Filter{Include: splitPatterns(include), Exclude: splitPatterns(exclude)}
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("pattern", p, "malformed pattern")
+		}
+	}
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func splitPatterns(s string) []string {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Excluded reports whether the entry named rel is excluded
+func (f *Filter) Excluded(rel string) bool {
+	return f != nil && matchAny(f.Exclude, rel)
+}
+
+// Included reports whether the entry named rel passes the filter
+func (f *Filter) Included(rel string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Excluded(rel) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, rel)
+}
+
+// matchAny checks rel, its base name and each parent directory against patterns
+func matchAny(patterns []string, rel string) bool {
+	rel = strings.Trim(rel, "/")
+	if rel == "" {
+		return false
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, path.Base(rel)); ok {
+			return true
+		}
+		for dir := rel; dir != "." && dir != ""; dir = path.Dir(dir) {
+			if ok, _ := path.Match(p, dir); ok {
+				return true
+			}
+			if ok, _ := path.Match(p, path.Base(dir)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Options controls archive creation and extraction
+type Options struct {
+	Filter *Filter // Optional entry filter
+	DryRun bool    // Extraction only: report entries without writing them
+}
+
+// Write encodes the tree rooted at root in the given format
+func Write(w io.Writer, fs filesystem.FileSystem, root string, format Format, opts Options) (Stats, error) {
+	switch format {
+	case FormatTar:
+		return writeTar(w, fs, root, opts)
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		stats, err := writeTar(gz, fs, root, opts)
+		if err != nil {
+			return stats, err
+		}
+		return stats, gz.Close()
+	case FormatZip:
+		return writeZip(w, fs, root, opts)
+	default:
+		return Stats{}, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// Extract decodes an archive in the given format into dest
+func Extract(r io.Reader, fs filesystem.FileSystem, dest string, format Format, opts Options) (Stats, error) {
+	switch format {
+	case FormatTar:
+		return extractTar(r, fs, dest, opts)
+	case FormatTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return Stats{}, filesystem.NewInvalidArgumentError("body", "", "invalid gzip stream: "+err.Error())
+		}
+		defer gz.Close()
+		return extractTar(gz, fs, dest, opts)
+	case FormatZip:
+		return extractZip(r, fs, dest, opts)
+	default:
+		return Stats{}, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// extractor writes archive entries into a file system, honoring Options
+type extractor struct {
+	fs    filesystem.FileSystem
+	dest  string
+	opts  Options
+	stats Stats
+}
+
+func newExtractor(fs filesystem.FileSystem, dest string, opts Options) (*extractor, error) {
+	e := &extractor{fs: fs, dest: filesystem.NormalizePath(dest), opts: opts}
+	if !opts.DryRun {
+		if err := filesystem.MkdirAll(fs, e.dest, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// dir creates the directory entry name
+func (e *extractor) dir(name string) error {
+	if !e.opts.Filter.Included(name) {
+		return nil
+	}
+	target := entryPath(e.dest, name)
+	e.stats.Dirs++
+	if e.opts.DryRun {
+		e.stats.Entries = append(e.stats.Entries, target+"/")
+		return nil
+	}
+	return filesystem.MkdirAll(e.fs, target, 0755)
+}
+
+// file writes the file entry name with the content read from r
+func (e *extractor) file(name string, r io.Reader, size int64) error {
+	if !e.opts.Filter.Included(name) {
+		return nil
+	}
+	target := entryPath(e.dest, name)
+	e.stats.Files++
+	if e.opts.DryRun {
+		e.stats.Bytes += size
+		e.stats.Entries = append(e.stats.Entries, target)
+		return nil
+	}
+
+	if err := filesystem.MkdirAll(e.fs, path.Dir(target), 0755); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s from archive: %w", name, err)
+	}
+	if _, err := e.fs.Write(target, data); err != nil {
+		return err
+	}
+	e.stats.Bytes += int64(len(data))
+	return nil
+}
+
+// walkEntries walks the tree under root and calls fn for every entry that passes the filter
+// name is relative to root; the root directory itself is skipped, a root file uses its base name
+func walkEntries(fs filesystem.FileSystem, root string, filter *Filter, fn func(p, name string, info *filesystem.FileInfo) error) error {
+	root = filesystem.NormalizePath(root)
+	return filesystem.Walk(fs, root, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name := relativeName(root, p)
+		if name == "" {
+			if info.IsDir {
+				// The root directory itself is implied
+				return nil
+			}
+			name = path.Base(p)
+		}
+
+		if filter.Excluded(name) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if !filter.Included(name) {
+			// Directories are still walked, included descendants bring their parents along
+			return nil
+		}
+		return fn(p, name, info)
+	})
+}
@@ -0,0 +1,118 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Stats summarizes an archive operation
+type Stats struct {
+	Files   int      `json:"files"`             // Number of regular files
+	Dirs    int      `json:"dirs"`              // Number of directories
+	Bytes   int64    `json:"bytes"`             // Total file content bytes
+	Entries []string `json:"entries,omitempty"` // Paths that would be written (dry run only)
+}
+
+// WriteTar writes the tree rooted at root as a tar stream
+// Entry names are relative to root; directories end with "/"
+func WriteTar(w io.Writer, fs filesystem.FileSystem, root string) (Stats, error) {
+	return Write(w, fs, root, FormatTar, Options{})
+}
+
+// ExtractTar unpacks a tar stream into dest, creating directories as needed
+// Existing files are overwritten; entries can never be written outside dest
+func ExtractTar(r io.Reader, fs filesystem.FileSystem, dest string) (Stats, error) {
+	return Extract(r, fs, dest, FormatTar, Options{})
+}
+
+func writeTar(w io.Writer, fs filesystem.FileSystem, root string, opts Options) (Stats, error) {
+	var stats Stats
+	tw := tar.NewWriter(w)
+
+	err := walkEntries(fs, root, opts.Filter, func(p, name string, info *filesystem.FileInfo) error {
+		if info.IsDir {
+			stats.Dirs++
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     int64(info.Mode & 0777),
+				ModTime:  info.ModTime,
+			})
+		}
+
+		data, err := fs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     int64(info.Mode & 0777),
+			Size:     int64(len(data)),
+			ModTime:  info.ModTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, tw.Close()
+}
+
+func extractTar(r io.Reader, fs filesystem.FileSystem, dest string, opts Options) (Stats, error) {
+	e, err := newExtractor(fs, dest, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return e.stats, nil
+		}
+		if err != nil {
+			return e.stats, filesystem.NewInvalidArgumentError("body", "", "invalid tar stream: "+err.Error())
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = e.dir(hdr.Name)
+		case tar.TypeReg:
+			err = e.file(hdr.Name, tr, hdr.Size)
+		default:
+			// Links, devices etc. have no equivalent in AGFS
+			continue
+		}
+		if err != nil {
+			return e.stats, err
+		}
+	}
+}
+
+// relativeName returns p relative to root without a leading slash
+func relativeName(root, p string) string {
+	if root == "/" {
+		return strings.TrimPrefix(p, "/")
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(p, root), "/")
+}
+
+// entryPath resolves an archive entry name under dest
+// Cleaning the name as an absolute path drops any ".." that would escape dest
+func entryPath(dest, name string) string {
+	return path.Join(dest, path.Clean("/"+name))
+}
@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func writeZip(w io.Writer, fs filesystem.FileSystem, root string, opts Options) (Stats, error) {
+	var stats Stats
+	zw := zip.NewWriter(w)
+
+	err := walkEntries(fs, root, opts.Filter, func(p, name string, info *filesystem.FileInfo) error {
+		hdr := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: info.ModTime,
+		}
+		if info.IsDir {
+			hdr.Name += "/"
+			hdr.Method = zip.Store
+			hdr.SetMode(os.ModeDir | os.FileMode(info.Mode&0777))
+			stats.Dirs++
+			_, err := zw.CreateHeader(hdr)
+			return err
+		}
+		hdr.SetMode(os.FileMode(info.Mode & 0777))
+
+		data, err := fs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, zw.Close()
+}
+
+// extractZip spools r to a temporary file, since zip archives need random access
+func extractZip(r io.Reader, fs filesystem.FileSystem, dest string, opts Options) (Stats, error) {
+	tmp, err := os.CreateTemp("", "agfs-import-*.zip")
+	if err != nil {
+		return Stats{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to read archive: %w", err)
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return Stats{}, filesystem.NewInvalidArgumentError("body", "", "invalid zip archive: "+err.Error())
+	}
+
+	e, err := newExtractor(fs, dest, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			if err := e.dir(f.Name); err != nil {
+				return e.stats, err
+			}
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return e.stats, fmt.Errorf("failed to open %s in archive: %w", f.Name, err)
+		}
+		err = e.file(f.Name, rc, int64(f.UncompressedSize64))
+		rc.Close()
+		if err != nil {
+			return e.stats, err
+		}
+	}
+	return e.stats, nil
+}
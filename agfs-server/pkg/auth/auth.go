@@ -0,0 +1,483 @@
+// Package auth authenticates requests of the HTTP API with static API keys or JWT bearer
+// tokens, and enforces the scopes that configured rules require on routes and the admin role
+// that admin routes require.
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// APIKeyHeader carries an API key as an alternative to the Authorization header
+const APIKeyHeader = "X-API-Key"
+
+// AllScopes in the scopes of a key or token grants every scope
+const AllScopes = "*"
+
+// DefaultLeeway is the clock skew allowed checking the expiry of tokens
+const DefaultLeeway = 30 * time.Second
+
+// DefaultAdminScopes are the scopes granting the admin role unless admin_scopes is configured
+var DefaultAdminScopes = []string{"admin"}
+
+// Roles of principals; only admins may use the admin routes, see SetAdminRoutes
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// DefaultExempt are the paths served without a token unless exempt is configured
+var DefaultExempt = []string{"/api/v1/health", "/livez", "/readyz"}
+
+// Errors of Authenticate
+var (
+	ErrNoToken      = errors.New("no token")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpired      = errors.New("token has expired")
+)
+
+// Principal is the identity a request was authenticated as
+type Principal struct {
+	Name   string   `json:"name"`   // Name of the API key, or subject of the JWT
+	Method string   `json:"method"` // "api_key", "jwt", or "guest" for guest logons to SMB shares
+	Scopes []string `json:"scopes"`
+	Role   string   `json:"role"` // RoleAdmin if one of the scopes grants it, else RoleUser
+}
+
+// HasScope reports whether the principal was granted scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == AllScopes {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// IsAdmin reports whether the principal has the admin role
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request, nil if there is none
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type apiKey struct {
+	key       []byte
+	principal *Principal
+}
+
+type rule struct {
+	path    string
+	methods map[string]bool
+	scopes  []string
+}
+
+// Authenticator checks the tokens of requests
+type Authenticator struct {
+	keys      []apiKey
+	secret    []byte
+	publicKey crypto.PublicKey
+	issuer    string
+	audience  string
+	leeway    time.Duration
+	exempt    []string
+	rules     []rule
+	admins    []string // Scopes granting the admin role
+	isAdmin   func(method, path string) bool
+	now       func() time.Time
+}
+
+// New creates an authenticator from the auth configuration
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		secret:   []byte(cfg.JWT.Secret),
+		issuer:   cfg.JWT.Issuer,
+		audience: cfg.JWT.Audience,
+		leeway:   DefaultLeeway,
+		exempt:   cfg.Exempt,
+		admins:   cfg.AdminScopes,
+		now:      time.Now,
+	}
+	if a.exempt == nil {
+		a.exempt = DefaultExempt
+	}
+	if a.admins == nil {
+		a.admins = DefaultAdminScopes
+	}
+	seen := make(map[string]bool)
+	for _, k := range cfg.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("auth: api key needs a name and a key")
+		}
+		if seen[k.Key] {
+			return nil, fmt.Errorf("auth: api key %s is not unique", k.Name)
+		}
+		seen[k.Key] = true
+		a.keys = append(a.keys, apiKey{key: []byte(k.Key), principal: a.withRole(&Principal{Name: k.Name, Method: "api_key", Scopes: k.Scopes})})
+	}
+	if cfg.JWT.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.JWT.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %w", err)
+		}
+		if a.publicKey, err = parsePublicKey(data); err != nil {
+			return nil, fmt.Errorf("auth: %s: %w", cfg.JWT.PublicKeyFile, err)
+		}
+	}
+	if cfg.JWT.Leeway != "" {
+		leeway, err := pluginconfig.ParseDuration(cfg.JWT.Leeway)
+		if err != nil || leeway < 0 {
+			return nil, fmt.Errorf("auth: invalid jwt leeway: %s", cfg.JWT.Leeway)
+		}
+		a.leeway = leeway
+	}
+	if len(a.keys) == 0 && len(a.secret) == 0 && a.publicKey == nil {
+		return nil, fmt.Errorf("auth: no api keys, jwt secret or jwt public key configured")
+	}
+	for _, r := range cfg.Rules {
+		if !strings.HasPrefix(r.Path, "/") || len(r.Scopes) == 0 {
+			return nil, fmt.Errorf("auth: rule %q needs an absolute path and scopes", r.Path)
+		}
+		ru := rule{path: r.Path, scopes: r.Scopes}
+		if len(r.Methods) > 0 {
+			ru.methods = make(map[string]bool, len(r.Methods))
+			for _, m := range r.Methods {
+				ru.methods[strings.ToUpper(m)] = true
+			}
+		}
+		a.rules = append(a.rules, ru)
+	}
+	return a, nil
+}
+
+// parsePublicKey parses a PEM RSA or ECDSA public key or certificate
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		var err error
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// withRole sets the role the scopes of p grant and returns p
+func (a *Authenticator) withRole(p *Principal) *Principal {
+	p.Role = RoleUser
+	for _, s := range a.admins {
+		if p.HasScope(s) {
+			p.Role = RoleAdmin
+			break
+		}
+	}
+	return p
+}
+
+// SetAdminRoutes makes the requests isAdmin reports, by method and path, require the admin
+// role; without it any principal may use any route the rules allow
+func (a *Authenticator) SetAdminRoutes(isAdmin func(method, path string) bool) {
+	a.isAdmin = isAdmin
+}
+
+// Exempt reports whether path is served without a token
+func (a *Authenticator) Exempt(path string) bool {
+	for _, e := range a.exempt {
+		if path == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(path, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiredScopes returns the scopes of the first rule matching a request, one of which the
+// principal must have; nil if any authenticated principal may make it
+func (a *Authenticator) RequiredScopes(method, path string) []string {
+	for _, r := range a.rules {
+		prefix := strings.TrimSuffix(r.path, "/")
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if r.methods != nil && !r.methods[method] {
+			continue
+		}
+		return r.scopes
+	}
+	return nil
+}
+
+// Authenticate returns the principal of the token of r
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token := r.Header.Get(APIKeyHeader)
+	if token == "" {
+		token = bearerToken(r)
+	}
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(token), k.key) == 1 {
+			return k.principal, nil
+		}
+	}
+	if strings.Count(token, ".") == 2 && (len(a.secret) > 0 || a.publicKey != nil) {
+		return a.verifyJWT(token)
+	}
+	return nil, ErrInvalidToken
+}
+
+// Credential returns the principal of the API key named name and the key, for protocols
+// such as SMB whose challenge-response logons need the shared secret instead of a token;
+// names match case-insensitively, as Windows user names do
+func (a *Authenticator) Credential(name string) (*Principal, []byte, bool) {
+	for _, k := range a.keys {
+		if strings.EqualFold(k.principal.Name, name) {
+			return k.principal, k.key, true
+		}
+	}
+	return nil, nil, false
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	Expires   *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"`
+	Scopes    []string        `json:"scopes"`
+}
+
+// verifyJWT checks the signature and claims of a compact JWT
+func (a *Authenticator) verifyJWT(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := a.verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		log.Debugf("[auth] jwt signature: %v", err)
+		return nil, ErrInvalidToken
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := a.now()
+	if claims.Expires != nil && now.After(unixTime(*claims.Expires).Add(a.leeway)) {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != nil && now.Add(a.leeway).Before(unixTime(*claims.NotBefore)) {
+		return nil, ErrInvalidToken
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, ErrInvalidToken
+	}
+	if a.audience != "" && !hasAudience(claims.Audience, a.audience) {
+		return nil, ErrInvalidToken
+	}
+	scopes := claims.Scopes
+	if claims.Scope != "" {
+		scopes = append(scopes, strings.Fields(claims.Scope)...)
+	}
+	return a.withRole(&Principal{Name: claims.Subject, Method: "jwt", Scopes: scopes}), nil
+}
+
+// verifySignature checks sig of signed with the key alg requires
+func (a *Authenticator) verifySignature(alg, signed string, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var newHash func() hash.Hash
+	var hashID crypto.Hash
+	switch alg[2:] {
+	case "256":
+		newHash, hashID = sha256.New, crypto.SHA256
+	case "384":
+		newHash, hashID = sha512.New384, crypto.SHA384
+	case "512":
+		newHash, hashID = sha512.New, crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+
+	switch alg[:2] {
+	case "HS":
+		if len(a.secret) == 0 {
+			return fmt.Errorf("no secret for %s", alg)
+		}
+		mac := hmac.New(newHash, a.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("signature mismatch")
+		}
+		return nil
+	case "RS":
+		key, ok := a.publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("no RSA key for %s", alg)
+		}
+		h := newHash()
+		h.Write([]byte(signed))
+		return rsa.VerifyPKCS1v15(key, hashID, h.Sum(nil), sig)
+	case "ES":
+		key, ok := a.publicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("no ECDSA key for %s", alg)
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("signature length %d", len(sig))
+		}
+		h := newHash()
+		h.Write([]byte(signed))
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, h.Sum(nil), r, s) {
+			return fmt.Errorf("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// hasAudience reports whether the "aud" claim, a string or an array, contains audience
+func hasAudience(raw json.RawMessage, audience string) bool {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, aud := range many {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests to routes that are not exempt without a valid token with 401,
+// and those whose principal lacks the scopes a rule requires or the admin role an admin
+// route requires with 403
+// The principal of authenticated requests is in their context, see FromContext
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := a.Authenticate(r)
+		if err != nil {
+			log.Debugf("[auth] %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agfs"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if scopes := a.RequiredScopes(r.Method, r.URL.Path); scopes != nil {
+			granted := false
+			for _, s := range scopes {
+				if p.HasScope(s) {
+					granted = true
+					break
+				}
+			}
+			if !granted {
+				writeError(w, http.StatusForbidden, fmt.Sprintf("requires scope %s", strings.Join(scopes, " or ")))
+				return
+			}
+		}
+		if a.isAdmin != nil && a.isAdmin(r.Method, r.URL.Path) && !p.IsAdmin() {
+			log.Debugf("[auth] %s %s as %s: not an admin", r.Method, r.URL.Path, p.Name)
+			writeError(w, http.StatusForbidden, "requires the admin role")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/client"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/diagnostics"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/embed"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+)
+
+func segment(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signHS256(secret string, claims map[string]interface{}) string {
+	signed := segment(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims map[string]interface{}) string {
+	signed := segment(map[string]string{"alg": "ES256", "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthenticator(t *testing.T) {
+	for _, bad := range []config.AuthConfig{
+		{},
+		{APIKeys: []config.APIKeyConfig{{Name: "a"}}},
+		{APIKeys: []config.APIKeyConfig{{Name: "a", Key: "k"}, {Name: "b", Key: "k"}}},
+		{JWT: config.JWTConfig{PublicKeyFile: "/nonexistent.pem"}},
+		{JWT: config.JWTConfig{Secret: "s", Leeway: "soon"}},
+		{JWT: config.JWTConfig{Secret: "s"}, Rules: []config.AuthRuleConfig{{Path: "/api/v1/mount"}}},
+	} {
+		if _, err := New(bad); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "ci", Key: "ci-key", Scopes: []string{"admin"}}, {Name: "reader", Key: "reader-key"}},
+		JWT:     config.JWTConfig{Secret: "jwt-secret", Issuer: "idp", Audience: "agfs"},
+		Rules:   []config.AuthRuleConfig{{Path: "/api/v1/mount", Methods: []string{"post"}, Scopes: []string{"admin", "mount"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := embed.New(embed.Options{Mounts: []embed.Mount{{Plugin: "memfs", Path: "/memfs"}, {Plugin: "queuefs", Path: "/queue"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	srv := httptest.NewServer(a.Middleware(s.Handler()))
+	defer srv.Close()
+
+	mounts := 0
+	do := func(method, path, header, token string) int {
+		t.Helper()
+		mounts++
+		body := fmt.Sprintf(`{"fstype":"memfs","path":"/m%d"}`, mounts)
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(header, token)
+		}
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Exempt paths need no token, the others a valid one
+	if code := do("GET", "/api/v1/health", "", ""); code != http.StatusOK {
+		t.Errorf("health = %d", code)
+	}
+	if code := do("GET", "/api/v1/mounts", "", ""); code != http.StatusUnauthorized {
+		t.Errorf("no token = %d", code)
+	}
+	if code := do("GET", "/api/v1/mounts", "Authorization", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong key = %d", code)
+	}
+	if code := do("GET", "/api/v1/mounts", APIKeyHeader, "reader-key"); code != http.StatusOK {
+		t.Errorf("X-API-Key = %d", code)
+	}
+
+	// Rules require scopes of matching methods
+	if code := do("POST", "/api/v1/mount", "Authorization", "Bearer reader-key"); code != http.StatusForbidden {
+		t.Errorf("mount without scope = %d", code)
+	}
+	if code := do("POST", "/api/v1/mount", "Authorization", "Bearer ci-key"); code != http.StatusOK {
+		t.Errorf("mount with scope = %d", code)
+	}
+
+	// Admin routes require the admin role, which the admin scope grants
+	a.SetAdminRoutes(handlers.IsAdminRoute)
+	if code := do("GET", "/api/v1/plugins", APIKeyHeader, "reader-key"); code != http.StatusForbidden {
+		t.Errorf("admin route as a user = %d", code)
+	}
+	if code := do("GET", "/api/v1/plugins", APIKeyHeader, "ci-key"); code != http.StatusOK {
+		t.Errorf("admin route as an admin = %d", code)
+	}
+	if code := do("GET", "/api/v1/mounts", APIKeyHeader, "reader-key"); code != http.StatusForbidden {
+		t.Errorf("mount list as a user = %d", code)
+	}
+	if code := do("GET", "/api/v1/stat?path=/memfs", APIKeyHeader, "reader-key"); code != http.StatusOK {
+		t.Errorf("user route as a user = %d", code)
+	}
+	if code := do("GET", "/api/v1/plugins/queue/", APIKeyHeader, "reader-key"); code != http.StatusOK {
+		t.Errorf("plugin route as a user = %d", code)
+	}
+	if code := do("POST", "/api/v1/holds", APIKeyHeader, "reader-key"); code != http.StatusForbidden {
+		t.Errorf("placing a hold as a user = %d", code)
+	}
+	if code := do("GET", "/api/v1/holds", APIKeyHeader, "reader-key"); code == http.StatusForbidden {
+		t.Errorf("hold list as a user = %d", code)
+	}
+
+	// So is /debug/, the diagnostics trusting the admin role behind auth
+	diag, err := diagnostics.New(config.DebugConfig{Token: "debug-token"}, s.FS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	diag.SetAuthorizer(func(r *http.Request) bool {
+		p := FromContext(r.Context())
+		return p != nil && p.IsAdmin()
+	})
+	debugSrv := httptest.NewServer(a.Middleware(diag.Middleware(s.Handler())))
+	defer debugSrv.Close()
+	for _, tc := range []struct {
+		token string
+		code  int
+	}{
+		{"reader-key", http.StatusForbidden},
+		{"debug-token", http.StatusUnauthorized},
+		{"ci-key", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", debugSrv.URL+"/debug/vars", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		resp, err := debugSrv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("/debug/vars with %s = %d, want %d", tc.token, resp.StatusCode, tc.code)
+		}
+	}
+	a.SetAdminRoutes(nil)
+
+	// Bound to their own listener, admin routes are not served on the other one
+	for _, tc := range []struct {
+		admin bool
+		path  string
+		code  int
+	}{
+		{false, "/api/v1/stat?path=/memfs", http.StatusOK},
+		{false, "/api/v1/plugins/queue/", http.StatusOK},
+		{false, "/api/v1/plugins", http.StatusNotFound},
+		{true, "/api/v1/plugins", http.StatusOK},
+		{true, "/api/v1/stat?path=/memfs", http.StatusNotFound},
+		{true, "/api/v1/health", http.StatusOK},
+	} {
+		rec := httptest.NewRecorder()
+		handlers.AdminListenerMiddleware(tc.admin, s.Handler()).ServeHTTP(rec, httptest.NewRequest("GET", tc.path, nil))
+		if rec.Code != tc.code {
+			t.Errorf("%s on the admin listener %v = %d, want %d", tc.path, tc.admin, rec.Code, tc.code)
+		}
+	}
+
+	// JWTs are checked for signature, expiry, issuer and audience
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "idp", "aud": []string{"other", "agfs"}, "exp": now + 60, "scope": "read mount"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+	for name, tc := range map[string]struct {
+		token string
+		code  int
+	}{
+		"valid":    {signHS256("jwt-secret", claims(nil)), http.StatusOK},
+		"secret":   {signHS256("other", claims(nil)), http.StatusUnauthorized},
+		"expired":  {signHS256("jwt-secret", claims(map[string]interface{}{"exp": now - 120})), http.StatusUnauthorized},
+		"issuer":   {signHS256("jwt-secret", claims(map[string]interface{}{"iss": "evil"})), http.StatusUnauthorized},
+		"audience": {signHS256("jwt-secret", claims(map[string]interface{}{"aud": "other"})), http.StatusUnauthorized},
+		"scope":    {signHS256("jwt-secret", claims(map[string]interface{}{"scope": "read"})), http.StatusForbidden},
+	} {
+		if code := do("POST", "/api/v1/mount", "Authorization", "Bearer "+tc.token); code != tc.code {
+			t.Errorf("%s jwt = %d, want %d", name, code, tc.code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+signHS256("jwt-secret", claims(nil)))
+	if p, err := a.Authenticate(req); err != nil || p.Name != "alice" || p.Method != "jwt" || !p.HasScope("mount") || p.IsAdmin() {
+		t.Errorf("principal = %+v, %v", p, err)
+	}
+
+	// Tokens signed with a public key's private key
+	es, err := New(config.AuthConfig{JWT: config.JWTConfig{PublicKeyFile: keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+signES256(t, key, map[string]interface{}{"sub": "bob", "scopes": []string{"*"}}))
+	if p, err := es.Authenticate(req); err != nil || p.Name != "bob" || !p.HasScope("admin") || p.Role != RoleAdmin {
+		t.Errorf("es256 principal = %+v, %v", p, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+signHS256("", map[string]interface{}{"sub": "bob"}))
+	if _, err := es.Authenticate(req); err != ErrInvalidToken {
+		t.Errorf("hs256 token for a public key: %v", err)
+	}
+
+	// The Go client sends its token with every request
+	c := client.NewClient(srv.URL)
+	if _, err := c.Write("/memfs/a.txt", []byte("hello")); err == nil {
+		t.Error("write without token: no error")
+	}
+	c.SetAuthToken("reader-key")
+	if _, err := c.Write("/memfs/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read("/memfs/a.txt", 0, -1); err != nil || string(data) != "hello" {
+		t.Errorf("read = %q, %v", data, err)
+	}
+}
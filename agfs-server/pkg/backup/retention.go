@@ -0,0 +1,63 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+)
+
+// selectExpired returns the backups that fall outside the retention policy
+// The newest backup is always kept; a policy with all counts at zero keeps everything
+func selectExpired(backups []time.Time, policy config.RetentionConfig) []time.Time {
+	if policy.Daily <= 0 && policy.Weekly <= 0 && policy.Monthly <= 0 {
+		return nil
+	}
+
+	sorted := append([]time.Time(nil), backups...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].After(sorted[j]) })
+
+	keep := make(map[time.Time]bool)
+	if len(sorted) > 0 {
+		keep[sorted[0]] = true
+	}
+	keepPerPeriod(sorted, policy.Daily, keep, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPerPeriod(sorted, policy.Weekly, keep, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPerPeriod(sorted, policy.Monthly, keep, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	var expired []time.Time
+	for _, t := range sorted {
+		if !keep[t] {
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+// keepPerPeriod marks the newest backup of each of the last count periods
+// sorted must be ordered newest first
+func keepPerPeriod(sorted []time.Time, count int, keep map[time.Time]bool, period func(time.Time) string) {
+	if count <= 0 {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, t := range sorted {
+		key := period(t)
+		if seen[key] {
+			continue
+		}
+		if len(seen) == count {
+			return
+		}
+		seen[key] = true
+		keep[t] = true
+	}
+}
@@ -0,0 +1,37 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+)
+
+func TestSelectExpired(t *testing.T) {
+	// One backup every 12 hours for 60 days, newest first
+	newest := time.Date(2025, 3, 31, 14, 0, 0, 0, time.UTC)
+	var backups []time.Time
+	for i := 0; i < 120; i++ {
+		backups = append(backups, newest.Add(-time.Duration(i)*12*time.Hour))
+	}
+
+	expired := selectExpired(backups, config.RetentionConfig{Daily: 7, Weekly: 4})
+	kept := len(backups) - len(expired)
+
+	// 7 daily backups, plus at most 4 weekly ones that are not already kept
+	if kept < 7 || kept > 11 {
+		t.Fatalf("expected between 7 and 11 backups kept, got %d", kept)
+	}
+	for _, e := range expired {
+		if e.Equal(newest) {
+			t.Fatalf("newest backup must never expire")
+		}
+		if newest.Sub(e) < 6*24*time.Hour && e.Hour() == 14 {
+			t.Fatalf("daily backup %s should have been kept", e)
+		}
+	}
+
+	if expired := selectExpired(backups, config.RetentionConfig{}); len(expired) != 0 {
+		t.Fatalf("empty policy should keep everything, got %d expired", len(expired))
+	}
+}
@@ -0,0 +1,327 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BackupLayout names each backup directory under the job target (UTC)
+	BackupLayout = "20060102-150405"
+
+	// DefaultInterval is used when a job does not configure an interval
+	DefaultInterval = 24 * time.Hour
+)
+
+// Job states reported in JobStatus
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateOK      = "ok"
+	StateFailed  = "failed"
+)
+
+// ErrJobRunning is returned when a backup is triggered while the same job is still running
+var ErrJobRunning = errors.New("backup already running")
+
+// JobStatus reports the configuration and last result of a backup job
+type JobStatus struct {
+	Name       string                 `json:"name"`
+	Source     string                 `json:"source"`
+	Target     string                 `json:"target"`
+	Interval   string                 `json:"interval"`
+	Retention  config.RetentionConfig `json:"retention"`
+	State      string                 `json:"state"`
+	LastRun    *time.Time             `json:"lastRun,omitempty"`
+	LastBackup string                 `json:"lastBackup,omitempty"`
+	Duration   string                 `json:"duration,omitempty"`
+	Consistent bool                   `json:"consistent"`
+	Files      int                    `json:"files"`
+	Bytes      int64                  `json:"bytes"`
+	Pruned     int                    `json:"pruned"`
+	Error      string                 `json:"error,omitempty"`
+	NextRun    *time.Time             `json:"nextRun,omitempty"`
+	Backups    int                    `json:"backups"`
+}
+
+// job is a configured backup with its runtime state
+type job struct {
+	cfg      config.BackupConfig
+	interval time.Duration
+	at       *time.Time // time of day for the first run, date part unused
+
+	runMu  sync.Mutex // held while the backup runs
+	mu     sync.Mutex // protects status
+	status JobStatus
+}
+
+// Scheduler runs backup jobs periodically and on demand
+type Scheduler struct {
+	fs   filesystem.FileSystem
+	jobs []*job
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewScheduler validates the backup configuration and creates a scheduler
+// Backups are read from and written to fs, so sources and targets may live on any mount
+func NewScheduler(fs filesystem.FileSystem, cfgs []config.BackupConfig) (*Scheduler, error) {
+	s := &Scheduler{
+		fs:   fs,
+		done: make(chan struct{}),
+	}
+
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if !cfg.Enabled {
+			continue
+		}
+		j, err := newJob(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate backup job name: %s", cfg.Name)
+		}
+		names[cfg.Name] = true
+		s.jobs = append(s.jobs, j)
+	}
+
+	return s, nil
+}
+
+func newJob(cfg config.BackupConfig) (*job, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("backup job name is required")
+	}
+	if cfg.Source == "" || cfg.Target == "" {
+		return nil, fmt.Errorf("backup job %s: source and target are required", cfg.Name)
+	}
+	cfg.Source = filesystem.NormalizePath(cfg.Source)
+	cfg.Target = filesystem.NormalizePath(cfg.Target)
+	if cfg.Source == "/" || cfg.Target == cfg.Source || strings.HasPrefix(cfg.Target, cfg.Source+"/") {
+		return nil, fmt.Errorf("backup job %s: target must not be inside source", cfg.Name)
+	}
+
+	interval := DefaultInterval
+	if cfg.Interval != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("backup job %s: invalid interval: %w", cfg.Name, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("backup job %s: interval must be positive", cfg.Name)
+		}
+		interval = d
+	}
+
+	j := &job{cfg: cfg, interval: interval}
+	if cfg.At != "" {
+		at, err := time.Parse("15:04", cfg.At)
+		if err != nil {
+			return nil, fmt.Errorf("backup job %s: invalid at %q, expected HH:MM", cfg.Name, cfg.At)
+		}
+		j.at = &at
+	}
+
+	j.status = JobStatus{
+		Name:      cfg.Name,
+		Source:    cfg.Source,
+		Target:    cfg.Target,
+		Interval:  interval.String(),
+		Retention: cfg.Retention,
+		State:     StateIdle,
+	}
+	return j, nil
+}
+
+// firstRun returns when the job should run first after now
+func (j *job) firstRun(now time.Time) time.Time {
+	if j.at == nil {
+		return now.Add(j.interval)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), j.at.Hour(), j.at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Start launches the scheduling loop of every job
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+	if len(s.jobs) > 0 {
+		log.Infof("[backup] scheduler started with %d job(s)", len(s.jobs))
+	}
+}
+
+// Stop stops scheduling and waits for the loops to exit
+// A backup that is already running is allowed to finish
+func (s *Scheduler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	next := j.firstRun(time.Now())
+	for {
+		j.setNextRun(next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := s.runJob(j); err != nil && !errors.Is(err, ErrJobRunning) {
+			log.Errorf("[backup] job %s failed: %v", j.cfg.Name, err)
+		}
+		next = next.Add(j.interval)
+		if now := time.Now(); next.Before(now) {
+			// Skip runs missed while the backup was running
+			next = now.Add(j.interval)
+		}
+	}
+}
+
+func (j *job) setNextRun(t time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.NextRun = &t
+}
+
+// Run triggers a backup immediately and waits for it to finish
+// An empty name runs every job
+func (s *Scheduler) Run(name string) ([]JobStatus, error) {
+	var results []JobStatus
+	found := false
+	for _, j := range s.jobs {
+		if name != "" && j.cfg.Name != name {
+			continue
+		}
+		found = true
+		status, err := s.runJob(j)
+		if err != nil && name != "" {
+			return []JobStatus{status}, err
+		}
+		results = append(results, status)
+	}
+	if !found && name != "" {
+		return nil, filesystem.NewNotFoundError("backup", name)
+	}
+	return results, nil
+}
+
+// Status returns the status of every job
+func (s *Scheduler) Status() []JobStatus {
+	statuses := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		statuses = append(statuses, j.snapshotStatus())
+	}
+	return statuses
+}
+
+func (j *job) snapshotStatus() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// runJob takes a snapshot of the source, copies it to a new timestamped
+// directory under the target and then applies the retention policy
+func (s *Scheduler) runJob(j *job) (JobStatus, error) {
+	if !j.runMu.TryLock() {
+		return j.snapshotStatus(), ErrJobRunning
+	}
+	defer j.runMu.Unlock()
+
+	start := time.Now()
+	j.mu.Lock()
+	j.status.State = StateRunning
+	j.status.LastRun = &start
+	j.mu.Unlock()
+
+	dest := path.Join(j.cfg.Target, start.UTC().Format(BackupLayout))
+	log.Infof("[backup] job %s: backing up %s to %s", j.cfg.Name, j.cfg.Source, dest)
+
+	var stats filesystem.CopyStats
+	var pruned, remaining int
+	snapFS, root, consistent, err := filesystem.OpenSnapshot(s.fs, j.cfg.Source)
+	if err == nil {
+		err = filesystem.MkdirAll(s.fs, j.cfg.Target, 0755)
+	}
+	if err == nil {
+		stats, err = filesystem.CopyTree(snapFS, root, s.fs, dest)
+	}
+	if err == nil {
+		pruned, remaining, err = s.prune(j)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Duration = time.Since(start).Round(time.Millisecond).String()
+	j.status.Consistent = consistent
+	j.status.Files = stats.Files
+	j.status.Bytes = stats.Bytes
+	j.status.Pruned = pruned
+	if err != nil {
+		j.status.State = StateFailed
+		j.status.Error = err.Error()
+		return j.status, err
+	}
+	j.status.State = StateOK
+	j.status.Error = ""
+	j.status.LastBackup = dest
+	j.status.Backups = remaining
+	log.Infof("[backup] job %s: %d files, %d bytes in %s, pruned %d", j.cfg.Name, stats.Files, stats.Bytes, j.status.Duration, pruned)
+	return j.status, nil
+}
+
+// prune removes backups of j that are outside its retention policy
+// It returns the number of removed and remaining backups
+func (s *Scheduler) prune(j *job) (int, int, error) {
+	entries, err := s.fs.ReadDir(j.cfg.Target)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var backups []time.Time
+	for _, entry := range entries {
+		if !entry.IsDir {
+			continue
+		}
+		t, err := time.Parse(BackupLayout, entry.Name)
+		if err != nil {
+			// Not created by the scheduler, leave it alone
+			continue
+		}
+		backups = append(backups, t)
+	}
+
+	expired := selectExpired(backups, j.cfg.Retention)
+	sort.Slice(expired, func(a, b int) bool { return expired[a].Before(expired[b]) })
+	for _, t := range expired {
+		p := path.Join(j.cfg.Target, t.Format(BackupLayout))
+		if err := s.fs.RemoveAll(p); err != nil {
+			return 0, 0, fmt.Errorf("failed to prune %s: %w", p, err)
+		}
+		log.Infof("[backup] job %s: pruned %s", j.cfg.Name, p)
+	}
+	return len(expired), len(backups) - len(expired), nil
+}
@@ -0,0 +1,455 @@
+// Package bench measures the throughput of a filesystem so performance can be compared across releases
+package bench
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Workloads
+const (
+	SeqWrite  = "seqwrite"  // Whole files of FileSize written by each worker
+	SeqRead   = "seqread"   // Files read front to back in BlockSize reads
+	RandRead  = "randread"  // BlockSize reads at random offsets
+	RandWrite = "randwrite" // BlockSize files overwritten at random in a pool of Files
+	Meta      = "meta"      // Stat of a random name followed by its create or remove
+	Stream    = "stream"    // Fanout of BlockSize chunks from one writer to Readers stream readers
+)
+
+// AllWorkloads lists the workloads in the order they run
+var AllWorkloads = []string{SeqWrite, SeqRead, RandRead, RandWrite, Meta, Stream}
+
+// maxSamples bounds the latency samples kept per worker
+const maxSamples = 10000
+
+// Options configures a benchmark run
+type Options struct {
+	Dir         string        `json:"dir"`                  // Scratch directory, created and removed by the run
+	StreamPath  string        `json:"streamPath,omitempty"` // Stream file for the stream workload, e.g. on a streamfs mount
+	Workloads   []string      `json:"workloads"`
+	Duration    time.Duration `json:"duration"` // Per workload
+	BlockSize   int64         `json:"blockSize"`
+	FileSize    int64         `json:"fileSize"`
+	Files       int           `json:"files"`
+	Concurrency int           `json:"concurrency"`
+	Readers     int           `json:"readers"` // Stream readers
+}
+
+// DefaultOptions returns the options used for unset fields
+func DefaultOptions() Options {
+	return Options{
+		Workloads:   []string{SeqWrite, SeqRead, RandRead, RandWrite, Meta},
+		Duration:    5 * time.Second,
+		BlockSize:   64 * 1024,
+		FileSize:    16 * 1024 * 1024,
+		Files:       100,
+		Concurrency: 4,
+		Readers:     4,
+	}
+}
+
+// ParseWorkloads parses a comma-separated workload list
+// Empty returns nil, which selects the default workloads; "all" selects every workload
+func ParseWorkloads(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "all" {
+		return AllWorkloads, nil
+	}
+	var workloads []string
+	for _, w := range strings.Split(s, ",") {
+		w = strings.TrimSpace(w)
+		if w == "" {
+			continue
+		}
+		known := false
+		for _, k := range AllWorkloads {
+			known = known || k == w
+		}
+		if !known {
+			return nil, filesystem.NewInvalidArgumentError("workload", w,
+				"expected "+strings.Join(AllWorkloads, ", "))
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// Result is the outcome of one workload
+type Result struct {
+	Workload  string  `json:"workload"`
+	Ops       int64   `json:"ops"`
+	Bytes     int64   `json:"bytes"`
+	Errors    int64   `json:"errors"`
+	Seconds   float64 `json:"seconds"`
+	OpsPerSec float64 `json:"opsPerSec"`
+	MBPerSec  float64 `json:"mbPerSec"`
+	P50Ms     float64 `json:"p50Ms"`
+	P99Ms     float64 `json:"p99Ms"`
+	Error     string  `json:"error,omitempty"`   // First error
+	Skipped   string  `json:"skipped,omitempty"` // Why the workload did not run
+}
+
+// Report is the outcome of a benchmark run
+type Report struct {
+	Target    string    `json:"target"`
+	StartedAt time.Time `json:"startedAt"`
+	Options   Options   `json:"options"`
+	Results   []Result  `json:"results"`
+}
+
+// Run runs the workloads of opts against fs and removes the files it created
+func Run(ctx context.Context, fs filesystem.FileSystem, target string, opts Options) (*Report, error) {
+	opts = withDefaults(opts)
+	if opts.Dir == "" {
+		return nil, filesystem.NewInvalidArgumentError("dir", "", "a scratch directory is required")
+	}
+	opts.Dir = filesystem.NormalizePath(opts.Dir)
+	if _, err := fs.Stat(opts.Dir); err == nil {
+		return nil, filesystem.NewAlreadyExistsError("bench", opts.Dir)
+	}
+	if err := fs.Mkdir(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer fs.RemoveAll(opts.Dir)
+
+	b := &runner{fs: fs, opts: opts}
+	report := &Report{Target: target, StartedAt: time.Now(), Options: opts}
+	for _, w := range opts.Workloads {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		report.Results = append(report.Results, b.run(ctx, w))
+	}
+	return report, nil
+}
+
+func withDefaults(opts Options) Options {
+	d := DefaultOptions()
+	if len(opts.Workloads) == 0 {
+		opts.Workloads = d.Workloads
+	}
+	if opts.Duration <= 0 {
+		opts.Duration = d.Duration
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = d.BlockSize
+	}
+	if opts.FileSize <= 0 {
+		opts.FileSize = d.FileSize
+	}
+	if opts.FileSize < opts.BlockSize {
+		opts.FileSize = opts.BlockSize
+	}
+	if opts.Files <= 0 {
+		opts.Files = d.Files
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = d.Concurrency
+	}
+	if opts.Readers <= 0 {
+		opts.Readers = d.Readers
+	}
+	return opts
+}
+
+type runner struct {
+	fs   filesystem.FileSystem
+	opts Options
+}
+
+func (b *runner) seqFile(worker int) string {
+	return path.Join(b.opts.Dir, fmt.Sprintf("seq-%d", worker))
+}
+
+func (b *runner) randFile(i int) string {
+	return path.Join(b.opts.Dir, fmt.Sprintf("rand-%d", i))
+}
+
+func (b *runner) run(ctx context.Context, workload string) Result {
+	switch workload {
+	case SeqWrite:
+		data := make([]byte, b.opts.FileSize)
+		rand.Read(data)
+		return b.parallel(ctx, workload, func(worker int, _ *rand.Rand) (int64, error) {
+			_, err := b.fs.Write(b.seqFile(worker), data)
+			return int64(len(data)), err
+		})
+	case SeqRead, RandRead:
+		if err := b.prepareSeqFiles(); err != nil {
+			return Result{Workload: workload, Error: err.Error()}
+		}
+		blocks := b.opts.FileSize / b.opts.BlockSize
+		offsets := make([]int64, b.opts.Concurrency)
+		return b.parallel(ctx, workload, func(worker int, rng *rand.Rand) (int64, error) {
+			var block int64
+			if workload == RandRead {
+				block = rng.Int63n(blocks)
+			} else {
+				block = offsets[worker]
+				offsets[worker] = (block + 1) % blocks
+			}
+			data, err := b.fs.Read(b.seqFile(worker), block*b.opts.BlockSize, b.opts.BlockSize)
+			if err == io.EOF {
+				err = nil
+			}
+			return int64(len(data)), err
+		})
+	case RandWrite:
+		data := make([]byte, b.opts.BlockSize)
+		rand.Read(data)
+		return b.parallel(ctx, workload, func(_ int, rng *rand.Rand) (int64, error) {
+			_, err := b.fs.Write(b.randFile(rng.Intn(b.opts.Files)), data)
+			return int64(len(data)), err
+		})
+	case Meta:
+		return b.parallel(ctx, workload, func(worker int, rng *rand.Rand) (int64, error) {
+			// Each worker cycles through its own names so that creates do not collide
+			p := path.Join(b.opts.Dir, fmt.Sprintf("meta-%d-%d", worker, rng.Intn(b.opts.Files)))
+			if _, err := b.fs.Stat(p); err == nil {
+				return 0, b.fs.Remove(p)
+			}
+			return 0, b.fs.Create(p)
+		})
+	case Stream:
+		return b.stream(ctx)
+	}
+	return Result{Workload: workload, Skipped: "unknown workload"}
+}
+
+// prepareSeqFiles writes the files of the read workloads unless seqwrite already did
+func (b *runner) prepareSeqFiles() error {
+	var data []byte
+	for w := 0; w < b.opts.Concurrency; w++ {
+		if info, err := b.fs.Stat(b.seqFile(w)); err == nil && info.Size >= b.opts.FileSize {
+			continue
+		}
+		if data == nil {
+			data = make([]byte, b.opts.FileSize)
+			rand.Read(data)
+		}
+		if _, err := b.fs.Write(b.seqFile(w), data); err != nil {
+			return fmt.Errorf("failed to prepare %s: %w", b.seqFile(w), err)
+		}
+	}
+	return nil
+}
+
+// recorder accumulates the measurements of one workload
+type recorder struct {
+	ops    atomic.Int64
+	bytes  atomic.Int64
+	errors atomic.Int64
+
+	mu       sync.Mutex // protects the fields below
+	firstErr error
+	samples  []time.Duration
+}
+
+func (rec *recorder) fail(err error) {
+	rec.errors.Add(1)
+	rec.mu.Lock()
+	if rec.firstErr == nil {
+		rec.firstErr = err
+	}
+	rec.mu.Unlock()
+}
+
+func (rec *recorder) result(workload string, elapsed time.Duration) Result {
+	res := Result{
+		Workload: workload,
+		Ops:      rec.ops.Load(),
+		Bytes:    rec.bytes.Load(),
+		Errors:   rec.errors.Load(),
+		Seconds:  elapsed.Seconds(),
+	}
+	if res.Seconds > 0 {
+		res.OpsPerSec = float64(res.Ops) / res.Seconds
+		res.MBPerSec = float64(res.Bytes) / res.Seconds / (1024 * 1024)
+	}
+	if rec.firstErr != nil {
+		res.Error = rec.firstErr.Error()
+	}
+	if len(rec.samples) > 0 {
+		sort.Slice(rec.samples, func(i, j int) bool { return rec.samples[i] < rec.samples[j] })
+		percentile := func(p float64) float64 {
+			d := rec.samples[int(float64(len(rec.samples)-1)*p)]
+			return d.Seconds() * 1000
+		}
+		res.P50Ms = percentile(0.50)
+		res.P99Ms = percentile(0.99)
+	}
+	return res
+}
+
+// parallel calls op from Concurrency workers until the duration elapses
+// op returns the bytes it transferred
+func (b *runner) parallel(ctx context.Context, workload string, op func(worker int, rng *rand.Rand) (int64, error)) Result {
+	ctx, cancel := context.WithTimeout(ctx, b.opts.Duration)
+	defer cancel()
+
+	rec := &recorder{}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < b.opts.Concurrency; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+			var samples []time.Duration
+			for n := 0; ctx.Err() == nil; n++ {
+				opStart := time.Now()
+				bytes, err := op(worker, rng)
+				latency := time.Since(opStart)
+				if err != nil {
+					rec.fail(err)
+					continue
+				}
+				rec.ops.Add(1)
+				rec.bytes.Add(bytes)
+				// Reservoir sampling keeps a uniform sample of the latencies
+				if len(samples) < maxSamples {
+					samples = append(samples, latency)
+				} else if i := rng.Intn(n + 1); i < maxSamples {
+					samples[i] = latency
+				}
+			}
+			rec.mu.Lock()
+			rec.samples = append(rec.samples, samples...)
+			rec.mu.Unlock()
+		}(w)
+	}
+	wg.Wait()
+	return rec.result(workload, time.Since(start))
+}
+
+// stream writes chunks to StreamPath for the duration while Readers readers consume them
+// Bytes and ops count what the readers received; chunks dropped for slow readers are not counted
+func (b *runner) stream(ctx context.Context) Result {
+	if b.opts.StreamPath == "" {
+		return Result{Workload: Stream, Skipped: "no stream path configured"}
+	}
+	streamer, ok := b.fs.(filesystem.Streamer)
+	if !ok {
+		return Result{Workload: Stream, Skipped: "filesystem does not support streaming"}
+	}
+
+	chunk := make([]byte, b.opts.BlockSize)
+	rand.Read(chunk)
+	// Writing creates the stream so that readers can open it
+	if _, err := b.fs.Write(b.opts.StreamPath, chunk); err != nil {
+		return Result{Workload: Stream, Error: err.Error()}
+	}
+	defer b.fs.Remove(b.opts.StreamPath)
+
+	readers := make([]filesystem.StreamReader, 0, b.opts.Readers)
+	for i := 0; i < b.opts.Readers; i++ {
+		r, err := streamer.OpenStream(b.opts.StreamPath)
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return Result{Workload: Stream, Error: err.Error()}
+		}
+		readers = append(readers, r)
+	}
+
+	rec := &recorder{}
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, r := range readers {
+		wg.Add(1)
+		go func(r filesystem.StreamReader) {
+			defer wg.Done()
+			for !done.Load() {
+				data, eof, err := r.ReadChunk(100 * time.Millisecond)
+				if eof || (err != nil && (done.Load() || err.Error() != "read timeout")) {
+					return
+				}
+				if len(data) > 0 {
+					rec.ops.Add(1)
+					rec.bytes.Add(int64(len(data)))
+				}
+			}
+		}(r)
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, b.opts.Duration)
+	defer cancel()
+	var samples []time.Duration
+	for writeCtx.Err() == nil {
+		opStart := time.Now()
+		if _, err := b.fs.Write(b.opts.StreamPath, chunk); err != nil {
+			rec.fail(err)
+			continue
+		}
+		if len(samples) < maxSamples {
+			samples = append(samples, time.Since(opStart))
+		}
+	}
+	elapsed := time.Since(start)
+
+	// Closing the readers unblocks those waiting for a chunk
+	done.Store(true)
+	for _, r := range readers {
+		r.Close()
+	}
+	wg.Wait()
+	rec.samples = samples
+	return rec.result(Stream, elapsed)
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// csvHeader lists the CSV columns, one row per result
+var csvHeader = []string{"target", "started_at", "workload", "ops", "bytes", "errors", "seconds",
+	"ops_per_sec", "mb_per_sec", "p50_ms", "p99_ms", "error", "skipped"}
+
+// WriteCSV writes the results as CSV with a header row
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	float := func(f float64) string { return strconv.FormatFloat(f, 'f', 3, 64) }
+	for _, res := range r.Results {
+		row := []string{
+			r.Target,
+			r.StartedAt.Format(time.RFC3339),
+			res.Workload,
+			strconv.FormatInt(res.Ops, 10),
+			strconv.FormatInt(res.Bytes, 10),
+			strconv.FormatInt(res.Errors, 10),
+			float(res.Seconds),
+			float(res.OpsPerSec),
+			float(res.MBPerSec),
+			float(res.P50Ms),
+			float(res.P99Ms),
+			res.Error,
+			res.Skipped,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
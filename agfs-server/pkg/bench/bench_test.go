@@ -0,0 +1,52 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestRun(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	opts := Options{
+		Dir:       "/bench",
+		Workloads: AllWorkloads,
+		Duration:  50 * time.Millisecond,
+		BlockSize: 4096,
+		FileSize:  64 * 1024,
+		Files:     10,
+	}
+	report, err := Run(context.Background(), fs, "memfs", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) != len(AllWorkloads) {
+		t.Fatalf("got %d results", len(report.Results))
+	}
+	for _, res := range report.Results {
+		if res.Workload == Stream {
+			if res.Skipped == "" {
+				t.Errorf("stream workload ran without a stream path")
+			}
+			continue
+		}
+		if res.Ops == 0 || res.Errors != 0 {
+			t.Errorf("%s: %d ops, %d errors (%s)", res.Workload, res.Ops, res.Errors, res.Error)
+		}
+	}
+	if _, err := fs.Stat("/bench"); err == nil {
+		t.Error("scratch directory was not removed")
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != len(AllWorkloads)+1 {
+		t.Fatalf("CSV has %d lines", lines)
+	}
+}
@@ -0,0 +1,95 @@
+// Package bufpool recycles byte buffers of streaming paths to reduce allocations and GC pressure
+package bufpool
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// Buffers are pooled in power-of-two size classes from minSize to maxSize
+// Larger buffers are allocated directly and left to the garbage collector
+const (
+	minShift = 12 // 4KB
+	maxShift = 22 // 4MB
+)
+
+var pools [maxShift - minShift + 1]sync.Pool
+
+// class returns the size class index of a buffer of n bytes, -1 if n is too large to pool
+func class(n int) int {
+	if n <= 1<<minShift {
+		return 0
+	}
+	shift := bits.Len(uint(n - 1))
+	if shift > maxShift {
+		return -1
+	}
+	return shift - minShift
+}
+
+// Get returns a buffer of length n from the pool
+// Its content is undefined; return it with Put when it is no longer used
+func Get(n int) []byte {
+	c := class(n)
+	if c < 0 {
+		return make([]byte, n)
+	}
+	if b, ok := pools[c].Get().(*[]byte); ok {
+		return (*b)[:n]
+	}
+	return make([]byte, n, 1<<(c+minShift))
+}
+
+// Put returns a buffer obtained from Get to the pool
+// The buffer must not be used after Put
+func Put(b []byte) {
+	c := class(cap(b))
+	// Only buffers with exactly the capacity of their class came from Get
+	if c < 0 || cap(b) != 1<<(c+minShift) {
+		return
+	}
+	b = b[:0]
+	pools[c].Put(&b)
+}
+
+// Chunk is a pooled buffer shared by several consumers, e.g. the readers of a stream
+// It is returned to the pool when the last reference is released
+type Chunk struct {
+	data []byte
+	refs atomic.Int32
+}
+
+// NewChunk copies data into a pooled buffer and returns a chunk holding one reference
+func NewChunk(data []byte) *Chunk {
+	c := &Chunk{data: Get(len(data))}
+	copy(c.data, data)
+	c.refs.Store(1)
+	return c
+}
+
+// Bytes returns the content of the chunk, valid while the caller holds a reference
+func (c *Chunk) Bytes() []byte {
+	return c.data
+}
+
+// Len returns the size of the chunk
+func (c *Chunk) Len() int {
+	return len(c.data)
+}
+
+// Retain adds a reference for another consumer
+func (c *Chunk) Retain() {
+	c.refs.Add(1)
+}
+
+// Release drops a reference, returning the buffer to the pool with the last one
+func (c *Chunk) Release() {
+	switch refs := c.refs.Add(-1); {
+	case refs == 0:
+		Put(c.data)
+		c.data = nil
+	case refs < 0:
+		panic("bufpool: chunk released more times than retained")
+	}
+}
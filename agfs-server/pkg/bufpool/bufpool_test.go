@@ -0,0 +1,33 @@
+package bufpool
+
+import "testing"
+
+func TestGetSizes(t *testing.T) {
+	for _, n := range []int{0, 1, 4096, 4097, 64 * 1024, 4 << 20, 4<<20 + 1} {
+		b := Get(n)
+		if len(b) != n {
+			t.Fatalf("Get(%d) returned %d bytes", n, len(b))
+		}
+		Put(b)
+	}
+}
+
+func TestChunkRefs(t *testing.T) {
+	c := NewChunk([]byte("hello"))
+	c.Retain()
+	c.Release()
+	if string(c.Bytes()) != "hello" {
+		t.Fatalf("chunk content %q after partial release", c.Bytes())
+	}
+	c.Release()
+	if c.Bytes() != nil {
+		t.Fatal("chunk buffer not recycled after last release")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("over-release did not panic")
+		}
+	}()
+	c.Release()
+}
@@ -1,8 +1,14 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,12 +17,23 @@ import (
 	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/idempotency"
 )
 
+// DefaultRetries is how many times a mutating request is retried after a network error or
+// a 502, 503 or 504 response
+const DefaultRetries = 2
+
+// retryBackoff is the wait before the first retry of a mutating request, doubled after each
+const retryBackoff = 200 * time.Millisecond
+
 // Client is a Go client for AGFS HTTP API
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+	ctx        context.Context // Context of the requests, nil for none
+	retries    int
+	authToken  string // Bearer token of the requests, empty for none
 }
 
 // NewClient creates a new AGFS client
@@ -29,6 +46,7 @@ func NewClient(baseURL string) *Client {
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		retries: DefaultRetries,
 	}
 }
 
@@ -37,9 +55,46 @@ func NewClientWithHTTPClient(baseURL string, httpClient *http.Client) *Client {
 	return &Client{
 		baseURL:    normalizeBaseURL(baseURL),
 		httpClient: httpClient,
+		retries:    DefaultRetries,
+	}
+}
+
+// SetRetries sets how many times mutating requests are retried, 0 disables retries
+// Retries carry the Idempotency-Key of the first attempt, so a server deduplicating keys
+// applies the request once even if an attempt timed out after it was applied
+func (c *Client) SetRetries(n int) {
+	c.retries = n
+}
+
+// SetAuthToken sets the API key or JWT sent as a bearer token with every request, for servers
+// with auth enabled; an empty token sends none
+func (c *Client) SetAuthToken(token string) {
+	c.authToken = token
+}
+
+// authorize adds the auth token of the client to req
+func (c *Client) authorize(req *http.Request) {
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
 	}
 }
 
+// WithContext returns a copy of the client whose requests are made under ctx, so they are
+// aborted when it is canceled or its deadline passes
+func (c *Client) WithContext(ctx context.Context) *Client {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// requestContext returns the context of the client's requests
+func (c *Client) requestContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // normalizeBaseURL ensures the base URL ends with /api/v1
 func normalizeBaseURL(baseURL string) string {
 	// Remove trailing slash
@@ -76,6 +131,7 @@ type FileInfoResponse struct {
 // ListResponse represents directory listing response from the API
 type ListResponse struct {
 	Files []FileInfoResponse `json:"files"`
+	Next  int                `json:"next,omitempty"`
 }
 
 // RenameRequest represents a rename request
@@ -83,18 +139,39 @@ type RenameRequest struct {
 	NewPath string `json:"newPath"`
 }
 
+// CopyRequest represents a copy request
+type CopyRequest struct {
+	Dest      string `json:"dest"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// CopyResponse is the result of a copy
+type CopyResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	Dest    string `json:"dest"`
+	Files   int    `json:"files"`
+	Dirs    int    `json:"dirs"`
+	Bytes   int64  `json:"bytes"`
+}
+
 // ChmodRequest represents a chmod request
 type ChmodRequest struct {
 	Mode uint32 `json:"mode"`
 }
 
 func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Reader) (*http.Response, error) {
+	return c.send(method, endpoint, query, body, "")
+}
+
+// send makes a request, with an Idempotency-Key header unless key is empty
+func (c *Client) send(method, endpoint string, query url.Values, body io.Reader, key string) (*http.Response, error) {
 	u := c.baseURL + endpoint
 	if len(query) > 0 {
 		u += "?" + query.Encode()
 	}
 
-	req, err := http.NewRequest(method, u, body)
+	req, err := http.NewRequestWithContext(c.requestContext(), method, u, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -102,6 +179,10 @@ func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Re
 	if body != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	if key != "" {
+		req.Header.Set(idempotency.Header, key)
+	}
+	c.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -111,6 +192,57 @@ func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Re
 	return resp, nil
 }
 
+// idempotencyKey returns the key of a mutating request: derived from the key of the request
+// the client works on behalf of, e.g. in proxyfs, so retries of that request reuse it, and
+// random otherwise
+func (c *Client) idempotencyKey(method, endpoint string, query url.Values, body []byte) string {
+	if key := idempotency.FromContext(c.requestContext()); key != "" {
+		sum := sha256.Sum256(body)
+		return idempotency.Derive(key, method, endpoint, query.Encode(), hex.EncodeToString(sum[:]))
+	}
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// doIdempotent makes a mutating request with an idempotency key, retrying it with the same
+// key after network errors and 502, 503 and 504 responses, which leave unknown whether the
+// request was applied
+func (c *Client) doIdempotent(method, endpoint string, query url.Values, body []byte) (*http.Response, error) {
+	key := c.idempotencyKey(method, endpoint, query, body)
+	for attempt := 0; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		resp, err := c.send(method, endpoint, query, reader, key)
+		retry := false
+		if err != nil {
+			retry = isRetryableError(err)
+		} else {
+			retry = retryableStatus(resp.StatusCode)
+		}
+		if !retry || attempt >= c.retries || c.requestContext().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(retryBackoff << attempt):
+		case <-c.requestContext().Done():
+			return nil, fmt.Errorf("failed to execute request: %w", c.requestContext().Err())
+		}
+	}
+}
+
+// retryableStatus reports whether a response of status leaves unknown whether the request
+// was applied, e.g. a proxy timing out on the server behind it
+func retryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
 func (c *Client) handleErrorResponse(resp *http.Response) error {
 	defer resp.Body.Close()
 
@@ -131,7 +263,7 @@ func (c *Client) Create(path string) error {
 	query := url.Values{}
 	query.Set("path", path)
 
-	resp, err := c.doRequest(http.MethodPost, "/files", query, nil)
+	resp, err := c.doIdempotent(http.MethodPost, "/files", query, nil)
 	if err != nil {
 		return err
 	}
@@ -145,7 +277,7 @@ func (c *Client) Mkdir(path string, perm uint32) error {
 	query.Set("path", path)
 	query.Set("mode", fmt.Sprintf("%o", perm))
 
-	resp, err := c.doRequest(http.MethodPost, "/directories", query, nil)
+	resp, err := c.doIdempotent(http.MethodPost, "/directories", query, nil)
 	if err != nil {
 		return err
 	}
@@ -159,7 +291,7 @@ func (c *Client) Remove(path string) error {
 	query.Set("path", path)
 	query.Set("recursive", "false")
 
-	resp, err := c.doRequest(http.MethodDelete, "/files", query, nil)
+	resp, err := c.doIdempotent(http.MethodDelete, "/files", query, nil)
 	if err != nil {
 		return err
 	}
@@ -173,7 +305,7 @@ func (c *Client) RemoveAll(path string) error {
 	query.Set("path", path)
 	query.Set("recursive", "true")
 
-	resp, err := c.doRequest(http.MethodDelete, "/files", query, nil)
+	resp, err := c.doIdempotent(http.MethodDelete, "/files", query, nil)
 	if err != nil {
 		return err
 	}
@@ -186,6 +318,13 @@ func (c *Client) RemoveAll(path string) error {
 // size: number of bytes to read (-1 means read all)
 // Returns io.EOF if offset+size >= file size (reached end of file)
 func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, _, err := c.ReadFormat(path, offset, size, "")
+	return data, err
+}
+
+// ReadFormat reads file content like Read, in format ("json" or "text") if the file is a
+// control file offering it; it returns the format of the data, "" if the file declares none
+func (c *Client) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
 	query := url.Values{}
 	query.Set("path", path)
 	if offset > 0 {
@@ -194,27 +333,36 @@ func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
 	if size >= 0 {
 		query.Set("size", fmt.Sprintf("%d", size))
 	}
+	if format != "" {
+		query.Set("format", format)
+	}
 
 	resp, err := c.doRequest(http.MethodGet, "/files", query, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, "", fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	return data, nil
+	served := ""
+	for _, f := range []string{filesystem.FormatJSON, filesystem.FormatText, filesystem.FormatMarkdown} {
+		if format != "" && resp.Header.Get("Content-Type") == filesystem.FormatContentType(f) {
+			served = f
+		}
+	}
+	return data, served, nil
 }
 
 // Write writes data to a file, creating it if necessary
@@ -224,14 +372,17 @@ func (c *Client) Write(path string, data []byte) ([]byte, error) {
 }
 
 // WriteWithRetry writes data to a file with configurable retry attempts
+// The attempts carry the same Idempotency-Key, so the write is applied once by servers
+// deduplicating keys
 func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byte, error) {
 	query := url.Values{}
 	query.Set("path", path)
+	key := c.idempotencyKey(http.MethodPut, "/files", query, data)
 
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err := c.doRequest(http.MethodPut, "/files", query, bytes.NewReader(data))
+		resp, err := c.send(http.MethodPut, "/files", query, bytes.NewReader(data), key)
 		if err != nil {
 			lastErr = err
 
@@ -291,6 +442,44 @@ func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byt
 	return nil, lastErr
 }
 
+// WriteStream writes everything read from r to a file, streaming it to the server which
+// writes it in chunks, so that files larger than memory can be uploaded
+// The body cannot be sent again, so the write is not retried.
+func (c *Client) WriteStream(path string, r io.Reader) ([]byte, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("stream", "true")
+
+	// Create request with no timeout for streaming
+	streamClient := &http.Client{
+		Transport: c.httpClient.Transport, // Authentication and TLS of the client
+		Timeout:   0,                      // No timeout for streaming
+	}
+
+	reqURL := fmt.Sprintf("%s/files?%s", c.baseURL, query.Encode())
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodPut, reqURL, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	c.authorize(req)
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	var successResp SuccessResponse
+	if err := json.NewDecoder(resp.Body).Decode(&successResp); err != nil {
+		return nil, fmt.Errorf("failed to decode success response: %w", err)
+	}
+	return []byte(successResp.Message), nil
+}
+
 // isRetryableError checks if an error is retryable (network/timeout errors)
 func isRetryableError(err error) bool {
 	if err == nil {
@@ -298,12 +487,14 @@ func isRetryableError(err error) bool {
 	}
 
 	// Check for timeout errors
-	if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
+	var timeoutErr interface{ Timeout() bool }
+	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
 		return true
 	}
 
 	// Check for temporary network errors
-	if netErr, ok := err.(interface{ Temporary() bool }); ok && netErr.Temporary() {
+	var tempErr interface{ Temporary() bool }
+	if errors.As(err, &tempErr) && tempErr.Temporary() {
 		return true
 	}
 
@@ -317,26 +508,63 @@ func isRetryableError(err error) bool {
 
 // ReadDir lists the contents of a directory
 func (c *Client) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return c.ReadDirFields(path, filesystem.AllFields)
+}
+
+// ReadDirFields lists the contents of a directory, asking the server for the selected
+// fields of the entries only; the others are left zero
+func (c *Client) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
 	query := url.Values{}
 	query.Set("path", path)
+	if !fields.All() {
+		query.Set("fields", fields.String())
+	}
+	files, _, err := c.list(query)
+	return files, err
+}
+
+// ReadDirPage lists a page of a directory, sorted and paged by the server; more reports
+// whether entries follow the page
+func (c *Client) ReadDirPage(path string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	if page.Sort != "" {
+		sortKey := page.Sort
+		if page.Desc {
+			sortKey = "-" + sortKey
+		}
+		query.Set("sort", sortKey)
+	}
+	if page.Offset > 0 {
+		query.Set("offset", fmt.Sprintf("%d", page.Offset))
+	}
+	if page.Limit > 0 {
+		query.Set("limit", fmt.Sprintf("%d", page.Limit))
+	}
+	files, next, err := c.list(query)
+	return files, next > 0, err
+}
 
+// list requests a directory listing with query, returning the entries and the offset of
+// the next page of a paged listing
+func (c *Client) list(query url.Values) ([]filesystem.FileInfo, int, error) {
 	resp, err := c.doRequest(http.MethodGet, "/directories", query, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, 0, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
 	}
 
 	var listResp ListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
-		return nil, fmt.Errorf("failed to decode list response: %w", err)
+		return nil, 0, fmt.Errorf("failed to decode list response: %w", err)
 	}
 
 	files := make([]filesystem.FileInfo, 0, len(listResp.Files))
@@ -352,7 +580,7 @@ func (c *Client) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		})
 	}
 
-	return files, nil
+	return files, listResp.Next, nil
 }
 
 // Stat returns file information
@@ -402,7 +630,7 @@ func (c *Client) Rename(oldPath, newPath string) error {
 		return fmt.Errorf("failed to marshal rename request: %w", err)
 	}
 
-	resp, err := c.doRequest(http.MethodPost, "/rename", query, bytes.NewReader(jsonData))
+	resp, err := c.doIdempotent(http.MethodPost, "/rename", query, jsonData)
 	if err != nil {
 		return err
 	}
@@ -410,6 +638,48 @@ func (c *Client) Rename(oldPath, newPath string) error {
 	return c.handleErrorResponse(resp)
 }
 
+// Copy copies the file src to dst, which may be in another mount, replacing dst
+func (c *Client) Copy(src, dst string) error {
+	_, err := c.copy(src, dst, false)
+	return err
+}
+
+// CopyAll copies the file or directory tree src to dst, which may be in another mount;
+// existing files at the destination are overwritten and existing directories are reused
+func (c *Client) CopyAll(src, dst string) (*CopyResponse, error) {
+	return c.copy(src, dst, true)
+}
+
+func (c *Client) copy(src, dst string, recursive bool) (*CopyResponse, error) {
+	query := url.Values{}
+	query.Set("path", src)
+
+	jsonData, err := json.Marshal(CopyRequest{Dest: dst, Recursive: recursive})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal copy request: %w", err)
+	}
+
+	resp, err := c.doIdempotent(http.MethodPost, "/copy", query, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+	}
+
+	var copyResp CopyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&copyResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &copyResp, nil
+}
+
 // Chmod changes file permissions
 func (c *Client) Chmod(path string, mode uint32) error {
 	query := url.Values{}
@@ -421,7 +691,7 @@ func (c *Client) Chmod(path string, mode uint32) error {
 		return fmt.Errorf("failed to marshal chmod request: %w", err)
 	}
 
-	resp, err := c.doRequest(http.MethodPost, "/chmod", query, bytes.NewReader(jsonData))
+	resp, err := c.doIdempotent(http.MethodPost, "/chmod", query, jsonData)
 	if err != nil {
 		return err
 	}
@@ -454,14 +724,16 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 
 	// Create request with no timeout for streaming
 	streamClient := &http.Client{
-		Timeout: 0, // No timeout for streaming
+		Transport: c.httpClient.Transport, // Authentication and TLS of the client
+		Timeout:   0,                      // No timeout for streaming
 	}
 
 	reqURL := fmt.Sprintf("%s/files?%s", c.baseURL, query.Encode())
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	c.authorize(req)
 
 	resp, err := streamClient.Do(req)
 	if err != nil {
@@ -482,6 +754,58 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// Watch reports the changes of path and its entries, or with recursive of everything below
+// it, until ctx is done or the connection is lost, when the channel is closed
+// Servers without the watch API answer with 404, 405 or 501; callers can poll Stat and
+// ReadDir instead.
+func (c *Client) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("recursive", fmt.Sprintf("%t", recursive))
+
+	streamClient := &http.Client{
+		Transport: c.httpClient.Transport, // Authentication and TLS of the client
+		Timeout:   0,                      // No timeout for streaming
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/watch?%s", c.baseURL, query.Encode()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req)
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	events := make(chan filesystem.Event, filesystem.WatchBuffer)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue // Keep-alive comments and blank lines
+			}
+			var e filesystem.Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
 // GrepRequest represents a grep search request
 type GrepRequest struct {
 	Path            string `json:"path"`
@@ -499,8 +823,12 @@ type GrepMatch struct {
 
 // GrepResponse represents the grep search results
 type GrepResponse struct {
-	Matches []GrepMatch `json:"matches"`
-	Count   int         `json:"count"`
+	Matches      []GrepMatch `json:"matches"`
+	Count        int         `json:"count"`
+	Truncated    bool        `json:"truncated"`       // The server budget ran out, so the matches may be partial
+	Limit        string      `json:"limit,omitempty"` // The limit that ran out
+	FilesScanned int64       `json:"files_scanned"`
+	BytesRead    int64       `json:"bytes_read"`
 }
 
 // DigestRequest represents a digest request
@@ -531,11 +859,12 @@ func (c *Client) Grep(path, pattern string, recursive, caseInsensitive bool) (*G
 	}
 
 	reqURL := fmt.Sprintf("%s/grep", c.baseURL)
-	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodPost, reqURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -572,11 +901,12 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 	}
 
 	reqURL := fmt.Sprintf("%s/digest", c.baseURL)
-	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(c.requestContext(), http.MethodPost, reqURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	c.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -599,3 +929,48 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 
 	return &digestResp, nil
 }
+
+// IngestResponse is the result of an ingest
+type IngestResponse struct {
+	Message string  `json:"message"`
+	Path    string  `json:"path"`
+	Files   int     `json:"files"`
+	Dirs    int     `json:"dirs"`
+	Bytes   int64   `json:"bytes"`
+	Batches int     `json:"batches"`
+	Bulk    bool    `json:"bulk"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Ingest writes the files of a tar archive below path in one request; a path outside the
+// mounts is reported as filesystem.ErrNotFound, a server without the ingest endpoint as
+// filesystem.ErrNotSupported
+func (c *Client) Ingest(path string, tarData []byte) (*IngestResponse, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	resp, err := c.doIdempotent(http.MethodPost, "/ingest", query, tarData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+				return nil, fmt.Errorf("HTTP %d: no ingest endpoint: %w", resp.StatusCode, filesystem.ErrNotSupported)
+			}
+			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("HTTP %d: %s: %w", resp.StatusCode, errResp.Error, filesystem.ErrNotFound)
+		}
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+	}
+
+	var ingestResp IngestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ingestResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &ingestResp, nil
+}
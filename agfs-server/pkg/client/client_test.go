@@ -116,3 +116,26 @@ func TestClient_ErrorHandling(t *testing.T) {
 		t.Error("expected error, got nil")
 	}
 }
+
+func TestClient_RetryIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "upstream timed out"})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "file created"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.Create("/test/file.txt"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("keys of the attempts: %q", keys)
+	}
+}
@@ -0,0 +1,562 @@
+// Package cluster lets several AGFS servers behind a load balancer act as one: dynamic
+// mounts are kept in a table shared through TiDB, MySQL or SQLite and applied by every
+// node, and requests for mounts whose state lives in one process (memfs, streamfs, ...)
+// are forwarded to the node owning the mount
+package cluster
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mtls"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	// DefaultDBPath is the SQLite database used when none is configured
+	DefaultDBPath = "cluster.db"
+	// DefaultSyncInterval is how often the mount table and the node list are refreshed
+	DefaultSyncInterval = 2 * time.Second
+	// DefaultNodeTTL is how long a node stays alive without a heartbeat
+	DefaultNodeTTL = 15 * time.Second
+
+	// ForwardedHeader marks a request forwarded by another node, which is always served locally
+	ForwardedHeader = "X-AGFS-Forwarded"
+)
+
+// DefaultPinnedPlugins keep their state in the memory of one process, so each of their
+// mounts is served by a single node
+var DefaultPinnedPlugins = []string{
+	"memfs", "streamfs", "queuefs", "kvfs", "heartbeatfs", "tmpfs", "agentfs", "vectorfs",
+}
+
+// NodeStatus describes a live node
+type NodeStatus struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Self      bool      `json:"self,omitempty"`
+}
+
+// MountStatus describes a mount of the shared table
+type MountStatus struct {
+	Path   string `json:"path"`
+	FSType string `json:"fstype"`
+	Owner  string `json:"owner,omitempty"` // Node serving a pinned mount
+	Error  string `json:"error,omitempty"` // Why this node could not mount it
+}
+
+// Status is a snapshot of the cluster as seen by this node
+type Status struct {
+	NodeID   string        `json:"nodeId"`
+	Backend  string        `json:"backend"`
+	LastSync time.Time     `json:"lastSync"`
+	Nodes    []NodeStatus  `json:"nodes"`
+	Mounts   []MountStatus `json:"mounts"`
+}
+
+// mountRow is a row of the shared mount table
+type mountRow struct {
+	path    string
+	fstype  string
+	config  map[string]interface{}
+	version int64
+}
+
+// failure remembers a mount this node could not apply, so it is not retried until it changes
+type failure struct {
+	version int64
+	err     string
+}
+
+// Cluster synchronizes the dynamic mounts of one node with the shared table
+type Cluster struct {
+	mfs       *mountablefs.MountableFS
+	db        *sql.DB
+	backend   string
+	nodeID    string
+	advertise string
+	interval  time.Duration
+	ttl       time.Duration
+	pinned    map[string]bool
+
+	advertiseDefault bool              // The address is derived from the listen address
+	transport        http.RoundTripper // Transport of forwarded requests, nil for the default
+
+	// syncMu serializes changes of the local mounts made by the API and by the sync loop
+	syncMu  sync.Mutex
+	applied map[string]int64 // Table mounts applied locally, path -> version
+
+	mu       sync.RWMutex // protects the fields below
+	nodes    []NodeStatus // Live nodes sorted by ID
+	rows     []mountRow
+	failed   map[string]failure
+	lastSync time.Time
+	proxies  map[string]*httputil.ReverseProxy
+
+	done     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// New connects to the shared database; listenAddr provides the default node ID and address
+func New(mfs *mountablefs.MountableFS, cfg config.ClusterConfig, listenAddr string) (*Cluster, error) {
+	c := &Cluster{
+		mfs:      mfs,
+		backend:  strings.ToLower(cfg.Backend),
+		nodeID:   cfg.NodeID,
+		interval: DefaultSyncInterval,
+		ttl:      DefaultNodeTTL,
+		pinned:   make(map[string]bool),
+		applied:  make(map[string]int64),
+		failed:   make(map[string]failure),
+		proxies:  make(map[string]*httputil.ReverseProxy),
+		done:     make(chan struct{}),
+	}
+	if c.backend == "" {
+		c.backend = "sqlite"
+	}
+
+	hostname, _ := os.Hostname()
+	if c.nodeID == "" {
+		c.nodeID = hostname + listenAddr
+	}
+	c.advertise = strings.TrimSuffix(cfg.Advertise, "/")
+	if c.advertise == "" {
+		host := listenAddr
+		if strings.HasPrefix(host, ":") {
+			host = hostname + host
+		}
+		c.advertise = "http://" + host
+		c.advertiseDefault = true
+	}
+	if _, err := url.Parse(c.advertise); err != nil {
+		return nil, fmt.Errorf("invalid cluster advertise address: %w", err)
+	}
+
+	var err error
+	if cfg.SyncInterval != "" {
+		if c.interval, err = pluginconfig.ParseDuration(cfg.SyncInterval); err != nil || c.interval <= 0 {
+			return nil, fmt.Errorf("invalid cluster sync_interval: %s", cfg.SyncInterval)
+		}
+	}
+	if cfg.NodeTTL != "" {
+		if c.ttl, err = pluginconfig.ParseDuration(cfg.NodeTTL); err != nil || c.ttl <= 0 {
+			return nil, fmt.Errorf("invalid cluster node_ttl: %s", cfg.NodeTTL)
+		}
+	}
+	if c.ttl <= c.interval {
+		return nil, fmt.Errorf("cluster node_ttl (%s) must be longer than sync_interval (%s)", c.ttl, c.interval)
+	}
+
+	pinned := cfg.PinnedPlugins
+	if pinned == nil {
+		pinned = DefaultPinnedPlugins
+	}
+	for _, name := range pinned {
+		c.pinned[name] = true
+	}
+
+	if c.db, err = openDB(c.backend, cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openDB opens the shared database and creates the cluster tables
+func openDB(backend string, cfg config.ClusterConfig) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	switch backend {
+	case "sqlite", "sqlite3":
+		dbPath := cfg.DBPath
+		if dbPath == "" {
+			dbPath = DefaultDBPath
+		}
+		// The nodes sharing a SQLite file are separate processes, wait for their locks
+		db, err = sql.Open("sqlite3", dbPath+"?_busy_timeout=5000")
+		if err == nil {
+			db.SetMaxOpenConns(1)
+		}
+	case "mysql", "tidb":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("cluster backend %s requires a dsn", backend)
+		}
+		db, err = sql.Open("mysql", cfg.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported cluster backend: %s (use tidb, mysql or sqlite)", backend)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cluster database: %w", err)
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS agfs_cluster_mounts (
+			path VARCHAR(512) NOT NULL PRIMARY KEY,
+			fstype VARCHAR(128) NOT NULL,
+			config TEXT NOT NULL,
+			version BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS agfs_cluster_nodes (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			address VARCHAR(512) NOT NULL,
+			heartbeat BIGINT NOT NULL
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize cluster database: %w", err)
+		}
+	}
+	return db, nil
+}
+
+// NodeID returns the ID of this node
+func (c *Cluster) NodeID() string {
+	return c.nodeID
+}
+
+// SetTLS makes the node forward requests to the other nodes over TLS with cfg, and
+// advertise an https address unless one is configured; it must be called before Start
+func (c *Cluster) SetTLS(cfg *tls.Config) {
+	c.transport = mtls.Transport(cfg)
+	if c.advertiseDefault {
+		c.advertise = "https://" + strings.TrimPrefix(c.advertise, "http://")
+	}
+}
+
+// Start joins the cluster, applying the shared mounts before it returns, and keeps them in sync
+func (c *Cluster) Start() {
+	if err := c.Sync(); err != nil {
+		log.Warnf("[cluster] initial sync failed: %v", err)
+	}
+	log.Infof("[cluster] node %s joined at %s", c.nodeID, c.advertise)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				if err := c.Sync(); err != nil {
+					log.Warnf("[cluster] sync failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop leaves the cluster, so the other nodes take over its pinned mounts at once
+// The local mounts are kept
+func (c *Cluster) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		if _, err := c.db.Exec(`DELETE FROM agfs_cluster_nodes WHERE id = ?`, c.nodeID); err != nil {
+			log.Warnf("[cluster] failed to remove node %s: %v", c.nodeID, err)
+		}
+		c.db.Close()
+	})
+}
+
+// MountPlugin mounts a plugin on this node and adds it to the shared table, so every
+// node mounts it
+func (c *Cluster) MountPlugin(fstype, path string, cfg map[string]interface{}) error {
+	path = filesystem.NormalizePath(path)
+	if cfg == nil {
+		cfg = map[string]interface{}{}
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid plugin config: %v", err)
+	}
+
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	var count int
+	if err := c.db.QueryRow(`SELECT COUNT(*) FROM agfs_cluster_mounts WHERE path = ?`, path).Scan(&count); err != nil {
+		return fmt.Errorf("failed to read cluster mounts: %w", err)
+	}
+	if count > 0 {
+		return filesystem.NewAlreadyExistsError("mount", path)
+	}
+
+	// Mounting locally first validates the configuration before other nodes see it
+	if err := c.mfs.MountPlugin(fstype, path, cfg); err != nil {
+		return err
+	}
+	version := time.Now().UnixNano()
+	if _, err := c.db.Exec(`INSERT INTO agfs_cluster_mounts (path, fstype, config, version) VALUES (?, ?, ?, ?)`,
+		path, fstype, string(data), version); err != nil {
+		c.mfs.Unmount(path)
+		return fmt.Errorf("failed to add cluster mount: %w", err)
+	}
+	c.applied[path] = version
+	return nil
+}
+
+// Unmount removes a mount from the shared table and from this node; mounts that are
+// not in the table, e.g. from the configuration file, are only unmounted locally
+func (c *Cluster) Unmount(path string) error {
+	path = filesystem.NormalizePath(path)
+
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	res, err := c.db.Exec(`DELETE FROM agfs_cluster_mounts WHERE path = ?`, path)
+	if err != nil {
+		return fmt.Errorf("failed to remove cluster mount: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		c.mu.Lock()
+		delete(c.failed, path)
+		c.mu.Unlock()
+		if _, ok := c.applied[path]; !ok {
+			// This node never mounted it
+			return nil
+		}
+		delete(c.applied, path)
+	}
+	return c.mfs.Unmount(path)
+}
+
+// Sync sends a heartbeat, refreshes the live nodes and applies the changes of the mount table
+func (c *Cluster) Sync() error {
+	now := time.Now()
+	if _, err := c.db.Exec(`REPLACE INTO agfs_cluster_nodes (id, address, heartbeat) VALUES (?, ?, ?)`,
+		c.nodeID, c.advertise, now.UnixMilli()); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	nodes, err := c.loadNodes(now)
+	if err != nil {
+		return err
+	}
+	rows, err := c.loadMounts()
+	if err != nil {
+		return err
+	}
+
+	c.syncMu.Lock()
+	failed := c.apply(rows)
+	c.syncMu.Unlock()
+
+	c.mu.Lock()
+	c.nodes, c.rows, c.failed, c.lastSync = nodes, rows, failed, now
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Cluster) loadNodes(now time.Time) ([]NodeStatus, error) {
+	rows, err := c.db.Query(`SELECT id, address, heartbeat FROM agfs_cluster_nodes WHERE heartbeat >= ?`,
+		now.Add(-c.ttl).UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster nodes: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []NodeStatus
+	for rows.Next() {
+		var n NodeStatus
+		var heartbeat int64
+		if err := rows.Scan(&n.ID, &n.Address, &heartbeat); err != nil {
+			return nil, fmt.Errorf("failed to read cluster nodes: %w", err)
+		}
+		n.Heartbeat = time.UnixMilli(heartbeat)
+		n.Self = n.ID == c.nodeID
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, rows.Err()
+}
+
+func (c *Cluster) loadMounts() ([]mountRow, error) {
+	rows, err := c.db.Query(`SELECT path, fstype, config, version FROM agfs_cluster_mounts ORDER BY path`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster mounts: %w", err)
+	}
+	defer rows.Close()
+
+	var mounts []mountRow
+	for rows.Next() {
+		var m mountRow
+		var data string
+		if err := rows.Scan(&m.path, &m.fstype, &data, &m.version); err != nil {
+			return nil, fmt.Errorf("failed to read cluster mounts: %w", err)
+		}
+		if err := json.Unmarshal([]byte(data), &m.config); err != nil {
+			log.Warnf("[cluster] ignoring mount %s with invalid config: %v", m.path, err)
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, rows.Err()
+}
+
+// apply makes the local mounts match rows and returns the mounts that failed
+// Called with syncMu held
+func (c *Cluster) apply(rows []mountRow) map[string]failure {
+	c.mu.RLock()
+	previous := c.failed
+	c.mu.RUnlock()
+
+	failed := make(map[string]failure)
+	inTable := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		inTable[row.path] = true
+		if version, ok := c.applied[row.path]; ok && version == row.version {
+			continue
+		}
+		if f, ok := previous[row.path]; ok && f.version == row.version {
+			failed[row.path] = f
+			continue
+		}
+
+		if _, ok := c.applied[row.path]; ok {
+			// Mounted again with another configuration
+			if err := c.mfs.Unmount(row.path); err != nil {
+				log.Warnf("[cluster] failed to unmount %s: %v", row.path, err)
+			}
+			delete(c.applied, row.path)
+		}
+		if err := c.mfs.MountPlugin(row.fstype, row.path, row.config); err != nil {
+			log.Warnf("[cluster] failed to mount %s at %s: %v", row.fstype, row.path, err)
+			failed[row.path] = failure{version: row.version, err: err.Error()}
+			continue
+		}
+		c.applied[row.path] = row.version
+	}
+
+	for path := range c.applied {
+		if inTable[path] {
+			continue
+		}
+		if err := c.mfs.Unmount(path); err != nil {
+			log.Warnf("[cluster] failed to unmount %s: %v", path, err)
+		}
+		delete(c.applied, path)
+	}
+	return failed
+}
+
+// Owner returns the live node serving the pinned mount at mountPath, chosen by
+// rendezvous hashing so that only the mounts of a node that leaves or joins move
+func (c *Cluster) Owner(mountPath string) NodeStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.owner(mountPath)
+}
+
+func (c *Cluster) owner(mountPath string) NodeStatus {
+	best := NodeStatus{ID: c.nodeID, Address: c.advertise, Self: true}
+	var bestScore uint64
+	for i, n := range c.nodes {
+		if score := xxh3.HashString(n.ID + "|" + mountPath); i == 0 || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
+
+// Middleware forwards requests for pinned mounts owned by another node to that node
+func (c *Cluster) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if path == "" || r.Header.Get(ForwardedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mount, ok := c.mfs.LookupMount(path)
+		if !ok || !c.pinned[mount.Plugin.Name()] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		owner := c.Owner(mount.Path)
+		if owner.Self {
+			next.ServeHTTP(w, r)
+			return
+		}
+		proxy, err := c.proxy(owner.Address)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Debugf("[cluster] forwarding %s %s to %s", r.Method, path, owner.ID)
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// proxy returns the reverse proxy to the node at address
+func (c *Cluster) proxy(address string) (*httputil.ReverseProxy, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.proxies[address]; ok {
+		return p, nil
+	}
+	target, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node address %s: %v", address, err)
+	}
+	p := httputil.NewSingleHostReverseProxy(target)
+	p.FlushInterval = -1 // Streams are relayed as they are written
+	p.Transport = c.transport
+	director := p.Director
+	p.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Set(ForwardedHeader, c.nodeID)
+	}
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Warnf("[cluster] forwarding to %s failed: %v", address, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("node %s is unreachable", address)})
+	}
+	c.proxies[address] = p
+	return p, nil
+}
+
+// Status returns the nodes and mounts of the cluster
+func (c *Cluster) Status() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	s := Status{
+		NodeID:   c.nodeID,
+		Backend:  c.backend,
+		LastSync: c.lastSync,
+		Nodes:    append([]NodeStatus(nil), c.nodes...),
+		Mounts:   make([]MountStatus, 0, len(c.rows)),
+	}
+	for _, row := range c.rows {
+		m := MountStatus{Path: row.path, FSType: row.fstype}
+		if c.pinned[row.fstype] {
+			m.Owner = c.owner(row.path).ID
+		}
+		if f, ok := c.failed[row.path]; ok {
+			m.Error = f.err
+		}
+		s.Mounts = append(s.Mounts, m)
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+type testNode struct {
+	mfs     *mountablefs.MountableFS
+	cluster *Cluster
+	server  *httptest.Server
+}
+
+func newTestNode(t *testing.T, id, dbPath string) *testNode {
+	t.Helper()
+	n := &testNode{mfs: mountablefs.NewMountableFS()}
+	n.mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+
+	var handler http.Handler
+	n.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(n.server.Close)
+
+	var err error
+	n.cluster, err = New(n.mfs, config.ClusterConfig{NodeID: id, Advertise: n.server.URL, DBPath: dbPath}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.cluster.Stop)
+
+	mux := http.NewServeMux()
+	handlers.NewHandler(n.mfs).SetupRoutes(mux)
+	handler = n.cluster.Middleware(mux)
+	return n
+}
+
+func get(t *testing.T, n *testNode, path string) string {
+	t.Helper()
+	resp, err := http.Get(n.server.URL + "/api/v1/files?path=" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestSharedMounts(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "cluster.db")
+	a := newTestNode(t, "a", dbPath)
+	b := newTestNode(t, "b", dbPath)
+	for _, n := range []*testNode{a, b} {
+		if err := n.cluster.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.cluster.MountPlugin("memfs", "/shared", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.cluster.MountPlugin("memfs", "/shared", nil); err == nil {
+		t.Errorf("mounting /shared twice succeeded")
+	}
+	for _, n := range []*testNode{a, b} {
+		if err := n.cluster.Sync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := b.mfs.LookupMount("/shared/x"); !ok {
+		t.Fatalf("/shared is not mounted on b")
+	}
+	if a.cluster.Owner("/shared").ID != b.cluster.Owner("/shared").ID {
+		t.Errorf("nodes disagree on the owner of /shared")
+	}
+
+	// Whichever node receives the request, the file lives in the owner's memfs
+	req, _ := http.NewRequest(http.MethodPut, a.server.URL+"/api/v1/files?path=/shared/f", strings.NewReader("data"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := get(t, b, "/shared/f"); got != "data" {
+		t.Errorf("read through b = %q", got)
+	}
+	owner, other := a, b
+	if a.cluster.Owner("/shared").ID == "b" {
+		owner, other = b, a
+	}
+	if _, err := other.mfs.Stat("/shared/f"); err == nil {
+		t.Errorf("file was written to the node not owning /shared")
+	}
+	if _, err := owner.mfs.Stat("/shared/f"); err != nil {
+		t.Errorf("file is missing on the owner: %v", err)
+	}
+
+	if err := b.cluster.Unmount("/shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.cluster.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.mfs.LookupMount("/shared/x"); ok {
+		t.Errorf("/shared is still mounted on a")
+	}
+}
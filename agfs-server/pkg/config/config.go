@@ -12,12 +12,57 @@ type Config struct {
 	Server          ServerConfig            `yaml:"server"`
 	Plugins         map[string]PluginConfig `yaml:"plugins"`
 	ExternalPlugins ExternalPluginsConfig   `yaml:"external_plugins"`
+	Backups         []BackupConfig          `yaml:"backups"`
+	Migrations      MigrationsConfig        `yaml:"migrations"`
+	Lifecycle       LifecycleConfig         `yaml:"lifecycle"`
+	QueueArchive    QueueArchiveConfig      `yaml:"queue_archive"`
+	FileDrop        FileDropConfig          `yaml:"file_drop"`
+	Pipelines       PipelinesConfig         `yaml:"pipelines"`
+	Search          SearchConfig            `yaml:"search"`
+	Tags            TagsConfig              `yaml:"tags"`
+	Analytics       AnalyticsConfig         `yaml:"analytics"`
+	Auth            AuthConfig              `yaml:"auth"`
+	ACL             ACLConfig               `yaml:"acl"`
+	Holds           HoldsConfig             `yaml:"holds"`
+	Tenancy         TenancyConfig           `yaml:"tenancy"`
+	Traffic         TrafficConfig           `yaml:"traffic"`
+	Recording       RecordingConfig         `yaml:"recording"`
+	MCP             MCPConfig               `yaml:"mcp"`
+	SMB             SMBConfig               `yaml:"smb"`
+	Cluster         ClusterConfig           `yaml:"cluster"`
+	Debug           DebugConfig             `yaml:"debug"`
+	Uploads         UploadsConfig           `yaml:"uploads"`
+	Budget          BudgetConfig            `yaml:"budget"`
+	Jobs            JobsConfig              `yaml:"jobs"`
+	Idempotency     IdempotencyConfig       `yaml:"idempotency"`
+	Secrets         SecretsConfig           `yaml:"secrets"`
+	Usage           UsageConfig             `yaml:"usage"`
 }
 
 // ServerConfig contains server-level configuration
 type ServerConfig struct {
-	Address  string `yaml:"address"`
-	LogLevel string `yaml:"log_level"`
+	Address      string    `yaml:"address"`
+	LogLevel     string    `yaml:"log_level"`
+	DevMode      bool      `yaml:"dev_mode"`      // Enables development-only plugins such as faultfs
+	DataDir      string    `yaml:"data_dir"`      // Parent of the local data directories of the mounts (default "data")
+	CORSOrigins  []string  `yaml:"cors_origins"`  // Origins of web pages allowed to read streams as server-sent events, "*" for any
+	AdminAddress string    `yaml:"admin_address"` // Serves the admin routes only here, "host:port" or "unix:/path/to.sock"
+	TLS          TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig serves the API over TLS; with a CA, clients must present a certificate signed
+// by it, and the certificate and CA are also used for connections to other servers (proxyfs
+// remotes and cluster nodes)
+type TLSConfig struct {
+	CertFile       string `yaml:"cert_file"`       // PEM certificate of the server
+	KeyFile        string `yaml:"key_file"`        // PEM private key of the certificate
+	CAFile         string `yaml:"ca_file"`         // PEM CA certificates that client and peer certificates must be signed by
+	ReloadInterval string `yaml:"reload_interval"` // How often the files are checked for rotated certificates (default "1m")
+}
+
+// Enabled reports whether the API is served over TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
 }
 
 // ExternalPluginsConfig contains configuration for external plugins
@@ -27,25 +72,404 @@ type ExternalPluginsConfig struct {
 	AutoLoad      bool     `yaml:"auto_load"`
 	PluginPaths   []string `yaml:"plugin_paths"`
 	WASIMountPath string   `yaml:"wasi_mount_path"` // Directory to mount for WASI filesystem access
+
+	Limits       PluginLimitsConfig            `yaml:"limits"`        // Resource limits of WASM plugins
+	PluginLimits map[string]PluginLimitsConfig `yaml:"plugin_limits"` // Limits of individual plugins by file name, overriding Limits
+	Signing      PluginSigningConfig           `yaml:"signing"`       // Signature verification of plugin files
+}
+
+// PluginSigningConfig configures the verification of plugin signatures kept in <plugin>.sig
+type PluginSigningConfig struct {
+	Required    bool     `yaml:"required"`     // Refuse plugins without a valid signature
+	TrustedKeys []string `yaml:"trusted_keys"` // PEM public keys (ed25519 or ECDSA), inline or as file paths
+}
+
+// PluginLimitsConfig bounds the resources of a WASM plugin instance; empty fields are unlimited
+type PluginLimitsConfig struct {
+	MaxMemory    string `yaml:"max_memory"`      // Linear memory of the module, e.g. "64MB"
+	CallTimeout  string `yaml:"call_timeout"`    // Longest call into the plugin, e.g. "5s"; a call running longer terminates it
+	MaxOpsPerSec int    `yaml:"max_ops_per_sec"` // File operations per second
+	SuspendAfter int    `yaml:"suspend_after"`   // Violations in a row after which the plugin is suspended
+	SuspendFor   string `yaml:"suspend_for"`     // How long a suspension lasts (default "1m")
+}
+
+// BackupConfig describes a scheduled backup job
+type BackupConfig struct {
+	Name      string          `yaml:"name"`
+	Enabled   bool            `yaml:"enabled"`
+	Source    string          `yaml:"source"`   // Path to back up, e.g. "/sqlfs/sqlite"
+	Target    string          `yaml:"target"`   // Directory receiving timestamped backups, e.g. "/s3fs/backups/sqlite"
+	Interval  string          `yaml:"interval"` // Time between runs (default "24h")
+	At        string          `yaml:"at"`       // Optional local time of day ("02:00") for the first run
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// MigrationsConfig configures the jobs moving data between mounts
+type MigrationsConfig struct {
+	StateDir string `yaml:"state_dir"` // Local directory keeping job checkpoints (default "migrations")
+}
+
+// LifecycleConfig configures the rules moving or deleting files by age
+type LifecycleConfig struct {
+	Interval string          `yaml:"interval"`  // Time between evaluations (default "1h")
+	AuditLog string          `yaml:"audit_log"` // Local file receiving one JSON line per action (default "lifecycle-audit.log")
+	Rules    []LifecycleRule `yaml:"rules"`
+}
+
+// LifecycleRule moves files older than OlderThan below Path to Target, or deletes them
+type LifecycleRule struct {
+	Name      string `yaml:"name"`
+	Enabled   bool   `yaml:"enabled"`
+	Path      string `yaml:"path"`       // Directory whose files the rule applies to, e.g. "/memfs/logs"
+	OlderThan string `yaml:"older_than"` // Minimum age since the last modification, e.g. "30d"
+	Action    string `yaml:"action"`     // "move" or "delete"
+	Target    string `yaml:"target"`     // Directory receiving moved files, e.g. "/s3fs/archive/logs"
+	Include   string `yaml:"include"`    // Optional comma separated patterns selecting files
+	Exclude   string `yaml:"exclude"`    // Optional comma separated patterns skipping files
+	DryRun    bool   `yaml:"dry_run"`    // Only report what the rule would do
+}
+
+// QueueArchiveConfig configures the archivers draining queuefs queues into batch files
+type QueueArchiveConfig struct {
+	StateDir  string                `yaml:"state_dir"` // Local directory keeping the checkpoints (default "queue-archive")
+	Archivers []QueueArchiverConfig `yaml:"archivers"`
+}
+
+// QueueArchiverConfig drains the messages of Queue into NDJSON files under Target, on a
+// schedule or once the queue holds Threshold messages
+type QueueArchiverConfig struct {
+	Name          string `yaml:"name"`
+	Enabled       bool   `yaml:"enabled"`
+	Queue         string `yaml:"queue"`          // queuefs queue to drain, e.g. "/queuefs/events"
+	Target        string `yaml:"target"`         // Directory receiving the batch files, e.g. "/s3fs/archive/events"
+	Interval      string `yaml:"interval"`       // Time between scheduled drains (default "5m")
+	Threshold     int    `yaml:"threshold"`      // Drain as soon as the queue holds this many messages (0: on schedule only)
+	PollInterval  string `yaml:"poll_interval"`  // How often the size of the queue is checked against Threshold (default "10s")
+	BatchSize     int    `yaml:"batch_size"`     // Messages per file at most (default 1000)
+	RetryInterval string `yaml:"retry_interval"` // Delay before retrying a failed drain, doubled up to Interval (default "30s")
+}
+
+// FileDropConfig configures the watchers enqueueing an event for every file dropped in a
+// directory
+type FileDropConfig struct {
+	StateDir string            `yaml:"state_dir"` // Local directory keeping the files seen by each watcher (default "file-drop")
+	Watchers []FileDropWatcher `yaml:"watchers"`
+}
+
+// FileDropWatcher scans Path and enqueues a message to Queue for each new file; with an
+// AckQueue, files whose event is acknowledged there are moved to Processed
+type FileDropWatcher struct {
+	Name      string `yaml:"name"`
+	Enabled   bool   `yaml:"enabled"`
+	Path      string `yaml:"path"`      // Directory watched, e.g. "/localfs/incoming"
+	Queue     string `yaml:"queue"`     // queuefs queue receiving the events, e.g. "/queuefs/incoming"
+	Interval  string `yaml:"interval"`  // Time between scans (default "10s")
+	Settle    string `yaml:"settle"`    // How long a file must be left unmodified before it is enqueued (default "5s")
+	Include   string `yaml:"include"`   // Optional comma separated patterns selecting files
+	Exclude   string `yaml:"exclude"`   // Optional comma separated patterns skipping files
+	Digest    string `yaml:"digest"`    // Digest of the content in the events: "sha256" (default), "xxh3" or "none"
+	AckQueue  string `yaml:"ack_queue"` // Optional queue consumers enqueue the ID of processed events to
+	Processed string `yaml:"processed"` // Directory acknowledged files are moved to (default "<path>/processed")
+}
+
+// PipelinesConfig configures the pipelines run by the server, defined inline or in a
+// separate pipelines.yaml file
+type PipelinesConfig struct {
+	StateDir    string           `yaml:"state_dir"`   // Local directory keeping the progress of each pipeline (default "pipelines")
+	File        string           `yaml:"file"`        // Optional YAML file with a "pipelines" list of further definitions
+	Definitions []PipelineConfig `yaml:"definitions"` // Inline definitions
+}
+
+// PipelineConfig takes records from a source, passes them through the transforms in order
+// and writes the result to every sink
+type PipelineConfig struct {
+	Name       string              `yaml:"name"`
+	Enabled    bool                `yaml:"enabled"`
+	Source     PipelineSource      `yaml:"source"`
+	Transforms []PipelineTransform `yaml:"transforms"`
+	Sinks      []PipelineSink      `yaml:"sinks"`
+}
+
+// PipelineSource is where records come from; exactly one of Watch, Queue and Schedule is set
+type PipelineSource struct {
+	Watch    string `yaml:"watch"`    // Directory whose new and modified files are records, e.g. "/localfs/incoming"
+	Queue    string `yaml:"queue"`    // queuefs queue whose messages are records, e.g. "/queuefs/events"
+	Schedule string `yaml:"schedule"` // Interval at which a record is emitted, e.g. "1h"
+	Read     string `yaml:"read"`     // With schedule, file whose content is the data of the record
+	Interval string `yaml:"interval"` // Time between polls of watch and queue sources (default "10s")
+	Include  string `yaml:"include"`  // Optional comma separated patterns selecting watched files
+	Exclude  string `yaml:"exclude"`  // Optional comma separated patterns skipping watched files
+	Batch    int    `yaml:"batch"`    // Most records taken by one run (default 100)
+}
+
+// PipelineTransform rewrites the data of a record; exactly one of Template, Script and
+// WASM is set
+type PipelineTransform struct {
+	Template string `yaml:"template"` // Go text/template executed with the record
+	Script   string `yaml:"script"`   // Lua source defining transform(record), returning the data or nil to drop the record
+	WASM     string `yaml:"wasm"`     // Local path of a WASI module reading the data on stdin and writing the result to stdout
+	Timeout  string `yaml:"timeout"`  // Longest a script or module may run per record (default "5s")
+}
+
+// PipelineSink is where records go; exactly one of Path, Queue and Webhook is set
+type PipelineSink struct {
+	Path    string            `yaml:"path"`    // Directory receiving a file per record, e.g. "/s3fs/processed"
+	Queue   string            `yaml:"queue"`   // queuefs queue receiving a message per record
+	Webhook string            `yaml:"webhook"` // URL receiving a POST per record
+	Headers map[string]string `yaml:"headers"` // Extra headers of webhook requests
+}
+
+// RetentionConfig controls how many backups are kept per period
+// The newest backup of each of the last N days, weeks and months is kept; all zero keeps everything
+type RetentionConfig struct {
+	Daily   int `yaml:"daily"`
+	Weekly  int `yaml:"weekly"`
+	Monthly int `yaml:"monthly"`
+}
+
+// SearchConfig configures the full-text search index
+type SearchConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	Paths           []string `yaml:"paths"`            // Paths to crawl and keep indexed, e.g. "/sqlfs"
+	Include         []string `yaml:"include"`          // Glob patterns of files to index (default all)
+	Exclude         []string `yaml:"exclude"`          // Glob patterns of files and directories to skip
+	MaxFileSize     int64    `yaml:"max_file_size"`    // Larger files are indexed by name only (default 1 MiB)
+	ReindexInterval string   `yaml:"reindex_interval"` // Full recrawl interval (default "1h", "0" disables)
+}
+
+// TagsConfig configures path tagging
+type TagsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	DBPath  string `yaml:"db_path"` // SQLite database holding the tags (default "tags.db")
+}
+
+// AnalyticsConfig counts the reads and writes of each path over sliding windows, to report
+// the hot files
+type AnalyticsConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Windows    []string `yaml:"windows"`    // Windows of the serverinfofs reports (default ["5m", "1h", "24h"]); the longest is kept
+	Resolution string   `yaml:"resolution"` // Granularity of the windows (default "1m")
+	MaxPaths   int      `yaml:"max_paths"`  // Paths tracked at most, the least recently accessed are dropped (default 10000)
+	Top        int      `yaml:"top"`        // Files listed by the serverinfofs reports (default 20)
+	Exclude    []string `yaml:"exclude"`    // Path prefixes not tracked (default ["/serverinfofs"])
+}
+
+// AuthConfig requires an API key or a JWT bearer token on the requests of the HTTP API
+type AuthConfig struct {
+	Enabled     bool             `yaml:"enabled"`
+	APIKeys     []APIKeyConfig   `yaml:"api_keys"`
+	JWT         JWTConfig        `yaml:"jwt"`
+	Exempt      []string         `yaml:"exempt"`       // Paths served without a token, a trailing "/" exempts a subtree (default /api/v1/health, /livez, /readyz)
+	Rules       []AuthRuleConfig `yaml:"rules"`        // Scopes required by routes, the first matching rule applies; other routes take any valid token
+	AdminScopes []string         `yaml:"admin_scopes"` // Scopes granting the admin role the admin routes require (default "admin"); "*" grants every scope
+}
+
+// APIKeyConfig is a static key, sent as a bearer token or in an X-API-Key header
+type APIKeyConfig struct {
+	Name   string   `yaml:"name"`
+	Key    string   `yaml:"key"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// JWTConfig verifies JWT bearer tokens; their scopes are the "scope" or "scopes" claim
+type JWTConfig struct {
+	Secret        string `yaml:"secret"`          // Shared secret of HS256/HS384/HS512 tokens
+	PublicKeyFile string `yaml:"public_key_file"` // PEM RSA or ECDSA public key of RS*/ES* tokens
+	Issuer        string `yaml:"issuer"`          // Required "iss" claim, if set
+	Audience      string `yaml:"audience"`        // Required "aud" claim, if set
+	Leeway        string `yaml:"leeway"`          // Clock skew allowed checking "exp" and "nbf" (default "30s")
+}
+
+// AuthRuleConfig requires one of Scopes on requests to Path and below
+type AuthRuleConfig struct {
+	Path    string   `yaml:"path"`    // e.g. "/api/v1/plugins"
+	Methods []string `yaml:"methods"` // e.g. ["POST", "DELETE"], all methods if empty
+	Scopes  []string `yaml:"scopes"`
+}
+
+// ACLConfig restricts which principals authenticated by auth may read and write which paths
+type ACLConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Default string          `yaml:"default"` // "allow" (default) or "deny" paths without a matching rule
+	File    string          `yaml:"file"`    // Local JSON file keeping rules edited through the API, loaded instead of Rules if it exists
+	Rules   []ACLRuleConfig `yaml:"rules"`
+}
+
+// ACLRuleConfig allows Principal the operations in Allow on Path and below
+// The rule of the longest matching path applies, one naming the principal before one for "*"
+type ACLRuleConfig struct {
+	Principal string   `yaml:"principal"` // API key name or JWT subject, "*" for all
+	Path      string   `yaml:"path"`
+	Allow     []string `yaml:"allow"` // "read" and/or "write", empty for no access
+}
+
+// HoldsConfig enables legal holds and retentions, which make paths undeletable and unmodifiable
+type HoldsConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	File       string   `yaml:"file"`       // Local JSON file keeping the holds (default "holds.json")
+	Principals []string `yaml:"principals"` // Principals allowed to place and release holds, admins if empty
+}
+
+// TenancyConfig gives each user a private home directory
+type TenancyConfig struct {
+	Enabled      bool               `yaml:"enabled"`
+	HomeRoot     string             `yaml:"home_root"`     // Parent of the home directories (default "/home")
+	HomePlugin   *HomePluginConfig  `yaml:"home_plugin"`   // Mount one plugin instance per user instead of using a shared prefix
+	AdminToken   string             `yaml:"admin_token"`   // Token for unrestricted access; if empty, requests without a token are unrestricted
+	DefaultQuota string             `yaml:"default_quota"` // Quota of users without one, e.g. "1GB" (default unlimited)
+	Users        []TenantUserConfig `yaml:"users"`
+}
+
+// HomePluginConfig describes the plugin instance mounted as each user's home
+// The string "{user}" in string config values is replaced with the user name
+type HomePluginConfig struct {
+	Type   string                 `yaml:"type"`
+	Config map[string]interface{} `yaml:"config"`
+}
+
+// TenantUserConfig describes a tenant
+type TenantUserConfig struct {
+	Name     string `yaml:"name"`
+	Token    string `yaml:"token"`     // Bearer token identifying the user
+	Quota    string `yaml:"quota"`     // e.g. "500MB", "0" for unlimited
+	ReadOnly bool   `yaml:"read_only"` // Deny all writes
+}
+
+// TrafficConfig limits the bandwidth of file transfers per client
+// Clients are tenants when tenancy is enabled, otherwise remote hosts; per-mount limits are mount options
+type TrafficConfig struct {
+	ClientReadBPS  string `yaml:"client_read_bps"`  // Read limit of each client in bytes/sec, e.g. "20MB" (default unlimited)
+	ClientWriteBPS string `yaml:"client_write_bps"` // Write limit of each client in bytes/sec (default unlimited)
+}
+
+// RecordingConfig captures API requests and responses for replay with "agfs-server replay"
+type RecordingConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	File          string   `yaml:"file"`           // Local file records are appended to
+	Dir           string   `yaml:"dir"`            // Or a directory of the server receiving one segment file per flush
+	MaxBody       string   `yaml:"max_body"`       // Bodies are recorded up to this size (default "1MB")
+	FlushInterval string   `yaml:"flush_interval"` // How often records are written (default "5s")
+	Exclude       []string `yaml:"exclude"`        // URL path prefixes not recorded (default ["/api/v1/health", "/livez", "/readyz"])
+}
+
+// MCPConfig exposes the file system to AI agents as Model Context Protocol tools
+type MCPConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Transport    string   `yaml:"transport"`      // "sse" on the HTTP server at /mcp/sse (default) or "stdio"
+	Allow        []string `yaml:"allow"`          // Paths the tools may access, with everything below them (default ["/"])
+	ReadOnly     bool     `yaml:"read_only"`      // Only offer tools that do not change anything
+	MaxReadSize  string   `yaml:"max_read_size"`  // Bytes returned by one read (default "256KB")
+	MaxWriteSize string   `yaml:"max_write_size"` // Bytes accepted by one write (default "1MB")
+}
+
+// SMBConfig serves subtrees of the file system to Windows machines as SMB2 shares
+// Users are the API keys of auth: the user name is the key's name, the password the key.
+type SMBConfig struct {
+	Enabled        bool             `yaml:"enabled"`
+	Address        string           `yaml:"address"`         // Listen address (default ":445")
+	Guest          bool             `yaml:"guest"`           // Accept anonymous logons and unknown users, as the principal "guest", who only reads, when auth is enabled
+	RequireSigning bool             `yaml:"require_signing"` // Refuse unsigned requests of authenticated sessions
+	MaxFileSize    string           `yaml:"max_file_size"`   // Size a file written through SMB may grow to, it is buffered until closed (default "64MB")
+	Shares         []SMBShareConfig `yaml:"shares"`
+}
+
+// SMBShareConfig is a share named Name serving the subtree at Path
+type SMBShareConfig struct {
+	Name     string `yaml:"name"`
+	Path     string `yaml:"path"`
+	ReadOnly bool   `yaml:"read_only"`
+}
+
+// ClusterConfig lets several servers share their dynamic mounts through a database and
+// routes requests for stateful mounts to the node owning them
+type ClusterConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	NodeID        string   `yaml:"node_id"`        // Unique name of this server (default host name and port)
+	Advertise     string   `yaml:"advertise"`      // URL other nodes forward requests to, e.g. "http://10.0.0.5:8080"
+	Backend       string   `yaml:"backend"`        // "tidb", "mysql" or "sqlite" (default "sqlite")
+	DSN           string   `yaml:"dsn"`            // TiDB/MySQL data source name
+	DBPath        string   `yaml:"db_path"`        // SQLite database shared by the nodes of one host
+	SyncInterval  string   `yaml:"sync_interval"`  // How often mounts and nodes are refreshed (default "2s")
+	NodeTTL       string   `yaml:"node_ttl"`       // Nodes without heartbeat for this long are down (default "15s")
+	PinnedPlugins []string `yaml:"pinned_plugins"` // Plugins whose mounts are served by one node (default: in-memory plugins)
+}
+
+// DebugConfig exposes the Go runtime profiles and variables under /debug
+type DebugConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"`    // Bearer token required by every /debug request
+	DumpDir string `yaml:"dump_dir"` // Directory of the server receiving the profiles of POST /debug/dump
+}
+
+// UploadsConfig configures the staging area of two-phase uploads
+type UploadsConfig struct {
+	Dir     string `yaml:"dir"`      // Local directory holding staged uploads (default "<temp>/agfs-uploads")
+	TTL     string `yaml:"ttl"`      // Uncommitted uploads are removed this long after their last chunk (default "24h")
+	MaxSize string `yaml:"max_size"` // Size an upload may grow to (default "1GB")
+}
+
+// BudgetConfig bounds the work of one recursive operation such as a recursive grep;
+// requests can only lower it, and "0" removes a limit
+type BudgetConfig struct {
+	MaxFiles *int64 `yaml:"max_files"` // Files scanned (default 100000)
+	MaxBytes string `yaml:"max_bytes"` // Bytes read (default "1GB")
+	MaxTime  string `yaml:"max_time"`  // Wall time (default "1m")
+}
+
+// JobsConfig configures the background jobs of the /api/v1/jobs endpoints
+type JobsConfig struct {
+	Webhook        string `yaml:"webhook"`         // Notified with the status of every job that ends
+	WebhookTimeout string `yaml:"webhook_timeout"` // Default "10s"
+	Retain         int    `yaml:"retain"`          // Ended jobs kept for status queries (default 100)
+}
+
+// IdempotencyConfig deduplicates retried mutating requests carrying an Idempotency-Key header
+type IdempotencyConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Window     string `yaml:"window"`      // How long retries of a key get the stored response (default "10m")
+	Dir        string `yaml:"dir"`         // Directory of the server keeping the responses, e.g. "/kvfs/keys"; in memory if empty
+	MaxEntries int    `yaml:"max_entries"` // Responses kept in memory (default 10000)
+}
+
+// UsageConfig meters the requests and bytes of each principal, authenticated by auth or
+// tenancy, in daily rollups for chargeback, and optionally bounds them per month
+type UsageConfig struct {
+	Enabled       bool               `yaml:"enabled"`
+	Dir           string             `yaml:"dir"`            // Directory of the server keeping the daily rollups, e.g. "/sqlfs/usage"; in memory if empty
+	FlushInterval string             `yaml:"flush_interval"` // How often the rollups are written (default "1m")
+	Exclude       []string           `yaml:"exclude"`        // URL path prefixes not metered (default ["/api/v1/health", "/livez", "/readyz"])
+	DefaultQuota  UsageQuotaConfig   `yaml:"default_quota"`  // Monthly quota of principals without one (default unlimited)
+	Quotas        []UsageQuotaConfig `yaml:"quotas"`
+}
+
+// UsageQuotaConfig bounds the usage of a principal per calendar month (UTC); requests beyond
+// it fail with 429 until the month ends
+type UsageQuotaConfig struct {
+	Principal string `yaml:"principal"`
+	Bytes     string `yaml:"bytes"`    // Bytes read and written, e.g. "100GB" (default unlimited)
+	Requests  int64  `yaml:"requests"` // Requests (default unlimited)
 }
 
 // PluginConfig can be either a single plugin or an array of plugin instances
+// Use PluginInstances to get the instances of all plugins in one form
 type PluginConfig struct {
-	// For single instance plugins
+	// For single instance plugins; with Instances, Enabled switches all of them
 	Enabled bool   `yaml:"enabled"`
 	Path    string `yaml:"path"`
 	Config  map[string]interface{} `yaml:"config"`
 
-	// For multi-instance plugins (array format)
-	Instances []PluginInstance `yaml:"-"`
+	// For multi-instance plugins (array format, or the instances key)
+	Instances []PluginInstance `yaml:"instances"`
 }
 
 // PluginInstance represents a single instance of a plugin
 type PluginInstance struct {
 	Name    string                 `yaml:"name"`
 	Enabled bool                   `yaml:"enabled"`
-	Path    string                 `yaml:"path"`
+	Path    string                 `yaml:"path"` // May be a template, e.g. "/s3/{{.name}}"
 	Config  map[string]interface{} `yaml:"config"`
+
+	Plugin string `yaml:"-"` // Plugin type, set by PluginInstances
 }
 
 // UnmarshalYAML implements custom unmarshaling to support both single plugin and array formats
@@ -53,6 +477,7 @@ func (p *PluginConfig) UnmarshalYAML(node *yaml.Node) error {
 	// Try to unmarshal as array first
 	var instances []PluginInstance
 	if err := node.Decode(&instances); err == nil && len(instances) > 0 {
+		p.Enabled = true
 		p.Instances = instances
 		return nil
 	}
@@ -63,17 +488,33 @@ func (p *PluginConfig) UnmarshalYAML(node *yaml.Node) error {
 	return node.Decode(aux)
 }
 
-// LoadConfig loads configuration from a YAML file
+// SecretsConfig names the key decrypting the encrypted values of the configuration, see
+// EncryptValue; $AGFS_CONFIG_KEY takes precedence
+type SecretsConfig struct {
+	KeyFile    string `yaml:"key_file"`    // File holding the base64 key
+	KeyCommand string `yaml:"key_command"` // Shell command printing the base64 key, e.g. unwrapping it with KMS or age
+}
+
+// LoadConfig loads configuration from a YAML file, decrypting its encrypted values
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := decryptConfig(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decrypt config file: %w", err)
+	}
+	var cfg Config
+	if len(doc.Content) > 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
 
 	return &cfg, nil
 }
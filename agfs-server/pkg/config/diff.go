@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MountChange is a difference between the mounts of two configurations
+type MountChange struct {
+	Path string
+	Old  *PluginInstance // nil if the mount is added
+	New  *PluginInstance // nil if the mount is removed
+	Keys []string        // Config keys whose values differ, if the mount changed
+}
+
+// Kind returns "added", "removed" or "changed"
+func (c MountChange) Kind() string {
+	switch {
+	case c.Old == nil:
+		return "added"
+	case c.New == nil:
+		return "removed"
+	default:
+		return "changed"
+	}
+}
+
+// String describes the change on one line, prefixed with +, - or ~
+func (c MountChange) String() string {
+	switch c.Kind() {
+	case "added":
+		return fmt.Sprintf("+ %s (%s instance '%s')", c.Path, c.New.Plugin, c.New.Name)
+	case "removed":
+		return fmt.Sprintf("- %s (%s instance '%s')", c.Path, c.Old.Plugin, c.Old.Name)
+	}
+	var what []string
+	if c.Old.Plugin != c.New.Plugin {
+		what = append(what, fmt.Sprintf("plugin %s -> %s", c.Old.Plugin, c.New.Plugin))
+	}
+	if c.Old.Name != c.New.Name {
+		what = append(what, fmt.Sprintf("instance '%s' -> '%s'", c.Old.Name, c.New.Name))
+	}
+	if len(c.Keys) > 0 {
+		what = append(what, "config "+strings.Join(c.Keys, ", "))
+	}
+	return fmt.Sprintf("~ %s (%s instance '%s'): %s", c.Path, c.New.Plugin, c.New.Name, strings.Join(what, "; "))
+}
+
+// DiffMounts compares the enabled plugin instances of configuration from with those of to by
+// mount path and returns the mounts that are added, removed or changed, ordered by path
+func DiffMounts(from, to *Config) ([]MountChange, error) {
+	oldMounts, err := enabledMounts(from)
+	if err != nil {
+		return nil, fmt.Errorf("old configuration: %w", err)
+	}
+	newMounts, err := enabledMounts(to)
+	if err != nil {
+		return nil, fmt.Errorf("new configuration: %w", err)
+	}
+
+	var changes []MountChange
+	for p, o := range oldMounts {
+		n, ok := newMounts[p]
+		if !ok {
+			changes = append(changes, MountChange{Path: p, Old: o})
+			continue
+		}
+		keys := changedKeys(o.Config, n.Config)
+		if o.Plugin != n.Plugin || o.Name != n.Name || len(keys) > 0 {
+			changes = append(changes, MountChange{Path: p, Old: o, New: n, Keys: keys})
+		}
+	}
+	for p, n := range newMounts {
+		if _, ok := oldMounts[p]; !ok {
+			changes = append(changes, MountChange{Path: p, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// enabledMounts returns the enabled instances of cfg by mount path
+func enabledMounts(cfg *Config) (map[string]*PluginInstance, error) {
+	instances, err := cfg.PluginInstances()
+	if err != nil {
+		return nil, err
+	}
+	mounts := make(map[string]*PluginInstance)
+	for i := range instances {
+		if instances[i].Enabled {
+			mounts[instances[i].Path] = &instances[i]
+		}
+	}
+	return mounts, nil
+}
+
+// changedKeys returns the sorted keys that are set in only one of a and b or differ in value
+func changedKeys(a, b map[string]interface{}) []string {
+	var keys []string
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !reflect.DeepEqual(v, w) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
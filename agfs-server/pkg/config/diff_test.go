@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestDiffMounts(t *testing.T) {
+	old := parse(t, `
+plugins:
+  memfs:
+    enabled: true
+    path: /memfs
+  kvfs:
+    enabled: true
+    path: /kvfs
+  sqlfs:
+    - name: a
+      enabled: true
+      path: /sql/a
+      config:
+        backend: sqlite
+        db_path: a.db
+    - name: b
+      enabled: true
+      path: /sql/b
+`)
+	newCfg := parse(t, `
+plugins:
+  memfs:
+    enabled: true
+    path: /memfs
+  kvfs:
+    enabled: false
+    path: /kvfs
+  sqlfs:
+    - name: a
+      enabled: true
+      path: "/sql/{{.name}}"
+      config:
+        backend: sqlite
+        db_path: other.db
+        stats: true
+    - name: b
+      enabled: true
+      path: /sql/b
+  queuefs:
+    enabled: true
+    path: /queue
+`)
+	changes, err := DiffMounts(old, newCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"- /kvfs (kvfs instance 'kvfs')",
+		"+ /queue (queuefs instance 'queuefs')",
+		"~ /sql/a (sqlfs instance 'a'): config db_path, stats",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v", changes)
+	}
+	for i, c := range changes {
+		if c.String() != want[i] {
+			t.Errorf("change %d = %q, want %q", i, c, want[i])
+		}
+	}
+
+	if changes, err := DiffMounts(old, old); err != nil || len(changes) != 0 {
+		t.Errorf("diff with itself = %v, %v", changes, err)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// PluginInstances returns the instances of all plugins in one form, ordered by plugin name
+// and then as configured. A plugin configured as a single instance becomes an instance
+// named after the plugin, instances of a disabled plugin are disabled, and paths are
+// rendered as templates with the fields name, plugin and index (counting from 0), then
+// cleaned, so that "/s3/{{.name}}" mounts each instance under its name.
+func (c *Config) PluginInstances() ([]PluginInstance, error) {
+	names := make([]string, 0, len(c.Plugins))
+	for name := range c.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var instances []PluginInstance
+	var errs []error
+	for _, pluginName := range names {
+		pluginCfg := c.Plugins[pluginName]
+		configured := pluginCfg.Instances
+		if len(configured) == 0 {
+			// Single instance mode: an instance named after the plugin
+			configured = []PluginInstance{{Name: pluginName, Enabled: true, Path: pluginCfg.Path, Config: pluginCfg.Config}}
+		}
+		for i, instance := range configured {
+			instance.Plugin = pluginName
+			instance.Enabled = instance.Enabled && pluginCfg.Enabled
+			if instance.Name == "" {
+				errs = append(errs, fmt.Errorf("plugins.%s: instance %d has no name", pluginName, i))
+				continue
+			}
+			p, err := renderPath(instance.Path, map[string]interface{}{"name": instance.Name, "plugin": pluginName, "index": i})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("plugins.%s: instance '%s': %w", pluginName, instance.Name, err))
+				continue
+			}
+			instance.Path = p
+			instances = append(instances, instance)
+		}
+	}
+	return instances, errors.Join(errs...)
+}
+
+// renderPath expands the template in a mount path and cleans the result
+func renderPath(p string, data map[string]interface{}) (string, error) {
+	if strings.Contains(p, "{{") {
+		tmpl, err := template.New("path").Option("missingkey=error").Parse(p)
+		if err != nil {
+			return "", fmt.Errorf("invalid path template %q: %w", p, err)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("invalid path template %q: %w", p, err)
+		}
+		p = buf.String()
+	}
+	if p == "" {
+		return "", nil
+	}
+	return path.Clean(p), nil
+}
+
+// ValidateConfig checks the plugin instances of cfg: path templates must render, instance
+// names must be unique, and enabled instances need distinct absolute paths. It reports
+// every problem found, joined into one error.
+func ValidateConfig(cfg *Config) error {
+	instances, err := cfg.PluginInstances()
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	names := make(map[string]string)
+	paths := make(map[string]PluginInstance)
+	for _, instance := range instances {
+		if other, ok := names[instance.Name]; ok {
+			errs = append(errs, fmt.Errorf("plugins.%s: instance name '%s' is also used by %s", instance.Plugin, instance.Name, other))
+		} else {
+			names[instance.Name] = instance.Plugin
+		}
+		if !instance.Enabled {
+			continue
+		}
+		switch {
+		case instance.Path == "":
+			errs = append(errs, fmt.Errorf("plugins.%s: instance '%s' has no path", instance.Plugin, instance.Name))
+		case !path.IsAbs(instance.Path):
+			errs = append(errs, fmt.Errorf("plugins.%s: instance '%s': path %q is not absolute", instance.Plugin, instance.Name, instance.Path))
+		default:
+			if other, ok := paths[instance.Path]; ok {
+				errs = append(errs, fmt.Errorf("plugins.%s: instance '%s': path %s is also used by %s instance '%s'",
+					instance.Plugin, instance.Name, instance.Path, other.Plugin, other.Name))
+			} else {
+				paths[instance.Path] = instance
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parse(t *testing.T, data string) *Config {
+	t.Helper()
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &cfg
+}
+
+func TestPluginInstances(t *testing.T) {
+	cfg := parse(t, `
+plugins:
+  memfs:
+    enabled: true
+    path: /memfs/
+  s3fs:
+    - name: aws
+      enabled: true
+      path: "/s3/{{.name}}"
+    - name: minio
+      enabled: false
+      path: "/{{.plugin}}/{{.index}}"
+  sqlfs:
+    enabled: false
+    instances:
+      - name: sqlite
+        enabled: true
+        path: /sqlfs/sqlite
+`)
+	instances, err := cfg.PluginInstances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		plugin, name, path string
+		enabled            bool
+	}{
+		{"memfs", "memfs", "/memfs", true},
+		{"s3fs", "aws", "/s3/aws", true},
+		{"s3fs", "minio", "/s3fs/1", false},
+		{"sqlfs", "sqlite", "/sqlfs/sqlite", false}, // The plugin is disabled
+	}
+	if len(instances) != len(want) {
+		t.Fatalf("instances = %+v", instances)
+	}
+	for i, w := range want {
+		got := instances[i]
+		if got.Plugin != w.plugin || got.Name != w.name || got.Path != w.path || got.Enabled != w.enabled {
+			t.Errorf("instance %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		t.Errorf("ValidateConfig: %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg := parse(t, `
+plugins:
+  memfs:
+    enabled: true
+    path: /data
+  localfs:
+    enabled: true
+    path: data
+  kvfs:
+    enabled: true
+  s3fs:
+    - name: memfs
+      enabled: false
+      path: /s3
+    - name: b
+      enabled: true
+      path: "/s3/{{.bucket}}"
+    - enabled: true
+      path: /s3/c
+  sqlfs:
+    - name: a
+      enabled: true
+      path: /data/
+`)
+	err := ValidateConfig(cfg)
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		"plugins.localfs: instance 'localfs': path \"data\" is not absolute",
+		"plugins.kvfs: instance 'kvfs' has no path",
+		"plugins.s3fs: instance name 'memfs' is also used by memfs",
+		"plugins.s3fs: instance 'b': invalid path template",
+		"plugins.s3fs: instance 2 has no name",
+		"plugins.sqlfs: instance 'a': path /data is also used by memfs instance 'memfs'",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
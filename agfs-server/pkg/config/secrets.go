@@ -0,0 +1,271 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KeyEnv holds the base64 configuration key, overriding the secrets section
+const KeyEnv = "AGFS_CONFIG_KEY"
+
+// KeySize is the size of configuration keys, AES-256
+const KeySize = 32
+
+// DefaultEncryptedKeys matches the names of the values encrypt-config encrypts by default
+const DefaultEncryptedKeys = `(?i)(password|passwd|secret|token|credentials?|dsn|access_key|access_key_id|api_key|private_key|^key)$`
+
+// Encrypted values are sops-style: ENC[AES256_GCM,data:<base64>,iv:<base64>,tag:<base64>,type:<yaml type>]
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:([A-Za-z0-9+/=]*),iv:([A-Za-z0-9+/=]+),tag:([A-Za-z0-9+/=]+),type:(str|int|float|bool)\]$`)
+
+// IsEncrypted reports whether a configuration value is encrypted
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, "ENC[")
+}
+
+// GenerateKey returns a new random configuration key, base64-encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseKey decodes a base64 configuration key
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("configuration key is not base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("configuration key has %d bytes, want %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// LoadKey returns the configuration key of $AGFS_CONFIG_KEY, or else of the command or the
+// file of s
+func LoadKey(s SecretsConfig) ([]byte, error) {
+	if env := os.Getenv(KeyEnv); env != "" {
+		return ParseKey(env)
+	}
+	switch {
+	case s.KeyCommand != "":
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", s.KeyCommand)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("key command: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return ParseKey(string(out))
+	case s.KeyFile != "":
+		data, err := os.ReadFile(s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("key file: %w", err)
+		}
+		return ParseKey(string(data))
+	}
+	return nil, fmt.Errorf("no configuration key: set %s, secrets.key_file or secrets.key_command", KeyEnv)
+}
+
+// listIndex matches the indexes of list items in the paths of values
+var listIndex = regexp.MustCompile(`\[[0-9]+\]`)
+
+// valueAAD returns the additional data binding an encrypted value to the key path of its
+// place in the configuration, such as "plugins.sqlfs.config.password", so that it cannot be
+// moved to another key; list indexes are left out, so list items can be reordered
+func valueAAD(path string) []byte {
+	return []byte(strings.TrimPrefix(listIndex.ReplaceAllString(path, ""), "."))
+}
+
+// EncryptValue encrypts the value of a YAML scalar of type typ (str, int, float or bool)
+// for the key path of the configuration holding it, e.g. "plugins.sqlfs.config.password"
+func EncryptValue(key []byte, path, value, typ string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, []byte(value), valueAAD(path))
+	data, tag := sealed[:len(value)], sealed[len(value):]
+	enc := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(data), enc(iv), enc(tag), typ), nil
+}
+
+// DecryptValue decrypts an encrypted value found at the key path it was encrypted for,
+// returning it and its YAML type
+func DecryptValue(key []byte, path, value string) (string, string, error) {
+	m := encryptedValue.FindStringSubmatch(value)
+	if m == nil {
+		return "", "", fmt.Errorf("malformed encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		if parts[i], err = base64.StdEncoding.DecodeString(m[i+1]); err != nil {
+			return "", "", fmt.Errorf("malformed encrypted value: %w", err)
+		}
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", "", err
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	if len(iv) != gcm.NonceSize() {
+		return "", "", fmt.Errorf("malformed encrypted value: iv has %d bytes", len(iv))
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), valueAAD(path))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot decrypt value, wrong key, value encrypted for another key path, or corrupted data")
+	}
+	return string(plain), m[4], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// hasEncrypted reports whether a scalar under n is encrypted
+func hasEncrypted(n *yaml.Node) bool {
+	if n.Kind == yaml.ScalarNode {
+		return IsEncrypted(n.Value)
+	}
+	for _, c := range n.Content {
+		if hasEncrypted(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptNode replaces the encrypted scalars under n by their values
+func decryptNode(n *yaml.Node, key []byte, path string) error {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if !IsEncrypted(n.Value) {
+			return nil
+		}
+		value, typ, err := DecryptValue(key, path, n.Value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.TrimPrefix(path, "."), err)
+		}
+		n.Value, n.Tag, n.Style = value, "!!"+typ, 0
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := decryptNode(n.Content[i+1], key, path+"."+n.Content[i].Value); err != nil {
+				return err
+			}
+		}
+	default:
+		for i, c := range n.Content {
+			if err := decryptNode(c, key, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decryptConfig decrypts the encrypted values of the configuration document doc with the
+// key of its secrets section
+func decryptConfig(doc *yaml.Node) error {
+	if !hasEncrypted(doc) {
+		return nil
+	}
+	var head struct {
+		Secrets SecretsConfig `yaml:"secrets"`
+	}
+	if err := doc.Decode(&head); err != nil {
+		return err
+	}
+	key, err := LoadKey(head.Secrets)
+	if err != nil {
+		return err
+	}
+	return decryptNode(rootOf(doc), key, "")
+}
+
+// EncryptConfig encrypts the values of the configuration data whose key names match keys,
+// leaving encrypted values and the secrets section as they are; it returns the new
+// configuration and the number of values encrypted
+// Comments are kept, but the document is re-indented.
+func EncryptConfig(data []byte, key []byte, keys *regexp.Regexp) ([]byte, int, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	count := 0
+	var walk func(n *yaml.Node, path string) error
+	walk = func(n *yaml.Node, path string) error {
+		if n.Kind != yaml.MappingNode {
+			for i, c := range n.Content {
+				if err := walk(c, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			name, value := n.Content[i], n.Content[i+1]
+			valuePath := path + "." + name.Value
+			if name.Value == "secrets" && n == rootOf(&doc) {
+				continue
+			}
+			if value.Kind == yaml.ScalarNode && keys.MatchString(name.Value) &&
+				value.Value != "" && !IsEncrypted(value.Value) && value.ShortTag() != "!!null" {
+				typ := strings.TrimPrefix(value.ShortTag(), "!!")
+				if typ != "int" && typ != "float" && typ != "bool" {
+					typ = "str"
+				}
+				enc, err := EncryptValue(key, valuePath, value.Value, typ)
+				if err != nil {
+					return err
+				}
+				value.Value, value.Tag, value.Style = enc, "!!str", 0
+				count++
+				continue
+			}
+			if err := walk(value, valuePath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(rootOf(&doc), ""); err != nil {
+		return nil, 0, err
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, 0, err
+	}
+	enc.Close()
+	return out.Bytes(), count, nil
+}
+
+// rootOf returns the top-level mapping of doc
+func rootOf(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) == 1 {
+		return doc.Content[0]
+	}
+	return doc
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "config.key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ParseKey(key)
+
+	plain := `
+secrets:
+  key_file: ` + keyFile + `
+plugins:
+  sqlfs:
+    enabled: true
+    path: /sqlfs
+    config:
+      backend: tidb
+      password: "s3cr3t"
+      port: 4000
+  s3fs:
+    - name: aws
+      enabled: true
+      path: /s3
+      config:
+        secret_access_key: "abc/def"
+auth:
+  api_keys:
+    - name: ci
+      key: "ci-key"
+`
+	data, count, err := EncryptConfig([]byte(plain), raw, regexp.MustCompile(DefaultEncryptedKeys+`|^port$`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || strings.Contains(string(data), "s3cr3t") || strings.Contains(string(data), "ci-key") || !strings.Contains(string(data), keyFile) {
+		t.Fatalf("encrypted %d values:\n%s", count, data)
+	}
+	again, count, err := EncryptConfig(data, raw, regexp.MustCompile(DefaultEncryptedKeys))
+	if err != nil || count != 0 || string(again) != string(data) {
+		t.Errorf("encrypted values encrypted again: %d, %v", count, err)
+	}
+
+	// Values are decrypted with their types at load time
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlfs := cfg.Plugins["sqlfs"].Config
+	if sqlfs["password"] != "s3cr3t" || sqlfs["port"] != 4000 || sqlfs["backend"] != "tidb" {
+		t.Errorf("sqlfs config = %v", sqlfs)
+	}
+	if got := cfg.Plugins["s3fs"].Instances[0].Config["secret_access_key"]; got != "abc/def" {
+		t.Errorf("s3fs secret = %v", got)
+	}
+	if len(cfg.Auth.APIKeys) != 1 || cfg.Auth.APIKeys[0].Key != "ci-key" {
+		t.Errorf("api keys = %+v", cfg.Auth.APIKeys)
+	}
+
+	// The key can also come from the environment or a command; a wrong key fails to load
+	t.Setenv(KeyEnv, "")
+	if err := os.WriteFile(file, []byte(strings.Replace(string(data), "key_file: "+keyFile, "key_command: cat "+keyFile, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(file); err != nil {
+		t.Errorf("key command: %v", err)
+	}
+	other, _ := GenerateKey()
+	t.Setenv(KeyEnv, other)
+	if _, err := LoadConfig(file); err == nil || !strings.Contains(err.Error(), "plugins.sqlfs.config.password") {
+		t.Errorf("wrong key: %v", err)
+	}
+	// Values only decrypt at the key path they were encrypted for
+	t.Setenv(KeyEnv, key)
+	moved := strings.Replace(string(data), "password:", "user:", 1)
+	if err := os.WriteFile(file, []byte(moved), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(file); err == nil || !strings.Contains(err.Error(), "plugins.sqlfs.config.user") {
+		t.Errorf("moved value: %v", err)
+	}
+	enc, err := EncryptValue(raw, "plugins.s3fs[0].config.secret_access_key", "abc/def", "str")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err := DecryptValue(raw, ".plugins.s3fs[3].config.secret_access_key", enc); err != nil || value != "abc/def" {
+		t.Errorf("value of a reordered list item = %q, %v", value, err)
+	}
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("short key accepted")
+	}
+}
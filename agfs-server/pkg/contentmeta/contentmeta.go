@@ -0,0 +1,179 @@
+package contentmeta
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"  // register GIF for DecodeConfig
+	_ "image/jpeg" // register JPEG for DecodeConfig
+	_ "image/png"  // register PNG for DecodeConfig
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Keys added to FileInfo.Meta.Content
+const (
+	MetaKeyContentType = "content_type" // Detected MIME type
+	MetaKeyLines       = "lines"        // Number of lines, text files only
+	MetaKeyWidth       = "width"        // Image width in pixels
+	MetaKeyHeight      = "height"       // Image height in pixels
+)
+
+const (
+	// sniffSize is how much of a file is read for magic bytes and image headers
+	sniffSize = 64 * 1024
+
+	// maxLineCountSize is the largest file whose lines are counted
+	maxLineCountSize = 1024 * 1024
+
+	// defaultCacheEntries bounds the default detector cache
+	defaultCacheEntries = 4096
+
+	octetStream = "application/octet-stream"
+)
+
+// cacheEntry holds detected metadata for a path at a given size and modification time
+type cacheEntry struct {
+	size    int64
+	modTime time.Time
+	meta    map[string]string
+}
+
+// Detector sniffs file content and caches the result until the file changes
+type Detector struct {
+	mu         sync.Mutex
+	cache      map[string]cacheEntry
+	maxEntries int
+}
+
+// NewDetector creates a detector caching at most maxEntries results
+func NewDetector(maxEntries int) *Detector {
+	return &Detector{
+		cache:      make(map[string]cacheEntry),
+		maxEntries: maxEntries,
+	}
+}
+
+var defaultDetector = NewDetector(defaultCacheEntries)
+
+// Detect returns content metadata for the file at p using the shared detector
+func Detect(fs filesystem.FileSystem, p string, info *filesystem.FileInfo) map[string]string {
+	return defaultDetector.Detect(fs, p, info)
+}
+
+// ContentType returns the detected MIME type of the file at p using the shared detector
+func ContentType(fs filesystem.FileSystem, p string, info *filesystem.FileInfo) string {
+	return defaultDetector.Detect(fs, p, info)[MetaKeyContentType]
+}
+
+// Detect returns content metadata for the file at p
+// Results are cached by path and invalidated when the size or modification time changes
+func (d *Detector) Detect(fs filesystem.FileSystem, p string, info *filesystem.FileInfo) map[string]string {
+	if info.IsDir {
+		return nil
+	}
+
+	d.mu.Lock()
+	entry, ok := d.cache[p]
+	d.mu.Unlock()
+	if ok && entry.size == info.Size && entry.modTime.Equal(info.ModTime) {
+		return entry.meta
+	}
+
+	meta := sniff(fs, p, info)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.cache) >= d.maxEntries {
+		// Evict an arbitrary entry, map iteration order is random
+		for k := range d.cache {
+			delete(d.cache, k)
+			break
+		}
+	}
+	d.cache[p] = cacheEntry{size: info.Size, modTime: info.ModTime, meta: meta}
+	return meta
+}
+
+// sniff reads the head of the file and derives its metadata
+func sniff(fs filesystem.FileSystem, p string, info *filesystem.FileInfo) map[string]string {
+	meta := map[string]string{MetaKeyContentType: TypeByName(p)}
+
+	readSize := int64(sniffSize)
+	if info.Size <= maxLineCountSize {
+		readSize = -1
+	}
+	data, err := fs.Read(p, 0, readSize)
+	if err != nil && err != io.EOF {
+		return meta
+	}
+	full := readSize == -1 || int64(len(data)) >= info.Size
+
+	// Magic bytes win over the extension unless they only say "some text" or "unknown"
+	if len(data) > 0 {
+		sniffed := http.DetectContentType(data)
+		if meta[MetaKeyContentType] == octetStream || !isGeneric(sniffed) {
+			meta[MetaKeyContentType] = sniffed
+		}
+	}
+
+	contentType := meta[MetaKeyContentType]
+	switch {
+	case strings.HasPrefix(contentType, "image/"):
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			meta[MetaKeyWidth] = strconv.Itoa(cfg.Width)
+			meta[MetaKeyHeight] = strconv.Itoa(cfg.Height)
+		}
+	case full && isText(contentType, data):
+		lines := bytes.Count(data, []byte{'\n'})
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			lines++
+		}
+		meta[MetaKeyLines] = strconv.Itoa(lines)
+	}
+
+	return meta
+}
+
+// isGeneric reports whether a sniffed type carries no more information than the extension
+func isGeneric(contentType string) bool {
+	return contentType == octetStream || strings.HasPrefix(contentType, "text/plain")
+}
+
+// IsTextType reports whether contentType describes text content
+func IsTextType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "javascript") ||
+		strings.Contains(contentType, "yaml")
+}
+
+// isText reports whether the content should be treated as text
+func isText(contentType string, data []byte) bool {
+	if IsTextType(contentType) {
+		return true
+	}
+	return contentType == octetStream && utf8.Valid(data) && !bytes.ContainsRune(data, 0)
+}
+
+// Enrich merges meta into info.Meta.Content without modifying the plugin's map
+func Enrich(info *filesystem.FileInfo, meta map[string]string) {
+	if len(meta) == 0 {
+		return
+	}
+	content := make(map[string]string, len(info.Meta.Content)+len(meta))
+	for k, v := range info.Meta.Content {
+		content[k] = v
+	}
+	for k, v := range meta {
+		content[k] = v
+	}
+	info.Meta.Content = content
+}
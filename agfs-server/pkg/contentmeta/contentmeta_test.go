@@ -0,0 +1,100 @@
+package contentmeta
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestDetect(t *testing.T) {
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewGray(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := memfs.NewMemoryFS()
+	for _, c := range []struct {
+		name string
+		data []byte
+		want map[string]string
+	}{
+		// Magic bytes, whatever the name says
+		{"/photo", img.Bytes(), map[string]string{MetaKeyContentType: "image/png", MetaKeyWidth: "3", MetaKeyHeight: "2"}},
+		{"/photo.txt", img.Bytes(), map[string]string{MetaKeyContentType: "image/png", MetaKeyWidth: "3", MetaKeyHeight: "2"}},
+		{"/page", []byte("<!DOCTYPE html><p>hi</p>\n"), map[string]string{MetaKeyContentType: "text/html; charset=utf-8", MetaKeyLines: "1"}},
+
+		// Extensions where the content is only known to be text
+		{"/notes.md", []byte("# Notes\n\n- one\n"), map[string]string{MetaKeyContentType: "text/markdown; charset=utf-8", MetaKeyLines: "3"}},
+		{"/data.json", []byte(`{"a": 1}`), map[string]string{MetaKeyContentType: "application/json; charset=utf-8", MetaKeyLines: "1"}},
+		{"/README", []byte("read me\nplease"), map[string]string{MetaKeyContentType: "text/plain; charset=utf-8", MetaKeyLines: "2"}},
+		{"/scan.png", []byte("not an image"), map[string]string{MetaKeyContentType: "image/png"}},
+
+		// Unknown extensions fall back to the content
+		{"/log.unknownext", []byte("a\nb\nc\n"), map[string]string{MetaKeyContentType: "text/plain; charset=utf-8", MetaKeyLines: "3"}},
+		{"/blob.unknownext", []byte{0, 1, 2, 3}, map[string]string{MetaKeyContentType: octetStream}},
+
+		// Empty and short files
+		{"/empty", nil, map[string]string{MetaKeyContentType: octetStream, MetaKeyLines: "0"}},
+		{"/empty.txt", nil, map[string]string{MetaKeyContentType: "text/plain; charset=utf-8", MetaKeyLines: "0"}},
+		{"/empty.png", nil, map[string]string{MetaKeyContentType: "image/png"}},
+		{"/x", []byte("x"), map[string]string{MetaKeyContentType: "text/plain; charset=utf-8", MetaKeyLines: "1"}},
+		{"/nul", []byte{0}, map[string]string{MetaKeyContentType: octetStream}},
+	} {
+		if _, err := fs.Write(c.name, c.data); err != nil {
+			t.Fatal(err)
+		}
+		info, err := fs.Stat(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := NewDetector(10).Detect(fs, c.name, info)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+			continue
+		}
+		for k, v := range c.want {
+			if got[k] != v {
+				t.Errorf("%s: %v, want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := fs.Stat("/dir"); NewDetector(10).Detect(fs, "/dir", info) != nil {
+		t.Error("directory has content metadata")
+	}
+}
+
+// Results are kept until the size or modification time of the file changes
+func TestDetectCache(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	fs.Write("/f", []byte("a\nb\n"))
+	info, _ := fs.Stat("/f")
+	d := NewDetector(1)
+	if got := d.Detect(fs, "/f", info)[MetaKeyLines]; got != "2" {
+		t.Fatalf("lines = %s", got)
+	}
+
+	fs.Write("/f", []byte("a\nb\nc\n"))
+	if got := d.Detect(fs, "/f", info)[MetaKeyLines]; got != "2" {
+		t.Errorf("lines of an unchanged stat = %s, want the cached 2", got)
+	}
+	info, _ = fs.Stat("/f")
+	if got := d.Detect(fs, "/f", info)[MetaKeyLines]; got != "3" {
+		t.Errorf("lines after a change = %s", got)
+	}
+
+	// The cache is bounded
+	fs.Write("/g", []byte("g"))
+	other, _ := fs.Stat("/g")
+	d.Detect(fs, "/g", other)
+	if len(d.cache) != 1 {
+		t.Errorf("cache of %d entries, limit 1", len(d.cache))
+	}
+}
@@ -0,0 +1,107 @@
+package contentmeta
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// TypeByName determines the Content-Type based on the file name and extension
+// Unknown extensions return octetStream
+func TypeByName(filename string) string {
+	// Get the base filename (without directory)
+	baseName := filepath.Base(filename)
+	baseNameUpper := strings.ToUpper(baseName)
+
+	// Special handling for README files (with or without extension)
+	// These should display as text/plain in the browser
+	if baseNameUpper == "README" ||
+		strings.HasPrefix(baseNameUpper, "README.") {
+		return "text/plain; charset=utf-8"
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+
+	// Common text formats that should display inline
+	textTypes := map[string]string{
+		".txt":      "text/plain; charset=utf-8",
+		".md":       "text/markdown; charset=utf-8",
+		".markdown": "text/markdown; charset=utf-8",
+		".json":     "application/json; charset=utf-8",
+		".xml":      "application/xml; charset=utf-8",
+		".html":     "text/html; charset=utf-8",
+		".htm":      "text/html; charset=utf-8",
+		".css":      "text/css; charset=utf-8",
+		".js":       "application/javascript; charset=utf-8",
+		".yaml":     "text/yaml; charset=utf-8",
+		".yml":      "text/yaml; charset=utf-8",
+		".log":      "text/plain; charset=utf-8",
+		".csv":      "text/csv; charset=utf-8",
+		".sh":       "text/x-shellscript; charset=utf-8",
+		".py":       "text/x-python; charset=utf-8",
+		".go":       "text/x-go; charset=utf-8",
+		".c":        "text/x-c; charset=utf-8",
+		".cpp":      "text/x-c++; charset=utf-8",
+		".h":        "text/x-c; charset=utf-8",
+		".java":     "text/x-java; charset=utf-8",
+		".rs":       "text/x-rust; charset=utf-8",
+		".sql":      "text/x-sql; charset=utf-8",
+	}
+
+	// Image formats
+	imageTypes := map[string]string{
+		".png":  "image/png",
+		".jpg":  "image/jpeg",
+		".jpeg": "image/jpeg",
+		".gif":  "image/gif",
+		".webp": "image/webp",
+		".svg":  "image/svg+xml",
+		".ico":  "image/x-icon",
+		".bmp":  "image/bmp",
+	}
+
+	// Video formats
+	videoTypes := map[string]string{
+		".mp4":  "video/mp4",
+		".webm": "video/webm",
+		".ogg":  "video/ogg",
+		".avi":  "video/x-msvideo",
+		".mov":  "video/quicktime",
+	}
+
+	// Audio formats
+	audioTypes := map[string]string{
+		".mp3":  "audio/mpeg",
+		".wav":  "audio/wav",
+		".ogg":  "audio/ogg",
+		".m4a":  "audio/mp4",
+		".flac": "audio/flac",
+	}
+
+	// PDF
+	if ext == ".pdf" {
+		return "application/pdf"
+	}
+
+	// Check our custom maps first
+	if ct, ok := textTypes[ext]; ok {
+		return ct
+	}
+	if ct, ok := imageTypes[ext]; ok {
+		return ct
+	}
+	if ct, ok := videoTypes[ext]; ok {
+		return ct
+	}
+	if ct, ok := audioTypes[ext]; ok {
+		return ct
+	}
+
+	// Fallback to mime package
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+
+	// Default to octet-stream for unknown types (will trigger download)
+	return octetStream
+}
@@ -0,0 +1,244 @@
+// Package credentials provides the credentials backends authenticate with, e.g. the keys of
+// an S3 bucket or the password of a database, from a configurable source: static values,
+// environment variables, a file, an external helper command or a cloud metadata service.
+// Short-lived credentials are refreshed before they expire.
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Provider types accepted by FromConfig
+const (
+	TypeStatic   = "static"
+	TypeEnv      = "env"
+	TypeFile     = "file"
+	TypeExec     = "exec"
+	TypeMetadata = "metadata"
+)
+
+// DefaultExpiryWindow is how long before they expire credentials are refreshed
+const DefaultExpiryWindow = 5 * time.Minute
+
+// Credentials authenticate a backend; each backend uses the fields that apply to it, e.g.
+// s3fs the access key and proxyfs the token
+type Credentials struct {
+	AccessKeyID     string    `json:"access_key_id,omitempty"`
+	SecretAccessKey string    `json:"secret_access_key,omitempty"`
+	SessionToken    string    `json:"session_token,omitempty"`
+	Username        string    `json:"username,omitempty"`
+	Password        string    `json:"password,omitempty"`
+	Token           string    `json:"token,omitempty"`      // Bearer token
+	Expires         time.Time `json:"expiration,omitempty"` // Zero if they do not expire
+}
+
+// Empty reports whether no field is set
+func (c Credentials) Empty() bool {
+	return c.AccessKeyID == "" && c.SecretAccessKey == "" && c.SessionToken == "" &&
+		c.Username == "" && c.Password == "" && c.Token == ""
+}
+
+// UnmarshalJSON accepts the fields of Credentials and those of the AWS credential_process
+// format (AccessKeyId, SecretAccessKey, SessionToken, Expiration), so AWS helpers work as is
+func (c *Credentials) UnmarshalJSON(data []byte) error {
+	var v struct {
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+		SessionToken    string `json:"session_token"`
+		Username        string `json:"username"`
+		Password        string `json:"password"`
+		Token           string `json:"token"`
+		Expiration      string `json:"expiration"`
+
+		AWSAccessKeyID     string `json:"AccessKeyId"`
+		AWSSecretAccessKey string `json:"SecretAccessKey"`
+		AWSSessionToken    string `json:"SessionToken"`
+		AWSToken           string `json:"Token"` // Instance metadata names the session token Token
+		AWSExpiration      string `json:"Expiration"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	first := func(values ...string) string {
+		for _, s := range values {
+			if s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	*c = Credentials{
+		AccessKeyID:     first(v.AccessKeyID, v.AWSAccessKeyID),
+		SecretAccessKey: first(v.SecretAccessKey, v.AWSSecretAccessKey),
+		SessionToken:    first(v.SessionToken, v.AWSSessionToken, v.AWSToken),
+		Username:        v.Username,
+		Password:        v.Password,
+		Token:           v.Token,
+	}
+	if exp := first(v.Expiration, v.AWSExpiration); exp != "" {
+		t, err := time.Parse(time.RFC3339, exp)
+		if err != nil {
+			return fmt.Errorf("invalid expiration: %w", err)
+		}
+		c.Expires = t
+	}
+	return nil
+}
+
+// Provider retrieves credentials, e.g. from a helper command or a metadata service
+type Provider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// ProviderFunc adapts a function to Provider
+type ProviderFunc func(ctx context.Context) (Credentials, error)
+
+// Retrieve calls f
+func (f ProviderFunc) Retrieve(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// Cache keeps the credentials of a provider until they are about to expire, or for the
+// refresh interval when they do not expire
+type Cache struct {
+	provider Provider
+	refresh  time.Duration // 0 keeps credentials without expiry forever
+	window   time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex // protects creds and fetched
+	creds   Credentials
+	fetched time.Time // Zero until the first retrieval
+}
+
+// NewCache caches the credentials of p; credentials without an expiry are retrieved again
+// after refresh, unless it is 0
+func NewCache(p Provider, refresh time.Duration) *Cache {
+	return &Cache{provider: p, refresh: refresh, window: DefaultExpiryWindow, now: time.Now}
+}
+
+// Retrieve returns the cached credentials, retrieving them again when they are about to
+// expire; a failed refresh returns the error, the cached credentials stay until they expire
+func (c *Cache) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.fetched.IsZero() && !c.stale() {
+		return c.creds, nil
+	}
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		if !c.fetched.IsZero() && (c.creds.Expires.IsZero() || c.now().Before(c.creds.Expires)) {
+			return c.creds, nil
+		}
+		return Credentials{}, err
+	}
+	c.creds, c.fetched = creds, c.now()
+	return creds, nil
+}
+
+// stale reports whether the cached credentials must be retrieved again
+func (c *Cache) stale() bool {
+	now := c.now()
+	if !c.creds.Expires.IsZero() {
+		return !now.Before(c.creds.Expires.Add(-c.window))
+	}
+	return c.refresh > 0 && now.Sub(c.fetched) >= c.refresh
+}
+
+// FromConfig returns the provider configured by cfg, the value of the "credentials" key of
+// a plugin configuration, e.g. {"type": "exec", "command": ["vault-creds", "s3"]}; the
+// provider caches its credentials
+func FromConfig(cfg map[string]interface{}) (Provider, error) {
+	if err := config.ValidateOnlyKnownKeys(cfg, []string{"type", "refresh",
+		"access_key_id", "secret_access_key", "session_token", "username", "password", "token",
+		"prefix", "path", "command", "timeout", "endpoint", "role"}); err != nil {
+		return nil, fmt.Errorf("credentials: %w", err)
+	}
+	refresh, err := config.GetDurationConfig(cfg, "refresh", 0)
+	if err != nil || refresh < 0 {
+		return nil, fmt.Errorf("credentials: invalid refresh")
+	}
+
+	var p Provider
+	switch typ := config.GetStringConfig(cfg, "type", TypeStatic); typ {
+	case TypeStatic:
+		p = Static(Credentials{
+			AccessKeyID:     config.GetStringConfig(cfg, "access_key_id", ""),
+			SecretAccessKey: config.GetStringConfig(cfg, "secret_access_key", ""),
+			SessionToken:    config.GetStringConfig(cfg, "session_token", ""),
+			Username:        config.GetStringConfig(cfg, "username", ""),
+			Password:        config.GetStringConfig(cfg, "password", ""),
+			Token:           config.GetStringConfig(cfg, "token", ""),
+		})
+	case TypeEnv:
+		p = Env(config.GetStringConfig(cfg, "prefix", ""))
+	case TypeFile:
+		path := config.GetStringConfig(cfg, "path", "")
+		if path == "" {
+			return nil, fmt.Errorf("credentials: path is required with type file")
+		}
+		p = File(path)
+	case TypeExec:
+		command, err := commandConfig(cfg["command"])
+		if err != nil {
+			return nil, err
+		}
+		timeout, err := config.GetDurationConfig(cfg, "timeout", DefaultExecTimeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("credentials: invalid timeout")
+		}
+		p = Exec(command, timeout)
+	case TypeMetadata:
+		p = Metadata(config.GetStringConfig(cfg, "endpoint", DefaultMetadataEndpoint), config.GetStringConfig(cfg, "role", ""))
+	default:
+		return nil, fmt.Errorf("credentials: unknown type %q (static, env, file, exec or metadata)", typ)
+	}
+	return NewCache(p, refresh), nil
+}
+
+// commandConfig accepts a command as a list of arguments or a string split on spaces
+func commandConfig(v interface{}) ([]string, error) {
+	var command []string
+	switch c := v.(type) {
+	case string:
+		command = strings.Fields(c)
+	case []string:
+		command = c
+	case []interface{}:
+		for _, arg := range c {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("credentials: command arguments must be strings")
+			}
+			command = append(command, s)
+		}
+	}
+	if len(command) == 0 {
+		return nil, fmt.Errorf("credentials: command is required with type exec")
+	}
+	return command, nil
+}
+
+// PluginProvider returns the provider of a plugin configuration: the "credentials" key if
+// set, otherwise static credentials from fallback, e.g. the access keys of older
+// configurations; it returns nil if neither is set
+func PluginProvider(pluginConfig map[string]interface{}, fallback Credentials) (Provider, error) {
+	if v, ok := pluginConfig["credentials"]; ok && v != nil {
+		cfg, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("credentials must be a map")
+		}
+		return FromConfig(cfg)
+	}
+	if fallback.Empty() {
+		return nil, nil
+	}
+	return Static(fallback), nil
+}
@@ -0,0 +1,161 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheRefresh(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	calls := 0
+	var fail bool
+	c := NewCache(ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		calls++
+		if fail {
+			return Credentials{}, errors.New("helper failed")
+		}
+		return Credentials{Token: "t", Expires: now.Add(time.Hour)}, nil
+	}), 0)
+	c.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	c.Retrieve(ctx)
+	c.Retrieve(ctx)
+	if calls != 1 {
+		t.Fatalf("%d retrievals of fresh credentials, want 1", calls)
+	}
+
+	// Within the expiry window they are retrieved again
+	now = now.Add(56 * time.Minute)
+	c.Retrieve(ctx)
+	if calls != 2 {
+		t.Fatalf("%d retrievals near expiry, want 2", calls)
+	}
+
+	// A failed refresh keeps the credentials until they expire
+	now = now.Add(56 * time.Minute)
+	fail = true
+	if creds, err := c.Retrieve(ctx); err != nil || creds.Token != "t" {
+		t.Errorf("failed refresh before expiry = %+v, %v", creds, err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := c.Retrieve(ctx); err == nil {
+		t.Error("expired credentials returned after a failed refresh")
+	}
+}
+
+func TestCacheRefreshInterval(t *testing.T) {
+	now := time.Now()
+	calls := 0
+	c := NewCache(ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		calls++
+		return Credentials{Password: "p"}, nil
+	}), time.Minute)
+	c.now = func() time.Time { return now }
+	c.Retrieve(context.Background())
+	now = now.Add(30 * time.Second)
+	c.Retrieve(context.Background())
+	now = now.Add(time.Minute)
+	c.Retrieve(context.Background())
+	if calls != 2 {
+		t.Errorf("%d retrievals, want 2", calls)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "creds.json")
+	os.WriteFile(file, []byte(`{"username": "app", "password": "pw"}`), 0600)
+	t.Setenv("TEST_AGFS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("TEST_AGFS_SECRET_ACCESS_KEY", "secret")
+
+	for _, tc := range []struct {
+		cfg  map[string]interface{}
+		want Credentials
+	}{
+		{map[string]interface{}{"type": "static", "token": "abc"}, Credentials{Token: "abc"}},
+		{map[string]interface{}{"type": "env", "prefix": "TEST_AGFS_"}, Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}},
+		{map[string]interface{}{"type": "file", "path": file}, Credentials{Username: "app", Password: "pw"}},
+		{map[string]interface{}{"type": "exec", "command": []interface{}{"echo", `{"Version": 1, "AccessKeyId": "A", "SecretAccessKey": "S", "SessionToken": "T"}`}},
+			Credentials{AccessKeyID: "A", SecretAccessKey: "S", SessionToken: "T"}},
+	} {
+		p, err := FromConfig(tc.cfg)
+		if err != nil {
+			t.Errorf("%v: %v", tc.cfg, err)
+			continue
+		}
+		if got, err := p.Retrieve(ctx); err != nil || got != tc.want {
+			t.Errorf("%v: Retrieve = %+v, %v; want %+v", tc.cfg, got, err, tc.want)
+		}
+	}
+
+	for _, cfg := range []map[string]interface{}{
+		{"type": "vault"},
+		{"type": "exec"},
+		{"type": "file"},
+		{"type": "static", "api_key": "x"},
+		{"type": "static", "refresh": "soon"},
+	} {
+		if _, err := FromConfig(cfg); err == nil {
+			t.Errorf("%v: no error", cfg)
+		}
+	}
+
+	if p, err := PluginProvider(map[string]interface{}{}, Credentials{}); p != nil || err != nil {
+		t.Errorf("PluginProvider without credentials = %v, %v", p, err)
+	}
+	p, _ := PluginProvider(map[string]interface{}{}, Credentials{AccessKeyID: "legacy"})
+	if got, _ := p.Retrieve(ctx); got.AccessKeyID != "legacy" {
+		t.Errorf("PluginProvider fallback = %+v", got)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("session"))
+		case r.Header.Get("X-aws-ec2-metadata-token") != "session":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("app-role\n"))
+		case r.URL.Path == "/latest/meta-data/iam/security-credentials/app-role":
+			w.Write([]byte(`{"Code": "Success", "AccessKeyId": "A", "SecretAccessKey": "S", "Token": "T", "Expiration": "2030-01-01T00:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	creds, err := Metadata(srv.URL, "").Retrieve(context.Background())
+	want := Credentials{AccessKeyID: "A", SecretAccessKey: "S", SessionToken: "T", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err != nil || creds != want {
+		t.Errorf("Retrieve = %+v, %v", creds, err)
+	}
+	if _, err := Metadata(srv.URL, "other").Retrieve(context.Background()); err == nil {
+		t.Error("unknown role: no error")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: Transport(Static(Credentials{Token: "abc"}), nil)}
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer abc" {
+		t.Errorf("Authorization = %q", got)
+	}
+}
@@ -0,0 +1,37 @@
+package credentials
+
+import "net/http"
+
+// Transport returns a round tripper authenticating the requests of base with the
+// credentials of p: the token as a bearer token, else the username and password with basic
+// authentication; a nil base uses http.DefaultTransport
+func Transport(p Provider, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{provider: p, base: base}
+}
+
+type transport struct {
+	provider Provider
+	base     http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	creds, err := t.provider.Retrieve(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	switch {
+	case creds.Token != "":
+		req.Header.Set("Authorization", "Bearer "+creds.Token)
+	case creds.Username != "" || creds.Password != "":
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	return t.base.RoundTrip(req)
+}
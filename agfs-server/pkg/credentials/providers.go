@@ -0,0 +1,146 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout limits a run of the helper command of an exec provider
+const DefaultExecTimeout = 30 * time.Second
+
+// DefaultMetadataEndpoint is the instance metadata service of EC2
+const DefaultMetadataEndpoint = "http://169.254.169.254"
+
+// metadataTokenTTL is the lifetime requested for IMDSv2 session tokens
+const metadataTokenTTL = "21600"
+
+// Static returns credentials that never change
+func Static(creds Credentials) Provider {
+	return ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		return creds, nil
+	})
+}
+
+// Env reads credentials from the environment variables <prefix>ACCESS_KEY_ID,
+// <prefix>SECRET_ACCESS_KEY, <prefix>SESSION_TOKEN, <prefix>USERNAME, <prefix>PASSWORD and
+// <prefix>TOKEN, e.g. with prefix "AWS_" the variables of the AWS tools
+func Env(prefix string) Provider {
+	return ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		creds := Credentials{
+			AccessKeyID:     os.Getenv(prefix + "ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv(prefix + "SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv(prefix + "SESSION_TOKEN"),
+			Username:        os.Getenv(prefix + "USERNAME"),
+			Password:        os.Getenv(prefix + "PASSWORD"),
+			Token:           os.Getenv(prefix + "TOKEN"),
+		}
+		if creds.Empty() {
+			return Credentials{}, fmt.Errorf("no credentials in the %s* environment variables", prefix)
+		}
+		return creds, nil
+	})
+}
+
+// File reads credentials from a JSON file, e.g. one a sidecar keeps up to date; with a
+// refresh interval the file is read again after it
+func File(path string) Provider {
+	return ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("credentials file: %w", err)
+		}
+		var creds Credentials
+		if err := json.Unmarshal(data, &creds); err != nil {
+			return Credentials{}, fmt.Errorf("credentials file %s: %w", path, err)
+		}
+		return creds, nil
+	})
+}
+
+// Exec runs a helper command printing credentials as JSON on its standard output, in the
+// format of Credentials or of the AWS credential_process
+func Exec(command []string, timeout time.Duration) Provider {
+	return ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return Credentials{}, fmt.Errorf("credentials helper %s: %w: %s", command[0], err, strings.TrimSpace(stderr.String()))
+		}
+		var creds Credentials
+		if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+			return Credentials{}, fmt.Errorf("credentials helper %s: %w", command[0], err)
+		}
+		return creds, nil
+	})
+}
+
+// Metadata retrieves the temporary credentials of the instance role from an EC2 compatible
+// instance metadata service (IMDSv2); without a role the first one of the instance is used
+func Metadata(endpoint, role string) Provider {
+	client := &http.Client{Timeout: 5 * time.Second}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	return ProviderFunc(func(ctx context.Context) (Credentials, error) {
+		token, err := metadataRequest(ctx, client, http.MethodPut, endpoint+"/latest/api/token", "")
+		if err != nil {
+			return Credentials{}, err
+		}
+		base := endpoint + "/latest/meta-data/iam/security-credentials/"
+		if role == "" {
+			roles, err := metadataRequest(ctx, client, http.MethodGet, base, token)
+			if err != nil {
+				return Credentials{}, err
+			}
+			role = strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+			if role == "" {
+				return Credentials{}, fmt.Errorf("instance metadata: no role attached to the instance")
+			}
+		}
+		body, err := metadataRequest(ctx, client, http.MethodGet, base+role, token)
+		if err != nil {
+			return Credentials{}, err
+		}
+		var creds Credentials
+		if err := json.Unmarshal([]byte(body), &creds); err != nil {
+			return Credentials{}, fmt.Errorf("instance metadata: %w", err)
+		}
+		return creds, nil
+	})
+}
+
+// metadataRequest sends a request to the metadata service; PUT requests a session token,
+// which the other requests carry
+func metadataRequest(ctx context.Context, client *http.Client, method, url, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if method == http.MethodPut {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", metadataTokenTTL)
+	} else {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("instance metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", fmt.Errorf("instance metadata: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata: %s returned HTTP %d", url, resp.StatusCode)
+	}
+	return string(body), nil
+}
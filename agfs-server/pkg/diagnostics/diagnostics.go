@@ -0,0 +1,197 @@
+// Package diagnostics serves the Go runtime profiles and variables of the server under
+// /debug, the variables also in the Prometheus format, and saves profiles to a directory
+// of the server for later retrieval
+package diagnostics
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"path"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of POST /debug/dump
+const (
+	DefaultDumpProfiles = "goroutine,heap"
+	DefaultCPUSeconds   = 10
+	maxCPUSeconds       = 300
+)
+
+// DumpResponse lists the profiles written by POST /debug/dump
+type DumpResponse struct {
+	Files []string `json:"files"`
+}
+
+// Diagnostics serves /debug to requests bearing its token, or authorized by SetAuthorizer
+type Diagnostics struct {
+	token     string
+	authorize func(r *http.Request) bool
+	dumpDir   string
+	fs        filesystem.FileSystem
+	mux       *http.ServeMux
+}
+
+// New creates the diagnostics endpoints from cfg; fs receives the profiles of /debug/dump
+func New(cfg config.DebugConfig, fs filesystem.FileSystem) (*Diagnostics, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("debug: token is required")
+	}
+	d := &Diagnostics{token: cfg.Token, fs: fs, mux: http.NewServeMux()}
+	if cfg.DumpDir != "" {
+		d.dumpDir = filesystem.NormalizePath(cfg.DumpDir)
+	}
+	d.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	d.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	d.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	d.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	d.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	d.mux.Handle("/debug/vars", expvar.Handler())
+	d.mux.HandleFunc("/debug/metrics", d.metrics)
+	d.mux.HandleFunc("/debug/dump", d.dump)
+	return d, nil
+}
+
+// SetAuthorizer lets the requests authorize accepts in without the token, e.g. those of
+// principals with the admin role when auth, which takes the same Authorization header,
+// authenticates the requests first
+func (d *Diagnostics) SetAuthorizer(authorize func(r *http.Request) bool) {
+	d.authorize = authorize
+}
+
+// Middleware serves the requests below /debug/ and passes the others to next
+func (d *Diagnostics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !d.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agfs-debug"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		d.mux.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r bears the token as "Authorization: Bearer <token>" or is
+// accepted by the authorizer
+func (d *Diagnostics) authorized(r *http.Request) bool {
+	if d.authorize != nil && d.authorize(r) {
+		return true
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return false
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+	return subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) == 1
+}
+
+// dump handles POST /debug/dump?dir=<dir>&profiles=<names>&seconds=<n>
+// It captures the named profiles (runtime/pprof profiles, or cpu for seconds) and writes
+// each to <dir>/<time>-<name>.pprof
+func (d *Diagnostics) dump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	dir := d.dumpDir
+	if v := q.Get("dir"); v != "" {
+		dir = filesystem.NormalizePath(v)
+	}
+	if dir == "" {
+		writeError(w, http.StatusBadRequest, "dir parameter is required without dump_dir")
+		return
+	}
+	names := q.Get("profiles")
+	if names == "" {
+		names = DefaultDumpProfiles
+	}
+	seconds := DefaultCPUSeconds
+	if v := q.Get("seconds"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxCPUSeconds {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("seconds must be between 1 and %d", maxCPUSeconds))
+			return
+		}
+		seconds = n
+	}
+
+	var profiles []string
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name != "cpu" && runtimepprof.Lookup(name) == nil {
+			writeError(w, http.StatusBadRequest, "unknown profile: "+name)
+			return
+		}
+		profiles = append(profiles, name)
+	}
+
+	if err := filesystem.MkdirAll(d.fs, dir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	stamp := time.Now().UTC().Format("20060102T150405")
+	resp := DumpResponse{Files: []string{}}
+	for _, name := range profiles {
+		data, err := capture(r, name, seconds)
+		if err != nil {
+			writeError(w, http.StatusConflict, fmt.Sprintf("%s profile: %v", name, err))
+			return
+		}
+		file := path.Join(dir, fmt.Sprintf("%s-%s.pprof", stamp, name))
+		if _, err := d.fs.Write(file, data); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("write %s: %v", file, err))
+			return
+		}
+		resp.Files = append(resp.Files, file)
+	}
+	log.Infof("[debug] wrote profiles %s", strings.Join(resp.Files, ", "))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// capture returns the named profile in the protobuf format of go tool pprof
+func capture(r *http.Request, name string, seconds int) ([]byte, error) {
+	var buf bytes.Buffer
+	if name != "cpu" {
+		if name == "heap" {
+			runtime.GC()
+		}
+		err := runtimepprof.Lookup(name).WriteTo(&buf, 0)
+		return buf.Bytes(), err
+	}
+	if err := runtimepprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	select {
+	case <-time.After(time.Duration(seconds) * time.Second):
+	case <-r.Context().Done():
+	}
+	runtimepprof.StopCPUProfile()
+	return buf.Bytes(), r.Context().Err()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,107 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestDiagnostics(t *testing.T) {
+	if _, err := New(config.DebugConfig{Enabled: true}, nil); err == nil {
+		t.Error("configuration without token accepted")
+	}
+
+	fs := memfs.NewMemoryFS()
+	d, err := New(config.DebugConfig{Enabled: true, Token: "secret", DumpDir: "/debug/profiles"}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	server := httptest.NewServer(d.Middleware(next))
+	defer server.Close()
+
+	do := func(method, url, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, c := range []struct {
+		method, url, token string
+		want               int
+	}{
+		{"GET", "/api/v1/health", "", http.StatusTeapot},
+		{"GET", "/debug/vars", "", http.StatusUnauthorized},
+		{"GET", "/debug/vars", "wrong", http.StatusUnauthorized},
+		{"GET", "/debug/vars", "secret", http.StatusOK},
+		{"GET", "/debug/metrics", "secret", http.StatusOK},
+		{"POST", "/debug/metrics", "secret", http.StatusMethodNotAllowed},
+		{"GET", "/debug/pprof/goroutine", "secret", http.StatusOK},
+		{"GET", "/debug/dump", "secret", http.StatusMethodNotAllowed},
+		{"POST", "/debug/dump?profiles=nope", "secret", http.StatusBadRequest},
+	} {
+		resp := do(c.method, c.url, c.token)
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("%s %s: status %d, want %d", c.method, c.url, resp.StatusCode, c.want)
+		}
+	}
+
+	resp := do("POST", "/debug/dump", "secret")
+	var dump DumpResponse
+	err = json.NewDecoder(resp.Body).Decode(&dump)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(dump.Files) != 2 {
+		t.Fatalf("dump = %d %+v, %v", resp.StatusCode, dump, err)
+	}
+	for _, file := range dump.Files {
+		if info, err := fs.Stat(file); err != nil || info.Size == 0 {
+			t.Errorf("%s: %+v, %v", file, info, err)
+		}
+	}
+}
+
+// The expvar registry is flattened into names and labels
+func TestSnapshot(t *testing.T) {
+	metrics := plugin.Services{}.NewInitContext("proxyfs", "/remote", nil).Metrics
+	metrics.Set("remotes", expvar.Func(func() any {
+		return map[string]map[string]any{"http://a:8080/api/v1": {"requests": 3, "latencyP50Ms": 1.5}}
+	}))
+	metrics.Set("healthy", expvar.Func(func() any { return true }))
+
+	text := string(TakeSnapshot().Prometheus())
+	for _, want := range []string{
+		"# TYPE agfs_plugins_remotes_requests untyped\n",
+		`agfs_plugins_remotes_requests{mount="/remote",remote="http://a:8080/api/v1"} 3` + "\n",
+		`agfs_plugins_remotes_latency_p50_ms{mount="/remote",remote="http://a:8080/api/v1"} 1.5` + "\n",
+		`agfs_plugins_healthy{mount="/remote"} 1` + "\n",
+		"\nagfs_memstats_heap_alloc ",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "cmdline") || strings.Contains(text, "pause_ns") {
+		t.Errorf("strings or arrays exported:\n%s", text)
+	}
+
+	for in, want := range map[string]string{"HeapAlloc": "heap_alloc", "NumGC": "num_gc", "HTTPRequests": "http_requests", "latencyP50Ms": "latency_p50_ms", "bytes-sent": "bytes_sent"} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package diagnostics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MetricsPrefix is the prefix of the Prometheus names of the metrics
+const MetricsPrefix = "agfs_"
+
+// Sample is one numeric value of the expvar registry
+type Sample struct {
+	Name   string            `json:"name"`             // Prometheus name, e.g. "agfs_memstats_heap_alloc"
+	Labels map[string]string `json:"labels,omitempty"` // e.g. {"mount": "/remote"}
+	Value  float64           `json:"value"`
+}
+
+// Snapshot is the content of the registry at a point in time
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Samples []Sample  `json:"samples"`
+}
+
+// nameSegment matches the keys that become part of a metric name; other keys, such as
+// mount paths or URLs, become labels
+var nameSegment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// TakeSnapshot flattens the numeric and boolean values of the expvar registry, the one
+// served at /debug/vars: nested keys are joined into snake_case names, the mounts of
+// "plugins" become a mount label and other keys that are not identifiers a label named
+// after their parent, e.g. remote for the URLs of "remotes"; strings and arrays are left out
+func TakeSnapshot() Snapshot {
+	s := Snapshot{Time: time.Now()}
+	expvar.Do(func(kv expvar.KeyValue) {
+		dec := json.NewDecoder(strings.NewReader(kv.Value.String()))
+		dec.UseNumber()
+		var v interface{}
+		if dec.Decode(&v) != nil {
+			return
+		}
+		s.Samples = flatten(s.Samples, []string{snakeCase(kv.Key)}, kv.Key, nil, v)
+	})
+	sort.SliceStable(s.Samples, func(i, j int) bool { return s.Samples[i].Name < s.Samples[j].Name })
+	return s
+}
+
+// flatten appends the samples of v, the value of the key parent, to out
+func flatten(out []Sample, name []string, parent string, labels map[string]string, v interface{}) []Sample {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return out
+		}
+		return append(out, Sample{Name: MetricsPrefix + strings.Join(name, "_"), Labels: labels, Value: f})
+	case bool:
+		f := 0.0
+		if v {
+			f = 1
+		}
+		return append(out, Sample{Name: MetricsPrefix + strings.Join(name, "_"), Labels: labels, Value: f})
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if nameSegment.MatchString(k) && parent != "plugins" {
+				out = flatten(out, append(name[:len(name):len(name)], snakeCase(k)), k, labels, v[k])
+				continue
+			}
+			label := "mount"
+			if parent != "plugins" {
+				label = snakeCase(strings.TrimSuffix(parent, "s"))
+			}
+			child := make(map[string]string, len(labels)+1)
+			for lk, lv := range labels {
+				child[lk] = lv
+			}
+			child[label] = k
+			out = flatten(out, name, k, child, v[k])
+		}
+	}
+	return out
+}
+
+// snakeCase turns an expvar key such as "HeapAlloc" or "latencyP50Ms" into "heap_alloc" or
+// "latency_p50_ms", replacing characters not allowed in Prometheus names with "_"
+func snakeCase(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		switch {
+		case c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)):
+			b.WriteRune(unicode.ToLower(c))
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// Prometheus renders the snapshot in the Prometheus text exposition format; the values of
+// expvar do not say whether they are counters, so every metric is untyped
+func (s Snapshot) Prometheus() []byte {
+	var b bytes.Buffer
+	last := ""
+	for _, sample := range s.Samples {
+		if sample.Name != last {
+			fmt.Fprintf(&b, "# TYPE %s untyped\n", sample.Name)
+			last = sample.Name
+		}
+		b.WriteString(sample.Name)
+		if len(sample.Labels) > 0 {
+			names := make([]string, 0, len(sample.Labels))
+			for name := range sample.Labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for i, name := range names {
+				sep := ","
+				if i == 0 {
+					sep = "{"
+				}
+				fmt.Fprintf(&b, "%s%s=%s", sep, name, strconv.Quote(sample.Labels[name]))
+			}
+			b.WriteByte('}')
+		}
+		fmt.Fprintf(&b, " %s\n", strconv.FormatFloat(sample.Value, 'g', -1, 64))
+	}
+	return b.Bytes()
+}
+
+// metrics handles GET /debug/metrics, the registry of /debug/vars in the Prometheus format
+func (d *Diagnostics) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(TakeSnapshot().Prometheus())
+}
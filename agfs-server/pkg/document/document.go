@@ -0,0 +1,548 @@
+// Package document reads and modifies parts of JSON and YAML files addressed by JSON
+// Pointers (RFC 6901), with JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902)
+package document
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the encoding of a document
+type Format string
+
+// Supported formats
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// ErrTestFailed is returned when a "test" operation of a JSON Patch does not match
+var ErrTestFailed = errors.New("patch test failed")
+
+// ParseFormat parses a format name; an empty name picks the format from the extension of
+// file, JSON unless it ends with .yaml or .yml
+func ParseFormat(name, file string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "":
+		switch strings.ToLower(path.Ext(file)) {
+		case ".yaml", ".yml":
+			return FormatYAML, nil
+		}
+		return FormatJSON, nil
+	case "json":
+		return FormatJSON, nil
+	case "yaml", "yml":
+		return FormatYAML, nil
+	}
+	return "", filesystem.NewInvalidArgumentError("format", name, "expected json or yaml")
+}
+
+// Document is a decoded JSON or YAML file
+// Values are nil, bool, string, json.Number, []interface{} and map[string]interface{}
+type Document struct {
+	Format Format
+	Root   interface{}
+
+	indent bool // Re-encode JSON indented, as it was read
+}
+
+// Parse decodes data; empty data is an empty object, so patches can create a new file
+func Parse(data []byte, format Format) (*Document, error) {
+	doc := &Document{Format: format}
+	if len(bytes.TrimSpace(data)) == 0 {
+		doc.Root = map[string]interface{}{}
+		doc.indent = true
+		return doc, nil
+	}
+
+	var root interface{}
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&root); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("document", "", "invalid JSON: "+err.Error())
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, filesystem.NewInvalidArgumentError("document", "", "invalid JSON: data after the top-level value")
+		}
+		doc.indent = bytes.Contains(bytes.TrimSpace(data), []byte("\n"))
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("document", "", "invalid YAML: "+err.Error())
+		}
+	default:
+		return nil, filesystem.NewInvalidArgumentError("format", format, "expected json or yaml")
+	}
+	var err error
+	if doc.Root, err = normalize(root); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ParseValue decodes a JSON value, e.g. a request body
+func ParseValue(data []byte) (interface{}, error) {
+	doc, err := Parse(data, FormatJSON)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, filesystem.NewInvalidArgumentError("body", "", "a JSON value is required")
+	}
+	return doc.Root, nil
+}
+
+// Encode encodes the document in its format
+// YAML comments and key order are not preserved, JSON objects are written with sorted keys
+func (d *Document) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if d.Format == FormatYAML {
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(yamlValue(d.Root)); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if d.indent {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(d.Root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Get returns the value at pointer
+func (d *Document) Get(pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return get(d.Root, tokens, pointer)
+}
+
+// Merge applies a JSON Merge Patch to the value at pointer, which is created if missing
+// together with missing parent objects
+func (d *Document) Merge(pointer string, patch interface{}) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+	node := d.Root
+	for _, t := range tokens[:max(len(tokens)-1, 0)] {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			break
+		}
+		if _, ok := m[t]; !ok {
+			m[t] = map[string]interface{}{}
+		}
+		node = m[t]
+	}
+	target, err := get(d.Root, tokens, pointer)
+	if err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+		return err
+	}
+	d.Root, err = add(d.Root, tokens, mergePatch(target, patch), pointer)
+	return err
+}
+
+// Operation is an operation of a JSON Patch
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ParsePatch decodes a JSON Patch, an array of operations
+func ParsePatch(data []byte) ([]Operation, error) {
+	value, err := ParseValue(data)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, filesystem.NewInvalidArgumentError("patch", "", "a JSON Patch is an array of operations")
+	}
+	ops := make([]Operation, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, filesystem.NewInvalidArgumentError("patch", i, "operation is not an object")
+		}
+		op := Operation{Value: m["value"]}
+		for key, dst := range map[string]*string{"op": &op.Op, "path": &op.Path, "from": &op.From} {
+			if v, ok := m[key]; ok {
+				if *dst, ok = v.(string); !ok {
+					return nil, filesystem.NewInvalidArgumentError("patch", i, key+" is not a string")
+				}
+			}
+		}
+		if _, ok := m["path"]; !ok {
+			return nil, filesystem.NewInvalidArgumentError("patch", i, "path is required")
+		}
+		if _, ok := m["value"]; !ok && (op.Op == "add" || op.Op == "replace" || op.Op == "test") {
+			return nil, filesystem.NewInvalidArgumentError("patch", i, op.Op+" requires a value")
+		}
+		ops[i] = op
+	}
+	return ops, nil
+}
+
+// Apply applies a JSON Patch; the document is unchanged if an operation fails
+func (d *Document) Apply(ops []Operation) error {
+	root := clone(d.Root)
+	for i, op := range ops {
+		tokens, err := parsePointer(op.Path)
+		if err != nil {
+			return err
+		}
+		switch op.Op {
+		case "add":
+			root, err = add(root, tokens, clone(op.Value), op.Path)
+		case "remove":
+			root, _, err = remove(root, tokens, op.Path)
+		case "replace":
+			if len(tokens) == 0 {
+				root = clone(op.Value)
+			} else if root, _, err = remove(root, tokens, op.Path); err == nil {
+				root, err = add(root, tokens, clone(op.Value), op.Path)
+			}
+		case "move", "copy":
+			var from []string
+			if from, err = parsePointer(op.From); err != nil {
+				return err
+			}
+			if op.Op == "move" && len(from) < len(tokens) && strings.Join(from, "/") == strings.Join(tokens[:len(from)], "/") {
+				return filesystem.NewInvalidArgumentError("patch", i, "cannot move a value into itself")
+			}
+			var value interface{}
+			if op.Op == "move" {
+				root, value, err = remove(root, from, op.From)
+			} else {
+				value, err = get(root, from, op.From)
+				value = clone(value)
+			}
+			if err == nil {
+				root, err = add(root, tokens, value, op.Path)
+			}
+		case "test":
+			var value interface{}
+			if value, err = get(root, tokens, op.Path); err == nil && !equal(value, op.Value) {
+				err = fmt.Errorf("%w: value at %q differs", ErrTestFailed, op.Path)
+			}
+		default:
+			return filesystem.NewInvalidArgumentError("patch", i, fmt.Sprintf("unknown op %q", op.Op))
+		}
+		if err != nil {
+			return fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	d.Root = root
+	return nil
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, filesystem.NewInvalidArgumentError("pointer", pointer, "must be empty or start with /")
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// index parses an array index; with end, "-" and the array length are accepted for insertions
+func index(token string, length int, end bool, pointer string) (int, error) {
+	if end && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, filesystem.NewInvalidArgumentError("pointer", pointer, fmt.Sprintf("invalid array index %q", token))
+	}
+	if i > length || (i == length && !end) {
+		return 0, filesystem.NewNotFoundError("pointer", pointer)
+	}
+	return i, nil
+}
+
+func get(node interface{}, tokens []string, pointer string) (interface{}, error) {
+	for _, t := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			v, ok := n[t]
+			if !ok {
+				return nil, filesystem.NewNotFoundError("pointer", pointer)
+			}
+			node = v
+		case []interface{}:
+			i, err := index(t, len(n), false, pointer)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, filesystem.NewNotFoundError("pointer", pointer)
+		}
+	}
+	return node, nil
+}
+
+// add sets the member at tokens or inserts into an array, and returns the new node
+func add(node interface{}, tokens []string, value interface{}, pointer string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	key, rest := tokens[0], tokens[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[key] = value
+			return n, nil
+		}
+		child, ok := n[key]
+		if !ok {
+			return nil, filesystem.NewNotFoundError("pointer", pointer)
+		}
+		child, err := add(child, rest, value, pointer)
+		if err != nil {
+			return nil, err
+		}
+		n[key] = child
+		return n, nil
+	case []interface{}:
+		i, err := index(key, len(n), len(rest) == 0, pointer)
+		if err != nil {
+			return nil, err
+		}
+		if len(rest) == 0 {
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		if n[i], err = add(n[i], rest, value, pointer); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, filesystem.NewNotFoundError("pointer", pointer)
+}
+
+// remove deletes the value at tokens and returns the new node and the removed value
+func remove(node interface{}, tokens []string, pointer string) (interface{}, interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, nil, filesystem.NewInvalidArgumentError("pointer", pointer, "cannot remove the whole document")
+	}
+	key, rest := tokens[0], tokens[1:]
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[key]
+		if !ok {
+			return nil, nil, filesystem.NewNotFoundError("pointer", pointer)
+		}
+		if len(rest) == 0 {
+			delete(n, key)
+			return n, child, nil
+		}
+		child, removed, err := remove(child, rest, pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[key] = child
+		return n, removed, nil
+	case []interface{}:
+		i, err := index(key, len(n), false, pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		child, removed, err := remove(n[i], rest, pointer)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = child
+		return n, removed, nil
+	}
+	return nil, nil, filesystem.NewNotFoundError("pointer", pointer)
+}
+
+// mergePatch applies patch to target as described by RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return clone(patch)
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// normalize converts decoded YAML to the value types of a Document
+func normalize(v interface{}) (interface{}, error) {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		for k, child := range n {
+			c, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			n[k] = c
+		}
+		return n, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			c, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = c
+		}
+		return m, nil
+	case []interface{}:
+		for i, child := range n {
+			c, err := normalize(child)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = c
+		}
+		return n, nil
+	case int:
+		return json.Number(strconv.Itoa(n)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(n, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(n, 'g', -1, 64)), nil
+	case nil, bool, string, json.Number:
+		return n, nil
+	}
+	return nil, filesystem.NewInvalidArgumentError("document", "", fmt.Sprintf("unsupported value of type %T", v))
+}
+
+// yamlValue converts numbers back to YAML numbers, yaml.Marshal would quote a json.Number
+func yamlValue(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			m[k] = yamlValue(child)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, child := range n {
+			l[i] = yamlValue(child)
+		}
+		return l
+	case json.Number:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+		return n.String()
+	}
+	return v
+}
+
+// clone deep copies a value
+func clone(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			m[k] = clone(child)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, child := range n {
+			l[i] = clone(child)
+		}
+		return l
+	}
+	return v
+}
+
+// equal compares values as JSON Patch "test" does, numbers by value
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		i, err1 := x.Int64()
+		j, err2 := y.Int64()
+		if err1 == nil && err2 == nil {
+			return i == j
+		}
+		f, err1 := x.Float64()
+		g, err2 := y.Float64()
+		return err1 == nil && err2 == nil && f == g
+	}
+	return a == b
+}
@@ -0,0 +1,96 @@
+package document
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, doc *Document) string {
+	t.Helper()
+	data, err := doc.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func encodeValue(t *testing.T, v interface{}) string {
+	return encode(t, &Document{Format: FormatJSON, Root: v})
+}
+
+func TestGetAndMerge(t *testing.T) {
+	doc, err := Parse([]byte(`{"a": {"b": 1, "c/d": [1, 2.5, "x"]}, "big": 12345678901234567890}`), FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pointer, want := range map[string]string{"/a/b": "1", "/a/c~1d/1": "2.5", "/a/c~1d/2": "x", "/big": "12345678901234567890"} {
+		v, err := doc.Get(pointer)
+		if err != nil {
+			t.Errorf("get %s: %v", pointer, err)
+		} else if got := strings.Trim(encodeValue(t, v), "\"\n"); got != want {
+			t.Errorf("get %s = %s, want %s", pointer, got, want)
+		}
+	}
+	for _, pointer := range []string{"/missing", "/a/c~1d/3", "/a/c~1d/01", "a"} {
+		if _, err := doc.Get(pointer); err == nil {
+			t.Errorf("get %s succeeded", pointer)
+		}
+	}
+
+	patch, _ := ParseValue([]byte(`{"b": null, "e": {"f": true}}`))
+	if err := doc.Merge("/a", patch); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := ParseValue([]byte(`"on"`))
+	if err := doc.Merge("/mode", value); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":{"c/d":[1,2.5,"x"],"e":{"f":true}},"big":12345678901234567890,"mode":"on"}` + "\n"
+	if got := encode(t, doc); got != want {
+		t.Errorf("merged = %s, want %s", got, want)
+	}
+}
+
+func TestPatch(t *testing.T) {
+	doc, err := Parse([]byte("name: app\nreplicas: 2\nports:\n  - 80\n  - 443\n"), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err := ParsePatch([]byte(`[
+		{"op": "test", "path": "/replicas", "value": 2},
+		{"op": "replace", "path": "/replicas", "value": 3},
+		{"op": "add", "path": "/ports/-", "value": 8080},
+		{"op": "remove", "path": "/ports/0"},
+		{"op": "copy", "from": "/name", "path": "/label"},
+		{"op": "move", "from": "/label", "path": "/id"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Apply(ops); err != nil {
+		t.Fatal(err)
+	}
+	want := "id: app\nname: app\nports:\n  - 443\n  - 8080\nreplicas: 3\n"
+	if got := encode(t, doc); got != want {
+		t.Errorf("patched =\n%s\nwant\n%s", got, want)
+	}
+
+	// A failed operation leaves the document unchanged
+	ops, _ = ParsePatch([]byte(`[{"op": "remove", "path": "/name"}, {"op": "test", "path": "/replicas", "value": 4}]`))
+	if err := doc.Apply(ops); !errors.Is(err, ErrTestFailed) {
+		t.Errorf("failed test: error %v", err)
+	}
+	if got := encode(t, doc); got != want {
+		t.Errorf("document changed by a failed patch:\n%s", got)
+	}
+	for _, patch := range []string{`{}`, `[{"op": "add", "path": "/x"}]`, `[{"op": "nop", "path": ""}]`, `[{"op": "move", "from": "/ports", "path": "/ports/0"}]`} {
+		ops, err := ParsePatch([]byte(patch))
+		if err == nil {
+			err = doc.Apply(ops)
+		}
+		if err == nil {
+			t.Errorf("patch %s succeeded", patch)
+		}
+	}
+}
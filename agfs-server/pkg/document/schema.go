@@ -0,0 +1,446 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Schema is a compiled JSON Schema
+// It supports the validation keywords configuration files need: type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems, uniqueItems,
+// minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, minLength, maxLength,
+// pattern, allOf, anyOf, oneOf and not. Annotations and unknown keywords are ignored
+type Schema struct {
+	always *bool // Set for the boolean schemas true and false
+
+	types      []string
+	enum       []interface{}
+	constValue interface{}
+	hasConst   bool
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	items                *Schema
+	minItems, maxItems   *int
+	uniqueItems          bool
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+// Violation is a part of a value that does not match its schema
+type Violation struct {
+	Pointer string `json:"pointer"` // JSON Pointer of the value, empty for the whole document
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Pointer == "" {
+		return v.Message
+	}
+	return v.Pointer + ": " + v.Message
+}
+
+// ValidationError lists the violations of a document
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.String()
+	}
+	return "schema validation failed: " + strings.Join(parts, "; ")
+}
+
+// Unwrap makes validation errors invalid arguments
+func (e *ValidationError) Unwrap() error {
+	return filesystem.ErrInvalidArgument
+}
+
+var schemaTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// CompileSchema compiles a schema given as a decoded document value
+func CompileSchema(value interface{}) (*Schema, error) {
+	s, err := compile(value, "")
+	if err != nil {
+		return nil, filesystem.NewInvalidArgumentError("schema", "", err.Error())
+	}
+	return s, nil
+}
+
+func compile(value interface{}, at string) (*Schema, error) {
+	if b, ok := value.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: a schema must be an object or a boolean", schemaPath(at))
+	}
+
+	s := &Schema{}
+	var err error
+	switch t := m["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: type must be a string or an array of strings", schemaPath(at))
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s: type must be a string or an array of strings", schemaPath(at))
+	}
+	for _, t := range s.types {
+		if !schemaTypes[t] {
+			return nil, fmt.Errorf("%s: unknown type %q", schemaPath(at), t)
+		}
+	}
+
+	if v, ok := m["enum"]; ok {
+		if s.enum, ok = v.([]interface{}); !ok {
+			return nil, fmt.Errorf("%s: enum must be an array", schemaPath(at))
+		}
+	}
+	s.constValue, s.hasConst = m["const"]
+
+	if v, ok := m["properties"]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: properties must be an object", schemaPath(at))
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = compile(sub, at+"/properties/"+escape(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if v, ok := m["required"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: required must be an array of strings", schemaPath(at))
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: required must be an array of strings", schemaPath(at))
+			}
+			s.required = append(s.required, name)
+		}
+	}
+	for key, dst := range map[string]**Schema{"additionalProperties": &s.additionalProperties, "items": &s.items, "not": &s.not} {
+		if v, ok := m[key]; ok {
+			if *dst, err = compile(v, at+"/"+key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for key, dst := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		v, ok := m[key]
+		if !ok {
+			continue
+		}
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return nil, fmt.Errorf("%s: %s must be a non-empty array of schemas", schemaPath(at), key)
+		}
+		for i, item := range list {
+			sub, err := compile(item, fmt.Sprintf("%s/%s/%d", at, key, i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, sub)
+		}
+	}
+
+	for key, dst := range map[string]**int{"minItems": &s.minItems, "maxItems": &s.maxItems, "minLength": &s.minLength, "maxLength": &s.maxLength} {
+		if v, ok := m[key]; ok {
+			n, ok := v.(json.Number)
+			i, err := n.Int64()
+			if !ok || err != nil || i < 0 {
+				return nil, fmt.Errorf("%s: %s must be a non-negative integer", schemaPath(at), key)
+			}
+			limit := int(i)
+			*dst = &limit
+		}
+	}
+	numbers := map[string]**float64{
+		"minimum": &s.minimum, "maximum": &s.maximum, "multipleOf": &s.multipleOf,
+		"exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum,
+	}
+	for key, dst := range numbers {
+		if v, ok := m[key]; ok {
+			n, ok := v.(json.Number)
+			f, err := n.Float64()
+			if !ok || err != nil {
+				return nil, fmt.Errorf("%s: %s must be a number", schemaPath(at), key)
+			}
+			*dst = &f
+		}
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return nil, fmt.Errorf("%s: multipleOf must be positive", schemaPath(at))
+	}
+	if v, ok := m["uniqueItems"]; ok {
+		if s.uniqueItems, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("%s: uniqueItems must be a boolean", schemaPath(at))
+		}
+	}
+	if v, ok := m["pattern"]; ok {
+		p, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: pattern must be a string", schemaPath(at))
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %v", schemaPath(at), err)
+		}
+	}
+	return s, nil
+}
+
+// schemaPath names a location in a schema for compile errors
+func schemaPath(at string) string {
+	if at == "" {
+		return "schema"
+	}
+	return "schema " + at
+}
+
+// escape escapes a reference token of a JSON Pointer
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// Validate checks a document value against the schema and returns an error listing every
+// violation, or nil
+func (s *Schema) Validate(value interface{}) error {
+	violations := s.validate(value, "")
+	if len(violations) == 0 {
+		return nil
+	}
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Pointer < violations[j].Pointer })
+	return &ValidationError{Violations: violations}
+}
+
+func (s *Schema) validate(value interface{}, at string) []Violation {
+	if s.always != nil {
+		if *s.always {
+			return nil
+		}
+		return []Violation{{at, "no value is allowed here"}}
+	}
+
+	var out []Violation
+	fail := func(format string, args ...interface{}) {
+		out = append(out, Violation{at, fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.types) > 0 {
+		matched := false
+		for _, t := range s.types {
+			if hasType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(s.types, " or "), typeName(value))
+			// The other keywords would only repeat the mismatch
+			return out
+		}
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if equal(value, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %s", compact(s.enum))
+		}
+	}
+	if s.hasConst && !equal(value, s.constValue) {
+		fail("must be %s", compact(s.constValue))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := at + "/" + escape(name)
+			if sub, ok := s.properties[name]; ok {
+				out = append(out, sub.validate(v[name], child)...)
+			} else if s.additionalProperties != nil {
+				if s.additionalProperties.always != nil && !*s.additionalProperties.always {
+					out = append(out, Violation{child, "unknown property"})
+				} else {
+					out = append(out, s.additionalProperties.validate(v[name], child)...)
+				}
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items, has %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items, has %d", *s.maxItems, len(v))
+		}
+		if s.uniqueItems {
+		unique:
+			for i := range v {
+				for j := 0; j < i; j++ {
+					if equal(v[i], v[j]) {
+						fail("items %d and %d are equal", j, i)
+						break unique
+					}
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				out = append(out, s.items.validate(item, fmt.Sprintf("%s/%d", at, i))...)
+			}
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.minimum != nil && f < *s.minimum {
+			fail("must be >= %v, got %s", *s.minimum, v)
+		}
+		if s.maximum != nil && f > *s.maximum {
+			fail("must be <= %v, got %s", *s.maximum, v)
+		}
+		if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+			fail("must be > %v, got %s", *s.exclusiveMinimum, v)
+		}
+		if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+			fail("must be < %v, got %s", *s.exclusiveMaximum, v)
+		}
+		if s.multipleOf != nil {
+			if q := f / *s.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+				fail("must be a multiple of %v, got %s", *s.multipleOf, v)
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %q", s.pattern.String())
+		}
+	}
+
+	for _, sub := range s.allOf {
+		out = append(out, sub.validate(value, at)...)
+	}
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.validate(value, at)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any schema of anyOf")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if len(sub.validate(value, at)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one schema of oneOf, matches %d", matches)
+		}
+	}
+	if s.not != nil && len(s.not.validate(value, at)) == 0 {
+		fail("must not match the schema of not")
+	}
+	return out
+}
+
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		if _, err := n.Int64(); err == nil {
+			return true
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	}
+	return typeName(value) == t
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// compact encodes a value for a message
+func compact(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,137 @@
+// Package embed runs AGFS inside another Go program: plugins are mounted in-process,
+// the file system is used directly and the HTTP API can be served from the program's
+// own server, without running agfs-server
+package embed
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// Mount is a built-in plugin to mount with its configuration
+type Mount struct {
+	Plugin string                 // Plugin name, e.g. "memfs"
+	Path   string                 // Mount path, e.g. "/memfs"
+	Config map[string]interface{} // Plugin configuration and mount options
+}
+
+// Options configure an embedded server
+type Options struct {
+	Mounts  []Mount
+	DevMode bool   // Makes the development-only plugins (faultfs) available
+	Version string // Reported by the health and capabilities endpoints (default "embedded")
+}
+
+// Server is an in-process AGFS instance
+type Server struct {
+	mfs     *mountablefs.MountableFS
+	version string
+
+	handlerOnce sync.Once
+	handler     http.Handler
+}
+
+// New creates a server and mounts opts.Mounts in order, unmounting them again if one fails
+func New(opts Options) (*Server, error) {
+	s := &Server{mfs: mountablefs.NewMountableFS(), version: opts.Version}
+	if s.version == "" {
+		s.version = "embedded"
+	}
+
+	factories := BuiltinPlugins()
+	if opts.DevMode {
+		for name, factory := range DevPlugins() {
+			factories[name] = factory
+		}
+	}
+	for name, factory := range factories {
+		s.mfs.RegisterPluginFactory(name, factory)
+	}
+
+	for _, m := range opts.Mounts {
+		if err := s.Mount(m.Plugin, m.Path, m.Config); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("mount %s at %s: %w", m.Plugin, m.Path, err)
+		}
+	}
+	return s, nil
+}
+
+// FS returns the file system of all mounts
+func (s *Server) FS() filesystem.FileSystem {
+	return s.mfs
+}
+
+// MountableFS returns the mount table, for mount options and statistics
+func (s *Server) MountableFS() *mountablefs.MountableFS {
+	return s.mfs
+}
+
+// Mount mounts a new instance of a built-in plugin at path
+func (s *Server) Mount(pluginName, path string, config map[string]interface{}) error {
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return s.mfs.MountPlugin(pluginName, path, config)
+}
+
+// MountPlugin mounts p, e.g. a plugin implemented by the embedding program, at path
+// after validating and initializing it with config
+func (s *Server) MountPlugin(path string, p plugin.ServicePlugin, config map[string]interface{}) error {
+	opts, pluginConfig, err := mountablefs.SplitMountOptions(config)
+	if err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
+	}
+	configWithPath := map[string]interface{}{"mount_path": filesystem.NormalizePath(path)}
+	for k, v := range pluginConfig {
+		configWithPath[k] = v
+	}
+	if err := p.Validate(configWithPath); err != nil {
+		return fmt.Errorf("failed to validate plugin: %v", err)
+	}
+	if err := plugin.Initialize(p, s.mfs.InitContext(p.Name(), path, configWithPath)); err != nil {
+		return fmt.Errorf("failed to initialize plugin: %v", err)
+	}
+	if err := s.mfs.MountWithOptions(path, p, opts); err != nil {
+		p.Shutdown()
+		return err
+	}
+	return nil
+}
+
+// Unmount shuts down the plugin mounted at path
+func (s *Server) Unmount(path string) error {
+	return s.mfs.Unmount(path)
+}
+
+// Handler returns the HTTP API (/api/v1, /api/v2 and plugin management); mount it at
+// the root of a server or strip a prefix with http.StripPrefix
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		handler := handlers.NewHandler(s.mfs)
+		handler.SetVersionInfo(s.version, "", "")
+		mux := http.NewServeMux()
+		handler.SetupRoutes(mux)
+		handlers.NewPluginHandler(s.mfs).SetupRoutes(mux)
+		s.handler = mux
+	})
+	return s.handler
+}
+
+// Close unmounts every plugin, shutting them down
+func (s *Server) Close() error {
+	var errs []error
+	for _, mount := range s.mfs.GetMounts() {
+		if err := s.mfs.Unmount(mount.Path); err != nil {
+			errs = append(errs, fmt.Errorf("unmount %s: %w", mount.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
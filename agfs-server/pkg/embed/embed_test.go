@@ -0,0 +1,42 @@
+package embed
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	s, err := New(Options{Mounts: []Mount{{Plugin: "memfs", Path: "/data"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// A plugin created by the embedding program
+	custom := memfs.NewMemFSPlugin()
+	if err := s.MountPlugin("/custom", custom, map[string]interface{}{"trash": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.FS().Write("/data/hello.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/api/v1/files?path=/data/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("GET /data/hello.txt = %d %q", resp.StatusCode, body)
+	}
+
+	if _, err := New(Options{Mounts: []Mount{{Plugin: "nosuchfs", Path: "/x"}}}); err == nil {
+		t.Errorf("mounting an unknown plugin succeeded")
+	}
+}
@@ -0,0 +1,76 @@
+package embed
+
+import (
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/agentfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/configfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/faultfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/formfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/heartbeatfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/hellofs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/httpfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/inboxfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/jobsfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/kvfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/s3fs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/scriptfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/searchfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/serverinfofs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/shardfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs2"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/statsfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/streamfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/tagfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/tmpfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/tsfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/tutorialfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/vectorfs"
+)
+
+// BuiltinPlugins returns the factories of the plugins compiled into AGFS by name
+// The map is a copy that callers may extend
+func BuiltinPlugins() map[string]mountablefs.PluginFactory {
+	return map[string]mountablefs.PluginFactory{
+		"serverinfofs": func() plugin.ServicePlugin { return serverinfofs.NewServerInfoFSPlugin() },
+		"memfs":        func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() },
+		"queuefs":      func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() },
+		"kvfs":         func() plugin.ServicePlugin { return kvfs.NewKVFSPlugin() },
+		"hellofs":      func() plugin.ServicePlugin { return hellofs.NewHelloFSPlugin() },
+		"heartbeatfs":  func() plugin.ServicePlugin { return heartbeatfs.NewHeartbeatFSPlugin() },
+		"httpfs":       func() plugin.ServicePlugin { return httpfs.NewHTTPFSPlugin() },
+		"proxyfs":      func() plugin.ServicePlugin { return proxyfs.NewProxyFSPlugin("") },
+		"s3fs":         func() plugin.ServicePlugin { return s3fs.NewS3FSPlugin() },
+		"streamfs":     func() plugin.ServicePlugin { return streamfs.NewStreamFSPlugin() },
+		"sqlfs":        func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() },
+		"sqlfs2":       func() plugin.ServicePlugin { return sqlfs2.NewSQLFS2Plugin() },
+		"localfs":      func() plugin.ServicePlugin { return localfs.NewLocalFSPlugin() },
+		"searchfs":     func() plugin.ServicePlugin { return searchfs.NewSearchFSPlugin() },
+		"jobsfs":       func() plugin.ServicePlugin { return jobsfs.NewJobsFSPlugin() },
+		"tagfs":        func() plugin.ServicePlugin { return tagfs.NewTagFSPlugin() },
+		"tmpfs":        func() plugin.ServicePlugin { return tmpfs.NewTmpFSPlugin() },
+		"agentfs":      func() plugin.ServicePlugin { return agentfs.NewAgentFSPlugin() },
+		"vectorfs":     func() plugin.ServicePlugin { return vectorfs.NewVectorFSPlugin() },
+		"shardfs":      func() plugin.ServicePlugin { return shardfs.NewShardFSPlugin() },
+		"configfs":     func() plugin.ServicePlugin { return configfs.NewConfigFSPlugin() },
+		"statsfs":      func() plugin.ServicePlugin { return statsfs.NewStatsFSPlugin() },
+		"tsfs":         func() plugin.ServicePlugin { return tsfs.NewTSFSPlugin() },
+		"inboxfs":      func() plugin.ServicePlugin { return inboxfs.NewInboxFSPlugin() },
+		"formfs":       func() plugin.ServicePlugin { return formfs.NewFormFSPlugin() },
+		"scriptfs":     func() plugin.ServicePlugin { return scriptfs.NewScriptFSPlugin() },
+		"tutorialfs":   func() plugin.ServicePlugin { return tutorialfs.NewTutorialFSPlugin() },
+	}
+}
+
+// DevPlugins returns the factories of the development-only plugins, which inject faults
+// and must not be available in production
+func DevPlugins() map[string]mountablefs.PluginFactory {
+	return map[string]mountablefs.PluginFactory{
+		"faultfs": func() plugin.ServicePlugin { return faultfs.NewFaultFSPlugin() },
+	}
+}
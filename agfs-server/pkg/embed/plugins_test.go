@@ -0,0 +1,53 @@
+package embed
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// sampleValue returns a value of the type of param
+func sampleValue(param plugin.ConfigParameter) interface{} {
+	if len(param.Choices) > 0 {
+		return param.Choices[0]
+	}
+	switch param.Type {
+	case plugin.ParamInt:
+		return 1
+	case plugin.ParamBool:
+		return true
+	case plugin.ParamSize:
+		return "1MB"
+	case plugin.ParamDuration:
+		return "1s"
+	case plugin.ParamStrings:
+		return []interface{}{"/a"}
+	case plugin.ParamMap:
+		return map[string]interface{}{}
+	default:
+		return "/x"
+	}
+}
+
+// The schemas name the keys the plugins accept, with the types they accept
+func TestConfigSchemas(t *testing.T) {
+	for name, factory := range BuiltinPlugins() {
+		provider, ok := factory().(plugin.ConfigSchemaProvider)
+		if !ok {
+			continue
+		}
+		required := false
+		for _, param := range provider.ConfigSchema() {
+			required = required || param.Required
+			cfg := map[string]interface{}{"mount_path": "/m", param.Name: sampleValue(param)}
+			err := factory().Validate(cfg)
+			if err != nil && (strings.Contains(err.Error(), "unknown configuration parameter") || strings.Contains(err.Error(), param.Name+" must be")) {
+				t.Errorf("%s: %s %s: %v", name, param.Name, param.Type, err)
+			}
+		}
+		if err := factory().Validate(map[string]interface{}{"mount_path": "/m"}); required && err == nil {
+			t.Errorf("%s: configuration without required keys accepted", name)
+		}
+	}
+}
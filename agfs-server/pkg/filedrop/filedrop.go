@@ -0,0 +1,633 @@
+// Package filedrop turns a directory into an ingestion point: watchers scan directories,
+// e.g. on localfs or s3fs, and enqueue an event to a queuefs queue for every file dropped
+// there, with its path, size and digest. Consumers may acknowledge an event through an ack
+// queue, and the file is then moved out of the way to a processed directory
+package filedrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/xxh3"
+)
+
+// Defaults of the watcher configuration
+const (
+	DefaultStateDir = "file-drop"
+	DefaultInterval = 10 * time.Second
+	DefaultSettle   = 5 * time.Second
+	DefaultDigest   = DigestSHA256
+)
+
+// Digests of the content of dropped files
+const (
+	DigestSHA256 = "sha256"
+	DigestXXH3   = "xxh3"
+	DigestNone   = "none"
+)
+
+// Watcher states reported in Status
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateOK      = "ok"
+	StateFailed  = "failed"
+)
+
+// processedDir is where acknowledged files go when the watcher does not configure one
+const processedDir = "processed"
+
+// ackPage bounds the acknowledgements taken from the ack queue at once
+const ackPage = 1000
+
+// ErrRunning is returned when a watcher is run while its previous scan has not finished
+var ErrRunning = errors.New("file drop watcher already running")
+
+// Event is the message enqueued for a dropped file
+type Event struct {
+	ID      string    `json:"id"` // Acknowledges the event when enqueued to the ack queue
+	Watcher string    `json:"watcher"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Digest  string    `json:"digest,omitempty"` // e.g. "sha256:9f86d0..."
+	Time    time.Time `json:"time"`             // When the file was found
+}
+
+// Status reports the configuration, totals and last scan of a watcher
+type Status struct {
+	Name      string     `json:"name"`
+	Path      string     `json:"path"`
+	Queue     string     `json:"queue"`
+	AckQueue  string     `json:"ackQueue,omitempty"`
+	Processed string     `json:"processed,omitempty"`
+	Interval  string     `json:"interval"`
+	State     string     `json:"state"`
+	LastScan  *time.Time `json:"lastScan,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	Enqueued  int        `json:"enqueued"` // Events enqueued by the last scan
+	Moved     int        `json:"moved"`    // Acknowledged files moved by the last scan
+	Unacked   int        `json:"unacked"`  // Files whose event waits for an acknowledgement
+	Events    int64      `json:"events"`   // Events enqueued since the watcher was created
+	Error     string     `json:"error,omitempty"`
+	NextScan  *time.Time `json:"nextScan,omitempty"`
+}
+
+// entry is a file a watcher enqueued, remembered until it leaves the directory
+type entry struct {
+	ID      string    `json:"id"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Acked   bool      `json:"acked,omitempty"` // Acknowledged, to be moved to the processed directory
+}
+
+// state is what a watcher keeps in the state directory across restarts
+type state struct {
+	Events int64             `json:"events"`
+	Files  map[string]*entry `json:"files"` // By path
+}
+
+// watcher is a configured watcher with its runtime state
+type watcher struct {
+	cfg       config.FileDropWatcher
+	interval  time.Duration
+	settle    time.Duration
+	filter    *archive.Filter
+	stateFile string
+
+	runMu  sync.Mutex // held while the watcher scans; protects st
+	st     state
+	mu     sync.Mutex // protects status
+	status Status
+}
+
+// Manager runs the watchers of the configuration
+type Manager struct {
+	fs       filesystem.FileSystem
+	watchers []*watcher
+	now      func() time.Time
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewManager validates the configuration and loads the files seen by the watchers
+// Directories are scanned and events enqueued through fs, so they may live on any mount
+func NewManager(fs filesystem.FileSystem, cfg config.FileDropConfig) (*Manager, error) {
+	m := &Manager{fs: fs, now: time.Now, done: make(chan struct{})}
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+
+	names := make(map[string]bool)
+	for _, wcfg := range cfg.Watchers {
+		if !wcfg.Enabled {
+			continue
+		}
+		w, err := newWatcher(wcfg, stateDir)
+		if err != nil {
+			return nil, err
+		}
+		if names[wcfg.Name] {
+			return nil, fmt.Errorf("duplicate file drop watcher name: %s", wcfg.Name)
+		}
+		names[wcfg.Name] = true
+		if err := w.load(); err != nil {
+			return nil, fmt.Errorf("file drop watcher %s: %w", wcfg.Name, err)
+		}
+		m.watchers = append(m.watchers, w)
+	}
+	return m, nil
+}
+
+func newWatcher(cfg config.FileDropWatcher, stateDir string) (*watcher, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("file drop watcher name is required")
+	}
+	if strings.ContainsAny(cfg.Name, `/\`) {
+		return nil, fmt.Errorf("file drop watcher %s: name must not contain slashes", cfg.Name)
+	}
+	if cfg.Path == "" || cfg.Queue == "" {
+		return nil, fmt.Errorf("file drop watcher %s: path and queue are required", cfg.Name)
+	}
+	cfg.Path = filesystem.NormalizePath(cfg.Path)
+	cfg.Queue = filesystem.NormalizePath(cfg.Queue)
+	if cfg.Path == "/" {
+		return nil, fmt.Errorf("file drop watcher %s: path must not be the root", cfg.Name)
+	}
+	if within(cfg.Queue, cfg.Path) || within(cfg.Path, cfg.Queue) {
+		return nil, fmt.Errorf("file drop watcher %s: path and queue must not contain each other", cfg.Name)
+	}
+
+	switch cfg.Digest {
+	case "":
+		cfg.Digest = DefaultDigest
+	case DigestSHA256, DigestXXH3, DigestNone:
+	default:
+		return nil, fmt.Errorf("file drop watcher %s: digest must be %q, %q or %q", cfg.Name, DigestSHA256, DigestXXH3, DigestNone)
+	}
+
+	if cfg.AckQueue != "" {
+		cfg.AckQueue = filesystem.NormalizePath(cfg.AckQueue)
+		if cfg.AckQueue == cfg.Queue {
+			return nil, fmt.Errorf("file drop watcher %s: ack_queue must differ from queue", cfg.Name)
+		}
+		if cfg.Processed == "" {
+			cfg.Processed = path.Join(cfg.Path, processedDir)
+		}
+		cfg.Processed = filesystem.NormalizePath(cfg.Processed)
+		if cfg.Processed == cfg.Path {
+			return nil, fmt.Errorf("file drop watcher %s: processed must differ from path", cfg.Name)
+		}
+	} else if cfg.Processed != "" {
+		return nil, fmt.Errorf("file drop watcher %s: processed requires ack_queue", cfg.Name)
+	}
+
+	w := &watcher{
+		cfg:       cfg,
+		interval:  DefaultInterval,
+		settle:    DefaultSettle,
+		stateFile: filepath.Join(stateDir, cfg.Name+".json"),
+		st:        state{Files: make(map[string]*entry)},
+	}
+	if cfg.Interval != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("file drop watcher %s: invalid interval: %w", cfg.Name, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("file drop watcher %s: interval must be positive", cfg.Name)
+		}
+		w.interval = d
+	}
+	if cfg.Settle != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Settle)
+		if err != nil {
+			return nil, fmt.Errorf("file drop watcher %s: invalid settle: %w", cfg.Name, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("file drop watcher %s: settle must not be negative", cfg.Name)
+		}
+		w.settle = d
+	}
+	filter, err := archive.NewFilter(cfg.Include, cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("file drop watcher %s: %w", cfg.Name, err)
+	}
+	w.filter = filter
+
+	w.status = Status{
+		Name:      cfg.Name,
+		Path:      cfg.Path,
+		Queue:     cfg.Queue,
+		AckQueue:  cfg.AckQueue,
+		Processed: cfg.Processed,
+		Interval:  w.interval.String(),
+		State:     StateIdle,
+	}
+	return w, nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// load reads the files seen by the watcher, if it ran before
+func (w *watcher) load() error {
+	data, err := os.ReadFile(w.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &w.st); err != nil {
+		return fmt.Errorf("invalid state %s: %w", w.stateFile, err)
+	}
+	if w.st.Files == nil {
+		w.st.Files = make(map[string]*entry)
+	}
+	w.status.Events = w.st.Events
+	w.status.Unacked = w.unacked()
+	return nil
+}
+
+// save writes the files seen by the watcher; the caller must hold w.runMu
+func (w *watcher) save() error {
+	data, err := json.MarshalIndent(w.st, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(w.stateFile), 0755)
+	}
+	if err == nil {
+		if err = os.WriteFile(w.stateFile+".tmp", data, 0644); err == nil {
+			err = os.Rename(w.stateFile+".tmp", w.stateFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// unacked counts the files waiting for an acknowledgement, none without an ack queue; the
+// caller must hold w.runMu or own w
+func (w *watcher) unacked() int {
+	if w.cfg.AckQueue == "" {
+		return 0
+	}
+	n := 0
+	for _, e := range w.st.Files {
+		if !e.Acked {
+			n++
+		}
+	}
+	return n
+}
+
+// Start launches the loop of every watcher
+func (m *Manager) Start() {
+	for _, w := range m.watchers {
+		m.wg.Add(1)
+		go m.loop(w)
+	}
+	if len(m.watchers) > 0 {
+		log.Infof("[filedrop] started %d watcher(s)", len(m.watchers))
+	}
+}
+
+// Stop stops the loops and waits for them to exit
+// A scan that is already running is allowed to finish
+func (m *Manager) Stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+func (m *Manager) loop(w *watcher) {
+	defer m.wg.Done()
+	for {
+		next := time.Now().Add(w.interval)
+		w.mu.Lock()
+		w.status.NextScan = &next
+		w.mu.Unlock()
+
+		timer := time.NewTimer(w.interval)
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := m.scan(w); err != nil && !errors.Is(err, ErrRunning) {
+			log.Errorf("[filedrop] watcher %s failed: %v", w.cfg.Name, err)
+		}
+	}
+}
+
+// Run scans a directory immediately and waits for the scan to finish; an empty name runs
+// every watcher
+func (m *Manager) Run(name string) ([]Status, error) {
+	var results []Status
+	found := false
+	for _, w := range m.watchers {
+		if name != "" && w.cfg.Name != name {
+			continue
+		}
+		found = true
+		status, err := m.scan(w)
+		if err != nil && name != "" {
+			return []Status{status}, err
+		}
+		results = append(results, status)
+	}
+	if !found && name != "" {
+		return nil, filesystem.NewNotFoundError("file drop watcher", name)
+	}
+	return results, nil
+}
+
+// Status returns the status of every watcher
+func (m *Manager) Status() []Status {
+	statuses := make([]Status, 0, len(m.watchers))
+	for _, w := range m.watchers {
+		w.mu.Lock()
+		statuses = append(statuses, w.status)
+		w.mu.Unlock()
+	}
+	return statuses
+}
+
+// scan takes the acknowledgements of the ack queue and moves their files, then enqueues an
+// event for every file of the directory that is new or was modified since its last event
+func (m *Manager) scan(w *watcher) (Status, error) {
+	if !w.runMu.TryLock() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.status, ErrRunning
+	}
+	defer w.runMu.Unlock()
+
+	start := m.now()
+	w.mu.Lock()
+	w.status.State = StateRunning
+	w.status.LastScan = &start
+	w.mu.Unlock()
+
+	var moved, enqueued int
+	var err error
+	if w.cfg.AckQueue != "" {
+		moved, err = m.processAcks(w)
+	}
+	if err == nil {
+		enqueued, err = m.enqueueNew(w, start)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status.Duration = time.Since(start).Round(time.Millisecond).String()
+	w.status.Enqueued = enqueued
+	w.status.Moved = moved
+	w.status.Events = w.st.Events
+	w.status.Unacked = w.unacked()
+	if err != nil {
+		w.status.State = StateFailed
+		w.status.Error = err.Error()
+		return w.status, err
+	}
+	w.status.State = StateOK
+	w.status.Error = ""
+	if enqueued > 0 || moved > 0 {
+		log.Infof("[filedrop] watcher %s: enqueued %d event(s), moved %d processed file(s)", w.cfg.Name, enqueued, moved)
+	}
+	return w.status, nil
+}
+
+// processAcks marks the files of the acknowledgements pending in the ack queue, removes
+// them from the queue once that is saved, and moves every acknowledged file to the
+// processed directory; an acknowledgement is the ID of an event, or the path of its file
+func (m *Manager) processAcks(w *watcher) (int, error) {
+	dir := w.cfg.AckQueue + "/messages"
+	infos, _, err := filesystem.ReadDirPage(m.fs, dir, filesystem.ListPage{Limit: ackPage})
+	if err != nil && !filesystem.IsNotFound(err) {
+		return 0, err
+	}
+	var taken []string
+	for _, info := range infos {
+		data, err := m.fs.Read(dir+"/"+info.Name, 0, -1)
+		if err != nil && len(data) == 0 {
+			if filesystem.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		var msg struct{ Data string }
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return 0, fmt.Errorf("read acknowledgement %s: %w", info.Name, err)
+		}
+		if !w.ack(strings.TrimSpace(msg.Data)) {
+			log.Warnf("[filedrop] watcher %s: ignoring acknowledgement of unknown event %q", w.cfg.Name, msg.Data)
+		}
+		taken = append(taken, info.Name)
+	}
+	if len(taken) > 0 {
+		if err := w.save(); err != nil {
+			return 0, err
+		}
+		for _, id := range taken {
+			if err := m.fs.Remove(dir + "/" + id); err != nil && !filesystem.IsNotFound(err) {
+				return 0, fmt.Errorf("remove acknowledgement %s: %w", id, err)
+			}
+		}
+	}
+
+	moved := 0
+	var firstErr error
+	for p, e := range w.st.Files {
+		if !e.Acked {
+			continue
+		}
+		target := path.Join(w.cfg.Processed, strings.TrimPrefix(p, w.cfg.Path))
+		if err := m.move(p, target); err != nil && !filesystem.IsNotFound(err) {
+			log.Warnf("[filedrop] watcher %s: failed to move %s to %s: %v", w.cfg.Name, p, target, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("move %s: %w", p, err)
+			}
+			continue
+		}
+		delete(w.st.Files, p)
+		moved++
+	}
+	if moved > 0 {
+		if err := w.save(); err != nil {
+			return moved, err
+		}
+	}
+	return moved, firstErr
+}
+
+// ack marks the file of the event id, or the file at path id, as acknowledged
+func (w *watcher) ack(id string) bool {
+	if e, ok := w.st.Files[id]; ok {
+		e.Acked = true
+		return true
+	}
+	for _, e := range w.st.Files {
+		if e.ID == id {
+			e.Acked = true
+			return true
+		}
+	}
+	return false
+}
+
+// move renames src to dst, or copies it and removes src when they are on different mounts
+func (m *Manager) move(src, dst string) error {
+	if err := filesystem.MkdirAll(m.fs, path.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := m.fs.Rename(src, dst); err == nil {
+		return nil
+	}
+	n, err := filesystem.CopyFile(m.fs, src, m.fs, dst)
+	if err != nil {
+		return err
+	}
+	info, err := m.fs.Stat(dst)
+	if err != nil {
+		return err
+	}
+	if info.Size != n {
+		return fmt.Errorf("copy of %s has %d bytes, expected %d", src, info.Size, n)
+	}
+	return m.fs.Remove(src)
+}
+
+// enqueueNew enqueues an event for each file of the directory that settled since it was
+// dropped or modified, and forgets the files that left the directory
+func (m *Manager) enqueueNew(w *watcher, now time.Time) (int, error) {
+	type candidate struct {
+		path string
+		info filesystem.FileInfo
+	}
+	var found []candidate
+	present := make(map[string]bool)
+	err := filesystem.Walk(m.fs, w.cfg.Path, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, w.cfg.Path), "/")
+		if w.cfg.Processed != "" && within(p, w.cfg.Processed) {
+			return filesystem.SkipDir
+		}
+		if w.filter.Excluded(rel) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir || rel == "" || !w.filter.Included(rel) {
+			return nil
+		}
+		present[p] = true
+		if e, ok := w.st.Files[p]; ok && e.Size == info.Size && e.ModTime.Equal(info.ModTime) {
+			return nil
+		}
+		if now.Sub(info.ModTime) >= w.settle {
+			found = append(found, candidate{path: p, info: *info})
+		}
+		return nil
+	})
+	if err != nil && filesystem.IsNotFound(err) {
+		// Nothing to do until the directory exists
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	changed := false
+	for p := range w.st.Files {
+		if !present[p] {
+			delete(w.st.Files, p)
+			changed = true
+		}
+	}
+
+	enqueued := 0
+	for i, c := range found {
+		if i == 0 {
+			if err = filesystem.MkdirAll(m.fs, w.cfg.Queue, 0755); err != nil {
+				break
+			}
+		}
+		event := Event{
+			ID:      uuid.NewString(),
+			Watcher: w.cfg.Name,
+			Path:    c.path,
+			Size:    c.info.Size,
+			ModTime: c.info.ModTime,
+			Time:    now,
+		}
+		if event.Digest, err = m.digest(w.cfg.Digest, c.path); err != nil {
+			if filesystem.IsNotFound(err) {
+				// Removed since the walk
+				err = nil
+				continue
+			}
+			err = fmt.Errorf("digest %s: %w", c.path, err)
+			break
+		}
+		data, _ := json.Marshal(event)
+		if _, err = m.fs.Write(w.cfg.Queue+"/enqueue", data); err != nil {
+			err = fmt.Errorf("enqueue %s: %w", c.path, err)
+			break
+		}
+		w.st.Files[c.path] = &entry{ID: event.ID, Size: c.info.Size, ModTime: c.info.ModTime}
+		w.st.Events++
+		enqueued++
+		changed = true
+	}
+	if changed {
+		if serr := w.save(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return enqueued, err
+}
+
+// digest returns the digest of the file at p, e.g. "sha256:9f86d0...", or "" for DigestNone
+func (m *Manager) digest(algo, p string) (string, error) {
+	if algo == DigestNone {
+		return "", nil
+	}
+	var h hash.Hash = sha256.New()
+	if algo == DigestXXH3 {
+		h = xxh3.New()
+	}
+	r, err := m.fs.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return algo + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,139 @@
+package filedrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+)
+
+func TestWatcher(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() })
+	for _, m := range []struct{ plugin, path string }{{"memfs", "/drop"}, {"queuefs", "/queuefs"}} {
+		if err := mfs.MountPlugin(m.plugin, m.path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(p, data string) {
+		t.Helper()
+		if err := filesystem.MkdirAll(mfs, p[:strings.LastIndex(p, "/")], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(p, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(p string) string {
+		t.Helper()
+		data, err := mfs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("read %s: %v", p, err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	dequeue := func() Event {
+		t.Helper()
+		var msg struct{ Data string }
+		var event Event
+		if err := json.Unmarshal([]byte(read("/queuefs/incoming/dequeue")), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(msg.Data), &event); err != nil {
+			t.Fatalf("event %q: %v", msg.Data, err)
+		}
+		return event
+	}
+
+	stateDir := t.TempDir()
+	cfg := config.FileDropConfig{StateDir: stateDir, Watchers: []config.FileDropWatcher{
+		{Name: "incoming", Enabled: true, Path: "/drop/in", Queue: "/queuefs/incoming", Exclude: "*.tmp", AckQueue: "/queuefs/acks"},
+	}}
+	for _, bad := range []config.FileDropWatcher{
+		{Name: "x", Enabled: true, Path: "/drop/in", Queue: "/drop/in/q"},
+		{Name: "x", Enabled: true, Path: "/drop/in", Queue: "/q", Digest: "md5"},
+		{Name: "x", Enabled: true, Path: "/drop/in", Queue: "/q", Processed: "/drop/done"},
+	} {
+		if _, err := NewManager(mfs, config.FileDropConfig{StateDir: stateDir, Watchers: []config.FileDropWatcher{bad}}); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	m, err := NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A missing directory is not an error
+	if _, err := m.Run("incoming"); err != nil {
+		t.Fatal(err)
+	}
+	write("/drop/in/a.csv", "a,1")
+	write("/drop/in/sub/b.csv", "b,2")
+	write("/drop/in/partial.tmp", "...")
+
+	// Files are enqueued once they have settled
+	if statuses, err := m.Run("incoming"); err != nil || statuses[0].Enqueued != 0 {
+		t.Fatalf("fresh files = %+v, %v", statuses, err)
+	}
+	m.now = func() time.Time { return time.Now().Add(time.Minute) }
+	statuses, err := m.Run("incoming")
+	if err != nil || statuses[0].Enqueued != 2 || statuses[0].Unacked != 2 {
+		t.Fatalf("settled files = %+v, %v", statuses, err)
+	}
+	first := dequeue()
+	sum := sha256.Sum256([]byte("a,1"))
+	if first.Path != "/drop/in/a.csv" || first.Size != 3 || first.Digest != "sha256:"+hex.EncodeToString(sum[:]) || first.Watcher != "incoming" || first.ID == "" {
+		t.Errorf("event = %+v", first)
+	}
+	second := dequeue()
+
+	// Seen files are not enqueued again, unless they change
+	if statuses, err := m.Run(""); err != nil || statuses[0].Enqueued != 0 {
+		t.Fatalf("rescan = %+v, %v", statuses, err)
+	}
+	write("/drop/in/a.csv", "a,1\na,2")
+	if statuses, err := m.Run(""); err != nil || statuses[0].Enqueued != 1 || statuses[0].Events != 3 {
+		t.Fatalf("modified file = %+v, %v", statuses, err)
+	}
+
+	// Acknowledged files move to processed/, by event ID or path; the state survives a restart
+	m, err = NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/queuefs/acks", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, ack := range []string{second.ID, "/drop/in/a.csv", "unknown"} {
+		if _, err := mfs.Write("/queuefs/acks/enqueue", []byte(ack)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses, err = m.Run("incoming")
+	if err != nil || statuses[0].Moved != 2 || statuses[0].Unacked != 0 || statuses[0].Events != 3 || statuses[0].Enqueued != 0 {
+		t.Fatalf("acknowledged = %+v, %v", statuses, err)
+	}
+	if got := read("/drop/in/processed/sub/b.csv"); got != "b,2" {
+		t.Errorf("processed file = %q", got)
+	}
+	if _, err := mfs.Stat("/drop/in/sub/b.csv"); !filesystem.IsNotFound(err) {
+		t.Errorf("acknowledged file left in place: %v", err)
+	}
+	if size := read("/queuefs/acks/size"); size != "0" {
+		t.Errorf("ack queue size = %s", size)
+	}
+	if _, err := m.Run("nope"); err == nil {
+		t.Error("unknown watcher: no error")
+	}
+}
@@ -0,0 +1,48 @@
+package filesystem
+
+import (
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+// Append adds data to the end of the file at path, creating it if missing, and returns
+// the size of the file after the append
+// File systems without Appender support are read and written back; callers must serialize
+// such appends to one path, e.g. with PathLocks
+func Append(fs FileSystem, path string, data []byte) (int64, error) {
+	if appender, ok := fs.(Appender); ok {
+		return appender.Append(path, data)
+	}
+	old, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		if !IsNotFound(err) {
+			return 0, err
+		}
+		old = nil
+	}
+	content := make([]byte, 0, len(old)+len(data))
+	content = append(append(content, old...), data...)
+	if _, err := fs.Write(path, content); err != nil {
+		return 0, err
+	}
+	return int64(len(content)), nil
+}
+
+// pathLockStripes is the number of mutexes of a PathLocks
+const pathLockStripes = 64
+
+// PathLocks serializes operations on the same path with a fixed set of mutexes;
+// unrelated paths share one only by chance. The zero value is ready to use
+type PathLocks struct {
+	stripes [pathLockStripes]sync.Mutex
+}
+
+// Lock locks path and returns the function unlocking it
+func (l *PathLocks) Lock(path string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(NormalizePath(path)))
+	mu := &l.stripes[h.Sum32()%pathLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
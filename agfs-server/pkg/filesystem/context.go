@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ContextBinder is implemented by file systems whose operations can be bound to a context,
+// e.g. remote backends that abort requests when its deadline passes or it is canceled
+type ContextBinder interface {
+	// WithContext returns a view of the file system whose operations run under ctx
+	// The view shares all state with the original and is meant to be used for a single call;
+	// readers, writers and streams should be opened on the original, as ctx usually ends
+	// when the call returns
+	WithContext(ctx context.Context) FileSystem
+}
+
+// WithContext binds fs to ctx if it supports contexts, and returns fs unchanged otherwise
+func WithContext(fs FileSystem, ctx context.Context) FileSystem {
+	if binder, ok := fs.(ContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return fs
+}
+
+// OperationContext returns the context of a single backend call made under parent (nil for
+// none), bounded by timeout unless it is 0; the parent's deadline wins if it is earlier
+func OperationContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if parent == nil {
+		parent = context.Background()
+	}
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// ContextError converts err into a TimeoutError if it was caused by ctx's deadline passing or
+// by a timeout of the backend itself, so slow backends report a timeout instead of a
+// transport error; timeout is the limit reported, 0 if it is not known
+func ContextError(ctx context.Context, op, path string, timeout time.Duration, err error) error {
+	if err == nil || err == io.EOF || errors.Is(err, ErrTimeout) {
+		return err
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return NewTimeoutError(op, path, timeout)
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package filesystem
+
+import (
+	"io"
+	"path"
+	"strings"
+)
+
+// CopyStats summarizes the result of a tree copy
+type CopyStats struct {
+	Files int   `json:"files"` // Number of files copied
+	Dirs  int   `json:"dirs"`  // Number of directories created or reused
+	Bytes int64 `json:"bytes"` // Total bytes copied
+}
+
+// CopyFile copies a single file between file systems and returns the number of bytes copied
+func CopyFile(src FileSystem, srcPath string, dst FileSystem, dstPath string) (int64, error) {
+	data, err := src.Read(srcPath, 0, -1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if _, err := dst.Write(dstPath, data); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// MkdirAll creates dir and any missing parents in fs
+func MkdirAll(fs FileSystem, dir string, perm uint32) error {
+	dir = NormalizePath(dir)
+	if dir == "/" {
+		return nil
+	}
+	current := ""
+	for _, part := range strings.Split(strings.Trim(dir, "/"), "/") {
+		current += "/" + part
+		info, err := fs.Stat(current)
+		if err == nil {
+			if !info.IsDir {
+				return NewNotDirectoryError(current)
+			}
+			continue
+		}
+		if err := fs.Mkdir(current, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyTree recursively copies srcPath from src to dstPath in dst
+// Existing files at the destination are overwritten and existing directories are reused
+func CopyTree(src FileSystem, srcPath string, dst FileSystem, dstPath string) (CopyStats, error) {
+	var stats CopyStats
+	srcPath = NormalizePath(srcPath)
+	dstPath = NormalizePath(dstPath)
+
+	// Copying a live tree into itself would never terminate
+	if src == dst && (srcPath == "/" || dstPath == srcPath || strings.HasPrefix(dstPath, srcPath+"/")) {
+		return stats, NewInvalidArgumentError("dest", dstPath, "cannot copy a directory into itself")
+	}
+
+	err := Walk(src, srcPath, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := dstPath
+		if p != srcPath {
+			target = path.Join(dstPath, strings.TrimPrefix(p, srcPath))
+		}
+
+		if info.IsDir {
+			if err := MkdirAll(dst, target, 0755); err != nil {
+				return err
+			}
+			stats.Dirs++
+			return nil
+		}
+
+		if err := MkdirAll(dst, path.Dir(target), 0755); err != nil {
+			return err
+		}
+		n, err := CopyFile(src, p, dst, target)
+		if err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += n
+		return nil
+	})
+
+	return stats, err
+}
@@ -3,6 +3,8 @@ package filesystem
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // Standard error types for filesystem operations
@@ -23,6 +25,21 @@ var (
 
 	// ErrNotDirectory indicates the path is not a directory when one was expected
 	ErrNotDirectory = errors.New("not a directory")
+
+	// ErrNotSupported indicates the operation is not supported by the file system
+	ErrNotSupported = errors.New("not supported")
+
+	// ErrIntegrity indicates stored data does not match its recorded checksum
+	ErrIntegrity = errors.New("integrity check failed")
+
+	// ErrQuotaExceeded indicates a write would exceed a storage quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrUnavailable indicates the file system cannot take more operations right now
+	ErrUnavailable = errors.New("temporarily unavailable")
+
+	// ErrTimeout indicates an operation did not complete in time
+	ErrTimeout = errors.New("timed out")
 )
 
 // NotFoundError represents a file or directory not found error with context
@@ -111,6 +128,86 @@ func (e *NotDirectoryError) Is(target error) bool {
 	return target == ErrNotDirectory
 }
 
+// NotSupportedError represents an operation that the file system does not support
+type NotSupportedError struct {
+	Path string
+	Op   string
+}
+
+func (e *NotSupportedError) Error() string {
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s: not supported", e.Op, e.Path)
+	}
+	return fmt.Sprintf("%s: not supported", e.Op)
+}
+
+func (e *NotSupportedError) Is(target error) bool {
+	return target == ErrNotSupported
+}
+
+// IntegrityError represents a checksum mismatch detected when reading a file
+type IntegrityError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("%s: integrity check failed: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrIntegrity
+}
+
+// QuotaExceededError represents a write rejected because it would exceed a storage quota
+type QuotaExceededError struct {
+	Path  string
+	Limit int64 // Quota in bytes
+	Used  int64 // Bytes in use before the write
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s: quota exceeded (%d of %d bytes used)", e.Path, e.Used, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// UnavailableError represents an operation rejected because the file system is overloaded or closed
+type UnavailableError struct {
+	Path   string
+	Op     string
+	Reason string
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s: temporarily unavailable (%s)", e.Op, e.Path, e.Reason)
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// TimeoutError represents an operation that did not complete within its time limit
+type TimeoutError struct {
+	Path    string
+	Op      string
+	Timeout time.Duration // 0 if the limit is not known
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout <= 0 {
+		return fmt.Sprintf("%s: %s: timed out", e.Op, e.Path)
+	}
+	return fmt.Sprintf("%s: %s: timed out after %v", e.Op, e.Path, e.Timeout)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
 // Helper functions to create common errors
 
 // NewNotFoundError creates a new NotFoundError
@@ -137,3 +234,41 @@ func NewAlreadyExistsError(resource, path string) error {
 func NewNotDirectoryError(path string) error {
 	return &NotDirectoryError{Path: path}
 }
+
+// NewNotSupportedError creates a new NotSupportedError
+func NewNotSupportedError(op, path string) error {
+	return &NotSupportedError{Op: op, Path: path}
+}
+
+// NewIntegrityError creates a new IntegrityError
+func NewIntegrityError(path, expected, actual string) error {
+	return &IntegrityError{Path: path, Expected: expected, Actual: actual}
+}
+
+// NewQuotaExceededError creates a new QuotaExceededError
+func NewQuotaExceededError(path string, limit, used int64) error {
+	return &QuotaExceededError{Path: path, Limit: limit, Used: used}
+}
+
+// NewUnavailableError creates a new UnavailableError
+func NewUnavailableError(op, path, reason string) error {
+	return &UnavailableError{Op: op, Path: path, Reason: reason}
+}
+
+// NewTimeoutError creates a new TimeoutError
+func NewTimeoutError(op, path string, timeout time.Duration) error {
+	return &TimeoutError{Op: op, Path: path, Timeout: timeout}
+}
+
+// IsNotFound reports whether err means that a path does not exist
+// Besides ErrNotFound, it recognizes the "no such file" messages most plugins return
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "no such file") || strings.Contains(msg, "no such directory")
+}
@@ -0,0 +1,82 @@
+package filesystem
+
+import (
+	"strings"
+)
+
+// ListFields selects the fields a directory listing fills in for its entries besides the
+// name and the directory flag, which are always filled in
+type ListFields struct {
+	Size    bool
+	Mode    bool
+	ModTime bool
+	Meta    bool
+}
+
+// AllFields selects every field of the entries, as ReadDir fills them in
+var AllFields = ListFields{Size: true, Mode: true, ModTime: true, Meta: true}
+
+// All reports whether f selects every field
+func (f ListFields) All() bool {
+	return f == AllFields
+}
+
+// ParseListFields parses a comma-separated list of entry fields named as in the JSON of
+// listings: name, isDir, size, mode, modTime and meta; an empty list selects all fields
+func ParseListFields(s string) (ListFields, error) {
+	if strings.TrimSpace(s) == "" {
+		return AllFields, nil
+	}
+	var f ListFields
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case "name", "isDir":
+		case "size":
+			f.Size = true
+		case "mode":
+			f.Mode = true
+		case "modTime":
+			f.ModTime = true
+		case "meta":
+			f.Meta = true
+		default:
+			return ListFields{}, NewInvalidArgumentError("fields", name, "must be name, isDir, size, mode, modTime or meta")
+		}
+	}
+	return f, nil
+}
+
+// String formats f as accepted by ParseListFields
+func (f ListFields) String() string {
+	names := []string{"name"}
+	if f.Size {
+		names = append(names, "size")
+	}
+	if f.Mode {
+		names = append(names, "mode")
+	}
+	if f.ModTime {
+		names = append(names, "modTime")
+	}
+	if f.Meta {
+		names = append(names, "meta")
+	}
+	return strings.Join(names, ",")
+}
+
+// FieldLister is implemented by file systems that list directories more cheaply when only
+// some fields of the entries are needed, e.g. by skipping a stat or metadata request per entry
+type FieldLister interface {
+	// ReadDirFields lists the contents of a directory like ReadDir; fields that are not
+	// selected may be left zero
+	ReadDirFields(path string, fields ListFields) ([]FileInfo, error)
+}
+
+// ReadDirFields lists the directory at path, filling in at least the selected fields of the
+// entries; file systems without FieldLister support list all fields
+func ReadDirFields(fs FileSystem, path string, fields ListFields) ([]FileInfo, error) {
+	if lister, ok := fs.(FieldLister); ok && !fields.All() {
+		return lister.ReadDirFields(path, fields)
+	}
+	return fs.ReadDir(path)
+}
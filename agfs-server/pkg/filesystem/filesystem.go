@@ -13,6 +13,10 @@ type MetaData struct {
 }
 
 // FileInfo represents file metadata similar to os.FileInfo
+// The ModTime of a directory is the time its entries last changed: an entry was created,
+// written, removed or renamed. Changes deeper in the tree do not propagate upwards.
+// Plugins that know the number of entries of a directory without listing it report it
+// in Meta.Content under MetaKeyChildren.
 type FileInfo struct {
 	Name    string
 	Size    int64
@@ -22,6 +26,9 @@ type FileInfo struct {
 	Meta    MetaData // Structured metadata for additional information
 }
 
+// MetaKeyChildren is the metadata key of the number of entries of a directory
+const MetaKeyChildren = "children"
+
 // FileSystem defines the interface for a POSIX-like file system
 type FileSystem interface {
 	// Create creates a new file
@@ -71,7 +78,7 @@ type FileSystem interface {
 type StreamReader interface {
 	// ReadChunk reads the next chunk of data with a timeout
 	// Returns (data, isEOF, error)
-	// - data: the chunk data (may be nil if timeout or EOF)
+	// - data: the chunk data (may be nil if timeout or EOF), valid until the next ReadChunk or Close
 	// - isEOF: true if stream is closed/ended
 	// - error: io.EOF for normal stream end, "read timeout" for timeout, or other errors
 	ReadChunk(timeout time.Duration) ([]byte, bool, error)
@@ -97,3 +104,66 @@ type Toucher interface {
 	// Returns error if the operation fails
 	Touch(path string) error
 }
+
+// Copier is implemented by file systems that copy files within themselves without passing
+// the content through the caller (e.g., memfs sharing the data, localfs copying in the kernel)
+type Copier interface {
+	// Copy copies the file src to dst, replacing dst, and returns the number of bytes copied
+	Copy(src, dst string) (int64, error)
+}
+
+// Snapshotter is implemented by file systems that can capture a consistent point-in-time view
+// of a directory tree (e.g., memfs copy-on-write, sqlfs single-statement read)
+type Snapshotter interface {
+	// Snapshot captures the directory at path and returns a read-only FileSystem
+	// whose root "/" corresponds to path at the time of the call
+	Snapshot(path string) (FileSystem, error)
+}
+
+// BulkWriter is implemented by file systems that store many files faster together than with
+// one Write each (e.g., sqlfs in multi-row transactions)
+type BulkWriter interface {
+	// BeginBulk starts a bulk load of files below dir, which is created if missing
+	BeginBulk(dir string, opts BulkOptions) (BulkSession, error)
+}
+
+// BulkOptions controls a bulk load
+type BulkOptions struct {
+	DeferIndexes bool // Drop secondary indexes during the load and rebuild them when it ends
+}
+
+// BulkEntry is a file or directory stored by a bulk load
+type BulkEntry struct {
+	Path    string
+	IsDir   bool
+	Mode    uint32    // 0 for the default mode
+	ModTime time.Time // zero for the time of the load
+	Data    []byte
+}
+
+// BulkSession stores the entries of a bulk load; its methods are safe for concurrent use
+type BulkSession interface {
+	// WriteBatch stores entries in one step, creating missing parent directories
+	// and replacing existing files
+	WriteBatch(entries []BulkEntry) error
+
+	// Close ends the load, rebuilding deferred indexes
+	Close() error
+}
+
+// Appender is implemented by file systems that append to a file without rewriting it
+// (e.g., sqlfs with a single UPDATE, localfs with O_APPEND)
+type Appender interface {
+	// Append adds data to the end of the file at path, creating it if missing,
+	// and returns the size of the file after the append
+	// Concurrent appends to the same file must not lose data
+	Append(path string, data []byte) (int64, error)
+}
+
+// WriterAt is implemented by file systems that write into a file at an offset without
+// rewriting it (e.g., localfs with pwrite)
+type WriterAt interface {
+	// WriteAt writes data at offset into the file at path, creating it if missing and
+	// zero-filling any gap after its end, and returns the size of the file after the write
+	WriteAt(path string, data []byte, offset int64) (int64, error)
+}
@@ -0,0 +1,675 @@
+// Package filesystemtest is a conformance suite for filesystem.FileSystem implementations
+//
+// Every general-purpose backend, built-in or third-party, should pass it so that clients see
+// the same semantics whichever plugin serves a path:
+//
+//	func TestConformance(t *testing.T) {
+//		filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+//			return NewMyFS(t.TempDir())
+//		})
+//	}
+//
+// Operations are only required to fail where noted, not to return a particular error type,
+// since plugins report errors in their own words.
+package filesystemtest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// baseDir is the directory each case works in; the root may hold entries of the backend itself
+const baseDir = "/fstest"
+
+// testCase is one conformance check, run against a fresh file system with baseDir created
+type testCase struct {
+	name string
+	fn   func(t *testing.T, fs filesystem.FileSystem)
+}
+
+var testCases = []testCase{
+	{"CreateAndStat", testCreateAndStat},
+	{"CreateExisting", testCreateExisting},
+	{"CreateMissingParent", testCreateMissingParent},
+	{"Mkdir", testMkdir},
+	{"EmptyNames", testEmptyNames},
+	{"PathNormalization", testPathNormalization},
+	{"SpecialNames", testSpecialNames},
+	{"WriteTruncates", testWriteTruncates},
+	{"WriteDirectory", testWriteDirectory},
+	{"BinaryData", testBinaryData},
+	{"ReadRanges", testReadRanges},
+	{"ReadPastEOF", testReadPastEOF},
+	{"ReadErrors", testReadErrors},
+	{"DeepNesting", testDeepNesting},
+	{"ReadDir", testReadDir},
+	{"Remove", testRemove},
+	{"RemoveAll", testRemoveAll},
+	{"RenameFile", testRenameFile},
+	{"RenameDirectory", testRenameDirectory},
+	{"RenameOntoExisting", testRenameOntoExisting},
+	{"RenameErrors", testRenameErrors},
+	{"Chmod", testChmod},
+	{"OpenAndOpenWrite", testOpenAndOpenWrite},
+	{"ConcurrentReaders", testConcurrentReaders},
+}
+
+// Run runs the conformance suite, calling newFS for a fresh, empty file system in every case
+func Run(t *testing.T, newFS func(t *testing.T) filesystem.FileSystem) {
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			fs := newFS(t)
+			if err := fs.Mkdir(baseDir, 0755); err != nil {
+				t.Fatalf("mkdir %s: %v", baseDir, err)
+			}
+			tc.fn(t, fs)
+		})
+	}
+}
+
+// p returns name joined to baseDir
+func p(name string) string {
+	return path.Join(baseDir, name)
+}
+
+func mustWrite(t *testing.T, fs filesystem.FileSystem, name string, data []byte) {
+	t.Helper()
+	if _, err := fs.Write(name, data); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+}
+
+func mustMkdir(t *testing.T, fs filesystem.FileSystem, name string) {
+	t.Helper()
+	if err := fs.Mkdir(name, 0755); err != nil {
+		t.Fatalf("mkdir %s: %v", name, err)
+	}
+}
+
+// readAll reads the whole file, accepting io.EOF
+func readAll(t *testing.T, fs filesystem.FileSystem, name string) []byte {
+	t.Helper()
+	data, err := fs.Read(name, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return data
+}
+
+func assertContent(t *testing.T, fs filesystem.FileSystem, name, want string) {
+	t.Helper()
+	if got := readAll(t, fs, name); string(got) != want {
+		t.Errorf("%s holds %q, want %q", name, got, want)
+	}
+}
+
+func assertNotExist(t *testing.T, fs filesystem.FileSystem, name string) {
+	t.Helper()
+	if _, err := fs.Stat(name); err == nil {
+		t.Errorf("%s exists, want it gone", name)
+	}
+}
+
+func mustStat(t *testing.T, fs filesystem.FileSystem, name string) *filesystem.FileInfo {
+	t.Helper()
+	info, err := fs.Stat(name)
+	if err != nil {
+		t.Fatalf("stat %s: %v", name, err)
+	}
+	return info
+}
+
+// names returns the sorted entry names of a directory, directories with a trailing "/"
+func names(t *testing.T, fs filesystem.FileSystem, dir string) []string {
+	t.Helper()
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("readdir %s: %v", dir, err)
+	}
+	var out []string
+	for _, info := range infos {
+		if info.IsDir {
+			out = append(out, info.Name+"/")
+		} else {
+			out = append(out, info.Name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func assertNames(t *testing.T, fs filesystem.FileSystem, dir string, want ...string) {
+	t.Helper()
+	sort.Strings(want)
+	if got := names(t, fs, dir); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("readdir %s = %v, want %v", dir, got, want)
+	}
+}
+
+func testCreateAndStat(t *testing.T, fs filesystem.FileSystem) {
+	if err := fs.Create(p("a.txt")); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	info := mustStat(t, fs, p("a.txt"))
+	if info.IsDir || info.Size != 0 || info.Name != "a.txt" {
+		t.Errorf("stat = {Name:%q Size:%d IsDir:%v}, want an empty file named a.txt", info.Name, info.Size, info.IsDir)
+	}
+	assertContent(t, fs, p("a.txt"), "")
+
+	mustWrite(t, fs, p("b.txt"), []byte("hello"))
+	if info := mustStat(t, fs, p("b.txt")); info.Size != 5 {
+		t.Errorf("size = %d, want 5", info.Size)
+	}
+	if info := mustStat(t, fs, baseDir); !info.IsDir {
+		t.Errorf("%s is not a directory", baseDir)
+	}
+}
+
+func testCreateExisting(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a.txt"), []byte("keep"))
+	if err := fs.Create(p("a.txt")); err == nil {
+		t.Errorf("create of an existing file succeeded")
+	}
+	assertContent(t, fs, p("a.txt"), "keep")
+
+	mustMkdir(t, fs, p("d"))
+	if err := fs.Create(p("d")); err == nil {
+		t.Errorf("create over a directory succeeded")
+	}
+	if info := mustStat(t, fs, p("d")); !info.IsDir {
+		t.Errorf("directory replaced by create")
+	}
+}
+
+func testCreateMissingParent(t *testing.T, fs filesystem.FileSystem) {
+	if err := fs.Create(p("missing/a.txt")); err == nil {
+		t.Errorf("create with a missing parent succeeded")
+	}
+	if _, err := fs.Write(p("missing/b.txt"), []byte("x")); err == nil {
+		t.Errorf("write with a missing parent succeeded")
+	}
+	if err := fs.Mkdir(p("missing/d"), 0755); err == nil {
+		t.Errorf("mkdir with a missing parent succeeded")
+	}
+	assertNotExist(t, fs, p("missing"))
+
+	mustWrite(t, fs, p("file"), []byte("x"))
+	if err := fs.Create(p("file/a.txt")); err == nil {
+		t.Errorf("create below a file succeeded")
+	}
+}
+
+func testMkdir(t *testing.T, fs filesystem.FileSystem) {
+	mustMkdir(t, fs, p("d"))
+	if info := mustStat(t, fs, p("d")); !info.IsDir || info.Name != "d" {
+		t.Errorf("stat = {Name:%q IsDir:%v}, want directory d", info.Name, info.IsDir)
+	}
+	assertNames(t, fs, p("d"))
+
+	if err := fs.Mkdir(p("d"), 0755); err == nil {
+		t.Errorf("mkdir of an existing directory succeeded")
+	}
+	mustWrite(t, fs, p("f"), []byte("x"))
+	if err := fs.Mkdir(p("f"), 0755); err == nil {
+		t.Errorf("mkdir over a file succeeded")
+	}
+	assertContent(t, fs, p("f"), "x")
+}
+
+func testEmptyNames(t *testing.T, fs filesystem.FileSystem) {
+	// "" and "/" both name the root directory
+	for _, name := range []string{"", "/"} {
+		if info := mustStat(t, fs, name); !info.IsDir {
+			t.Errorf("stat %q is not a directory", name)
+		}
+		if err := fs.Mkdir(name, 0755); err == nil {
+			t.Errorf("mkdir %q succeeded", name)
+		}
+		if err := fs.Create(name); err == nil {
+			t.Errorf("create %q succeeded", name)
+		}
+		if _, err := fs.Write(name, []byte("x")); err == nil {
+			t.Errorf("write %q succeeded", name)
+		}
+		if err := fs.Remove(name); err == nil {
+			t.Errorf("remove %q succeeded", name)
+		}
+	}
+
+	// A trailing slash names the directory itself, not an empty child
+	if err := fs.Create(baseDir + "/"); err == nil {
+		t.Errorf("create %s/ succeeded", baseDir)
+	}
+	if info := mustStat(t, fs, baseDir); !info.IsDir {
+		t.Errorf("%s replaced by create", baseDir)
+	}
+}
+
+func testPathNormalization(t *testing.T, fs filesystem.FileSystem) {
+	mustMkdir(t, fs, p("d"))
+	mustWrite(t, fs, p("d/a.txt"), []byte("hello"))
+
+	for _, name := range []string{
+		"fstest/d/a.txt",
+		baseDir + "//d/a.txt",
+		baseDir + "/./d/a.txt",
+		baseDir + "/d/../d/a.txt",
+	} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Errorf("stat %s: %v", name, err)
+			continue
+		}
+		if info.Name != "a.txt" {
+			t.Errorf("stat %s: name %q, want a.txt", name, info.Name)
+		}
+		assertContent(t, fs, name, "hello")
+	}
+	assertNames(t, fs, p("d")+"/", "a.txt")
+
+	// ".." cannot escape the root
+	if info, err := fs.Stat("/../" + baseDir); err != nil || !info.IsDir {
+		t.Errorf("stat /..%s: %v", baseDir, err)
+	}
+}
+
+func testSpecialNames(t *testing.T, fs filesystem.FileSystem) {
+	special := []string{"with space.txt", "日本語.txt", "semi;colon", "per%cent", "plus+sign", ".hidden", "-dash"}
+	for _, name := range special {
+		mustWrite(t, fs, p(name), []byte(name))
+	}
+	assertNames(t, fs, baseDir, special...)
+	for _, name := range special {
+		assertContent(t, fs, p(name), name)
+	}
+}
+
+func testWriteTruncates(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a.txt"), []byte("hello world"))
+	mustWrite(t, fs, p("a.txt"), []byte("hi"))
+	assertContent(t, fs, p("a.txt"), "hi")
+	if info := mustStat(t, fs, p("a.txt")); info.Size != 2 {
+		t.Errorf("size = %d, want 2", info.Size)
+	}
+
+	mustWrite(t, fs, p("a.txt"), nil)
+	assertContent(t, fs, p("a.txt"), "")
+	if info := mustStat(t, fs, p("a.txt")); info.Size != 0 {
+		t.Errorf("size = %d after an empty write, want 0", info.Size)
+	}
+}
+
+func testWriteDirectory(t *testing.T, fs filesystem.FileSystem) {
+	mustMkdir(t, fs, p("d"))
+	mustWrite(t, fs, p("d/child"), []byte("x"))
+	if _, err := fs.Write(p("d"), []byte("data")); err == nil {
+		t.Errorf("write to a directory succeeded")
+	}
+	if info := mustStat(t, fs, p("d")); !info.IsDir {
+		t.Errorf("directory replaced by write")
+	}
+	assertNames(t, fs, p("d"), "child")
+}
+
+func testBinaryData(t *testing.T, fs filesystem.FileSystem) {
+	data := make([]byte, 256*4)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	mustWrite(t, fs, p("bin"), data)
+	if got := readAll(t, fs, p("bin")); !bytes.Equal(got, data) {
+		t.Errorf("binary data changed in a round trip")
+	}
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	mustWrite(t, fs, p("large"), large)
+	if got := readAll(t, fs, p("large")); !bytes.Equal(got, large) {
+		t.Errorf("1MB file changed in a round trip: got %d bytes", len(got))
+	}
+	if info := mustStat(t, fs, p("large")); info.Size != int64(len(large)) {
+		t.Errorf("size = %d, want %d", info.Size, len(large))
+	}
+}
+
+func testReadRanges(t *testing.T, fs filesystem.FileSystem) {
+	const content = "hello world"
+	mustWrite(t, fs, p("a.txt"), []byte(content))
+
+	for _, tc := range []struct {
+		offset, size int64
+		want         string
+		eof          bool // Whether the range reaches the end of the file
+	}{
+		{0, -1, "hello world", true},
+		{0, 5, "hello", false},
+		{2, 3, "llo", false},
+		{6, -1, "world", true},
+		{6, 5, "world", true},
+		{6, 100, "world", true},
+		{10, 1, "d", true},
+		{0, 11, "hello world", true},
+		{0, 0, "", false},
+	} {
+		data, err := fs.Read(p("a.txt"), tc.offset, tc.size)
+		if err != nil && err != io.EOF {
+			t.Errorf("read(%d, %d): %v", tc.offset, tc.size, err)
+			continue
+		}
+		if string(data) != tc.want {
+			t.Errorf("read(%d, %d) = %q, want %q", tc.offset, tc.size, data, tc.want)
+		}
+		if err == io.EOF && !tc.eof {
+			t.Errorf("read(%d, %d) returned io.EOF before the end of the file", tc.offset, tc.size)
+		}
+	}
+}
+
+func testReadPastEOF(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a.txt"), []byte("hello"))
+	mustWrite(t, fs, p("empty"), nil)
+
+	for _, tc := range []struct {
+		name         string
+		offset, size int64
+	}{
+		{"a.txt", 5, -1},
+		{"a.txt", 5, 10},
+		{"a.txt", 6, -1},
+		{"a.txt", 1 << 40, 1},
+		{"empty", 0, 10},
+		{"empty", 1, -1},
+	} {
+		data, err := fs.Read(p(tc.name), tc.offset, tc.size)
+		if err != io.EOF {
+			t.Errorf("read %s(%d, %d): error %v, want io.EOF", tc.name, tc.offset, tc.size, err)
+		}
+		if len(data) != 0 {
+			t.Errorf("read %s(%d, %d) = %q past the end of the file", tc.name, tc.offset, tc.size, data)
+		}
+	}
+}
+
+func testReadErrors(t *testing.T, fs filesystem.FileSystem) {
+	if _, err := fs.Read(p("missing"), 0, -1); err == nil || err == io.EOF {
+		t.Errorf("read of a missing file: error %v", err)
+	}
+	mustMkdir(t, fs, p("d"))
+	if _, err := fs.Read(p("d"), 0, -1); err == nil || err == io.EOF {
+		t.Errorf("read of a directory: error %v", err)
+	}
+	if _, err := fs.Stat(p("missing")); err == nil {
+		t.Errorf("stat of a missing file succeeded")
+	}
+	if _, err := fs.Open(p("missing")); err == nil {
+		t.Errorf("open of a missing file succeeded")
+	}
+}
+
+func testDeepNesting(t *testing.T, fs filesystem.FileSystem) {
+	const depth = 32
+	dir := baseDir
+	for i := 0; i < depth; i++ {
+		dir = path.Join(dir, fmt.Sprintf("d%02d", i))
+		mustMkdir(t, fs, dir)
+	}
+	mustWrite(t, fs, path.Join(dir, "leaf.txt"), []byte("deep"))
+	assertContent(t, fs, path.Join(dir, "leaf.txt"), "deep")
+
+	parent := baseDir
+	for i := 0; i < depth; i++ {
+		assertNames(t, fs, parent, fmt.Sprintf("d%02d/", i))
+		parent = path.Join(parent, fmt.Sprintf("d%02d", i))
+	}
+	assertNames(t, fs, dir, "leaf.txt")
+
+	if err := fs.RemoveAll(p("d00")); err != nil {
+		t.Fatalf("removeall: %v", err)
+	}
+	assertNotExist(t, fs, p("d00"))
+	assertNotExist(t, fs, path.Join(dir, "leaf.txt"))
+	assertNames(t, fs, baseDir)
+}
+
+func testReadDir(t *testing.T, fs filesystem.FileSystem) {
+	assertNames(t, fs, baseDir)
+
+	mustWrite(t, fs, p("a.txt"), []byte("12345"))
+	mustWrite(t, fs, p("b.txt"), nil)
+	mustMkdir(t, fs, p("sub"))
+	mustWrite(t, fs, p("sub/nested.txt"), []byte("x"))
+	assertNames(t, fs, baseDir, "a.txt", "b.txt", "sub/")
+
+	infos, err := fs.ReadDir(baseDir)
+	if err != nil {
+		t.Fatalf("readdir: %v", err)
+	}
+	for _, info := range infos {
+		if info.Name == "a.txt" && info.Size != 5 {
+			t.Errorf("readdir size of a.txt = %d, want 5", info.Size)
+		}
+	}
+
+	if _, err := fs.ReadDir(p("a.txt")); err == nil {
+		t.Errorf("readdir of a file succeeded")
+	}
+	if _, err := fs.ReadDir(p("missing")); err == nil {
+		t.Errorf("readdir of a missing directory succeeded")
+	}
+}
+
+func testRemove(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a.txt"), []byte("x"))
+	if err := fs.Remove(p("a.txt")); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	assertNotExist(t, fs, p("a.txt"))
+
+	mustMkdir(t, fs, p("empty"))
+	if err := fs.Remove(p("empty")); err != nil {
+		t.Fatalf("remove empty directory: %v", err)
+	}
+	assertNotExist(t, fs, p("empty"))
+
+	mustMkdir(t, fs, p("full"))
+	mustWrite(t, fs, p("full/child"), []byte("x"))
+	if err := fs.Remove(p("full")); err == nil {
+		t.Errorf("remove of a non-empty directory succeeded")
+	}
+	assertContent(t, fs, p("full/child"), "x")
+
+	if err := fs.Remove(p("missing")); err == nil {
+		t.Errorf("remove of a missing file succeeded")
+	}
+}
+
+func testRemoveAll(t *testing.T, fs filesystem.FileSystem) {
+	mustMkdir(t, fs, p("tree"))
+	mustMkdir(t, fs, p("tree/sub"))
+	mustWrite(t, fs, p("tree/a"), []byte("a"))
+	mustWrite(t, fs, p("tree/sub/b"), []byte("b"))
+	mustWrite(t, fs, p("keep"), []byte("k"))
+
+	if err := fs.RemoveAll(p("tree")); err != nil {
+		t.Fatalf("removeall: %v", err)
+	}
+	assertNotExist(t, fs, p("tree"))
+	assertNotExist(t, fs, p("tree/sub/b"))
+	assertNames(t, fs, baseDir, "keep")
+
+	if err := fs.RemoveAll(p("keep")); err != nil {
+		t.Fatalf("removeall of a file: %v", err)
+	}
+	assertNotExist(t, fs, p("keep"))
+
+	// Recreating a removed path starts empty
+	mustMkdir(t, fs, p("tree"))
+	assertNames(t, fs, p("tree"))
+}
+
+func testRenameFile(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a.txt"), []byte("content"))
+	if err := fs.Rename(p("a.txt"), p("b.txt")); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	assertNotExist(t, fs, p("a.txt"))
+	assertContent(t, fs, p("b.txt"), "content")
+	if info := mustStat(t, fs, p("b.txt")); info.Name != "b.txt" {
+		t.Errorf("renamed file reports name %q", info.Name)
+	}
+
+	mustMkdir(t, fs, p("other"))
+	if err := fs.Rename(p("b.txt"), p("other/c.txt")); err != nil {
+		t.Fatalf("rename across directories: %v", err)
+	}
+	assertNotExist(t, fs, p("b.txt"))
+	assertContent(t, fs, p("other/c.txt"), "content")
+	assertNames(t, fs, baseDir, "other/")
+}
+
+func testRenameDirectory(t *testing.T, fs filesystem.FileSystem) {
+	mustMkdir(t, fs, p("src"))
+	mustMkdir(t, fs, p("src/sub"))
+	mustWrite(t, fs, p("src/a"), []byte("a"))
+	mustWrite(t, fs, p("src/sub/b"), []byte("b"))
+
+	if err := fs.Rename(p("src"), p("dst")); err != nil {
+		t.Fatalf("rename directory: %v", err)
+	}
+	assertNotExist(t, fs, p("src"))
+	assertNotExist(t, fs, p("src/a"))
+	assertNames(t, fs, p("dst"), "a", "sub/")
+	assertContent(t, fs, p("dst/a"), "a")
+	assertContent(t, fs, p("dst/sub/b"), "b")
+
+	// The old name is free again
+	mustMkdir(t, fs, p("src"))
+	assertNames(t, fs, p("src"))
+}
+
+// testRenameOntoExisting accepts either POSIX replacement or a refusal, but not a mix of both
+func testRenameOntoExisting(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("src"), []byte("new"))
+	mustWrite(t, fs, p("dst"), []byte("old"))
+
+	if err := fs.Rename(p("src"), p("dst")); err != nil {
+		assertContent(t, fs, p("src"), "new")
+		assertContent(t, fs, p("dst"), "old")
+		return
+	}
+	assertNotExist(t, fs, p("src"))
+	assertContent(t, fs, p("dst"), "new")
+}
+
+func testRenameErrors(t *testing.T, fs filesystem.FileSystem) {
+	if err := fs.Rename(p("missing"), p("b")); err == nil {
+		t.Errorf("rename of a missing file succeeded")
+	}
+	assertNotExist(t, fs, p("b"))
+
+	mustWrite(t, fs, p("a"), []byte("a"))
+	if err := fs.Rename(p("a"), p("missing/b")); err == nil {
+		t.Errorf("rename into a missing directory succeeded")
+	}
+	assertContent(t, fs, p("a"), "a")
+}
+
+func testChmod(t *testing.T, fs filesystem.FileSystem) {
+	mustWrite(t, fs, p("a"), []byte("x"))
+	if err := fs.Chmod(p("a"), 0600); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if info := mustStat(t, fs, p("a")); info.Mode&0777 != 0600 {
+		t.Errorf("mode = %o, want 600", info.Mode&0777)
+	}
+	assertContent(t, fs, p("a"), "x")
+
+	if err := fs.Chmod(p("missing"), 0600); err == nil {
+		t.Errorf("chmod of a missing file succeeded")
+	}
+}
+
+func testOpenAndOpenWrite(t *testing.T, fs filesystem.FileSystem) {
+	w, err := fs.OpenWrite(p("a.txt"))
+	if err != nil {
+		t.Fatalf("openwrite: %v", err)
+	}
+	if _, err := io.WriteString(w, "hello "); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := io.WriteString(w, "world"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	assertContent(t, fs, p("a.txt"), "hello world")
+
+	r, err := fs.Open(p("a.txt"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("open read %q, want %q", data, "hello world")
+	}
+}
+
+func testConcurrentReaders(t *testing.T, fs filesystem.FileSystem) {
+	data := bytes.Repeat([]byte("concurrent readers "), 8192)
+	mustWrite(t, fs, p("shared"), data)
+
+	const readers, rounds = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for round := 0; round < rounds; round++ {
+				offset := int64((i*rounds + round) * 997 % len(data))
+				chunk, err := fs.Read(p("shared"), offset, 4096)
+				if err != nil && err != io.EOF {
+					t.Errorf("reader %d: read: %v", i, err)
+					return
+				}
+				end := offset + 4096
+				if end > int64(len(data)) {
+					end = int64(len(data))
+				}
+				if !bytes.Equal(chunk, data[offset:end]) {
+					t.Errorf("reader %d: read(%d, 4096) returned wrong data", i, offset)
+					return
+				}
+
+				r, err := fs.Open(p("shared"))
+				if err != nil {
+					t.Errorf("reader %d: open: %v", i, err)
+					return
+				}
+				all, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Errorf("reader %d: read all: %v", i, err)
+					return
+				}
+				if !bytes.Equal(all, data) {
+					t.Errorf("reader %d: open returned %d bytes, want %d", i, len(all), len(data))
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+package filesystem
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Formats of the content of control files
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// FormatMarkdown is offered by READMEs, for readers such as web UIs rendering them
+	FormatMarkdown = "markdown"
+)
+
+// Metadata keys declaring the format of a file: MetaKeyFormat is the format Read returns,
+// MetaKeyFormats the comma-separated formats ReadFormat offers
+const (
+	MetaKeyFormat  = "format"
+	MetaKeyFormats = "formats"
+)
+
+// FormatReader is implemented by file systems whose control files can be read in more than
+// one format, e.g. a queue message as JSON or as its bare data
+type FormatReader interface {
+	// ReadFormat reads the file at path like Read, in format if the file offers it and in
+	// its default format otherwise; it returns the format of the data, "" for files without
+	// a declared format
+	ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error)
+}
+
+// ReadFormat reads the file at path of fs in format if fs offers it, and as Read does
+// otherwise; it returns the format of the data, "" if undeclared
+func ReadFormat(fs FileSystem, path string, offset int64, size int64, format string) ([]byte, string, error) {
+	if reader, ok := fs.(FormatReader); ok && format != "" {
+		return reader.ReadFormat(path, offset, size, format)
+	}
+	data, err := fs.Read(path, offset, size)
+	return data, "", err
+}
+
+// FormatMeta returns the metadata content declaring that a file is read in format by
+// default and offers the formats of offered
+func FormatMeta(format string, offered ...string) map[string]string {
+	content := map[string]string{MetaKeyFormat: format}
+	if len(offered) > 0 {
+		content[MetaKeyFormats] = strings.Join(offered, ",")
+	}
+	return content
+}
+
+// FormatFromAccept returns the format preferred by an HTTP Accept header among JSON, text
+// and Markdown, "" if it accepts none of them specifically
+func FormatFromAccept(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		var format string
+		switch strings.ToLower(strings.TrimSpace(fields[0])) {
+		case "application/json":
+			format = FormatJSON
+		case "text/plain":
+			format = FormatText
+		case "text/markdown":
+			format = FormatMarkdown
+		default:
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// FormatContentType returns the media type of format, "" if it is not a known format
+func FormatContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatText:
+		return "text/plain; charset=utf-8"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return ""
+}
@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+type languageKey struct{}
+
+// WithLanguage returns ctx carrying the language the reader prefers, e.g. "zh", used by
+// READMEs offered in more than one language
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	if lang == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// LanguageFromContext returns the language the reader of ctx prefers, "" if none was set
+func LanguageFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	lang, _ := ctx.Value(languageKey{}).(string)
+	return lang
+}
+
+// LanguageFromAccept returns the language preferred by an HTTP Accept-Language header as
+// its primary subtag in lower case, e.g. "zh" for "zh-CN", "" if it names none
+func LanguageFromAccept(accept string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	primary, _, _ := strings.Cut(best, "-")
+	return primary
+}
@@ -0,0 +1,116 @@
+package filesystem
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keys a listing page can be sorted by, named as in the JSON of listings
+const (
+	SortName    = "name"
+	SortModTime = "modTime"
+	SortSize    = "size"
+)
+
+// ListPage selects a page of a directory listing: the entries ordered by Sort, reversed if
+// Desc, skipping the first Offset and keeping at most Limit of them, all if Limit is 0
+type ListPage struct {
+	Sort   string
+	Desc   bool
+	Offset int
+	Limit  int
+}
+
+// IsZero reports whether p selects the whole listing in the file system's order
+func (p ListPage) IsZero() bool {
+	return p == ListPage{}
+}
+
+// ParseListPage parses the sort, offset and limit parameters of a listing; sort is a key
+// optionally prefixed with "-" for descending order, e.g. "-modTime"
+func ParseListPage(sortKey, offset, limit string) (ListPage, error) {
+	var p ListPage
+	if sortKey != "" {
+		p.Desc = strings.HasPrefix(sortKey, "-")
+		p.Sort = strings.TrimPrefix(sortKey, "-")
+		switch p.Sort {
+		case SortName, SortModTime, SortSize:
+		default:
+			return ListPage{}, NewInvalidArgumentError("sort", sortKey, "must be name, modTime or size, optionally prefixed with -")
+		}
+	}
+	for _, param := range []struct {
+		name, value string
+		dst         *int
+	}{{"offset", offset, &p.Offset}, {"limit", limit, &p.Limit}} {
+		if param.value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(param.value)
+		if err != nil || n < 0 {
+			return ListPage{}, NewInvalidArgumentError(param.name, param.value, "must be a non-negative integer")
+		}
+		*param.dst = n
+	}
+	return p, nil
+}
+
+// Apply orders infos as p selects, by name if p has no Sort, and returns p's page of them
+// and whether entries follow it
+func (p ListPage) Apply(infos []FileInfo) ([]FileInfo, bool) {
+	less := func(a, b *FileInfo) bool {
+		switch p.Sort {
+		case SortModTime:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		case SortSize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		}
+		return a.Name < b.Name
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		if p.Desc {
+			return less(&infos[j], &infos[i])
+		}
+		return less(&infos[i], &infos[j])
+	})
+	return p.Slice(infos)
+}
+
+// Slice returns p's page of infos, which are in the order of p, and whether entries follow it
+func (p ListPage) Slice(infos []FileInfo) ([]FileInfo, bool) {
+	if p.Offset >= len(infos) {
+		return []FileInfo{}, false
+	}
+	infos = infos[p.Offset:]
+	if p.Limit > 0 && len(infos) > p.Limit {
+		return infos[:p.Limit], true
+	}
+	return infos, false
+}
+
+// PageLister is implemented by file systems that list a page of a directory without reading
+// the whole directory, e.g. a queue whose backend pages through its messages
+type PageLister interface {
+	// ReadDirPage lists the entries of page of the directory at path and reports whether
+	// entries follow them; without a Sort, the entries are in the file system's order
+	ReadDirPage(path string, page ListPage) ([]FileInfo, bool, error)
+}
+
+// ReadDirPage lists page of the directory at path of fs; file systems without PageLister
+// support list the whole directory, ordered by name without a Sort
+func ReadDirPage(fs FileSystem, path string, page ListPage) ([]FileInfo, bool, error) {
+	if lister, ok := fs.(PageLister); ok {
+		return lister.ReadDirPage(path, page)
+	}
+	infos, err := fs.ReadDir(path)
+	if err != nil {
+		return nil, false, err
+	}
+	infos, more := page.Apply(infos)
+	return infos, more, nil
+}
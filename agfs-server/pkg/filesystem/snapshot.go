@@ -0,0 +1,28 @@
+package filesystem
+
+import "errors"
+
+// OpenSnapshot returns a file system and root path to read a snapshot of p from
+// If fs supports snapshots, a frozen copy rooted at "/" is returned and consistent is true;
+// otherwise the live file system is returned so that callers walk it directly
+func OpenSnapshot(fs FileSystem, p string) (snap FileSystem, root string, consistent bool, err error) {
+	p = NormalizePath(p)
+	info, err := fs.Stat(p)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	if info.IsDir {
+		if snapshotter, ok := fs.(Snapshotter); ok {
+			snap, err := snapshotter.Snapshot(p)
+			if err == nil {
+				return snap, "/", true, nil
+			}
+			if !errors.Is(err, ErrNotSupported) {
+				return nil, "", false, err
+			}
+		}
+	}
+
+	return fs, p, false, nil
+}
@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"sort"
+)
+
+// SkipDir can be returned by a WalkFunc to skip the contents of a directory
+var SkipDir = errors.New("skip this directory")
+
+// WalkFunc is called by Walk for each file or directory
+// If err is non-nil, info is nil and the function decides whether to continue
+type WalkFunc func(path string, info *FileInfo, err error) error
+
+// Walk walks the tree rooted at root, calling fn for each file or directory,
+// including root itself. Entries are visited in lexical order.
+func Walk(fs FileSystem, root string, fn WalkFunc) error {
+	root = NormalizePath(root)
+	info, err := fs.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = walk(fs, root, info, fn)
+	if err == SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(fs FileSystem, p string, info *FileInfo, fn WalkFunc) error {
+	if err := fn(p, info, nil); err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return nil
+	}
+
+	entries, err := fs.ReadDir(p)
+	if err != nil {
+		return fn(p, nil, err)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	for i := range entries {
+		child := path.Join(p, entries[i].Name)
+		if err := walk(fs, child, &entries[i], fn); err != nil {
+			if err == SkipDir && entries[i].IsDir {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package filesystem
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types reported by watchers
+const (
+	EventCreate = "create"
+	EventWrite  = "write"
+	EventRemove = "remove"
+	EventRename = "rename"
+)
+
+// WatchBuffer is the number of events a watch buffers; events for a watch whose consumer
+// falls further behind are dropped
+const WatchBuffer = 256
+
+// Event is a change of a file or directory reported by a Watcher
+type Event struct {
+	Type    string    `json:"type"`              // EventCreate, EventWrite, EventRemove or EventRename
+	Path    string    `json:"path"`              // Path of the file, its new path for renames
+	OldPath string    `json:"oldPath,omitempty"` // Previous path of a renamed file
+	IsDir   bool      `json:"isDir"`
+	Time    time.Time `json:"time"`
+}
+
+// Watcher is implemented by file systems that report changes to their files, including
+// changes made behind the API where the backend allows it (e.g., localfs seeing other
+// processes write to its directory)
+type Watcher interface {
+	// Watch reports the changes of path and its entries, or with recursive of everything
+	// below it, until ctx is done, when the channel is closed
+	Watch(ctx context.Context, path string, recursive bool) (<-chan Event, error)
+}
+
+// Watched reports whether a change of p is reported to a watch of dir
+func Watched(dir string, recursive bool, p string) bool {
+	dir, p = NormalizePath(dir), NormalizePath(p)
+	if p == dir {
+		return true
+	}
+	if recursive {
+		return dir == "/" || strings.HasPrefix(p, dir+"/")
+	}
+	return path.Dir(p) == dir
+}
+
+// Watchers fans events out to the watches covering them; file systems implementing Watcher
+// keep one and Emit their changes into it
+// The zero value is ready to use.
+type Watchers struct {
+	mu      sync.Mutex
+	watches map[*watch]struct{}
+}
+
+type watch struct {
+	path      string
+	recursive bool
+	ch        chan Event
+}
+
+// Watch implements Watcher
+func (w *Watchers) Watch(ctx context.Context, p string, recursive bool) (<-chan Event, error) {
+	wt := &watch{path: NormalizePath(p), recursive: recursive, ch: make(chan Event, WatchBuffer)}
+	w.mu.Lock()
+	if w.watches == nil {
+		w.watches = make(map[*watch]struct{})
+	}
+	w.watches[wt] = struct{}{}
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		delete(w.watches, wt)
+		close(wt.ch)
+		w.mu.Unlock()
+	}()
+	return wt.ch, nil
+}
+
+// Covered reports whether a watch covers p, recursively if recursive is set
+// localfs uses it to decide which new directories to watch.
+func (w *Watchers) Covered(p string, recursive bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wt := range w.watches {
+		if (!recursive || wt.recursive) && Watched(wt.path, wt.recursive, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit sends e to the watches covering its path or, for renames, its old path
+// It never blocks: a watch whose buffer is full misses the event.
+func (w *Watchers) Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wt := range w.watches {
+		if !Watched(wt.path, wt.recursive, e.Path) && (e.OldPath == "" || !Watched(wt.path, wt.recursive, e.OldPath)) {
+			continue
+		}
+		select {
+		case wt.ch <- e:
+		default:
+		}
+	}
+}
+
+// PollWatch implements Watch for file systems that cannot be notified of changes by
+// comparing the files list returns, by path, every interval (see ListTree); it reports
+// creations, writes (a new size or modification time) and removals, renames show as a
+// removal and a creation
+func PollWatch(ctx context.Context, list func() (map[string]FileInfo, error), interval time.Duration) (<-chan Event, error) {
+	prev, err := list()
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Event, WatchBuffer)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur, err := list()
+			if err != nil {
+				// The watched directory is gone or the backend is unavailable; report
+				// what disappeared once it is back
+				if IsNotFound(err) {
+					cur = map[string]FileInfo{}
+				} else {
+					continue
+				}
+			}
+			now := time.Now()
+			send := func(e Event) {
+				e.Time = now
+				select {
+				case ch <- e:
+				default:
+				}
+			}
+			for name, info := range cur {
+				old, ok := prev[name]
+				switch {
+				case !ok:
+					send(Event{Type: EventCreate, Path: name, IsDir: info.IsDir})
+				case !info.IsDir && (old.Size != info.Size || !old.ModTime.Equal(info.ModTime)):
+					send(Event{Type: EventWrite, Path: name})
+				}
+			}
+			for name, info := range prev {
+				if _, ok := cur[name]; !ok {
+					send(Event{Type: EventRemove, Path: name, IsDir: info.IsDir})
+				}
+			}
+			prev = cur
+		}
+	}()
+	return ch, nil
+}
+
+// ListTree returns the file information of p and its entries, or with recursive of its whole
+// tree, by path
+func ListTree(fs FileSystem, p string, recursive bool) (map[string]FileInfo, error) {
+	p = NormalizePath(p)
+	info, err := fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]FileInfo{p: *info}
+	if !info.IsDir {
+		return tree, nil
+	}
+	if recursive {
+		err = Walk(fs, p, func(name string, info *FileInfo, err error) error {
+			if err != nil && name != p && IsNotFound(err) {
+				return nil // Removed while walking, the next poll reports it
+			}
+			if err != nil {
+				return err
+			}
+			tree[name] = *info
+			return nil
+		})
+		return tree, err
+	}
+	entries, err := fs.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		tree[path.Join(p, e.Name)] = e
+	}
+	return tree, nil
+}
@@ -0,0 +1,33 @@
+package filesystem
+
+import (
+	"io"
+)
+
+// WriteAt writes data at offset into the file at path, creating it if missing, and returns
+// the size of the file after the write
+// File systems without WriterAt support are read and written back; callers must serialize
+// such writes to one path, e.g. with PathLocks
+func WriteAt(fs FileSystem, path string, data []byte, offset int64) (int64, error) {
+	if offset < 0 {
+		return 0, NewInvalidArgumentError("offset", offset, "must not be negative")
+	}
+	if writer, ok := fs.(WriterAt); ok {
+		return writer.WriteAt(path, data, offset)
+	}
+	old, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		if !IsNotFound(err) {
+			return 0, err
+		}
+		old = nil
+	}
+	size := max(int64(len(old)), offset+int64(len(data)))
+	content := make([]byte, size)
+	copy(content, old)
+	copy(content[offset:], data)
+	if _, err := fs.Write(path, content); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -38,5 +38,29 @@ func (w *BufferedWriter) Close() error {
 	return err
 }
 
-// Ensure BufferedWriter implements io.WriteCloser
-var _ io.WriteCloser = (*BufferedWriter)(nil)
+// Abort drops the buffered data without writing it.
+func (w *BufferedWriter) Abort() error {
+	w.buf = nil
+	return nil
+}
+
+// Aborter is implemented by writers of OpenWrite that can end a write without committing
+// it, e.g. when the data being streamed fails; the file keeps its previous content
+type Aborter interface {
+	Abort() error
+}
+
+// AbortWrite ends w without committing what was written if w is an Aborter, and closes it
+// otherwise, which may leave the partial data in the file
+func AbortWrite(w io.WriteCloser) error {
+	if a, ok := w.(Aborter); ok {
+		return a.Abort()
+	}
+	return w.Close()
+}
+
+// Ensure BufferedWriter implements io.WriteCloser and Aborter
+var (
+	_ io.WriteCloser = (*BufferedWriter)(nil)
+	_ Aborter        = (*BufferedWriter)(nil)
+)
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+)
+
+// SetACL sets the access control list edited through the acl endpoints
+func (h *Handler) SetACL(a *acl.ACL) {
+	h.acl = a
+}
+
+// ACL handles /acl: GET returns the policy, PUT replaces it, POST adds or replaces a rule
+// and DELETE ?principal=<name>&path=<path> removes one
+func (h *Handler) ACL(w http.ResponseWriter, r *http.Request) {
+	if h.acl == nil {
+		writeError(w, http.StatusNotFound, "acl is not enabled")
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.acl.Policy())
+		return
+	case http.MethodPut:
+		var policy acl.Policy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		err = h.acl.SetPolicy(policy)
+	case http.MethodPost:
+		var rule acl.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		err = h.acl.SetRule(rule)
+	case http.MethodDelete:
+		q := r.URL.Query()
+		if q.Get("principal") == "" || q.Get("path") == "" {
+			writeError(w, http.StatusBadRequest, "principal and path parameters are required")
+			return
+		}
+		err = h.acl.RemoveRule(q.Get("principal"), q.Get("path"))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, h.acl.Policy())
+}
+
+// CheckACL handles GET /acl/check?principal=<name>&path=<path>
+// It reports whether the principal may read and write the path, and the rule deciding it
+func (h *Handler) CheckACL(w http.ResponseWriter, r *http.Request) {
+	if h.acl == nil {
+		writeError(w, http.StatusNotFound, "acl is not enabled")
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("principal") == "" || q.Get("path") == "" {
+		writeError(w, http.StatusBadRequest, "principal and path parameters are required")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.acl.Decide(q.Get("principal"), q.Get("path")))
+}
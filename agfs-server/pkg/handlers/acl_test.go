@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/analytics"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+)
+
+// The results of the search index, the tag store and the analytics only show the paths the
+// principal of the request may read
+func TestIndexResultsACL(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	for _, path := range []string{"/public", "/private"} {
+		if err := mfs.MountPlugin("memfs", path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := acl.New(config.ACLConfig{Rules: []config.ACLRuleConfig{{Principal: "*", Path: "/private"}}}, principalOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetAccessControl(a)
+
+	indexer, err := search.NewIndexer(mfs, config.SearchConfig{Paths: []string{"/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := tags.Open(filepath.Join(t.TempDir(), "tags.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	collector, err := analytics.NewCollector(config.AnalyticsConfig{}, func(path string) string {
+		mount, _ := mfs.LookupMount(path)
+		return mount.Path
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.Start(mfs)
+	defer collector.Stop()
+	for _, p := range []string{"/public/report.txt", "/private/report.txt"} {
+		if _, err := mfs.Write(p, []byte("quarterly report")); err != nil {
+			t.Fatal(err)
+		}
+		indexer.Index().Add(p, 16, time.Now(), "text/plain", []byte("quarterly report"))
+		if err := store.Add(p, "finance"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewHandler(mfs)
+	h.SetSearchIndexer(indexer)
+	h.SetTagStore(store)
+	h.SetAnalytics(collector)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	get := func(principal, url string, v interface{}) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if principal != "" {
+			req = req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK && v != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code
+	}
+
+	for _, tc := range []struct {
+		principal string
+		visible   int
+	}{{"bob", 1}, {"", 2}} {
+		var hits SearchResponse
+		if status := get(tc.principal, "/api/v1/search?q=report", &hits); status != http.StatusOK || hits.Total != tc.visible {
+			t.Errorf("%q search = %d %+v", tc.principal, status, hits)
+		}
+		var tagged TaggedPathsResponse
+		if status := get(tc.principal, "/api/v1/tags?tag=finance", &tagged); status != http.StatusOK || len(tagged.Paths) != tc.visible {
+			t.Errorf("%q tagged paths = %d %+v", tc.principal, status, tagged)
+		}
+		var list TagListResponse
+		if status := get(tc.principal, "/api/v1/tags", &list); status != http.StatusOK || len(list.Tags) != 1 || list.Tags[0].Count != tc.visible {
+			t.Errorf("%q tag list = %d %+v", tc.principal, status, list)
+		}
+		var hot analytics.Report
+		if status := get(tc.principal, "/api/v1/analytics/hot", &hot); status != http.StatusOK || len(hot.Files) != tc.visible {
+			t.Errorf("%q hot files = %d %+v", tc.principal, status, hot)
+		}
+	}
+	if status := get("bob", "/api/v1/tags?path=/private/report.txt", nil); status != http.StatusForbidden {
+		t.Errorf("tags of a denied path: status %d", status)
+	}
+	var limited SearchResponse
+	if status := get("bob", "/api/v1/search?q=report&limit=1", &limited); status != http.StatusOK || limited.Total != 1 || limited.Hits[0].Path != "/public/report.txt" {
+		t.Errorf("limited search = %d %+v", status, limited)
+	}
+}
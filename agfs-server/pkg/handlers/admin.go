@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AdminRoutes are the routes administering the server rather than its files: mounts, plugins,
+// jobs, the ACL, holds, restores and the background services; a trailing "/" includes the
+// subtree, and a leading method limits the route to requests with it. With auth, they require the admin role; with server.admin_address, they are only served
+// on the admin listener. The endpoints plugins serve below /api/v1/plugins/ are user routes.
+var AdminRoutes = []string{
+	"/api/v1/mount",
+	"/api/v1/mounts", // Lists the configurations of the mounts, credentials included
+	"/api/v1/unmount",
+	"/api/v1/shadow",
+	"/api/v1/plugins",
+	"/api/v1/plugins/load",
+	"/api/v1/plugins/unload",
+	"/api/v1/snapshot/restore",
+	"/api/v1/jobs",
+	"/api/v1/jobs/",
+	"/api/v1/acl",
+	"/api/v1/acl/",
+	"POST /api/v1/holds",
+	"DELETE /api/v1/holds",
+	"/api/v1/backups",
+	"/api/v1/backups/",
+	"/api/v1/lifecycle",
+	"/api/v1/lifecycle/",
+	"/api/v1/queue-archive",
+	"/api/v1/queue-archive/",
+	"/api/v1/file-drop",
+	"/api/v1/file-drop/",
+	"/api/v1/migrations",
+	"/api/v1/migrations/",
+	"/api/v1/bench",
+	"/debug/",
+}
+
+// probeRoutes are served on both listeners, so either can be health-checked
+var probeRoutes = []string{"/api/v1/health", "/livez", "/readyz"}
+
+// IsAdminRoute reports whether a request with method to path is one of the AdminRoutes
+func IsAdminRoute(method, path string) bool {
+	return matchRoute(AdminRoutes, method, path)
+}
+
+func matchRoute(routes []string, method, path string) bool {
+	for _, r := range routes {
+		if m, p, ok := strings.Cut(r, " "); ok {
+			if m != method {
+				continue
+			}
+			r = p
+		}
+		if path == r || (strings.HasSuffix(r, "/") && strings.HasPrefix(path, r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminListenerMiddleware confines a listener to the admin routes, or with admin false to the
+// other routes, answering the rest with 404 so each API is only reachable where it is bound
+func AdminListenerMiddleware(admin bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsAdminRoute(r.Method, r.URL.Path) != admin && !matchRoute(probeRoutes, r.Method, r.URL.Path) {
+			if admin {
+				writeError(w, http.StatusNotFound, "not an admin route")
+			} else {
+				writeError(w, http.StatusNotFound, "admin routes are served on the admin listener")
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
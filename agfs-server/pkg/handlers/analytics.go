@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/analytics"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// DefaultHotWindow is the window of the hot files report when the request does not set one
+const DefaultHotWindow = time.Hour
+
+// SetAnalytics sets the collector used by the analytics endpoint
+func (h *Handler) SetAnalytics(c *analytics.Collector) {
+	h.analytics = c
+}
+
+// HotFiles handles GET /analytics/hot?window=<duration>&by=<order>&path=<prefix>&limit=<n>
+// It lists the files and mounts with the most accesses within the window
+func (h *Handler) HotFiles(w http.ResponseWriter, r *http.Request) {
+	if h.analytics == nil {
+		writeError(w, http.StatusNotFound, "analytics are not enabled")
+		return
+	}
+
+	q := r.URL.Query()
+	window := DefaultHotWindow
+	if s := q.Get("window"); s != "" {
+		d, err := pluginconfig.ParseDuration(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid window parameter")
+			return
+		}
+		window = d
+	}
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	readable := func(path string) bool { return h.readable(r, path) }
+	report, err := h.analytics.Report(window, q.Get("by"), q.Get("path"), limit, readable)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
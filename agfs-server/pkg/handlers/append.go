@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// AppendResponse represents the result of an append
+type AppendResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	Bytes   int    `json:"bytes"` // Bytes appended
+	Size    int64  `json:"size"`  // Size of the file after the append
+}
+
+// AppendFile handles POST /append?path=<path>&newline=<true|false>
+// The request body is added to the end of the file, which is created if missing. Appends
+// to the same path never interleave or lose each other, so many writers can share a log
+// file. With newline=true a missing trailing newline is added to the body
+func (h *Handler) AppendFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	_, write := h.limiters(r, path)
+	data, err := io.ReadAll(throttle.NewReader(r.Context(), r.Body, write...))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+	if r.URL.Query().Get("newline") == "true" && (len(data) == 0 || data[len(data)-1] != '\n') {
+		data = append(data, '\n')
+	}
+
+	fs := h.requestFS(r)
+	if _, ok := fs.(filesystem.Appender); !ok {
+		// Appends through other views are read-modify-write, serialize them here
+		defer h.pathLocks.Lock(path)()
+	}
+	size, err := filesystem.Append(fs, path, data)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, AppendResponse{Message: "appended", Path: path, Bytes: len(data), Size: size})
+}
@@ -0,0 +1,63 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Concurrent appends to a file all land in it, whole
+func TestAppend(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	appendLine := func(query, body string) (int, handlers.AppendResponse) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/v1/append?"+query, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			return 0, handlers.AppendResponse{}
+		}
+		defer resp.Body.Close()
+		var result handlers.AppendResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if status, _ := appendLine("path=/memfs/log&newline=true", fmt.Sprintf("line %02d", i)); status != http.StatusOK {
+				t.Errorf("append %d: status %d", i, status)
+			}
+		}(i)
+	}
+	wg.Wait()
+	data, err := srv.FS.Read("/memfs/log", 0, -1)
+	if err != nil && len(data) == 0 {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	sort.Strings(lines)
+	if len(lines) != 20 || lines[0] != "line 00" || lines[19] != "line 19" {
+		t.Errorf("appended lines = %q", lines)
+	}
+
+	status, result := appendLine("path=/memfs/log", "tail\n")
+	if status != http.StatusOK || result.Bytes != 5 || result.Size != int64(len(data)+5) {
+		t.Errorf("append = %d %+v", status, result)
+	}
+	if status, _ := appendLine("", "x"); status != http.StatusBadRequest {
+		t.Errorf("append without path: status %d", status)
+	}
+	if status, _ := appendLine("path=/nonexistent/log", "x"); status != http.StatusNotFound {
+		t.Errorf("append outside the mounts: status %d", status)
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImportResponse represents the result of an archive import
+type ImportResponse struct {
+	Message string   `json:"message"`
+	Path    string   `json:"path"`
+	DryRun  bool     `json:"dryRun"`
+	Files   int      `json:"files"`
+	Dirs    int      `json:"dirs"`
+	Bytes   int64    `json:"bytes"`
+	Entries []string `json:"entries,omitempty"` // Paths that would be written (dry run only)
+}
+
+// archiveOptions parses the format, include and exclude query parameters
+func archiveOptions(r *http.Request) (archive.Format, archive.Options, error) {
+	q := r.URL.Query()
+	format, err := archive.ParseFormat(q.Get("format"))
+	if err != nil {
+		return "", archive.Options{}, err
+	}
+	filter, err := archive.NewFilter(q.Get("include"), q.Get("exclude"))
+	if err != nil {
+		return "", archive.Options{}, err
+	}
+	return format, archive.Options{Filter: filter, DryRun: q.Get("dry_run") == "true"}, nil
+}
+
+// Export handles GET /export?path=<path>&format=<tar|tar.gz|zip>&include=<patterns>&exclude=<patterns>
+// The subtree is streamed as an archive; patterns are comma separated globs
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	p = filesystem.NormalizePath(p)
+
+	format, opts, err := archiveOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	fs := h.requestFS(r)
+	if _, err := fs.Stat(p); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	name := path.Base(p)
+	if name == "/" {
+		name = "root"
+	}
+	filename := fmt.Sprintf("%s-%s%s", name, time.Now().UTC().Format("20060102-150405"), format.Extension())
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	stats, err := archive.Write(w, fs, p, format, opts)
+	if err != nil {
+		// Headers are already sent, the client sees a truncated archive
+		log.Errorf("Export of %s failed after %d files: %v", p, stats.Files, err)
+		return
+	}
+	log.Infof("Exported %s as %s: %d files, %d bytes", p, format, stats.Files, stats.Bytes)
+}
+
+// Import handles POST /import?path=<dest>&format=<tar|tar.gz|zip>&include=<patterns>&exclude=<patterns>&dry_run=true
+// The request body is an archive that is unpacked into dest; with dry_run nothing is written
+// and the paths that would be written are returned instead
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	dest := r.URL.Query().Get("path")
+	if dest == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	format, opts, err := archiveOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	stats, err := archive.Extract(r.Body, h.requestFS(r), dest, format, opts)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "failed to import archive: "+err.Error())
+		return
+	}
+
+	message := "archive imported"
+	if opts.DryRun {
+		message = "dry run, nothing written"
+	}
+	writeJSON(w, http.StatusOK, ImportResponse{
+		Message: message,
+		Path:    dest,
+		DryRun:  opts.DryRun,
+		Files:   stats.Files,
+		Dirs:    stats.Dirs,
+		Bytes:   stats.Bytes,
+		Entries: stats.Entries,
+	})
+}
@@ -0,0 +1,77 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Trees exported as archives are imported back, with the entries the filters select
+func TestExportImport(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{
+		"/memfs/proj/main.go":       "package main",
+		"/memfs/proj/docs/guide.md": "# Guide",
+		"/memfs/proj/build/out.log": "noise",
+	})
+	export := func(query string) []byte {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/export?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("export %s: %d %s", query, resp.StatusCode, data)
+		}
+		return data
+	}
+	importArchive := func(query string, archive []byte) (int, handlers.ImportResponse) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/v1/import?"+query, "application/octet-stream", bytes.NewReader(archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result handlers.ImportResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	for _, format := range []string{"tar", "tar.gz", "zip"} {
+		archive := export("path=/memfs/proj&exclude=build&format=" + format)
+		dest := "/memfs/" + format
+		if status, result := importArchive("path="+dest+"&format="+format, archive); status != http.StatusOK || result.Files != 2 {
+			t.Errorf("%s import = %d %+v", format, status, result)
+		}
+		srv.AssertTree(dest, map[string]string{"main.go": "package main", "docs/guide.md": "# Guide"})
+	}
+
+	// A dry run lists what would be written, as the include filter selects it
+	archive := export("path=/memfs/proj")
+	status, result := importArchive("path=/memfs/dry&dry_run=true&include=*.md", archive)
+	if status != http.StatusOK || !result.DryRun || len(result.Entries) != 1 || result.Entries[0] != "/memfs/dry/docs/guide.md" {
+		t.Errorf("dry run = %d %+v", status, result)
+	}
+	srv.AssertNotExist("/memfs/dry")
+
+	for _, query := range []string{"path=/memfs/proj&format=rar", "path=/memfs/proj&include=[", "path=/nonexistent"} {
+		resp, err := http.Get(srv.URL + "/api/v1/export?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("export %s succeeded", query)
+		}
+	}
+	if status, _ := importArchive("path=/memfs/bad&format=tar", []byte("not an archive")); status == http.StatusOK {
+		t.Error("import of an invalid archive succeeded")
+	}
+}
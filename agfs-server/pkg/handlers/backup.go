@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/backup"
+)
+
+// BackupStatusResponse lists the status of backup jobs
+type BackupStatusResponse struct {
+	Jobs []backup.JobStatus `json:"jobs"`
+}
+
+// SetBackupScheduler sets the scheduler used by the backup endpoints
+func (h *Handler) SetBackupScheduler(s *backup.Scheduler) {
+	h.backups = s
+}
+
+// BackupStatus handles GET /backups
+func (h *Handler) BackupStatus(w http.ResponseWriter, r *http.Request) {
+	if h.backups == nil {
+		writeJSON(w, http.StatusOK, BackupStatusResponse{Jobs: []backup.JobStatus{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, BackupStatusResponse{Jobs: h.backups.Status()})
+}
+
+// RunBackup handles POST /backups/run?name=<job>
+// Without a name every configured job is run; the request returns once the backups finish
+func (h *Handler) RunBackup(w http.ResponseWriter, r *http.Request) {
+	if h.backups == nil {
+		writeError(w, http.StatusNotFound, "no backup jobs configured")
+		return
+	}
+
+	jobs, err := h.backups.Run(r.URL.Query().Get("name"))
+	if err != nil {
+		status := mapErrorToStatus(err)
+		if errors.Is(err, backup.ErrJobRunning) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, "backup failed: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BackupStatusResponse{Jobs: jobs})
+}
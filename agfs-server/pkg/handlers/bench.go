@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bench"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// benchOptions parses the query parameters of a benchmark request
+func benchOptions(r *http.Request) (bench.Options, error) {
+	q := r.URL.Query()
+	opts := bench.Options{Dir: q.Get("path"), StreamPath: q.Get("stream_path")}
+
+	var err error
+	if opts.Workloads, err = bench.ParseWorkloads(q.Get("workloads")); err != nil {
+		return opts, err
+	}
+	if v := q.Get("duration"); v != "" {
+		if opts.Duration, err = time.ParseDuration(v); err != nil {
+			return opts, filesystem.NewInvalidArgumentError("duration", v, err.Error())
+		}
+	}
+	sizes := map[string]*int64{"block_size": &opts.BlockSize, "file_size": &opts.FileSize}
+	for key, dst := range sizes {
+		if v := q.Get(key); v != "" {
+			if *dst, err = pluginconfig.ParseSize(v); err != nil {
+				return opts, filesystem.NewInvalidArgumentError(key, v, err.Error())
+			}
+		}
+	}
+	ints := map[string]*int{"files": &opts.Files, "concurrency": &opts.Concurrency, "readers": &opts.Readers}
+	for key, dst := range ints {
+		if v := q.Get(key); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return opts, filesystem.NewInvalidArgumentError(key, v, "expected an integer")
+			}
+		}
+	}
+	return opts, nil
+}
+
+// RunBench handles POST /bench?path=<scratch dir>&workloads=<list>&duration=<d>&format=<json|csv>
+// Optional: block_size, file_size, files, concurrency, readers and stream_path
+// The workloads run in the server against the filesystem, without HTTP overhead;
+// the request returns once they finish
+func (h *Handler) RunBench(w http.ResponseWriter, r *http.Request) {
+	opts, err := benchOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if opts.Dir == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	log.Infof("[bench] running %v in %s", opts.Workloads, opts.Dir)
+	report, err := bench.Run(r.Context(), h.requestFS(r), "server", opts)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "benchmark failed: "+err.Error())
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		report.WriteCSV(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
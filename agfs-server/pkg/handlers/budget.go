@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Defaults of the server budget of recursive operations
+const (
+	DefaultBudgetMaxFiles = 100000
+	DefaultBudgetMaxBytes = 1 << 30 // 1GB
+	DefaultBudgetMaxTime  = time.Minute
+)
+
+// Limits of a budget reported when it runs out
+const (
+	BudgetMaxFiles = "max_files"
+	BudgetMaxBytes = "max_bytes"
+	BudgetMaxTime  = "max_time"
+)
+
+// Budget bounds the work of one recursive operation such as a recursive grep; zero fields
+// do not limit
+type Budget struct {
+	MaxFiles int64         // Files scanned
+	MaxBytes int64         // Bytes read
+	MaxTime  time.Duration // Wall time
+}
+
+// DefaultBudget is the server budget unless configured otherwise
+var DefaultBudget = Budget{MaxFiles: DefaultBudgetMaxFiles, MaxBytes: DefaultBudgetMaxBytes, MaxTime: DefaultBudgetMaxTime}
+
+// BudgetFromConfig returns the server budget of cfg, with defaults for unset keys; "0"
+// removes a limit
+func BudgetFromConfig(cfg config.BudgetConfig) (Budget, error) {
+	b := DefaultBudget
+	if cfg.MaxFiles != nil {
+		if *cfg.MaxFiles < 0 {
+			return Budget{}, fmt.Errorf("budget: invalid max_files: %d", *cfg.MaxFiles)
+		}
+		b.MaxFiles = *cfg.MaxFiles
+	}
+	if cfg.MaxBytes != "" {
+		n, err := pluginconfig.ParseSize(cfg.MaxBytes)
+		if err != nil || n < 0 {
+			return Budget{}, fmt.Errorf("budget: invalid max_bytes: %s", cfg.MaxBytes)
+		}
+		b.MaxBytes = n
+	}
+	if cfg.MaxTime != "" {
+		d, err := pluginconfig.ParseDuration(cfg.MaxTime)
+		if err != nil || d < 0 {
+			return Budget{}, fmt.Errorf("budget: invalid max_time: %s", cfg.MaxTime)
+		}
+		b.MaxTime = d
+	}
+	return b, nil
+}
+
+// SetBudget sets the server budget of recursive operations; budgets of requests can only
+// lower it
+func (h *Handler) SetBudget(b Budget) {
+	h.budget = b
+}
+
+// Within returns b lowered to the limits of req that are set
+func (b Budget) Within(req Budget) Budget {
+	lower := func(limit, requested int64) int64 {
+		if requested > 0 && (limit == 0 || requested < limit) {
+			return requested
+		}
+		return limit
+	}
+	return Budget{
+		MaxFiles: lower(b.MaxFiles, req.MaxFiles),
+		MaxBytes: lower(b.MaxBytes, req.MaxBytes),
+		MaxTime:  time.Duration(lower(int64(b.MaxTime), int64(req.MaxTime))),
+	}
+}
+
+// errBudgetExceeded stops a recursive operation whose budget ran out
+var errBudgetExceeded = errors.New("budget exceeded")
+
+// budgetTracker accounts the work of one operation against its budget
+type budgetTracker struct {
+	budget   Budget
+	ctx      context.Context
+	files    int64
+	bytes    int64
+	exceeded string // The limit that ran out, "" while within budget
+}
+
+// newBudgetTracker starts accounting against b; the returned context ends when the time
+// budget does, so backend calls are aborted with it
+func newBudgetTracker(parent context.Context, b Budget) (*budgetTracker, context.CancelFunc) {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	if b.MaxTime > 0 {
+		ctx, cancel = context.WithTimeout(parent, b.MaxTime)
+	}
+	return &budgetTracker{budget: b, ctx: ctx}, cancel
+}
+
+// file takes a file from the budget and returns the number of bytes that may be read from
+// it, -1 for all; it returns errBudgetExceeded once a limit is used up
+func (t *budgetTracker) file() (int64, error) {
+	if err := t.check(); err != nil {
+		return 0, err
+	}
+	if t.budget.MaxFiles > 0 && t.files >= t.budget.MaxFiles {
+		t.exceeded = BudgetMaxFiles
+		return 0, errBudgetExceeded
+	}
+	t.files++
+	if t.budget.MaxBytes > 0 {
+		return t.budget.MaxBytes - t.bytes, nil
+	}
+	return -1, nil
+}
+
+// read accounts n bytes read
+func (t *budgetTracker) read(n int) {
+	t.bytes += int64(n)
+	if t.budget.MaxBytes > 0 && t.bytes >= t.budget.MaxBytes && t.exceeded == "" {
+		t.exceeded = BudgetMaxBytes
+	}
+}
+
+// check returns errBudgetExceeded once a limit is used up or the time budget passed
+func (t *budgetTracker) check() error {
+	if t.exceeded == "" && t.budget.MaxTime > 0 && errors.Is(t.ctx.Err(), context.DeadlineExceeded) {
+		t.exceeded = BudgetMaxTime
+	}
+	if t.exceeded != "" {
+		return errBudgetExceeded
+	}
+	return nil
+}
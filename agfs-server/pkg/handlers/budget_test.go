@@ -0,0 +1,58 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+func TestGrepBudget(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	for i := 0; i < 5; i++ {
+		srv.Seed(map[string]string{fmt.Sprintf("/memfs/logs/%d.log", i): "ok\nerror here\n"})
+	}
+	grep := func(body string) (int, handlers.GrepResponse) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/v1/grep", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var result handlers.GrepResponse
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	status, result := grep(`{"path": "/memfs/logs", "pattern": "error", "recursive": true}`)
+	if status != http.StatusOK || result.Count != 5 || result.Truncated || result.FilesScanned != 5 {
+		t.Fatalf("unbounded grep = %d %+v", status, result)
+	}
+
+	status, result = grep(`{"path": "/memfs/logs", "pattern": "error", "recursive": true, "max_files": 2}`)
+	if status != http.StatusOK || result.Count != 2 || !result.Truncated || result.Limit != handlers.BudgetMaxFiles {
+		t.Errorf("max_files grep = %d %+v", status, result)
+	}
+
+	// The third file is read up to the budget, before its match
+	status, result = grep(`{"path": "/memfs/logs", "pattern": "error", "recursive": true, "max_bytes": 32}`)
+	if status != http.StatusOK || result.Count != 2 || !result.Truncated || result.Limit != handlers.BudgetMaxBytes || result.BytesRead != 32 {
+		t.Errorf("max_bytes grep = %d %+v", status, result)
+	}
+
+	if status, _ = grep(`{"path": "/memfs/logs", "pattern": "error", "recursive": true, "max_time": "soon"}`); status != http.StatusBadRequest {
+		t.Errorf("invalid max_time: status %d", status)
+	}
+}
+
+func TestBudgetWithin(t *testing.T) {
+	server := handlers.Budget{MaxFiles: 10, MaxBytes: 100}
+	got := server.Within(handlers.Budget{MaxFiles: 20, MaxBytes: 50, MaxTime: 5})
+	if want := (handlers.Budget{MaxFiles: 10, MaxBytes: 50, MaxTime: 5}); got != want {
+		t.Errorf("Within = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/document"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// Content types of document patches
+const (
+	contentTypeMergePatch = "application/merge-patch+json"
+	contentTypeJSONPatch  = "application/json-patch+json"
+)
+
+// DocPatchResponse represents the result of a document patch
+type DocPatchResponse struct {
+	Message string      `json:"message"`
+	Path    string      `json:"path"`
+	Pointer string      `json:"pointer"`
+	Value   interface{} `json:"value"` // Value at pointer after the patch
+}
+
+// readDocument reads and decodes the JSON or YAML file of a doc request
+// With missing, a missing file is an empty document
+func (h *Handler) readDocument(r *http.Request, missing bool) (*document.Document, error) {
+	q := r.URL.Query()
+	path := q.Get("path")
+	if path == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", "", "path parameter is required")
+	}
+	format, err := document.ParseFormat(q.Get("format"), path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := h.requestFS(r).Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		if !missing || !filesystem.IsNotFound(err) {
+			return nil, err
+		}
+		data = nil
+	}
+	return document.Parse(data, format)
+}
+
+// GetDocument handles GET /doc?path=<path>&pointer=</a/b>&format=<json|yaml>
+// It returns the value at the JSON Pointer of a JSON or YAML file as JSON; the format is
+// taken from the file extension unless given
+func (h *Handler) GetDocument(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.readDocument(r, false)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	value, err := doc.Get(r.URL.Query().Get("pointer"))
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, value)
+}
+
+// PatchDocument handles PATCH /doc?path=<path>&pointer=</a/b>&format=<json|yaml>
+// A body of type application/json-patch+json is a JSON Patch applied to the whole document,
+// any other body is a JSON Merge Patch applied at pointer, so a scalar body sets one key.
+// The file is created if missing and rewritten as a whole, patches of a path are serialized
+func (h *Handler) PatchDocument(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	pointer := r.URL.Query().Get("pointer")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	_, write := h.limiters(r, path)
+	body, err := io.ReadAll(throttle.NewReader(r.Context(), r.Body, write...))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read request body")
+		return
+	}
+
+	var apply func(*document.Document) error
+	if mediaType == contentTypeJSONPatch {
+		if pointer != "" {
+			writeError(w, http.StatusBadRequest, "pointer is not used with JSON Patch, operations carry their own paths")
+			return
+		}
+		ops, err := document.ParsePatch(body)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		apply = func(doc *document.Document) error { return doc.Apply(ops) }
+	} else {
+		patch, err := document.ParseValue(body)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		apply = func(doc *document.Document) error { return doc.Merge(pointer, patch) }
+	}
+
+	if path != "" {
+		defer h.pathLocks.Lock(path)()
+	}
+	doc, err := h.readDocument(r, true)
+	if err == nil {
+		err = apply(doc)
+	}
+	if errors.Is(err, document.ErrTestFailed) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	data, err := doc.Encode()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := h.requestFS(r).Write(path, data); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	value, _ := doc.Get(pointer)
+	writeJSON(w, http.StatusOK, DocPatchResponse{Message: "patched", Path: path, Pointer: pointer, Value: value})
+}
@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Values of JSON and YAML files are read and patched by pointer
+func TestDocument(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{"/memfs/app.yaml": "server:\n  port: 8080\n"})
+	do := func(method, query, contentType, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/api/v1/doc?"+query, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	if status, body := do(http.MethodGet, "path=/memfs/app.yaml&pointer=/server/port", "", ""); status != http.StatusOK || body != "8080" {
+		t.Errorf("get port = %d %s", status, body)
+	}
+	if status, body := do(http.MethodPatch, "path=/memfs/app.yaml&pointer=/server/port", "application/json", "9090"); status != http.StatusOK || !strings.Contains(body, `"value":9090`) {
+		t.Errorf("merge patch = %d %s", status, body)
+	}
+	srv.AssertFile("/memfs/app.yaml", "server:\n  port: 9090\n")
+
+	// JSON Patch with a failing test leaves the file alone
+	ops := `[{"op": "test", "path": "/server/port", "value": 1}, {"op": "remove", "path": "/server"}]`
+	if status, body := do(http.MethodPatch, "path=/memfs/app.yaml", "application/json-patch+json", ops); status != http.StatusConflict {
+		t.Errorf("failed test = %d %s", status, body)
+	}
+	ops = `[{"op": "add", "path": "/server/hosts", "value": ["a"]}, {"op": "add", "path": "/server/hosts/-", "value": "b"}]`
+	if status, body := do(http.MethodPatch, "path=/memfs/app.yaml", "application/json-patch+json", ops); status != http.StatusOK {
+		t.Errorf("JSON patch = %d %s", status, body)
+	}
+	status, body := do(http.MethodGet, "path=/memfs/app.yaml&pointer=/server", "", "")
+	var server struct {
+		Port  int
+		Hosts []string
+	}
+	if json.Unmarshal([]byte(body), &server); status != http.StatusOK || server.Port != 9090 || strings.Join(server.Hosts, ",") != "a,b" {
+		t.Errorf("server = %d %s", status, body)
+	}
+
+	// Patches create missing files
+	if status, body := do(http.MethodPatch, "path=/memfs/new.json&pointer=/name", "application/json", `"agfs"`); status != http.StatusOK {
+		t.Errorf("patch of a missing file = %d %s", status, body)
+	}
+	srv.AssertFile("/memfs/new.json", "{\n  \"name\": \"agfs\"\n}\n")
+
+	for _, tc := range []struct {
+		method, query, contentType, body string
+		status                           int
+	}{
+		{http.MethodGet, "path=/memfs/app.yaml&pointer=/missing", "", "", http.StatusNotFound},
+		{http.MethodGet, "pointer=/a", "", "", http.StatusBadRequest},
+		{http.MethodPatch, "path=/memfs/app.yaml&pointer=/a", "application/json-patch+json", "[]", http.StatusBadRequest},
+		{http.MethodPatch, "path=/memfs/app.yaml", "application/json", "{", http.StatusBadRequest},
+		{http.MethodPut, "path=/memfs/app.yaml", "", "", http.StatusMethodNotAllowed},
+	} {
+		if status, body := do(tc.method, tc.query, tc.contentType, tc.body); status != tc.status {
+			t.Errorf("%s %s: %d %s, want %d", tc.method, tc.query, status, body, tc.status)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/zeebo/xxh3"
+)
+
+// fileETag returns the entity tag of file content, its xxh3 digest as computed by POST
+// /digest, quoted
+func fileETag(data []byte) string {
+	return digestETag(xxh3.Hash128(data))
+}
+
+// digestETag returns the entity tag of content with the xxh3 digest sum, for content hashed
+// as it streams
+func digestETag(sum xxh3.Uint128) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%016x", sum.Lo))
+}
+
+// conditionalWrite reports whether a write request carries If-Match or If-None-Match
+func conditionalWrite(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-None-Match") != ""
+}
+
+// etagListMatches reports whether a header value, "*" or a comma separated list of entity
+// tags, matches etag; "*" matches any existing file
+func etagListMatches(header, etag string, exists bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if (tag == "*" && exists) || (exists && tag == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritePreconditions evaluates the If-Match and If-None-Match headers of a write
+// against the current content of path and answers 412 Precondition Failed, with the
+// current ETag, if they fail. Callers hold the path lock so that the file does not change
+// between the check and the write
+func (h *Handler) checkWritePreconditions(w http.ResponseWriter, r *http.Request, fs filesystem.FileSystem, path string) bool {
+	exists := true
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		if !filesystem.IsNotFound(err) {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return false
+		}
+		exists = false
+	}
+	etag := ""
+	if exists {
+		etag = fileETag(data)
+	}
+	if match := r.Header.Get("If-Match"); match != "" && !etagListMatches(match, etag, exists) {
+		if exists {
+			w.Header().Set("ETag", etag)
+			writeError(w, http.StatusPreconditionFailed, "precondition failed: "+path+" was modified")
+		} else {
+			writeError(w, http.StatusPreconditionFailed, "precondition failed: "+path+" does not exist")
+		}
+		return false
+	}
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && etagListMatches(noneMatch, etag, exists) {
+		w.Header().Set("ETag", etag)
+		writeError(w, http.StatusPreconditionFailed, "precondition failed: "+path+" already exists")
+		return false
+	}
+	return true
+}
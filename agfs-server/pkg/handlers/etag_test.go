@@ -0,0 +1,45 @@
+package handlers_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Writes with If-Match fail once the file changed since it was read
+func TestConditionalWrite(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	do := func(method, path, body, header, value string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/api/v1/files?path="+path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("ETag")
+	}
+
+	const path = "/memfs/config.yaml"
+	if status, _ := do(http.MethodPut, path, "a: 1\n", "If-None-Match", "*"); status != http.StatusOK {
+		t.Fatalf("create: status %d", status)
+	}
+	if status, _ := do(http.MethodPut, path, "a: 1\n", "If-None-Match", "*"); status != http.StatusPreconditionFailed {
+		t.Errorf("second create: status %d", status)
+	}
+	status, etag := do(http.MethodGet, path, "", "", "")
+	if status != http.StatusOK || etag == "" {
+		t.Fatalf("read: status %d, etag %q", status, etag)
+	}
+	if status, _ := do(http.MethodPut, path, "a: 2\n", "If-Match", etag); status != http.StatusOK {
+		t.Fatalf("write with current etag: status %d", status)
+	}
+	if status, current := do(http.MethodPut, path, "a: 3\n", "If-Match", etag); status != http.StatusPreconditionFailed || current == etag {
+		t.Errorf("write with stale etag: status %d, etag %q", status, current)
+	}
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filedrop"
+)
+
+// FileDropResponse lists the status of file drop watchers
+type FileDropResponse struct {
+	Watchers []filedrop.Status `json:"watchers"`
+}
+
+// SetFileDrop sets the manager used by the file drop endpoints
+func (h *Handler) SetFileDrop(m *filedrop.Manager) {
+	h.fileDrop = m
+}
+
+// FileDropStatus handles GET /file-drop
+func (h *Handler) FileDropStatus(w http.ResponseWriter, r *http.Request) {
+	if h.fileDrop == nil {
+		writeJSON(w, http.StatusOK, FileDropResponse{Watchers: []filedrop.Status{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, FileDropResponse{Watchers: h.fileDrop.Status()})
+}
+
+// RunFileDrop handles POST /file-drop/run?name=<watcher>
+// Without a name every configured watcher scans its directory; the request returns once they finish
+func (h *Handler) RunFileDrop(w http.ResponseWriter, r *http.Request) {
+	if h.fileDrop == nil {
+		writeError(w, http.StatusNotFound, "no file drop watchers configured")
+		return
+	}
+
+	watchers, err := h.fileDrop.Run(r.URL.Query().Get("name"))
+	if err != nil {
+		status := mapErrorToStatus(err)
+		if errors.Is(err, filedrop.ErrRunning) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, "file drop scan failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, FileDropResponse{Watchers: watchers})
+}
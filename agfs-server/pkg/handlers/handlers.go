@@ -10,12 +10,29 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/analytics"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/backup"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bufpool"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/contentmeta"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filedrop"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/holds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/lifecycle"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/migrate"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/queuearchive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
 	log "github.com/sirupsen/logrus"
 	"github.com/zeebo/xxh3"
 )
@@ -26,6 +43,26 @@ type Handler struct {
 	version    string
 	gitCommit  string
 	buildTime  string
+	backups    *backup.Scheduler
+	migrations *migrate.Manager
+	lifecycle  *lifecycle.Engine
+	archiver   *queuearchive.Manager
+	fileDrop   *filedrop.Manager
+	search     *search.Indexer
+	analytics  *analytics.Collector
+	acl        *acl.ACL
+	holds      *holds.Manager
+	origins    []string // See SetCORSOrigins
+	tags       *tags.Store
+	clients    *throttle.ClientLimits
+	handles    *handleTable
+	principal  HandlePrincipalFunc // See SetHandlePrincipal
+	uploads    *UploadStore
+	startup    *StartupReport
+	budget     Budget // Bounds recursive operations such as grep
+	jobs       *jobs.Manager
+
+	pathLocks filesystem.PathLocks // serializes read-modify-write updates, e.g. appends and document patches
 }
 
 // NewHandler creates a new Handler
@@ -35,6 +72,10 @@ func NewHandler(fs filesystem.FileSystem) *Handler {
 		version:   "dev",
 		gitCommit: "unknown",
 		buildTime: "unknown",
+		handles:   newHandleTable(),
+		uploads:   NewUploadStore(filepath.Join(os.TempDir(), "agfs-uploads"), DefaultUploadTTL, DefaultMaxUploadSize),
+		budget:    DefaultBudget,
+		jobs:      jobs.NewManager(jobs.Options{}),
 	}
 }
 
@@ -68,6 +109,24 @@ type FileInfoResponse struct {
 // ListResponse represents directory listing response
 type ListResponse struct {
 	Files []FileInfoResponse `json:"files"`
+	Next  int                `json:"next,omitempty"` // Offset of the next page of a paged listing, 0 after the last
+}
+
+// PartialFileInfoResponse is an entry of a listing restricted with the fields parameter,
+// leaving out the fields that were not selected
+type PartialFileInfoResponse struct {
+	Name    string               `json:"name"`
+	Size    *int64               `json:"size,omitempty"`
+	Mode    *uint32              `json:"mode,omitempty"`
+	ModTime string               `json:"modTime,omitempty"`
+	IsDir   bool                 `json:"isDir"`
+	Meta    *filesystem.MetaData `json:"meta,omitempty"`
+}
+
+// PartialListResponse is the response of a listing restricted with the fields parameter
+type PartialListResponse struct {
+	Files []PartialFileInfoResponse `json:"files"`
+	Next  int                       `json:"next,omitempty"`
 }
 
 // WriteRequest represents a write request
@@ -80,6 +139,22 @@ type RenameRequest struct {
 	NewPath string `json:"newPath"`
 }
 
+// CopyRequest represents a copy request
+type CopyRequest struct {
+	Dest      string `json:"dest"`
+	Recursive bool   `json:"recursive"` // Copy a directory and everything below it
+}
+
+// CopyResponse represents the result of a copy
+type CopyResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	Dest    string `json:"dest"`
+	Files   int    `json:"files"`
+	Dirs    int    `json:"dirs"`
+	Bytes   int64  `json:"bytes"`
+}
+
 // ChmodRequest represents a chmod request
 type ChmodRequest struct {
 	Mode uint32 `json:"mode"`
@@ -122,9 +197,36 @@ func mapErrorToStatus(err error) int {
 	if errors.Is(err, filesystem.ErrAlreadyExists) {
 		return http.StatusConflict
 	}
+	if errors.Is(err, filesystem.ErrNotSupported) {
+		return http.StatusNotImplemented
+	}
+	if errors.Is(err, filesystem.ErrQuotaExceeded) {
+		return http.StatusInsufficientStorage
+	}
+	if errors.Is(err, filesystem.ErrUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, filesystem.ErrTimeout) {
+		return http.StatusGatewayTimeout
+	}
 	return http.StatusInternalServerError
 }
 
+// requestFS returns the file system bound to the request's context, so plugin work is
+// canceled when the client goes away
+func (h *Handler) requestFS(r *http.Request) filesystem.FileSystem {
+	return filesystem.WithContext(h.fs, r.Context())
+}
+
+// readable reports whether the access control of the request's file system, if any, lets
+// the request read path; results of indexes are filtered with it
+func (h *Handler) readable(r *http.Request, path string) bool {
+	if checker, ok := h.requestFS(r).(mountablefs.AccessChecker); ok {
+		return checker.Allowed(mountablefs.AccessRead, path)
+	}
+	return true
+}
+
 // CreateFile handles POST /files?path=<path>
 func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
@@ -133,7 +235,7 @@ func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Create(path); err != nil {
+	if err := h.requestFS(r).Create(path); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -161,7 +263,7 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 		mode = uint32(m)
 	}
 
-	if err := h.fs.Mkdir(path, mode); err != nil {
+	if err := h.requestFS(r).Mkdir(path, mode); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -170,19 +272,36 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, SuccessResponse{Message: "directory created"})
 }
 
-// ReadFile handles GET /files?path=<path>&offset=<offset>&size=<size>&stream=<true|false>
+// ReadFile handles GET /files?path=<path>&offset=<offset>&size=<size>&stream=<true|false|sse>
+// A Range header with a single byte range is answered with 206 Partial Content; whole files
+// come with an ETag for conditional writes. Control files offering several formats are read
+// in the one of format=<json|text|markdown>, or else of the Accept header, with its
+// Content-Type. READMEs are written in the language of lang=, or else of Accept-Language,
+// if the plugin offers it
 func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		writeError(w, http.StatusBadRequest, "path parameter is required")
 		return
 	}
+	lang := r.URL.Query().Get("lang")
+	if lang == "" {
+		lang = filesystem.LanguageFromAccept(r.Header.Get("Accept-Language"))
+	}
+	r = r.WithContext(filesystem.WithLanguage(r.Context(), lang))
 
 	// Check if streaming mode is requested
-	stream := r.URL.Query().Get("stream") == "true"
-	if stream {
+	switch r.URL.Query().Get("stream") {
+	case "true":
 		h.streamFile(w, r, path)
 		return
+	case "sse":
+		h.streamSSE(w, r, path)
+		return
+	}
+	if header := r.Header.Get("Range"); header != "" {
+		h.readRange(w, r, path, header)
+		return
 	}
 
 	// Parse offset and size parameters
@@ -207,13 +326,34 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	data, err := h.fs.Read(path, offset, size)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = filesystem.FormatFromAccept(r.Header.Get("Accept"))
+	} else if filesystem.FormatContentType(format) == "" {
+		writeError(w, http.StatusBadRequest, "format must be json, text or markdown")
+		return
+	}
+
+	data, served, err := filesystem.ReadFormat(h.requestFS(r), path, offset, size, format)
+	contentType := "application/octet-stream"
+	if served != "" {
+		contentType = filesystem.FormatContentType(served)
+		w.Header().Set("Vary", "Accept")
+	}
+	if strings.HasSuffix(path, "/README") {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	read, _ := h.limiters(r, path)
+	out := throttle.NewWriter(r.Context(), w, read...)
 	if err != nil {
 		// Check if it's EOF (reached end of file)
 		if err == io.EOF {
-			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Type", contentType)
+			if offset == 0 && size < 0 {
+				w.Header().Set("ETag", fileETag(data))
+			}
 			w.WriteHeader(http.StatusOK)
-			w.Write(data) // Return partial data with 200 OK
+			out.Write(data) // Return partial data with 200 OK
 			return
 		}
 		// Map error to appropriate HTTP status code
@@ -222,12 +362,17 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Type", contentType)
+	if offset == 0 && size < 0 {
+		w.Header().Set("ETag", fileETag(data))
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	out.Write(data)
 }
 
-// WriteFile handles PUT /files?path=<path>
+// WriteFile handles PUT /files?path=<path>&offset=<offset>
+// Without offset the body replaces the file. With If-Match the write fails with 412 unless
+// the file's ETag matches, with If-None-Match: * unless the file does not exist
 func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -235,24 +380,117 @@ func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := io.ReadAll(r.Body)
+	if r.URL.Query().Get("stream") == "true" {
+		h.writeStream(w, r, path)
+		return
+	}
+
+	_, write := h.limiters(r, path)
+	data, err := io.ReadAll(throttle.NewReader(r.Context(), r.Body, write...))
 	if err != nil {
 		writeError(w, http.StatusBadRequest, "failed to read request body")
 		return
 	}
+	offset := int64(-1)
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.ParseInt(offsetStr, 10, 64)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid offset parameter")
+			return
+		}
+	}
+
+	fs := h.requestFS(r)
+	conditional := conditionalWrite(r)
+	if conditional {
+		// Conditional writes check and write under the path lock, serialized with each
+		// other, appends and document patches
+		defer h.pathLocks.Lock(path)()
+		if !h.checkWritePreconditions(w, r, fs, path) {
+			return
+		}
+	}
+	if offset >= 0 {
+		h.writeFileAt(w, r, path, offset, data, conditional)
+		return
+	}
 
-	response, err := h.fs.Write(path, data)
+	response, err := fs.Write(path, data)
 	if err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
 	}
+	w.Header().Set("ETag", fileETag(data))
 
 	// Return the custom message from the filesystem
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: string(response)})
 }
 
+// streamWriteChunk is the size of the chunks streamed writes pass to the file system
+const streamWriteChunk = 64 * 1024
+
+// writeStream handles PUT /files?path=<path>&stream=true
+// The request body is written through OpenWrite in chunks of streamWriteChunk bytes rather
+// than read into memory first; the server's memory stays constant with file systems writing
+// as data comes, such as localfs, while those storing whole files (memfs, sqlfs, s3fs, and
+// mounts with checksums, scanning or a shadow) still buffer the file until it is complete.
+// If the body or a write fails the write is aborted, see filesystem.AbortWrite.
+func (h *Handler) writeStream(w http.ResponseWriter, r *http.Request, path string) {
+	if r.URL.Query().Get("offset") != "" {
+		writeError(w, http.StatusBadRequest, "offset is not supported with stream=true")
+		return
+	}
+	fs := h.requestFS(r)
+	if conditionalWrite(r) {
+		defer h.pathLocks.Lock(path)()
+		if !h.checkWritePreconditions(w, r, fs, path) {
+			return
+		}
+	}
+
+	writer, err := fs.OpenWrite(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	_, write := h.limiters(r, path)
+	body := throttle.NewReader(r.Context(), r.Body, write...)
+	buf := bufpool.Get(streamWriteChunk)
+	defer bufpool.Put(buf)
+	hasher := xxh3.New()
+	var written int64
+	for {
+		n, readErr := io.ReadFull(body, buf)
+		if n > 0 {
+			if _, err := writer.Write(buf[:n]); err != nil {
+				filesystem.AbortWrite(writer)
+				writeError(w, mapErrorToStatus(err), err.Error())
+				return
+			}
+			hasher.Write(buf[:n])
+			written += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			filesystem.AbortWrite(writer)
+			writeError(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+	}
+	if err := writer.Close(); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	w.Header().Set("ETag", digestETag(hasher.Sum128()))
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: fmt.Sprintf("wrote %d bytes", written)})
+}
+
 // Delete handles DELETE /files?path=<path>&recursive=<true|false>
+// With recursive=true&async=true the tree is removed by a background job whose status is
+// returned; webhook=<url> is notified when it ends
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -261,12 +499,16 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	recursive := r.URL.Query().Get("recursive") == "true"
+	if recursive && r.URL.Query().Get("async") == "true" {
+		h.startJob(r.Context(), w, JobRequest{Type: JobRemove, Path: path, Webhook: r.URL.Query().Get("webhook")})
+		return
+	}
 
 	var err error
 	if recursive {
-		err = h.fs.RemoveAll(path)
+		err = h.requestFS(r).RemoveAll(path)
 	} else {
-		err = h.fs.Remove(path)
+		err = h.requestFS(r).Remove(path)
 	}
 
 	if err != nil {
@@ -278,22 +520,41 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "deleted"})
 }
 
-// ListDirectory handles GET /directories?path=<path>
+// ListDirectory handles GET /directories?path=<path>&fields=<fields>&sort=<key>&offset=<n>&limit=<n>
+// fields is a comma-separated subset of name, isDir, size, mode, modTime and meta; plugins
+// that can list more cheaply without the other fields leave them out. sort, offset and limit
+// select a page of the listing, see listDirectory
 func (h *Handler) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		path = "/"
 	}
+	fields, err := filesystem.ParseListFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, err := parseListPage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	files, err := h.fs.ReadDir(path)
+	files, next, err := listDirectory(h.requestFS(r), path, fields, page)
 	if err != nil {
 		// Map error to appropriate HTTP status code
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
 	}
+	if !fields.All() {
+		response := partialListResponse(files, fields)
+		response.Next = next
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
 
-	var response ListResponse
+	response := ListResponse{Next: next}
 	for _, f := range files {
 		response.Files = append(response.Files, FileInfoResponse{
 			Name:    f.Name,
@@ -308,7 +569,52 @@ func (h *Handler) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, response)
 }
 
-// Stat handles GET /stat?path=<path>
+// parseListPage parses the sort, offset and limit parameters of a listing request
+func parseListPage(r *http.Request) (filesystem.ListPage, error) {
+	query := r.URL.Query()
+	return filesystem.ParseListPage(query.Get("sort"), query.Get("offset"), query.Get("limit"))
+}
+
+// listDirectory lists the selected fields of the entries of path, or of the selected page
+// of them if page is not zero; sorting and paging are left to plugins that page natively,
+// e.g. queuefs messages in queue order, and done on the whole listing otherwise. next is
+// the offset of the following page, 0 after the last
+func listDirectory(fs filesystem.FileSystem, path string, fields filesystem.ListFields, page filesystem.ListPage) ([]filesystem.FileInfo, int, error) {
+	if page.IsZero() {
+		files, err := filesystem.ReadDirFields(fs, path, fields)
+		return files, 0, err
+	}
+	files, more, err := filesystem.ReadDirPage(fs, path, page)
+	if err != nil || !more {
+		return files, 0, err
+	}
+	return files, page.Offset + len(files), nil
+}
+
+// partialListResponse returns the selected fields of files
+func partialListResponse(files []filesystem.FileInfo, fields filesystem.ListFields) PartialListResponse {
+	response := PartialListResponse{Files: []PartialFileInfoResponse{}}
+	for i := range files {
+		f := &files[i]
+		entry := PartialFileInfoResponse{Name: f.Name, IsDir: f.IsDir}
+		if fields.Size {
+			entry.Size = &f.Size
+		}
+		if fields.Mode {
+			entry.Mode = &f.Mode
+		}
+		if fields.ModTime {
+			entry.ModTime = f.ModTime.Format(time.RFC3339Nano)
+		}
+		if fields.Meta {
+			entry.Meta = &f.Meta
+		}
+		response.Files = append(response.Files, entry)
+	}
+	return response
+}
+
+// Stat handles GET /stat?path=<path>&detect=<true|false>
 func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -316,7 +622,8 @@ func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := h.fs.Stat(path)
+	fs := h.requestFS(r)
+	info, err := fs.Stat(path)
 	if err != nil {
 		log.Errorf("Stat error for path %s: %v", path, err)
 		status := mapErrorToStatus(err)
@@ -324,6 +631,21 @@ func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The content type from the file name is always reported; sniffing reads the file,
+	// which has side effects on some virtual files, so it is only done on request
+	if !info.IsDir {
+		if r.URL.Query().Get("detect") == "true" {
+			contentmeta.Enrich(info, contentmeta.Detect(fs, filesystem.NormalizePath(path), info))
+		} else if info.Meta.Content[contentmeta.MetaKeyContentType] == "" {
+			contentmeta.Enrich(info, map[string]string{contentmeta.MetaKeyContentType: contentmeta.TypeByName(path)})
+		}
+	}
+	if h.tags != nil {
+		if pathTags, err := h.tags.Tags(path); err == nil && len(pathTags) > 0 {
+			contentmeta.Enrich(info, map[string]string{MetaKeyTags: strings.Join(pathTags, ",")})
+		}
+	}
+
 	response := FileInfoResponse{
 		Name:    info.Name,
 		Size:    info.Size,
@@ -355,7 +677,7 @@ func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Rename(path, req.NewPath); err != nil {
+	if err := h.requestFS(r).Rename(path, req.NewPath); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -364,6 +686,65 @@ func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "renamed"})
 }
 
+// treeCopier is implemented by file systems that copy trees themselves, like MountableFS
+// which copies between its mounts
+type treeCopier interface {
+	CopyAll(src, dst string) (filesystem.CopyStats, error)
+}
+
+// Copy handles POST /copy?path=<path>
+// A directory is only copied with recursive; unlike rename, the destination may be in
+// another mount
+func (h *Handler) Copy(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	var req CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.Dest == "" {
+		writeError(w, http.StatusBadRequest, "dest is required")
+		return
+	}
+
+	fs := h.requestFS(r)
+	var stats filesystem.CopyStats
+	var err error
+	switch copier, ok := fs.(treeCopier); {
+	case req.Recursive && ok:
+		stats, err = copier.CopyAll(path, req.Dest)
+	case req.Recursive:
+		stats, err = filesystem.CopyTree(fs, path, fs, req.Dest)
+	default:
+		var n int64
+		if copier, ok := fs.(filesystem.Copier); ok {
+			n, err = copier.Copy(path, req.Dest)
+		} else {
+			n, err = filesystem.CopyFile(fs, path, fs, req.Dest)
+		}
+		stats = filesystem.CopyStats{Files: 1, Bytes: n}
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CopyResponse{
+		Message: "copied",
+		Path:    path,
+		Dest:    req.Dest,
+		Files:   stats.Files,
+		Dirs:    stats.Dirs,
+		Bytes:   stats.Bytes,
+	})
+}
+
 // Chmod handles POST /chmod?path=<path>
 func (h *Handler) Chmod(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
@@ -378,7 +759,7 @@ func (h *Handler) Chmod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Chmod(path, req.Mode); err != nil {
+	if err := h.requestFS(r).Chmod(path, req.Mode); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -413,9 +794,9 @@ func (h *Handler) Digest(w http.ResponseWriter, r *http.Request) {
 
 	switch req.Algorithm {
 	case "xxh3":
-		digest, err = h.calculateXXH3Digest(req.Path)
+		digest, err = h.calculateXXH3Digest(h.requestFS(r), req.Path)
 	case "md5":
-		digest, err = h.calculateMD5Digest(req.Path)
+		digest, err = h.calculateMD5Digest(h.requestFS(r), req.Path)
 	default:
 		writeError(w, http.StatusBadRequest, "unsupported algorithm: "+req.Algorithm)
 		return
@@ -437,9 +818,9 @@ func (h *Handler) Digest(w http.ResponseWriter, r *http.Request) {
 }
 
 // calculateXXH3Digest calculates XXH3 hash using streaming approach
-func (h *Handler) calculateXXH3Digest(path string) (string, error) {
+func (h *Handler) calculateXXH3Digest(fs filesystem.FileSystem, path string) (string, error) {
 	// Try to open file for streaming
-	reader, err := h.fs.Open(path)
+	reader, err := fs.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -447,7 +828,8 @@ func (h *Handler) calculateXXH3Digest(path string) (string, error) {
 
 	// Stream and hash the file in chunks
 	hasher := xxh3.New()
-	buffer := make([]byte, 64*1024) // 64KB buffer
+	buffer := bufpool.Get(64 * 1024) // 64KB buffer
+	defer bufpool.Put(buffer)
 
 	for {
 		n, err := reader.Read(buffer)
@@ -467,9 +849,9 @@ func (h *Handler) calculateXXH3Digest(path string) (string, error) {
 }
 
 // calculateMD5Digest calculates MD5 hash using streaming approach
-func (h *Handler) calculateMD5Digest(path string) (string, error) {
+func (h *Handler) calculateMD5Digest(fs filesystem.FileSystem, path string) (string, error) {
 	// Try to open file for streaming
-	reader, err := h.fs.Open(path)
+	reader, err := fs.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -477,7 +859,8 @@ func (h *Handler) calculateMD5Digest(path string) (string, error) {
 
 	// Stream and hash the file in chunks
 	hasher := md5.New()
-	buffer := make([]byte, 64*1024) // 64KB buffer
+	buffer := bufpool.Get(64 * 1024) // 64KB buffer
+	defer bufpool.Put(buffer)
 
 	for {
 		n, err := reader.Read(buffer)
@@ -525,7 +908,8 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if filesystem implements efficient Touch
-	if toucher, ok := h.fs.(filesystem.Toucher); ok {
+	fs := h.requestFS(r)
+	if toucher, ok := fs.(filesystem.Toucher); ok {
 		// Use efficient touch implementation
 		err := toucher.Touch(path)
 		if err != nil {
@@ -539,17 +923,17 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 
 	// Fallback: inefficient implementation for filesystems without Touch
 	// Check if file exists
-	info, err := h.fs.Stat(path)
+	info, err := fs.Stat(path)
 	if err == nil {
 		// File exists - read current content and write it back to update timestamp
 		if !info.IsDir {
-			data, readErr := h.fs.Read(path, 0, -1)
+			data, readErr := fs.Read(path, 0, -1)
 			if readErr != nil {
 				status := mapErrorToStatus(readErr)
 				writeError(w, status, readErr.Error())
 				return
 			}
-			_, writeErr := h.fs.Write(path, data)
+			_, writeErr := fs.Write(path, data)
 			if writeErr != nil {
 				status := mapErrorToStatus(writeErr)
 				writeError(w, status, writeErr.Error())
@@ -562,7 +946,7 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// File doesn't exist - create with empty content
-		_, err := h.fs.Write(path, []byte{})
+		_, err := fs.Write(path, []byte{})
 		if err != nil {
 			status := mapErrorToStatus(err)
 			writeError(w, status, err.Error())
@@ -573,9 +957,21 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "touched"})
 }
 
-// SetupRoutes sets up all HTTP routes with /api/v1 prefix
+// SetupRoutes sets up all HTTP routes with /api/v1 and /api/v2 prefixes
 func (h *Handler) SetupRoutes(mux *http.ServeMux) {
+	h.setupV2Routes(mux)
 	mux.HandleFunc("/api/v1/health", h.Health)
+	for _, prefix := range []string{"", "/api/v1"} {
+		mux.HandleFunc(prefix+"/livez", h.Livez)
+		mux.HandleFunc(prefix+"/readyz", h.Readyz)
+	}
+	mux.HandleFunc("/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Status(w, r)
+	})
 	mux.HandleFunc("/api/v1/files", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
@@ -590,6 +986,31 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		}
 	})
+	mux.HandleFunc("/api/v1/uploads", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.CreateUpload(w, r)
+	})
+	mux.HandleFunc("/api/v1/uploads/", h.Upload)
+	mux.HandleFunc("/api/v1/append", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.AppendFile(w, r)
+	})
+	mux.HandleFunc("/api/v1/doc", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetDocument(w, r)
+		case http.MethodPatch:
+			h.PatchDocument(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
 	mux.HandleFunc("/api/v1/directories", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodPost:
@@ -616,6 +1037,20 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Rename(w, r)
 	})
+	mux.HandleFunc("/api/v1/copy", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Copy(w, r)
+	})
+	mux.HandleFunc("/api/v1/watch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Watch(w, r)
+	})
 	mux.HandleFunc("/api/v1/chmod", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -644,12 +1079,178 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Touch(w, r)
 	})
+	mux.HandleFunc("/api/v1/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Snapshot(w, r)
+	})
+	mux.HandleFunc("/api/v1/snapshot/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RestoreSnapshot(w, r)
+	})
+	mux.HandleFunc("/api/v1/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Export(w, r)
+	})
+	mux.HandleFunc("/api/v1/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Import(w, r)
+	})
+	mux.HandleFunc("/api/v1/ingest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Ingest(w, r)
+	})
+	mux.HandleFunc("/api/v1/backups", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.BackupStatus(w, r)
+	})
+	mux.HandleFunc("/api/v1/backups/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RunBackup(w, r)
+	})
+	mux.HandleFunc("/api/v1/lifecycle", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.LifecycleStatus(w, r)
+	})
+	mux.HandleFunc("/api/v1/lifecycle/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RunLifecycle(w, r)
+	})
+	mux.HandleFunc("/api/v1/lifecycle/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.LifecycleAudit(w, r)
+	})
+	mux.HandleFunc("/api/v1/queue-archive", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.QueueArchiveStatus(w, r)
+	})
+	mux.HandleFunc("/api/v1/queue-archive/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RunQueueArchive(w, r)
+	})
+	mux.HandleFunc("/api/v1/file-drop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.FileDropStatus(w, r)
+	})
+	mux.HandleFunc("/api/v1/file-drop/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RunFileDrop(w, r)
+	})
+	mux.HandleFunc("/api/v1/migrations", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.MigrationStatus(w, r)
+		case http.MethodPost:
+			h.StartMigration(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/v1/migrations/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.ResumeMigration(w, r)
+	})
+	mux.HandleFunc("/api/v1/migrations/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.CancelMigration(w, r)
+	})
+	mux.HandleFunc("/api/v1/jobs", h.handleJobs)
+	mux.HandleFunc("/api/v1/jobs/", h.handleJobs)
+	mux.HandleFunc("/api/v1/bench", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RunBench(w, r)
+	})
+	mux.HandleFunc("/api/v1/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Search(w, r)
+	})
+	mux.HandleFunc("/api/v1/analytics/hot", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.HotFiles(w, r)
+	})
+	mux.HandleFunc("/api/v1/acl", h.ACL)
+	mux.HandleFunc("/api/v1/acl/check", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.CheckACL(w, r)
+	})
+	mux.HandleFunc("/api/v1/holds", h.Holds)
+	mux.HandleFunc("/api/v1/tags", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.GetTags(w, r)
+		case http.MethodPost:
+			h.AddTags(w, r)
+		case http.MethodDelete:
+			h.RemoveTags(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
 }
 
 // streamFile handles streaming file reads with HTTP chunked transfer encoding
 func (h *Handler) streamFile(w http.ResponseWriter, r *http.Request, path string) {
 	// Check if filesystem supports streaming
-	streamer, ok := h.fs.(filesystem.Streamer)
+	streamer, ok := h.requestFS(r).(filesystem.Streamer)
 	if !ok {
 		writeError(w, http.StatusBadRequest, "streaming not supported for this filesystem")
 		return
@@ -664,11 +1265,13 @@ func (h *Handler) streamFile(w http.ResponseWriter, r *http.Request, path string
 	defer reader.Close()
 
 	// Stream data to client
-	h.streamFromStreamReader(w, r, reader)
+	read, _ := h.limiters(r, path)
+	h.streamFromStreamReader(w, r, reader, read)
 }
 
 // streamFromStreamReader streams data from a filesystem.StreamReader using chunked transfer
-func (h *Handler) streamFromStreamReader(w http.ResponseWriter, r *http.Request, reader filesystem.StreamReader) {
+// Chunks are written at the rate allowed by limiters
+func (h *Handler) streamFromStreamReader(w http.ResponseWriter, r *http.Request, reader filesystem.StreamReader, limiters []*throttle.Limiter) {
 	// Set headers for chunked transfer
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -681,6 +1284,8 @@ func (h *Handler) streamFromStreamReader(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	out := throttle.NewWriter(r.Context(), w, limiters...)
+
 	log.Debugf("Starting stream read")
 
 	// Read timeout for each chunk
@@ -729,7 +1334,7 @@ func (h *Handler) streamFromStreamReader(w http.ResponseWriter, r *http.Request,
 				if end > len(chunk) {
 					end = len(chunk)
 				}
-				n, writeErr := w.Write(chunk[offset:end])
+				n, writeErr := out.Write(chunk[offset:end])
 				if writeErr != nil {
 					log.Debugf("Error writing chunk: %v (this is normal if client disconnected)", writeErr)
 					return
@@ -748,11 +1353,14 @@ func (h *Handler) streamFromStreamReader(w http.ResponseWriter, r *http.Request,
 
 // GrepRequest represents a grep search request
 type GrepRequest struct {
-	Path            string `json:"path"`              // Path to file or directory to search
-	Pattern         string `json:"pattern"`           // Regular expression pattern
-	Recursive       bool   `json:"recursive"`         // Whether to search recursively in directories
-	CaseInsensitive bool   `json:"case_insensitive"`  // Case-insensitive matching
-	Stream          bool   `json:"stream"`            // Stream results as NDJSON (one match per line)
+	Path            string `json:"path"`                // Path to file or directory to search
+	Pattern         string `json:"pattern"`             // Regular expression pattern
+	Recursive       bool   `json:"recursive"`           // Whether to search recursively in directories
+	CaseInsensitive bool   `json:"case_insensitive"`    // Case-insensitive matching
+	Stream          bool   `json:"stream"`              // Stream results as NDJSON (one match per line)
+	MaxFiles        int64  `json:"max_files,omitempty"` // Files to scan at most, within the server budget
+	MaxBytes        int64  `json:"max_bytes,omitempty"` // Bytes to read at most, within the server budget
+	MaxTime         string `json:"max_time,omitempty"`  // Time to search at most (e.g. "10s"), within the server budget
 }
 
 // GrepMatch represents a single match result
@@ -764,11 +1372,16 @@ type GrepMatch struct {
 
 // GrepResponse represents the grep search results
 type GrepResponse struct {
-	Matches []GrepMatch `json:"matches"` // All matches
-	Count   int         `json:"count"`   // Total number of matches
+	Matches      []GrepMatch `json:"matches"`         // All matches
+	Count        int         `json:"count"`           // Total number of matches
+	Truncated    bool        `json:"truncated"`       // The budget ran out, so the matches may be partial
+	Limit        string      `json:"limit,omitempty"` // The limit that ran out: max_files, max_bytes or max_time
+	FilesScanned int64       `json:"files_scanned"`
+	BytesRead    int64       `json:"bytes_read"`
 }
 
 // Grep searches for a pattern in files
+// The search stops when the budget of the server, or the lower one of the request, runs out
 func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 	var req GrepRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -785,6 +1398,19 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "pattern is required")
 		return
 	}
+	reqBudget := Budget{MaxFiles: req.MaxFiles, MaxBytes: req.MaxBytes}
+	if req.MaxFiles < 0 || req.MaxBytes < 0 {
+		writeError(w, http.StatusBadRequest, "max_files and max_bytes must not be negative")
+		return
+	}
+	if req.MaxTime != "" {
+		d, err := time.ParseDuration(req.MaxTime)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid max_time: "+req.MaxTime)
+			return
+		}
+		reqBudget.MaxTime = d
+	}
 
 	// Compile regex pattern
 	var re *regexp.Regexp
@@ -799,8 +1425,13 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Backend calls are aborted when the time budget passes
+	budget, cancel := newBudgetTracker(r.Context(), h.budget.Within(reqBudget))
+	defer cancel()
+	fs := filesystem.WithContext(h.fs, budget.ctx)
+
 	// Check if path exists and get file info
-	info, err := h.fs.Stat(req.Path)
+	info, err := fs.Stat(req.Path)
 	if err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, "failed to stat path: "+err.Error())
@@ -809,40 +1440,48 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 
 	// Handle stream mode
 	if req.Stream {
-		h.grepStream(w, req.Path, re, info.IsDir, req.Recursive)
+		h.grepStream(fs, w, req.Path, re, info.IsDir, req.Recursive, budget)
 		return
 	}
 
 	// Non-stream mode: collect all matches
 	var matches []GrepMatch
+	collect := func(match GrepMatch) error {
+		matches = append(matches, match)
+		return nil
+	}
 
 	// Search in file or directory
 	if info.IsDir {
 		if req.Recursive {
-			matches, err = h.grepDirectory(req.Path, re)
+			err = h.grepDirectoryStream(fs, req.Path, re, budget, collect)
 		} else {
 			writeError(w, http.StatusBadRequest, "path is a directory, use recursive=true to search")
 			return
 		}
 	} else {
-		matches, err = h.grepFile(req.Path, re)
+		err = h.grepFileStream(fs, req.Path, re, budget, collect)
 	}
 
-	if err != nil {
+	if err != nil && !errors.Is(err, errBudgetExceeded) {
 		writeError(w, http.StatusInternalServerError, "grep failed: "+err.Error())
 		return
 	}
 
 	response := GrepResponse{
-		Matches: matches,
-		Count:   len(matches),
+		Matches:      matches,
+		Count:        len(matches),
+		Truncated:    budget.exceeded != "",
+		Limit:        budget.exceeded,
+		FilesScanned: budget.files,
+		BytesRead:    budget.bytes,
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
 // grepStream handles streaming grep results as NDJSON
-func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Regexp, isDir bool, recursive bool) {
+func (h *Handler) grepStream(fs filesystem.FileSystem, w http.ResponseWriter, path string, re *regexp.Regexp, isDir bool, recursive bool, budget *budgetTracker) {
 	// Set headers for NDJSON streaming
 	w.Header().Set("Content-Type", "application/x-ndjson")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -880,17 +1519,23 @@ func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Rege
 			flusher.Flush()
 			return
 		}
-		err = h.grepDirectoryStream(path, re, sendMatch)
+		err = h.grepDirectoryStream(fs, path, re, budget, sendMatch)
 	} else {
-		err = h.grepFileStream(path, re, sendMatch)
+		err = h.grepFileStream(fs, path, re, budget, sendMatch)
 	}
 
 	// Send final summary with count
 	summary := map[string]interface{}{
-		"type":  "summary",
-		"count": matchCount,
+		"type":          "summary",
+		"count":         matchCount,
+		"truncated":     budget.exceeded != "",
+		"files_scanned": budget.files,
+		"bytes_read":    budget.bytes,
 	}
-	if err != nil {
+	if budget.exceeded != "" {
+		summary["limit"] = budget.exceeded
+	}
+	if err != nil && !errors.Is(err, errBudgetExceeded) {
 		summary["error"] = err.Error()
 	}
 	encoder.Encode(summary)
@@ -898,11 +1543,21 @@ func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Rege
 }
 
 // grepFileStream searches for pattern in a single file and calls callback for each match
-func (h *Handler) grepFileStream(path string, re *regexp.Regexp, callback func(GrepMatch) error) error {
+// Only the part of the file within the byte budget is searched
+func (h *Handler) grepFileStream(fs filesystem.FileSystem, path string, re *regexp.Regexp, budget *budgetTracker, callback func(GrepMatch) error) error {
+	limit, err := budget.file()
+	if err != nil {
+		return err
+	}
+
 	// Read file content
-	data, err := h.fs.Read(path, 0, -1)
+	data, err := fs.Read(path, 0, limit)
+	budget.read(len(data))
 	// io.EOF is normal when reading entire file, only return error for other errors
 	if err != nil && err != io.EOF {
+		if budget.check() != nil {
+			return errBudgetExceeded
+		}
 		return err
 	}
 
@@ -932,79 +1587,19 @@ func (h *Handler) grepFileStream(path string, re *regexp.Regexp, callback func(G
 }
 
 // grepDirectoryStream recursively searches for pattern in a directory and calls callback for each match
-func (h *Handler) grepDirectoryStream(dirPath string, re *regexp.Regexp, callback func(GrepMatch) error) error {
-	// List directory contents
-	entries, err := h.fs.ReadDir(dirPath)
-	if err != nil {
+// It returns errBudgetExceeded when the budget runs out
+func (h *Handler) grepDirectoryStream(fs filesystem.FileSystem, dirPath string, re *regexp.Regexp, budget *budgetTracker, callback func(GrepMatch) error) error {
+	if err := budget.check(); err != nil {
 		return err
 	}
 
-	for _, entry := range entries {
-		// Build full path
-		fullPath := filepath.Join(dirPath, entry.Name)
-		// Clean path to use forward slashes
-		fullPath = filepath.ToSlash(fullPath)
-
-		if entry.IsDir {
-			// Recursively search subdirectories
-			if err := h.grepDirectoryStream(fullPath, re, callback); err != nil {
-				// Log error but continue searching other files
-				log.Warnf("failed to search directory %s: %v", fullPath, err)
-				continue
-			}
-		} else {
-			// Search in file
-			if err := h.grepFileStream(fullPath, re, callback); err != nil {
-				// Log error but continue searching other files
-				log.Warnf("failed to search file %s: %v", fullPath, err)
-				continue
-			}
-		}
-	}
-
-	return nil
-}
-
-// grepFile searches for pattern in a single file
-func (h *Handler) grepFile(path string, re *regexp.Regexp) ([]GrepMatch, error) {
-	// Read file content
-	data, err := h.fs.Read(path, 0, -1)
-	// io.EOF is normal when reading entire file, only return error for other errors
-	if err != nil && err != io.EOF {
-		return nil, err
-	}
-
-	var matches []GrepMatch
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	lineNum := 1
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if re.MatchString(line) {
-			matches = append(matches, GrepMatch{
-				File:    path,
-				Line:    lineNum,
-				Content: line,
-			})
-		}
-		lineNum++
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
-
-	return matches, nil
-}
-
-// grepDirectory recursively searches for pattern in a directory
-func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch, error) {
-	var allMatches []GrepMatch
-
-	// List directory contents
-	entries, err := h.fs.ReadDir(dirPath)
+	// List directory contents, names are enough
+	entries, err := filesystem.ReadDirFields(fs, dirPath, filesystem.ListFields{})
 	if err != nil {
-		return nil, err
+		if budget.check() != nil {
+			return errBudgetExceeded
+		}
+		return err
 	}
 
 	for _, entry := range entries {
@@ -1015,26 +1610,21 @@ func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch,
 
 		if entry.IsDir {
 			// Recursively search subdirectories
-			subMatches, err := h.grepDirectory(fullPath, re)
-			if err != nil {
-				// Log error but continue searching other files
-				log.Warnf("failed to search directory %s: %v", fullPath, err)
-				continue
-			}
-			allMatches = append(allMatches, subMatches...)
+			err = h.grepDirectoryStream(fs, fullPath, re, budget, callback)
 		} else {
 			// Search in file
-			matches, err := h.grepFile(fullPath, re)
-			if err != nil {
-				// Log error but continue searching other files
-				log.Warnf("failed to search file %s: %v", fullPath, err)
-				continue
-			}
-			allMatches = append(allMatches, matches...)
+			err = h.grepFileStream(fs, fullPath, re, budget, callback)
+		}
+		if errors.Is(err, errBudgetExceeded) {
+			return err
+		}
+		if err != nil {
+			// Log error but continue searching other files
+			log.Warnf("failed to search %s: %v", fullPath, err)
 		}
 	}
 
-	return allMatches, nil
+	return nil
 }
 
 // LoggingMiddleware logs HTTP requests
@@ -0,0 +1,339 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/kvfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/serverinfofs"
+)
+
+// withLocalFS mounts localfs over dir at /local
+func withLocalFS(dir string) pfstest.Option {
+	return pfstest.WithPlugin("/local", localfs.NewLocalFSPlugin(), map[string]interface{}{"local_dir": dir})
+}
+
+// Files and trees are copied within a mount and between mounts
+func TestCopy(t *testing.T) {
+	srv := pfstest.NewServer(t, withLocalFS(t.TempDir()))
+	c := srv.Client
+	files := map[string]string{"/src/a.txt": "alpha", "/src/sub/b.txt": strings.Repeat("b", 300*1024)}
+	for p, data := range files {
+		srv.Seed(map[string]string{"/memfs" + p: data})
+	}
+	read := func(p string) string {
+		data, err := c.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("read %s: %v", p, err)
+		}
+		return string(data)
+	}
+
+	// A file, within the mount and to another one
+	if err := c.Copy("/memfs/src/a.txt", "/memfs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Copy("/memfs/src/a.txt", "/local/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if read("/memfs/a.txt") != "alpha" || read("/local/a.txt") != "alpha" {
+		t.Error("copied files differ from the source")
+	}
+
+	// Trees, to another mount and back
+	stats, err := c.CopyAll("/memfs/src", "/local/dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Dirs != 2 || stats.Bytes != int64(5+300*1024) {
+		t.Errorf("copy stats = %+v", stats)
+	}
+	if _, err := c.CopyAll("/local/dst", "/memfs/back"); err != nil {
+		t.Fatal(err)
+	}
+	for p, data := range files {
+		if read("/local/dst"+strings.TrimPrefix(p, "/src")) != data || read("/memfs/back"+strings.TrimPrefix(p, "/src")) != data {
+			t.Errorf("%s was not copied", p)
+		}
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/memfs/src", `{"dest": "/local/nr"}`, http.StatusBadRequest},
+		{"/memfs/src", `{"dest": "/memfs/src/sub/loop", "recursive": true}`, http.StatusBadRequest},
+		{"/nonexistent/a.txt", `{"dest": "/memfs/a.txt"}`, http.StatusNotFound},
+		{"/memfs/src/a.txt", `{}`, http.StatusBadRequest},
+	} {
+		resp, err := http.Post(srv.URL+"/api/v1/copy?path="+tc.path, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("copy %s %s: status %d, want %d", tc.path, tc.body, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+// Control files are read in the format asked for by Accept or format=
+func TestReadFormat(t *testing.T) {
+	srv := pfstest.NewServer(t,
+		pfstest.WithPlugin("/queuefs", queuefs.NewQueueFSPlugin(), nil),
+		pfstest.WithPlugin("/serverinfofs", serverinfofs.NewServerInfoFSPlugin(), nil))
+	get := func(path, accept string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/files?path="+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	c := srv.Client
+	if err := c.Mkdir("/queuefs/jobs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"first", "second"} {
+		if _, err := c.Write("/queuefs/jobs/enqueue", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ct, body := get("/queuefs/jobs/dequeue", "text/plain"); ct != "text/plain; charset=utf-8" || body != "first" {
+		t.Errorf("dequeue as text = %q, %q", ct, body)
+	}
+	ct, body := get("/queuefs/jobs/dequeue", "application/json, text/plain;q=0.5")
+	var msg struct{ Data string }
+	if json.Unmarshal([]byte(body), &msg); ct != "application/json" || msg.Data != "second" {
+		t.Errorf("dequeue as JSON = %q, %q", ct, body)
+	}
+	if ct, body := get("/queuefs/jobs/size", "application/json"); ct != "application/json" || body != `{"size":0}` {
+		t.Errorf("size as JSON = %q, %q", ct, body)
+	}
+	if ct, body := get("/queuefs/jobs/size", ""); ct != "application/octet-stream" || body != "0" {
+		t.Errorf("size without Accept = %q, %q", ct, body)
+	}
+	if ct, body := get("/serverinfofs/stats", "text/plain"); ct != "text/plain; charset=utf-8" || !strings.Contains(body, "memory.alloc: ") {
+		t.Errorf("stats as text = %q, %q", ct, body)
+	}
+	if data, format, err := c.ReadFormat("/serverinfofs/version", 0, -1, "json"); err != nil || format != "json" || !strings.HasPrefix(string(data), `{"version":`) {
+		t.Errorf("version as JSON = %q, %q, %v", data, format, err)
+	}
+	if _, _, err := c.ReadFormat("/serverinfofs/version", 0, -1, "yaml"); err == nil {
+		t.Error("unknown format: no error")
+	}
+	if ct, body := get("/serverinfofs/metrics.prom", ""); !strings.Contains(body, "\nagfs_memstats_heap_alloc ") {
+		t.Errorf("metrics.prom = %q, %q", ct, body)
+	}
+	if ct, body := get("/serverinfofs/metrics.json", "text/plain"); ct != "text/plain; charset=utf-8" || !strings.HasPrefix(body, "# TYPE agfs_") {
+		t.Errorf("metrics.json as text = %q, %q", ct, body)
+	}
+	if data, format, err := c.ReadFormat("/serverinfofs/metrics.prom", 0, -1, "json"); err != nil || format != "json" || !strings.Contains(string(data), `"name": "agfs_memstats_num_gc"`) {
+		t.Errorf("metrics.prom as JSON = %q, %q, %v", data, format, err)
+	}
+
+	info, err := c.Stat("/queuefs/jobs/peek")
+	if err != nil || info.Meta.Content["format"] != "json" || info.Meta.Content["formats"] != "json,text" {
+		t.Errorf("peek metadata = %+v, %v", info, err)
+	}
+}
+
+func TestListFields(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	srv := pfstest.NewServer(t, withLocalFS(dir))
+	list := func(query string) (int, []map[string]interface{}) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/api/v1/directories?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Files []map[string]interface{} `json:"files"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Files
+	}
+
+	status, files := list("path=/local&fields=name")
+	if status != http.StatusOK || len(files) != 2 {
+		t.Fatalf("fields=name: %d %v", status, files)
+	}
+	for _, f := range files {
+		if _, ok := f["size"]; ok {
+			t.Errorf("fields=name: unexpected size in %v", f)
+		}
+		if _, ok := f["modTime"]; ok {
+			t.Errorf("fields=name: unexpected modTime in %v", f)
+		}
+		if f["isDir"] != (f["name"] == "sub") {
+			t.Errorf("fields=name: isDir of %v", f)
+		}
+	}
+
+	status, files = list("path=/local&fields=name,size")
+	if status != http.StatusOK || len(files) != 2 {
+		t.Fatalf("fields=name,size: %d %v", status, files)
+	}
+	for _, f := range files {
+		if f["name"] == "a.txt" && f["size"] != float64(5) {
+			t.Errorf("fields=name,size: %v", f)
+		}
+		if _, ok := f["mode"]; ok {
+			t.Errorf("fields=name,size: unexpected mode in %v", f)
+		}
+	}
+
+	// Plugins without cheaper listings answer with the selected fields too
+	status, files = list("path=/memfs&fields=name")
+	if status != http.StatusOK || len(files) == 0 {
+		t.Fatalf("memfs fields=name: %d %v", status, files)
+	}
+	if _, ok := files[0]["meta"]; ok {
+		t.Errorf("memfs fields=name: unexpected meta in %v", files[0])
+	}
+
+	status, files = list("path=/local")
+	if status != http.StatusOK || len(files) != 2 || files[0]["mode"] == nil {
+		t.Errorf("all fields: %d %v", status, files)
+	}
+
+	if status, _ = list("path=/local&fields=name,owner"); status != http.StatusBadRequest {
+		t.Errorf("unknown field: status %d", status)
+	}
+}
+
+func TestListPage(t *testing.T) {
+	srv := pfstest.NewServer(t, pfstest.WithPlugin("/queuefs", queuefs.NewQueueFSPlugin(), nil))
+	c := srv.Client
+	names := func(files []filesystem.FileInfo) string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	srv.Seed(map[string]string{"/memfs/dir/a": "xxx", "/memfs/dir/b": "x", "/memfs/dir/c": "xx"})
+	files, more, err := c.ReadDirPage("/memfs/dir", filesystem.ListPage{Sort: filesystem.SortSize, Desc: true, Limit: 2})
+	if err != nil || names(files) != "a,c" || !more {
+		t.Errorf("first page by size = %s, %v, %v", names(files), more, err)
+	}
+	files, more, err = c.ReadDirPage("/memfs/dir", filesystem.ListPage{Sort: filesystem.SortSize, Desc: true, Offset: 2, Limit: 2})
+	if err != nil || names(files) != "b" || more {
+		t.Errorf("last page by size = %s, %v, %v", names(files), more, err)
+	}
+
+	// Queue messages are paged in queue order by the backend
+	if err := c.Mkdir("/queuefs/jobs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, msg := range []string{"first", "stuck", "third"} {
+		id, err := c.Write("/queuefs/jobs/enqueue", []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, string(id))
+	}
+	files, more, err = c.ReadDirPage("/queuefs/jobs/messages", filesystem.ListPage{Offset: 1, Limit: 1})
+	if err != nil || names(files) != ids[1] || files[0].Size != int64(len("stuck")) || !more {
+		t.Errorf("message page = %+v, %v, %v", files, more, err)
+	}
+	if err := c.Remove("/queuefs/jobs/messages/" + ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read("/queuefs/jobs/size", 0, -1); err != nil || string(data) != "2" {
+		t.Errorf("size after removing a message = %q, %v", data, err)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/directories?path=/memfs&sort=owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown sort key: status %d", resp.StatusCode)
+	}
+}
+
+// READMEs are rendered with the mount's configuration in the language and format asked for
+func TestReadme(t *testing.T) {
+	srv := pfstest.NewServer(t,
+		pfstest.WithPlugin("/store", kvfs.NewKVFSPlugin(), nil),
+		pfstest.WithPlugin("/queuefs", queuefs.NewQueueFSPlugin(), nil))
+	// Mounted by type, as the server does, so that the mount keeps its configuration
+	srv.FS.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := srv.FS.MountPlugin("memfs", "/mem", map[string]interface{}{"compact_interval": "1m"}); err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string, header ...string) (string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/files?"+query, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", query, resp.StatusCode, body)
+		}
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	ct, body := get("path=/store/README")
+	if ct != "application/octet-stream" || !strings.HasPrefix(body, "KVFS Plugin") || !strings.Contains(body, "cat /store/keys/mykey") {
+		t.Errorf("README = %q:\n%s", ct, body)
+	}
+	if _, body := get("path=/store/README", "Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8"); !strings.HasPrefix(body, "KVFS 插件") {
+		t.Errorf("README in Chinese:\n%s", body)
+	}
+	if _, body := get("path=/store/README&lang=fr"); !strings.HasPrefix(body, "KVFS Plugin") {
+		t.Errorf("README in a language without a translation:\n%s", body)
+	}
+	if _, body := get("path=/mem/README"); !strings.Contains(body, "compact_interval  1m") || !strings.Contains(body, "mkdir /mem/data") {
+		t.Errorf("README with the mount's configuration:\n%s", body)
+	}
+
+	ct, body = get("path=/store/README&lang=zh", "Accept", "text/markdown")
+	if ct != "text/markdown; charset=utf-8" || !strings.HasPrefix(body, "# KVFS 插件") || !strings.Contains(body, "## 用法\n```\n设置键值:") {
+		t.Errorf("README as Markdown = %q:\n%s", ct, body)
+	}
+	// READMEs that are not templates are converted to Markdown too
+	ct, body = get("path=/queuefs/README&format=markdown")
+	if ct != "text/markdown; charset=utf-8" || !strings.HasPrefix(body, "# QueueFS Plugin") || !strings.Contains(body, "\n## ") {
+		t.Errorf("plain README as Markdown = %q:\n%s", ct, body)
+	}
+	if ct, body := get("path=/queuefs/README", "Accept", "text/plain"); ct != "text/plain; charset=utf-8" || !strings.HasPrefix(body, "QueueFS Plugin") {
+		t.Errorf("plain README as text = %q:\n%s", ct, body)
+	}
+}
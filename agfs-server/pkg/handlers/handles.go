@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tenancy"
+)
+
+// Limits of the open handles of /api/v2
+const (
+	MaxOpenHandles         = 1024              // Handles open at the same time
+	MaxHandlesPerOwner     = 64                // Handles a principal or tenant may have open at the same time
+	HandleIdleTimeout      = 5 * time.Minute   // Unused handles are closed after this long, discarding unsynced writes
+	MaxHandleWriteSize     = 64 * 1024 * 1024  // Size a file opened for writing may grow to
+	MaxHandleBufferedBytes = 512 * 1024 * 1024 // Content all writable handles may buffer together
+)
+
+// Handle open modes
+const (
+	HandleModeRead      = "r"  // Reads go straight to the file
+	HandleModeWrite     = "w"  // Writes are buffered and stored on sync or close
+	HandleModeReadWrite = "rw" // Reads see the buffered writes
+)
+
+// openHandle is a file opened through /api/v2/handles
+// Writable handles hold the file's content; the plugin's Write replaces whole files,
+// so writes at offsets are applied to the buffer, which is written back on sync and close
+type openHandle struct {
+	id    string
+	path  string
+	mode  string
+	owner string       // See Handler.handleOwner
+	table *handleTable // Accounts for the buffered content
+
+	mu       sync.Mutex // protects the fields below
+	data     []byte     // Content of a writable handle
+	dirty    bool       // Set if data has writes that are not stored yet
+	closed   bool       // Set once the handle is removed from the table, its buffer no longer grows
+	lastUsed time.Time
+}
+
+// writable checks if the handle accepts writes
+func (oh *openHandle) writable() bool {
+	return oh.mode != HandleModeRead
+}
+
+// readAt reads size bytes at offset through fs, or from the buffer of a writable handle,
+// with the semantics of filesystem.FileSystem.Read
+func (oh *openHandle) readAt(fs filesystem.FileSystem, offset, size int64) ([]byte, error) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.lastUsed = time.Now()
+	if !oh.writable() {
+		return fs.Read(oh.path, offset, size)
+	}
+	if offset < 0 {
+		return nil, filesystem.NewInvalidArgumentError("offset", offset, "must not be negative")
+	}
+	if offset >= int64(len(oh.data)) {
+		return []byte{}, io.EOF
+	}
+	end := int64(len(oh.data))
+	if size >= 0 && offset+size < end {
+		end = offset + size
+	}
+	data := append([]byte(nil), oh.data[offset:end]...)
+	if end == int64(len(oh.data)) {
+		return data, io.EOF
+	}
+	return data, nil
+}
+
+// writeAt writes data at offset into the buffer, extending the file with zeros if offset
+// is past its end; offset -1 appends. Returns the size of the file after the write
+func (oh *openHandle) writeAt(offset int64, data []byte) (int64, error) {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.lastUsed = time.Now()
+	if oh.closed {
+		return 0, filesystem.NewInvalidArgumentError("handle", oh.id, "is closed")
+	}
+	if offset < 0 {
+		offset = int64(len(oh.data))
+	}
+	end := offset + int64(len(data))
+	if end > MaxHandleWriteSize {
+		return 0, filesystem.NewInvalidArgumentError("offset", offset, "file would grow beyond the handle write limit")
+	}
+	if end > int64(len(oh.data)) {
+		if !oh.table.reserve(end - int64(len(oh.data))) {
+			return 0, filesystem.NewUnavailableError("write", oh.path, "too much content buffered by open handles")
+		}
+		oh.data = append(oh.data, make([]byte, end-int64(len(oh.data)))...)
+	}
+	copy(oh.data[offset:], data)
+	oh.dirty = true
+	return int64(len(oh.data)), nil
+}
+
+// sync stores the buffered writes of the handle through fs
+func (oh *openHandle) sync(fs filesystem.FileSystem) error {
+	oh.mu.Lock()
+	defer oh.mu.Unlock()
+	oh.lastUsed = time.Now()
+	if !oh.dirty {
+		return nil
+	}
+	if _, err := fs.Write(oh.path, oh.data); err != nil {
+		return err
+	}
+	oh.dirty = false
+	return nil
+}
+
+// handleTable keeps the open handles of a Handler
+type handleTable struct {
+	mu       sync.Mutex
+	handles  map[string]*openHandle
+	buffered atomic.Int64 // Bytes buffered by the writable handles, at most MaxHandleBufferedBytes
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{handles: make(map[string]*openHandle)}
+}
+
+// open opens path in mode through fs; create creates a missing file and truncate starts
+// writable handles with an empty file. The handle belongs to owner
+func (t *handleTable) open(fs filesystem.FileSystem, owner, path, mode string, create, truncate bool) (*openHandle, *filesystem.FileInfo, error) {
+	if mode != HandleModeRead && mode != HandleModeWrite && mode != HandleModeReadWrite {
+		return nil, nil, filesystem.NewInvalidArgumentError("mode", mode, "must be r, w or rw")
+	}
+
+	oh := &openHandle{path: path, mode: mode, owner: owner, table: t, lastUsed: time.Now()}
+	info, err := fs.Stat(path)
+	switch {
+	case err == nil && info.IsDir:
+		return nil, nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	case err == nil && oh.writable() && !truncate:
+		if info.Size > MaxHandleWriteSize {
+			return nil, nil, filesystem.NewInvalidArgumentError("path", path, "file is too large to be opened for writing")
+		}
+		data, err := fs.Read(path, 0, -1)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		// Writes change the buffer in place, it must not share memory with the plugin
+		oh.data = append([]byte(nil), data...)
+	case err == nil && oh.writable():
+		oh.dirty = true
+	case filesystem.IsNotFound(err) && create:
+		// The file is created right away so that it is visible while the handle is open
+		if err := fs.Create(path); err != nil {
+			return nil, nil, err
+		}
+		if info, err = fs.Stat(path); err != nil {
+			return nil, nil, err
+		}
+	case err != nil:
+		return nil, nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+	oh.id = hex.EncodeToString(id)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expireLocked()
+	if len(t.handles) >= MaxOpenHandles {
+		return nil, nil, filesystem.NewUnavailableError("open", path, "too many open handles")
+	}
+	if owner != "" && t.countLocked(owner) >= MaxHandlesPerOwner {
+		return nil, nil, filesystem.NewUnavailableError("open", path, "too many open handles for this principal")
+	}
+	if !t.reserve(int64(len(oh.data))) {
+		return nil, nil, filesystem.NewUnavailableError("open", path, "too much content buffered by open handles")
+	}
+	t.handles[oh.id] = oh
+	return oh, info, nil
+}
+
+// reserve accounts for n more buffered bytes, and returns false if they exceed
+// MaxHandleBufferedBytes
+func (t *handleTable) reserve(n int64) bool {
+	if t.buffered.Add(n) > MaxHandleBufferedBytes {
+		t.buffered.Add(-n)
+		return false
+	}
+	return true
+}
+
+// countLocked returns the number of handles open for owner
+func (t *handleTable) countLocked(owner string) int {
+	n := 0
+	for _, oh := range t.handles {
+		if oh.owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// get returns the handle with id if it is open for owner
+func (t *handleTable) get(id, owner string) (*openHandle, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	oh, ok := t.handles[id]
+	if !ok || oh.owner != owner {
+		return nil, false
+	}
+	return oh, true
+}
+
+// remove forgets the handle with id
+func (t *handleTable) remove(id string) (*openHandle, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	oh, ok := t.handles[id]
+	if ok {
+		t.forgetLocked(oh)
+	}
+	return oh, ok
+}
+
+// forgetLocked removes oh from the table and releases its buffer; the content stays
+// readable for the sync of a closing handle
+func (t *handleTable) forgetLocked(oh *openHandle) {
+	delete(t.handles, oh.id)
+	oh.mu.Lock()
+	oh.closed = true
+	t.buffered.Add(-int64(len(oh.data)))
+	oh.mu.Unlock()
+}
+
+// expireLocked forgets handles unused for HandleIdleTimeout
+func (t *handleTable) expireLocked() {
+	now := time.Now()
+	for _, oh := range t.handles {
+		oh.mu.Lock()
+		idle := now.Sub(oh.lastUsed)
+		oh.mu.Unlock()
+		if idle > HandleIdleTimeout {
+			t.forgetLocked(oh)
+		}
+	}
+}
+
+// HandlePrincipalFunc returns the authenticated principal of the request a context belongs
+// to, and false for requests without one
+type HandlePrincipalFunc func(ctx context.Context) (string, bool)
+
+// SetHandlePrincipal binds open handles to the principal it returns for the requests that
+// open them; only that principal may use or close them
+func (h *Handler) SetHandlePrincipal(principal HandlePrincipalFunc) {
+	h.principal = principal
+}
+
+// handleOwner returns who the handles opened by r belong to: its authenticated principal,
+// else its tenant, else "" on servers with neither, where any client may use any handle
+func (h *Handler) handleOwner(r *http.Request) string {
+	if h.principal != nil {
+		if name, ok := h.principal(r.Context()); ok {
+			return "principal:" + name
+		}
+	}
+	if t := tenancy.FromContext(r.Context()); t != nil {
+		return "tenant:" + t.Name
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// Handles belong to the principal that opened them, which may only have so many open
+func TestHandleOwners(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/memfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/memfs/f", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(mfs)
+	h.SetHandlePrincipal(principalOf)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	do := func(principal, method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if principal != "" {
+			req = req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	open := func(principal string) (*httptest.ResponseRecorder, string) {
+		t.Helper()
+		rec := do(principal, http.MethodPost, "/api/v2/handles?path=/memfs/f&mode=rw", "")
+		var resp OpenHandleResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, "/api/v2/handles/" + resp.Handle
+	}
+
+	_, handle := open("alice")
+	for _, tc := range []struct{ method, url, body string }{
+		{http.MethodGet, handle, ""},
+		{http.MethodPut, handle, "x"},
+		{http.MethodPost, handle + "/sync", ""},
+		{http.MethodDelete, handle, ""},
+	} {
+		for _, principal := range []string{"bob", ""} {
+			if rec := do(principal, tc.method, tc.url, tc.body); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), CodeInvalidHandle) {
+				t.Errorf("%s %s by %q = %d %s", tc.method, tc.url, principal, rec.Code, rec.Body)
+			}
+		}
+	}
+	if rec := do("alice", http.MethodGet, handle, ""); rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("read by the owner = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("alice", http.MethodDelete, handle, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("close by the owner = %d %s", rec.Code, rec.Body)
+	}
+
+	// The limit of a principal leaves the others their handles
+	for i := 0; i < MaxHandlesPerOwner; i++ {
+		if rec, _ := open("alice"); rec.Code != http.StatusCreated {
+			t.Fatalf("open %d = %d %s", i, rec.Code, rec.Body)
+		}
+	}
+	if rec, _ := open("alice"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("open past the limit = %d %s", rec.Code, rec.Body)
+	}
+	if rec, _ := open("bob"); rec.Code != http.StatusCreated {
+		t.Errorf("open by another principal = %d %s", rec.Code, rec.Body)
+	}
+}
+
+// Writable handles buffer at most MaxHandleBufferedBytes together
+func TestHandleBuffers(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	if _, err := fs.Write("/f", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	table := newHandleTable()
+	oh, _, err := table.open(fs, "", "/f", HandleModeReadWrite, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := table.buffered.Load(); n != 5 {
+		t.Fatalf("buffered = %d after opening a 5 byte file", n)
+	}
+
+	table.buffered.Add(MaxHandleBufferedBytes - 8)
+	if _, err := oh.writeAt(-1, []byte("world")); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("write past the limit: %v", err)
+	}
+	if _, err := oh.writeAt(0, []byte("HELLO")); err != nil {
+		t.Errorf("write within the buffer: %v", err)
+	}
+	if _, err := oh.writeAt(-1, []byte("!!!")); err != nil {
+		t.Errorf("write up to the limit: %v", err)
+	}
+	if _, _, err := table.open(fs, "", "/f", HandleModeWrite, false, false); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("open past the limit: %v", err)
+	}
+	if _, _, err := table.open(fs, "", "/f", HandleModeRead, false, false); err != nil {
+		t.Errorf("read handles buffer nothing: %v", err)
+	}
+
+	// Closing releases the buffer, and closed handles no longer grow
+	table.remove(oh.id)
+	if n := table.buffered.Load(); n != MaxHandleBufferedBytes-8 {
+		t.Errorf("buffered = %d after closing", n)
+	}
+	if _, err := oh.writeAt(-1, []byte("x")); err == nil {
+		t.Error("write to a closed handle")
+	}
+}
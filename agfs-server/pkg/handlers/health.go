@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// defaultReadyTimeout bounds the backend checks of GET /readyz
+const defaultReadyTimeout = 5 * time.Second
+
+// LivenessResponse is the response of GET /livez
+type LivenessResponse struct {
+	Status        string `json:"status"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+}
+
+// MountHealth is the readiness of a mount
+type MountHealth struct {
+	Path      string `json:"path"`
+	Plugin    string `json:"plugin"`
+	State     string `json:"state"`   // A mount state of GET /status
+	Checked   bool   `json:"checked"` // Whether the backend was checked; plugins without a check are not
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs,omitempty"` // Duration of the backend check
+}
+
+// ReadinessResponse is the response of GET /readyz
+type ReadinessResponse struct {
+	Status string        `json:"status"` // "ready" or "not ready"
+	Mounts []MountHealth `json:"mounts"`
+}
+
+// Livez handles GET /livez: the process is up and serving requests
+func (h *Handler) Livez(w http.ResponseWriter, r *http.Request) {
+	resp := LivenessResponse{Status: "alive"}
+	if h.startup != nil {
+		resp.UptimeSeconds = int64(time.Since(h.startup.StartedAt()).Seconds())
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Readyz handles GET /readyz?timeout=<duration>: the configured mounts are initialized and
+// the backends of the active mounts are reachable. It answers 503 otherwise
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultReadyTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid timeout: "+v)
+			return
+		}
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	resp := ReadinessResponse{Status: "ready", Mounts: []MountHealth{}}
+	var mounts []*mountablefs.MountPoint
+	if mfs, ok := h.fs.(*mountablefs.MountableFS); ok {
+		mounts = mfs.GetMounts()
+	}
+	served := make(map[string]bool, len(mounts))
+	for _, mount := range mounts {
+		served[mount.Path] = true
+	}
+
+	// Configured mounts that are not served: pending or failed ones make the server not
+	// ready, while unmounting through the API is deliberate
+	if h.startup != nil {
+		for _, m := range h.startup.Mounts() {
+			if m.State != MountPending && m.State != MountFailed {
+				continue
+			}
+			if served[m.Path] {
+				continue
+			}
+			resp.Mounts = append(resp.Mounts, MountHealth{Path: m.Path, Plugin: m.Plugin, State: m.State, Error: m.Error})
+		}
+	}
+
+	checks := make([]MountHealth, len(mounts))
+	var wg sync.WaitGroup
+	for i, mount := range mounts {
+		wg.Add(1)
+		go func(i int, mount *mountablefs.MountPoint) {
+			defer wg.Done()
+			checks[i] = checkMount(ctx, mount)
+		}(i, mount)
+	}
+	wg.Wait()
+	resp.Mounts = append(resp.Mounts, checks...)
+
+	sort.Slice(resp.Mounts, func(i, j int) bool { return resp.Mounts[i].Path < resp.Mounts[j].Path })
+	status := http.StatusOK
+	for _, m := range resp.Mounts {
+		if !m.Healthy {
+			resp.Status = "not ready"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeJSON(w, status, resp)
+}
+
+// checkMount runs the backend check of mount, giving up when ctx is done even if the
+// plugin does not honor it
+func checkMount(ctx context.Context, mount *mountablefs.MountPoint) MountHealth {
+	health := MountHealth{Path: mount.Path, Plugin: mount.Plugin.Name(), State: MountActive}
+	type result struct {
+		checked bool
+		err     error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		checked, err := mount.HealthCheck(ctx)
+		done <- result{checked, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		res = result{true, ctx.Err()}
+	}
+	health.Checked = res.checked
+	if res.checked {
+		health.LatencyMs = time.Since(start).Milliseconds()
+	}
+	health.Healthy = res.err == nil
+	if res.err != nil {
+		health.Error = res.err.Error()
+	}
+	return health
+}
@@ -0,0 +1,46 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+func TestProbes(t *testing.T) {
+	srv := pfstest.NewServer(t)
+
+	resp, err := http.Get(srv.URL + "/livez")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("livez status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ready handlers.ReadinessResponse
+	err = json.NewDecoder(resp.Body).Decode(&ready)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || ready.Status != "ready" {
+		t.Fatalf("readyz = %d %+v, %v", resp.StatusCode, ready, err)
+	}
+	if len(ready.Mounts) != 1 || ready.Mounts[0].Path != "/memfs" || !ready.Mounts[0].Healthy || ready.Mounts[0].Checked {
+		t.Errorf("readyz mounts = %+v", ready.Mounts)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/readyz?timeout=soon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("readyz with invalid timeout: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/holds"
+)
+
+// SetHolds sets the holds listed, placed and released through the holds endpoint
+func (h *Handler) SetHolds(m *holds.Manager) {
+	h.holds = m
+}
+
+// Holds handles /holds: GET ?path=<prefix> lists the active holds, POST places or extends
+// one and DELETE ?path=<path> releases a legal hold
+// Placing and releasing holds is limited to the principals of the holds configuration, or
+// to admins without them.
+func (h *Handler) Holds(w http.ResponseWriter, r *http.Request) {
+	if h.holds == nil {
+		writeError(w, http.StatusNotFound, "holds are not enabled")
+		return
+	}
+	if r.Method == http.MethodGet {
+		prefix := r.URL.Query().Get("path")
+		if prefix == "" {
+			prefix = "/"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"holds": h.holds.List(prefix)})
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name, ok := h.holds.Authorize(r.Context())
+	if !ok {
+		writeError(w, http.StatusForbidden, "not authorized to manage holds")
+		return
+	}
+
+	var hold holds.Hold
+	var err error
+	if r.Method == http.MethodPost {
+		var req holds.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if req.Path == "" {
+			writeError(w, http.StatusBadRequest, "path is required")
+			return
+		}
+		if _, err := h.requestFS(r).Stat(req.Path); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		hold, err = h.holds.Place(req, name)
+	} else {
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			writeError(w, http.StatusBadRequest, "path parameter is required")
+			return
+		}
+		hold, err = h.holds.Release(path)
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, hold)
+}
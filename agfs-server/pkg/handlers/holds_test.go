@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/holds"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+type principalKey struct{}
+
+func principalOf(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(principalKey{}).(string)
+	return name, ok
+}
+
+// Holds are placed and released by the principals of the configuration, and keep the held
+// paths from being changed meanwhile
+func TestHolds(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/memfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/memfs/contract.pdf", []byte("signed")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := holds.New(config.HoldsConfig{File: filepath.Join(t.TempDir(), "holds.json"), Principals: []string{"legal"}}, principalOf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetHolds(m)
+	h := NewHandler(mfs)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	do := func(principal, method, url, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if principal != "" {
+			req = req.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("legal", http.MethodGet, "/api/v1/holds", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("holds not enabled: status %d", rec.Code)
+	}
+	h.SetHolds(m)
+
+	place := `{"path": "/memfs/contract.pdf", "legalHold": true, "reason": "litigation"}`
+	for _, tc := range []struct {
+		principal, method, url, body string
+		status                       int
+	}{
+		{"bob", http.MethodPost, "/api/v1/holds", place, http.StatusForbidden},
+		{"", http.MethodPost, "/api/v1/holds", place, http.StatusForbidden},
+		{"legal", http.MethodPost, "/api/v1/holds", "{", http.StatusBadRequest},
+		{"legal", http.MethodPost, "/api/v1/holds", `{"legalHold": true}`, http.StatusBadRequest},
+		{"legal", http.MethodPost, "/api/v1/holds", `{"path": "/nonexistent/a", "legalHold": true}`, http.StatusNotFound},
+		{"legal", http.MethodPost, "/api/v1/holds", `{"path": "/memfs/contract.pdf"}`, http.StatusBadRequest},
+		{"legal", http.MethodPut, "/api/v1/holds", place, http.StatusMethodNotAllowed},
+		{"legal", http.MethodDelete, "/api/v1/holds", "", http.StatusBadRequest},
+		{"legal", http.MethodDelete, "/api/v1/holds?path=/memfs/contract.pdf", "", http.StatusNotFound},
+	} {
+		if rec := do(tc.principal, tc.method, tc.url, tc.body); rec.Code != tc.status {
+			t.Errorf("%q %s %s %s: status %d, want %d", tc.principal, tc.method, tc.url, tc.body, rec.Code, tc.status)
+		}
+	}
+
+	rec := do("legal", http.MethodPost, "/api/v1/holds", place)
+	var hold holds.Hold
+	if err := json.Unmarshal(rec.Body.Bytes(), &hold); rec.Code != http.StatusOK || err != nil || !hold.LegalHold || hold.By != "legal" {
+		t.Fatalf("place = %d %s", rec.Code, rec.Body)
+	}
+	if _, err := mfs.Write("/memfs/contract.pdf", []byte("forged")); err == nil {
+		t.Error("write to a held file: no error")
+	}
+	var list struct {
+		Holds []holds.Hold `json:"holds"`
+	}
+	rec = do("bob", http.MethodGet, "/api/v1/holds?path=/memfs", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); rec.Code != http.StatusOK || err != nil || len(list.Holds) != 1 || list.Holds[0].Reason != "litigation" {
+		t.Errorf("list = %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do("bob", http.MethodDelete, "/api/v1/holds?path=/memfs/contract.pdf", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("release by bob: status %d", rec.Code)
+	}
+	if rec := do("legal", http.MethodDelete, "/api/v1/holds?path=/memfs/contract.pdf", ""); rec.Code != http.StatusOK {
+		t.Errorf("release: status %d %s", rec.Code, rec.Body)
+	}
+	if err := mfs.Remove("/memfs/contract.pdf"); err != nil {
+		t.Errorf("remove after the release: %v", err)
+	}
+
+	// Without configured principals only admins place and release holds
+	admins, err := holds.New(config.HoldsConfig{File: filepath.Join(t.TempDir(), "admins.json")}, principalOf, func(ctx context.Context) bool {
+		name, _ := principalOf(ctx)
+		return name == "root"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetHolds(admins)
+	mfs.SetHolds(admins)
+	if _, err := mfs.Write("/memfs/minutes.txt", []byte("draft")); err != nil {
+		t.Fatal(err)
+	}
+	minutes := `{"path": "/memfs/minutes.txt", "legalHold": true}`
+	for _, principal := range []string{"bob", "legal", ""} {
+		if rec := do(principal, http.MethodPost, "/api/v1/holds", minutes); rec.Code != http.StatusForbidden {
+			t.Errorf("place by %q without principals: status %d", principal, rec.Code)
+		}
+	}
+	if rec := do("root", http.MethodPost, "/api/v1/holds", minutes); rec.Code != http.StatusOK {
+		t.Errorf("place by an admin: status %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("bob", http.MethodDelete, "/api/v1/holds?path=/memfs/minutes.txt", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("release by bob without principals: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/ingest"
+	log "github.com/sirupsen/logrus"
+)
+
+// IngestResponse represents the result of an ingest
+type IngestResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	ingest.Stats
+}
+
+// ingestOptions parses the batch_size, workers, defer_indexes, include and exclude query parameters
+func ingestOptions(r *http.Request) (ingest.Options, error) {
+	q := r.URL.Query()
+	var opts ingest.Options
+	ints := map[string]*int{"batch_size": &opts.BatchSize, "workers": &opts.Workers}
+	for key, dst := range ints {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return opts, filesystem.NewInvalidArgumentError(key, v, "expected an integer")
+			}
+			*dst = n
+		}
+	}
+	opts.DeferIndexes = q.Get("defer_indexes") == "true"
+	filter, err := archive.NewFilter(q.Get("include"), q.Get("exclude"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+	return opts, nil
+}
+
+// Ingest handles POST /ingest?path=<dest>&from=<dir>&format=<tar|tar.gz>
+// Optional: batch_size, workers, defer_indexes=true, include and exclude
+// Without from the request body is a tar stream. Files are written in batches when the
+// destination supports it (e.g. sqlfs), which is much faster than one request per file
+func (h *Handler) Ingest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dest := q.Get("path")
+	if dest == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	opts, err := ingestOptions(r)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	fs := h.requestFS(r)
+	var stats ingest.Stats
+	if from := q.Get("from"); from != "" {
+		stats, err = ingest.FromTree(fs, from, dest, opts)
+	} else {
+		format, ferr := archive.ParseFormat(q.Get("format"))
+		if ferr != nil {
+			writeError(w, mapErrorToStatus(ferr), ferr.Error())
+			return
+		}
+		stats, err = ingest.FromTar(r.Body, format, fs, dest, opts)
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "ingest failed after "+strconv.Itoa(stats.Files)+" files: "+err.Error())
+		return
+	}
+
+	log.Infof("Ingested %d files (%d bytes) into %s in %.1fs", stats.Files, stats.Bytes, dest, stats.Seconds)
+	writeJSON(w, http.StatusOK, IngestResponse{Message: "ingest finished", Path: dest, Stats: stats})
+}
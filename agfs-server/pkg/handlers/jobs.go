@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/migrate"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Job types accepted by POST /jobs
+const (
+	JobRemove    = "remove"    // Remove a tree
+	JobCopy      = "copy"      // Copy a tree to target
+	JobMigration = "migration" // Run a migration of path to target
+	JobScrub     = "scrub"     // Verify the checksums of the files under path
+)
+
+// migrationPollInterval is how often a migration job copies the progress of the migration
+const migrationPollInterval = 500 * time.Millisecond
+
+// JobRequest is the body of POST /jobs
+type JobRequest struct {
+	Type    string `json:"type"`              // One of remove, copy, migration and scrub
+	Path    string `json:"path"`              // Tree the job works on
+	Target  string `json:"target,omitempty"`  // Destination of copy and migration jobs
+	Rate    string `json:"rate,omitempty"`    // Bytes per second of migration jobs
+	Verify  *bool  `json:"verify,omitempty"`  // Whether migration jobs verify copied files
+	Webhook string `json:"webhook,omitempty"` // Notified when the job ends
+}
+
+// JobListResponse lists the jobs
+type JobListResponse struct {
+	Jobs []jobs.Status `json:"jobs"`
+}
+
+// JobManagerFromConfig creates the job manager configured by cfg
+func JobManagerFromConfig(cfg config.JobsConfig) (*jobs.Manager, error) {
+	opts := jobs.Options{Webhook: cfg.Webhook, Retain: cfg.Retain}
+	if cfg.Retain < 0 {
+		return nil, fmt.Errorf("jobs: invalid retain: %d", cfg.Retain)
+	}
+	if cfg.WebhookTimeout != "" {
+		d, err := pluginconfig.ParseDuration(cfg.WebhookTimeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("jobs: invalid webhook_timeout: %s", cfg.WebhookTimeout)
+		}
+		opts.WebhookTimeout = d
+	}
+	return jobs.NewManager(opts), nil
+}
+
+// SetJobManager sets the manager running the background jobs
+func (h *Handler) SetJobManager(m *jobs.Manager) {
+	h.jobs = m
+}
+
+// ListJobs handles GET /jobs
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, JobListResponse{Jobs: h.jobs.List()})
+}
+
+// GetJob handles GET /jobs/<id>
+func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request, id string) {
+	status, err := h.jobs.Get(id)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// CancelJob handles DELETE /jobs/<id>
+// It returns once the job stopped; ended jobs are returned unchanged
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request, id string) {
+	status, err := h.jobs.Cancel(id)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// StartJob handles POST /jobs with a JobRequest body
+// The request is validated before the job starts; its progress is reported by GET /jobs/<id>
+func (h *Handler) StartJob(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	h.startJob(r.Context(), w, req)
+}
+
+// startJob validates req, starts its job under the values of ctx and writes its status
+func (h *Handler) startJob(ctx context.Context, w http.ResponseWriter, req JobRequest) {
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	req.Path = filesystem.NormalizePath(req.Path)
+	if req.Target != "" {
+		req.Target = filesystem.NormalizePath(req.Target)
+	}
+
+	var fn jobs.Func
+	var err error
+	switch req.Type {
+	case JobRemove:
+		fn, err = h.removeJob(ctx, req)
+	case JobCopy:
+		fn, err = h.copyJob(ctx, req)
+	case JobMigration:
+		if h.migrations == nil {
+			writeError(w, http.StatusNotFound, "migrations are not enabled")
+			return
+		}
+		fn, err = h.migrationJob(ctx, req)
+	case JobScrub:
+		fn, err = h.scrubJob(ctx, req)
+	default:
+		err = filesystem.NewInvalidArgumentError("type", req.Type, "must be remove, copy, migration or scrub")
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	status := h.jobs.Start(jobs.Spec{Type: req.Type, Path: req.Path, Target: req.Target, Webhook: req.Webhook, Context: ctx}, fn)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// removeJob removes the tree of req.Path, deepest entries first so that progress is
+// reported per entry and a canceled job stops between them
+func (h *Handler) removeJob(ctx context.Context, req JobRequest) (jobs.Func, error) {
+	if req.Path == "/" {
+		return nil, filesystem.NewInvalidArgumentError("path", req.Path, "cannot remove the root")
+	}
+	if _, err := filesystem.WithContext(h.fs, ctx).Stat(req.Path); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p *jobs.Progress) error {
+		fs := filesystem.WithContext(h.fs, ctx)
+		var paths []string
+		err := filesystem.Walk(fs, req.Path, func(path string, info *filesystem.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		p.Set(0, int64(len(paths)), 0)
+		for i := len(paths) - 1; i > 0; i-- {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := fs.Remove(paths[i]); err != nil {
+				// Plugins without real directories may have dropped it with its entries
+				if _, statErr := fs.Stat(paths[i]); statErr == nil {
+					return err
+				}
+			}
+			p.Add(1, 0)
+		}
+		// The root goes like in a synchronous recursive delete, e.g. for mount points
+		if err := fs.RemoveAll(req.Path); err != nil {
+			return err
+		}
+		p.Add(1, 0)
+		return nil
+	}, nil
+}
+
+// copyJob copies the tree of req.Path to req.Target
+func (h *Handler) copyJob(ctx context.Context, req JobRequest) (jobs.Func, error) {
+	if req.Target == "" {
+		return nil, filesystem.NewInvalidArgumentError("target", "", "required")
+	}
+	if req.Target == req.Path || strings.HasPrefix(req.Target, strings.TrimSuffix(req.Path, "/")+"/") {
+		return nil, filesystem.NewInvalidArgumentError("target", req.Target, "cannot be inside the source")
+	}
+	if _, err := filesystem.WithContext(h.fs, ctx).Stat(req.Path); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p *jobs.Progress) error {
+		fs := filesystem.WithContext(h.fs, ctx)
+		return filesystem.Walk(fs, req.Path, func(src string, info *filesystem.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			dst := path.Join(req.Target, strings.TrimPrefix(src, req.Path))
+			if info.IsDir {
+				return filesystem.MkdirAll(fs, dst, 0755)
+			}
+			if err := filesystem.MkdirAll(fs, path.Dir(dst), 0755); err != nil {
+				return err
+			}
+			n, err := filesystem.CopyFile(fs, src, fs, dst)
+			if err != nil {
+				return err
+			}
+			p.Add(1, n)
+			return nil
+		})
+	}, nil
+}
+
+// migrationJob starts a migration of req.Path to req.Target and follows it until it ends;
+// canceling the job cancels the migration, which keeps its checkpoint
+func (h *Handler) migrationJob(ctx context.Context, req JobRequest) (jobs.Func, error) {
+	// Migrations move the files with the server's own access, the principal must be allowed
+	// to write both trees
+	if h.acl != nil {
+		for _, p := range []string{req.Path, req.Target} {
+			if err := h.acl.Check(ctx, mountablefs.AccessWrite, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	status, err := h.migrations.Start(migrate.Request{Source: req.Path, Target: req.Target, Rate: req.Rate, Verify: req.Verify})
+	if err != nil {
+		return nil, err
+	}
+	id := status.ID
+	return func(ctx context.Context, p *jobs.Progress) error {
+		ticker := time.NewTicker(migrationPollInterval)
+		defer ticker.Stop()
+		for {
+			status, err := h.migrations.Get(id)
+			if err != nil {
+				return err
+			}
+			p.Set(status.Files, status.TotalFiles, status.Bytes)
+			p.SetResult(status)
+			switch status.State {
+			case migrate.StateDone:
+				return nil
+			case migrate.StateFailed:
+				return fmt.Errorf("migration %s: %s", id, status.Error)
+			case migrate.StateCanceled, migrate.StateInterrupted:
+				return context.Canceled
+			}
+			select {
+			case <-ctx.Done():
+				status, _ := h.migrations.Cancel(id)
+				p.SetResult(status)
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}, nil
+}
+
+// scrubJob verifies the checksums of the files under req.Path, which must be in a mount
+// with checksums
+func (h *Handler) scrubJob(ctx context.Context, req JobRequest) (jobs.Func, error) {
+	mfs, ok := h.fs.(*mountablefs.MountableFS)
+	if !ok {
+		return nil, filesystem.NewNotSupportedError("scrub", req.Path)
+	}
+	if _, err := filesystem.WithContext(h.fs, ctx).Stat(req.Path); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, p *jobs.Progress) error {
+		report, err := mfs.Scrub(ctx, req.Path, func(r mountablefs.ScrubReport) {
+			p.Set(scrubbed(r), 0, 0)
+		})
+		if err != nil {
+			return err
+		}
+		p.Set(scrubbed(report), 0, 0)
+		p.SetResult(report)
+		if report.Canceled {
+			return context.Canceled
+		}
+		if len(report.Corrupted) > 0 {
+			return fmt.Errorf("%d corrupted files", len(report.Corrupted))
+		}
+		return nil
+	}, nil
+}
+
+// scrubbed returns the number of files a scrub went through
+func scrubbed(r mountablefs.ScrubReport) int64 {
+	return int64(r.Checked + r.Missing + len(r.Corrupted))
+}
+
+// handleJobs routes /jobs and /jobs/<id>
+func (h *Handler) handleJobs(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/jobs"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.ListJobs(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		h.StartJob(w, r)
+	case id != "" && r.Method == http.MethodGet:
+		h.GetJob(w, r, id)
+	case id != "" && r.Method == http.MethodDelete:
+		h.CancelJob(w, r, id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
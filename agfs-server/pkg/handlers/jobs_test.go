@@ -0,0 +1,74 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+func TestJobs(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	for i := 0; i < 3; i++ {
+		srv.Seed(map[string]string{fmt.Sprintf("/memfs/tree/%d.txt", i): "data"})
+	}
+	do := func(method, url, body string) (int, jobs.Status) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+url, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status jobs.Status
+		json.NewDecoder(resp.Body).Decode(&status)
+		return resp.StatusCode, status
+	}
+	wait := func(id string) jobs.Status {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if _, status := do(http.MethodGet, "/api/v1/jobs/"+id, ""); status.State != jobs.StateRunning {
+				return status
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("job %s did not end", id)
+		return jobs.Status{}
+	}
+
+	code, status := do(http.MethodPost, "/api/v1/jobs", `{"type": "copy", "path": "/memfs/tree", "target": "/memfs/copy"}`)
+	if code != http.StatusAccepted || status.ID == "" {
+		t.Fatalf("start copy = %d %+v", code, status)
+	}
+	if status = wait(status.ID); status.State != jobs.StateDone || status.Done != 3 || status.Bytes != 12 {
+		t.Errorf("copy job: %+v", status)
+	}
+	srv.AssertFile("/memfs/copy/2.txt", "data")
+
+	code, status = do(http.MethodDelete, "/api/v1/files?path=/memfs/tree&recursive=true&async=true", "")
+	if code != http.StatusAccepted || status.Type != "remove" {
+		t.Fatalf("async delete = %d %+v", code, status)
+	}
+	if status = wait(status.ID); status.State != jobs.StateDone || status.Done != 4 {
+		t.Errorf("remove job: %+v", status)
+	}
+	srv.AssertNotExist("/memfs/tree")
+
+	for _, body := range []string{
+		`{"type": "copy", "path": "/memfs/copy", "target": "/memfs/copy/sub"}`,
+		`{"type": "defrag", "path": "/memfs"}`,
+		`{"type": "remove", "path": "/"}`,
+	} {
+		if code, _ := do(http.MethodPost, "/api/v1/jobs", body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, code)
+		}
+	}
+	if code, _ := do(http.MethodGet, "/api/v1/jobs/missing", ""); code != http.StatusNotFound {
+		t.Errorf("missing job: status %d", code)
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/lifecycle"
+)
+
+// LifecycleRunResponse lists the reports of a lifecycle run
+type LifecycleRunResponse struct {
+	Reports []lifecycle.Report `json:"reports"`
+}
+
+// LifecycleAuditResponse lists the actions of the audit log
+type LifecycleAuditResponse struct {
+	Actions []lifecycle.Action `json:"actions"`
+}
+
+// SetLifecycle sets the engine used by the lifecycle endpoints
+func (h *Handler) SetLifecycle(e *lifecycle.Engine) {
+	h.lifecycle = e
+}
+
+// LifecycleStatus handles GET /lifecycle
+func (h *Handler) LifecycleStatus(w http.ResponseWriter, r *http.Request) {
+	if h.lifecycle == nil {
+		writeJSON(w, http.StatusOK, lifecycle.Status{Rules: []lifecycle.RuleStatus{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, h.lifecycle.Status())
+}
+
+// RunLifecycle handles POST /lifecycle/run?rule=<name>&dry_run=true
+// Without a rule every configured rule is evaluated; the request returns once they finish.
+// With dry_run the reports list what would be moved or deleted without changing anything
+func (h *Handler) RunLifecycle(w http.ResponseWriter, r *http.Request) {
+	if h.lifecycle == nil {
+		writeError(w, http.StatusNotFound, "no lifecycle rules configured")
+		return
+	}
+
+	q := r.URL.Query()
+	reports, err := h.lifecycle.Run(q.Get("rule"), q.Get("dry_run") == "true")
+	if err != nil {
+		status := mapErrorToStatus(err)
+		if errors.Is(err, lifecycle.ErrRunning) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, "lifecycle run failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, LifecycleRunResponse{Reports: reports})
+}
+
+// LifecycleAudit handles GET /lifecycle/audit?limit=<n>
+// It returns the last n actions of the audit log (default 100, 0 for all), oldest first
+func (h *Handler) LifecycleAudit(w http.ResponseWriter, r *http.Request) {
+	if h.lifecycle == nil {
+		writeJSON(w, http.StatusOK, LifecycleAuditResponse{Actions: []lifecycle.Action{}})
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+	actions, err := h.lifecycle.ReadAudit(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, LifecycleAuditResponse{Actions: actions})
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/migrate"
+)
+
+// MigrationListResponse lists the migration jobs
+type MigrationListResponse struct {
+	Migrations []migrate.Status `json:"migrations"`
+}
+
+// SetMigrations sets the manager used by the migration endpoints
+func (h *Handler) SetMigrations(m *migrate.Manager) {
+	h.migrations = m
+}
+
+// migrationsEnabled writes an error if no manager is set
+func (h *Handler) migrationsEnabled(w http.ResponseWriter) bool {
+	if h.migrations == nil {
+		writeError(w, http.StatusNotFound, "migrations are not enabled")
+		return false
+	}
+	return true
+}
+
+// writeMigrationError maps migration errors to HTTP status codes
+func writeMigrationError(w http.ResponseWriter, err error) {
+	status := mapErrorToStatus(err)
+	if errors.Is(err, migrate.ErrRunning) {
+		status = http.StatusConflict
+	}
+	writeError(w, status, err.Error())
+}
+
+// MigrationStatus handles GET /migrations?id=<id>
+// Without an id every job is listed
+func (h *Handler) MigrationStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.migrationsEnabled(w) {
+		return
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		status, err := h.migrations.Get(id)
+		if err != nil {
+			writeMigrationError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	writeJSON(w, http.StatusOK, MigrationListResponse{Migrations: h.migrations.List()})
+}
+
+// StartMigration handles POST /migrations with a migrate.Request body
+// The job runs in the background; its progress is reported by GET /migrations?id=<id>
+func (h *Handler) StartMigration(w http.ResponseWriter, r *http.Request) {
+	if !h.migrationsEnabled(w) {
+		return
+	}
+	var req migrate.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	status, err := h.migrations.Start(req)
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// ResumeMigration handles POST /migrations/resume?id=<id>
+func (h *Handler) ResumeMigration(w http.ResponseWriter, r *http.Request) {
+	if !h.migrationsEnabled(w) {
+		return
+	}
+	status, err := h.migrations.Resume(r.URL.Query().Get("id"))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// CancelMigration handles POST /migrations/cancel?id=<id>
+// The job keeps its checkpoint and can be resumed
+func (h *Handler) CancelMigration(w http.ResponseWriter, r *http.Request) {
+	if !h.migrationsEnabled(w) {
+		return
+	}
+	status, err := h.migrations.Cancel(r.URL.Query().Get("id"))
+	if err != nil {
+		writeMigrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
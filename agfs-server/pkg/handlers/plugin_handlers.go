@@ -14,24 +14,45 @@ import (
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/loader"
 	log "github.com/sirupsen/logrus"
 )
 
+// MountTable mounts and unmounts plugins, implemented by MountableFS and by the
+// cluster, which shares the mounts with the other nodes
+type MountTable interface {
+	MountPlugin(fstype string, path string, config map[string]interface{}) error
+	Unmount(path string) error
+}
+
 // PluginHandler handles plugin management operations
 type PluginHandler struct {
-	mfs *mountablefs.MountableFS
+	mfs    *mountablefs.MountableFS
+	mounts MountTable
 }
 
 // NewPluginHandler creates a new plugin handler
 func NewPluginHandler(mfs *mountablefs.MountableFS) *PluginHandler {
-	return &PluginHandler{mfs: mfs}
+	return &PluginHandler{mfs: mfs, mounts: mfs}
+}
+
+// SetMountTable makes POST /mount and /unmount go through mounts instead of the local mount table
+func (ph *PluginHandler) SetMountTable(mounts MountTable) {
+	ph.mounts = mounts
 }
 
 // MountInfo represents information about a mounted plugin
 type MountInfo struct {
-	Path       string                 `json:"path"`
-	PluginName string                 `json:"pluginName"`
-	Config     map[string]interface{} `json:"config,omitempty"`
+	Path       string                   `json:"path"`
+	PluginName string                   `json:"pluginName"`
+	Config     map[string]interface{}   `json:"config,omitempty"`
+	Pool       *mountablefs.PoolStats   `json:"pool,omitempty"`      // Worker pool activity, if the mount has one
+	Crashes    int64                    `json:"crashes,omitempty"`   // Plugin panics recovered on the mount
+	Disabled   bool                     `json:"disabled,omitempty"`  // Set once the mount reached its crash limit
+	Endpoint   string                   `json:"endpoint,omitempty"`  // Base URL of the plugin's own HTTP endpoints, if it has any
+	Resources  *plugin.ResourceStats    `json:"resources,omitempty"` // Resource use of plugins running under limits
+	Shadow     *mountablefs.ShadowStats `json:"shadow,omitempty"`    // Writes mirrored to the shadow, if the mount has one
 }
 
 // ListMountsResponse represents the response for listing mounts
@@ -45,11 +66,27 @@ func (ph *PluginHandler) ListMounts(w http.ResponseWriter, r *http.Request) {
 
 	var mountInfos []MountInfo
 	for _, mount := range mounts {
-		mountInfos = append(mountInfos, MountInfo{
+		info := MountInfo{
 			Path:       mount.Path,
 			PluginName: mount.Plugin.Name(),
 			Config:     mount.Config,
-		})
+		}
+		if stats, ok := mount.PoolStats(); ok {
+			info.Pool = &stats
+		}
+		crashes := mount.CrashStats()
+		info.Crashes, info.Disabled = crashes.Crashes, crashes.Disabled
+		if _, ok := mount.Plugin.(plugin.HTTPHandlerProvider); ok {
+			info.Endpoint = pluginRoutePrefix + strings.TrimPrefix(mount.Path+"/", "/")
+		}
+		if reporter, ok := mount.Plugin.(plugin.ResourceReporter); ok {
+			resources := reporter.ResourceStats()
+			info.Resources = &resources
+		}
+		if stats, ok := mount.ShadowStats(); ok {
+			info.Shadow = &stats
+		}
+		mountInfos = append(mountInfos, info)
 	}
 
 	writeJSON(w, http.StatusOK, ListMountsResponse{Mounts: mountInfos})
@@ -73,7 +110,7 @@ func (ph *PluginHandler) Unmount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ph.mfs.Unmount(req.Path); err != nil {
+	if err := ph.mounts.Unmount(req.Path); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -86,6 +123,7 @@ type MountRequest struct {
 	FSType string                 `json:"fstype"`
 	Path   string                 `json:"path"`
 	Config map[string]interface{} `json:"config"`
+	DryRun bool                   `json:"dry_run,omitempty"` // Only validate the mount
 }
 
 // Mount handles POST /mount
@@ -106,28 +144,43 @@ func (ph *PluginHandler) Mount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ph.mfs.MountPlugin(req.FSType, req.Path, req.Config); err != nil {
-		// First check for typed errors
-		if errors.Is(err, filesystem.ErrAlreadyExists) {
-			writeError(w, http.StatusConflict, err.Error())
+	if req.DryRun {
+		if err := ph.mfs.ValidateMount(req.FSType, req.Path, req.Config); err != nil {
+			writeMountError(w, err)
 			return
 		}
+		writeJSON(w, http.StatusOK, SuccessResponse{Message: "mount is valid"})
+		return
+	}
 
-		// For backward compatibility, check string-based errors that aren't typed yet
-		errMsg := err.Error()
-		if strings.Contains(errMsg, "unknown filesystem type") || strings.Contains(errMsg, "unknown plugin") ||
-			strings.Contains(errMsg, "failed to validate") || strings.Contains(errMsg, "is required") ||
-			strings.Contains(errMsg, "invalid") || strings.Contains(errMsg, "unknown configuration parameter") {
-			writeError(w, http.StatusBadRequest, err.Error())
-		} else {
-			writeError(w, http.StatusInternalServerError, err.Error())
-		}
+	if err := ph.mounts.MountPlugin(req.FSType, req.Path, req.Config); err != nil {
+		writeMountError(w, err)
 		return
 	}
 
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "plugin mounted"})
 }
 
+// writeMountError answers a failed mount with 409 for a used path, 400 for an invalid
+// configuration and 500 otherwise
+func writeMountError(w http.ResponseWriter, err error) {
+	// First check for typed errors
+	if errors.Is(err, filesystem.ErrAlreadyExists) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	// For backward compatibility, check string-based errors that aren't typed yet
+	errMsg := err.Error()
+	if strings.Contains(errMsg, "unknown filesystem type") || strings.Contains(errMsg, "unknown plugin") ||
+		strings.Contains(errMsg, "failed to validate") || strings.Contains(errMsg, "is required") ||
+		strings.Contains(errMsg, "invalid") || strings.Contains(errMsg, "unknown configuration parameter") {
+		writeError(w, http.StatusBadRequest, err.Error())
+	} else {
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
 
 // LoadPluginRequest represents a request to load an external plugin
 type LoadPluginRequest struct {
@@ -245,6 +298,41 @@ func (ph *PluginHandler) readPluginFromAGFS(agfsPath string) (string, error) {
 	return tmpFile, nil
 }
 
+// fetchSignature copies the signature of a plugin at a URL or AGFS path, <source>.sig, to
+// dst; a plugin without a signature leaves dst missing
+func (ph *PluginHandler) fetchSignature(source, dst string) error {
+	os.Remove(dst)
+
+	var data []byte
+	if isHTTPURL(source) {
+		resp, err := http.Get(source + loader.SignatureExt)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 64<<10)); err != nil {
+			return err
+		}
+	} else {
+		path := filesystem.NormalizePath(strings.TrimPrefix(source, "agfs://")) + loader.SignatureExt
+		var err error
+		data, err = ph.mfs.Read(path, 0, -1)
+		if filesystem.IsNotFound(err) {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
 // LoadPlugin handles POST /plugins/load
 func (ph *PluginHandler) LoadPlugin(w http.ResponseWriter, r *http.Request) {
 	var req LoadPluginRequest
@@ -283,6 +371,16 @@ func (ph *PluginHandler) LoadPlugin(w http.ResponseWriter, r *http.Request) {
 		log.Infof("Using plugin from AGFS temporary file: %s", libraryPath)
 	}
 
+	if tmpFile != "" {
+		// The signature is fetched from next to the plugin, so the loader finds it next to the copy
+		defer os.Remove(tmpFile + loader.SignatureExt)
+		if err := ph.fetchSignature(req.LibraryPath, tmpFile+loader.SignatureExt); err != nil {
+			os.Remove(tmpFile)
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to fetch plugin signature: %v", err))
+			return
+		}
+	}
+
 	plugin, err := ph.mfs.LoadExternalPlugin(libraryPath)
 	if err != nil {
 		// Clean up temporary file if it was downloaded
@@ -336,13 +434,120 @@ func (ph *PluginHandler) UnloadPlugin(w http.ResponseWriter, r *http.Request) {
 
 // ListPluginsResponse represents the response for listing plugins
 type ListPluginsResponse struct {
-	LoadedPlugins []string `json:"loaded_plugins"`
+	LoadedPlugins []string            `json:"loaded_plugins"`
+	Provenance    []loader.Provenance `json:"provenance"` // Checksum and signature of each loaded plugin file
 }
 
 // ListPlugins handles GET /plugins
 func (ph *PluginHandler) ListPlugins(w http.ResponseWriter, r *http.Request) {
 	plugins := ph.mfs.GetLoadedExternalPlugins()
-	writeJSON(w, http.StatusOK, ListPluginsResponse{LoadedPlugins: plugins})
+	writeJSON(w, http.StatusOK, ListPluginsResponse{
+		LoadedPlugins: plugins,
+		Provenance:    ph.mfs.GetPluginLoader().Provenance(),
+	})
+}
+
+// PluginTypeInfo describes a plugin that can be mounted
+type PluginTypeInfo struct {
+	Name      string                   `json:"name"`
+	HasSchema bool                     `json:"hasSchema"` // The plugin describes its configuration keys
+	Schema    []plugin.ConfigParameter `json:"schema"`
+}
+
+// ListPluginTypesResponse represents the response for listing plugin types
+type ListPluginTypesResponse struct {
+	Types []PluginTypeInfo `json:"types"`
+}
+
+// ListPluginTypes handles GET /plugin-types?name=<type>
+// Without a name every registered plugin, built in or loaded, is listed
+func (ph *PluginHandler) ListPluginTypes(w http.ResponseWriter, r *http.Request) {
+	names := ph.mfs.PluginTypes()
+	if name := r.URL.Query().Get("name"); name != "" {
+		if ph.mfs.CreatePlugin(name) == nil {
+			writeError(w, http.StatusNotFound, "unknown plugin type: "+name)
+			return
+		}
+		names = []string{name}
+	}
+
+	types := make([]PluginTypeInfo, 0, len(names))
+	for _, name := range names {
+		info := PluginTypeInfo{Name: name, Schema: []plugin.ConfigParameter{}}
+		if provider, ok := ph.mfs.CreatePlugin(name).(plugin.ConfigSchemaProvider); ok {
+			info.HasSchema = true
+			info.Schema = append(info.Schema, provider.ConfigSchema()...)
+		}
+		types = append(types, info)
+	}
+	writeJSON(w, http.StatusOK, ListPluginTypesResponse{Types: types})
+}
+
+// pluginRoutePrefix is the path below which plugins serve their own endpoints
+const pluginRoutePrefix = "/api/v1/plugins/"
+
+// ServePluginRoute handles /api/v1/plugins/<mount path>/... by passing the request, with the
+// prefix and mount path stripped, to the HTTP handler of the plugin mounted there
+func (ph *PluginHandler) ServePluginRoute(w http.ResponseWriter, r *http.Request) {
+	target := filesystem.NormalizePath(strings.TrimPrefix(r.URL.Path, pluginRoutePrefix))
+	mount, ok := ph.mfs.LookupMount(target)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no mount at "+target)
+		return
+	}
+	provider, ok := mount.Plugin.(plugin.HTTPHandlerProvider)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("plugin %s mounted at %s has no HTTP endpoints", mount.Plugin.Name(), mount.Path))
+		return
+	}
+	if mount.CrashStats().Disabled {
+		writeError(w, http.StatusServiceUnavailable, "mount "+mount.Path+" is disabled after repeated crashes")
+		return
+	}
+
+	// The endpoints work on the mount's files without going through the file system, so the
+	// request needs the access to the mount itself, and changes must not reach held paths
+	op := mountablefs.AccessWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		op = mountablefs.AccessRead
+	}
+	if checker, ok := filesystem.WithContext(ph.mfs, r.Context()).(mountablefs.AccessChecker); ok && !checker.Allowed(op, mount.Path) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("%s access to %s denied", op, mount.Path))
+		return
+	}
+	if op == mountablefs.AccessWrite {
+		if err := ph.mfs.CheckHoldTree(r.Method, mount.Path); err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+	}
+
+	rest := target
+	if mount.Path != "/" {
+		rest = strings.TrimPrefix(target, mount.Path)
+	}
+	if rest == "" || strings.HasSuffix(r.URL.Path, "/") && rest != "/" {
+		rest += "/"
+	}
+	req := r.Clone(r.Context())
+	req.URL.Path, req.URL.RawPath = rest, ""
+	provider.HTTPHandler().ServeHTTP(w, req)
+}
+
+// CompareShadow handles GET /shadow?path=<mount>: it compares the files of a mount with the
+// shadow receiving its writes and reports the differences with the mirroring counters
+func (ph *PluginHandler) CompareShadow(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	report, err := ph.mfs.CompareShadow(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
 }
 
 // SetupRoutes sets up plugin management routes with /api/v1 prefix
@@ -371,6 +576,22 @@ func (ph *PluginHandler) SetupRoutes(mux *http.ServeMux) {
 		ph.Unmount(w, r)
 	})
 
+	mux.HandleFunc("/api/v1/plugin-types", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		ph.ListPluginTypes(w, r)
+	})
+
+	mux.HandleFunc("/api/v1/shadow", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		ph.CompareShadow(w, r)
+	})
+
 	// External plugin management endpoints
 	mux.HandleFunc("/api/v1/plugins", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -395,4 +616,7 @@ func (ph *PluginHandler) SetupRoutes(mux *http.ServeMux) {
 		}
 		ph.UnloadPlugin(w, r)
 	})
+
+	// Endpoints of the mounted plugins
+	mux.HandleFunc(pluginRoutePrefix, ph.ServePluginRoute)
 }
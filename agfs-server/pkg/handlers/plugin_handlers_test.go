@@ -0,0 +1,47 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/s3fs"
+)
+
+func TestPluginTypesAndDryRun(t *testing.T) {
+	srv := pfstest.NewServer(t, pfstest.WithoutMemFS())
+	srv.FS.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	srv.FS.RegisterPluginFactory("s3fs", func() plugin.ServicePlugin { return s3fs.NewS3FSPlugin() })
+
+	resp, err := http.Get(srv.URL + "/api/v1/plugin-types?name=s3fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types handlers.ListPluginTypesResponse
+	err = json.NewDecoder(resp.Body).Decode(&types)
+	resp.Body.Close()
+	if err != nil || len(types.Types) != 1 || !types.Types[0].HasSchema || types.Types[0].Schema[0].Name != "bucket" {
+		t.Fatalf("plugin types = %+v, %v", types, err)
+	}
+
+	for body, want := range map[string]int{
+		`{"fstype": "memfs", "path": "/m", "dry_run": true}`:                             http.StatusOK,
+		`{"fstype": "s3fs", "path": "/s3", "dry_run": true}`:                             http.StatusBadRequest,
+		`{"fstype": "memfs", "path": "/m", "config": {"trash": "yes"}, "dry_run": true}`: http.StatusBadRequest,
+	} {
+		resp, err := http.Post(srv.URL+"/api/v1/mount", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+	srv.AssertNotExist("/m")
+}
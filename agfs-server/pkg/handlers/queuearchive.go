@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/queuearchive"
+)
+
+// QueueArchiveResponse lists the status of queue archivers
+type QueueArchiveResponse struct {
+	Archivers []queuearchive.Status `json:"archivers"`
+}
+
+// SetQueueArchive sets the manager used by the queue archive endpoints
+func (h *Handler) SetQueueArchive(m *queuearchive.Manager) {
+	h.archiver = m
+}
+
+// QueueArchiveStatus handles GET /queue-archive
+func (h *Handler) QueueArchiveStatus(w http.ResponseWriter, r *http.Request) {
+	if h.archiver == nil {
+		writeJSON(w, http.StatusOK, QueueArchiveResponse{Archivers: []queuearchive.Status{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, QueueArchiveResponse{Archivers: h.archiver.Status()})
+}
+
+// RunQueueArchive handles POST /queue-archive/run?name=<archiver>
+// Without a name every configured archiver drains its queue; the request returns once they finish
+func (h *Handler) RunQueueArchive(w http.ResponseWriter, r *http.Request) {
+	if h.archiver == nil {
+		writeError(w, http.StatusNotFound, "no queue archivers configured")
+		return
+	}
+
+	archivers, err := h.archiver.Run(r.URL.Query().Get("name"))
+	if err != nil {
+		status := mapErrorToStatus(err)
+		if errors.Is(err, queuearchive.ErrRunning) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, "queue archive failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, QueueArchiveResponse{Archivers: archivers})
+}
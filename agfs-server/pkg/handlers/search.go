@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+)
+
+// SearchResponse is the result of a search query
+type SearchResponse struct {
+	Query string       `json:"query"`
+	Hits  []search.Hit `json:"hits"`
+	Total int          `json:"total"`
+}
+
+// SetSearchIndexer sets the indexer used by the search endpoint
+func (h *Handler) SetSearchIndexer(x *search.Indexer) {
+	h.search = x
+}
+
+// Search handles GET /search?q=<query>&path=<path>&limit=<n>
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	if h.search == nil {
+		writeError(w, http.StatusNotFound, "search index is not enabled")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q parameter is required")
+		return
+	}
+
+	within := r.URL.Query().Get("path")
+	if within == "" {
+		within = "/"
+	}
+	within = filesystem.NormalizePath(within)
+
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
+
+	// The index holds every path, those the request may not read are left out
+	hits := []search.Hit{}
+	for _, hit := range h.search.Index().Search(query, within, 0) {
+		if h.readable(r, hit.Path) {
+			hits = append(hits, hit)
+		}
+		if limit > 0 && len(hits) == limit {
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, SearchResponse{Query: query, Hits: hits, Total: len(hits)})
+}
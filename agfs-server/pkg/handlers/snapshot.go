@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// SnapshotRequest represents a snapshot request
+type SnapshotRequest struct {
+	Path string `json:"path"` // Path to capture
+	Dest string `json:"dest"` // Optional destination path; if empty the snapshot is returned as a tar stream
+}
+
+// SnapshotResponse represents the result of a snapshot copy or restore
+type SnapshotResponse struct {
+	Message    string `json:"message"`
+	Path       string `json:"path"`
+	Dest       string `json:"dest,omitempty"`
+	Consistent bool   `json:"consistent"` // true if a plugin snapshot hook captured a point-in-time view
+	Files      int    `json:"files"`
+	Dirs       int    `json:"dirs"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// Snapshot handles POST /snapshot
+// With a dest, the snapshot is copied into that path (which may be on another mount);
+// without one, it is streamed back as a tar archive
+func (h *Handler) Snapshot(w http.ResponseWriter, r *http.Request) {
+	var req SnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	req.Path = filesystem.NormalizePath(req.Path)
+
+	fs := h.requestFS(r)
+	snapFS, root, consistent, err := filesystem.OpenSnapshot(fs, req.Path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "failed to snapshot: "+err.Error())
+		return
+	}
+	if !consistent {
+		log.Debugf("Snapshot hook not available for %s, walking live tree", req.Path)
+	}
+
+	if req.Dest != "" {
+		stats, err := filesystem.CopyTree(snapFS, root, fs, req.Dest)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), "failed to copy snapshot: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, SnapshotResponse{
+			Message:    "snapshot created",
+			Path:       req.Path,
+			Dest:       req.Dest,
+			Consistent: consistent,
+			Files:      stats.Files,
+			Dirs:       stats.Dirs,
+			Bytes:      stats.Bytes,
+		})
+		return
+	}
+
+	name := path.Base(req.Path)
+	if name == "/" {
+		name = "root"
+	}
+	filename := fmt.Sprintf("%s-%s.tar", name, time.Now().UTC().Format("20060102-150405"))
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("X-Snapshot-Consistent", fmt.Sprintf("%t", consistent))
+	w.WriteHeader(http.StatusOK)
+
+	stats, err := archive.WriteTar(w, snapFS, root)
+	if err != nil {
+		// Headers are already sent, the client sees a truncated archive
+		log.Errorf("Snapshot of %s failed after %d files: %v", req.Path, stats.Files, err)
+		return
+	}
+	log.Infof("Snapshot of %s streamed: %d files, %d bytes", req.Path, stats.Files, stats.Bytes)
+}
+
+// RestoreSnapshot handles POST /snapshot/restore?path=<dest>&source=<path>
+// With source, a snapshot previously copied into PFS is copied back to path;
+// otherwise the request body is a tar archive that is extracted into path
+func (h *Handler) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	dest := r.URL.Query().Get("path")
+	if dest == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	source := r.URL.Query().Get("source")
+
+	fs := h.requestFS(r)
+	var stats archive.Stats
+	var err error
+	if source != "" {
+		var copied filesystem.CopyStats
+		copied, err = filesystem.CopyTree(fs, source, fs, dest)
+		stats = archive.Stats{Files: copied.Files, Dirs: copied.Dirs, Bytes: copied.Bytes}
+	} else {
+		stats, err = archive.ExtractTar(r.Body, fs, dest)
+	}
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), "failed to restore snapshot: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SnapshotResponse{
+		Message: "snapshot restored",
+		Path:    dest,
+		Files:   stats.Files,
+		Dirs:    stats.Dirs,
+		Bytes:   stats.Bytes,
+	})
+}
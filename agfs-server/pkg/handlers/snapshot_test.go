@@ -0,0 +1,65 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Snapshots are copied into AGFS or streamed as tar, and restored from either
+func TestSnapshotRestore(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	tree := map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"}
+	for p, data := range tree {
+		srv.Seed(map[string]string{"/memfs/data/" + p: data})
+	}
+	post := func(url string, body io.Reader, v interface{}) (int, []byte) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+url, "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.Unmarshal(data, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, data
+	}
+
+	var snap handlers.SnapshotResponse
+	if status, body := post("/api/v1/snapshot", strings.NewReader(`{"path": "/memfs/data", "dest": "/memfs/snap"}`), &snap); status != http.StatusOK || snap.Files != 2 {
+		t.Fatalf("snapshot to a path = %d %s", status, body)
+	}
+	status, archive := post("/api/v1/snapshot", strings.NewReader(`{"path": "/memfs/data"}`), nil)
+	if status != http.StatusOK {
+		t.Fatalf("snapshot as tar = %d %s", status, archive)
+	}
+
+	// Changes after the snapshot are undone by restoring it
+	srv.Seed(map[string]string{"/memfs/data/a.txt": "changed"})
+	var restored handlers.SnapshotResponse
+	if status, body := post("/api/v1/snapshot/restore?path=/memfs/data&source=/memfs/snap", nil, &restored); status != http.StatusOK || restored.Files != 2 {
+		t.Fatalf("restore from a path = %d %s", status, body)
+	}
+	srv.AssertTree("/memfs/data", tree)
+	if status, body := post("/api/v1/snapshot/restore?path=/memfs/fromtar", bytes.NewReader(archive), &restored); status != http.StatusOK || restored.Files != 2 {
+		t.Fatalf("restore from tar = %d %s", status, body)
+	}
+	srv.AssertTree("/memfs/fromtar", tree)
+
+	if status, _ := post("/api/v1/snapshot", strings.NewReader(`{}`), nil); status != http.StatusBadRequest {
+		t.Errorf("snapshot without path: status %d", status)
+	}
+	if status, _ := post("/api/v1/snapshot/restore", nil, nil); status != http.StatusBadRequest {
+		t.Errorf("restore without path: status %d", status)
+	}
+}
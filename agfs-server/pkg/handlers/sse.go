@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+	log "github.com/sirupsen/logrus"
+)
+
+// Encodings of the data of stream events
+const (
+	SSEEncodingBase64 = "base64"
+	SSEEncodingText   = "text"
+)
+
+const (
+	// sseKeepAlive is how often an idle event stream sends a comment, so that proxies keep it open
+	sseKeepAlive = 15 * time.Second
+	// ssePoll bounds how long a read waits for a chunk before checking the client is still there
+	ssePoll = time.Second
+)
+
+// SetCORSOrigins sets the origins whose pages may read streams as server-sent events, "*"
+// for any; without origins only pages served by the API's own origin may
+func (h *Handler) SetCORSOrigins(origins []string) {
+	h.origins = origins
+}
+
+// allowOrigin lets the page of r's Origin read the response if its origin is allowed
+func (h *Handler) allowOrigin(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for _, o := range h.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			return
+		}
+	}
+}
+
+// streamSSE handles GET /files?path=<path>&stream=sse&encoding=<base64|text>
+// Each chunk of the stream is a message event with the chunk as data, base64-encoded by
+// default; with encoding=text it is UTF-8 text, the sequences split between chunks being
+// sent with the next one. The stream ends with an "eof" event, or a "stream-error" event
+// whose data is {"error": <message>}.
+func (h *Handler) streamSSE(w http.ResponseWriter, r *http.Request, path string) {
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = SSEEncodingBase64
+	}
+	if encoding != SSEEncodingBase64 && encoding != SSEEncodingText {
+		writeError(w, http.StatusBadRequest, "encoding must be base64 or text")
+		return
+	}
+	streamer, ok := h.requestFS(r).(filesystem.Streamer)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "streaming not supported for this filesystem")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported by the connection")
+		return
+	}
+	reader, err := streamer.OpenStream(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	defer reader.Close()
+
+	h.allowOrigin(w, r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	read, _ := h.limiters(r, path)
+	out := bufio.NewWriter(throttle.NewWriter(r.Context(), w, read...))
+	send := func(event, data string) bool {
+		if event != "" {
+			out.WriteString("event: " + event + "\n")
+		}
+		for _, line := range strings.Split(data, "\n") {
+			out.WriteString("data: " + line + "\n")
+		}
+		out.WriteString("\n")
+		if err := out.Flush(); err != nil {
+			log.Debugf("Error writing event: %v (this is normal if client disconnected)", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	var pending []byte // Incomplete UTF-8 sequence at the end of the last chunk of a text stream
+	idle := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		chunk, eof, err := reader.ReadChunk(ssePoll)
+		if err != nil && err != io.EOF {
+			if err.Error() == "read timeout" {
+				if time.Since(idle) >= sseKeepAlive {
+					if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+						return
+					}
+					flusher.Flush()
+					idle = time.Now()
+				}
+				continue
+			}
+			data, _ := json.Marshal(map[string]string{"error": err.Error()})
+			send("stream-error", string(data))
+			return
+		}
+		if len(chunk) > 0 {
+			var data string
+			if encoding == SSEEncodingText {
+				var text []byte
+				text, pending = splitUTF8(append(pending, chunk...))
+				if n := len(text); n > 0 && text[n-1] == '\r' {
+					// The LF of a CRLF may start the next chunk
+					pending = append([]byte{'\r'}, pending...)
+					text = text[:n-1]
+				}
+				data = normalizeNewlines(strings.ToValidUTF8(string(text), "\uFFFD"))
+			} else {
+				data = base64.StdEncoding.EncodeToString(chunk)
+			}
+			if data != "" && !send("", data) {
+				return
+			}
+			idle = time.Now()
+		}
+		if eof || err == io.EOF {
+			if len(pending) > 0 && !send("", normalizeNewlines(strings.ToValidUTF8(string(pending), "\uFFFD"))) {
+				return
+			}
+			send("eof", "")
+			return
+		}
+	}
+}
+
+// splitUTF8 splits b before a UTF-8 sequence cut short at its end, if any
+func splitUTF8(b []byte) (complete, rest []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], append([]byte(nil), b[i:]...)
+			}
+			break
+		}
+	}
+	return b, nil
+}
+
+// normalizeNewlines turns the CRLF and CR line endings of s into LF, the only one data
+// lines of events can carry
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
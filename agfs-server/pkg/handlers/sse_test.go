@@ -0,0 +1,70 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/streamfs"
+)
+
+// Streams are read as server-sent events, each chunk in base64 or as text
+func TestStreamSSE(t *testing.T) {
+	srv := pfstest.NewServer(t, pfstest.WithoutMemFS(), pfstest.WithPlugin("/streamfs", streamfs.NewStreamFSPlugin(), nil))
+
+	// "é" is split between the chunks
+	for _, chunk := range []string{"h\xc3", "\xa9llo\r\nworld"} {
+		if _, err := srv.FS.Write("/streamfs/logs", []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := func(encoding string, n int) []string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+			srv.URL+"/api/v1/files?path=/streamfs/logs&stream=sse&encoding="+encoding, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("%s: status %d, content type %q", encoding, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		var got, data []string
+		scanner := bufio.NewScanner(resp.Body)
+		for len(got) < n && scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			case line == "" && data != nil:
+				got = append(got, strings.Join(data, "\n"))
+				data = nil
+			}
+		}
+		return got
+	}
+
+	if got := events("base64", 2); len(got) != 2 || got[0] != "aMM=" || got[1] != "qWxsbw0Kd29ybGQ=" {
+		t.Errorf("base64 events = %q", got)
+	}
+	if got := events("text", 2); len(got) != 2 || got[0] != "h" || got[1] != "éllo\nworld" {
+		t.Errorf("text events = %q", got)
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/files?path=/streamfs/logs&stream=sse&encoding=hex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown encoding: status %d", resp.StatusCode)
+	}
+}
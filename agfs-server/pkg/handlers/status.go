@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// Mount states reported by GET /status
+const (
+	MountPending   = "pending"   // Being initialized
+	MountActive    = "active"    // Mounted
+	MountFailed    = "failed"    // Initialization or mounting failed
+	MountDisabled  = "disabled"  // Disabled in the configuration
+	MountUnmounted = "unmounted" // Mounted, then unmounted through the API
+)
+
+// MountState is the state of a plugin instance from the configuration file
+type MountState struct {
+	Path     string `json:"path"`
+	Plugin   string `json:"plugin"`
+	Instance string `json:"instance"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Listener is an address or endpoint the server accepts requests on
+type Listener struct {
+	Protocol string `json:"protocol"` // "http" or "mcp-sse"
+	Address  string `json:"address"`
+}
+
+// StartupReport records how the configured plugin instances were mounted and where the
+// server listens, for GET /status and the startup banner
+type StartupReport struct {
+	startedAt time.Time
+
+	mu        sync.Mutex
+	mounts    []*MountState
+	instances map[string]*MountState
+	listeners []Listener
+}
+
+// NewStartupReport creates a report of a server starting now
+func NewStartupReport() *StartupReport {
+	return &StartupReport{startedAt: time.Now(), instances: make(map[string]*MountState)}
+}
+
+// Configure adds a configured instance, pending if enabled
+func (r *StartupReport) Configure(plugin, instance, path string, enabled bool) {
+	state := MountDisabled
+	if enabled {
+		state = MountPending
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := &MountState{Path: path, Plugin: plugin, Instance: instance, State: state}
+	r.mounts = append(r.mounts, m)
+	r.instances[instance] = m
+}
+
+// Mounted records that an instance was mounted
+func (r *StartupReport) Mounted(instance string) {
+	r.set(instance, MountActive, nil)
+}
+
+// Failed records that an instance could not be mounted
+func (r *StartupReport) Failed(instance string, err error) {
+	r.set(instance, MountFailed, err)
+}
+
+func (r *StartupReport) set(instance, state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.instances[instance]; ok {
+		m.State = state
+		if err != nil {
+			m.Error = err.Error()
+		}
+	}
+}
+
+// AddListener records an address the server accepts requests on
+func (r *StartupReport) AddListener(protocol, address string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, Listener{Protocol: protocol, Address: address})
+}
+
+// StartedAt returns when the server started
+func (r *StartupReport) StartedAt() time.Time {
+	return r.startedAt
+}
+
+// Mounts returns the states of the configured instances, in configuration order
+func (r *StartupReport) Mounts() []MountState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mounts := make([]MountState, len(r.mounts))
+	for i, m := range r.mounts {
+		mounts[i] = *m
+	}
+	return mounts
+}
+
+// Listeners returns the recorded listeners
+func (r *StartupReport) Listeners() []Listener {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Listener(nil), r.listeners...)
+}
+
+// Wait waits up to timeout for the pending instances to be mounted or fail and reports
+// whether none is pending anymore
+func (r *StartupReport) Wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := 0
+		for _, m := range r.Mounts() {
+			if m.State == MountPending {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// ActiveMount is a mount currently served, configured or mounted through the API
+type ActiveMount struct {
+	Path     string `json:"path"`
+	Plugin   string `json:"plugin"`
+	Disabled bool   `json:"disabled,omitempty"` // Set once the mount reached its crash limit
+}
+
+// MountSummary counts the mounts by state
+type MountSummary struct {
+	Configured int `json:"configured"` // Enabled instances in the configuration
+	Active     int `json:"active"`     // All mounts currently served
+	Failed     int `json:"failed"`
+	Pending    int `json:"pending"`
+}
+
+// StatusResponse represents the server status
+type StatusResponse struct {
+	Status          string        `json:"status"`
+	Version         string        `json:"version"`
+	GitCommit       string        `json:"gitCommit"`
+	BuildTime       string        `json:"buildTime"`
+	StartedAt       time.Time     `json:"startedAt"`
+	UptimeSeconds   int64         `json:"uptimeSeconds"`
+	Summary         MountSummary  `json:"summary"`
+	Configured      []MountState  `json:"configuredMounts"`
+	Active          []ActiveMount `json:"activeMounts"`
+	ExternalPlugins []string      `json:"externalPlugins"`
+	Listeners       []Listener    `json:"listeners"`
+}
+
+// SetStartupReport sets the report served by GET /status
+func (h *Handler) SetStartupReport(r *StartupReport) {
+	h.startup = r
+}
+
+// Status handles GET /status
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	report := h.startup
+	if report == nil {
+		report = NewStartupReport()
+	}
+	resp := StatusResponse{
+		Status:          "healthy",
+		Version:         h.version,
+		GitCommit:       h.gitCommit,
+		BuildTime:       h.buildTime,
+		StartedAt:       report.StartedAt(),
+		UptimeSeconds:   int64(time.Since(report.StartedAt()).Seconds()),
+		Configured:      report.Mounts(),
+		Active:          []ActiveMount{},
+		ExternalPlugins: []string{},
+		Listeners:       report.Listeners(),
+	}
+
+	served := make(map[string]bool)
+	if mfs, ok := h.fs.(*mountablefs.MountableFS); ok {
+		for _, mount := range mfs.GetMounts() {
+			resp.Active = append(resp.Active, ActiveMount{Path: mount.Path, Plugin: mount.Plugin.Name(), Disabled: mount.CrashStats().Disabled})
+			served[mount.Path] = true
+		}
+		if plugins := mfs.GetLoadedExternalPlugins(); plugins != nil {
+			resp.ExternalPlugins = plugins
+		}
+	}
+	sort.Slice(resp.Active, func(i, j int) bool { return resp.Active[i].Path < resp.Active[j].Path })
+	sort.Strings(resp.ExternalPlugins)
+
+	resp.Summary.Active = len(resp.Active)
+	for i, m := range resp.Configured {
+		switch m.State {
+		case MountActive:
+			if !served[m.Path] {
+				resp.Configured[i].State = MountUnmounted
+			}
+		case MountFailed:
+			resp.Summary.Failed++
+		case MountPending:
+			resp.Summary.Pending++
+		}
+		if m.State != MountDisabled {
+			resp.Summary.Configured++
+		}
+	}
+	if resp.Summary.Failed > 0 {
+		resp.Status = "degraded"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,72 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// The status reports the configured instances by state, the served mounts and listeners
+func TestStatus(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	report := handlers.NewStartupReport()
+	report.Configure("memfs", "mem", "/memfs", true)
+	report.Configure("memfs", "gone", "/gone", true)
+	report.Configure("s3fs", "bucket", "/s3", true)
+	report.Configure("sqlfs", "db", "/db", true)
+	report.Configure("localfs", "off", "/local", false)
+	report.Mounted("mem")
+	report.Mounted("gone") // Unmounted since, it is not served
+	report.Failed("bucket", errors.New("no credentials"))
+	report.AddListener("http", "127.0.0.1:8080")
+	if report.Wait(0) {
+		t.Error("wait with a pending instance = true")
+	}
+
+	h := handlers.NewHandler(srv.FS)
+	h.SetVersionInfo("1.2.3", "abc", "today")
+	h.SetStartupReport(report)
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status", nil))
+	var status handlers.StatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("status = %d %s", rec.Code, rec.Body)
+	}
+	if status.Status != "degraded" || status.Version != "1.2.3" || len(status.Listeners) != 1 {
+		t.Errorf("status = %+v", status)
+	}
+	if want := (handlers.MountSummary{Configured: 4, Active: 1, Failed: 1, Pending: 1}); status.Summary != want {
+		t.Errorf("summary = %+v, want %+v", status.Summary, want)
+	}
+	states := make(map[string]string)
+	for _, m := range status.Configured {
+		states[m.Instance] = m.State
+	}
+	for instance, want := range map[string]string{
+		"mem":    handlers.MountActive,
+		"gone":   handlers.MountUnmounted,
+		"bucket": handlers.MountFailed,
+		"db":     handlers.MountPending,
+		"off":    handlers.MountDisabled,
+	} {
+		if states[instance] != want {
+			t.Errorf("%s state %q, want %q", instance, states[instance], want)
+		}
+	}
+	if len(status.Active) != 1 || status.Active[0].Path != "/memfs" {
+		t.Errorf("active mounts = %+v", status.Active)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d", rec.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+)
+
+// MetaKeyTags is the Stat metadata key listing the tags of a path, comma-separated
+const MetaKeyTags = "tags"
+
+// PathTagsResponse lists the tags of a path
+type PathTagsResponse struct {
+	Path string   `json:"path"`
+	Tags []string `json:"tags"`
+}
+
+// TaggedPathsResponse lists the paths carrying a tag
+type TaggedPathsResponse struct {
+	Tag   string   `json:"tag"`
+	Paths []string `json:"paths"`
+}
+
+// TagListResponse lists every tag in use
+type TagListResponse struct {
+	Tags []tags.TagCount `json:"tags"`
+}
+
+// SetTagStore sets the store used by the tags endpoints
+func (h *Handler) SetTagStore(s *tags.Store) {
+	h.tags = s
+}
+
+// GetTags handles GET /tags?path=<path> or GET /tags?tag=<tag>
+// Without parameters it lists every tag in use. Only the paths the request may read are
+// reported and counted.
+func (h *Handler) GetTags(w http.ResponseWriter, r *http.Request) {
+	if h.tags == nil {
+		writeError(w, http.StatusNotFound, "tagging is not enabled")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	tag := r.URL.Query().Get("tag")
+	switch {
+	case path != "":
+		if !h.readable(r, path) {
+			writeError(w, http.StatusForbidden, filesystem.NewPermissionDeniedError("tags", filesystem.NormalizePath(path), "").Error())
+			return
+		}
+		pathTags, err := h.tags.Tags(path)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, PathTagsResponse{Path: filesystem.NormalizePath(path), Tags: pathTags})
+	case tag != "":
+		paths, err := h.tags.Paths(tag)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, TaggedPathsResponse{Tag: tag, Paths: h.readablePaths(r, paths)})
+	default:
+		counts, err := h.tags.List()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if _, ok := h.requestFS(r).(mountablefs.AccessChecker); ok {
+			// Recount the paths of each tag the request may read
+			visible := counts[:0]
+			for _, c := range counts {
+				paths, err := h.tags.Paths(c.Tag)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, err.Error())
+					return
+				}
+				if c.Count = len(h.readablePaths(r, paths)); c.Count > 0 {
+					visible = append(visible, c)
+				}
+			}
+			counts = visible
+		}
+		writeJSON(w, http.StatusOK, TagListResponse{Tags: counts})
+	}
+}
+
+// AddTags handles POST /tags?path=<path>&tag=<tag1,tag2>
+func (h *Handler) AddTags(w http.ResponseWriter, r *http.Request) {
+	if h.tags == nil {
+		writeError(w, http.StatusNotFound, "tagging is not enabled")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	newTags, err := tags.ParseTags(r.URL.Query().Get("tag"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(newTags) == 0 {
+		writeError(w, http.StatusBadRequest, "tag parameter is required")
+		return
+	}
+
+	// Only existing paths can be tagged
+	if _, err := h.requestFS(r).Stat(path); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if err := h.tags.Add(path, newTags...); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	h.writePathTags(w, path)
+}
+
+// RemoveTags handles DELETE /tags?path=<path>&tag=<tag1,tag2>
+// Without a tag every tag of the path is removed
+func (h *Handler) RemoveTags(w http.ResponseWriter, r *http.Request) {
+	if h.tags == nil {
+		writeError(w, http.StatusNotFound, "tagging is not enabled")
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	oldTags, err := tags.ParseTags(r.URL.Query().Get("tag"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.readable(r, path) {
+		writeError(w, http.StatusForbidden, filesystem.NewPermissionDeniedError("tags", filesystem.NormalizePath(path), "").Error())
+		return
+	}
+	if err := h.tags.Remove(path, oldTags...); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+
+	h.writePathTags(w, path)
+}
+
+// readablePaths leaves out the paths the request may not read
+func (h *Handler) readablePaths(r *http.Request, paths []string) []string {
+	readable := paths[:0]
+	for _, p := range paths {
+		if h.readable(r, p) {
+			readable = append(readable, p)
+		}
+	}
+	return readable
+}
+
+func (h *Handler) writePathTags(w http.ResponseWriter, path string) {
+	pathTags, err := h.tags.Tags(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, PathTagsResponse{Path: filesystem.NormalizePath(path), Tags: pathTags})
+}
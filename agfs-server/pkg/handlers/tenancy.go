@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tenancy"
+	log "github.com/sirupsen/logrus"
+)
+
+// TenancyMiddleware authenticates requests against the tenancy manager
+// Tenant requests are served by a handler confined to the tenant's home directory,
+// which has no plugin management or server-wide endpoints; admin requests go to next
+// The probes /livez and /readyz go to next without authentication
+func TenancyMiddleware(m *tenancy.Manager, base *Handler, next http.Handler) http.Handler {
+	tenantMuxes := make(map[*tenancy.Tenant]*http.ServeMux, len(m.Tenants()))
+	for _, t := range m.Tenants() {
+		h := NewHandler(t.FileSystem())
+		h.SetVersionInfo(base.version, base.gitCommit, base.buildTime)
+		h.SetClientLimits(base.clients)
+		h.SetUploadStore(base.uploads.scoped(t.Name))
+		h.SetBudget(base.budget)
+		h.SetJobManager(base.jobs.Scoped())
+		mux := http.NewServeMux()
+		h.SetupRoutes(mux)
+		tenantMuxes[t] = mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/livez" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, err := m.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agfs"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if t == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := m.EnsureHome(t); err != nil {
+			log.Errorf("[tenancy] %v", err)
+			writeError(w, http.StatusServiceUnavailable, "home directory is not available")
+			return
+		}
+		log.Debugf("[tenancy] %s %s as %s", r.Method, r.URL.Path, t.Name)
+		tenantMuxes[t].ServeHTTP(w, r.WithContext(tenancy.WithTenant(r.Context(), t)))
+	})
+}
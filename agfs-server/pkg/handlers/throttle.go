@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tenancy"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// SetClientLimits enables per-client bandwidth limits on file reads and writes
+func (h *Handler) SetClientLimits(clients *throttle.ClientLimits) {
+	h.clients = clients
+}
+
+// clientKey identifies the client of r for per-client limits: the tenant if any, else the remote host
+func clientKey(r *http.Request) string {
+	if t := tenancy.FromContext(r.Context()); t != nil {
+		return "tenant:" + t.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limiters returns the limiters applying to reads and writes of path by the client of r
+func (h *Handler) limiters(r *http.Request, path string) (read, write []*throttle.Limiter) {
+	if pl, ok := h.fs.(throttle.PathLimiter); ok {
+		mountRead, mountWrite := pl.PathLimiters(path)
+		read = append(read, mountRead)
+		write = append(write, mountWrite)
+	}
+	clientRead, clientWrite := h.clients.Limiters(clientKey(r))
+	read = append(read, clientRead)
+	write = append(write, clientWrite)
+	return read, write
+}
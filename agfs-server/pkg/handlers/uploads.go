@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the upload staging area
+const (
+	DefaultUploadTTL     = 24 * time.Hour // Uploads without a chunk for this long are removed
+	DefaultMaxUploadSize = 1 << 30        // Size a staged upload may grow to
+	MaxUploads           = 1024           // Uploads staged at the same time per client scope
+	uploadSweepInterval  = time.Minute
+)
+
+// errUploadOffset is returned when a chunk does not start where the staged data ends
+var errUploadOffset = errors.New("chunk offset does not match the uploaded size")
+
+// UploadStatus describes a staged upload
+type UploadStatus struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`            // Where the upload is materialized on commit
+	Size      int64     `json:"size"`            // Bytes received, the offset of the next chunk
+	Total     int64     `json:"total,omitempty"` // Announced size, required on commit if set
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CommitResponse is the response of POST /uploads/<id>/commit
+type CommitResponse struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+// uploadMeta is the part of an upload's status stored next to its data
+type uploadMeta struct {
+	Path      string    `json:"path"`
+	Total     int64     `json:"total,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UploadStore stages uploads in a local directory until they are committed
+// Each upload is kept as <id>.json and <id>.data; uploads expire TTL after their last chunk
+// and are removed by a sweep running at most once a minute, also after a restart
+type UploadStore struct {
+	dir     string
+	ttl     time.Duration
+	maxSize int64
+
+	locks filesystem.PathLocks // Serializes the chunks and the commit of an upload
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewUploadStore creates a store staging uploads in dir; the directory is created on the
+// first upload
+func NewUploadStore(dir string, ttl time.Duration, maxSize int64) *UploadStore {
+	return &UploadStore{dir: dir, ttl: ttl, maxSize: maxSize}
+}
+
+// UploadStoreFromConfig creates the staging area of cfg, with defaults for unset keys
+func UploadStoreFromConfig(cfg config.UploadsConfig) (*UploadStore, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "agfs-uploads")
+	}
+	ttl := DefaultUploadTTL
+	if cfg.TTL != "" {
+		var err error
+		if ttl, err = pluginconfig.ParseDuration(cfg.TTL); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("uploads: invalid ttl: %s", cfg.TTL)
+		}
+	}
+	maxSize := int64(DefaultMaxUploadSize)
+	if cfg.MaxSize != "" {
+		var err error
+		if maxSize, err = pluginconfig.ParseSize(cfg.MaxSize); err != nil || maxSize <= 0 {
+			return nil, fmt.Errorf("uploads: invalid max_size: %s", cfg.MaxSize)
+		}
+	}
+	return NewUploadStore(dir, ttl, maxSize), nil
+}
+
+// scoped returns a store of the same settings in a subdirectory, keeping the uploads of
+// different tenants apart
+func (s *UploadStore) scoped(name string) *UploadStore {
+	return NewUploadStore(filepath.Join(s.dir, "tenants", name), s.ttl, s.maxSize)
+}
+
+// SetUploadStore sets the staging area of /uploads
+func (h *Handler) SetUploadStore(s *UploadStore) {
+	h.uploads = s
+}
+
+func (s *UploadStore) metaPath(id string) string { return filepath.Join(s.dir, id+".json") }
+func (s *UploadStore) dataPath(id string) string { return filepath.Join(s.dir, id+".data") }
+
+// validUploadID checks that id is a hex upload ID, so it cannot name other files
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// create stages an empty upload for path
+func (s *UploadStore) create(path string, total int64) (*UploadStatus, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	if matches, _ := filepath.Glob(filepath.Join(s.dir, "*.json")); len(matches) >= MaxUploads {
+		return nil, filesystem.NewUnavailableError("upload", path, "too many staged uploads")
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(raw)
+	if err := os.WriteFile(s.dataPath(id), nil, 0600); err != nil {
+		return nil, err
+	}
+	meta, _ := json.Marshal(uploadMeta{Path: path, Total: total, CreatedAt: time.Now().UTC()})
+	if err := os.WriteFile(s.metaPath(id), meta, 0600); err != nil {
+		os.Remove(s.dataPath(id))
+		return nil, err
+	}
+	return s.status(id)
+}
+
+// status returns the status of upload id; expired uploads are not found
+func (s *UploadStore) status(id string) (*UploadStatus, error) {
+	s.sweep()
+	if !validUploadID(id) {
+		return nil, filesystem.NewNotFoundError("upload", id)
+	}
+	raw, err := os.ReadFile(s.metaPath(id))
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("upload", id)
+	}
+	var meta uploadMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("upload", id)
+	}
+	st := &UploadStatus{
+		ID:        id,
+		Path:      meta.Path,
+		Size:      info.Size(),
+		Total:     meta.Total,
+		CreatedAt: meta.CreatedAt,
+		ExpiresAt: info.ModTime().Add(s.ttl).UTC(),
+	}
+	if time.Now().After(st.ExpiresAt) {
+		s.remove(id)
+		return nil, filesystem.NewNotFoundError("upload", id)
+	}
+	return st, nil
+}
+
+// write appends body to upload id, which must have received offset bytes so far
+// Bytes received before a failure are kept, the client resumes at the new size
+func (s *UploadStore) write(id string, offset int64, body io.Reader) (*UploadStatus, error) {
+	defer s.locks.Lock(id)()
+	st, err := s.status(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != st.Size {
+		return st, errUploadOffset
+	}
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	n, copyErr := io.Copy(f, io.LimitReader(body, s.maxSize-st.Size+1))
+	if st.Size+n > s.maxSize {
+		f.Truncate(st.Size)
+		copyErr = filesystem.NewInvalidArgumentError("size", st.Size+n, fmt.Sprintf("upload exceeds %d bytes", s.maxSize))
+	}
+	if err := f.Close(); copyErr == nil {
+		copyErr = err
+	}
+	if copyErr != nil {
+		return nil, copyErr
+	}
+	return s.status(id)
+}
+
+// remove deletes upload id
+func (s *UploadStore) remove(id string) {
+	os.Remove(s.metaPath(id))
+	os.Remove(s.dataPath(id))
+}
+
+// sweep removes the expired uploads, at most once per uploadSweepInterval
+func (s *UploadStore) sweep() {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < uploadSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	for _, m := range matches {
+		id := strings.TrimSuffix(filepath.Base(m), ".json")
+		info, err := os.Stat(s.dataPath(id))
+		if err != nil || time.Since(info.ModTime()) > s.ttl {
+			log.Debugf("[uploads] removing expired upload %s", id)
+			s.remove(id)
+		}
+	}
+}
+
+// CreateUpload handles PUT /uploads?path=<path>&total=<size>
+// It stages a new upload of path with the request body as its first chunk
+func (h *Handler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	path = filesystem.NormalizePath(path)
+	var total int64
+	if v := r.URL.Query().Get("total"); v != "" {
+		var err error
+		if total, err = strconv.ParseInt(v, 10, 64); err != nil || total < 0 {
+			writeError(w, http.StatusBadRequest, "invalid total: "+v)
+			return
+		}
+		if total > h.uploads.maxSize {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("upload exceeds %d bytes", h.uploads.maxSize))
+			return
+		}
+	}
+	st, err := h.uploads.create(path, total)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	h.writeChunk(w, r, st, 0, http.StatusCreated)
+}
+
+// writeChunk stages the request body at offset of upload st and answers with its status
+func (h *Handler) writeChunk(w http.ResponseWriter, r *http.Request, st *UploadStatus, offset int64, status int) {
+	_, write := h.limiters(r, st.Path)
+	st, err := h.uploads.write(st.ID, offset, throttle.NewReader(r.Context(), r.Body, write...))
+	switch {
+	case errors.Is(err, errUploadOffset):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(st.Size, 10))
+		writeError(w, http.StatusConflict, fmt.Sprintf("%v: expected offset %d", err, st.Size))
+	case errors.Is(err, filesystem.ErrInvalidArgument):
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case err != nil:
+		writeError(w, mapErrorToStatus(err), err.Error())
+	default:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(st.Size, 10))
+		writeJSON(w, status, st)
+	}
+}
+
+// Upload handles /uploads/<id>: GET returns the status, PUT ?offset=<n> stages a chunk and
+// DELETE aborts the upload; POST /uploads/<id>/commit materializes it
+func (h *Handler) Upload(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/uploads/")
+	id, action, _ := strings.Cut(rest, "/")
+	switch {
+	case action == "commit" && r.Method == http.MethodPost:
+		h.CommitUpload(w, r, id)
+		return
+	case action != "":
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	st, err := h.uploads.status(id)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(st.Size, 10))
+		writeJSON(w, http.StatusOK, st)
+	case http.MethodPut:
+		offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "offset parameter is required")
+			return
+		}
+		h.writeChunk(w, r, st, offset, http.StatusOK)
+	case http.MethodDelete:
+		unlock := h.uploads.locks.Lock(id)
+		h.uploads.remove(id)
+		unlock()
+		writeJSON(w, http.StatusOK, SuccessResponse{Message: "upload aborted"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// CommitUpload handles POST /uploads/<id>/commit
+// The staged data is written to its path with a single write, so readers see the old
+// content or the whole new one on backends whose writes replace files at once, such as
+// s3fs and sqlfs. If-Match and If-None-Match apply as for PUT /files. The upload is removed
+// once committed, and kept if the write fails
+func (h *Handler) CommitUpload(w http.ResponseWriter, r *http.Request, id string) {
+	defer h.uploads.locks.Lock(id)()
+	st, err := h.uploads.status(id)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	if st.Total > 0 && st.Size != st.Total {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(st.Size, 10))
+		writeError(w, http.StatusConflict, fmt.Sprintf("upload incomplete: %d of %d bytes received", st.Size, st.Total))
+		return
+	}
+	data, err := os.ReadFile(h.uploads.dataPath(id))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	fs := h.requestFS(r)
+	if conditionalWrite(r) {
+		defer h.pathLocks.Lock(st.Path)()
+		if !h.checkWritePreconditions(w, r, fs, st.Path) {
+			return
+		}
+	}
+	if _, err := fs.Write(st.Path, data); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	h.uploads.remove(id)
+	etag := fileETag(data)
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, CommitResponse{Path: st.Path, Size: int64(len(data)), ETag: etag})
+}
@@ -0,0 +1,68 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+func TestUploads(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	do := func(method, url, body string, v interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+url, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		} else {
+			io.Copy(io.Discard, resp.Body)
+		}
+		return resp.StatusCode
+	}
+
+	var st handlers.UploadStatus
+	if code := do("PUT", "/api/v1/uploads?path=/memfs/big&total=11", "hello", &st); code != http.StatusCreated || st.Size != 5 {
+		t.Fatalf("create = %d %+v", code, st)
+	}
+	base := "/api/v1/uploads/" + st.ID
+
+	if code := do("POST", base+"/commit", "", nil); code != http.StatusConflict {
+		t.Errorf("commit of incomplete upload: status %d", code)
+	}
+	if code := do("PUT", base+"?offset=3", "xx", nil); code != http.StatusConflict {
+		t.Errorf("chunk at wrong offset: status %d", code)
+	}
+	if code := do("PUT", base+"?offset=5", " world", &st); code != http.StatusOK || st.Size != 11 {
+		t.Fatalf("chunk = %d %+v", code, st)
+	}
+	if _, err := srv.FS.Stat("/memfs/big"); err == nil {
+		t.Fatal("upload visible before commit")
+	}
+
+	var commit handlers.CommitResponse
+	if code := do("POST", base+"/commit", "", &commit); code != http.StatusOK || commit.Size != 11 {
+		t.Fatalf("commit = %d %+v", code, commit)
+	}
+	srv.AssertFile("/memfs/big", "hello world")
+	if code := do("GET", base, "", nil); code != http.StatusNotFound {
+		t.Errorf("committed upload still staged: status %d", code)
+	}
+
+	// Aborted uploads are gone
+	do("PUT", "/api/v1/uploads?path=/memfs/other", "x", &st)
+	if code := do("DELETE", "/api/v1/uploads/"+st.ID, "", nil); code != http.StatusOK {
+		t.Errorf("abort: status %d", code)
+	}
+	if code := do("GET", "/api/v1/uploads/"+st.ID, "", nil); code != http.StatusNotFound {
+		t.Errorf("aborted upload: status %d", code)
+	}
+}
@@ -0,0 +1,576 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bufpool"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// APIVersionHeader is set on every /api/v2 response
+const APIVersionHeader = "X-AGFS-API-Version"
+
+// RequireHeader lists capabilities a client depends on, comma separated; requests
+// requiring a capability the server does not have fail with 412 and code
+// "unsupported_capability" instead of behaving differently than expected
+const RequireHeader = "X-AGFS-Require"
+
+// Capabilities of /api/v2
+const (
+	CapabilityHandles   = "handles"    // Open handles with reads and writes at offsets
+	CapabilityStreaming = "streaming"  // Files are read and written as streams
+	CapabilityFollow    = "follow"     // Streams of streaming plugins can be followed
+	CapabilityRangeRead = "range-read" // Reads of a part of a file
+	CapabilityErrorCode = "error-codes"
+)
+
+var capabilities = []string{CapabilityHandles, CapabilityStreaming, CapabilityFollow, CapabilityRangeRead, CapabilityErrorCode}
+
+// Error codes of /api/v2, in addition to the codes of errorCode
+const (
+	CodeBadRequest            = "bad_request"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeInvalidHandle         = "invalid_handle"
+	CodeUnsupportedCapability = "unsupported_capability"
+)
+
+// ErrorV2 is the error returned by /api/v2
+type ErrorV2 struct {
+	Code    string `json:"code"` // Stable identifier of the error, e.g. "not_found"
+	Message string `json:"message"`
+}
+
+// ErrorResponseV2 represents an error response of /api/v2
+type ErrorResponseV2 struct {
+	Error ErrorV2 `json:"error"`
+}
+
+// CapabilitiesResponse represents the response of GET /api/v2/capabilities
+type CapabilitiesResponse struct {
+	Versions     []int        `json:"versions"` // API versions served
+	Capabilities []string     `json:"capabilities"`
+	Limits       HandleLimits `json:"limits"`
+}
+
+// HandleLimits describes the limits of open handles
+type HandleLimits struct {
+	MaxOpenHandles         int   `json:"maxOpenHandles"`
+	MaxHandlesPerOwner     int   `json:"maxHandlesPerOwner"`
+	HandleIdleTimeout      int64 `json:"handleIdleTimeoutSeconds"`
+	MaxHandleWriteSize     int64 `json:"maxHandleWriteSize"`
+	MaxHandleBufferedBytes int64 `json:"maxHandleBufferedBytes"`
+}
+
+// OpenHandleResponse represents the response of POST /api/v2/handles
+type OpenHandleResponse struct {
+	Handle string           `json:"handle"`
+	Path   string           `json:"path"`
+	Mode   string           `json:"mode"`
+	Info   FileInfoResponse `json:"info"`
+}
+
+// WriteResponseV2 represents the response of a write to a file or handle
+type WriteResponseV2 struct {
+	Written int64 `json:"written"`
+	Size    int64 `json:"size"` // Size of the file after the write, including buffered writes of a handle
+}
+
+// errorCode returns the stable error code of a filesystem error
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, filesystem.ErrNotFound):
+		return "not_found"
+	case errors.Is(err, filesystem.ErrPermissionDenied):
+		return "permission_denied"
+	case errors.Is(err, filesystem.ErrInvalidArgument):
+		return "invalid_argument"
+	case errors.Is(err, filesystem.ErrAlreadyExists):
+		return "already_exists"
+	case errors.Is(err, filesystem.ErrNotDirectory):
+		return "not_directory"
+	case errors.Is(err, filesystem.ErrNotSupported):
+		return "not_supported"
+	case errors.Is(err, filesystem.ErrIntegrity):
+		return "integrity"
+	case errors.Is(err, filesystem.ErrQuotaExceeded):
+		return "quota_exceeded"
+	case errors.Is(err, filesystem.ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, filesystem.ErrTimeout):
+		return "timeout"
+	}
+	return "internal"
+}
+
+func writeErrorV2(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponseV2{Error: ErrorV2{Code: code, Message: message}})
+}
+
+// writeFSErrorV2 writes a filesystem error with its status and code
+func writeFSErrorV2(w http.ResponseWriter, err error) {
+	status := mapErrorToStatus(err)
+	if errors.Is(err, filesystem.ErrNotDirectory) || errors.Is(err, filesystem.ErrIntegrity) {
+		status = http.StatusConflict
+	}
+	writeErrorV2(w, status, errorCode(err), err.Error())
+}
+
+// v2 wraps a /api/v2 handler with the version header and capability negotiation
+func v2(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(APIVersionHeader, "2")
+		if required := r.Header.Get(RequireHeader); required != "" {
+			for _, c := range strings.Split(required, ",") {
+				if c = strings.TrimSpace(c); c != "" && !hasCapability(c) {
+					writeErrorV2(w, http.StatusPreconditionFailed, CodeUnsupportedCapability, "unsupported capability: "+c)
+					return
+				}
+			}
+		}
+		next(w, r)
+	}
+}
+
+func hasCapability(c string) bool {
+	for _, have := range capabilities {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+func fileInfoResponse(info *filesystem.FileInfo) FileInfoResponse {
+	return FileInfoResponse{
+		Name:    info.Name,
+		Size:    info.Size,
+		Mode:    info.Mode,
+		ModTime: info.ModTime.Format(time.RFC3339Nano),
+		IsDir:   info.IsDir,
+		Meta:    info.Meta,
+	}
+}
+
+// queryInt64 parses the query parameter name, def if it is not set
+func queryInt64(r *http.Request, name string, def int64) (int64, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return v, nil
+}
+
+// Capabilities handles GET /api/v2/capabilities
+func (h *Handler) Capabilities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, CapabilitiesResponse{
+		Versions:     []int{1, 2},
+		Capabilities: capabilities,
+		Limits: HandleLimits{
+			MaxOpenHandles:         MaxOpenHandles,
+			MaxHandlesPerOwner:     MaxHandlesPerOwner,
+			HandleIdleTimeout:      int64(HandleIdleTimeout / time.Second),
+			MaxHandleWriteSize:     MaxHandleWriteSize,
+			MaxHandleBufferedBytes: MaxHandleBufferedBytes,
+		},
+	})
+}
+
+// ReadFileV2 handles GET /api/v2/files?path=<path>&offset=<offset>&size=<size>&follow=<true|false>
+// The file is streamed unless offset or size select a part of it
+func (h *Handler) ReadFileV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+	if r.URL.Query().Get("follow") == "true" {
+		h.streamFile(w, r, path)
+		return
+	}
+
+	read, _ := h.limiters(r, path)
+	out := throttle.NewWriter(r.Context(), w, read...)
+	q := r.URL.Query()
+	if q.Has("offset") || q.Has("size") {
+		offset, err := queryInt64(r, "offset", 0)
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		size, err := queryInt64(r, "size", -1)
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		data, err := h.requestFS(r).Read(path, offset, size)
+		if err != nil && err != io.EOF {
+			writeFSErrorV2(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		out.Write(data)
+		return
+	}
+
+	// Readers are served by the file system itself, the request context would not
+	// outlive them anyway; the request's view only checks access
+	reader, err := h.requestFS(r).Open(path)
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	defer reader.Close()
+
+	buf := bufpool.Get(32 * 1024)
+	defer bufpool.Put(buf)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	// Errors after the header was sent can only be reported by cutting the response short
+	io.CopyBuffer(out, reader, buf)
+}
+
+// WriteFileV2 handles PUT /api/v2/files?path=<path>
+// The request body is streamed into the file
+func (h *Handler) WriteFileV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+
+	writer, err := h.requestFS(r).OpenWrite(path)
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	_, write := h.limiters(r, path)
+	buf := bufpool.Get(32 * 1024)
+	defer bufpool.Put(buf)
+	n, err := io.CopyBuffer(writer, throttle.NewReader(r.Context(), r.Body, write...), buf)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, WriteResponseV2{Written: n, Size: n})
+}
+
+// DeleteV2 handles DELETE /api/v2/files?path=<path>&recursive=<true|false>
+func (h *Handler) DeleteV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+	fs := h.requestFS(r)
+	var err error
+	if r.URL.Query().Get("recursive") == "true" {
+		err = fs.RemoveAll(path)
+	} else {
+		err = fs.Remove(path)
+	}
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StatV2 handles GET /api/v2/stat?path=<path>
+func (h *Handler) StatV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+	info, err := h.requestFS(r).Stat(path)
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, fileInfoResponse(info))
+}
+
+// ListDirectoryV2 handles GET /api/v2/directories?path=<path>&fields=<fields>&sort=<key>&offset=<n>&limit=<n>,
+// see ListDirectory
+func (h *Handler) ListDirectoryV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+	fields, err := filesystem.ParseListFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	page, err := parseListPage(r)
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	files, next, err := listDirectory(h.requestFS(r), path, fields, page)
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	if !fields.All() {
+		response := partialListResponse(files, fields)
+		response.Next = next
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	response := ListResponse{Files: []FileInfoResponse{}, Next: next}
+	for i := range files {
+		response.Files = append(response.Files, fileInfoResponse(&files[i]))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// CreateDirectoryV2 handles POST /api/v2/directories?path=<path>&mode=<mode>
+func (h *Handler) CreateDirectoryV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+	mode := uint32(0755)
+	if modeStr := r.URL.Query().Get("mode"); modeStr != "" {
+		m, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil {
+			writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "invalid mode")
+			return
+		}
+		mode = uint32(m)
+	}
+	if err := h.requestFS(r).Mkdir(path, mode); err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RenameV2 handles POST /api/v2/rename?path=<path> with a RenameRequest body
+func (h *Handler) RenameV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || path == "" || req.NewPath == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter and newPath are required")
+		return
+	}
+	if err := h.requestFS(r).Rename(path, req.NewPath); err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ChmodV2 handles POST /api/v2/chmod?path=<path> with a ChmodRequest body
+func (h *Handler) ChmodV2(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	var req ChmodRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter and mode are required")
+		return
+	}
+	if err := h.requestFS(r).Chmod(path, req.Mode); err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OpenHandle handles POST /api/v2/handles?path=<path>&mode=<r|w|rw>&create=<true|false>&truncate=<true|false>
+func (h *Handler) OpenHandle(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "path parameter is required")
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = HandleModeRead
+	}
+	oh, info, err := h.handles.open(h.requestFS(r), h.handleOwner(r), filesystem.NormalizePath(path), mode,
+		r.URL.Query().Get("create") == "true", r.URL.Query().Get("truncate") == "true")
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	resp := OpenHandleResponse{Handle: oh.id, Path: oh.path, Mode: oh.mode, Info: fileInfoResponse(info)}
+	if oh.writable() {
+		resp.Info.Size = int64(len(oh.data))
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// ReadHandle handles GET /api/v2/handles/<id>?offset=<offset>&size=<size>
+func (h *Handler) ReadHandle(w http.ResponseWriter, r *http.Request, oh *openHandle) {
+	offset, err := queryInt64(r, "offset", 0)
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	size, err := queryInt64(r, "size", -1)
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	data, err := oh.readAt(h.requestFS(r), offset, size)
+	if err != nil && err != io.EOF {
+		writeFSErrorV2(w, err)
+		return
+	}
+	read, _ := h.limiters(r, oh.path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if err == io.EOF {
+		w.Header().Set("X-AGFS-EOF", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+	throttle.NewWriter(r.Context(), w, read...).Write(data)
+}
+
+// WriteHandle handles PUT /api/v2/handles/<id>?offset=<offset>, appending without offset
+func (h *Handler) WriteHandle(w http.ResponseWriter, r *http.Request, oh *openHandle) {
+	if !oh.writable() {
+		writeErrorV2(w, http.StatusConflict, CodeInvalidHandle, "handle is not open for writing")
+		return
+	}
+	offset, err := queryInt64(r, "offset", -1)
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	_, write := h.limiters(r, oh.path)
+	data, err := io.ReadAll(io.LimitReader(throttle.NewReader(r.Context(), r.Body, write...), MaxHandleWriteSize+1))
+	if err != nil {
+		writeErrorV2(w, http.StatusBadRequest, CodeBadRequest, "failed to read request body")
+		return
+	}
+	size, err := oh.writeAt(offset, data)
+	if err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, WriteResponseV2{Written: int64(len(data)), Size: size})
+}
+
+// SyncHandle handles POST /api/v2/handles/<id>/sync, storing the buffered writes
+func (h *Handler) SyncHandle(w http.ResponseWriter, r *http.Request, oh *openHandle) {
+	if err := oh.sync(h.requestFS(r)); err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CloseHandle handles DELETE /api/v2/handles/<id>, storing the buffered writes
+// The handle is closed even if storing them fails
+func (h *Handler) CloseHandle(w http.ResponseWriter, r *http.Request, oh *openHandle) {
+	h.handles.remove(oh.id)
+	if err := oh.sync(h.requestFS(r)); err != nil {
+		writeFSErrorV2(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setupV2Routes sets up the /api/v2 routes
+func (h *Handler) setupV2Routes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v2/capabilities", v2(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Capabilities(w, r)
+	}))
+	mux.HandleFunc("/api/v2/files", v2(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.ReadFileV2(w, r)
+		case http.MethodPut:
+			h.WriteFileV2(w, r)
+		case http.MethodDelete:
+			h.DeleteV2(w, r)
+		default:
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		}
+	}))
+	mux.HandleFunc("/api/v2/directories", v2(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.ListDirectoryV2(w, r)
+		case http.MethodPost:
+			h.CreateDirectoryV2(w, r)
+		case http.MethodDelete:
+			h.DeleteV2(w, r)
+		default:
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		}
+	}))
+	mux.HandleFunc("/api/v2/stat", v2(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.StatV2(w, r)
+	}))
+	mux.HandleFunc("/api/v2/rename", v2(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RenameV2(w, r)
+	}))
+	mux.HandleFunc("/api/v2/chmod", v2(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.ChmodV2(w, r)
+	}))
+	mux.HandleFunc("/api/v2/handles", v2(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.OpenHandle(w, r)
+	}))
+	mux.HandleFunc("/api/v2/handles/", v2(func(w http.ResponseWriter, r *http.Request) {
+		id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v2/handles/"), "/")
+		// Handles of other principals are as unknown as closed ones
+		oh, ok := h.handles.get(id, h.handleOwner(r))
+		if !ok {
+			writeErrorV2(w, http.StatusNotFound, CodeInvalidHandle, "unknown or expired handle")
+			return
+		}
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			h.ReadHandle(w, r, oh)
+		case action == "" && r.Method == http.MethodPut:
+			h.WriteHandle(w, r, oh)
+		case action == "" && r.Method == http.MethodDelete:
+			h.CloseHandle(w, r, oh)
+		case action == "sync" && r.Method == http.MethodPost:
+			h.SyncHandle(w, r, oh)
+		default:
+			writeErrorV2(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+		}
+	}))
+}
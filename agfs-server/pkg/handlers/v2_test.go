@@ -0,0 +1,87 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Files are read and written through open handles, and errors carry stable codes
+func TestHandlesV2(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{"/memfs/f": "hello world"})
+	do := func(method, url, body string, header ...string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+url, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+	code := func(data []byte) string {
+		var e handlers.ErrorResponseV2
+		json.Unmarshal(data, &e)
+		return e.Error.Code
+	}
+
+	resp, data := do(http.MethodGet, "/api/v2/capabilities", "")
+	var caps handlers.CapabilitiesResponse
+	if json.Unmarshal(data, &caps); resp.Header.Get(handlers.APIVersionHeader) != "2" || len(caps.Capabilities) == 0 {
+		t.Errorf("capabilities = %s %+v", resp.Header.Get(handlers.APIVersionHeader), caps)
+	}
+	if resp, data := do(http.MethodGet, "/api/v2/stat?path=/memfs/f", "", handlers.RequireHeader, "handles, teleport"); resp.StatusCode != http.StatusPreconditionFailed || code(data) != handlers.CodeUnsupportedCapability {
+		t.Errorf("unsupported capability = %d %s", resp.StatusCode, data)
+	}
+	if resp, data := do(http.MethodGet, "/api/v2/files?path=/memfs/f&offset=6&size=5", "", handlers.RequireHeader, handlers.CapabilityRangeRead); resp.StatusCode != http.StatusOK || string(data) != "world" {
+		t.Errorf("range read = %d %q", resp.StatusCode, data)
+	}
+	if resp, data := do(http.MethodGet, "/api/v2/stat?path=/nonexistent/f", ""); resp.StatusCode != http.StatusNotFound || code(data) != "not_found" {
+		t.Errorf("stat of a missing file = %d %s", resp.StatusCode, data)
+	}
+
+	// Writes through a handle are stored on sync
+	resp, data = do(http.MethodPost, "/api/v2/handles?path=/memfs/f&mode=rw", "")
+	var open handlers.OpenHandleResponse
+	if json.Unmarshal(data, &open); resp.StatusCode != http.StatusCreated || open.Handle == "" || open.Info.Size != 11 {
+		t.Fatalf("open = %d %s", resp.StatusCode, data)
+	}
+	handle := "/api/v2/handles/" + open.Handle
+	if resp, data := do(http.MethodPut, handle+"?offset=6", "WORLD"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("write at 6 = %d %s", resp.StatusCode, data)
+	}
+	if resp, data := do(http.MethodPut, handle, "!"); resp.StatusCode != http.StatusOK || !strings.Contains(string(data), `"size":12`) {
+		t.Errorf("append = %d %s", resp.StatusCode, data)
+	}
+	if resp, data := do(http.MethodGet, handle+"?offset=6", ""); resp.StatusCode != http.StatusOK || string(data) != "WORLD!" || resp.Header.Get("X-AGFS-EOF") != "true" {
+		t.Errorf("read through the handle = %d %q", resp.StatusCode, data)
+	}
+	srv.AssertFile("/memfs/f", "hello world")
+	if resp, _ := do(http.MethodPost, handle+"/sync", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("sync: status %d", resp.StatusCode)
+	}
+	srv.AssertFile("/memfs/f", "hello WORLD!")
+	if resp, _ := do(http.MethodDelete, handle, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("close: status %d", resp.StatusCode)
+	}
+	if resp, data := do(http.MethodGet, handle, ""); resp.StatusCode != http.StatusNotFound || code(data) != handlers.CodeInvalidHandle {
+		t.Errorf("read of a closed handle = %d %s", resp.StatusCode, data)
+	}
+
+	// Read-only handles refuse writes
+	_, data = do(http.MethodPost, "/api/v2/handles?path=/memfs/f", "")
+	json.Unmarshal(data, &open)
+	if resp, data := do(http.MethodPut, "/api/v2/handles/"+open.Handle, "x"); resp.StatusCode != http.StatusConflict || code(data) != handlers.CodeInvalidHandle {
+		t.Errorf("write to a read handle = %d %s", resp.StatusCode, data)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watch handles GET /watch?path=<path>&recursive=<true|false>
+// Changes of path and its entries, or with recursive of its whole tree, are sent as
+// server-sent events whose data is a filesystem.Event, until the client goes away. Events
+// are dropped for clients falling too far behind.
+func (h *Handler) Watch(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+	watcher, ok := h.requestFS(r).(filesystem.Watcher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "watching not supported for this filesystem")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported by the connection")
+		return
+	}
+	events, err := watcher.Watch(r.Context(), path, r.URL.Query().Get("recursive") == "true")
+	if err != nil {
+		status := mapErrorToStatus(err)
+		if status == http.StatusInternalServerError && filesystem.IsNotFound(err) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+
+	h.allowOrigin(w, r)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			_, err = io.WriteString(w, "data: "+string(data)+"\n\n")
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			log.Debugf("Error writing event: %v (this is normal if client disconnected)", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
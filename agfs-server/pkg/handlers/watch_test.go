@@ -0,0 +1,90 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Changes are streamed to watchers, including those localfs sees made behind the API
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	srv := pfstest.NewServer(t, withLocalFS(dir))
+	c := srv.Client
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	expect := func(events <-chan filesystem.Event, typ, path, oldPath string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Type != typ || e.Path != path || e.OldPath != oldPath {
+				t.Fatalf("event = %+v, want %s %s %s", e, typ, path, oldPath)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event, want %s %s", typ, path)
+		}
+	}
+
+	// memfs, through the API
+	if err := c.Mkdir("/memfs/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	events, err := c.Watch(ctx, "/memfs", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write("/memfs/dir/a.txt", []byte("alpha")); err != nil {
+		t.Fatal(err)
+	}
+	expect(events, filesystem.EventCreate, "/memfs/dir/a.txt", "")
+	expect(events, filesystem.EventWrite, "/memfs/dir/a.txt", "")
+	if err := c.Rename("/memfs/dir/a.txt", "/memfs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	expect(events, filesystem.EventRename, "/memfs/b.txt", "/memfs/dir/a.txt")
+	if err := c.Remove("/memfs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	expect(events, filesystem.EventRemove, "/memfs/b.txt", "")
+
+	// localfs, behind the API
+	local, err := c.Watch(ctx, "/local", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c.txt"), []byte("gamma"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect(local, filesystem.EventCreate, "/local/c.txt", "")
+
+	// The stream ends with the watch
+	cancel()
+	for range events {
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/nonexistent", http.StatusNotFound},
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodPost, "/memfs", http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+"/api/v1/watch?path="+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s watch %q: status %d, want %d", tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// WriteAtResponse represents the result of a write at an offset
+type WriteAtResponse struct {
+	Message string `json:"message"`
+	Path    string `json:"path"`
+	Offset  int64  `json:"offset"`
+	Bytes   int    `json:"bytes"` // Bytes written
+	Size    int64  `json:"size"`  // Size of the file after the write
+}
+
+// writeFileAt handles PUT /files?path=<path>&offset=<offset>
+// The body replaces the bytes of the file from offset on, the rest of the file is kept. A gap
+// between the end of the file and offset is filled with zeros, a missing file is created
+// locked is set if the caller holds the path lock
+func (h *Handler) writeFileAt(w http.ResponseWriter, r *http.Request, path string, offset int64, data []byte, locked bool) {
+	fs := h.requestFS(r)
+	if _, ok := fs.(filesystem.WriterAt); !ok && !locked {
+		// Writes through other views are read-modify-write, serialize them here
+		defer h.pathLocks.Lock(path)()
+	}
+	size, err := filesystem.WriteAt(fs, path, data, offset)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, WriteAtResponse{Message: "written", Path: path, Offset: offset, Bytes: len(data), Size: size})
+}
+
+// parseRange parses a Range header with a single byte range, "bytes=a-b", "bytes=a-" or
+// "bytes=-n", against a file of size bytes and returns the offset and length it covers
+func parseRange(header string, size int64) (offset, length int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		if size == 0 {
+			return 0, 0, fmt.Errorf("range %q not satisfiable", header)
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", header)
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range %q not satisfiable", header)
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", header)
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// readRange serves the byte range of a GET /files request with a Range header
+func (h *Handler) readRange(w http.ResponseWriter, r *http.Request, path, header string) {
+	fs := h.requestFS(r)
+	info, err := fs.Stat(path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	offset, length, err := parseRange(header, info.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, err.Error())
+		return
+	}
+	data, err := fs.Read(path, offset, length)
+	if err != nil && err != io.EOF {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	read, _ := h.limiters(r, path)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(data))-1, info.Size))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusPartialContent)
+	throttle.NewWriter(r.Context(), w, read...).Write(data)
+}
@@ -0,0 +1,59 @@
+package handlers_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+// Writes at an offset replace part of a file, and Range reads return part of it
+func TestWriteAtAndRange(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{"/memfs/f": "hello world"})
+	do := func(method, query, body, rangeHeader string) (int, string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/api/v1/files?"+query, strings.NewReader(body))
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Range"), string(data)
+	}
+
+	if status, _, body := do(http.MethodPut, "path=/memfs/f&offset=6", "WORLD", ""); status != http.StatusOK {
+		t.Fatalf("write at 6 = %d %s", status, body)
+	}
+	srv.AssertFile("/memfs/f", "hello WORLD")
+	// A gap up to the offset is filled with zeros
+	if status, _, body := do(http.MethodPut, "path=/memfs/f&offset=13", "!", ""); status != http.StatusOK {
+		t.Fatalf("write past the end = %d %s", status, body)
+	}
+	srv.AssertFile("/memfs/f", "hello WORLD\x00\x00!")
+
+	for _, tc := range []struct {
+		header, contentRange, body string
+	}{
+		{"bytes=0-4", "bytes 0-4/14", "hello"},
+		{"bytes=6-", "bytes 6-13/14", "WORLD\x00\x00!"},
+		{"bytes=-1", "bytes 13-13/14", "!"},
+		{"bytes=10-100", "bytes 10-13/14", "D\x00\x00!"},
+	} {
+		status, contentRange, body := do(http.MethodGet, "path=/memfs/f", "", tc.header)
+		if status != http.StatusPartialContent || contentRange != tc.contentRange || body != tc.body {
+			t.Errorf("%s = %d %q %q, want %q %q", tc.header, status, contentRange, body, tc.contentRange, tc.body)
+		}
+	}
+	for _, header := range []string{"bytes=14-", "bytes=0-1,4-5", "lines=1-2", "bytes=5-2"} {
+		if status, contentRange, _ := do(http.MethodGet, "path=/memfs/f", "", header); status != http.StatusRequestedRangeNotSatisfiable || contentRange != "bytes */14" {
+			t.Errorf("%s = %d %q", header, status, contentRange)
+		}
+	}
+}
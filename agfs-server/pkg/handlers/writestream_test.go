@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/client"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+)
+
+// failingBody returns its data, then an error as if the client went away
+type failingBody struct {
+	r io.Reader
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+// Streamed writes land in the file whole and carry the ETag of its content
+func TestWriteStream(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/memfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(mfs).SetupRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Several chunks and a partial one
+	data := bytes.Repeat([]byte("0123456789abcdef"), 3*streamWriteChunk/16+7)
+	c := client.NewClient(srv.URL)
+	if _, err := c.WriteStream("/memfs/big.bin", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Read("/memfs/big.bin", 0, -1)
+	if (err != nil && err != io.EOF) || !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, want %d: %v", len(got), len(data), err)
+	}
+
+	put := func(query, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/v1/files?"+query, strings.NewReader(body))
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	streamed := put("path=/memfs/a.txt&stream=true", "hello")
+	buffered := put("path=/memfs/b.txt", "hello")
+	if streamed.StatusCode != http.StatusOK || streamed.Header.Get("ETag") != buffered.Header.Get("ETag") {
+		t.Errorf("streamed write: status %d, etag %q, want %q", streamed.StatusCode, streamed.Header.Get("ETag"), buffered.Header.Get("ETag"))
+	}
+	if resp := put("path=/memfs/a.txt&stream=true&offset=2", "x"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("streamed write at an offset: status %d", resp.StatusCode)
+	}
+	if _, err := c.WriteStream("/nonexistent/a.txt", strings.NewReader("x")); err == nil {
+		t.Error("streamed write outside the mounts: no error")
+	}
+}
+
+// Streamed writes are committed when the body ends, and aborted when it fails
+func TestWriteStreamAbort(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("sqlfs", func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/memfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("sqlfs", "/sqlfs", map[string]interface{}{"db_path": filepath.Join(t.TempDir(), "sqlfs.db")}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(mfs).SetupRoutes(mux)
+	put := func(path string, body io.Reader) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/files?stream=true&path="+path, body))
+		return rec.Code
+	}
+
+	for _, path := range []string{"/memfs/a.txt", "/sqlfs/a.txt"} {
+		if status := put(path, strings.NewReader("old")); status != http.StatusOK {
+			t.Fatalf("%s: stream write status %d", path, status)
+		}
+		partial := &failingBody{r: strings.NewReader(strings.Repeat("x", 3*streamWriteChunk/2))}
+		if status := put(path, partial); status != http.StatusBadRequest {
+			t.Errorf("%s: failed body status %d", path, status)
+		}
+		if data, err := mfs.Read(path, 0, -1); string(data) != "old" {
+			t.Errorf("%s after the failed body = %.10q (%d bytes), %v", path, data, len(data), err)
+		}
+	}
+}
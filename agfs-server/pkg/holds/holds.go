@@ -0,0 +1,245 @@
+// Package holds keeps the legal holds and retentions placed on paths. A MountableFS
+// given the Manager refuses to modify, rename or remove held paths, whoever asks, until
+// legal holds are released by an authorized principal and retentions expire.
+package holds
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// DefaultFile keeps the holds when the configuration names no file
+const DefaultFile = "holds.json"
+
+// Hold is the hold on a path, see mountablefs.Hold
+type Hold = mountablefs.Hold
+
+// Request places or extends the hold on Path
+// LegalHold places a legal hold; RetainUntil sets the retention, which can only be extended.
+type Request struct {
+	Path        string     `json:"path"`
+	LegalHold   bool       `json:"legalHold"`
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+// PrincipalFunc returns the principal of the request a context belongs to, and false for
+// requests without one
+type PrincipalFunc func(ctx context.Context) (string, bool)
+
+// AdminFunc reports whether the principal of the request a context belongs to is an admin
+type AdminFunc func(ctx context.Context) bool
+
+// Manager keeps the holds and saves them to a file; it implements mountablefs.HoldSource
+type Manager struct {
+	mu         sync.RWMutex
+	holds      map[string]*Hold
+	file       string
+	principals map[string]bool
+	principal  PrincipalFunc
+	admin      AdminFunc
+}
+
+// New creates a Manager from the holds configuration, loading the holds of its file; admin
+// tells the principals placing and releasing holds without configured ones, nil for nobody
+func New(cfg config.HoldsConfig, principal PrincipalFunc, admin AdminFunc) (*Manager, error) {
+	m := &Manager{holds: make(map[string]*Hold), file: cfg.File, principal: principal, admin: admin}
+	if m.file == "" {
+		m.file = DefaultFile
+	}
+	if len(cfg.Principals) > 0 {
+		m.principals = make(map[string]bool, len(cfg.Principals))
+		for _, p := range cfg.Principals {
+			m.principals[p] = true
+		}
+	}
+	data, err := os.ReadFile(m.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("holds: %w", err)
+	}
+	if err == nil {
+		var saved []Hold
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("holds: %s: %w", m.file, err)
+		}
+		for i := range saved {
+			h := saved[i]
+			h.Path = filesystem.NormalizePath(h.Path)
+			m.holds[h.Path] = &h
+		}
+	}
+	return m, nil
+}
+
+// Authorize returns the principal of ctx, which placed and released holds are recorded
+// with, and whether it may place and release them
+// Without configured principals only admins may, as on the other admin routes.
+func (m *Manager) Authorize(ctx context.Context) (string, bool) {
+	name, ok := m.principal(ctx)
+	if m.principals == nil {
+		return name, m.admin != nil && m.admin(ctx)
+	}
+	return name, ok && m.principals[name]
+}
+
+// under reports whether path is dir or below it
+func under(path, dir string) bool {
+	return dir == "/" || path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// HoldOn implements mountablefs.HoldSource, the hold of the longest held path wins
+func (m *Manager) HoldOn(path string) (Hold, bool) {
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var match *Hold
+	for dir, h := range m.holds {
+		if under(path, dir) && h.Active(now) && (match == nil || len(dir) > len(match.Path)) {
+			match = h
+		}
+	}
+	if match == nil {
+		return Hold{}, false
+	}
+	return *match, true
+}
+
+// HoldUnder implements mountablefs.HoldSource
+func (m *Manager) HoldUnder(path string) (Hold, bool) {
+	if h, ok := m.HoldOn(path); ok {
+		return h, true
+	}
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for dir, h := range m.holds {
+		if under(dir, path) && h.Active(now) {
+			return *h, true
+		}
+	}
+	return Hold{}, false
+}
+
+// List returns the active holds on prefix and below it, sorted by path
+func (m *Manager) List(prefix string) []Hold {
+	prefix = filesystem.NormalizePath(prefix)
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	list := []Hold{}
+	for dir, h := range m.holds {
+		if under(dir, prefix) && h.Active(now) {
+			list = append(list, *h)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list
+}
+
+// Place places or extends the hold of req for principal by
+func (m *Manager) Place(req Request, by string) (Hold, error) {
+	if !strings.HasPrefix(req.Path, "/") {
+		return Hold{}, filesystem.NewInvalidArgumentError("path", req.Path, "must be absolute")
+	}
+	if !req.LegalHold && req.RetainUntil == nil {
+		return Hold{}, filesystem.NewInvalidArgumentError("hold", req.Path, "legalHold or retainUntil required")
+	}
+	now := time.Now()
+	if req.RetainUntil != nil && !req.RetainUntil.After(now) {
+		return Hold{}, filesystem.NewInvalidArgumentError("retainUntil", req.RetainUntil.Format(time.RFC3339), "must be in the future")
+	}
+	path := filesystem.NormalizePath(req.Path)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := Hold{Path: path, Reason: req.Reason, By: by, Created: now}
+	if old, ok := m.holds[path]; ok && old.Active(now) {
+		h = *old
+		if req.Reason != "" {
+			h.Reason = req.Reason
+		}
+	}
+	if req.LegalHold {
+		h.LegalHold = true
+	}
+	if req.RetainUntil != nil {
+		if h.RetainUntil != nil && req.RetainUntil.Before(*h.RetainUntil) {
+			return Hold{}, filesystem.NewInvalidArgumentError("retainUntil", req.RetainUntil.Format(time.RFC3339),
+				"retention can only be extended, it ends at "+h.RetainUntil.Format(time.RFC3339))
+		}
+		until := req.RetainUntil.UTC()
+		h.RetainUntil = &until
+	}
+	if err := m.update(path, &h, now); err != nil {
+		return Hold{}, err
+	}
+	return h, nil
+}
+
+// Release releases the legal hold on path; the path stays immutable until the end of its
+// retention, if any
+func (m *Manager) Release(path string) (Hold, error) {
+	path = filesystem.NormalizePath(path)
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.holds[path]
+	if !ok || !old.LegalHold {
+		return Hold{}, filesystem.NewNotFoundError("legal hold", path)
+	}
+	h := *old
+	h.LegalHold = false
+	kept := &h
+	if !h.Active(now) {
+		kept = nil
+	}
+	if err := m.update(path, kept, now); err != nil {
+		return Hold{}, err
+	}
+	return h, nil
+}
+
+// update sets the hold of path, nil removes it, drops expired holds and saves them to
+// the file; the caller must hold m.mu
+func (m *Manager) update(path string, h *Hold, now time.Time) error {
+	holds := make(map[string]*Hold, len(m.holds)+1)
+	saved := make([]Hold, 0, len(m.holds)+1)
+	for dir, old := range m.holds {
+		if dir != path && old.Active(now) {
+			holds[dir] = old
+			saved = append(saved, *old)
+		}
+	}
+	if h != nil {
+		holds[path] = h
+		saved = append(saved, *h)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Path < saved[j].Path })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.file), 0755); err != nil {
+		return err
+	}
+	tmp := m.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.file); err != nil {
+		return err
+	}
+	m.holds = holds
+	return nil
+}
@@ -0,0 +1,159 @@
+package holds
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+)
+
+type principalKey struct{}
+
+func principalOf(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(principalKey{}).(string)
+	return name, ok
+}
+
+func TestHolds(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("sqlfs", func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/memfs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("sqlfs", "/sqlfs", map[string]interface{}{"db_path": filepath.Join(t.TempDir(), "sqlfs.db")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/sqlfs/held.txt", []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/memfs/case", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/memfs/case/a.txt", "/memfs/b.txt"} {
+		if _, err := mfs.Write(p, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "holds.json")
+	m, err := New(config.HoldsConfig{File: file, Principals: []string{"legal"}}, principalOf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetHolds(m)
+	defer mfs.SetHolds(nil)
+	as := func(principal string) context.Context {
+		return context.WithValue(context.Background(), principalKey{}, principal)
+	}
+	if _, ok := m.Authorize(as("bob")); ok {
+		t.Error("bob authorized")
+	}
+	if _, ok := m.Authorize(context.Background()); ok {
+		t.Error("request without principal authorized")
+	}
+	if name, ok := m.Authorize(as("legal")); !ok || name != "legal" {
+		t.Errorf("legal = %q, %v", name, ok)
+	}
+
+	// Held paths and those below them cannot be modified, renamed or removed
+	if _, err := m.Place(Request{Path: "/memfs/case", LegalHold: true, Reason: "litigation"}, "legal"); err != nil {
+		t.Fatal(err)
+	}
+	denied := func(what string, err error) {
+		t.Helper()
+		if !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s of a held path: %v", what, err)
+		}
+	}
+	_, err = mfs.Write("/memfs/case/a.txt", []byte("x"))
+	denied("write", err)
+	_, err = mfs.Write("/memfs/case/new.txt", []byte("x"))
+	denied("create", err)
+	denied("remove", mfs.Remove("/memfs/case/a.txt"))
+	denied("rename", mfs.Rename("/memfs/case/a.txt", "/memfs/c.txt"))
+	denied("rename over", mfs.Rename("/memfs/b.txt", "/memfs/case/a.txt"))
+	denied("removal of the parent", mfs.RemoveAll("/memfs"))
+	if _, err := mfs.Write("/memfs/b.txt", []byte("x")); err != nil {
+		t.Errorf("write beside the held path: %v", err)
+	}
+
+	// Bulk loads cannot replace held files either
+	if _, err := m.Place(Request{Path: "/sqlfs/held.txt", LegalHold: true}, "legal"); err != nil {
+		t.Fatal(err)
+	}
+	session, err := mfs.BeginBulk("/sqlfs", filesystem.BulkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied("bulk load", session.WriteBatch([]filesystem.BulkEntry{{Path: "/sqlfs/new.txt"}, {Path: "/sqlfs/held.txt", Data: []byte("x")}}))
+	if err := session.WriteBatch([]filesystem.BulkEntry{{Path: "/sqlfs/new.txt", Data: []byte("x")}}); err != nil {
+		t.Errorf("bulk load beside the held file: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := mfs.Read("/sqlfs/held.txt", 0, -1); string(data) != "data" {
+		t.Errorf("held file after the bulk load = %q, %v", data, err)
+	}
+
+	// Stat and listings report the hold
+	if info, err := mfs.Stat("/memfs/case/a.txt"); err != nil || info.Meta.Content[mountablefs.MetaKeyLegalHold] != "true" {
+		t.Errorf("stat = %+v, %v", info, err)
+	}
+	if infos, err := mfs.ReadDir("/memfs"); err != nil || len(infos) != 3 {
+		t.Errorf("listing = %+v, %v", infos, err)
+	} else {
+		for _, info := range infos {
+			if held := info.Meta.Content[mountablefs.MetaKeyLegalHold] == "true"; held != (info.Name == "case") {
+				t.Errorf("listed %s with meta %v", info.Name, info.Meta.Content)
+			}
+		}
+	}
+
+	// Retentions can only be extended and outlive released legal holds
+	until := time.Now().Add(time.Hour)
+	if _, err := m.Place(Request{Path: "/memfs/case", RetainUntil: &until}, "legal"); err != nil {
+		t.Fatal(err)
+	}
+	sooner := until.Add(-time.Minute)
+	if _, err := m.Place(Request{Path: "/memfs/case", RetainUntil: &sooner}, "legal"); err == nil {
+		t.Error("retention shortened")
+	}
+	if h, err := m.Release("/memfs/case"); err != nil || h.LegalHold || h.RetainUntil == nil {
+		t.Errorf("release = %+v, %v", h, err)
+	}
+	denied("removal during retention", mfs.Remove("/memfs/case/a.txt"))
+	if _, err := m.Release("/memfs/case"); !filesystem.IsNotFound(err) {
+		t.Errorf("second release: %v", err)
+	}
+
+	// Holds are saved and loaded back
+	reloaded, err := New(config.HoldsConfig{File: file}, principalOf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := reloaded.List("/memfs"); len(list) != 1 || list[0].Path != "/memfs/case" || list[0].Reason != "litigation" || list[0].By != "legal" {
+		t.Errorf("reloaded holds = %+v", list)
+	}
+	if len(reloaded.List("/other")) != 0 {
+		t.Error("holds listed outside the prefix")
+	}
+
+	// Expired retentions no longer protect their path
+	m.mu.Lock()
+	expired := time.Now().Add(-time.Second)
+	m.holds["/memfs/case"].RetainUntil = &expired
+	m.mu.Unlock()
+	if err := mfs.Remove("/memfs/case/a.txt"); err != nil {
+		t.Errorf("remove after retention: %v", err)
+	}
+}
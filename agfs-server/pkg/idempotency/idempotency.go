@@ -0,0 +1,414 @@
+// Package idempotency makes retries of mutating API requests safe: a request carrying an
+// Idempotency-Key header is applied once, and retries with the same key within a window get
+// the stored response instead of applying it again. Clients behind proxyfs chains can then
+// retry a write whose outcome a timeout left unknown.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Header carries the key of a request; ReplayedHeader marks a stored response
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// Defaults of the idempotency configuration
+const (
+	DefaultWindow     = 10 * time.Minute
+	DefaultMaxEntries = 10000
+	MaxKeyLength      = 255
+	maxResponseBody   = 1024 * 1024 // Larger responses are not stored, their retries apply again
+	sweepInterval     = time.Minute
+	recordPrefix      = "idempotency-"
+)
+
+type contextKey struct{}
+
+// WithKey returns a context carrying the idempotency key of the request being served, so
+// the requests made on its behalf, e.g. by proxyfs, derive their keys from it
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the idempotency key carried by ctx, "" if none
+func FromContext(ctx context.Context) string {
+	key, _ := ctx.Value(contextKey{}).(string)
+	return key
+}
+
+// Derive returns the key of a request identified by parts made on behalf of the request
+// of key; a retry of that request derives the same key, distinct requests different ones
+func Derive(key string, parts ...string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Response is the stored outcome of a request
+type Response struct {
+	Fingerprint string      `json:"fingerprint"` // Method, URL and body size of the request
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	Expires     time.Time   `json:"expires"`
+}
+
+// Store keeps responses by key
+type Store interface {
+	// Get returns the response stored for key, nil if there is none
+	Get(key string) (*Response, error)
+	Put(key string, resp *Response) error
+}
+
+// MemoryStore keeps responses in memory, dropping the oldest beyond its capacity
+type MemoryStore struct {
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*Response
+	order   []string // Keys in the order they were stored
+}
+
+// NewMemoryStore creates a store of at most max responses
+func NewMemoryStore(max int) *MemoryStore {
+	return &MemoryStore{max: max, entries: make(map[string]*Response)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(key string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key], nil
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(key string, resp *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for len(s.order) > 0 {
+		oldest := s.entries[s.order[0]]
+		if oldest != nil && len(s.entries) < s.max && oldest.Expires.After(now) {
+			break
+		}
+		delete(s.entries, s.order[0])
+		s.order = s.order[1:]
+	}
+	if _, ok := s.entries[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.entries[key] = resp
+	return nil
+}
+
+// FileStore keeps responses as files in a directory of the server, e.g. /kvfs/keys, so
+// they survive a restart; expired files are removed by a sweep running at most once a minute
+type FileStore struct {
+	fs     filesystem.FileSystem
+	dir    string
+	window time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileStore creates a store in dir of fs, creating the directory if needed
+func NewFileStore(fs filesystem.FileSystem, dir string, window time.Duration) (*FileStore, error) {
+	dir = filesystem.NormalizePath(dir)
+	if _, err := fs.Stat(dir); err != nil {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	return &FileStore{fs: fs, dir: dir, window: window}, nil
+}
+
+func (s *FileStore) file(key string) string {
+	return path.Join(s.dir, recordPrefix+key)
+}
+
+// Get implements Store
+func (s *FileStore) Get(key string) (*Response, error) {
+	data, err := s.fs.Read(s.file(key), 0, -1)
+	if err != nil && err != io.EOF {
+		if _, statErr := s.fs.Stat(s.file(key)); statErr != nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid idempotency record %s: %w", s.file(key), err)
+	}
+	return &resp, nil
+}
+
+// Put implements Store
+func (s *FileStore) Put(key string, resp *Response) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if _, err := s.fs.Write(s.file(key), data); err != nil {
+		return err
+	}
+	s.sweep()
+	return nil
+}
+
+// sweep removes the records older than the window, going by their modification time
+func (s *FileStore) sweep() {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	infos, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		log.Warnf("[idempotency] failed to list %s: %v", s.dir, err)
+		return
+	}
+	cutoff := time.Now().Add(-s.window)
+	for _, info := range infos {
+		if info.IsDir || !strings.HasPrefix(info.Name, recordPrefix) || info.ModTime.After(cutoff) {
+			continue
+		}
+		if err := s.fs.Remove(path.Join(s.dir, info.Name)); err != nil {
+			log.Warnf("[idempotency] failed to remove %s: %v", info.Name, err)
+		}
+	}
+}
+
+// Deduplicator is a middleware applying each idempotency key once
+type Deduplicator struct {
+	store     Store
+	window    time.Duration
+	principal PrincipalFunc
+
+	mu       sync.Mutex
+	inflight map[string]chan struct{} // Closed when the request of the key completes
+}
+
+// New creates a deduplicator from cfg; fs holds the responses when cfg.Dir is set
+func New(cfg config.IdempotencyConfig, fs filesystem.FileSystem) (*Deduplicator, error) {
+	window := DefaultWindow
+	if cfg.Window != "" {
+		var err error
+		if window, err = pluginconfig.ParseDuration(cfg.Window); err != nil || window <= 0 {
+			return nil, fmt.Errorf("idempotency: invalid window: %s", cfg.Window)
+		}
+	}
+	maxEntries := cfg.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	if maxEntries < 0 {
+		return nil, fmt.Errorf("idempotency: invalid max_entries: %d", cfg.MaxEntries)
+	}
+
+	var store Store = NewMemoryStore(maxEntries)
+	if cfg.Dir != "" {
+		fileStore, err := NewFileStore(fs, cfg.Dir, window)
+		if err != nil {
+			return nil, fmt.Errorf("idempotency: %w", err)
+		}
+		store = fileStore
+	}
+	return NewDeduplicator(store, window), nil
+}
+
+// NewDeduplicator creates a deduplicator replaying the responses of store for window
+func NewDeduplicator(store Store, window time.Duration) *Deduplicator {
+	return &Deduplicator{store: store, window: window, inflight: make(map[string]chan struct{})}
+}
+
+// mutating reports whether requests of method change the file system
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// PrincipalFunc returns the authenticated principal of the request a context belongs to, and
+// false for requests without one
+type PrincipalFunc func(ctx context.Context) (string, bool)
+
+// SetPrincipal makes keys private to the principal it returns for the requests having one,
+// rather than to their credentials; it must be set before the middleware serves requests
+func (d *Deduplicator) SetPrincipal(principal PrincipalFunc) {
+	d.principal = principal
+}
+
+// scope returns the store key of key, which is private to the authenticated principal of r,
+// or to its credentials without one, so clients cannot replay each other's responses
+func (d *Deduplicator) scope(r *http.Request, key string) string {
+	owner := "credentials:" + r.Header.Get("Authorization")
+	if d.principal != nil {
+		if name, ok := d.principal(r.Context()); ok {
+			owner = "principal:" + name
+		}
+	}
+	sum := sha256.Sum256([]byte(owner + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint identifies the request a key was first used with
+func fingerprint(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI() + " " + strconv.FormatInt(r.ContentLength, 10)
+}
+
+// Middleware applies the mutating requests of next carrying an Idempotency-Key once per
+// key; concurrent retries wait for the first request and get its response. Responses with
+// a 5xx status are not stored, so a retry applies the request again
+func (d *Deduplicator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > MaxKeyLength {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("%s longer than %d bytes", Header, MaxKeyLength))
+			return
+		}
+		id, fp := d.scope(r, key), fingerprint(r)
+
+		done, err := d.acquire(r.Context(), id)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		defer done()
+
+		stored, err := d.store.Get(id)
+		if err != nil {
+			log.Warnf("[idempotency] %v", err)
+		}
+		if stored != nil && time.Now().Before(stored.Expires) {
+			if stored.Fingerprint != fp {
+				writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("%s was used with a different request: %s", Header, stored.Fingerprint))
+				return
+			}
+			replay(w, stored)
+			return
+		}
+
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(WithKey(r.Context(), id)))
+		if rw.status >= 500 || rw.truncated {
+			return
+		}
+		resp := &Response{
+			Fingerprint: fp,
+			Status:      rw.status,
+			Header:      rw.header,
+			Body:        rw.body.Bytes(),
+			Expires:     time.Now().Add(d.window),
+		}
+		if err := d.store.Put(id, resp); err != nil {
+			log.Warnf("[idempotency] failed to store the response of %s: %v", fp, err)
+		}
+	})
+}
+
+// acquire waits until no other request of id is in flight and marks one in flight; done
+// releases it
+func (d *Deduplicator) acquire(ctx context.Context, id string) (done func(), err error) {
+	for {
+		d.mu.Lock()
+		wait, busy := d.inflight[id]
+		if !busy {
+			ch := make(chan struct{})
+			d.inflight[id] = ch
+			d.mu.Unlock()
+			return func() {
+				d.mu.Lock()
+				delete(d.inflight, id)
+				d.mu.Unlock()
+				close(ch)
+			}, nil
+		}
+		d.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request with the same %s still in progress", Header)
+		}
+	}
+}
+
+// replay writes a stored response
+func replay(w http.ResponseWriter, resp *Response) {
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// responseRecorder captures the status, the headers and the body of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.header == nil {
+		w.status = status
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.body.Len()+len(p) > maxResponseBody {
+		w.truncated = true
+	} else if !w.truncated {
+		w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses working; they are not stored
+func (w *responseRecorder) Flush() {
+	w.truncated = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
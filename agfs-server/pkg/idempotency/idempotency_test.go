@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestMiddleware(t *testing.T) {
+	for _, cfg := range []config.IdempotencyConfig{{}, {Dir: "/idem"}} {
+		d, err := New(cfg, memfs.NewMemoryFS())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var applied atomic.Int32
+		release := make(chan struct{})
+		var keys sync.Map
+		server := httptest.NewServer(d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys.Store(r.URL.Query().Get("path"), FromContext(r.Context()))
+			switch r.URL.Query().Get("path") {
+			case "/slow":
+				<-release
+			case "/fail":
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			applied.Add(1)
+			w.Header().Set("ETag", `"v1"`)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created"))
+		})))
+
+		send := func(path, key, body string) *http.Response {
+			req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path="+path, strings.NewReader(body))
+			if key != "" {
+				req.Header.Set(Header, key)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			return resp
+		}
+
+		send("/a", "k1", "data")
+		resp := send("/a", "k1", "data")
+		if applied.Load() != 1 || resp.StatusCode != http.StatusCreated || resp.Header.Get(ReplayedHeader) != "true" || resp.Header.Get("ETag") != `"v1"` {
+			t.Errorf("%+v: retry applied %d times, status %d, headers %v", cfg, applied.Load(), resp.StatusCode, resp.Header)
+		}
+		if resp := send("/b", "k1", "data"); resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%+v: key reused for another request: status %d", cfg, resp.StatusCode)
+		}
+		if key, _ := keys.Load("/a"); key == "" {
+			t.Errorf("%+v: key not passed on in the context", cfg)
+		}
+		send("/a", "", "data")
+		if applied.Load() != 2 {
+			t.Errorf("%+v: request without a key not applied", cfg)
+		}
+
+		// Failures are not stored, their retries apply again
+		send("/fail", "k2", "")
+		if resp := send("/fail", "k2", ""); resp.Header.Get(ReplayedHeader) != "" {
+			t.Errorf("%+v: 5xx response replayed", cfg)
+		}
+
+		// A retry waits for the request in flight and gets its response
+		var wg sync.WaitGroup
+		statuses := make([]*http.Response, 2)
+		for i := range statuses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				statuses[i] = send("/slow", "k3", "")
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		if applied.Load() != 3 || statuses[0].StatusCode != http.StatusCreated || statuses[1].StatusCode != http.StatusCreated {
+			t.Errorf("%+v: concurrent retries applied %d times", cfg, applied.Load()-2)
+		}
+		server.Close()
+	}
+}
+
+// Keys are private to the authenticated principal, whatever credentials it presents
+func TestScope(t *testing.T) {
+	type principalKey struct{}
+	d := NewDeduplicator(NewMemoryStore(10), time.Minute)
+	d.SetPrincipal(func(ctx context.Context) (string, bool) {
+		name, ok := ctx.Value(principalKey{}).(string)
+		return name, ok
+	})
+	request := func(principal, authorization string) *http.Request {
+		r := httptest.NewRequest(http.MethodPut, "/api/v1/files?path=/a", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if principal != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+		}
+		return r
+	}
+	if d.scope(request("alice", "Bearer key-1"), "k") != d.scope(request("alice", "Bearer key-2"), "k") {
+		t.Error("keys of a principal depend on its credentials")
+	}
+	if d.scope(request("alice", "Bearer shared"), "k") == d.scope(request("bob", "Bearer shared"), "k") {
+		t.Error("principals share keys")
+	}
+	if d.scope(request("", "Bearer tenant-1"), "k") == d.scope(request("", "Bearer tenant-2"), "k") {
+		t.Error("credentials without principals share keys")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	if Derive("k", "PUT", "/a") != Derive("k", "PUT", "/a") {
+		t.Error("derived keys differ for the same request")
+	}
+	if Derive("k", "PUT", "/a") == Derive("k", "PUT", "/b") || Derive("k", "PUT", "/a") == Derive("j", "PUT", "/a") {
+		t.Error("derived keys collide")
+	}
+}
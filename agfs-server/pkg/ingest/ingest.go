@@ -0,0 +1,360 @@
+// Package ingest seeds a directory with many files at once, from a tar stream or from
+// another directory. Targets implementing filesystem.BulkWriter, such as sqlfs, receive the
+// files in batches written by parallel workers; other targets get one Write per file.
+package ingest
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Defaults of Options
+const (
+	DefaultBatchSize = 500
+	DefaultWorkers   = 4
+	MaxBatchSize     = 1000
+	MaxWorkers       = 64
+
+	// batchBytes ends a batch early once its files hold this much data
+	batchBytes = 16 << 20
+)
+
+// Options controls an ingest
+type Options struct {
+	BatchSize    int             // Entries per batch, DefaultBatchSize if 0
+	Workers      int             // Batches written (and source files read) concurrently, DefaultWorkers if 0
+	DeferIndexes bool            // Drop secondary indexes of the target during the ingest
+	Filter       *archive.Filter // Optional filter on the paths relative to the source
+}
+
+// Stats summarizes an ingest
+type Stats struct {
+	Files   int     `json:"files"`
+	Dirs    int     `json:"dirs"`
+	Bytes   int64   `json:"bytes"`
+	Batches int     `json:"batches"`
+	Bulk    bool    `json:"bulk"`    // False if the target wrote the files one by one
+	Seconds float64 `json:"seconds"` // Duration of the ingest
+}
+
+// normalize applies the defaults and limits of opts
+func (o Options) normalize() (Options, error) {
+	if o.BatchSize == 0 {
+		o.BatchSize = DefaultBatchSize
+	}
+	if o.Workers == 0 {
+		o.Workers = DefaultWorkers
+	}
+	if o.BatchSize < 0 || o.BatchSize > MaxBatchSize {
+		return o, filesystem.NewInvalidArgumentError("batch_size", o.BatchSize, fmt.Sprintf("must be between 1 and %d", MaxBatchSize))
+	}
+	if o.Workers < 0 || o.Workers > MaxWorkers {
+		return o, filesystem.NewInvalidArgumentError("workers", o.Workers, fmt.Sprintf("must be between 1 and %d", MaxWorkers))
+	}
+	return o, nil
+}
+
+// FromTar ingests a tar or tar.gz stream into dest of fs
+// Links and other special entries are skipped, like archive.Extract does
+func FromTar(r io.Reader, format archive.Format, fs filesystem.FileSystem, dest string, opts Options) (Stats, error) {
+	switch format {
+	case archive.FormatTar:
+	case archive.FormatTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return Stats{}, filesystem.NewInvalidArgumentError("body", "", "invalid gzip stream: "+err.Error())
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return Stats{}, filesystem.NewInvalidArgumentError("format", format, "ingest reads tar and tar.gz streams")
+	}
+
+	l, err := start(fs, dest, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			l.fail(filesystem.NewInvalidArgumentError("body", "", "invalid tar stream: "+err.Error()))
+			break
+		}
+		if hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !l.opts.Filter.Included(hdr.Name) {
+			continue
+		}
+		entry := filesystem.BulkEntry{
+			Path:    path.Join(l.dest, path.Clean("/"+hdr.Name)),
+			IsDir:   hdr.Typeflag == tar.TypeDir,
+			Mode:    uint32(hdr.Mode) & 0777,
+			ModTime: hdr.ModTime,
+		}
+		if !entry.IsDir {
+			if entry.Data, err = io.ReadAll(tr); err != nil {
+				l.fail(fmt.Errorf("failed to read %s from archive: %w", hdr.Name, err))
+				break
+			}
+		}
+		if err := l.add(entry); err != nil {
+			break
+		}
+	}
+	return l.finish()
+}
+
+// FromTree ingests the tree at src of fs into dest of the same file system, e.g. a localfs
+// or s3fs mount into a sqlfs mount
+func FromTree(fs filesystem.FileSystem, src, dest string, opts Options) (Stats, error) {
+	src = filesystem.NormalizePath(src)
+	dest = filesystem.NormalizePath(dest)
+	if src == "/" || dest == src || strings.HasPrefix(dest, src+"/") || strings.HasPrefix(src, dest+"/") {
+		return Stats{}, filesystem.NewInvalidArgumentError("from", src, "source and destination must not contain each other")
+	}
+	if _, err := fs.Stat(src); err != nil {
+		return Stats{}, err
+	}
+
+	l, err := start(fs, dest, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// Files are read by the workers as well, remote sources are usually the slow side
+	type file struct {
+		path  string
+		entry filesystem.BulkEntry
+	}
+	files := make(chan file)
+	var readers sync.WaitGroup
+	for i := 0; i < l.opts.Workers; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for f := range files {
+				data, err := fs.Read(f.path, 0, -1)
+				if err != nil && err != io.EOF {
+					l.fail(err)
+					continue
+				}
+				f.entry.Data = data
+				l.add(f.entry)
+			}
+		}()
+	}
+
+	walkErr := filesystem.Walk(fs, src, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := l.error(); err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(p, src), "/")
+		if name == "" {
+			if info.IsDir {
+				return nil
+			}
+			name = path.Base(p)
+		}
+		if l.opts.Filter.Excluded(name) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if !l.opts.Filter.Included(name) {
+			return nil
+		}
+		entry := filesystem.BulkEntry{Path: path.Join(l.dest, name), IsDir: info.IsDir, Mode: info.Mode & 0777, ModTime: info.ModTime}
+		if info.IsDir {
+			return l.add(entry)
+		}
+		files <- file{path: p, entry: entry}
+		return nil
+	})
+	close(files)
+	readers.Wait()
+	if walkErr != nil {
+		l.fail(walkErr)
+	}
+	return l.finish()
+}
+
+// loader groups entries into batches and writes them with a pool of workers
+type loader struct {
+	session filesystem.BulkSession
+	dest    string
+	opts    Options
+	started time.Time
+	batches chan []filesystem.BulkEntry
+	workers sync.WaitGroup
+
+	mu      sync.Mutex // protects the fields below
+	pending []filesystem.BulkEntry
+	size    int // bytes of data in pending
+	stats   Stats
+	err     error
+}
+
+// start begins a bulk load of dest, or a load with one Write per file if fs has no bulk support
+func start(fs filesystem.FileSystem, dest string, opts Options) (*loader, error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{dest: filesystem.NormalizePath(dest), opts: opts, started: time.Now()}
+
+	if bw, ok := fs.(filesystem.BulkWriter); ok {
+		l.session, err = bw.BeginBulk(l.dest, filesystem.BulkOptions{DeferIndexes: opts.DeferIndexes})
+		if err != nil && !errors.Is(err, filesystem.ErrNotSupported) {
+			return nil, err
+		}
+		l.stats.Bulk = err == nil
+	}
+	writers := opts.Workers
+	if l.session == nil {
+		if err := filesystem.MkdirAll(fs, l.dest, 0755); err != nil {
+			return nil, err
+		}
+		l.session = &fileSession{fs: fs}
+		// Concurrent MkdirAll calls would race for the same parents
+		writers = 1
+	}
+
+	l.batches = make(chan []filesystem.BulkEntry)
+	for i := 0; i < writers; i++ {
+		l.workers.Add(1)
+		go l.write()
+	}
+	return l, nil
+}
+
+// write stores batches until the channel is closed, skipping them after a failure
+func (l *loader) write() {
+	defer l.workers.Done()
+	for batch := range l.batches {
+		if l.error() != nil {
+			continue
+		}
+		if err := l.session.WriteBatch(batch); err != nil {
+			l.fail(err)
+			continue
+		}
+		l.mu.Lock()
+		l.stats.Batches++
+		for _, e := range batch {
+			if e.IsDir {
+				l.stats.Dirs++
+			} else {
+				l.stats.Files++
+				l.stats.Bytes += int64(len(e.Data))
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// add queues an entry and hands a full batch to the workers
+// It returns the first error of the ingest so that producers stop early
+func (l *loader) add(e filesystem.BulkEntry) error {
+	l.mu.Lock()
+	if err := l.err; err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.pending = append(l.pending, e)
+	l.size += len(e.Data)
+	var batch []filesystem.BulkEntry
+	if len(l.pending) >= l.opts.BatchSize || l.size >= batchBytes {
+		batch = l.pending
+		l.pending, l.size = nil, 0
+	}
+	l.mu.Unlock()
+
+	if batch != nil {
+		l.batches <- batch
+	}
+	return nil
+}
+
+// fail records the first error of the ingest
+func (l *loader) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// error returns the first error of the ingest
+func (l *loader) error() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// finish writes the last batch, waits for the workers and ends the session
+func (l *loader) finish() (Stats, error) {
+	l.mu.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(batch) > 0 {
+		l.batches <- batch
+	}
+	close(l.batches)
+	l.workers.Wait()
+
+	closeErr := l.session.Close()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stats.Seconds = time.Since(l.started).Seconds()
+	if l.err != nil {
+		return l.stats, l.err
+	}
+	return l.stats, closeErr
+}
+
+// fileSession writes the entries of a batch one by one, for targets without bulk support
+type fileSession struct {
+	fs filesystem.FileSystem
+}
+
+func (s *fileSession) WriteBatch(entries []filesystem.BulkEntry) error {
+	for _, e := range entries {
+		if e.IsDir {
+			if err := filesystem.MkdirAll(s.fs, e.Path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := filesystem.MkdirAll(s.fs, path.Dir(e.Path), 0755); err != nil {
+			return err
+		}
+		if _, err := s.fs.Write(e.Path, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileSession) Close() error {
+	return nil
+}
@@ -0,0 +1,117 @@
+package ingest
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+)
+
+func newTestFS(t *testing.T) *mountablefs.MountableFS {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("sqlfs", func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("sqlfs", "/sql", map[string]interface{}{"db_path": filepath.Join(t.TempDir(), "sqlfs.db")}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mfs.Unmount("/sql") })
+	return mfs
+}
+
+func read(mfs *mountablefs.MountableFS, p string) string {
+	data, err := mfs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func TestFromTree(t *testing.T) {
+	mfs := newTestFS(t)
+	const n = 120
+	for i := 0; i < n; i++ {
+		dir := fmt.Sprintf("/mem/src/d%d", i%7)
+		if err := filesystem.MkdirAll(mfs, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(fmt.Sprintf("%s/f%d", dir, i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mfs.Write("/mem/src/skip.tmp", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	filter, _ := archive.NewFilter("", "*.tmp")
+
+	stats, err := FromTree(mfs, "/mem/src", "/sql/dst", Options{BatchSize: 16, DeferIndexes: true, Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Bulk || stats.Files != n || stats.Dirs != 7 || stats.Batches < n/16 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if got := read(mfs, "/sql/dst/d3/f10"); got != "10" {
+		t.Errorf("/sql/dst/d3/f10 = %q", got)
+	}
+	if _, err := mfs.Stat("/sql/dst/skip.tmp"); err == nil {
+		t.Errorf("excluded file was ingested")
+	}
+
+	// Targets without bulk support get one write per file
+	stats, err = FromTree(mfs, "/sql/dst", "/mem/copy", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Bulk || stats.Files != n {
+		t.Errorf("fallback stats = %+v", stats)
+	}
+	if got := read(mfs, "/mem/copy/d3/f10"); got != "10" {
+		t.Errorf("/mem/copy/d3/f10 = %q", got)
+	}
+
+	if _, err := FromTree(mfs, "/mem/src", "/mem/src/inner", Options{}); err == nil {
+		t.Errorf("ingesting a tree into itself succeeded")
+	}
+}
+
+func TestFromTar(t *testing.T) {
+	mfs := newTestFS(t)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0700})
+	for _, name := range []string{"a/one", "a/b/two", "../escape"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(name))})
+		tw.Write([]byte(name))
+	}
+	tw.Close()
+
+	stats, err := FromTar(&buf, archive.FormatTar, mfs, "/sql/in", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 3 || stats.Dirs != 1 || stats.Batches != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if got := read(mfs, "/sql/in/a/b/two"); got != "a/b/two" {
+		t.Errorf("/sql/in/a/b/two = %q", got)
+	}
+	if got := read(mfs, "/sql/in/escape"); got != "../escape" {
+		t.Errorf("/sql/in/escape = %q", got)
+	}
+	if info, err := mfs.Stat("/sql/in/a"); err != nil || info.Mode != 0700 {
+		t.Errorf("Stat(/sql/in/a) = %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,23 @@
+package jobs
+
+import "sync"
+
+var (
+	defaultMu      sync.RWMutex
+	defaultManager *Manager
+)
+
+// SetDefault sets the manager used by components that cannot be handed one directly, such
+// as plugins created from a factory (e.g. jobsfs)
+func SetDefault(m *Manager) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultManager = m
+}
+
+// Default returns the manager set with SetDefault, or nil if none is set
+func Default() *Manager {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultManager
+}
@@ -0,0 +1,312 @@
+// Package jobs runs long administrative operations, such as removing or copying large trees,
+// in the background: each gets an ID to follow its progress by, can be canceled and may
+// notify a webhook when it ends
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// Job states reported in Status
+const (
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateFailed   = "failed"
+	StateCanceled = "canceled"
+)
+
+// Defaults of Options
+const (
+	DefaultRetain         = 100
+	DefaultWebhookTimeout = 10 * time.Second
+)
+
+// Status reports the operation and progress of a job
+type Status struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"` // Operation, e.g. "remove"
+	Path     string      `json:"path"` // Path the operation works on
+	Target   string      `json:"target,omitempty"`
+	State    string      `json:"state"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Done     int64       `json:"done"`            // Files (or other units of work) done
+	Total    int64       `json:"total,omitempty"` // Units of work in all, 0 while unknown
+	Bytes    int64       `json:"bytes,omitempty"` // Bytes processed
+	Result   interface{} `json:"result,omitempty"`
+	Webhook  string      `json:"webhook,omitempty"`
+}
+
+// Spec describes a job to start
+type Spec struct {
+	Type    string
+	Path    string
+	Target  string
+	Webhook string // Notified when the job ends instead of the webhook of the manager
+	// Context whose values, such as the principal of the request starting the job, the
+	// job's context carries; its cancellation does not cancel the job
+	Context context.Context
+}
+
+// Func runs the operation of a job, reporting to p, until it ends or ctx is canceled; a job
+// returning context.Canceled is reported as canceled
+type Func func(ctx context.Context, p *Progress) error
+
+// Progress is how a running job reports its progress
+type Progress struct {
+	j *job
+}
+
+// Add counts done units of work and bytes
+func (p *Progress) Add(done, bytes int64) {
+	p.j.mu.Lock()
+	defer p.j.mu.Unlock()
+	p.j.status.Done += done
+	p.j.status.Bytes += bytes
+}
+
+// Set replaces the progress, e.g. with that reported by another component
+func (p *Progress) Set(done, total, bytes int64) {
+	p.j.mu.Lock()
+	defer p.j.mu.Unlock()
+	p.j.status.Done, p.j.status.Total, p.j.status.Bytes = done, total, bytes
+}
+
+// SetResult sets the outcome reported with the status, e.g. a report of the operation
+func (p *Progress) SetResult(v interface{}) {
+	p.j.mu.Lock()
+	defer p.j.mu.Unlock()
+	p.j.status.Result = v
+}
+
+// job is a job and its running state
+type job struct {
+	mu     sync.Mutex // protects status
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the job and its webhook ended
+}
+
+func (j *job) snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Options configure a Manager
+type Options struct {
+	Webhook        string        // Notified with the status of every job that ends, "" for none
+	WebhookTimeout time.Duration // Default DefaultWebhookTimeout
+	Retain         int           // Ended jobs kept for status queries, default DefaultRetain
+}
+
+// Manager runs jobs and keeps the last ones that ended
+type Manager struct {
+	opts   Options
+	client *http.Client
+
+	mu   sync.Mutex // protects jobs
+	jobs map[string]*job
+}
+
+// NewManager creates a manager with opts, using defaults for unset options
+func NewManager(opts Options) *Manager {
+	if opts.WebhookTimeout <= 0 {
+		opts.WebhookTimeout = DefaultWebhookTimeout
+	}
+	if opts.Retain <= 0 {
+		opts.Retain = DefaultRetain
+	}
+	return &Manager{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.WebhookTimeout},
+		jobs:   make(map[string]*job),
+	}
+}
+
+// Scoped returns an empty manager of the same options, e.g. for the jobs of one tenant
+func (m *Manager) Scoped() *Manager {
+	return NewManager(m.opts)
+}
+
+// Start runs fn in the background as the job described by spec and returns its status
+func (m *Manager) Start(spec Spec, fn Func) Status {
+	parent := context.Background()
+	if spec.Context != nil {
+		parent = context.WithoutCancel(spec.Context)
+	}
+	ctx, cancel := context.WithCancel(parent)
+	j := &job{
+		status: Status{
+			ID:      uuid.NewString()[:8],
+			Type:    spec.Type,
+			Path:    spec.Path,
+			Target:  spec.Target,
+			State:   StateRunning,
+			Created: time.Now(),
+			Webhook: spec.Webhook,
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.jobs[j.status.ID] = j
+	m.mu.Unlock()
+	log.Infof("[jobs] job %s: %s %s started", j.status.ID, spec.Type, spec.Path)
+
+	go func() {
+		defer close(j.done)
+		err := fn(ctx, &Progress{j: j})
+
+		now := time.Now()
+		j.mu.Lock()
+		j.status.Finished = &now
+		switch {
+		case err == nil:
+			j.status.State = StateDone
+		case errors.Is(err, context.Canceled):
+			j.status.State = StateCanceled
+		default:
+			j.status.State = StateFailed
+			j.status.Error = err.Error()
+		}
+		status := j.status
+		j.mu.Unlock()
+		cancel()
+
+		if status.State == StateFailed {
+			log.Warnf("[jobs] job %s failed: %v", status.ID, err)
+		} else {
+			log.Infof("[jobs] job %s %s: %d done, %d bytes", status.ID, status.State, status.Done, status.Bytes)
+		}
+		m.prune()
+		m.notify(status)
+	}()
+	return j.snapshot()
+}
+
+// Get returns the status of a job
+func (m *Manager) Get(id string) (Status, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return j.snapshot(), nil
+}
+
+// List returns the status of every job, newest first
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	statuses := make([]Status, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		statuses = append(statuses, j.snapshot())
+	}
+	m.mu.Unlock()
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].Created.After(statuses[b].Created) })
+	return statuses
+}
+
+// Cancel stops a running job and waits for it to end; ended jobs are returned unchanged
+func (m *Manager) Cancel(id string) (Status, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Status{}, err
+	}
+	j.cancel()
+	<-j.done
+	return j.snapshot(), nil
+}
+
+// Close cancels the running jobs and waits for them to end
+func (m *Manager) Close() {
+	m.mu.Lock()
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+	for _, j := range jobs {
+		j.cancel()
+		<-j.done
+	}
+}
+
+func (m *Manager) job(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("job", id)
+	}
+	return j, nil
+}
+
+// prune drops the oldest ended jobs beyond the number retained
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ended []Status
+	for _, j := range m.jobs {
+		if s := j.snapshot(); s.State != StateRunning {
+			ended = append(ended, s)
+		}
+	}
+	if len(ended) <= m.opts.Retain {
+		return
+	}
+	sort.Slice(ended, func(a, b int) bool { return ended[a].Finished.Before(*ended[b].Finished) })
+	for _, s := range ended[:len(ended)-m.opts.Retain] {
+		delete(m.jobs, s.ID)
+	}
+}
+
+// notify posts the status of an ended job to its webhook, or to that of the manager
+func (m *Manager) notify(status Status) {
+	url := status.Webhook
+	if url == "" {
+		url = m.opts.Webhook
+	}
+	if url == "" {
+		return
+	}
+	if err := m.post(url, status); err != nil {
+		log.Warnf("[jobs] job %s: webhook %s failed: %v", status.ID, url, err)
+	}
+}
+
+func (m *Manager) post(url string, status Status) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-AGFS-Job", status.ID)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
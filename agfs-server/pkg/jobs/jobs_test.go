@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestJobDone(t *testing.T) {
+	m := NewManager(Options{})
+	status := m.Start(Spec{Type: "copy", Path: "/a"}, func(ctx context.Context, p *Progress) error {
+		p.Set(0, 2, 0)
+		p.Add(1, 10)
+		p.Add(1, 5)
+		p.SetResult("ok")
+		return nil
+	})
+	if status.State != StateRunning || status.ID == "" {
+		t.Fatalf("started job: %+v", status)
+	}
+	// Cancel waits for the job; an ended job is returned unchanged
+	status, err := m.Cancel(status.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StateDone || status.Done != 2 || status.Total != 2 || status.Bytes != 15 || status.Result != "ok" {
+		t.Errorf("ended job: %+v", status)
+	}
+	if _, err := m.Get("missing"); err == nil {
+		t.Error("Get of a missing job succeeded")
+	}
+}
+
+func TestJobCancelAndFailure(t *testing.T) {
+	m := NewManager(Options{})
+	started := make(chan struct{})
+	running := m.Start(Spec{Type: "remove", Path: "/big"}, func(ctx context.Context, p *Progress) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	failing := m.Start(Spec{Type: "copy", Path: "/a"}, func(ctx context.Context, p *Progress) error {
+		return errors.New("disk full")
+	})
+
+	status, err := m.Cancel(running.ID)
+	if err != nil || status.State != StateCanceled || status.Finished == nil {
+		t.Errorf("canceled job: %+v %v", status, err)
+	}
+	status, _ = m.Cancel(failing.ID)
+	if status.State != StateFailed || status.Error != "disk full" {
+		t.Errorf("failed job: %+v", status)
+	}
+	if list := m.List(); len(list) != 2 || list[0].ID != failing.ID {
+		t.Errorf("List = %+v", list)
+	}
+}
+
+func TestJobRetain(t *testing.T) {
+	m := NewManager(Options{Retain: 2})
+	for i := 0; i < 4; i++ {
+		s := m.Start(Spec{Type: "copy"}, func(ctx context.Context, p *Progress) error { return nil })
+		m.Cancel(s.ID)
+	}
+	if n := len(m.List()); n != 2 {
+		t.Errorf("%d jobs kept, want 2", n)
+	}
+}
+
+func TestJobWebhook(t *testing.T) {
+	received := make(chan Status, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status Status
+		json.NewDecoder(r.Body).Decode(&status)
+		if r.Header.Get("X-AGFS-Job") != status.ID {
+			t.Errorf("X-AGFS-Job = %q, want %q", r.Header.Get("X-AGFS-Job"), status.ID)
+		}
+		received <- status
+	}))
+	defer srv.Close()
+
+	m := NewManager(Options{Webhook: srv.URL + "/default"})
+	job := m.Start(Spec{Type: "scrub", Path: "/data"}, func(ctx context.Context, p *Progress) error {
+		p.Add(3, 0)
+		return nil
+	})
+	select {
+	case status := <-received:
+		if status.ID != job.ID || status.State != StateDone || status.Done != 3 {
+			t.Errorf("webhook got %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
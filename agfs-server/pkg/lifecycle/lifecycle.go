@@ -0,0 +1,471 @@
+// Package lifecycle applies age based rules to files: files older than a rule's threshold are
+// moved to a colder mount or deleted by a background engine, and every action is appended
+// to an audit log
+package lifecycle
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the lifecycle configuration
+const (
+	DefaultInterval = time.Hour
+	DefaultAuditLog = "lifecycle-audit.log"
+)
+
+// Rule actions
+const (
+	ActionMove   = "move"
+	ActionDelete = "delete"
+)
+
+// maxReportActions bounds the actions kept in a report; the audit log has all of them
+const maxReportActions = 100
+
+// ErrRunning is returned when a rule is evaluated while its previous run has not finished
+var ErrRunning = errors.New("lifecycle rule already running")
+
+// Action is a file moved or deleted by a rule, or that would be in a dry run
+type Action struct {
+	Time    time.Time `json:"time"`
+	Rule    string    `json:"rule"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path"`
+	Target  string    `json:"target,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	DryRun  bool      `json:"dryRun,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Report summarizes one evaluation of a rule
+type Report struct {
+	Rule      string    `json:"rule"`
+	DryRun    bool      `json:"dryRun"`
+	Started   time.Time `json:"started"`
+	Duration  string    `json:"duration"`
+	Scanned   int       `json:"scanned"` // Files below the rule's path passing its filter
+	Matched   int       `json:"matched"` // Files old enough for the action
+	Moved     int       `json:"moved"`
+	Deleted   int       `json:"deleted"`
+	Bytes     int64     `json:"bytes"` // Size of the matched files
+	Failed    int       `json:"failed"`
+	Error     string    `json:"error,omitempty"`
+	Actions   []Action  `json:"actions"`
+	Truncated bool      `json:"truncated,omitempty"` // More actions than the report keeps
+}
+
+// RuleStatus reports the configuration and the last run of a rule
+type RuleStatus struct {
+	Name      string  `json:"name"`
+	Path      string  `json:"path"`
+	OlderThan string  `json:"olderThan"`
+	Action    string  `json:"action"`
+	Target    string  `json:"target,omitempty"`
+	DryRun    bool    `json:"dryRun"`
+	Running   bool    `json:"running"`
+	LastRun   *Report `json:"lastRun,omitempty"`
+}
+
+// Status reports the engine and its rules
+type Status struct {
+	Interval string       `json:"interval"`
+	AuditLog string       `json:"auditLog"`
+	NextRun  *time.Time   `json:"nextRun,omitempty"`
+	Rules    []RuleStatus `json:"rules"`
+}
+
+// rule is a configured rule with its runtime state
+type rule struct {
+	cfg       config.LifecycleRule
+	olderThan time.Duration
+	filter    *archive.Filter
+
+	runMu   sync.Mutex // held while the rule runs
+	mu      sync.Mutex // protects last and running
+	last    *Report
+	running bool
+}
+
+// Engine evaluates lifecycle rules periodically and on demand
+type Engine struct {
+	fs       filesystem.FileSystem
+	rules    []*rule
+	interval time.Duration
+	auditLog string
+	now      func() time.Time
+
+	auditMu sync.Mutex // serializes audit log writes
+
+	mu      sync.Mutex // protects nextRun
+	nextRun *time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewEngine validates the lifecycle configuration and creates an engine
+// Rules read and write through fs, so sources and targets may live on any mount
+func NewEngine(fs filesystem.FileSystem, cfg config.LifecycleConfig) (*Engine, error) {
+	e := &Engine{
+		fs:       fs,
+		interval: DefaultInterval,
+		auditLog: cfg.AuditLog,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+	if e.auditLog == "" {
+		e.auditLog = DefaultAuditLog
+	}
+	if cfg.Interval != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("lifecycle: invalid interval: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("lifecycle: interval must be positive")
+		}
+		e.interval = d
+	}
+
+	names := make(map[string]bool)
+	for _, cfg := range cfg.Rules {
+		if !cfg.Enabled {
+			continue
+		}
+		r, err := newRule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("duplicate lifecycle rule name: %s", cfg.Name)
+		}
+		names[cfg.Name] = true
+		e.rules = append(e.rules, r)
+	}
+	return e, nil
+}
+
+func newRule(cfg config.LifecycleRule) (*rule, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("lifecycle rule name is required")
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("lifecycle rule %s: path is required", cfg.Name)
+	}
+	cfg.Path = filesystem.NormalizePath(cfg.Path)
+	if cfg.Path == "/" {
+		return nil, fmt.Errorf("lifecycle rule %s: path must not be the root", cfg.Name)
+	}
+
+	switch cfg.Action {
+	case ActionMove:
+		if cfg.Target == "" {
+			return nil, fmt.Errorf("lifecycle rule %s: target is required for move", cfg.Name)
+		}
+		cfg.Target = filesystem.NormalizePath(cfg.Target)
+		if cfg.Target == cfg.Path || strings.HasPrefix(cfg.Target, cfg.Path+"/") || strings.HasPrefix(cfg.Path, cfg.Target+"/") {
+			return nil, fmt.Errorf("lifecycle rule %s: path and target must not contain each other", cfg.Name)
+		}
+	case ActionDelete:
+		if cfg.Target != "" {
+			return nil, fmt.Errorf("lifecycle rule %s: target is only used by move", cfg.Name)
+		}
+	default:
+		return nil, fmt.Errorf("lifecycle rule %s: action must be %q or %q", cfg.Name, ActionMove, ActionDelete)
+	}
+
+	if cfg.OlderThan == "" {
+		return nil, fmt.Errorf("lifecycle rule %s: older_than is required", cfg.Name)
+	}
+	olderThan, err := pluginconfig.ParseDuration(cfg.OlderThan)
+	if err != nil {
+		return nil, fmt.Errorf("lifecycle rule %s: invalid older_than: %w", cfg.Name, err)
+	}
+	if olderThan <= 0 {
+		return nil, fmt.Errorf("lifecycle rule %s: older_than must be positive", cfg.Name)
+	}
+
+	filter, err := archive.NewFilter(cfg.Include, cfg.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("lifecycle rule %s: %w", cfg.Name, err)
+	}
+	return &rule{cfg: cfg, olderThan: olderThan, filter: filter}, nil
+}
+
+// Start launches the evaluation loop
+func (e *Engine) Start() {
+	if len(e.rules) == 0 {
+		return
+	}
+	e.wg.Add(1)
+	go e.loop()
+	log.Infof("[lifecycle] engine started with %d rule(s), evaluated every %s", len(e.rules), e.interval)
+}
+
+// Stop stops the loop and waits for it to exit
+// A rule that is already running is allowed to finish
+func (e *Engine) Stop() {
+	close(e.done)
+	e.wg.Wait()
+}
+
+func (e *Engine) loop() {
+	defer e.wg.Done()
+	for {
+		next := time.Now().Add(e.interval)
+		e.mu.Lock()
+		e.nextRun = &next
+		e.mu.Unlock()
+
+		timer := time.NewTimer(e.interval)
+		select {
+		case <-e.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		for _, r := range e.rules {
+			if _, err := e.runRule(r, r.cfg.DryRun); err != nil && !errors.Is(err, ErrRunning) {
+				log.Errorf("[lifecycle] rule %s failed: %v", r.cfg.Name, err)
+			}
+		}
+	}
+}
+
+// Run evaluates a rule immediately and waits for it to finish; an empty name runs every rule
+// With dryRun nothing is changed, otherwise each rule's own dry_run setting applies
+func (e *Engine) Run(name string, dryRun bool) ([]Report, error) {
+	var reports []Report
+	found := false
+	for _, r := range e.rules {
+		if name != "" && r.cfg.Name != name {
+			continue
+		}
+		found = true
+		report, err := e.runRule(r, dryRun || r.cfg.DryRun)
+		if err != nil && name != "" {
+			return []Report{report}, err
+		}
+		reports = append(reports, report)
+	}
+	if !found && name != "" {
+		return nil, filesystem.NewNotFoundError("lifecycle rule", name)
+	}
+	return reports, nil
+}
+
+// Status returns the status of the engine and every rule
+func (e *Engine) Status() Status {
+	status := Status{Interval: e.interval.String(), AuditLog: e.auditLog, Rules: make([]RuleStatus, 0, len(e.rules))}
+	e.mu.Lock()
+	status.NextRun = e.nextRun
+	e.mu.Unlock()
+	for _, r := range e.rules {
+		r.mu.Lock()
+		status.Rules = append(status.Rules, RuleStatus{
+			Name:      r.cfg.Name,
+			Path:      r.cfg.Path,
+			OlderThan: r.cfg.OlderThan,
+			Action:    r.cfg.Action,
+			Target:    r.cfg.Target,
+			DryRun:    r.cfg.DryRun,
+			Running:   r.running,
+			LastRun:   r.last,
+		})
+		r.mu.Unlock()
+	}
+	return status
+}
+
+// candidate is a file matched by a rule
+type candidate struct {
+	path string
+	rel  string
+	info filesystem.FileInfo
+}
+
+// runRule evaluates r: files are collected first and then acted on, so that the walk never
+// sees its own changes
+func (e *Engine) runRule(r *rule, dryRun bool) (Report, error) {
+	if !r.runMu.TryLock() {
+		return Report{Rule: r.cfg.Name}, ErrRunning
+	}
+	defer r.runMu.Unlock()
+	r.setRunning(true)
+	defer r.setRunning(false)
+
+	began := time.Now()
+	start := e.now()
+	report := Report{Rule: r.cfg.Name, DryRun: dryRun, Started: start, Actions: []Action{}}
+	cutoff := start.Add(-r.olderThan)
+
+	var matched []candidate
+	err := filesystem.Walk(e.fs, r.cfg.Path, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, r.cfg.Path), "/")
+		if r.filter.Excluded(rel) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir || rel == "" || !r.filter.Included(rel) {
+			return nil
+		}
+		report.Scanned++
+		if info.ModTime.Before(cutoff) {
+			matched = append(matched, candidate{path: p, rel: rel, info: *info})
+		}
+		return nil
+	})
+	if err != nil && filesystem.IsNotFound(err) {
+		// Nothing to do until the directory exists
+		err = nil
+	}
+
+	if err == nil {
+		for _, c := range matched {
+			action := Action{
+				Time:    e.now(),
+				Rule:    r.cfg.Name,
+				Action:  r.cfg.Action,
+				Path:    c.path,
+				Size:    c.info.Size,
+				ModTime: c.info.ModTime,
+				DryRun:  dryRun,
+			}
+			if r.cfg.Action == ActionMove {
+				action.Target = path.Join(r.cfg.Target, c.rel)
+			}
+			report.Matched++
+			report.Bytes += c.info.Size
+
+			if !dryRun {
+				if aerr := e.apply(action); aerr != nil {
+					action.Error = aerr.Error()
+					report.Failed++
+					log.Warnf("[lifecycle] rule %s: %s %s failed: %v", r.cfg.Name, action.Action, action.Path, aerr)
+				} else if action.Action == ActionMove {
+					report.Moved++
+				} else {
+					report.Deleted++
+				}
+				if aerr := e.audit(action); aerr != nil {
+					log.Errorf("[lifecycle] failed to write audit log: %v", aerr)
+				}
+			}
+			if len(report.Actions) < maxReportActions {
+				report.Actions = append(report.Actions, action)
+			} else {
+				report.Truncated = true
+			}
+		}
+	}
+
+	report.Duration = time.Since(began).Round(time.Millisecond).String()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.last = &report
+	r.mu.Unlock()
+	if !dryRun && report.Matched > 0 {
+		log.Infof("[lifecycle] rule %s: %d moved, %d deleted, %d failed", r.cfg.Name, report.Moved, report.Deleted, report.Failed)
+	}
+	return report, err
+}
+
+func (r *rule) setRunning(running bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = running
+}
+
+// apply moves or deletes the file of a
+// A moved file is copied and checked before the original is removed, so a failure
+// never loses data; it may leave a copy behind that the next run overwrites
+func (e *Engine) apply(a Action) error {
+	if a.Action == ActionMove {
+		if err := filesystem.MkdirAll(e.fs, path.Dir(a.Target), 0755); err != nil {
+			return err
+		}
+		n, err := filesystem.CopyFile(e.fs, a.Path, e.fs, a.Target)
+		if err != nil {
+			return err
+		}
+		info, err := e.fs.Stat(a.Target)
+		if err != nil {
+			return err
+		}
+		if info.Size != n {
+			return fmt.Errorf("copy of %s has %d bytes, expected %d", a.Path, info.Size, n)
+		}
+	}
+	return e.fs.Remove(a.Path)
+}
+
+// audit appends a to the audit log
+func (e *Engine) audit(a Action) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	e.auditMu.Lock()
+	defer e.auditMu.Unlock()
+	f, err := os.OpenFile(e.auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadAudit returns the last n actions of the audit log, oldest first; n <= 0 returns all
+func (e *Engine) ReadAudit(n int) ([]Action, error) {
+	e.auditMu.Lock()
+	defer e.auditMu.Unlock()
+	f, err := os.Open(e.auditLog)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Action{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	actions := []Action{}
+	dec := json.NewDecoder(f)
+	for {
+		var a Action
+		if err := dec.Decode(&a); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid audit log %s: %w", e.auditLog, err)
+		}
+		actions = append(actions, a)
+		if n > 0 && len(actions) > n {
+			actions = actions[1:]
+		}
+	}
+	return actions, nil
+}
@@ -0,0 +1,119 @@
+package lifecycle
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestFS(t *testing.T) *mountablefs.MountableFS {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	for _, p := range []string{"/hot", "/cold"} {
+		if err := mfs.MountPlugin("memfs", p, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{"/hot/logs/a.log": "aa", "/hot/logs/2024/b.log": "bbb", "/hot/logs/keep.txt": "k", "/hot/scratch/x": "x"} {
+		if err := filesystem.MkdirAll(mfs, filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return mfs
+}
+
+func TestRules(t *testing.T) {
+	mfs := newTestFS(t)
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	e, err := NewEngine(mfs, config.LifecycleConfig{AuditLog: auditLog, Rules: []config.LifecycleRule{
+		{Name: "tier", Enabled: true, Path: "/hot/logs", OlderThan: "7d", Action: ActionMove, Target: "/cold/logs", Include: "*.log"},
+		{Name: "expire", Enabled: true, Path: "/hot/scratch", OlderThan: "1d", Action: ActionDelete},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fresh files are left alone
+	reports, err := e.Run("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Scanned != 2 || reports[0].Matched != 0 || reports[1].Matched != 0 {
+		t.Fatalf("reports = %+v", reports)
+	}
+
+	// A dry run eight days later reports without changing anything
+	e.now = func() time.Time { return time.Now().Add(8 * 24 * time.Hour) }
+	reports, err = e.Run("tier", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := reports[0]; !r.DryRun || r.Matched != 2 || r.Moved != 0 || r.Bytes != 5 || len(r.Actions) != 2 {
+		t.Errorf("dry run = %+v", r)
+	}
+	if _, err := mfs.Stat("/hot/logs/a.log"); err != nil {
+		t.Errorf("dry run moved a file: %v", err)
+	}
+
+	reports, err = e.Run("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[0].Moved != 2 || reports[1].Deleted != 1 || reports[0].Failed+reports[1].Failed != 0 {
+		t.Errorf("reports = %+v", reports)
+	}
+	data, err := mfs.Read("/cold/logs/2024/b.log", 0, -1)
+	if (err != nil && err != io.EOF) || string(data) != "bbb" {
+		t.Errorf("moved file = %q, %v", data, err)
+	}
+	for _, p := range []string{"/hot/logs/2024/b.log", "/hot/scratch/x"} {
+		if _, err := mfs.Stat(p); err == nil {
+			t.Errorf("%s still exists", p)
+		}
+	}
+	if _, err := mfs.Stat("/hot/logs/keep.txt"); err != nil {
+		t.Errorf("file outside the filter was touched: %v", err)
+	}
+
+	// Real actions are audited, dry runs are not
+	actions, err := e.ReadAudit(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 3 || actions[2].Action != ActionDelete || actions[2].Path != "/hot/scratch/x" {
+		t.Errorf("audit = %+v", actions)
+	}
+	if last, _ := e.ReadAudit(1); len(last) != 1 || last[0].Path != "/hot/scratch/x" {
+		t.Errorf("last audit entry = %+v", last)
+	}
+
+	if _, err := e.Run("missing", false); !filesystem.IsNotFound(err) {
+		t.Errorf("running an unknown rule: %v", err)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []config.LifecycleRule{
+		{Name: "a", Path: "/hot", OlderThan: "1d", Action: ActionMove},
+		{Name: "b", Path: "/hot", OlderThan: "1d", Action: ActionMove, Target: "/hot/archive"},
+		{Name: "c", Path: "/hot", OlderThan: "soon", Action: ActionDelete},
+		{Name: "d", Path: "/hot", OlderThan: "1d", Action: "archive"},
+		{Name: "e", Path: "/", OlderThan: "1d", Action: ActionDelete},
+	} {
+		rule.Enabled = true
+		if _, err := NewEngine(nil, config.LifecycleConfig{Rules: []config.LifecycleRule{rule}}); err == nil {
+			t.Errorf("rule %s was accepted", rule.Name)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestServer(t *testing.T, cfg config.MCPConfig) *Server {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS()
+	for _, p := range []string{"/public", "/private"} {
+		plugin := memfs.NewMemFSPlugin()
+		plugin.Initialize(map[string]interface{}{})
+		if err := mfs.Mount(p, plugin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mfs.Write("/private/secret", []byte("s3cret"))
+	mfs.Write("/public/notes.txt", []byte("0123456789"))
+
+	s, err := New(cfg, mfs, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// callTool runs a tool through the stdio transport and returns its text and error flag
+func callTool(t *testing.T, s *Server, name string, args map[string]interface{}) (string, bool) {
+	t.Helper()
+	params, _ := json.Marshal(map[string]interface{}{"name": name, "arguments": args})
+	in := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":%s}`+"\n", params)
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Result struct {
+			Content []struct{ Text string } `json:"content"`
+			IsError bool                    `json:"isError"`
+		} `json:"result"`
+		Error *rpcError `json:"error"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("response %q: %v", out.String(), err)
+	}
+	if resp.Error != nil {
+		return resp.Error.Message, true
+	}
+	return resp.Result.Content[0].Text, resp.Result.IsError
+}
+
+func TestInitializeAndList(t *testing.T) {
+	s := newTestServer(t, config.MCPConfig{ReadOnly: true})
+	in := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}
+{"jsonrpc":"2.0","method":"notifications/initialized"}
+{"jsonrpc":"2.0","id":2,"method":"tools/list"}
+`
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d responses, want 2 (notifications are not answered): %s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[0], `"protocolVersion":"2024-11-05"`) {
+		t.Errorf("initialize = %s", lines[0])
+	}
+	// Read-only servers do not offer writing tools
+	if !strings.Contains(lines[1], `"read_file"`) || strings.Contains(lines[1], `"write_file"`) {
+		t.Errorf("tools/list = %s", lines[1])
+	}
+	if _, isErr := callTool(t, s, "write_file", map[string]interface{}{"path": "/public/a", "content": "x"}); !isErr {
+		t.Errorf("write_file succeeded on a read-only server")
+	}
+}
+
+func TestAllowList(t *testing.T) {
+	s := newTestServer(t, config.MCPConfig{Allow: []string{"/public"}})
+
+	// The root only shows the way to allowed paths
+	text, isErr := callTool(t, s, "list_directory", map[string]interface{}{"path": "/"})
+	if isErr || !strings.Contains(text, "public") || strings.Contains(text, "private") {
+		t.Errorf("list / = %s", text)
+	}
+	if text, isErr := callTool(t, s, "read_file", map[string]interface{}{"path": "/private/secret"}); !isErr {
+		t.Errorf("read of a path outside the allow list = %s", text)
+	}
+	if text, isErr := callTool(t, s, "read_file", map[string]interface{}{"path": "/public/../private/secret"}); !isErr {
+		t.Errorf("read escaping the allow list = %s", text)
+	}
+	if text, isErr := callTool(t, s, "write_file", map[string]interface{}{"path": "/public/new.txt", "content": "hi"}); isErr {
+		t.Errorf("write_file: %s", text)
+	}
+	if text, _ := callTool(t, s, "search", map[string]interface{}{"query": "new"}); !strings.Contains(text, "/public/new.txt") {
+		t.Errorf("search = %s", text)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	s := newTestServer(t, config.MCPConfig{MaxReadSize: "4", MaxWriteSize: "8"})
+
+	text, _ := callTool(t, s, "read_file", map[string]interface{}{"path": "/public/notes.txt", "offset": 2})
+	if !strings.HasPrefix(text, "2345\n") || !strings.Contains(text, "offset 6") {
+		t.Errorf("read = %q", text)
+	}
+	if text, isErr := callTool(t, s, "write_file", map[string]interface{}{"path": "/public/a", "content": "123456789"}); !isErr {
+		t.Errorf("write above the limit = %s", text)
+	}
+}
@@ -0,0 +1,251 @@
+// Package mcp serves the file system to AI agents over the Model Context Protocol
+// Agents get tools to list, read, write and search files, confined to the allowed paths
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the MCP configuration
+const (
+	DefaultMaxReadSize  = 256 * 1024
+	DefaultMaxWriteSize = 1024 * 1024
+)
+
+// Transports of the MCP server
+const (
+	TransportSSE   = "sse"
+	TransportStdio = "stdio"
+)
+
+// protocolVersions are the MCP revisions the server speaks, newest first
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// request is a JSON-RPC request or notification (without ID)
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Server answers MCP requests with tools operating on a file system
+type Server struct {
+	fs           filesystem.FileSystem
+	version      string
+	transport    string
+	allow        []string // Normalized allowed paths
+	readOnly     bool
+	maxReadSize  int64
+	maxWriteSize int64
+}
+
+// New creates an MCP server on fs from cfg; version is reported to clients
+func New(cfg config.MCPConfig, fs filesystem.FileSystem, version string) (*Server, error) {
+	s := &Server{
+		fs:           fs,
+		version:      version,
+		transport:    cfg.Transport,
+		readOnly:     cfg.ReadOnly,
+		maxReadSize:  DefaultMaxReadSize,
+		maxWriteSize: DefaultMaxWriteSize,
+	}
+	if s.transport == "" {
+		s.transport = TransportSSE
+	}
+	if s.transport != TransportSSE && s.transport != TransportStdio {
+		return nil, fmt.Errorf("mcp: invalid transport %q (supported: %s, %s)", cfg.Transport, TransportSSE, TransportStdio)
+	}
+
+	allow := cfg.Allow
+	if allow == nil {
+		allow = []string{"/"}
+	}
+	for _, p := range allow {
+		s.allow = append(s.allow, filesystem.NormalizePath(p))
+	}
+
+	var err error
+	if cfg.MaxReadSize != "" {
+		if s.maxReadSize, err = pluginconfig.ParseSize(cfg.MaxReadSize); err != nil || s.maxReadSize <= 0 {
+			return nil, fmt.Errorf("mcp: invalid max_read_size: %s", cfg.MaxReadSize)
+		}
+	}
+	if cfg.MaxWriteSize != "" {
+		if s.maxWriteSize, err = pluginconfig.ParseSize(cfg.MaxWriteSize); err != nil || s.maxWriteSize <= 0 {
+			return nil, fmt.Errorf("mcp: invalid max_write_size: %s", cfg.MaxWriteSize)
+		}
+	}
+	return s, nil
+}
+
+// Transport returns the configured transport
+func (s *Server) Transport() string {
+	return s.transport
+}
+
+// ServeStdio answers requests read from r, one JSON message per line, writing the
+// responses to w until r ends
+func (s *Server) ServeStdio(ctx context.Context, r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	// Escaping can grow written content up to six times in JSON
+	scanner.Buffer(make([]byte, 64*1024), int(s.maxWriteSize)*6+64*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if resp := s.handleMessage(ctx, []byte(line)); resp != nil {
+			if err := enc.Encode(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// handleMessage answers one JSON-RPC message; notifications have no response
+func (s *Server) handleMessage(ctx context.Context, data []byte) *response {
+	var req request
+	if err := json.Unmarshal(data, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: codeParseError, Message: "parse error"}}
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		if req.ID == nil {
+			return nil
+		}
+		return &response{JSONRPC: "2.0", ID: req.ID, Error: &rpcError{Code: codeInvalidRequest, Message: "invalid request"}}
+	}
+
+	result, rpcErr := s.dispatch(ctx, req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	resp := &response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+	if rpcErr == nil && result == nil {
+		resp.Result = struct{}{}
+	}
+	return resp
+}
+
+// dispatch runs the method of a request
+func (s *Server) dispatch(ctx context.Context, method string, params json.RawMessage) (interface{}, *rpcError) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(params, &p)
+		version := protocolVersions[0]
+		for _, v := range protocolVersions {
+			if v == p.ProtocolVersion {
+				version = v
+			}
+		}
+		return map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{"listChanged": false}},
+			"serverInfo":      map[string]string{"name": "agfs", "version": s.version},
+			"instructions":    s.instructions(),
+		}, nil
+	case "ping", "notifications/initialized", "notifications/cancelled":
+		return nil, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": s.tools()}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "invalid params"}
+		}
+		t, ok := s.tool(p.Name)
+		if !ok {
+			return nil, &rpcError{Code: codeInvalidParams, Message: "unknown tool: " + p.Name}
+		}
+		if len(p.Arguments) == 0 {
+			p.Arguments = json.RawMessage("{}")
+		}
+		text, err := t.call(s, filesystem.WithContext(s.fs, ctx), p.Arguments)
+		if err != nil {
+			log.Debugf("[mcp] %s failed: %v", p.Name, err)
+			return toolResult(err.Error(), true), nil
+		}
+		return toolResult(text, false), nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + method}
+}
+
+// instructions describes the server to the agent
+func (s *Server) instructions() string {
+	mode := "read-write"
+	if s.readOnly {
+		mode = "read-only"
+	}
+	return fmt.Sprintf("AGFS file system (%s). Accessible paths: %s. Reads return at most %d bytes, use offset to read further.",
+		mode, strings.Join(s.allow, ", "), s.maxReadSize)
+}
+
+func toolResult(text string, isError bool) map[string]interface{} {
+	return map[string]interface{}{
+		"content": []map[string]string{{"type": "text", "text": text}},
+		"isError": isError,
+	}
+}
+
+// allowed checks if the tools may access p
+func (s *Server) allowed(p string) bool {
+	for _, a := range s.allow {
+		if a == "/" || p == a || strings.HasPrefix(p, a+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// visible checks if p is allowed or a directory leading to an allowed path, which may
+// be listed so that agents can find the allowed paths
+func (s *Server) visible(p string) bool {
+	if s.allowed(p) {
+		return true
+	}
+	for _, a := range s.allow {
+		if p == "/" || strings.HasPrefix(a, p+"/") {
+			return true
+		}
+	}
+	return false
+}
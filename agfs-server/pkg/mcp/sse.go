@@ -0,0 +1,130 @@
+package mcp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sseKeepAlive is how often idle event streams get a comment so proxies keep them open
+const sseKeepAlive = 30 * time.Second
+
+// maxPendingMessages bounds the responses waiting to be sent on an event stream
+const maxPendingMessages = 64
+
+// sseSessions are the clients connected with the SSE transport
+type sseSessions struct {
+	mu       sync.Mutex
+	sessions map[string]chan []byte
+}
+
+// Handler returns the handler of the SSE transport, which serves the event stream at
+// <prefix>/sse and receives messages posted to <prefix>/message
+func (s *Server) Handler(prefix string) http.Handler {
+	sessions := &sseSessions{sessions: make(map[string]chan []byte)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/sse", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveEvents(w, r, sessions, prefix+"/message")
+	})
+	mux.HandleFunc(prefix+"/message", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.receiveMessage(w, r, sessions)
+	})
+	return mux
+}
+
+// serveEvents streams the responses of a new session, starting with the endpoint the
+// client posts its messages to
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request, sessions *sseSessions, endpoint string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sessionID := hex.EncodeToString(id)
+	messages := make(chan []byte, maxPendingMessages)
+	sessions.mu.Lock()
+	sessions.sessions[sessionID] = messages
+	sessions.mu.Unlock()
+	defer func() {
+		sessions.mu.Lock()
+		delete(sessions.sessions, sessionID)
+		sessions.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: endpoint\ndata: %s?sessionId=%s\n\n", endpoint, sessionID)
+	flusher.Flush()
+	log.Debugf("[mcp] SSE session %s opened by %s", sessionID, r.RemoteAddr)
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Debugf("[mcp] SSE session %s closed", sessionID)
+			return
+		case msg := <-messages:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// receiveMessage answers a message posted by the client of a session on its event stream
+func (s *Server) receiveMessage(w http.ResponseWriter, r *http.Request, sessions *sseSessions) {
+	sessions.mu.Lock()
+	messages, ok := sessions.sessions[r.URL.Query().Get("sessionId")]
+	sessions.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, s.maxWriteSize*6+64*1024))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	resp := s.handleMessage(r.Context(), data)
+	if resp != nil {
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case messages <- encoded:
+		default:
+			http.Error(w, "too many pending messages", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
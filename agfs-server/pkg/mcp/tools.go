@@ -0,0 +1,346 @@
+package mcp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+)
+
+// maxSearchResults bounds the results of a search
+const maxSearchResults = 100
+
+// maxSearchEntries bounds the entries visited by a search without index
+const maxSearchEntries = 10000
+
+// tool is an MCP tool
+type tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+	writes      bool                   // Changes the file system, not offered in read-only mode
+	call        func(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error)
+}
+
+// schema returns a JSON schema of an object with properties, the required ones first
+func schema(required []string, props map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "object", "properties": props, "required": required}
+}
+
+func prop(typ, description string) map[string]interface{} {
+	return map[string]interface{}{"type": typ, "description": description}
+}
+
+var allTools = []*tool{
+	{
+		Name:        "list_directory",
+		Description: "List the entries of a directory with their size, type and modification time",
+		InputSchema: schema([]string{"path"}, map[string]interface{}{"path": prop("string", "Absolute directory path, e.g. /memfs")}),
+		call:        listDirectory,
+	},
+	{
+		Name:        "stat",
+		Description: "Get the size, mode, type and modification time of a file or directory",
+		InputSchema: schema([]string{"path"}, map[string]interface{}{"path": prop("string", "Absolute path")}),
+		call:        stat,
+	},
+	{
+		Name:        "read_file",
+		Description: "Read a file; long files are returned in parts, continue at the reported offset",
+		InputSchema: schema([]string{"path"}, map[string]interface{}{
+			"path":   prop("string", "Absolute file path"),
+			"offset": prop("integer", "Byte offset to start at (default 0)"),
+			"size":   prop("integer", "Bytes to read (default and maximum: the server's read limit)"),
+		}),
+		call: readFile,
+	},
+	{
+		Name:        "search",
+		Description: "Find files by name and content; terms may use * wildcards, name:term only matches names",
+		InputSchema: schema([]string{"query"}, map[string]interface{}{
+			"query": prop("string", "Search terms"),
+			"path":  prop("string", "Only return files below this directory (default all)"),
+		}),
+		call: searchFiles,
+	},
+	{
+		Name:        "write_file",
+		Description: "Write text to a file, replacing its content and creating it if needed",
+		InputSchema: schema([]string{"path", "content"}, map[string]interface{}{
+			"path":    prop("string", "Absolute file path"),
+			"content": prop("string", "New content of the file"),
+		}),
+		writes: true,
+		call:   writeFile,
+	},
+	{
+		Name:        "make_directory",
+		Description: "Create a directory",
+		InputSchema: schema([]string{"path"}, map[string]interface{}{"path": prop("string", "Absolute directory path")}),
+		writes:      true,
+		call:        makeDirectory,
+	},
+	{
+		Name:        "remove",
+		Description: "Remove a file or an empty directory, or a directory with its contents if recursive is set",
+		InputSchema: schema([]string{"path"}, map[string]interface{}{
+			"path":      prop("string", "Absolute path"),
+			"recursive": prop("boolean", "Also remove the contents of a directory"),
+		}),
+		writes: true,
+		call:   remove,
+	},
+}
+
+// tools returns the tools offered to clients
+func (s *Server) tools() []*tool {
+	var tools []*tool
+	for _, t := range allTools {
+		if !t.writes || !s.readOnly {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+// tool returns the offered tool called name
+func (s *Server) tool(name string) (*tool, bool) {
+	for _, t := range s.tools() {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// pathArgs are the arguments shared by the tools
+type pathArgs struct {
+	Path      string `json:"path"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+	Content   string `json:"content"`
+	Recursive bool   `json:"recursive"`
+	Query     string `json:"query"`
+}
+
+// parseArgs decodes args and checks that path is allowed, or only visible if listing is set
+func (s *Server) parseArgs(args json.RawMessage, listing bool) (pathArgs, error) {
+	var a pathArgs
+	if err := json.Unmarshal(args, &a); err != nil {
+		return a, fmt.Errorf("invalid arguments: %v", err)
+	}
+	if a.Path == "" {
+		return a, fmt.Errorf("path is required")
+	}
+	a.Path = filesystem.NormalizePath(a.Path)
+	if !s.allowed(a.Path) && !(listing && s.visible(a.Path)) {
+		return a, fmt.Errorf("access to %s is not allowed", a.Path)
+	}
+	return a, nil
+}
+
+// entry is a file or directory in tool results
+type entry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path,omitempty"`
+	Size    int64  `json:"size"`
+	IsDir   bool   `json:"isDir"`
+	Mode    string `json:"mode"`
+	ModTime string `json:"modTime"`
+}
+
+func newEntry(info *filesystem.FileInfo, p string) entry {
+	return entry{
+		Name:    info.Name,
+		Path:    p,
+		Size:    info.Size,
+		IsDir:   info.IsDir,
+		Mode:    fmt.Sprintf("%o", info.Mode),
+		ModTime: info.ModTime.Format(time.RFC3339),
+	}
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	return string(data), err
+}
+
+func listDirectory(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, true)
+	if err != nil {
+		return "", err
+	}
+	infos, err := fs.ReadDir(a.Path)
+	if err != nil {
+		return "", err
+	}
+	entries := []entry{}
+	for i := range infos {
+		p := strings.TrimSuffix(a.Path, "/") + "/" + infos[i].Name
+		if s.visible(p) {
+			entries = append(entries, newEntry(&infos[i], ""))
+		}
+	}
+	return toJSON(entries)
+}
+
+func stat(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, true)
+	if err != nil {
+		return "", err
+	}
+	info, err := fs.Stat(a.Path)
+	if err != nil {
+		return "", err
+	}
+	return toJSON(newEntry(info, a.Path))
+}
+
+func readFile(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, false)
+	if err != nil {
+		return "", err
+	}
+	if a.Offset < 0 || a.Size < 0 {
+		return "", fmt.Errorf("offset and size must not be negative")
+	}
+	size := a.Size
+	if size == 0 || size > s.maxReadSize {
+		size = s.maxReadSize
+	}
+	// One byte more tells if the file continues
+	data, err := fs.Read(a.Path, a.Offset, size+1)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	more := int64(len(data)) > size
+	if more {
+		data = data[:size]
+	}
+
+	var text string
+	if utf8.Valid(data) {
+		text = string(data)
+	} else {
+		text = "[binary content, base64 encoded]\n" + base64.StdEncoding.EncodeToString(data)
+	}
+	if more {
+		text += fmt.Sprintf("\n[truncated: the file continues at offset %d]", a.Offset+size)
+	}
+	return text, nil
+}
+
+func writeFile(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, false)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(a.Content)) > s.maxWriteSize {
+		return "", fmt.Errorf("content of %d bytes exceeds the write limit of %d bytes", len(a.Content), s.maxWriteSize)
+	}
+	if _, err := fs.Write(a.Path, []byte(a.Content)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("wrote %d bytes to %s", len(a.Content), a.Path), nil
+}
+
+func makeDirectory(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, false)
+	if err != nil {
+		return "", err
+	}
+	if err := fs.Mkdir(a.Path, 0755); err != nil {
+		return "", err
+	}
+	return "created " + a.Path, nil
+}
+
+func remove(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	a, err := s.parseArgs(args, false)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range s.allow {
+		if a.Path == allowed {
+			return "", fmt.Errorf("%s is an allowed root and cannot be removed", a.Path)
+		}
+	}
+	if a.Recursive {
+		err = fs.RemoveAll(a.Path)
+	} else {
+		err = fs.Remove(a.Path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "removed " + a.Path, nil
+}
+
+// searchFiles uses the search index if it is enabled, and matches file names otherwise
+func searchFiles(s *Server, fs filesystem.FileSystem, args json.RawMessage) (string, error) {
+	var a pathArgs
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	if strings.TrimSpace(a.Query) == "" {
+		return "", fmt.Errorf("query is required")
+	}
+	within := "/"
+	if a.Path != "" {
+		within = filesystem.NormalizePath(a.Path)
+		if !s.visible(within) {
+			return "", fmt.Errorf("access to %s is not allowed", within)
+		}
+	}
+
+	results := []entry{}
+	if indexer := search.Default(); indexer != nil {
+		for _, hit := range indexer.Index().Search(a.Query, within, 0) {
+			if s.allowed(hit.Path) {
+				results = append(results, entry{Name: hit.Path[strings.LastIndex(hit.Path, "/")+1:], Path: hit.Path,
+					Size: hit.Size, ModTime: hit.ModTime.Format(time.RFC3339)})
+			}
+			if len(results) == maxSearchResults {
+				break
+			}
+		}
+		return toJSON(results)
+	}
+
+	terms := strings.Fields(strings.ToLower(a.Query))
+	visited := 0
+	errStop := errors.New("stop")
+	err := filesystem.Walk(fs, within, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if visited++; visited > maxSearchEntries || len(results) == maxSearchResults {
+			return errStop
+		}
+		if !s.visible(p) {
+			return filesystem.SkipDir
+		}
+		if !s.allowed(p) {
+			return nil
+		}
+		name := strings.ToLower(info.Name)
+		for _, term := range terms {
+			if !strings.Contains(name, strings.Trim(strings.TrimPrefix(term, "name:"), "*")) {
+				return nil
+			}
+		}
+		results = append(results, newEntry(info, p))
+		return nil
+	})
+	if err != nil && err != errStop {
+		return "", err
+	}
+	return toJSON(results)
+}
@@ -0,0 +1,445 @@
+// Package migrate moves the data of one mount to another, e.g. from memfs to sqlfs or between
+// two s3fs buckets, as background jobs that are throttled, verified and resumable
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/xxh3"
+)
+
+// Job states reported in Status
+const (
+	StateRunning     = "running"
+	StateDone        = "done"
+	StateFailed      = "failed"
+	StateCanceled    = "canceled"
+	StateInterrupted = "interrupted" // The server stopped while the job was running
+)
+
+// Phases of a running job
+const (
+	PhaseScanning = "scanning"
+	PhaseCopying  = "copying"
+)
+
+// DefaultStateDir is the local directory keeping checkpoints when none is configured
+const DefaultStateDir = "migrations"
+
+// checkpointInterval is how often the progress of a running job is saved
+const checkpointInterval = time.Second
+
+// ErrRunning is returned when a job is resumed while it is running
+var ErrRunning = errors.New("migration already running")
+
+// Request describes a migration to start
+type Request struct {
+	Source string `json:"source"`           // Directory or mount to copy, e.g. "/memfs"
+	Target string `json:"target"`           // Directory receiving the data, e.g. "/sqlfs"
+	Rate   string `json:"rate,omitempty"`   // Bytes per second, e.g. "20MB" (default unlimited)
+	Verify *bool  `json:"verify,omitempty"` // Read every file back and compare digests (default true)
+}
+
+// Status reports the configuration and progress of a job
+type Status struct {
+	ID         string     `json:"id"`
+	Source     string     `json:"source"`
+	Target     string     `json:"target"`
+	Rate       int64      `json:"rate,omitempty"`
+	Verify     bool       `json:"verify"`
+	State      string     `json:"state"`
+	Phase      string     `json:"phase,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Created    time.Time  `json:"created"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	TotalFiles int64      `json:"totalFiles"` // Found by the scan
+	TotalBytes int64      `json:"totalBytes"`
+	Files      int64      `json:"files"` // Copied, including the runs before a resume
+	Bytes      int64      `json:"bytes"`
+	Verified   int64      `json:"verified"`
+	Checkpoint string     `json:"checkpoint,omitempty"` // Last file copied, relative to the source
+}
+
+// job is a migration and its running state
+type job struct {
+	mu     sync.Mutex // protects status and cancel
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{} // Closed when the current run ends
+}
+
+func (j *job) snapshot() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Manager runs migrations in the file system and keeps their checkpoints in a local directory
+type Manager struct {
+	fs       filesystem.FileSystem
+	stateDir string // Empty keeps checkpoints in memory
+
+	mu   sync.Mutex // protects jobs
+	jobs map[string]*job
+}
+
+// NewManager creates a manager and loads the jobs saved in stateDir; jobs that were running
+// when the server stopped are reported as interrupted and can be resumed
+func NewManager(fs filesystem.FileSystem, stateDir string) (*Manager, error) {
+	m := &Manager{fs: fs, stateDir: stateDir, jobs: make(map[string]*job)}
+	if stateDir == "" {
+		return m, nil
+	}
+
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read migration state: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(stateDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration state: %w", err)
+		}
+		var status Status
+		if err := json.Unmarshal(data, &status); err != nil || status.ID == "" {
+			log.Warnf("[migrate] ignoring invalid state file %s", entry.Name())
+			continue
+		}
+		if status.State == StateRunning {
+			status.State = StateInterrupted
+			status.Phase = ""
+		}
+		m.jobs[status.ID] = &job{status: status}
+	}
+	return m, nil
+}
+
+// Start validates req and starts copying in the background
+func (m *Manager) Start(req Request) (Status, error) {
+	source := filesystem.NormalizePath(req.Source)
+	target := filesystem.NormalizePath(req.Target)
+	if req.Source == "" || req.Target == "" {
+		return Status{}, filesystem.NewInvalidArgumentError("source", req.Source, "source and target are required")
+	}
+	if source == "/" || target == "/" || within(target, source) || within(source, target) {
+		return Status{}, filesystem.NewInvalidArgumentError("target", target, "source and target must not contain each other")
+	}
+	var rate int64
+	if req.Rate != "" {
+		var err error
+		if rate, err = pluginconfig.ParseSize(req.Rate); err != nil || rate < 0 {
+			return Status{}, filesystem.NewInvalidArgumentError("rate", req.Rate, "must be a size such as \"20MB\"")
+		}
+	}
+	info, err := m.fs.Stat(source)
+	if err != nil {
+		return Status{}, err
+	}
+	if !info.IsDir {
+		return Status{}, filesystem.NewNotDirectoryError(source)
+	}
+
+	j := &job{status: Status{
+		ID:      uuid.NewString()[:8],
+		Source:  source,
+		Target:  target,
+		Rate:    rate,
+		Verify:  req.Verify == nil || *req.Verify,
+		State:   StateRunning,
+		Created: time.Now(),
+	}}
+	m.mu.Lock()
+	m.jobs[j.status.ID] = j
+	m.mu.Unlock()
+
+	log.Infof("[migrate] job %s: migrating %s to %s", j.status.ID, source, target)
+	return m.run(j), nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// Resume continues a failed, canceled or interrupted job after its checkpoint
+func (m *Manager) Resume(id string) (Status, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Status{}, err
+	}
+	j.mu.Lock()
+	if j.status.State == StateRunning {
+		j.mu.Unlock()
+		return j.snapshot(), ErrRunning
+	}
+	if j.status.State == StateDone {
+		j.mu.Unlock()
+		return j.snapshot(), filesystem.NewInvalidArgumentError("id", id, "migration is already done")
+	}
+	j.status.State = StateRunning
+	j.status.Error = ""
+	j.status.Finished = nil
+	j.mu.Unlock()
+
+	log.Infof("[migrate] job %s: resuming after %q", id, j.snapshot().Checkpoint)
+	return m.run(j), nil
+}
+
+// Cancel stops a running job; it keeps its checkpoint and can be resumed
+func (m *Manager) Cancel(id string) (Status, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Status{}, err
+	}
+	j.mu.Lock()
+	cancel, done := j.cancel, j.done
+	j.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return j.snapshot(), nil
+}
+
+// Get returns the status of a job
+func (m *Manager) Get(id string) (Status, error) {
+	j, err := m.job(id)
+	if err != nil {
+		return Status{}, err
+	}
+	return j.snapshot(), nil
+}
+
+// List returns the status of every job, newest first
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	statuses := make([]Status, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		statuses = append(statuses, j.snapshot())
+	}
+	m.mu.Unlock()
+	sort.Slice(statuses, func(a, b int) bool { return statuses[a].Created.After(statuses[b].Created) })
+	return statuses
+}
+
+// Close cancels the running jobs, saving their checkpoints
+func (m *Manager) Close() {
+	m.mu.Lock()
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+	for _, j := range jobs {
+		j.mu.Lock()
+		cancel, done := j.cancel, j.done
+		j.mu.Unlock()
+		if cancel != nil {
+			cancel()
+			<-done
+		}
+	}
+}
+
+func (m *Manager) job(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("migration", id)
+	}
+	return j, nil
+}
+
+// run starts the copy of j in the background and returns its status
+func (m *Manager) run(j *job) Status {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	j.mu.Lock()
+	j.cancel, j.done = cancel, done
+	j.mu.Unlock()
+	m.save(j)
+
+	go func() {
+		defer close(done)
+		err := m.copy(ctx, j)
+
+		now := time.Now()
+		j.mu.Lock()
+		j.cancel = nil
+		j.status.Phase = ""
+		j.status.Finished = &now
+		switch {
+		case err == nil:
+			j.status.State = StateDone
+		case ctx.Err() != nil:
+			j.status.State = StateCanceled
+		default:
+			j.status.State = StateFailed
+			j.status.Error = err.Error()
+		}
+		status := j.status
+		j.mu.Unlock()
+		m.save(j)
+		cancel()
+
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("[migrate] job %s failed: %v", status.ID, err)
+		} else {
+			log.Infof("[migrate] job %s %s: %d files, %d bytes", status.ID, status.State, status.Files, status.Bytes)
+		}
+	}()
+	return j.snapshot()
+}
+
+// copy scans the source, then copies and verifies every file after the checkpoint
+func (m *Manager) copy(ctx context.Context, j *job) error {
+	fs := filesystem.WithContext(m.fs, ctx)
+	status := j.snapshot()
+	limiter := throttle.NewLimiter(status.Rate)
+
+	j.mu.Lock()
+	j.status.Phase = PhaseScanning
+	j.mu.Unlock()
+	var totalFiles, totalBytes int64
+	err := filesystem.Walk(fs, status.Source, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			totalFiles++
+			totalBytes += info.Size
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	j.status.Phase = PhaseCopying
+	j.status.TotalFiles, j.status.TotalBytes = totalFiles, totalBytes
+	j.mu.Unlock()
+	if err := filesystem.MkdirAll(fs, status.Target, 0755); err != nil {
+		return err
+	}
+
+	lastSave := time.Now()
+	return filesystem.Walk(fs, status.Source, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, status.Source), "/")
+		dst := path.Join(status.Target, rel)
+		if info.IsDir {
+			return filesystem.MkdirAll(fs, dst, 0755)
+		}
+		if status.Checkpoint != "" && comparePaths(rel, status.Checkpoint) <= 0 {
+			return nil
+		}
+
+		n, err := copyFile(ctx, fs, p, dst, limiter, status.Verify)
+		if err != nil {
+			return err
+		}
+		fs.Chmod(dst, info.Mode)
+
+		j.mu.Lock()
+		j.status.Files++
+		j.status.Bytes += n
+		if status.Verify {
+			j.status.Verified++
+		}
+		j.status.Checkpoint = rel
+		j.mu.Unlock()
+		if time.Since(lastSave) >= checkpointInterval {
+			m.save(j)
+			lastSave = time.Now()
+		}
+		return nil
+	})
+}
+
+// copyFile copies src to dst at the rate allowed by limiter and, with verify, reads dst back
+// and compares its digest with the one of the data read from src
+func copyFile(ctx context.Context, fs filesystem.FileSystem, src, dst string, limiter *throttle.Limiter, verify bool) (int64, error) {
+	data, err := fs.Read(src, 0, -1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if err := limiter.WaitN(ctx, len(data)); err != nil {
+		return 0, err
+	}
+	if _, err := fs.Write(dst, data); err != nil {
+		return 0, err
+	}
+	if !verify {
+		return int64(len(data)), nil
+	}
+
+	written, err := fs.Read(dst, 0, -1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if expected, actual := xxh3.Hash(data), xxh3.Hash(written); expected != actual {
+		return 0, filesystem.NewIntegrityError(dst, strconv.FormatUint(expected, 16), strconv.FormatUint(actual, 16))
+	}
+	return int64(len(data)), nil
+}
+
+// comparePaths orders relative paths like filesystem.Walk visits them: component by component
+func comparePaths(a, b string) int {
+	pa, pb := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if c := strings.Compare(pa[i], pb[i]); c != 0 {
+			return c
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// save writes the status of j to the state directory
+func (m *Manager) save(j *job) {
+	if m.stateDir == "" {
+		return
+	}
+	status := j.snapshot()
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(m.stateDir, 0755)
+	}
+	if err == nil {
+		file := filepath.Join(m.stateDir, status.ID+".json")
+		if err = os.WriteFile(file+".tmp", data, 0644); err == nil {
+			err = os.Rename(file+".tmp", file)
+		}
+	}
+	if err != nil {
+		log.Warnf("[migrate] failed to save job %s: %v", status.ID, err)
+	}
+}
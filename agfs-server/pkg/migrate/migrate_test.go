@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestFS(t *testing.T) *mountablefs.MountableFS {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	for _, p := range []string{"/src", "/dst"} {
+		if err := mfs.MountPlugin("memfs", p, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{"/src/a": "1", "/src/dir/b": "22", "/src/dir/c": "333", "/src/z": "4444"} {
+		write(t, mfs, name, data)
+	}
+	return mfs
+}
+
+func write(t *testing.T, mfs *mountablefs.MountableFS, p, data string) {
+	t.Helper()
+	if err := filesystem.MkdirAll(mfs, path.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write(p, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(mfs *mountablefs.MountableFS, p string) string {
+	data, err := mfs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func wait(t *testing.T, m *Manager, id string) Status {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		status, err := m.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != StateRunning {
+			return status
+		}
+	}
+	t.Fatalf("migration %s did not finish", id)
+	return Status{}
+}
+
+func TestMigrate(t *testing.T) {
+	mfs := newTestFS(t)
+	m, err := NewManager(mfs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Start(Request{Source: "/src", Target: "/src/copy"}); err == nil {
+		t.Errorf("migrating into the source succeeded")
+	}
+
+	started, err := m.Start(Request{Source: "/src", Target: "/dst/data", Rate: "10MB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := wait(t, m, started.ID)
+	// The memfs README is copied as well
+	if status.State != StateDone || status.TotalFiles != 5 || status.Files != 5 || status.Bytes != status.TotalBytes || status.Verified != 5 {
+		t.Errorf("status = %+v", status)
+	}
+	if got := read(mfs, "/dst/data/dir/c"); got != "333" {
+		t.Errorf("/dst/data/dir/c = %q", got)
+	}
+}
+
+func TestResume(t *testing.T) {
+	mfs := newTestFS(t)
+	stateDir := t.TempDir()
+
+	// A job interrupted by a restart after copying /a and /dir/b
+	saved := Status{ID: "job1", Source: "/src", Target: "/dst", Verify: true, State: StateRunning,
+		Created: time.Now(), Files: 2, Bytes: 3, Checkpoint: "dir/b"}
+	data, _ := json.Marshal(saved)
+	if err := os.WriteFile(filepath.Join(stateDir, "job1.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(mfs, stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m.Get("job1"); status.State != StateInterrupted {
+		t.Fatalf("loaded state = %s", status.State)
+	}
+	if _, err := m.Resume("job1"); err != nil {
+		t.Fatal(err)
+	}
+	status := wait(t, m, "job1")
+	if status.State != StateDone || status.Files != 4 || status.Bytes != 10 || status.Checkpoint != "z" {
+		t.Errorf("status = %+v", status)
+	}
+	// Files before the checkpoint are not copied again
+	if _, err := mfs.Stat("/dst/a"); err == nil {
+		t.Errorf("/dst/a was copied again")
+	}
+	if got := read(mfs, "/dst/dir/c"); got != "333" {
+		t.Errorf("/dst/dir/c = %q", got)
+	}
+
+	// The final state is saved
+	m2, err := NewManager(mfs, stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m2.Get("job1"); status.State != StateDone {
+		t.Errorf("saved state = %s", status.State)
+	}
+	if _, err := m2.Resume("job1"); err == nil {
+		t.Errorf("resuming a finished job succeeded")
+	}
+}
+
+func TestComparePaths(t *testing.T) {
+	// Walk visits "a" and its children before "a-c"
+	if comparePaths("a/b", "a-c") >= 0 || comparePaths("a", "a/b") >= 0 || comparePaths("b", "a/z") <= 0 {
+		t.Errorf("comparePaths does not follow the walk order")
+	}
+}
@@ -0,0 +1,140 @@
+package mountablefs
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// AccessOp identifies the kind of file access reported to access observers
+type AccessOp string
+
+// Access operations
+const (
+	AccessRead  AccessOp = "read"
+	AccessWrite AccessOp = "write"
+)
+
+// Access describes a successful read or write of file content made through MountableFS
+type Access struct {
+	Op    AccessOp  `json:"op"`
+	Path  string    `json:"path"`
+	Bytes int64     `json:"bytes"`
+	Time  time.Time `json:"time"`
+}
+
+// accessObservers fans accesses out to observers; reads of the observer list are lock
+// free so that unobserved file systems pay next to nothing per operation
+type accessObservers struct {
+	mu        sync.Mutex
+	observers map[uint64]func(Access)
+	nextID    uint64
+	snapshot  atomic.Pointer[[]func(Access)]
+}
+
+// ObserveAccess registers fn to be called after every read and write of file content,
+// including streamed ones, which are reported when closed
+// fn runs on the accessing goroutine and must not block; the returned function unregisters it
+func (mfs *MountableFS) ObserveAccess(fn func(Access)) func() {
+	o := &mfs.access
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.observers == nil {
+		o.observers = make(map[uint64]func(Access))
+	}
+	o.nextID++
+	id := o.nextID
+	o.observers[id] = fn
+	o.publish()
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.observers, id)
+		o.publish()
+	}
+}
+
+// publish replaces the snapshot read by notify; the caller must hold o.mu
+func (o *accessObservers) publish() {
+	fns := make([]func(Access), 0, len(o.observers))
+	for _, fn := range o.observers {
+		fns = append(fns, fn)
+	}
+	o.snapshot.Store(&fns)
+}
+
+// observed reports whether anyone observes accesses
+func (o *accessObservers) observed() bool {
+	fns := o.snapshot.Load()
+	return fns != nil && len(*fns) > 0
+}
+
+// notify reports an access of n bytes to path
+func (o *accessObservers) notify(op AccessOp, path string, n int64) {
+	fns := o.snapshot.Load()
+	if fns == nil || len(*fns) == 0 {
+		return
+	}
+	a := Access{Op: op, Path: filesystem.NormalizePath(path), Bytes: n, Time: time.Now()}
+	for _, fn := range *fns {
+		fn(a)
+	}
+}
+
+// recordAccess reports op on path if err is nil or io.EOF and passes err through
+func (mfs *MountableFS) recordAccess(err error, op AccessOp, path string, n int64) error {
+	if err == nil || err == io.EOF {
+		mfs.access.notify(op, path, n)
+	}
+	return err
+}
+
+// accessReader reports the bytes read from a stream when it is closed
+type accessReader struct {
+	io.ReadCloser
+	access *accessObservers
+	path   string
+	n      int64
+}
+
+func (r *accessReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *accessReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.access.notify(AccessRead, r.path, r.n)
+	return err
+}
+
+// accessWriter reports the bytes written to a stream when it is closed successfully
+type accessWriter struct {
+	io.WriteCloser
+	access *accessObservers
+	path   string
+	n      int64
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *accessWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.access.notify(AccessWrite, w.path, w.n)
+	return nil
+}
+
+// Abort implements filesystem.Aborter, an aborted write is not an access
+func (w *accessWriter) Abort() error {
+	return filesystem.AbortWrite(w.WriteCloser)
+}
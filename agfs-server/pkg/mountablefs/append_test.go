@@ -0,0 +1,58 @@
+package mountablefs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
+)
+
+func TestConcurrentAppend(t *testing.T) {
+	mfs := NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("sqlfs", func() plugin.ServicePlugin { return sqlfs.NewSQLFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("sqlfs", "/sql", map[string]interface{}{"db_path": filepath.Join(t.TempDir(), "sqlfs.db")}); err != nil {
+		t.Fatal(err)
+	}
+	defer mfs.Unmount("/sql")
+
+	// memfs appends are read-modify-write under the path locks, sqlfs appends are native
+	for _, p := range []string{"/mem/log", "/sql/log"} {
+		const writers, records = 20, 25
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < records; j++ {
+					if _, err := mfs.Append(p, []byte(fmt.Sprintf("%02d-%02d\x00\n", i, j))); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		data, err := mfs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if lines := bytes.Count(data, []byte("\x00\n")); lines != writers*records || len(data) != writers*records*7 {
+			t.Errorf("%s has %d records in %d bytes, want %d", p, lines, len(data), writers*records)
+		}
+		info, err := mfs.Stat(p)
+		if err != nil || info.Size != int64(len(data)) {
+			t.Errorf("stat %s = %+v, %v", p, info, err)
+		}
+	}
+}
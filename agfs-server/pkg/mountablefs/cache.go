@@ -0,0 +1,245 @@
+package mountablefs
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// DefaultCacheSize is the number of cached results kept per mount when no size is configured
+const DefaultCacheSize = 10000
+
+// cacheEntry is a cached Stat (info) or ReadDir (infos) result, or a "not found" error
+type cacheEntry struct {
+	info    *filesystem.FileInfo
+	infos   []filesystem.FileInfo
+	err     error
+	expires time.Time
+}
+
+// cacheFS wraps a FileSystem and keeps Stat and ReadDir results for a short time, saving
+// round trips for remote backends when shells issue bursts of metadata calls
+//
+// Successful results are kept for ttl and "not found" results for negativeTTL, either may be 0.
+// Negative entries also answer Read and Open, so probes of files such as .git or .DS_Store
+// by editors and shell completion do not reach the backend.
+//
+// Entries are invalidated by MountableFS from the change journal, so changes made through the
+// server are seen immediately; changes made behind its back are seen once the TTL expires.
+type cacheFS struct {
+	filesystem.FileSystem
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	*cacheState // Shared with the views returned by WithContext
+}
+
+// cacheState holds the cached results
+type cacheState struct {
+	mu    sync.Mutex
+	gen   uint64 // Incremented by every invalidation, lookups started earlier are not stored
+	stats map[string]cacheEntry
+	dirs  map[string]cacheEntry
+}
+
+// newCacheFS creates a cache wrapper keeping at most maxEntries results
+func newCacheFS(fs filesystem.FileSystem, ttl, negativeTTL time.Duration, maxEntries int) *cacheFS {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheSize
+	}
+	return &cacheFS{
+		FileSystem:  fs,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxEntries:  maxEntries,
+		cacheState: &cacheState{
+			stats: make(map[string]cacheEntry),
+			dirs:  make(map[string]cacheEntry),
+		},
+	}
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system
+func (c *cacheFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *c
+	bound.FileSystem = filesystem.WithContext(c.FileSystem, ctx)
+	return &bound
+}
+
+// lookup returns the unexpired entry of p in m and the current generation
+func (c *cacheFS) lookup(m map[string]cacheEntry, p string) (cacheEntry, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := m[p]
+	if ok && time.Now().After(entry.expires) {
+		delete(m, p)
+		ok = false
+	}
+	return entry, ok, c.gen
+}
+
+// store adds an entry to m unless the cache was invalidated since generation gen
+// Errors other than "not found" are never cached
+func (c *cacheFS) store(m map[string]cacheEntry, p string, entry cacheEntry, gen uint64) {
+	ttl := c.ttl
+	if entry.err != nil {
+		if !filesystem.IsNotFound(entry.err) {
+			return
+		}
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	if len(c.stats)+len(c.dirs) >= c.maxEntries {
+		c.evict()
+	}
+	entry.expires = time.Now().Add(ttl)
+	m[p] = entry
+}
+
+// evict drops expired entries, or an arbitrary tenth of the cache if none expired
+// Must be called with c.mu held
+func (c *cacheFS) evict() {
+	now := time.Now()
+	dropped := 0
+	for _, m := range []map[string]cacheEntry{c.stats, c.dirs} {
+		for p, entry := range m {
+			if now.After(entry.expires) {
+				delete(m, p)
+				dropped++
+			}
+		}
+	}
+	for _, m := range []map[string]cacheEntry{c.stats, c.dirs} {
+		for p := range m {
+			if dropped >= c.maxEntries/10+1 {
+				return
+			}
+			delete(m, p)
+			dropped++
+		}
+	}
+}
+
+// invalidate drops the entries of p and of its parent directory, whose listing and
+// modification time change with p; subtree also drops every entry below p
+func (c *cacheFS) invalidate(p string, subtree bool) {
+	p = filesystem.NormalizePath(p)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+
+	if p == "/" && subtree {
+		c.stats = make(map[string]cacheEntry)
+		c.dirs = make(map[string]cacheEntry)
+		return
+	}
+	parent := path.Dir(p)
+	for _, m := range []map[string]cacheEntry{c.stats, c.dirs} {
+		delete(m, p)
+		delete(m, parent)
+		if subtree {
+			prefix := p + "/"
+			for key := range m {
+				if strings.HasPrefix(key, prefix) {
+					delete(m, key)
+				}
+			}
+		}
+	}
+}
+
+func (c *cacheFS) Stat(p string) (*filesystem.FileInfo, error) {
+	p = filesystem.NormalizePath(p)
+	entry, ok, gen := c.lookup(c.stats, p)
+	if !ok {
+		info, err := c.FileSystem.Stat(p)
+		entry = cacheEntry{info: info, err: err}
+		c.store(c.stats, p, entry, gen)
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	// Callers may modify the result, e.g. MountableFS renames mount points
+	info := *entry.info
+	return &info, nil
+}
+
+func (c *cacheFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	p = filesystem.NormalizePath(p)
+	entry, ok, gen := c.lookup(c.dirs, p)
+	if !ok {
+		infos, err := c.FileSystem.ReadDir(p)
+		entry = cacheEntry{infos: infos, err: err}
+		c.store(c.dirs, p, entry, gen)
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	// Callers may append to the result, e.g. MountableFS adds nested mount points
+	return append([]filesystem.FileInfo(nil), entry.infos...), nil
+}
+
+// missing returns the current generation and the cached "not found" error of p, if any
+func (c *cacheFS) missing(p string) (uint64, error) {
+	entry, ok, gen := c.lookup(c.stats, p)
+	if ok && entry.err != nil {
+		return gen, entry.err
+	}
+	return gen, nil
+}
+
+func (c *cacheFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	p = filesystem.NormalizePath(p)
+	gen, err := c.missing(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.FileSystem.Read(p, offset, size)
+	if err != nil && err != io.EOF {
+		c.store(c.stats, p, cacheEntry{err: err}, gen)
+	}
+	return data, err
+}
+
+func (c *cacheFS) Open(p string) (io.ReadCloser, error) {
+	p = filesystem.NormalizePath(p)
+	gen, err := c.missing(p)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.FileSystem.Open(p)
+	if err != nil {
+		c.store(c.stats, p, cacheEntry{err: err}, gen)
+	}
+	return r, err
+}
+
+// invalidateCache is subscribed to the change journal and drops the cached results
+// a change affects in the mounts holding its paths
+func (mfs *MountableFS) invalidateCache(c Change) {
+	subtree := c.Op == ChangeRemove || c.Op == ChangeRemoveAll || c.Op == ChangeRename
+
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	for _, p := range []string{c.Path, c.NewPath} {
+		if p == "" {
+			continue
+		}
+		if mount, relPath, found := mfs.findMount(p); found && mount.cache != nil {
+			mount.cache.invalidate(relPath, subtree)
+		}
+	}
+}
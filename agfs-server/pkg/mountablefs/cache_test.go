@@ -0,0 +1,195 @@
+package mountablefs
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// countingFS counts the Stat and ReadDir calls reaching the backend
+type countingFS struct {
+	filesystem.FileSystem
+	stats, readdirs atomic.Int64
+}
+
+func (c *countingFS) Stat(p string) (*filesystem.FileInfo, error) {
+	c.stats.Add(1)
+	return c.FileSystem.Stat(p)
+}
+
+func (c *countingFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	c.readdirs.Add(1)
+	return c.FileSystem.ReadDir(p)
+}
+
+// countingPlugin serves a countingFS over memfs
+type countingPlugin struct {
+	*memfs.MemFSPlugin
+	fs *countingFS
+}
+
+func (p *countingPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func mountCounting(t *testing.T, ttl time.Duration) (*MountableFS, *countingFS) {
+	t.Helper()
+	mem := memfs.NewMemFSPlugin()
+	if err := mem.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	p := &countingPlugin{MemFSPlugin: mem, fs: &countingFS{FileSystem: mem.GetFileSystem()}}
+	mfs := NewMountableFS()
+	if err := mfs.MountWithOptions("/m", p, MountOptions{CacheTTL: ttl, CacheSize: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return mfs, p.fs
+}
+
+func TestCacheServesRepeatedLookups(t *testing.T) {
+	mfs, backend := mountCounting(t, time.Minute)
+	if _, err := mfs.Write("/m/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		info, err := mfs.Stat("/m/a")
+		if err != nil || info.Size != 5 {
+			t.Fatalf("stat = %+v, %v", info, err)
+		}
+		if _, err := mfs.ReadDir("/m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := backend.stats.Load(); n != 1 {
+		t.Errorf("backend stat calls = %d, want 1", n)
+	}
+	if n := backend.readdirs.Load(); n != 1 {
+		t.Errorf("backend readdir calls = %d, want 1", n)
+	}
+
+	// The mount point is renamed by MountableFS without affecting the cached entry
+	for i := 0; i < 2; i++ {
+		if info, err := mfs.Stat("/m"); err != nil || info.Name != "m" {
+			t.Fatalf("stat /m = %+v, %v", info, err)
+		}
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	mfs, _ := mountCounting(t, time.Minute)
+	if err := mfs.Mkdir("/m/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/m/dir/a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	mfs.ReadDir("/m/dir")
+	mfs.Stat("/m/dir/a")
+
+	// Writes update the size and the parent listing
+	if _, err := mfs.Write("/m/dir/a", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/m/dir/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := mfs.Stat("/m/dir/a"); info == nil || info.Size != 3 {
+		t.Errorf("stat after write = %+v, want size 3", info)
+	}
+	if infos, _ := mfs.ReadDir("/m/dir"); len(infos) != 2 {
+		t.Errorf("readdir after create = %d entries, want 2", len(infos))
+	}
+
+	// Renaming a directory drops the cached entries below it
+	if err := mfs.Rename("/m/dir", "/m/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/m/dir/a"); err == nil {
+		t.Errorf("stat of a renamed file served from the cache")
+	}
+	if info, err := mfs.Stat("/m/moved/a"); err != nil || info.Size != 3 {
+		t.Errorf("stat /m/moved/a = %+v, %v", info, err)
+	}
+
+	// Touch bypasses the mount wrappers and is seen through the change journal
+	before, _ := mfs.Stat("/m/moved/a")
+	time.Sleep(2 * time.Millisecond)
+	if err := mfs.Touch("/m/moved/a"); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := mfs.Stat("/m/moved/a"); !after.ModTime.After(before.ModTime) {
+		t.Errorf("modification time not refreshed after touch")
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	mfs, backend := mountCounting(t, 10*time.Millisecond)
+	mfs.Write("/m/a", nil)
+	mfs.Stat("/m/a")
+	time.Sleep(20 * time.Millisecond)
+	mfs.Stat("/m/a")
+	if n := backend.stats.Load(); n != 2 {
+		t.Errorf("backend stat calls = %d, want 2 after expiry", n)
+	}
+}
+
+func TestCacheOptions(t *testing.T) {
+	opts, cfg, err := SplitMountOptions(map[string]interface{}{"cache_ttl": "2s", "cache_size": 50, "other": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.CacheTTL != 2*time.Second || opts.CacheSize != 50 || len(cfg) != 1 {
+		t.Errorf("options = %+v, plugin config %v", opts, cfg)
+	}
+	if _, _, err := SplitMountOptions(map[string]interface{}{"cache_size": 0}); err == nil {
+		t.Errorf("cache_size 0 accepted")
+	}
+}
+
+// lookupFS counts the Stat and Read calls reaching the backend
+type lookupFS struct {
+	filesystem.FileSystem
+	lookups atomic.Int64
+}
+
+func (n *lookupFS) Stat(p string) (*filesystem.FileInfo, error) {
+	n.lookups.Add(1)
+	return n.FileSystem.Stat(p)
+}
+
+func (n *lookupFS) Read(p string, offset, size int64) ([]byte, error) {
+	n.lookups.Add(1)
+	return n.FileSystem.Read(p, offset, size)
+}
+
+func TestNegativeCache(t *testing.T) {
+	backend := &lookupFS{FileSystem: memfs.NewMemoryFS()}
+	c := newCacheFS(backend, 0, time.Minute, 100)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Stat("/.git"); !filesystem.IsNotFound(err) {
+			t.Fatalf("stat error = %v, want not found", err)
+		}
+		if _, err := c.Read("/.git", 0, -1); !filesystem.IsNotFound(err) {
+			t.Fatalf("read error = %v, want not found", err)
+		}
+	}
+	if n := backend.lookups.Load(); n != 1 {
+		t.Errorf("backend lookups = %d, want 1", n)
+	}
+
+	// Creating the path drops the negative entry; positive results are not cached with ttl 0
+	backend.Write("/.git", []byte("x"))
+	c.invalidate("/.git", false)
+	if _, err := c.Stat("/.git"); err != nil {
+		t.Errorf("stat after create: %v", err)
+	}
+	c.Stat("/.git")
+	if n := backend.lookups.Load(); n != 3 {
+		t.Errorf("backend lookups = %d, want 3", n)
+	}
+}
@@ -0,0 +1,145 @@
+package mountablefs
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// ChangeOp identifies the kind of mutation recorded in the change journal
+type ChangeOp string
+
+// Change operations
+const (
+	ChangeCreate    ChangeOp = "create"
+	ChangeMkdir     ChangeOp = "mkdir"
+	ChangeWrite     ChangeOp = "write"
+	ChangeRemove    ChangeOp = "remove"
+	ChangeRemoveAll ChangeOp = "removeall"
+	ChangeRename    ChangeOp = "rename"
+	ChangeChmod     ChangeOp = "chmod"
+	ChangeTouch     ChangeOp = "touch"
+)
+
+// changeJournalSize is the number of recent changes kept for ChangesSince
+const changeJournalSize = 10000
+
+// Change describes a successful mutation made through MountableFS
+type Change struct {
+	Seq     uint64    `json:"seq"`
+	Op      ChangeOp  `json:"op"`
+	Path    string    `json:"path"`
+	NewPath string    `json:"newPath,omitempty"` // Rename target
+	Time    time.Time `json:"time"`
+}
+
+// changeJournal keeps a bounded history of changes and fans them out to subscribers
+// Only mutations made through MountableFS are recorded, changes made directly in a
+// backend (e.g. another process writing to a localfs directory) are not seen
+type changeJournal struct {
+	mu          sync.Mutex
+	seq         uint64
+	ring        []Change
+	next        int
+	subscribers map[uint64]func(Change)
+	nextSubID   uint64
+}
+
+func newChangeJournal() *changeJournal {
+	return &changeJournal{
+		ring:        make([]Change, 0, changeJournalSize),
+		subscribers: make(map[uint64]func(Change)),
+	}
+}
+
+// record appends a change and notifies subscribers synchronously
+func (j *changeJournal) record(op ChangeOp, path, newPath string) {
+	j.mu.Lock()
+	j.seq++
+	c := Change{Seq: j.seq, Op: op, Path: path, NewPath: newPath, Time: time.Now()}
+	if len(j.ring) < changeJournalSize {
+		j.ring = append(j.ring, c)
+	} else {
+		j.ring[j.next] = c
+		j.next = (j.next + 1) % changeJournalSize
+	}
+	subscribers := make([]func(Change), 0, len(j.subscribers))
+	for _, fn := range j.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	j.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(c)
+	}
+}
+
+// Subscribe registers fn to be called after every change
+// fn runs on the mutating goroutine and must not block; the returned function unsubscribes
+func (mfs *MountableFS) Subscribe(fn func(Change)) func() {
+	j := mfs.changes
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.nextSubID++
+	id := j.nextSubID
+	j.subscribers[id] = fn
+	return func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		delete(j.subscribers, id)
+	}
+}
+
+// ChangesSince returns the recorded changes with a sequence number greater than seq
+// complete is false if older changes were already dropped from the journal
+func (mfs *MountableFS) ChangesSince(seq uint64) (changes []Change, complete bool) {
+	j := mfs.changes
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ordered := append(append([]Change{}, j.ring[j.next:]...), j.ring[:j.next]...)
+	complete = len(ordered) == 0 || ordered[0].Seq <= seq+1
+	for _, c := range ordered {
+		if c.Seq > seq {
+			changes = append(changes, c)
+		}
+	}
+	return changes, complete
+}
+
+// LastChangeSeq returns the sequence number of the most recent change
+func (mfs *MountableFS) LastChangeSeq() uint64 {
+	mfs.changes.mu.Lock()
+	defer mfs.changes.mu.Unlock()
+	return mfs.changes.seq
+}
+
+// changeWriter records a write change when a streamed write is closed successfully
+type changeWriter struct {
+	io.WriteCloser
+	journal *changeJournal
+	path    string
+}
+
+func (w *changeWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.journal.record(ChangeWrite, w.path, "")
+	return nil
+}
+
+// Abort implements filesystem.Aborter, nothing changed so nothing is recorded
+func (w *changeWriter) Abort() error {
+	return filesystem.AbortWrite(w.WriteCloser)
+}
+
+// recordChange records op on path if err is nil and passes err through
+func (mfs *MountableFS) recordChange(err error, op ChangeOp, path, newPath string) error {
+	if err == nil {
+		mfs.changes.record(op, filesystem.NormalizePath(path), newPath)
+	}
+	return err
+}
@@ -0,0 +1,572 @@
+package mountablefs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/xxh3"
+)
+
+// Checksum layout within a mount
+const (
+	ChecksumDir = "/.checksums" // Hidden directory holding one "<path>.sum" file per checksummed file
+	ScrubFile   = "/.scrub"     // Control file: write a path to verify its subtree, read for the last report
+
+	checksumSuffix = ".sum"
+)
+
+// Supported checksum algorithms
+const (
+	ChecksumXXH3   = "xxh3"
+	ChecksumSHA256 = "sha256"
+)
+
+// Meta values for the checksum facility
+const (
+	MetaKeyChecksum       = "checksum"
+	MetaValueScrubControl = "scrub-control"
+)
+
+// Scrub states
+const (
+	ScrubIdle     = "idle"
+	ScrubRunning  = "running"
+	ScrubFinished = "finished"
+)
+
+// ScrubReport describes the progress and result of a scrub
+type ScrubReport struct {
+	State      string     `json:"state"`
+	Path       string     `json:"path,omitempty"`
+	Algorithm  string     `json:"algorithm"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Checked    int        `json:"checked"`          // Files whose checksum matched
+	Missing    int        `json:"missing"`          // Files without a recorded checksum
+	Corrupted  []string   `json:"corrupted"`        // Files whose checksum did not match
+	Errors     []string   `json:"errors,omitempty"` // Files that could not be read
+	Canceled   bool       `json:"canceled,omitempty"`
+}
+
+// validChecksumAlgorithm checks if algo is a supported checksum algorithm
+func validChecksumAlgorithm(algo string) bool {
+	return algo == ChecksumXXH3 || algo == ChecksumSHA256
+}
+
+// newChecksumHash returns a streaming hash for algo
+func newChecksumHash(algo string) hash.Hash {
+	if algo == ChecksumSHA256 {
+		return sha256.New()
+	}
+	return xxh3.New()
+}
+
+// checksumOf returns the encoded checksum of data, e.g. "xxh3:9a3c..."
+func checksumOf(algo string, data []byte) string {
+	h := newChecksumHash(algo)
+	h.Write(data)
+	return algo + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// checksumFS wraps a FileSystem so that every write records a checksum
+// and every read of a checksummed file is verified against it
+type checksumFS struct {
+	filesystem.FileSystem
+	algorithm   string
+	*scrubState                       // Shared with the views returned by WithContext
+	locks       *filesystem.PathLocks // Keep files and their checksums consistent, shared like scrubState
+	base        *checksumFS           // The unbound wrapper of a view returned by WithContext, nil otherwise
+}
+
+// scrubState is the report of the last or running scrub
+type scrubState struct {
+	mu    sync.Mutex // protects scrub
+	scrub ScrubReport
+}
+
+// newChecksumFS creates a checksum wrapper using algo
+func newChecksumFS(fs filesystem.FileSystem, algo string) *checksumFS {
+	return &checksumFS{
+		FileSystem: fs,
+		algorithm:  algo,
+		scrubState: &scrubState{scrub: ScrubReport{State: ScrubIdle, Algorithm: algo, Corrupted: []string{}}},
+		locks:      &filesystem.PathLocks{},
+	}
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system
+func (c *checksumFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *c
+	bound.FileSystem = filesystem.WithContext(c.FileSystem, ctx)
+	bound.base = c.unbound()
+	return &bound
+}
+
+// unbound returns the wrapper not bound to a context, scrubs run on it so they outlive the call
+func (c *checksumFS) unbound() *checksumFS {
+	if c.base != nil {
+		return c.base
+	}
+	return c
+}
+
+// isChecksumPath checks if path is the checksum directory or inside it
+func isChecksumPath(p string) bool {
+	return p == ChecksumDir || strings.HasPrefix(p, ChecksumDir+"/")
+}
+
+// sumPath returns the location of the checksum of file p
+func sumPath(p string) string {
+	return ChecksumDir + p + checksumSuffix
+}
+
+// storedChecksum returns the recorded checksum of p, or "" if there is none
+func (c *checksumFS) storedChecksum(p string) string {
+	data, err := c.FileSystem.Read(sumPath(p), 0, -1)
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// record stores the checksum of p
+func (c *checksumFS) record(p, sum string) error {
+	sp := sumPath(p)
+	if err := filesystem.MkdirAll(c.FileSystem, path.Dir(sp), 0755); err != nil {
+		return fmt.Errorf("failed to record checksum of %s: %w", p, err)
+	}
+	if _, err := c.FileSystem.Write(sp, []byte(sum)); err != nil {
+		return fmt.Errorf("failed to record checksum of %s: %w", p, err)
+	}
+	return nil
+}
+
+// verify compares data with the recorded checksum of p
+// Files written before the checksum mode was enabled have no checksum and are not verified
+func (c *checksumFS) verify(p string, data []byte) error {
+	expected := c.storedChecksum(p)
+	if expected == "" {
+		return nil
+	}
+	algo := c.algorithm
+	if i := strings.Index(expected, ":"); i > 0 {
+		algo = expected[:i]
+	}
+	if !validChecksumAlgorithm(algo) {
+		return nil
+	}
+	if actual := checksumOf(algo, data); actual != expected {
+		return filesystem.NewIntegrityError(p, expected, actual)
+	}
+	return nil
+}
+
+func (c *checksumFS) denied(op, p string) error {
+	return filesystem.NewPermissionDeniedError(op, p, "checksums are managed by the server")
+}
+
+func (c *checksumFS) Create(p string) error {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) || p == ScrubFile {
+		return c.denied("create", p)
+	}
+	unlock := c.locks.Lock(p)
+	defer unlock()
+	if err := c.FileSystem.Create(p); err != nil {
+		return err
+	}
+	return c.record(p, checksumOf(c.algorithm, nil))
+}
+
+func (c *checksumFS) Mkdir(p string, perm uint32) error {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) || p == ScrubFile {
+		return c.denied("mkdir", p)
+	}
+	return c.FileSystem.Mkdir(p, perm)
+}
+
+func (c *checksumFS) Write(p string, data []byte) ([]byte, error) {
+	p = filesystem.NormalizePath(p)
+	if p == ScrubFile {
+		if err := c.startScrub(string(data)); err != nil {
+			return nil, err
+		}
+		return []byte("scrub started"), nil
+	}
+	if isChecksumPath(p) {
+		return nil, c.denied("write", p)
+	}
+
+	// Readers verifying the file must not see the new data with the old checksum
+	unlock := c.locks.Lock(p)
+	defer unlock()
+	result, err := c.FileSystem.Write(p, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.record(p, checksumOf(c.algorithm, data)); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *checksumFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	p = filesystem.NormalizePath(p)
+	if p == ScrubFile {
+		return plugin.ApplyRangeRead(c.scrubReport(), offset, size)
+	}
+	if isChecksumPath(p) {
+		return nil, filesystem.NewNotFoundError("read", p)
+	}
+
+	// The checksum covers the whole file; hashing it for every range would make ranged reads
+	// of large files quadratic, so only whole reads, such as those of Open, are verified
+	if offset > 0 || size >= 0 {
+		return c.FileSystem.Read(p, offset, size)
+	}
+	unlock := c.locks.Lock(p)
+	data, err := c.FileSystem.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		unlock()
+		return nil, err
+	}
+	err = c.verify(p, data)
+	unlock()
+	if err != nil {
+		log.Errorf("[checksum] %v", err)
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (c *checksumFS) Open(p string) (io.ReadCloser, error) {
+	data, err := c.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *checksumFS) OpenWrite(p string) (io.WriteCloser, error) {
+	p = filesystem.NormalizePath(p)
+	if p == ScrubFile {
+		return filesystem.NewBufferedWriter(p, c.Write), nil
+	}
+	if isChecksumPath(p) {
+		return nil, c.denied("openwrite", p)
+	}
+	w, err := c.FileSystem.OpenWrite(p)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumWriter{fs: c, path: p, w: w, h: newChecksumHash(c.algorithm)}, nil
+}
+
+func (c *checksumFS) Remove(p string) error {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) || p == ScrubFile {
+		return c.denied("remove", p)
+	}
+	if err := c.FileSystem.Remove(p); err != nil {
+		return err
+	}
+	c.FileSystem.Remove(sumPath(p))
+	return nil
+}
+
+func (c *checksumFS) RemoveAll(p string) error {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) || p == ScrubFile {
+		return c.denied("removeall", p)
+	}
+	if err := c.FileSystem.RemoveAll(p); err != nil {
+		return err
+	}
+	if p == "/" {
+		// The plugin may keep hidden entries it cannot remove, drop the checksums anyway
+		c.FileSystem.RemoveAll(ChecksumDir)
+		return nil
+	}
+	c.FileSystem.RemoveAll(ChecksumDir + p)
+	c.FileSystem.Remove(sumPath(p))
+	return nil
+}
+
+func (c *checksumFS) Rename(oldPath, newPath string) error {
+	oldPath = filesystem.NormalizePath(oldPath)
+	newPath = filesystem.NormalizePath(newPath)
+	if isChecksumPath(oldPath) || isChecksumPath(newPath) || oldPath == ScrubFile || newPath == ScrubFile {
+		return c.denied("rename", oldPath)
+	}
+	if err := c.FileSystem.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	// Move the checksums along; a directory carries the checksums of its descendants
+	for _, pair := range [][2]string{
+		{sumPath(oldPath), sumPath(newPath)},
+		{ChecksumDir + oldPath, ChecksumDir + newPath},
+	} {
+		if _, err := c.FileSystem.Stat(pair[0]); err != nil {
+			continue
+		}
+		if err := filesystem.MkdirAll(c.FileSystem, path.Dir(pair[1]), 0755); err != nil {
+			return err
+		}
+		if err := c.FileSystem.Rename(pair[0], pair[1]); err != nil {
+			return fmt.Errorf("failed to move checksum of %s: %w", oldPath, err)
+		}
+	}
+	return nil
+}
+
+func (c *checksumFS) Chmod(p string, mode uint32) error {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) || p == ScrubFile {
+		return c.denied("chmod", p)
+	}
+	return c.FileSystem.Chmod(p, mode)
+}
+
+func (c *checksumFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	p = filesystem.NormalizePath(p)
+	if isChecksumPath(p) {
+		return nil, filesystem.NewNotFoundError("readdir", p)
+	}
+	entries, err := c.FileSystem.ReadDir(p)
+	if err != nil || p != "/" {
+		return entries, err
+	}
+
+	// Hide the checksum store and show the scrub control file at the mount root
+	visible := make([]filesystem.FileInfo, 0, len(entries)+1)
+	for _, entry := range entries {
+		if "/"+entry.Name != ChecksumDir {
+			visible = append(visible, entry)
+		}
+	}
+	return append(visible, *c.scrubFileInfo()), nil
+}
+
+func (c *checksumFS) Stat(p string) (*filesystem.FileInfo, error) {
+	p = filesystem.NormalizePath(p)
+	if p == ScrubFile {
+		return c.scrubFileInfo(), nil
+	}
+	if isChecksumPath(p) {
+		return nil, filesystem.NewNotFoundError("stat", p)
+	}
+
+	info, err := c.FileSystem.Stat(p)
+	if err != nil || info.IsDir {
+		return info, err
+	}
+	if sum := c.storedChecksum(p); sum != "" {
+		content := make(map[string]string, len(info.Meta.Content)+1)
+		for k, v := range info.Meta.Content {
+			content[k] = v
+		}
+		content[MetaKeyChecksum] = sum
+		info.Meta.Content = content
+	}
+	return info, nil
+}
+
+func (c *checksumFS) scrubFileInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    path.Base(ScrubFile),
+		Size:    int64(len(c.scrubReport())),
+		Mode:    0644,
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Type: MetaValueScrubControl},
+	}
+}
+
+// scrubReport returns the current scrub report as JSON
+func (c *checksumFS) scrubReport() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, _ := json.MarshalIndent(c.scrub, "", "  ")
+	return append(data, '\n')
+}
+
+// startScrub verifies every file under root in the background
+func (c *checksumFS) startScrub(root string) error {
+	root, err := c.beginScrub(root)
+	if err != nil {
+		return err
+	}
+	go c.unbound().runScrub(context.Background(), root, nil)
+	return nil
+}
+
+// beginScrub resets the report for a scrub of root, failing if a scrub is running, and
+// returns the normalized root
+func (c *checksumFS) beginScrub(root string) (string, error) {
+	root = strings.TrimSpace(root)
+	if root == "" {
+		root = "/"
+	}
+	root = filesystem.NormalizePath(root)
+	if isChecksumPath(root) {
+		return "", filesystem.NewInvalidArgumentError("path", root, "cannot scrub the checksum store")
+	}
+	if _, err := c.FileSystem.Stat(root); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scrub.State == ScrubRunning {
+		return "", filesystem.NewAlreadyExistsError("scrub", c.scrub.Path)
+	}
+	now := time.Now()
+	c.scrub = ScrubReport{
+		State:     ScrubRunning,
+		Path:      root,
+		Algorithm: c.algorithm,
+		StartedAt: &now,
+		Corrupted: []string{},
+	}
+	return root, nil
+}
+
+// runScrub verifies every file under root until ctx is canceled, passing the report to
+// progress (if not nil) after each file, and returns the final report
+func (c *checksumFS) runScrub(ctx context.Context, root string, progress func(ScrubReport)) ScrubReport {
+	log.Infof("[checksum] scrub of %s started", root)
+	filesystem.Walk(c.FileSystem, root, func(p string, info *filesystem.FileInfo, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if progress != nil && info != nil && !info.IsDir {
+			defer func() { progress(c.scrubSnapshot()) }()
+		}
+		if err != nil {
+			c.scrubResult(func(r *ScrubReport) { r.Errors = append(r.Errors, err.Error()) })
+			return nil
+		}
+		if info.IsDir {
+			if isChecksumPath(p) {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+
+		if c.storedChecksum(p) == "" {
+			c.scrubResult(func(r *ScrubReport) { r.Missing++ })
+			return nil
+		}
+		unlock := c.locks.Lock(p)
+		data, err := c.FileSystem.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			unlock()
+			c.scrubResult(func(r *ScrubReport) { r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", p, err)) })
+			return nil
+		}
+		err = c.verify(p, data)
+		unlock()
+		switch {
+		case err == nil:
+			c.scrubResult(func(r *ScrubReport) { r.Checked++ })
+		case errors.Is(err, filesystem.ErrIntegrity):
+			log.Errorf("[checksum] scrub: %v", err)
+			c.scrubResult(func(r *ScrubReport) { r.Corrupted = append(r.Corrupted, p) })
+		}
+		return nil
+	})
+
+	c.scrubResult(func(r *ScrubReport) {
+		now := time.Now()
+		r.State = ScrubFinished
+		r.FinishedAt = &now
+		r.Canceled = ctx.Err() != nil
+		log.Infof("[checksum] scrub of %s finished: %d ok, %d corrupted, %d without checksum",
+			root, r.Checked, len(r.Corrupted), r.Missing)
+	})
+	return c.scrubSnapshot()
+}
+
+// scrubSnapshot returns a copy of the scrub report
+func (c *checksumFS) scrubSnapshot() ScrubReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.scrub
+	r.Corrupted = append([]string{}, r.Corrupted...)
+	r.Errors = append([]string(nil), r.Errors...)
+	return r
+}
+
+// Scrub verifies every file under path, which must be in a mount with checksums, until ctx
+// is canceled; progress (if not nil) gets the report after each file. The scrub control file
+// of the mount reports it like a scrub started through the file
+func (mfs *MountableFS) Scrub(ctx context.Context, path string, progress func(ScrubReport)) (ScrubReport, error) {
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	mfs.mu.RUnlock()
+	if !found {
+		return ScrubReport{}, filesystem.NewNotFoundError("scrub", path)
+	}
+	if mount.sums == nil {
+		return ScrubReport{}, filesystem.NewInvalidArgumentError("path", path, "mount "+mount.Path+" has no checksums")
+	}
+	root, err := mount.sums.beginScrub(relPath)
+	if err != nil {
+		return ScrubReport{}, err
+	}
+	return mount.sums.runScrub(ctx, root, progress), nil
+}
+
+// scrubResult updates the scrub report under the lock
+func (c *checksumFS) scrubResult(update func(r *ScrubReport)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(&c.scrub)
+}
+
+// checksumWriter hashes streamed data and records the checksum on Close
+type checksumWriter struct {
+	fs   *checksumFS
+	path string
+	w    io.WriteCloser
+	h    hash.Hash
+}
+
+func (cw *checksumWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.h.Write(p[:n])
+	return n, err
+}
+
+func (cw *checksumWriter) Close() error {
+	unlock := cw.fs.locks.Lock(cw.path)
+	defer unlock()
+	if err := cw.w.Close(); err != nil {
+		return err
+	}
+	return cw.fs.record(cw.path, cw.fs.algorithm+":"+hex.EncodeToString(cw.h.Sum(nil)))
+}
+
+// Abort implements filesystem.Aborter, the checksum of the file is left as it was
+func (cw *checksumWriter) Abort() error {
+	return filesystem.AbortWrite(cw.w)
+}
+
+// Ensure checksumFS implements FileSystem
+var _ filesystem.FileSystem = (*checksumFS)(nil)
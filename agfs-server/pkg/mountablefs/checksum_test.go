@@ -0,0 +1,77 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// racingFS calls onWrite after writing a file, between the write and the checksum record
+type racingFS struct {
+	filesystem.FileSystem
+	onWrite func()
+}
+
+func (r *racingFS) Write(p string, data []byte) ([]byte, error) {
+	result, err := r.FileSystem.Write(p, data)
+	if r.onWrite != nil && !isChecksumPath(p) {
+		r.onWrite()
+	}
+	return result, err
+}
+
+func TestChecksumConcurrentWrites(t *testing.T) {
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	fs := &racingFS{FileSystem: mem.GetFileSystem()}
+	c := newChecksumFS(fs, ChecksumXXH3)
+	if _, err := c.Write("/f", []byte("initial")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader coming between the data and its checksum waits for the checksum
+	read := make(chan error, 1)
+	fs.onWrite = func() {
+		go func() {
+			_, err := c.Read("/f", 0, -1)
+			read <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := c.Write("/f", []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; err != nil && err != io.EOF {
+		t.Errorf("read during a write: %v", err)
+	}
+}
+
+func TestChecksumRangedReads(t *testing.T) {
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	c := newChecksumFS(mem.GetFileSystem(), ChecksumSHA256)
+	if _, err := c.Write("/f", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read("/f", 6, 5); string(data) != "world" || (err != nil && err != io.EOF) {
+		t.Errorf("ranged read = %q, %v", data, err)
+	}
+
+	// Corruption behind the wrapper fails whole reads; ranges are not verified
+	if _, err := mem.GetFileSystem().Write("/f", []byte("hello there")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Read("/f", 0, -1); !errors.Is(err, filesystem.ErrIntegrity) {
+		t.Errorf("whole read of a corrupted file: %v", err)
+	}
+	if _, err := c.Open("/f"); !errors.Is(err, filesystem.ErrIntegrity) {
+		t.Errorf("open of a corrupted file: %v", err)
+	}
+	if data, err := c.Read("/f", 6, -1); string(data) != "there" || (err != nil && err != io.EOF) {
+		t.Errorf("ranged read of a corrupted file = %q, %v", data, err)
+	}
+}
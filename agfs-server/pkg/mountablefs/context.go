@@ -0,0 +1,249 @@
+package mountablefs
+
+import (
+	"context"
+	"io"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// contextFS is a view of a MountableFS whose operations run under a context, e.g. that of
+// an HTTP request, so plugin work stops once the client goes away
+// Operations returning readers and streams, such as Open and OpenStream, are served by the
+// MountableFS itself, as what they return outlives the call. All operations are subject to
+// the access control of the MountableFS, see SetAccessControl.
+type contextFS struct {
+	*MountableFS
+	ctx context.Context
+}
+
+// WithContext implements filesystem.ContextBinder
+// The context is passed to the plugins of the mounts, combined with their deadlines.
+func (mfs *MountableFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &contextFS{MountableFS: mfs, ctx: ctx}
+}
+
+func (c *contextFS) Create(path string) error {
+	if err := c.check(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.create(c.ctx, path)
+}
+
+func (c *contextFS) Mkdir(path string, perm uint32) error {
+	if err := c.check(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.mkdir(c.ctx, path, perm)
+}
+
+func (c *contextFS) Remove(path string) error {
+	if err := c.check(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.remove(c.ctx, path)
+}
+
+func (c *contextFS) RemoveAll(path string) error {
+	if err := c.checkTree(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.removeAll(c.ctx, path)
+}
+
+func (c *contextFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.read(c.ctx, path, offset, size)
+}
+
+// ReadFormat implements filesystem.FormatReader interface
+func (c *contextFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, "", err
+	}
+	return c.readFormat(c.ctx, path, offset, size, format)
+}
+
+func (c *contextFS) Write(path string, data []byte) ([]byte, error) {
+	if err := c.check(AccessWrite, path); err != nil {
+		return nil, err
+	}
+	return c.write(c.ctx, path, data)
+}
+
+// Append implements filesystem.Appender interface
+func (c *contextFS) Append(path string, data []byte) (int64, error) {
+	if err := c.check(AccessWrite, path); err != nil {
+		return 0, err
+	}
+	return c.append(c.ctx, path, data)
+}
+
+// WriteAt implements filesystem.WriterAt interface
+func (c *contextFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	if err := c.check(AccessWrite, path); err != nil {
+		return 0, err
+	}
+	return c.writeAt(c.ctx, path, data, offset)
+}
+
+func (c *contextFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return c.ReadDirFields(path, filesystem.AllFields)
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+func (c *contextFS) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	infos, err := c.readDir(c.ctx, path, fields)
+	return c.filter(path, infos), err
+}
+
+// ReadDirPage implements filesystem.PageLister interface
+func (c *contextFS) ReadDirPage(path string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, false, err
+	}
+	infos, more, err := c.readDirPage(c.ctx, path, page)
+	return c.filter(path, infos), more, err
+}
+
+func (c *contextFS) Stat(path string) (*filesystem.FileInfo, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.stat(c.ctx, path)
+}
+
+func (c *contextFS) Rename(oldPath, newPath string) error {
+	if err := c.checkTree(AccessWrite, oldPath, newPath); err != nil {
+		return err
+	}
+	return c.rename(c.ctx, oldPath, newPath)
+}
+
+func (c *contextFS) Chmod(path string, mode uint32) error {
+	if err := c.check(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.chmod(c.ctx, path, mode)
+}
+
+// Touch implements filesystem.Toucher interface
+func (c *contextFS) Touch(path string) error {
+	if err := c.check(AccessWrite, path); err != nil {
+		return err
+	}
+	return c.recordChange(c.touch(c.ctx, path), ChangeTouch, path, "")
+}
+
+func (c *contextFS) Open(path string) (io.ReadCloser, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.MountableFS.Open(path)
+}
+
+func (c *contextFS) OpenWrite(path string) (io.WriteCloser, error) {
+	if err := c.check(AccessWrite, path); err != nil {
+		return nil, err
+	}
+	return c.MountableFS.OpenWrite(path)
+}
+
+// OpenStream implements filesystem.Streamer interface
+func (c *contextFS) OpenStream(path string) (filesystem.StreamReader, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.MountableFS.OpenStream(path)
+}
+
+// GetStream is GetStream of the MountableFS, subject to its access control
+func (c *contextFS) GetStream(path string) (interface{}, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.MountableFS.GetStream(path)
+}
+
+// Snapshot implements filesystem.Snapshotter interface
+func (c *contextFS) Snapshot(path string) (filesystem.FileSystem, error) {
+	if err := c.checkTree(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.MountableFS.Snapshot(path)
+}
+
+// BeginBulk implements filesystem.BulkWriter interface
+// Each entry of the load is checked, rules below dir may forbid writing some of them.
+func (c *contextFS) BeginBulk(dir string, opts filesystem.BulkOptions) (filesystem.BulkSession, error) {
+	if err := c.check(AccessWrite, dir); err != nil {
+		return nil, err
+	}
+	session, err := c.MountableFS.BeginBulk(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &contextBulk{BulkSession: session, c: c}, nil
+}
+
+// contextBulk checks the entries of a bulk load against the access control of its view
+type contextBulk struct {
+	filesystem.BulkSession
+	c *contextFS
+}
+
+func (s *contextBulk) WriteBatch(entries []filesystem.BulkEntry) error {
+	for _, e := range entries {
+		if err := s.c.check(AccessWrite, e.Path); err != nil {
+			return err
+		}
+	}
+	return s.BulkSession.WriteBatch(entries)
+}
+
+// Copy implements filesystem.Copier interface
+func (c *contextFS) Copy(src, dst string) (int64, error) {
+	if err := c.check(AccessRead, src); err != nil {
+		return 0, err
+	}
+	if err := c.check(AccessWrite, dst); err != nil {
+		return 0, err
+	}
+	return c.copy(c.ctx, c, src, dst)
+}
+
+// CopyAll copies the tree with the access of the view, see MountableFS.CopyAll
+func (c *contextFS) CopyAll(src, dst string) (filesystem.CopyStats, error) {
+	return copyAll(c, c, src, dst)
+}
+
+// Watch implements filesystem.Watcher interface
+// Only the events of paths the view may read are reported.
+func (c *contextFS) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	if err := c.check(AccessRead, path); err != nil {
+		return nil, err
+	}
+	return c.watch(ctx, c, path, recursive, func(p string) bool { return c.check(AccessRead, p) == nil })
+}
+
+// Ensure contextFS keeps the optional interfaces of MountableFS
+var (
+	_ filesystem.FileSystem    = (*contextFS)(nil)
+	_ filesystem.Toucher       = (*contextFS)(nil)
+	_ filesystem.Streamer      = (*contextFS)(nil)
+	_ filesystem.Snapshotter   = (*contextFS)(nil)
+	_ filesystem.BulkWriter    = (*contextFS)(nil)
+	_ filesystem.Copier        = (*contextFS)(nil)
+	_ filesystem.Watcher       = (*contextFS)(nil)
+	_ filesystem.Appender      = (*contextFS)(nil)
+	_ filesystem.WriterAt      = (*contextFS)(nil)
+	_ filesystem.FieldLister   = (*contextFS)(nil)
+	_ filesystem.FormatReader  = (*contextFS)(nil)
+	_ filesystem.PageLister    = (*contextFS)(nil)
+	_ filesystem.ContextBinder = (*contextFS)(nil)
+)
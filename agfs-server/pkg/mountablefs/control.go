@@ -0,0 +1,122 @@
+package mountablefs
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// AccessControl decides whether operations bound to a context may access a path, e.g. by
+// the principal of the request the context belongs to
+type AccessControl interface {
+	// Check returns nil if op on path is allowed, and a permission denied error otherwise
+	Check(ctx context.Context, op AccessOp, path string) error
+}
+
+// TreeAccessControl is implemented by access controls that can check a whole tree at once,
+// for the operations affecting everything below a path such as RemoveAll and Rename; the
+// trees of other access controls are walked
+type TreeAccessControl interface {
+	AccessControl
+
+	// CheckTree returns nil if op is allowed on path and on every path below it
+	CheckTree(ctx context.Context, op AccessOp, path string) error
+}
+
+// AccessChecker is implemented by the views returned by WithContext, for the results that
+// do not come from file operations, such as those of indexes, to be checked too
+type AccessChecker interface {
+	// Allowed reports whether the access control of the view allows op on path
+	Allowed(op AccessOp, path string) bool
+}
+
+// accessControl holds the AccessControl of a MountableFS, if any
+type accessControl struct {
+	p atomic.Pointer[AccessControl]
+}
+
+// SetAccessControl subjects the operations of the views returned by WithContext to ac, nil
+// removes it; operations on the MountableFS itself, made by the server, are not checked
+// Listings of those views leave out the entries ac does not allow reading.
+func (mfs *MountableFS) SetAccessControl(ac AccessControl) {
+	if ac == nil {
+		mfs.control.p.Store(nil)
+		return
+	}
+	mfs.control.p.Store(&ac)
+}
+
+// check returns the error of the access control for op on paths, nil without one
+func (c *contextFS) check(op AccessOp, paths ...string) error {
+	ac := c.control.p.Load()
+	if ac == nil {
+		return nil
+	}
+	for _, p := range paths {
+		if err := (*ac).Check(c.ctx, op, filesystem.NormalizePath(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allowed implements AccessChecker
+func (c *contextFS) Allowed(op AccessOp, path string) bool {
+	return c.check(op, path) == nil
+}
+
+// checkTree is check of op on paths and on everything below them
+func (c *contextFS) checkTree(op AccessOp, paths ...string) error {
+	ac := c.control.p.Load()
+	if ac == nil {
+		return nil
+	}
+	for _, p := range paths {
+		p = filesystem.NormalizePath(p)
+		var err error
+		if tree, ok := (*ac).(TreeAccessControl); ok {
+			err = tree.CheckTree(c.ctx, op, p)
+		} else {
+			err = c.walkCheck(*ac, op, p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkCheck checks op on p and, if it is a directory, on its whole tree
+func (c *contextFS) walkCheck(ac AccessControl, op AccessOp, p string) error {
+	if err := ac.Check(c.ctx, op, p); err != nil {
+		return err
+	}
+	infos, err := c.readDir(c.ctx, p, filesystem.ListFields{})
+	if err != nil {
+		return nil // Files and missing paths have nothing below them
+	}
+	for _, info := range infos {
+		if err := c.walkCheck(ac, op, path.Join(p, info.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filter leaves out the entries of the listing of dir that may not be read
+func (c *contextFS) filter(dir string, infos []filesystem.FileInfo) []filesystem.FileInfo {
+	ac := c.control.p.Load()
+	if ac == nil {
+		return infos
+	}
+	dir = filesystem.NormalizePath(dir)
+	allowed := infos[:0:0]
+	for _, info := range infos {
+		if (*ac).Check(c.ctx, AccessRead, path.Join(dir, info.Name)) == nil {
+			allowed = append(allowed, info)
+		}
+	}
+	return allowed
+}
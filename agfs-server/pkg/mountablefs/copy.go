@@ -0,0 +1,125 @@
+package mountablefs
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bufpool"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// copyBufferSize is the size of the chunks files are copied in between mounts
+const copyBufferSize = 256 * 1024
+
+// Copy implements filesystem.Copier
+// Files are copied by the plugin when both paths are in a mount whose plugin implements
+// filesystem.Copier and whose options let writes bypass its layers, see BeginBulk;
+// otherwise, e.g. between mounts, the content is streamed from src into dst.
+func (mfs *MountableFS) Copy(src, dst string) (int64, error) {
+	return mfs.copy(context.Background(), mfs, src, dst)
+}
+
+// CopyAll copies the file or tree of src to dst, which may be in another mount
+// Existing files at the destination are overwritten and existing directories are reused.
+func (mfs *MountableFS) CopyAll(src, dst string) (filesystem.CopyStats, error) {
+	return copyAll(mfs, mfs, src, dst)
+}
+
+// copy copies the file src to dst, streaming it through fs, the MountableFS or a view of it,
+// unless the plugin copies it
+func (mfs *MountableFS) copy(ctx context.Context, fs filesystem.FileSystem, src, dst string) (int64, error) {
+	if err := mfs.checkHold("copy", dst, false); err != nil {
+		return 0, err
+	}
+	mfs.mu.RLock()
+	srcMount, srcRel, srcFound := mfs.findMount(src)
+	dstMount, dstRel, dstFound := mfs.findMount(dst)
+	mfs.mu.RUnlock()
+	if !srcFound {
+		return 0, filesystem.NewNotFoundError("copy", src)
+	}
+	if !dstFound {
+		return 0, filesystem.NewNotFoundError("copy", dst)
+	}
+
+	info, err := fs.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, filesystem.NewInvalidArgumentError("path", src, "is a directory, copy it recursively")
+	}
+
+	if srcMount == dstMount && srcMount.Options.bulkCompatible() {
+		pfs, done := srcMount.bind(ctx, "copy", src)
+		if copier, ok := pfs.(filesystem.Copier); ok {
+			var n int64
+			err := srcMount.guard.call("copy", srcRel, func() (err error) {
+				n, err = copier.Copy(srcRel, dstRel)
+				return err
+			})
+			return n, mfs.recordChange(done(err), ChangeWrite, dst, "")
+		}
+		done(nil)
+	}
+
+	r, err := fs.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	w, err := fs.OpenWrite(dst)
+	if err != nil {
+		return 0, err
+	}
+	buf := bufpool.Get(copyBufferSize)
+	defer bufpool.Put(buf)
+	n, err := io.CopyBuffer(w, r, buf)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// copyAll copies the tree of src to dst through fs, the MountableFS or a view of it, with
+// the copier of fs
+func copyAll(fs filesystem.FileSystem, copier filesystem.Copier, src, dst string) (filesystem.CopyStats, error) {
+	var stats filesystem.CopyStats
+	src = filesystem.NormalizePath(src)
+	dst = filesystem.NormalizePath(dst)
+
+	// Copying a live tree into itself would never terminate
+	if src == "/" || dst == src || strings.HasPrefix(dst, src+"/") {
+		return stats, filesystem.NewInvalidArgumentError("dest", dst, "cannot copy a directory into itself")
+	}
+
+	err := filesystem.Walk(fs, src, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := dst
+		if p != src {
+			target = path.Join(dst, strings.TrimPrefix(p, src))
+		}
+		if info.IsDir {
+			if err := filesystem.MkdirAll(fs, target, 0755); err != nil {
+				return err
+			}
+			stats.Dirs++
+			return nil
+		}
+		if err := filesystem.MkdirAll(fs, path.Dir(target), 0755); err != nil {
+			return err
+		}
+		n, err := copier.Copy(p, target)
+		if err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Bytes += n
+		return nil
+	})
+	return stats, err
+}
@@ -0,0 +1,212 @@
+package mountablefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCrashReports is the number of recent crash reports kept per mount
+const maxCrashReports = 10
+
+// CrashReport describes a panic recovered from a plugin call
+type CrashReport struct {
+	Time  time.Time `json:"time"`
+	Op    string    `json:"op"`
+	Path  string    `json:"path"`
+	Panic string    `json:"panic"`
+	Stack string    `json:"stack"`
+}
+
+// CrashStats describes the panics recovered from a mount's plugin
+type CrashStats struct {
+	Crashes  int64         `json:"crashes"`
+	Disabled bool          `json:"disabled"`         // Set once the mount reached its crash limit
+	Recent   []CrashReport `json:"recent,omitempty"` // Most recent last
+}
+
+// crashGuard recovers panics of a mount's plugin calls and keeps track of them
+type crashGuard struct {
+	mountPath string
+	limit     int // Crashes after which the mount is disabled, 0 never disables it
+
+	mu      sync.Mutex // protects the fields below
+	crashes int64
+	recent  []CrashReport
+}
+
+func newCrashGuard(mountPath string, limit int) *crashGuard {
+	return &crashGuard{mountPath: mountPath, limit: limit}
+}
+
+// disabled checks if the mount reached its crash limit
+func (g *crashGuard) disabled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.limit > 0 && g.crashes >= int64(g.limit)
+}
+
+// call runs fn, converting a panic into an error and recording it
+func (g *crashGuard) call(op, path string, fn func() error) (err error) {
+	if g.disabled() {
+		return filesystem.NewUnavailableError(op, path, fmt.Sprintf("mount disabled after %d crashes, remount it to enable it again", g.limit))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = g.crashed(op, path, r)
+		}
+	}()
+	return fn()
+}
+
+// crashed records the panic value r of op on path and returns the error reported to the caller
+func (g *crashGuard) crashed(op, path string, r interface{}) error {
+	report := CrashReport{
+		Time:  time.Now(),
+		Op:    op,
+		Path:  path,
+		Panic: fmt.Sprint(r),
+		Stack: string(debug.Stack()),
+	}
+
+	g.mu.Lock()
+	g.crashes++
+	g.recent = append(g.recent, report)
+	if len(g.recent) > maxCrashReports {
+		g.recent = g.recent[len(g.recent)-maxCrashReports:]
+	}
+	disable := g.limit > 0 && g.crashes == int64(g.limit)
+	g.mu.Unlock()
+
+	log.Errorf("[mountablefs] plugin at %s panicked in %s %s: %v\n%s", g.mountPath, op, path, r, report.Stack)
+	if disable {
+		log.Errorf("[mountablefs] mount %s disabled after %d crashes", g.mountPath, g.limit)
+	}
+	return fmt.Errorf("%s %s: plugin crashed: %v", op, path, r)
+}
+
+// Stats returns the crashes of the mount
+func (g *crashGuard) Stats() CrashStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return CrashStats{
+		Crashes:  g.crashes,
+		Disabled: g.limit > 0 && g.crashes >= int64(g.limit),
+		Recent:   append([]CrashReport(nil), g.recent...),
+	}
+}
+
+// guardFS wraps a plugin FileSystem so that a panic in one of its methods fails only the
+// operation instead of the request goroutine or a pool worker
+type guardFS struct {
+	filesystem.FileSystem
+	*crashGuard
+}
+
+// WithContext implements filesystem.ContextBinder by binding the plugin
+func (g *guardFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &guardFS{FileSystem: filesystem.WithContext(g.FileSystem, ctx), crashGuard: g.crashGuard}
+}
+
+func (g *guardFS) Create(path string) error {
+	return g.call("create", path, func() error { return g.FileSystem.Create(path) })
+}
+
+func (g *guardFS) Mkdir(path string, perm uint32) error {
+	return g.call("mkdir", path, func() error { return g.FileSystem.Mkdir(path, perm) })
+}
+
+func (g *guardFS) Remove(path string) error {
+	return g.call("remove", path, func() error { return g.FileSystem.Remove(path) })
+}
+
+func (g *guardFS) RemoveAll(path string) error {
+	return g.call("removeall", path, func() error { return g.FileSystem.RemoveAll(path) })
+}
+
+func (g *guardFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	var data []byte
+	err := g.call("read", path, func() (err error) {
+		data, err = g.FileSystem.Read(path, offset, size)
+		return err
+	})
+	return data, err
+}
+
+func (g *guardFS) Write(path string, data []byte) ([]byte, error) {
+	var result []byte
+	err := g.call("write", path, func() (err error) {
+		result, err = g.FileSystem.Write(path, data)
+		return err
+	})
+	return result, err
+}
+
+func (g *guardFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	var infos []filesystem.FileInfo
+	err := g.call("readdir", path, func() (err error) {
+		infos, err = g.FileSystem.ReadDir(path)
+		return err
+	})
+	return infos, err
+}
+
+func (g *guardFS) Stat(path string) (*filesystem.FileInfo, error) {
+	var info *filesystem.FileInfo
+	err := g.call("stat", path, func() (err error) {
+		info, err = g.FileSystem.Stat(path)
+		return err
+	})
+	return info, err
+}
+
+func (g *guardFS) Rename(oldPath, newPath string) error {
+	return g.call("rename", oldPath, func() error { return g.FileSystem.Rename(oldPath, newPath) })
+}
+
+func (g *guardFS) Chmod(path string, mode uint32) error {
+	return g.call("chmod", path, func() error { return g.FileSystem.Chmod(path, mode) })
+}
+
+func (g *guardFS) Open(path string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := g.call("open", path, func() (err error) {
+		r, err = g.FileSystem.Open(path)
+		return err
+	})
+	return r, err
+}
+
+func (g *guardFS) OpenWrite(path string) (io.WriteCloser, error) {
+	var w io.WriteCloser
+	err := g.call("openwrite", path, func() (err error) {
+		w, err = g.FileSystem.OpenWrite(path)
+		return err
+	})
+	return w, err
+}
+
+// CrashStats returns the panics recovered from the mount's plugin
+func (mp *MountPoint) CrashStats() CrashStats {
+	return mp.guard.Stats()
+}
+
+// CrashStats returns the crash statistics of every mount whose plugin panicked,
+// keyed by mount path
+func (mfs *MountableFS) CrashStats() map[string]CrashStats {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+	stats := make(map[string]CrashStats)
+	for p, mount := range mfs.mounts {
+		if s := mount.CrashStats(); s.Crashes > 0 {
+			stats[p] = s
+		}
+	}
+	return stats
+}
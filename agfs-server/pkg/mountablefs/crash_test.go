@@ -0,0 +1,80 @@
+package mountablefs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// panicFS panics on every Stat of /boom, like a plugin with a nil pointer bug
+type panicFS struct {
+	filesystem.FileSystem
+}
+
+func (p *panicFS) Stat(path string) (*filesystem.FileInfo, error) {
+	if path == "/boom" {
+		var info *filesystem.FileInfo
+		_ = info.Name
+	}
+	return p.FileSystem.Stat(path)
+}
+
+// panicPlugin serves a panicFS over memfs
+type panicPlugin struct {
+	*memfs.MemFSPlugin
+}
+
+func (p *panicPlugin) GetFileSystem() filesystem.FileSystem {
+	return &panicFS{FileSystem: p.MemFSPlugin.GetFileSystem()}
+}
+
+func TestPluginPanicIsRecovered(t *testing.T) {
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	mfs := NewMountableFS()
+	if err := mfs.MountWithOptions("/m", &panicPlugin{MemFSPlugin: mem}, MountOptions{Workers: 1, QueueSize: 1, CrashLimit: 2}); err != nil {
+		t.Fatal(err)
+	}
+	defer mfs.Unmount("/m")
+
+	if _, err := mfs.Stat("/m/boom"); err == nil || !strings.Contains(err.Error(), "plugin crashed") {
+		t.Fatalf("stat error = %v, want a crash", err)
+	}
+	// The pool worker survived the panic
+	if _, err := mfs.Write("/m/a", []byte("hello")); err != nil {
+		t.Fatalf("write after a crash: %v", err)
+	}
+
+	stats := mfs.CrashStats()["/m"]
+	if stats.Crashes != 1 || stats.Disabled || len(stats.Recent) != 1 {
+		t.Fatalf("crash stats = %+v", stats)
+	}
+	if r := stats.Recent[0]; r.Op != "stat" || r.Path != "/boom" || !strings.Contains(r.Stack, "panicFS") {
+		t.Errorf("crash report = %+v", r)
+	}
+
+	// Reaching the limit disables the mount
+	mfs.Stat("/m/boom")
+	if _, err := mfs.Read("/m/a", 0, -1); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("read of a disabled mount: error %v, want unavailable", err)
+	}
+	if stats := mfs.CrashStats()["/m"]; stats.Crashes != 2 || !stats.Disabled {
+		t.Errorf("crash stats = %+v", stats)
+	}
+}
+
+func TestCrashLimitOption(t *testing.T) {
+	opts, cfg, err := SplitMountOptions(map[string]interface{}{"crash_limit": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.CrashLimit != 3 || len(cfg) != 0 {
+		t.Errorf("crash limit = %d, plugin config = %v", opts.CrashLimit, cfg)
+	}
+	if _, _, err := SplitMountOptions(map[string]interface{}{"crash_limit": -1}); err == nil {
+		t.Errorf("negative crash limit accepted")
+	}
+}
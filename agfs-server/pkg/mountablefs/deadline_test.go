@@ -0,0 +1,108 @@
+package mountablefs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// slowFS blocks a Stat of /slow until its context is done, like a backend that never answers
+type slowFS struct {
+	filesystem.FileSystem
+	ctx context.Context
+}
+
+func (c *slowFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &slowFS{FileSystem: c.FileSystem, ctx: ctx}
+}
+
+func (c *slowFS) Stat(p string) (*filesystem.FileInfo, error) {
+	if c.ctx == nil || p != "/slow" {
+		return c.FileSystem.Stat(p)
+	}
+	<-c.ctx.Done()
+	return nil, c.ctx.Err()
+}
+
+// slowPlugin serves a slowFS over memfs
+type slowPlugin struct {
+	*memfs.MemFSPlugin
+}
+
+func (p *slowPlugin) GetFileSystem() filesystem.FileSystem {
+	return &slowFS{FileSystem: p.MemFSPlugin.GetFileSystem()}
+}
+
+func TestDeadlineReachesPlugin(t *testing.T) {
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	p := &slowPlugin{MemFSPlugin: mem}
+
+	mfs := NewMountableFS()
+	opts := MountOptions{Deadline: time.Minute, OpDeadlines: map[string]time.Duration{"stat": 20 * time.Millisecond},
+		Checksum: ChecksumXXH3, Trash: true, CacheTTL: time.Minute, Workers: 2, QueueSize: 2}
+	if err := mfs.MountWithOptions("/m", p, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer mfs.Unmount("/m")
+
+	// The context passes through every mount wrapper down to the plugin
+	start := time.Now()
+	_, err := mfs.Stat("/m/slow")
+	if !errors.Is(err, filesystem.ErrTimeout) {
+		t.Fatalf("stat error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("stat returned after %v", elapsed)
+	}
+
+	// Other operations use the mount-wide deadline
+	if _, err := mfs.Write("/m/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := mfs.Read("/m/a", 0, -1); string(data) != "hello" {
+		t.Errorf("read = %q, %v", data, err)
+	}
+}
+
+func TestDeadlineOptions(t *testing.T) {
+	opts, cfg, err := SplitMountOptions(map[string]interface{}{"deadline": "10s", "read_deadline": "1m", "timeout": "5s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.deadline("read") != time.Minute || opts.deadline("stat") != 10*time.Second {
+		t.Errorf("deadlines = %v, %v", opts.deadline("read"), opts.deadline("stat"))
+	}
+	// Plugin settings with similar names are left to the plugin
+	if len(cfg) != 1 || cfg["timeout"] != "5s" {
+		t.Errorf("plugin config = %v", cfg)
+	}
+	if _, _, err := SplitMountOptions(map[string]interface{}{"stat_deadline": "-1s"}); err == nil {
+		t.Errorf("negative deadline accepted")
+	}
+}
+
+func TestCancellationReachesPlugin(t *testing.T) {
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	mfs := NewMountableFS()
+	if err := mfs.MountWithOptions("/m", &slowPlugin{MemFSPlugin: mem}, MountOptions{Workers: 1, QueueSize: 1}); err != nil {
+		t.Fatal(err)
+	}
+	defer mfs.Unmount("/m")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := mfs.WithContext(ctx).Stat("/m/slow"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("stat error = %v, want context.Canceled", err)
+	}
+
+	// The plugin call ended with the context, so the only worker is free again
+	if _, err := mfs.WithContext(context.Background()).Write("/m/a", nil); err != nil {
+		t.Errorf("write after a canceled stat: %v", err)
+	}
+}
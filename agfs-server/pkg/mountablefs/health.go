@@ -0,0 +1,21 @@
+package mountablefs
+
+import (
+	"context"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// HealthCheck checks the backend of the mount's plugin if it implements
+// plugin.HealthChecker; checked is false if it does not. A mount disabled by its crash
+// limit fails the check
+func (mp *MountPoint) HealthCheck(ctx context.Context) (checked bool, err error) {
+	checker, ok := mp.Plugin.(plugin.HealthChecker)
+	if !ok {
+		if mp.guard.disabled() {
+			return true, mp.guard.call("health", "/", func() error { return nil })
+		}
+		return false, nil
+	}
+	return true, mp.guard.call("health", "/", func() error { return checker.HealthCheck(ctx) })
+}
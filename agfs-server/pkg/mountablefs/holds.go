@@ -0,0 +1,152 @@
+package mountablefs
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Keys added to FileInfo.Meta.Content of held paths
+const (
+	MetaKeyLegalHold   = "legal_hold"   // "true" while a legal hold protects the path
+	MetaKeyRetainUntil = "retain_until" // RFC 3339 time until which the path is immutable
+)
+
+// Hold protects a path and everything below it from being modified, renamed or removed
+// A legal hold lasts until it is released, a retention until its time passes.
+type Hold struct {
+	Path        string     `json:"path"`
+	LegalHold   bool       `json:"legalHold"`
+	RetainUntil *time.Time `json:"retainUntil,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	By          string     `json:"by,omitempty"` // Principal who placed it
+	Created     time.Time  `json:"created"`
+}
+
+// Active reports whether the hold protects its path at now
+func (h *Hold) Active(now time.Time) bool {
+	return h.LegalHold || (h.RetainUntil != nil && now.Before(*h.RetainUntil))
+}
+
+// HoldSource reports the active holds on paths
+type HoldSource interface {
+	// HoldOn returns the active hold on path or on a directory above it, if any
+	HoldOn(path string) (Hold, bool)
+	// HoldUnder returns the active hold on path, above it or below it, if any
+	HoldUnder(path string) (Hold, bool)
+}
+
+// holdSource holds the HoldSource of a MountableFS, if any
+type holdSource struct {
+	p atomic.Pointer[HoldSource]
+}
+
+// SetHolds makes the paths src reports held undeletable and unmodifiable, by every caller
+// including the server itself; nil removes it. Stat and listings report held paths in
+// Meta.Content under MetaKeyLegalHold and MetaKeyRetainUntil.
+func (mfs *MountableFS) SetHolds(src HoldSource) {
+	if src == nil {
+		mfs.holds.p.Store(nil)
+		return
+	}
+	mfs.holds.p.Store(&src)
+}
+
+// CheckHoldTree returns a permission denied error if p or anything below it is held, for
+// changes made around the file system such as those of the HTTP endpoints of plugins
+func (mfs *MountableFS) CheckHoldTree(op, p string) error {
+	return mfs.checkHold(op, p, true)
+}
+
+// checkHold returns a permission denied error if p is held, or with tree anything below it
+func (mfs *MountableFS) checkHold(op, p string, tree bool) error {
+	src := mfs.holds.p.Load()
+	if src == nil {
+		return nil
+	}
+	p = filesystem.NormalizePath(p)
+	var h Hold
+	var held bool
+	if tree {
+		h, held = (*src).HoldUnder(p)
+	} else {
+		h, held = (*src).HoldOn(p)
+	}
+	if !held {
+		return nil
+	}
+	reason := "under legal hold at " + h.Path
+	if !h.LegalHold {
+		reason = "immutable until " + h.RetainUntil.Format(time.RFC3339) + " by retention at " + h.Path
+	}
+	return filesystem.NewPermissionDeniedError(op, p, reason)
+}
+
+// annotated returns info with the hold protecting p, if any, in its metadata
+// info is copied rather than changed, plugins and caches may share it
+func annotated(src HoldSource, info filesystem.FileInfo, p string) (filesystem.FileInfo, bool) {
+	h, held := src.HoldOn(p)
+	if !held {
+		return info, false
+	}
+	content := make(map[string]string, len(info.Meta.Content)+2)
+	for k, v := range info.Meta.Content {
+		content[k] = v
+	}
+	if h.LegalHold {
+		content[MetaKeyLegalHold] = "true"
+	}
+	if h.RetainUntil != nil && time.Now().Before(*h.RetainUntil) {
+		content[MetaKeyRetainUntil] = h.RetainUntil.Format(time.RFC3339)
+	}
+	info.Meta.Content = content
+	return info, true
+}
+
+// annotateHolds returns the listing of dir with the holds of its entries
+func (mfs *MountableFS) annotateHolds(dir string, infos []filesystem.FileInfo) []filesystem.FileInfo {
+	src := mfs.holds.p.Load()
+	if src == nil {
+		return infos
+	}
+	dir = filesystem.NormalizePath(dir)
+	var out []filesystem.FileInfo
+	for i := range infos {
+		info, held := annotated(*src, infos[i], path.Join(dir, infos[i].Name))
+		if !held {
+			continue
+		}
+		if out == nil {
+			out = make([]filesystem.FileInfo, len(infos))
+			copy(out, infos)
+		}
+		out[i] = info
+	}
+	if out == nil {
+		return infos
+	}
+	return out
+}
+
+func (mfs *MountableFS) stat(ctx context.Context, p string) (*filesystem.FileInfo, error) {
+	info, err := mfs.statPath(ctx, p)
+	if src := mfs.holds.p.Load(); src != nil && err == nil {
+		if held, ok := annotated(*src, *info, filesystem.NormalizePath(p)); ok {
+			return &held, nil
+		}
+	}
+	return info, err
+}
+
+func (mfs *MountableFS) readDir(ctx context.Context, dir string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	infos, err := mfs.listDir(ctx, dir, fields)
+	return mfs.annotateHolds(dir, infos), err
+}
+
+func (mfs *MountableFS) readDirPage(ctx context.Context, dir string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	infos, more, err := mfs.listDirPage(ctx, dir, page)
+	return mfs.annotateHolds(dir, infos), more, err
+}
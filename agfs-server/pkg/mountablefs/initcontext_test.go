@@ -0,0 +1,93 @@
+package mountablefs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// contextPlugin records the InitContext it is initialized with
+type contextPlugin struct {
+	*memfs.MemFSPlugin
+	ictx *plugin.InitContext
+}
+
+func (p *contextPlugin) Validate(cfg map[string]interface{}) error {
+	return nil
+}
+
+func (p *contextPlugin) InitializeContext(ictx *plugin.InitContext) error {
+	p.ictx = ictx
+	return p.MemFSPlugin.Initialize(map[string]interface{}{})
+}
+
+// legacyPlugin only has the map-based Initialize and SetRootFS
+type legacyPlugin struct {
+	*memfs.MemFSPlugin
+	rootFS filesystem.FileSystem
+	config map[string]interface{}
+}
+
+func (p *legacyPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *legacyPlugin) Initialize(cfg map[string]interface{}) error {
+	p.config = cfg
+	return p.MemFSPlugin.Initialize(cfg)
+}
+
+func TestPluginInitContext(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("AGFS_TEST_TOKEN", "s3cret")
+	mfs := NewMountableFS()
+	mfs.SetPluginServices(plugin.Services{DataDir: dataDir})
+
+	cp := &contextPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	lp := &legacyPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	mfs.RegisterPluginFactory("ctxfs", func() plugin.ServicePlugin { return cp })
+	mfs.RegisterPluginFactory("legacyfs", func() plugin.ServicePlugin { return lp })
+
+	if err := mfs.MountPlugin("ctxfs", "/data/ctx", map[string]interface{}{"token": "env:AGFS_TEST_TOKEN"}); err != nil {
+		t.Fatal(err)
+	}
+	ictx := cp.ictx
+	if ictx == nil {
+		t.Fatal("InitializeContext was not called")
+	}
+	if ictx.Name != "ctxfs" || ictx.MountPath != "/data/ctx" || ictx.Config["mount_path"] != "/data/ctx" {
+		t.Errorf("context = %+v", ictx)
+	}
+	if ictx.RootFS != mfs {
+		t.Error("root file system is not the mountable file system")
+	}
+	if token, err := ictx.Secret("token"); err != nil || token != "s3cret" {
+		t.Errorf("Secret = %q, %v", token, err)
+	}
+	if _, err := ictx.Secret("missing"); err != nil {
+		t.Errorf("Secret of an unset key: %v", err)
+	}
+	dir, err := ictx.DataDir()
+	if err != nil || dir != filepath.Join(dataDir, "data_ctx") {
+		t.Errorf("DataDir = %q, %v", dir, err)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("data directory not created: %v", err)
+	}
+	ictx.Metrics.Add("calls", 1)
+	if got := mfs.InitContext("ctxfs", "/data/ctx", nil).Metrics.Get("calls"); got == nil || got.String() != "1" {
+		t.Errorf("metrics of a remount = %v", got)
+	}
+
+	// Plugins without InitializeContext keep being initialized the old way
+	if err := mfs.MountPlugin("legacyfs", "/legacy", map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if lp.rootFS != mfs || lp.config["mount_path"] != "/legacy" {
+		t.Errorf("legacy plugin: rootFS %v, config %v", lp.rootFS, lp.config)
+	}
+}
@@ -1,6 +1,7 @@
 package mountablefs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/loader"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,9 +24,212 @@ const (
 
 // MountPoint represents a mounted service plugin
 type MountPoint struct {
-	Path   string
-	Plugin plugin.ServicePlugin
-	Config map[string]interface{} // Plugin configuration
+	Path    string
+	Plugin  plugin.ServicePlugin
+	Config  map[string]interface{} // Plugin configuration
+	Options MountOptions           // Mount-level features handled by MountableFS
+
+	fs      filesystem.FileSystem // Plugin filesystem wrapped with mount-level features
+	closers []io.Closer           // Resources owned by the wrappers, released on unmount
+	guard   *crashGuard           // Recovers panics of the plugin
+	cache   *cacheFS              // Stat and ReadDir cache, nil if disabled
+	pool    *poolFS               // Worker pool running the plugin's operations, nil if disabled
+	shadow  *shadowMirror         // Mirror of the writes, nil if disabled
+	sums    *checksumFS           // Checksum layer, nil if disabled
+
+	readLimiter  *throttle.Limiter // Shared by all reads from the mount, nil if unlimited
+	writeLimiter *throttle.Limiter // Shared by all writes to the mount, nil if unlimited
+}
+
+// newMountPoint creates a mount point and wraps the plugin filesystem according to opts;
+// root is the tree the shadow directory of opts is resolved in
+func newMountPoint(root filesystem.FileSystem, path string, p plugin.ServicePlugin, config map[string]interface{}, opts MountOptions) *MountPoint {
+	mount := &MountPoint{
+		Path:         path,
+		Plugin:       p,
+		Config:       config,
+		Options:      opts,
+		readLimiter:  throttle.NewLimiter(opts.ReadBPS),
+		writeLimiter: throttle.NewLimiter(opts.WriteBPS),
+		guard:        newCrashGuard(path, opts.CrashLimit),
+	}
+
+	// The guard sits directly on the plugin so a panic never reaches a pool worker
+	var fs filesystem.FileSystem = &guardFS{FileSystem: p.GetFileSystem(), crashGuard: mount.guard}
+	mount.fs = fs
+	if opts.Workers > 0 {
+		// The pool is innermost so that only calls into the plugin occupy workers
+		mount.pool = newPoolFS(fs, path, opts.Workers, opts.QueueSize, opts.OpTimeout)
+		fs = mount.pool
+		mount.fs = fs
+		mount.closers = append(mount.closers, mount.pool)
+	}
+	if opts.Checksum != "" {
+		mount.sums = newChecksumFS(fs, opts.Checksum)
+		fs = mount.sums
+		mount.fs = fs
+	}
+	if opts.Trash {
+		// Trash wraps the checksum layer so trashed files keep their checksums
+		// Held entries are kept in the trash past the retention
+		var held func(p string) bool
+		if mfs, ok := root.(*MountableFS); ok {
+			prefix := strings.TrimSuffix(path, "/")
+			held = func(p string) bool { return mfs.checkHold("purge", prefix+p, true) != nil }
+		}
+		trash := newTrashFS(fs, opts.TrashRetention, held)
+		fs = trash
+		mount.fs = fs
+		mount.closers = append(mount.closers, trash)
+	}
+	if opts.Scanner != nil {
+		// Scanning is outermost so flagged content never reaches the other layers
+		fs = newScanFS(fs, opts.Scanner, opts.ScanAction, opts.ScanOnError)
+		mount.fs = fs
+	}
+	if opts.CacheTTL > 0 || opts.NegativeTTL > 0 {
+		// The cache serves the view of all other layers, e.g. without the trash directory
+		mount.cache = newCacheFS(fs, opts.CacheTTL, opts.NegativeTTL, opts.CacheSize)
+		fs = mount.cache
+		mount.fs = fs
+	}
+	if opts.Shadow != "" {
+		// The shadow gets the writes that passed all other layers
+		mount.shadow = newShadowMirror(root, opts.Shadow, opts.ShadowQueue)
+		fs = &shadowFS{FileSystem: fs, mirror: mount.shadow}
+		mount.fs = fs
+		mount.closers = append(mount.closers, mount.shadow)
+	}
+	if opts.Stats {
+		// Counting is outermost so the stats show the operations clients made
+		fs = plugin.NewStatsFS(fs, p)
+		mount.fs = fs
+	}
+
+	return mount
+}
+
+// FileSystem returns the filesystem used to serve operations on this mount
+func (mp *MountPoint) FileSystem() filesystem.FileSystem {
+	return mp.fs
+}
+
+// bind returns the filesystem serving op on path, bound to parent and to the mount's deadline
+// for op if it has one, and a function the caller passes the operation's error through: it
+// releases the deadline and reports it or a backend timeout passing as filesystem.ErrTimeout
+func (mp *MountPoint) bind(parent context.Context, op, path string) (filesystem.FileSystem, func(error) error) {
+	ctx, done := mp.opContext(parent, op, path)
+	return filesystem.WithContext(mp.FileSystem(), ctx), done
+}
+
+// opContext returns parent bound to the mount's deadline for op and the function releasing it
+// that the caller passes the operation's error through, see bind
+func (mp *MountPoint) opContext(parent context.Context, op, path string) (context.Context, func(error) error) {
+	ctx, cancel := parent, context.CancelFunc(func() {})
+	d := mp.Options.deadline(op)
+	if d > 0 {
+		ctx, cancel = context.WithTimeout(parent, d)
+	}
+	return ctx, func(err error) error {
+		cancel()
+		return filesystem.ContextError(ctx, op, path, d, err)
+	}
+}
+
+// appender returns the plugin bound to ctx if it appends in place and no layer of the
+// mount has to see whole files; the cache learns about appends from the change journal
+func (mp *MountPoint) appender(ctx context.Context) (filesystem.Appender, bool) {
+	if mp.Options.wholeFiles() {
+		return nil, false
+	}
+	appender, ok := filesystem.WithContext(mp.Plugin.GetFileSystem(), ctx).(filesystem.Appender)
+	return appender, ok
+}
+
+// writerAt returns the plugin bound to ctx if it writes at offsets in place and no layer of
+// the mount has to see whole files
+func (mp *MountPoint) writerAt(ctx context.Context) (filesystem.WriterAt, bool) {
+	if mp.Options.wholeFiles() {
+		return nil, false
+	}
+	writer, ok := filesystem.WithContext(mp.Plugin.GetFileSystem(), ctx).(filesystem.WriterAt)
+	return writer, ok
+}
+
+// readDir lists relPath of the mount, asking the plugin for the selected fields only if
+// no layer of the mount takes part in listings
+func (mp *MountPoint) readDir(ctx context.Context, path, relPath string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	if fields.All() || !mp.Options.plainListings() {
+		fs, done := mp.bind(ctx, "readdir", path)
+		infos, err := fs.ReadDir(relPath)
+		return infos, done(err)
+	}
+	opCtx, done := mp.opContext(ctx, "readdir", path)
+	fs := filesystem.WithContext(mp.Plugin.GetFileSystem(), opCtx)
+	var infos []filesystem.FileInfo
+	err := mp.guard.call("readdir", relPath, func() (err error) {
+		infos, err = filesystem.ReadDirFields(fs, relPath, fields)
+		return err
+	})
+	return infos, done(err)
+}
+
+// readDirPage lists page of relPath of the mount, asking the plugin for the page only if no
+// layer of the mount takes part in listings
+func (mp *MountPoint) readDirPage(ctx context.Context, path, relPath string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	if !mp.Options.plainListings() {
+		fs, done := mp.bind(ctx, "readdir", path)
+		infos, more, err := filesystem.ReadDirPage(fs, relPath, page)
+		return infos, more, done(err)
+	}
+	opCtx, done := mp.opContext(ctx, "readdir", path)
+	fs := filesystem.WithContext(mp.Plugin.GetFileSystem(), opCtx)
+	var infos []filesystem.FileInfo
+	var more bool
+	err := mp.guard.call("readdir", relPath, func() (err error) {
+		infos, more, err = filesystem.ReadDirPage(fs, relPath, page)
+		return err
+	})
+	return infos, more, done(err)
+}
+
+// readFormat reads relPath of the mount in format if the plugin offers it and no layer of
+// the mount takes part in reads; otherwise the file is read as is
+func (mp *MountPoint) readFormat(ctx context.Context, path, relPath string, offset, size int64, format string) ([]byte, string, error) {
+	if data, served, ok, err := mp.readReadme(ctx, path, relPath, offset, size, format); ok {
+		return data, served, err
+	}
+	if format == "" || !mp.Options.plainReads() {
+		fs, done := mp.bind(ctx, "read", path)
+		data, err := fs.Read(relPath, offset, size)
+		return data, "", done(err)
+	}
+	opCtx, done := mp.opContext(ctx, "read", path)
+	fs := filesystem.WithContext(mp.Plugin.GetFileSystem(), opCtx)
+	var data []byte
+	var served string
+	err := mp.guard.call("read", relPath, func() (err error) {
+		data, served, err = filesystem.ReadFormat(fs, relPath, offset, size, format)
+		return err
+	})
+	return data, served, done(err)
+}
+
+// PoolStats returns the activity of the mount's worker pool, ok is false if it has none
+func (mp *MountPoint) PoolStats() (stats PoolStats, ok bool) {
+	if mp.pool == nil {
+		return PoolStats{}, false
+	}
+	return mp.pool.Stats(), true
+}
+
+// close releases resources owned by the mount-level wrappers
+func (mp *MountPoint) close() {
+	for _, c := range mp.closers {
+		if err := c.Close(); err != nil {
+			log.Warnf("failed to close mount wrapper at %s: %v", mp.Path, err)
+		}
+	}
 }
 
 // PluginFactory is a function that creates a new plugin instance
@@ -37,18 +242,27 @@ type MountableFS struct {
 	pluginFactories    map[string]PluginFactory
 	pluginLoader       *loader.PluginLoader // For loading external plugins
 	pluginNameCounters map[string]int       // Track counters for plugin names
+	changes            *changeJournal       // Recent mutations, see Subscribe and ChangesSince
+	access             accessObservers      // See ObserveAccess
+	control            accessControl        // See SetAccessControl
+	holds              holdSource           // See SetHolds
+	appendLocks        filesystem.PathLocks // Serializes appends rewriting whole files
+	services           plugin.Services      // Handed to plugins through their InitContext
 	mu                 sync.RWMutex
 }
 
 // NewMountableFS creates a new mountable file system
 func NewMountableFS() *MountableFS {
-	return &MountableFS{
+	mfs := &MountableFS{
 		mounts:             make(map[string]*MountPoint),
 		mountPaths:         []string{},
 		pluginFactories:    make(map[string]PluginFactory),
 		pluginLoader:       loader.NewPluginLoader(),
 		pluginNameCounters: make(map[string]int),
+		changes:            newChangeJournal(),
 	}
+	mfs.Subscribe(mfs.invalidateCache)
+	return mfs
 }
 
 // GetPluginLoader returns the plugin loader instance
@@ -56,6 +270,29 @@ func (mfs *MountableFS) GetPluginLoader() *loader.PluginLoader {
 	return mfs.pluginLoader
 }
 
+// SetPluginServices sets the services handed to the plugins mounted from now on; the root
+// file system defaults to mfs
+func (mfs *MountableFS) SetPluginServices(s plugin.Services) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	mfs.services = s
+}
+
+// InitContext returns the context initializing plugin name at path with cfg
+func (mfs *MountableFS) InitContext(name, path string, cfg map[string]interface{}) *plugin.InitContext {
+	mfs.mu.RLock()
+	services := mfs.services
+	mfs.mu.RUnlock()
+	return mfs.initContext(services, name, path, cfg)
+}
+
+func (mfs *MountableFS) initContext(services plugin.Services, name, path string, cfg map[string]interface{}) *plugin.InitContext {
+	if services.RootFS == nil {
+		services.RootFS = mfs
+	}
+	return services.NewInitContext(name, filesystem.NormalizePath(path), cfg)
+}
+
 // RenamedPlugin wraps a plugin with a different name
 type RenamedPlugin struct {
 	plugin.ServicePlugin
@@ -73,6 +310,11 @@ func (rp *RenamedPlugin) OriginalName() string {
 	return rp.originalName
 }
 
+// InitializeContext initializes the wrapped plugin with ictx
+func (rp *RenamedPlugin) InitializeContext(ictx *plugin.InitContext) error {
+	return plugin.Initialize(rp.ServicePlugin, ictx)
+}
+
 // generateUniquePluginName generates a unique plugin name with incremental suffix
 // Must be called with mfs.mu held (write lock)
 func (mfs *MountableFS) generateUniquePluginName(baseName string) string {
@@ -118,8 +360,26 @@ func (mfs *MountableFS) CreatePlugin(name string) plugin.ServicePlugin {
 	return factory()
 }
 
+// PluginTypes returns the names of the registered plugin factories, sorted
+func (mfs *MountableFS) PluginTypes() []string {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	names := make([]string, 0, len(mfs.pluginFactories))
+	for name := range mfs.pluginFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Mount mounts a service plugin at the specified path
 func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
+	return mfs.MountWithOptions(path, plugin, MountOptions{})
+}
+
+// MountWithOptions mounts an initialized service plugin at the specified path with mount-level options
+func (mfs *MountableFS) MountWithOptions(path string, plugin plugin.ServicePlugin, opts MountOptions) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
 
@@ -132,11 +392,10 @@ func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
 	}
 
 	// Add mount (no config for static mounts)
-	mfs.mounts[path] = &MountPoint{
-		Path:   path,
-		Plugin: plugin,
-		Config: make(map[string]interface{}),
+	if err := checkShadow(path, opts); err != nil {
+		return err
 	}
+	mfs.mounts[path] = newMountPoint(mfs, path, plugin, make(map[string]interface{}), opts)
 
 	// Update mount paths list and sort by length (longest first)
 	mfs.mountPaths = append(mfs.mountPaths, path)
@@ -167,19 +426,18 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 	// Create plugin instance
 	pluginInstance := factory()
 
-	// Special handling for plugins that need rootFS reference
-	// Check if plugin has SetRootFS method (e.g., httpfs, proxyfs)
-	type rootFSSetter interface {
-		SetRootFS(filesystem.FileSystem)
+	// Separate mount-level options from the plugin configuration
+	opts, pluginConfig, err := SplitMountOptions(config)
+	if err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
 	}
-	if setter, ok := pluginInstance.(rootFSSetter); ok {
-		setter.SetRootFS(mfs)
-		log.Debugf("Set rootFS for plugin %s at %s", fstype, path)
+	if err := checkShadow(path, opts); err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
 	}
 
 	// Inject mount_path into config for plugins that need to know their virtual path
 	configWithPath := make(map[string]interface{})
-	for k, v := range config {
+	for k, v := range pluginConfig {
 		configWithPath[k] = v
 	}
 	configWithPath["mount_path"] = path
@@ -189,17 +447,13 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 		return fmt.Errorf("failed to validate plugin: %v", err)
 	}
 
-	// Initialize plugin with config
-	if err := pluginInstance.Initialize(configWithPath); err != nil {
+	// Initialize plugin with config and the server services
+	if err := plugin.Initialize(pluginInstance, mfs.initContext(mfs.services, fstype, path, configWithPath)); err != nil {
 		return fmt.Errorf("failed to initialize plugin: %v", err)
 	}
 
 	// Add mount
-	mfs.mounts[path] = &MountPoint{
-		Path:   path,
-		Plugin: pluginInstance,
-		Config: config,
-	}
+	mfs.mounts[path] = newMountPoint(mfs, path, pluginInstance, config, opts)
 
 	// Update mount paths list and sort by length (longest first)
 	mfs.mountPaths = append(mfs.mountPaths, path)
@@ -209,6 +463,41 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 	return nil
 }
 
+// ValidateMount checks a mount like MountPlugin without mounting it: the path must be free,
+// fstype registered, and the mount options and plugin configuration valid. The plugin is
+// not initialized, so backends are not contacted.
+func (mfs *MountableFS) ValidateMount(fstype string, path string, config map[string]interface{}) error {
+	path = filesystem.NormalizePath(path)
+
+	mfs.mu.RLock()
+	_, exists := mfs.mounts[path]
+	factory, ok := mfs.pluginFactories[fstype]
+	mfs.mu.RUnlock()
+	if exists {
+		return filesystem.NewAlreadyExistsError("mount", path)
+	}
+	if !ok {
+		return fmt.Errorf("unknown filesystem type: %s", fstype)
+	}
+
+	opts, pluginConfig, err := SplitMountOptions(config)
+	if err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
+	}
+	if err := checkShadow(path, opts); err != nil {
+		return fmt.Errorf("invalid mount options: %v", err)
+	}
+	configWithPath := make(map[string]interface{})
+	for k, v := range pluginConfig {
+		configWithPath[k] = v
+	}
+	configWithPath["mount_path"] = path
+	if err := factory().Validate(configWithPath); err != nil {
+		return fmt.Errorf("failed to validate plugin: %v", err)
+	}
+	return nil
+}
+
 // Unmount unmounts a plugin from the specified path
 func (mfs *MountableFS) Unmount(path string) error {
 	mfs.mu.Lock()
@@ -225,6 +514,7 @@ func (mfs *MountableFS) Unmount(path string) error {
 	if err := mount.Plugin.Shutdown(); err != nil {
 		return fmt.Errorf("failed to shutdown plugin: %v", err)
 	}
+	mount.close()
 
 	delete(mfs.mounts, path)
 
@@ -242,11 +532,12 @@ func (mfs *MountableFS) Unmount(path string) error {
 
 // LoadExternalPluginWithType loads a plugin with an explicitly specified type
 func (mfs *MountableFS) LoadExternalPluginWithType(libraryPath string, pluginType loader.PluginType) (plugin.ServicePlugin, error) {
-	// For WASM plugins, pass MountableFS as host filesystem to allow access to all agfs paths
+	// For WASM plugins, pass MountableFS as host filesystem; it can be restricted per plugin
+	// instance with the host_root and host_paths configuration keys
 	var p plugin.ServicePlugin
 	var err error
 	if pluginType == loader.PluginTypeWASM {
-		log.Infof("Loading WASM plugin with host filesystem access to all agfs paths")
+		log.Infof("Loading WASM plugin with host filesystem access to agfs paths")
 		p, err = mfs.pluginLoader.LoadPluginWithType(libraryPath, pluginType, mfs)
 	} else {
 		p, err = mfs.pluginLoader.LoadPluginWithType(libraryPath, pluginType)
@@ -367,6 +658,15 @@ func (mfs *MountableFS) GetMounts() []*MountPoint {
 	return mounts
 }
 
+// LookupMount returns the mount point holding path
+func (mfs *MountableFS) LookupMount(path string) (*MountPoint, bool) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	mount, _, ok := mfs.findMount(path)
+	return mount, ok
+}
+
 // findMount finds the mount point for a given path
 // Returns the mount and the relative path within the mount
 func (mfs *MountableFS) findMount(path string) (*MountPoint, string, bool) {
@@ -383,11 +683,27 @@ func (mfs *MountableFS) findMount(path string) (*MountPoint, string, bool) {
 			relPath := strings.TrimPrefix(path, mountPath)
 			return mfs.mounts[mountPath], relPath, true
 		}
+		if mountPath == "/" {
+			// A root mount holds every path outside the other mounts
+			return mfs.mounts[mountPath], path, true
+		}
 	}
 
 	return nil, "", false
 }
 
+// PathLimiters implements throttle.PathLimiter with the bandwidth limits of the mount holding path
+func (mfs *MountableFS) PathLimiters(path string) (read, write *throttle.Limiter) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	mount, _, found := mfs.findMount(path)
+	if !found {
+		return nil, nil
+	}
+	return mount.readLimiter, mount.writeLimiter
+}
+
 // sortMountPaths sorts mount paths by length (longest first) for correct prefix matching
 func (mfs *MountableFS) sortMountPaths() {
 	sort.Slice(mfs.mountPaths, func(i, j int) bool {
@@ -398,72 +714,278 @@ func (mfs *MountableFS) sortMountPaths() {
 // Delegate all FileSystem methods to either base FS or mounted plugin
 
 func (mfs *MountableFS) Create(path string) error {
+	return mfs.create(context.Background(), path)
+}
+
+func (mfs *MountableFS) create(ctx context.Context, path string) error {
+	if err := mfs.checkHold("create", path, false); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Create(relPath)
+		fs, done := mount.bind(ctx, "create", path)
+		return mfs.recordChange(done(fs.Create(relPath)), ChangeCreate, path, "")
 	}
 	return filesystem.NewPermissionDeniedError("create", path, "not allowed to create file in rootfs, use mount instead")
 }
 
 func (mfs *MountableFS) Mkdir(path string, perm uint32) error {
+	return mfs.mkdir(context.Background(), path, perm)
+}
+
+func (mfs *MountableFS) mkdir(ctx context.Context, path string, perm uint32) error {
+	if err := mfs.checkHold("mkdir", path, false); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Mkdir(relPath, perm)
+		fs, done := mount.bind(ctx, "mkdir", path)
+		return mfs.recordChange(done(fs.Mkdir(relPath, perm)), ChangeMkdir, path, "")
 	}
 	return filesystem.NewPermissionDeniedError("mkdir", path, "not allowed to create directory in rootfs, use mount instead")
 }
 
 func (mfs *MountableFS) Remove(path string) error {
+	return mfs.remove(context.Background(), path)
+}
+
+func (mfs *MountableFS) remove(ctx context.Context, path string) error {
+	if err := mfs.checkHold("remove", path, true); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Remove(relPath)
+		fs, done := mount.bind(ctx, "remove", path)
+		return mfs.recordChange(done(fs.Remove(relPath)), ChangeRemove, path, "")
 	}
 	return filesystem.NewNotFoundError("remove", path)
 }
 
 func (mfs *MountableFS) RemoveAll(path string) error {
+	return mfs.removeAll(context.Background(), path)
+}
+
+func (mfs *MountableFS) removeAll(ctx context.Context, path string) error {
+	if err := mfs.checkHold("remove", path, true); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().RemoveAll(relPath)
+		fs, done := mount.bind(ctx, "remove", path)
+		return mfs.recordChange(done(fs.RemoveAll(relPath)), ChangeRemoveAll, path, "")
 	}
 	return filesystem.NewNotFoundError("removeall", path)
 }
 
 func (mfs *MountableFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return mfs.read(context.Background(), path, offset, size)
+}
+
+func (mfs *MountableFS) read(ctx context.Context, path string, offset int64, size int64) ([]byte, error) {
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Read(relPath, offset, size)
+		if data, _, ok, err := mount.readReadme(ctx, path, relPath, offset, size, ""); ok {
+			return data, err
+		}
+		fs, done := mount.bind(ctx, "read", path)
+		data, err := fs.Read(relPath, offset, size)
+		return data, mfs.recordAccess(done(err), AccessRead, path, int64(len(data)))
 	}
 	return nil, filesystem.NewNotFoundError("read", path)
 }
 
+// ReadFormat implements filesystem.FormatReader interface
+// Plugins implementing FormatReader read their control files in the requested format; on
+// mounts with layers taking part in reads the file is read as is
+func (mfs *MountableFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	return mfs.readFormat(context.Background(), path, offset, size, format)
+}
+
+func (mfs *MountableFS) readFormat(ctx context.Context, path string, offset int64, size int64, format string) ([]byte, string, error) {
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	mfs.mu.RUnlock()
+
+	if found {
+		data, actual, err := mount.readFormat(ctx, path, relPath, offset, size, format)
+		return data, actual, mfs.recordAccess(err, AccessRead, path, int64(len(data)))
+	}
+	return nil, "", filesystem.NewNotFoundError("read", path)
+}
+
 func (mfs *MountableFS) Write(path string, data []byte) ([]byte, error) {
+	return mfs.write(context.Background(), path, data)
+}
+
+func (mfs *MountableFS) write(ctx context.Context, path string, data []byte) ([]byte, error) {
+	if err := mfs.checkHold("write", path, false); err != nil {
+		return nil, err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Write(relPath, data)
+		fs, done := mount.bind(ctx, "write", path)
+		result, err := fs.Write(relPath, data)
+		err = mfs.recordChange(done(err), ChangeWrite, path, "")
+		return result, mfs.recordAccess(err, AccessWrite, path, int64(len(data)))
 	}
 	return nil, filesystem.NewNotFoundError("write", path)
 }
 
+// Append implements filesystem.Appender interface
+// Plugins implementing Appender append in place; for the others, and on mounts whose
+// checksum, scan or shadow layers have to see whole files, the file is read and written
+// back with appends to the same path serialized
+func (mfs *MountableFS) Append(path string, data []byte) (int64, error) {
+	return mfs.append(context.Background(), path, data)
+}
+
+func (mfs *MountableFS) append(ctx context.Context, path string, data []byte) (int64, error) {
+	if err := mfs.checkHold("append", path, false); err != nil {
+		return 0, err
+	}
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	mfs.mu.RUnlock()
+
+	if !found {
+		return 0, filesystem.NewNotFoundError("append", path)
+	}
+	opCtx, done := mount.opContext(ctx, "write", path)
+	var size int64
+	var err error
+	if appender, ok := mount.appender(opCtx); ok {
+		err = mount.guard.call("append", relPath, func() (err error) {
+			size, err = appender.Append(relPath, data)
+			return err
+		})
+	} else {
+		unlock := mfs.appendLocks.Lock(path)
+		size, err = filesystem.Append(filesystem.WithContext(mount.FileSystem(), opCtx), relPath, data)
+		unlock()
+	}
+	err = mfs.recordChange(done(err), ChangeWrite, path, "")
+	return size, mfs.recordAccess(err, AccessWrite, path, int64(len(data)))
+}
+
+// WriteAt implements filesystem.WriterAt interface
+// Plugins implementing WriterAt write in place, as do plugins implementing Appender when the
+// write starts at the end of the file; otherwise the file is read and written back with
+// writes to the same path serialized
+func (mfs *MountableFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	return mfs.writeAt(context.Background(), path, data, offset)
+}
+
+func (mfs *MountableFS) writeAt(ctx context.Context, path string, data []byte, offset int64) (int64, error) {
+	if err := mfs.checkHold("write", path, false); err != nil {
+		return 0, err
+	}
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	mfs.mu.RUnlock()
+
+	if !found {
+		return 0, filesystem.NewNotFoundError("write", path)
+	}
+	opCtx, done := mount.opContext(ctx, "write", path)
+	var size int64
+	var err error
+	if writer, ok := mount.writerAt(opCtx); ok {
+		err = mount.guard.call("write", relPath, func() (err error) {
+			size, err = writer.WriteAt(relPath, data, offset)
+			return err
+		})
+		err = mfs.recordChange(done(err), ChangeWrite, path, "")
+		return size, mfs.recordAccess(err, AccessWrite, path, int64(len(data)))
+	}
+
+	unlock := mfs.appendLocks.Lock(path)
+	defer unlock()
+	fs := filesystem.WithContext(mount.FileSystem(), opCtx)
+	if appender, ok := mount.appender(opCtx); ok && offset == fileSize(fs, relPath) {
+		err = mount.guard.call("append", relPath, func() (err error) {
+			size, err = appender.Append(relPath, data)
+			return err
+		})
+	} else {
+		size, err = filesystem.WriteAt(fs, relPath, data, offset)
+	}
+	err = mfs.recordChange(done(err), ChangeWrite, path, "")
+	return size, mfs.recordAccess(err, AccessWrite, path, int64(len(data)))
+}
+
+// fileSize returns the size of the file at path, or -1 if it cannot be determined
+func fileSize(fs filesystem.FileSystem, path string) int64 {
+	info, err := fs.Stat(path)
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			return 0
+		}
+		return -1
+	}
+	return info.Size
+}
+
 func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return mfs.readDir(context.Background(), path, filesystem.AllFields)
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+func (mfs *MountableFS) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	return mfs.readDir(context.Background(), path, fields)
+}
+
+// ReadDirPage implements filesystem.PageLister interface
+// Directories within a mount are paged by the plugin; listings that show other mounts are
+// read whole and paged here
+func (mfs *MountableFS) ReadDirPage(path string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	return mfs.readDirPage(context.Background(), path, page)
+}
+
+func (mfs *MountableFS) listDirPage(ctx context.Context, path string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	path = filesystem.NormalizePath(path)
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	if found {
+		pathPrefix := strings.TrimSuffix(path, "/") + "/"
+		for mountPath := range mfs.mounts {
+			if mountPath != path && strings.HasPrefix(mountPath, pathPrefix) {
+				found = false
+				break
+			}
+		}
+	}
+	mfs.mu.RUnlock()
+
+	if found {
+		return mount.readDirPage(ctx, path, relPath, page)
+	}
+	infos, err := mfs.listDir(ctx, path, filesystem.AllFields)
+	if err != nil {
+		return nil, false, err
+	}
+	infos, more := page.Apply(infos)
+	return infos, more, nil
+}
+
+func (mfs *MountableFS) listDir(ctx context.Context, path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
 	mfs.mu.RLock()
 	defer mfs.mu.RUnlock()
 
@@ -471,7 +993,7 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	path = filesystem.NormalizePath(path)
 
 	// If listing root, show all top-level mount point directories
-	if path == "/" {
+	if path == "/" && mfs.mounts["/"] == nil {
 		var infos []filesystem.FileInfo
 		seenDirs := make(map[string]bool)
 
@@ -510,22 +1032,17 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	mount, relPath, found := mfs.findMount(path)
 	if found {
 		// Get contents from the mounted filesystem
-		infos, err := mount.Plugin.GetFileSystem().ReadDir(relPath)
+		infos, err := mount.readDir(ctx, path, relPath, fields)
 		if err != nil {
-			return nil, err
+			// Under a root mount, parents of other mounts need not exist in the root
+			if mount.Path != "/" || !filesystem.IsNotFound(err) || !mfs.hasMountsUnder(path) {
+				return nil, err
+			}
+			infos = nil
 		}
 
 		// Check if there are any child mounts under this path that should be shown
-		// Build the full path we're listing
-		fullPath := path
-		if relPath != "/" {
-			// We're listing a subdirectory within a mount
-			fullPath = mount.Path
-			if relPath != "/" {
-				fullPath = fullPath + relPath
-			}
-		}
-		pathPrefix := fullPath + "/"
+		pathPrefix := strings.TrimSuffix(path, "/") + "/"
 
 		// Find child mount points
 		seenDirs := make(map[string]bool)
@@ -535,7 +1052,7 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 		// Look for mounts that are children of the current path
 		for mountPath := range mfs.mounts {
-			if strings.HasPrefix(mountPath, pathPrefix) {
+			if strings.HasPrefix(mountPath, pathPrefix) && mountPath != path {
 				// Extract the next level directory/mount name
 				remainder := strings.TrimPrefix(mountPath, pathPrefix)
 
@@ -613,13 +1130,17 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 }
 
 func (mfs *MountableFS) Stat(path string) (*filesystem.FileInfo, error) {
+	return mfs.stat(context.Background(), path)
+}
+
+func (mfs *MountableFS) statPath(ctx context.Context, path string) (*filesystem.FileInfo, error) {
 	mfs.mu.RLock()
 	defer mfs.mu.RUnlock()
 
 	path = filesystem.NormalizePath(path)
 
 	// Check if path is root
-	if path == "/" {
+	if path == "/" && mfs.mounts["/"] == nil {
 		return &filesystem.FileInfo{
 			Name:    "/",
 			Size:    0,
@@ -635,9 +1156,14 @@ func (mfs *MountableFS) Stat(path string) (*filesystem.FileInfo, error) {
 	// Check if path is a mount point or within a mount
 	mount, relPath, found := mfs.findMount(path)
 	if found {
-		stat, err := mount.Plugin.GetFileSystem().Stat(relPath)
-		if err != nil {
-			return nil, err
+		fs, done := mount.bind(ctx, "stat", path)
+		stat, err := fs.Stat(relPath)
+		if err = done(err); err != nil {
+			// Under a root mount, parents of other mounts need not exist in the root
+			if mount.Path != "/" || !filesystem.IsNotFound(err) || !mfs.hasMountsUnder(path) {
+				return nil, err
+			}
+			return mountParentInfo(path), nil
 		}
 
 		// If querying the mount point itself (not a file within it),
@@ -659,37 +1185,60 @@ func (mfs *MountableFS) Stat(path string) (*filesystem.FileInfo, error) {
 
 	// Check if path is a parent directory of any mount points
 	// For example, /mnt when mounts exist at /mnt/queue and /mnt/kv
+	if mfs.hasMountsUnder(path) {
+		return mountParentInfo(path), nil
+	}
+
+	return nil, filesystem.NewNotFoundError("stat", path)
+}
+
+// hasMountsUnder checks if path is a parent directory of a mount point
+// The caller holds mfs.mu
+func (mfs *MountableFS) hasMountsUnder(path string) bool {
 	pathPrefix := path + "/"
 	for mountPath := range mfs.mounts {
 		if strings.HasPrefix(mountPath, pathPrefix) {
-			// This path is a parent directory of a mount point
-			name := path[1:] // Remove leading slash
-			if name == "" {
-				name = "/"
-			} else {
-				// Get the last component of the path
-				lastSlash := strings.LastIndex(name, "/")
-				if lastSlash >= 0 {
-					name = name[lastSlash+1:]
-				}
-			}
-			return &filesystem.FileInfo{
-				Name:    name,
-				Size:    0,
-				Mode:    0755,
-				ModTime: time.Now(),
-				IsDir:   true,
-				Meta: filesystem.MetaData{
-					Type: MetaValueMountPoint,
-				},
-			}, nil
+			return true
 		}
 	}
+	return false
+}
 
-	return nil, filesystem.NewNotFoundError("stat", path)
+// mountParentInfo describes a directory that only exists as the parent of mount points
+func mountParentInfo(path string) *filesystem.FileInfo {
+	name := path[1:] // Remove leading slash
+	if name == "" {
+		name = "/"
+	} else {
+		// Get the last component of the path
+		lastSlash := strings.LastIndex(name, "/")
+		if lastSlash >= 0 {
+			name = name[lastSlash+1:]
+		}
+	}
+	return &filesystem.FileInfo{
+		Name:    name,
+		Size:    0,
+		Mode:    0755,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta: filesystem.MetaData{
+			Type: MetaValueMountPoint,
+		},
+	}
 }
 
 func (mfs *MountableFS) Rename(oldPath, newPath string) error {
+	return mfs.rename(context.Background(), oldPath, newPath)
+}
+
+func (mfs *MountableFS) rename(ctx context.Context, oldPath, newPath string) error {
+	if err := mfs.checkHold("rename", oldPath, true); err != nil {
+		return err
+	}
+	if err := mfs.checkHold("rename", newPath, true); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	oldMount, oldRelPath, oldFound := mfs.findMount(oldPath)
 	newMount, newRelPath, newFound := mfs.findMount(newPath)
@@ -700,55 +1249,81 @@ func (mfs *MountableFS) Rename(oldPath, newPath string) error {
 		if oldMount != newMount {
 			return fmt.Errorf("cannot rename across different mounts")
 		}
-		return oldMount.Plugin.GetFileSystem().Rename(oldRelPath, newRelPath)
+		fs, done := oldMount.bind(ctx, "rename", oldPath)
+		err := done(fs.Rename(oldRelPath, newRelPath))
+		return mfs.recordChange(err, ChangeRename, oldPath, filesystem.NormalizePath(newPath))
 	}
 
 	return fmt.Errorf("cannot rename: paths not in same mounted filesystem")
 }
 
 func (mfs *MountableFS) Chmod(path string, mode uint32) error {
+	return mfs.chmod(context.Background(), path, mode)
+}
+
+func (mfs *MountableFS) chmod(ctx context.Context, path string, mode uint32) error {
+	if err := mfs.checkHold("chmod", path, false); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Chmod(relPath, mode)
+		fs, done := mount.bind(ctx, "chmod", path)
+		return mfs.recordChange(done(fs.Chmod(relPath, mode)), ChangeChmod, path, "")
 	}
 	return filesystem.NewNotFoundError("chmod", path)
 }
 
 // Touch implements filesystem.Toucher interface
 func (mfs *MountableFS) Touch(path string) error {
+	return mfs.recordChange(mfs.touch(context.Background(), path), ChangeTouch, path, "")
+}
+
+func (mfs *MountableFS) touch(ctx context.Context, path string) error {
+	if err := mfs.checkHold("touch", path, false); err != nil {
+		return err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		fs := mount.Plugin.GetFileSystem()
-		// Check if the underlying filesystem implements Toucher
-		if toucher, ok := fs.(filesystem.Toucher); ok {
-			return toucher.Touch(relPath)
+		err := touchMount(ctx, mount, relPath)
+		if mount.shadow != nil {
+			mount.shadow.mirror(shadowOp{op: "touch", path: relPath}, err)
 		}
-		// Fallback: inefficient implementation - read and write back
-		info, err := fs.Stat(relPath)
-		if err == nil {
-			// File exists - read current content and write it back
-			if !info.IsDir {
-				data, readErr := fs.Read(relPath, 0, -1)
-				if readErr != nil {
-					return readErr
-				}
-				_, writeErr := fs.Write(relPath, data)
-				return writeErr
+		return err
+	}
+	return filesystem.NewNotFoundError("touch", path)
+}
+
+func touchMount(ctx context.Context, mount *MountPoint, relPath string) error {
+	fs := filesystem.WithContext(mount.Plugin.GetFileSystem(), ctx)
+	// Check if the underlying filesystem implements Toucher
+	if toucher, ok := fs.(filesystem.Toucher); ok {
+		return mount.guard.call("touch", relPath, func() error { return toucher.Touch(relPath) })
+	}
+	fs = &guardFS{FileSystem: fs, crashGuard: mount.guard}
+	// Fallback: inefficient implementation - read and write back
+	info, err := fs.Stat(relPath)
+	if err == nil {
+		// File exists - read current content and write it back
+		if !info.IsDir {
+			data, readErr := fs.Read(relPath, 0, -1)
+			if readErr != nil && readErr != io.EOF {
+				return readErr
 			}
-			return fmt.Errorf("cannot touch directory")
-		} else {
-			// File doesn't exist - create with empty content
-			_, err := fs.Write(relPath, []byte{})
-			return err
+			_, writeErr := fs.Write(relPath, data)
+			return writeErr
 		}
+		return fmt.Errorf("cannot touch directory")
+	} else {
+		// File doesn't exist - create with empty content
+		_, err := fs.Write(relPath, []byte{})
+		return err
 	}
-	return filesystem.NewNotFoundError("touch", path)
 }
 
 func (mfs *MountableFS) Open(path string) (io.ReadCloser, error) {
@@ -757,22 +1332,133 @@ func (mfs *MountableFS) Open(path string) (io.ReadCloser, error) {
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().Open(relPath)
+		r, err := mount.FileSystem().Open(relPath)
+		if err != nil || !mfs.access.observed() {
+			return r, err
+		}
+		return &accessReader{ReadCloser: r, access: &mfs.access, path: filesystem.NormalizePath(path)}, nil
 	}
 	return nil, filesystem.NewNotFoundError("open", path)
 }
 
 func (mfs *MountableFS) OpenWrite(path string) (io.WriteCloser, error) {
+	if err := mfs.checkHold("openwrite", path, false); err != nil {
+		return nil, err
+	}
 	mfs.mu.RLock()
 	mount, relPath, found := mfs.findMount(path)
 	mfs.mu.RUnlock()
 
 	if found {
-		return mount.Plugin.GetFileSystem().OpenWrite(relPath)
+		w, err := mount.FileSystem().OpenWrite(relPath)
+		if err != nil {
+			return nil, err
+		}
+		w = &changeWriter{WriteCloser: w, journal: mfs.changes, path: filesystem.NormalizePath(path)}
+		if mfs.access.observed() {
+			w = &accessWriter{WriteCloser: w, access: &mfs.access, path: filesystem.NormalizePath(path)}
+		}
+		return w, nil
 	}
 	return nil, filesystem.NewNotFoundError("openwrite", path)
 }
 
+// Snapshot implements filesystem.Snapshotter interface
+// Only directories within a single mount whose plugin supports snapshots can be captured;
+// callers should fall back to walking the live tree on filesystem.ErrNotSupported
+func (mfs *MountableFS) Snapshot(path string) (filesystem.FileSystem, error) {
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(path)
+	mfs.mu.RUnlock()
+
+	if !found {
+		return nil, filesystem.NewNotSupportedError("snapshot", path)
+	}
+
+	if snapshotter, ok := mount.Plugin.GetFileSystem().(filesystem.Snapshotter); ok {
+		var snap filesystem.FileSystem
+		err := mount.guard.call("snapshot", relPath, func() (err error) {
+			snap, err = snapshotter.Snapshot(relPath)
+			return err
+		})
+		return snap, err
+	}
+	return nil, filesystem.NewNotSupportedError("snapshot", path)
+}
+
+// BeginBulk implements filesystem.BulkWriter interface
+// Bulk loads write to the plugin directly, so they are only supported by mounts without
+// options that have to see every write; callers should fall back to per-file writes on
+// filesystem.ErrNotSupported. Every entry of the load must belong to the mount holding dir
+func (mfs *MountableFS) BeginBulk(dir string, opts filesystem.BulkOptions) (filesystem.BulkSession, error) {
+	if err := mfs.checkHold("bulk", dir, false); err != nil {
+		return nil, err
+	}
+	mfs.mu.RLock()
+	mount, relPath, found := mfs.findMount(dir)
+	mfs.mu.RUnlock()
+
+	if !found {
+		return nil, filesystem.NewNotFoundError("bulk", dir)
+	}
+	writer, ok := mount.Plugin.GetFileSystem().(filesystem.BulkWriter)
+	if !ok || !mount.Options.bulkCompatible() {
+		return nil, filesystem.NewNotSupportedError("bulk", dir)
+	}
+	var session filesystem.BulkSession
+	err := mount.guard.call("bulk", relPath, func() (err error) {
+		session, err = writer.BeginBulk(relPath, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &bulkSession{BulkSession: session, mfs: mfs, mount: mount}, nil
+}
+
+// bulkSession translates the paths of a bulk load to its mount and records the changes
+type bulkSession struct {
+	filesystem.BulkSession
+	mfs   *MountableFS
+	mount *MountPoint
+}
+
+func (s *bulkSession) WriteBatch(entries []filesystem.BulkEntry) error {
+	// Entries replace existing files, held ones must not be overwritten
+	for _, e := range entries {
+		if err := s.mfs.checkHold("bulk", e.Path, false); err != nil {
+			return err
+		}
+	}
+	translated := make([]filesystem.BulkEntry, len(entries))
+	s.mfs.mu.RLock()
+	for i, e := range entries {
+		mount, relPath, found := s.mfs.findMount(e.Path)
+		if !found || mount != s.mount {
+			s.mfs.mu.RUnlock()
+			return filesystem.NewInvalidArgumentError("path", e.Path, "outside the mount of the bulk load")
+		}
+		e.Path = relPath
+		translated[i] = e
+	}
+	s.mfs.mu.RUnlock()
+
+	err := s.mount.guard.call("bulk", s.mount.Path, func() error {
+		return s.BulkSession.WriteBatch(translated)
+	})
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		op := ChangeWrite
+		if e.IsDir {
+			op = ChangeMkdir
+		}
+		s.mfs.recordChange(nil, op, e.Path, "")
+	}
+	return nil
+}
+
 // OpenStream implements filesystem.Streamer interface
 func (mfs *MountableFS) OpenStream(path string) (filesystem.StreamReader, error) {
 	mfs.mu.RLock()
@@ -787,7 +1473,12 @@ func (mfs *MountableFS) OpenStream(path string) (filesystem.StreamReader, error)
 	fs := mount.Plugin.GetFileSystem()
 	if streamer, ok := fs.(filesystem.Streamer); ok {
 		log.Debugf("[mountablefs] OpenStream: found streamer for path %s (relPath: %s, fs type: %T)", path, relPath, fs)
-		return streamer.OpenStream(relPath)
+		var stream filesystem.StreamReader
+		err := mount.guard.call("openstream", relPath, func() (err error) {
+			stream, err = streamer.OpenStream(relPath)
+			return err
+		})
+		return stream, err
 	}
 
 	log.Warnf("[mountablefs] OpenStream: filesystem does not support streaming: %s (fs type: %T)", path, fs)
@@ -813,7 +1504,12 @@ func (mfs *MountableFS) GetStream(path string) (interface{}, error) {
 	fs := mount.Plugin.GetFileSystem()
 	if sg, ok := fs.(streamGetter); ok {
 		log.Debugf("[mountablefs] GetStream: found stream getter for path %s (relPath: %s, fs type: %T)", path, relPath, fs)
-		return sg.GetStream(relPath)
+		var stream interface{}
+		err := mount.guard.call("getstream", relPath, func() (err error) {
+			stream, err = sg.GetStream(relPath)
+			return err
+		})
+		return stream, err
 	}
 
 	log.Warnf("[mountablefs] GetStream: filesystem does not support streaming: %s (fs type: %T)", path, fs)
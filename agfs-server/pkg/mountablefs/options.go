@@ -0,0 +1,299 @@
+package mountablefs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/scanner"
+)
+
+// Mount option keys
+// These keys are handled by MountableFS itself and are removed from the
+// configuration before it is passed to the plugin's Validate/Initialize
+const (
+	OptionTrash          = "trash"              // Move removed entries into /.trash instead of deleting them
+	OptionTrashRetention = "trash_retention"    // How long trashed entries are kept (e.g., "7d", "12h")
+	OptionChecksum       = "checksum"           // Record and verify a checksum of every file ("xxh3" or "sha256")
+	OptionScan           = "scan"               // Content scanner inspecting writes ("webhook", "clamav" or "icap")
+	OptionScanURL        = "scan_url"           // Scanner address
+	OptionScanAction     = "scan_action"        // What to do with flagged writes ("reject" or "quarantine")
+	OptionScanOnError    = "scan_on_error"      // What to do when the scanner fails ("reject" or "allow")
+	OptionScanTimeout    = "scan_timeout"       // Timeout of a single scan (e.g., "30s")
+	OptionReadBPS        = "read_bps"           // Bandwidth limit of reads from the mount in bytes/sec (e.g., "10MB")
+	OptionWriteBPS       = "write_bps"          // Bandwidth limit of writes to the mount in bytes/sec (e.g., "10MB")
+	OptionCacheTTL       = "cache_ttl"          // How long Stat and ReadDir results are cached (e.g., "2s")
+	OptionCacheSize      = "cache_size"         // Maximum number of cached Stat and ReadDir results
+	OptionNegativeTTL    = "negative_cache_ttl" // How long "not found" results are cached (e.g., "5s")
+	OptionWorkers        = "workers"            // Number of workers running the mount's operations
+	OptionQueueSize      = "queue_size"         // Operations that may wait for a worker before new ones are rejected
+	OptionOpTimeout      = "op_timeout"         // How long callers wait for an operation (e.g., "30s")
+	OptionDeadline       = "deadline"           // Deadline passed to the plugin with every operation (e.g., "10s")
+	OptionCrashLimit     = "crash_limit"        // Plugin panics after which the mount is disabled
+	OptionStats          = "stats"              // Count the mount's operations and serve them in /.stats
+	OptionShadow         = "shadow"             // Directory of the tree receiving a copy of every write (e.g., "/sqlfs/new")
+	OptionShadowQueue    = "shadow_queue"       // Writes that may wait to be mirrored before further ones are dropped
+)
+
+// deadlineOps are the operations whose deadline can be set with "<op>_deadline" (e.g.,
+// "read_deadline"), overriding OptionDeadline; "remove" also covers RemoveAll
+var deadlineOps = []string{"read", "write", "stat", "readdir", "create", "mkdir", "remove", "rename", "chmod"}
+
+// DefaultTrashRetention is how long trashed entries are kept when no retention is configured
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// mountOptionKeys lists all keys consumed by MountableFS
+var mountOptionKeys = []string{
+	OptionTrash,
+	OptionTrashRetention,
+	OptionChecksum,
+	OptionScan,
+	OptionScanURL,
+	OptionScanAction,
+	OptionScanOnError,
+	OptionScanTimeout,
+	OptionReadBPS,
+	OptionWriteBPS,
+	OptionCacheTTL,
+	OptionCacheSize,
+	OptionNegativeTTL,
+	OptionWorkers,
+	OptionQueueSize,
+	OptionOpTimeout,
+	OptionDeadline,
+	OptionCrashLimit,
+	OptionStats,
+	OptionShadow,
+	OptionShadowQueue,
+}
+
+// MountOptions contains mount-level features provided by MountableFS on top of a plugin
+type MountOptions struct {
+	Trash          bool                     // Enable the trash facility for Remove/RemoveAll
+	TrashRetention time.Duration            // Retention period for trashed entries (0 keeps them forever)
+	Checksum       string                   // Checksum algorithm for integrity mode, empty if disabled
+	Scanner        scanner.Scanner          // Content scanner inspecting writes, nil if disabled
+	ScanAction     string                   // Action for flagged writes
+	ScanOnError    string                   // Policy when the scanner fails
+	ReadBPS        int64                    // Read bandwidth limit shared by all clients, 0 if unlimited
+	WriteBPS       int64                    // Write bandwidth limit shared by all clients, 0 if unlimited
+	CacheTTL       time.Duration            // Lifetime of cached Stat and ReadDir results, 0 if caching is disabled
+	CacheSize      int                      // Maximum number of cached results
+	NegativeTTL    time.Duration            // Lifetime of cached "not found" results, 0 if disabled
+	Workers        int                      // Size of the worker pool running the mount's operations, 0 if disabled
+	QueueSize      int                      // Operations that may wait for a worker
+	OpTimeout      time.Duration            // How long callers wait for an operation, 0 without limit
+	Deadline       time.Duration            // Deadline of the plugin's operations, 0 without limit
+	OpDeadlines    map[string]time.Duration // Deadlines of single operations, overriding Deadline
+	CrashLimit     int                      // Plugin panics after which the mount is disabled, 0 never disables it
+	Stats          bool                     // Serve the operation counters and gauges of the plugin in /.stats
+	Shadow         string                   // Directory receiving a copy of every write, empty if disabled
+	ShadowQueue    int                      // Writes that may wait to be mirrored
+}
+
+// deadline returns the deadline of op, 0 if it has none
+func (o MountOptions) deadline(op string) time.Duration {
+	if d, ok := o.OpDeadlines[op]; ok {
+		return d
+	}
+	return o.Deadline
+}
+
+// bulkCompatible reports whether bulk loads may bypass the mount's layers, which is not
+// the case when checksums, scanning, the trash, caching, write limits or a shadow apply
+func (o MountOptions) bulkCompatible() bool {
+	return o.Checksum == "" && o.Scanner == nil && !o.Trash && o.CacheTTL == 0 && o.NegativeTTL == 0 && o.WriteBPS == 0 && o.Shadow == ""
+}
+
+// wholeFiles reports whether a layer of the mount has to see files written as a whole, so
+// that appends and writes at offsets cannot go to the plugin directly
+func (o MountOptions) wholeFiles() bool {
+	return o.Checksum != "" || o.Scanner != nil || o.Shadow != ""
+}
+
+// plainListings reports whether listings of the mount may come from the plugin directly, as
+// no layer hides entries, caches or counts listings, or queues the calls into the plugin
+func (o MountOptions) plainListings() bool {
+	return o.Checksum == "" && !o.Trash && o.CacheTTL == 0 && o.NegativeTTL == 0 && !o.Stats && o.Workers == 0
+}
+
+// plainReads reports whether reads of the mount may come from the plugin directly, as no
+// layer verifies, scans, caches or counts reads, or queues the calls into the plugin
+func (o MountOptions) plainReads() bool {
+	return o.plainListings() && o.Scanner == nil
+}
+
+// SplitMountOptions separates mount-level options from the plugin configuration
+// Returns the parsed options and a copy of cfg without the mount option keys
+func SplitMountOptions(cfg map[string]interface{}) (MountOptions, map[string]interface{}, error) {
+	opts := MountOptions{
+		TrashRetention: DefaultTrashRetention,
+	}
+
+	optionCfg := make(map[string]interface{})
+	pluginCfg := make(map[string]interface{})
+	for k, v := range cfg {
+		if isMountOptionKey(k) {
+			optionCfg[k] = v
+		} else {
+			pluginCfg[k] = v
+		}
+	}
+
+	if err := config.ValidateBoolType(optionCfg, OptionTrash); err != nil {
+		return opts, nil, err
+	}
+	opts.Trash = config.GetBoolConfig(optionCfg, OptionTrash, false)
+
+	retention, err := config.GetDurationConfig(optionCfg, OptionTrashRetention, DefaultTrashRetention)
+	if err != nil {
+		return opts, nil, err
+	}
+	opts.TrashRetention = retention
+
+	if err := config.ValidateStringType(optionCfg, OptionChecksum); err != nil {
+		return opts, nil, err
+	}
+	opts.Checksum = config.GetStringConfig(optionCfg, OptionChecksum, "")
+	if opts.Checksum != "" && !validChecksumAlgorithm(opts.Checksum) {
+		return opts, nil, fmt.Errorf("invalid %s: %s (supported: %s, %s)", OptionChecksum, opts.Checksum, ChecksumXXH3, ChecksumSHA256)
+	}
+
+	for _, key := range []string{OptionScan, OptionScanURL, OptionScanAction, OptionScanOnError} {
+		if err := config.ValidateStringType(optionCfg, key); err != nil {
+			return opts, nil, err
+		}
+	}
+	if kind := config.GetStringConfig(optionCfg, OptionScan, ""); kind != "" {
+		timeout, err := config.GetDurationConfig(optionCfg, OptionScanTimeout, scanner.DefaultTimeout)
+		if err != nil {
+			return opts, nil, err
+		}
+		opts.ScanAction = config.GetStringConfig(optionCfg, OptionScanAction, ScanActionReject)
+		opts.ScanOnError = config.GetStringConfig(optionCfg, OptionScanOnError, ScanOnErrorReject)
+		opts.Scanner, err = scanOptions(kind, config.GetStringConfig(optionCfg, OptionScanURL, ""), opts.ScanAction, opts.ScanOnError, timeout)
+		if err != nil {
+			return opts, nil, err
+		}
+	}
+
+	if opts.ReadBPS, err = config.GetSizeConfig(optionCfg, OptionReadBPS, 0); err != nil {
+		return opts, nil, err
+	}
+	if opts.WriteBPS, err = config.GetSizeConfig(optionCfg, OptionWriteBPS, 0); err != nil {
+		return opts, nil, err
+	}
+	if opts.ReadBPS < 0 || opts.WriteBPS < 0 {
+		return opts, nil, fmt.Errorf("%s and %s must not be negative", OptionReadBPS, OptionWriteBPS)
+	}
+
+	if opts.CacheTTL, err = config.GetDurationConfig(optionCfg, OptionCacheTTL, 0); err != nil {
+		return opts, nil, err
+	}
+	if err := config.ValidateIntType(optionCfg, OptionCacheSize); err != nil {
+		return opts, nil, err
+	}
+	opts.CacheSize = config.GetIntConfig(optionCfg, OptionCacheSize, DefaultCacheSize)
+	if opts.NegativeTTL, err = config.GetDurationConfig(optionCfg, OptionNegativeTTL, 0); err != nil {
+		return opts, nil, err
+	}
+	if opts.CacheTTL < 0 || opts.NegativeTTL < 0 || opts.CacheSize <= 0 {
+		return opts, nil, fmt.Errorf("%s and %s must not be negative and %s must be positive", OptionCacheTTL, OptionNegativeTTL, OptionCacheSize)
+	}
+
+	// Any pool option enables the pool, with defaults for the others
+	for _, key := range []string{OptionWorkers, OptionQueueSize} {
+		if err := config.ValidateIntType(optionCfg, key); err != nil {
+			return opts, nil, err
+		}
+	}
+	if opts.OpTimeout, err = config.GetDurationConfig(optionCfg, OptionOpTimeout, 0); err != nil {
+		return opts, nil, err
+	}
+	_, hasWorkers := optionCfg[OptionWorkers]
+	_, hasQueue := optionCfg[OptionQueueSize]
+	if hasWorkers || hasQueue || opts.OpTimeout > 0 {
+		opts.Workers = config.GetIntConfig(optionCfg, OptionWorkers, DefaultWorkers)
+		opts.QueueSize = config.GetIntConfig(optionCfg, OptionQueueSize, DefaultQueueSize)
+		if opts.Workers <= 0 || opts.QueueSize < 0 || opts.OpTimeout < 0 {
+			return opts, nil, fmt.Errorf("%s must be positive, %s and %s must not be negative", OptionWorkers, OptionQueueSize, OptionOpTimeout)
+		}
+	}
+
+	if opts.Deadline, err = config.GetDurationConfig(optionCfg, OptionDeadline, 0); err != nil {
+		return opts, nil, err
+	}
+	for _, op := range deadlineOps {
+		key := op + "_deadline"
+		if _, ok := optionCfg[key]; !ok {
+			continue
+		}
+		d, err := config.GetDurationConfig(optionCfg, key, 0)
+		if err != nil {
+			return opts, nil, err
+		}
+		if opts.OpDeadlines == nil {
+			opts.OpDeadlines = make(map[string]time.Duration)
+		}
+		opts.OpDeadlines[op] = d
+	}
+	if opts.Deadline < 0 {
+		return opts, nil, fmt.Errorf("%s must not be negative", OptionDeadline)
+	}
+	for op, d := range opts.OpDeadlines {
+		if d < 0 {
+			return opts, nil, fmt.Errorf("%s_deadline must not be negative", op)
+		}
+	}
+
+	if err := config.ValidateIntType(optionCfg, OptionCrashLimit); err != nil {
+		return opts, nil, err
+	}
+	opts.CrashLimit = config.GetIntConfig(optionCfg, OptionCrashLimit, 0)
+	if opts.CrashLimit < 0 {
+		return opts, nil, fmt.Errorf("%s must not be negative", OptionCrashLimit)
+	}
+
+	if err := config.ValidateBoolType(optionCfg, OptionStats); err != nil {
+		return opts, nil, err
+	}
+	opts.Stats = config.GetBoolConfig(optionCfg, OptionStats, false)
+
+	if err := config.ValidateStringType(optionCfg, OptionShadow); err != nil {
+		return opts, nil, err
+	}
+	if err := config.ValidateIntType(optionCfg, OptionShadowQueue); err != nil {
+		return opts, nil, err
+	}
+	if shadow := config.GetStringConfig(optionCfg, OptionShadow, ""); shadow != "" {
+		opts.Shadow = filesystem.NormalizePath(shadow)
+		if opts.Shadow == "/" {
+			return opts, nil, fmt.Errorf("%s must be a directory below the root", OptionShadow)
+		}
+		opts.ShadowQueue = config.GetIntConfig(optionCfg, OptionShadowQueue, DefaultShadowQueue)
+		if opts.ShadowQueue <= 0 {
+			return opts, nil, fmt.Errorf("%s must be positive", OptionShadowQueue)
+		}
+	}
+
+	return opts, pluginCfg, nil
+}
+
+// isMountOptionKey checks if a config key is a mount option
+func isMountOptionKey(key string) bool {
+	for _, k := range mountOptionKeys {
+		if k == key {
+			return true
+		}
+	}
+	if op, ok := strings.CutSuffix(key, "_deadline"); ok {
+		for _, o := range deadlineOps {
+			if o == op {
+				return true
+			}
+		}
+	}
+	return false
+}
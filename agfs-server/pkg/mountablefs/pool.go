@@ -0,0 +1,311 @@
+package mountablefs
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the worker pool options
+const (
+	DefaultWorkers   = 16
+	DefaultQueueSize = 256
+)
+
+// States of a pool task
+const (
+	taskPending   int32 = iota // Queued or running
+	taskDone                   // Finished while the caller was waiting
+	taskAbandoned              // The caller gave up waiting
+)
+
+// poolTask is an operation waiting for or running on a worker
+type poolTask struct {
+	fn      func() error
+	cleanup func()     // Releases what fn acquired if nobody waits for it anymore, may be nil
+	result  chan error // Buffered, so workers never block on abandoned tasks
+	state   atomic.Int32
+}
+
+// PoolStats describes the activity of a mount's worker pool
+type PoolStats struct {
+	Workers  int   `json:"workers"`
+	Busy     int64 `json:"busy"`     // Operations running, including timed out ones still stuck
+	Queued   int   `json:"queued"`   // Operations waiting for a worker
+	Rejected int64 `json:"rejected"` // Operations refused because the queue was full
+	TimedOut int64 `json:"timedOut"` // Operations the caller stopped waiting for
+}
+
+// poolFS wraps a FileSystem so that its operations run on a bounded set of workers
+//
+// A backend that hangs can only tie up the workers of its own mount: once they are busy and
+// the queue is full, further operations fail fast with filesystem.ErrUnavailable instead of
+// piling up handler goroutines, and callers stop waiting after the timeout or once the
+// context of a view returned by WithContext is done. Readers and writers returned by Open
+// and OpenWrite are used directly by the caller.
+type poolFS struct {
+	filesystem.FileSystem
+	*poolState                 // Shared with the views returned by WithContext
+	ctx        context.Context // Context of a view returned by WithContext, nil otherwise
+}
+
+// poolState holds the workers and counters of a pool
+type poolState struct {
+	mountPath string
+	workers   int
+	timeout   time.Duration // 0 waits for as long as the operation takes
+
+	tasks chan *poolTask
+	done  chan struct{}
+
+	mu     sync.RWMutex // Held for writing to close, so no task is queued after the workers drained
+	closed bool
+
+	busy     atomic.Int64
+	rejected atomic.Int64
+	timedOut atomic.Int64
+}
+
+// newPoolFS creates a pool wrapper and starts its workers
+func newPoolFS(fs filesystem.FileSystem, mountPath string, workers, queueSize int, timeout time.Duration) *poolFS {
+	p := &poolFS{
+		FileSystem: fs,
+		poolState: &poolState{
+			mountPath: mountPath,
+			workers:   workers,
+			timeout:   timeout,
+			tasks:     make(chan *poolTask, queueSize),
+			done:      make(chan struct{}),
+		},
+	}
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// WithContext implements filesystem.ContextBinder; the view's operations run under ctx on
+// the shared workers and the caller stops waiting once ctx is done
+func (p *poolFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &poolFS{
+		FileSystem: filesystem.WithContext(p.FileSystem, ctx),
+		poolState:  p.poolState,
+		ctx:        ctx,
+	}
+}
+
+func (p *poolState) worker() {
+	for {
+		select {
+		case t := <-p.tasks:
+			p.run(t)
+		case <-p.done:
+			// Fail whatever is still queued, nothing can be queued anymore
+			for {
+				select {
+				case t := <-p.tasks:
+					t.result <- filesystem.NewUnavailableError("pool", p.mountPath, "mount closed")
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *poolState) run(t *poolTask) {
+	if t.state.Load() == taskAbandoned {
+		return
+	}
+	p.busy.Add(1)
+	defer p.busy.Add(-1)
+	err := t.fn()
+	if !t.state.CompareAndSwap(taskPending, taskDone) {
+		if err == nil && t.cleanup != nil {
+			t.cleanup()
+		}
+		return
+	}
+	t.result <- err
+}
+
+// Close stops the workers; operations still running finish in the background
+func (p *poolState) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	return nil
+}
+
+// Stats returns the current activity of the pool
+func (p *poolState) Stats() PoolStats {
+	return PoolStats{
+		Workers:  p.workers,
+		Busy:     p.busy.Load(),
+		Queued:   len(p.tasks),
+		Rejected: p.rejected.Load(),
+		TimedOut: p.timedOut.Load(),
+	}
+}
+
+// do runs fn on a worker and waits for it; results are passed back through variables
+// captured by fn, which the caller must only use when do returns nil
+func (p *poolFS) do(op, path string, fn func() error) error {
+	return p.doWithCleanup(op, path, fn, nil)
+}
+
+// doWithCleanup is do for operations acquiring a resource; cleanup runs if fn succeeds
+// after the caller stopped waiting
+func (p *poolFS) doWithCleanup(op, path string, fn func() error, cleanup func()) error {
+	t := &poolTask{fn: fn, cleanup: cleanup, result: make(chan error, 1)}
+
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return filesystem.NewUnavailableError(op, path, "mount closed")
+	}
+	select {
+	case p.tasks <- t:
+		p.mu.RUnlock()
+	default:
+		p.mu.RUnlock()
+		p.rejected.Add(1)
+		return filesystem.NewUnavailableError(op, path, "too many pending operations on the mount")
+	}
+
+	var expired <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var ctxDone <-chan struct{}
+	if p.ctx != nil {
+		ctxDone = p.ctx.Done()
+	}
+	select {
+	case err := <-t.result:
+		return err
+	case <-expired:
+		if !t.state.CompareAndSwap(taskPending, taskAbandoned) {
+			// Finished just now
+			return <-t.result
+		}
+		p.timedOut.Add(1)
+		log.Warnf("[mountablefs] %s %s on %s timed out after %v", op, path, p.mountPath, p.timeout)
+		return filesystem.NewTimeoutError(op, path, p.timeout)
+	case <-ctxDone:
+		if !t.state.CompareAndSwap(taskPending, taskAbandoned) {
+			return <-t.result
+		}
+		// The caller reports the deadline or cancellation, see filesystem.ContextError
+		p.timedOut.Add(1)
+		return p.ctx.Err()
+	}
+}
+
+func (p *poolFS) Create(path string) error {
+	return p.do("create", path, func() error { return p.FileSystem.Create(path) })
+}
+
+func (p *poolFS) Mkdir(path string, perm uint32) error {
+	return p.do("mkdir", path, func() error { return p.FileSystem.Mkdir(path, perm) })
+}
+
+func (p *poolFS) Remove(path string) error {
+	return p.do("remove", path, func() error { return p.FileSystem.Remove(path) })
+}
+
+func (p *poolFS) RemoveAll(path string) error {
+	return p.do("removeall", path, func() error { return p.FileSystem.RemoveAll(path) })
+}
+
+func (p *poolFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	var data []byte
+	var readErr error
+	err := p.do("read", path, func() error {
+		data, readErr = p.FileSystem.Read(path, offset, size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// io.EOF accompanies data, so it is passed back alongside it rather than as a failure
+	return data, readErr
+}
+
+func (p *poolFS) Write(path string, data []byte) ([]byte, error) {
+	var result []byte
+	err := p.do("write", path, func() (err error) {
+		result, err = p.FileSystem.Write(path, data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *poolFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	var infos []filesystem.FileInfo
+	err := p.do("readdir", path, func() (err error) {
+		infos, err = p.FileSystem.ReadDir(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (p *poolFS) Stat(path string) (*filesystem.FileInfo, error) {
+	var info *filesystem.FileInfo
+	err := p.do("stat", path, func() (err error) {
+		info, err = p.FileSystem.Stat(path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (p *poolFS) Rename(oldPath, newPath string) error {
+	return p.do("rename", oldPath, func() error { return p.FileSystem.Rename(oldPath, newPath) })
+}
+
+func (p *poolFS) Chmod(path string, mode uint32) error {
+	return p.do("chmod", path, func() error { return p.FileSystem.Chmod(path, mode) })
+}
+
+func (p *poolFS) Open(path string) (io.ReadCloser, error) {
+	var r io.ReadCloser
+	err := p.doWithCleanup("open", path, func() (err error) {
+		r, err = p.FileSystem.Open(path)
+		return err
+	}, func() { r.Close() })
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (p *poolFS) OpenWrite(path string) (io.WriteCloser, error) {
+	var w io.WriteCloser
+	err := p.doWithCleanup("openwrite", path, func() (err error) {
+		w, err = p.FileSystem.OpenWrite(path)
+		return err
+	}, func() { w.Close() })
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
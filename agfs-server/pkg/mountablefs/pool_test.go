@@ -0,0 +1,102 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// hangingFS blocks every Stat until release is closed
+type hangingFS struct {
+	filesystem.FileSystem
+	release chan struct{}
+}
+
+func (h *hangingFS) Stat(p string) (*filesystem.FileInfo, error) {
+	<-h.release
+	return h.FileSystem.Stat(p)
+}
+
+func TestPoolTimesOutAndRejects(t *testing.T) {
+	backend := &hangingFS{FileSystem: memfs.NewMemoryFS(), release: make(chan struct{})}
+	pool := newPoolFS(backend, "/slow", 1, 1, 20*time.Millisecond)
+	defer pool.Close()
+	defer close(backend.release)
+
+	// The first call occupies the only worker, the second the only queue slot
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Stat("/"); !errors.Is(err, filesystem.ErrTimeout) {
+				t.Errorf("stat error = %v, want timeout", err)
+			}
+		}()
+	}
+	deadline := time.Now().Add(time.Second)
+	for pool.Stats().Busy+int64(pool.Stats().Queued) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := pool.Stat("/"); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("stat with a full queue: error %v, want unavailable", err)
+	}
+	wg.Wait()
+
+	stats := pool.Stats()
+	if stats.Rejected != 1 || stats.TimedOut != 2 {
+		t.Errorf("stats = %+v, want 1 rejected and 2 timed out", stats)
+	}
+}
+
+func TestPoolPassesResultsThrough(t *testing.T) {
+	pool := newPoolFS(memfs.NewMemoryFS(), "/m", 2, 4, time.Second)
+	defer pool.Close()
+
+	if _, err := pool.Write("/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := pool.Read("/a", 0, -1)
+	if string(data) != "hello" || (err != nil && err != io.EOF) {
+		t.Errorf("read = %q, %v", data, err)
+	}
+	if _, err := pool.Stat("/missing"); err == nil {
+		t.Errorf("stat of a missing file succeeded")
+	}
+
+	pool.Close()
+	if _, err := pool.Stat("/a"); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("stat after close: error %v, want unavailable", err)
+	}
+}
+
+func TestPoolIsolatesMounts(t *testing.T) {
+	slow := &hangingFS{FileSystem: memfs.NewMemoryFS(), release: make(chan struct{})}
+	defer close(slow.release)
+
+	mfs := NewMountableFS()
+	if err := mfs.MountWithOptions("/slow", &countingPlugin{fs: &countingFS{FileSystem: slow}},
+		MountOptions{Workers: 1, OpTimeout: 10 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	mem := memfs.NewMemFSPlugin()
+	mem.Initialize(map[string]interface{}{})
+	if err := mfs.Mount("/fast", mem); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := mfs.Stat("/slow/x"); err == nil {
+			t.Fatalf("stat on the hanging mount succeeded")
+		}
+	}
+	if _, err := mfs.Stat("/fast"); err != nil {
+		t.Errorf("stat on another mount: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package mountablefs
+
+import (
+	"context"
+	"io"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// readmePath is where plugins serve their README, relative to their mount
+const readmePath = "/README"
+
+// readReadme serves the README of the mount when the plugin's own file does not answer the
+// read: templated READMEs are rendered with the mount's configuration in the language of
+// ctx, and other READMEs are converted for Markdown reads; ok is false for other reads
+func (mp *MountPoint) readReadme(ctx context.Context, path, relPath string, offset, size int64, format string) (data []byte, served string, ok bool, err error) {
+	if filesystem.NormalizePath(relPath) != readmePath {
+		return nil, "", false, nil
+	}
+	templater, templated := mp.Plugin.(plugin.ReadmeTemplater)
+	if !templated && format != filesystem.FormatMarkdown && format != filesystem.FormatText {
+		return nil, "", false, nil
+	}
+
+	var text string
+	if templated {
+		instance := plugin.NewReadmeData(mp.Plugin.Name(), mp.Path, mp.Config)
+		text, err = plugin.RenderReadme(templater.ReadmeTemplates(), filesystem.LanguageFromContext(ctx), format, instance)
+		if err != nil {
+			return nil, "", true, err
+		}
+	} else {
+		fs, done := mp.bind(ctx, "read", path)
+		raw, err := fs.Read(relPath, 0, -1)
+		if err = done(err); err != nil && err != io.EOF {
+			return nil, "", true, err
+		}
+		text = string(raw)
+		if format == filesystem.FormatMarkdown {
+			text = plugin.TextToMarkdown(text)
+		}
+	}
+
+	// Plain reads keep returning an undeclared format, as for every other file
+	if format == filesystem.FormatMarkdown || format == filesystem.FormatText {
+		served = format
+	} else if format != "" {
+		served = filesystem.FormatText
+	}
+	data, err = plugin.ApplyRangeRead([]byte(text), offset, size)
+	return data, served, true, err
+}
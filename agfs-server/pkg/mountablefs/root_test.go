@@ -0,0 +1,54 @@
+package mountablefs
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestRootMount(t *testing.T) {
+	mfs := NewMountableFS()
+	for _, p := range []string{"/", "/tmp", "/a/b"} {
+		plugin := memfs.NewMemFSPlugin()
+		plugin.Initialize(map[string]interface{}{})
+		if err := mfs.Mount(p, plugin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mfs.Write("/notes.txt", []byte("root")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/tmp/scratch", []byte("tmp")); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := mfs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	sort.Strings(names)
+	if got, want := strings.Join(names, " "), "README a notes.txt tmp"; got != want {
+		t.Errorf("ReadDir(/) = %s, want %s", got, want)
+	}
+
+	// The other mounts keep their files
+	if data, _ := mfs.Read("/tmp/scratch", 0, -1); string(data) != "tmp" {
+		t.Errorf("/tmp/scratch = %q", data)
+	}
+	if _, err := mfs.Stat("/scratch"); err == nil {
+		t.Errorf("/tmp/scratch is visible in the root mount")
+	}
+	// Parents of mounts exist even if the root mount does not have them
+	if info, err := mfs.Stat("/a"); err != nil || !info.IsDir {
+		t.Errorf("Stat(/a) = %v, %v", info, err)
+	}
+	if infos, err := mfs.ReadDir("/a"); err != nil || len(infos) != 1 || infos[0].Name != "b" {
+		t.Errorf("ReadDir(/a) = %v, %v", infos, err)
+	}
+}
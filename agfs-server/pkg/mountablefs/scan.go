@@ -0,0 +1,183 @@
+package mountablefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/scanner"
+	log "github.com/sirupsen/logrus"
+)
+
+// QuarantineDir holds writes flagged by the content scanner when scan_action is "quarantine"
+const QuarantineDir = "/.quarantine"
+
+// Actions taken when the scanner flags a write
+const (
+	ScanActionReject     = "reject"     // Fail the write
+	ScanActionQuarantine = "quarantine" // Fail the write and keep the content under QuarantineDir
+)
+
+// Policies applied when the scanner itself fails
+const (
+	ScanOnErrorReject = "reject" // Fail the write (fail closed)
+	ScanOnErrorAllow  = "allow"  // Let the write through (fail open)
+)
+
+// scanFS wraps a FileSystem so that every write is inspected by a scanner
+// before it reaches the plugin
+type scanFS struct {
+	filesystem.FileSystem
+	scanner scanner.Scanner
+	action  string
+	onError string
+}
+
+// newScanFS creates a scanning wrapper
+func newScanFS(fs filesystem.FileSystem, s scanner.Scanner, action, onError string) *scanFS {
+	return &scanFS{
+		FileSystem: fs,
+		scanner:    s,
+		action:     action,
+		onError:    onError,
+	}
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system
+func (s *scanFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *s
+	bound.FileSystem = filesystem.WithContext(s.FileSystem, ctx)
+	return &bound
+}
+
+// isQuarantinePath checks if path is the quarantine directory or inside it
+func isQuarantinePath(p string) bool {
+	return p == QuarantineDir || strings.HasPrefix(p, QuarantineDir+"/")
+}
+
+func (s *scanFS) denied(op, p string) error {
+	return filesystem.NewPermissionDeniedError(op, p, "quarantine is managed by the server")
+}
+
+// inspect scans data for p and returns an error if the write must not proceed
+func (s *scanFS) inspect(p string, data []byte) error {
+	result, err := s.scanner.Scan(p, data)
+	if err != nil {
+		if s.onError == ScanOnErrorAllow {
+			log.Warnf("[scan] %s: scan failed, allowing write: %v", p, err)
+			return nil
+		}
+		log.Errorf("[scan] %s: scan failed, rejecting write: %v", p, err)
+		return filesystem.NewPermissionDeniedError("write", p, "content scan failed")
+	}
+	if result.Clean {
+		return nil
+	}
+
+	reason := result.Reason
+	if reason == "" {
+		reason = "policy violation"
+	}
+	if s.action == ScanActionQuarantine {
+		dest, err := s.quarantine(p, data)
+		if err != nil {
+			log.Errorf("[scan] %s: failed to quarantine: %v", p, err)
+		} else {
+			log.Warnf("[scan] %s: %s, quarantined as %s", p, reason, dest)
+		}
+		return filesystem.NewPermissionDeniedError("write", p, "quarantined by content scanner: "+reason)
+	}
+	log.Warnf("[scan] %s: %s, write rejected", p, reason)
+	return filesystem.NewPermissionDeniedError("write", p, "rejected by content scanner: "+reason)
+}
+
+// quarantine stores flagged content under QuarantineDir/<timestamp>/<original path>
+func (s *scanFS) quarantine(p string, data []byte) (string, error) {
+	dest := path.Join(QuarantineDir, time.Now().UTC().Format(trashBatchLayout)) + p
+	if err := filesystem.MkdirAll(s.FileSystem, path.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+	if _, err := s.FileSystem.Write(dest, data); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+func (s *scanFS) Write(p string, data []byte) ([]byte, error) {
+	p = filesystem.NormalizePath(p)
+	if isQuarantinePath(p) {
+		return nil, s.denied("write", p)
+	}
+	if err := s.inspect(p, data); err != nil {
+		return nil, err
+	}
+	return s.FileSystem.Write(p, data)
+}
+
+func (s *scanFS) OpenWrite(p string) (io.WriteCloser, error) {
+	p = filesystem.NormalizePath(p)
+	if isQuarantinePath(p) {
+		return nil, s.denied("openwrite", p)
+	}
+	// The whole content must be scanned before anything reaches the plugin
+	return filesystem.NewBufferedWriter(p, s.Write), nil
+}
+
+func (s *scanFS) Create(p string) error {
+	p = filesystem.NormalizePath(p)
+	if isQuarantinePath(p) {
+		return s.denied("create", p)
+	}
+	return s.FileSystem.Create(p)
+}
+
+func (s *scanFS) Mkdir(p string, perm uint32) error {
+	p = filesystem.NormalizePath(p)
+	if isQuarantinePath(p) {
+		return s.denied("mkdir", p)
+	}
+	return s.FileSystem.Mkdir(p, perm)
+}
+
+func (s *scanFS) Rename(oldPath, newPath string) error {
+	oldPath = filesystem.NormalizePath(oldPath)
+	newPath = filesystem.NormalizePath(newPath)
+	if isQuarantinePath(oldPath) || isQuarantinePath(newPath) {
+		return s.denied("rename", oldPath)
+	}
+	return s.FileSystem.Rename(oldPath, newPath)
+}
+
+// Quarantined content is never served, it can only be listed and removed
+
+func (s *scanFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if isQuarantinePath(filesystem.NormalizePath(p)) {
+		return nil, filesystem.NewPermissionDeniedError("read", p, "quarantined content")
+	}
+	return s.FileSystem.Read(p, offset, size)
+}
+
+func (s *scanFS) Open(p string) (io.ReadCloser, error) {
+	if isQuarantinePath(filesystem.NormalizePath(p)) {
+		return nil, filesystem.NewPermissionDeniedError("open", p, "quarantined content")
+	}
+	return s.FileSystem.Open(p)
+}
+
+// scanOptions validates the scanner mount options and creates the scanner
+func scanOptions(kind, addr, action, onError string, timeout time.Duration) (scanner.Scanner, error) {
+	if action != ScanActionReject && action != ScanActionQuarantine {
+		return nil, fmt.Errorf("invalid %s: %s (supported: %s, %s)", OptionScanAction, action, ScanActionReject, ScanActionQuarantine)
+	}
+	if onError != ScanOnErrorReject && onError != ScanOnErrorAllow {
+		return nil, fmt.Errorf("invalid %s: %s (supported: %s, %s)", OptionScanOnError, onError, ScanOnErrorReject, ScanOnErrorAllow)
+	}
+	return scanner.New(kind, addr, timeout)
+}
+
+// Ensure scanFS implements FileSystem
+var _ filesystem.FileSystem = (*scanFS)(nil)
@@ -0,0 +1,89 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestScan(t *testing.T) {
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "EICAR") {
+			w.Write([]byte(`{"clean": false, "reason": "Eicar-Test-Signature"}`))
+			return
+		}
+		w.Write([]byte(`{"clean": true}`))
+	}))
+	defer scanner.Close()
+
+	mfs := NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	for mount, cfg := range map[string]map[string]interface{}{
+		"/reject":     {"scan": "webhook", "scan_url": scanner.URL},
+		"/quarantine": {"scan": "webhook", "scan_url": scanner.URL, "scan_action": "quarantine"},
+		"/closed":     {"scan": "webhook", "scan_url": "http://127.0.0.1:1/scan"},
+		"/open":       {"scan": "webhook", "scan_url": "http://127.0.0.1:1/scan", "scan_on_error": "allow"},
+	} {
+		if err := mfs.MountPlugin("memfs", mount, cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mfs.MountPlugin("memfs", "/bad", map[string]interface{}{"scan": "webhook", "scan_url": scanner.URL, "scan_action": "delete"}); err == nil {
+		t.Error("invalid scan_action accepted")
+	}
+
+	// Clean content is written, flagged content is not
+	if _, err := mfs.Write("/reject/clean.txt", []byte("hello")); err != nil {
+		t.Errorf("clean write: %v", err)
+	}
+	if _, err := mfs.Write("/reject/eicar.txt", []byte("X5O!P%@AP EICAR")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("flagged write: %v", err)
+	}
+	if _, err := mfs.Stat("/reject/eicar.txt"); !filesystem.IsNotFound(err) {
+		t.Errorf("flagged file written: %v", err)
+	}
+	w, err := mfs.OpenWrite("/reject/streamed.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("X5O!P%@AP "))
+	w.Write([]byte("EICAR"))
+	if err := w.Close(); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("flagged stream: %v", err)
+	}
+
+	// Quarantined content is kept where it can be listed and removed, but not read
+	if _, err := mfs.Write("/quarantine/eicar.txt", []byte("X5O!P%@AP EICAR")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("quarantined write: %v", err)
+	}
+	batches, err := mfs.ReadDir("/quarantine" + QuarantineDir)
+	if err != nil || len(batches) != 1 {
+		t.Fatalf("quarantine = %v, %v", batches, err)
+	}
+	kept := "/quarantine" + QuarantineDir + "/" + batches[0].Name + "/eicar.txt"
+	if _, err := mfs.Read(kept, 0, -1); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("read of quarantined content: %v", err)
+	}
+	if _, err := mfs.Write(kept, []byte("replaced")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("write to the quarantine: %v", err)
+	}
+	if err := mfs.RemoveAll("/quarantine" + QuarantineDir + "/" + batches[0].Name); err != nil {
+		t.Errorf("removal from the quarantine: %v", err)
+	}
+
+	// Scanners that cannot be reached fail closed unless the mount allows writes then
+	if _, err := mfs.Write("/closed/a.txt", []byte("hello")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("write without a scanner, failing closed: %v", err)
+	}
+	if _, err := mfs.Write("/open/a.txt", []byte("hello")); err != nil {
+		t.Errorf("write without a scanner, failing open: %v", err)
+	}
+}
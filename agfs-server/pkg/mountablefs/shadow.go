@@ -0,0 +1,395 @@
+package mountablefs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultShadowQueue is the number of writes that may wait to be mirrored before further
+// ones are dropped
+const DefaultShadowQueue = 1000
+
+// maxShadowDivergences bounds the divergences kept in ShadowStats and the differences
+// listed in a ShadowReport
+const maxShadowDivergences = 100
+
+// ShadowStats counts the writes of a mount mirrored to its shadow
+type ShadowStats struct {
+	Target      string             `json:"target"`      // Directory receiving the writes
+	Mirrored    int64              `json:"mirrored"`    // Writes applied to the shadow
+	Diverged    int64              `json:"diverged"`    // Writes that failed on only one side
+	Dropped     int64              `json:"dropped"`     // Writes not mirrored because the queue was full
+	Queued      int                `json:"queued"`      // Writes waiting to be mirrored
+	Divergences []ShadowDivergence `json:"divergences"` // The most recent divergences, oldest first
+}
+
+// ShadowDivergence is a write that failed on the primary mount or on its shadow only
+type ShadowDivergence struct {
+	Time         time.Time `json:"time"`
+	Op           string    `json:"op"`
+	Path         string    `json:"path"` // Relative to the mount
+	PrimaryError string    `json:"primaryError,omitempty"`
+	ShadowError  string    `json:"shadowError,omitempty"`
+}
+
+// shadowOp is a write of the primary mount to repeat on the shadow
+type shadowOp struct {
+	op         string
+	path       string // Relative to the mount
+	newPath    string // Rename target
+	data       []byte
+	mode       uint32
+	primaryErr error
+}
+
+// shadowMirror repeats the writes of a mount in a directory of the tree, in order and in the
+// background so that the shadow never slows down or fails the primary mount
+type shadowMirror struct {
+	root   filesystem.FileSystem // Tree the shadow directory is resolved in
+	target string
+
+	ops  chan shadowOp
+	done chan struct{}
+	once sync.Once
+
+	mirrored atomic.Int64
+	diverged atomic.Int64
+	dropped  atomic.Int64
+
+	mu          sync.Mutex
+	divergences []ShadowDivergence
+}
+
+// newShadowMirror creates a mirror writing to target in root and starts its worker
+func newShadowMirror(root filesystem.FileSystem, target string, queueSize int) *shadowMirror {
+	m := &shadowMirror{
+		root:   root,
+		target: filesystem.NormalizePath(target),
+		ops:    make(chan shadowOp, queueSize),
+		done:   make(chan struct{}),
+	}
+	go m.worker()
+	return m
+}
+
+// mirror queues op with the outcome of the primary write, dropping it if the queue is full
+func (m *shadowMirror) mirror(op shadowOp, primaryErr error) {
+	op.primaryErr = primaryErr
+	select {
+	case <-m.done:
+		return
+	default:
+	}
+	select {
+	case m.ops <- op:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+func (m *shadowMirror) worker() {
+	for {
+		select {
+		case op := <-m.ops:
+			m.apply(op)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// apply repeats op on the shadow and records whether the outcomes differ
+func (m *shadowMirror) apply(op shadowOp) {
+	target := path.Join(m.target, op.path)
+	var err error
+	switch op.op {
+	case "create":
+		err = m.root.Create(target)
+	case "mkdir":
+		err = m.root.Mkdir(target, op.mode)
+	case "write":
+		_, err = m.root.Write(target, op.data)
+	case "remove":
+		err = m.root.Remove(target)
+	case "removeall":
+		err = m.root.RemoveAll(target)
+	case "rename":
+		err = m.root.Rename(target, path.Join(m.target, op.newPath))
+	case "chmod":
+		err = m.root.Chmod(target, op.mode)
+	case "touch":
+		if toucher, ok := m.root.(filesystem.Toucher); ok {
+			err = toucher.Touch(target)
+		}
+	}
+	m.mirrored.Add(1)
+	if (err == nil) == (op.primaryErr == nil) {
+		return
+	}
+	m.diverged.Add(1)
+	d := ShadowDivergence{Time: time.Now(), Op: op.op, Path: op.path}
+	if op.primaryErr != nil {
+		d.PrimaryError = op.primaryErr.Error()
+	}
+	if err != nil {
+		d.ShadowError = err.Error()
+	}
+	log.Debugf("[shadow] %s %s diverged: primary %q, shadow %q", op.op, op.path, d.PrimaryError, d.ShadowError)
+	m.mu.Lock()
+	m.divergences = append(m.divergences, d)
+	if len(m.divergences) > maxShadowDivergences {
+		m.divergences = m.divergences[len(m.divergences)-maxShadowDivergences:]
+	}
+	m.mu.Unlock()
+}
+
+// Close stops the worker once its current write is done; writes still queued are not mirrored
+func (m *shadowMirror) Close() error {
+	m.once.Do(func() { close(m.done) })
+	return nil
+}
+
+// Stats returns the counters of the mirror
+func (m *shadowMirror) Stats() ShadowStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return ShadowStats{
+		Target:      m.target,
+		Mirrored:    m.mirrored.Load(),
+		Diverged:    m.diverged.Load(),
+		Dropped:     m.dropped.Load(),
+		Queued:      len(m.ops),
+		Divergences: append([]ShadowDivergence{}, m.divergences...),
+	}
+}
+
+// checkShadow rejects a shadow of the mount at mountPath inside the mount, whose writes
+// would be mirrored again
+func checkShadow(mountPath string, opts MountOptions) error {
+	if opts.Shadow == "" {
+		return nil
+	}
+	if opts.Shadow == mountPath || strings.HasPrefix(opts.Shadow, strings.TrimSuffix(mountPath, "/")+"/") {
+		return fmt.Errorf("%s %s is inside the mount %s", OptionShadow, opts.Shadow, mountPath)
+	}
+	return nil
+}
+
+// shadowFS wraps a FileSystem so that its writes are also applied to a shadow directory
+// Reads are only served by the wrapped file system
+type shadowFS struct {
+	filesystem.FileSystem
+	mirror *shadowMirror
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system; the
+// shadow writes are not bound, they happen after the call
+func (s *shadowFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &shadowFS{FileSystem: filesystem.WithContext(s.FileSystem, ctx), mirror: s.mirror}
+}
+
+func (s *shadowFS) Create(p string) error {
+	err := s.FileSystem.Create(p)
+	s.mirror.mirror(shadowOp{op: "create", path: p}, err)
+	return err
+}
+
+func (s *shadowFS) Mkdir(p string, perm uint32) error {
+	err := s.FileSystem.Mkdir(p, perm)
+	s.mirror.mirror(shadowOp{op: "mkdir", path: p, mode: perm}, err)
+	return err
+}
+
+func (s *shadowFS) Write(p string, data []byte) ([]byte, error) {
+	result, err := s.FileSystem.Write(p, data)
+	s.mirror.mirror(shadowOp{op: "write", path: p, data: bytes.Clone(data)}, err)
+	return result, err
+}
+
+// OpenWrite buffers the written data, which is written as a whole on Close
+func (s *shadowFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(p, s.Write), nil
+}
+
+func (s *shadowFS) Remove(p string) error {
+	err := s.FileSystem.Remove(p)
+	s.mirror.mirror(shadowOp{op: "remove", path: p}, err)
+	return err
+}
+
+func (s *shadowFS) RemoveAll(p string) error {
+	err := s.FileSystem.RemoveAll(p)
+	s.mirror.mirror(shadowOp{op: "removeall", path: p}, err)
+	return err
+}
+
+func (s *shadowFS) Rename(oldPath, newPath string) error {
+	err := s.FileSystem.Rename(oldPath, newPath)
+	s.mirror.mirror(shadowOp{op: "rename", path: oldPath, newPath: newPath}, err)
+	return err
+}
+
+func (s *shadowFS) Chmod(p string, mode uint32) error {
+	err := s.FileSystem.Chmod(p, mode)
+	s.mirror.mirror(shadowOp{op: "chmod", path: p, mode: mode}, err)
+	return err
+}
+
+// ShadowStats returns the counters of the mount's shadow, ok is false if it has none
+func (mp *MountPoint) ShadowStats() (stats ShadowStats, ok bool) {
+	if mp.shadow == nil {
+		return ShadowStats{}, false
+	}
+	return mp.shadow.Stats(), true
+}
+
+// Reasons of ShadowDifference
+const (
+	ShadowMissing  = "missing"    // Only on the primary mount
+	ShadowExtra    = "extra"      // Only in the shadow
+	ShadowType     = "type"       // A file on one side and a directory on the other
+	ShadowSize     = "size"       // Sizes differ
+	ShadowContent  = "content"    // Same size, different content
+	ShadowReadFail = "unreadable" // Could not be read on either side
+)
+
+// ShadowDifference is an entry that differs between a mount and its shadow
+type ShadowDifference struct {
+	Path   string `json:"path"` // Relative to the mount
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ShadowReport compares the tree of a mount with its shadow
+type ShadowReport struct {
+	Mount       string             `json:"mount"`
+	Shadow      ShadowStats        `json:"shadow"`
+	Compared    int                `json:"compared"`    // Entries compared
+	Identical   bool               `json:"identical"`   // No difference found
+	Differences []ShadowDifference `json:"differences"` // Up to 100 differences
+	Truncated   bool               `json:"truncated,omitempty"`
+}
+
+// CompareShadow compares the files of the mount at mountPath with its shadow by type, size
+// and content; the trash, checksum and stats files of the mounts are ignored
+func (mfs *MountableFS) CompareShadow(mountPath string) (*ShadowReport, error) {
+	mountPath = filesystem.NormalizePath(mountPath)
+	mfs.mu.RLock()
+	mount, ok := mfs.mounts[mountPath]
+	mfs.mu.RUnlock()
+	if !ok {
+		return nil, filesystem.NewNotFoundError("shadow", mountPath)
+	}
+	if mount.shadow == nil {
+		return nil, filesystem.NewInvalidArgumentError("shadow", mountPath, "mount has no shadow")
+	}
+
+	report := &ShadowReport{Mount: mountPath, Shadow: mount.shadow.Stats(), Differences: []ShadowDifference{}}
+	differ := func(rel, reason string, err error) {
+		if len(report.Differences) >= maxShadowDivergences {
+			report.Truncated = true
+			return
+		}
+		d := ShadowDifference{Path: rel, Reason: reason}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		report.Differences = append(report.Differences, d)
+	}
+	mfs.compareShadowDir(mountPath, mount.shadow.target, "/", report, differ)
+	report.Identical = len(report.Differences) == 0
+	return report, nil
+}
+
+// compareShadowDir compares directory rel of the mount at primary with the one of shadow
+func (mfs *MountableFS) compareShadowDir(primary, shadow, rel string, report *ShadowReport, differ func(rel, reason string, err error)) {
+	primaryEntries, err := mfs.shadowEntries(path.Join(primary, rel), rel == "/")
+	if err != nil {
+		differ(rel, ShadowReadFail, err)
+		return
+	}
+	shadowEntries, err := mfs.shadowEntries(path.Join(shadow, rel), rel == "/")
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			differ(rel, ShadowMissing, nil)
+		} else {
+			differ(rel, ShadowReadFail, err)
+		}
+		return
+	}
+
+	names := make([]string, 0, len(primaryEntries))
+	for name := range primaryEntries {
+		names = append(names, name)
+	}
+	for name := range shadowEntries {
+		if _, ok := primaryEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := path.Join(rel, name)
+		p, inPrimary := primaryEntries[name]
+		s, inShadow := shadowEntries[name]
+		report.Compared++
+		switch {
+		case !inShadow:
+			differ(child, ShadowMissing, nil)
+		case !inPrimary:
+			differ(child, ShadowExtra, nil)
+		case p.IsDir != s.IsDir:
+			differ(child, ShadowType, nil)
+		case p.IsDir:
+			mfs.compareShadowDir(primary, shadow, child, report, differ)
+		case p.Size != s.Size:
+			differ(child, ShadowSize, nil)
+		default:
+			a, err := mfs.Read(path.Join(primary, child), 0, -1)
+			if err != nil && err != io.EOF {
+				differ(child, ShadowReadFail, err)
+				continue
+			}
+			b, err := mfs.Read(path.Join(shadow, child), 0, -1)
+			if err != nil && err != io.EOF {
+				differ(child, ShadowReadFail, err)
+				continue
+			}
+			if !bytes.Equal(a, b) {
+				differ(child, ShadowContent, nil)
+			}
+		}
+	}
+}
+
+// shadowEntries lists dir by name; at the top of a mount, the files MountableFS adds to
+// mounts are left out
+func (mfs *MountableFS) shadowEntries(dir string, top bool) (map[string]filesystem.FileInfo, error) {
+	infos, err := mfs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]filesystem.FileInfo, len(infos))
+	for _, info := range infos {
+		if top {
+			switch "/" + info.Name {
+			case TrashDir, ChecksumDir, plugin.StatsFile:
+				continue
+			}
+		}
+		entries[info.Name] = info
+	}
+	return entries, nil
+}
@@ -0,0 +1,99 @@
+package mountablefs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestShadow(t *testing.T) {
+	mfs := NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("localfs", func() plugin.ServicePlugin { return localfs.NewLocalFSPlugin() })
+	if err := mfs.MountPlugin("localfs", "/new", map[string]interface{}{"local_dir": t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("memfs", "/old", map[string]interface{}{"shadow": "/old/copy"}); err == nil {
+		t.Fatal("shadow inside the mount accepted")
+	}
+	if err := mfs.MountPlugin("memfs", "/old", map[string]interface{}{"shadow": "/new"}); err != nil {
+		t.Fatal(err)
+	}
+	mount, _ := mfs.LookupMount("/old")
+
+	// Existing files are copied, as a migration would
+	readme, _ := mfs.Read("/old/README", 0, -1)
+	if _, err := mfs.Write("/new/README", readme); err != nil {
+		t.Fatal(err)
+	}
+
+	// wait returns the stats once every queued write is mirrored
+	wait := func(mirrored int64) ShadowStats {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stats, _ := mount.ShadowStats()
+			if stats.Mirrored >= mirrored || time.Now().After(deadline) {
+				return stats
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if err := mfs.Mkdir("/old/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/old/dir/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Append("/old/dir/a", []byte(" world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Rename("/old/dir/a", "/old/dir/b"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := wait(4); stats.Mirrored != 4 || stats.Diverged != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if data, err := mfs.Read("/new/dir/b", 0, -1); err != nil && err != io.EOF || string(data) != "hello world" {
+		t.Fatalf("shadow content = %q, %v", data, err)
+	}
+
+	report, err := mfs.CompareShadow("/old")
+	if err != nil || !report.Identical || report.Compared != 3 {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+
+	// Diverge: a file only in the shadow, then a write only the shadow refuses
+	if _, err := mfs.Write("/new/extra", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/new/c", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/old/c", []byte("file")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := wait(5); stats.Diverged != 1 || len(stats.Divergences) != 1 || stats.Divergences[0].Path != "/c" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	report, err = mfs.CompareShadow("/old")
+	if err != nil || report.Identical {
+		t.Fatalf("report = %+v, %v", report, err)
+	}
+	reasons := make(map[string]string)
+	for _, d := range report.Differences {
+		reasons[d.Path] = d.Reason
+	}
+	if reasons["/c"] != ShadowType || reasons["/extra"] != ShadowExtra || len(reasons) != 2 {
+		t.Errorf("differences = %+v", report.Differences)
+	}
+
+	if _, err := mfs.CompareShadow("/new"); err == nil {
+		t.Error("comparing a mount without shadow succeeded")
+	}
+}
@@ -0,0 +1,75 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestStatsFile(t *testing.T) {
+	mfs := NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/m", map[string]interface{}{OptionStats: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("memfs", "/plain", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	mfs.Mkdir("/m/dir", 0755)
+	mfs.Write("/m/dir/a", []byte("hello"))
+	mfs.Read("/m/dir/a", 0, -1)
+	mfs.Stat("/m/missing")
+	mfs.Remove("/m/dir")
+
+	data, err := mfs.Read("/m/.stats", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	var stats plugin.InstanceStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("stats %s: %v", data, err)
+	}
+	if stats.Plugin != "memfs" || stats.Errors != 1 || stats.LastError == "" || stats.LastErrorAt == nil {
+		t.Errorf("stats = %+v", stats)
+	}
+	if st := stats.Ops["write"]; st.Calls != 1 || st.Bytes != 5 {
+		t.Errorf("write stats = %+v", st)
+	}
+	if st := stats.Ops["read"]; st.Calls != 1 || st.Bytes != 5 {
+		t.Errorf("read stats = %+v", st)
+	}
+	if st := stats.Ops["stat"]; st.NotFound != 1 || st.Errors != 0 {
+		t.Errorf("stat stats = %+v", st)
+	}
+	if st := stats.Ops["remove"]; st.Errors != 1 {
+		t.Errorf("remove stats = %+v", st)
+	}
+	// memfs also holds its README
+	if stats.Gauges["files"] != float64(2) || stats.Gauges["directories"] != float64(1) {
+		t.Errorf("gauges = %v", stats.Gauges)
+	}
+
+	infos, err := mfs.ReadDir("/m")
+	if err != nil || len(infos) != 3 || infos[2].Name != ".stats" {
+		t.Fatalf("readdir = %+v, %v", infos, err)
+	}
+	if _, err := mfs.Write("/m/.stats", []byte("{}")); err == nil {
+		t.Error("write to .stats succeeded")
+	}
+	if err := mfs.Remove("/m/.stats"); err == nil {
+		t.Error("remove of .stats succeeded")
+	}
+	// Reading the stats file is not an operation of the plugin
+	data, _ = mfs.Read("/m/.stats", 0, -1)
+	if err := json.Unmarshal(data, &stats); err != nil || stats.Ops["read"].Calls != 1 {
+		t.Errorf("read calls = %d after reading .stats, %v", stats.Ops["read"].Calls, err)
+	}
+
+	if _, err := mfs.Stat("/plain/.stats"); err == nil {
+		t.Error("mount without the stats option serves .stats")
+	}
+}
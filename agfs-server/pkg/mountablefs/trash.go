@@ -0,0 +1,362 @@
+package mountablefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// Trash layout within a mount
+const (
+	TrashDir         = "/.trash"          // Directory holding trashed entries
+	TrashRestoreFile = "/.trash/.restore" // Control file: write a trashed path to restore it
+
+	// trashBatchLayout names the per-removal directory under TrashDir
+	trashBatchLayout = "20060102-150405.000000000"
+
+	// trashPurgeInterval is how often expired trash batches are purged
+	trashPurgeInterval = time.Minute
+)
+
+// Meta values for the trash facility
+const (
+	MetaValueTrashControl = "trash-control"
+)
+
+const trashRestoreHelp = `Trash restore control file
+
+Removed entries are kept under /.trash/<timestamp>/<original path>.
+Write a trashed path to this file to move it back to its original location:
+
+  echo "20250101-120000.000000000/docs/report.txt" > /.trash/.restore
+
+Remove entries under /.trash to delete them permanently.
+`
+
+// trashFS wraps a FileSystem so that Remove and RemoveAll move entries into
+// /.trash/<timestamp>/ instead of deleting them immediately
+type trashFS struct {
+	filesystem.FileSystem
+	retention time.Duration
+	held      func(p string) bool // Reports whether a hold keeps p or an entry below it, nil for none
+	done      chan struct{}
+}
+
+// newTrashFS creates a trash wrapper and starts the retention purger, which keeps the
+// batches held reports held
+func newTrashFS(fs filesystem.FileSystem, retention time.Duration, held func(p string) bool) *trashFS {
+	t := &trashFS{
+		FileSystem: fs,
+		retention:  retention,
+		held:       held,
+		done:       make(chan struct{}),
+	}
+	if retention > 0 {
+		go t.purgeLoop()
+	}
+	return t
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system
+// The view shares the purger of t and must not be closed
+func (t *trashFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *t
+	bound.FileSystem = filesystem.WithContext(t.FileSystem, ctx)
+	return &bound
+}
+
+// Close stops the background purger
+func (t *trashFS) Close() error {
+	close(t.done)
+	return nil
+}
+
+// isTrashPath checks if path is the trash directory or inside it
+func isTrashPath(p string) bool {
+	return p == TrashDir || strings.HasPrefix(p, TrashDir+"/")
+}
+
+func (t *trashFS) Create(p string) error {
+	if isTrashPath(filesystem.NormalizePath(p)) {
+		return filesystem.NewPermissionDeniedError("create", p, "trash is managed by the server")
+	}
+	return t.FileSystem.Create(p)
+}
+
+func (t *trashFS) Mkdir(p string, perm uint32) error {
+	if isTrashPath(filesystem.NormalizePath(p)) {
+		return filesystem.NewPermissionDeniedError("mkdir", p, "trash is managed by the server")
+	}
+	return t.FileSystem.Mkdir(p, perm)
+}
+
+func (t *trashFS) Remove(p string) error {
+	p = filesystem.NormalizePath(p)
+	if p == "/" || (isTrashPath(p) && p != TrashRestoreFile) {
+		// Removing from the trash deletes permanently
+		return t.FileSystem.Remove(p)
+	}
+	if p == TrashRestoreFile {
+		return filesystem.NewPermissionDeniedError("remove", p, "control file")
+	}
+
+	info, err := t.FileSystem.Stat(p)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		entries, err := t.FileSystem.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("directory not empty: %s", p)
+		}
+	}
+
+	return t.moveToTrash([]string{p})
+}
+
+func (t *trashFS) RemoveAll(p string) error {
+	p = filesystem.NormalizePath(p)
+	if p == TrashRestoreFile {
+		return filesystem.NewPermissionDeniedError("removeall", p, "control file")
+	}
+	if isTrashPath(p) {
+		return t.FileSystem.RemoveAll(p)
+	}
+
+	if p != "/" {
+		if _, err := t.FileSystem.Stat(p); err != nil {
+			return err
+		}
+		return t.moveToTrash([]string{p})
+	}
+
+	// The mount root cannot be moved into its own trash, move its children instead
+	entries, err := t.FileSystem.ReadDir("/")
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, entry := range entries {
+		child := "/" + entry.Name
+		// Control files of other mount wrappers cannot be moved
+		if child != TrashDir && entry.Meta.Type != MetaValueScrubControl {
+			paths = append(paths, child)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	return t.moveToTrash(paths)
+}
+
+// moveToTrash moves paths into a new timestamped batch directory, keeping their original layout
+func (t *trashFS) moveToTrash(paths []string) error {
+	batch := path.Join(TrashDir, time.Now().UTC().Format(trashBatchLayout))
+	for _, p := range paths {
+		dest := batch + p
+		if err := filesystem.MkdirAll(t.FileSystem, path.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to prepare trash for %s: %w", p, err)
+		}
+		if err := t.FileSystem.Rename(p, dest); err != nil {
+			return fmt.Errorf("failed to move %s to trash: %w", p, err)
+		}
+		log.Debugf("[trash] moved %s to %s", p, dest)
+	}
+	return nil
+}
+
+// restore moves a trashed entry back to its original location
+// trashed is relative to TrashDir, e.g. "<timestamp>/docs/report.txt"
+func (t *trashFS) restore(trashed string) error {
+	trashed = strings.TrimSpace(trashed)
+	trashed = strings.TrimPrefix(filesystem.NormalizePath(trashed), TrashDir)
+	trashed = strings.TrimPrefix(trashed, "/")
+
+	parts := strings.SplitN(trashed, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return filesystem.NewInvalidArgumentError("path", trashed, "expected <timestamp>/<original path>")
+	}
+	if _, err := time.Parse(trashBatchLayout, parts[0]); err != nil {
+		return filesystem.NewInvalidArgumentError("path", trashed, "unknown trash batch")
+	}
+
+	src := path.Join(TrashDir, trashed)
+	dest := "/" + parts[1]
+
+	if _, err := t.FileSystem.Stat(src); err != nil {
+		return filesystem.NewNotFoundError("restore", src)
+	}
+	if _, err := t.FileSystem.Stat(dest); err == nil {
+		return filesystem.NewAlreadyExistsError("file", dest)
+	}
+
+	if err := filesystem.MkdirAll(t.FileSystem, path.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := t.FileSystem.Rename(src, dest); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", dest, err)
+	}
+
+	t.pruneEmptyDirs(path.Dir(src))
+	log.Infof("[trash] restored %s from %s", dest, src)
+	return nil
+}
+
+// pruneEmptyDirs removes empty directories from dir upwards, stopping at TrashDir
+func (t *trashFS) pruneEmptyDirs(dir string) {
+	for dir != TrashDir && isTrashPath(dir) {
+		entries, err := t.FileSystem.ReadDir(dir)
+		if err != nil || len(entries) > 0 {
+			return
+		}
+		if err := t.FileSystem.Remove(dir); err != nil {
+			return
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// purgeLoop periodically removes batches older than the retention period
+func (t *trashFS) purgeLoop() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			t.purgeExpired()
+		}
+	}
+}
+
+// purgeExpired permanently deletes expired trash batches
+func (t *trashFS) purgeExpired() {
+	entries, err := t.FileSystem.ReadDir(TrashDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		removedAt, err := time.Parse(trashBatchLayout, entry.Name)
+		if err != nil {
+			continue
+		}
+		if time.Since(removedAt) < t.retention {
+			continue
+		}
+		batch := path.Join(TrashDir, entry.Name)
+		if t.held != nil && t.held(batch) {
+			log.Debugf("[trash] keeping expired batch %s, it is held", batch)
+			continue
+		}
+		if err := t.FileSystem.RemoveAll(batch); err != nil {
+			log.Warnf("[trash] failed to purge %s: %v", batch, err)
+			continue
+		}
+		log.Infof("[trash] purged expired batch %s", batch)
+	}
+}
+
+func (t *trashFS) restoreFileInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    path.Base(TrashRestoreFile),
+		Size:    int64(len(trashRestoreHelp)),
+		Mode:    0644,
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Type: MetaValueTrashControl},
+	}
+}
+
+func (t *trashFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if filesystem.NormalizePath(p) == TrashRestoreFile {
+		return plugin.ApplyRangeRead([]byte(trashRestoreHelp), offset, size)
+	}
+	return t.FileSystem.Read(p, offset, size)
+}
+
+func (t *trashFS) Write(p string, data []byte) ([]byte, error) {
+	p = filesystem.NormalizePath(p)
+	if p == TrashRestoreFile {
+		if err := t.restore(string(data)); err != nil {
+			return nil, err
+		}
+		return []byte("restored"), nil
+	}
+	if isTrashPath(p) {
+		return nil, filesystem.NewPermissionDeniedError("write", p, "trash is managed by the server")
+	}
+	return t.FileSystem.Write(p, data)
+}
+
+func (t *trashFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(p) != TrashDir {
+		return t.FileSystem.ReadDir(p)
+	}
+
+	// The trash directory is created lazily, list it as empty until then
+	entries, err := t.FileSystem.ReadDir(TrashDir)
+	if err != nil {
+		entries = nil
+	}
+	return append(entries, *t.restoreFileInfo()), nil
+}
+
+func (t *trashFS) Stat(p string) (*filesystem.FileInfo, error) {
+	p = filesystem.NormalizePath(p)
+	switch p {
+	case TrashRestoreFile:
+		return t.restoreFileInfo(), nil
+	case TrashDir:
+		if info, err := t.FileSystem.Stat(p); err == nil {
+			return info, nil
+		}
+		return &filesystem.FileInfo{
+			Name:    path.Base(TrashDir),
+			Mode:    0755,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Type: MetaValueTrashControl},
+		}, nil
+	}
+	return t.FileSystem.Stat(p)
+}
+
+func (t *trashFS) Rename(oldPath, newPath string) error {
+	if isTrashPath(filesystem.NormalizePath(newPath)) {
+		return filesystem.NewPermissionDeniedError("rename", newPath, "use remove to move entries into trash")
+	}
+	return t.FileSystem.Rename(oldPath, newPath)
+}
+
+func (t *trashFS) Open(p string) (io.ReadCloser, error) {
+	if filesystem.NormalizePath(p) == TrashRestoreFile {
+		return io.NopCloser(strings.NewReader(trashRestoreHelp)), nil
+	}
+	return t.FileSystem.Open(p)
+}
+
+func (t *trashFS) OpenWrite(p string) (io.WriteCloser, error) {
+	p = filesystem.NormalizePath(p)
+	if p == TrashRestoreFile {
+		return filesystem.NewBufferedWriter(p, t.Write), nil
+	}
+	if isTrashPath(p) {
+		return nil, filesystem.NewPermissionDeniedError("openwrite", p, "trash is managed by the server")
+	}
+	return t.FileSystem.OpenWrite(p)
+}
+
+// Ensure trashFS implements FileSystem
+var _ filesystem.FileSystem = (*trashFS)(nil)
@@ -0,0 +1,133 @@
+package mountablefs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Watch implements filesystem.Watcher
+// The changes of mounts whose plugin implements filesystem.Watcher come from the plugin, so
+// that changes made behind the API are seen too; those of other mounts, and of mounts
+// mounted after the watch started, come from the change journal, which only sees changes
+// made through MountableFS.
+func (mfs *MountableFS) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	return mfs.watch(ctx, mfs, path, recursive, nil)
+}
+
+// watch watches path after checking it exists through fs, the MountableFS or a view of it,
+// forwarding the events whose path allow accepts, all without allow
+func (mfs *MountableFS) watch(ctx context.Context, fs filesystem.FileSystem, p string, recursive bool, allow func(string) bool) (<-chan filesystem.Event, error) {
+	p = filesystem.NormalizePath(p)
+	if _, err := fs.Stat(p); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	out := make(chan filesystem.Event, filesystem.WatchBuffer)
+	var mu sync.Mutex
+	closed := false
+	forward := func(e filesystem.Event) {
+		if allow != nil && !allow(e.Path) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case out <- e:
+		default:
+		}
+	}
+
+	// Watch the plugins of the mount holding path and, recursively, of those below it
+	mfs.mu.RLock()
+	var mounts []*MountPoint
+	for _, mountPath := range mfs.mountPaths {
+		if filesystem.Watched(mountPath, true, p) || (recursive && filesystem.Watched(p, true, mountPath)) {
+			mounts = append(mounts, mfs.mounts[mountPath])
+		}
+	}
+	mfs.mu.RUnlock()
+	watched := make(map[*MountPoint]bool)
+	for _, mount := range mounts {
+		watcher, ok := mount.Plugin.GetFileSystem().(filesystem.Watcher)
+		if !ok {
+			continue
+		}
+		relPath, relRecursive := "/", true
+		holds := filesystem.Watched(mount.Path, true, p)
+		if holds {
+			relPath, relRecursive = "/"+strings.TrimPrefix(strings.TrimPrefix(p, mount.Path), "/"), recursive
+		}
+		events, err := watcher.Watch(ctx, relPath, relRecursive)
+		if err != nil {
+			if holds {
+				cancel()
+				return nil, err
+			}
+			log.Warnf("Watching %s: %v, only changes made through AGFS are reported", mount.Path, err)
+			continue
+		}
+		watched[mount] = true
+		go func(mountPath string) {
+			for e := range events {
+				e.Path = path.Join(mountPath, e.Path)
+				if e.OldPath != "" {
+					e.OldPath = path.Join(mountPath, e.OldPath)
+				}
+				forward(e)
+			}
+		}(mount.Path)
+	}
+
+	unsubscribe := mfs.Subscribe(func(c Change) {
+		e, ok := changeEvent(c)
+		if !ok || (!filesystem.Watched(p, recursive, e.Path) && (e.OldPath == "" || !filesystem.Watched(p, recursive, e.OldPath))) {
+			return
+		}
+		mfs.mu.RLock()
+		mount, _, found := mfs.findMount(e.Path)
+		mfs.mu.RUnlock()
+		if found && watched[mount] {
+			return // Reported by the plugin
+		}
+		forward(e)
+	})
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+		mu.Lock()
+		closed = true
+		close(out)
+		mu.Unlock()
+		cancel()
+	}()
+	return out, nil
+}
+
+// changeEvent returns the watch event of a change, if it has one
+func changeEvent(c Change) (filesystem.Event, bool) {
+	e := filesystem.Event{Path: c.Path, Time: c.Time}
+	switch c.Op {
+	case ChangeCreate:
+		e.Type = filesystem.EventCreate
+	case ChangeMkdir:
+		e.Type, e.IsDir = filesystem.EventCreate, true
+	case ChangeWrite, ChangeTouch:
+		e.Type = filesystem.EventWrite
+	case ChangeRemove, ChangeRemoveAll:
+		e.Type = filesystem.EventRemove
+	case ChangeRename:
+		e.Type, e.Path, e.OldPath = filesystem.EventRename, filesystem.NormalizePath(c.NewPath), c.Path
+	default:
+		return e, false
+	}
+	return e, true
+}
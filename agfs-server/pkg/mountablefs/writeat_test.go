@@ -0,0 +1,51 @@
+package mountablefs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestWriteAt(t *testing.T) {
+	mfs := NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("localfs", func() plugin.ServicePlugin { return localfs.NewLocalFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("localfs", "/local", map[string]interface{}{"local_dir": t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// memfs writes are read-modify-write, localfs writes are native
+	for _, p := range []string{"/mem/f", "/local/f"} {
+		for _, w := range []struct {
+			data   string
+			offset int64
+			size   int64
+		}{
+			{"hello", 0, 5},
+			{" world", 5, 11}, // At the end
+			{"W", 6, 11},      // Inside
+			{"!", 13, 14},     // Past the end
+		} {
+			size, err := mfs.WriteAt(p, []byte(w.data), w.offset)
+			if err != nil || size != w.size {
+				t.Fatalf("%s: write %q at %d = %d, %v, want %d", p, w.data, w.offset, size, err, w.size)
+			}
+		}
+		data, err := mfs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if want := "hello World\x00\x00!"; string(data) != want {
+			t.Errorf("%s = %q, want %q", p, data, want)
+		}
+		if _, err := mfs.WriteAt(p, []byte("x"), -1); err == nil {
+			t.Errorf("%s: write at a negative offset succeeded", p)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Package mtls provides the TLS configuration of connections between servers: a certificate
+// and key presented to the peer and a CA that peer certificates must be signed by, reloaded
+// when the files change so certificates can be rotated without a restart
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultReloadInterval is how often the files are checked for changes
+const DefaultReloadInterval = time.Minute
+
+// Certs holds the certificate, key and CA loaded from files and reloads them when the
+// files change; configurations returned by ServerConfig and ClientConfig always use the
+// current ones
+type Certs struct {
+	certFile, keyFile, caFile string
+
+	mu       sync.RWMutex // protects the fields below
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes [3]time.Time
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New loads the certificates of the server TLS configuration and watches them for rotation
+func New(cfg config.TLSConfig) (*Certs, error) {
+	interval := DefaultReloadInterval
+	if cfg.ReloadInterval != "" {
+		d, err := pluginconfig.ParseDuration(cfg.ReloadInterval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid reload_interval %q", cfg.ReloadInterval)
+		}
+		interval = d
+	}
+	c, err := Load(cfg.CertFile, cfg.KeyFile, cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	c.Watch(interval)
+	return c, nil
+}
+
+// Load loads the certificate and key and the CA from PEM files; the certificate may be
+// omitted by clients that only verify the server, the CA by servers that do not require
+// client certificates and by clients trusting the system roots
+func Load(certFile, keyFile, caFile string) (*Certs, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a certificate needs both a cert file and a key file")
+	}
+	if certFile == "" && caFile == "" {
+		return nil, errors.New("neither a certificate nor a CA is configured")
+	}
+	c := &Certs{certFile: certFile, keyFile: keyFile, caFile: caFile, done: make(chan struct{})}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload loads the files again if any of them changed since they were last loaded; on
+// error the previous certificates stay in use
+func (c *Certs) Reload() error {
+	var modTimes [3]time.Time
+	for i, name := range []string{c.certFile, c.keyFile, c.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	c.mu.RLock()
+	unchanged := c.modTimes == modTimes
+	c.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	if c.certFile != "" {
+		loaded, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &loaded
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.pool, c.modTimes = cert, pool, modTimes
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files every interval (DefaultReloadInterval if 0) until Close
+func (c *Certs) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+				if err := c.Reload(); err != nil {
+					log.Warnf("[mtls] failed to reload certificates, keeping the current ones: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops watching the files
+func (c *Certs) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Certs) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, c.pool
+}
+
+// ServerConfig returns the configuration of a server presenting the certificate; with a
+// CA, clients must present a certificate signed by it
+func (c *Certs) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+			cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{*cert}}
+			if pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns the configuration of a client presenting the certificate, if any,
+// and verifying servers against the CA, or the system roots without one; serverName
+// overrides the host name verified, which is the host of the request by default
+// With a CA, connections to IP addresses are only verified through Transport, which tells
+// the dialed address to the verification; TLS sends no server name for them.
+func (c *Certs) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := c.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if c.caFile == "" {
+		return cfg
+	}
+	// The CA may be rotated, so servers are verified here against the current one
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		name := serverName
+		if name == "" {
+			name = cs.ServerName
+		}
+		if name == "" {
+			return errors.New("no server name to verify the server certificate against")
+		}
+		_, pool := c.current()
+		opts := x509.VerifyOptions{DNSName: name, Roots: pool, Intermediates: x509.NewCertPool()}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return cfg
+}
+
+// Transport returns an HTTP transport connecting with cfg, with the settings of
+// http.DefaultTransport otherwise
+// A VerifyConnection of cfg gets the server name of cfg or the dialed host, IP addresses
+// included, in ServerName: the connection state only has the name sent to the server.
+func Transport(cfg *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	if cfg.VerifyConnection == nil {
+		return transport
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// The transport adds its protocols to its configuration, so that one is cloned
+		conf := transport.TLSClientConfig.Clone()
+		if conf.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			conf.ServerName = host
+		}
+		verify, name := conf.VerifyConnection, conf.ServerName
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			cs.ServerName = name
+			return verify(cs)
+		}
+		return (&tls.Dialer{NetDialer: dialer, Config: conf}).DialContext(ctx, network, addr)
+	}
+	return transport
+}
@@ -0,0 +1,195 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issuer signs certificates for the tests
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newIssuer(t *testing.T, name string) *issuer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for 127.0.0.1 and its key to dir, named after name
+func (ca *issuer) issue(t *testing.T, dir, name string, serial int64) (certFile, keyFile string) {
+	return ca.issueFor(t, dir, name, serial, "127.0.0.1")
+}
+
+// issueFor is issue for the address ip
+func (ca *issuer) issueFor(t *testing.T, dir, name string, serial int64, ip string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	write(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	write(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+// write replaces a file, moving its modification time forward so a reload notices it
+func write(t *testing.T, name string, data []byte) {
+	var prev time.Time
+	if info, err := os.Stat(name); err == nil {
+		prev = info.ModTime()
+	}
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !prev.IsZero() {
+		os.Chtimes(name, prev.Add(time.Second), prev.Add(time.Second))
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newIssuer(t, "agfs-ca")
+	caFile := filepath.Join(dir, "ca.crt")
+	write(t, caFile, ca.pem)
+	serverCert, serverKey := ca.issue(t, dir, "server", 2)
+	clientCert, clientKey := ca.issue(t, dir, "client", 3)
+
+	serverCerts, err := Load(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = serverCerts.ServerConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs *Certs) (string, error) {
+		client := &http.Client{Transport: Transport(certs.ClientConfig(""))}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	clientCerts, err := Load(clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := get(clientCerts); err != nil || name != "client" {
+		t.Fatalf("client with a certificate: %q, %v", name, err)
+	}
+
+	// Clients need a certificate signed by the CA
+	anonymous, err := Load("", "", caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(anonymous); err == nil {
+		t.Error("client without a certificate was accepted")
+	}
+	otherCert, otherKey := newIssuer(t, "other-ca").issue(t, dir, "intruder", 4)
+	intruder, err := Load(otherCert, otherKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(intruder); err == nil {
+		t.Error("client certificate of another CA was accepted")
+	}
+
+	// A rotated CA and client certificate are used once reloaded
+	rotated := newIssuer(t, "rotated-ca")
+	write(t, caFile, append(ca.pem, rotated.pem...))
+	if err := serverCerts.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	newCert, newKey := rotated.issue(t, dir, "rotated", 5)
+	data, _ := os.ReadFile(newCert)
+	write(t, clientCert, data)
+	data, _ = os.ReadFile(newKey)
+	write(t, clientKey, data)
+	if err := clientCerts.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := get(clientCerts); err != nil || name != "rotated" {
+		t.Errorf("rotated client certificate: %q, %v", name, err)
+	}
+
+	// A broken file keeps the current certificates
+	write(t, clientCert, []byte("not a certificate"))
+	if err := clientCerts.Reload(); err == nil {
+		t.Error("reloading a broken certificate: no error")
+	}
+	if name, err := get(clientCerts); err != nil || name != "rotated" {
+		t.Errorf("after a failed reload: %q, %v", name, err)
+	}
+
+	// Servers dialed by address must have it in their certificate
+	wrongCert, wrongKey := ca.issueFor(t, dir, "wrong", 6, "10.0.0.1")
+	wrongCerts, err := Load(wrongCert, wrongKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := httptest.NewUnstartedServer(srv.Config.Handler)
+	wrong.TLS = wrongCerts.ServerConfig()
+	wrong.StartTLS()
+	defer wrong.Close()
+	if resp, err := (&http.Client{Transport: Transport(clientCerts.ClientConfig(""))}).Get(wrong.URL); err == nil {
+		resp.Body.Close()
+		t.Error("server certificate for another address was accepted")
+	}
+	if resp, err := (&http.Client{Transport: Transport(clientCerts.ClientConfig("10.0.0.1"))}).Get(wrong.URL); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("server certificate for the configured name: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,292 @@
+// Package pfstest runs an in-memory AGFS server inside Go tests, so code using
+// client.Client can be tested without Docker or a network
+//
+//	func TestUpload(t *testing.T) {
+//		srv := pfstest.NewServer(t)
+//		srv.Seed(map[string]string{"/memfs/in/a.txt": "hello"})
+//		runUpload(srv.Client, "/memfs/in", "/memfs/out")
+//		srv.AssertTree("/memfs/out", map[string]string{"a.txt": "hello"})
+//	}
+package pfstest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/client"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// DefaultMemFSPath is where NewServer mounts memfs unless WithoutMemFS is given
+const DefaultMemFSPath = "/memfs"
+
+// mount is a plugin to mount when the server starts
+type mount struct {
+	path   string
+	plugin plugin.ServicePlugin
+	config map[string]interface{}
+}
+
+type options struct {
+	mounts  []mount
+	noMemFS bool
+}
+
+// Option configures a test server
+type Option func(*options)
+
+// WithPlugin mounts p at path with the plugin configuration cfg (may be nil)
+// Mount options such as trash or checksum are accepted in cfg as in the server configuration
+func WithPlugin(path string, p plugin.ServicePlugin, cfg map[string]interface{}) Option {
+	return func(o *options) {
+		o.mounts = append(o.mounts, mount{path: path, plugin: p, config: cfg})
+	}
+}
+
+// WithoutMemFS leaves out the memfs mounted at DefaultMemFSPath
+func WithoutMemFS() Option {
+	return func(o *options) {
+		o.noMemFS = true
+	}
+}
+
+// Server is an AGFS server listening on a local port for the duration of a test
+type Server struct {
+	URL    string                   // Base URL, e.g. http://127.0.0.1:41234
+	FS     *mountablefs.MountableFS // Root filesystem, for direct access bypassing HTTP
+	Client *client.Client           // Client connected to the server
+
+	t   testing.TB
+	srv *httptest.Server
+}
+
+// NewServer starts a server with memfs at DefaultMemFSPath and the plugins of opts
+// The server is closed and its plugins shut down when the test finishes
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	mfs := mountablefs.NewMountableFS()
+	mounts := o.mounts
+	if !o.noMemFS {
+		mounts = append([]mount{{path: DefaultMemFSPath, plugin: memfs.NewMemFSPlugin()}}, mounts...)
+	}
+	for _, m := range mounts {
+		if err := mountPlugin(mfs, m); err != nil {
+			t.Fatalf("pfstest: mount %s at %s: %v", m.plugin.Name(), m.path, err)
+		}
+	}
+
+	handler := handlers.NewHandler(mfs)
+	pluginHandler := handlers.NewPluginHandler(mfs)
+	mux := http.NewServeMux()
+	handler.SetupRoutes(mux)
+	pluginHandler.SetupRoutes(mux)
+	srv := httptest.NewServer(mux)
+
+	s := &Server{URL: srv.URL, FS: mfs, Client: client.NewClient(srv.URL), t: t, srv: srv}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// mountPlugin validates, initializes and mounts a plugin the way the server does at startup
+func mountPlugin(mfs *mountablefs.MountableFS, m mount) error {
+	opts, cfg, err := mountablefs.SplitMountOptions(m.config)
+	if err != nil {
+		return err
+	}
+	withPath := map[string]interface{}{"mount_path": m.path}
+	for k, v := range cfg {
+		withPath[k] = v
+	}
+	if err := m.plugin.Validate(withPath); err != nil {
+		return err
+	}
+	if err := plugin.Initialize(m.plugin, mfs.InitContext(m.plugin.Name(), m.path, withPath)); err != nil {
+		return err
+	}
+	return mfs.MountWithOptions(m.path, m.plugin, opts)
+}
+
+// Close stops the server and shuts its plugins down; it is called automatically at the end of the test
+func (s *Server) Close() {
+	if s.srv == nil {
+		return
+	}
+	s.srv.Close()
+	s.srv = nil
+	for _, mount := range s.FS.GetMounts() {
+		mount.Plugin.Shutdown()
+	}
+}
+
+// Seed creates files with the given content, and directories for paths ending in "/"
+// Missing parent directories are created
+func (s *Server) Seed(tree map[string]string) {
+	s.t.Helper()
+	paths := make([]string, 0, len(tree))
+	for p := range tree {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		isDir := strings.HasSuffix(p, "/")
+		clean := filesystem.NormalizePath(p)
+		dir := clean
+		if !isDir {
+			dir = path.Dir(clean)
+		}
+		s.mkdirAll(dir)
+		if isDir {
+			continue
+		}
+		if _, err := s.FS.Write(clean, []byte(tree[p])); err != nil {
+			s.t.Fatalf("pfstest: seed %s: %v", clean, err)
+		}
+	}
+}
+
+func (s *Server) mkdirAll(dir string) {
+	s.t.Helper()
+	if dir == "/" {
+		return
+	}
+	if info, err := s.FS.Stat(dir); err == nil {
+		if !info.IsDir {
+			s.t.Fatalf("pfstest: %s is not a directory", dir)
+		}
+		return
+	}
+	s.mkdirAll(path.Dir(dir))
+	if err := s.FS.Mkdir(dir, 0755); err != nil {
+		s.t.Fatalf("pfstest: mkdir %s: %v", dir, err)
+	}
+}
+
+// Tree returns the files below root by path relative to root, with directories as "<dir>/"
+// entries mapped to ""; mount READMEs are included like any other file
+func (s *Server) Tree(root string) map[string]string {
+	s.t.Helper()
+	root = filesystem.NormalizePath(root)
+	tree := make(map[string]string)
+	var walk func(dir, rel string)
+	walk = func(dir, rel string) {
+		infos, err := s.FS.ReadDir(dir)
+		if err != nil {
+			s.t.Fatalf("pfstest: readdir %s: %v", dir, err)
+		}
+		for _, info := range infos {
+			p := path.Join(dir, info.Name)
+			r := path.Join(rel, info.Name)
+			if info.IsDir {
+				tree[r+"/"] = ""
+				walk(p, r)
+				continue
+			}
+			tree[r] = s.read(p)
+		}
+	}
+	walk(root, "")
+	return tree
+}
+
+func (s *Server) read(p string) string {
+	s.t.Helper()
+	data, err := s.FS.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		s.t.Fatalf("pfstest: read %s: %v", p, err)
+	}
+	return string(data)
+}
+
+// AssertFile fails the test unless p is a file holding content
+func (s *Server) AssertFile(p, content string) {
+	s.t.Helper()
+	info, err := s.FS.Stat(p)
+	if err != nil {
+		s.t.Errorf("pfstest: %s: %v", p, err)
+		return
+	}
+	if info.IsDir {
+		s.t.Errorf("pfstest: %s is a directory", p)
+		return
+	}
+	if got := s.read(p); got != content {
+		s.t.Errorf("pfstest: %s holds %q, want %q", p, got, content)
+	}
+}
+
+// AssertDir fails the test unless p is a directory
+func (s *Server) AssertDir(p string) {
+	s.t.Helper()
+	info, err := s.FS.Stat(p)
+	if err != nil {
+		s.t.Errorf("pfstest: %s: %v", p, err)
+		return
+	}
+	if !info.IsDir {
+		s.t.Errorf("pfstest: %s is not a directory", p)
+	}
+}
+
+// AssertNotExist fails the test if p exists
+func (s *Server) AssertNotExist(p string) {
+	s.t.Helper()
+	if _, err := s.FS.Stat(p); err == nil {
+		s.t.Errorf("pfstest: %s exists", p)
+	}
+}
+
+// AssertTree fails the test unless the tree below root is exactly want, in the format of Tree
+// Directories implied by a file path may be left out of want
+func (s *Server) AssertTree(root string, want map[string]string) {
+	s.t.Helper()
+	got := s.Tree(root)
+	expected := make(map[string]string, len(want))
+	for p, content := range want {
+		p = strings.TrimPrefix(p, "/")
+		expected[p] = content
+		for dir := path.Dir(strings.TrimSuffix(p, "/")); dir != "."; dir = path.Dir(dir) {
+			expected[dir+"/"] = ""
+		}
+	}
+
+	var diffs []string
+	for p, content := range expected {
+		if g, ok := got[p]; !ok {
+			diffs = append(diffs, "missing "+p)
+		} else if g != content {
+			diffs = append(diffs, "content of "+p+": got "+quote(g)+", want "+quote(content))
+		}
+	}
+	for p := range got {
+		if _, ok := expected[p]; !ok {
+			diffs = append(diffs, "unexpected "+p)
+		}
+	}
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		s.t.Errorf("pfstest: tree %s differs:\n  %s", root, strings.Join(diffs, "\n  "))
+	}
+}
+
+// quote quotes s for a test failure, shortening long content
+func quote(s string) string {
+	if len(s) > 64 {
+		s = s[:64] + "..."
+	}
+	return strconv.Quote(s)
+}
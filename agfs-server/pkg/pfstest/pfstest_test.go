@@ -0,0 +1,44 @@
+package pfstest
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestServer(t *testing.T) {
+	srv := NewServer(t, WithPlugin("/trashed", memfs.NewMemFSPlugin(), map[string]interface{}{"trash": true}))
+	srv.Seed(map[string]string{
+		"/memfs/in/a.txt":  "hello",
+		"/memfs/in/sub/b":  "world",
+		"/memfs/in/empty/": "",
+	})
+
+	// Exercise the server through the client library
+	data, err := srv.Client.Read("/memfs/in/a.txt", 0, -1)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("read: %q, %v", data, err)
+	}
+	if _, err := srv.Client.Write("/memfs/in/c.txt", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Client.Remove("/memfs/in/sub/b"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Seed(map[string]string{"/trashed/x": "kept"})
+	if err := srv.Client.Remove("/trashed/x"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.AssertFile("/memfs/in/c.txt", "new")
+	srv.AssertDir("/memfs/in/empty")
+	srv.AssertNotExist("/memfs/in/sub/b")
+	srv.AssertNotExist("/trashed/x")
+	srv.AssertDir("/trashed/.trash")
+	srv.AssertTree("/memfs/in", map[string]string{
+		"a.txt":  "hello",
+		"c.txt":  "new",
+		"sub/":   "",
+		"empty/": "",
+	})
+}
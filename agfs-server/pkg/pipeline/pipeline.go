@@ -0,0 +1,804 @@
+// Package pipeline runs the declarative pipelines of the configuration: each pipeline takes
+// records from a source (new files of a watched directory, the messages of a queuefs queue
+// or a schedule), passes them through template, Lua script or WASM transforms, and writes
+// the result to its sinks (a directory, a queue or a webhook)
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the pipeline configuration
+const (
+	DefaultStateDir       = "pipelines"
+	DefaultInterval       = 10 * time.Second
+	DefaultBatch          = 100
+	DefaultTimeout        = 5 * time.Second
+	DefaultWebhookTimeout = 10 * time.Second
+)
+
+// Kinds of sources
+const (
+	SourceWatch    = "watch"
+	SourceQueue    = "queue"
+	SourceSchedule = "schedule"
+)
+
+// Pipeline states reported in Status
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateOK      = "ok"
+	StateFailed  = "failed"
+)
+
+// Commands accepted by Control
+const (
+	CommandStart = "start"
+	CommandStop  = "stop"
+	CommandRun   = "run"
+)
+
+// ErrRunning is returned when a pipeline is run while its previous run has not finished
+var ErrRunning = errors.New("pipeline already running")
+
+// Record is what flows through a pipeline; transforms rewrite its Data
+type Record struct {
+	ID       string    `json:"id"`             // Message ID for queue sources, a new UUID otherwise
+	Pipeline string    `json:"pipeline"`       // Name of the pipeline
+	Source   string    `json:"source"`         // "watch", "queue" or "schedule"
+	Path     string    `json:"path,omitempty"` // File the data was read from
+	Name     string    `json:"name"`           // Name of the file written by path sinks
+	Size     int64     `json:"size"`           // Size of the data read from the source
+	Time     time.Time `json:"time"`
+	Data     string    `json:"data"`
+}
+
+// Status reports the configuration, control state, totals and last run of a pipeline
+type Status struct {
+	Name       string     `json:"name"`
+	Source     string     `json:"source"` // e.g. "queue /queuefs/events"
+	Transforms int        `json:"transforms"`
+	Sinks      []string   `json:"sinks"` // e.g. ["path /s3fs/processed"]
+	Interval   string     `json:"interval"`
+	Stopped    bool       `json:"stopped"` // Stopped through the control file; runs only when asked to
+	State      string     `json:"state"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	Processed  int        `json:"processed"` // Records taken by the last run
+	Records    int64      `json:"records"`   // Records written to the sinks since the pipeline was created
+	Dropped    int64      `json:"dropped"`   // Records dropped by a transform
+	Failed     int64      `json:"failed"`    // Records that failed in a transform or sink
+	Error      string     `json:"error,omitempty"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+}
+
+// seen is a watched file that went through the pipeline
+type seen struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// state is what a pipeline keeps in the state directory across restarts
+type state struct {
+	Stopped bool             `json:"stopped"`
+	Records int64            `json:"records"`
+	Dropped int64            `json:"dropped"`
+	Failed  int64            `json:"failed"`
+	Files   map[string]*seen `json:"files,omitempty"` // Files of a watch source, by path
+}
+
+// pipeline is a configured pipeline with its runtime state
+type pipeline struct {
+	cfg        config.PipelineConfig
+	kind       string
+	interval   time.Duration
+	filter     *archive.Filter
+	transforms []transform
+	stateFile  string
+
+	runMu  sync.Mutex // held while the pipeline runs; protects st and transforms
+	st     state
+	closed bool       // Transforms were released by Stop
+	mu     sync.Mutex // protects status
+	status Status
+}
+
+// Manager runs the pipelines of the configuration
+type Manager struct {
+	fs        filesystem.FileSystem
+	pipelines []*pipeline
+	client    *http.Client
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// pipelinesFile is the layout of the file of PipelinesConfig.File
+type pipelinesFile struct {
+	Pipelines []config.PipelineConfig `yaml:"pipelines"`
+}
+
+// NewManager validates the inline definitions and those of the pipelines file, compiles
+// their transforms and loads their progress
+// Sources and sinks are accessed through fs, so they may live on any mount
+func NewManager(fs filesystem.FileSystem, cfg config.PipelinesConfig) (*Manager, error) {
+	m := &Manager{fs: fs, client: &http.Client{Timeout: DefaultWebhookTimeout}, done: make(chan struct{})}
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+	defs := cfg.Definitions
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pipelines file: %w", err)
+		}
+		var file pipelinesFile
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse pipelines file %s: %w", cfg.File, err)
+		}
+		defs = append(defs[:len(defs):len(defs)], file.Pipelines...)
+	}
+
+	names := make(map[string]bool)
+	for _, pcfg := range defs {
+		if !pcfg.Enabled {
+			continue
+		}
+		if names[pcfg.Name] {
+			m.close()
+			return nil, fmt.Errorf("duplicate pipeline name: %s", pcfg.Name)
+		}
+		p, err := newPipeline(pcfg, stateDir)
+		if err == nil {
+			err = p.load()
+		}
+		if err != nil {
+			if p != nil {
+				p.close()
+			}
+			m.close()
+			return nil, fmt.Errorf("pipeline %s: %w", pcfg.Name, err)
+		}
+		names[pcfg.Name] = true
+		m.pipelines = append(m.pipelines, p)
+	}
+	return m, nil
+}
+
+func newPipeline(cfg config.PipelineConfig, stateDir string) (*pipeline, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if strings.ContainsAny(cfg.Name, `/\`) {
+		return nil, fmt.Errorf("name must not contain slashes")
+	}
+	p := &pipeline{
+		cfg:       cfg,
+		interval:  DefaultInterval,
+		stateFile: filepath.Join(stateDir, cfg.Name+".json"),
+		st:        state{Files: make(map[string]*seen)},
+	}
+
+	src := &p.cfg.Source
+	var location string
+	switch {
+	case src.Watch != "" && src.Queue == "" && src.Schedule == "":
+		p.kind, src.Watch = SourceWatch, filesystem.NormalizePath(src.Watch)
+		location = src.Watch
+	case src.Queue != "" && src.Watch == "" && src.Schedule == "":
+		p.kind, src.Queue = SourceQueue, filesystem.NormalizePath(src.Queue)
+		location = src.Queue
+	case src.Schedule != "" && src.Watch == "" && src.Queue == "":
+		p.kind, location = SourceSchedule, src.Schedule
+	default:
+		return nil, fmt.Errorf("source: exactly one of watch, queue and schedule is required")
+	}
+	if src.Read != "" {
+		if p.kind != SourceSchedule {
+			return nil, fmt.Errorf("source: read requires schedule")
+		}
+		src.Read = filesystem.NormalizePath(src.Read)
+	}
+	if (src.Include != "" || src.Exclude != "") && p.kind != SourceWatch {
+		return nil, fmt.Errorf("source: include and exclude require watch")
+	}
+	if p.kind == SourceSchedule {
+		if src.Interval != "" {
+			return nil, fmt.Errorf("source: interval does not apply to schedule, which is the interval")
+		}
+		src.Interval = src.Schedule
+	}
+	if src.Interval != "" {
+		d, err := pluginconfig.ParseDuration(src.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("source: invalid interval: %w", err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("source: interval must be positive")
+		}
+		p.interval = d
+	}
+	if src.Batch < 0 {
+		return nil, fmt.Errorf("source: batch must not be negative")
+	}
+	if src.Batch == 0 {
+		src.Batch = DefaultBatch
+	}
+	filter, err := archive.NewFilter(src.Include, src.Exclude)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	p.filter = filter
+
+	if len(cfg.Sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink is required")
+	}
+	p.cfg.Sinks = make([]config.PipelineSink, len(cfg.Sinks))
+	sinks := make([]string, len(cfg.Sinks))
+	for i, sink := range cfg.Sinks {
+		switch {
+		case sink.Path != "" && sink.Queue == "" && sink.Webhook == "":
+			sink.Path = filesystem.NormalizePath(sink.Path)
+			if p.kind == SourceWatch && within(sink.Path, src.Watch) {
+				return nil, fmt.Errorf("sink %d: path must not be below the watched directory", i)
+			}
+			sinks[i] = "path " + sink.Path
+		case sink.Queue != "" && sink.Path == "" && sink.Webhook == "":
+			sink.Queue = filesystem.NormalizePath(sink.Queue)
+			if p.kind == SourceQueue && sink.Queue == src.Queue {
+				return nil, fmt.Errorf("sink %d: queue must differ from the source queue", i)
+			}
+			sinks[i] = "queue " + sink.Queue
+		case sink.Webhook != "" && sink.Path == "" && sink.Queue == "":
+			if !strings.HasPrefix(sink.Webhook, "http://") && !strings.HasPrefix(sink.Webhook, "https://") {
+				return nil, fmt.Errorf("sink %d: webhook must be an http or https URL", i)
+			}
+			sinks[i] = "webhook " + sink.Webhook
+		default:
+			return nil, fmt.Errorf("sink %d: exactly one of path, queue and webhook is required", i)
+		}
+		if len(sink.Headers) > 0 && sink.Webhook == "" {
+			return nil, fmt.Errorf("sink %d: headers require webhook", i)
+		}
+		p.cfg.Sinks[i] = sink
+	}
+
+	for i, tcfg := range cfg.Transforms {
+		t, err := newTransform(tcfg, i)
+		if err != nil {
+			p.close()
+			return nil, err
+		}
+		p.transforms = append(p.transforms, t)
+	}
+
+	p.status = Status{
+		Name:       cfg.Name,
+		Source:     p.kind + " " + location,
+		Transforms: len(p.transforms),
+		Sinks:      sinks,
+		Interval:   p.interval.String(),
+		State:      StateIdle,
+	}
+	return p, nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// load reads the progress of the pipeline, if it ran before
+func (p *pipeline) load() error {
+	data, err := os.ReadFile(p.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &p.st); err != nil {
+		return fmt.Errorf("invalid state %s: %w", p.stateFile, err)
+	}
+	if p.st.Files == nil {
+		p.st.Files = make(map[string]*seen)
+	}
+	p.status.Stopped = p.st.Stopped
+	p.status.Records, p.status.Dropped, p.status.Failed = p.st.Records, p.st.Dropped, p.st.Failed
+	return nil
+}
+
+// save writes the progress of the pipeline; the caller must hold p.runMu
+func (p *pipeline) save() error {
+	data, err := json.MarshalIndent(p.st, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(p.stateFile), 0755)
+	}
+	if err == nil {
+		if err = os.WriteFile(p.stateFile+".tmp", data, 0644); err == nil {
+			err = os.Rename(p.stateFile+".tmp", p.stateFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// close releases the transforms; the caller must hold p.runMu or own p
+func (p *pipeline) close() {
+	for _, t := range p.transforms {
+		t.close()
+	}
+	p.transforms = nil
+	p.closed = true
+}
+
+func (m *Manager) close() {
+	for _, p := range m.pipelines {
+		p.close()
+	}
+}
+
+// Names returns the names of the pipelines
+func (m *Manager) Names() []string {
+	names := make([]string, len(m.pipelines))
+	for i, p := range m.pipelines {
+		names[i] = p.cfg.Name
+	}
+	return names
+}
+
+// Start launches the loop of every pipeline; stopped pipelines wait for a start command
+func (m *Manager) Start() {
+	for _, p := range m.pipelines {
+		m.wg.Add(1)
+		go m.loop(p)
+	}
+	if len(m.pipelines) > 0 {
+		log.Infof("[pipeline] started %d pipeline(s)", len(m.pipelines))
+	}
+}
+
+// Stop stops the loops, waits for them to exit and releases the transforms
+// A run that is already in progress is allowed to finish
+func (m *Manager) Stop() {
+	close(m.done)
+	m.wg.Wait()
+	for _, p := range m.pipelines {
+		p.runMu.Lock()
+		p.close()
+		p.runMu.Unlock()
+	}
+}
+
+func (m *Manager) loop(p *pipeline) {
+	defer m.wg.Done()
+	for {
+		p.mu.Lock()
+		if p.status.Stopped {
+			p.status.NextRun = nil
+		} else {
+			next := time.Now().Add(p.interval)
+			p.status.NextRun = &next
+		}
+		p.mu.Unlock()
+
+		timer := time.NewTimer(p.interval)
+		select {
+		case <-m.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		p.mu.Lock()
+		stopped := p.status.Stopped
+		p.mu.Unlock()
+		if stopped {
+			continue
+		}
+		if _, err := m.run(p); err != nil && !errors.Is(err, ErrRunning) {
+			log.Errorf("[pipeline] %s failed: %v", p.cfg.Name, err)
+		}
+	}
+}
+
+// find returns the pipeline called name
+func (m *Manager) find(name string) (*pipeline, error) {
+	for _, p := range m.pipelines {
+		if p.cfg.Name == name {
+			return p, nil
+		}
+	}
+	return nil, filesystem.NewNotFoundError("pipeline", name)
+}
+
+// Run runs a pipeline immediately, stopped or not, and waits for the run to finish; an
+// empty name runs every pipeline
+func (m *Manager) Run(name string) ([]Status, error) {
+	if name != "" {
+		p, err := m.find(name)
+		if err != nil {
+			return nil, err
+		}
+		status, err := m.run(p)
+		return []Status{status}, err
+	}
+	results := make([]Status, 0, len(m.pipelines))
+	for _, p := range m.pipelines {
+		status, _ := m.run(p)
+		results = append(results, status)
+	}
+	return results, nil
+}
+
+// Status returns the status of every pipeline
+func (m *Manager) Status() []Status {
+	statuses := make([]Status, 0, len(m.pipelines))
+	for _, p := range m.pipelines {
+		p.mu.Lock()
+		statuses = append(statuses, p.status)
+		p.mu.Unlock()
+	}
+	return statuses
+}
+
+// PipelineStatus returns the status of the pipeline called name
+func (m *Manager) PipelineStatus(name string) (Status, error) {
+	p, err := m.find(name)
+	if err != nil {
+		return Status{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status, nil
+}
+
+// ControlState returns "stopped" or "started", the state set by the last control command
+func (m *Manager) ControlState(name string) (string, error) {
+	status, err := m.PipelineStatus(name)
+	if err != nil {
+		return "", err
+	}
+	if status.Stopped {
+		return "stopped", nil
+	}
+	return "started", nil
+}
+
+// Control starts or stops the scheduled runs of a pipeline, which is remembered across
+// restarts, or runs it once
+func (m *Manager) Control(name, command string) error {
+	p, err := m.find(name)
+	if err != nil {
+		return err
+	}
+	switch command {
+	case CommandStart, CommandStop:
+	case CommandRun:
+		_, err := m.run(p)
+		return err
+	default:
+		return fmt.Errorf("unknown pipeline command %q: expected %q, %q or %q", command, CommandStart, CommandStop, CommandRun)
+	}
+
+	stopped := command == CommandStop
+	// Wait for a run in progress, which saves the state too
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+	p.st.Stopped = stopped
+	if err := p.save(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.status.Stopped = stopped
+	if stopped {
+		p.status.NextRun = nil
+	}
+	p.mu.Unlock()
+	if stopped {
+		log.Infof("[pipeline] %s: stopped", p.cfg.Name)
+	} else {
+		log.Infof("[pipeline] %s: started", p.cfg.Name)
+	}
+	return nil
+}
+
+// run takes a batch of records from the source and passes each through the pipeline
+func (m *Manager) run(p *pipeline) (Status, error) {
+	if !p.runMu.TryLock() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.status, ErrRunning
+	}
+	defer p.runMu.Unlock()
+	if p.closed {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.status, fmt.Errorf("pipeline %s is shut down", p.cfg.Name)
+	}
+
+	start := time.Now()
+	p.mu.Lock()
+	p.status.State = StateRunning
+	p.status.LastRun = &start
+	p.mu.Unlock()
+
+	var processed int
+	var err error
+	switch p.kind {
+	case SourceWatch:
+		processed, err = m.runWatch(p, start)
+	case SourceQueue:
+		processed, err = m.runQueue(p, start)
+	default:
+		processed, err = m.runSchedule(p, start)
+	}
+	if serr := p.save(); serr != nil && err == nil {
+		err = serr
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Duration = time.Since(start).Round(time.Millisecond).String()
+	p.status.Processed = processed
+	p.status.Records, p.status.Dropped, p.status.Failed = p.st.Records, p.st.Dropped, p.st.Failed
+	if err != nil {
+		p.status.State = StateFailed
+		p.status.Error = err.Error()
+		return p.status, err
+	}
+	p.status.State = StateOK
+	p.status.Error = ""
+	if processed > 0 {
+		log.Infof("[pipeline] %s: processed %d record(s)", p.cfg.Name, processed)
+	}
+	return p.status, nil
+}
+
+// runWatch processes the files of the watched directory that are new or were modified
+// since they went through the pipeline; a file that fails is retried by the next run
+func (m *Manager) runWatch(p *pipeline, now time.Time) (int, error) {
+	dir := p.cfg.Source.Watch
+	var files []string
+	present := make(map[string]bool)
+	err := filesystem.Walk(m.fs, dir, func(fp string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(fp, dir), "/")
+		if p.filter.Excluded(rel) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir || rel == "" || !p.filter.Included(rel) {
+			return nil
+		}
+		present[fp] = true
+		if s, ok := p.st.Files[fp]; ok && s.Size == info.Size && s.ModTime.Equal(info.ModTime) {
+			return nil
+		}
+		if len(files) < p.cfg.Source.Batch {
+			files = append(files, fp)
+		}
+		return nil
+	})
+	if err != nil && filesystem.IsNotFound(err) {
+		// Nothing to do until the directory exists
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	for fp := range p.st.Files {
+		if !present[fp] {
+			delete(p.st.Files, fp)
+		}
+	}
+
+	var firstErr error
+	for _, fp := range files {
+		info, err := m.fs.Stat(fp)
+		var data []byte
+		if err == nil {
+			data, err = m.fs.Read(fp, 0, -1)
+			if err == io.EOF {
+				err = nil
+			}
+		}
+		if err != nil {
+			if filesystem.IsNotFound(err) {
+				// Removed since the walk
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("read %s: %w", fp, err)
+			}
+			p.st.Failed++
+			continue
+		}
+		rec := &Record{
+			ID:       uuid.NewString(),
+			Pipeline: p.cfg.Name,
+			Source:   SourceWatch,
+			Path:     fp,
+			Name:     strings.TrimPrefix(strings.TrimPrefix(fp, dir), "/"),
+			Size:     int64(len(data)),
+			Time:     now,
+			Data:     string(data),
+		}
+		if err := m.process(p, rec); err != nil {
+			log.Warnf("[pipeline] %s: %s: %v", p.cfg.Name, fp, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", fp, err)
+			}
+			continue
+		}
+		p.st.Files[fp] = &seen{Size: info.Size, ModTime: info.ModTime}
+	}
+	return len(files), firstErr
+}
+
+// runQueue processes the messages of the queue in order, removing each once it went
+// through the pipeline; a message that fails stops the run and is retried by the next one
+func (m *Manager) runQueue(p *pipeline, now time.Time) (int, error) {
+	dir := p.cfg.Source.Queue + "/messages"
+	infos, _, err := filesystem.ReadDirPage(m.fs, dir, filesystem.ListPage{Limit: p.cfg.Source.Batch})
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	processed := 0
+	for _, info := range infos {
+		data, err := m.fs.Read(dir+"/"+info.Name, 0, -1)
+		if err != nil && len(data) == 0 {
+			if filesystem.IsNotFound(err) {
+				continue
+			}
+			return processed, err
+		}
+		var msg struct {
+			ID   string `json:"id"`
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return processed, fmt.Errorf("read message %s: %w", info.Name, err)
+		}
+		if msg.ID == "" {
+			msg.ID = info.Name
+		}
+		rec := &Record{
+			ID:       msg.ID,
+			Pipeline: p.cfg.Name,
+			Source:   SourceQueue,
+			Name:     msg.ID,
+			Size:     int64(len(msg.Data)),
+			Time:     now,
+			Data:     msg.Data,
+		}
+		processed++
+		if err := m.process(p, rec); err != nil {
+			return processed, fmt.Errorf("message %s: %w", msg.ID, err)
+		}
+		if err := m.fs.Remove(dir + "/" + info.Name); err != nil && !filesystem.IsNotFound(err) {
+			return processed, fmt.Errorf("remove message %s: %w", msg.ID, err)
+		}
+	}
+	return processed, nil
+}
+
+// runSchedule processes one record, with the content of the read file if there is one
+func (m *Manager) runSchedule(p *pipeline, now time.Time) (int, error) {
+	rec := &Record{
+		ID:       uuid.NewString(),
+		Pipeline: p.cfg.Name,
+		Source:   SourceSchedule,
+		Name:     now.UTC().Format("20060102-150405"),
+		Time:     now,
+	}
+	if src := p.cfg.Source.Read; src != "" {
+		data, err := m.fs.Read(src, 0, -1)
+		if err != nil && err != io.EOF {
+			return 0, fmt.Errorf("read %s: %w", src, err)
+		}
+		rec.Path, rec.Size, rec.Data = src, int64(len(data)), string(data)
+	}
+	return 1, m.process(p, rec)
+}
+
+// process passes a record through the transforms and writes what they return to every sink
+// A record dropped by a transform is not an error; the counters are updated either way
+func (m *Manager) process(p *pipeline, rec *Record) error {
+	for i, t := range p.transforms {
+		keep, err := t.apply(rec)
+		if err != nil {
+			p.st.Failed++
+			return fmt.Errorf("transform %d: %w", i, err)
+		}
+		if !keep {
+			p.st.Dropped++
+			return nil
+		}
+	}
+	for i, sink := range p.cfg.Sinks {
+		if err := m.write(sink, rec); err != nil {
+			p.st.Failed++
+			return fmt.Errorf("sink %d: %w", i, err)
+		}
+	}
+	p.st.Records++
+	return nil
+}
+
+// write delivers a record to a sink
+func (m *Manager) write(sink config.PipelineSink, rec *Record) error {
+	switch {
+	case sink.Path != "":
+		target := path.Join(sink.Path, rec.Name)
+		if err := filesystem.MkdirAll(m.fs, path.Dir(target), 0755); err != nil {
+			return err
+		}
+		_, err := m.fs.Write(target, []byte(rec.Data))
+		return err
+	case sink.Queue != "":
+		if err := filesystem.MkdirAll(m.fs, sink.Queue, 0755); err != nil {
+			return err
+		}
+		_, err := m.fs.Write(sink.Queue+"/enqueue", []byte(rec.Data))
+		return err
+	default:
+		return m.post(sink, rec)
+	}
+}
+
+// post sends the data of a record to a webhook sink
+// The request carries the pipeline, record ID and source path in X-AGFS-* headers
+func (m *Manager) post(sink config.PipelineSink, rec *Record) error {
+	req, err := http.NewRequest(http.MethodPost, sink.Webhook, strings.NewReader(rec.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-AGFS-Pipeline", rec.Pipeline)
+	req.Header.Set("X-AGFS-Record", rec.ID)
+	if rec.Path != "" {
+		req.Header.Set("X-AGFS-Path", rec.Path)
+	}
+	for k, v := range sink.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook failed: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package pipeline
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+)
+
+func TestPipelines(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() })
+	for _, m := range []struct{ plugin, path string }{{"memfs", "/memfs"}, {"queuefs", "/queuefs"}} {
+		if err := mfs.MountPlugin(m.plugin, m.path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(p string) string {
+		t.Helper()
+		data, err := mfs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("read %s: %v", p, err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+	write := func(p, data string) {
+		t.Helper()
+		if err := filesystem.MkdirAll(mfs, p[:strings.LastIndex(p, "/")], 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(p, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var posted []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		posted = append(posted, r.Header.Get("X-AGFS-Pipeline")+":"+r.Header.Get("Authorization")+":"+string(body))
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	stateDir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "pipelines.yaml")
+	if err := os.WriteFile(file, []byte(`
+pipelines:
+  - name: upper
+    enabled: true
+    source:
+      queue: /queuefs/in
+    transforms:
+      - script: |
+          function transform(record)
+            if record.data == "skip" then return nil end
+            return string.upper(record.data)
+          end
+    sinks:
+      - queue: /queuefs/out
+      - webhook: `+hook.URL+`
+        headers:
+          Authorization: Bearer secret
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.PipelinesConfig{StateDir: stateDir, File: file, Definitions: []config.PipelineConfig{{
+		Name:       "reports",
+		Enabled:    true,
+		Source:     config.PipelineSource{Watch: "/memfs/in", Exclude: "*.tmp"},
+		Transforms: []config.PipelineTransform{{Template: `{{.Name}}={{trim .Data}}`}},
+		Sinks:      []config.PipelineSink{{Path: "/memfs/out"}},
+	}}}
+
+	for _, bad := range []config.PipelineConfig{
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Queue: "/q", Watch: "/w"}, Sinks: []config.PipelineSink{{Path: "/o"}}},
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Queue: "/q"}},
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Watch: "/w"}, Sinks: []config.PipelineSink{{Path: "/w/out"}}},
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Queue: "/q"}, Sinks: []config.PipelineSink{{Webhook: "ftp://host"}}},
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Schedule: "1h"}, Sinks: []config.PipelineSink{{Path: "/o"}},
+			Transforms: []config.PipelineTransform{{Script: "x = 1"}}},
+		{Name: "x", Enabled: true, Source: config.PipelineSource{Schedule: "1h"}, Sinks: []config.PipelineSink{{Path: "/o"}},
+			Transforms: []config.PipelineTransform{{Template: "{{.Nope"}}},
+	} {
+		if _, err := NewManager(mfs, config.PipelinesConfig{StateDir: stateDir, Definitions: []config.PipelineConfig{bad}}); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	m, err := NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(m.Names(), ","); names != "reports,upper" {
+		t.Fatalf("names = %s", names)
+	}
+
+	// Watched files are processed once, until they change
+	write("/memfs/in/a.txt", "alpha\n")
+	write("/memfs/in/sub/b.txt", "beta")
+	write("/memfs/in/c.tmp", "partial")
+	if statuses, err := m.Run("reports"); err != nil || statuses[0].Processed != 2 || statuses[0].Records != 2 {
+		t.Fatalf("watch run = %+v, %v", statuses, err)
+	}
+	if got := read("/memfs/out/a.txt") + " " + read("/memfs/out/sub/b.txt"); got != "a.txt=alpha sub/b.txt=beta" {
+		t.Errorf("path sink = %q", got)
+	}
+	if statuses, err := m.Run("reports"); err != nil || statuses[0].Processed != 0 {
+		t.Fatalf("rescan = %+v, %v", statuses, err)
+	}
+
+	// Queue messages are transformed, dropped by returning nil, and removed once delivered
+	if err := mfs.Mkdir("/queuefs/in", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "skip", "two"} {
+		if _, err := mfs.Write("/queuefs/in/enqueue", []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status, err := m.PipelineStatus("upper")
+	if err != nil || status.Source != "queue /queuefs/in" || len(status.Sinks) != 2 {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+	if statuses, err := m.Run("upper"); err != nil || statuses[0].Processed != 3 || statuses[0].Records != 2 || statuses[0].Dropped != 1 {
+		t.Fatalf("queue run = %+v, %v", statuses, err)
+	}
+	if size := read("/queuefs/in/size"); size != "0" {
+		t.Errorf("source queue size = %s", size)
+	}
+	if size := read("/queuefs/out/size"); size != "2" {
+		t.Errorf("sink queue size = %s", size)
+	}
+	mu.Lock()
+	if got := strings.Join(posted, ","); got != "upper:Bearer secret:ONE,upper:Bearer secret:TWO" {
+		t.Errorf("webhook requests = %s", got)
+	}
+	mu.Unlock()
+
+	// A failing sink leaves the message in the queue
+	hook.Close()
+	if _, err := mfs.Write("/queuefs/in/enqueue", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if statuses, err := m.Run("upper"); err == nil || statuses[0].State != StateFailed || statuses[0].Failed != 1 {
+		t.Fatalf("failing run = %+v, %v", statuses, err)
+	}
+	if size := read("/queuefs/in/size"); size != "1" {
+		t.Errorf("source queue size after failure = %s", size)
+	}
+
+	// Stopping is remembered across restarts
+	if err := m.Control("reports", CommandStop); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Control("reports", "pause"); err == nil {
+		t.Error("unknown command: no error")
+	}
+	m.Stop()
+	m, err = NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if state, err := m.ControlState("reports"); err != nil || state != "stopped" {
+		t.Errorf("control state = %s, %v", state, err)
+	}
+	if status, _ := m.PipelineStatus("reports"); status.Records != 2 {
+		t.Errorf("records after restart = %d", status.Records)
+	}
+	if err := m.Control("reports", CommandStart); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := m.ControlState("reports"); state != "started" {
+		t.Errorf("control state after start = %s", state)
+	}
+	if _, err := m.Run("nope"); !filesystem.IsNotFound(err) {
+		t.Errorf("unknown pipeline: %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// transform rewrites the data of a record; it returns false to drop the record
+type transform interface {
+	apply(r *Record) (bool, error)
+	close()
+}
+
+// templateFuncs are the functions templates can use besides the builtins
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// newTransform compiles the transform at index i of a pipeline
+func newTransform(cfg config.PipelineTransform, i int) (transform, error) {
+	set := 0
+	for _, s := range []string{cfg.Template, cfg.Script, cfg.WASM} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("transform %d: exactly one of template, script and wasm is required", i)
+	}
+	timeout := DefaultTimeout
+	if cfg.Timeout != "" {
+		d, err := pluginconfig.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: invalid timeout: %w", i, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("transform %d: timeout must be positive", i)
+		}
+		timeout = d
+	}
+
+	switch {
+	case cfg.Template != "":
+		tmpl, err := template.New(fmt.Sprintf("transform%d", i)).Funcs(templateFuncs).Option("missingkey=error").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: invalid template: %w", i, err)
+		}
+		return &templateTransform{tmpl: tmpl}, nil
+	case cfg.Script != "":
+		t, err := newScriptTransform(cfg.Script, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i, err)
+		}
+		return t, nil
+	default:
+		t, err := newWASMTransform(cfg.WASM, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i, err)
+		}
+		return t, nil
+	}
+}
+
+// templateTransform replaces the data with the output of a template executed with the record
+type templateTransform struct {
+	tmpl *template.Template
+}
+
+func (t *templateTransform) apply(r *Record) (bool, error) {
+	var b bytes.Buffer
+	if err := t.tmpl.Execute(&b, r); err != nil {
+		return false, err
+	}
+	r.Data = b.String()
+	return true, nil
+}
+
+func (t *templateTransform) close() {}
+
+// luaLibs are the only standard libraries scripts can use, as in scriptfs
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// scriptTransform calls the transform function of a Lua script with the record as a table;
+// the function returns the new data, or nil to drop the record
+type scriptTransform struct {
+	state   *lua.LState
+	timeout time.Duration
+}
+
+func newScriptTransform(source string, timeout time.Duration) (*scriptTransform, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range luaLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "print"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	t := &scriptTransform{state: L, timeout: timeout}
+	fn, err := L.LoadString(source)
+	if err == nil {
+		_, err = t.call(fn)
+	}
+	if err == nil && L.GetGlobal("transform").Type() != lua.LTFunction {
+		err = fmt.Errorf("script does not define a transform function")
+	}
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *scriptTransform) apply(r *Record) (bool, error) {
+	L := t.state
+	record := L.NewTable()
+	for k, v := range map[string]string{"id": r.ID, "pipeline": r.Pipeline, "source": r.Source, "path": r.Path, "name": r.Name, "data": r.Data} {
+		record.RawSetString(k, lua.LString(v))
+	}
+	record.RawSetString("size", lua.LNumber(r.Size))
+	record.RawSetString("time", lua.LNumber(float64(r.Time.UnixNano())/1e9))
+
+	ret, err := t.call(L.GetGlobal("transform"), record)
+	if err != nil {
+		return false, err
+	}
+	switch v := ret.(type) {
+	case *lua.LNilType:
+		return false, nil
+	case lua.LString:
+		r.Data = string(v)
+	case lua.LNumber:
+		r.Data = v.String()
+	default:
+		return false, fmt.Errorf("script transform returned a %s, expected a string or nil", ret.Type())
+	}
+	return true, nil
+}
+
+// call runs fn under the timeout of the script and returns its first result
+func (t *scriptTransform) call(fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	L := t.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, filesystem.NewTimeoutError("transform", "script", t.timeout)
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("script failed: %s", apiErr.Object.String())
+		}
+		return nil, fmt.Errorf("script failed: %w", err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret, nil
+}
+
+func (t *scriptTransform) close() {
+	t.state.Close()
+}
+
+// wasmTransform runs a WASI command module per record, with the data on stdin; what the
+// module writes to stdout is the new data, and a non-zero exit code fails the record
+type wasmTransform struct {
+	path    string
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+	timeout time.Duration
+}
+
+func newWASMTransform(path string, timeout time.Duration) (*wasmTransform, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	module, err := r.CompileModule(ctx, code)
+	if err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("failed to compile %s: %w", path, err)
+	}
+	return &wasmTransform{path: path, runtime: r, module: module, timeout: timeout}, nil
+}
+
+func (t *wasmTransform) apply(r *Record) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	// Instances get no name, so that one can be created per record, and no file system
+	cfg := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(r.Pipeline).
+		WithStdin(strings.NewReader(r.Data)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+	mod, err := t.runtime.InstantiateModule(ctx, t.module, cfg)
+	if mod != nil {
+		mod.Close(ctx)
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return false, filesystem.NewTimeoutError("transform", t.path, t.timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return false, fmt.Errorf("%s failed: %w: %s", t.path, err, msg)
+		}
+		return false, fmt.Errorf("%s failed: %w", t.path, err)
+	}
+	r.Data = stdout.String()
+	return true, nil
+}
+
+func (t *wasmTransform) close() {
+	t.runtime.Close(context.Background())
+}
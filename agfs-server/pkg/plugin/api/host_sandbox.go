@@ -0,0 +1,309 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Configuration keys restricting the host filesystem of a WASM plugin instance
+// They are consumed by WASMPlugin and not passed on to the module
+const (
+	ConfigHostRoot  = "host_root"  // Host directory the plugin sees as "/"
+	ConfigHostPaths = "host_paths" // Paths the plugin may use, e.g. ["/in:ro", "/out:rw"]
+)
+
+// HostAccess is the kind of access a WASM plugin has to a host path
+type HostAccess int
+
+const (
+	HostReadOnly  HostAccess = iota // Read, Stat and ReadDir
+	HostReadWrite                   // Every operation
+)
+
+func (a HostAccess) String() string {
+	if a == HostReadWrite {
+		return "rw"
+	}
+	return "ro"
+}
+
+// HostPath grants access to a host path and everything below it
+type HostPath struct {
+	Path   string
+	Access HostAccess
+}
+
+// HostSandboxConfig restricts the host filesystem seen by a WASM plugin instance
+type HostSandboxConfig struct {
+	Root  string     // Host directory the plugin sees as "/"
+	Paths []HostPath // Paths the plugin may use, relative to Root; nil allows everything
+}
+
+// ParseHostSandboxConfig reads host_root and host_paths from a plugin configuration
+// host_paths entries are paths with an optional ":ro" (the default) or ":rw" suffix, given
+// as an array or a comma-separated string
+func ParseHostSandboxConfig(cfg map[string]interface{}) (HostSandboxConfig, error) {
+	sc := HostSandboxConfig{Root: "/"}
+	switch v := cfg[ConfigHostRoot].(type) {
+	case nil:
+	case string:
+		if !strings.HasPrefix(v, "/") {
+			return sc, fmt.Errorf("%s must be an absolute path", ConfigHostRoot)
+		}
+		sc.Root = filesystem.NormalizePath(v)
+	default:
+		return sc, fmt.Errorf("%s must be a string", ConfigHostRoot)
+	}
+
+	var entries []string
+	switch v := cfg[ConfigHostPaths].(type) {
+	case nil:
+		return sc, nil
+	case string:
+		for _, entry := range strings.Split(v, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			entry, ok := item.(string)
+			if !ok {
+				return sc, fmt.Errorf("%s must be a list of paths", ConfigHostPaths)
+			}
+			entries = append(entries, entry)
+		}
+	case []string:
+		entries = v
+	default:
+		return sc, fmt.Errorf("%s must be a list of paths", ConfigHostPaths)
+	}
+
+	sc.Paths = []HostPath{}
+	for _, entry := range entries {
+		p, access := entry, HostReadOnly
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			switch entry[i+1:] {
+			case "ro":
+			case "rw":
+				access = HostReadWrite
+			default:
+				return sc, fmt.Errorf("invalid access in %s entry %q: use ro or rw", ConfigHostPaths, entry)
+			}
+			p = entry[:i]
+		}
+		if !strings.HasPrefix(p, "/") {
+			return sc, fmt.Errorf("%s entry %q must be an absolute path", ConfigHostPaths, entry)
+		}
+		sc.Paths = append(sc.Paths, HostPath{Path: filesystem.NormalizePath(p), Access: access})
+	}
+	return sc, nil
+}
+
+// withoutHostSandboxConfig returns cfg without the keys read by ParseHostSandboxConfig
+func withoutHostSandboxConfig(cfg map[string]interface{}) map[string]interface{} {
+	if _, ok := cfg[ConfigHostRoot]; !ok {
+		if _, ok := cfg[ConfigHostPaths]; !ok {
+			return cfg
+		}
+	}
+	out := make(map[string]interface{}, len(cfg))
+	for k, v := range cfg {
+		if k != ConfigHostRoot && k != ConfigHostPaths {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// HostSandbox is the host filesystem handed to the host functions of a WASM plugin
+// Paths are resolved below the configured root and checked against the allowed paths, so a
+// plugin cannot reach anything else however it spells its paths
+type HostSandbox struct {
+	fs  filesystem.FileSystem
+	mu  sync.RWMutex
+	cfg HostSandboxConfig
+}
+
+// NewHostSandbox wraps fs, allowing every operation until Configure is called
+func NewHostSandbox(fs filesystem.FileSystem) *HostSandbox {
+	return &HostSandbox{fs: fs, cfg: HostSandboxConfig{Root: "/"}}
+}
+
+// Configure replaces the restrictions of the sandbox
+func (s *HostSandbox) Configure(cfg HostSandboxConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// rule returns the most specific allowed path covering p
+func (s *HostSandbox) rule(p string) (HostPath, bool) {
+	if s.cfg.Paths == nil {
+		return HostPath{Path: "/", Access: HostReadWrite}, true
+	}
+	var best HostPath
+	found := false
+	for _, hp := range s.cfg.Paths {
+		if hp.Path == p || hp.Path == "/" || strings.HasPrefix(p, hp.Path+"/") {
+			if !found || len(hp.Path) > len(best.Path) {
+				best, found = hp, true
+			}
+		}
+	}
+	return best, found
+}
+
+// isAncestor reports whether p is a parent directory of an allowed path
+func (s *HostSandbox) isAncestor(p string) bool {
+	for _, hp := range s.cfg.Paths {
+		if p == "/" || strings.HasPrefix(hp.Path, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve checks an operation on a plugin path and returns the host path it refers to
+func (s *HostSandbox) resolve(op, p string, access HostAccess) (string, error) {
+	p = filesystem.NormalizePath(p)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hp, ok := s.rule(p)
+	if !ok {
+		// Parent directories of allowed paths can be inspected to find them
+		if access != HostReadOnly || (op != "stat" && op != "readdir") || !s.isAncestor(p) {
+			return "", filesystem.NewPermissionDeniedError(op, p, "outside the host paths of the plugin")
+		}
+	} else if access > hp.Access {
+		return "", filesystem.NewPermissionDeniedError(op, p, hp.Path+" is read-only for the plugin")
+	}
+	return path.Join(s.cfg.Root, p), nil
+}
+
+func (s *HostSandbox) Create(p string) error {
+	hostPath, err := s.resolve("create", p, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.Create(hostPath)
+}
+
+func (s *HostSandbox) Mkdir(p string, perm uint32) error {
+	hostPath, err := s.resolve("mkdir", p, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.Mkdir(hostPath, perm)
+}
+
+func (s *HostSandbox) Remove(p string) error {
+	hostPath, err := s.resolve("remove", p, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.Remove(hostPath)
+}
+
+func (s *HostSandbox) RemoveAll(p string) error {
+	hostPath, err := s.resolve("remove", p, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.RemoveAll(hostPath)
+}
+
+func (s *HostSandbox) Read(p string, offset int64, size int64) ([]byte, error) {
+	hostPath, err := s.resolve("read", p, HostReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.Read(hostPath, offset, size)
+}
+
+func (s *HostSandbox) Write(p string, data []byte) ([]byte, error) {
+	hostPath, err := s.resolve("write", p, HostReadWrite)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.Write(hostPath, data)
+}
+
+func (s *HostSandbox) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	hostPath, err := s.resolve("readdir", p, HostReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.fs.ReadDir(hostPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Below an ancestor of the allowed paths only the way to them is listed
+	dir := filesystem.NormalizePath(p)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.rule(dir); ok {
+		return entries, nil
+	}
+	visible := entries[:0]
+	for _, entry := range entries {
+		child := path.Join(dir, entry.Name)
+		if _, ok := s.rule(child); ok || s.isAncestor(child) {
+			visible = append(visible, entry)
+		}
+	}
+	return visible, nil
+}
+
+func (s *HostSandbox) Stat(p string) (*filesystem.FileInfo, error) {
+	hostPath, err := s.resolve("stat", p, HostReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.Stat(hostPath)
+}
+
+func (s *HostSandbox) Rename(oldPath, newPath string) error {
+	oldHostPath, err := s.resolve("rename", oldPath, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	newHostPath, err := s.resolve("rename", newPath, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.Rename(oldHostPath, newHostPath)
+}
+
+func (s *HostSandbox) Chmod(p string, mode uint32) error {
+	hostPath, err := s.resolve("chmod", p, HostReadWrite)
+	if err != nil {
+		return err
+	}
+	return s.fs.Chmod(hostPath, mode)
+}
+
+func (s *HostSandbox) Open(p string) (io.ReadCloser, error) {
+	hostPath, err := s.resolve("read", p, HostReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.Open(hostPath)
+}
+
+func (s *HostSandbox) OpenWrite(p string) (io.WriteCloser, error) {
+	hostPath, err := s.resolve("write", p, HostReadWrite)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.OpenWrite(hostPath)
+}
+
+var _ filesystem.FileSystem = (*HostSandbox)(nil)
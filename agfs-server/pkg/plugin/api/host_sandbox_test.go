@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestHostSandbox(t *testing.T) {
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	host := p.GetFileSystem()
+	for _, dir := range []string{"/agents/a/in", "/agents/a/out", "/agents/a/secret", "/agents/b"} {
+		if err := filesystem.MkdirAll(host, dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := host.Write("/agents/a/in/task", []byte("todo")); err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := NewHostSandbox(host)
+	cfg, err := ParseHostSandboxConfig(map[string]interface{}{
+		"host_root":  "/agents/a",
+		"host_paths": []interface{}{"/in", "/out:rw"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandbox.Configure(cfg)
+
+	if data, err := sandbox.Read("/in/task", 0, -1); (err != nil && err != io.EOF) || string(data) != "todo" {
+		t.Errorf("read = %q, %v", data, err)
+	}
+	if _, err := sandbox.Write("/out/result", []byte("done")); err != nil {
+		t.Errorf("write to rw path: %v", err)
+	}
+	if data, _ := host.Read("/agents/a/out/result", 0, -1); string(data) != "done" {
+		t.Errorf("host file = %q", data)
+	}
+
+	var denied *filesystem.PermissionDeniedError
+	for name, err := range map[string]error{
+		"write to ro path": func() error { _, err := sandbox.Write("/in/task", []byte("x")); return err }(),
+		"read outside":     func() error { _, err := sandbox.ReadDir("/secret"); return err }(),
+		"escape root":      func() error { _, err := sandbox.Stat("/../b"); return err }(),
+		"rename out of rw": sandbox.Rename("/out/result", "/in/result"),
+		"mkdir at root":    sandbox.Mkdir("/new", 0755),
+	} {
+		if !errors.As(err, &denied) {
+			t.Errorf("%s: got %v, want permission denied", name, err)
+		}
+	}
+
+	// The root lists only the way to the allowed paths
+	entries, err := sandbox.ReadDir("/")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("readdir / = %v, %v", entries, err)
+	}
+
+	// Without host_paths everything below the root is writable
+	cfg, err = ParseHostSandboxConfig(map[string]interface{}{"host_root": "/agents/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandbox.Configure(cfg)
+	if _, err := sandbox.Write("/notes", []byte("x")); err != nil {
+		t.Errorf("write below root: %v", err)
+	}
+	if _, err := host.Stat("/agents/b/notes"); err != nil {
+		t.Errorf("host stat: %v", err)
+	}
+
+	for _, bad := range []map[string]interface{}{
+		{"host_root": "relative"},
+		{"host_paths": []interface{}{"/in:rx"}},
+		{"host_paths": "in"},
+		{"host_paths": 3},
+	} {
+		if _, err := ParseHostSandboxConfig(bad); err == nil {
+			t.Errorf("ParseHostSandboxConfig(%v) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+	wazeroapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// DefaultSuspendFor is how long a plugin stays suspended when no duration is configured
+const DefaultSuspendFor = time.Minute
+
+// wasmPageSize is the size of a page of WASM linear memory
+const wasmPageSize = 64 << 10
+
+// WASMLimits bound the resources a WASM plugin instance may use
+type WASMLimits struct {
+	MaxMemory    int64         // Linear memory of the module in bytes, 0 leaves it at the runtime maximum
+	CallTimeout  time.Duration // Longest call into the module; a call running longer terminates the instance
+	MaxOpsPerSec int           // File operations per second, 0 is unlimited
+	SuspendAfter int           // Limit violations after which the plugin is suspended, 0 never suspends it
+	SuspendFor   time.Duration // How long a suspension lasts
+}
+
+// MemoryLimitPages returns MaxMemory in WASM pages, 0 if memory is not limited
+func (l WASMLimits) MemoryLimitPages() uint32 {
+	if l.MaxMemory <= 0 {
+		return 0
+	}
+	pages := (l.MaxMemory + wasmPageSize - 1) / wasmPageSize
+	if pages > 65536 {
+		pages = 65536
+	}
+	return uint32(pages)
+}
+
+// callGuard enforces the WASMLimits of a plugin instance on the calls into its module
+// Operations over the rate limit and calls failing in the runtime are violations; after
+// SuspendAfter of them in a row the plugin is suspended for SuspendFor. A call exceeding the
+// timeout is stopped by the runtime, which closes the module, so the plugin stays suspended
+// until it is reloaded
+type callGuard struct {
+	module wazeroapi.Module
+	now    func() time.Time
+
+	mu             sync.Mutex // protects the fields below
+	limits         WASMLimits
+	windowStart    time.Time
+	windowOps      int
+	violations     int
+	suspendedUntil time.Time
+	terminated     string // Why the module was closed, "" while it runs
+	reason         string // Why the plugin is suspended
+	stats          plugin.ResourceStats
+}
+
+func newCallGuard(module wazeroapi.Module) *callGuard {
+	return &callGuard{module: module, now: time.Now}
+}
+
+// setLimits replaces the limits of the guard
+func (g *callGuard) setLimits(limits WASMLimits) {
+	if limits.SuspendFor <= 0 {
+		limits.SuspendFor = DefaultSuspendFor
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.limits = limits
+}
+
+// admit checks whether an operation may call into the module now; only file operations are
+// refused while the plugin is suspended, and they count against the operation rate limit
+func (g *callGuard) admit(op, path string, fileOp bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.terminated != "" {
+		return filesystem.NewUnavailableError(op, path, g.terminated+", reload the plugin to enable it again")
+	}
+	now := g.now()
+	if fileOp && now.Before(g.suspendedUntil) {
+		return filesystem.NewUnavailableError(op, path, fmt.Sprintf("plugin suspended until %s: %s", g.suspendedUntil.Format(time.RFC3339), g.reason))
+	}
+	if fileOp && g.limits.MaxOpsPerSec > 0 {
+		if now.Sub(g.windowStart) >= time.Second {
+			g.windowStart, g.windowOps = now, 0
+		}
+		if g.windowOps >= g.limits.MaxOpsPerSec {
+			g.stats.RateLimited++
+			g.violation(now, fmt.Sprintf("more than %d operations per second", g.limits.MaxOpsPerSec))
+			return filesystem.NewUnavailableError(op, path, fmt.Sprintf("plugin exceeds %d operations per second", g.limits.MaxOpsPerSec))
+		}
+		g.windowOps++
+	}
+	g.stats.Calls++
+	return nil
+}
+
+// closed reports whether the runtime closed the module after a call timed out
+func (g *callGuard) closed() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.terminated != ""
+}
+
+// violation records a limit violation, suspending the plugin once it has too many
+func (g *callGuard) violation(now time.Time, reason string) {
+	g.violations++
+	if g.limits.SuspendAfter <= 0 || g.violations < g.limits.SuspendAfter {
+		return
+	}
+	g.violations = 0
+	g.suspendedUntil = now.Add(g.limits.SuspendFor)
+	g.reason = reason
+	log.Warnf("WASM plugin suspended until %s: %s", g.suspendedUntil.Format(time.RFC3339), reason)
+}
+
+// call runs a lifecycle function of the module under the limits
+func (g *callGuard) call(ctx context.Context, fn wazeroapi.Function, op string, params ...uint64) ([]uint64, error) {
+	if err := g.admit(op, "/", false); err != nil {
+		return nil, err
+	}
+	return g.run(ctx, fn, op, "/", params...)
+}
+
+// run calls fn of the module for op on path, stopping it after the call timeout; the
+// operation must have been admitted
+func (g *callGuard) run(ctx context.Context, fn wazeroapi.Function, op, path string, params ...uint64) ([]uint64, error) {
+	g.mu.Lock()
+	timeout := g.limits.CallTimeout
+	g.mu.Unlock()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	results, err := fn.Call(ctx, params...)
+	if err == nil {
+		g.mu.Lock()
+		g.violations = 0
+		g.mu.Unlock()
+		return results, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == sys.ExitCodeDeadlineExceeded {
+		g.stats.Timeouts++
+		g.terminated = fmt.Sprintf("plugin terminated after a call ran longer than %s", timeout)
+		log.Warnf("WASM plugin %s on %s: %s", op, path, g.terminated)
+		return nil, filesystem.NewTimeoutError(op, path, timeout)
+	}
+	g.stats.Failures++
+	g.violation(g.now(), fmt.Sprintf("%s failed in the runtime: %v", op, err))
+	return nil, err
+}
+
+// resourceStats reports the calls and the memory of the plugin
+func (g *callGuard) resourceStats() plugin.ResourceStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.MemoryLimit = int64(g.limits.MemoryLimitPages()) * wasmPageSize
+	if mem := g.module.Memory(); mem != nil {
+		stats.MemoryBytes = int64(mem.Size())
+	}
+	switch now := g.now(); {
+	case g.terminated != "":
+		stats.Suspended, stats.Reason = true, g.terminated
+	case now.Before(g.suspendedUntil):
+		until := g.suspendedUntil
+		stats.Suspended, stats.SuspendedUntil, stats.Reason = true, &until, g.reason
+	}
+	return stats
+}
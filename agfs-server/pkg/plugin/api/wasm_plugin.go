@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	log "github.com/sirupsen/logrus"
 	wazeroapi "github.com/tetratelabs/wazero/api"
 )
@@ -17,12 +18,15 @@ type WASMPlugin struct {
 	module     wazeroapi.Module
 	name       string
 	fileSystem *WASMFileSystem
+	hostFS     *HostSandbox // Host filesystem of the plugin, nil if it has none
+	guard      *callGuard
 }
 
 // WASMFileSystem implements filesystem.FileSystem by delegating to WASM functions
 type WASMFileSystem struct {
 	ctx    context.Context
 	module wazeroapi.Module
+	guard  *callGuard
 }
 
 // NewWASMPlugin creates a new WASM plugin wrapper
@@ -53,6 +57,7 @@ func NewWASMPlugin(ctx context.Context, module wazeroapi.Module) (*WASMPlugin, e
 		}
 	}
 
+	guard := newCallGuard(module)
 	wp := &WASMPlugin{
 		ctx:    ctx,
 		module: module,
@@ -60,12 +65,31 @@ func NewWASMPlugin(ctx context.Context, module wazeroapi.Module) (*WASMPlugin, e
 		fileSystem: &WASMFileSystem{
 			ctx:    ctx,
 			module: module,
+			guard:  guard,
 		},
+		guard: guard,
 	}
 
 	return wp, nil
 }
 
+// SetHostSandbox sets the host filesystem given to the plugin's host functions, which
+// Initialize restricts according to host_root and host_paths
+func (wp *WASMPlugin) SetHostSandbox(sandbox *HostSandbox) {
+	wp.hostFS = sandbox
+}
+
+// SetLimits sets the resource limits enforced on calls into the plugin; the memory limit
+// is applied by the runtime the module was instantiated in
+func (wp *WASMPlugin) SetLimits(limits WASMLimits) {
+	wp.guard.setLimits(limits)
+}
+
+// ResourceStats reports the calls into the plugin and the memory it holds
+func (wp *WASMPlugin) ResourceStats() plugin.ResourceStats {
+	return wp.guard.resourceStats()
+}
+
 // Name returns the plugin name
 func (wp *WASMPlugin) Name() string {
 	return wp.name
@@ -73,6 +97,11 @@ func (wp *WASMPlugin) Name() string {
 
 // Validate validates the plugin configuration
 func (wp *WASMPlugin) Validate(config map[string]interface{}) error {
+	if _, err := ParseHostSandboxConfig(config); err != nil {
+		return err
+	}
+	config = withoutHostSandboxConfig(config)
+
 	validateFunc := wp.module.ExportedFunction("plugin_validate")
 	if validateFunc == nil {
 		// If validate function is not exported, assume validation passes
@@ -92,7 +121,7 @@ func (wp *WASMPlugin) Validate(config map[string]interface{}) error {
 	}
 
 	// Call validate function
-	results, err := validateFunc.Call(wp.ctx, uint64(configPtr))
+	results, err := wp.guard.call(wp.ctx, validateFunc, "validate", uint64(configPtr))
 	if err != nil {
 		return fmt.Errorf("validate call failed: %w", err)
 	}
@@ -110,6 +139,15 @@ func (wp *WASMPlugin) Validate(config map[string]interface{}) error {
 
 // Initialize initializes the plugin with configuration
 func (wp *WASMPlugin) Initialize(config map[string]interface{}) error {
+	sandboxConfig, err := ParseHostSandboxConfig(config)
+	if err != nil {
+		return err
+	}
+	if wp.hostFS != nil {
+		wp.hostFS.Configure(sandboxConfig)
+	}
+	config = withoutHostSandboxConfig(config)
+
 	initFunc := wp.module.ExportedFunction("plugin_initialize")
 	if initFunc == nil {
 		// If initialize function is not exported, assume initialization succeeds
@@ -129,7 +167,7 @@ func (wp *WASMPlugin) Initialize(config map[string]interface{}) error {
 	}
 
 	// Call initialize function
-	results, err := initFunc.Call(wp.ctx, uint64(configPtr))
+	results, err := wp.guard.call(wp.ctx, initFunc, "initialize", uint64(configPtr))
 	if err != nil {
 		return fmt.Errorf("initialize call failed: %w", err)
 	}
@@ -157,7 +195,7 @@ func (wp *WASMPlugin) GetReadme() string {
 		return ""
 	}
 
-	results, err := readmeFunc.Call(wp.ctx)
+	results, err := wp.guard.call(wp.ctx, readmeFunc, "readme")
 	if err != nil {
 		log.Warnf("Failed to get readme: %v", err)
 		return ""
@@ -174,12 +212,16 @@ func (wp *WASMPlugin) GetReadme() string {
 
 // Shutdown shuts down the plugin
 func (wp *WASMPlugin) Shutdown() error {
+	if wp.guard.closed() {
+		// The runtime already closed the module
+		return nil
+	}
 	shutdownFunc := wp.module.ExportedFunction("plugin_shutdown")
 	if shutdownFunc == nil {
 		return nil
 	}
 
-	results, err := shutdownFunc.Call(wp.ctx)
+	results, err := wp.guard.call(wp.ctx, shutdownFunc, "shutdown")
 	if err != nil {
 		return fmt.Errorf("shutdown call failed: %w", err)
 	}
@@ -203,12 +245,16 @@ func (wfs *WASMFileSystem) Create(path string) error {
 		return fmt.Errorf("fs_create not implemented")
 	}
 
+	if err := wfs.guard.admit("create", path, true); err != nil {
+		return err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return err
 	}
 
-	results, err := createFunc.Call(wfs.ctx, uint64(pathPtr))
+	results, err := wfs.guard.run(wfs.ctx, createFunc, "create", path, uint64(pathPtr))
 	if err != nil {
 		return fmt.Errorf("fs_create failed: %w", err)
 	}
@@ -229,12 +275,16 @@ func (wfs *WASMFileSystem) Mkdir(path string, perm uint32) error {
 		return fmt.Errorf("fs_mkdir not implemented")
 	}
 
+	if err := wfs.guard.admit("mkdir", path, true); err != nil {
+		return err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return err
 	}
 
-	results, err := mkdirFunc.Call(wfs.ctx, uint64(pathPtr), uint64(perm))
+	results, err := wfs.guard.run(wfs.ctx, mkdirFunc, "mkdir", path, uint64(pathPtr), uint64(perm))
 	if err != nil {
 		return fmt.Errorf("fs_mkdir failed: %w", err)
 	}
@@ -255,12 +305,16 @@ func (wfs *WASMFileSystem) Remove(path string) error {
 		return fmt.Errorf("fs_remove not implemented")
 	}
 
+	if err := wfs.guard.admit("remove", path, true); err != nil {
+		return err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return err
 	}
 
-	results, err := removeFunc.Call(wfs.ctx, uint64(pathPtr))
+	results, err := wfs.guard.run(wfs.ctx, removeFunc, "remove", path, uint64(pathPtr))
 	if err != nil {
 		return fmt.Errorf("fs_remove failed: %w", err)
 	}
@@ -282,12 +336,16 @@ func (wfs *WASMFileSystem) RemoveAll(path string) error {
 		return wfs.Remove(path)
 	}
 
+	if err := wfs.guard.admit("remove", path, true); err != nil {
+		return err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return err
 	}
 
-	results, err := removeAllFunc.Call(wfs.ctx, uint64(pathPtr))
+	results, err := wfs.guard.run(wfs.ctx, removeAllFunc, "remove", path, uint64(pathPtr))
 	if err != nil {
 		return fmt.Errorf("fs_remove_all failed: %w", err)
 	}
@@ -308,12 +366,16 @@ func (wfs *WASMFileSystem) Read(path string, offset int64, size int64) ([]byte,
 		return nil, fmt.Errorf("fs_read not implemented")
 	}
 
+	if err := wfs.guard.admit("read", path, true); err != nil {
+		return nil, err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return nil, err
 	}
 
-	results, err := readFunc.Call(wfs.ctx, uint64(pathPtr), uint64(offset), uint64(size))
+	results, err := wfs.guard.run(wfs.ctx, readFunc, "read", path, uint64(pathPtr), uint64(offset), uint64(size))
 	if err != nil {
 		return nil, fmt.Errorf("fs_read failed: %w", err)
 	}
@@ -345,6 +407,10 @@ func (wfs *WASMFileSystem) Write(path string, data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("fs_write not implemented")
 	}
 
+	if err := wfs.guard.admit("write", path, true); err != nil {
+		return nil, err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return nil, err
@@ -355,7 +421,7 @@ func (wfs *WASMFileSystem) Write(path string, data []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	results, err := writeFunc.Call(wfs.ctx, uint64(pathPtr), uint64(dataPtr), uint64(len(data)))
+	results, err := wfs.guard.run(wfs.ctx, writeFunc, "write", path, uint64(pathPtr), uint64(dataPtr), uint64(len(data)))
 	if err != nil {
 		return nil, fmt.Errorf("fs_write failed: %w", err)
 	}
@@ -388,12 +454,16 @@ func (wfs *WASMFileSystem) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("fs_readdir not implemented")
 	}
 
+	if err := wfs.guard.admit("readdir", path, true); err != nil {
+		return nil, err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return nil, err
 	}
 
-	results, err := readDirFunc.Call(wfs.ctx, uint64(pathPtr))
+	results, err := wfs.guard.run(wfs.ctx, readDirFunc, "readdir", path, uint64(pathPtr))
 	if err != nil {
 		return nil, fmt.Errorf("fs_readdir failed: %w", err)
 	}
@@ -439,6 +509,10 @@ func (wfs *WASMFileSystem) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("fs_stat not implemented")
 	}
 
+	if err := wfs.guard.admit("stat", path, true); err != nil {
+		return nil, err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		log.Errorf("Failed to write path to memory: %v", err)
@@ -446,7 +520,7 @@ func (wfs *WASMFileSystem) Stat(path string) (*filesystem.FileInfo, error) {
 	}
 
 	log.Debugf("Calling fs_stat WASM function with pathPtr=%d", pathPtr)
-	results, err := statFunc.Call(wfs.ctx, uint64(pathPtr))
+	results, err := wfs.guard.run(wfs.ctx, statFunc, "stat", path, uint64(pathPtr))
 	if err != nil {
 		log.Errorf("fs_stat WASM call failed: %v", err)
 		return nil, fmt.Errorf("fs_stat failed: %w", err)
@@ -493,6 +567,10 @@ func (wfs *WASMFileSystem) Rename(oldPath, newPath string) error {
 		return fmt.Errorf("fs_rename not implemented")
 	}
 
+	if err := wfs.guard.admit("rename", oldPath, true); err != nil {
+		return err
+	}
+
 	oldPathPtr, err := writeStringToMemory(wfs.module, oldPath)
 	if err != nil {
 		return err
@@ -503,7 +581,7 @@ func (wfs *WASMFileSystem) Rename(oldPath, newPath string) error {
 		return err
 	}
 
-	results, err := renameFunc.Call(wfs.ctx, uint64(oldPathPtr), uint64(newPathPtr))
+	results, err := wfs.guard.run(wfs.ctx, renameFunc, "rename", oldPath, uint64(oldPathPtr), uint64(newPathPtr))
 	if err != nil {
 		return fmt.Errorf("fs_rename failed: %w", err)
 	}
@@ -525,12 +603,16 @@ func (wfs *WASMFileSystem) Chmod(path string, mode uint32) error {
 		return nil
 	}
 
+	if err := wfs.guard.admit("chmod", path, true); err != nil {
+		return err
+	}
+
 	pathPtr, err := writeStringToMemory(wfs.module, path)
 	if err != nil {
 		return err
 	}
 
-	results, err := chmodFunc.Call(wfs.ctx, uint64(pathPtr), uint64(mode))
+	results, err := wfs.guard.run(wfs.ctx, chmodFunc, "chmod", path, uint64(pathPtr), uint64(mode))
 	if err != nil {
 		return fmt.Errorf("fs_chmod failed: %w", err)
 	}
@@ -684,3 +766,4 @@ func writeBytesToMemory(module wazeroapi.Module, data []byte) (uint32, error) {
 	return ptr, nil
 }
 
+var _ plugin.ResourceReporter = (*WASMPlugin)(nil)
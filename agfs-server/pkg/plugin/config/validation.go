@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // GetStringConfig retrieves a string value from config with a default fallback
@@ -188,6 +189,45 @@ func GetSizeConfig(config map[string]interface{}, key string, defaultBytes int64
 	}
 }
 
+// ParseDuration parses a duration string (e.g., "30s", "5m", "168h") or a day count with a "d" suffix (e.g., "7d")
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration format: %s", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration format: %s (expected e.g. '30s', '5m', '24h' or '7d')", s)
+	}
+	return d, nil
+}
+
+// GetDurationConfig retrieves a duration value from config with a default fallback
+// Supports duration strings (e.g., "30s", "7d") and numbers interpreted as seconds
+func GetDurationConfig(config map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	val, exists := config[key]
+	if !exists {
+		return defaultValue, nil
+	}
+
+	switch v := val.(type) {
+	case string:
+		return ParseDuration(v)
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	default:
+		return 0, fmt.Errorf("%s must be a duration string (e.g., '30s') or number of seconds", key)
+	}
+}
+
 // GetPortConfig retrieves a port value from config with a default fallback
 // Supports string, int, and float64 types
 func GetPortConfig(config map[string]interface{}, key, defaultPort string) string {
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// JSONToText renders a JSON document as human-readable text, one "key: value" line per
+// scalar with the keys of nested objects joined by dots and array elements indexed, e.g.
+// "memory.alloc: 1024"; keys are sorted and strings are unquoted. A scalar document is
+// rendered as its bare value
+func JSONToText(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	var buf bytes.Buffer
+	writeText(&buf, "", v)
+	return buf.Bytes(), nil
+}
+
+func writeText(buf *bytes.Buffer, key string, v interface{}) {
+	join := func(child string) string {
+		if key == "" {
+			return child
+		}
+		return key + "." + child
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeText(buf, join(k), v[k])
+		}
+	case []interface{}:
+		for i, elem := range v {
+			writeText(buf, key+"["+strconv.Itoa(i)+"]", elem)
+		}
+	default:
+		if key != "" {
+			buf.WriteString(key + ": ")
+		}
+		if s, ok := v.(string); ok {
+			buf.WriteString(s)
+		} else if v == nil {
+			buf.WriteString("null")
+		} else {
+			fmt.Fprint(buf, v)
+		}
+		buf.WriteByte('\n')
+	}
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDataDir is the parent of the per-mount data directories when none is configured
+const DefaultDataDir = "data"
+
+// pluginMetrics holds the metrics of every mount, published at /debug/vars as "plugins"
+var pluginMetrics = expvar.NewMap("plugins")
+
+// SecretResolver returns the secret a configuration value refers to
+type SecretResolver func(ref string) (string, error)
+
+// ResolveSecret resolves "env:NAME" to the environment variable NAME and "file:/path" to
+// the content of a local file without its trailing newline; other values are returned as is
+func ResolveSecret(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return ref, nil
+	}
+}
+
+// Services are the server facilities shared by the plugins, handed to each one through
+// its InitContext
+type Services struct {
+	RootFS  filesystem.FileSystem // Root of the server, for plugins reading other mounts
+	DataDir string                // Parent of the per-mount data directories, default DefaultDataDir
+	Secrets SecretResolver        // Default ResolveSecret
+
+	// ClientTLS configures connections to other servers with the certificate and CA of the
+	// server, nil if it does not serve TLS
+	ClientTLS *tls.Config
+}
+
+// InitContext is what a plugin is initialized with: its configuration and the services
+// of the server, scoped to its mount
+type InitContext struct {
+	Name      string                 // Plugin name
+	MountPath string                 // Path the plugin is mounted at
+	Config    map[string]interface{} // Plugin configuration, including mount_path
+	Logger    *log.Entry             // Logs with the plugin name and mount path
+	Metrics   *expvar.Map            // Metrics of the mount, published at /debug/vars
+	RootFS    filesystem.FileSystem  // Root of the server, nil if unavailable
+	ClientTLS *tls.Config            // TLS of connections to other servers, nil if the server does not serve TLS
+
+	dataDir string
+	secrets SecretResolver
+}
+
+// NewInitContext returns the context initializing plugin name at mountPath with cfg
+func (s Services) NewInitContext(name, mountPath string, cfg map[string]interface{}) *InitContext {
+	dataDir := s.DataDir
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+	secrets := s.Secrets
+	if secrets == nil {
+		secrets = ResolveSecret
+	}
+	metrics, ok := pluginMetrics.Get(mountPath).(*expvar.Map)
+	if !ok {
+		metrics = new(expvar.Map).Init()
+		pluginMetrics.Set(mountPath, metrics)
+	}
+	return &InitContext{
+		Name:      name,
+		MountPath: mountPath,
+		Config:    cfg,
+		Logger:    log.WithFields(log.Fields{"plugin": name, "mount": mountPath}),
+		Metrics:   metrics,
+		RootFS:    s.RootFS,
+		ClientTLS: s.ClientTLS,
+		dataDir:   filepath.Join(dataDir, mountDirName(mountPath)),
+		secrets:   secrets,
+	}
+}
+
+// LegacyInitContext returns the context of a plugin initialized only with its configuration,
+// e.g. by a caller of Initialize(map) on a plugin implementing ContextInitializer; the
+// mount path is taken from mount_path and the root file system is unavailable
+func LegacyInitContext(name string, cfg map[string]interface{}) *InitContext {
+	mountPath, _ := cfg["mount_path"].(string)
+	return Services{}.NewInitContext(name, mountPath, cfg)
+}
+
+// mountDirName names the data directory of a mount, e.g. "/s3/archive" is "s3_archive"
+func mountDirName(mountPath string) string {
+	name := strings.ReplaceAll(strings.Trim(mountPath, "/"), "/", "_")
+	if name == "" {
+		return "_root"
+	}
+	return name
+}
+
+// DataDir returns the local directory of the mount for state such as caches or indexes,
+// creating it if needed
+func (ic *InitContext) DataDir() (string, error) {
+	if err := os.MkdirAll(ic.dataDir, 0700); err != nil {
+		return "", fmt.Errorf("data directory of %s: %w", ic.MountPath, err)
+	}
+	return ic.dataDir, nil
+}
+
+// Secret returns the configuration value of key with secret references resolved, "" if
+// the key is not set
+func (ic *InitContext) Secret(key string) (string, error) {
+	ref, _ := ic.Config[key].(string)
+	if ref == "" {
+		return "", nil
+	}
+	value, err := ic.secrets(ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return value, nil
+}
+
+// ContextInitializer is implemented by plugins initialized with an InitContext; Initialize
+// then calls InitializeContext instead of the Initialize method of the plugin, which remains
+// for callers that only have a configuration (see LegacyInitContext)
+type ContextInitializer interface {
+	InitializeContext(ictx *InitContext) error
+}
+
+// Initialize initializes p with ictx; plugins without InitializeContext get the root file
+// system through SetRootFS, when they have it, and the configuration of ictx
+func Initialize(p ServicePlugin, ictx *InitContext) error {
+	if ci, ok := p.(ContextInitializer); ok {
+		return ci.InitializeContext(ictx)
+	}
+	if setter, ok := p.(interface{ SetRootFS(filesystem.FileSystem) }); ok && ictx.RootFS != nil {
+		setter.SetRootFS(ictx.RootFS)
+	}
+	return p.Initialize(ictx.Config)
+}
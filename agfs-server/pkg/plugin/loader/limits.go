@@ -0,0 +1,55 @@
+package loader
+
+import (
+	"fmt"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// WASMLimitsFromConfig reads the resource limits of WASM plugins and the limits of individual
+// plugins by file name; the fields set for a plugin override the common limits
+func WASMLimitsFromConfig(cfg config.ExternalPluginsConfig) (api.WASMLimits, map[string]api.WASMLimits, error) {
+	limits, err := applyLimitsConfig(api.WASMLimits{}, cfg.Limits)
+	if err != nil {
+		return api.WASMLimits{}, nil, fmt.Errorf("limits: %w", err)
+	}
+	fileLimits := make(map[string]api.WASMLimits, len(cfg.PluginLimits))
+	for name, c := range cfg.PluginLimits {
+		if fileLimits[name], err = applyLimitsConfig(limits, c); err != nil {
+			return api.WASMLimits{}, nil, fmt.Errorf("plugin_limits of %s: %w", name, err)
+		}
+	}
+	return limits, fileLimits, nil
+}
+
+// applyLimitsConfig returns limits with the fields set in c replaced
+func applyLimitsConfig(limits api.WASMLimits, c config.PluginLimitsConfig) (api.WASMLimits, error) {
+	var err error
+	if c.MaxMemory != "" {
+		if limits.MaxMemory, err = pluginconfig.ParseSize(c.MaxMemory); err != nil {
+			return limits, fmt.Errorf("invalid max_memory: %w", err)
+		}
+	}
+	if c.CallTimeout != "" {
+		if limits.CallTimeout, err = pluginconfig.ParseDuration(c.CallTimeout); err != nil {
+			return limits, fmt.Errorf("invalid call_timeout: %w", err)
+		}
+	}
+	if c.SuspendFor != "" {
+		if limits.SuspendFor, err = pluginconfig.ParseDuration(c.SuspendFor); err != nil {
+			return limits, fmt.Errorf("invalid suspend_for: %w", err)
+		}
+	}
+	if c.MaxOpsPerSec < 0 || c.SuspendAfter < 0 {
+		return limits, fmt.Errorf("max_ops_per_sec and suspend_after must not be negative")
+	}
+	if c.MaxOpsPerSec > 0 {
+		limits.MaxOpsPerSec = c.MaxOpsPerSec
+	}
+	if c.SuspendAfter > 0 {
+		limits.SuspendAfter = c.SuspendAfter
+	}
+	return limits, nil
+}
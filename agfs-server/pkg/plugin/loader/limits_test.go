@@ -0,0 +1,130 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// limitsTestModule assembles a WASM plugin whose fs_stat succeeds, fs_readdir traps,
+// fs_read never returns and fs_mkdir tries to grow memory by 16 pages
+func limitsTestModule() []byte {
+	section := func(id byte, payload ...byte) []byte {
+		return append([]byte{id, byte(len(payload))}, payload...)
+	}
+	name := func(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+	body := func(code ...byte) []byte { return append([]byte{byte(len(code) + 1), 0x00}, code...) }
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(0x01, // types
+		5,
+		0x60, 0, 1, 0x7e, // () -> i64
+		0x60, 1, 0x7f, 1, 0x7f, // (i32) -> i32
+		0x60, 1, 0x7f, 1, 0x7e, // (i32) -> i64
+		0x60, 3, 0x7f, 0x7e, 0x7e, 1, 0x7e, // (i32, i64, i64) -> i64
+		0x60, 2, 0x7f, 0x7f, 1, 0x7f, // (i32, i32) -> i32
+	)...)
+	module = append(module, section(0x03, 6, 0, 1, 2, 2, 3, 4)...) // functions
+	module = append(module, section(0x05, 1, 0x00, 1)...)          // one page of memory
+
+	var exports []byte
+	exports = append(exports, 7)
+	exports = append(append(exports, name("memory")...), 0x02, 0)
+	for i, fn := range []string{"plugin_new", "malloc", "fs_stat", "fs_readdir", "fs_read", "fs_mkdir"} {
+		exports = append(append(exports, name(fn)...), 0x00, byte(i))
+	}
+	module = append(module, section(0x07, exports...)...)
+
+	var code []byte
+	code = append(code, 6)
+	code = append(code, body(0x42, 0x00, 0x0b)...)                               // plugin_new: 0
+	code = append(code, body(0x41, 0x80, 0x08, 0x0b)...)                         // malloc: 1024
+	code = append(code, body(0x42, 0x00, 0x0b)...)                               // fs_stat: 0
+	code = append(code, body(0x00, 0x0b)...)                                     // fs_readdir: unreachable
+	code = append(code, body(0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00, 0x0b)...) // fs_read: loop forever
+	code = append(code, body(0x41, 0x10, 0x40, 0x00, 0x0b)...)                   // fs_mkdir: memory.grow 16
+	return append(module, section(0x0a, code...)...)
+}
+
+func TestWASMLimits(t *testing.T) {
+	wasmPath := filepath.Join(t.TempDir(), "limits.wasm")
+	if err := os.WriteFile(wasmPath, limitsTestModule(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	limits, fileLimits, err := WASMLimitsFromConfig(config.ExternalPluginsConfig{
+		Limits: config.PluginLimitsConfig{MaxMemory: "128KB", CallTimeout: "200ms", SuspendFor: "1h"},
+		PluginLimits: map[string]config.PluginLimitsConfig{
+			"limits.wasm": {MaxOpsPerSec: 3, SuspendAfter: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := fileLimits["limits.wasm"]; l.MaxMemory != 128<<10 || l.MaxOpsPerSec != 3 || l.SuspendFor != time.Hour {
+		t.Fatalf("limits of limits.wasm = %+v", l)
+	}
+	wl := NewWASMPluginLoader()
+	wl.SetLimits(limits, fileLimits)
+	load := func() (plugin.ServicePlugin, filesystem.FileSystem) {
+		p, err := wl.LoadWASMPlugin(wasmPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p, p.GetFileSystem()
+	}
+	stats := func(p plugin.ServicePlugin) plugin.ResourceStats {
+		return p.(plugin.ResourceReporter).ResourceStats()
+	}
+
+	// Operations over the rate limit are refused, and repeated violations suspend the plugin
+	p, fs := load()
+	for i := 0; i < 5; i++ {
+		_, err := fs.Stat("/")
+		if unavailable := errors.Is(err, filesystem.ErrUnavailable); unavailable != (i >= 3) {
+			t.Errorf("stat %d: %v", i, err)
+		}
+	}
+	if st := stats(p); st.RateLimited != 2 || !st.Suspended || st.SuspendedUntil == nil {
+		t.Errorf("stats after rate limiting = %+v", st)
+	}
+
+	// So do calls failing in the runtime
+	p, fs = load()
+	fs.ReadDir("/")
+	fs.ReadDir("/")
+	if _, err := fs.ReadDir("/"); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("readdir of suspended plugin: %v", err)
+	}
+	if st := stats(p); st.Failures != 2 || !st.Suspended {
+		t.Errorf("stats after failures = %+v", st)
+	}
+
+	// Memory cannot grow beyond the limit, and a call running too long terminates the plugin
+	p, fs = load()
+	fs.Mkdir("/dir", 0755)
+	if st := stats(p); st.MemoryBytes != 64<<10 || st.MemoryLimit != 128<<10 {
+		t.Errorf("memory = %d of %d bytes", st.MemoryBytes, st.MemoryLimit)
+	}
+	if _, err := fs.Read("/file", 0, -1); !errors.Is(err, filesystem.ErrTimeout) {
+		t.Fatalf("read of looping plugin: %v", err)
+	}
+	if _, err := fs.Stat("/"); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("stat of terminated plugin: %v", err)
+	}
+	if st := stats(p); st.Timeouts != 1 || !st.Suspended || st.SuspendedUntil != nil {
+		t.Errorf("stats after timeout = %+v", st)
+	}
+	if err := p.Shutdown(); err != nil {
+		t.Errorf("shutdown of terminated plugin: %v", err)
+	}
+
+	if _, _, err := WASMLimitsFromConfig(config.ExternalPluginsConfig{Limits: config.PluginLimitsConfig{CallTimeout: "soon"}}); err == nil {
+		t.Error("invalid call_timeout accepted")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 
@@ -51,6 +52,8 @@ type LoadedPlugin struct {
 type PluginLoader struct {
 	loadedPlugins map[string]*LoadedPlugin
 	wasmLoader    *WASMPluginLoader
+	verifier      *Verifier             // Checks plugin signatures, nil accepts every plugin
+	provenance    map[string]Provenance // Provenance of the loaded plugin files by absolute path
 	mu            sync.RWMutex
 }
 
@@ -59,9 +62,34 @@ func NewPluginLoader() *PluginLoader {
 	return &PluginLoader{
 		loadedPlugins: make(map[string]*LoadedPlugin),
 		wasmLoader:    NewWASMPluginLoader(),
+		provenance:    make(map[string]Provenance),
 	}
 }
 
+// SetWASMLimits sets the resource limits of the WASM plugins loaded afterwards
+func (pl *PluginLoader) SetWASMLimits(limits api.WASMLimits, fileLimits map[string]api.WASMLimits) {
+	pl.wasmLoader.SetLimits(limits, fileLimits)
+}
+
+// SetVerifier sets the signature verification of the plugins loaded afterwards
+func (pl *PluginLoader) SetVerifier(v *Verifier) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.verifier = v
+}
+
+// Provenance returns the provenance of the loaded plugin files
+func (pl *PluginLoader) Provenance() []Provenance {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+
+	result := make([]Provenance, 0, len(pl.provenance))
+	for _, prov := range pl.provenance {
+		result = append(result, prov)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
 
 // DetectPluginType detects the type of plugin based on file content and extension
 func DetectPluginType(libraryPath string) (PluginType, error) {
@@ -134,15 +162,39 @@ func detectPluginTypeByExtension(libraryPath string) PluginType {
 func (pl *PluginLoader) LoadPluginWithType(libraryPath string, pluginType PluginType, hostFS ...interface{}) (plugin.ServicePlugin, error) {
 	log.Debugf("Loading plugin with type %s: %s", pluginType, libraryPath)
 
+	absPath, err := filepath.Abs(libraryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+	pl.mu.RLock()
+	verifier := pl.verifier
+	pl.mu.RUnlock()
+	prov, err := verifier.Verify(absPath)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load based on specified type
+	var p plugin.ServicePlugin
 	switch pluginType {
 	case PluginTypeWASM:
-		return pl.wasmLoader.LoadWASMPlugin(libraryPath, hostFS...)
+		p, err = pl.wasmLoader.LoadWASMPlugin(libraryPath, hostFS...)
 	case PluginTypeNative:
-		return pl.loadNativePlugin(libraryPath)
+		p, err = pl.loadNativePlugin(libraryPath)
 	default:
 		return nil, fmt.Errorf("unsupported plugin type: %s", pluginType)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	pl.mu.Lock()
+	pl.provenance[absPath] = prov
+	pl.mu.Unlock()
+	if prov.Signed {
+		log.Infof("Plugin %s (sha256 %s) is signed with trusted key %s", absPath, prov.SHA256, prov.KeyID)
+	}
+	return p, nil
 }
 
 // LoadPlugin loads a plugin from a shared library file (.so, .dylib, .dll) or WASM file (.wasm)
@@ -245,14 +297,25 @@ func (pl *PluginLoader) UnloadPluginWithType(libraryPath string, pluginType Plug
 	log.Debugf("Unloading plugin with type %s: %s", pluginType, libraryPath)
 
 	// Unload based on specified type
+	var err error
 	switch pluginType {
 	case PluginTypeWASM:
-		return pl.wasmLoader.UnloadWASMPlugin(libraryPath)
+		err = pl.wasmLoader.UnloadWASMPlugin(libraryPath)
 	case PluginTypeNative:
-		return pl.unloadNativePlugin(libraryPath)
+		err = pl.unloadNativePlugin(libraryPath)
 	default:
 		return fmt.Errorf("unsupported plugin type: %s", pluginType)
 	}
+	if err != nil {
+		return err
+	}
+
+	if absPath, err := filepath.Abs(libraryPath); err == nil && !pl.IsLoadedWithType(libraryPath, pluginType) {
+		pl.mu.Lock()
+		delete(pl.provenance, absPath)
+		pl.mu.Unlock()
+	}
+	return nil
 }
 
 // UnloadPlugin unloads a plugin (decrements ref count, unloads when reaches 0)
@@ -0,0 +1,143 @@
+package loader
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+)
+
+// SignatureExt is appended to a plugin's path to find its detached signature
+const SignatureExt = ".sig"
+
+// Provenance describes where a loaded plugin came from and how it was verified
+type Provenance struct {
+	Path     string    `json:"path"`
+	SHA256   string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	Signed   bool      `json:"signed"`           // Set if a trusted key verified the signature
+	KeyID    string    `json:"key_id,omitempty"` // Fingerprint of the key that verified it
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// trustedKey is a public key plugins may be signed with
+type trustedKey struct {
+	id  string
+	pub interface{} // ed25519.PublicKey or *ecdsa.PublicKey
+}
+
+// Verifier checks the detached signatures of plugin files against trusted keys
+// A signature is kept next to the plugin in <plugin>.sig, base64 encoded: an ed25519
+// signature of the file, or an ECDSA signature of its SHA-256 digest as written by
+// "cosign sign-blob"
+type Verifier struct {
+	required bool
+	keys     []trustedKey
+}
+
+// NewVerifier reads the trusted keys of the signing configuration; each key is a PEM
+// encoded public key or the path of a file holding one
+func NewVerifier(cfg config.PluginSigningConfig) (*Verifier, error) {
+	v := &Verifier{required: cfg.Required}
+	for _, entry := range cfg.TrustedKeys {
+		data := []byte(entry)
+		if !strings.Contains(entry, "-----BEGIN") {
+			var err error
+			if data, err = os.ReadFile(entry); err != nil {
+				return nil, fmt.Errorf("failed to read trusted key: %w", err)
+			}
+		}
+		found := 0
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			key, err := parseTrustedKey(block)
+			if err != nil {
+				return nil, err
+			}
+			v.keys = append(v.keys, key)
+			found++
+		}
+		if found == 0 {
+			return nil, fmt.Errorf("no PEM public key found in trusted key %q", entry)
+		}
+	}
+	if v.required && len(v.keys) == 0 {
+		return nil, fmt.Errorf("plugin signatures are required but no trusted key is configured")
+	}
+	return v, nil
+}
+
+func parseTrustedKey(block *pem.Block) (trustedKey, error) {
+	if block.Type != "PUBLIC KEY" {
+		return trustedKey{}, fmt.Errorf("trusted keys must be PEM public keys, got %s", block.Type)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return trustedKey{}, fmt.Errorf("invalid trusted key: %w", err)
+	}
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+	default:
+		return trustedKey{}, fmt.Errorf("unsupported trusted key type %T, use ed25519 or ECDSA", pub)
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return trustedKey{id: hex.EncodeToString(sum[:8]), pub: pub}, nil
+}
+
+// Verify checks the signature of the plugin in path and returns its provenance
+// A plugin without a signature is accepted unless signatures are required, but a signature
+// no trusted key verifies always fails
+func (v *Verifier) Verify(path string) (Provenance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Provenance{}, fmt.Errorf("failed to read plugin: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	prov := Provenance{Path: path, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data)), LoadedAt: time.Now()}
+	if v == nil || len(v.keys) == 0 {
+		return prov, nil
+	}
+
+	encoded, err := os.ReadFile(path + SignatureExt)
+	if os.IsNotExist(err) {
+		if v.required {
+			return prov, fmt.Errorf("plugin %s is not signed: %s%s not found", path, path, SignatureExt)
+		}
+		return prov, nil
+	}
+	if err != nil {
+		return prov, fmt.Errorf("failed to read signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return prov, fmt.Errorf("invalid signature of plugin %s: %w", path, err)
+	}
+
+	for _, key := range v.keys {
+		var ok bool
+		switch pub := key.pub.(type) {
+		case ed25519.PublicKey:
+			ok = ed25519.Verify(pub, data, sig)
+		case *ecdsa.PublicKey:
+			ok = ecdsa.VerifyASN1(pub, sum[:], sig)
+		}
+		if ok {
+			prov.Signed, prov.KeyID = true, key.id
+			return prov, nil
+		}
+	}
+	return prov, fmt.Errorf("signature of plugin %s is not valid for any trusted key", path)
+}
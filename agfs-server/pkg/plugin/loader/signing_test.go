@@ -0,0 +1,112 @@
+package loader
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+)
+
+func publicKeyPEM(t *testing.T, pub interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestSignedPlugins(t *testing.T) {
+	dir := t.TempDir()
+	module := limitsTestModule()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := write("cosign.pub", []byte(publicKeyPEM(t, &ecPriv.PublicKey)))
+
+	signedED := write("ed.wasm", module)
+	write("ed.wasm.sig", []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, module))))
+	digest := sha256.Sum256(module)
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecPriv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedEC := write("ec.wasm", module)
+	write("ec.wasm.sig", []byte(base64.StdEncoding.EncodeToString(ecSig)+"\n"))
+	unsigned := write("unsigned.wasm", module)
+	tampered := write("tampered.wasm", append(append([]byte{}, module...), 0x00, 0x01, 0x00))
+	write("tampered.wasm.sig", []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, module))))
+
+	v, err := NewVerifier(config.PluginSigningConfig{Required: true, TrustedKeys: []string{publicKeyPEM(t, edPub), keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := NewPluginLoader()
+	pl.SetVerifier(v)
+	for _, p := range []string{signedED, signedEC} {
+		if _, err := pl.LoadPlugin(p); err != nil {
+			t.Errorf("load %s: %v", filepath.Base(p), err)
+		}
+	}
+	for _, p := range []string{unsigned, tampered} {
+		if _, err := pl.LoadPlugin(p); err == nil {
+			t.Errorf("load %s succeeded", filepath.Base(p))
+		}
+	}
+	provenance := pl.Provenance()
+	if len(provenance) != 2 {
+		t.Fatalf("provenance = %+v", provenance)
+	}
+	for _, prov := range provenance {
+		if !prov.Signed || prov.KeyID == "" || prov.Size != int64(len(module)) || len(prov.SHA256) != 64 {
+			t.Errorf("provenance = %+v", prov)
+		}
+	}
+	if provenance[0].KeyID == provenance[1].KeyID {
+		t.Errorf("both plugins verified by key %s", provenance[0].KeyID)
+	}
+	if err := pl.UnloadPlugin(signedEC); err != nil {
+		t.Fatal(err)
+	}
+	if provenance := pl.Provenance(); len(provenance) != 1 || provenance[0].Path != signedED {
+		t.Errorf("provenance after unload = %+v", provenance)
+	}
+
+	// Without required signatures unsigned plugins load, but bad signatures are still refused
+	v, err = NewVerifier(config.PluginSigningConfig{TrustedKeys: []string{keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl.SetVerifier(v)
+	if _, err := pl.LoadPlugin(unsigned); err != nil {
+		t.Errorf("load unsigned: %v", err)
+	}
+	if _, err := pl.LoadPlugin(tampered); err == nil {
+		t.Error("load tampered succeeded")
+	}
+
+	if _, err := NewVerifier(config.PluginSigningConfig{Required: true}); err == nil {
+		t.Error("required signatures without trusted keys accepted")
+	}
+}
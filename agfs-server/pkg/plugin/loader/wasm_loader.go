@@ -29,6 +29,8 @@ type LoadedWASMPlugin struct {
 // WASMPluginLoader manages loading and unloading of WASM plugins
 type WASMPluginLoader struct {
 	loadedPlugins map[string]*LoadedWASMPlugin
+	limits        api.WASMLimits            // Limits of plugins without limits of their own
+	fileLimits    map[string]api.WASMLimits // Limits by plugin file name
 	mu            sync.RWMutex
 }
 
@@ -39,6 +41,22 @@ func NewWASMPluginLoader() *WASMPluginLoader {
 	}
 }
 
+// SetLimits sets the resource limits of the plugins loaded afterwards; fileLimits holds the
+// limits of individual plugins by file name (e.g. "hellofs.wasm")
+func (wl *WASMPluginLoader) SetLimits(limits api.WASMLimits, fileLimits map[string]api.WASMLimits) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.limits, wl.fileLimits = limits, fileLimits
+}
+
+// limitsFor returns the resource limits of the plugin in wasmPath
+func (wl *WASMPluginLoader) limitsFor(wasmPath string) api.WASMLimits {
+	if limits, ok := wl.fileLimits[filepath.Base(wasmPath)]; ok {
+		return limits
+	}
+	return wl.limits
+}
+
 // LoadWASMPlugin loads a plugin from a WASM file
 // If hostFS is provided, it will be exposed to the WASM plugin as host functions
 func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, hostFS ...interface{}) (plugin.ServicePlugin, error) {
@@ -76,9 +94,18 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, hostFS ...interface{
 		return nil, fmt.Errorf("failed to read WASM file %s: %w", wasmPath, err)
 	}
 
-	// Create a new WASM runtime
+	// Create a new WASM runtime, bounding the memory of the module and stopping calls that
+	// run too long
 	ctx := context.Background()
-	r := wazero.NewRuntime(ctx)
+	limits := wl.limitsFor(wasmPath)
+	runtimeConfig := wazero.NewRuntimeConfig()
+	if pages := limits.MemoryLimitPages(); pages > 0 {
+		runtimeConfig = runtimeConfig.WithMemoryLimitPages(pages)
+	}
+	if limits.CallTimeout > 0 {
+		runtimeConfig = runtimeConfig.WithCloseOnContextDone(true)
+	}
+	r := wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
 
 	// Instantiate WASI
 	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
@@ -88,15 +115,19 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, hostFS ...interface{
 
 	// Always instantiate host filesystem module (required by WASM modules that import these functions)
 	// If no hostFS is provided, use stub functions that return errors
+	// The host functions only see the host filesystem through a sandbox, which the plugin's
+	// host_root and host_paths configuration restricts
 	var fs filesystem.FileSystem
+	var sandbox *api.HostSandbox
 	if len(hostFS) > 0 && hostFS[0] != nil {
 		// Type assert to filesystem.FileSystem
-		var ok bool
-		fs, ok = hostFS[0].(filesystem.FileSystem)
+		hfs, ok := hostFS[0].(filesystem.FileSystem)
 		if !ok {
 			r.Close(ctx)
 			return nil, fmt.Errorf("hostFS is not a filesystem.FileSystem")
 		}
+		sandbox = api.NewHostSandbox(hfs)
+		fs = sandbox
 		log.Infof("Registering host filesystem for WASM plugin")
 	} else {
 		log.Infof("No host filesystem provided, using stub functions")
@@ -189,6 +220,10 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, hostFS ...interface{
 		r.Close(ctx)
 		return nil, fmt.Errorf("failed to create WASM plugin wrapper: %w", err)
 	}
+	if sandbox != nil {
+		wasmPlugin.SetHostSandbox(sandbox)
+	}
+	wasmPlugin.SetLimits(limits)
 
 	// Track loaded plugin
 	loaded := &LoadedWASMPlugin{
@@ -1,6 +1,10 @@
 package plugin
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 )
 
@@ -31,6 +35,40 @@ type ServicePlugin interface {
 	Shutdown() error
 }
 
+// HTTPHandlerProvider is implemented by plugins that serve endpoints of their own next to
+// the file interface, e.g. batch operations or queries that do not map onto reads and writes
+// The handler receives the requests to /api/v1/plugins/<mount path>/... with that prefix
+// stripped, so a request to /api/v1/plugins/queue/jobs/enqueue reaches it as /jobs/enqueue
+type HTTPHandlerProvider interface {
+	HTTPHandler() http.Handler
+}
+
+// ResourceStats describes the resource use of a plugin running under resource limits
+type ResourceStats struct {
+	Calls          int64      `json:"calls"`                     // Calls into the plugin
+	RateLimited    int64      `json:"rate_limited"`              // Operations refused by the operation rate limit
+	Timeouts       int64      `json:"timeouts"`                  // Calls stopped by the call timeout
+	Failures       int64      `json:"failures"`                  // Calls aborted by the runtime, e.g. when memory ran out
+	MemoryBytes    int64      `json:"memory_bytes"`              // Memory currently held by the plugin
+	MemoryLimit    int64      `json:"memory_limit,omitempty"`    // Memory the plugin may hold, 0 if unlimited
+	Suspended      bool       `json:"suspended,omitempty"`       // Set while calls are refused
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"` // End of the current suspension, nil if it lasts until a reload
+	Reason         string     `json:"reason,omitempty"`          // Why the plugin is suspended
+}
+
+// ResourceReporter is implemented by plugins that run under resource limits, such as WASM
+// plugins, so the server can report their resource use
+type ResourceReporter interface {
+	ResourceStats() ResourceStats
+}
+
+// HealthChecker is implemented by plugins whose backend can become unreachable, such as a
+// database or a remote server, so that GET /readyz can report whether it is reachable
+// HealthCheck should be cheap, e.g. a ping, and return when ctx is done
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
 // MountPoint represents a mounted service plugin
 type MountPoint struct {
 	Path   string
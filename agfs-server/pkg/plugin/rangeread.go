@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Defaults for parallel range reads
+const (
+	DefaultReadPartSize    = 8 * 1024 * 1024 // 8MB per ranged sub-read
+	DefaultReadConcurrency = 1               // Sub-reads in flight; 1 disables parallel reads
+)
+
+// RangeFetcher fetches length bytes at offset of a file
+// It may return fewer bytes only at the end of the file
+type RangeFetcher func(offset, length int64) ([]byte, error)
+
+// ParallelRangeRead reads size bytes (-1 for the rest of the file) at offset of a file of
+// fileSize bytes, split into parts of partSize fetched by up to concurrency goroutines
+// Like ApplyRangeRead, it returns io.EOF with the data when the read reaches the end of the file
+func ParallelRangeRead(fileSize, offset, size, partSize int64, concurrency int, fetch RangeFetcher) ([]byte, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= fileSize {
+		return nil, io.EOF
+	}
+	end := fileSize
+	if size >= 0 && offset+size < fileSize {
+		end = offset + size
+	}
+	if partSize <= 0 {
+		partSize = DefaultReadPartSize
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	buf := make([]byte, end-offset)
+	parts := (end - offset + partSize - 1) / partSize
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // protects firstErr
+		firstErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+	next := make(chan int64)
+
+	for w := 0; w < concurrency && int64(w) < parts; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range next {
+				if failed() {
+					continue
+				}
+				start := offset + part*partSize
+				length := partSize
+				if start+length > end {
+					length = end - start
+				}
+				data, err := fetch(start, length)
+				if err == io.EOF {
+					err = nil
+				}
+				if err == nil && int64(len(data)) != length {
+					err = fmt.Errorf("short ranged read at offset %d: got %d of %d bytes", start, len(data), length)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				copy(buf[start-offset:], data)
+			}
+		}()
+	}
+	for part := int64(0); part < parts && !failed(); part++ {
+		next <- part
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if end >= fileSize {
+		return buf, io.EOF
+	}
+	return buf, nil
+}
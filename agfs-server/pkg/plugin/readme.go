@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Languages READMEs are written in; English is the fallback of the others
+const (
+	LangEnglish = "en"
+	LangChinese = "zh"
+)
+
+// ReadmeTemplater is implemented by plugins whose README is a template, rendered for each
+// mount with its live configuration and in the language of the reader
+type ReadmeTemplater interface {
+	// ReadmeTemplates returns the text/template sources of the README by language, which
+	// must include English; they use the plain-text README layout, see TextToMarkdown
+	ReadmeTemplates() map[string]string
+}
+
+// ReadmeData is what README templates are executed with
+type ReadmeData struct {
+	Plugin    string                 // Name of the plugin
+	MountPath string                 // Where the instance is mounted, e.g. "/kvfs"
+	Config    map[string]interface{} // Configuration of the instance
+}
+
+// NewReadmeData returns the data of the instance of plugin mounted at mountPath with cfg;
+// without a mount path the plugin is assumed at /<plugin>
+func NewReadmeData(plugin, mountPath string, cfg map[string]interface{}) ReadmeData {
+	if mountPath == "" {
+		mountPath = "/" + plugin
+	}
+	return ReadmeData{Plugin: plugin, MountPath: mountPath, Config: cfg}
+}
+
+// readmeFuncs are the functions of README templates:
+//
+//	config "key" "default"  the configured value of key, default if it is not set
+func readmeFuncs(data ReadmeData) template.FuncMap {
+	return template.FuncMap{
+		"config": func(key string, def interface{}) string {
+			if v, ok := data.Config[key]; ok && v != nil && fmt.Sprint(v) != "" {
+				return fmt.Sprint(v)
+			}
+			return fmt.Sprint(def)
+		},
+	}
+}
+
+// readmeTemplate returns the template of lang, falling back to English
+func readmeTemplate(templates map[string]string, lang string) string {
+	if src, ok := templates[strings.ToLower(lang)]; ok {
+		return src
+	}
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	if src, ok := templates[primary]; ok {
+		return src
+	}
+	return templates[LangEnglish]
+}
+
+// RenderReadme renders the README template of lang, or English if there is none, with data
+// as text or, if format is filesystem.FormatMarkdown, as Markdown
+func RenderReadme(templates map[string]string, lang, format string, data ReadmeData) (string, error) {
+	t, err := template.New(data.Plugin).Funcs(readmeFuncs(data)).Parse(readmeTemplate(templates, lang))
+	if err != nil {
+		return "", fmt.Errorf("README template of %s: %w", data.Plugin, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("README template of %s: %w", data.Plugin, err)
+	}
+	if format == filesystem.FormatMarkdown {
+		return TextToMarkdown(buf.String()), nil
+	}
+	return buf.String(), nil
+}
+
+// Readme renders the English README as text, for GetReadme of templated plugins
+func Readme(templates map[string]string, data ReadmeData) string {
+	text, err := RenderReadme(templates, LangEnglish, filesystem.FormatText, data)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	return text
+}
+
+var (
+	// sectionHeading matches the unindented "SECTION:" lines of the README layout, which are
+	// upper case in languages with case
+	sectionHeading = regexp.MustCompile(`^[^\s:：][^:：]{0,40}[:：]$`)
+	// fieldLine matches unindented "KEY: value" lines such as "VERSION: 1.0.0"
+	fieldLine = regexp.MustCompile(`^([A-Z][A-Z0-9 _-]*):\s+(\S.*)$`)
+)
+
+// TextToMarkdown converts a README in the plain-text layout of the plugins to Markdown: the
+// first line is the title, unindented lines ending with a colon are section headings and
+// indented blocks are code, or a list if all their lines start with "- "
+func TextToMarkdown(text string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	titled := false
+	for i := 0; i < len(lines); {
+		line := strings.TrimRight(lines[i], " \t")
+		switch {
+		case line == "":
+			out = append(out, "")
+			i++
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			end := indentedBlockEnd(lines, i)
+			out = append(out, markdownBlock(lines[i:end])...)
+			i = end
+			continue
+		case !titled:
+			out = append(out, "# "+line)
+		case sectionHeading.MatchString(line) && line == strings.ToUpper(line):
+			out = append(out, "## "+headingCase(strings.TrimRight(line, ":：")))
+		default:
+			if m := fieldLine.FindStringSubmatch(line); m != nil {
+				out = append(out, "**"+headingCase(m[1])+":** "+m[2])
+			} else {
+				out = append(out, line)
+			}
+		}
+		titled = true
+		i++
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// indentedBlockEnd returns the end of the indented block starting at lines[start], which
+// spans blank lines followed by more indented lines
+func indentedBlockEnd(lines []string, start int) int {
+	end := start
+	for i := start; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			break
+		}
+		end = i + 1
+	}
+	return end
+}
+
+// markdownBlock renders an indented block, without its common indentation, as a list or
+// as a code block
+func markdownBlock(block []string) []string {
+	indent := -1
+	for _, line := range block {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if n := len(line) - len(strings.TrimLeft(line, " \t")); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	list := true
+	lines := make([]string, len(block))
+	for i, line := range block {
+		line = strings.TrimRight(line, " \t")
+		if len(line) >= indent {
+			line = line[indent:]
+		}
+		lines[i] = line
+		if line != "" && !strings.HasPrefix(line, "- ") {
+			list = false
+		}
+	}
+	if list {
+		return lines
+	}
+	return append(append([]string{"```"}, lines...), "```")
+}
+
+// headingCase turns an upper case heading such as "WRITE BATCHING" into "Write batching"
+// and leaves other headings as they are
+func headingCase(s string) string {
+	if s != strings.ToUpper(s) || s == strings.ToLower(s) {
+		return s
+	}
+	return s[:1] + strings.ToLower(s[1:])
+}
@@ -0,0 +1,30 @@
+package plugin
+
+// Types of configuration parameters
+const (
+	ParamString   = "string"
+	ParamInt      = "int"
+	ParamBool     = "bool"
+	ParamSize     = "size"     // Bytes as a number or a string such as "64MB"
+	ParamDuration = "duration" // A string such as "30s" or "7d"
+	ParamStrings  = "strings"  // A list of strings
+	ParamMap      = "map"      // An object
+)
+
+// ConfigParameter describes a configuration key of a plugin
+type ConfigParameter struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Choices     []string `json:"choices,omitempty"` // Allowed values, if limited
+	Secret      bool     `json:"secret,omitempty"`  // Credentials, not echoed when entered
+	Description string   `json:"description"`
+}
+
+// ConfigSchemaProvider is implemented by plugins that describe their configuration keys,
+// so that clients such as the mount wizard of agfs-shell can prompt for them
+// mount_path and the mount options are not part of the schema
+type ConfigSchemaProvider interface {
+	ConfigSchema() []ConfigParameter
+}
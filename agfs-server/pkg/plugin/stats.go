@@ -0,0 +1,314 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// StatsFile is the virtual file reporting the statistics of a plugin instance
+const StatsFile = "/.stats"
+
+// GaugeReporter is implemented by plugins, or their file systems, that report gauges of their
+// backend in the stats file, such as queued messages or stored bytes
+type GaugeReporter interface {
+	Gauges() map[string]interface{}
+}
+
+// OpStats counts the calls of one file operation
+type OpStats struct {
+	Calls    int64 `json:"calls"`
+	Errors   int64 `json:"errors"`              // Failed calls, not counting NotFound
+	NotFound int64 `json:"not_found,omitempty"` // Calls for paths that do not exist
+	Bytes    int64 `json:"bytes,omitempty"`     // Bytes read or written
+}
+
+// InstanceStats is the content of the stats file
+type InstanceStats struct {
+	Plugin      string                 `json:"plugin"`
+	Since       time.Time              `json:"since"`
+	Ops         map[string]OpStats     `json:"ops"`
+	Errors      int64                  `json:"errors"`
+	LastError   string                 `json:"last_error,omitempty"`
+	LastErrorAt *time.Time             `json:"last_error_at,omitempty"`
+	Gauges      map[string]interface{} `json:"gauges,omitempty"`
+	Resources   *ResourceStats         `json:"resources,omitempty"`
+}
+
+// opCounters holds the counters shared by a StatsFS and its context-bound copies
+type opCounters struct {
+	plugin ServicePlugin
+	since  time.Time
+
+	mu          sync.Mutex
+	ops         map[string]*OpStats
+	errors      int64
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// StatsFS counts the operations on a plugin's file system and serves them, with the gauges
+// and resources the plugin reports, as JSON in StatsFile at its root
+type StatsFS struct {
+	filesystem.FileSystem
+	counters *opCounters
+}
+
+// NewStatsFS wraps fs, the file system of p or a layer over it
+func NewStatsFS(fs filesystem.FileSystem, p ServicePlugin) *StatsFS {
+	return &StatsFS{
+		FileSystem: fs,
+		counters:   &opCounters{plugin: p, since: time.Now(), ops: make(map[string]*OpStats)},
+	}
+}
+
+// WithContext implements filesystem.ContextBinder by binding the wrapped file system
+func (s *StatsFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *s
+	bound.FileSystem = filesystem.WithContext(s.FileSystem, ctx)
+	return &bound
+}
+
+// record counts a call of op that transferred n bytes and returns its error
+func (s *StatsFS) record(op string, n int, err error) error {
+	return s.count(op, 1, n, err)
+}
+
+// count adds calls, n bytes and err to the counters of op and returns err
+func (s *StatsFS) count(op string, calls, n int, err error) error {
+	c := s.counters
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.ops[op]
+	if st == nil {
+		st = &OpStats{}
+		c.ops[op] = st
+	}
+	st.Calls += int64(calls)
+	st.Bytes += int64(n)
+	switch {
+	case err == nil || err == io.EOF:
+	case filesystem.IsNotFound(err):
+		st.NotFound++
+	default:
+		st.Errors++
+		c.errors++
+		c.lastError, c.lastErrorAt = err.Error(), time.Now()
+	}
+	return err
+}
+
+// Stats returns the current statistics of the instance
+func (s *StatsFS) Stats() InstanceStats {
+	c := s.counters
+	c.mu.Lock()
+	stats := InstanceStats{Plugin: c.plugin.Name(), Since: c.since, Ops: make(map[string]OpStats, len(c.ops)), Errors: c.errors, LastError: c.lastError}
+	for op, st := range c.ops {
+		stats.Ops[op] = *st
+	}
+	if !c.lastErrorAt.IsZero() {
+		at := c.lastErrorAt
+		stats.LastErrorAt = &at
+	}
+	c.mu.Unlock()
+
+	if g, ok := c.plugin.(GaugeReporter); ok {
+		stats.Gauges = g.Gauges()
+	} else if g, ok := c.plugin.GetFileSystem().(GaugeReporter); ok {
+		stats.Gauges = g.Gauges()
+	}
+	if r, ok := c.plugin.(ResourceReporter); ok {
+		resources := r.ResourceStats()
+		stats.Resources = &resources
+	}
+	return stats
+}
+
+func (s *StatsFS) content() ([]byte, error) {
+	data, err := json.MarshalIndent(s.Stats(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (s *StatsFS) denied(op, p string) error {
+	return filesystem.NewPermissionDeniedError(op, p, StatsFile+" is maintained by the server")
+}
+
+func (s *StatsFS) Create(p string) error {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return s.denied("create", p)
+	}
+	return s.record("create", 0, s.FileSystem.Create(p))
+}
+
+func (s *StatsFS) Mkdir(p string, perm uint32) error {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return s.denied("mkdir", p)
+	}
+	return s.record("mkdir", 0, s.FileSystem.Mkdir(p, perm))
+}
+
+func (s *StatsFS) Remove(p string) error {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return s.denied("remove", p)
+	}
+	return s.record("remove", 0, s.FileSystem.Remove(p))
+}
+
+func (s *StatsFS) RemoveAll(p string) error {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return s.denied("remove", p)
+	}
+	return s.record("removeall", 0, s.FileSystem.RemoveAll(p))
+}
+
+func (s *StatsFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if filesystem.NormalizePath(p) == StatsFile {
+		data, err := s.content()
+		if err != nil {
+			return nil, err
+		}
+		return ApplyRangeRead(data, offset, size)
+	}
+	data, err := s.FileSystem.Read(p, offset, size)
+	return data, s.record("read", len(data), err)
+}
+
+func (s *StatsFS) Write(p string, data []byte) ([]byte, error) {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return nil, s.denied("write", p)
+	}
+	resp, err := s.FileSystem.Write(p, data)
+	if err != nil {
+		return resp, s.record("write", 0, err)
+	}
+	return resp, s.record("write", len(data), nil)
+}
+
+func (s *StatsFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	infos, err := s.FileSystem.ReadDir(p)
+	if err = s.record("readdir", 0, err); err != nil || filesystem.NormalizePath(p) != "/" {
+		return infos, err
+	}
+	for _, info := range infos {
+		if "/"+info.Name == StatsFile {
+			return infos, nil
+		}
+	}
+	info, err := s.Stat(StatsFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(infos, *info), nil
+}
+
+func (s *StatsFS) Stat(p string) (*filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(p) == StatsFile {
+		data, err := s.content()
+		if err != nil {
+			return nil, err
+		}
+		return &filesystem.FileInfo{
+			Name:    StatsFile[1:],
+			Size:    int64(len(data)),
+			Mode:    0444,
+			ModTime: time.Now(),
+			Meta:    filesystem.MetaData{Name: s.counters.plugin.Name(), Type: "stats"},
+		}, nil
+	}
+	info, err := s.FileSystem.Stat(p)
+	return info, s.record("stat", 0, err)
+}
+
+func (s *StatsFS) Rename(oldPath, newPath string) error {
+	if filesystem.NormalizePath(oldPath) == StatsFile || filesystem.NormalizePath(newPath) == StatsFile {
+		return s.denied("rename", oldPath)
+	}
+	return s.record("rename", 0, s.FileSystem.Rename(oldPath, newPath))
+}
+
+func (s *StatsFS) Chmod(p string, mode uint32) error {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return s.denied("chmod", p)
+	}
+	return s.record("chmod", 0, s.FileSystem.Chmod(p, mode))
+}
+
+func (s *StatsFS) Open(p string) (io.ReadCloser, error) {
+	if filesystem.NormalizePath(p) == StatsFile {
+		data, err := s.content()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r, err := s.FileSystem.Open(p)
+	if err = s.record("open", 0, err); err != nil {
+		return nil, err
+	}
+	return &countingReader{ReadCloser: r, stats: s}, nil
+}
+
+func (s *StatsFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if filesystem.NormalizePath(p) == StatsFile {
+		return nil, s.denied("openwrite", p)
+	}
+	w, err := s.FileSystem.OpenWrite(p)
+	if err = s.record("openwrite", 0, err); err != nil {
+		return nil, err
+	}
+	return &countingWriter{WriteCloser: w, stats: s}, nil
+}
+
+// countingReader adds the bytes read from an opened file to the read counters
+type countingReader struct {
+	io.ReadCloser
+	stats *StatsFS
+	n     int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.stats.count("open", 0, r.n, r.ReadCloser.Close())
+}
+
+// countingWriter adds the bytes written to an opened file to the write counters
+type countingWriter struct {
+	io.WriteCloser
+	stats *StatsFS
+	n     int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += n
+	return n, err
+}
+
+func (w *countingWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if err != nil {
+		w.n = 0
+	}
+	return w.stats.count("openwrite", 0, w.n, err)
+}
+
+// Abort implements filesystem.Aborter, aborted writes are not counted
+func (w *countingWriter) Abort() error {
+	return filesystem.AbortWrite(w.WriteCloser)
+}
+
+var _ filesystem.FileSystem = (*StatsFS)(nil)
+var _ filesystem.ContextBinder = (*StatsFS)(nil)
@@ -0,0 +1,891 @@
+package agentfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "agentfs" // Name of this plugin
+
+	// DefaultMaxSize bounds the input, output and log of a task
+	DefaultMaxSize = 16 * 1024 * 1024
+
+	// DefaultRetention is how long finished tasks are kept
+	DefaultRetention = 24 * time.Hour
+
+	// gcInterval is how often finished tasks past their retention are removed
+	gcInterval = time.Minute
+)
+
+// Files of a task directory
+const (
+	FileInput  = "input"  // Task spec, writing it submits the task
+	FileStatus = "status" // State as JSON, workers write the new state
+	FileOutput = "output" // Result, appended by the worker
+	FileLog    = "log"    // Progress, appended by the worker and on state changes
+	FileCancel = "cancel" // Writing anything cancels the task
+)
+
+// Files of the queue directory
+const (
+	FileDequeue = "dequeue" // Reading claims the next pending task
+	FilePeek    = "peek"    // Reading shows the next pending task
+	FileSize    = "size"    // Number of pending tasks
+)
+
+// Meta values for AgentFS plugin
+const (
+	MetaValueTask    = "task"    // Task directories
+	MetaValueControl = "control" // Input, status, cancel and queue files
+	MetaValueStream  = "stream"  // Output and log, followed with stream reads
+)
+
+var taskFiles = []string{FileInput, FileStatus, FileOutput, FileLog, FileCancel}
+
+var queueFiles = []string{FileDequeue, FilePeek, FileSize}
+
+// AgentFSPlugin runs agent tasks through files: clients submit a task spec, workers
+// claim it from a queue and append the output that clients follow while it is produced
+type AgentFSPlugin struct {
+	fs *agentFS
+}
+
+// NewAgentFSPlugin creates a new AgentFS plugin
+func NewAgentFSPlugin() *AgentFSPlugin {
+	p := &AgentFSPlugin{}
+	p.fs = newAgentFS(p)
+	return p
+}
+
+func (p *AgentFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *AgentFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"max_size", "retention", "task_workers", "llm_url", "llm_api_key", "llm_model",
+		"llm_system_prompt", "llm_timeout", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"llm_url", "llm_api_key", "llm_model", "llm_system_prompt"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if err := config.ValidateIntType(cfg, "task_workers"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "task_workers", 1) < 1 {
+		return fmt.Errorf("task_workers must be at least 1")
+	}
+	if size, err := config.GetSizeConfig(cfg, "max_size", DefaultMaxSize); err != nil {
+		return err
+	} else if size <= 0 {
+		return fmt.Errorf("max_size must be positive")
+	}
+	if retention, err := config.GetDurationConfig(cfg, "retention", DefaultRetention); err != nil {
+		return err
+	} else if retention < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+	if _, err := config.GetDurationConfig(cfg, "llm_timeout", DefaultLLMTimeout); err != nil {
+		return err
+	}
+	if config.GetStringConfig(cfg, "llm_url", "") != "" && config.GetStringConfig(cfg, "llm_model", "") == "" {
+		return fmt.Errorf("llm_model is required with llm_url")
+	}
+	return nil
+}
+
+func (p *AgentFSPlugin) Initialize(cfg map[string]interface{}) error {
+	maxSize, err := config.GetSizeConfig(cfg, "max_size", DefaultMaxSize)
+	if err != nil {
+		return err
+	}
+	retention, err := config.GetDurationConfig(cfg, "retention", DefaultRetention)
+	if err != nil {
+		return err
+	}
+	timeout, err := config.GetDurationConfig(cfg, "llm_timeout", DefaultLLMTimeout)
+	if err != nil {
+		return err
+	}
+	p.fs.maxSize = maxSize
+	p.fs.retention = retention
+
+	if url := config.GetStringConfig(cfg, "llm_url", ""); url != "" {
+		backend := newLLMBackend(url, config.GetStringConfig(cfg, "llm_api_key", ""),
+			config.GetStringConfig(cfg, "llm_model", ""), config.GetStringConfig(cfg, "llm_system_prompt", ""), timeout)
+		workers := config.GetIntConfig(cfg, "task_workers", 1)
+		p.fs.startWorkers(backend, workers)
+		log.Infof("[agentfs] %d built-in worker(s) using %s", workers, backend.url)
+	}
+	if retention > 0 {
+		p.fs.startGC(gcInterval)
+	}
+	return nil
+}
+
+func (p *AgentFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *AgentFSPlugin) GetReadme() string {
+	return `AgentFS Plugin - Agent Tasks as Files
+
+Submit a task by writing its spec, let a worker claim it from a queue, and follow
+its output and log while they are produced.
+
+CONFIGURATION:
+  max_size           - Limit of the input, output and log of a task (default "16MB")
+  retention          - How long finished tasks are kept, 0 keeps them (default "24h")
+  llm_url            - OpenAI-compatible API, e.g. "https://api.openai.com/v1"; enables
+                       built-in workers that send each input to the model
+  llm_model          - Model used by the built-in workers
+  llm_api_key        - API key of llm_url
+  llm_system_prompt  - System prompt of every task
+  llm_timeout        - Time limit of one task (default "10m")
+  task_workers       - Number of built-in workers (default 1)
+
+STRUCTURE:
+  /README
+  /tasks/<id>/input    - Task spec; writing it submits the task (once)
+  /tasks/<id>/status   - State as JSON; workers write "running", "succeeded" or
+                         "failed", optionally followed by a message
+  /tasks/<id>/output   - Result; every write appends, stream it to follow
+  /tasks/<id>/log      - Progress; every write appends, state changes are logged too
+  /tasks/<id>/cancel   - Write anything to cancel the task
+  /queue/dequeue       - Read to claim the next pending task ({} if none)
+  /queue/peek          - Read the next pending task without claiming it
+  /queue/size          - Number of pending tasks
+
+STATES:
+  new -> pending -> running -> succeeded | failed
+  pending or running tasks can be canceled; a worker writing to a canceled task
+  gets "permission denied" and should stop.
+
+TASK SPEC (built-in workers):
+  Plain text is sent as the prompt. JSON may set fields:
+  {"prompt": "...", "system": "...", "model": "..."}
+
+EXAMPLES:
+  agfs:/> echo "Summarize the release notes" > /agentfs/tasks/t1/input
+  agfs:/> cat /agentfs/queue/dequeue            # in an external worker
+  {"id":"t1","data":"Summarize the release notes\n","timestamp":"..."}
+  agfs:/> echo "chunk of the answer" > /agentfs/tasks/t1/output
+  agfs:/> echo succeeded > /agentfs/tasks/t1/status
+
+  $ curl "http://localhost:8080/api/v1/files?path=/agentfs/tasks/t1/output&stream=true"
+  agfs:/> echo 1 > /agentfs/tasks/t1/cancel
+  agfs:/> rm -r /agentfs/tasks/t1
+`
+}
+
+func (p *AgentFSPlugin) Shutdown() error {
+	p.fs.stop()
+	return nil
+}
+
+// agentFS holds the tasks in memory
+type agentFS struct {
+	plugin    *AgentFSPlugin
+	maxSize   int64
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending *sync.Cond // Signaled when a task is queued or the file system stops
+	tasks   map[string]*task
+	queue   []*task // Pending tasks, oldest first
+	stopped bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newAgentFS(p *AgentFSPlugin) *agentFS {
+	fs := &agentFS{
+		plugin:    p,
+		maxSize:   DefaultMaxSize,
+		retention: DefaultRetention,
+		tasks:     make(map[string]*task),
+		done:      make(chan struct{}),
+	}
+	fs.pending = sync.NewCond(&fs.mu)
+	return fs
+}
+
+// parsePath returns the elements of p
+func parsePath(p string) []string {
+	p = strings.Trim(filesystem.NormalizePath(p), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func isTaskFile(name string) bool {
+	for _, f := range taskFiles {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isQueueFile(name string) bool {
+	for _, f := range queueFiles {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the task of a /tasks/<id>[/<file>] path and the file, which is empty
+// for the task directory. The caller holds fs.mu
+func (fs *agentFS) lookup(op, p string) (*task, string, error) {
+	parts := parsePath(p)
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "tasks" {
+		return nil, "", filesystem.NewNotFoundError(op, p)
+	}
+	t, ok := fs.tasks[parts[1]]
+	if !ok {
+		return nil, "", filesystem.NewNotFoundError(op, p)
+	}
+	if len(parts) == 3 {
+		if !isTaskFile(parts[2]) {
+			return nil, "", filesystem.NewNotFoundError(op, p)
+		}
+		return t, parts[2], nil
+	}
+	return t, "", nil
+}
+
+// createTask creates the task of a /tasks/<id>[/<file>] path unless it exists.
+// The caller holds fs.mu
+func (fs *agentFS) createTask(op, p string) (*task, string, error) {
+	parts := parsePath(p)
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "tasks" {
+		return nil, "", filesystem.NewPermissionDeniedError(op, p, "tasks can only be created in /tasks")
+	}
+	if len(parts) == 3 && !isTaskFile(parts[2]) {
+		return nil, "", filesystem.NewPermissionDeniedError(op, p, "task directories only hold "+strings.Join(taskFiles, ", "))
+	}
+	t, ok := fs.tasks[parts[1]]
+	if !ok {
+		if fs.stopped {
+			return nil, "", filesystem.NewUnavailableError(op, p, "agentfs is shut down")
+		}
+		t = newTask(parts[1], time.Now())
+		fs.tasks[t.id] = t
+	}
+	if len(parts) == 3 {
+		return t, parts[2], nil
+	}
+	return t, "", nil
+}
+
+// event appends a line about a state change to the log of t. The caller holds fs.mu
+func (fs *agentFS) event(t *task, format string, args ...interface{}) {
+	line := time.Now().UTC().Format(time.RFC3339) + " " + fmt.Sprintf(format, args...) + "\n"
+	if int64(len(t.log.data)+len(line)) <= fs.maxSize {
+		t.log.data = append(t.log.data, line...)
+		t.log.modTime = time.Now()
+		t.log.notify()
+	}
+}
+
+// submit queues t with input. The caller holds fs.mu
+func (fs *agentFS) submit(t *task, input []byte) error {
+	if t.state != StateNew {
+		return filesystem.NewPermissionDeniedError("write", "/tasks/"+t.id+"/"+FileInput, "task is already "+t.state)
+	}
+	t.input = append([]byte(nil), input...)
+	t.setState(StatePending, "")
+	fs.queue = append(fs.queue, t)
+	fs.event(t, "submitted (%d bytes)", len(input))
+	fs.pending.Signal()
+	return nil
+}
+
+// unqueue removes t from the pending tasks. The caller holds fs.mu
+func (fs *agentFS) unqueue(t *task) {
+	for i, queued := range fs.queue {
+		if queued == t {
+			fs.queue = append(fs.queue[:i], fs.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// claim marks the oldest pending task as running, returning nil if there is none.
+// The caller holds fs.mu
+func (fs *agentFS) claim(worker string) *task {
+	if len(fs.queue) == 0 {
+		return nil
+	}
+	t := fs.queue[0]
+	fs.queue = fs.queue[1:]
+	t.setState(StateRunning, "")
+	fs.event(t, "claimed by %s", worker)
+	return t
+}
+
+// cancelTask cancels t unless it finished. The caller holds fs.mu
+func (fs *agentFS) cancelTask(t *task, reason string) {
+	if finished(t.state) {
+		return
+	}
+	if t.state == StatePending {
+		fs.unqueue(t)
+	}
+	fs.event(t, "canceled: %s", reason)
+	t.setState(StateCanceled, reason)
+}
+
+// report applies a state written to the status file by a worker. The caller holds fs.mu
+func (fs *agentFS) report(t *task, p string, data []byte) error {
+	text := strings.TrimSpace(string(data))
+	state, message := text, ""
+	if i := strings.IndexAny(text, " \t\n:"); i >= 0 {
+		state, message = text[:i], strings.TrimSpace(strings.TrimLeft(text[i:], ": \t\n"))
+	}
+	switch state {
+	case StateRunning, StateSucceeded, StateFailed:
+	default:
+		return filesystem.NewInvalidArgumentError("state", state, "workers report running, succeeded or failed")
+	}
+	if t.state == StatePending && state == StateRunning {
+		// A worker that knows the task claims it without the queue
+		fs.unqueue(t)
+		t.setState(StateRunning, message)
+		fs.event(t, "claimed by a worker")
+		return nil
+	}
+	if t.state != StateRunning {
+		return filesystem.NewPermissionDeniedError("write", p, "task is "+t.state)
+	}
+	if state == StateRunning {
+		t.message = message
+		t.updated = time.Now()
+		return nil
+	}
+	if message != "" {
+		fs.event(t, "%s: %s", state, message)
+	} else {
+		fs.event(t, "%s", state)
+	}
+	t.setState(state, message)
+	return nil
+}
+
+// appendLog appends data to the output or log of a running task. The caller holds fs.mu
+func (fs *agentFS) appendLog(t *task, l *taskLog, p string, data []byte) error {
+	if t.state != StateRunning {
+		return filesystem.NewPermissionDeniedError("write", p, "task is "+t.state)
+	}
+	if used := int64(len(l.data)); used+int64(len(data)) > fs.maxSize {
+		return filesystem.NewQuotaExceededError(p, fs.maxSize, used)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	l.data = append(l.data, data...)
+	l.modTime = time.Now()
+	l.notify()
+	return nil
+}
+
+// queueMessage is a pending task as returned by the queue files, like a queuefs message
+type queueMessage struct {
+	ID        string    `json:"id"`
+	Data      string    `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func queueEntry(t *task) ([]byte, error) {
+	if t == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(queueMessage{ID: t.id, Data: string(t.input), Timestamp: t.created})
+}
+
+func (fs *agentFS) Create(p string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, _, err := fs.createTask("create", p)
+	return err
+}
+
+func (fs *agentFS) Mkdir(p string, perm uint32) error {
+	parts := parsePath(p)
+	if len(parts) != 2 || parts[0] != "tasks" {
+		return filesystem.NewPermissionDeniedError("mkdir", p, "only task directories can be created, in /tasks")
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.tasks[parts[1]]; ok {
+		return filesystem.NewAlreadyExistsError("task", p)
+	}
+	_, _, err := fs.createTask("mkdir", p)
+	return err
+}
+
+// Touch implements filesystem.Toucher without rewriting the appended files
+func (fs *agentFS) Touch(p string) error {
+	return fs.Create(p)
+}
+
+func (fs *agentFS) Remove(p string) error {
+	return fs.RemoveAll(p)
+}
+
+// RemoveAll removes a task, canceling it if it did not finish
+func (fs *agentFS) RemoveAll(p string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, file, err := fs.lookup("remove", p)
+	if err != nil {
+		if parts := parsePath(p); len(parts) == 2 && parts[0] == "tasks" {
+			return err
+		}
+		return filesystem.NewPermissionDeniedError("remove", p, "only task directories can be removed")
+	}
+	if file != "" {
+		return filesystem.NewPermissionDeniedError("remove", p, "remove the task directory instead")
+	}
+	fs.cancelTask(t, "removed")
+	t.removed = true
+	delete(fs.tasks, t.id)
+	return nil
+}
+
+func (fs *agentFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// content returns the whole content of a file; reading dequeue claims a task
+func (fs *agentFS) content(p string) ([]byte, error) {
+	parts := parsePath(p)
+	if len(parts) == 1 && parts[0] == "README" {
+		return []byte(fs.plugin.GetReadme()), nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if len(parts) == 2 && parts[0] == "queue" {
+		switch parts[1] {
+		case FileDequeue:
+			return queueEntry(fs.claim("a worker"))
+		case FilePeek:
+			if len(fs.queue) == 0 {
+				return queueEntry(nil)
+			}
+			return queueEntry(fs.queue[0])
+		case FileSize:
+			return []byte(strconv.Itoa(len(fs.queue))), nil
+		}
+	}
+
+	t, file, err := fs.lookup("read", p)
+	if err != nil {
+		if _, statErr := fs.stat(p); statErr == nil {
+			return nil, fmt.Errorf("is a directory: %s", p)
+		}
+		return nil, err
+	}
+	switch file {
+	case "":
+		return nil, fmt.Errorf("is a directory: %s", p)
+	case FileInput:
+		return t.input, nil
+	case FileStatus:
+		data, err := json.MarshalIndent(t.status(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FileOutput:
+		return t.output.data, nil
+	case FileLog:
+		return t.log.data, nil
+	}
+	return nil, nil
+}
+
+func (fs *agentFS) Write(p string, data []byte) ([]byte, error) {
+	if int64(len(data)) > fs.maxSize {
+		return nil, filesystem.NewQuotaExceededError(p, fs.maxSize, 0)
+	}
+	if parts := parsePath(p); len(parts) == 2 && parts[0] == "queue" && isQueueFile(parts[1]) {
+		return nil, filesystem.NewPermissionDeniedError("write", p, "queue files are read-only")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var t *task
+	var file string
+	var err error
+	if strings.HasSuffix(p, "/"+FileInput) {
+		t, file, err = fs.createTask("write", p)
+	} else {
+		t, file, err = fs.lookup("write", p)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch file {
+	case FileInput:
+		err = fs.submit(t, data)
+	case FileStatus:
+		err = fs.report(t, p, data)
+	case FileOutput:
+		err = fs.appendLog(t, &t.output, p, data)
+	case FileLog:
+		err = fs.appendLog(t, &t.log, p, data)
+	case FileCancel:
+		if finished(t.state) {
+			err = filesystem.NewPermissionDeniedError("cancel", p, "task is already "+t.state)
+		} else {
+			reason := strings.TrimSpace(string(data))
+			if reason == "" || reason == "1" {
+				reason = "by request"
+			}
+			fs.cancelTask(t, reason)
+		}
+	default:
+		err = fmt.Errorf("is a directory: %s", p)
+	}
+	return nil, err
+}
+
+func (fs *agentFS) dirInfo(name, metaType string, modTime time.Time) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    name,
+		Mode:    0755,
+		ModTime: modTime,
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: metaType},
+	}
+}
+
+// taskInfo describes the directory of t. The caller holds fs.mu
+func (fs *agentFS) taskInfo(t *task) filesystem.FileInfo {
+	info := fs.dirInfo(t.id, MetaValueTask, t.updated)
+	info.Meta.Content = map[string]string{"state": t.state}
+	return info
+}
+
+// fileInfo describes a file of t. The caller holds fs.mu
+func (fs *agentFS) fileInfo(t *task, name string) filesystem.FileInfo {
+	info := filesystem.FileInfo{
+		Name:    name,
+		Mode:    0644,
+		ModTime: t.updated,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueControl},
+	}
+	switch name {
+	case FileInput:
+		info.Size = int64(len(t.input))
+		info.ModTime = t.created
+		if t.state != StateNew {
+			info.Mode = 0444
+		}
+	case FileStatus:
+		if data, err := json.MarshalIndent(t.status(), "", "  "); err == nil {
+			info.Size = int64(len(data) + 1)
+		}
+	case FileOutput:
+		info.Size = int64(len(t.output.data))
+		info.ModTime = t.output.modTime
+		info.Meta.Type = MetaValueStream
+	case FileLog:
+		info.Size = int64(len(t.log.data))
+		info.ModTime = t.log.modTime
+		info.Meta.Type = MetaValueStream
+	case FileCancel:
+		info.Mode = 0222
+	}
+	return info
+}
+
+func (fs *agentFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	parts := parsePath(p)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch {
+	case len(parts) == 0:
+		now := time.Now()
+		return []filesystem.FileInfo{
+			{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+				Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}},
+			fs.dirInfo("tasks", "dir", now),
+			fs.dirInfo("queue", "dir", now),
+		}, nil
+	case len(parts) == 1 && parts[0] == "tasks":
+		tasks := make([]*task, 0, len(fs.tasks))
+		for _, t := range fs.tasks {
+			tasks = append(tasks, t)
+		}
+		sort.Slice(tasks, func(i, j int) bool {
+			if !tasks[i].created.Equal(tasks[j].created) {
+				return tasks[i].created.Before(tasks[j].created)
+			}
+			return tasks[i].id < tasks[j].id
+		})
+		infos := make([]filesystem.FileInfo, 0, len(tasks))
+		for _, t := range tasks {
+			infos = append(infos, fs.taskInfo(t))
+		}
+		return infos, nil
+	case len(parts) == 1 && parts[0] == "queue":
+		infos := make([]filesystem.FileInfo, 0, len(queueFiles))
+		for _, name := range queueFiles {
+			info, _ := fs.stat("/queue/" + name)
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+
+	t, file, err := fs.lookup("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+	infos := make([]filesystem.FileInfo, 0, len(taskFiles))
+	for _, name := range taskFiles {
+		infos = append(infos, fs.fileInfo(t, name))
+	}
+	return infos, nil
+}
+
+func (fs *agentFS) Stat(p string) (*filesystem.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.stat(p)
+}
+
+// stat describes p. The caller holds fs.mu
+func (fs *agentFS) stat(p string) (*filesystem.FileInfo, error) {
+	parts := parsePath(p)
+	now := time.Now()
+	switch {
+	case len(parts) == 0:
+		info := fs.dirInfo("/", "root", now)
+		return &info, nil
+	case len(parts) == 1 && parts[0] == "README":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case len(parts) == 1 && (parts[0] == "tasks" || parts[0] == "queue"):
+		info := fs.dirInfo(parts[0], "dir", now)
+		return &info, nil
+	case len(parts) == 2 && parts[0] == "queue" && isQueueFile(parts[1]):
+		info := &filesystem.FileInfo{Name: parts[1], Mode: 0444, ModTime: now,
+			Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueControl}}
+		if len(fs.queue) > 0 {
+			info.ModTime = fs.queue[len(fs.queue)-1].updated
+		}
+		if parts[1] == FileSize {
+			info.Size = int64(len(strconv.Itoa(len(fs.queue))))
+		}
+		return info, nil
+	}
+
+	t, file, err := fs.lookup("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	var info filesystem.FileInfo
+	if file == "" {
+		info = fs.taskInfo(t)
+	} else {
+		info = fs.fileInfo(t, file)
+	}
+	return &info, nil
+}
+
+func (fs *agentFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *agentFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *agentFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *agentFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if strings.HasSuffix(p, "/"+FileOutput) || strings.HasSuffix(p, "/"+FileLog) {
+		fs.mu.Lock()
+		_, _, err := fs.lookup("open", p)
+		fs.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return &logWriter{fs: fs, path: p}, nil
+	}
+	return &bufferedWriter{fs: fs, path: p}, nil
+}
+
+// OpenStream implements filesystem.Streamer for the output and log of a task, which
+// are read from the start and followed until the task finishes
+func (fs *agentFS) OpenStream(p string) (filesystem.StreamReader, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, file, err := fs.lookup("stream", p)
+	if err != nil {
+		return nil, err
+	}
+	switch file {
+	case FileOutput:
+		return &logReader{fs: fs, task: t, log: &t.output}, nil
+	case FileLog:
+		return &logReader{fs: fs, task: t, log: &t.log}, nil
+	}
+	return nil, filesystem.NewNotSupportedError("stream", p)
+}
+
+// startWorkers starts n workers running the pending tasks on backend
+func (fs *agentFS) startWorkers(backend *llmBackend, n int) {
+	for i := 0; i < n; i++ {
+		fs.wg.Add(1)
+		go func(worker string) {
+			defer fs.wg.Done()
+			for {
+				t, ctx := fs.next(worker)
+				if t == nil {
+					return
+				}
+				fs.run(ctx, backend, t)
+			}
+		}(fmt.Sprintf("built-in worker %d", i+1))
+	}
+}
+
+// next waits for a pending task and claims it, returning nil once the file system stops
+func (fs *agentFS) next(worker string) (*task, context.Context) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for len(fs.queue) == 0 && !fs.stopped {
+		fs.pending.Wait()
+	}
+	if fs.stopped {
+		return nil, nil
+	}
+	t := fs.claim(worker)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	return t, ctx
+}
+
+// run runs t on backend and records the outcome unless the task was canceled meanwhile
+func (fs *agentFS) run(ctx context.Context, backend *llmBackend, t *task) {
+	p := "/tasks/" + t.id + "/" + FileOutput
+	err := backend.run(ctx, t.input, func(data []byte) error {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		return fs.appendLog(t, &t.output, p, data)
+	}, func(format string, args ...interface{}) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		fs.event(t, format, args...)
+	})
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if t.state != StateRunning {
+		return
+	}
+	if err != nil {
+		log.Warnf("[agentfs] task %s failed: %v", t.id, err)
+		fs.event(t, "failed: %v", err)
+		t.setState(StateFailed, err.Error())
+		return
+	}
+	fs.event(t, "succeeded (%d bytes of output)", len(t.output.data))
+	t.setState(StateSucceeded, "")
+}
+
+// startGC removes finished tasks past their retention every interval
+func (fs *agentFS) startGC(interval time.Duration) {
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fs.done:
+				return
+			case now := <-ticker.C:
+				fs.gc(now)
+			}
+		}
+	}()
+}
+
+// gc removes the tasks that finished more than the retention before now
+func (fs *agentFS) gc(now time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for id, t := range fs.tasks {
+		if finished(t.state) && now.Sub(t.finished) >= fs.retention {
+			t.removed = true
+			delete(fs.tasks, id)
+			log.Debugf("[agentfs] removed finished task %s", id)
+		}
+	}
+}
+
+// stop stops the workers and the GC, canceling the running tasks of built-in workers
+func (fs *agentFS) stop() {
+	fs.mu.Lock()
+	if fs.stopped {
+		fs.mu.Unlock()
+		return
+	}
+	fs.stopped = true
+	for _, t := range fs.tasks {
+		if t.cancel != nil {
+			fs.cancelTask(t, "server shutdown")
+		}
+	}
+	fs.pending.Broadcast()
+	close(fs.done)
+	fs.mu.Unlock()
+	fs.wg.Wait()
+}
+
+// Ensure AgentFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*AgentFSPlugin)(nil)
+var _ filesystem.FileSystem = (*agentFS)(nil)
+var _ filesystem.Streamer = (*agentFS)(nil)
+var _ filesystem.Toucher = (*agentFS)(nil)
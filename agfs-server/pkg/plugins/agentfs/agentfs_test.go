@@ -0,0 +1,170 @@
+package agentfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestPlugin(t *testing.T, cfg map[string]interface{}) *agentFS {
+	t.Helper()
+	p := NewAgentFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Shutdown() })
+	return p.fs
+}
+
+func state(t *testing.T, fs *agentFS, id string) taskStatus {
+	t.Helper()
+	data, err := fs.Read("/tasks/"+id+"/status", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	var s taskStatus
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// follow reads a stream until it ends
+func follow(t *testing.T, fs *agentFS, p string) string {
+	t.Helper()
+	r, err := fs.OpenStream(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var out strings.Builder
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		data, eof, err := r.ReadChunk(100 * time.Millisecond)
+		out.Write(data)
+		if eof {
+			return out.String()
+		}
+		if err != nil && err.Error() != "read timeout" {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("stream %s did not end", p)
+	return ""
+}
+
+func TestExternalWorker(t *testing.T) {
+	fs := newTestPlugin(t, map[string]interface{}{})
+
+	if _, err := fs.Write("/tasks/t1/input", []byte("summarize")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/tasks/t1/input", []byte("again")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("second submit: %v", err)
+	}
+	if _, err := fs.Write("/tasks/t1/output", []byte("early")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("output of a pending task: %v", err)
+	}
+
+	data, _ := fs.Read("/queue/dequeue", 0, -1)
+	var msg queueMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.ID != "t1" || msg.Data != "summarize" {
+		t.Fatalf("dequeue = %s (%v)", data, err)
+	}
+	if data, _ := fs.Read("/queue/dequeue", 0, -1); string(data) != "{}" {
+		t.Errorf("dequeue of an empty queue = %s", data)
+	}
+	if s := state(t, fs, "t1"); s.State != StateRunning {
+		t.Errorf("state after dequeue = %s", s.State)
+	}
+
+	done := make(chan string)
+	go func() { done <- follow(t, fs, "/tasks/t1/output") }()
+	fs.Write("/tasks/t1/output", []byte("part 1, "))
+	fs.Write("/tasks/t1/output", []byte("part 2"))
+	if _, err := fs.Write("/tasks/t1/status", []byte("succeeded")); err != nil {
+		t.Fatal(err)
+	}
+	if out := <-done; out != "part 1, part 2" {
+		t.Errorf("followed output = %q", out)
+	}
+	if _, err := fs.Write("/tasks/t1/cancel", []byte("1")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("cancel of a finished task: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	fs := newTestPlugin(t, map[string]interface{}{})
+
+	fs.Write("/tasks/pending/input", []byte("a"))
+	fs.Write("/tasks/running/input", []byte("b"))
+	fs.Write("/tasks/pending/cancel", []byte("1"))
+	if data, _ := fs.Read("/queue/size", 0, -1); string(data) != "1" {
+		t.Errorf("queue size after canceling a pending task = %s", data)
+	}
+	fs.Read("/queue/dequeue", 0, -1)
+	fs.Write("/tasks/running/cancel", []byte("no longer needed"))
+
+	s := state(t, fs, "running")
+	if s.State != StateCanceled || s.Message != "no longer needed" {
+		t.Errorf("status = %+v", s)
+	}
+	// The worker learns about the cancellation from its next write
+	if _, err := fs.Write("/tasks/running/output", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("output of a canceled task: %v", err)
+	}
+}
+
+func TestLLMWorker(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/chat/completions" || !strings.Contains(string(body), `"content":"say hi"`) {
+			http.Error(w, "unexpected request "+r.URL.Path+" "+string(body), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{"Hi", " there"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word)
+			w.(http.Flusher).Flush()
+		}
+		if strings.Contains(string(body), "slow") {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	fs := newTestPlugin(t, map[string]interface{}{"llm_url": server.URL + "/v1", "llm_model": "test", "task_workers": 2})
+	fs.Write("/tasks/t1/input", []byte(`{"prompt": "say hi"}`))
+	if out := follow(t, fs, "/tasks/t1/output"); out != "Hi there" {
+		t.Errorf("output = %q", out)
+	}
+	if s := state(t, fs, "t1"); s.State != StateSucceeded {
+		t.Errorf("status = %+v", s)
+	}
+
+	// Canceling stops the request of a built-in worker
+	fs.Write("/tasks/t2/input", []byte(`{"prompt": "say hi", "system": "slow"}`))
+	r, _ := fs.OpenStream("/tasks/t2/output")
+	r.ReadChunk(5 * time.Second)
+	r.Close()
+	fs.Write("/tasks/t2/cancel", []byte("1"))
+	if s := state(t, fs, "t2"); s.State != StateCanceled {
+		t.Errorf("status = %+v", s)
+	}
+}
@@ -0,0 +1,167 @@
+package agentfs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultLLMTimeout is the time limit of one task run by a built-in worker
+const DefaultLLMTimeout = 10 * time.Minute
+
+// llmBackend runs tasks on an OpenAI-compatible chat completions API
+type llmBackend struct {
+	url          string // Chat completions endpoint
+	apiKey       string
+	model        string
+	systemPrompt string
+	timeout      time.Duration
+	client       *http.Client
+}
+
+func newLLMBackend(url, apiKey, model, systemPrompt string, timeout time.Duration) *llmBackend {
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/chat/completions") {
+		url += "/chat/completions"
+	}
+	return &llmBackend{
+		url:          url,
+		apiKey:       apiKey,
+		model:        model,
+		systemPrompt: systemPrompt,
+		timeout:      timeout,
+		client:       &http.Client{},
+	}
+}
+
+// taskSpec is a JSON task input; any other input is the prompt
+type taskSpec struct {
+	Prompt string `json:"prompt"`
+	System string `json:"system"`
+	Model  string `json:"model"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// chatChunk holds the fields used of streamed and complete responses
+type chatChunk struct {
+	Choices []struct {
+		Delta   chatMessage `json:"delta"`
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// run sends input to the model, passing the answer to emit as it streams in and
+// progress to logf
+func (b *llmBackend) run(ctx context.Context, input []byte, emit func([]byte) error, logf func(string, ...interface{})) error {
+	spec := taskSpec{Prompt: string(input)}
+	if trimmed := bytes.TrimSpace(input); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &spec); err != nil {
+			return fmt.Errorf("invalid task spec: %v", err)
+		}
+	}
+	if strings.TrimSpace(spec.Prompt) == "" {
+		return fmt.Errorf("task spec has no prompt")
+	}
+	req := chatRequest{Model: b.model, Stream: true}
+	if spec.Model != "" {
+		req.Model = spec.Model
+	}
+	if system := spec.System; system != "" || b.systemPrompt != "" {
+		if system == "" {
+			system = b.systemPrompt
+		}
+		req.Messages = append(req.Messages, chatMessage{Role: "system", Content: system})
+	}
+	req.Messages = append(req.Messages, chatMessage{Role: "user", Content: spec.Prompt})
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	if b.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
+	}
+	logf("sending %d bytes to %s (model %s)", len(spec.Prompt), b.url, req.Model)
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("model API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	// Servers that ignore stream answer with one JSON response
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var chunk chatChunk
+		if err := json.NewDecoder(resp.Body).Decode(&chunk); err != nil {
+			return fmt.Errorf("invalid model response: %v", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("model API error: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) == 0 {
+			return fmt.Errorf("model response has no choices")
+		}
+		return emit([]byte(chunk.Choices[0].Message.Content))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("invalid model stream event: %v", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("model API error: %s", chunk.Error.Message)
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				if err := emit([]byte(choice.Delta.Content)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package agentfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Task states
+const (
+	StateNew       = "new"       // Created, the input has not been written yet
+	StatePending   = "pending"   // Submitted, waiting for a worker
+	StateRunning   = "running"   // Claimed by a worker
+	StateSucceeded = "succeeded" // Finished by the worker
+	StateFailed    = "failed"    // Given up by the worker
+	StateCanceled  = "canceled"  // Canceled through the cancel file
+)
+
+// finished checks if a task in state will not change anymore
+func finished(state string) bool {
+	return state == StateSucceeded || state == StateFailed || state == StateCanceled
+}
+
+// taskLog is an append-only file of a task that readers can follow
+type taskLog struct {
+	data    []byte
+	modTime time.Time
+	changed chan struct{} // Closed and replaced when data is appended or the task finishes
+}
+
+func newTaskLog(now time.Time) taskLog {
+	return taskLog{modTime: now, changed: make(chan struct{})}
+}
+
+// notify wakes the readers waiting for more data
+func (l *taskLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// task is a job submitted through its input file
+type task struct {
+	id       string
+	state    string
+	message  string // Last message of the worker, e.g. the reason of a failure
+	input    []byte
+	output   taskLog
+	log      taskLog
+	created  time.Time
+	updated  time.Time // Last state change
+	started  time.Time
+	finished time.Time
+	removed  bool
+	cancel   context.CancelFunc // Stops the built-in worker running the task
+}
+
+func newTask(id string, now time.Time) *task {
+	return &task{
+		id:      id,
+		state:   StateNew,
+		output:  newTaskLog(now),
+		log:     newTaskLog(now),
+		created: now,
+		updated: now,
+	}
+}
+
+// setState moves the task to state, waking the followers of its output and log once it
+// finishes. The caller holds the lock of the file system
+func (t *task) setState(state, message string) {
+	now := time.Now()
+	t.state = state
+	t.message = message
+	t.updated = now
+	switch {
+	case state == StateRunning && t.started.IsZero():
+		t.started = now
+	case finished(state):
+		t.finished = now
+		if t.cancel != nil {
+			t.cancel()
+			t.cancel = nil
+		}
+		t.output.notify()
+		t.log.notify()
+	}
+}
+
+// taskStatus is the content of the status file
+type taskStatus struct {
+	ID         string     `json:"id"`
+	State      string     `json:"state"`
+	Message    string     `json:"message,omitempty"`
+	Created    time.Time  `json:"created"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	InputSize  int        `json:"inputSize"`
+	OutputSize int        `json:"outputSize"`
+}
+
+func (t *task) status() taskStatus {
+	s := taskStatus{
+		ID:         t.id,
+		State:      t.state,
+		Message:    t.message,
+		Created:    t.created,
+		InputSize:  len(t.input),
+		OutputSize: len(t.output.data),
+	}
+	if !t.started.IsZero() {
+		started := t.started
+		s.Started = &started
+	}
+	if !t.finished.IsZero() {
+		finished := t.finished
+		s.Finished = &finished
+	}
+	return s
+}
+
+// logReader follows the output or log of a task until the task finishes
+type logReader struct {
+	fs     *agentFS
+	task   *task
+	log    *taskLog
+	offset int
+}
+
+// ReadChunk implements filesystem.StreamReader
+func (r *logReader) ReadChunk(timeout time.Duration) ([]byte, bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.fs.mu.Lock()
+		if r.offset < len(r.log.data) {
+			// Appends never change written bytes, so the chunk can share the buffer
+			chunk := r.log.data[r.offset:len(r.log.data):len(r.log.data)]
+			r.offset = len(r.log.data)
+			r.fs.mu.Unlock()
+			return chunk, false, nil
+		}
+		if finished(r.task.state) || r.task.removed {
+			r.fs.mu.Unlock()
+			return nil, true, io.EOF
+		}
+		changed := r.log.changed
+		r.fs.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, false, errors.New("read timeout")
+		}
+	}
+}
+
+// Close implements filesystem.StreamReader
+func (r *logReader) Close() error {
+	return nil
+}
+
+// logWriter appends every write to the output or log of a task, so followers see
+// the data while it is written
+type logWriter struct {
+	fs   *agentFS
+	path string
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	if _, err := w.fs.Write(w.path, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *logWriter) Close() error {
+	return nil
+}
+
+// bufferedWriter writes the whole content to a control file on close
+type bufferedWriter struct {
+	fs   *agentFS
+	path string
+	buf  []byte
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	if int64(len(w.buf)+len(p)) > w.fs.maxSize {
+		return 0, fmt.Errorf("%s: content exceeds %d bytes", w.path, w.fs.maxSize)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *bufferedWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf)
+	return err
+}
@@ -0,0 +1,738 @@
+package configfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/document"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "configfs"
+
+	// SchemaFile binds the JSON schema it holds to its directory and the directories below
+	// it that have no schema of their own
+	SchemaFile = ".schema"
+
+	// HistoryDir lists the versions of every config at the root of the mount
+	HistoryDir = "history"
+
+	// RollbackFile restores the version written to it, in the history directory of a config
+	RollbackFile = "rollback"
+
+	// DefaultMaxHistory is the number of versions kept per config
+	DefaultMaxHistory = 20
+
+	// MetaValueHistory marks the entries of the history directory
+	MetaValueHistory = "history"
+)
+
+// ConfigFSPlugin stores JSON and YAML configs validated against per-directory schemas
+type ConfigFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *configFS
+}
+
+// NewConfigFSPlugin creates a new ConfigFS plugin
+func NewConfigFSPlugin() *ConfigFSPlugin {
+	return &ConfigFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem holding the store
+func (p *ConfigFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *ConfigFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *ConfigFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"store", "max_history", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	store, err := storeConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if mountPath := config.GetStringConfig(cfg, "mount_path", ""); mountPath != "" {
+		mountPath = filesystem.NormalizePath(mountPath)
+		if within(store, mountPath) || within(mountPath, store) {
+			return fmt.Errorf("store %s overlaps the mount path %s", store, mountPath)
+		}
+	}
+	if err := config.ValidateIntType(cfg, "max_history"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "max_history", DefaultMaxHistory) < 1 {
+		return fmt.Errorf("max_history must be at least 1")
+	}
+	return nil
+}
+
+// storeConfig returns the normalized store path of the configuration
+func storeConfig(cfg map[string]interface{}) (string, error) {
+	store := config.GetStringConfig(cfg, "store", "")
+	if store == "" {
+		return "", fmt.Errorf("store is required")
+	}
+	if !strings.HasPrefix(store, "/") {
+		return "", fmt.Errorf("store must be an absolute path: %s", store)
+	}
+	store = filesystem.NormalizePath(store)
+	if store == "/" {
+		return "", fmt.Errorf("store must not be the root")
+	}
+	return store, nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (p *ConfigFSPlugin) Initialize(cfg map[string]interface{}) error {
+	store, err := storeConfig(cfg)
+	if err != nil {
+		return err
+	}
+	p.fs = &configFS{
+		root:       p.rootFS,
+		store:      store,
+		maxHistory: config.GetIntConfig(cfg, "max_history", DefaultMaxHistory),
+		mu:         &sync.Mutex{},
+	}
+	return nil
+}
+
+func (p *ConfigFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *ConfigFSPlugin) GetReadme() string {
+	return `ConfigFS Plugin - Validated Configuration Store
+
+Stores JSON and YAML configuration files, kept in a store path of
+another mount (e.g. sqlfs). A directory is bound to a JSON schema by
+writing the schema to its .schema file; the schema also applies to the
+directories below it without a .schema of their own. Writes of configs
+that do not parse or do not match their schema are rejected with every
+violation listed, e.g.
+
+  schema validation failed: /port: expected integer, got string;
+  /replicas: must be >= 1, got 0
+
+Files ending in .yaml or .yml are YAML, all others JSON. Binding or
+removing a schema is rejected while configs in its scope would break.
+
+CONFIGURATION:
+  store        - Backing path, e.g. "/sqlfs/configfs"
+  max_history  - Versions kept per config (default: 20)
+
+HISTORY:
+  /history/<config>/<n>        - Version n of a config, 1 is the oldest
+  /history/<config>/rollback   - Write a version number to restore it
+
+Every write of a config or schema, including a rollback, creates a new
+version. The history of a removed config is kept, so it can be restored
+by a rollback once its directory exists.
+
+EXAMPLES:
+  agfs:/> mkdir /configfs/api
+  agfs:/> echo '{"type": "object", "required": ["port"], "properties": {"port": {"type": "integer"}}}' > /configfs/api/.schema
+  agfs:/> echo '{"port": "80"}' > /configfs/api/prod.json     # rejected
+  agfs:/> echo '{"port": 80}' > /configfs/api/prod.json
+  agfs:/> ls /configfs/history/api/prod.json
+  agfs:/> echo 1 > /configfs/history/api/prod.json/rollback
+`
+}
+
+func (p *ConfigFSPlugin) Shutdown() error {
+	return nil
+}
+
+// configFS implements the FileSystem interface over the store
+// Configs live in <store>/files, version n of a config in <store>/versions/<config>/<n>
+type configFS struct {
+	root       filesystem.FileSystem
+	store      string
+	maxHistory int
+	mu         *sync.Mutex // Serializes changes; shared with the views returned by WithContext
+}
+
+// WithContext implements filesystem.ContextBinder by binding the root file system
+func (fs *configFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.root = filesystem.WithContext(fs.root, ctx)
+	return &bound
+}
+
+func (fs *configFS) checkRoot() error {
+	if fs.root == nil {
+		return fmt.Errorf("configfs is not mounted")
+	}
+	return nil
+}
+
+// files returns the backing path of the config rel
+func (fs *configFS) files(rel string) string {
+	return path.Join(fs.store, "files", rel)
+}
+
+// versions returns the backing directory of the versions below the config or directory rel
+func (fs *configFS) versions(rel string) string {
+	return path.Join(fs.store, "versions", rel)
+}
+
+// historyRel returns the config path of a path in the history directory
+func historyRel(rel string) (string, bool) {
+	if rel == "/"+HistoryDir {
+		return "/", true
+	}
+	if strings.HasPrefix(rel, "/"+HistoryDir+"/") {
+		return strings.TrimPrefix(rel, "/"+HistoryDir), true
+	}
+	return "", false
+}
+
+func historyDenied(op, rel string) error {
+	return filesystem.NewPermissionDeniedError(op, rel, "history is changed by writing configs and rollbacks")
+}
+
+// schemaFor returns the schema bound to dir, nil if there is none
+func (fs *configFS) schemaFor(dir string) (*document.Schema, error) {
+	for {
+		data, err := fs.root.Read(fs.files(path.Join(dir, SchemaFile)), 0, -1)
+		if err == nil || err == io.EOF {
+			return parseSchema(data)
+		}
+		if !filesystem.IsNotFound(err) {
+			return nil, err
+		}
+		if dir == "/" {
+			return nil, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+func parseSchema(data []byte) (*document.Schema, error) {
+	doc, err := document.Parse(data, document.FormatJSON)
+	if err != nil {
+		return nil, err
+	}
+	return document.CompileSchema(doc.Root)
+}
+
+// validate parses a config and checks it against schema
+func validate(rel string, data []byte, schema *document.Schema) error {
+	format, _ := document.ParseFormat("", rel)
+	doc, err := document.Parse(data, format)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return nil
+	}
+	return schema.Validate(doc.Root)
+}
+
+// checkScope validates the configs below dir that are bound to the schema of dir, e.g. before
+// that schema changes; directories with their own schema are skipped
+func (fs *configFS) checkScope(dir string, schema *document.Schema) error {
+	entries, err := fs.root.ReadDir(fs.files(dir))
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		rel := path.Join(dir, entry.Name)
+		if entry.IsDir {
+			if _, err := fs.root.Stat(fs.files(path.Join(rel, SchemaFile))); err == nil {
+				continue
+			}
+			if err := fs.checkScope(rel, schema); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.Name == SchemaFile {
+			continue
+		}
+		data, err := fs.root.Read(fs.files(rel), 0, -1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err := validate(rel, data, schema); err != nil {
+			return fmt.Errorf("%s would become invalid: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// checkTree validates the config rel, or the configs below the directory rel, against the
+// schemas bound to them; directories below rel with their own schema kept it and are skipped
+func (fs *configFS) checkTree(rel string) error {
+	info, err := fs.root.Stat(fs.files(rel))
+	if err != nil {
+		return err
+	}
+	dir := rel
+	if !info.IsDir {
+		dir = path.Dir(rel)
+	}
+	schema, err := fs.schemaFor(dir)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return fs.checkScope(rel, schema)
+	}
+	data, err := fs.root.Read(fs.files(rel), 0, -1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return validate(rel, data, schema)
+}
+
+// checkParent ensures the directory of rel exists
+func (fs *configFS) checkParent(rel string) error {
+	dir := path.Dir(rel)
+	if dir == "/" {
+		return filesystem.MkdirAll(fs.root, fs.files("/"), 0755)
+	}
+	info, err := fs.root.Stat(fs.files(dir))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return filesystem.NewNotDirectoryError(dir)
+	}
+	return nil
+}
+
+// put validates data and stores it as the next version of rel; fs.mu must be held
+func (fs *configFS) put(rel string, data []byte) (int, error) {
+	if rel == "/" || rel == "/"+HistoryDir {
+		return 0, filesystem.NewInvalidArgumentError("path", rel, "is a directory")
+	}
+	if err := fs.checkParent(rel); err != nil {
+		return 0, err
+	}
+	if info, err := fs.root.Stat(fs.files(rel)); err == nil && info.IsDir {
+		return 0, filesystem.NewInvalidArgumentError("path", rel, "is a directory")
+	}
+
+	if path.Base(rel) == SchemaFile {
+		schema, err := parseSchema(data)
+		if err != nil {
+			return 0, err
+		}
+		if err := fs.checkScope(path.Dir(rel), schema); err != nil {
+			return 0, err
+		}
+	} else {
+		schema, err := fs.schemaFor(path.Dir(rel))
+		if err != nil {
+			return 0, err
+		}
+		if err := validate(rel, data, schema); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := fs.root.Write(fs.files(rel), data); err != nil {
+		return 0, err
+	}
+	return fs.record(rel, data)
+}
+
+// record adds data as the next version of rel and drops the versions beyond maxHistory
+func (fs *configFS) record(rel string, data []byte) (int, error) {
+	dir := fs.versions(rel)
+	if err := filesystem.MkdirAll(fs.root, dir, 0755); err != nil {
+		return 0, err
+	}
+	numbers, err := fs.versionNumbers(rel)
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1] + 1
+	}
+	if _, err := fs.root.Write(path.Join(dir, strconv.Itoa(next)), data); err != nil {
+		return 0, err
+	}
+	numbers = append(numbers, next)
+	for _, n := range numbers[:max(len(numbers)-fs.maxHistory, 0)] {
+		if err := fs.root.Remove(path.Join(dir, strconv.Itoa(n))); err != nil && !filesystem.IsNotFound(err) {
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// versionNumbers returns the sorted version numbers of the config rel, nil if it has none
+// Directories of the history hold only directories, those of configs only versions
+func (fs *configFS) versionNumbers(rel string) ([]int, error) {
+	entries, err := fs.root.ReadDir(fs.versions(rel))
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var numbers []int
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(entry.Name); err == nil && !entry.IsDir {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// rollback restores version n of the config rel as a new version
+func (fs *configFS) rollback(rel string, data []byte) ([]byte, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, filesystem.NewInvalidArgumentError("version", strings.TrimSpace(string(data)), "expected a version number")
+	}
+	content, err := fs.root.Read(path.Join(fs.versions(rel), strconv.Itoa(n)), 0, -1)
+	if err != nil && err != io.EOF {
+		if filesystem.IsNotFound(err) {
+			return nil, filesystem.NewNotFoundError("rollback", fmt.Sprintf("%s version %d", rel, n))
+		}
+		return nil, err
+	}
+	version, err := fs.put(rel, content)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("rolled back %s to version %d as version %d\n", rel, n, version)), nil
+}
+
+func (fs *configFS) Create(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if _, ok := historyRel(rel); ok {
+		return historyDenied("create", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, err := fs.root.Stat(fs.files(rel)); err == nil {
+		return filesystem.NewAlreadyExistsError("file", rel)
+	}
+	_, err := fs.put(rel, []byte{})
+	return err
+}
+
+func (fs *configFS) Mkdir(p string, perm uint32) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" || rel == "/"+HistoryDir {
+		return filesystem.NewAlreadyExistsError("directory", rel)
+	}
+	if _, ok := historyRel(rel); ok {
+		return historyDenied("mkdir", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.checkParent(rel); err != nil {
+		return err
+	}
+	return fs.root.Mkdir(fs.files(rel), perm)
+}
+
+func (fs *configFS) Remove(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return filesystem.NewPermissionDeniedError("remove", rel, "the root cannot be changed")
+	}
+	if _, ok := historyRel(rel); ok {
+		return historyDenied("remove", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.checkUnbind(rel); err != nil {
+		return err
+	}
+	return fs.root.Remove(fs.files(rel))
+}
+
+// checkUnbind checks that the configs bound to the schema rel match the parent schema, which
+// they fall back to once rel is removed
+func (fs *configFS) checkUnbind(rel string) error {
+	if path.Base(rel) != SchemaFile {
+		return nil
+	}
+	dir := path.Dir(rel)
+	var parent *document.Schema
+	if dir != "/" {
+		var err error
+		if parent, err = fs.schemaFor(path.Dir(dir)); err != nil {
+			return err
+		}
+	}
+	return fs.checkScope(dir, parent)
+}
+
+func (fs *configFS) RemoveAll(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return filesystem.NewPermissionDeniedError("remove", rel, "the root cannot be changed")
+	}
+	if _, ok := historyRel(rel); ok {
+		return historyDenied("remove", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.checkUnbind(rel); err != nil {
+		return err
+	}
+	return fs.root.RemoveAll(fs.files(rel))
+}
+
+func (fs *configFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	rel := filesystem.NormalizePath(p)
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+	if config, ok := historyRel(rel); ok {
+		if path.Base(config) == RollbackFile {
+			if numbers, _ := fs.versionNumbers(path.Dir(config)); len(numbers) > 0 {
+				return plugin.ApplyRangeRead([]byte{}, offset, size)
+			}
+		}
+		return fs.root.Read(fs.versions(config), offset, size)
+	}
+	return fs.root.Read(fs.files(rel), offset, size)
+}
+
+func (fs *configFS) Write(p string, data []byte) ([]byte, error) {
+	rel := filesystem.NormalizePath(p)
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if config, ok := historyRel(rel); ok {
+		if path.Base(config) == RollbackFile {
+			return fs.rollback(path.Dir(config), data)
+		}
+		return nil, historyDenied("write", rel)
+	}
+	version, err := fs.put(rel, data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("written %s as version %d\n", rel, version)), nil
+}
+
+func (fs *configFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+	config, isHistory := historyRel(rel)
+	backing := fs.files(rel)
+	if isHistory {
+		backing = fs.versions(config)
+	}
+	infos, err := fs.root.ReadDir(backing)
+	if err != nil {
+		if !filesystem.IsNotFound(err) || (rel != "/" && rel != "/"+HistoryDir) {
+			return nil, err
+		}
+		// The store is created by the first write
+		infos = nil
+	}
+
+	switch {
+	case rel == "/":
+		infos = append(infos, *historyDirInfo())
+	case isHistory:
+		numbers, err := fs.versionNumbers(config)
+		if err != nil {
+			return nil, err
+		}
+		for i := range infos {
+			infos[i].Meta = filesystem.MetaData{Name: PluginName, Type: MetaValueHistory}
+		}
+		if len(numbers) > 0 {
+			// Versions sort by number and end with the rollback file
+			sort.Slice(infos, func(a, b int) bool {
+				x, _ := strconv.Atoi(infos[a].Name)
+				y, _ := strconv.Atoi(infos[b].Name)
+				return x < y
+			})
+			infos = append(infos, *rollbackInfo())
+		}
+	}
+	return infos, nil
+}
+
+func historyDirInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    HistoryDir,
+		Mode:    0555,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueHistory},
+	}
+}
+
+func rollbackInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    RollbackFile,
+		Mode:    0222,
+		ModTime: time.Now(),
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueHistory},
+	}
+}
+
+func (fs *configFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return &filesystem.FileInfo{
+			Name:    "/",
+			Mode:    0755,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName},
+		}, nil
+	}
+	if rel == "/"+HistoryDir {
+		return historyDirInfo(), nil
+	}
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+
+	config, isHistory := historyRel(rel)
+	backing := fs.files(rel)
+	if isHistory {
+		if path.Base(config) == RollbackFile {
+			if numbers, _ := fs.versionNumbers(path.Dir(config)); len(numbers) > 0 {
+				return rollbackInfo(), nil
+			}
+		}
+		backing = fs.versions(config)
+	}
+	info, err := fs.root.Stat(backing)
+	if err != nil {
+		if filesystem.IsNotFound(err) {
+			return nil, filesystem.NewNotFoundError("stat", rel)
+		}
+		return nil, err
+	}
+	result := *info
+	result.Name = path.Base(rel)
+	if isHistory {
+		result.Meta = filesystem.MetaData{Name: PluginName, Type: MetaValueHistory}
+	}
+	return &result, nil
+}
+
+func (fs *configFS) Rename(oldPath, newPath string) error {
+	oldRel := filesystem.NormalizePath(oldPath)
+	newRel := filesystem.NormalizePath(newPath)
+	_, oldHistory := historyRel(oldRel)
+	_, newHistory := historyRel(newRel)
+	if oldRel == "/" || oldHistory || newHistory {
+		return historyDenied("rename", oldRel)
+	}
+	if path.Base(oldRel) == SchemaFile || path.Base(newRel) == SchemaFile {
+		return filesystem.NewInvalidArgumentError("path", oldRel, "schemas are bound by writing .schema files, not by renames")
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.checkParent(newRel); err != nil {
+		return err
+	}
+
+	// The moved configs are checked against the schemas of their new place, and moved back
+	// if they do not match
+	if err := fs.root.Rename(fs.files(oldRel), fs.files(newRel)); err != nil {
+		return err
+	}
+	if err := fs.checkTree(newRel); err != nil {
+		if rerr := fs.root.Rename(fs.files(newRel), fs.files(oldRel)); rerr != nil {
+			return fmt.Errorf("%w (moving it back failed: %v)", err, rerr)
+		}
+		return err
+	}
+
+	// History follows the configs
+	if _, err := fs.root.Stat(fs.versions(oldRel)); err == nil {
+		if err := filesystem.MkdirAll(fs.root, path.Dir(fs.versions(newRel)), 0755); err != nil {
+			return err
+		}
+		fs.root.RemoveAll(fs.versions(newRel))
+		if err := fs.root.Rename(fs.versions(oldRel), fs.versions(newRel)); err != nil {
+			return fmt.Errorf("renamed, but moving the history failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (fs *configFS) Chmod(p string, mode uint32) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return filesystem.NewPermissionDeniedError("chmod", rel, "the root cannot be changed")
+	}
+	if _, ok := historyRel(rel); ok {
+		return historyDenied("chmod", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	return fs.root.Chmod(fs.files(rel), mode)
+}
+
+func (fs *configFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+// OpenWrite buffers the config, it is validated and stored on Close
+func (fs *configFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+	return filesystem.NewBufferedWriter(p, fs.Write), nil
+}
+
+// Ensure ConfigFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*ConfigFSPlugin)(nil)
+var _ filesystem.FileSystem = (*configFS)(nil)
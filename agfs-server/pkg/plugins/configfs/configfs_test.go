@@ -0,0 +1,107 @@
+package configfs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func read(mfs *mountablefs.MountableFS, p string) string {
+	data, err := mfs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func TestConfigFS(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewConfigFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/store", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/conf", map[string]interface{}{"store": "/store/conf", "max_history": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/bad", map[string]interface{}{"store": "/bad/store"}); err == nil {
+		t.Errorf("mounting over the store succeeded")
+	}
+
+	if err := mfs.Mkdir("/conf/api", 0755); err != nil {
+		t.Fatal(err)
+	}
+	schema := `{"type": "object", "required": ["port"], "additionalProperties": false,
+		"properties": {"port": {"type": "integer", "minimum": 1}, "mode": {"enum": ["dev", "prod"]}}}`
+	if _, err := mfs.Write("/conf/api/.schema", []byte(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every violation is reported
+	_, err := mfs.Write("/conf/api/prod.yaml", []byte("port: 0\nmode: test\nextra: 1\n"))
+	if !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Fatalf("invalid config: error %v", err)
+	}
+	for _, want := range []string{"/extra: unknown property", "/mode: must be one of", "/port: must be >= 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if _, err := mfs.Write("/conf/api/prod.json", []byte(`{"port": 80`)); err == nil {
+		t.Errorf("unparsable config was accepted")
+	}
+
+	for _, port := range []string{"80", "81", "82"} {
+		if _, err := mfs.Write("/conf/api/prod.yaml", []byte("port: "+port+"\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A stricter schema would break the existing config
+	if _, err := mfs.Write("/conf/api/.schema", []byte(`{"required": ["host"]}`)); err == nil {
+		t.Errorf("schema breaking a config was bound")
+	}
+	// Configs cannot move out of the scope of their schema into a stricter one
+	if err := mfs.Mkdir("/conf/strict", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Write("/conf/strict/.schema", []byte(`{"required": ["host"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Rename("/conf/api/prod.yaml", "/conf/strict/prod.yaml"); err == nil {
+		t.Errorf("rename into a stricter schema succeeded")
+	}
+	if got := read(mfs, "/conf/api/prod.yaml"); got != "port: 82\n" {
+		t.Errorf("prod.yaml after the failed rename = %q", got)
+	}
+
+	// Versions 2 and 3 are kept
+	entries, err := mfs.ReadDir("/conf/history/api/prod.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	if strings.Join(names, ",") != "2,3,rollback" {
+		t.Errorf("history = %v", names)
+	}
+	if _, err := mfs.Write("/conf/history/api/prod.yaml/rollback", []byte("2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(mfs, "/conf/api/prod.yaml"); got != "port: 81\n" {
+		t.Errorf("prod.yaml after the rollback = %q", got)
+	}
+	if got := read(mfs, "/conf/history/api/prod.yaml/4"); got != "port: 81\n" {
+		t.Errorf("version 4 = %q", got)
+	}
+	if _, err := mfs.Write("/conf/history/api/prod.yaml/3", []byte("x")); err == nil {
+		t.Errorf("writing a version succeeded")
+	}
+}
@@ -0,0 +1,655 @@
+package faultfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "faultfs" // Name of this plugin
+
+	// ControlFile is the control file at the mount root
+	// Reading it returns the fault rules and counters, writing key=value lines updates the rules
+	ControlFile = "/.faults"
+
+	// MetaValueFaultControl identifies the control file in Stat metadata
+	MetaValueFaultControl = "fault-control"
+)
+
+// Operation types faults can be configured for
+const (
+	OpRead    = "read" // Read and Open
+	OpWrite   = "write"
+	OpStat    = "stat"
+	OpReadDir = "readdir"
+	OpCreate  = "create"
+	OpMkdir   = "mkdir"
+	OpRemove  = "remove" // Remove and RemoveAll
+	OpRename  = "rename"
+	OpChmod   = "chmod"
+)
+
+// Operations lists the operation types
+var Operations = []string{OpRead, OpWrite, OpStat, OpReadDir, OpCreate, OpMkdir, OpRemove, OpRename, OpChmod}
+
+// ErrInjected is returned by operations failing on purpose
+// It is not a filesystem error, so the HTTP API answers 500 and clients may retry
+var ErrInjected = fmt.Errorf("injected fault")
+
+// Rule is the fault configuration of an operation type
+type Rule struct {
+	Latency     time.Duration // Added before the operation
+	Jitter      time.Duration // Random extra latency up to this much
+	ErrorRate   float64       // Probability of failing with ErrInjected
+	PartialRate float64       // Probability of a short read (read only)
+}
+
+// active reports whether the rule injects anything
+func (r Rule) active() bool {
+	return r.Latency > 0 || r.Jitter > 0 || r.ErrorRate > 0 || r.PartialRate > 0
+}
+
+// ruleKeys lists the rule settings; "<setting>" applies to every operation and
+// "<op>_<setting>" to a single one
+var ruleKeys = []string{"latency", "jitter", "error_rate", "partial_rate"}
+
+// configKeys returns every rule key accepted in the configuration and the control file
+func configKeys() []string {
+	keys := append([]string{}, ruleKeys...)
+	for _, op := range Operations {
+		for _, k := range ruleKeys {
+			keys = append(keys, op+"_"+k)
+		}
+	}
+	return keys
+}
+
+// parseRules builds the rule of every operation from cfg on top of base
+func parseRules(cfg map[string]interface{}, base map[string]Rule) (map[string]Rule, error) {
+	rules := make(map[string]Rule, len(Operations))
+	for _, op := range Operations {
+		rule := base[op]
+		for _, prefix := range []string{"", op + "_"} {
+			var err error
+			if rule.Latency, err = config.GetDurationConfig(cfg, prefix+"latency", rule.Latency); err != nil {
+				return nil, err
+			}
+			if rule.Jitter, err = config.GetDurationConfig(cfg, prefix+"jitter", rule.Jitter); err != nil {
+				return nil, err
+			}
+			if rule.ErrorRate, err = getRate(cfg, prefix+"error_rate", rule.ErrorRate); err != nil {
+				return nil, err
+			}
+			if rule.PartialRate, err = getRate(cfg, prefix+"partial_rate", rule.PartialRate); err != nil {
+				return nil, err
+			}
+		}
+		if rule.Latency < 0 || rule.Jitter < 0 {
+			return nil, fmt.Errorf("%s latency must not be negative", op)
+		}
+		rules[op] = rule
+	}
+	return rules, nil
+}
+
+// getRate reads a probability between 0 and 1, given as a number or a string
+func getRate(cfg map[string]interface{}, key string, defaultValue float64) (float64, error) {
+	val, exists := cfg[key]
+	if !exists {
+		return defaultValue, nil
+	}
+	var rate float64
+	switch v := val.(type) {
+	case float64:
+		rate = v
+	case int:
+		rate = float64(v)
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a number between 0 and 1", key)
+		}
+		rate = parsed
+	default:
+		return 0, fmt.Errorf("%s must be a number between 0 and 1", key)
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%s must be between 0 and 1", key)
+	}
+	return rate, nil
+}
+
+// FaultFSPlugin wraps a path of the root filesystem and injects latency, errors and
+// short reads into its operations, to test client retry logic
+// The server only offers it in dev mode
+type FaultFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *faultFS
+}
+
+// NewFaultFSPlugin creates a new FaultFS plugin
+func NewFaultFSPlugin() *FaultFSPlugin {
+	return &FaultFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem holding the wrapped path
+func (p *FaultFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *FaultFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *FaultFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := append([]string{"target", "seed", "mount_path"}, configKeys()...)
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if _, err := config.RequireString(cfg, "target"); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "seed"); err != nil {
+		return err
+	}
+	_, err := parseRules(cfg, nil)
+	return err
+}
+
+func (p *FaultFSPlugin) Initialize(cfg map[string]interface{}) error {
+	target, err := config.RequireString(cfg, "target")
+	if err != nil {
+		return err
+	}
+	target = filesystem.NormalizePath(target)
+	if mountPath := config.GetStringConfig(cfg, "mount_path", ""); mountPath != "" {
+		mountPath = filesystem.NormalizePath(mountPath)
+		if target == mountPath || strings.HasPrefix(target, mountPath+"/") || mountPath == "/" {
+			return fmt.Errorf("target %s must be outside the mount path %s", target, mountPath)
+		}
+	}
+	rules, err := parseRules(cfg, nil)
+	if err != nil {
+		return err
+	}
+	seed := int64(config.GetIntConfig(cfg, "seed", 0))
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	p.fs = newFaultFS(p, target, rules, seed)
+	log.Warnf("[faultfs] injecting faults into %s, do not use in production", target)
+	return nil
+}
+
+func (p *FaultFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *FaultFSPlugin) GetReadme() string {
+	return `FaultFS Plugin - Fault Injection
+
+Wraps a path of the server and injects latency, errors and short reads into
+the operations on it, to test client retry logic. Only available when the
+server runs with dev_mode enabled.
+
+CONFIGURATION:
+  target          Wrapped path, e.g. /memfs/data (required)
+  seed            Random seed, for reproducible fault sequences
+  latency         Added to every operation, e.g. "50ms"
+  jitter          Random extra latency up to this much
+  error_rate      Probability (0-1) of failing with an injected error (HTTP 500)
+  partial_rate    Probability (0-1) of a short read
+
+  Each setting can be given per operation type as <op>_<setting>, e.g.
+  write_error_rate=0.2 or read_partial_rate=0.5. Operation types are
+  read, write, stat, readdir, create, mkdir, remove, rename and chmod.
+
+CONTROL FILE:
+  cat /.faults                     Rules and counters of injected faults (JSON)
+  echo "error_rate=0.5" > /.faults Update rules with key=value pairs
+  echo "off" > /.faults            Disable every fault
+  echo "reset" > /.faults          Reset the counters
+
+EXAMPLE:
+  agfs:/> mount faultfs /flaky target=/memfs/data write_error_rate=0.3 latency=20ms
+  agfs:/> echo hello > /flaky/file.txt   # fails about one time in three
+`
+}
+
+func (p *FaultFSPlugin) Shutdown() error {
+	return nil
+}
+
+// counters tracks the faults injected into an operation type
+type counters struct {
+	Calls   int64 `json:"calls"`
+	Delayed int64 `json:"delayed"`
+	Failed  int64 `json:"failed"`
+	Partial int64 `json:"partial,omitempty"`
+}
+
+// faultFS forwards operations to a path of the root filesystem, injecting faults on the way
+type faultFS struct {
+	plugin *FaultFSPlugin
+	target string // Wrapped path of the root filesystem
+
+	mu     sync.Mutex // protects the fields below
+	rules  map[string]Rule
+	stats  map[string]*counters
+	random *rand.Rand
+}
+
+func newFaultFS(p *FaultFSPlugin, target string, rules map[string]Rule, seed int64) *faultFS {
+	fs := &faultFS{
+		plugin: p,
+		target: target,
+		rules:  rules,
+		stats:  make(map[string]*counters, len(Operations)),
+		random: rand.New(rand.NewSource(seed)),
+	}
+	for _, op := range Operations {
+		fs.stats[op] = &counters{}
+	}
+	return fs
+}
+
+// decision is what to inject into one call
+type decision struct {
+	delay   time.Duration
+	fail    bool
+	partial bool
+}
+
+// decide draws the faults of a call of op
+func (fs *faultFS) decide(op string) decision {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	rule := fs.rules[op]
+	c := fs.stats[op]
+	c.Calls++
+
+	var d decision
+	d.delay = rule.Latency
+	if rule.Jitter > 0 {
+		d.delay += time.Duration(fs.random.Int63n(int64(rule.Jitter) + 1))
+	}
+	d.fail = rule.ErrorRate > 0 && fs.random.Float64() < rule.ErrorRate
+	d.partial = !d.fail && rule.PartialRate > 0 && fs.random.Float64() < rule.PartialRate
+	if d.delay > 0 {
+		c.Delayed++
+	}
+	if d.fail {
+		c.Failed++
+	}
+	if d.partial {
+		c.Partial++
+	}
+	return d
+}
+
+// inject applies the latency and error faults of a call of op on p
+func (fs *faultFS) inject(op, p string) (decision, error) {
+	d := fs.decide(op)
+	if d.delay > 0 {
+		time.Sleep(d.delay)
+	}
+	if d.fail {
+		log.Debugf("[faultfs] injecting %s fault on %s", op, p)
+		return d, fmt.Errorf("faultfs: %s %s: %w", op, p, ErrInjected)
+	}
+	return d, nil
+}
+
+// shorten returns a random strict prefix of data, at least one byte when data is not empty
+func (fs *faultFS) shorten(data []byte) []byte {
+	if len(data) < 2 {
+		return data
+	}
+	fs.mu.Lock()
+	n := 1 + fs.random.Intn(len(data)-1)
+	fs.mu.Unlock()
+	return data[:n]
+}
+
+// resolve maps a path of the mount to the wrapped path
+func (fs *faultFS) resolve(p string) (string, error) {
+	if fs.plugin.rootFS == nil {
+		return "", fmt.Errorf("faultfs is not mounted")
+	}
+	return path.Join(fs.target, filesystem.NormalizePath(p)), nil
+}
+
+func isControl(p string) bool {
+	return filesystem.NormalizePath(p) == ControlFile
+}
+
+// Report is the content of the control file
+type Report struct {
+	Target string               `json:"target"`
+	Rules  map[string]ruleJSON  `json:"rules"` // Operations with faults configured
+	Stats  map[string]*counters `json:"stats"`
+}
+
+type ruleJSON struct {
+	Latency     string  `json:"latency,omitempty"`
+	Jitter      string  `json:"jitter,omitempty"`
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	PartialRate float64 `json:"partial_rate,omitempty"`
+}
+
+func (fs *faultFS) report() []byte {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	r := Report{Target: fs.target, Rules: map[string]ruleJSON{}, Stats: fs.stats}
+	for op, rule := range fs.rules {
+		if !rule.active() {
+			continue
+		}
+		rj := ruleJSON{ErrorRate: rule.ErrorRate, PartialRate: rule.PartialRate}
+		if rule.Latency > 0 {
+			rj.Latency = rule.Latency.String()
+		}
+		if rule.Jitter > 0 {
+			rj.Jitter = rule.Jitter.String()
+		}
+		r.Rules[op] = rj
+	}
+	data, _ := json.MarshalIndent(r, "", "  ")
+	return append(data, '\n')
+}
+
+// control applies a write to the control file: "off" clears every rule, "reset" clears
+// the counters, and key=value lines (same keys as the mount configuration) update rules
+func (fs *faultFS) control(data []byte) error {
+	cfg := make(map[string]interface{})
+	known := make(map[string]bool)
+	for _, k := range configKeys() {
+		known[k] = true
+	}
+	off, reset := false, false
+	for _, line := range strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' || r == ' ' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "off":
+			off = true
+			continue
+		case "reset":
+			reset = true
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !known[strings.TrimSpace(key)] {
+			return filesystem.NewInvalidArgumentError("fault rule", line,
+				"expected off, reset or key=value with keys such as latency, error_rate or read_partial_rate")
+		}
+		cfg[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	base := fs.rules
+	if off {
+		base = map[string]Rule{}
+	}
+	rules, err := parseRules(cfg, base)
+	if err != nil {
+		return filesystem.NewInvalidArgumentError("fault rule", string(data), err.Error())
+	}
+	fs.rules = rules
+	if reset {
+		for _, op := range Operations {
+			fs.stats[op] = &counters{}
+		}
+	}
+	log.Infof("[faultfs] fault rules of %s updated", fs.target)
+	return nil
+}
+
+func (fs *faultFS) controlInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    path.Base(ControlFile),
+		Size:    int64(len(fs.report())),
+		Mode:    0644,
+		ModTime: time.Now(),
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueFaultControl},
+	}
+}
+
+func controlDenied(op string) error {
+	return filesystem.NewPermissionDeniedError(op, ControlFile, "fault control file")
+}
+
+func (fs *faultFS) Create(p string) error {
+	if isControl(p) {
+		return controlDenied("create")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpCreate, p); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Create(target)
+}
+
+func (fs *faultFS) Mkdir(p string, perm uint32) error {
+	if isControl(p) {
+		return controlDenied("mkdir")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpMkdir, p); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Mkdir(target, perm)
+}
+
+func (fs *faultFS) Remove(p string) error {
+	if isControl(p) {
+		return controlDenied("remove")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpRemove, p); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Remove(target)
+}
+
+func (fs *faultFS) RemoveAll(p string) error {
+	if isControl(p) {
+		return controlDenied("remove")
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		return filesystem.NewPermissionDeniedError("removeall", p, "cannot remove the wrapped path")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpRemove, p); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.RemoveAll(target)
+}
+
+func (fs *faultFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if isControl(p) {
+		return plugin.ApplyRangeRead(fs.report(), offset, size)
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	d, err := fs.inject(OpRead, p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.plugin.rootFS.Read(target, offset, size)
+	if d.partial && len(data) > 1 {
+		// A short read without io.EOF, as a flaky network or disk would return
+		return fs.shorten(data), nil
+	}
+	return data, err
+}
+
+func (fs *faultFS) Write(p string, data []byte) ([]byte, error) {
+	if isControl(p) {
+		if err := fs.control(data); err != nil {
+			return nil, err
+		}
+		return []byte("fault rules updated"), nil
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.inject(OpWrite, p); err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.Write(target, data)
+}
+
+func (fs *faultFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.inject(OpReadDir, p); err != nil {
+		return nil, err
+	}
+	infos, err := fs.plugin.rootFS.ReadDir(target)
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		infos = append(infos, *fs.controlInfo())
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	}
+	return infos, nil
+}
+
+func (fs *faultFS) Stat(p string) (*filesystem.FileInfo, error) {
+	if isControl(p) {
+		return fs.controlInfo(), nil
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.inject(OpStat, p); err != nil {
+		return nil, err
+	}
+	info, err := fs.plugin.rootFS.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		result := *info
+		result.Name = "/"
+		return &result, nil
+	}
+	return info, nil
+}
+
+func (fs *faultFS) Rename(oldPath, newPath string) error {
+	if isControl(oldPath) || isControl(newPath) {
+		return controlDenied("rename")
+	}
+	oldTarget, err := fs.resolve(oldPath)
+	if err != nil {
+		return err
+	}
+	newTarget, err := fs.resolve(newPath)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpRename, oldPath); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Rename(oldTarget, newTarget)
+}
+
+func (fs *faultFS) Chmod(p string, mode uint32) error {
+	if isControl(p) {
+		return controlDenied("chmod")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.inject(OpChmod, p); err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Chmod(target, mode)
+}
+
+func (fs *faultFS) Open(p string) (io.ReadCloser, error) {
+	if isControl(p) {
+		return io.NopCloser(strings.NewReader(string(fs.report()))), nil
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	d, err := fs.inject(OpRead, p)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fs.plugin.rootFS.Open(target)
+	if err != nil {
+		return nil, err
+	}
+	if d.partial {
+		return &shortReader{ReadCloser: rc, fs: fs}, nil
+	}
+	return rc, nil
+}
+
+func (fs *faultFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if isControl(p) {
+		return nil, controlDenied("openwrite")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.inject(OpWrite, p); err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.OpenWrite(target)
+}
+
+// shortReader returns fewer bytes than requested from every Read
+type shortReader struct {
+	io.ReadCloser
+	fs *faultFS
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = r.fs.shorten(p)
+	}
+	return r.ReadCloser.Read(p)
+}
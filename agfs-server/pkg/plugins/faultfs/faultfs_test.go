@@ -0,0 +1,76 @@
+package faultfs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) *faultFS {
+	t.Helper()
+	root := memfs.NewMemoryFS()
+	if err := root.Mkdir("/data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := NewFaultFSPlugin()
+	p.SetRootFS(root)
+	cfg["target"] = "/data"
+	cfg["seed"] = 1
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return p.fs
+}
+
+func TestInjectedErrors(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{"write_error_rate": 1.0})
+
+	if _, err := fs.Write("/f", []byte("data")); !errors.Is(err, ErrInjected) {
+		t.Fatalf("write: got %v, want injected fault", err)
+	}
+	if err := fs.Create("/f"); err != nil {
+		t.Fatalf("create is not faulty: %v", err)
+	}
+
+	if _, err := fs.Write(ControlFile, []byte("off")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/f", []byte("data")); err != nil {
+		t.Fatalf("write after off: %v", err)
+	}
+	if fs.stats[OpWrite].Failed != 1 {
+		t.Fatalf("got %d failed writes", fs.stats[OpWrite].Failed)
+	}
+}
+
+func TestPartialReads(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{"read_partial_rate": "1"})
+	if _, err := fs.plugin.rootFS.Write("/data/f", []byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.Read("/f", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) == 0 || len(data) >= 10 {
+		t.Fatalf("got %d bytes, want a short read", len(data))
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	p := NewFaultFSPlugin()
+	for _, cfg := range []map[string]interface{}{
+		{"target": "/data", "error_rate": 2.0},
+		{"target": "/data", "read_latency": "soon"},
+		{"target": "/data", "open_error_rate": 0.5},
+		{"error_rate": 0.5},
+	} {
+		if err := p.Validate(cfg); err == nil {
+			t.Errorf("config %v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package formfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "formfs"
+
+	// DefaultPort is the port the form is served on
+	DefaultPort = "8001"
+
+	// DefaultRateLimit is the number of posts accepted per client and minute
+	DefaultRateLimit = 10
+
+	// DefaultMaxBody is the size limit of a post
+	DefaultMaxBody = 64 * 1024
+
+	// DefaultMaxFieldSize is the size limit of a field value
+	DefaultMaxFieldSize = 8 * 1024
+
+	// DefaultMaxFields is the number of fields accepted per post
+	DefaultMaxFields = 50
+)
+
+// FormFSPlugin collects HTML form posts as JSON files
+type FormFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *formFS
+	server *http.Server
+}
+
+// NewFormFSPlugin creates a new FormFS plugin
+func NewFormFSPlugin() *FormFSPlugin {
+	return &FormFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem submissions are written to
+func (p *FormFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *FormFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *FormFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"dir", "queue", "form", "fields", "required", "honeypot", "allowed_origins", "redirect",
+		"rate_limit", "max_body", "max_field_size", "max_fields", "host", "port", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"dir", "queue", "form", "honeypot", "redirect", "host"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	dir := config.GetStringConfig(cfg, "dir", "")
+	queue := config.GetStringConfig(cfg, "queue", "")
+	if (dir == "") == (queue == "") {
+		return fmt.Errorf("exactly one of dir and queue is required")
+	}
+	for _, key := range []string{"dir", "queue", "form"} {
+		if v := config.GetStringConfig(cfg, key, ""); v != "" && !strings.HasPrefix(v, "/") {
+			return fmt.Errorf("%s must be an absolute path: %s", key, v)
+		}
+	}
+	target := dir
+	if target == "" {
+		target = queue
+	}
+	if mountPath := config.GetStringConfig(cfg, "mount_path", ""); mountPath != "" {
+		if target = filesystem.NormalizePath(target); target == mountPath || strings.HasPrefix(target, mountPath+"/") {
+			return fmt.Errorf("%s must not be below the mount path %s", target, mountPath)
+		}
+	}
+	fields, err := stringList(cfg, "fields")
+	if err != nil {
+		return err
+	}
+	required, err := stringList(cfg, "required")
+	if err != nil {
+		return err
+	}
+	for _, name := range required {
+		if len(fields) > 0 && !contains(fields, name) {
+			return fmt.Errorf("required field %s is not in fields", name)
+		}
+	}
+	if _, err := stringList(cfg, "allowed_origins"); err != nil {
+		return err
+	}
+	for _, key := range []string{"rate_limit", "max_field_size", "max_fields"} {
+		if err := config.ValidateIntType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if config.GetIntConfig(cfg, "rate_limit", DefaultRateLimit) < 0 {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	if config.GetIntConfig(cfg, "max_field_size", DefaultMaxFieldSize) < 1 || config.GetIntConfig(cfg, "max_fields", DefaultMaxFields) < 1 {
+		return fmt.Errorf("max_field_size and max_fields must be at least 1")
+	}
+	if maxBody, err := config.GetSizeConfig(cfg, "max_body", DefaultMaxBody); err != nil {
+		return err
+	} else if maxBody < 1 {
+		return fmt.Errorf("max_body must be at least 1 byte")
+	}
+	if val, exists := cfg["port"]; exists {
+		switch val.(type) {
+		case string, int, int64, float64:
+		default:
+			return fmt.Errorf("port must be a string or number")
+		}
+	}
+	return nil
+}
+
+// stringList reads an array of strings, or a comma separated string as passed by the shell
+func stringList(cfg map[string]interface{}, key string) ([]string, error) {
+	var values []string
+	switch v := cfg[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		values = strings.Split(v, ",")
+	case []string:
+		values = v
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be an array of strings", key)
+			}
+			values = append(values, s)
+		}
+	default:
+		return nil, fmt.Errorf("%s must be an array of strings", key)
+	}
+	list := make([]string, 0, len(values))
+	for _, s := range values {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+func (p *FormFSPlugin) Initialize(cfg map[string]interface{}) error {
+	if p.rootFS == nil {
+		return fmt.Errorf("formfs needs the root file system")
+	}
+	fields, _ := stringList(cfg, "fields")
+	required, _ := stringList(cfg, "required")
+	origins, _ := stringList(cfg, "allowed_origins")
+	maxBody, err := config.GetSizeConfig(cfg, "max_body", DefaultMaxBody)
+	if err != nil {
+		return err
+	}
+	normalize := func(key string) string {
+		if v := config.GetStringConfig(cfg, key, ""); v != "" {
+			return filesystem.NormalizePath(v)
+		}
+		return ""
+	}
+	srv := &formServer{
+		root:         p.rootFS,
+		dir:          normalize("dir"),
+		queue:        normalize("queue"),
+		form:         normalize("form"),
+		fields:       fields,
+		required:     required,
+		honeypot:     config.GetStringConfig(cfg, "honeypot", ""),
+		origins:      origins,
+		redirect:     config.GetStringConfig(cfg, "redirect", ""),
+		maxBody:      maxBody,
+		maxFieldSize: config.GetIntConfig(cfg, "max_field_size", DefaultMaxFieldSize),
+		maxFields:    config.GetIntConfig(cfg, "max_fields", DefaultMaxFields),
+		limiter:      &rateLimiter{limit: config.GetIntConfig(cfg, "rate_limit", DefaultRateLimit)},
+		now:          time.Now,
+	}
+
+	port := DefaultPort
+	switch v := cfg["port"].(type) {
+	case string:
+		if v != "" {
+			port = v
+		}
+	case int:
+		port = fmt.Sprint(v)
+	case int64:
+		port = fmt.Sprint(v)
+	case float64:
+		port = fmt.Sprint(int(v))
+	}
+	addr := config.GetStringConfig(cfg, "host", "0.0.0.0") + ":" + port
+	p.fs = &formFS{server: srv, addr: addr, started: time.Now()}
+	p.server = &http.Server{Addr: addr, Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	target := srv.dir
+	if target == "" {
+		target = srv.queue
+	}
+	go func() {
+		log.Infof("[formfs] Serving form on http://%s, storing submissions in %s", addr, target)
+		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("[formfs] HTTP server error on %s: %v", addr, err)
+		}
+	}()
+	return nil
+}
+
+func (p *FormFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *FormFSPlugin) GetReadme() string {
+	return `FormFS Plugin - Form Collection
+
+Serves an HTML form over HTTP and stores every post as a timestamped
+JSON file in an AGFS directory, or enqueues it to a queuefs queue.
+Pair it with httpfs serving the site whose forms post here.
+
+STRUCTURE:
+  /stats   - Accepted, spam, rate limited and rejected posts (JSON)
+  /README  - This file
+
+HTTP:
+  GET  /   - The form page, or a plain form with the configured fields
+  POST /   - A submission: URL-encoded, multipart (without files) or JSON
+
+Each accepted post becomes <dir>/<time>-<random>.json holding id,
+submitted_at, remote_addr, user_agent, referer and fields. Browsers
+are redirected to redirect if set, JSON clients get {"status": "ok",
+"id": ...}.
+
+SPAM CONTROLS:
+  honeypot         - Hidden field that must stay empty; posts filling it
+                     are answered like accepted ones but dropped
+  rate_limit       - Posts per client IP and minute (default: 10, 0: off)
+  allowed_origins  - Origins allowed to post, any if empty
+  fields/required  - Accepted fields (others are rejected) and required ones
+  max_body         - Size limit of a post (default: 64KB)
+  max_field_size   - Size limit of a field value (default: 8192 bytes)
+  max_fields       - Fields accepted per post (default: 50)
+
+CONFIGURATION:
+  dir | queue  - Directory for the JSON files, or queuefs queue (e.g. /queuefs/forms)
+  form         - AGFS path of the HTML page served on GET (optional)
+  redirect     - URL browsers are sent to after posting (optional)
+  host, port   - Address of the HTTP server (default: 0.0.0.0:8001)
+
+EXAMPLES:
+  agfs:/> mount formfs /contact dir=/memfs/contact fields=name,email,message required=email honeypot=website port=8001
+  $ curl -d name=Ann -d email=ann@example.com http://localhost:8001/
+  agfs:/> ls /memfs/contact
+  agfs:/> cat /contact/stats
+`
+}
+
+func (p *FormFSPlugin) Shutdown() error {
+	if p.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+// formFS exposes the state of the form server
+type formFS struct {
+	server  *formServer
+	addr    string
+	started time.Time
+}
+
+func (fs *formFS) content(p string) ([]byte, error) {
+	switch filesystem.NormalizePath(p) {
+	case "/README":
+		return []byte((&FormFSPlugin{}).GetReadme()), nil
+	case "/stats":
+		data, err := json.MarshalIndent(fs.server.stats(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, filesystem.NewNotFoundError("read", p)
+}
+
+func (fs *formFS) Create(p string) error {
+	return filesystem.NewPermissionDeniedError("create", p, "submissions are posted over HTTP")
+}
+
+func (fs *formFS) Mkdir(p string, perm uint32) error {
+	return filesystem.NewPermissionDeniedError("mkdir", p, "submissions are posted over HTTP")
+}
+
+func (fs *formFS) Remove(p string) error {
+	return filesystem.NewPermissionDeniedError("remove", p, "formfs files are read-only")
+}
+
+func (fs *formFS) RemoveAll(p string) error {
+	return fs.Remove(p)
+}
+
+func (fs *formFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *formFS) Write(p string, data []byte) ([]byte, error) {
+	return nil, filesystem.NewPermissionDeniedError("write", p, "submissions are posted over HTTP")
+}
+
+func (fs *formFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(p) != "/" {
+		if _, err := fs.Stat(p); err != nil {
+			return nil, err
+		}
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+	var infos []filesystem.FileInfo
+	for _, name := range []string{"README", "stats"} {
+		info, err := fs.Stat("/" + name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+func (fs *formFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	meta := filesystem.MetaData{Name: PluginName, Content: map[string]string{"address": fs.addr}}
+	if rel == "/" {
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: fs.started, IsDir: true, Meta: meta}, nil
+	}
+	data, err := fs.content(rel)
+	if err != nil {
+		return nil, err
+	}
+	modTime := fs.started
+	if last := fs.server.stats().LastSubmission; rel == "/stats" && !last.IsZero() {
+		modTime = last
+	}
+	return &filesystem.FileInfo{Name: path.Base(rel), Size: int64(len(data)), Mode: 0444, ModTime: modTime, Meta: meta}, nil
+}
+
+func (fs *formFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *formFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *formFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (fs *formFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return nil, filesystem.NewPermissionDeniedError("write", p, "submissions are posted over HTTP")
+}
+
+// Ensure FormFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*FormFSPlugin)(nil)
+var _ filesystem.FileSystem = (*formFS)(nil)
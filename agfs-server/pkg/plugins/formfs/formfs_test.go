@@ -0,0 +1,122 @@
+package formfs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+)
+
+func newTestServer(mfs *mountablefs.MountableFS) *formServer {
+	return &formServer{
+		root:         mfs,
+		dir:          "/mem/forms",
+		fields:       []string{"name", "email", "message"},
+		required:     []string{"email"},
+		honeypot:     "website",
+		origins:      []string{"https://example.com"},
+		maxBody:      1024,
+		maxFieldSize: 100,
+		maxFields:    10,
+		limiter:      &rateLimiter{limit: 3},
+		now:          time.Now,
+	}
+}
+
+func post(s *formServer, contentType, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSubmissions(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(mfs)
+	const form = "application/x-www-form-urlencoded"
+
+	rec := post(s, "application/json", `{"name": "Ann", "email": "ann@example.com"}`, "Origin", "https://example.com")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("json post: %d %s", rec.Code, rec.Body)
+	}
+	entries, err := mfs.ReadDir("/mem/forms")
+	if err != nil || len(entries) != 1 || !strings.HasSuffix(entries[0].Name, ".json") {
+		t.Fatalf("submissions = %v, %v", entries, err)
+	}
+	data, err := mfs.Read("/mem/forms/"+entries[0].Name, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	var sub Submission
+	if err := json.Unmarshal(data, &sub); err != nil || sub.Fields["email"] != "ann@example.com" {
+		t.Errorf("submission = %s, %v", data, err)
+	}
+
+	for _, c := range []struct {
+		name, body string
+		header     []string
+		status     int
+	}{
+		{"honeypot", "email=bot@example.com&website=spam", nil, http.StatusOK},
+		{"missing required", "name=Bob", nil, http.StatusBadRequest},
+		{"unknown field", "email=b@example.com&admin=1", nil, http.StatusBadRequest},
+		{"oversized field", "email=" + strings.Repeat("x", 200), nil, http.StatusRequestEntityTooLarge},
+		{"oversized body", "message=" + strings.Repeat("x", 2000), nil, http.StatusRequestEntityTooLarge},
+		{"foreign origin", "email=c@example.com", []string{"Origin", "https://evil.example"}, http.StatusForbidden},
+	} {
+		s.limiter = &rateLimiter{limit: 3}
+		if rec := post(s, form, c.body, c.header...); rec.Code != c.status {
+			t.Errorf("%s: status %d, want %d: %s", c.name, rec.Code, c.status, rec.Body)
+		}
+	}
+	if entries, _ := mfs.ReadDir("/mem/forms"); len(entries) != 1 {
+		t.Errorf("rejected posts were stored: %d files", len(entries))
+	}
+
+	// The fourth post of a client within a minute is refused
+	for i := 0; i < 4; i++ {
+		rec = post(s, form, "email=d@example.com")
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("rate limit: status %d", rec.Code)
+	}
+	if st := s.stats(); st.Accepted != 4 || st.Spam != 1 || st.RateLimited != 1 || st.Rejected != 5 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// Submissions may go straight into a queue
+	if err := mfs.MountPlugin("queuefs", "/queue", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/queue/forms", 0755); err != nil {
+		t.Fatal(err)
+	}
+	s.dir, s.queue, s.limiter = "", "/queue/forms", nil
+	if rec := post(s, form, "email=e@example.com"); rec.Code != http.StatusOK {
+		t.Fatalf("queue post: %d %s", rec.Code, rec.Body)
+	}
+	msg, err := mfs.Read("/queue/forms/dequeue", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "e@example.com") {
+		t.Errorf("queued message = %s", msg)
+	}
+}
@@ -0,0 +1,397 @@
+package formfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Submission is the record stored for each accepted form post
+type Submission struct {
+	ID          string                 `json:"id"`
+	SubmittedAt time.Time              `json:"submitted_at"`
+	RemoteAddr  string                 `json:"remote_addr"`
+	UserAgent   string                 `json:"user_agent,omitempty"`
+	Referer     string                 `json:"referer,omitempty"`
+	Fields      map[string]interface{} `json:"fields"` // A string, or an array for repeated fields
+}
+
+// Stats counts the outcomes of form posts
+type Stats struct {
+	Accepted       int64     `json:"accepted"`
+	Spam           int64     `json:"spam"`         // Honeypot filled, dropped silently
+	RateLimited    int64     `json:"rate_limited"` // Over the per-client limit
+	Rejected       int64     `json:"rejected"`     // Invalid, oversized or from a foreign origin
+	LastSubmission time.Time `json:"last_submission,omitempty"`
+}
+
+// formServer receives form posts and stores them in the root file system
+type formServer struct {
+	root         filesystem.FileSystem
+	dir          string   // Directory submissions are written to, or
+	queue        string   // queuefs queue submissions are enqueued to
+	form         string   // Path of the HTML form served on GET, optional
+	fields       []string // Accepted fields, any if empty
+	required     []string
+	honeypot     string
+	origins      []string // Allowed Origin headers, any if empty
+	redirect     string
+	maxBody      int64
+	maxFieldSize int
+	maxFields    int
+	limiter      *rateLimiter
+
+	accepted, spam, rateLimited, rejected atomic.Int64
+	last                                  atomic.Int64 // UnixNano of the last accepted post
+	now                                   func() time.Time
+}
+
+// rateLimiter allows a number of posts per client and minute
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  int64 // Current minute
+	clients map[string]int
+}
+
+func (l *rateLimiter) allow(client string, now time.Time) bool {
+	if l == nil || l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if window := now.Unix() / 60; window != l.window {
+		l.window = window
+		l.clients = make(map[string]int)
+	}
+	if l.clients[client] >= l.limit {
+		return false
+	}
+	l.clients[client]++
+	return true
+}
+
+// formError is a rejected post with the HTTP status to answer it with
+type formError struct {
+	status int
+	msg    string
+}
+
+func (e *formError) Error() string { return e.msg }
+
+func reject(status int, format string, args ...interface{}) error {
+	return &formError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *formServer) stats() Stats {
+	st := Stats{
+		Accepted:    s.accepted.Load(),
+		Spam:        s.spam.Load(),
+		RateLimited: s.rateLimited.Load(),
+		Rejected:    s.rejected.Load(),
+	}
+	if last := s.last.Load(); last != 0 {
+		st.LastSubmission = time.Unix(0, last).UTC()
+	}
+	return st
+}
+
+func (s *formServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	if origin != "" && s.allowedOrigin(origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Vary", "Origin")
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.serveForm(w)
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.handlePost(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *formServer) allowedOrigin(origin string) bool {
+	if len(s.origins) == 0 {
+		return true
+	}
+	for _, o := range s.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+var formTemplate = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Form</title></head>
+<body>
+<form method="post" action="/">
+{{- range .Fields}}
+  <p><label>{{.}}<br><input name="{{.}}"></label></p>
+{{- end}}
+{{- if .Honeypot}}
+  <p style="display:none"><label>Leave this empty<input name="{{.Honeypot}}" tabindex="-1" autocomplete="off"></label></p>
+{{- end}}
+  <p><button type="submit">Submit</button></p>
+</form>
+</body>
+</html>
+`))
+
+// serveForm serves the configured form page, or a plain form with the configured fields
+func (s *formServer) serveForm(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if s.form != "" {
+		data, err := s.root.Read(s.form, 0, -1)
+		if err != nil && err != io.EOF {
+			log.Warnf("[formfs] failed to read form %s: %v", s.form, err)
+			http.Error(w, "form not available", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	fields := s.fields
+	if len(fields) == 0 {
+		fields = []string{"message"}
+	}
+	formTemplate.Execute(w, map[string]interface{}{"Fields": fields, "Honeypot": s.honeypot})
+}
+
+func (s *formServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	sub, err := s.submit(r)
+	var fe *formError
+	switch {
+	case err == nil:
+	case errors.As(err, &fe):
+		switch fe.status {
+		case http.StatusTooManyRequests:
+			s.rateLimited.Add(1)
+		default:
+			s.rejected.Add(1)
+		}
+		http.Error(w, fe.msg, fe.status)
+		return
+	default:
+		log.Errorf("[formfs] failed to store submission: %v", err)
+		http.Error(w, "failed to store submission", http.StatusInternalServerError)
+		return
+	}
+
+	if s.redirect != "" {
+		http.Redirect(w, r, s.redirect, http.StatusSeeOther)
+		return
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "id": sub.ID})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, "<!DOCTYPE html>\n<html><body><p>Thank you, your submission was received.</p></body></html>\n")
+}
+
+func wantsJSON(r *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ct == "application/json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// submit validates a post and stores it; posts filling the honeypot are answered like
+// accepted ones so bots learn nothing, but are not stored
+func (s *formServer) submit(r *http.Request) (*Submission, error) {
+	now := s.now()
+	if origin := r.Header.Get("Origin"); origin != "" && !s.allowedOrigin(origin) {
+		return nil, reject(http.StatusForbidden, "origin not allowed: %s", origin)
+	}
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	if !s.limiter.allow(client, now) {
+		return nil, reject(http.StatusTooManyRequests, "too many submissions, try again later")
+	}
+
+	values, err := s.parse(r)
+	if err != nil {
+		return nil, err
+	}
+	sub := &Submission{
+		ID:          newID(now),
+		SubmittedAt: now.UTC(),
+		RemoteAddr:  client,
+		UserAgent:   r.UserAgent(),
+		Referer:     r.Referer(),
+		Fields:      make(map[string]interface{}, len(values)),
+	}
+	if s.honeypot != "" && strings.TrimSpace(strings.Join(values[s.honeypot], "")) != "" {
+		s.spam.Add(1)
+		return sub, nil
+	}
+	delete(values, s.honeypot)
+	if err := s.check(values); err != nil {
+		return nil, err
+	}
+	for name, v := range values {
+		if len(v) == 1 {
+			sub.Fields[name] = v[0]
+		} else {
+			sub.Fields[name] = v
+		}
+	}
+
+	data, err := json.MarshalIndent(sub, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, '\n')
+	if s.queue != "" {
+		_, err = s.root.Write(path.Join(s.queue, "enqueue"), data)
+	} else {
+		file := path.Join(s.dir, sub.ID+".json")
+		if _, err = s.root.Write(file, data); filesystem.IsNotFound(err) {
+			if err = filesystem.MkdirAll(s.root, s.dir, 0755); err == nil {
+				_, err = s.root.Write(file, data)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.accepted.Add(1)
+	s.last.Store(now.UnixNano())
+	return sub, nil
+}
+
+// parse reads the fields of a URL-encoded, multipart or JSON post within the size limits
+func (s *formServer) parse(r *http.Request) (url.Values, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "failed to read the body: %v", err)
+	}
+	if int64(len(body)) > s.maxBody {
+		return nil, reject(http.StatusRequestEntityTooLarge, "submission exceeds %d bytes", s.maxBody)
+	}
+
+	ct, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	values := url.Values{}
+	switch ct {
+	case "application/x-www-form-urlencoded", "":
+		if values, err = url.ParseQuery(string(body)); err != nil {
+			return nil, reject(http.StatusBadRequest, "invalid form data: %v", err)
+		}
+	case "multipart/form-data":
+		form, err := multipartForm(body, params["boundary"], s.maxBody)
+		if err != nil {
+			return nil, err
+		}
+		values = form
+	case "application/json":
+		var obj map[string]interface{}
+		if err := json.Unmarshal(body, &obj); err != nil {
+			return nil, reject(http.StatusBadRequest, "invalid JSON: expected an object of fields")
+		}
+		for name, v := range obj {
+			switch v := v.(type) {
+			case string:
+				values.Add(name, v)
+			case float64, bool:
+				values.Add(name, fmt.Sprint(v))
+			case []interface{}:
+				for _, item := range v {
+					values.Add(name, fmt.Sprint(item))
+				}
+			default:
+				return nil, reject(http.StatusBadRequest, "field %s must be a string, number, boolean or array", name)
+			}
+		}
+	default:
+		return nil, reject(http.StatusUnsupportedMediaType, "unsupported content type: %s", ct)
+	}
+	return values, nil
+}
+
+// multipartForm reads the fields of a multipart body; file uploads are not accepted
+func multipartForm(body []byte, boundary string, maxBody int64) (url.Values, error) {
+	if boundary == "" {
+		return nil, reject(http.StatusBadRequest, "multipart boundary is missing")
+	}
+	form, err := multipart.NewReader(bytes.NewReader(body), boundary).ReadForm(maxBody)
+	if err != nil {
+		return nil, reject(http.StatusBadRequest, "invalid multipart form: %v", err)
+	}
+	defer form.RemoveAll()
+	if len(form.File) > 0 {
+		return nil, reject(http.StatusBadRequest, "file uploads are not accepted")
+	}
+	return url.Values(form.Value), nil
+}
+
+// check enforces the accepted and required fields and the field limits
+func (s *formServer) check(values url.Values) error {
+	if len(values) > s.maxFields {
+		return reject(http.StatusBadRequest, "too many fields, at most %d are accepted", s.maxFields)
+	}
+	for name, v := range values {
+		if len(s.fields) > 0 && !contains(s.fields, name) {
+			return reject(http.StatusBadRequest, "unknown field: %s", name)
+		}
+		for _, value := range v {
+			if len(value) > s.maxFieldSize {
+				return reject(http.StatusRequestEntityTooLarge, "field %s exceeds %d bytes", name, s.maxFieldSize)
+			}
+		}
+	}
+	for _, name := range s.required {
+		if strings.TrimSpace(values.Get(name)) == "" {
+			return reject(http.StatusBadRequest, "field %s is required", name)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newID returns a file name that sorts by submission time
+func newID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return now.UTC().Format("20060102T150405.000000Z") + "-" + hex.EncodeToString(b)
+}
@@ -15,11 +15,13 @@ const (
 )
 
 // HelloFSPlugin is a minimal plugin that only provides a single "hello" file
-type HelloFSPlugin struct{}
+type HelloFSPlugin struct {
+	readme plugin.ReadmeData // Mount the README is rendered for
+}
 
 // NewHelloFSPlugin creates a new HelloFS plugin
 func NewHelloFSPlugin() *HelloFSPlugin {
-	return &HelloFSPlugin{}
+	return &HelloFSPlugin{readme: plugin.NewReadmeData(PluginName, "", nil)}
 }
 
 func (p *HelloFSPlugin) Name() string {
@@ -32,7 +34,8 @@ func (p *HelloFSPlugin) Validate(cfg map[string]interface{}) error {
 	return config.ValidateOnlyKnownKeys(cfg, allowedKeys)
 }
 
-func (p *HelloFSPlugin) Initialize(config map[string]interface{}) error {
+func (p *HelloFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.readme = plugin.NewReadmeData(PluginName, config.GetStringConfig(cfg, "mount_path", ""), cfg)
 	return nil
 }
 
@@ -41,13 +44,7 @@ func (p *HelloFSPlugin) GetFileSystem() filesystem.FileSystem {
 }
 
 func (p *HelloFSPlugin) GetReadme() string {
-	return `HelloFS Plugin - Minimal Demo
-
-This plugin provides a single file: /hello
-
-USAGE:
-  cat /hellofs/hello
-`
+	return plugin.Readme(readmeTemplates, p.readme)
 }
 
 func (p *HelloFSPlugin) Shutdown() error {
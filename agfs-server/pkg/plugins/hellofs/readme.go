@@ -0,0 +1,26 @@
+package hellofs
+
+import "github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+
+// readmeTemplates are the README of the plugin by language, see plugin.ReadmeTemplater
+var readmeTemplates = map[string]string{
+	plugin.LangEnglish: `HelloFS Plugin - Minimal Demo
+
+This plugin provides a single file: /hello
+
+USAGE:
+  cat {{.MountPath}}/hello
+`,
+	plugin.LangChinese: `HelloFS 插件 - 最小示例
+
+此插件只提供一个文件：/hello
+
+用法:
+  cat {{.MountPath}}/hello
+`,
+}
+
+// ReadmeTemplates implements plugin.ReadmeTemplater
+func (p *HelloFSPlugin) ReadmeTemplates() map[string]string {
+	return readmeTemplates
+}
@@ -5,15 +5,14 @@ import (
 	"fmt"
 	"html/template"
 	"io"
-	"mime"
 	"net/http"
 	"path"
-	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/contentmeta"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
@@ -24,105 +23,6 @@ const (
 	PluginName = "httpfs"
 )
 
-// getContentType determines the Content-Type based on file extension
-func getContentType(filename string) string {
-	// Get the base filename (without directory)
-	baseName := filepath.Base(filename)
-	baseNameUpper := strings.ToUpper(baseName)
-
-	// Special handling for README files (with or without extension)
-	// These should display as text/plain in the browser
-	if baseNameUpper == "README" ||
-		strings.HasPrefix(baseNameUpper, "README.") {
-		return "text/plain; charset=utf-8"
-	}
-
-	ext := strings.ToLower(filepath.Ext(filename))
-
-	// Common text formats that should display inline
-	textTypes := map[string]string{
-		".txt":      "text/plain; charset=utf-8",
-		".md":       "text/markdown; charset=utf-8",
-		".markdown": "text/markdown; charset=utf-8",
-		".json":     "application/json; charset=utf-8",
-		".xml":      "application/xml; charset=utf-8",
-		".html":     "text/html; charset=utf-8",
-		".htm":      "text/html; charset=utf-8",
-		".css":      "text/css; charset=utf-8",
-		".js":       "application/javascript; charset=utf-8",
-		".yaml":     "text/yaml; charset=utf-8",
-		".yml":      "text/yaml; charset=utf-8",
-		".log":      "text/plain; charset=utf-8",
-		".csv":      "text/csv; charset=utf-8",
-		".sh":       "text/x-shellscript; charset=utf-8",
-		".py":       "text/x-python; charset=utf-8",
-		".go":       "text/x-go; charset=utf-8",
-		".c":        "text/x-c; charset=utf-8",
-		".cpp":      "text/x-c++; charset=utf-8",
-		".h":        "text/x-c; charset=utf-8",
-		".java":     "text/x-java; charset=utf-8",
-		".rs":       "text/x-rust; charset=utf-8",
-		".sql":      "text/x-sql; charset=utf-8",
-	}
-
-	// Image formats
-	imageTypes := map[string]string{
-		".png":  "image/png",
-		".jpg":  "image/jpeg",
-		".jpeg": "image/jpeg",
-		".gif":  "image/gif",
-		".webp": "image/webp",
-		".svg":  "image/svg+xml",
-		".ico":  "image/x-icon",
-		".bmp":  "image/bmp",
-	}
-
-	// Video formats
-	videoTypes := map[string]string{
-		".mp4":  "video/mp4",
-		".webm": "video/webm",
-		".ogg":  "video/ogg",
-		".avi":  "video/x-msvideo",
-		".mov":  "video/quicktime",
-	}
-
-	// Audio formats
-	audioTypes := map[string]string{
-		".mp3":  "audio/mpeg",
-		".wav":  "audio/wav",
-		".ogg":  "audio/ogg",
-		".m4a":  "audio/mp4",
-		".flac": "audio/flac",
-	}
-
-	// PDF
-	if ext == ".pdf" {
-		return "application/pdf"
-	}
-
-	// Check our custom maps first
-	if ct, ok := textTypes[ext]; ok {
-		return ct
-	}
-	if ct, ok := imageTypes[ext]; ok {
-		return ct
-	}
-	if ct, ok := videoTypes[ext]; ok {
-		return ct
-	}
-	if ct, ok := audioTypes[ext]; ok {
-		return ct
-	}
-
-	// Fallback to mime package
-	if ct := mime.TypeByExtension(ext); ct != "" {
-		return ct
-	}
-
-	// Default to octet-stream for unknown types (will trigger download)
-	return "application/octet-stream"
-}
-
 // HTTPFS implements FileSystem interface with an embedded HTTP server
 // It serves files from an AGFS mount path over HTTP like 'python3 -m http.server'
 type HTTPFS struct {
@@ -135,10 +35,16 @@ type HTTPFS struct {
 	server     *http.Server
 	pluginName string
 	startTime  time.Time // Server start time
+	site       SiteOptions
 }
 
 // NewHTTPFS creates a new HTTP file server that serves AGFS paths
 func NewHTTPFS(agfsPath string, host string, port string, statusPath string, rootFS filesystem.FileSystem) (*HTTPFS, error) {
+	return NewSiteHTTPFS(agfsPath, host, port, statusPath, rootFS, DefaultSiteOptions())
+}
+
+// NewSiteHTTPFS creates a new HTTP file server that serves AGFS paths as a static website
+func NewSiteHTTPFS(agfsPath string, host string, port string, statusPath string, rootFS filesystem.FileSystem, site SiteOptions) (*HTTPFS, error) {
 	if agfsPath == "" {
 		return nil, fmt.Errorf("agfs_path is required")
 	}
@@ -167,6 +73,7 @@ func NewHTTPFS(agfsPath string, host string, port string, statusPath string, roo
 		rootFS:     rootFS,
 		pluginName: PluginName,
 		startTime:  time.Now(),
+		site:       site,
 	}
 
 	// Start HTTP server
@@ -220,13 +127,34 @@ func (fs *HTTPFS) handleHTTPRequest(w http.ResponseWriter, r *http.Request) {
 	// Get file info
 	info, err := fs.rootFS.Stat(pfsPath)
 	if err != nil {
+		if htmlPath, ok := fs.resolveCleanURL(urlPath); ok {
+			fs.serveFile(w, r, htmlPath)
+			return
+		}
 		log.Warnf("[httpfs:%s] Not found: %s (AGFS: %s)", fs.httpPort, urlPath, pfsPath)
-		http.NotFound(w, r)
+		fs.notFound(w, r)
 		return
 	}
 
-	// If it's a directory, list contents
+	// If it's a directory, serve its index or list contents
 	if info.IsDir {
+		if index := fs.findIndex(pfsPath); index != "" {
+			// Relative links in the index resolve against the directory only with a trailing slash
+			if !strings.HasSuffix(urlPath, "/") {
+				target := urlPath + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			fs.serveFile(w, r, index)
+			return
+		}
+		if !fs.site.Listing {
+			fs.notFound(w, r)
+			return
+		}
 		fs.serveDirectory(w, r, pfsPath, urlPath)
 		return
 	}
@@ -245,8 +173,8 @@ func (fs *HTTPFS) serveFile(w http.ResponseWriter, r *http.Request, pfsPath stri
 		return
 	}
 
-	// Determine content type based on file extension
-	contentType := getContentType(pfsPath)
+	// Determine content type from the extension and magic bytes
+	contentType := contentmeta.ContentType(fs.rootFS, pfsPath, info)
 	log.Infof("[httpfs:%s] Serving file: %s (size: %d bytes, type: %s)", fs.httpPort, pfsPath, info.Size, contentType)
 
 	// Try to open file using Open method
@@ -264,11 +192,12 @@ func (fs *HTTPFS) serveFile(w http.ResponseWriter, r *http.Request, pfsPath stri
 
 		// Set headers
 		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
 		w.Header().Set("Last-Modified", info.ModTime.Format(http.TimeFormat))
+		body, closeBody := fs.bodyWriter(w, r, contentType, int64(len(data)))
+		defer closeBody()
 
 		// Write content
-		w.Write(data)
+		body.Write(data)
 		log.Infof("[httpfs:%s] Sent file: %s (%d bytes via Read)", fs.httpPort, pfsPath, len(data))
 		return
 	}
@@ -276,11 +205,12 @@ func (fs *HTTPFS) serveFile(w http.ResponseWriter, r *http.Request, pfsPath stri
 
 	// Set headers
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", info.Size))
 	w.Header().Set("Last-Modified", info.ModTime.Format(http.TimeFormat))
+	body, closeBody := fs.bodyWriter(w, r, contentType, info.Size)
+	defer closeBody()
 
 	// Copy content
-	written, _ := io.Copy(w, reader)
+	written, _ := io.Copy(body, reader)
 	log.Infof("[httpfs:%s] Sent file: %s (%d bytes via stream)", fs.httpPort, pfsPath, written)
 }
 
@@ -571,6 +501,7 @@ type HTTPFSPlugin struct {
 	httpHost   string
 	httpPort   string
 	statusPath string
+	site       SiteOptions
 	rootFS     filesystem.FileSystem
 }
 
@@ -585,10 +516,13 @@ func (p *HTTPFSPlugin) Name() string {
 
 func (p *HTTPFSPlugin) Validate(cfg map[string]interface{}) error {
 	// Check for unknown parameters
-	allowedKeys := []string{"agfs_path", "host", "port", "mount_path"}
+	allowedKeys := append([]string{"agfs_path", "host", "port", "mount_path"}, siteKeys...)
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
 	}
+	if _, err := siteOptionsFromConfig(cfg); err != nil {
+		return err
+	}
 
 	// Validate agfs_path (required)
 	if _, err := config.RequireString(cfg, "agfs_path"); err != nil {
@@ -655,9 +589,15 @@ func (p *HTTPFSPlugin) Initialize(config map[string]interface{}) error {
 	}
 	p.statusPath = statusPath
 
+	site, err := siteOptionsFromConfig(config)
+	if err != nil {
+		return err
+	}
+	p.site = site
+
 	// Create HTTPFS instance if rootFS is available
 	if p.rootFS != nil {
-		fs, err := NewHTTPFS(p.agfsPath, p.httpHost, p.httpPort, p.statusPath, p.rootFS)
+		fs, err := NewSiteHTTPFS(p.agfsPath, p.httpHost, p.httpPort, p.statusPath, p.rootFS, p.site)
 		if err != nil {
 			return fmt.Errorf("failed to initialize httpfs: %w", err)
 		}
@@ -673,7 +613,7 @@ func (p *HTTPFSPlugin) Initialize(config map[string]interface{}) error {
 func (p *HTTPFSPlugin) GetFileSystem() filesystem.FileSystem {
 	// Lazy initialization: create HTTPFS instance if not already created
 	if p.fs == nil && p.rootFS != nil {
-		fs, err := NewHTTPFS(p.agfsPath, p.httpHost, p.httpPort, p.statusPath, p.rootFS)
+		fs, err := NewSiteHTTPFS(p.agfsPath, p.httpHost, p.httpPort, p.statusPath, p.rootFS, p.site)
 		if err != nil {
 			log.Errorf("[httpfs] Failed to initialize: %v", err)
 			return nil
@@ -733,6 +673,22 @@ CURRENT CONFIGURATION:
   AGFS Path: %s
   HTTP Server: http://%s:%s
 
+STATIC SITES:
+
+  [plugins.httpfs_site.config]
+  agfs_path = "/memfs/site"
+  port = "8080"
+  site = true                   # Preset: index.html, 404.html, clean URLs, gzip, no listings
+  cache_control = "public, max-age=300"
+
+  Options (each overrides the site preset):
+    index          - Files served for a directory, e.g. "index.html,index.htm"
+    listing        - List directories without an index (default: true)
+    not_found      - Page served with 404, relative to agfs_path, e.g. "/404.html"
+    clean_urls     - Serve /about from /about.html
+    cache_control  - Cache-Control header of files
+    gzip           - Compress text, JSON, JavaScript and SVG responses
+
 DYNAMIC MOUNTING:
 
   You can dynamically mount httagfs at runtime using AGFS Shell:
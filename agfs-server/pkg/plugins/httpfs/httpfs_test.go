@@ -0,0 +1,87 @@
+package httpfs
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestStaticSite(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	for p, content := range map[string]string{
+		"/mem/site/index.html":      "<h1>home</h1>",
+		"/mem/site/about.html":      "<h1>about</h1>",
+		"/mem/site/404.html":        "<h1>missing</h1>",
+		"/mem/site/docs/index.html": "<h1>docs</h1>",
+		"/mem/site/assets/app.js":   strings.Repeat("console.log(1);\n", 100),
+	} {
+		if err := filesystem.MkdirAll(mfs, path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mfs.Write(p, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	site, err := siteOptionsFromConfig(map[string]interface{}{"site": true, "cache_control": "max-age=60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &HTTPFS{agfsPath: "/mem/site", rootFS: mfs, site: site}
+
+	get := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		fs.handleHTTPRequest(rec, req)
+		return rec
+	}
+
+	for _, c := range []struct {
+		url, body string
+		status    int
+	}{
+		{"/", "<h1>home</h1>", http.StatusOK},
+		{"/about", "<h1>about</h1>", http.StatusOK},
+		{"/docs/", "<h1>docs</h1>", http.StatusOK},
+		{"/nope", "<h1>missing</h1>", http.StatusNotFound},
+		{"/assets/", "<h1>missing</h1>", http.StatusNotFound},
+	} {
+		rec := get(c.url)
+		if rec.Code != c.status || rec.Body.String() != c.body {
+			t.Errorf("GET %s = %d %q, want %d %q", c.url, rec.Code, rec.Body, c.status, c.body)
+		}
+	}
+	if rec := get("/docs?x=1"); rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/docs/?x=1" {
+		t.Errorf("GET /docs = %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec := get("/assets/app.js", "Accept-Encoding", "gzip")
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Cache-Control") != "max-age=60" {
+		t.Fatalf("headers = %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(gz); len(data) != 1600 {
+		t.Errorf("decompressed %d bytes", len(data))
+	}
+	if rec := get("/assets/app.js"); rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 1600 {
+		t.Errorf("uncompressed response: %v, %d bytes", rec.Header(), rec.Body.Len())
+	}
+}
@@ -0,0 +1,159 @@
+package httpfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// SiteOptions configure how httpfs serves a static website
+type SiteOptions struct {
+	Index        []string // Files served for a directory, in order of preference
+	Listing      bool     // List directories without an index
+	NotFound     string   // Page served with 404, relative to the served path
+	CleanURLs    bool     // Serve /about from /about.html
+	CacheControl string   // Cache-Control header of files
+	Gzip         bool     // Compress text responses for clients accepting gzip
+}
+
+// DefaultSiteOptions serves plain directory listings
+func DefaultSiteOptions() SiteOptions {
+	return SiteOptions{Listing: true}
+}
+
+// siteKeys are the configuration keys of SiteOptions
+var siteKeys = []string{"site", "index", "listing", "not_found", "clean_urls", "cache_control", "gzip"}
+
+// siteOptionsFromConfig reads SiteOptions; site: true presets index.html, 404.html and
+// clean URLs without listings, and the other keys override the preset
+func siteOptionsFromConfig(cfg map[string]interface{}) (SiteOptions, error) {
+	for _, key := range []string{"site", "listing", "clean_urls", "gzip"} {
+		if err := config.ValidateBoolType(cfg, key); err != nil {
+			return SiteOptions{}, err
+		}
+	}
+	for _, key := range []string{"not_found", "cache_control"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return SiteOptions{}, err
+		}
+	}
+
+	opts := DefaultSiteOptions()
+	if config.GetBoolConfig(cfg, "site", false) {
+		opts = SiteOptions{Index: []string{"index.html"}, NotFound: "/404.html", CleanURLs: true, Gzip: true}
+	}
+	switch v := cfg["index"].(type) {
+	case nil:
+	case string:
+		opts.Index = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Index = append(opts.Index, name)
+			}
+		}
+	case []interface{}:
+		opts.Index = nil
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok || name == "" {
+				return SiteOptions{}, fmt.Errorf("index must be a file name or an array of file names")
+			}
+			opts.Index = append(opts.Index, name)
+		}
+	default:
+		return SiteOptions{}, fmt.Errorf("index must be a file name or an array of file names")
+	}
+	for _, name := range opts.Index {
+		if strings.Contains(name, "/") {
+			return SiteOptions{}, fmt.Errorf("index must name files, not paths: %s", name)
+		}
+	}
+	opts.Listing = config.GetBoolConfig(cfg, "listing", opts.Listing)
+	opts.NotFound = config.GetStringConfig(cfg, "not_found", opts.NotFound)
+	opts.CleanURLs = config.GetBoolConfig(cfg, "clean_urls", opts.CleanURLs)
+	opts.CacheControl = config.GetStringConfig(cfg, "cache_control", opts.CacheControl)
+	opts.Gzip = config.GetBoolConfig(cfg, "gzip", opts.Gzip)
+	return opts, nil
+}
+
+// findIndex returns the AGFS path of the index file of a directory, or "" if it has none
+func (fs *HTTPFS) findIndex(dir string) string {
+	for _, name := range fs.site.Index {
+		p := path.Join(dir, name)
+		if info, err := fs.rootFS.Stat(p); err == nil && !info.IsDir {
+			return p
+		}
+	}
+	return ""
+}
+
+// resolveCleanURL returns the AGFS path of the .html file serving an extensionless URL
+func (fs *HTTPFS) resolveCleanURL(urlPath string) (string, bool) {
+	if !fs.site.CleanURLs || strings.HasSuffix(urlPath, "/") || path.Ext(urlPath) != "" {
+		return "", false
+	}
+	p := fs.resolveAGFSPath(urlPath) + ".html"
+	if info, err := fs.rootFS.Stat(p); err == nil && !info.IsDir {
+		return p, true
+	}
+	return "", false
+}
+
+// notFound answers with the custom 404 page if there is one
+func (fs *HTTPFS) notFound(w http.ResponseWriter, r *http.Request) {
+	if fs.site.NotFound != "" {
+		p := fs.resolveAGFSPath(fs.site.NotFound)
+		data, err := fs.rootFS.Read(p, 0, -1)
+		if err == nil || err == io.EOF {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write(data)
+			return
+		}
+		log.Debugf("[httpfs:%s] Custom 404 page %s not available: %v", fs.httpPort, p, err)
+	}
+	http.NotFound(w, r)
+}
+
+// compressible reports whether a content type benefits from gzip
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// bodyWriter sets the caching and encoding headers of a file response and returns the writer
+// for its body; close must be called once the body is written
+func (fs *HTTPFS) bodyWriter(w http.ResponseWriter, r *http.Request, contentType string, size int64) (io.Writer, func()) {
+	if fs.site.CacheControl != "" {
+		w.Header().Set("Cache-Control", fs.site.CacheControl)
+	}
+	if !fs.site.Gzip || !compressible(contentType) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+		return w, func() {}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+		return w, func() {}
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	return gz, func() { gz.Close() }
+}
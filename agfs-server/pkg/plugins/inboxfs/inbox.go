@@ -0,0 +1,141 @@
+package inboxfs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Message is a notification posted to a topic
+type Message struct {
+	ID        uint64    `json:"id"`
+	Data      string    `json:"data"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// topic holds the retained messages of a topic, oldest first, and the cursors of its consumers
+type topic struct {
+	messages  []Message
+	lastID    uint64
+	consumers map[string]*consumer
+	modified  time.Time
+}
+
+// consumer tracks what a named reader of a topic has read
+type consumer struct {
+	cursor   uint64 // ID of the last message read
+	modified time.Time
+}
+
+// unread returns the retained messages after the cursor
+func (t *topic) unread(c *consumer) []Message {
+	i := sort.Search(len(t.messages), func(i int) bool { return t.messages[i].ID > c.cursor })
+	return t.messages[i:]
+}
+
+// message returns a retained message by ID
+func (t *topic) message(id uint64) (Message, bool) {
+	i := sort.Search(len(t.messages), func(i int) bool { return t.messages[i].ID >= id })
+	if i < len(t.messages) && t.messages[i].ID == id {
+		return t.messages[i], true
+	}
+	return Message{}, false
+}
+
+// inbox holds the topics of a mount
+type inbox struct {
+	mu          sync.Mutex
+	topics      map[string]*topic
+	ttl         time.Duration // Age at which messages are dropped, 0 keeps them
+	maxMessages int           // Messages retained per topic
+	now         func() time.Time
+}
+
+func newInbox(ttl time.Duration, maxMessages int) *inbox {
+	return &inbox{
+		topics:      make(map[string]*topic),
+		ttl:         ttl,
+		maxMessages: maxMessages,
+		now:         time.Now,
+	}
+}
+
+// expire drops the messages older than the TTL; b.mu must be held
+func (b *inbox) expire() {
+	if b.ttl == 0 {
+		return
+	}
+	cutoff := b.now().Add(-b.ttl)
+	for _, t := range b.topics {
+		i := sort.Search(len(t.messages), func(i int) bool { return !t.messages[i].Timestamp.Before(cutoff) })
+		if i > 0 {
+			t.messages = append([]Message(nil), t.messages[i:]...)
+		}
+	}
+}
+
+// lookup returns a topic after dropping expired messages; b.mu must be held
+func (b *inbox) lookup(op, p, name string) (*topic, error) {
+	b.expire()
+	t, ok := b.topics[name]
+	if !ok {
+		return nil, filesystem.NewNotFoundError(op, p)
+	}
+	return t, nil
+}
+
+func (b *inbox) createTopic(name string) *topic {
+	t := &topic{consumers: make(map[string]*consumer), modified: b.now()}
+	b.topics[name] = t
+	return t
+}
+
+// post appends a message to a topic, creating the topic if needed
+func (b *inbox) post(name string, data []byte) Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	t, ok := b.topics[name]
+	if !ok {
+		t = b.createTopic(name)
+	}
+	t.lastID++
+	msg := Message{ID: t.lastID, Data: string(data), Timestamp: b.now()}
+	t.messages = append(t.messages, msg)
+	if len(t.messages) > b.maxMessages {
+		t.messages = append([]Message(nil), t.messages[len(t.messages)-b.maxMessages:]...)
+	}
+	t.modified = msg.Timestamp
+	return msg
+}
+
+// consumer returns a consumer of a topic, registering it if needed; b.mu must be held
+// New consumers start before the oldest retained message
+func (b *inbox) consumer(t *topic, name string) *consumer {
+	c, ok := t.consumers[name]
+	if !ok {
+		c = &consumer{modified: b.now()}
+		t.consumers[name] = c
+	}
+	return c
+}
+
+// run expires messages in the background until stop is closed
+func (b *inbox) run(stop <-chan struct{}, done func()) {
+	defer done()
+	interval := min(max(b.ttl/10, time.Second), time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			b.expire()
+			b.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,577 @@
+package inboxfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "inboxfs"
+
+	// DefaultTTL is the age at which messages are dropped
+	DefaultTTL = 7 * 24 * time.Hour
+
+	// DefaultMaxMessages is the number of messages retained per topic
+	DefaultMaxMessages = 10000
+)
+
+// Entries of a topic directory
+const (
+	filePost     = "post"
+	dirMessages  = "messages"
+	dirConsumers = "consumers"
+)
+
+// Control files of a consumer directory
+const (
+	fileCursor   = "cursor"
+	fileUnread   = "unread"
+	filePending  = "pending"
+	fileNext     = "next"
+	fileMarkRead = "mark_read"
+)
+
+var consumerFiles = []string{fileCursor, fileMarkRead, fileNext, filePending, fileUnread}
+
+// validName restricts topic and consumer names to one path element
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,200}$`)
+
+// InboxFSPlugin fans notifications out to named consumers that track what they have read
+type InboxFSPlugin struct {
+	fs   *inboxFS
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewInboxFSPlugin creates a new InboxFS plugin
+func NewInboxFSPlugin() *InboxFSPlugin {
+	return &InboxFSPlugin{}
+}
+
+func (p *InboxFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *InboxFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"ttl", "max_messages", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if _, err := config.GetDurationConfig(cfg, "ttl", DefaultTTL); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "max_messages"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "max_messages", DefaultMaxMessages) < 1 {
+		return fmt.Errorf("max_messages must be at least 1")
+	}
+	return nil
+}
+
+func (p *InboxFSPlugin) Initialize(cfg map[string]interface{}) error {
+	ttl, err := config.GetDurationConfig(cfg, "ttl", DefaultTTL)
+	if err != nil {
+		return err
+	}
+	p.fs = &inboxFS{
+		inbox:   newInbox(ttl, config.GetIntConfig(cfg, "max_messages", DefaultMaxMessages)),
+		started: time.Now(),
+	}
+	if ttl > 0 {
+		p.stop = make(chan struct{})
+		p.wg.Add(1)
+		go p.fs.inbox.run(p.stop, p.wg.Done)
+	}
+	return nil
+}
+
+func (p *InboxFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *InboxFSPlugin) GetReadme() string {
+	return `InboxFS Plugin - Notification Inbox
+
+Producers post messages to topics; every named consumer of a topic
+has its own cursor, so each of them reads every message once.
+
+STRUCTURE:
+  /<topic>/post                        - Write a message, returns its ID
+  /<topic>/messages/<id>               - Retained messages, removable
+  /<topic>/consumers/<name>/cursor     - ID of the last message read; write an ID to move it
+  /<topic>/consumers/<name>/unread     - Number of unread messages
+  /<topic>/consumers/<name>/pending    - Unread messages (JSON array)
+  /<topic>/consumers/<name>/next       - Reading returns the next unread message (JSON)
+                                         and marks it read, {} when there is none
+  /<topic>/consumers/<name>/mark_read  - Write an ID to mark it and every earlier
+                                         message read, or "all" (or nothing)
+  /README                              - This file
+
+Topics are created by mkdir or by their first post, consumers by mkdir
+or by their first read or write. A new consumer starts before the
+oldest retained message. Messages are dropped once older than the TTL
+or beyond max_messages per topic. Everything is kept in memory.
+Names may use letters, digits and _ . : -
+
+CONFIGURATION:
+  ttl           - Age at which messages are dropped, 0 keeps them (default: 7d)
+  max_messages  - Messages retained per topic (default: 10000)
+
+EXAMPLES:
+  agfs:/> echo "deploy finished" > /inboxfs/deploys/post
+  agfs:/> cat /inboxfs/deploys/consumers/slack-bot/unread
+  agfs:/> cat /inboxfs/deploys/consumers/slack-bot/next
+  agfs:/> echo all > /inboxfs/deploys/consumers/dashboard/mark_read
+`
+}
+
+func (p *InboxFSPlugin) Shutdown() error {
+	if p.stop != nil {
+		close(p.stop)
+		p.wg.Wait()
+		p.stop = nil
+	}
+	return nil
+}
+
+// inboxFS implements the FileSystem interface over the topics
+type inboxFS struct {
+	inbox   *inbox
+	started time.Time
+}
+
+// node is a parsed path; trailing fields are empty for directories higher up
+type node struct {
+	topic    string
+	entry    string // post, messages or consumers
+	name     string // Message ID or consumer name
+	file     string // Consumer control file
+	elements int
+}
+
+// parse splits a path into a node, validating the names of each element
+func parse(op, p string) (node, error) {
+	rel := strings.TrimPrefix(filesystem.NormalizePath(p), "/")
+	if rel == "" {
+		return node{}, nil
+	}
+	parts := strings.Split(rel, "/")
+	n := node{elements: len(parts)}
+	fields := []*string{&n.topic, &n.entry, &n.name, &n.file}
+	if len(parts) > len(fields) {
+		return n, filesystem.NewNotFoundError(op, p)
+	}
+	for i, part := range parts {
+		*fields[i] = part
+	}
+	if !validName.MatchString(n.topic) {
+		return n, filesystem.NewNotFoundError(op, p)
+	}
+	switch n.entry {
+	case "":
+	case filePost:
+		if n.elements > 2 {
+			return n, filesystem.NewNotFoundError(op, p)
+		}
+	case dirMessages:
+		if n.elements > 3 {
+			return n, filesystem.NewNotFoundError(op, p)
+		}
+	case dirConsumers:
+		if n.name != "" && !validName.MatchString(n.name) {
+			return n, filesystem.NewNotFoundError(op, p)
+		}
+		if n.file != "" && !isConsumerFile(n.file) {
+			return n, filesystem.NewNotFoundError(op, p)
+		}
+	default:
+		return n, filesystem.NewNotFoundError(op, p)
+	}
+	return n, nil
+}
+
+func isConsumerFile(name string) bool {
+	for _, f := range consumerFiles {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// messageID parses the name of a message file
+func messageID(op, p, name string) (uint64, error) {
+	id, err := strconv.ParseUint(name, 10, 64)
+	if err != nil || id == 0 {
+		return 0, filesystem.NewNotFoundError(op, p)
+	}
+	return id, nil
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// content returns the content of a file; reading next marks the message it returns read
+func (fs *inboxFS) content(p string) ([]byte, error) {
+	if filesystem.NormalizePath(p) == "/README" {
+		return []byte((&InboxFSPlugin{}).GetReadme()), nil
+	}
+	n, err := parse("read", p)
+	if err != nil {
+		return nil, err
+	}
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n.elements == 0 {
+		return nil, filesystem.NewInvalidArgumentError("path", p, "is a directory")
+	}
+	t, err := b.lookup("read", p, n.topic)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case n.entry == filePost:
+		return []byte{}, nil
+	case n.entry == dirMessages && n.name != "":
+		id, err := messageID("read", p, n.name)
+		if err != nil {
+			return nil, err
+		}
+		msg, ok := t.message(id)
+		if !ok {
+			return nil, filesystem.NewNotFoundError("read", p)
+		}
+		return []byte(msg.Data), nil
+	case n.entry == dirConsumers && n.file != "":
+		c := b.consumer(t, n.name)
+		unread := t.unread(c)
+		switch n.file {
+		case fileCursor:
+			return []byte(fmt.Sprintf("%d\n", c.cursor)), nil
+		case fileUnread:
+			return []byte(fmt.Sprintf("%d\n", len(unread))), nil
+		case filePending:
+			return marshal(unread)
+		case fileNext:
+			if len(unread) == 0 {
+				return []byte("{}"), nil
+			}
+			c.cursor = unread[0].ID
+			c.modified = b.now()
+			return marshal(unread[0])
+		case fileMarkRead:
+			return []byte{}, nil
+		}
+	}
+	return nil, filesystem.NewInvalidArgumentError("path", p, "is a directory")
+}
+
+func (fs *inboxFS) Create(p string) error {
+	n, err := parse("create", p)
+	if err != nil {
+		return err
+	}
+	if n.entry == filePost || n.file != "" {
+		// Control files always exist
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("create", p, "messages are written to <topic>/post")
+}
+
+// Mkdir creates a topic or registers a consumer
+func (fs *inboxFS) Mkdir(p string, perm uint32) error {
+	n, err := parse("mkdir", p)
+	if err != nil {
+		return err
+	}
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case n.elements == 1:
+		if _, ok := b.topics[n.topic]; ok {
+			return filesystem.NewAlreadyExistsError("directory", p)
+		}
+		b.createTopic(n.topic)
+		return nil
+	case n.elements == 3 && n.entry == dirConsumers:
+		t, err := b.lookup("mkdir", p, n.topic)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.consumers[n.name]; ok {
+			return filesystem.NewAlreadyExistsError("directory", p)
+		}
+		b.consumer(t, n.name)
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("mkdir", p, "only topics and consumers can be created")
+}
+
+// Remove removes a message
+func (fs *inboxFS) Remove(p string) error {
+	n, err := parse("remove", p)
+	if err != nil {
+		return err
+	}
+	if n.entry != dirMessages || n.name == "" {
+		return fs.RemoveAll(p)
+	}
+	id, err := messageID("remove", p, n.name)
+	if err != nil {
+		return err
+	}
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, err := b.lookup("remove", p, n.topic)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(t.messages), func(i int) bool { return t.messages[i].ID >= id })
+	if i == len(t.messages) || t.messages[i].ID != id {
+		return filesystem.NewNotFoundError("remove", p)
+	}
+	t.messages = append(t.messages[:i:i], t.messages[i+1:]...)
+	return nil
+}
+
+// RemoveAll removes a topic, a consumer, a message, or every message of a topic
+func (fs *inboxFS) RemoveAll(p string) error {
+	n, err := parse("remove", p)
+	if err != nil {
+		return err
+	}
+	if n.entry == dirMessages && n.name != "" {
+		return fs.Remove(p)
+	}
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n.elements == 0 {
+		return filesystem.NewPermissionDeniedError("remove", p, "cannot remove the root")
+	}
+	t, err := b.lookup("remove", p, n.topic)
+	if err != nil {
+		return err
+	}
+	switch {
+	case n.elements == 1:
+		delete(b.topics, n.topic)
+	case n.entry == dirMessages:
+		t.messages = nil
+	case n.entry == dirConsumers && n.name != "" && n.file == "":
+		if _, ok := t.consumers[n.name]; !ok {
+			return filesystem.NewNotFoundError("remove", p)
+		}
+		delete(t.consumers, n.name)
+	case n.entry == dirConsumers && n.name == "":
+		t.consumers = make(map[string]*consumer)
+	default:
+		return filesystem.NewPermissionDeniedError("remove", p, "control files cannot be removed")
+	}
+	return nil
+}
+
+func (fs *inboxFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *inboxFS) Write(p string, data []byte) ([]byte, error) {
+	n, err := parse("write", p)
+	if err != nil {
+		return nil, err
+	}
+	if n.entry == filePost {
+		if len(data) == 0 {
+			return nil, filesystem.NewInvalidArgumentError("message", "", "a message must not be empty")
+		}
+		msg := fs.inbox.post(n.topic, data)
+		return []byte(fmt.Sprintf("%d\n", msg.ID)), nil
+	}
+	if n.file != fileCursor && n.file != fileMarkRead {
+		return nil, filesystem.NewPermissionDeniedError("write", p, "messages are written to <topic>/post")
+	}
+
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, err := b.lookup("write", p, n.topic)
+	if err != nil {
+		return nil, err
+	}
+	value := strings.TrimSpace(string(data))
+	id := t.lastID
+	if n.file == fileCursor || (value != "" && value != "all") {
+		if id, err = strconv.ParseUint(value, 10, 64); err != nil || id > t.lastID {
+			return nil, filesystem.NewInvalidArgumentError("id", value, fmt.Sprintf("expected a message ID up to %d", t.lastID))
+		}
+	}
+	c := b.consumer(t, n.name)
+	if n.file == fileMarkRead {
+		// Marking read never moves the cursor back
+		id = max(id, c.cursor)
+	}
+	c.cursor = id
+	c.modified = b.now()
+	return []byte(fmt.Sprintf("%d\n", len(t.unread(c)))), nil
+}
+
+func (fs *inboxFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	info, err := fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir {
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+	n, _ := parse("readdir", p)
+	rel := filesystem.NormalizePath(p)
+
+	var names []string
+	b := fs.inbox
+	b.mu.Lock()
+	switch {
+	case n.elements == 0:
+		names = append(names, "README")
+		for name := range b.topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	case n.elements == 1:
+		names = []string{dirConsumers, dirMessages, filePost}
+	case n.entry == dirMessages:
+		for _, msg := range b.topics[n.topic].messages {
+			names = append(names, strconv.FormatUint(msg.ID, 10))
+		}
+	case n.name == "":
+		for name := range b.topics[n.topic].consumers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	default:
+		names = consumerFiles
+	}
+	b.mu.Unlock()
+
+	infos := []filesystem.FileInfo{}
+	for _, name := range names {
+		if info, err := fs.Stat(path.Join(rel, name)); err == nil {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+func (fs *inboxFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	info := &filesystem.FileInfo{Name: path.Base(rel), Mode: 0444, ModTime: fs.started, Meta: filesystem.MetaData{Name: PluginName}}
+	if rel == "/" {
+		info.Mode, info.IsDir = 0755, true
+		return info, nil
+	}
+	if rel == "/README" {
+		info.Size = int64(len((&InboxFSPlugin{}).GetReadme()))
+		return info, nil
+	}
+	n, err := parse("stat", rel)
+	if err != nil {
+		return nil, err
+	}
+
+	b := fs.inbox
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, err := b.lookup("stat", rel, n.topic)
+	if err != nil {
+		return nil, err
+	}
+	info.ModTime = t.modified
+	switch {
+	case n.elements == 1, n.elements == 2 && n.entry != filePost:
+		info.Mode, info.IsDir = 0755, true
+	case n.entry == filePost:
+		info.Mode = 0222
+	case n.entry == dirMessages:
+		id, err := messageID("stat", rel, n.name)
+		if err != nil {
+			return nil, err
+		}
+		msg, ok := t.message(id)
+		if !ok {
+			return nil, filesystem.NewNotFoundError("stat", rel)
+		}
+		info.Size, info.ModTime = int64(len(msg.Data)), msg.Timestamp
+	default:
+		c, ok := t.consumers[n.name]
+		if !ok {
+			return nil, filesystem.NewNotFoundError("stat", rel)
+		}
+		info.ModTime = c.modified
+		switch n.file {
+		case "":
+			info.Mode, info.IsDir = 0755, true
+		case fileCursor:
+			info.Mode = 0644
+			info.Size = int64(len(strconv.FormatUint(c.cursor, 10)) + 1)
+		case fileUnread:
+			info.Size = int64(len(strconv.Itoa(len(t.unread(c)))) + 1)
+		case filePending:
+			data, err := marshal(t.unread(c))
+			if err != nil {
+				return nil, err
+			}
+			info.Size = int64(len(data))
+		case fileMarkRead:
+			info.Mode = 0222
+		}
+	}
+	return info, nil
+}
+
+func (fs *inboxFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *inboxFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *inboxFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (fs *inboxFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(p, fs.Write), nil
+}
+
+// Ensure InboxFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*InboxFSPlugin)(nil)
+var _ filesystem.FileSystem = (*inboxFS)(nil)
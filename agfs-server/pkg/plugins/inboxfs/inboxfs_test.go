@@ -0,0 +1,117 @@
+package inboxfs
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) *inboxFS {
+	t.Helper()
+	p := NewInboxFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Shutdown() })
+	return p.fs
+}
+
+func read(t *testing.T, fs *inboxFS, p string) string {
+	t.Helper()
+	data, err := fs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func write(t *testing.T, fs *inboxFS, p, data string) string {
+	t.Helper()
+	out, err := fs.Write(p, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestConsumers(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{})
+	for _, msg := range []string{"a", "b", "c"} {
+		write(t, fs, "/deploys/post", msg)
+	}
+
+	// Each consumer reads every message once
+	for _, name := range []string{"bot", "dash"} {
+		var msg Message
+		if err := json.Unmarshal([]byte(read(t, fs, "/deploys/consumers/"+name+"/next")), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != 1 || msg.Data != "a" {
+			t.Errorf("%s: next = %+v", name, msg)
+		}
+	}
+	if got := read(t, fs, "/deploys/consumers/bot/unread"); got != "2\n" {
+		t.Errorf("unread = %q", got)
+	}
+
+	if got := write(t, fs, "/deploys/consumers/bot/mark_read", "all"); got != "0\n" {
+		t.Errorf("unread after mark_read = %q", got)
+	}
+	if got := read(t, fs, "/deploys/consumers/bot/next"); got != "{}" {
+		t.Errorf("next when read = %q", got)
+	}
+	// mark_read never moves back, writing the cursor does
+	write(t, fs, "/deploys/consumers/bot/mark_read", "1")
+	if got := read(t, fs, "/deploys/consumers/bot/cursor"); got != "3\n" {
+		t.Errorf("cursor = %q", got)
+	}
+	write(t, fs, "/deploys/consumers/bot/cursor", "1")
+	var pending []Message
+	if err := json.Unmarshal([]byte(read(t, fs, "/deploys/consumers/bot/pending")), &pending); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].Data != "b" {
+		t.Errorf("pending = %+v", pending)
+	}
+	if _, err := fs.Write("/deploys/consumers/bot/cursor", []byte("9")); err == nil {
+		t.Errorf("cursor past the last message accepted")
+	}
+
+	entries, err := fs.ReadDir("/deploys/consumers")
+	if err != nil || len(entries) != 2 || entries[0].Name != "bot" {
+		t.Errorf("consumers = %v, %v", entries, err)
+	}
+	if err := fs.Remove("/deploys/messages/2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, fs, "/deploys/consumers/dash/unread"); got != "1\n" {
+		t.Errorf("unread after remove = %q", got)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{"ttl": "1h", "max_messages": 2})
+	now := time.Unix(1000000, 0)
+	fs.inbox.now = func() time.Time { return now }
+
+	for _, msg := range []string{"a", "b", "c"} {
+		write(t, fs, "/alerts/post", msg)
+		now = now.Add(time.Minute)
+	}
+	if got := read(t, fs, "/alerts/consumers/new/unread"); got != "2\n" {
+		t.Errorf("unread beyond max_messages = %q", got)
+	}
+	now = now.Add(time.Hour - 90*time.Second)
+	if got := read(t, fs, "/alerts/consumers/new/unread"); got != "1\n" {
+		t.Errorf("unread after ttl = %q", got)
+	}
+	if _, err := fs.Stat("/alerts/messages/2"); !filesystem.IsNotFound(err) {
+		t.Errorf("expired message: %v", err)
+	}
+}
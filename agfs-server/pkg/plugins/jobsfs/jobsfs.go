@@ -0,0 +1,247 @@
+package jobsfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "jobsfs"
+)
+
+// Virtual files in jobsfs
+const (
+	fileReadme = "/README"
+)
+
+// JobsFSPlugin exposes the background jobs of the server as files: reading /<id> returns
+// the status of a job, writing "cancel" to it cancels the job
+type JobsFSPlugin struct{}
+
+// NewJobsFSPlugin creates a new JobsFS plugin
+func NewJobsFSPlugin() *JobsFSPlugin {
+	return &JobsFSPlugin{}
+}
+
+func (p *JobsFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *JobsFSPlugin) Validate(cfg map[string]interface{}) error {
+	return config.ValidateOnlyKnownKeys(cfg, []string{"mount_path"})
+}
+
+func (p *JobsFSPlugin) Initialize(cfg map[string]interface{}) error {
+	return nil
+}
+
+func (p *JobsFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &jobsFS{plugin: p}
+}
+
+func (p *JobsFSPlugin) GetReadme() string {
+	return `JobsFS Plugin - Background Jobs as Files
+
+Every background job of the server (started with POST /api/v1/jobs or
+DELETE /api/v1/files?recursive=true&async=true) is a file named by its ID.
+Reading it returns the status of the job; ended jobs stay listed until
+they are pruned (see "retain" in the "jobs" section of the server config).
+
+FILES:
+  /README  - This file
+  /<id>    - Status of job <id> (JSON); write "cancel" to cancel it
+
+EXAMPLES:
+  agfs:/> ls /jobsfs
+  README
+  3f9c2a1b
+
+  agfs:/> cat /jobsfs/3f9c2a1b
+  {"id": "3f9c2a1b", "type": "remove", "state": "running", "done": 1200, ...}
+
+  agfs:/> echo cancel > /jobsfs/3f9c2a1b
+`
+}
+
+func (p *JobsFSPlugin) Shutdown() error {
+	return nil
+}
+
+// jobsFS implements the FileSystem interface for job files
+type jobsFS struct {
+	plugin *JobsFSPlugin
+}
+
+// manager returns the job manager of the server
+func (fs *jobsFS) manager(path string) (*jobs.Manager, error) {
+	m := jobs.Default()
+	if m == nil {
+		return nil, filesystem.NewUnavailableError("read", path, "jobs are not enabled")
+	}
+	return m, nil
+}
+
+// render returns the status of a job as file content
+func render(status jobs.Status) ([]byte, error) {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (fs *jobsFS) content(path string) ([]byte, error) {
+	switch path {
+	case "/":
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	case fileReadme:
+		return []byte(fs.plugin.GetReadme()), nil
+	}
+	m, err := fs.manager(path)
+	if err != nil {
+		return nil, err
+	}
+	status, err := m.Get(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	return render(status)
+}
+
+func (fs *jobsFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(filesystem.NormalizePath(path))
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *jobsFS) fileInfo(name string, size int64, modTime time.Time, mode uint32, fileType string) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    size,
+		Mode:    mode,
+		ModTime: modTime,
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: fileType},
+	}
+}
+
+// jobInfo describes the file of a job
+func (fs *jobsFS) jobInfo(status jobs.Status) (filesystem.FileInfo, error) {
+	data, err := render(status)
+	if err != nil {
+		return filesystem.FileInfo{}, err
+	}
+	modTime := status.Created
+	if status.Finished != nil {
+		modTime = *status.Finished
+	}
+	return fs.fileInfo(status.ID, int64(len(data)), modTime, 0644, "job"), nil
+}
+
+func (fs *jobsFS) Stat(path string) (*filesystem.FileInfo, error) {
+	path = filesystem.NormalizePath(path)
+	switch path {
+	case "/":
+		return &filesystem.FileInfo{
+			Name:    "/",
+			Mode:    0555,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName},
+		}, nil
+	case fileReadme:
+		info := fs.fileInfo("README", int64(len(fs.plugin.GetReadme())), time.Now(), 0444, "doc")
+		return &info, nil
+	}
+	m, err := fs.manager(path)
+	if err != nil {
+		return nil, err
+	}
+	status, err := m.Get(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	info, err := fs.jobInfo(status)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (fs *jobsFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(path) != "/" {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	files := []filesystem.FileInfo{
+		fs.fileInfo("README", int64(len(fs.plugin.GetReadme())), time.Now(), 0444, "doc"),
+	}
+	m := jobs.Default()
+	if m == nil {
+		return files, nil
+	}
+	for _, status := range m.List() {
+		info, err := fs.jobInfo(status)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+func (fs *jobsFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Write cancels the job of path when data is "cancel" and returns its final status
+func (fs *jobsFS) Write(path string, data []byte) ([]byte, error) {
+	path = filesystem.NormalizePath(path)
+	if path == "/" || path == fileReadme {
+		return nil, fs.readOnly("write", path)
+	}
+	if strings.TrimSpace(string(data)) != "cancel" {
+		return nil, filesystem.NewInvalidArgumentError("data", strings.TrimSpace(string(data)), `only "cancel" can be written to a job`)
+	}
+	m, err := fs.manager(path)
+	if err != nil {
+		return nil, err
+	}
+	status, err := m.Cancel(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("write", path)
+	}
+	return render(status)
+}
+
+func (fs *jobsFS) readOnly(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, path, "jobs can only be canceled")
+}
+
+func (fs *jobsFS) Create(path string) error             { return fs.readOnly("create", path) }
+func (fs *jobsFS) Mkdir(path string, perm uint32) error { return fs.readOnly("mkdir", path) }
+func (fs *jobsFS) Remove(path string) error             { return fs.readOnly("remove", path) }
+func (fs *jobsFS) RemoveAll(path string) error          { return fs.readOnly("removeall", path) }
+func (fs *jobsFS) Rename(oldPath, newPath string) error { return fs.readOnly("rename", oldPath) }
+func (fs *jobsFS) Chmod(path string, mode uint32) error { return fs.readOnly("chmod", path) }
+
+func (fs *jobsFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, fs.readOnly("openwrite", path)
+}
+
+// Ensure JobsFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*JobsFSPlugin)(nil)
+var _ filesystem.FileSystem = (*jobsFS)(nil)
@@ -0,0 +1,70 @@
+package jobsfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/jobs"
+)
+
+// Job files report the status of the jobs, and writing "cancel" cancels them
+func TestJobFiles(t *testing.T) {
+	fs := NewJobsFSPlugin().GetFileSystem()
+	if entries, err := fs.ReadDir("/"); err != nil || len(entries) != 1 {
+		t.Errorf("root without jobs = %+v, %v", entries, err)
+	}
+	if _, err := fs.Stat("/abc"); !errors.Is(err, filesystem.ErrUnavailable) {
+		t.Errorf("stat without jobs: %v", err)
+	}
+
+	m := jobs.NewManager(jobs.Options{})
+	defer m.Close()
+	jobs.SetDefault(m)
+	defer jobs.SetDefault(nil)
+	started := m.Start(jobs.Spec{Type: "remove", Path: "/memfs/tmp"}, func(ctx context.Context, p *jobs.Progress) error {
+		p.Add(3, 100)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	entries, err := fs.ReadDir("/")
+	if err != nil || len(entries) != 2 || entries[1].Name != started.ID {
+		t.Fatalf("root = %+v, %v", entries, err)
+	}
+	data, err := fs.Read("/"+started.ID, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	var status jobs.Status
+	if err := json.Unmarshal(data, &status); err != nil || status.State != jobs.StateRunning || status.Path != "/memfs/tmp" {
+		t.Errorf("job file = %s, %v", data, err)
+	}
+
+	if _, err := fs.Write("/"+started.ID, []byte("stop")); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("write of stop: %v", err)
+	}
+	if _, err := fs.Write("/README", []byte("cancel")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("write to the README: %v", err)
+	}
+	if _, err := fs.Write("/unknown", []byte("cancel")); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("cancel of an unknown job: %v", err)
+	}
+	data, err = fs.Write("/"+started.ID, []byte("cancel\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &status); err != nil || status.State != jobs.StateCanceled || status.Done != 3 {
+		t.Errorf("cancel = %s, %v", data, err)
+	}
+	info, err := fs.Stat("/" + started.ID)
+	if err != nil || info.Meta.Type != "job" || !info.ModTime.Equal(*status.Finished) {
+		t.Errorf("stat of an ended job = %+v, %v", info, err)
+	}
+	if err := fs.Remove("/" + started.ID); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("remove: %v", err)
+	}
+}
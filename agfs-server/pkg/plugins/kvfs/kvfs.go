@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
 )
 
 const (
@@ -32,21 +34,30 @@ const (
 //	DELETE /keys/<key> - Delete key
 //	GET /keys          - List all keys
 type KVFSPlugin struct {
-	store    map[string][]byte
-	mu       sync.RWMutex
-	metadata plugin.PluginMetadata
+	store       map[string][]byte
+	modTimes    map[string]time.Time // Last write of each key
+	keysModTime time.Time            // Last change to the keys, the modification time of /keys
+	started     time.Time            // Modification time of / and the README
+	mu          sync.RWMutex
+	metadata    plugin.PluginMetadata
+	readme      plugin.ReadmeData // Mount the README is rendered for
 }
 
 // NewKVFSPlugin creates a new key-value store plugin
 func NewKVFSPlugin() *KVFSPlugin {
+	now := time.Now()
 	return &KVFSPlugin{
-		store: make(map[string][]byte),
+		store:       make(map[string][]byte),
+		modTimes:    make(map[string]time.Time),
+		keysModTime: now,
+		started:     now,
 		metadata: plugin.PluginMetadata{
 			Name:        PluginName,
 			Version:     "1.0.0",
 			Description: "Key-Value store service plugin",
 			Author:      "VFS Server",
 		},
+		readme: plugin.NewReadmeData(PluginName, "", nil),
 	}
 }
 
@@ -83,11 +94,21 @@ func (kv *KVFSPlugin) Validate(cfg map[string]interface{}) error {
 	return nil
 }
 
-func (kv *KVFSPlugin) Initialize(config map[string]interface{}) error {
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (kv *KVFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "initial_data", Type: plugin.ParamMap, Description: "Keys and values stored at startup"},
+	}
+}
+
+func (kv *KVFSPlugin) Initialize(cfg map[string]interface{}) error {
+	kv.readme = plugin.NewReadmeData(PluginName, config.GetStringConfig(cfg, "mount_path", ""), cfg)
+
 	// Load initial data if provided
-	if data, ok := config["initial_data"].(map[string]string); ok {
+	if data, ok := cfg["initial_data"].(map[string]string); ok {
 		for k, v := range data {
 			kv.store[k] = []byte(v)
+			kv.modTimes[k] = kv.started
 		}
 	}
 	return nil
@@ -98,56 +119,33 @@ func (kv *KVFSPlugin) GetFileSystem() filesystem.FileSystem {
 }
 
 func (kv *KVFSPlugin) GetReadme() string {
-	return `KVFS Plugin - Key-Value Store Service
-
-This plugin provides a key-value store service through a file system interface.
-
-USAGE:
-  Set a key-value pair:
-    echo "value" > /keys/<key>
-
-  Get a value:
-    cat /keys/<key>
-
-  List all keys:
-    ls /keys
-
-  Delete a key:
-    rm /keys/<key>
-
-  Rename a key:
-    mv /keys/<oldkey> /keys/<newkey>
-
-STRUCTURE:
-  /keys/     - Directory containing all key-value pairs
-  /README    - This file
-
-EXAMPLES:
-  # Set a value
-  agfs:/> echo "hello world" > /kvfs/keys/mykey
-
-  # Get a value
-  agfs:/> cat /kvfs/keys/mykey
-  hello world
-
-  # List all keys
-  agfs:/> ls /kvfs/keys
-
-  # Delete a key
-  agfs:/> rm /kvfs/keys/mykey
-
-  # Rename a key
-  agfs:/> mv /kvfs/keys/oldname /kvfs/keys/newname
-`
+	return plugin.Readme(readmeTemplates, kv.readme)
 }
 
 func (kv *KVFSPlugin) Shutdown() error {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
 	kv.store = nil
+	kv.modTimes = nil
 	return nil
 }
 
+// touch records a write of key; the caller must hold mu for writing
+func (kv *KVFSPlugin) touch(key string) {
+	now := time.Now()
+	kv.modTimes[key] = now
+	kv.keysModTime = now
+}
+
+// dirMeta returns the metadata of a directory with n entries
+func dirMeta(n int) filesystem.MetaData {
+	return filesystem.MetaData{
+		Name:    PluginName,
+		Type:    MetaValueDir,
+		Content: map[string]string{filesystem.MetaKeyChildren: strconv.Itoa(n)},
+	}
+}
+
 // kvFS implements the FileSystem interface for key-value operations
 type kvFS struct {
 	plugin *KVFSPlugin
@@ -176,6 +174,7 @@ func (kvfs *kvFS) Create(path string) error {
 	}
 
 	kvfs.plugin.store[key] = []byte{}
+	kvfs.plugin.touch(key)
 	return nil
 }
 
@@ -204,6 +203,8 @@ func (kvfs *kvFS) Remove(path string) error {
 	}
 
 	delete(kvfs.plugin.store, key)
+	delete(kvfs.plugin.modTimes, key)
+	kvfs.plugin.keysModTime = time.Now()
 	return nil
 }
 
@@ -213,6 +214,8 @@ func (kvfs *kvFS) RemoveAll(path string) error {
 		kvfs.plugin.mu.Lock()
 		defer kvfs.plugin.mu.Unlock()
 		kvfs.plugin.store = make(map[string][]byte)
+		kvfs.plugin.modTimes = make(map[string]time.Time)
+		kvfs.plugin.keysModTime = time.Now()
 		return nil
 	}
 	return kvfs.Remove(path)
@@ -265,19 +268,43 @@ func (kvfs *kvFS) Write(path string, data []byte) ([]byte, error) {
 	defer kvfs.plugin.mu.Unlock()
 
 	kvfs.plugin.store[key] = data
+	kvfs.plugin.touch(key)
 	return nil, nil
 }
 
+// Append implements filesystem.Appender, the value grows under the store lock
+func (kvfs *kvFS) Append(path string, data []byte) (int64, error) {
+	if !strings.HasPrefix(path, "/keys/") {
+		return 0, fmt.Errorf("keys must be under /keys/ directory")
+	}
+
+	key := strings.TrimPrefix(path, "/keys/")
+	if key == "" {
+		return 0, fmt.Errorf("key name cannot be empty")
+	}
+
+	kvfs.plugin.mu.Lock()
+	defer kvfs.plugin.mu.Unlock()
+
+	// Readers only see the old length of the value, appending past it is safe
+	value := append(kvfs.plugin.store[key], data...)
+	kvfs.plugin.store[key] = value
+	kvfs.plugin.touch(key)
+	return int64(len(value)), nil
+}
+
 func (kvfs *kvFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	if path == "/" {
 		// Root directory contains /keys and README
 		readme := kvfs.plugin.GetReadme()
+		kvfs.plugin.mu.RLock()
+		defer kvfs.plugin.mu.RUnlock()
 		return []filesystem.FileInfo{
 			{
 				Name:    "README",
 				Size:    int64(len(readme)),
 				Mode:    0444,
-				ModTime: time.Now(),
+				ModTime: kvfs.plugin.started,
 				IsDir:   false,
 				Meta: filesystem.MetaData{
 					Name: PluginName,
@@ -288,12 +315,9 @@ func (kvfs *kvFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name:    "keys",
 				Size:    0,
 				Mode:    0755,
-				ModTime: time.Now(),
+				ModTime: kvfs.plugin.keysModTime,
 				IsDir:   true,
-				Meta: filesystem.MetaData{
-					Name: PluginName,
-					Type: MetaValueDir,
-				},
+				Meta:    dirMeta(len(kvfs.plugin.store)),
 			},
 		}, nil
 	}
@@ -309,7 +333,7 @@ func (kvfs *kvFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name:    filepath.Base(key),
 				Size:    int64(len(value)),
 				Mode:    0644,
-				ModTime: time.Now(),
+				ModTime: kvfs.plugin.modTimes[key],
 				IsDir:   false,
 				Meta: filesystem.MetaData{
 					Name: PluginName,
@@ -325,17 +349,27 @@ func (kvfs *kvFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 }
 
 func (kvfs *kvFS) Stat(path string) (*filesystem.FileInfo, error) {
-	if path == "/" || path == "/keys" {
+	if path == "/" {
 		return &filesystem.FileInfo{
 			Name:    filepath.Base(path),
 			Size:    0,
 			Mode:    0755,
-			ModTime: time.Now(),
+			ModTime: kvfs.plugin.started,
 			IsDir:   true,
-			Meta: filesystem.MetaData{
-				Name: PluginName,
-				Type: MetaValueDir,
-			},
+			Meta:    dirMeta(2),
+		}, nil
+	}
+
+	if path == "/keys" {
+		kvfs.plugin.mu.RLock()
+		defer kvfs.plugin.mu.RUnlock()
+		return &filesystem.FileInfo{
+			Name:    "keys",
+			Size:    0,
+			Mode:    0755,
+			ModTime: kvfs.plugin.keysModTime,
+			IsDir:   true,
+			Meta:    dirMeta(len(kvfs.plugin.store)),
 		}, nil
 	}
 
@@ -345,7 +379,7 @@ func (kvfs *kvFS) Stat(path string) (*filesystem.FileInfo, error) {
 			Name:    "README",
 			Size:    int64(len(readme)),
 			Mode:    0444,
-			ModTime: time.Now(),
+			ModTime: kvfs.plugin.started,
 			IsDir:   false,
 			Meta: filesystem.MetaData{
 				Name: PluginName,
@@ -375,7 +409,7 @@ func (kvfs *kvFS) Stat(path string) (*filesystem.FileInfo, error) {
 		Name:    filepath.Base(key),
 		Size:    int64(len(value)),
 		Mode:    0644,
-		ModTime: time.Now(),
+		ModTime: kvfs.plugin.modTimes[key],
 		IsDir:   false,
 		Meta: filesystem.MetaData{
 			Name: PluginName,
@@ -409,7 +443,10 @@ func (kvfs *kvFS) Rename(oldPath, newPath string) error {
 	}
 
 	kvfs.plugin.store[newKey] = value
+	kvfs.plugin.modTimes[newKey] = kvfs.plugin.modTimes[oldKey]
 	delete(kvfs.plugin.store, oldKey)
+	delete(kvfs.plugin.modTimes, oldKey)
+	kvfs.plugin.keysModTime = time.Now()
 
 	return nil
 }
@@ -445,3 +482,5 @@ func (kw *kvWriter) Close() error {
 	return err
 }
 
+// Ensure kvFS appends in place
+var _ filesystem.Appender = (*kvFS)(nil)
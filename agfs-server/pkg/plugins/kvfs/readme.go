@@ -0,0 +1,94 @@
+package kvfs
+
+import "github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+
+// readmeTemplates are the README of the plugin by language, see plugin.ReadmeTemplater
+var readmeTemplates = map[string]string{
+	plugin.LangEnglish: `KVFS Plugin - Key-Value Store Service
+
+This plugin provides a key-value store service through a file system interface.
+
+USAGE:
+  Set a key-value pair:
+    echo "value" > {{.MountPath}}/keys/<key>
+
+  Get a value:
+    cat {{.MountPath}}/keys/<key>
+
+  List all keys:
+    ls {{.MountPath}}/keys
+
+  Delete a key:
+    rm {{.MountPath}}/keys/<key>
+
+  Rename a key:
+    mv {{.MountPath}}/keys/<oldkey> {{.MountPath}}/keys/<newkey>
+
+STRUCTURE:
+  /keys/     - Directory containing all key-value pairs
+  /README    - This file
+
+EXAMPLES:
+  # Set a value
+  agfs:/> echo "hello world" > {{.MountPath}}/keys/mykey
+
+  # Get a value
+  agfs:/> cat {{.MountPath}}/keys/mykey
+  hello world
+
+  # List all keys
+  agfs:/> ls {{.MountPath}}/keys
+
+  # Delete a key
+  agfs:/> rm {{.MountPath}}/keys/mykey
+
+  # Rename a key
+  agfs:/> mv {{.MountPath}}/keys/oldname {{.MountPath}}/keys/newname
+`,
+	plugin.LangChinese: `KVFS 插件 - 键值存储服务
+
+此插件通过文件系统接口提供键值存储服务。
+
+用法:
+  设置键值:
+    echo "value" > {{.MountPath}}/keys/<key>
+
+  读取值:
+    cat {{.MountPath}}/keys/<key>
+
+  列出所有键:
+    ls {{.MountPath}}/keys
+
+  删除键:
+    rm {{.MountPath}}/keys/<key>
+
+  重命名键:
+    mv {{.MountPath}}/keys/<oldkey> {{.MountPath}}/keys/<newkey>
+
+结构:
+  /keys/     - 存放所有键值对的目录
+  /README    - 本文件
+
+示例:
+  # 设置值
+  agfs:/> echo "hello world" > {{.MountPath}}/keys/mykey
+
+  # 读取值
+  agfs:/> cat {{.MountPath}}/keys/mykey
+  hello world
+
+  # 列出所有键
+  agfs:/> ls {{.MountPath}}/keys
+
+  # 删除键
+  agfs:/> rm {{.MountPath}}/keys/mykey
+
+  # 重命名键
+  agfs:/> mv {{.MountPath}}/keys/oldname {{.MountPath}}/keys/newname
+`,
+}
+
+// ReadmeTemplates implements plugin.ReadmeTemplater
+func (kv *KVFSPlugin) ReadmeTemplates() map[string]string {
+	return readmeTemplates
+}
@@ -0,0 +1,18 @@
+package localfs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem/filesystemtest"
+)
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := NewLocalFS(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
@@ -23,6 +23,7 @@ type LocalFS struct {
 	basePath   string // The local directory to mount
 	mu         sync.RWMutex
 	pluginName string
+	watch      watchState // See Watch
 }
 
 // NewLocalFS creates a new local file system
@@ -262,7 +263,111 @@ func (fs *LocalFS) Write(path string, data []byte) ([]byte, error) {
 	return []byte(fmt.Sprintf("Written %d bytes to %s", len(data), path)), nil
 }
 
+// Copy implements filesystem.Copier; on Linux the kernel copies the data
+func (fs *LocalFS) Copy(src, dst string) (int64, error) {
+	srcPath, dstPath := fs.resolvePath(src), fs.resolvePath(dst)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, filesystem.NewNotFoundError("copy", src)
+		}
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+	if info, err := in.Stat(); err == nil && info.IsDir() {
+		return 0, fmt.Errorf("is a directory: %s", src)
+	}
+	if info, err := os.Stat(dstPath); err == nil && info.IsDir() {
+		return 0, fmt.Errorf("is a directory: %s", dst)
+	}
+	if _, err := os.Stat(filepath.Dir(dstPath)); os.IsNotExist(err) {
+		return 0, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(dst))
+	}
+
+	out, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to copy file: %w", err)
+	}
+	return n, nil
+}
+
+// Append implements filesystem.Appender with O_APPEND, each append lands at the end of the
+// file even with other writers
+func (fs *LocalFS) Append(path string, data []byte) (int64, error) {
+	localPath := fs.resolvePath(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		return 0, fmt.Errorf("is a directory: %s", path)
+	}
+	if _, err := os.Stat(filepath.Dir(localPath)); os.IsNotExist(err) {
+		return 0, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
+	}
+
+	f, err := os.OpenFile(localPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to append to file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// WriteAt implements filesystem.WriterAt with pwrite, so resumed uploads do not rewrite the file
+func (fs *LocalFS) WriteAt(path string, data []byte, offset int64) (int64, error) {
+	localPath := fs.resolvePath(path)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		return 0, fmt.Errorf("is a directory: %s", path)
+	}
+	if _, err := os.Stat(filepath.Dir(localPath)); os.IsNotExist(err) {
+		return 0, fmt.Errorf("parent directory does not exist: %s", filepath.Dir(path))
+	}
+
+	f, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(data, offset); err != nil {
+		return 0, fmt.Errorf("failed to write file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat: %w", err)
+	}
+	return info.Size(), nil
+}
+
 func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return fs.ReadDirFields(path, filesystem.AllFields)
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+// Listings of names only skip the stat of each entry
+func (fs *LocalFS) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
 	localPath := fs.resolvePath(path)
 
 	fs.mu.RLock()
@@ -287,8 +392,20 @@ func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("failed to read directory: %w", err)
 	}
 
+	needInfo := fields.Size || fields.Mode || fields.ModTime
 	var files []filesystem.FileInfo
 	for _, entry := range entries {
+		if !needInfo {
+			files = append(files, filesystem.FileInfo{
+				Name:  entry.Name(),
+				IsDir: entry.IsDir(),
+				Meta: filesystem.MetaData{
+					Name: PluginName,
+					Type: "local",
+				},
+			})
+			continue
+		}
 		entryInfo, err := entry.Info()
 		if err != nil {
 			continue
@@ -586,6 +703,13 @@ func (p *LocalFSPlugin) Validate(cfg map[string]interface{}) error {
 	return nil
 }
 
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *LocalFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "local_dir", Type: plugin.ParamString, Required: true, Description: "Existing local directory served by the mount"},
+	}
+}
+
 func (p *LocalFSPlugin) Initialize(config map[string]interface{}) error {
 	// Parse configuration (validation already done in Validate)
 	basePath := config["local_dir"].(string)
@@ -718,9 +842,17 @@ AUTHOR: AGFS Server
 
 func (p *LocalFSPlugin) Shutdown() error {
 	log.Infof("[localfs] Shutting down")
+	if p.fs != nil {
+		p.fs.watch.close()
+	}
 	return nil
 }
 
 // Ensure LocalFSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*LocalFSPlugin)(nil)
 var _ filesystem.FileSystem = (*LocalFS)(nil)
+var _ filesystem.Appender = (*LocalFS)(nil)
+var _ filesystem.WriterAt = (*LocalFS)(nil)
+var _ filesystem.Copier = (*LocalFS)(nil)
+var _ filesystem.Watcher = (*LocalFS)(nil)
+var _ filesystem.FieldLister = (*LocalFS)(nil)
@@ -0,0 +1,242 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// inotifyMask are the inotify events turned into watch events
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DONT_FOLLOW | syscall.IN_EXCL_UNLINK
+
+// watchState watches the directories of a LocalFS with inotify once something is watched,
+// so changes made by other processes are reported too
+// Directories stay watched until the plugin shuts down.
+type watchState struct {
+	once sync.Once
+	err  error
+	fd   int
+	file *os.File
+
+	mu   sync.Mutex
+	dirs map[int32]string // Watched directories by watch descriptor, relative to the base path
+	wds  map[string]int32
+
+	watchers filesystem.Watchers
+}
+
+// Watch implements filesystem.Watcher with inotify
+func (fs *LocalFS) Watch(ctx context.Context, p string, recursive bool) (<-chan filesystem.Event, error) {
+	p = filesystem.NormalizePath(p)
+	info, err := os.Stat(fs.resolvePath(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, filesystem.NewNotFoundError("watch", p)
+		}
+		return nil, fmt.Errorf("failed to stat: %w", err)
+	}
+
+	w := &fs.watch
+	w.once.Do(func() { w.err = w.start(fs) })
+	if w.err != nil {
+		return nil, w.err
+	}
+	dir := p
+	if !info.IsDir() {
+		dir = path.Dir(p)
+	}
+	if err := w.add(fs, dir, recursive && info.IsDir(), false); err != nil {
+		return nil, err
+	}
+	return w.watchers.Watch(ctx, p, recursive)
+}
+
+// start opens the inotify instance and starts reading its events
+func (w *watchState) start(lfs *LocalFS) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("failed to start watching: %w", err)
+	}
+	w.fd = fd
+	w.file = os.NewFile(uintptr(fd), "inotify")
+	w.dirs = make(map[int32]string)
+	w.wds = make(map[string]int32)
+	go w.read(lfs)
+	return nil
+}
+
+// add watches dir and with recursive its subdirectories; with announce, their entries are
+// reported as created, for directories that appeared before they were watched
+func (w *watchState) add(lfs *LocalFS, dir string, recursive, announce bool) error {
+	if !recursive {
+		return w.addDir(lfs, dir)
+	}
+	return filepath.WalkDir(lfs.resolvePath(dir), func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(lfs.basePath, name)
+		if err != nil {
+			return err
+		}
+		p := filesystem.NormalizePath(filepath.ToSlash(rel))
+		if announce && p != dir {
+			w.watchers.Emit(filesystem.Event{Type: filesystem.EventCreate, Path: p, IsDir: d.IsDir()})
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.addDir(lfs, p)
+	})
+}
+
+func (w *watchState) addDir(lfs *LocalFS, dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.wds[dir]; ok {
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, lfs.resolvePath(dir), inotifyMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return nil // Gone already, its removal is reported by its parent
+		}
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	w.dirs[int32(wd)] = dir
+	w.wds[dir] = int32(wd)
+	return nil
+}
+
+// moved updates the watched directories below oldDir, renamed to newDir within the base
+// path, or stops watching them if newDir is empty
+func (w *watchState) moved(oldDir, newDir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, dir := range w.dirs {
+		if dir != oldDir && !strings.HasPrefix(dir, oldDir+"/") {
+			continue
+		}
+		delete(w.wds, dir)
+		if newDir == "" {
+			delete(w.dirs, wd)
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			continue
+		}
+		dir = newDir + strings.TrimPrefix(dir, oldDir)
+		w.dirs[wd] = dir
+		w.wds[dir] = wd
+	}
+}
+
+// read turns inotify events into watch events until the instance is closed
+func (w *watchState) read(lfs *LocalFS) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Errorf("[localfs] watching stopped: %v", err)
+			}
+			return
+		}
+
+		// A file moved out of a watched directory has no IN_MOVED_TO; the kernel queues
+		// both halves of a move together, so one left at the end of a read is a removal
+		var movedFrom *filesystem.Event
+		var cookie uint32
+		flush := func() {
+			if movedFrom != nil {
+				movedFrom.Type = filesystem.EventRemove
+				w.watchers.Emit(*movedFrom)
+				if movedFrom.IsDir {
+					w.moved(movedFrom.Path, "")
+				}
+				movedFrom = nil
+			}
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+			mask := raw.Mask
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				log.Warnf("[localfs] inotify queue overflowed, events were lost")
+				continue
+			}
+			w.mu.Lock()
+			dir, ok := w.dirs[raw.Wd]
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, raw.Wd)
+				if ok && w.wds[dir] == raw.Wd {
+					delete(w.wds, dir)
+				}
+			}
+			w.mu.Unlock()
+			if !ok || len(nameBytes) == 0 {
+				continue
+			}
+			e := filesystem.Event{
+				Path:  path.Join(dir, strings.TrimRight(string(nameBytes), "\x00")),
+				IsDir: mask&syscall.IN_ISDIR != 0,
+			}
+
+			switch {
+			case mask&syscall.IN_CREATE != 0:
+				e.Type = filesystem.EventCreate
+				w.watchers.Emit(e)
+				if e.IsDir && w.watchers.Covered(e.Path, true) {
+					w.add(lfs, e.Path, true, true)
+				}
+			case mask&syscall.IN_MODIFY != 0:
+				e.Type = filesystem.EventWrite
+				w.watchers.Emit(e)
+			case mask&syscall.IN_DELETE != 0:
+				e.Type = filesystem.EventRemove
+				w.watchers.Emit(e)
+			case mask&syscall.IN_MOVED_FROM != 0:
+				flush()
+				movedFrom, cookie = &e, raw.Cookie
+			case mask&syscall.IN_MOVED_TO != 0:
+				if movedFrom != nil && cookie == raw.Cookie {
+					e.Type, e.OldPath = filesystem.EventRename, movedFrom.Path
+					movedFrom = nil
+					if e.IsDir {
+						w.moved(e.OldPath, e.Path)
+					}
+				} else {
+					e.Type = filesystem.EventCreate
+				}
+				w.watchers.Emit(e)
+				if e.IsDir && w.watchers.Covered(e.Path, true) {
+					w.add(lfs, e.Path, true, e.Type == filesystem.EventCreate)
+				}
+			}
+		}
+		flush()
+	}
+}
+
+// close stops watching, and keeps later watches from starting
+func (w *watchState) close() {
+	w.once.Do(func() { w.err = fmt.Errorf("localfs is shut down") })
+	if w.file != nil {
+		w.file.Close()
+	}
+}
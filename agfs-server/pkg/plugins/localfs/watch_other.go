@@ -0,0 +1,25 @@
+//go:build !linux
+
+package localfs
+
+import (
+	"context"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// watchInterval is how often watched trees are compared for changes without inotify
+const watchInterval = 2 * time.Second
+
+// watchState is empty without inotify, watches poll
+type watchState struct{}
+
+// Watch implements filesystem.Watcher by polling, see filesystem.PollWatch
+func (fs *LocalFS) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	return filesystem.PollWatch(ctx, func() (map[string]filesystem.FileInfo, error) {
+		return filesystem.ListTree(fs, path, recursive)
+	}, watchInterval)
+}
+
+func (w *watchState) close() {}
@@ -0,0 +1,382 @@
+package memfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// Journal operations
+const (
+	journalCreate    = "create"
+	journalMkdir     = "mkdir"
+	journalWrite     = "write"
+	journalRemove    = "remove"
+	journalRemoveAll = "removeall"
+	journalRename    = "rename"
+	journalChmod     = "chmod"
+	journalTouch     = "touch" // Sets the modification time of a directory, see CompactJournal
+)
+
+// journalRecord is one line of the journal
+type journalRecord struct {
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	NewPath string `json:"new_path,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Mode    uint32 `json:"mode,omitempty"`
+	Time    int64  `json:"time"` // Modification time, Unix nanoseconds
+}
+
+// journal appends mutations to a file so they can be replayed after a restart
+// Records are JSON lines written while the MemoryFS write lock is held, so the
+// journal order is the order in which mutations were applied
+type journal struct {
+	path  string
+	fsync bool
+
+	mu      sync.Mutex // protects the fields below
+	file    *os.File
+	records int // Records appended since the last compaction
+}
+
+// openJournal opens the journal file for appending, creating it if needed
+func openJournal(p string, fsync bool) (*journal, error) {
+	if dir := path.Dir(p); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create journal directory: %w", err)
+		}
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &journal{path: p, fsync: fsync, file: f}, nil
+}
+
+func (j *journal) append(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(line); err != nil {
+		return fmt.Errorf("journal write failed: %w", err)
+	}
+	if j.fsync {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("journal sync failed: %w", err)
+		}
+	}
+	j.records++
+	return nil
+}
+
+func (j *journal) pending() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.records
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// record appends a mutation to the journal if journaling is enabled
+// The caller must hold mfs.mu for writing
+func (mfs *MemoryFS) record(rec journalRecord) error {
+	if mfs.journal == nil {
+		return nil
+	}
+	rec.Path = filesystem.NormalizePath(rec.Path)
+	if rec.NewPath != "" {
+		rec.NewPath = filesystem.NormalizePath(rec.NewPath)
+	}
+	if rec.Time == 0 {
+		rec.Time = time.Now().UnixNano()
+	}
+	return mfs.journal.append(rec)
+}
+
+// EnableJournal replays the journal at p into the filesystem and appends every later
+// mutation to it. With fsync, each record is synced to disk before the mutation returns.
+// A record torn by a crash at the end of the journal is discarded.
+func (mfs *MemoryFS) EnableJournal(p string, fsync bool) error {
+	j, err := openJournal(p, fsync)
+	if err != nil {
+		return err
+	}
+
+	replayed, err := mfs.replay(j.file)
+	if err != nil {
+		j.close()
+		return err
+	}
+
+	mfs.mu.Lock()
+	mfs.journal = j
+	mfs.mu.Unlock()
+
+	log.Infof("[memfs] replayed %d journal records from %s", replayed, p)
+	if replayed > 0 {
+		// Start from a compact journal so replay time does not grow across restarts
+		return mfs.CompactJournal()
+	}
+	return nil
+}
+
+// replay applies the records of f and truncates a torn final record
+func (mfs *MemoryFS) replay(f *os.File) (int, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	count := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Warnf("[memfs] discarding incomplete journal record at offset %d", offset)
+				if err := f.Truncate(offset); err != nil {
+					return count, fmt.Errorf("failed to truncate journal: %w", err)
+				}
+			}
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return count, fmt.Errorf("corrupt journal record at offset %d: %w", offset, err)
+		}
+		if err := mfs.apply(rec); err != nil {
+			// The record was journaled after the mutation succeeded, so this only
+			// happens if the journal was edited; skip it like the original operation would fail
+			log.Warnf("[memfs] skipping journal record %s %s: %v", rec.Op, rec.Path, err)
+		}
+		offset += int64(len(line))
+		count++
+	}
+}
+
+// apply replays a single record; the caller must hold mfs.mu for writing
+func (mfs *MemoryFS) apply(rec journalRecord) error {
+	modTime := time.Unix(0, rec.Time)
+
+	switch rec.Op {
+	case journalCreate, journalMkdir, journalWrite:
+		parent, name, err := mfs.getParentNode(rec.Path)
+		if err != nil {
+			return err
+		}
+		node, exists := parent.Children[name]
+		if !exists {
+			node = &Node{Name: name, IsDir: rec.Op == journalMkdir, Mode: rec.Mode}
+			if node.IsDir {
+				node.Children = make(map[string]*Node)
+			} else {
+				node.Data = []byte{}
+			}
+			parent.Children[name] = node
+		}
+		if rec.Op == journalWrite {
+			node.Data = rec.Data
+		}
+		node.ModTime = modTime
+		touch(parent, modTime)
+	case journalRemove, journalRemoveAll:
+		if rec.Path == "/" {
+			mfs.root.Children = make(map[string]*Node)
+			touch(mfs.root, modTime)
+			return nil
+		}
+		parent, name, err := mfs.getParentNode(rec.Path)
+		if err != nil {
+			return err
+		}
+		delete(parent.Children, name)
+		touch(parent, modTime)
+	case journalRename:
+		oldParent, oldName, err := mfs.getParentNode(rec.Path)
+		if err != nil {
+			return err
+		}
+		node, exists := oldParent.Children[oldName]
+		if !exists {
+			return fmt.Errorf("no such file or directory: %s", rec.Path)
+		}
+		newParent, newName, err := mfs.getParentNode(rec.NewPath)
+		if err != nil {
+			return err
+		}
+		delete(oldParent.Children, oldName)
+		node.Name = newName
+		newParent.Children[newName] = node
+		touch(oldParent, modTime)
+		touch(newParent, modTime)
+	case journalChmod:
+		node, err := mfs.getNode(rec.Path)
+		if err != nil {
+			return err
+		}
+		node.Mode = rec.Mode
+	case journalTouch:
+		node, err := mfs.getNode(rec.Path)
+		if err != nil {
+			return err
+		}
+		node.ModTime = modTime
+	default:
+		return fmt.Errorf("unknown journal operation %q", rec.Op)
+	}
+	return nil
+}
+
+// CompactJournal rewrites the journal as the minimal set of records that rebuild the
+// current tree. Writers are blocked while the new journal is written; readers are not.
+func (mfs *MemoryFS) CompactJournal() error {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	j := mfs.journal
+	if j == nil {
+		return nil
+	}
+
+	tmpPath := j.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted journal: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	count := 0
+	var writeNode func(p string, n *Node) error
+	writeNode = func(p string, n *Node) error {
+		if p != "/" {
+			rec := journalRecord{Op: journalWrite, Path: p, Data: n.Data, Mode: n.Mode, Time: n.ModTime.UnixNano()}
+			if n.IsDir {
+				rec = journalRecord{Op: journalMkdir, Path: p, Mode: n.Mode, Time: n.ModTime.UnixNano()}
+			}
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+			count++
+		}
+		// Sorted for a deterministic journal
+		names := make([]string, 0, len(n.Children))
+		for name := range n.Children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := writeNode(path.Join(p, name), n.Children[name]); err != nil {
+				return err
+			}
+		}
+		if n.IsDir {
+			// Replaying the children moved the modification time of the directory to
+			// theirs, restore its own
+			if err := encoder.Encode(journalRecord{Op: journalTouch, Path: p, Time: n.ModTime.UnixNano()}); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}
+
+	err = writeNode("/", mfs.root)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write compacted journal: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace journal: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen journal: %w", err)
+	}
+	j.file.Close()
+	j.file = f
+	j.records = 0
+
+	log.Debugf("[memfs] compacted journal %s to %d records", j.path, count)
+	return nil
+}
+
+// startCompaction compacts the journal every interval if it has grown by at least minRecords
+func (mfs *MemoryFS) startCompaction(interval time.Duration, minRecords int) {
+	mfs.compactDone = make(chan struct{})
+	mfs.compactWG.Add(1)
+	go func() {
+		defer mfs.compactWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mfs.compactDone:
+				return
+			case <-ticker.C:
+				if mfs.journal.pending() < minRecords {
+					continue
+				}
+				if err := mfs.CompactJournal(); err != nil {
+					log.Errorf("[memfs] %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// closeJournal stops compaction and closes the journal file
+func (mfs *MemoryFS) closeJournal() error {
+	if mfs.compactDone != nil {
+		close(mfs.compactDone)
+		mfs.compactWG.Wait()
+		mfs.compactDone = nil
+	}
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+	if mfs.journal == nil {
+		return nil
+	}
+	err := mfs.journal.close()
+	mfs.journal = nil
+	return err
+}
@@ -0,0 +1,89 @@
+package memfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalReplay(t *testing.T) {
+	journalPath := filepath.Join(t.TempDir(), "memfs.journal")
+
+	fs := NewMemoryFS()
+	if err := fs.EnableJournal(journalPath, false); err != nil {
+		t.Fatal(err)
+	}
+	fs.Mkdir("/data", 0750)
+	fs.Write("/data/a.txt", []byte("first"))
+	fs.Write("/data/a.txt", []byte("second"))
+	fs.Write("/data/b.txt", []byte("gone"))
+	fs.Remove("/data/b.txt")
+	fs.Rename("/data/a.txt", "/data/c.txt")
+	fs.Chmod("/data/c.txt", 0600)
+	dirInfo, _ := fs.Stat("/data")
+	if err := fs.closeJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of appending a record
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"write","path":"/data/torn`)
+	f.Close()
+
+	restored := NewMemoryFS()
+	if err := restored.EnableJournal(journalPath, false); err != nil {
+		t.Fatal(err)
+	}
+	defer restored.closeJournal()
+
+	data, err := restored.Read("/data/c.txt", 0, -1)
+	if string(data) != "second" {
+		t.Fatalf("expected replayed content, got %q (%v)", data, err)
+	}
+	if info, err := restored.Stat("/data/c.txt"); err != nil || info.Mode != 0600 {
+		t.Fatalf("expected mode 0600, got %+v (%v)", info, err)
+	}
+	if info, err := restored.Stat("/data"); err != nil || info.Mode != 0750 || !info.ModTime.Equal(dirInfo.ModTime) {
+		t.Fatalf("expected directory mode 0750 modified at %v, got %+v (%v)", dirInfo.ModTime, info, err)
+	}
+	for _, p := range []string{"/data/a.txt", "/data/b.txt", "/data/torn"} {
+		if _, err := restored.Stat(p); err == nil {
+			t.Fatalf("%s should not exist after replay", p)
+		}
+	}
+
+	// Replay compacts the journal to one record per node and one per directory restoring
+	// its modification time
+	if _, err := restored.Write("/data/d.txt", []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(journalPath)
+	if lines := countLines(content); lines != 5 {
+		t.Fatalf("expected 5 journal records after compaction and one write, got %d", lines)
+	}
+
+	// The compacted journal keeps the modification time of directories
+	dirInfo, _ = restored.Stat("/data")
+	restored.closeJournal()
+	compacted := NewMemoryFS()
+	if err := compacted.EnableJournal(journalPath, false); err != nil {
+		t.Fatal(err)
+	}
+	defer compacted.closeJournal()
+	if info, err := compacted.Stat("/data"); err != nil || !info.ModTime.Equal(dirInfo.ModTime) {
+		t.Fatalf("expected directory modified at %v after compaction, got %+v (%v)", dirInfo.ModTime, info, err)
+	}
+}
+
+func countLines(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c == '\n' {
+			n++
+		}
+	}
+	return n
+}
@@ -2,6 +2,7 @@ package memfs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
@@ -10,17 +11,25 @@ import (
 
 const (
 	PluginName = "memfs" // Name of this plugin
+
+	// DefaultCompactInterval is how often the journal is checked for compaction
+	DefaultCompactInterval = 10 * time.Minute
+
+	// compactMinRecords is how many records must be appended before a periodic compaction
+	compactMinRecords = 1000
 )
 
 // MemFSPlugin wraps MemoryFS as a plugin
 type MemFSPlugin struct {
-	fs *MemoryFS
+	fs     *MemoryFS
+	readme plugin.ReadmeData // Mount the README is rendered for
 }
 
 // NewMemFSPlugin creates a new MemFS plugin
 func NewMemFSPlugin() *MemFSPlugin {
 	return &MemFSPlugin{
-		fs: NewMemoryFSWithPlugin(PluginName),
+		fs:     NewMemoryFSWithPlugin(PluginName),
+		readme: plugin.NewReadmeData(PluginName, "", nil),
 	}
 }
 
@@ -30,10 +39,21 @@ func (p *MemFSPlugin) Name() string {
 
 func (p *MemFSPlugin) Validate(cfg map[string]interface{}) error {
 	// Check for unknown parameters
-	allowedKeys := []string{"init_dirs", "mount_path"}
+	allowedKeys := []string{"init_dirs", "journal_path", "journal_fsync", "compact_interval", "mount_path"}
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
 	}
+	if err := config.ValidateStringType(cfg, "journal_path"); err != nil {
+		return err
+	}
+	if err := config.ValidateBoolType(cfg, "journal_fsync"); err != nil {
+		return err
+	}
+	if interval, err := config.GetDurationConfig(cfg, "compact_interval", DefaultCompactInterval); err != nil {
+		return err
+	} else if interval <= 0 {
+		return fmt.Errorf("compact_interval must be positive")
+	}
 
 	// Validate init_dirs if provided
 	if val, exists := cfg["init_dirs"]; exists {
@@ -48,7 +68,31 @@ func (p *MemFSPlugin) Validate(cfg map[string]interface{}) error {
 	return nil
 }
 
-func (p *MemFSPlugin) Initialize(config map[string]interface{}) error {
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *MemFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "init_dirs", Type: plugin.ParamStrings, Description: "Directories created at startup"},
+		{Name: "journal_path", Type: plugin.ParamString, Description: "Local file journaling changes, to restore the files after a restart"},
+		{Name: "journal_fsync", Type: plugin.ParamBool, Default: "false", Description: "Sync the journal after every change"},
+		{Name: "compact_interval", Type: plugin.ParamDuration, Default: DefaultCompactInterval.String(), Description: "How often the journal is compacted"},
+	}
+}
+
+func (p *MemFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.readme = plugin.NewReadmeData(PluginName, config.GetStringConfig(cfg, "mount_path", ""), cfg)
+
+	// Restore the previous contents before anything is written
+	if journalPath := config.GetStringConfig(cfg, "journal_path", ""); journalPath != "" {
+		if err := p.fs.EnableJournal(journalPath, config.GetBoolConfig(cfg, "journal_fsync", false)); err != nil {
+			return err
+		}
+		interval, err := config.GetDurationConfig(cfg, "compact_interval", DefaultCompactInterval)
+		if err != nil {
+			return err
+		}
+		p.fs.startCompaction(interval, compactMinRecords)
+	}
+
 	// Create README file
 	readme := []byte(p.GetReadme())
 	_ = p.fs.Create("/README")
@@ -56,8 +100,8 @@ func (p *MemFSPlugin) Initialize(config map[string]interface{}) error {
 	_ = p.fs.Chmod("/README", 0444) // Make it read-only
 
 	// Initialize with some default directories if needed
-	if config != nil {
-		if initDirs, ok := config["init_dirs"].([]string); ok {
+	if cfg != nil {
+		if initDirs, ok := cfg["init_dirs"].([]string); ok {
 			for _, dir := range initDirs {
 				_ = p.fs.Mkdir(dir, 0755)
 			}
@@ -71,58 +115,11 @@ func (p *MemFSPlugin) GetFileSystem() filesystem.FileSystem {
 }
 
 func (p *MemFSPlugin) GetReadme() string {
-	return `MemFS Plugin - In-Memory File System
-
-This plugin provides a full-featured in-memory file system.
-
-FEATURES:
-  - Standard file system operations (create, read, write, delete)
-  - Directory support with hierarchical structure
-  - File permissions (chmod)
-  - File/directory renaming and moving
-  - Metadata tracking
-
-USAGE:
-  Create a file:
-    touch /path/to/file
-
-  Write to a file:
-    echo "content" > /path/to/file
-
-  Read a file:
-    cat /path/to/file
-
-  Create a directory:
-    mkdir /path/to/dir
-
-  List directory:
-    ls /path/to/dir
-
-  Remove file/directory:
-    rm /path/to/file
-    rm -r /path/to/dir
-
-  Move/rename:
-    mv /old/path /new/path
-
-  Change permissions:
-    chmod 755 /path/to/file
-
-EXAMPLES:
-  agfs:/> mkdir /memfs/data
-  agfs:/> echo "hello" > /memfs/data/file.txt
-  agfs:/> cat /memfs/data/file.txt
-  hello
-  agfs:/> ls /memfs/data
-  agfs:/> mv /memfs/data/file.txt /memfs/data/renamed.txt
-
-VERSION: 1.0.0
-AUTHOR: VFS Server
-`
+	return plugin.Readme(readmeTemplates, p.readme)
 }
 
 func (p *MemFSPlugin) Shutdown() error {
-	return nil
+	return p.fs.closeJournal()
 }
 
 // Ensure MemFSPlugin implements ServicePlugin
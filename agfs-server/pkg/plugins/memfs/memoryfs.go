@@ -2,9 +2,11 @@ package memfs
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -34,6 +36,12 @@ type MemoryFS struct {
 	root       *Node
 	mu         sync.RWMutex
 	pluginName string
+
+	journal     *journal // Optional write-ahead journal, see EnableJournal
+	compactDone chan struct{}
+	compactWG   sync.WaitGroup
+
+	watchers filesystem.Watchers // See Watch
 }
 
 // NewMemoryFS creates a new in-memory file system
@@ -103,6 +111,26 @@ func (mfs *MemoryFS) getParentNode(path string) (*Node, string, error) {
 	return parent, base, nil
 }
 
+// touch records a change to the entries of dir at t; the modification time of a directory
+// only moves forward, so replaying records in order rebuilds it
+func touch(dir *Node, t time.Time) {
+	if t.After(dir.ModTime) {
+		dir.ModTime = t
+	}
+}
+
+// meta returns the metadata of node, with the number of entries of a directory
+func (mfs *MemoryFS) meta(node *Node) filesystem.MetaData {
+	if !node.IsDir {
+		return filesystem.MetaData{Name: mfs.pluginName, Type: MetaValueFile}
+	}
+	return filesystem.MetaData{
+		Name:    mfs.pluginName,
+		Type:    MetaValueDir,
+		Content: map[string]string{filesystem.MetaKeyChildren: strconv.Itoa(len(node.Children))},
+	}
+}
+
 // Create creates a new file
 func (mfs *MemoryFS) Create(path string) error {
 	mfs.mu.Lock()
@@ -117,7 +145,7 @@ func (mfs *MemoryFS) Create(path string) error {
 		return fmt.Errorf("file already exists: %s", path)
 	}
 
-	parent.Children[name] = &Node{
+	node := &Node{
 		Name:     name,
 		IsDir:    false,
 		Data:     []byte{},
@@ -125,8 +153,11 @@ func (mfs *MemoryFS) Create(path string) error {
 		ModTime:  time.Now(),
 		Children: nil,
 	}
+	parent.Children[name] = node
+	touch(parent, node.ModTime)
+	mfs.notify(filesystem.EventCreate, path, "", false)
 
-	return nil
+	return mfs.record(journalRecord{Op: journalCreate, Path: path, Mode: node.Mode, Time: node.ModTime.UnixNano()})
 }
 
 // Mkdir creates a new directory
@@ -143,15 +174,18 @@ func (mfs *MemoryFS) Mkdir(path string, perm uint32) error {
 		return fmt.Errorf("directory already exists: %s", path)
 	}
 
-	parent.Children[name] = &Node{
+	node := &Node{
 		Name:     name,
 		IsDir:    true,
 		Mode:     perm,
 		ModTime:  time.Now(),
 		Children: make(map[string]*Node),
 	}
+	parent.Children[name] = node
+	touch(parent, node.ModTime)
+	mfs.notify(filesystem.EventCreate, path, "", true)
 
-	return nil
+	return mfs.record(journalRecord{Op: journalMkdir, Path: path, Mode: perm, Time: node.ModTime.UnixNano()})
 }
 
 // Remove removes a file or empty directory
@@ -177,8 +211,11 @@ func (mfs *MemoryFS) Remove(path string) error {
 		return fmt.Errorf("directory not empty: %s", path)
 	}
 
+	now := time.Now()
 	delete(parent.Children, name)
-	return nil
+	touch(parent, now)
+	mfs.notify(filesystem.EventRemove, path, "", node.IsDir)
+	return mfs.record(journalRecord{Op: journalRemove, Path: path, Time: now.UnixNano()})
 }
 
 // RemoveAll removes a path and any children it contains
@@ -188,8 +225,13 @@ func (mfs *MemoryFS) RemoveAll(path string) error {
 
 	// If path is root, remove all children but not the root itself
 	if filesystem.NormalizePath(path) == "/" {
+		now := time.Now()
+		for name, child := range mfs.root.Children {
+			mfs.notify(filesystem.EventRemove, "/"+name, "", child.IsDir)
+		}
 		mfs.root.Children = make(map[string]*Node)
-		return nil
+		touch(mfs.root, now)
+		return mfs.record(journalRecord{Op: journalRemoveAll, Path: "/", Time: now.UnixNano()})
 	}
 
 	parent, name, err := mfs.getParentNode(path)
@@ -197,12 +239,16 @@ func (mfs *MemoryFS) RemoveAll(path string) error {
 		return err
 	}
 
-	if _, exists := parent.Children[name]; !exists {
+	node, exists := parent.Children[name]
+	if !exists {
 		return fmt.Errorf("no such file or directory: %s", path)
 	}
 
+	now := time.Now()
 	delete(parent.Children, name)
-	return nil
+	touch(parent, now)
+	mfs.notify(filesystem.EventRemove, path, "", node.IsDir)
+	return mfs.record(journalRecord{Op: journalRemoveAll, Path: path, Time: now.UnixNano()})
 }
 
 // Read reads file content with optional offset and size
@@ -244,6 +290,7 @@ func (mfs *MemoryFS) Write(path string, data []byte) ([]byte, error) {
 			Children: nil,
 		}
 		parent.Children[name] = node
+		mfs.notify(filesystem.EventCreate, path, "", false)
 	} else {
 		if node.IsDir {
 			return nil, fmt.Errorf("is a directory: %s", path)
@@ -251,8 +298,44 @@ func (mfs *MemoryFS) Write(path string, data []byte) ([]byte, error) {
 		node.Data = data
 		node.ModTime = time.Now()
 	}
+	touch(parent, node.ModTime)
+	mfs.notify(filesystem.EventWrite, path, "", false)
 
-	return nil, nil
+	return nil, mfs.record(journalRecord{Op: journalWrite, Path: path, Data: data, Mode: node.Mode, Time: node.ModTime.UnixNano()})
+}
+
+// Copy implements filesystem.Copier
+// The copy shares the content of src, which is safe because writes replace Data
+func (mfs *MemoryFS) Copy(src, dst string) (int64, error) {
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.getNode(src)
+	if err != nil {
+		return 0, err
+	}
+	if node.IsDir {
+		return 0, fmt.Errorf("is a directory: %s", src)
+	}
+	parent, name, err := mfs.getParentNode(dst)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	target, exists := parent.Children[name]
+	if !exists {
+		target = &Node{Name: name, Mode: 0644}
+		parent.Children[name] = target
+		mfs.notify(filesystem.EventCreate, dst, "", false)
+	} else if target.IsDir {
+		return 0, fmt.Errorf("is a directory: %s", dst)
+	}
+	target.Data = node.Data
+	target.ModTime = now
+	touch(parent, now)
+	mfs.notify(filesystem.EventWrite, dst, "", false)
+
+	return int64(len(node.Data)), mfs.record(journalRecord{Op: journalWrite, Path: dst, Data: node.Data, Mode: target.Mode, Time: now.UnixNano()})
 }
 
 // ReadDir lists the contents of a directory
@@ -271,21 +354,13 @@ func (mfs *MemoryFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	var infos []filesystem.FileInfo
 	for _, child := range node.Children {
-		metaType := MetaValueFile
-		if child.IsDir {
-			metaType = MetaValueDir
-		}
-
 		infos = append(infos, filesystem.FileInfo{
 			Name:    child.Name,
 			Size:    int64(len(child.Data)),
 			Mode:    child.Mode,
 			ModTime: child.ModTime,
 			IsDir:   child.IsDir,
-			Meta: filesystem.MetaData{
-				Name: mfs.pluginName,
-				Type: metaType,
-			},
+			Meta:    mfs.meta(child),
 		})
 	}
 
@@ -302,21 +377,13 @@ func (mfs *MemoryFS) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, err
 	}
 
-	metaType := MetaValueFile
-	if node.IsDir {
-		metaType = MetaValueDir
-	}
-
 	return &filesystem.FileInfo{
 		Name:    node.Name,
 		Size:    int64(len(node.Data)),
 		Mode:    node.Mode,
 		ModTime: node.ModTime,
 		IsDir:   node.IsDir,
-		Meta: filesystem.MetaData{
-			Name: mfs.pluginName,
-			Type: metaType,
-		},
+		Meta:    mfs.meta(node),
 	}, nil
 }
 
@@ -345,11 +412,15 @@ func (mfs *MemoryFS) Rename(oldPath, newPath string) error {
 	}
 
 	// Move the node
+	now := time.Now()
 	delete(oldParent.Children, oldName)
 	node.Name = newName
 	newParent.Children[newName] = node
+	touch(oldParent, now)
+	touch(newParent, now)
+	mfs.notify(filesystem.EventRename, newPath, oldPath, node.IsDir)
 
-	return nil
+	return mfs.record(journalRecord{Op: journalRename, Path: oldPath, NewPath: newPath, Time: now.UnixNano()})
 }
 
 // Chmod changes file permissions
@@ -363,7 +434,27 @@ func (mfs *MemoryFS) Chmod(path string, mode uint32) error {
 	}
 
 	node.Mode = mode
-	return nil
+	return mfs.record(journalRecord{Op: journalChmod, Path: path, Mode: mode})
+}
+
+// Watch implements filesystem.Watcher
+// Removing a tree reports its root only, not every entry below it.
+func (mfs *MemoryFS) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	mfs.mu.RLock()
+	_, err := mfs.getNode(path)
+	mfs.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return mfs.watchers.Watch(ctx, path, recursive)
+}
+
+// notify reports a change to the watches; oldPath is set for renames
+func (mfs *MemoryFS) notify(typ, path, oldPath string, isDir bool) {
+	if oldPath != "" {
+		oldPath = filesystem.NormalizePath(oldPath)
+	}
+	mfs.watchers.Emit(filesystem.Event{Type: typ, Path: filesystem.NormalizePath(path), OldPath: oldPath, IsDir: isDir})
 }
 
 // memoryReadCloser wraps a bytes.Reader to implement io.ReadCloser
@@ -378,7 +469,7 @@ func (m *memoryReadCloser) Close() error {
 // Open opens a file for reading
 func (mfs *MemoryFS) Open(path string) (io.ReadCloser, error) {
 	data, err := mfs.Read(path, 0, -1)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return nil, err
 	}
 	return &memoryReadCloser{bytes.NewReader(data)}, nil
@@ -400,6 +491,12 @@ func (m *memoryWriteCloser) Close() error {
 	return err
 }
 
+// Abort implements filesystem.Aborter, the buffered data is dropped
+func (m *memoryWriteCloser) Abort() error {
+	m.buffer.Reset()
+	return nil
+}
+
 // OpenWrite opens a file for writing
 func (mfs *MemoryFS) OpenWrite(path string) (io.WriteCloser, error) {
 	return &memoryWriteCloser{
@@ -409,3 +506,71 @@ func (mfs *MemoryFS) OpenWrite(path string) (io.WriteCloser, error) {
 	}, nil
 }
 
+
+// Gauges implements plugin.GaugeReporter with the number of files and directories and
+// the bytes stored
+func (mfs *MemoryFS) Gauges() map[string]interface{} {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	var files, dirs, size int64
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		for _, child := range n.Children {
+			if child.IsDir {
+				dirs++
+				walk(child)
+			} else {
+				files++
+				size += int64(len(child.Data))
+			}
+		}
+	}
+	walk(mfs.root)
+	return map[string]interface{}{"files": files, "directories": dirs, "bytes": size}
+}
+
+// Snapshot implements filesystem.Snapshotter
+// The node tree is copied while holding the read lock; file contents are shared
+// with the live tree, which is safe because writes replace Data instead of modifying it
+func (mfs *MemoryFS) Snapshot(path string) (filesystem.FileSystem, error) {
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	node, err := mfs.getNode(path)
+	if err != nil {
+		return nil, err
+	}
+	if !node.IsDir {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+
+	snapshot := NewMemoryFSWithPlugin(mfs.pluginName)
+	snapshot.root = cloneNode(node)
+	snapshot.root.Name = "/"
+	return snapshot, nil
+}
+
+// cloneNode copies a node and its descendants, sharing file contents
+func cloneNode(n *Node) *Node {
+	clone := &Node{
+		Name:    n.Name,
+		IsDir:   n.IsDir,
+		Data:    n.Data,
+		Mode:    n.Mode,
+		ModTime: n.ModTime,
+	}
+	if n.Children != nil {
+		clone.Children = make(map[string]*Node, len(n.Children))
+		for name, child := range n.Children {
+			clone.Children[name] = cloneNode(child)
+		}
+	}
+	return clone
+}
+
+// Ensure MemoryFS implements Snapshotter
+var _ filesystem.Snapshotter = (*MemoryFS)(nil)
+var _ filesystem.Copier = (*MemoryFS)(nil)
+var _ filesystem.Watcher = (*MemoryFS)(nil)
+var _ plugin.GaugeReporter = (*MemoryFS)(nil)
@@ -0,0 +1,70 @@
+package memfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestDirectoryModTime(t *testing.T) {
+	fs := NewMemoryFS()
+	fs.Mkdir("/dir", 0755)
+	fs.Mkdir("/dir/sub", 0755)
+
+	modTime := func(path string) time.Time {
+		t.Helper()
+		info, err := fs.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime
+	}
+
+	for _, step := range []struct {
+		name string
+		op   func() error
+	}{
+		{"create", func() error { return fs.Create("/dir/a") }},
+		{"write", func() error { _, err := fs.Write("/dir/a", []byte("data")); return err }},
+		{"rename", func() error { return fs.Rename("/dir/a", "/dir/b") }},
+		{"remove", func() error { return fs.Remove("/dir/b") }},
+		{"mkdir", func() error { return fs.Mkdir("/dir/c", 0755) }},
+		{"removeall", func() error { return fs.RemoveAll("/dir/c") }},
+	} {
+		before := modTime("/dir")
+		time.Sleep(time.Millisecond)
+		if err := step.op(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !modTime("/dir").After(before) {
+			t.Errorf("%s did not update the directory modification time", step.name)
+		}
+	}
+
+	// Changes deeper in the tree do not propagate upwards
+	before := modTime("/dir")
+	time.Sleep(time.Millisecond)
+	fs.Write("/dir/sub/deep", []byte("x"))
+	if !modTime("/dir").Equal(before) {
+		t.Error("a change in a subdirectory updated its parent")
+	}
+	// Moving an entry updates both directories
+	before = modTime("/dir")
+	time.Sleep(time.Millisecond)
+	fs.Rename("/dir/sub/deep", "/moved")
+	if !modTime("/dir/sub").After(before) || !modTime("/").After(before) {
+		t.Error("rename did not update the source and destination directories")
+	}
+
+	info, _ := fs.Stat("/dir")
+	if n := info.Meta.Content[filesystem.MetaKeyChildren]; n != "1" {
+		t.Errorf("children = %q, want 1", n)
+	}
+	entries, _ := fs.ReadDir("/")
+	for _, e := range entries {
+		if e.IsDir && e.Meta.Content[filesystem.MetaKeyChildren] == "" {
+			t.Errorf("no child count in the listing of %s", e.Name)
+		}
+	}
+}
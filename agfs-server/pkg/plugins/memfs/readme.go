@@ -0,0 +1,132 @@
+package memfs
+
+import "github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+
+// readmeTemplates are the README of the plugin by language, see plugin.ReadmeTemplater
+var readmeTemplates = map[string]string{
+	plugin.LangEnglish: `MemFS Plugin - In-Memory File System
+
+This plugin provides a full-featured in-memory file system.
+
+FEATURES:
+  - Standard file system operations (create, read, write, delete)
+  - Directory support with hierarchical structure
+  - File permissions (chmod)
+  - File/directory renaming and moving
+  - Metadata tracking
+  - Optional journal for durability across restarts
+
+CONFIGURATION:
+  journal_path      - Append every change to this local file and replay it on startup
+  journal_fsync     - Sync the journal after every change (default false)
+  compact_interval  - How often the journal is compacted (default "10m")
+
+THIS MOUNT:
+  path              {{.MountPath}}
+  journal_path      {{config "journal_path" "(none, files are lost on restart)"}}
+  journal_fsync     {{config "journal_fsync" "false"}}
+  compact_interval  {{config "compact_interval" "10m"}}
+
+USAGE:
+  Create a file:
+    touch {{.MountPath}}/path/to/file
+
+  Write to a file:
+    echo "content" > {{.MountPath}}/path/to/file
+
+  Read a file:
+    cat {{.MountPath}}/path/to/file
+
+  Create a directory:
+    mkdir {{.MountPath}}/path/to/dir
+
+  List directory:
+    ls {{.MountPath}}/path/to/dir
+
+  Remove file/directory:
+    rm {{.MountPath}}/path/to/file
+    rm -r {{.MountPath}}/path/to/dir
+
+  Move/rename:
+    mv {{.MountPath}}/old/path {{.MountPath}}/new/path
+
+  Change permissions:
+    chmod 755 {{.MountPath}}/path/to/file
+
+EXAMPLES:
+  agfs:/> mkdir {{.MountPath}}/data
+  agfs:/> echo "hello" > {{.MountPath}}/data/file.txt
+  agfs:/> cat {{.MountPath}}/data/file.txt
+  hello
+  agfs:/> ls {{.MountPath}}/data
+  agfs:/> mv {{.MountPath}}/data/file.txt {{.MountPath}}/data/renamed.txt
+
+VERSION: 1.0.0
+AUTHOR: VFS Server
+`,
+	plugin.LangChinese: `MemFS 插件 - 内存文件系统
+
+此插件提供功能完整的内存文件系统。
+
+功能:
+  - 标准文件操作（创建、读取、写入、删除）
+  - 支持层级目录结构
+  - 文件权限（chmod）
+  - 文件和目录的重命名与移动
+  - 元数据记录
+  - 可选的日志，重启后数据不丢失
+
+配置:
+  journal_path      - 将每次修改追加到此本地文件，并在启动时重放
+  journal_fsync     - 每次修改后同步日志到磁盘（默认 false）
+  compact_interval  - 日志压缩的间隔（默认 "10m"）
+
+当前挂载:
+  path              {{.MountPath}}
+  journal_path      {{config "journal_path" "（无，重启后文件丢失）"}}
+  journal_fsync     {{config "journal_fsync" "false"}}
+  compact_interval  {{config "compact_interval" "10m"}}
+
+用法:
+  创建文件:
+    touch {{.MountPath}}/path/to/file
+
+  写入文件:
+    echo "content" > {{.MountPath}}/path/to/file
+
+  读取文件:
+    cat {{.MountPath}}/path/to/file
+
+  创建目录:
+    mkdir {{.MountPath}}/path/to/dir
+
+  列出目录:
+    ls {{.MountPath}}/path/to/dir
+
+  删除文件或目录:
+    rm {{.MountPath}}/path/to/file
+    rm -r {{.MountPath}}/path/to/dir
+
+  移动或重命名:
+    mv {{.MountPath}}/old/path {{.MountPath}}/new/path
+
+  修改权限:
+    chmod 755 {{.MountPath}}/path/to/file
+
+示例:
+  agfs:/> mkdir {{.MountPath}}/data
+  agfs:/> echo "hello" > {{.MountPath}}/data/file.txt
+  agfs:/> cat {{.MountPath}}/data/file.txt
+  hello
+  agfs:/> ls {{.MountPath}}/data
+  agfs:/> mv {{.MountPath}}/data/file.txt {{.MountPath}}/data/renamed.txt
+
+VERSION: 1.0.0
+AUTHOR: VFS Server
+`,
+}
+
+// ReadmeTemplates implements plugin.ReadmeTemplater
+func (p *MemFSPlugin) ReadmeTemplates() map[string]string {
+	return readmeTemplates
+}
@@ -0,0 +1,202 @@
+package proxyfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBatchMaxWrite is the size of the largest write batched by default
+	DefaultBatchMaxWrite = 64 << 10
+
+	// batchFiles and batchBytes send a batch before its window ends once it holds this many
+	// files or this much data
+	batchFiles = 1000
+	batchBytes = 4 << 20
+)
+
+// batchConsistency is the caveat of batched writes, shown in the metadata of /flush
+const batchConsistency = "writes are acknowledged before the remote applies them; this mount " +
+	"reads its own writes, other clients of the remote see them after the window; a repeated " +
+	"write to a file within the window replaces the previous one; missing parent directories " +
+	"are created; writing to /flush reports the error of the last batch until a later one is applied"
+
+// writeBatcher groups small writes made within a window and sends them to the remote in one
+// ingest request, the last write to each path winning
+type writeBatcher struct {
+	fs       *ProxyFS // The unbound file system sending the batches
+	window   time.Duration
+	maxWrite int64
+
+	mu      sync.Mutex // protects the fields below
+	pending map[string][]byte
+	order   []string // Paths of pending in the order they were first written
+	size    int64    // Bytes of data in pending
+	timer   *time.Timer
+	err     error // Error of the last batch sent, nil if it was applied
+	closed  bool
+	noBatch bool // The remote has no ingest endpoint, batches are sent one write at a time
+
+	sendMu sync.Mutex // Serializes sends, so batches are applied in order
+}
+
+func newWriteBatcher(fs *ProxyFS, window time.Duration, maxWrite int64) *writeBatcher {
+	return &writeBatcher{fs: fs, window: window, maxWrite: maxWrite, pending: make(map[string][]byte)}
+}
+
+// add queues a write of data to path and reports whether it was queued; large writes are
+// not batched and must be sent directly after a flush
+func (b *writeBatcher) add(path string, data []byte) bool {
+	if int64(len(data)) > b.maxWrite {
+		return false
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return false
+	}
+	if prev, ok := b.pending[path]; ok {
+		b.size -= int64(len(prev))
+	} else {
+		b.order = append(b.order, path)
+	}
+	b.pending[path] = append([]byte(nil), data...)
+	b.size += int64(len(data))
+	full := len(b.order) >= batchFiles || b.size >= batchBytes
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.window, func() { b.flush() })
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+	return true
+}
+
+// hasPending reports whether writes wait to be sent
+func (b *writeBatcher) hasPending() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.order) > 0
+}
+
+// take removes the pending writes, in order
+func (b *writeBatcher) take() ([]string, map[string][]byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	order, pending := b.order, b.pending
+	b.order, b.pending, b.size = nil, make(map[string][]byte), 0
+	return order, pending
+}
+
+// flush sends the pending writes, recording whether they were applied for Flush
+func (b *writeBatcher) flush() {
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+	order, pending := b.take()
+	if len(order) == 0 {
+		return
+	}
+	err := b.send(order, pending)
+	if err != nil {
+		log.Warnf("[proxyfs] failed to send %d batched writes to %s: %v", len(order), b.fs.baseURL, err)
+	}
+	b.mu.Lock()
+	b.err = err
+	b.mu.Unlock()
+}
+
+// Flush sends the pending writes and returns the error of the last batch sent, which is
+// reported until a later batch is applied, so a retried flush reports it again
+func (b *writeBatcher) Flush() error {
+	b.flush()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Close sends the pending writes and stops batching
+func (b *writeBatcher) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.Flush()
+}
+
+// send writes the files to the remote with one ingest below their common directory, or one
+// write at a time if the remote cannot ingest there
+func (b *writeBatcher) send(order []string, pending map[string][]byte) error {
+	b.mu.Lock()
+	noBatch := b.noBatch
+	b.mu.Unlock()
+
+	// Ingests target one mount, so files spread over mounts are written one at a time
+	if dir := commonDir(order); !noBatch && len(order) > 1 && dir != "/" {
+		archive, err := tarFiles(dir, order, pending)
+		if err != nil {
+			return err
+		}
+		_, err = b.fs.client.Ingest(dir, archive)
+		switch {
+		case errors.Is(err, filesystem.ErrNotSupported):
+			b.mu.Lock()
+			b.noBatch = true
+			b.mu.Unlock()
+		case !errors.Is(err, filesystem.ErrNotFound):
+			return err
+		}
+	}
+	var firstErr error
+	for _, p := range order {
+		if _, err := b.fs.client.Write(p, pending[p]); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return firstErr
+}
+
+// commonDir returns the deepest directory holding all paths
+func commonDir(paths []string) string {
+	dir := path.Dir(paths[0])
+	for _, p := range paths[1:] {
+		for dir != "/" && !strings.HasPrefix(p, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// tarFiles archives the files at paths, named relative to dir
+func tarFiles(dir string, paths []string, data map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	for _, p := range paths {
+		name := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data[p])), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data[p]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
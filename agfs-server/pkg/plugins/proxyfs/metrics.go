@@ -0,0 +1,149 @@
+package proxyfs
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencySamples is how many of the most recent request latencies percentiles cover
+const latencySamples = 1024
+
+// RemoteStats are the totals of the requests made to a remote server
+type RemoteStats struct {
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`    // Requests failing to connect or answered with a 5xx status
+	ErrorRate     float64 `json:"errorRate"` // Errors / Requests
+	LatencyP50Ms  float64 `json:"latencyP50Ms"`
+	LatencyP99Ms  float64 `json:"latencyP99Ms"`
+	BytesSent     int64   `json:"bytesSent"`
+	BytesReceived int64   `json:"bytesReceived"`
+}
+
+// Status is the content of the /status file: the stats of each remote, by URL
+type Status struct {
+	Remotes map[string]RemoteStats `json:"remotes"`
+}
+
+// remoteCounters accumulates the stats of one remote
+type remoteCounters struct {
+	requests, errors int64
+	bytesSent        int64
+	bytesReceived    atomic.Int64 // Counted as response bodies are read, outside the lock
+	latencies        []float64    // Ring of the last latencySamples latencies in milliseconds
+	next             int
+}
+
+// remoteMetrics tracks the requests of a mount by remote; it outlives the HTTP clients
+// recreated by Reload
+type remoteMetrics struct {
+	mu      sync.Mutex
+	remotes map[string]*remoteCounters
+}
+
+func newRemoteMetrics() *remoteMetrics {
+	return &remoteMetrics{remotes: make(map[string]*remoteCounters)}
+}
+
+// counters returns the counters of remote, creating them if needed; the caller must hold m.mu
+func (m *remoteMetrics) counters(remote string) *remoteCounters {
+	c, ok := m.remotes[remote]
+	if !ok {
+		c = &remoteCounters{}
+		m.remotes[remote] = c
+	}
+	return c
+}
+
+// record adds a request to remote that took latency until its response headers
+func (m *remoteMetrics) record(remote string, latency time.Duration, sent int64, failed bool) *remoteCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.counters(remote)
+	c.requests++
+	if failed {
+		c.errors++
+	}
+	c.bytesSent += sent
+	ms := float64(latency) / float64(time.Millisecond)
+	if len(c.latencies) < latencySamples {
+		c.latencies = append(c.latencies, ms)
+	} else {
+		c.latencies[c.next] = ms
+		c.next = (c.next + 1) % latencySamples
+	}
+	return c
+}
+
+// snapshot returns the current stats of every remote
+func (m *remoteMetrics) snapshot() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := Status{Remotes: make(map[string]RemoteStats, len(m.remotes))}
+	for remote, c := range m.remotes {
+		s := RemoteStats{
+			Requests:      c.requests,
+			Errors:        c.errors,
+			BytesSent:     c.bytesSent,
+			BytesReceived: c.bytesReceived.Load(),
+		}
+		if c.requests > 0 {
+			s.ErrorRate = float64(c.errors) / float64(c.requests)
+		}
+		sorted := append([]float64(nil), c.latencies...)
+		sort.Float64s(sorted)
+		s.LatencyP50Ms = percentile(sorted, 0.5)
+		s.LatencyP99Ms = percentile(sorted, 0.99)
+		status.Remotes[remote] = s
+	}
+	return status
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// metricsTransport records the requests of next in metrics, keyed by the scheme and host
+// of their URL
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *remoteMetrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	var sent int64
+	if req.ContentLength > 0 {
+		sent = req.ContentLength
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	c := t.metrics.record(req.URL.Scheme+"://"+req.URL.Host, time.Since(start), sent, failed)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body, counter: &c.bytesReceived}
+	return resp, nil
+}
+
+// countingBody adds the bytes read from a response body to counter, so streams are counted
+// as they are consumed
+type countingBody struct {
+	io.ReadCloser
+	counter *atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.counter.Add(int64(n))
+	return n, err
+}
@@ -1,42 +1,112 @@
 package proxyfs
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/client"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/credentials"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mtls"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
-	PluginName = "proxyfs" // Name of this plugin
+	PluginName     = "proxyfs"        // Name of this plugin
+	DefaultTimeout = 10 * time.Second // Default limit of a proxied request
 )
 
 // ProxyFS implements filesystem.FileSystem by proxying to a remote AGFS HTTP API
 // All file system operations are transparently forwarded to the remote server
 type ProxyFS struct {
-	client     *client.Client
-	pluginName string
-	baseURL    string // Store base URL for reload
+	client          *client.Client
+	pluginName      string
+	baseURL         string // Store base URL for reload
+	readPartSize    int64  // Size of the ranged sub-reads of large reads
+	readConcurrency int    // Ranged sub-reads in flight, 1 reads files with a single request
+	timeout         time.Duration
+	credentials     credentials.Provider // Authenticates the requests, nil for none
+	tlsConfig       *tls.Config          // TLS of the connections to the remote, nil for the default
+	metrics         *remoteMetrics       // Requests to the remote, kept across reloads
+	batcher         *writeBatcher        // Groups small writes, nil unless write_batch_window is set
+	base            *ProxyFS             // The unbound file system of a view returned by WithContext, nil otherwise
 }
 
 // NewProxyFS creates a new ProxyFS that redirects to a remote AGFS server
 // baseURL should include the API version, e.g., "http://localhost:8080/api/v1"
 func NewProxyFS(baseURL string, pluginName string) *ProxyFS {
+	return NewProxyFSWithTimeout(baseURL, pluginName, DefaultTimeout)
+}
+
+// NewProxyFSWithTimeout creates a new ProxyFS whose requests fail after timeout
+func NewProxyFSWithTimeout(baseURL string, pluginName string, timeout time.Duration) *ProxyFS {
+	metrics := newRemoteMetrics()
 	return &ProxyFS{
-		client:     client.NewClient(baseURL),
-		pluginName: pluginName,
-		baseURL:    baseURL,
+		client:          newClient(baseURL, timeout, nil, nil, metrics),
+		pluginName:      pluginName,
+		baseURL:         baseURL,
+		readPartSize:    plugin.DefaultReadPartSize,
+		readConcurrency: plugin.DefaultReadConcurrency,
+		timeout:         timeout,
+		metrics:         metrics,
+	}
+}
+
+func newClient(baseURL string, timeout time.Duration, creds credentials.Provider, tlsConfig *tls.Config, metrics *remoteMetrics) *client.Client {
+	var transport http.RoundTripper = http.DefaultTransport
+	if tlsConfig != nil {
+		transport = mtls.Transport(tlsConfig)
+	}
+	httpClient := &http.Client{Timeout: timeout, Transport: &metricsTransport{next: transport, metrics: metrics}}
+	if creds != nil {
+		httpClient.Transport = credentials.Transport(creds, httpClient.Transport)
+	}
+	return client.NewClientWithHTTPClient(baseURL, httpClient)
+}
+
+// SetCredentials authenticates the requests to the remote server with the token (or the
+// username and password) of creds
+func (p *ProxyFS) SetCredentials(creds credentials.Provider) {
+	p.credentials = creds
+	p.client = newClient(p.baseURL, p.timeout, creds, p.tlsConfig, p.metrics)
+}
+
+// SetTLS connects to the remote server with cfg, e.g. presenting a client certificate
+func (p *ProxyFS) SetTLS(cfg *tls.Config) {
+	p.tlsConfig = cfg
+	p.client = newClient(p.baseURL, p.timeout, p.credentials, cfg, p.metrics)
+}
+
+// WithContext implements filesystem.ContextBinder, the view's requests are made under ctx
+func (p *ProxyFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *p
+	bound.client = p.client.WithContext(ctx)
+	bound.base = p.unbound()
+	return &bound
+}
+
+// unbound returns the file system not bound to a context, used for work outliving a call
+func (p *ProxyFS) unbound() *ProxyFS {
+	if p.base != nil {
+		return p.base
 	}
+	return p
 }
 
 // Reload recreates the HTTP client, useful for refreshing connections
 func (p *ProxyFS) Reload() error {
 	// Create a new client to refresh the connection
-	p.client = client.NewClient(p.baseURL)
+	p.client = newClient(p.baseURL, p.timeout, p.credentials, p.tlsConfig, p.metrics)
 
 	// Test the new connection
 	if err := p.client.Health(); err != nil {
@@ -46,19 +116,109 @@ func (p *ProxyFS) Reload() error {
 	return nil
 }
 
+// Status returns the request counts, error rates, latencies and bytes transferred of the
+// remote, read from the /status file
+func (p *ProxyFS) Status() Status {
+	return p.metrics.snapshot()
+}
+
+// statusInfo describes the /status file
+func (p *ProxyFS) statusInfo() filesystem.FileInfo {
+	meta := filesystem.FormatMeta(filesystem.FormatJSON, filesystem.FormatJSON, filesystem.FormatText)
+	meta["description"] = "Requests, errors, latency and bytes transferred per remote"
+	meta["remote-url"] = p.baseURL
+	return filesystem.FileInfo{
+		Name:    "status",
+		Size:    0,
+		Mode:    0o444, // read-only
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Type: "control", Content: meta},
+	}
+}
+
+// readStatus renders the /status file in format, JSON or "key: value" lines as text
+func (p *ProxyFS) readStatus(offset int64, size int64, format string) ([]byte, string, error) {
+	data, err := json.MarshalIndent(p.Status(), "", "  ")
+	if err != nil {
+		return nil, "", err
+	}
+	data = append(data, '\n')
+	if format == filesystem.FormatText {
+		if data, err = plugin.JSONToText(data); err != nil {
+			return nil, "", err
+		}
+	} else {
+		format = filesystem.FormatJSON
+	}
+	data, err = plugin.ApplyRangeRead(data, offset, size)
+	return data, format, err
+}
+
+// SetWriteBatching groups writes of at most maxWrite bytes made within window and sends them
+// in one request; a window of 0 sends every write on its own
+func (p *ProxyFS) SetWriteBatching(window time.Duration, maxWrite int64) {
+	if p.batcher != nil {
+		p.batcher.Close()
+		p.batcher = nil
+	}
+	if window > 0 {
+		p.batcher = newWriteBatcher(p.unbound(), window, maxWrite)
+	}
+}
+
+// Flush sends the batched writes and returns the error of the last batch sent, until a
+// later batch is applied
+func (p *ProxyFS) Flush() error {
+	if p.batcher == nil {
+		return nil
+	}
+	return p.batcher.Flush()
+}
+
+// sync sends the batched writes before another operation, so it sees them
+func (p *ProxyFS) sync() {
+	if p.batcher != nil && p.batcher.hasPending() {
+		p.batcher.flush()
+	}
+}
+
+// flushInfo describes the /flush file, which exists with write batching
+func (p *ProxyFS) flushInfo() filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    "flush",
+		Size:    0,
+		Mode:    0o200, // write-only
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta: filesystem.MetaData{
+			Type: "control",
+			Content: map[string]string{
+				"description":        "Write to this file to send the batched writes and report their errors",
+				"write-batch-window": p.batcher.window.String(),
+				"consistency":        batchConsistency,
+			},
+		},
+	}
+}
+
 func (p *ProxyFS) Create(path string) error {
+	p.sync()
 	return p.client.Create(path)
 }
 
 func (p *ProxyFS) Mkdir(path string, perm uint32) error {
+	p.sync()
 	return p.client.Mkdir(path, perm)
 }
 
 func (p *ProxyFS) Remove(path string) error {
+	p.sync()
 	return p.client.Remove(path)
 }
 
 func (p *ProxyFS) RemoveAll(path string) error {
+	p.sync()
 	return p.client.RemoveAll(path)
 }
 
@@ -68,22 +228,97 @@ func (p *ProxyFS) Read(path string, offset int64, size int64) ([]byte, error) {
 		data := []byte("Write to this file to reload the proxy connection\n")
 		return plugin.ApplyRangeRead(data, offset, size)
 	}
+	if path == "/status" {
+		data, _, err := p.readStatus(offset, size, filesystem.FormatJSON)
+		return data, err
+	}
+	if path == "/flush" && p.batcher != nil {
+		data := []byte("Write to this file to send the batched writes\n")
+		return plugin.ApplyRangeRead(data, offset, size)
+	}
+	p.sync()
+	if p.readConcurrency > 1 {
+		return p.parallelRead(path, offset, size)
+	}
 	return p.client.Read(path, offset, size)
 }
 
+// ReadFormat implements filesystem.FormatReader, the remote server reads its control files
+// in format
+func (p *ProxyFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	if path == "/reload" || (path == "/flush" && p.batcher != nil) {
+		data, err := p.Read(path, offset, size)
+		return data, "", err
+	}
+	if path == "/status" {
+		return p.readStatus(offset, size, format)
+	}
+	p.sync()
+	return p.client.ReadFormat(path, offset, size, format)
+}
+
+// parallelRead splits reads spanning more than one part into concurrent ranged reads
+func (p *ProxyFS) parallelRead(path string, offset int64, size int64) ([]byte, error) {
+	info, err := p.client.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	remaining := info.Size - offset
+	if size >= 0 && size < remaining {
+		remaining = size
+	}
+	if info.IsDir || remaining <= p.readPartSize {
+		return p.client.Read(path, offset, size)
+	}
+
+	data, err := plugin.ParallelRangeRead(info.Size, offset, size, p.readPartSize, p.readConcurrency, func(off, n int64) ([]byte, error) {
+		return p.client.Read(path, off, n)
+	})
+	if err == io.EOF {
+		// Remote reads do not report the end of the file either
+		err = nil
+	}
+	return data, err
+}
+
 func (p *ProxyFS) Write(path string, data []byte) ([]byte, error) {
 	// Special handling for /reload - trigger hot reload
 	if path == "/reload" {
-		if err := p.Reload(); err != nil {
+		if err := p.unbound().Reload(); err != nil {
 			return nil, fmt.Errorf("reload failed: %w", err)
 		}
 		return []byte("ProxyFS reloaded successfully"), nil
 	}
+	if path == "/status" {
+		return nil, filesystem.NewPermissionDeniedError("write", path, "read-only")
+	}
+	if p.batcher != nil {
+		if path == "/flush" {
+			if err := p.batcher.Flush(); err != nil {
+				return nil, fmt.Errorf("flush failed: %w", err)
+			}
+			return []byte("Batched writes sent"), nil
+		}
+		if p.batcher.add(path, data) {
+			return nil, nil
+		}
+		p.sync()
+	}
 	return p.client.Write(path, data)
 }
 
 func (p *ProxyFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
-	files, err := p.client.ReadDir(path)
+	return p.ReadDirFields(path, filesystem.AllFields)
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+// The selected fields are passed on to the remote server
+func (p *ProxyFS) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	p.sync()
+	files, err := p.client.ReadDirFields(path, fields)
 	if err != nil {
 		return nil, err
 	}
@@ -103,7 +338,14 @@ func (p *ProxyFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				},
 			},
 		}
-		files = append(files, reloadFile)
+		statusFile := p.statusInfo()
+		statusFile.ModTime = reloadFile.ModTime
+		files = append(files, reloadFile, statusFile)
+		if p.batcher != nil {
+			flushFile := p.flushInfo()
+			flushFile.ModTime = reloadFile.ModTime
+			files = append(files, flushFile)
+		}
 	}
 
 	return files, nil
@@ -128,7 +370,17 @@ func (p *ProxyFS) Stat(path string) (*filesystem.FileInfo, error) {
 		}, nil
 	}
 
+	if path == "/status" {
+		info := p.statusInfo()
+		return &info, nil
+	}
+	if path == "/flush" && p.batcher != nil {
+		info := p.flushInfo()
+		return &info, nil
+	}
+
 	// Get stat from remote
+	p.sync()
 	stat, err := p.client.Stat(path)
 	if err != nil {
 		return nil, err
@@ -139,20 +391,26 @@ func (p *ProxyFS) Stat(path string) (*filesystem.FileInfo, error) {
 		stat.Meta.Content = make(map[string]string)
 	}
 	stat.Meta.Content["remote-url"] = p.baseURL
+	if p.batcher != nil {
+		// The caveats are in the metadata of /flush
+		stat.Meta.Content["write-batch-window"] = p.batcher.window.String()
+	}
 
 	return stat, nil
 }
 
 func (p *ProxyFS) Rename(oldPath, newPath string) error {
+	p.sync()
 	return p.client.Rename(oldPath, newPath)
 }
 
 func (p *ProxyFS) Chmod(path string, mode uint32) error {
+	p.sync()
 	return p.client.Chmod(path, mode)
 }
 
 func (p *ProxyFS) Open(path string) (io.ReadCloser, error) {
-	data, err := p.client.Read(path, 0, -1)
+	data, err := p.Read(path, 0, -1)
 	if err != nil {
 		return nil, err
 	}
@@ -160,13 +418,14 @@ func (p *ProxyFS) Open(path string) (io.ReadCloser, error) {
 }
 
 func (p *ProxyFS) OpenWrite(path string) (io.WriteCloser, error) {
-	return filesystem.NewBufferedWriter(path, p.Write), nil
+	return filesystem.NewBufferedWriter(path, p.unbound().Write), nil
 }
 
 // OpenStream implements filesystem.Streamer interface
 func (p *ProxyFS) OpenStream(path string) (filesystem.StreamReader, error) {
 	// Use the client's ReadStream to get a streaming connection
-	streamReader, err := p.client.ReadStream(path)
+	p.sync()
+	streamReader, err := p.unbound().client.ReadStream(path)
 	if err != nil {
 		return nil, err
 	}
@@ -183,7 +442,8 @@ func (p *ProxyFS) OpenStream(path string) (filesystem.StreamReader, error) {
 // Deprecated: Use OpenStream instead
 func (p *ProxyFS) GetStream(path string) (interface{}, error) {
 	// Use the client's ReadStream to get a streaming connection
-	streamReader, err := p.client.ReadStream(path)
+	p.sync()
+	streamReader, err := p.unbound().client.ReadStream(path)
 	if err != nil {
 		return nil, err
 	}
@@ -212,10 +472,8 @@ func (psr *ProxyStreamReader) ReadChunk(timeout time.Duration) ([]byte, bool, er
 	n, err := psr.reader.Read(psr.buf)
 
 	if n > 0 {
-		// Make a copy of the data to return
-		chunk := make([]byte, n)
-		copy(chunk, psr.buf[:n])
-		return chunk, false, nil
+		// The buffer is reused by the next call, as allowed by filesystem.StreamReader
+		return psr.buf[:n], false, nil
 	}
 
 	if err == io.EOF {
@@ -276,6 +534,7 @@ func (r *bytesReader) Read(p []byte) (n int, err error) {
 type ProxyFSPlugin struct {
 	fs      *ProxyFS
 	baseURL string
+	certs   *mtls.Certs // Certificates configured for the mount, nil if none
 }
 
 // NewProxyFSPlugin creates a new ProxyFS plugin
@@ -293,7 +552,9 @@ func (p *ProxyFSPlugin) Name() string {
 
 func (p *ProxyFSPlugin) Validate(cfg map[string]interface{}) error {
 	// Check for unknown parameters
-	allowedKeys := []string{"base_url", "mount_path"}
+	allowedKeys := []string{"base_url", "credentials", "read_part_size", "read_concurrency", "timeout",
+		"tls_cert_file", "tls_key_file", "tls_ca_file", "tls_server_name", "write_batch_window", "write_batch_max",
+		"mount_path"}
 	if cfg != nil {
 		for key := range cfg {
 			found := false
@@ -326,27 +587,150 @@ func (p *ProxyFSPlugin) Validate(cfg map[string]interface{}) error {
 		return fmt.Errorf("invalid base_url format: %w", err)
 	}
 
+	// Validate parallel read settings
+	if _, err := config.GetSizeConfig(cfg, "read_part_size", plugin.DefaultReadPartSize); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "read_concurrency"); err != nil {
+		return err
+	}
+	if timeout, err := config.GetDurationConfig(cfg, "timeout", DefaultTimeout); err != nil {
+		return err
+	} else if timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if _, err := credentials.PluginProvider(cfg, credentials.Credentials{}); err != nil {
+		return err
+	}
+	if window, err := config.GetDurationConfig(cfg, "write_batch_window", 0); err != nil {
+		return err
+	} else if window < 0 {
+		return fmt.Errorf("write_batch_window must not be negative")
+	}
+	if _, err := config.GetSizeConfig(cfg, "write_batch_max", DefaultBatchMaxWrite); err != nil {
+		return err
+	}
+	if (config.GetStringConfig(cfg, "tls_cert_file", "") == "") != (config.GetStringConfig(cfg, "tls_key_file", "") == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+
 	return nil
 }
 
-func (p *ProxyFSPlugin) Initialize(config map[string]interface{}) error {
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *ProxyFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "base_url", Type: plugin.ParamString, Required: true, Description: "API URL of the remote server, e.g. http://host:8080/api/v1"},
+		{Name: "read_part_size", Type: plugin.ParamSize, Default: "8MB", Description: "Size of the ranged requests of parallel reads"},
+		{Name: "read_concurrency", Type: plugin.ParamInt, Default: "1", Description: "Ranged requests in flight (1 disables parallel reads)"},
+		{Name: "timeout", Type: plugin.ParamDuration, Default: DefaultTimeout.String(), Description: "Limit of a proxied request"},
+		{Name: "credentials", Type: plugin.ParamMap, Description: "Credential provider of the bearer token of the remote server (static, env, file, exec or metadata)"},
+		{Name: "tls_cert_file", Type: plugin.ParamString, Description: "PEM client certificate presented to the remote (default: the server's, for https remotes)"},
+		{Name: "tls_key_file", Type: plugin.ParamString, Description: "PEM private key of tls_cert_file"},
+		{Name: "tls_ca_file", Type: plugin.ParamString, Description: "PEM CA the remote's certificate must be signed by (default: the server's, else the system roots)"},
+		{Name: "tls_server_name", Type: plugin.ParamString, Description: "Host name verified in the remote's certificate (default: the host of base_url)"},
+		{Name: "write_batch_window", Type: plugin.ParamDuration, Default: "0", Description: "Window small writes are grouped in and sent in one request (0 disables batching)"},
+		{Name: "write_batch_max", Type: plugin.ParamSize, Default: "64KB", Description: "Size of the largest write batched"},
+	}
+}
+
+// Initialize connects without the TLS configuration of the server, see InitializeContext
+func (p *ProxyFSPlugin) Initialize(cfg map[string]interface{}) error {
+	return p.InitializeContext(plugin.LegacyInitContext(PluginName, cfg))
+}
+
+// InitializeContext connects to the remote server, over https with the certificates of the
+// server unless the mount configures its own
+func (p *ProxyFSPlugin) InitializeContext(ictx *plugin.InitContext) error {
+	cfg := ictx.Config
 	// Override base URL if provided in config
 	// Expected config: {"base_url": "http://remote-server:8080/api/v1"}
-	if config != nil {
-		if url, ok := config["base_url"].(string); ok && url != "" {
+	if cfg != nil {
+		if url, ok := cfg["base_url"].(string); ok && url != "" {
 			p.baseURL = url
-			p.fs = NewProxyFS(url, PluginName)
 		}
 	}
+	timeout, err := config.GetDurationConfig(cfg, "timeout", DefaultTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	p.fs = NewProxyFSWithTimeout(p.baseURL, PluginName, timeout)
+	// The stats of /status are also published with the metrics of the mount
+	metrics := p.fs.metrics
+	ictx.Metrics.Set("remotes", expvar.Func(func() any { return metrics.snapshot().Remotes }))
+	creds, err := credentials.PluginProvider(cfg, credentials.Credentials{})
+	if err != nil {
+		return err
+	}
+	if creds != nil {
+		p.fs.SetCredentials(creds)
+	}
+	tlsConfig, err := p.clientTLS(ictx)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		p.fs.SetTLS(tlsConfig)
+	}
+
+	// Parallel ranged reads for large files
+	if partSize, err := config.GetSizeConfig(cfg, "read_part_size", plugin.DefaultReadPartSize); err == nil && partSize > 0 {
+		p.fs.readPartSize = partSize
+	}
+	p.fs.readConcurrency = config.GetIntConfig(cfg, "read_concurrency", plugin.DefaultReadConcurrency)
+
+	// Batching of small writes
+	if window, err := config.GetDurationConfig(cfg, "write_batch_window", 0); err == nil && window > 0 {
+		maxWrite, err := config.GetSizeConfig(cfg, "write_batch_max", DefaultBatchMaxWrite)
+		if err != nil {
+			maxWrite = DefaultBatchMaxWrite
+		}
+		p.fs.SetWriteBatching(window, maxWrite)
+	}
 
 	// Test connection to remote server with health check
 	if err := p.fs.client.Health(); err != nil {
+		p.Shutdown()
 		return fmt.Errorf("failed to connect to remote AGFS server at %s: %w", p.baseURL, err)
 	}
 
 	return nil
 }
 
+// clientTLS returns the TLS configuration of the connections to the remote: the certificates
+// configured for the mount, which are watched for rotation, else those of the server for an
+// https remote; nil keeps the default
+func (p *ProxyFSPlugin) clientTLS(ictx *plugin.InitContext) (*tls.Config, error) {
+	certFile := config.GetStringConfig(ictx.Config, "tls_cert_file", "")
+	keyFile := config.GetStringConfig(ictx.Config, "tls_key_file", "")
+	caFile := config.GetStringConfig(ictx.Config, "tls_ca_file", "")
+	serverName := config.GetStringConfig(ictx.Config, "tls_server_name", "")
+
+	if p.certs != nil {
+		p.certs.Close()
+		p.certs = nil
+	}
+	switch {
+	case certFile != "" || caFile != "":
+		certs, err := mtls.Load(certFile, keyFile, caFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		certs.Watch(mtls.DefaultReloadInterval)
+		p.certs = certs
+		return certs.ClientConfig(serverName), nil
+	case ictx.ClientTLS != nil && strings.HasPrefix(p.baseURL, "https://"):
+		shared := ictx.ClientTLS.Clone()
+		if serverName != "" {
+			shared.ServerName = serverName
+		}
+		return shared, nil
+	case serverName != "":
+		return &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}, nil
+	}
+	return nil, nil
+}
+
 func (p *ProxyFSPlugin) GetFileSystem() filesystem.FileSystem {
 	return p.fs
 }
@@ -367,6 +751,30 @@ FEATURES:
 
 CONFIGURATION:
   base_url: URL of the remote AGFS server (e.g., "http://remote:8080/api/v1")
+  read_concurrency: Ranged sub-reads in flight for large reads (default: 1, disabled)
+  read_part_size: Size of each ranged sub-read (default: "8MB")
+  timeout: Limit of each proxied request, e.g. "30s" (default: "10s")
+  tls_cert_file, tls_key_file: Client certificate presented to the remote server
+  tls_ca_file: CA the remote server's certificate must be signed by
+  tls_server_name: Host name verified instead of the host of base_url
+  write_batch_window: Window small writes are grouped in, e.g. "10ms" (default: 0, disabled)
+  write_batch_max: Size of the largest write batched (default: "64KB")
+
+MUTUAL TLS:
+  With an https base_url, the mount presents the certificate of the server's
+  own tls configuration and verifies the remote server against its CA, so
+  servers sharing a CA authenticate each other. The tls_* keys give the mount
+  its own certificate and CA instead. Rotated files are picked up without a
+  remount:
+
+    agfs:/> mount proxyfs /dc2 base_url=https://dc2:8443/api/v1 tls_cert_file=/etc/agfs/dc1.crt tls_key_file=/etc/agfs/dc1.key tls_ca_file=/etc/agfs/ca.crt
+
+PARALLEL READS:
+  Over high-latency links a single request per read limits throughput.
+  With read_concurrency above 1, reads larger than read_part_size are split
+  into ranged sub-reads fetched in parallel and reassembled:
+
+    agfs:/> mount proxyfs /remote base_url=http://far:8080/api/v1 read_concurrency=8
 
 HOT RELOAD:
   ProxyFS provides a special /reload file for hot-reloading the connection:
@@ -379,6 +787,27 @@ HOT RELOAD:
   - Network connection was interrupted
   - Need to refresh connection pool
 
+WRITE BATCHING:
+  With write_batch_window, small writes are acknowledged at once and sent
+  together through the remote's ingest endpoint when the window ends. Any
+  other operation on the mount sends them first; other clients of the remote
+  see them up to a window later. Repeated writes to a file within a window
+  are coalesced, so do not batch queues or other files taking commands.
+  Write to /flush to send the pending writes and get the error of the last
+  batch:
+
+    agfs:/> mount proxyfs /dc2 base_url=http://dc2:8080/api/v1 write_batch_window=10ms
+    agfs:/> echo '' > /dc2/flush
+
+STATUS:
+  /status reports the requests made to the remote since the mount: count,
+  errors (failed connections and 5xx responses), error rate, P50 and P99
+  latency until the response headers, and bytes sent and received, per
+  remote URL. It is JSON, or "key: value" lines in text format; the same
+  stats are published at /debug/vars under plugins.<mount>.remotes:
+
+    agfs:/> cat /proxyfs/status
+
 USAGE:
   All standard file operations are proxied to the remote server:
 
@@ -446,8 +875,29 @@ USE CASES:
 }
 
 func (p *ProxyFSPlugin) Shutdown() error {
+	if p.fs != nil && p.fs.batcher != nil {
+		if err := p.fs.batcher.Close(); err != nil {
+			log.Warnf("[proxyfs] batched writes to %s failed: %v", p.baseURL, err)
+		}
+	}
+	if p.certs != nil {
+		p.certs.Close()
+		p.certs = nil
+	}
 	return nil
 }
 
+// HealthCheck implements plugin.HealthChecker interface with the health check of the remote server
+func (p *ProxyFSPlugin) HealthCheck(ctx context.Context) error {
+	if p.fs == nil {
+		return fmt.Errorf("not initialized")
+	}
+	return p.fs.client.WithContext(ctx).Health()
+}
+
 // Ensure ProxyFSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*ProxyFSPlugin)(nil)
+var _ plugin.HealthChecker = (*ProxyFSPlugin)(nil)
+var _ plugin.ContextInitializer = (*ProxyFSPlugin)(nil)
+var _ filesystem.FieldLister = (*ProxyFS)(nil)
+var _ filesystem.FormatReader = (*ProxyFS)(nil)
@@ -0,0 +1,131 @@
+package proxyfs_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
+)
+
+// newProxy starts a remote server with memfs and a server mounting it at /remote
+func newProxy(t *testing.T, cfg map[string]interface{}) (remote, srv *pfstest.Server) {
+	t.Helper()
+	remote = pfstest.NewServer(t)
+	cfg["base_url"] = remote.URL + "/api/v1"
+	srv = pfstest.NewServer(t, pfstest.WithoutMemFS(), pfstest.WithPlugin("/remote", proxyfs.NewProxyFSPlugin(""), cfg))
+	return remote, srv
+}
+
+// The /status file of proxyfs reports the requests made to the remote
+func TestProxyStatus(t *testing.T) {
+	remote, srv := newProxy(t, map[string]interface{}{})
+	c := srv.Client
+	if _, err := c.Write("/remote/memfs/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.Read("/remote/memfs/a", 0, -1); err != nil || string(data) != "hello" {
+		t.Fatalf("read = %q, %v", data, err)
+	}
+
+	remoteStats := func() proxyfs.RemoteStats {
+		t.Helper()
+		data, err := c.Read("/remote/status", 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status proxyfs.Status
+		if err := json.Unmarshal(data, &status); err != nil {
+			t.Fatalf("status %q: %v", data, err)
+		}
+		stats, ok := status.Remotes[remote.URL]
+		if !ok {
+			t.Fatalf("no stats of %s in %s", remote.URL, data)
+		}
+		return stats
+	}
+	// The health check at mount time, the write and the read
+	if stats := remoteStats(); stats.Requests != 3 || stats.Errors != 0 || stats.BytesSent != 5 || stats.BytesReceived < 5 || stats.LatencyP99Ms <= 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	text, _, err := c.ReadFormat("/remote/status", 0, -1, "text")
+	if err != nil || !strings.Contains(string(text), "remotes."+remote.URL+".requests: ") {
+		t.Errorf("status as text = %q, %v", text, err)
+	}
+	if _, err := c.Write("/remote/status", []byte("x")); err == nil {
+		t.Error("write to status: no error")
+	}
+
+	// Requests failing to connect are errors
+	remote.Close()
+	if _, err := c.Read("/remote/memfs/a", 0, -1); err == nil {
+		t.Fatal("read from a stopped remote: no error")
+	}
+	if stats := remoteStats(); stats.Errors == 0 || stats.ErrorRate != float64(stats.Errors)/float64(stats.Requests) {
+		t.Errorf("stats after a failure = %+v", stats)
+	}
+
+	vars := expvar.Get("plugins").(*expvar.Map).Get("/remote").(*expvar.Map).Get("remotes").String()
+	if !strings.Contains(vars, `"`+remote.URL+`":{"requests":`) {
+		t.Errorf("published metrics = %s", vars)
+	}
+}
+
+// Small writes through proxyfs are sent to the remote in one ingest request
+func TestProxyWriteBatching(t *testing.T) {
+	remote, srv := newProxy(t, map[string]interface{}{"write_batch_window": "1h"})
+	c, rc := srv.Client, remote.Client
+	if err := rc.Mkdir("/memfs/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []struct{ path, data string }{{"a", "one"}, {"b", "two"}, {"a", "three"}} {
+		if _, err := c.Write("/remote/memfs/dir/"+w.path, []byte(w.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rc.Stat("/memfs/dir/a"); err == nil {
+		t.Fatal("batched write reached the remote before the window ended")
+	}
+
+	info, err := c.Stat("/remote/flush")
+	if err != nil || info.Meta.Content["write-batch-window"] != "1h0m0s" || info.Meta.Content["consistency"] == "" {
+		t.Errorf("flush metadata = %+v, %v", info, err)
+	}
+
+	// Reads through the mount send the batch first
+	if data, err := c.Read("/remote/memfs/dir/a", 0, -1); err != nil || string(data) != "three" {
+		t.Errorf("read through the mount = %q, %v", data, err)
+	}
+	for path, want := range map[string]string{"/memfs/dir/a": "three", "/memfs/dir/b": "two"} {
+		if data, err := rc.Read(path, 0, -1); (err != nil && err != io.EOF) || string(data) != want {
+			t.Errorf("remote %s = %q, %v", path, data, err)
+		}
+	}
+	data, _ := c.Read("/remote/status", 0, -1)
+	var status proxyfs.Status
+	json.Unmarshal(data, &status)
+	// The health check, one ingest and the read
+	if n := status.Remotes[remote.URL].Requests; n != 3 {
+		t.Errorf("requests to the remote = %d, want 3", n)
+	}
+
+	// Errors of batched writes are reported by /flush until a batch is applied
+	if _, err := c.Write("/remote/unmounted/c", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := srv.FS.Write("/remote/flush", nil); err == nil || !strings.Contains(err.Error(), "/unmounted/c") {
+			t.Errorf("flush %d after a failed write: %v", i, err)
+		}
+	}
+	if _, err := c.Write("/remote/memfs/dir/c", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.FS.Write("/remote/flush", nil); err != nil {
+		t.Errorf("flush after a batch was applied: %v", err)
+	}
+}
@@ -50,6 +50,17 @@ type QueueBackend interface {
 
 	// QueueExists checks if a queue exists (even if empty)
 	QueueExists(queueName string) (bool, error)
+
+	// ListMessages returns the pending messages of a queue in queue order, skipping the
+	// first offset and returning at most limit of them, all if limit is 0
+	ListMessages(queueName string, offset, limit int) ([]QueueMessage, error)
+
+	// GetMessage returns the pending message with the given ID without removing it
+	GetMessage(queueName, id string) (QueueMessage, bool, error)
+
+	// DeleteMessage removes the pending message with the given ID, wherever it is in the
+	// queue, and reports whether it was found
+	DeleteMessage(queueName, id string) (bool, error)
 }
 
 // MemoryBackend implements QueueBackend using in-memory storage
@@ -218,6 +229,60 @@ func (b *MemoryBackend) QueueExists(queueName string) (bool, error) {
 	return exists, nil
 }
 
+func (b *MemoryBackend) ListMessages(queueName string, offset, limit int) ([]QueueMessage, error) {
+	queue, exists := b.queues[queueName]
+	if !exists {
+		return nil, nil
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if offset >= len(queue.messages) {
+		return nil, nil
+	}
+	messages := queue.messages[offset:]
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return append([]QueueMessage(nil), messages...), nil
+}
+
+func (b *MemoryBackend) GetMessage(queueName, id string) (QueueMessage, bool, error) {
+	queue, exists := b.queues[queueName]
+	if !exists {
+		return QueueMessage{}, false, nil
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for _, msg := range queue.messages {
+		if msg.ID == id {
+			return msg, true, nil
+		}
+	}
+	return QueueMessage{}, false, nil
+}
+
+func (b *MemoryBackend) DeleteMessage(queueName, id string) (bool, error) {
+	queue, exists := b.queues[queueName]
+	if !exists {
+		return false, nil
+	}
+
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for i, msg := range queue.messages {
+		if msg.ID == id {
+			queue.messages = append(queue.messages[:i:i], queue.messages[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // TiDBBackend implements QueueBackend using TiDB database
 type TiDBBackend struct {
 	db          *sql.DB
@@ -665,3 +730,100 @@ func (b *TiDBBackend) QueueExists(queueName string) (bool, error) {
 	}
 	return count > 0, nil
 }
+
+func (b *TiDBBackend) ListMessages(queueName string, offset, limit int) ([]QueueMessage, error) {
+	// Get table name from cache (lazy loading)
+	tableName, err := b.getTableName(queueName, false)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get queue table name: %w", err)
+	}
+
+	querySQL := fmt.Sprintf("SELECT data FROM %s WHERE deleted = 0 ORDER BY id", tableName)
+	var args []interface{}
+	if limit > 0 {
+		querySQL += " LIMIT ? OFFSET ?"
+		args = []interface{}{limit, offset}
+	}
+	rows, err := b.db.Query(querySQL, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []QueueMessage
+	skip := 0
+	if limit == 0 {
+		skip = offset
+	}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		var msg QueueMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (b *TiDBBackend) GetMessage(queueName, id string) (QueueMessage, bool, error) {
+	// Get table name from cache (lazy loading)
+	tableName, err := b.getTableName(queueName, false)
+	if err == sql.ErrNoRows {
+		return QueueMessage{}, false, nil
+	} else if err != nil {
+		return QueueMessage{}, false, fmt.Errorf("failed to get queue table name: %w", err)
+	}
+
+	var data string
+	querySQL := fmt.Sprintf(
+		"SELECT data FROM %s WHERE message_id = ? AND deleted = 0",
+		tableName,
+	)
+	err = b.db.QueryRow(querySQL, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return QueueMessage{}, false, nil
+	} else if err != nil {
+		return QueueMessage{}, false, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	var msg QueueMessage
+	if err := json.Unmarshal([]byte(data), &msg); err != nil {
+		return QueueMessage{}, false, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return msg, true, nil
+}
+
+func (b *TiDBBackend) DeleteMessage(queueName, id string) (bool, error) {
+	// Get table name from cache (lazy loading)
+	tableName, err := b.getTableName(queueName, false)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get queue table name: %w", err)
+	}
+
+	// Mark the message as deleted, as a dequeue does
+	updateSQL := fmt.Sprintf(
+		"UPDATE %s SET deleted = 1, deleted_at = CURRENT_TIMESTAMP WHERE message_id = ? AND deleted = 0",
+		tableName,
+	)
+	result, err := b.db.Exec(updateSQL, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete message: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete message: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,176 @@
+package queuefs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxBatch is the number of messages a bulk request may enqueue or dequeue
+	maxBatch = 1000
+
+	// maxBatchBody is the size limit of a bulk enqueue request
+	maxBatchBody = 32 << 20
+)
+
+// QueueInfo describes a queue in the listing of the bulk API
+type QueueInfo struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// HTTPHandler serves the bulk API next to the control files:
+//
+//	GET  /                      - Every queue with its size
+//	POST /<queue>/enqueue       - Enqueue a JSON array of messages, strings as is and other
+//	                              values as their JSON encoding
+//	POST /<queue>/dequeue?n=10  - Dequeue up to n messages
+func (q *QueueFSPlugin) HTTPHandler() http.Handler {
+	return http.HandlerFunc(q.serveBulk)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func (q *QueueFSPlugin) serveBulk(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q.listQueues(w)
+		return
+	}
+
+	queueName, operation, _, err := parseQueuePath(r.URL.Path)
+	if err != nil || queueName == "" || (operation != "enqueue" && operation != "dequeue") {
+		writeError(w, http.StatusNotFound, "expected /<queue>/enqueue or /<queue>/dequeue")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if operation == "enqueue" {
+		q.enqueueBatch(w, r, queueName)
+	} else {
+		q.dequeueBatch(w, r, queueName)
+	}
+}
+
+func (q *QueueFSPlugin) listQueues(w http.ResponseWriter) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	names, err := q.backend.ListQueues("")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	queues := make([]QueueInfo, 0, len(names))
+	for _, name := range names {
+		size, err := q.backend.Size(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		queues = append(queues, QueueInfo{Name: name, Size: size})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"queues": queues})
+}
+
+func (q *QueueFSPlugin) enqueueBatch(w http.ResponseWriter, r *http.Request, queueName string) {
+	var items []json.RawMessage
+	body := http.MaxBytesReader(w, r.Body, maxBatchBody)
+	if err := json.NewDecoder(body).Decode(&items); err != nil {
+		writeError(w, http.StatusBadRequest, "expected a JSON array of messages: "+err.Error())
+		return
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatch {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("expected 1 to %d messages", maxBatch))
+		return
+	}
+
+	msgs := make([]QueueMessage, len(items))
+	for i, item := range items {
+		data := string(item)
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			data = s
+		}
+		id, err := uuid.NewV7()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to generate UUIDv7: "+err.Error())
+			return
+		}
+		msgs[i] = QueueMessage{ID: id.String(), Data: data, Timestamp: time.Now()}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]string, 0, len(msgs))
+	defer func() {
+		if len(ids) > 0 {
+			q.touchQueue(queueName)
+		}
+	}()
+	for _, msg := range msgs {
+		if err := q.backend.Enqueue(queueName, msg); err != nil {
+			// Report the messages enqueued so far, so the client can retry the rest
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "ids": ids})
+			return
+		}
+		ids = append(ids, msg.ID)
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"ids": ids})
+}
+
+func (q *QueueFSPlugin) dequeueBatch(w http.ResponseWriter, r *http.Request, queueName string) {
+	n := 1
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 || n > maxBatch {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxBatch))
+			return
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := []QueueMessage{}
+	defer func() {
+		if len(messages) > 0 {
+			q.touchQueue(queueName)
+		}
+	}()
+	for len(messages) < n {
+		msg, found, err := q.backend.Dequeue(queueName)
+		if err != nil {
+			// Messages already dequeued are returned rather than lost
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": err.Error(), "messages": messages})
+			return
+		}
+		if !found {
+			break
+		}
+		messages = append(messages, msg)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"messages": messages})
+}
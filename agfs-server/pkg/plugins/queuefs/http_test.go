@@ -0,0 +1,108 @@
+package queuefs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestBulkAPI(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return NewQueueFSPlugin() })
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("queuefs", "/queue", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/queue/jobs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	handlers.NewPluginHandler(mfs).SetupRoutes(mux)
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/v1/plugins/queue/jobs/enqueue", `["a", {"b": 1}, "c"]`)
+	var enqueued struct{ IDs []string }
+	if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &enqueued) != nil || len(enqueued.IDs) != 3 {
+		t.Fatalf("enqueue: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodGet, "/api/v1/plugins/queue/", "")
+	var listed struct{ Queues []QueueInfo }
+	if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil || len(listed.Queues) != 1 || listed.Queues[0].Size != 3 {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(http.MethodPost, "/api/v1/plugins/queue/jobs/dequeue?n=2", "")
+	var dequeued struct{ Messages []QueueMessage }
+	if err := json.Unmarshal(rec.Body.Bytes(), &dequeued); err != nil || len(dequeued.Messages) != 2 {
+		t.Fatalf("dequeue: %d %s", rec.Code, rec.Body)
+	}
+	if m := dequeued.Messages; m[0].Data != "a" || m[1].Data != `{"b": 1}` || m[0].ID != enqueued.IDs[0] {
+		t.Errorf("dequeued %+v", m)
+	}
+	if data, _ := mfs.Read("/queue/jobs/size", 0, -1); strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("size after dequeue = %q", data)
+	}
+
+	for _, c := range []struct {
+		method, url, body string
+		status            int
+	}{
+		{http.MethodPost, "/api/v1/plugins/queue/jobs/enqueue", `{"not": "an array"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/v1/plugins/queue/jobs/dequeue?n=0", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/plugins/queue/jobs/dequeue", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/api/v1/plugins/queue/jobs/peek", "", http.StatusNotFound},
+		{http.MethodGet, "/api/v1/plugins/mem/", "", http.StatusNotFound},
+	} {
+		if rec := do(c.method, c.url, c.body); rec.Code != c.status {
+			t.Errorf("%s %s: status %d, want %d: %s", c.method, c.url, rec.Code, c.status, rec.Body)
+		}
+	}
+
+	// The mount listing points at the endpoints of the plugins that have them
+	rec = do(http.MethodGet, "/api/v1/mounts", "")
+	var mounts handlers.ListMountsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &mounts); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mounts.Mounts {
+		if want := map[string]string{"/queue": "/api/v1/plugins/queue/"}[m.Path]; m.Endpoint != want {
+			t.Errorf("endpoint of %s = %q, want %q", m.Path, m.Endpoint, want)
+		}
+	}
+
+	// The access control of the mount applies to its endpoints
+	a, err := acl.New(config.ACLConfig{Rules: []config.ACLRuleConfig{{Principal: "*", Path: "/queue", Allow: []string{"read"}}}},
+		func(ctx context.Context) (string, bool) { return "reader", true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs.SetAccessControl(a)
+	if rec := do(http.MethodPost, "/api/v1/plugins/queue/jobs/enqueue", `["d"]`); rec.Code != http.StatusForbidden {
+		t.Errorf("enqueue denied by the acl: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/api/v1/plugins/queue/", ""); rec.Code != http.StatusOK {
+		t.Errorf("list allowed by the acl: status %d: %s", rec.Code, rec.Body)
+	}
+	if data, _ := mfs.Read("/queue/jobs/size", 0, -1); strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("size after denied enqueue = %q", data)
+	}
+}
@@ -0,0 +1,168 @@
+package queuefs
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// messagesDir is the read-only directory of each queue listing its pending messages, one
+// file per message named by its ID; a nested queue cannot be named like it
+const messagesDir = "messages"
+
+// parseMessagePath parses a path like "/queue_name/messages" or "/queue_name/messages/<id>"
+// Returns the queue name, the message ID ("" for the directory) and whether path is one
+func parseMessagePath(path string) (queueName string, id string, ok bool) {
+	parts := strings.Split(strings.Trim(filepath.Clean(path), "/"), "/")
+	n := len(parts)
+	switch {
+	case n >= 2 && parts[n-1] == messagesDir:
+		return strings.Join(parts[:n-1], "/"), "", true
+	case n >= 3 && parts[n-2] == messagesDir:
+		return strings.Join(parts[:n-2], "/"), parts[n-1], true
+	}
+	return "", "", false
+}
+
+// messageInfo describes the file of a pending message
+func messageInfo(msg QueueMessage) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    msg.ID,
+		Size:    int64(len(msg.Data)),
+		Mode:    0644, // readable, removable
+		ModTime: msg.Timestamp,
+		IsDir:   false,
+		Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueQueueMessage,
+			Content: filesystem.FormatMeta(filesystem.FormatJSON, filesystem.FormatJSON, filesystem.FormatText)},
+	}
+}
+
+// messagesDirInfo describes the messages directory of a queue; the caller must hold
+// qfs.plugin.mu
+func (qfs *queueFS) messagesDirInfo(queueName string) (*filesystem.FileInfo, error) {
+	if err := qfs.checkQueue(queueName); err != nil {
+		return nil, err
+	}
+	return &filesystem.FileInfo{
+		Name:    messagesDir,
+		Size:    0,
+		Mode:    0555, // read-only
+		ModTime: qfs.plugin.modTime(queueName),
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueMessages},
+	}, nil
+}
+
+// checkQueue returns a not found error if the queue does not exist; the caller must hold
+// qfs.plugin.mu
+func (qfs *queueFS) checkQueue(queueName string) error {
+	exists, err := qfs.plugin.backend.QueueExists(queueName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return filesystem.NewNotFoundError("queue", queueName)
+	}
+	return nil
+}
+
+// listMessages lists the pending messages of a queue in queue order, skipping the first
+// offset and returning at most limit of them, all if limit is 0
+func (qfs *queueFS) listMessages(queueName string, offset, limit int) ([]filesystem.FileInfo, error) {
+	qfs.plugin.mu.RLock()
+	defer qfs.plugin.mu.RUnlock()
+
+	if err := qfs.checkQueue(queueName); err != nil {
+		return nil, err
+	}
+	messages, err := qfs.plugin.backend.ListMessages(queueName, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]filesystem.FileInfo, 0, len(messages))
+	for _, msg := range messages {
+		files = append(files, messageInfo(msg))
+	}
+	return files, nil
+}
+
+// getMessage returns the pending message with the ID without removing it
+func (qfs *queueFS) getMessage(queueName, id string) (QueueMessage, error) {
+	qfs.plugin.mu.RLock()
+	defer qfs.plugin.mu.RUnlock()
+
+	msg, found, err := qfs.plugin.backend.GetMessage(queueName, id)
+	if err != nil {
+		return QueueMessage{}, err
+	}
+	if !found {
+		return QueueMessage{}, filesystem.NewNotFoundError("message", queueName+"/"+messagesDir+"/"+id)
+	}
+	return msg, nil
+}
+
+// readMessage reads the pending message with the ID, as JSON like peek or as its bare data
+// in text format
+func (qfs *queueFS) readMessage(queueName, id string, offset, size int64, format string) ([]byte, string, error) {
+	msg, err := qfs.getMessage(queueName, id)
+	if err != nil {
+		return nil, "", err
+	}
+	var data []byte
+	if format == filesystem.FormatText {
+		data = []byte(msg.Data)
+	} else if data, err = json.Marshal(msg); err != nil {
+		return nil, "", err
+	} else {
+		format = filesystem.FormatJSON
+	}
+	data, err = plugin.ApplyRangeRead(data, offset, size)
+	return data, format, err
+}
+
+// removeMessage removes the pending message with the ID from anywhere in the queue
+func (qfs *queueFS) removeMessage(queueName, id string) error {
+	qfs.plugin.mu.Lock()
+	defer qfs.plugin.mu.Unlock()
+
+	found, err := qfs.plugin.backend.DeleteMessage(queueName, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return filesystem.NewNotFoundError("message", queueName+"/"+messagesDir+"/"+id)
+	}
+	qfs.plugin.touchQueue(queueName)
+	return nil
+}
+
+// ReadDirPage implements filesystem.PageLister: messages directories are paged by the
+// backend in queue order unless sorted, other directories are read whole
+func (qfs *queueFS) ReadDirPage(path string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	queueName, id, ok := parseMessagePath(path)
+	if !ok || id != "" || page.Sort != "" {
+		files, err := qfs.ReadDir(path)
+		if err != nil {
+			return nil, false, err
+		}
+		files, more := page.Apply(files)
+		return files, more, nil
+	}
+
+	limit := page.Limit
+	if limit > 0 {
+		// One more tells whether entries follow the page
+		limit++
+	}
+	files, err := qfs.listMessages(queueName, page.Offset, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	if page.Limit > 0 && len(files) > page.Limit {
+		return files[:page.Limit], true, nil
+	}
+	return files, false, nil
+}
@@ -24,8 +24,10 @@ const (
 
 // Meta values for QueueFS plugin
 const (
-	MetaValueQueueControl = "control" // Queue control files (enqueue, dequeue, peek, clear)
-	MetaValueQueueStatus  = "status"  // Queue status files (size)
+	MetaValueQueueControl  = "control"  // Queue control files (enqueue, dequeue, peek, clear)
+	MetaValueQueueStatus   = "status"   // Queue status files (size)
+	MetaValueQueueMessages = "messages" // Directory of the pending messages of a queue
+	MetaValueQueueMessage  = "message"  // Pending message in a messages directory
 )
 
 // QueueFSPlugin provides a message queue service through a file system interface.
@@ -38,6 +40,7 @@ const (
 //	                      This can be used for implementing poll offset logic
 //	/queue_name/size    - read to get queue size
 //	/queue_name/clear   - write to this file to clear the queue
+//	/queue_name/messages/<id> - the pending messages, readable and removable by ID
 //
 // Supports multiple backends:
 //   - memory (default): In-memory storage
@@ -45,8 +48,13 @@ const (
 //   - sqlite: SQLite database storage
 type QueueFSPlugin struct {
 	backend  QueueBackend
-	mu       sync.RWMutex // Protects backend operations
+	mu       sync.RWMutex // Protects backend operations and modTimes
 	metadata plugin.PluginMetadata
+
+	// modTimes holds the last change of each queue and directory since the plugin started,
+	// "" is the root; other times fall back to the last enqueue or the start of the plugin
+	modTimes map[string]time.Time
+	started  time.Time
 }
 
 // Queue represents a single message queue (for memory backend)
@@ -71,6 +79,8 @@ func NewQueueFSPlugin() *QueueFSPlugin {
 			Description: "Message queue service plugin with multiple queue support and pluggable backends",
 			Author:      "AGFS Server",
 		},
+		modTimes: make(map[string]time.Time),
+		started:  time.Now(),
 	}
 }
 
@@ -127,6 +137,23 @@ func (q *QueueFSPlugin) Validate(cfg map[string]interface{}) error {
 	return nil
 }
 
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (q *QueueFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "backend", Type: plugin.ParamString, Default: "memory", Choices: []string{"memory", "sqlite", "tidb", "mysql"}, Description: "Where the queues are stored"},
+		{Name: "db_path", Type: plugin.ParamString, Default: "queue.db", Description: "SQLite database file"},
+		{Name: "dsn", Type: plugin.ParamString, Secret: true, Description: "TiDB/MySQL data source name, instead of host, port, user and password"},
+		{Name: "host", Type: plugin.ParamString, Description: "TiDB/MySQL host"},
+		{Name: "port", Type: plugin.ParamInt, Description: "TiDB/MySQL port"},
+		{Name: "user", Type: plugin.ParamString, Description: "TiDB/MySQL user"},
+		{Name: "password", Type: plugin.ParamString, Secret: true, Description: "TiDB/MySQL password"},
+		{Name: "database", Type: plugin.ParamString, Description: "TiDB/MySQL database"},
+		{Name: "enable_tls", Type: plugin.ParamBool, Default: "false", Description: "Connect to TiDB/MySQL over TLS"},
+		{Name: "tls_server_name", Type: plugin.ParamString, Description: "Server name verified with TLS (default host)"},
+		{Name: "tls_skip_verify", Type: plugin.ParamBool, Default: "false", Description: "Skip verifying the server certificate"},
+	}
+}
+
 func (q *QueueFSPlugin) Initialize(cfg map[string]interface{}) error {
 	backendType := config.GetStringConfig(cfg, "backend", "memory")
 
@@ -173,6 +200,7 @@ STRUCTURE:
       peek          - Read-only file to peek at next message
       size          - Read-only file showing queue size
       clear         - Write-only file to clear all messages
+      messages/     - Read-only listing of the pending messages, one file per message ID
 
 WORKFLOW:
   1. Create a queue:
@@ -196,12 +224,29 @@ WORKFLOW:
   7. Delete the queue:
      rm -rf /queuefs/my_queue
 
+BROWSING MESSAGES:
+  messages/ lists the pending messages in queue order, named by ID, with their
+  size and enqueue time, without dequeuing them. Read a message by its ID, or
+  remove a stuck one from anywhere in the queue:
+    ls /queuefs/my_queue/messages
+    cat /queuefs/my_queue/messages/<id>
+    rm /queuefs/my_queue/messages/<id>
+  Large queues are paged by the backend:
+    curl "localhost:8080/api/v1/directories?path=/queuefs/my_queue/messages&offset=100&limit=50"
+  A nested queue cannot be named "messages".
+
 NESTED QUEUES:
   You can create queues in nested directories:
     mkdir -p /queuefs/logs/errors
     echo "error: timeout" > /queuefs/logs/errors/enqueue
     cat /queuefs/logs/errors/dequeue
 
+BULK API:
+  Batches of up to 1000 messages go through the plugin's HTTP endpoints:
+    curl -X POST localhost:8080/api/v1/plugins/queuefs/my_queue/enqueue -d '["a", "b"]'
+    curl -X POST "localhost:8080/api/v1/plugins/queuefs/my_queue/dequeue?n=10"
+    curl localhost:8080/api/v1/plugins/queuefs/
+
 BACKENDS:
 
   Memory Backend (default):
@@ -247,6 +292,11 @@ BACKENDS:
     enable_tls = true
     tls_server_name = "gateway01.us-west-2.prod.aws.tidbcloud.com"
 
+FORMATS:
+  dequeue and peek return the message as JSON, or its bare data when read
+  as text; size returns the count as text, or {"size":N} when read as JSON.
+  Choose with the format=json|text query parameter or the Accept header.
+
 EXAMPLES:
   # Create multiple queues
   agfs:/> mkdir /queuefs/orders
@@ -290,6 +340,85 @@ func (q *QueueFSPlugin) Shutdown() error {
 	return nil
 }
 
+// Gauges implements plugin.GaugeReporter with the number of queues and queued messages
+func (q *QueueFSPlugin) Gauges() map[string]interface{} {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	gauges := map[string]interface{}{}
+	if q.backend == nil {
+		return gauges
+	}
+	names, err := q.backend.ListQueues("")
+	if err != nil {
+		gauges["error"] = err.Error()
+		return gauges
+	}
+	messages := 0
+	for _, name := range names {
+		if size, err := q.backend.Size(name); err == nil {
+			messages += size
+		}
+	}
+	gauges["queues"], gauges["messages"] = len(names), messages
+	return gauges
+}
+
+// modTime returns the modification time of the queue or directory name, "" for the root;
+// the caller must hold mu
+func (q *QueueFSPlugin) modTime(name string) time.Time {
+	if t, ok := q.modTimes[name]; ok {
+		return t
+	}
+	if name != "" {
+		if t, err := q.backend.GetLastEnqueueTime(name); err == nil && !t.IsZero() {
+			return t
+		}
+	}
+	return q.started
+}
+
+// parentQueue returns the directory containing the queue name, "" for the root
+func parentQueue(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// touchQueue records a change to the messages of the queue name; a queue seen for the first
+// time may be a new entry of the directories above it, which are updated as well. The
+// caller must hold mu for writing
+func (q *QueueFSPlugin) touchQueue(name string) {
+	_, known := q.modTimes[name]
+	now := time.Now()
+	q.modTimes[name] = now
+	if !known {
+		for dir := name; dir != ""; {
+			dir = parentQueue(dir)
+			q.modTimes[dir] = now
+		}
+	}
+}
+
+// queueRemoved forgets the times of the queue name and the queues below it and updates the
+// directories above it; the caller must hold mu for writing
+func (q *QueueFSPlugin) queueRemoved(name string) {
+	for n := range q.modTimes {
+		if name == "" || n == name || strings.HasPrefix(n, name+"/") {
+			delete(q.modTimes, n)
+		}
+	}
+	now := time.Now()
+	if name == "" {
+		q.modTimes[""] = now
+	}
+	for dir := name; dir != ""; {
+		dir = parentQueue(dir)
+		q.modTimes[dir] = now
+	}
+}
+
 // queueFS implements the FileSystem interface for queue operations
 type queueFS struct {
 	plugin *QueueFSPlugin
@@ -347,6 +476,10 @@ func isValidQueueOperation(op string) bool {
 }
 
 func (qfs *queueFS) Create(path string) error {
+	if _, _, ok := parseMessagePath(path); ok {
+		return fmt.Errorf("permission denied: %s is read-only", path)
+	}
+
 	_, operation, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return err
@@ -365,6 +498,10 @@ func (qfs *queueFS) Create(path string) error {
 }
 
 func (qfs *queueFS) Mkdir(path string, perm uint32) error {
+	if _, _, ok := parseMessagePath(path); ok {
+		return fmt.Errorf("cannot create queue: %s is reserved for listing messages", path)
+	}
+
 	queueName, _, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return err
@@ -382,10 +519,21 @@ func (qfs *queueFS) Mkdir(path string, perm uint32) error {
 	qfs.plugin.mu.Lock()
 	defer qfs.plugin.mu.Unlock()
 
-	return qfs.plugin.backend.CreateQueue(queueName)
+	if err := qfs.plugin.backend.CreateQueue(queueName); err != nil {
+		return err
+	}
+	qfs.plugin.touchQueue(queueName)
+	return nil
 }
 
 func (qfs *queueFS) Remove(path string) error {
+	if queueName, id, ok := parseMessagePath(path); ok {
+		if id == "" {
+			return fmt.Errorf("cannot remove: %s lists the messages of the queue", path)
+		}
+		return qfs.removeMessage(queueName, id)
+	}
+
 	_, operation, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return err
@@ -403,6 +551,10 @@ func (qfs *queueFS) Remove(path string) error {
 }
 
 func (qfs *queueFS) RemoveAll(path string) error {
+	if _, _, ok := parseMessagePath(path); ok {
+		return qfs.Remove(path)
+	}
+
 	queueName, _, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return err
@@ -415,7 +567,11 @@ func (qfs *queueFS) RemoveAll(path string) error {
 	qfs.plugin.mu.Lock()
 	defer qfs.plugin.mu.Unlock()
 
-	return qfs.plugin.backend.RemoveQueue(queueName)
+	if err := qfs.plugin.backend.RemoveQueue(queueName); err != nil {
+		return err
+	}
+	qfs.plugin.queueRemoved(queueName)
+	return nil
 }
 
 func (qfs *queueFS) Read(path string, offset int64, size int64) ([]byte, error) {
@@ -425,6 +581,14 @@ func (qfs *queueFS) Read(path string, offset int64, size int64) ([]byte, error)
 		return plugin.ApplyRangeRead(data, offset, size)
 	}
 
+	if queueName, id, ok := parseMessagePath(path); ok {
+		if id == "" {
+			return nil, fmt.Errorf("is a directory: %s", path)
+		}
+		data, _, err := qfs.readMessage(queueName, id, offset, size, filesystem.FormatJSON)
+		return data, err
+	}
+
 	queueName, operation, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return nil, err
@@ -462,6 +626,10 @@ func (qfs *queueFS) Read(path string, offset int64, size int64) ([]byte, error)
 }
 
 func (qfs *queueFS) Write(path string, data []byte) ([]byte, error) {
+	if _, _, ok := parseMessagePath(path); ok {
+		return nil, fmt.Errorf("permission denied: %s is read-only", path)
+	}
+
 	queueName, operation, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return nil, err
@@ -493,6 +661,13 @@ func (qfs *queueFS) Write(path string, data []byte) ([]byte, error) {
 }
 
 func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if queueName, id, ok := parseMessagePath(path); ok {
+		if id != "" {
+			return nil, fmt.Errorf("not a directory: %s", path)
+		}
+		return qfs.listMessages(queueName, 0, 0)
+	}
+
 	queueName, _, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return nil, err
@@ -502,8 +677,6 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("not a directory: %s", path)
 	}
 
-	now := time.Now()
-
 	// Root directory: list all queues + README
 	if path == "/" || queueName == "" {
 		qfs.plugin.mu.RLock()
@@ -515,7 +688,7 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name:    "README",
 				Size:    int64(len(readme)),
 				Mode:    0444, // read-only
-				ModTime: now,
+				ModTime: qfs.plugin.started,
 				IsDir:   false,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "doc"},
 			},
@@ -541,7 +714,7 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name:    dirName,
 				Size:    0,
 				Mode:    0755,
-				ModTime: now,
+				ModTime: qfs.plugin.modTime(dirName),
 				IsDir:   true,
 				Meta:    filesystem.MetaData{Name: PluginName, Type: "queue"},
 			})
@@ -562,7 +735,7 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	if size > 0 {
 		// This is an actual queue with messages - return control files
-		return qfs.getQueueControlFiles(queueName, now)
+		return qfs.getQueueControlFiles(queueName)
 	}
 
 	// Check for nested queues
@@ -590,7 +763,7 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	if !hasNested {
 		// No messages and no nested queues - treat as empty queue directory
-		return qfs.getQueueControlFiles(queueName, now)
+		return qfs.getQueueControlFiles(queueName)
 	}
 
 	// Return subdirectories
@@ -600,7 +773,7 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			Name:    subdir,
 			Size:    0,
 			Mode:    0755,
-			ModTime: now,
+			ModTime: qfs.plugin.modTime(queueName + "/" + subdir),
 			IsDir:   true,
 			Meta:    filesystem.MetaData{Name: PluginName, Type: "queue"},
 		})
@@ -609,7 +782,11 @@ func (qfs *queueFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	return files, nil
 }
 
-func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]filesystem.FileInfo, error) {
+// getQueueControlFiles lists the control files of a queue, modified when the queue last
+// changed; the caller must hold qfs.plugin.mu
+func (qfs *queueFS) getQueueControlFiles(queueName string) ([]filesystem.FileInfo, error) {
+	modTime := qfs.plugin.modTime(queueName)
+
 	// Get queue size
 	queueSize, err := qfs.plugin.backend.Size(queueName)
 	if err != nil {
@@ -619,7 +796,7 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 	// Get last enqueue time for peek ModTime
 	lastEnqueueTime, err := qfs.plugin.backend.GetLastEnqueueTime(queueName)
 	if err != nil || lastEnqueueTime.IsZero() {
-		lastEnqueueTime = now
+		lastEnqueueTime = modTime
 	}
 
 	files := []filesystem.FileInfo{
@@ -627,7 +804,7 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Name:    "enqueue",
 			Size:    0,
 			Mode:    0222, // write-only
-			ModTime: now,
+			ModTime: modTime,
 			IsDir:   false,
 			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
 		},
@@ -635,9 +812,9 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Name:    "dequeue",
 			Size:    0,
 			Mode:    0444, // read-only
-			ModTime: now,
+			ModTime: modTime,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
+			Meta:    controlMeta("dequeue"),
 		},
 		{
 			Name:    "peek",
@@ -645,36 +822,47 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Mode:    0444,             // read-only
 			ModTime: lastEnqueueTime, // Use last enqueue time for poll offset tracking
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
+			Meta:    controlMeta("peek"),
 		},
 		{
 			Name:    "size",
 			Size:    int64(len(strconv.Itoa(queueSize))),
 			Mode:    0444, // read-only
-			ModTime: now,
+			ModTime: modTime,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueStatus},
+			Meta:    controlMeta("size"),
 		},
 		{
 			Name:    "clear",
 			Size:    0,
 			Mode:    0222, // write-only
-			ModTime: now,
+			ModTime: modTime,
 			IsDir:   false,
 			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
 		},
+		{
+			Name:    messagesDir,
+			Size:    0,
+			Mode:    0555, // read-only
+			ModTime: modTime,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueMessages},
+		},
 	}
 
 	return files, nil
 }
 
 func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
+	qfs.plugin.mu.RLock()
+	defer qfs.plugin.mu.RUnlock()
+
 	if path == "/" {
 		return &filesystem.FileInfo{
 			Name:    "/",
 			Size:    0,
 			Mode:    0755,
-			ModTime: time.Now(),
+			ModTime: qfs.plugin.modTime(""),
 			IsDir:   true,
 			Meta: filesystem.MetaData{
 				Name: PluginName,
@@ -692,19 +880,32 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 			Name:    "README",
 			Size:    int64(len(readme)),
 			Mode:    0444,
-			ModTime: time.Now(),
+			ModTime: qfs.plugin.started,
 			IsDir:   false,
 			Meta:    filesystem.MetaData{Name: PluginName, Type: "doc"},
 		}, nil
 	}
 
+	if queueName, id, ok := parseMessagePath(path); ok {
+		if id == "" {
+			return qfs.messagesDirInfo(queueName)
+		}
+		msg, found, err := qfs.plugin.backend.GetMessage(queueName, id)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, filesystem.NewNotFoundError("stat", path)
+		}
+		info := messageInfo(msg)
+		return &info, nil
+	}
+
 	queueName, operation, isDir, err := parseQueuePath(path)
 	if err != nil {
 		return nil, err
 	}
 
-	now := time.Now()
-
 	// Directory stat
 	if isDir {
 		name := filepath.Base(path)
@@ -716,7 +917,7 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 			Name:    name,
 			Size:    0,
 			Mode:    0755,
-			ModTime: now,
+			ModTime: qfs.plugin.modTime(queueName),
 			IsDir:   true,
 			Meta:    filesystem.MetaData{Name: PluginName, Type: "queue"},
 		}, nil
@@ -734,12 +935,10 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 		mode = 0444
 	}
 
-	fileType := MetaValueQueueControl
 	size := int64(0)
-	modTime := now
+	modTime := qfs.plugin.modTime(queueName)
 
 	if operation == "size" {
-		fileType = MetaValueQueueStatus
 		queueSize, _ := qfs.plugin.backend.Size(queueName)
 		size = int64(len(strconv.Itoa(queueSize)))
 	} else if operation == "peek" {
@@ -756,10 +955,69 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 		Mode:    mode,
 		ModTime: modTime,
 		IsDir:   false,
-		Meta:    filesystem.MetaData{Name: PluginName, Type: fileType},
+		Meta:    controlMeta(operation),
 	}, nil
 }
 
+// controlMeta returns the metadata of the control file of operation, declaring the formats
+// of the readable ones
+func controlMeta(operation string) filesystem.MetaData {
+	switch operation {
+	case "dequeue", "peek":
+		return filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl,
+			Content: filesystem.FormatMeta(filesystem.FormatJSON, filesystem.FormatJSON, filesystem.FormatText)}
+	case "size":
+		return filesystem.MetaData{Name: PluginName, Type: MetaValueQueueStatus,
+			Content: filesystem.FormatMeta(filesystem.FormatText, filesystem.FormatText, filesystem.FormatJSON)}
+	}
+	return filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl}
+}
+
+// ReadFormat implements filesystem.FormatReader: dequeue and peek return a message as JSON
+// by default and its bare data as text, size returns the count as text by default and as
+// {"size": n} in JSON
+func (qfs *queueFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	if queueName, id, ok := parseMessagePath(path); ok && id != "" {
+		return qfs.readMessage(queueName, id, offset, size, format)
+	}
+
+	queueName, operation, isDir, err := parseQueuePath(path)
+	if err != nil || isDir || (operation != "dequeue" && operation != "peek" && operation != "size") {
+		data, err := qfs.Read(path, offset, size)
+		return data, "", err
+	}
+
+	var data []byte
+	switch operation {
+	case "dequeue", "peek":
+		if operation == "dequeue" {
+			data, err = qfs.dequeue(queueName)
+		} else {
+			data, err = qfs.peek(queueName)
+		}
+		if err == nil && format == filesystem.FormatText {
+			var msg QueueMessage
+			if err = json.Unmarshal(data, &msg); err == nil {
+				data = []byte(msg.Data)
+			}
+		} else {
+			format = filesystem.FormatJSON
+		}
+	case "size":
+		data, err = qfs.size(queueName)
+		if err == nil && format == filesystem.FormatJSON {
+			data = []byte(`{"size":` + string(data) + `}`)
+		} else {
+			format = filesystem.FormatText
+		}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = plugin.ApplyRangeRead(data, offset, size)
+	return data, format, err
+}
+
 func (qfs *queueFS) Rename(oldPath, newPath string) error {
 	return fmt.Errorf("cannot rename files in queuefs service")
 }
@@ -819,6 +1077,7 @@ func (qfs *queueFS) enqueue(queueName string, data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	qfs.plugin.touchQueue(queueName)
 
 	return []byte(msg.ID), nil
 }
@@ -836,6 +1095,7 @@ func (qfs *queueFS) dequeue(queueName string) ([]byte, error) {
 		// Return empty JSON object instead of error for empty queue
 		return []byte("{}"), nil
 	}
+	qfs.plugin.touchQueue(queueName)
 
 	return json.Marshal(msg)
 }
@@ -873,9 +1133,17 @@ func (qfs *queueFS) clear(queueName string) error {
 	qfs.plugin.mu.Lock()
 	defer qfs.plugin.mu.Unlock()
 
-	return qfs.plugin.backend.Clear(queueName)
+	if err := qfs.plugin.backend.Clear(queueName); err != nil {
+		return err
+	}
+	qfs.plugin.touchQueue(queueName)
+	return nil
 }
 
 // Ensure QueueFSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*QueueFSPlugin)(nil)
+var _ plugin.HTTPHandlerProvider = (*QueueFSPlugin)(nil)
+var _ plugin.GaugeReporter = (*QueueFSPlugin)(nil)
 var _ filesystem.FileSystem = (*queueFS)(nil)
+var _ filesystem.FormatReader = (*queueFS)(nil)
+var _ filesystem.PageLister = (*queueFS)(nil)
@@ -0,0 +1,137 @@
+package queuefs
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestDirectoryModTime(t *testing.T) {
+	q := NewQueueFSPlugin()
+	if err := q.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	fs := q.GetFileSystem()
+
+	modTime := func(path string) time.Time {
+		t.Helper()
+		info, err := fs.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime
+	}
+	// changed runs op and reports whether it moved the modification time of each path
+	changed := func(op func() error, paths ...string) []bool {
+		t.Helper()
+		before := make([]time.Time, len(paths))
+		for i, p := range paths {
+			before[i] = modTime(p)
+		}
+		time.Sleep(time.Millisecond)
+		if err := op(); err != nil {
+			t.Fatal(err)
+		}
+		result := make([]bool, len(paths))
+		for i, p := range paths {
+			result[i] = modTime(p).After(before[i])
+		}
+		return result
+	}
+
+	// Listings and stats are stable between changes
+	if first, second := modTime("/"), modTime("/"); !first.Equal(second) {
+		t.Errorf("root modified between two stats: %v, %v", first, second)
+	}
+
+	if got := changed(func() error { return fs.Mkdir("/jobs/urgent", 0755) }, "/", "/jobs"); !got[0] || !got[1] {
+		t.Errorf("mkdir updated root %v, parent %v", got[0], got[1])
+	}
+	if got := changed(func() error { _, err := fs.Write("/jobs/urgent/enqueue", []byte("a")); return err }, "/jobs/urgent", "/jobs"); !got[0] || got[1] {
+		t.Errorf("enqueue updated the queue %v, its parent %v", got[0], got[1])
+	}
+	dequeue := func() error {
+		if _, err := fs.Read("/jobs/urgent/dequeue", 0, -1); err != io.EOF {
+			return err
+		}
+		return nil
+	}
+	if got := changed(dequeue, "/jobs/urgent", "/jobs/urgent/size"); !got[0] || !got[1] {
+		t.Errorf("dequeue updated the queue %v, its size file %v", got[0], got[1])
+	}
+	if got := changed(func() error { return fs.RemoveAll("/jobs/urgent") }, "/jobs"); !got[0] {
+		t.Error("removing a queue did not update its parent")
+	}
+
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name == "jobs" && !e.ModTime.Equal(modTime("/jobs")) {
+			t.Errorf("listing shows %v, stat %v", e.ModTime, modTime("/jobs"))
+		}
+	}
+}
+
+func TestMessages(t *testing.T) {
+	q := NewQueueFSPlugin()
+	if err := q.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	fs := q.GetFileSystem()
+
+	var ids []string
+	for _, msg := range []string{"a", "bb", "ccc"} {
+		id, err := fs.Write("/jobs/enqueue", []byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, string(id))
+	}
+
+	entries, err := fs.ReadDir("/jobs/messages")
+	if err != nil || len(entries) != 3 || entries[0].Name != ids[0] || entries[2].Size != 3 {
+		t.Fatalf("messages = %+v, %v", entries, err)
+	}
+	if data, err := fs.Read("/jobs/messages/"+ids[1], 0, -1); err != io.EOF || !strings.Contains(string(data), `"data":"bb"`) {
+		t.Errorf("read message = %q, %v", data, err)
+	}
+	if data, format, err := fs.(filesystem.FormatReader).ReadFormat("/jobs/messages/"+ids[1], 0, -1, filesystem.FormatText); err != io.EOF || string(data) != "bb" || format != filesystem.FormatText {
+		t.Errorf("read message as text = %q, %q, %v", data, format, err)
+	}
+
+	// Removing a message from the middle keeps the others in order
+	if err := fs.Remove("/jobs/messages/" + ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/jobs/messages/" + ids[1]); !filesystem.IsNotFound(err) {
+		t.Errorf("stat of a removed message: %v", err)
+	}
+	if err := fs.Remove("/jobs/messages/" + ids[1]); !filesystem.IsNotFound(err) {
+		t.Errorf("removing a message twice: %v", err)
+	}
+	page, more, err := fs.(filesystem.PageLister).ReadDirPage("/jobs/messages", filesystem.ListPage{Limit: 1})
+	if err != nil || len(page) != 1 || page[0].Name != ids[0] || !more {
+		t.Errorf("first page = %+v, %v, %v", page, more, err)
+	}
+	if data, _ := fs.Read("/jobs/dequeue", 0, -1); !strings.Contains(string(data), `"data":"a"`) {
+		t.Errorf("dequeue = %q", data)
+	}
+	if data, _ := fs.Read("/jobs/dequeue", 0, -1); !strings.Contains(string(data), `"data":"ccc"`) {
+		t.Errorf("dequeue after the removed message = %q", data)
+	}
+
+	if _, err := fs.Write("/jobs/messages/"+ids[0], []byte("x")); err == nil {
+		t.Error("write to a message: no error")
+	}
+	if err := fs.Mkdir("/jobs/messages", 0755); err == nil {
+		t.Error("mkdir of a messages directory: no error")
+	}
+	if _, err := fs.ReadDir("/missing/messages"); !filesystem.IsNotFound(err) {
+		t.Errorf("messages of a missing queue: %v", err)
+	}
+}
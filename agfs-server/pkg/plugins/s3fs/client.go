@@ -6,17 +6,22 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	agfscreds "github.com/c4pt0r/agfs/agfs-server/pkg/credentials"
 	log "github.com/sirupsen/logrus"
 )
 
+// credentialsRecheck is how often the SDK asks a provider for credentials that do not expire,
+// so a provider with a refresh interval is consulted
+const credentialsRecheck = time.Minute
+
 // S3Client wraps AWS S3 client with helper methods
 type S3Client struct {
 	client *s3.Client
@@ -27,13 +32,36 @@ type S3Client struct {
 
 // S3Config holds S3 client configuration
 type S3Config struct {
-	Region          string
-	Bucket          string
-	AccessKeyID     string
-	SecretAccessKey string
-	Endpoint        string // Optional custom endpoint (for S3-compatible services)
-	Prefix          string // Optional prefix for all keys
-	DisableSSL      bool   // For testing with local S3
+	Region      string
+	Bucket      string
+	Credentials agfscreds.Provider // nil for the default credential chain of the SDK
+	Endpoint    string             // Optional custom endpoint (for S3-compatible services)
+	Prefix      string             // Optional prefix for all keys
+	DisableSSL  bool               // For testing with local S3
+}
+
+// awsCredentials adapts a credentials provider to the SDK
+type awsCredentials struct {
+	provider agfscreds.Provider
+}
+
+func (c awsCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	expires := creds.Expires
+	if expires.IsZero() {
+		expires = time.Now().Add(credentialsRecheck)
+	}
+	return aws.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Source:          "agfs",
+		CanExpire:       true,
+		Expires:         expires,
+	}, nil
 }
 
 // NewS3Client creates a new S3 client
@@ -49,10 +77,8 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 	}
 
 	// Add credentials if provided
-	if cfg.AccessKeyID != "" && cfg.SecretAccessKey != "" {
-		opts = append(opts, config.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
-		))
+	if cfg.Credentials != nil {
+		opts = append(opts, config.WithCredentialsProvider(awsCredentials{cfg.Credentials}))
 	}
 
 	awsCfg, err = config.LoadDefaultConfig(ctx, opts...)
@@ -95,6 +121,15 @@ func NewS3Client(cfg S3Config) (*S3Client, error) {
 }
 
 // buildKey builds the full S3 key with prefix
+// Ping checks that the bucket is still accessible
+func (c *S3Client) Ping(ctx context.Context) error {
+	_, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)})
+	if err != nil {
+		return fmt.Errorf("failed to access bucket %s: %w", c.bucket, err)
+	}
+	return nil
+}
+
 func (c *S3Client) buildKey(path string) string {
 	// Normalize path
 	path = strings.TrimPrefix(path, "/")
@@ -131,6 +166,38 @@ func (c *S3Client) GetObject(ctx context.Context, path string) ([]byte, error) {
 	return data, nil
 }
 
+// GetObjectRange retrieves length bytes at offset of an object
+// It also returns the total size of the object, taken from the Content-Range of the response
+func (c *S3Client) GetObjectRange(ctx context.Context, path string, offset, length int64) ([]byte, int64, error) {
+	key := c.buildKey(path)
+
+	result, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read object body: %w", err)
+	}
+
+	// Content-Range has the form "bytes 0-99/1234"
+	total := offset + int64(len(data))
+	if cr := aws.ToString(result.ContentRange); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				total = size
+			}
+		}
+	}
+	return data, total, nil
+}
+
 // GetObjectStream retrieves an object from S3 and returns a stream reader
 // The caller is responsible for closing the returned ReadCloser
 func (c *S3Client) GetObjectStream(ctx context.Context, path string) (io.ReadCloser, error) {
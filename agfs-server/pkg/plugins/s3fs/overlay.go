@@ -0,0 +1,201 @@
+package s3fs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// writeTracker remembers the keys written and deleted through the mount for a window and
+// overlays them onto listings, so a listing right after a write reflects it even when the
+// store lists objects eventually (read-your-writes). Only changes made by this server are
+// tracked; a nil tracker tracks nothing.
+type writeTracker struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex              // protects the fields below
+	entries map[string]trackedWrite // Latest change of each key, without the mount prefix
+	queue   []queuedWrite           // Changes in the order they were made, for expiry
+}
+
+// trackedWrite is the latest change of a key
+type trackedWrite struct {
+	deleted bool
+	isDir   bool
+	emptied bool // A directory created again after its contents were deleted
+	size    int64
+	at      time.Time
+}
+
+// queuedWrite is a change waiting to expire
+type queuedWrite struct {
+	key string
+	at  time.Time
+}
+
+// newWriteTracker returns a tracker keeping changes for window, nil if window is 0
+func newWriteTracker(window time.Duration) *writeTracker {
+	if window <= 0 {
+		return nil
+	}
+	return &writeTracker{window: window, now: time.Now, entries: make(map[string]trackedWrite)}
+}
+
+// written records that the file key was written with size bytes
+func (t *writeTracker) written(key string, size int64) {
+	t.record(key, trackedWrite{size: size})
+}
+
+// madeDir records that the directory key was created
+func (t *writeTracker) madeDir(key string) {
+	t.record(key, trackedWrite{isDir: true})
+}
+
+// deleted records that the file or directory key was deleted, a directory with its contents
+func (t *writeTracker) deleted(key string, isDir bool) {
+	t.record(key, trackedWrite{deleted: true, isDir: isDir})
+}
+
+func (t *writeTracker) record(key string, w trackedWrite) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune()
+
+	w.at = t.now()
+	if w.isDir {
+		prev, ok := t.entries[key]
+		if w.deleted {
+			// The changes below the directory are gone with it
+			prefix := key + "/"
+			for k := range t.entries {
+				if key == "" || strings.HasPrefix(k, prefix) {
+					delete(t.entries, k)
+				}
+			}
+		} else if ok && (prev.emptied || prev.deleted && prev.isDir) {
+			w.emptied = true
+		}
+	}
+	t.entries[key] = w
+	t.queue = append(t.queue, queuedWrite{key: key, at: w.at})
+}
+
+// prune forgets the changes older than the window; the caller must hold mu
+func (t *writeTracker) prune() {
+	cutoff := t.now().Add(-t.window)
+	n := 0
+	for n < len(t.queue) && !t.queue[n].at.After(cutoff) {
+		q := t.queue[n]
+		if w, ok := t.entries[q.key]; ok && w.at.Equal(q.at) {
+			delete(t.entries, q.key)
+		}
+		n++
+	}
+	t.queue = t.queue[n:]
+}
+
+// hidden reports whether key, as listed by the store, was deleted since; the caller must
+// hold mu
+func (t *writeTracker) hidden(key string) bool {
+	for k := key; ; k = getParentPath(k) {
+		if w, ok := t.entries[k]; ok {
+			if (w.deleted && (k == key || w.isDir)) || (w.emptied && k != key) {
+				return !t.liveUnder(key)
+			}
+		}
+		if k == "" {
+			return false
+		}
+	}
+}
+
+// liveUnder reports whether key or a key below it was written or created since it was
+// deleted; the caller must hold mu
+func (t *writeTracker) liveUnder(key string) bool {
+	prefix := key + "/"
+	for k, w := range t.entries {
+		if !w.deleted && (k == key || key == "" || strings.HasPrefix(k, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// exists reports whether the directory dir was created or written to (true, true) or
+// deleted (false, true) recently, and false for known if there is no such change
+func (t *writeTracker) exists(dir string) (exists, known bool) {
+	if t == nil {
+		return false, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune()
+	if t.liveUnder(dir) {
+		return true, true
+	}
+	if t.hidden(dir) {
+		return false, true
+	}
+	return false, false
+}
+
+// overlay applies the recent changes below dir to files, the listing of dir by the store:
+// deleted entries are removed, written ones added or updated
+func (t *writeTracker) overlay(dir string, files []filesystem.FileInfo) []filesystem.FileInfo {
+	if t == nil {
+		return files
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune()
+	if len(t.entries) == 0 {
+		return files
+	}
+
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+	result := make([]filesystem.FileInfo, 0, len(files))
+	index := make(map[string]int, len(files))
+	for _, f := range files {
+		if t.hidden(prefix + f.Name) {
+			continue
+		}
+		index[f.Name] = len(result)
+		result = append(result, f)
+	}
+
+	for key, w := range t.entries {
+		if w.deleted || key == dir || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name, _, nested := strings.Cut(key[len(prefix):], "/")
+		info := filesystem.FileInfo{
+			Name:    name,
+			Size:    w.size,
+			Mode:    0644,
+			ModTime: w.at,
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "s3"},
+		}
+		if nested || w.isDir {
+			info.IsDir, info.Mode, info.Size = true, 0755, 0
+		}
+		if i, ok := index[name]; ok {
+			// The store may still list the previous version of a file
+			if !info.IsDir && !result[i].IsDir {
+				result[i].Size, result[i].ModTime = info.Size, info.ModTime
+			}
+			continue
+		}
+		index[name] = len(result)
+		result = append(result, info)
+	}
+	return result
+}
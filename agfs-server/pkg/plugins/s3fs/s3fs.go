@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	agfscreds "github.com/c4pt0r/agfs/agfs-server/pkg/credentials"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
@@ -17,14 +18,21 @@ import (
 )
 
 const (
-	PluginName = "s3fs"
+	PluginName     = "s3fs"
+	DefaultTimeout = 60 * time.Second // Default limit of the S3 calls of one operation
 )
 
 // S3FS implements FileSystem interface using AWS S3 as backend
+// State is held by pointer so that views returned by WithContext share it
 type S3FS struct {
-	client     *S3Client
-	mu         sync.RWMutex
-	pluginName string
+	client          *S3Client
+	mu              *sync.RWMutex
+	pluginName      string
+	readPartSize    int64           // Size of the ranged sub-reads of large reads
+	readConcurrency int             // Ranged sub-reads in flight, 1 reads objects with a single request
+	ctx             context.Context // Context of the S3 calls, nil for none
+	timeout         time.Duration   // Limit of the S3 calls of one operation, 0 for none
+	tracker         *writeTracker   // Recent changes overlaid onto listings, nil if disabled
 }
 
 // NewS3FS creates a new S3-backed file system
@@ -35,14 +43,31 @@ func NewS3FS(cfg S3Config) (*S3FS, error) {
 	}
 
 	return &S3FS{
-		client:     client,
-		pluginName: PluginName,
+		client:          client,
+		mu:              &sync.RWMutex{},
+		pluginName:      PluginName,
+		readPartSize:    plugin.DefaultReadPartSize,
+		readConcurrency: plugin.DefaultReadConcurrency,
+		timeout:         DefaultTimeout,
 	}, nil
 }
 
+// WithContext implements filesystem.ContextBinder, the view's S3 calls run under ctx
+func (fs *S3FS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.ctx = ctx
+	return &bound
+}
+
+// opContext returns the context of the S3 calls of one operation
+func (fs *S3FS) opContext() (context.Context, context.CancelFunc) {
+	return filesystem.OperationContext(fs.ctx, fs.timeout)
+}
+
 func (fs *S3FS) Create(path string) error {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -59,7 +84,7 @@ func (fs *S3FS) Create(path string) error {
 	// Check if parent directory exists
 	parent := getParentPath(path)
 	if parent != "" {
-		dirExists, err := fs.client.DirectoryExists(ctx, parent)
+		dirExists, err := fs.directoryExists(ctx, parent)
 		if err != nil {
 			return fmt.Errorf("failed to check parent directory: %w", err)
 		}
@@ -73,19 +98,21 @@ func (fs *S3FS) Create(path string) error {
 	if err != nil {
 		return err
 	}
+	fs.tracker.written(path, 0)
 
 	return nil
 }
 
 func (fs *S3FS) Mkdir(path string, perm uint32) error {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Check if directory already exists
-	exists, err := fs.client.DirectoryExists(ctx, path)
+	exists, err := fs.directoryExists(ctx, path)
 	if err != nil {
 		return fmt.Errorf("failed to check if directory exists: %w", err)
 	}
@@ -96,7 +123,7 @@ func (fs *S3FS) Mkdir(path string, perm uint32) error {
 	// Check if parent directory exists
 	parent := getParentPath(path)
 	if parent != "" {
-		dirExists, err := fs.client.DirectoryExists(ctx, parent)
+		dirExists, err := fs.directoryExists(ctx, parent)
 		if err != nil {
 			return fmt.Errorf("failed to check parent directory: %w", err)
 		}
@@ -106,12 +133,17 @@ func (fs *S3FS) Mkdir(path string, perm uint32) error {
 	}
 
 	// Create directory marker
-	return fs.client.CreateDirectory(ctx, path)
+	if err := fs.client.CreateDirectory(ctx, path); err != nil {
+		return err
+	}
+	fs.tracker.madeDir(path)
+	return nil
 }
 
 func (fs *S3FS) Remove(path string) error {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -124,11 +156,15 @@ func (fs *S3FS) Remove(path string) error {
 
 	if exists {
 		// It's a file, delete it
-		return fs.client.DeleteObject(ctx, path)
+		if err := fs.client.DeleteObject(ctx, path); err != nil {
+			return err
+		}
+		fs.tracker.deleted(path, false)
+		return nil
 	}
 
 	// Check if it's a directory
-	dirExists, err := fs.client.DirectoryExists(ctx, path)
+	dirExists, err := fs.directoryExists(ctx, path)
 	if err != nil {
 		return fmt.Errorf("failed to check if directory exists: %w", err)
 	}
@@ -138,7 +174,7 @@ func (fs *S3FS) Remove(path string) error {
 	}
 
 	// Check if directory is empty
-	objects, err := fs.client.ListObjects(ctx, path)
+	objects, err := fs.listObjects(ctx, path)
 	if err != nil {
 		return fmt.Errorf("failed to list directory: %w", err)
 	}
@@ -148,27 +184,47 @@ func (fs *S3FS) Remove(path string) error {
 	}
 
 	// Delete directory marker
-	return fs.client.DeleteObject(ctx, path+"/")
+	if err := fs.client.DeleteObject(ctx, path+"/"); err != nil {
+		return err
+	}
+	fs.tracker.deleted(path, true)
+	return nil
 }
 
 func (fs *S3FS) RemoveAll(path string) error {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	return fs.client.DeleteDirectory(ctx, path)
+	if err := fs.client.DeleteDirectory(ctx, path); err != nil {
+		return err
+	}
+	fs.tracker.deleted(path, true)
+	return nil
 }
 
 func (fs *S3FS) Read(path string, offset int64, size int64) ([]byte, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
-	// Get the entire object (S3 doesn't support efficient range reads in this simple implementation)
+	if fs.readConcurrency > 1 {
+		data, err := fs.parallelRead(ctx, path, offset, size)
+		if err != nil && err != io.EOF {
+			if strings.Contains(err.Error(), "NoSuchKey") || strings.Contains(err.Error(), "NotFound") {
+				return nil, fmt.Errorf("no such file: %s", path)
+			}
+		}
+		return data, err
+	}
+
+	// Get the entire object
 	data, err := fs.client.GetObject(ctx, path)
 	if err != nil {
 		if strings.Contains(err.Error(), "NoSuchKey") || strings.Contains(err.Error(), "NotFound") {
@@ -181,15 +237,60 @@ func (fs *S3FS) Read(path string, offset int64, size int64) ([]byte, error) {
 	return plugin.ApplyRangeRead(data, offset, size)
 }
 
+// parallelRead reads the first part of the range, which also reveals the object size,
+// then fetches the remaining parts concurrently
+func (fs *S3FS) parallelRead(ctx context.Context, path string, offset, size int64) ([]byte, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	length := fs.readPartSize
+	if size >= 0 && size < length {
+		length = size
+	}
+	if length == 0 {
+		return []byte{}, nil
+	}
+
+	first, total, err := fs.client.GetObjectRange(ctx, path, offset, length)
+	if err != nil {
+		if strings.Contains(err.Error(), "InvalidRange") {
+			// The offset is at or past the end of the object
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	end := total
+	if size >= 0 && offset+size < total {
+		end = offset + size
+	}
+	if offset+int64(len(first)) >= end {
+		if end >= total {
+			return first, io.EOF
+		}
+		return first, nil
+	}
+
+	rest, err := plugin.ParallelRangeRead(total, offset+int64(len(first)), end-offset-int64(len(first)), fs.readPartSize, fs.readConcurrency,
+		func(off, n int64) ([]byte, error) {
+			data, _, err := fs.client.GetObjectRange(ctx, path, off, n)
+			return data, err
+		})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return append(first, rest...), err
+}
+
 func (fs *S3FS) Write(path string, data []byte) ([]byte, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Check if it's a directory
-	dirExists, _ := fs.client.DirectoryExists(ctx, path)
+	dirExists, _ := fs.directoryExists(ctx, path)
 	if dirExists {
 		return nil, fmt.Errorf("is a directory: %s", path)
 	}
@@ -197,7 +298,7 @@ func (fs *S3FS) Write(path string, data []byte) ([]byte, error) {
 	// Check if parent directory exists
 	parent := getParentPath(path)
 	if parent != "" {
-		parentExists, err := fs.client.DirectoryExists(ctx, parent)
+		parentExists, err := fs.directoryExists(ctx, parent)
 		if err != nil {
 			return nil, fmt.Errorf("failed to check parent directory: %w", err)
 		}
@@ -211,29 +312,68 @@ func (fs *S3FS) Write(path string, data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	fs.tracker.written(path, int64(len(data)))
 
 	return []byte(fmt.Sprintf("Written %d bytes to %s", len(data), path)), nil
 }
 
 func (fs *S3FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	return fs.readDir(path, true)
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+// The listing already carries sizes and times; partial listings save the requests checking
+// that the directory exists (a HEAD of its marker and a listing) unless it turns out empty
+func (fs *S3FS) ReadDirFields(path string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	return fs.readDir(path, fields.All())
+}
+
+// readDir lists path, checking that the directory exists first if checkFirst is set and
+// only for an empty listing otherwise
+func (fs *S3FS) readDir(path string, checkFirst bool) ([]filesystem.FileInfo, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
 	// Check if directory exists
-	if path != "" {
-		exists, err := fs.client.DirectoryExists(ctx, path)
+	checkExists := func() error {
+		if path == "" {
+			return nil
+		}
+		exists, err := fs.directoryExists(ctx, path)
 		if err != nil {
-			return nil, fmt.Errorf("failed to check directory: %w", err)
+			return fmt.Errorf("failed to check directory: %w", err)
 		}
 		if !exists {
-			return nil, fmt.Errorf("no such directory: %s", path)
+			return fmt.Errorf("no such directory: %s", path)
+		}
+		return nil
+	}
+	if checkFirst {
+		if err := checkExists(); err != nil {
+			return nil, err
 		}
 	}
 
 	// List objects
+	files, err := fs.listObjects(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !checkFirst && len(files) == 0 {
+		if err := checkExists(); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// listObjects lists the entries of the directory path with the recent changes overlaid
+func (fs *S3FS) listObjects(ctx context.Context, path string) ([]filesystem.FileInfo, error) {
 	objects, err := fs.client.ListObjects(ctx, path)
 	if err != nil {
 		return nil, err
@@ -253,13 +393,22 @@ func (fs *S3FS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			},
 		})
 	}
+	return fs.tracker.overlay(path, files), nil
+}
 
-	return files, nil
+// directoryExists checks if the directory path exists, trusting recent changes over the
+// listing of the store
+func (fs *S3FS) directoryExists(ctx context.Context, path string) (bool, error) {
+	if exists, known := fs.tracker.exists(path); known {
+		return exists, nil
+	}
+	return fs.client.DirectoryExists(ctx, path)
 }
 
 func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
 	path = filesystem.NormalizeS3Key(path)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
@@ -306,7 +455,7 @@ func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
 	}
 
 	// Try as directory
-	dirExists, err := fs.client.DirectoryExists(ctx, path)
+	dirExists, err := fs.directoryExists(ctx, path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check directory: %w", err)
 	}
@@ -336,7 +485,8 @@ func (fs *S3FS) Stat(path string) (*filesystem.FileInfo, error) {
 func (fs *S3FS) Rename(oldPath, newPath string) error {
 	oldPath = filesystem.NormalizeS3Key(oldPath)
 	newPath = filesystem.NormalizeS3Key(newPath)
-	ctx := context.Background()
+	ctx, cancel := fs.opContext()
+	defer cancel()
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -362,11 +512,14 @@ func (fs *S3FS) Rename(oldPath, newPath string) error {
 		return fmt.Errorf("failed to write destination: %w", err)
 	}
 
+	fs.tracker.written(newPath, int64(len(data)))
+
 	// Delete old object
 	err = fs.client.DeleteObject(ctx, oldPath)
 	if err != nil {
 		return fmt.Errorf("failed to delete source: %w", err)
 	}
+	fs.tracker.deleted(oldPath, false)
 
 	return nil
 }
@@ -405,6 +558,12 @@ func (w *s3fsWriter) Close() error {
 	return err
 }
 
+// Abort implements filesystem.Aborter, nothing is uploaded
+func (w *s3fsWriter) Abort() error {
+	w.buf = nil
+	return nil
+}
+
 // S3FSPlugin wraps S3FS as a plugin
 type S3FSPlugin struct {
 	fs     *S3FS
@@ -422,7 +581,8 @@ func (p *S3FSPlugin) Name() string {
 
 func (p *S3FSPlugin) Validate(cfg map[string]interface{}) error {
 	// Check for unknown parameters
-	allowedKeys := []string{"bucket", "region", "access_key_id", "secret_access_key", "endpoint", "prefix", "disable_ssl", "mount_path"}
+	allowedKeys := []string{"bucket", "region", "access_key_id", "secret_access_key", "credentials", "endpoint", "prefix", "disable_ssl",
+		"read_part_size", "read_concurrency", "timeout", "consistency_window", "mount_path"}
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
 	}
@@ -439,26 +599,66 @@ func (p *S3FSPlugin) Validate(cfg map[string]interface{}) error {
 		}
 	}
 
+	if _, err := credentialsProvider(cfg); err != nil {
+		return err
+	}
+
 	// Validate disable_ssl (optional boolean)
 	if err := config.ValidateBoolType(cfg, "disable_ssl"); err != nil {
 		return err
 	}
 
+	// Validate parallel read settings
+	if _, err := config.GetSizeConfig(cfg, "read_part_size", plugin.DefaultReadPartSize); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "read_concurrency"); err != nil {
+		return err
+	}
+	if _, err := config.GetDurationConfig(cfg, "timeout", DefaultTimeout); err != nil {
+		return fmt.Errorf("invalid timeout: %w", err)
+	}
+	if window, err := config.GetDurationConfig(cfg, "consistency_window", 0); err != nil || window < 0 {
+		return fmt.Errorf("invalid consistency_window: %v", cfg["consistency_window"])
+	}
+
 	return nil
 }
 
-func (p *S3FSPlugin) Initialize(config map[string]interface{}) error {
-	p.config = config
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *S3FSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "bucket", Type: plugin.ParamString, Required: true, Description: "S3 bucket"},
+		{Name: "region", Type: plugin.ParamString, Default: "us-east-1", Description: "AWS region"},
+		{Name: "access_key_id", Type: plugin.ParamString, Description: "Access key, the default credential chain is used without one"},
+		{Name: "secret_access_key", Type: plugin.ParamString, Secret: true, Description: "Secret of the access key"},
+		{Name: "credentials", Type: plugin.ParamMap, Description: "Credential provider (static, env, file, exec or metadata) instead of the access key"},
+		{Name: "endpoint", Type: plugin.ParamString, Description: "Endpoint of S3 compatible storage such as MinIO"},
+		{Name: "prefix", Type: plugin.ParamString, Description: "Key prefix the mount is restricted to"},
+		{Name: "disable_ssl", Type: plugin.ParamBool, Default: "false", Description: "Use plain HTTP with the endpoint"},
+		{Name: "read_part_size", Type: plugin.ParamSize, Default: "8MB", Description: "Size of the ranged requests of parallel reads"},
+		{Name: "read_concurrency", Type: plugin.ParamInt, Default: "1", Description: "Ranged requests in flight (1 disables parallel reads)"},
+		{Name: "timeout", Type: plugin.ParamDuration, Default: DefaultTimeout.String(), Description: "Limit of the S3 calls of one operation"},
+		{Name: "consistency_window", Type: plugin.ParamDuration, Default: "0", Description: "How long listings include writes and deletes the store may not list yet (0 disables)"},
+	}
+}
+
+func (p *S3FSPlugin) Initialize(pluginConfig map[string]interface{}) error {
+	p.config = pluginConfig
+
+	creds, err := credentialsProvider(pluginConfig)
+	if err != nil {
+		return err
+	}
 
 	// Parse configuration
 	cfg := S3Config{
-		Region: getStringConfig(config, "region", "us-east-1"),
-		Bucket: getStringConfig(config, "bucket", ""),
-		AccessKeyID: getStringConfig(config, "access_key_id", ""),
-		SecretAccessKey: getStringConfig(config, "secret_access_key", ""),
-		Endpoint: getStringConfig(config, "endpoint", ""),
-		Prefix: getStringConfig(config, "prefix", ""),
-		DisableSSL: getBoolConfig(config, "disable_ssl", false),
+		Region: getStringConfig(pluginConfig, "region", "us-east-1"),
+		Bucket: getStringConfig(pluginConfig, "bucket", ""),
+		Credentials: creds,
+		Endpoint: getStringConfig(pluginConfig, "endpoint", ""),
+		Prefix: getStringConfig(pluginConfig, "prefix", ""),
+		DisableSSL: getBoolConfig(pluginConfig, "disable_ssl", false),
 	}
 
 	if cfg.Bucket == "" {
@@ -472,10 +672,31 @@ func (p *S3FSPlugin) Initialize(config map[string]interface{}) error {
 	}
 	p.fs = fs
 
+	// Parallel ranged reads for large objects
+	if partSize, err := config.GetSizeConfig(pluginConfig, "read_part_size", plugin.DefaultReadPartSize); err == nil && partSize > 0 {
+		fs.readPartSize = partSize
+	}
+	fs.readConcurrency = config.GetIntConfig(pluginConfig, "read_concurrency", plugin.DefaultReadConcurrency)
+	if timeout, err := config.GetDurationConfig(pluginConfig, "timeout", DefaultTimeout); err == nil {
+		fs.timeout = timeout
+	}
+	if window, err := config.GetDurationConfig(pluginConfig, "consistency_window", 0); err == nil {
+		fs.tracker = newWriteTracker(window)
+	}
+
 	log.Infof("[s3fs] Initialized with bucket: %s, region: %s", cfg.Bucket, cfg.Region)
 	return nil
 }
 
+// credentialsProvider returns the provider of the credentials key, or of the static access
+// key of older configurations; nil selects the default credential chain of the SDK
+func credentialsProvider(cfg map[string]interface{}) (agfscreds.Provider, error) {
+	return agfscreds.PluginProvider(cfg, agfscreds.Credentials{
+		AccessKeyID:     getStringConfig(cfg, "access_key_id", ""),
+		SecretAccessKey: getStringConfig(cfg, "secret_access_key", ""),
+	})
+}
+
 func (p *S3FSPlugin) GetFileSystem() filesystem.FileSystem {
 	return p.fs
 }
@@ -488,6 +709,14 @@ func (p *S3FSPlugin) Shutdown() error {
 	return nil
 }
 
+// HealthCheck implements plugin.HealthChecker interface by checking that the bucket is accessible
+func (p *S3FSPlugin) HealthCheck(ctx context.Context) error {
+	if p.fs == nil {
+		return fmt.Errorf("not initialized")
+	}
+	return p.fs.client.Ping(ctx)
+}
+
 func getReadme() string {
 	return `S3FS Plugin - AWS S3-backed File System
 
@@ -528,6 +757,30 @@ CONFIGURATION:
     endpoint = "http://localhost:9000"
     disable_ssl = true
 
+  Parallel Reads (high-latency links):
+  Reads larger than read_part_size are split into ranged GETs fetched
+  concurrently and reassembled.
+
+    [plugins.s3fs.config]
+    read_concurrency = 8       # Ranged GETs in flight (default: 1, disabled)
+    read_part_size = "8MB"     # Size of each ranged GET (default: 8MB)
+
+  Timeouts:
+  The S3 calls of one operation are aborted after timeout (default: 60s,
+  0 disables it); raise it for very large objects on slow links:
+
+    [plugins.s3fs.config]
+    timeout = "5m"
+
+  Read-Your-Writes Listings:
+  Stores that list objects eventually (some MinIO gateways, older
+  providers) may omit a file written a moment ago. With a consistency
+  window, listings include the writes and deletes made through this
+  server during that window:
+
+    [plugins.s3fs.config]
+    consistency_window = "30s"  # Default: 0, disabled
+
   Multiple S3 Buckets:
   [plugins.s3fs_prod]
   enabled = true
@@ -732,5 +985,7 @@ func (fs *S3FS) OpenStream(path string) (filesystem.StreamReader, error) {
 
 // Ensure S3FSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*S3FSPlugin)(nil)
+var _ plugin.HealthChecker = (*S3FSPlugin)(nil)
 var _ filesystem.FileSystem = (*S3FS)(nil)
 var _ filesystem.Streamer = (*S3FS)(nil)
+var _ filesystem.FieldLister = (*S3FS)(nil)
@@ -0,0 +1,375 @@
+package scriptfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxHTTPBody is the size limit of a response read by agfs.http_get
+const maxHTTPBody = 4 << 20
+
+// maxValueDepth bounds the nesting of tables converted to JSON
+const maxValueDepth = 64
+
+// luaLibs are the only standard libraries scripts can use; io, os, package and debug
+// would give scripts access to the host
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// runtime is a loaded script; its Lua state is not safe for concurrent use, callers
+// must serialize calls
+type runtime struct {
+	state   *lua.LState
+	timeout time.Duration
+}
+
+// host is what the agfs helper table gives scripts access to
+type host struct {
+	rootFS    filesystem.FileSystem
+	mountPath string
+	client    *http.Client
+	allow     []*url.URL // URL prefixes http_get may fetch
+
+	// bound is rootFS bound to the context of the hook being called, so that scripts
+	// only access what its caller may
+	bound filesystem.FileSystem
+}
+
+// bind makes the helpers access AGFS with ctx until the next bind; nil unbinds them
+func (h *host) bind(ctx context.Context) {
+	h.bound = nil
+	if ctx != nil && h.rootFS != nil {
+		h.bound = filesystem.WithContext(h.rootFS, ctx)
+	}
+}
+
+// fs is the file system the helpers access
+func (h *host) fs() filesystem.FileSystem {
+	if h.bound != nil {
+		return h.bound
+	}
+	return h.rootFS
+}
+
+// allowed reports whether u is under one of the http_allow prefixes: same scheme and
+// host, and a path equal to the prefix's or below it
+func (h *host) allowed(u *url.URL) bool {
+	for _, prefix := range h.allow {
+		if u.Scheme != prefix.Scheme || !strings.EqualFold(u.Host, prefix.Host) || u.User != nil {
+			continue
+		}
+		base := strings.TrimSuffix(prefix.EscapedPath(), "/")
+		if p := u.EscapedPath(); p == base || strings.HasPrefix(p, base+"/") || base == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// newRuntime runs source in a fresh sandboxed Lua state
+func newRuntime(name, source string, timeout time.Duration, h *host) (*runtime, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range luaLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		parts := make([]string, L.GetTop())
+		for i := range parts {
+			parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+		}
+		log.Infof("[scriptfs] %s: %s", name, strings.Join(parts, "\t"))
+		return 0
+	}))
+	L.SetGlobal("agfs", h.table(L))
+
+	rt := &runtime{state: L, timeout: timeout}
+	fn, err := L.LoadString(source)
+	if err == nil {
+		_, err = rt.call("load", fn)
+	}
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+	if !rt.has("read") {
+		L.Close()
+		return nil, fmt.Errorf("script does not define a read function")
+	}
+	return rt, nil
+}
+
+func (rt *runtime) close() {
+	rt.state.Close()
+}
+
+// has reports whether the script defines the hook
+func (rt *runtime) has(hook string) bool {
+	return rt.state.GetGlobal(hook).Type() == lua.LTFunction
+}
+
+// hook calls a function the script defines and returns its first result
+func (rt *runtime) hook(name string, args ...lua.LValue) (lua.LValue, error) {
+	fn := rt.state.GetGlobal(name)
+	if fn.Type() != lua.LTFunction {
+		return nil, fmt.Errorf("script does not define %s", name)
+	}
+	return rt.call(name, fn, args...)
+}
+
+// call runs fn under the timeout of the script
+func (rt *runtime) call(name string, fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), rt.timeout)
+	defer cancel()
+	L := rt.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, filesystem.NewTimeoutError(name, "script", rt.timeout)
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("script %s failed: %s", name, apiErr.Object.String())
+		}
+		return nil, fmt.Errorf("script %s failed: %w", name, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return ret, nil
+}
+
+// table builds the agfs helper table
+func (h *host) table(L *lua.LState) *lua.LTable {
+	return L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"read":        h.read,
+		"write":       h.write,
+		"ls":          h.ls,
+		"http_get":    h.httpGet,
+		"json_encode": jsonEncode,
+		"json_decode": jsonDecode,
+		"time":        func(L *lua.LState) int { L.Push(lua.LNumber(float64(time.Now().UnixNano()) / 1e9)); return 1 },
+	})
+}
+
+// checkPath returns the AGFS path argument, refusing paths below the mount of the script
+// itself: the hook calling them already holds the script
+func (h *host) checkPath(L *lua.LState) (string, error) {
+	p := filesystem.NormalizePath(L.CheckString(1))
+	if h.rootFS == nil {
+		return "", fmt.Errorf("the root file system is not available")
+	}
+	if h.mountPath != "" && (p == h.mountPath || strings.HasPrefix(p, h.mountPath+"/")) {
+		return "", fmt.Errorf("%s is served by this script", p)
+	}
+	return p, nil
+}
+
+// fail returns nil and the error message, the Lua convention for failed calls
+func fail(L *lua.LState, err error) int {
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+	return 2
+}
+
+func (h *host) read(L *lua.LState) int {
+	p, err := h.checkPath(L)
+	if err != nil {
+		return fail(L, err)
+	}
+	data, err := h.fs().Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return fail(L, err)
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+func (h *host) write(L *lua.LState) int {
+	p, err := h.checkPath(L)
+	if err != nil {
+		return fail(L, err)
+	}
+	if _, err := h.fs().Write(p, []byte(L.CheckString(2))); err != nil {
+		return fail(L, err)
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func (h *host) ls(L *lua.LState) int {
+	p, err := h.checkPath(L)
+	if err != nil {
+		return fail(L, err)
+	}
+	infos, err := h.fs().ReadDir(p)
+	if err != nil {
+		return fail(L, err)
+	}
+	list := L.CreateTable(len(infos), 0)
+	for _, info := range infos {
+		entry := L.CreateTable(0, 3)
+		entry.RawSetString("name", lua.LString(info.Name))
+		entry.RawSetString("size", lua.LNumber(info.Size))
+		entry.RawSetString("dir", lua.LBool(info.IsDir))
+		list.Append(entry)
+	}
+	L.Push(list)
+	return 1
+}
+
+// httpGet fetches a URL under http_allow and returns its body and status code; the
+// request is cancelled when the hook times out
+func (h *host) httpGet(L *lua.LState) int {
+	if len(h.allow) == 0 {
+		return fail(L, fmt.Errorf("agfs.http_get is disabled, no http_allow is configured"))
+	}
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, L.CheckString(1), nil)
+	if err != nil {
+		return fail(L, err)
+	}
+	if !h.allowed(req.URL) {
+		return fail(L, fmt.Errorf("%s is not allowed by http_allow", req.URL.Redacted()))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fail(L, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPBody))
+	if err != nil {
+		return fail(L, err)
+	}
+	L.Push(lua.LString(body))
+	L.Push(lua.LNumber(resp.StatusCode))
+	return 2
+}
+
+func jsonEncode(L *lua.LState) int {
+	v, err := toGo(L.CheckAny(1), 0)
+	if err != nil {
+		return fail(L, err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fail(L, err)
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+func jsonDecode(L *lua.LState) int {
+	var v interface{}
+	if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+		return fail(L, err)
+	}
+	L.Push(toLua(L, v))
+	return 1
+}
+
+// toGo converts a Lua value to its JSON form; tables with a sequence are arrays, other
+// tables objects
+func toGo(v lua.LValue, depth int) (interface{}, error) {
+	if depth > maxValueDepth {
+		return nil, fmt.Errorf("value nested too deeply")
+	}
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		if f := float64(v); math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%v cannot be encoded", f)
+		}
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			list := make([]interface{}, n)
+			for i := 1; i <= n; i++ {
+				item, err := toGo(v.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				list[i-1] = item
+			}
+			return list, nil
+		}
+		obj := make(map[string]interface{})
+		var err error
+		v.ForEach(func(key, value lua.LValue) {
+			if err != nil {
+				return
+			}
+			var item interface{}
+			if item, err = toGo(value, depth+1); err == nil {
+				obj[key.String()] = item
+			}
+		})
+		return obj, err
+	default:
+		return nil, fmt.Errorf("%s values cannot be encoded", v.Type())
+	}
+}
+
+// toLua converts a decoded JSON value to a Lua value
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.CreateTable(0, len(v))
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			t.RawSetString(key, toLua(L, v[key]))
+		}
+		return t
+	default:
+		return lua.LNil
+	}
+}
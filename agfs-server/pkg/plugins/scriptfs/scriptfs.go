@@ -0,0 +1,636 @@
+package scriptfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	PluginName = "scriptfs"
+
+	// DefaultReloadInterval is how often script_path is checked for changes
+	DefaultReloadInterval = 2 * time.Second
+
+	// DefaultTimeout is the longest a hook may run
+	DefaultTimeout = 5 * time.Second
+)
+
+// ScriptFSPlugin serves files computed by a Lua script
+type ScriptFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *scriptFS
+}
+
+// NewScriptFSPlugin creates a new ScriptFS plugin
+func NewScriptFSPlugin() *ScriptFSPlugin {
+	return &ScriptFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem scripts are loaded from and can access
+func (p *ScriptFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *ScriptFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *ScriptFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"script", "script_path", "reload_interval", "timeout", "http_allow", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"script", "script_path"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	script := config.GetStringConfig(cfg, "script", "")
+	scriptPath := config.GetStringConfig(cfg, "script_path", "")
+	if (script == "") == (scriptPath == "") {
+		return fmt.Errorf("exactly one of script and script_path is required")
+	}
+	if scriptPath != "" {
+		if !strings.HasPrefix(scriptPath, "/") {
+			return fmt.Errorf("script_path must be an absolute path: %s", scriptPath)
+		}
+		mountPath := config.GetStringConfig(cfg, "mount_path", "")
+		if p := filesystem.NormalizePath(scriptPath); mountPath != "" && (p == mountPath || strings.HasPrefix(p, mountPath+"/")) {
+			return fmt.Errorf("script_path must not be below the mount path %s", mountPath)
+		}
+	}
+	if script != "" {
+		L := lua.NewState(lua.Options{SkipOpenLibs: true})
+		defer L.Close()
+		if _, err := L.LoadString(script); err != nil {
+			return fmt.Errorf("invalid script: %w", err)
+		}
+	}
+	if interval, err := config.GetDurationConfig(cfg, "reload_interval", DefaultReloadInterval); err != nil {
+		return err
+	} else if interval < 0 {
+		return fmt.Errorf("reload_interval must not be negative")
+	}
+	if timeout, err := config.GetDurationConfig(cfg, "timeout", DefaultTimeout); err != nil {
+		return err
+	} else if timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if _, err := httpAllowList(cfg); err != nil {
+		return err
+	}
+	return nil
+}
+
+// httpAllowList parses the URL prefixes of http_allow, an array of strings or a comma
+// separated string as passed by the shell
+func httpAllowList(cfg map[string]interface{}) ([]*url.URL, error) {
+	var values []string
+	switch v := cfg["http_allow"].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		values = strings.Split(v, ",")
+	case []string:
+		values = v
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("http_allow must be an array of strings")
+			}
+			values = append(values, s)
+		}
+	default:
+		return nil, fmt.Errorf("http_allow must be an array of strings")
+	}
+	var list []*url.URL
+	for _, s := range values {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("http_allow: %q is not an http or https URL prefix", s)
+		}
+		list = append(list, u)
+	}
+	return list, nil
+}
+
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *ScriptFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "script", Type: plugin.ParamString, Description: "Lua source of the hooks, or use script_path"},
+		{Name: "script_path", Type: plugin.ParamString, Description: "AGFS path of the Lua script, reloaded when it changes"},
+		{Name: "reload_interval", Type: plugin.ParamDuration, Default: DefaultReloadInterval.String(), Description: "How often script_path is checked for changes"},
+		{Name: "timeout", Type: plugin.ParamDuration, Default: DefaultTimeout.String(), Description: "Limit of one hook call"},
+		{Name: "http_allow", Type: plugin.ParamStrings, Description: "URL prefixes agfs.http_get may fetch; without any it is disabled"},
+	}
+}
+
+func (p *ScriptFSPlugin) Initialize(cfg map[string]interface{}) error {
+	interval, err := config.GetDurationConfig(cfg, "reload_interval", DefaultReloadInterval)
+	if err != nil {
+		return err
+	}
+	timeout, err := config.GetDurationConfig(cfg, "timeout", DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	allow, err := httpAllowList(cfg)
+	if err != nil {
+		return err
+	}
+	h := &host{
+		rootFS:    p.rootFS,
+		mountPath: config.GetStringConfig(cfg, "mount_path", ""),
+		allow:     allow,
+	}
+	h.client = &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if !h.allowed(req.URL) {
+			return fmt.Errorf("redirect to %s is not allowed by http_allow", req.URL.Redacted())
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}}
+	fs := &script{
+		host:     h,
+		timeout:  timeout,
+		interval: interval,
+	}
+	if scriptPath := config.GetStringConfig(cfg, "script_path", ""); scriptPath != "" {
+		if p.rootFS == nil {
+			return fmt.Errorf("scriptfs needs the root file system to load %s", scriptPath)
+		}
+		// The mount holds the root file system, so the script is loaded on first access
+		fs.scriptPath = filesystem.NormalizePath(scriptPath)
+	} else {
+		rt, err := newRuntime("script", config.GetStringConfig(cfg, "script", ""), timeout, fs.host)
+		if err != nil {
+			return err
+		}
+		fs.rt, fs.loadedAt = rt, time.Now()
+	}
+	p.fs = &scriptFS{script: fs}
+	return nil
+}
+
+func (p *ScriptFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *ScriptFSPlugin) GetReadme() string {
+	return `ScriptFS Plugin - Scripted Files
+
+Serves virtual files computed by a Lua script: computed values, views of
+other AGFS files or shims in front of HTTP APIs, without compiling Go or
+WASM. The script defines global functions the file operations call:
+
+HOOKS:
+  read(path)        - Content of the file: a string, a number, or a table
+                      returned as JSON; nil if the file does not exist (required)
+  list(path)        - Entries of a directory, nil if it does not exist; an
+                      entry is a name ("name/" for a directory) or a table
+                      {name=..., dir=true, size=...}
+  stat(path)        - nil if path does not exist, else {dir=..., size=...};
+                      without it files are looked up in the listing of their parent
+  write(path, data) - Handles a write; a returned value is sent back to the
+                      writer. Without it the files are read-only
+
+Paths are absolute within the mount. Hooks raise errors with error(), and a
+hook running longer than timeout is stopped. Globals keep their values
+between calls. The agfs helpers access AGFS as the caller of the hook, so
+with the access control of the API request that triggered it.
+
+SANDBOX:
+  The base, string, table and math libraries are available; io, os, require,
+  dofile and loadfile are not. print writes to the server log. The agfs table
+  gives access to the rest of AGFS:
+    agfs.read(path), agfs.write(path, data), agfs.ls(path)
+    agfs.http_get(url)  - body, status; only URLs under http_allow
+    agfs.json_encode(value), agfs.json_decode(string)
+    agfs.time()         - Seconds since the epoch
+  Failing calls return nil and an error message.
+
+CONFIGURATION:
+  script           - Inline source of the script
+  script_path      - AGFS path of the script, loaded on first access and
+                     reloaded when it changes
+  reload_interval  - How often script_path is checked (default: 2s, 0: never)
+  timeout          - Longest a hook may run (default: 5s)
+  http_allow       - URL prefixes agfs.http_get may fetch, such as
+                     "https://api.example.com/v1/"; redirects must stay
+                     within them. Without any, agfs.http_get is disabled
+
+  When a changed script fails to load, the previous one keeps serving and
+  the error is logged.
+
+EXAMPLES:
+  agfs:/> cat /memfs/clock.lua
+  function list(path)
+    if path == "/" then return {"now", "uptime.json"} end
+  end
+  function read(path)
+    if path == "/now" then return tostring(agfs.time()) .. "\n" end
+    if path == "/uptime.json" then return {started = started} end
+  end
+  started = agfs.time()
+  agfs:/> mount scriptfs /clock script_path=/memfs/clock.lua
+  agfs:/> cat /clock/now
+`
+}
+
+func (p *ScriptFSPlugin) Shutdown() error {
+	if p.fs != nil {
+		p.fs.close()
+	}
+	return nil
+}
+
+// entry is a directory entry returned by the list or stat hook
+type entry struct {
+	name    string
+	dir     bool
+	size    int64
+	hasSize bool
+}
+
+// script calls the hooks of the script, reloading it when script_path changes
+type script struct {
+	host       *host
+	scriptPath string
+	timeout    time.Duration
+	interval   time.Duration
+
+	mu        sync.Mutex // serializes calls into the script and protects the fields below
+	rt        *runtime
+	closed    bool
+	loadedAt  time.Time
+	lastCheck time.Time
+	modTime   time.Time // of the loaded version of script_path
+	size      int64
+}
+
+// scriptFS is the file system of a script; views bound to a context run its hooks with
+// the agfs helpers accessing AGFS with that context
+type scriptFS struct {
+	*script
+	ctx context.Context
+}
+
+// WithContext implements filesystem.ContextBinder, so scripts read and write only what
+// the caller may
+func (fs *scriptFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &scriptFS{script: fs.script, ctx: ctx}
+}
+
+// load runs the version of script_path described by info
+func (fs *script) load(info *filesystem.FileInfo) error {
+	fs.modTime, fs.size, fs.lastCheck = info.ModTime, info.Size, time.Now()
+	source, err := fs.host.rootFS.Read(fs.scriptPath, 0, -1)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to load script: %w", err)
+	}
+	rt, err := newRuntime(fs.scriptPath, string(source), fs.timeout, fs.host)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", fs.scriptPath, err)
+	}
+	if fs.rt != nil {
+		fs.rt.close()
+	}
+	fs.rt, fs.loadedAt = rt, time.Now()
+	return nil
+}
+
+// acquire locks the script, loading script_path on first use and reloading it when it
+// changed; the script must be released even if acquire fails. Until then the agfs
+// helpers of the hooks access AGFS with ctx, if not nil.
+func (fs *script) acquire(ctx context.Context, op, p string) (*runtime, error) {
+	fs.mu.Lock()
+	fs.host.bind(ctx)
+	if fs.closed {
+		return nil, filesystem.NewUnavailableError(op, p, "scriptfs is shut down")
+	}
+	if fs.scriptPath == "" || (fs.rt != nil && (fs.interval <= 0 || time.Since(fs.lastCheck) < fs.interval)) {
+		return fs.rt, nil
+	}
+	fs.lastCheck = time.Now()
+	info, err := fs.host.rootFS.Stat(fs.scriptPath)
+	if err != nil {
+		if fs.rt == nil {
+			return nil, fmt.Errorf("failed to load script: %w", err)
+		}
+		log.Warnf("[scriptfs] Cannot check %s for changes: %v", fs.scriptPath, err)
+		return fs.rt, nil
+	}
+	if fs.rt != nil && info.ModTime.Equal(fs.modTime) && info.Size == fs.size {
+		return fs.rt, nil
+	}
+	reload := fs.rt != nil
+	if err := fs.load(info); err != nil {
+		if !reload {
+			return nil, err
+		}
+		log.Errorf("[scriptfs] Keeping the previous script: %v", err)
+	} else if reload {
+		log.Infof("[scriptfs] Reloaded %s", fs.scriptPath)
+	}
+	return fs.rt, nil
+}
+
+func (fs *script) release() {
+	fs.host.bind(nil)
+	fs.mu.Unlock()
+}
+
+func (fs *script) close() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.closed = true
+	if fs.rt != nil {
+		fs.rt.close()
+		fs.rt = nil
+	}
+}
+
+// content converts a value returned by a hook to file content
+func content(v lua.LValue) ([]byte, error) {
+	switch v := v.(type) {
+	case lua.LString:
+		return []byte(v), nil
+	case lua.LNumber, lua.LBool:
+		return []byte(v.String()), nil
+	case *lua.LTable:
+		obj, err := toGo(v, 0)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, fmt.Errorf("hook returned a %s", v.Type())
+	}
+}
+
+func (fs *script) read(rt *runtime, p string) ([]byte, error) {
+	ret, err := rt.hook("read", lua.LString(p))
+	if err != nil {
+		return nil, err
+	}
+	if ret == lua.LNil {
+		return nil, filesystem.NewNotFoundError("read", p)
+	}
+	return content(ret)
+}
+
+// list returns the entries of directory p, or nil if the script does not list it
+func (fs *script) list(rt *runtime, p string) ([]entry, error) {
+	if !rt.has("list") {
+		if p == "/" {
+			return []entry{}, nil
+		}
+		return nil, nil
+	}
+	ret, err := rt.hook("list", lua.LString(p))
+	if err != nil {
+		return nil, err
+	}
+	t, ok := ret.(*lua.LTable)
+	if !ok {
+		if ret == lua.LNil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list of %s returned a %s", p, ret.Type())
+	}
+	entries := make([]entry, 0, t.MaxN())
+	for i := 1; i <= t.MaxN(); i++ {
+		var e entry
+		switch v := t.RawGetInt(i).(type) {
+		case lua.LString:
+			e.name = string(v)
+			if strings.HasSuffix(e.name, "/") {
+				e.name, e.dir = strings.TrimSuffix(e.name, "/"), true
+			}
+		case *lua.LTable:
+			e = tableEntry(v)
+			e.name = lua.LVAsString(v.RawGetString("name"))
+		}
+		if e.name == "" || strings.Contains(e.name, "/") {
+			return nil, fmt.Errorf("list of %s returned an invalid entry %d", p, i)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// tableEntry reads the dir and size fields of an entry
+func tableEntry(t *lua.LTable) entry {
+	e := entry{dir: lua.LVAsBool(t.RawGetString("dir"))}
+	if size, ok := t.RawGetString("size").(lua.LNumber); ok {
+		e.size, e.hasSize = int64(size), true
+	}
+	return e
+}
+
+// lookup finds p with the stat hook, in the listing of its parent, or by reading it
+func (fs *script) lookup(rt *runtime, p string) (*entry, error) {
+	if p == "/" {
+		return &entry{name: "/", dir: true}, nil
+	}
+	name := path.Base(p)
+	switch {
+	case rt.has("stat"):
+		ret, err := rt.hook("stat", lua.LString(p))
+		if err != nil {
+			return nil, err
+		}
+		switch v := ret.(type) {
+		case *lua.LTable:
+			e := tableEntry(v)
+			e.name = name
+			return &e, nil
+		case lua.LBool:
+			if v {
+				return &entry{name: name}, nil
+			}
+		}
+	case rt.has("list"):
+		entries, err := fs.list(rt, path.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.name == name {
+				return &e, nil
+			}
+		}
+	default:
+		if _, err := fs.read(rt, p); err == nil {
+			return &entry{name: name}, nil
+		} else if !filesystem.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, filesystem.NewNotFoundError("stat", p)
+}
+
+// info completes an entry to file info, reading files whose size the script did not give
+func (fs *script) info(rt *runtime, p string, e entry) (*filesystem.FileInfo, error) {
+	meta := filesystem.MetaData{Name: PluginName, Type: "file"}
+	if fs.scriptPath != "" {
+		meta.Content = map[string]string{"script": fs.scriptPath}
+	}
+	if e.dir {
+		meta.Type = "directory"
+		return &filesystem.FileInfo{Name: e.name, Mode: 0755, ModTime: fs.loadedAt, IsDir: true, Meta: meta}, nil
+	}
+	if !e.hasSize {
+		data, err := fs.read(rt, p)
+		if err != nil {
+			return nil, err
+		}
+		e.size = int64(len(data))
+	}
+	mode := uint32(0444)
+	if rt.has("write") {
+		mode = 0644
+	}
+	return &filesystem.FileInfo{Name: e.name, Size: e.size, Mode: mode, ModTime: time.Now(), Meta: meta}, nil
+}
+
+func (fs *scriptFS) Create(p string) error {
+	_, err := fs.Write(p, nil)
+	return err
+}
+
+func (fs *scriptFS) Mkdir(p string, perm uint32) error {
+	return filesystem.NewNotSupportedError("mkdir", p)
+}
+
+func (fs *scriptFS) Remove(p string) error {
+	return filesystem.NewNotSupportedError("remove", p)
+}
+
+func (fs *scriptFS) RemoveAll(p string) error {
+	return fs.Remove(p)
+}
+
+func (fs *scriptFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	rt, err := fs.acquire(fs.ctx, "read", p)
+	defer fs.release()
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.read(rt, filesystem.NormalizePath(p))
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *scriptFS) Write(p string, data []byte) ([]byte, error) {
+	rt, err := fs.acquire(fs.ctx, "write", p)
+	defer fs.release()
+	if err != nil {
+		return nil, err
+	}
+	if !rt.has("write") {
+		return nil, filesystem.NewPermissionDeniedError("write", p, "the script defines no write hook")
+	}
+	ret, err := rt.hook("write", lua.LString(filesystem.NormalizePath(p)), lua.LString(data))
+	if err != nil || ret == lua.LNil {
+		return nil, err
+	}
+	return content(ret)
+}
+
+func (fs *scriptFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	rt, err := fs.acquire(fs.ctx, "readdir", p)
+	defer fs.release()
+	if err != nil {
+		return nil, err
+	}
+	p = filesystem.NormalizePath(p)
+	entries, err := fs.list(rt, p)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		e, err := fs.lookup(rt, p)
+		if err != nil {
+			return nil, err
+		}
+		if !e.dir {
+			return nil, filesystem.NewNotDirectoryError(p)
+		}
+		return []filesystem.FileInfo{}, nil
+	}
+	infos := make([]filesystem.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := fs.info(rt, path.Join(p, e.name), e)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+func (fs *scriptFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rt, err := fs.acquire(fs.ctx, "stat", p)
+	defer fs.release()
+	if err != nil {
+		return nil, err
+	}
+	p = filesystem.NormalizePath(p)
+	e, err := fs.lookup(rt, p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.info(rt, p, *e)
+}
+
+func (fs *scriptFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *scriptFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *scriptFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (fs *scriptFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(p, fs.Write), nil
+}
+
+// Ensure ScriptFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*ScriptFSPlugin)(nil)
+var _ filesystem.FileSystem = (*scriptFS)(nil)
+var _ filesystem.ContextBinder = (*scriptFS)(nil)
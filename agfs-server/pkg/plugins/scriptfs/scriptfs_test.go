@@ -0,0 +1,298 @@
+package scriptfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+const testScript = `
+counter = 0
+notes = {}
+
+function list(path)
+  if path == "/" then
+    local entries = {"counter", {name = "notes", dir = true}, {name = "config.json", size = 0}}
+    return entries
+  end
+  if path == "/notes" then
+    local names = {}
+    for name in pairs(notes) do table.insert(names, name) end
+    table.sort(names)
+    return names
+  end
+end
+
+function read(path)
+  if path == "/counter" then
+    counter = counter + 1
+    return counter
+  end
+  if path == "/config.json" then
+    local data, err = agfs.read("/mem/config.json")
+    if not data then error(err) end
+    local cfg = agfs.json_decode(data)
+    return {name = cfg.name, upper = string.upper(cfg.name)}
+  end
+  local name = string.match(path, "^/notes/(.+)$")
+  if name then return notes[name] end
+end
+
+function write(path, data)
+  local name = string.match(path, "^/notes/(.+)$")
+  if not name then error("notes are written to /notes") end
+  notes[name] = data
+  return "stored " .. #data .. " bytes\n"
+end
+`
+
+// readFile reads a whole file, which ends in io.EOF
+func readFile(fs filesystem.FileSystem, p string) (string, error) {
+	data, err := fs.Read(p, 0, -1)
+	if err == io.EOF {
+		err = nil
+	}
+	return string(data), err
+}
+
+func newTestFS(t *testing.T) *mountablefs.MountableFS {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewScriptFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/mem", nil); err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+func TestScriptHooks(t *testing.T) {
+	mfs := newTestFS(t)
+	if _, err := mfs.Write("/mem/config.json", []byte(`{"name": "agfs"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/script", map[string]interface{}{"script": testScript}); err != nil {
+		t.Fatal(err)
+	}
+
+	for want := "1"; want != "3"; {
+		data, err := readFile(mfs, "/script/counter")
+		if err != nil || data != want {
+			t.Fatalf("counter = %q, %v; want %q", data, err, want)
+		}
+		want = map[string]string{"1": "2", "2": "3"}[want]
+	}
+	data, err := readFile(mfs, "/script/config.json")
+	if err != nil || !strings.Contains(data, `"upper": "AGFS"`) {
+		t.Errorf("config.json = %q, %v", data, err)
+	}
+
+	resp, err := mfs.Write("/script/notes/todo", []byte("buy milk"))
+	if err != nil || string(resp) != "stored 8 bytes\n" {
+		t.Errorf("write = %q, %v", resp, err)
+	}
+	if _, err := mfs.Write("/script/counter", []byte("0")); err == nil || !strings.Contains(err.Error(), "notes are written to /notes") {
+		t.Errorf("write of counter: %v", err)
+	}
+	if data, err := mfs.Read("/script/notes/todo", 0, 3); err != nil || string(data) != "buy" {
+		t.Errorf("note = %q, %v", data, err)
+	}
+
+	entries, err := mfs.ReadDir("/script")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("readdir = %+v, %v", entries, err)
+	}
+	if !entries[1].IsDir || entries[2].Size != 0 || entries[0].Size != 1 {
+		t.Errorf("entries = %+v", entries)
+	}
+	if entries, err := mfs.ReadDir("/script/notes"); err != nil || len(entries) != 1 || entries[0].Size != 8 || entries[0].Mode != 0644 {
+		t.Errorf("notes = %+v, %v", entries, err)
+	}
+	if info, err := mfs.Stat("/script/notes/todo"); err != nil || info.IsDir || info.Size != 8 {
+		t.Errorf("stat = %+v, %v", info, err)
+	}
+	if _, err := mfs.Stat("/script/missing"); !filesystem.IsNotFound(err) {
+		t.Errorf("stat of missing file: %v", err)
+	}
+	if _, err := mfs.ReadDir("/script/counter"); err == nil {
+		t.Error("readdir of a file succeeded")
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	mfs := newTestFS(t)
+	p := NewScriptFSPlugin()
+	for _, cfg := range []map[string]interface{}{
+		{},
+		{"script": "function read(p) end", "script_path": "/mem/s.lua"},
+		{"script": "function read(p"},
+		{"script_path": "relative.lua"},
+		{"script_path": "/script/s.lua", "mount_path": "/script"},
+		{"script": "function read(p) end", "timeout": "0s"},
+		{"script": "function read(p) end", "http_allow": []interface{}{"ftp://example.com/"}},
+		{"script": "function read(p) end", "http_allow": "example.com"},
+	} {
+		if err := p.Validate(cfg); err == nil {
+			t.Errorf("config %v accepted", cfg)
+		}
+	}
+
+	script := `
+function read(path)
+  if path == "/loop" then while true do end end
+  if path == "/self" then return agfs.read("/script/loop") end
+  if path == "/io" then return tostring(io) .. tostring(os) .. tostring(dofile) end
+end`
+	cfg := map[string]interface{}{"script": script, "timeout": "100ms"}
+	if err := mfs.MountPlugin(PluginName, "/script", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Read("/script/loop", 0, -1); !errors.Is(err, filesystem.ErrTimeout) {
+		t.Errorf("read of looping hook: %v", err)
+	}
+	if data, err := readFile(mfs, "/script/io"); err != nil || data != "nilnilnil" {
+		t.Errorf("io = %q, %v", data, err)
+	}
+	// A script reading its own mount would wait for itself; the helper refuses it
+	if data, err := readFile(mfs, "/script/self"); !filesystem.IsNotFound(err) {
+		t.Errorf("self = %q, %v", data, err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/broken", map[string]interface{}{"script": "x = 1"}); err == nil {
+		t.Error("script without read hook mounted")
+	}
+}
+
+func TestScriptReload(t *testing.T) {
+	mfs := newTestFS(t)
+	if _, err := mfs.Write("/mem/hello.lua", []byte(`function read(p) return "v1" end`)); err != nil {
+		t.Fatal(err)
+	}
+	cfg := map[string]interface{}{"script_path": "/mem/hello.lua", "reload_interval": "10ms"}
+	if err := mfs.MountPlugin(PluginName, "/hello", cfg); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		time.Sleep(20 * time.Millisecond)
+		data, err := readFile(mfs, "/hello/x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if got := read(); got != "v1" {
+		t.Fatalf("read = %q", got)
+	}
+	if _, err := mfs.Write("/mem/hello.lua", []byte(`function read(p) return "version 2" end`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "version 2" {
+		t.Errorf("read after change = %q", got)
+	}
+	// A broken version leaves the previous one serving
+	if _, err := mfs.Write("/mem/hello.lua", []byte(`function read(p`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "version 2" {
+		t.Errorf("read after broken change = %q", got)
+	}
+}
+
+type userKey struct{}
+
+// denySecret denies the paths under /mem/secret to contexts with a user
+type denySecret struct{}
+
+func (denySecret) Check(ctx context.Context, op mountablefs.AccessOp, p string) error {
+	if _, ok := ctx.Value(userKey{}).(string); ok && strings.HasPrefix(p, "/mem/secret") {
+		return filesystem.NewPermissionDeniedError(string(op), p, "denied")
+	}
+	return nil
+}
+
+// The helpers access AGFS with the access control of whoever calls the hook
+func TestScriptCallerAccess(t *testing.T) {
+	mfs := newTestFS(t)
+	mfs.SetAccessControl(denySecret{})
+	if _, err := mfs.Write("/mem/secret", []byte("hunter2")); err != nil {
+		t.Fatal(err)
+	}
+	script := `
+function read(path)
+  if path == "/leak" then
+    local data, err = agfs.read("/mem/secret")
+    if not data then error(err) end
+    return data
+  end
+  if path == "/copy" then
+    local ok, err = agfs.write("/mem/secret", "overwritten")
+    if not ok then error(err) end
+    return "done"
+  end
+end`
+	if err := mfs.MountPlugin(PluginName, "/script", map[string]interface{}{"script": script}); err != nil {
+		t.Fatal(err)
+	}
+	user := mfs.WithContext(context.WithValue(context.Background(), userKey{}, "bob"))
+	for _, p := range []string{"/script/leak", "/script/copy"} {
+		if data, err := readFile(user, p); err == nil || !strings.Contains(err.Error(), "denied") {
+			t.Errorf("%s as bob = %q, %v", p, data, err)
+		}
+	}
+	if data, err := readFile(mfs, "/mem/secret"); err != nil || data != "hunter2" {
+		t.Errorf("secret = %q, %v", data, err)
+	}
+	// The server's own calls are not bound to a principal
+	if data, err := readFile(mfs, "/script/leak"); err != nil || data != "hunter2" {
+		t.Errorf("unbound leak = %q, %v", data, err)
+	}
+}
+
+// agfs.http_get only fetches the URLs under http_allow
+func TestScriptHTTPAllow(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/redirect" {
+			http.Redirect(w, r, "/private", http.StatusFound)
+			return
+		}
+		w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	defer server.Close()
+	script := `
+function read(path)
+  local body, status = agfs.http_get(URL .. path)
+  if not body then error(status) end
+  return body
+end`
+	mfs := newTestFS(t)
+	cfg := map[string]interface{}{"script": "URL = '" + server.URL + "'" + script, "http_allow": []interface{}{server.URL + "/api/"}}
+	if err := mfs.MountPlugin(PluginName, "/web", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/offline", map[string]interface{}{"script": "URL = '" + server.URL + "'" + script}); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := readFile(mfs, "/web/api/status"); err != nil || data != "hello from /api/status" {
+		t.Errorf("allowed get = %q, %v", data, err)
+	}
+	for _, tc := range []struct{ path, want string }{
+		{"/web/private", "not allowed by http_allow"},
+		{"/web/apiary", "not allowed by http_allow"},
+		{"/web/api/redirect", "not allowed by http_allow"},
+		{"/offline/api/status", "agfs.http_get is disabled"},
+	} {
+		if data, err := readFile(mfs, tc.path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s = %q, %v; want an error containing %q", tc.path, data, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package searchfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+)
+
+const (
+	PluginName = "searchfs"
+
+	// DefaultLimit is the maximum number of results returned per query file
+	DefaultLimit = 100
+)
+
+// Virtual files in searchfs
+const (
+	fileReadme = "/README"
+	fileStatus = "/status"
+)
+
+// SearchFSPlugin exposes the server search index as files: reading /<query> returns matching paths
+type SearchFSPlugin struct {
+	limit int
+}
+
+// NewSearchFSPlugin creates a new SearchFS plugin
+func NewSearchFSPlugin() *SearchFSPlugin {
+	return &SearchFSPlugin{limit: DefaultLimit}
+}
+
+func (p *SearchFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *SearchFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"limit", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	return config.ValidateIntType(cfg, "limit")
+}
+
+func (p *SearchFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.limit = config.GetIntConfig(cfg, "limit", DefaultLimit)
+	return nil
+}
+
+func (p *SearchFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &searchFS{plugin: p}
+}
+
+func (p *SearchFSPlugin) GetReadme() string {
+	return `SearchFS Plugin - Query the Search Index by File
+
+Every file name is a query against the server search index. Reading it
+returns the matching paths, best match first, one per line.
+The index is configured in the "search" section of the server config.
+
+FILES:
+  /README   - This file
+  /status   - Index status (JSON)
+  /<query>  - Matching paths for <query>
+
+QUERY SYNTAX:
+  word          Files containing "word" in their name or content
+  word1 word2   Files containing both words
+  repo*         Any word starting with "repo"
+  name:report   Only match file names
+
+Words are matched whole and lower-cased, without stemming ("report" does not
+match "reports", "report*" does). Results are ordered by the number of
+occurrences of the words, file name matches counting more.
+
+EXAMPLES:
+  agfs:/> cat "/searchfs/quarterly report"
+  /sqlfs/docs/q3-report.md
+  /s3fs/archive/report-2024.txt
+
+  agfs:/> cat /searchfs/name:*.pdf
+`
+}
+
+func (p *SearchFSPlugin) Shutdown() error {
+	return nil
+}
+
+// searchFS implements the FileSystem interface for query files
+type searchFS struct {
+	plugin *SearchFSPlugin
+}
+
+// query runs the query named by path and renders the result
+func (fs *searchFS) query(path string) ([]byte, error) {
+	indexer := search.Default()
+	if indexer == nil {
+		return nil, fmt.Errorf("search index is not enabled")
+	}
+	q := strings.TrimPrefix(path, "/")
+	var buf bytes.Buffer
+	for _, hit := range indexer.Index().Search(q, "/", fs.plugin.limit) {
+		buf.WriteString(hit.Path)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func (fs *searchFS) content(path string) ([]byte, error) {
+	switch path {
+	case "/":
+		return nil, fmt.Errorf("is a directory: %s", path)
+	case fileReadme:
+		return []byte(fs.plugin.GetReadme()), nil
+	case fileStatus:
+		indexer := search.Default()
+		if indexer == nil {
+			return []byte("{\"enabled\": false}\n"), nil
+		}
+		data, err := json.MarshalIndent(indexer.Status(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	default:
+		return fs.query(path)
+	}
+}
+
+func (fs *searchFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(filesystem.NormalizePath(path))
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *searchFS) fileInfo(name string, size int64, fileType string) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    size,
+		Mode:    0444,
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: fileType},
+	}
+}
+
+func (fs *searchFS) Stat(path string) (*filesystem.FileInfo, error) {
+	path = filesystem.NormalizePath(path)
+	if path == "/" {
+		return &filesystem.FileInfo{
+			Name:    "/",
+			Mode:    0555,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName},
+		}, nil
+	}
+
+	data, err := fs.content(path)
+	if err != nil {
+		return nil, err
+	}
+	fileType := "query"
+	switch path {
+	case fileReadme:
+		fileType = "doc"
+	case fileStatus:
+		fileType = "info"
+	}
+	info := fs.fileInfo(strings.TrimPrefix(path, "/"), int64(len(data)), fileType)
+	return &info, nil
+}
+
+func (fs *searchFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(path) != "/" {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	readme, _ := fs.content(fileReadme)
+	status, _ := fs.content(fileStatus)
+	return []filesystem.FileInfo{
+		fs.fileInfo("README", int64(len(readme)), "doc"),
+		fs.fileInfo("status", int64(len(status)), "info"),
+	}, nil
+}
+
+func (fs *searchFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *searchFS) readOnly(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, path, "searchfs is read-only")
+}
+
+func (fs *searchFS) Create(path string) error             { return fs.readOnly("create", path) }
+func (fs *searchFS) Mkdir(path string, perm uint32) error { return fs.readOnly("mkdir", path) }
+func (fs *searchFS) Remove(path string) error             { return fs.readOnly("remove", path) }
+func (fs *searchFS) RemoveAll(path string) error          { return fs.readOnly("removeall", path) }
+func (fs *searchFS) Rename(oldPath, newPath string) error { return fs.readOnly("rename", oldPath) }
+func (fs *searchFS) Chmod(path string, mode uint32) error { return fs.readOnly("chmod", path) }
+
+func (fs *searchFS) Write(path string, data []byte) ([]byte, error) {
+	return nil, fs.readOnly("write", path)
+}
+
+func (fs *searchFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, fs.readOnly("openwrite", path)
+}
+
+// Ensure SearchFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*SearchFSPlugin)(nil)
+var _ filesystem.FileSystem = (*searchFS)(nil)
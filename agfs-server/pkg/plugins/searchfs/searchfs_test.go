@@ -0,0 +1,81 @@
+package searchfs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/search"
+)
+
+func readFile(fs filesystem.FileSystem, p string) (string, error) {
+	data, err := fs.Read(p, 0, -1)
+	if err == io.EOF {
+		err = nil
+	}
+	return string(data), err
+}
+
+// Query files list the matching paths, best match first, up to the configured limit
+func TestQueryFiles(t *testing.T) {
+	p := NewSearchFSPlugin()
+	if err := p.Validate(map[string]interface{}{"limit": "many"}); err == nil {
+		t.Error("Validate of a string limit: no error")
+	}
+	if err := p.Initialize(map[string]interface{}{"limit": 2}); err != nil {
+		t.Fatal(err)
+	}
+	fs := p.GetFileSystem()
+	if status, err := readFile(fs, "/status"); err != nil || status != "{\"enabled\": false}\n" {
+		t.Errorf("status without an index = %q, %v", status, err)
+	}
+	if _, err := readFile(fs, "/report"); err == nil {
+		t.Error("query without an index: no error")
+	}
+
+	indexer, err := search.NewIndexer(memfs.NewMemoryFS(), config.SearchConfig{Paths: []string{"/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	search.SetDefault(indexer)
+	defer search.SetDefault(nil)
+	idx := indexer.Index()
+	now := time.Now()
+	idx.Add("/docs/q3-report.md", 20, now, "text/markdown", []byte("quarterly report report"))
+	idx.Add("/docs/notes.txt", 10, now, "text/plain", []byte("quarterly notes"))
+	idx.Add("/docs/reports.txt", 10, now, "text/plain", []byte("quarterly reporting"))
+	idx.Add("/archive/report-2024.txt", 10, now, "text/plain", []byte("yearly"))
+
+	for query, want := range map[string]string{
+		"/quarterly report": "/docs/q3-report.md\n",
+		"/name:notes":       "/docs/notes.txt\n",
+		"/nothing":          "",
+	} {
+		if got, err := readFile(fs, query); err != nil || got != want {
+			t.Errorf("%s = %q, %v, want %q", query, got, err, want)
+		}
+	}
+	// Three files match, the limit keeps two
+	if got, err := readFile(fs, "/report*"); err != nil || strings.Count(got, "\n") != 2 {
+		t.Errorf("/report* = %q, %v", got, err)
+	}
+	info, err := fs.Stat("/quarterly")
+	if err != nil || info.Meta.Type != "query" || info.Size == 0 {
+		t.Errorf("stat of a query = %+v, %v", info, err)
+	}
+	if status, err := readFile(fs, "/status"); err != nil || !strings.Contains(status, "{") || strings.Contains(status, "enabled\": false") {
+		t.Errorf("status = %q, %v", status, err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("root = %+v, %v", entries, err)
+	}
+	if _, err := fs.Write("/report", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("write: %v", err)
+	}
+}
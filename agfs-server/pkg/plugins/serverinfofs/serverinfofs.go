@@ -6,13 +6,103 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/diagnostics"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
 )
 
+// InfoFunc produces the content of a registered info file
+type InfoFunc func() ([]byte, error)
+
+// ControlFunc handles the data written to a registered control file
+type ControlFunc func(data []byte) error
+
+// infoFile is a file registered by a server component
+type infoFile struct {
+	read   InfoFunc
+	write  ControlFunc // nil for read-only files
+	format string
+}
+
+var (
+	infoFilesMu sync.RWMutex
+	infoFiles   = make(map[string]infoFile)
+)
+
+// RegisterInfoFile exposes the output of fn, a JSON document, as /<name> in every
+// serverinfofs mount
+// Server components use this to publish their runtime status (e.g. "backups"); names
+// with slashes, e.g. "pipelines/orders/status", are listed in directories
+func RegisterInfoFile(name string, fn InfoFunc) {
+	registerInfoFile(name, infoFile{read: fn, format: filesystem.FormatJSON})
+}
+
+// RegisterControlFile exposes a writable text file as /<name>: reads return the output of
+// fn and writes are handled by write, e.g. "stop" written to "pipelines/orders/control"
+func RegisterControlFile(name string, fn InfoFunc, write ControlFunc) {
+	registerInfoFile(name, infoFile{read: fn, write: write, format: filesystem.FormatText})
+}
+
+func registerInfoFile(name string, f infoFile) {
+	infoFilesMu.Lock()
+	defer infoFilesMu.Unlock()
+	infoFiles[strings.Trim(name, "/")] = f
+}
+
+// lookupInfoFile returns the registered info file for path, if any
+func lookupInfoFile(path string) (infoFile, bool) {
+	infoFilesMu.RLock()
+	defer infoFilesMu.RUnlock()
+	f, ok := infoFiles[strings.TrimPrefix(path, "/")]
+	return f, ok
+}
+
+// infoDirEntries returns the sorted names of the registered files and directories in the
+// directory at path, "/" for the root, and whether path is such a directory
+func infoDirEntries(path string) (files, dirs []string, ok bool) {
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	infoFilesMu.RLock()
+	defer infoFilesMu.RUnlock()
+	seen := make(map[string]bool)
+	for name := range infoFiles {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ok = true
+		child, rest, nested := strings.Cut(strings.TrimPrefix(name, prefix), "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		if nested && rest != "" {
+			dirs = append(dirs, child)
+		} else {
+			files = append(files, child)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(dirs)
+	return files, dirs, ok
+}
+
+// isInfoDir reports whether path is a directory of registered files
+func isInfoDir(path string) bool {
+	if path == "/" {
+		return false
+	}
+	_, _, ok := infoDirEntries(path)
+	return ok
+}
+
 // ServerInfoFSPlugin provides server metadata and information
 type ServerInfoFSPlugin struct {
 	startTime time.Time
@@ -74,10 +164,36 @@ USAGE:
     cat /info
 
 FILES:
-  /version  - Server version information
-  /uptime   - Server uptime since start
-  /info     - Complete server information (JSON)
-  /README   - This file
+  /version      - Server version information
+  /uptime       - Server uptime since start
+  /info         - Complete server information (JSON)
+  /metrics.prom - Server metrics in the Prometheus text format
+  /metrics.json - The same metrics in JSON
+  /README       - This file
+
+  Server components may publish additional files, e.g.:
+  /backups      - Status of scheduled backup jobs (JSON)
+  /pipelines/<name>/status  - Status of a configured pipeline (JSON)
+  /pipelines/<name>/control - Control file: write start, stop or run;
+                              reads return started or stopped
+
+FORMATS:
+  version and uptime are text, the other files JSON. Read with format=text
+  (or Accept: text/plain), JSON files are rendered as "key: value" lines;
+  with format=json, text files are wrapped as {"<name>": "<value>"}.
+  metrics.prom and metrics.json are one snapshot in two formats: reading
+  either with format=text gives the Prometheus text, format=json the JSON.
+
+METRICS:
+  The metrics are the numeric values of the expvar registry of the server,
+  the one served at /debug/vars and, in the Prometheus format, at
+  /debug/metrics: Go memory stats and the metrics of the mounts, labelled
+  with their mount path. Scrapers that can only fetch files, over FUSE or
+  proxyfs, read /metrics.prom instead:
+    agfs:/> cat /serverinfofs/metrics.prom
+    # TYPE agfs_memstats_heap_alloc untyped
+    agfs_memstats_heap_alloc 2.1504e+06
+    ...
 
 EXAMPLES:
   # Check server version
@@ -115,14 +231,18 @@ const (
 	fileVersion    = "/version"
 	fileStats      = "/stats"
 	fileReadme     = "/README"
+
+	fileMetricsProm = "/metrics.prom"
+	fileMetricsJSON = "/metrics.json"
 )
 
 func (fs *serverInfoFS) isValidPath(path string) bool {
 	switch path {
-	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileReadme:
+	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileReadme, fileMetricsProm, fileMetricsJSON:
 		return true
 	default:
-		return false
+		_, ok := lookupInfoFile(path)
+		return ok || isInfoDir(path)
 	}
 }
 
@@ -152,7 +272,7 @@ func (fs *serverInfoFS) Read(path string, offset int64, size int64) ([]byte, err
 		return nil, fmt.Errorf("no such file or directory: %s", path)
 	}
 
-	if path == "/" {
+	if path == "/" || isInfoDir(path) {
 		return nil, fmt.Errorf("is a directory: %s", path)
 	}
 
@@ -191,11 +311,27 @@ func (fs *serverInfoFS) Read(path string, offset int64, size int64) ([]byte, err
 			return nil, err
 		}
 
+	case fileMetricsProm:
+		data = diagnostics.TakeSnapshot().Prometheus()
+
+	case fileMetricsJSON:
+		data, err = json.MarshalIndent(diagnostics.TakeSnapshot(), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
 	case fileReadme:
 		data = []byte(fs.plugin.GetReadme())
 
 	default:
-		return nil, fmt.Errorf("no such file: %s", path)
+		f, ok := lookupInfoFile(path)
+		if !ok {
+			return nil, fmt.Errorf("no such file: %s", path)
+		}
+		data, err = f.read()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// if data is not ended by '\n' then add it
@@ -206,11 +342,19 @@ func (fs *serverInfoFS) Read(path string, offset int64, size int64) ([]byte, err
 	return plugin.ApplyRangeRead(data, offset, size)
 }
 
+// Write hands data to the component of a registered control file; other files are read-only
 func (fs *serverInfoFS) Write(path string, data []byte) ([]byte, error) {
+	if f, ok := lookupInfoFile(path); ok && f.write != nil {
+		return nil, f.write(data)
+	}
 	return nil, fmt.Errorf("operation not permitted: serverinfofs is read-only")
 }
 
 func (fs *serverInfoFS) Create(path string) error {
+	if f, ok := lookupInfoFile(path); ok && f.write != nil {
+		// Control files always exist
+		return nil
+	}
 	return fmt.Errorf("operation not permitted: serverinfofs is read-only")
 }
 
@@ -228,7 +372,10 @@ func (fs *serverInfoFS) RemoveAll(path string) error {
 
 func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	if path != "/" {
-		return nil, fmt.Errorf("not a directory: %s", path)
+		if !isInfoDir(path) {
+			return nil, fmt.Errorf("not a directory: %s", path)
+		}
+		return fs.infoEntries(path), nil
 	}
 
 	now := time.Now()
@@ -239,8 +386,10 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	uptimeData, _ := fs.Read(fileUptime, 0, -1)
 	versionData, _ := fs.Read(fileVersion, 0, -1)
 	statsData, _ := fs.Read(fileStats, 0, -1)
+	metricsProm, _ := fs.Read(fileMetricsProm, 0, -1)
+	metricsJSON, _ := fs.Read(fileMetricsJSON, 0, -1)
 
-	return []filesystem.FileInfo{
+	files := []filesystem.FileInfo{
 		{
 			Name:    "README",
 			Size:    int64(len(readme)),
@@ -255,7 +404,7 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			Mode:    0444,
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+			Meta:    infoMeta(fileServerInfo),
 		},
 		{
 			Name:    "uptime",
@@ -263,7 +412,7 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			Mode:    0444,
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+			Meta:    infoMeta(fileUptime),
 		},
 		{
 			Name:    "version",
@@ -271,7 +420,7 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			Mode:    0444,
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+			Meta:    infoMeta(fileVersion),
 		},
 		{
 			Name:    "stats",
@@ -279,9 +428,64 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			Mode:    0444,
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+			Meta:    infoMeta(fileStats),
 		},
-	}, nil
+		{
+			Name:    "metrics.prom",
+			Size:    int64(len(metricsProm)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    infoMeta(fileMetricsProm),
+		},
+		{
+			Name:    "metrics.json",
+			Size:    int64(len(metricsJSON)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    infoMeta(fileMetricsJSON),
+		},
+	}
+
+	return append(files, fs.infoEntries("/")...), nil
+}
+
+// infoEntries lists the registered files and directories in the directory at dir
+func (fs *serverInfoFS) infoEntries(dir string) []filesystem.FileInfo {
+	now := time.Now()
+	names, dirs, _ := infoDirEntries(dir)
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var entries []filesystem.FileInfo
+	for _, name := range dirs {
+		entries = append(entries, filesystem.FileInfo{
+			Name:    name,
+			Mode:    0555,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: "serverinfofs"},
+		})
+	}
+	for _, name := range names {
+		data, _ := fs.Read(prefix+name, 0, -1)
+		entries = append(entries, filesystem.FileInfo{
+			Name:    name,
+			Size:    int64(len(data)),
+			Mode:    infoMode(prefix + name),
+			ModTime: now,
+			IsDir:   false,
+			Meta:    infoMeta(prefix + name),
+		})
+	}
+	return entries
+}
+
+// infoMode returns the permissions of the file at path: control files are writable
+func infoMode(path string) uint32 {
+	if f, ok := lookupInfoFile(path); ok && f.write != nil {
+		return 0644
+	}
+	return 0444
 }
 
 func (fs *serverInfoFS) Stat(path string) (*filesystem.FileInfo, error) {
@@ -301,6 +505,15 @@ func (fs *serverInfoFS) Stat(path string) (*filesystem.FileInfo, error) {
 			Meta:    filesystem.MetaData{Name: "serverinfofs"},
 		}, nil
 	}
+	if isInfoDir(path) {
+		return &filesystem.FileInfo{
+			Name:    path[strings.LastIndex(path, "/")+1:],
+			Mode:    0555,
+			ModTime: now,
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: "serverinfofs"},
+		}, nil
+	}
 
 	// For files, read content to get size
 	data, err := fs.Read(path, 0, -1)
@@ -308,21 +521,74 @@ func (fs *serverInfoFS) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, err
 	}
 
-	fileType := "info"
-	if path == fileReadme {
-		fileType = "doc"
-	}
-
 	return &filesystem.FileInfo{
-		Name:    path[1:], // Remove leading slash
+		Name:    path[strings.LastIndex(path, "/")+1:],
 		Size:    int64(len(data)),
-		Mode:    0444,
+		Mode:    infoMode(path),
 		ModTime: now,
 		IsDir:   false,
-		Meta:    filesystem.MetaData{Name: "serverinfofs", Type: fileType},
+		Meta:    infoMeta(path),
 	}, nil
 }
 
+// infoFormat returns the format of the file at path, "" for the README
+func infoFormat(path string) string {
+	switch path {
+	case fileReadme:
+		return ""
+	case fileUptime, fileVersion, fileMetricsProm:
+		return filesystem.FormatText
+	}
+	if f, ok := lookupInfoFile(path); ok {
+		return f.format
+	}
+	return filesystem.FormatJSON
+}
+
+// infoMeta returns the metadata of the file at path, declaring its formats
+func infoMeta(path string) filesystem.MetaData {
+	format := infoFormat(path)
+	if format == "" {
+		return filesystem.MetaData{Name: "serverinfofs", Type: "doc"}
+	}
+	return filesystem.MetaData{Name: "serverinfofs", Type: "info",
+		Content: filesystem.FormatMeta(format, filesystem.FormatJSON, filesystem.FormatText)}
+}
+
+// ReadFormat implements filesystem.FormatReader: the JSON files are rendered as
+// "key: value" lines as text, and the text files as {"<name>": "<value>"} in JSON; the
+// metrics files are read as the one of them in the requested format
+func (fs *serverInfoFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	if path == fileMetricsProm || path == fileMetricsJSON {
+		switch format {
+		case filesystem.FormatText:
+			path = fileMetricsProm
+		case filesystem.FormatJSON:
+			path = fileMetricsJSON
+		}
+	}
+	def := infoFormat(path)
+	if def == "" || format == def || (format != filesystem.FormatJSON && format != filesystem.FormatText) {
+		data, err := fs.Read(path, offset, size)
+		return data, def, err
+	}
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	if format == filesystem.FormatText {
+		data, err = plugin.JSONToText(data)
+	} else {
+		data, err = json.Marshal(map[string]string{path[strings.LastIndex(path, "/")+1:]: strings.TrimSpace(string(data))})
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = plugin.ApplyRangeRead(data, offset, size)
+	return data, format, err
+}
+
 func (fs *serverInfoFS) Rename(oldPath, newPath string) error {
 	return fmt.Errorf("operation not permitted: serverinfofs is read-only")
 }
@@ -340,6 +606,10 @@ func (fs *serverInfoFS) Open(path string) (io.ReadCloser, error) {
 }
 
 func (fs *serverInfoFS) OpenWrite(path string) (io.WriteCloser, error) {
+	if f, ok := lookupInfoFile(path); ok && f.write != nil {
+		return filesystem.NewBufferedWriter(path, fs.Write), nil
+	}
 	return nil, fmt.Errorf("operation not permitted: serverinfofs is read-only")
 }
 
+var _ filesystem.FormatReader = (*serverInfoFS)(nil)
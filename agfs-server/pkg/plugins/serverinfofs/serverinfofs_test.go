@@ -0,0 +1,49 @@
+package serverinfofs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Components publish nested status files and writable control files
+func TestControlFiles(t *testing.T) {
+	state := "stopped"
+	RegisterInfoFile("pipelines/test/status", func() ([]byte, error) {
+		return []byte(`{"state": "` + state + `"}`), nil
+	})
+	RegisterControlFile("pipelines/test/control", func() ([]byte, error) {
+		return []byte(state + "\n"), nil
+	}, func(data []byte) error {
+		if cmd := strings.TrimSpace(string(data)); cmd != "start" {
+			return fmt.Errorf("unknown command %q", cmd)
+		}
+		state = "started"
+		return nil
+	})
+	fs := NewServerInfoFSPlugin().GetFileSystem()
+
+	entries, err := fs.ReadDir("/pipelines/test")
+	if err != nil || len(entries) != 2 || entries[0].Name != "control" || entries[0].Mode != 0644 || entries[1].Mode != 0444 {
+		t.Fatalf("pipeline directory = %+v, %v", entries, err)
+	}
+	if info, err := fs.Stat("/pipelines"); err != nil || !info.IsDir {
+		t.Errorf("pipelines = %+v, %v", info, err)
+	}
+	if _, err := fs.Write("/pipelines/test/control", []byte("pause")); err == nil {
+		t.Error("unknown command: no error")
+	}
+	if _, err := fs.Write("/pipelines/test/control", []byte("start\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := fs.Read("/pipelines/test/control", 0, -1); (err != nil && err != io.EOF) || string(data) != "started\n" {
+		t.Errorf("control = %q, %v", data, err)
+	}
+	if data, err := fs.Read("/pipelines/test/status", 0, -1); (err != nil && err != io.EOF) || !strings.Contains(string(data), "started") {
+		t.Errorf("status = %q, %v", data, err)
+	}
+	if _, err := fs.Write("/pipelines/test/status", []byte("{}")); err == nil {
+		t.Error("status file is writable")
+	}
+}
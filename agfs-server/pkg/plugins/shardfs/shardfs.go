@@ -0,0 +1,785 @@
+package shardfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	PluginName = "shardfs"
+
+	// ControlDir holds the control files at the root of the mount
+	ControlDir = ".shardfs"
+
+	// MetaValueControl marks the files of the control directory
+	MetaValueControl = "control"
+
+	// MetaKeyShard holds the backing path of a file in Stat metadata
+	MetaKeyShard = "shard"
+)
+
+// ShardFSPlugin spreads one logical directory across several backing paths
+type ShardFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *shardFS
+}
+
+// NewShardFSPlugin creates a new ShardFS plugin
+func NewShardFSPlugin() *ShardFSPlugin {
+	return &ShardFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem holding the shards
+func (p *ShardFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *ShardFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *ShardFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"shards", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	shards, err := shardsConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	mountPath := config.GetStringConfig(cfg, "mount_path", "")
+	seen := make(map[string]bool, len(shards))
+	for _, shard := range shards {
+		if seen[shard] {
+			return fmt.Errorf("duplicate shard: %s", shard)
+		}
+		seen[shard] = true
+		if mountPath != "" && (within(shard, mountPath) || within(mountPath, shard)) {
+			return fmt.Errorf("shard %s overlaps the mount path %s", shard, mountPath)
+		}
+	}
+	return nil
+}
+
+// shardsConfig returns the normalized shard paths of the configuration
+func shardsConfig(cfg map[string]interface{}) ([]string, error) {
+	var values []string
+	switch v := cfg["shards"].(type) {
+	case []string:
+		values = v
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("shards must be an array of paths")
+			}
+			values = append(values, s)
+		}
+	case nil:
+		return nil, fmt.Errorf("shards is required")
+	default:
+		return nil, fmt.Errorf("shards must be an array of paths")
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("shards must not be empty")
+	}
+
+	shards := make([]string, 0, len(values))
+	for _, s := range values {
+		if !strings.HasPrefix(s, "/") {
+			return nil, fmt.Errorf("shard must be an absolute path: %s", s)
+		}
+		shards = append(shards, filesystem.NormalizePath(s))
+	}
+	return shards, nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (p *ShardFSPlugin) Initialize(cfg map[string]interface{}) error {
+	shards, err := shardsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	p.fs = &shardFS{root: p.rootFS, shards: shards, state: &rebalanceState{done: make(chan struct{})}}
+	return nil
+}
+
+func (p *ShardFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *ShardFSPlugin) GetReadme() string {
+	return `ShardFS Plugin - Sharded Directory
+
+Presents several backing paths, e.g. prefixes of different s3fs or sqlfs
+mounts, as one directory. Each file is stored in one shard, chosen by
+hashing its path, so directories with millions of entries are split
+across the backends. Listings merge the entries of all shards.
+
+CONFIGURATION:
+  shards  - Backing paths, e.g. ["/s3a/data", "/s3b/data", "/sqlfs/data"]
+
+CONTROL FILES:
+  /.shardfs/README     - This file
+  /.shardfs/status     - Shards and the progress of the last rebalance (JSON)
+  /.shardfs/rebalance  - Write anything to move misplaced files to their shard
+
+Shards are chosen by rendezvous hashing: adding a shard only moves the
+files that now belong to it, about 1/N of them. Until the rebalance has
+run, files are found in their old shard and stay there when they are
+written. Renaming a directory leaves the files below it in their shards
+too; they are found and moved the same way.
+
+EXAMPLES:
+  agfs:/> echo hello > /shardfs/users/42.json
+  agfs:/> stat /shardfs/users/42.json        # "shard" metadata is the backing path
+  agfs:/> echo > /shardfs/.shardfs/rebalance
+  agfs:/> cat /shardfs/.shardfs/status
+`
+}
+
+func (p *ShardFSPlugin) Shutdown() error {
+	if p.fs != nil {
+		p.fs.state.stop()
+	}
+	return nil
+}
+
+// shardFS implements the FileSystem interface over the shards
+type shardFS struct {
+	root   filesystem.FileSystem
+	shards []string
+	state  *rebalanceState // Shared with the views returned by WithContext
+}
+
+// WithContext implements filesystem.ContextBinder by binding the root file system
+func (fs *shardFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.root = filesystem.WithContext(fs.root, ctx)
+	return &bound
+}
+
+// home returns the index of the shard a path belongs to
+func (fs *shardFS) home(rel string) int {
+	best := 0
+	var bestScore uint64
+	for i, shard := range fs.shards {
+		if score := xxh3.HashString(shard + "|" + rel); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// backing returns the path of rel in shard i
+func (fs *shardFS) backing(i int, rel string) string {
+	return path.Join(fs.shards[i], rel)
+}
+
+func (fs *shardFS) checkRoot() error {
+	if fs.root == nil {
+		return fmt.Errorf("shardfs is not mounted")
+	}
+	return nil
+}
+
+// locate returns the shard holding rel, trying its home shard first
+func (fs *shardFS) locate(rel string) (int, *filesystem.FileInfo, error) {
+	if err := fs.checkRoot(); err != nil {
+		return 0, nil, err
+	}
+	home := fs.home(rel)
+	info, err := fs.root.Stat(fs.backing(home, rel))
+	if err == nil {
+		return home, info, nil
+	}
+	if !filesystem.IsNotFound(err) {
+		return 0, nil, err
+	}
+	for i := range fs.shards {
+		if i == home {
+			continue
+		}
+		if info, err := fs.root.Stat(fs.backing(i, rel)); err == nil {
+			return i, info, nil
+		}
+	}
+	return 0, nil, filesystem.NewNotFoundError("stat", rel)
+}
+
+// target returns the shard a write to rel goes to: the one holding it, or its home
+func (fs *shardFS) target(rel string) (int, bool, error) {
+	i, info, err := fs.locate(rel)
+	if err == nil {
+		if info.IsDir {
+			return 0, false, filesystem.NewInvalidArgumentError("path", rel, "is a directory")
+		}
+		return i, true, nil
+	}
+	if !filesystem.IsNotFound(err) {
+		return 0, false, err
+	}
+	i = fs.home(rel)
+	return i, false, fs.ensureDir(i, path.Dir(rel))
+}
+
+// ensureDir creates dir in shard i if the logical directory exists
+func (fs *shardFS) ensureDir(i int, dir string) error {
+	if dir != "/" {
+		_, info, err := fs.locate(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+	}
+
+	// The backing directory may be missing, e.g. in a new shard
+	return mkdirAll(fs.root, fs.backing(i, dir))
+}
+
+// isControl reports whether rel is the control directory or one of its files
+func isControl(rel string) bool {
+	return rel == "/"+ControlDir || strings.HasPrefix(rel, "/"+ControlDir+"/")
+}
+
+func controlDenied(op, rel string) error {
+	return filesystem.NewPermissionDeniedError(op, rel, "control files cannot be changed")
+}
+
+func (fs *shardFS) Create(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if isControl(rel) {
+		return controlDenied("create", rel)
+	}
+	i, exists, err := fs.target(rel)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return filesystem.NewAlreadyExistsError("file", rel)
+	}
+	return fs.root.Create(fs.backing(i, rel))
+}
+
+func (fs *shardFS) Mkdir(p string, perm uint32) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" || isControl(rel) {
+		return filesystem.NewAlreadyExistsError("directory", rel)
+	}
+	if _, _, err := fs.locate(rel); err == nil {
+		return filesystem.NewAlreadyExistsError("directory", rel)
+	} else if !filesystem.IsNotFound(err) {
+		return err
+	}
+	i := fs.home(rel)
+	if err := fs.ensureDir(i, path.Dir(rel)); err != nil {
+		return err
+	}
+	return fs.root.Mkdir(fs.backing(i, rel), perm)
+}
+
+func (fs *shardFS) Remove(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" || isControl(rel) {
+		return controlDenied("remove", rel)
+	}
+	i, info, err := fs.locate(rel)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return fs.root.Remove(fs.backing(i, rel))
+	}
+
+	entries, err := fs.ReadDir(rel)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("directory not empty: %s", rel)
+	}
+	return fs.eachShard(rel, func(backing string) error { return fs.root.Remove(backing) })
+}
+
+func (fs *shardFS) RemoveAll(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" || isControl(rel) {
+		return controlDenied("remove", rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return err
+	}
+	return fs.eachShard(rel, func(backing string) error { return fs.root.RemoveAll(backing) })
+}
+
+// eachShard calls fn with the backing path of rel in every shard holding it
+func (fs *shardFS) eachShard(rel string, fn func(backing string) error) error {
+	for i := range fs.shards {
+		backing := fs.backing(i, rel)
+		if _, err := fs.root.Stat(backing); err != nil {
+			continue
+		}
+		if err := fn(backing); err != nil && !filesystem.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *shardFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	rel := filesystem.NormalizePath(p)
+	if isControl(rel) {
+		data, err := fs.controlContent(rel)
+		if err != nil {
+			return nil, err
+		}
+		return plugin.ApplyRangeRead(data, offset, size)
+	}
+	i, _, err := fs.locate(rel)
+	if err != nil {
+		return nil, err
+	}
+	return fs.root.Read(fs.backing(i, rel), offset, size)
+}
+
+func (fs *shardFS) Write(p string, data []byte) ([]byte, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/"+ControlDir+"/rebalance" {
+		if err := fs.checkRoot(); err != nil {
+			return nil, err
+		}
+		if err := fs.state.start(fs.root, fs.shards, fs.home); err != nil {
+			return nil, err
+		}
+		return []byte("rebalance started\n"), nil
+	}
+	if isControl(rel) {
+		return nil, controlDenied("write", rel)
+	}
+	i, _, err := fs.target(rel)
+	if err != nil {
+		return nil, err
+	}
+	return fs.root.Write(fs.backing(i, rel), data)
+}
+
+func (fs *shardFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/"+ControlDir {
+		var infos []filesystem.FileInfo
+		for _, name := range controlFiles {
+			info, err := fs.Stat(path.Join(rel, name))
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+	if isControl(rel) {
+		return nil, filesystem.NewNotDirectoryError(rel)
+	}
+	if err := fs.checkRoot(); err != nil {
+		return nil, err
+	}
+
+	found := rel == "/"
+	byName := make(map[string]int)
+	var infos []filesystem.FileInfo
+	for i := range fs.shards {
+		entries, err := fs.root.ReadDir(fs.backing(i, rel))
+		if err != nil {
+			if filesystem.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		found = true
+		for _, entry := range entries {
+			if rel == "/" && entry.Name == ControlDir {
+				continue
+			}
+			if j, ok := byName[entry.Name]; ok {
+				// A directory exists in several shards; a file copy in its home shard wins
+				if !entry.IsDir && fs.home(path.Join(rel, entry.Name)) == i {
+					infos[j] = entry
+				}
+				continue
+			}
+			byName[entry.Name] = len(infos)
+			infos = append(infos, entry)
+		}
+	}
+	if !found {
+		if _, info, err := fs.locate(rel); err == nil && !info.IsDir {
+			return nil, filesystem.NewNotDirectoryError(rel)
+		}
+		return nil, filesystem.NewNotFoundError("readdir", rel)
+	}
+	if rel == "/" {
+		info := fs.controlDirInfo()
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(a, b int) bool { return infos[a].Name < infos[b].Name })
+	return infos, nil
+}
+
+func (fs *shardFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return &filesystem.FileInfo{
+			Name:    "/",
+			Mode:    0755,
+			ModTime: time.Now(),
+			IsDir:   true,
+			Meta:    filesystem.MetaData{Name: PluginName},
+		}, nil
+	}
+	if rel == "/"+ControlDir {
+		return fs.controlDirInfo(), nil
+	}
+	if isControl(rel) {
+		data, err := fs.controlContent(rel)
+		if err != nil {
+			return nil, err
+		}
+		mode := uint32(0444)
+		if path.Base(rel) == "rebalance" {
+			mode = 0222
+		}
+		return &filesystem.FileInfo{
+			Name:    path.Base(rel),
+			Size:    int64(len(data)),
+			Mode:    mode,
+			ModTime: time.Now(),
+			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueControl},
+		}, nil
+	}
+
+	i, info, err := fs.locate(rel)
+	if err != nil {
+		return nil, err
+	}
+	result := *info
+	result.Name = path.Base(rel)
+	if !result.IsDir {
+		content := make(map[string]string, len(info.Meta.Content)+1)
+		for k, v := range info.Meta.Content {
+			content[k] = v
+		}
+		content[MetaKeyShard] = fs.shards[i]
+		result.Meta.Content = content
+	}
+	return &result, nil
+}
+
+func (fs *shardFS) Rename(oldPath, newPath string) error {
+	oldRel := filesystem.NormalizePath(oldPath)
+	newRel := filesystem.NormalizePath(newPath)
+	if oldRel == "/" || isControl(oldRel) || isControl(newRel) {
+		return controlDenied("rename", oldRel)
+	}
+	i, info, err := fs.locate(oldRel)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir {
+		// Every shard renames its part; the files keep their shards until the next rebalance
+		for j := range fs.shards {
+			if _, err := fs.root.Stat(fs.backing(j, oldRel)); err != nil {
+				continue
+			}
+			if err := fs.ensureDir(j, path.Dir(newRel)); err != nil {
+				return err
+			}
+			if err := fs.root.Rename(fs.backing(j, oldRel), fs.backing(j, newRel)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	j, _, err := fs.target(newRel)
+	if err != nil {
+		return err
+	}
+	return moveFile(fs.root, fs.backing(i, oldRel), fs.backing(j, newRel), info.Mode)
+}
+
+// moveFile renames src to dst, copying the data when they are on different mounts
+func moveFile(root filesystem.FileSystem, src, dst string, mode uint32) error {
+	if err := root.Rename(src, dst); err == nil {
+		return nil
+	}
+	data, err := root.Read(src, 0, -1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if _, err := root.Write(dst, data); err != nil {
+		return err
+	}
+	root.Chmod(dst, mode)
+	return root.Remove(src)
+}
+
+func (fs *shardFS) Chmod(p string, mode uint32) error {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" || isControl(rel) {
+		return controlDenied("chmod", rel)
+	}
+	if _, _, err := fs.locate(rel); err != nil {
+		return err
+	}
+	return fs.eachShard(rel, func(backing string) error { return fs.root.Chmod(backing, mode) })
+}
+
+func (fs *shardFS) Touch(p string) error {
+	rel := filesystem.NormalizePath(p)
+	if isControl(rel) {
+		return controlDenied("touch", rel)
+	}
+	i, _, err := fs.target(rel)
+	if err != nil {
+		return err
+	}
+	if toucher, ok := fs.root.(filesystem.Toucher); ok {
+		return toucher.Touch(fs.backing(i, rel))
+	}
+	return fmt.Errorf("touch is not supported by the root file system")
+}
+
+func (fs *shardFS) Open(p string) (io.ReadCloser, error) {
+	rel := filesystem.NormalizePath(p)
+	if isControl(rel) {
+		data, err := fs.controlContent(rel)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(string(data))), nil
+	}
+	i, _, err := fs.locate(rel)
+	if err != nil {
+		return nil, err
+	}
+	return fs.root.Open(fs.backing(i, rel))
+}
+
+func (fs *shardFS) OpenWrite(p string) (io.WriteCloser, error) {
+	rel := filesystem.NormalizePath(p)
+	if isControl(rel) {
+		return nil, controlDenied("write", rel)
+	}
+	i, _, err := fs.target(rel)
+	if err != nil {
+		return nil, err
+	}
+	return fs.root.OpenWrite(fs.backing(i, rel))
+}
+
+// controlFiles are the files of the control directory
+var controlFiles = []string{"README", "rebalance", "status"}
+
+func (fs *shardFS) controlDirInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    ControlDir,
+		Mode:    0555,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueControl},
+	}
+}
+
+// controlContent returns the content of a control file
+func (fs *shardFS) controlContent(rel string) ([]byte, error) {
+	switch rel {
+	case "/" + ControlDir + "/README":
+		return []byte((&ShardFSPlugin{}).GetReadme()), nil
+	case "/" + ControlDir + "/rebalance":
+		return []byte{}, nil
+	case "/" + ControlDir + "/status":
+		data, err := json.MarshalIndent(status{Shards: fs.shards, Rebalance: fs.state.snapshot()}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, filesystem.NewNotFoundError("read", rel)
+}
+
+// status is the content of the status control file
+type status struct {
+	Shards    []string        `json:"shards"`
+	Rebalance RebalanceStatus `json:"rebalance"`
+}
+
+// RebalanceStatus reports the progress of the last rebalance
+type RebalanceStatus struct {
+	Running   bool      `json:"running"`
+	Started   time.Time `json:"started,omitempty"`
+	Finished  time.Time `json:"finished,omitempty"`
+	Scanned   int64     `json:"scanned"`   // Files visited
+	Moved     int64     `json:"moved"`     // Files moved to their home shard
+	Conflicts int64     `json:"conflicts"` // Misplaced files whose home shard has a file with the same path
+	Errors    int64     `json:"errors"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// rebalanceState runs one rebalance at a time
+type rebalanceState struct {
+	mu     sync.Mutex
+	status RebalanceStatus
+	done   chan struct{} // Closed on shutdown to abort a running rebalance
+	closed bool
+}
+
+func (s *rebalanceState) snapshot() RebalanceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *rebalanceState) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		close(s.done)
+		s.closed = true
+	}
+}
+
+func (s *rebalanceState) update(fn func(*RebalanceStatus)) {
+	s.mu.Lock()
+	fn(&s.status)
+	s.mu.Unlock()
+}
+
+// start moves every file that is not in its home shard, in the background
+func (s *rebalanceState) start(root filesystem.FileSystem, shards []string, home func(string) int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status.Running {
+		return filesystem.NewAlreadyExistsError("rebalance", "/"+ControlDir+"/rebalance")
+	}
+	if s.closed {
+		return fmt.Errorf("shardfs is shutting down")
+	}
+	s.status = RebalanceStatus{Running: true, Started: time.Now()}
+	go s.run(root, shards, home)
+	return nil
+}
+
+var errAborted = errors.New("rebalance aborted")
+
+func (s *rebalanceState) run(root filesystem.FileSystem, shards []string, home func(string) int) {
+	defer s.update(func(st *RebalanceStatus) {
+		st.Running = false
+		st.Finished = time.Now()
+		log.Infof("[shardfs] rebalance finished: %d scanned, %d moved, %d conflicts, %d errors",
+			st.Scanned, st.Moved, st.Conflicts, st.Errors)
+	})
+
+	// Find the misplaced files first, so that moved files are not visited again
+	type move struct {
+		src, dst string
+		mode     uint32
+	}
+	var moves []move
+	for i, shard := range shards {
+		err := filesystem.Walk(root, shard, func(p string, info *filesystem.FileInfo, err error) error {
+			if s.aborted() {
+				return errAborted
+			}
+			if err != nil {
+				if !filesystem.IsNotFound(err) {
+					s.update(func(st *RebalanceStatus) { st.Errors++; st.LastError = err.Error() })
+				}
+				return nil
+			}
+			if info.IsDir {
+				return nil
+			}
+			s.update(func(st *RebalanceStatus) { st.Scanned++ })
+			rel := "/" + strings.TrimPrefix(strings.TrimPrefix(p, shard), "/")
+			if j := home(rel); j != i {
+				moves = append(moves, move{src: p, dst: path.Join(shards[j], rel), mode: info.Mode})
+			}
+			return nil
+		})
+		if err == errAborted {
+			return
+		}
+	}
+
+	for _, m := range moves {
+		if s.aborted() {
+			return
+		}
+		if _, err := root.Stat(m.dst); err == nil {
+			s.update(func(st *RebalanceStatus) { st.Conflicts++ })
+			continue
+		}
+		err := mkdirAll(root, path.Dir(m.dst))
+		if err == nil {
+			err = moveFile(root, m.src, m.dst, m.mode)
+		}
+		if err != nil {
+			s.update(func(st *RebalanceStatus) { st.Errors++; st.LastError = err.Error() })
+			continue
+		}
+		s.update(func(st *RebalanceStatus) { st.Moved++ })
+	}
+}
+
+func (s *rebalanceState) aborted() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// mkdirAll creates dir and its missing parents
+func mkdirAll(root filesystem.FileSystem, dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	if info, err := root.Stat(dir); err == nil {
+		if !info.IsDir {
+			return filesystem.NewNotDirectoryError(dir)
+		}
+		return nil
+	}
+	if err := mkdirAll(root, path.Dir(dir)); err != nil {
+		return err
+	}
+	if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, filesystem.ErrAlreadyExists) {
+		return err
+	}
+	return nil
+}
+
+// Ensure ShardFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*ShardFSPlugin)(nil)
@@ -0,0 +1,111 @@
+package shardfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func mountShards(t *testing.T, mfs *mountablefs.MountableFS, shards ...interface{}) {
+	t.Helper()
+	if err := mfs.MountPlugin(PluginName, "/sharded", map[string]interface{}{"shards": shards}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(mfs *mountablefs.MountableFS, p string) string {
+	data, err := mfs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		return err.Error()
+	}
+	return string(data)
+}
+
+func TestShardedDirectory(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewShardFSPlugin() })
+	for _, p := range []string{"/a", "/b", "/c"} {
+		if err := mfs.MountPlugin("memfs", p, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mountShards(t, mfs, "/a/data", "/b/data")
+
+	if err := mfs.Mkdir("/sharded/users", 0755); err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	for i := 0; i < n; i++ {
+		if _, err := mfs.Write(fmt.Sprintf("/sharded/users/%d", i), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counts := map[string]int{}
+	for _, shard := range []string{"/a/data", "/b/data"} {
+		infos, _ := mfs.ReadDir(shard + "/users")
+		counts[shard] = len(infos)
+	}
+	if counts["/a/data"] == 0 || counts["/b/data"] == 0 || counts["/a/data"]+counts["/b/data"] != n {
+		t.Errorf("files per shard = %v", counts)
+	}
+	if infos, err := mfs.ReadDir("/sharded/users"); err != nil || len(infos) != n {
+		t.Fatalf("ReadDir = %d entries, %v", len(infos), err)
+	}
+
+	// A new shard: files stay readable in their old shards until the rebalance moves them
+	if err := mfs.Unmount("/sharded"); err != nil {
+		t.Fatal(err)
+	}
+	mountShards(t, mfs, "/a/data", "/b/data", "/c/data")
+	if got := read(mfs, "/sharded/users/7"); got != "7" {
+		t.Fatalf("read before rebalance = %q", got)
+	}
+	if _, err := mfs.Write("/sharded/.shardfs/rebalance", []byte("go")); err != nil {
+		t.Fatal(err)
+	}
+	var st status
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if err := json.Unmarshal([]byte(read(mfs, "/sharded/.shardfs/status")), &st); err != nil {
+			t.Fatal(err)
+		}
+		if !st.Rebalance.Running || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Rebalance.Running || st.Rebalance.Scanned != n || st.Rebalance.Moved == 0 || st.Rebalance.Errors != 0 {
+		t.Errorf("rebalance = %+v", st.Rebalance)
+	}
+	if infos, err := mfs.ReadDir("/c/data/users"); err != nil || len(infos) != int(st.Rebalance.Moved) {
+		t.Errorf("new shard holds %d files, %v", len(infos), err)
+	}
+	for i := 0; i < n; i++ {
+		if got := read(mfs, fmt.Sprintf("/sharded/users/%d", i)); got != fmt.Sprint(i) {
+			t.Errorf("read %d after rebalance = %q", i, got)
+		}
+	}
+
+	// Files move between shards when renamed
+	if err := mfs.Rename("/sharded/users/1", "/sharded/users/one"); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(mfs, "/sharded/users/one"); got != "1" {
+		t.Errorf("renamed file = %q", got)
+	}
+	if err := mfs.Remove("/sharded/users"); err == nil {
+		t.Errorf("removing a non-empty directory succeeded")
+	}
+	if err := mfs.RemoveAll("/sharded/users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/sharded/users"); err == nil {
+		t.Errorf("/sharded/users exists after RemoveAll")
+	}
+}
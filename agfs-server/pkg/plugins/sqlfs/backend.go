@@ -1,12 +1,14 @@
 package sqlfs
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
 	"fmt"
 	"regexp"
 	"strings"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/credentials"
 	"github.com/go-sql-driver/mysql"
 	_ "github.com/go-sql-driver/mysql" // MySQL/TiDB driver
 	log "github.com/sirupsen/logrus"
@@ -28,6 +30,32 @@ type DBBackend interface {
 
 	// GetOptimizationSQL returns SQL statements for optimization (e.g., PRAGMA for SQLite)
 	GetOptimizationSQL() []string
+
+	// GetVacuumSQL returns SQL statements that reclaim space freed by deletions
+	GetVacuumSQL() []string
+
+	// GetAnalyzeSQL returns SQL statements that refresh optimizer statistics
+	GetAnalyzeSQL() []string
+
+	// GetBatchDeleteSQL returns a statement deleting at most one batch of the files matching where;
+	// the batch size is bound to its last placeholder
+	GetBatchDeleteSQL(where string) string
+
+	// GetInsertIgnoreSQL returns the INSERT variant skipping rows whose key exists
+	GetInsertIgnoreSQL() string
+
+	// GetDropIndexSQL returns SQL statements dropping the secondary indexes for a bulk load
+	GetDropIndexSQL() []string
+
+	// GetCreateIndexSQL returns SQL statements rebuilding the indexes dropped by GetDropIndexSQL
+	GetCreateIndexSQL() []string
+
+	// GetAppendSQL returns an UPDATE adding its first argument to the data of a file,
+	// followed by the number of bytes added, the modification time and the path
+	GetAppendSQL() string
+
+	// MaxBulkWriters returns how many bulk batches may be written concurrently, 0 for no limit
+	MaxBulkWriters() int
 }
 
 // SQLiteBackend implements DBBackend for SQLite
@@ -81,6 +109,44 @@ func (b *SQLiteBackend) SupportsTxIsolation() bool {
 	return false
 }
 
+func (b *SQLiteBackend) GetVacuumSQL() []string {
+	return []string{
+		"PRAGMA wal_checkpoint(TRUNCATE)",
+		"VACUUM",
+	}
+}
+
+func (b *SQLiteBackend) GetAnalyzeSQL() []string {
+	return []string{"ANALYZE"}
+}
+
+func (b *SQLiteBackend) GetBatchDeleteSQL(where string) string {
+	// SQLite only accepts DELETE ... LIMIT when built with SQLITE_ENABLE_UPDATE_DELETE_LIMIT
+	return "DELETE FROM files WHERE rowid IN (SELECT rowid FROM files WHERE " + where + " LIMIT ?)"
+}
+
+func (b *SQLiteBackend) GetInsertIgnoreSQL() string {
+	return "INSERT OR IGNORE"
+}
+
+func (b *SQLiteBackend) GetDropIndexSQL() []string {
+	return []string{"DROP INDEX IF EXISTS idx_parent"}
+}
+
+func (b *SQLiteBackend) GetCreateIndexSQL() []string {
+	return []string{"CREATE INDEX IF NOT EXISTS idx_parent ON files(path)"}
+}
+
+func (b *SQLiteBackend) GetAppendSQL() string {
+	// || works on text, the result is cast back so the column keeps holding a blob
+	return "UPDATE files SET data = CAST(COALESCE(data, X'') || ? AS BLOB), size = size + ?, mod_time = ? WHERE path = ?"
+}
+
+func (b *SQLiteBackend) MaxBulkWriters() int {
+	// SQLite has a single writer, concurrent transactions would fail with "database is locked"
+	return 1
+}
+
 // TiDBBackend implements DBBackend for TiDB
 type TiDBBackend struct{}
 
@@ -169,6 +235,19 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 		}
 	}
 
+	// A credential provider replaces the user and password of the DSN at every new connection,
+	// so rotated passwords are picked up
+	creds, err := credentials.PluginProvider(config, credentials.Credentials{})
+	if err != nil {
+		return nil, err
+	}
+	open := func(dsn string) (*sql.DB, error) {
+		if creds == nil {
+			return sql.Open("mysql", dsn)
+		}
+		return openWithCredentials(dsn, creds)
+	}
+
 	log.Infof("[sqlfs] Connecting to TiDB (TLS: %v)", enableTLS)
 
 	// Extract database name to create it if needed
@@ -178,7 +257,7 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 	if dbName != "" {
 		dsnWithoutDB := removeDatabaseFromDSN(dsn)
 		if dsnWithoutDB != dsn {
-			tempDB, err := sql.Open("mysql", dsnWithoutDB)
+			tempDB, err := open(dsnWithoutDB)
 			defer tempDB.Close()
 			if err == nil {
 				// Try to create database if it doesn't exist
@@ -191,7 +270,7 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 		}
 	}
 
-	db, err := sql.Open("mysql", dsn)
+	db, err := open(dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open TiDB database: %w", err)
 	}
@@ -204,6 +283,34 @@ func (b *TiDBBackend) Open(config map[string]interface{}) (*sql.DB, error) {
 	return db, nil
 }
 
+// openWithCredentials opens dsn with the user and password retrieved from creds when a
+// connection is made
+func openWithCredentials(dsn string, creds credentials.Provider) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Apply(mysql.BeforeConnect(func(ctx context.Context, cfg *mysql.Config) error {
+		c, err := creds.Retrieve(ctx)
+		if err != nil {
+			return err
+		}
+		if c.Username != "" {
+			cfg.User = c.Username
+		}
+		cfg.Passwd = c.Password
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(connector), nil
+}
+
 // extractDatabaseName extracts database name from DSN or config
 func extractDatabaseName(dsn string, configDB string) string {
 	if dsn != "" {
@@ -246,6 +353,39 @@ func (b *TiDBBackend) SupportsTxIsolation() bool {
 	return true
 }
 
+func (b *TiDBBackend) GetVacuumSQL() []string {
+	// TiKV reclaims space with its own garbage collection
+	return []string{}
+}
+
+func (b *TiDBBackend) GetAnalyzeSQL() []string {
+	return []string{"ANALYZE TABLE files"}
+}
+
+func (b *TiDBBackend) GetBatchDeleteSQL(where string) string {
+	return "DELETE FROM files WHERE " + where + " LIMIT ?"
+}
+
+func (b *TiDBBackend) GetInsertIgnoreSQL() string {
+	return "INSERT IGNORE"
+}
+
+func (b *TiDBBackend) GetDropIndexSQL() []string {
+	return []string{"ALTER TABLE files DROP INDEX idx_parent"}
+}
+
+func (b *TiDBBackend) GetCreateIndexSQL() []string {
+	return []string{"ALTER TABLE files ADD INDEX idx_parent (path(200))"}
+}
+
+func (b *TiDBBackend) GetAppendSQL() string {
+	return "UPDATE files SET data = CONCAT(COALESCE(data, ''), ?), size = size + ?, mod_time = ? WHERE path = ?"
+}
+
+func (b *TiDBBackend) MaxBulkWriters() int {
+	return 0
+}
+
 // getStringConfig retrieves a string value from config map with default
 func getStringConfig(config map[string]interface{}, key, defaultValue string) string {
 	if val, ok := config[key].(string); ok && val != "" {
@@ -0,0 +1,348 @@
+package sqlfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+const (
+	// bulkRowsPerStatement is the number of rows inserted by one statement of a bulk load;
+	// with 6 placeholders per row it stays well below the variable limits of SQLite and MySQL
+	bulkRowsPerStatement = 100
+
+	// bulkLookupSize is the number of paths looked up by one query of a bulk load
+	bulkLookupSize = 500
+)
+
+// bulkState tracks the bulk loads of a file system
+type bulkState struct {
+	writers chan struct{} // limits concurrent batches, nil without limit
+
+	mu       sync.Mutex // protects deferred and serializes index changes
+	deferred int        // running loads that deferred the indexes
+}
+
+func newBulkState(backend DBBackend) *bulkState {
+	b := &bulkState{}
+	if n := backend.MaxBulkWriters(); n > 0 {
+		b.writers = make(chan struct{}, n)
+	}
+	return b
+}
+
+// BeginBulk implements filesystem.BulkWriter
+// Batches are written in one transaction each with multi-row prepared statements. With
+// DeferIndexes the secondary indexes are dropped until the last deferring load ends
+func (fs *SQLFS) BeginBulk(dir string, opts filesystem.BulkOptions) (filesystem.BulkSession, error) {
+	if err := filesystem.MkdirAll(fs, dir, 0755); err != nil {
+		return nil, err
+	}
+	if opts.DeferIndexes {
+		b := fs.bulk
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.deferred == 0 {
+			if err := fs.changeIndexes(fs.backend.GetDropIndexSQL()); err != nil {
+				return nil, fmt.Errorf("failed to drop indexes: %w", err)
+			}
+		}
+		b.deferred++
+	}
+	return &bulkSession{fs: fs, deferIndexes: opts.DeferIndexes}, nil
+}
+
+// changeIndexes runs index statements without the query timeout, a rebuild may take long
+func (fs *SQLFS) changeIndexes(statements []string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.execAll(statements)
+}
+
+// bulkSession implements filesystem.BulkSession
+type bulkSession struct {
+	fs           *SQLFS
+	deferIndexes bool
+	closeOnce    sync.Once
+}
+
+// bulkRow is a row of the files table written by a bulk load
+type bulkRow struct {
+	path    string
+	isDir   bool
+	mode    uint32
+	modTime int64
+	data    []byte
+}
+
+func (r bulkRow) args() []interface{} {
+	isDir := 0
+	if r.isDir {
+		isDir = 1
+	}
+	return []interface{}{r.path, isDir, r.mode, len(r.data), r.modTime, r.data}
+}
+
+// WriteBatch implements filesystem.BulkSession
+func (s *bulkSession) WriteBatch(entries []filesystem.BulkEntry) error {
+	fs := s.fs
+	rows, parents, err := bulkRows(entries)
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+
+	if fs.bulk.writers != nil {
+		fs.bulk.writers <- struct{}{}
+		defer func() { <-fs.bulk.writers }()
+	}
+	// Batches exclude the per-file operations, which check before they write, but not each other
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+	tx, err := fs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := checkBulkRows(ctx, tx, rows, parents)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	dirs := make([]bulkRow, len(parents))
+	for i, p := range parents {
+		dirs[i] = bulkRow{path: p, isDir: true, mode: 0755, modTime: now}
+	}
+	if err := insertBulkRows(ctx, tx, fs.backend.GetInsertIgnoreSQL(), dirs); err != nil {
+		return err
+	}
+	if err := insertBulkRows(ctx, tx, "REPLACE", rows); err != nil {
+		return err
+	}
+	// Directories of the batch keep the modification time of their entry
+	touched := touchedDirs(rows, parents, existing)
+	for _, dir := range touched {
+		if err := touchDir(ctx, tx, dir, now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		fs.listCache.InvalidateParent(row.path)
+	}
+	for _, p := range parents {
+		fs.listCache.InvalidateParent(p)
+	}
+	for _, dir := range touched {
+		fs.listCache.InvalidateParent(dir)
+	}
+	return nil
+}
+
+// Close implements filesystem.BulkSession
+func (s *bulkSession) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if !s.deferIndexes {
+			return
+		}
+		b := s.fs.bulk
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.deferred--
+		if b.deferred == 0 {
+			if e := s.fs.changeIndexes(s.fs.backend.GetCreateIndexSQL()); e != nil {
+				err = fmt.Errorf("failed to rebuild indexes: %w", e)
+			}
+		}
+	})
+	return err
+}
+
+// bulkRows converts entries to rows, the last entry of a path wins
+// It also returns the sorted ancestors of the entries that are not entries themselves
+func bulkRows(entries []filesystem.BulkEntry) ([]bulkRow, []string, error) {
+	now := time.Now().Unix()
+	index := make(map[string]int, len(entries))
+	rows := make([]bulkRow, 0, len(entries))
+	for _, e := range entries {
+		p := filesystem.NormalizePath(e.Path)
+		if p == "/" && e.IsDir {
+			continue
+		}
+		if p == "/" || p == MaintenanceFile {
+			return nil, nil, filesystem.NewInvalidArgumentError("path", p, "reserved path")
+		}
+		if len(e.Data) > MaxFileSize {
+			return nil, nil, fmt.Errorf("file size exceeds maximum limit of %dMB (got %d bytes)", MaxFileSizeMB, len(e.Data))
+		}
+		row := bulkRow{path: p, isDir: e.IsDir, mode: e.Mode, modTime: now}
+		if !e.IsDir {
+			row.data = e.Data
+			if row.data == nil {
+				row.data = []byte{}
+			}
+		}
+		if row.mode == 0 {
+			row.mode = 0644
+			if e.IsDir {
+				row.mode = 0755
+			}
+		}
+		if !e.ModTime.IsZero() {
+			row.modTime = e.ModTime.Unix()
+		}
+		if i, ok := index[p]; ok {
+			rows[i] = row
+			continue
+		}
+		index[p] = len(rows)
+		rows = append(rows, row)
+	}
+
+	seen := make(map[string]bool)
+	var parents []string
+	for _, row := range rows {
+		for p := getParentPath(row.path); p != "/" && !seen[p]; p = getParentPath(p) {
+			seen[p] = true
+			if i, ok := index[p]; ok {
+				if !rows[i].isDir {
+					return nil, nil, filesystem.NewNotDirectoryError(p)
+				}
+				continue
+			}
+			parents = append(parents, p)
+		}
+	}
+	sort.Strings(parents)
+	return rows, parents, nil
+}
+
+// touchedDirs returns the sorted directories gaining or changing entries with rows and
+// the parents missing before the batch, except the rows themselves
+func touchedDirs(rows []bulkRow, parents []string, existing map[string]bool) []string {
+	inBatch := make(map[string]bool, len(rows))
+	paths := make([]string, 0, len(rows)+len(parents))
+	for _, row := range rows {
+		inBatch[row.path] = true
+		paths = append(paths, row.path)
+	}
+	for _, p := range parents {
+		if _, ok := existing[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range paths {
+		if dir := getParentPath(p); !inBatch[dir] && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// checkBulkRows rejects rows that would turn a directory into a file or the reverse,
+// and parents that exist as files; it returns the paths that exist, mapped to whether
+// they are directories
+func checkBulkRows(ctx context.Context, tx *sql.Tx, rows []bulkRow, parents []string) (map[string]bool, error) {
+	paths := make([]string, 0, len(rows)+len(parents))
+	for _, row := range rows {
+		paths = append(paths, row.path)
+	}
+	paths = append(paths, parents...)
+
+	existing := make(map[string]bool, len(paths))
+	for len(paths) > 0 {
+		n := min(len(paths), bulkLookupSize)
+		args := make([]interface{}, n)
+		for i, p := range paths[:n] {
+			args[i] = p
+		}
+		query := "SELECT path, is_dir FROM files WHERE path IN (?" + strings.Repeat(", ?", n-1) + ")"
+		result, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		for result.Next() {
+			var p string
+			var isDir int
+			if err := result.Scan(&p, &isDir); err != nil {
+				result.Close()
+				return nil, err
+			}
+			existing[p] = isDir == 1
+		}
+		result.Close()
+		if err := result.Err(); err != nil {
+			return nil, err
+		}
+		paths = paths[n:]
+	}
+
+	for _, row := range rows {
+		isDir, ok := existing[row.path]
+		switch {
+		case ok && isDir && !row.isDir:
+			return nil, filesystem.NewInvalidArgumentError("path", row.path, "is a directory")
+		case ok && !isDir && row.isDir:
+			return nil, filesystem.NewAlreadyExistsError("file", row.path)
+		}
+	}
+	for _, p := range parents {
+		if isDir, ok := existing[p]; ok && !isDir {
+			return nil, filesystem.NewNotDirectoryError(p)
+		}
+	}
+	return existing, nil
+}
+
+// insertBulkRows inserts rows with verb (e.g. "REPLACE"), bulkRowsPerStatement rows per statement
+func insertBulkRows(ctx context.Context, tx *sql.Tx, verb string, rows []bulkRow) error {
+	var full *sql.Stmt
+	for len(rows) > 0 {
+		n := min(len(rows), bulkRowsPerStatement)
+		args := make([]interface{}, 0, n*6)
+		for _, row := range rows[:n] {
+			args = append(args, row.args()...)
+		}
+		query := verb + " INTO files (path, is_dir, mode, size, mod_time, data) VALUES (?, ?, ?, ?, ?, ?)" +
+			strings.Repeat(", (?, ?, ?, ?, ?, ?)", n-1)
+
+		var err error
+		if n == bulkRowsPerStatement {
+			// Full batches share one prepared statement
+			if full == nil {
+				if full, err = tx.PrepareContext(ctx, query); err != nil {
+					return err
+				}
+				defer full.Close()
+			}
+			_, err = full.ExecContext(ctx, args...)
+		} else {
+			_, err = tx.ExecContext(ctx, query, args...)
+		}
+		if err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// Ensure SQLFS supports bulk loads
+var _ filesystem.BulkWriter = (*SQLFS)(nil)
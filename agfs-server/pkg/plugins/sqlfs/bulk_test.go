@@ -0,0 +1,71 @@
+package sqlfs
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestBulkLoad(t *testing.T) {
+	fs, err := NewSQLFS(NewSQLiteBackend(), map[string]interface{}{
+		"db_path": filepath.Join(t.TempDir(), "sqlfs.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if _, err := fs.Write("/file", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := fs.BeginBulk("/data", filesystem.BulkOptions{DeferIndexes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []filesystem.BulkEntry
+	for i := 0; i < 250; i++ {
+		entries = append(entries, filesystem.BulkEntry{Path: fmt.Sprintf("/data/dir/%c/%d", 'a'+i%26, i%10), Data: []byte{byte(i)}})
+	}
+	entries = append(entries, filesystem.BulkEntry{Path: "/data/empty", IsDir: true, Mode: 0700})
+	if err := session.WriteBatch(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch with a conflict is rejected as a whole
+	conflicts := [][]filesystem.BulkEntry{
+		{{Path: "/data/new"}, {Path: "/data/dir"}},   // directory replaced by a file
+		{{Path: "/data/new"}, {Path: "/file/child"}}, // parent is a file
+		{{Path: "/data/new"}, {Path: "/file", IsDir: true}},
+	}
+	for _, batch := range conflicts {
+		if err := session.WriteBatch(batch); err == nil {
+			t.Errorf("batch %v succeeded", batch)
+		}
+	}
+	if _, err := fs.Stat("/data/new"); err == nil {
+		t.Errorf("a rejected batch was partly written")
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var indexes int
+	if err := fs.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_parent'").Scan(&indexes); err != nil || indexes != 1 {
+		t.Errorf("idx_parent after the load: %d, %v", indexes, err)
+	}
+	infos, err := fs.ReadDir("/data/dir")
+	if err != nil || len(infos) != 26 {
+		t.Fatalf("ReadDir = %d entries, %v", len(infos), err)
+	}
+	if info, err := fs.Stat("/data/empty"); err != nil || !info.IsDir || info.Mode != 0700 {
+		t.Errorf("Stat(/data/empty) = %+v, %v", info, err)
+	}
+	// The last entry of a path wins
+	data, err := fs.Read("/data/dir/a/0", 0, -1)
+	if (err != nil && err != io.EOF) || len(data) != 1 || data[0] != 130 {
+		t.Errorf("Read = %v, %v", data, err)
+	}
+}
@@ -0,0 +1,22 @@
+package sqlfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem/filesystemtest"
+)
+
+func TestConformance(t *testing.T) {
+	filesystemtest.Run(t, func(t *testing.T) filesystem.FileSystem {
+		fs, err := NewSQLFS(NewSQLiteBackend(), map[string]interface{}{
+			"db_path": filepath.Join(t.TempDir(), "sqlfs.db"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { fs.db.Close() })
+		return fs
+	})
+}
@@ -0,0 +1,35 @@
+package sqlfs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueriesUseBoundContext(t *testing.T) {
+	fs, err := NewSQLFS(NewSQLiteBackend(), map[string]interface{}{
+		"db_path": filepath.Join(t.TempDir(), "sqlfs.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	if _, err := fs.Write("/a", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fs.WithContext(ctx).Stat("/a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("stat with a canceled context: error %v, want context.Canceled", err)
+	}
+
+	// The view shares the database, the original file system is not affected
+	if _, err := fs.WithContext(context.Background()).Write("/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/b"); err != nil {
+		t.Errorf("stat of a file written through a view: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package sqlfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaintenanceFile is the control file at the mount root: write an operation to start a run,
+// read for the report of the last run
+const MaintenanceFile = "/.maintenance"
+
+// MetaValueMaintenanceControl is the meta type of the maintenance control file
+const MetaValueMaintenanceControl = "maintenance-control"
+
+// Maintenance operations
+const (
+	MaintenanceAll     = "all"     // Every operation below
+	MaintenanceVacuum  = "vacuum"  // Reclaim free space (VACUUM on SQLite)
+	MaintenanceAnalyze = "analyze" // Refresh optimizer statistics
+	MaintenanceOrphans = "orphans" // Delete entries whose parent directory is missing
+)
+
+// Maintenance states
+const (
+	MaintenanceIdle     = "idle"
+	MaintenanceRunning  = "running"
+	MaintenanceFinished = "finished"
+	MaintenanceFailed   = "failed"
+)
+
+// MaintenanceReport describes the last maintenance run and the schedule
+type MaintenanceReport struct {
+	State      string     `json:"state"`
+	Operations []string   `json:"operations,omitempty"`
+	Trigger    string     `json:"trigger,omitempty"` // "manual" or "scheduled"
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	Orphans    int        `json:"orphans"` // Entries removed by the orphan sweep
+	Error      string     `json:"error,omitempty"`
+	Runs       int        `json:"runs"`               // Completed runs since the mount
+	Interval   string     `json:"interval,omitempty"` // Schedule, empty when disabled
+	NextRunAt  *time.Time `json:"nextRunAt,omitempty"`
+}
+
+// maintenance holds the state of the maintenance subsystem
+type maintenance struct {
+	done chan struct{}
+	wg   sync.WaitGroup // Scheduler and background runs
+
+	mu       sync.Mutex    // protects the fields below
+	interval time.Duration // 0 disables scheduled runs
+	report   MaintenanceReport
+}
+
+// parseMaintenanceOps parses a comma-separated operation list; empty means all
+func parseMaintenanceOps(s string) ([]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "run" || s == MaintenanceAll {
+		return []string{MaintenanceOrphans, MaintenanceVacuum, MaintenanceAnalyze}, nil
+	}
+	var ops []string
+	for _, op := range strings.Split(s, ",") {
+		op = strings.TrimSpace(op)
+		switch op {
+		case MaintenanceVacuum, MaintenanceAnalyze, MaintenanceOrphans:
+			ops = append(ops, op)
+		case "":
+		default:
+			return nil, filesystem.NewInvalidArgumentError("operation", op,
+				"expected all, vacuum, analyze or orphans")
+		}
+	}
+	return ops, nil
+}
+
+// startMaintenance runs maintenance every interval until stopMaintenance is called
+func (fs *SQLFS) startMaintenance(interval time.Duration) {
+	m := fs.maint
+	m.mu.Lock()
+	m.interval = interval
+	m.report.Interval = interval.String()
+	next := time.Now().Add(interval)
+	m.report.NextRunAt = &next
+	m.mu.Unlock()
+	done := make(chan struct{})
+	m.done = done
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ops, _ := parseMaintenanceOps(MaintenanceAll)
+				if err := fs.beginMaintenance(ops, "scheduled"); err != nil {
+					log.Warnf("[sqlfs] skipping scheduled maintenance: %v", err)
+					continue
+				}
+				fs.runMaintenance(ops)
+			}
+		}
+	}()
+}
+
+// stopMaintenance stops the scheduler and waits for a running maintenance to finish
+func (fs *SQLFS) stopMaintenance() {
+	if fs.maint.done != nil {
+		close(fs.maint.done)
+		fs.maint.done = nil
+	}
+	fs.maint.wg.Wait()
+}
+
+// TriggerMaintenance starts the operations in ops (see parseMaintenanceOps) in the background
+func (fs *SQLFS) TriggerMaintenance(ops string) error {
+	parsed, err := parseMaintenanceOps(ops)
+	if err != nil {
+		return err
+	}
+	if err := fs.beginMaintenance(parsed, "manual"); err != nil {
+		return err
+	}
+	fs.maint.wg.Add(1)
+	go func() {
+		defer fs.maint.wg.Done()
+		fs.runMaintenance(parsed)
+	}()
+	return nil
+}
+
+// beginMaintenance marks a run as started, failing if one is already running
+func (fs *SQLFS) beginMaintenance(ops []string, trigger string) error {
+	m := fs.maint
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.report.State == MaintenanceRunning {
+		return filesystem.NewAlreadyExistsError("maintenance", strings.Join(m.report.Operations, ","))
+	}
+	now := time.Now()
+	m.report.State = MaintenanceRunning
+	m.report.Operations = ops
+	m.report.Trigger = trigger
+	m.report.StartedAt = &now
+	m.report.FinishedAt = nil
+	m.report.Duration = ""
+	m.report.Orphans = 0
+	m.report.Error = ""
+	return nil
+}
+
+// runMaintenance performs ops and records the result
+// Maintenance holds the write lock, so it blocks other operations until it finishes
+func (fs *SQLFS) runMaintenance(ops []string) {
+	log.Infof("[sqlfs] maintenance started: %s", strings.Join(ops, ","))
+
+	orphans := 0
+	var runErr error
+	func() {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+		for _, op := range ops {
+			var err error
+			switch op {
+			case MaintenanceOrphans:
+				orphans, err = fs.sweepOrphans()
+			case MaintenanceVacuum:
+				err = fs.execAll(fs.backend.GetVacuumSQL())
+			case MaintenanceAnalyze:
+				err = fs.execAll(fs.backend.GetAnalyzeSQL())
+			}
+			if err != nil {
+				runErr = fmt.Errorf("%s: %w", op, err)
+				return
+			}
+		}
+	}()
+
+	m := fs.maint
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.report.FinishedAt = &now
+	m.report.Duration = now.Sub(*m.report.StartedAt).Round(time.Millisecond).String()
+	m.report.Orphans = orphans
+	m.report.Runs++
+	if m.interval > 0 {
+		next := now.Add(m.interval)
+		m.report.NextRunAt = &next
+	}
+	if runErr != nil {
+		m.report.State = MaintenanceFailed
+		m.report.Error = runErr.Error()
+		log.Errorf("[sqlfs] maintenance failed: %v", runErr)
+		return
+	}
+	m.report.State = MaintenanceFinished
+	log.Infof("[sqlfs] maintenance finished in %s, %d orphaned entries removed", m.report.Duration, orphans)
+}
+
+// execAll executes statements in order; the caller must hold fs.mu
+func (fs *SQLFS) execAll(statements []string) error {
+	for _, stmt := range statements {
+		if _, err := fs.db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepOrphans deletes entries whose parent is missing or is not a directory,
+// e.g. left behind by an interrupted batched RemoveAll; the caller must hold fs.mu
+// Once chunked storage lands, chunks not referenced by any file belong here as well
+func (fs *SQLFS) sweepOrphans() (int, error) {
+	type entry struct {
+		path  string
+		isDir bool
+	}
+
+	rows, err := fs.db.Query("SELECT path, is_dir FROM files WHERE path != '/'")
+	if err != nil {
+		return 0, err
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var isDir int
+		if err := rows.Scan(&e.path, &isDir); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		e.isDir = isDir == 1
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Visit parents before children so that entries below an orphan are orphans too
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.Count(entries[i].path, "/") < strings.Count(entries[j].path, "/")
+	})
+	dirs := map[string]bool{"/": true}
+	var orphans []string
+	for _, e := range entries {
+		if !dirs[path.Dir(e.path)] {
+			orphans = append(orphans, e.path)
+			continue
+		}
+		if e.isDir {
+			dirs[e.path] = true
+		}
+	}
+	if len(orphans) == 0 {
+		return 0, nil
+	}
+
+	tx, err := fs.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range orphans {
+		if _, err := tx.Exec("DELETE FROM files WHERE path = ?", p); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	fs.listCache.Clear()
+	log.Warnf("[sqlfs] removed %d orphaned entries", len(orphans))
+	return len(orphans), nil
+}
+
+// maintenanceReport returns the maintenance report as JSON
+func (fs *SQLFS) maintenanceReport() []byte {
+	fs.maint.mu.Lock()
+	defer fs.maint.mu.Unlock()
+	data, _ := json.MarshalIndent(fs.maint.report, "", "  ")
+	return append(data, '\n')
+}
+
+func (fs *SQLFS) maintenanceFileInfo() *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    path.Base(MaintenanceFile),
+		Size:    int64(len(fs.maintenanceReport())),
+		Mode:    0644,
+		ModTime: time.Now(),
+		IsDir:   false,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueMaintenanceControl},
+	}
+}
+
+// maintenanceDenied rejects operations other than read and write on the control file
+func maintenanceDenied(op string) error {
+	return filesystem.NewPermissionDeniedError(op, MaintenanceFile, "maintenance control file")
+}
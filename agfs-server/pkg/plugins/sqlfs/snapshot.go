@@ -0,0 +1,167 @@
+package sqlfs
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// Snapshot implements filesystem.Snapshotter
+// The directory and all its descendants are loaded with a single SELECT,
+// which the database serves from one consistent read view
+func (fs *SQLFS) Snapshot(path string) (filesystem.FileSystem, error) {
+	path = filesystem.NormalizePath(path)
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	pattern := path + "/%"
+	if path == "/" {
+		pattern = "/%"
+	}
+
+	rows, err := fs.db.Query(
+		"SELECT path, is_dir, mode, size, mod_time, data FROM files WHERE path = ? OR path LIKE ?",
+		path, pattern,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snap := &snapshotFS{
+		entries:  make(map[string]*FileEntry),
+		children: make(map[string][]string),
+	}
+	for rows.Next() {
+		var entry FileEntry
+		var isDir int
+		var modTime int64
+		var data sql.RawBytes
+		if err := rows.Scan(&entry.Path, &isDir, &entry.Mode, &entry.Size, &modTime, &data); err != nil {
+			return nil, err
+		}
+		entry.IsDir = isDir == 1
+		entry.ModTime = time.Unix(modTime, 0)
+		entry.Data = append([]byte(nil), data...)
+
+		// Re-root the entry so that path becomes "/"
+		rel := "/"
+		if entry.Path != path {
+			rel = filesystem.NormalizePath(strings.TrimPrefix(entry.Path, strings.TrimSuffix(path, "/")))
+		}
+		entry.Path = rel
+		snap.entries[rel] = &entry
+		if rel != "/" {
+			parent := getParentPath(rel)
+			snap.children[parent] = append(snap.children[parent], rel)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	root, ok := snap.entries["/"]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("snapshot", path)
+	}
+	if !root.IsDir {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+
+	return snap, nil
+}
+
+// snapshotFS is a read-only, in-memory copy of a SQLFS subtree
+type snapshotFS struct {
+	entries  map[string]*FileEntry
+	children map[string][]string
+}
+
+func (s *snapshotFS) infoFor(e *FileEntry) filesystem.FileInfo {
+	name := filepath.Base(e.Path)
+	return filesystem.FileInfo{
+		Name:    name,
+		Size:    e.Size,
+		Mode:    e.Mode,
+		ModTime: e.ModTime,
+		IsDir:   e.IsDir,
+		Meta: filesystem.MetaData{
+			Name: PluginName,
+			Type: "snapshot",
+		},
+	}
+}
+
+func (s *snapshotFS) Stat(path string) (*filesystem.FileInfo, error) {
+	e, ok := s.entries[filesystem.NormalizePath(path)]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	info := s.infoFor(e)
+	return &info, nil
+}
+
+func (s *snapshotFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	path = filesystem.NormalizePath(path)
+	e, ok := s.entries[path]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("readdir", path)
+	}
+	if !e.IsDir {
+		return nil, filesystem.NewNotDirectoryError(path)
+	}
+	var files []filesystem.FileInfo
+	for _, child := range s.children[path] {
+		files = append(files, s.infoFor(s.entries[child]))
+	}
+	return files, nil
+}
+
+func (s *snapshotFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	e, ok := s.entries[filesystem.NormalizePath(path)]
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	if e.IsDir {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	return plugin.ApplyRangeRead(e.Data, offset, size)
+}
+
+func (s *snapshotFS) Open(path string) (io.ReadCloser, error) {
+	data, err := s.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *snapshotFS) readOnly(op, path string) error {
+	return filesystem.NewPermissionDeniedError(op, path, "snapshot is read-only")
+}
+
+func (s *snapshotFS) Create(path string) error             { return s.readOnly("create", path) }
+func (s *snapshotFS) Mkdir(path string, perm uint32) error { return s.readOnly("mkdir", path) }
+func (s *snapshotFS) Remove(path string) error             { return s.readOnly("remove", path) }
+func (s *snapshotFS) RemoveAll(path string) error          { return s.readOnly("removeall", path) }
+func (s *snapshotFS) Rename(oldPath, newPath string) error { return s.readOnly("rename", oldPath) }
+func (s *snapshotFS) Chmod(path string, mode uint32) error { return s.readOnly("chmod", path) }
+
+func (s *snapshotFS) Write(path string, data []byte) ([]byte, error) {
+	return nil, s.readOnly("write", path)
+}
+
+func (s *snapshotFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, s.readOnly("openwrite", path)
+}
+
+// Ensure SQLFS implements Snapshotter
+var _ filesystem.Snapshotter = (*SQLFS)(nil)
+var _ filesystem.FileSystem = (*snapshotFS)(nil)
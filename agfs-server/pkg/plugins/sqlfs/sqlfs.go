@@ -1,14 +1,17 @@
 package sqlfs
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/credentials"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
@@ -17,9 +20,10 @@ import (
 )
 
 const (
-	PluginName    = "sqlfs"
-	MaxFileSize   = 5 * 1024 * 1024 // 5MB maximum file size
-	MaxFileSizeMB = 5
+	PluginName          = "sqlfs"
+	MaxFileSize         = 5 * 1024 * 1024 // 5MB maximum file size
+	MaxFileSizeMB       = 5
+	DefaultQueryTimeout = 30 * time.Second // Default limit of the queries of one operation
 )
 
 // SQLFSPlugin provides a database-backed file system
@@ -40,8 +44,8 @@ func (p *SQLFSPlugin) Name() string {
 
 func (p *SQLFSPlugin) Validate(cfg map[string]interface{}) error {
 	// Check for unknown parameters
-	allowedKeys := []string{"backend", "db_path", "dsn", "user", "password", "host", "port", "database",
-		"cache_enabled", "cache_max_size", "cache_ttl_seconds", "mount_path"}
+	allowedKeys := []string{"backend", "db_path", "dsn", "user", "password", "credentials", "host", "port", "database",
+		"cache_enabled", "cache_max_size", "cache_ttl_seconds", "maintenance_interval", "query_timeout", "watch_interval", "mount_path"}
 	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
 		return err
 	}
@@ -65,6 +69,10 @@ func (p *SQLFSPlugin) Validate(cfg map[string]interface{}) error {
 		}
 	}
 
+	if _, err := credentials.PluginProvider(cfg, credentials.Credentials{}); err != nil {
+		return err
+	}
+
 	// Validate optional integer parameters
 	for _, key := range []string{"port", "cache_max_size", "cache_ttl_seconds"} {
 		if err := config.ValidateIntType(cfg, key); err != nil {
@@ -77,28 +85,82 @@ func (p *SQLFSPlugin) Validate(cfg map[string]interface{}) error {
 		return err
 	}
 
+	// Validate maintenance_interval (optional duration)
+	if _, err := config.GetDurationConfig(cfg, "maintenance_interval", 0); err != nil {
+		return fmt.Errorf("invalid maintenance_interval: %w", err)
+	}
+
+	// Validate query_timeout (optional duration, 0 disables it)
+	if _, err := config.GetDurationConfig(cfg, "query_timeout", DefaultQueryTimeout); err != nil {
+		return fmt.Errorf("invalid query_timeout: %w", err)
+	}
+	if d, err := config.GetDurationConfig(cfg, "watch_interval", DefaultWatchInterval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid watch_interval: must be a positive duration")
+	}
+
 	return nil
 }
 
-func (p *SQLFSPlugin) Initialize(config map[string]interface{}) error {
-	p.config = config
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *SQLFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "backend", Type: plugin.ParamString, Default: "sqlite", Choices: []string{"sqlite", "tidb", "mysql"}, Description: "Database holding the files"},
+		{Name: "db_path", Type: plugin.ParamString, Default: "sqlfs.db", Description: "SQLite database file"},
+		{Name: "dsn", Type: plugin.ParamString, Secret: true, Description: "TiDB/MySQL data source name, instead of host, port, user and password"},
+		{Name: "host", Type: plugin.ParamString, Description: "TiDB/MySQL host"},
+		{Name: "port", Type: plugin.ParamInt, Description: "TiDB/MySQL port"},
+		{Name: "user", Type: plugin.ParamString, Description: "TiDB/MySQL user"},
+		{Name: "password", Type: plugin.ParamString, Secret: true, Description: "TiDB/MySQL password"},
+		{Name: "credentials", Type: plugin.ParamMap, Description: "Credential provider of the TiDB/MySQL user and password (static, env, file, exec or metadata), asked at every new connection"},
+		{Name: "database", Type: plugin.ParamString, Description: "TiDB/MySQL database"},
+		{Name: "cache_enabled", Type: plugin.ParamBool, Default: "true", Description: "Cache directory listings"},
+		{Name: "cache_max_size", Type: plugin.ParamInt, Default: "1000", Description: "Directory listings kept in the cache"},
+		{Name: "cache_ttl_seconds", Type: plugin.ParamInt, Default: "5", Description: "Seconds a cached listing is used"},
+		{Name: "maintenance_interval", Type: plugin.ParamDuration, Default: "0", Description: "How often the database is optimized (0 disables it)"},
+		{Name: "query_timeout", Type: plugin.ParamDuration, Default: DefaultQueryTimeout.String(), Description: "Limit of the queries of one operation (0 disables it)"},
+		{Name: "watch_interval", Type: plugin.ParamDuration, Default: DefaultWatchInterval.String(), Description: "How often watches query the database for changes"},
+	}
+}
+
+func (p *SQLFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.config = cfg
+
+	interval, err := config.GetDurationConfig(cfg, "maintenance_interval", 0)
+	if err != nil {
+		return fmt.Errorf("invalid maintenance_interval: %w", err)
+	}
+	queryTimeout, err := config.GetDurationConfig(cfg, "query_timeout", DefaultQueryTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid query_timeout: %w", err)
+	}
+	watchInterval, err := config.GetDurationConfig(cfg, "watch_interval", DefaultWatchInterval)
+	if err != nil || watchInterval <= 0 {
+		return fmt.Errorf("invalid watch_interval: must be a positive duration")
+	}
 
 	// Create appropriate backend
-	backend, err := CreateBackend(config)
+	backend, err := CreateBackend(cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create backend: %w", err)
 	}
 	p.backend = backend
 
 	// Create SQLFS instance with the backend
-	fs, err := NewSQLFS(backend, config)
+	fs, err := NewSQLFS(backend, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to initialize sqlfs: %w", err)
 	}
+	fs.queryTimeout = queryTimeout
+	fs.watchInterval = watchInterval
 	p.fs = fs
 
+	// Scheduled maintenance (disabled by default)
+	if interval > 0 {
+		fs.startMaintenance(interval)
+	}
+
 	backendType := "sqlite"
-	if bt, ok := config["backend"].(string); ok && bt != "" {
+	if bt, ok := cfg["backend"].(string); ok && bt != "" {
 		backendType = bt
 	}
 	log.Infof("[sqlfs] Initialized with backend: %s", backendType)
@@ -120,13 +182,27 @@ func (p *SQLFSPlugin) Shutdown() error {
 	return nil
 }
 
+// HealthCheck implements plugin.HealthChecker interface by pinging the database
+func (p *SQLFSPlugin) HealthCheck(ctx context.Context) error {
+	if p.fs == nil {
+		return fmt.Errorf("not initialized")
+	}
+	return p.fs.db.PingContext(ctx)
+}
+
 // SQLFS implements FileSystem interface using a database backend
+// State is held by pointer so that views returned by WithContext share it
 type SQLFS struct {
-	db         *sql.DB
-	backend    DBBackend
-	mu         sync.RWMutex
-	pluginName string
-	listCache  *ListDirCache // cache for directory listings
+	db            *sql.DB
+	backend       DBBackend
+	mu            *sync.RWMutex
+	pluginName    string
+	listCache     *ListDirCache   // cache for directory listings
+	maint         *maintenance    // scheduled and manual maintenance runs
+	bulk          *bulkState      // running bulk loads
+	ctx           context.Context // Context of the queries, nil for none
+	queryTimeout  time.Duration   // Limit of the queries of one operation, 0 for none
+	watchInterval time.Duration   // Time between the queries of watches, see Watch
 }
 
 // FileEntry represents a file or directory in the database
@@ -170,11 +246,17 @@ func NewSQLFS(backend DBBackend, config map[string]interface{}) (*SQLFS, error)
 	}
 
 	fs := &SQLFS{
-		db:         db,
-		backend:    backend,
-		pluginName: PluginName,
-		listCache:  NewListDirCache(cacheMaxSize, time.Duration(cacheTTLSeconds)*time.Second, cacheEnabled),
-	}
+		db:            db,
+		backend:       backend,
+		mu:            &sync.RWMutex{},
+		pluginName:    PluginName,
+		listCache:     NewListDirCache(cacheMaxSize, time.Duration(cacheTTLSeconds)*time.Second, cacheEnabled),
+		maint:         &maintenance{},
+		bulk:          newBulkState(backend),
+		queryTimeout:  DefaultQueryTimeout,
+		watchInterval: DefaultWatchInterval,
+	}
+	fs.maint.report.State = MaintenanceIdle
 
 	// Initialize database schema
 	if err := fs.initSchema(); err != nil {
@@ -223,8 +305,10 @@ func (fs *SQLFS) ensureRootExists() error {
 	return nil
 }
 
-// Close closes the database connection
+// Close stops maintenance and closes the database connection
 func (fs *SQLFS) Close() error {
+	fs.stopMaintenance()
+
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
@@ -234,6 +318,18 @@ func (fs *SQLFS) Close() error {
 	return nil
 }
 
+// WithContext implements filesystem.ContextBinder, the view's queries run under ctx
+func (fs *SQLFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.ctx = ctx
+	return &bound
+}
+
+// queryContext returns the context of the queries of one operation
+func (fs *SQLFS) queryContext() (context.Context, context.CancelFunc) {
+	return filesystem.OperationContext(fs.ctx, fs.queryTimeout)
+}
+
 // getParentPath returns the parent directory path
 func getParentPath(path string) string {
 	if path == "/" {
@@ -246,17 +342,47 @@ func getParentPath(path string) string {
 	return parent
 }
 
+// execer runs statements on the database or in a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// touchDir records a change to the entries of dir at now, Unix seconds; the modification
+// time of a directory only moves forward, servers sharing the database may lag behind
+func touchDir(ctx context.Context, db execer, dir string, now int64) error {
+	_, err := db.ExecContext(ctx, "UPDATE files SET mod_time = ? WHERE path = ? AND mod_time < ?", now, dir, now)
+	return err
+}
+
+// touchParent records a change to the directory containing path after the change was
+// made, a failure only leaves the directory time behind; the listings showing the
+// directory or its entries are invalidated. The caller must hold fs.mu
+func (fs *SQLFS) touchParent(ctx context.Context, path string, now int64) {
+	dir := getParentPath(path)
+	if err := touchDir(ctx, fs.db, dir, now); err != nil {
+		log.Warnf("[sqlfs] failed to update the modification time of %s: %v", dir, err)
+	}
+	fs.listCache.Invalidate(dir)
+	fs.listCache.InvalidateParent(dir)
+}
+
 func (fs *SQLFS) Create(path string) error {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return maintenanceDenied("create")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if parent directory exists
 	parent := getParentPath(path)
 	if parent != "/" {
 		var isDir int
-		err := fs.db.QueryRow("SELECT is_dir FROM files WHERE path = ?", parent).Scan(&isDir)
+		err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", parent).Scan(&isDir)
 		if err == sql.ErrNoRows {
 			return filesystem.NewNotFoundError("create", parent)
 		} else if err != nil {
@@ -269,7 +395,7 @@ func (fs *SQLFS) Create(path string) error {
 
 	// Check if file already exists
 	var exists int
-	err := fs.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ?", path).Scan(&exists)
+	err := fs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE path = ?", path).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -278,14 +404,15 @@ func (fs *SQLFS) Create(path string) error {
 	}
 
 	// Create empty file
-	_, err = fs.db.Exec(
+	now := time.Now().Unix()
+	_, err = fs.db.ExecContext(ctx,
 		"INSERT INTO files (path, is_dir, mode, size, mod_time, data) VALUES (?, ?, ?, ?, ?, ?)",
-		path, 0, 0644, 0, time.Now().Unix(), []byte{},
+		path, 0, 0644, 0, now, []byte{},
 	)
 
-	// Invalidate parent directory cache
+	// Update the parent directory, which invalidates its cache
 	if err == nil {
-		fs.listCache.InvalidateParent(path)
+		fs.touchParent(ctx, path, now)
 	}
 
 	return err
@@ -293,15 +420,21 @@ func (fs *SQLFS) Create(path string) error {
 
 func (fs *SQLFS) Mkdir(path string, perm uint32) error {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return maintenanceDenied("mkdir")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if parent directory exists
 	parent := getParentPath(path)
 	if parent != "/" {
 		var isDir int
-		err := fs.db.QueryRow("SELECT is_dir FROM files WHERE path = ?", parent).Scan(&isDir)
+		err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", parent).Scan(&isDir)
 		if err == sql.ErrNoRows {
 			return filesystem.NewNotFoundError("mkdir", parent)
 		} else if err != nil {
@@ -314,7 +447,7 @@ func (fs *SQLFS) Mkdir(path string, perm uint32) error {
 
 	// Check if directory already exists
 	var exists int
-	err := fs.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ?", path).Scan(&exists)
+	err := fs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE path = ?", path).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -326,14 +459,15 @@ func (fs *SQLFS) Mkdir(path string, perm uint32) error {
 	if perm == 0 {
 		perm = 0755
 	}
-	_, err = fs.db.Exec(
+	now := time.Now().Unix()
+	_, err = fs.db.ExecContext(ctx,
 		"INSERT INTO files (path, is_dir, mode, size, mod_time, data) VALUES (?, ?, ?, ?, ?, ?)",
-		path, 1, perm, 0, time.Now().Unix(), nil,
+		path, 1, perm, 0, now, nil,
 	)
 
-	// Invalidate parent directory cache
+	// Update the parent directory, which invalidates its cache
 	if err == nil {
-		fs.listCache.InvalidateParent(path)
+		fs.touchParent(ctx, path, now)
 	}
 
 	return err
@@ -345,13 +479,19 @@ func (fs *SQLFS) Remove(path string) error {
 	if path == "/" {
 		return fmt.Errorf("cannot remove root directory")
 	}
+	if path == MaintenanceFile {
+		return maintenanceDenied("remove")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if file exists and is not a directory
 	var isDir int
-	err := fs.db.QueryRow("SELECT is_dir FROM files WHERE path = ?", path).Scan(&isDir)
+	err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", path).Scan(&isDir)
 	if err == sql.ErrNoRows {
 		return filesystem.NewNotFoundError("remove", path)
 	} else if err != nil {
@@ -361,7 +501,7 @@ func (fs *SQLFS) Remove(path string) error {
 	if isDir == 1 {
 		// Check if directory is empty
 		var count int
-		err = fs.db.QueryRow("SELECT COUNT(*) FROM files WHERE path LIKE ? AND path != ?", path+"/%", path).Scan(&count)
+		err = fs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE path LIKE ? AND path != ?", path+"/%", path).Scan(&count)
 		if err != nil {
 			return err
 		}
@@ -371,11 +511,11 @@ func (fs *SQLFS) Remove(path string) error {
 	}
 
 	// Delete file
-	_, err = fs.db.Exec("DELETE FROM files WHERE path = ?", path)
+	_, err = fs.db.ExecContext(ctx, "DELETE FROM files WHERE path = ?", path)
 
-	// Invalidate parent directory cache and the path itself if it's a directory
+	// Update the parent directory and invalidate the path itself if it's a directory
 	if err == nil {
-		fs.listCache.InvalidateParent(path)
+		fs.touchParent(ctx, path, time.Now().Unix())
 		fs.listCache.Invalidate(path)
 	}
 
@@ -384,6 +524,9 @@ func (fs *SQLFS) Remove(path string) error {
 
 func (fs *SQLFS) RemoveAll(path string) error {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return maintenanceDenied("removeall")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -394,7 +537,7 @@ func (fs *SQLFS) RemoveAll(path string) error {
 	// If path is root, remove all children but not the root itself
 	if path == "/" {
 		for {
-			result, err := fs.db.Exec("DELETE FROM files WHERE path != '/' LIMIT ?", batchSize)
+			result, err := fs.execBatch(fs.backend.GetBatchDeleteSQL("path != '/'"), batchSize)
 			if err != nil {
 				return err
 			}
@@ -413,12 +556,16 @@ func (fs *SQLFS) RemoveAll(path string) error {
 		}
 		// Invalidate entire cache
 		fs.listCache.InvalidatePrefix("/")
+		ctx, cancel := fs.queryContext()
+		defer cancel()
+		fs.touchParent(ctx, "/", time.Now().Unix())
 		return nil
 	}
 
 	// Delete file and all children in batches
+	removed := false
 	for {
-		result, err := fs.db.Exec("DELETE FROM files WHERE (path = ? OR path LIKE ?) LIMIT ?", path, path+"/%", batchSize)
+		result, err := fs.execBatch(fs.backend.GetBatchDeleteSQL("(path = ? OR path LIKE ?)"), path, path+"/%", batchSize)
 		if err != nil {
 			return err
 		}
@@ -430,6 +577,7 @@ func (fs *SQLFS) RemoveAll(path string) error {
 		if affected == 0 {
 			break
 		}
+		removed = true
 		// If fewer rows than batch size were deleted, we're done
 		if affected < int64(batchSize) {
 			break
@@ -439,19 +587,37 @@ func (fs *SQLFS) RemoveAll(path string) error {
 	// Invalidate cache for the path and all descendants
 	fs.listCache.InvalidateParent(path)
 	fs.listCache.InvalidatePrefix(path)
+	if removed {
+		ctx, cancel := fs.queryContext()
+		defer cancel()
+		fs.touchParent(ctx, path, time.Now().Unix())
+	}
 
 	return nil
 }
 
+// execBatch runs one batch of a batched deletion, each batch gets the full query timeout
+func (fs *SQLFS) execBatch(query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+	return fs.db.ExecContext(ctx, query, args...)
+}
+
 func (fs *SQLFS) Read(path string, offset int64, size int64) ([]byte, error) {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return plugin.ApplyRangeRead(fs.maintenanceReport(), offset, size)
+	}
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	var isDir int
 	var data []byte
-	err := fs.db.QueryRow("SELECT is_dir, data FROM files WHERE path = ?", path).Scan(&isDir, &data)
+	err := fs.db.QueryRowContext(ctx, "SELECT is_dir, data FROM files WHERE path = ?", path).Scan(&isDir, &data)
 	if err == sql.ErrNoRows {
 		return nil, filesystem.NewNotFoundError("read", path)
 	} else if err != nil {
@@ -488,6 +654,12 @@ func (fs *SQLFS) Read(path string, offset int64, size int64) ([]byte, error) {
 
 func (fs *SQLFS) Write(path string, data []byte) ([]byte, error) {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		if err := fs.TriggerMaintenance(string(data)); err != nil {
+			return nil, err
+		}
+		return []byte("maintenance started"), nil
+	}
 
 	// Check file size limit
 	if len(data) > MaxFileSize {
@@ -497,10 +669,13 @@ func (fs *SQLFS) Write(path string, data []byte) ([]byte, error) {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if file exists
 	var exists int
 	var isDir int
-	err := fs.db.QueryRow("SELECT COUNT(*), COALESCE(MAX(is_dir), 0) FROM files WHERE path = ?", path).Scan(&exists, &isDir)
+	err := fs.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(is_dir), 0) FROM files WHERE path = ?", path).Scan(&exists, &isDir)
 	if err != nil {
 		return nil, err
 	}
@@ -509,12 +684,13 @@ func (fs *SQLFS) Write(path string, data []byte) ([]byte, error) {
 		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
 	}
 
+	now := time.Now().Unix()
 	if exists == 0 {
 		// File doesn't exist, create it
 		parent := getParentPath(path)
 		if parent != "/" {
 			var parentIsDir int
-			err := fs.db.QueryRow("SELECT is_dir FROM files WHERE path = ?", parent).Scan(&parentIsDir)
+			err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", parent).Scan(&parentIsDir)
 			if err == sql.ErrNoRows {
 				return nil, filesystem.NewNotFoundError("write", parent)
 			} else if err != nil {
@@ -525,45 +701,118 @@ func (fs *SQLFS) Write(path string, data []byte) ([]byte, error) {
 			}
 		}
 
-		_, err = fs.db.Exec(
+		_, err = fs.db.ExecContext(ctx,
 			"INSERT INTO files (path, is_dir, mode, size, mod_time, data) VALUES (?, ?, ?, ?, ?, ?)",
-			path, 0, 0644, len(data), time.Now().Unix(), data,
+			path, 0, 0644, len(data), now, data,
 		)
-
-		// Invalidate parent directory cache on new file creation
-		if err == nil {
-			fs.listCache.InvalidateParent(path)
-		}
 	} else {
 		// Update existing file
-		_, err = fs.db.Exec(
+		_, err = fs.db.ExecContext(ctx,
 			"UPDATE files SET data = ?, size = ?, mod_time = ? WHERE path = ?",
-			data, len(data), time.Now().Unix(), path,
+			data, len(data), now, path,
 		)
-		// Note: no need to invalidate parent cache on update, only on create/delete
 	}
 
 	if err != nil {
 		return nil, err
 	}
+	// Both change the parent directory, which invalidates its cache
+	fs.touchParent(ctx, path, now)
 
 	return []byte(fmt.Sprintf("Written %d bytes to %s", len(data), path)), nil
 }
 
+// Append implements filesystem.Appender
+// Existing files grow with a single UPDATE, so appends from servers sharing the database
+// are not lost either
+func (fs *SQLFS) Append(path string, data []byte) (int64, error) {
+	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return 0, filesystem.NewInvalidArgumentError("path", path, "cannot append to the maintenance file")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
+	var exists, isDir int
+	var size int64
+	err := fs.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(is_dir), 0), COALESCE(MAX(size), 0) FROM files WHERE path = ?", path).Scan(&exists, &isDir, &size)
+	if err != nil {
+		return 0, err
+	}
+	if exists > 0 && isDir == 1 {
+		return 0, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	if size+int64(len(data)) > MaxFileSize {
+		return 0, fmt.Errorf("file size exceeds maximum limit of %dMB (got %d bytes)", MaxFileSizeMB, size+int64(len(data)))
+	}
+
+	if exists == 0 {
+		parent := getParentPath(path)
+		if parent != "/" {
+			var parentIsDir int
+			err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", parent).Scan(&parentIsDir)
+			if err == sql.ErrNoRows {
+				return 0, filesystem.NewNotFoundError("append", parent)
+			} else if err != nil {
+				return 0, err
+			}
+			if parentIsDir == 0 {
+				return 0, filesystem.NewNotDirectoryError(parent)
+			}
+		}
+		now := time.Now().Unix()
+		_, err = fs.db.ExecContext(ctx,
+			"INSERT INTO files (path, is_dir, mode, size, mod_time, data) VALUES (?, ?, ?, ?, ?, ?)",
+			path, 0, 0644, len(data), now, data,
+		)
+		if err != nil {
+			return 0, err
+		}
+		fs.touchParent(ctx, path, now)
+		return int64(len(data)), nil
+	}
+
+	now := time.Now().Unix()
+	tx, err := fs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fs.backend.GetAppendSQL(), data, len(data), now, path); err != nil {
+		return 0, err
+	}
+	// Another server may have appended as well
+	if err := tx.QueryRowContext(ctx, "SELECT size FROM files WHERE path = ?", path).Scan(&size); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	fs.touchParent(ctx, path, now)
+	return size, nil
+}
+
 func (fs *SQLFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	path = filesystem.NormalizePath(path)
 
 	// Try to get from cache first
 	if files, found := fs.listCache.Get(path); found {
-		return files, nil
+		return fs.withMaintenanceFile(path, files), nil
 	}
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if directory exists
 	var isDir int
-	err := fs.db.QueryRow("SELECT is_dir FROM files WHERE path = ?", path).Scan(&isDir)
+	err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", path).Scan(&isDir)
 	if err == sql.ErrNoRows {
 		return nil, filesystem.NewNotFoundError("readdir", path)
 	} else if err != nil {
@@ -580,7 +829,7 @@ func (fs *SQLFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		pattern = path + "/"
 	}
 
-	rows, err := fs.db.Query(
+	rows, err := fs.db.QueryContext(ctx,
 		"SELECT path, is_dir, mode, size, mod_time FROM files WHERE path LIKE ? AND path != ? AND path NOT LIKE ?",
 		pattern+"%", path, pattern+"%/%",
 	)
@@ -621,21 +870,38 @@ func (fs *SQLFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	// Cache the result
 	fs.listCache.Put(path, files)
 
-	return files, nil
+	return fs.withMaintenanceFile(path, files), nil
+}
+
+// withMaintenanceFile adds the maintenance control file to a listing of the root
+func (fs *SQLFS) withMaintenanceFile(path string, files []filesystem.FileInfo) []filesystem.FileInfo {
+	if path != "/" {
+		return files
+	}
+	// Copy so the cached listing is not modified
+	result := make([]filesystem.FileInfo, 0, len(files)+1)
+	result = append(result, files...)
+	return append(result, *fs.maintenanceFileInfo())
 }
 
 func (fs *SQLFS) Stat(path string) (*filesystem.FileInfo, error) {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return fs.maintenanceFileInfo(), nil
+	}
 
 	fs.mu.RLock()
 	defer fs.mu.RUnlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	var isDir int
 	var mode uint32
 	var size int64
 	var modTime int64
 
-	err := fs.db.QueryRow(
+	err := fs.db.QueryRowContext(ctx,
 		"SELECT is_dir, mode, size, mod_time FROM files WHERE path = ?",
 		path,
 	).Scan(&isDir, &mode, &size, &modTime)
@@ -651,19 +917,45 @@ func (fs *SQLFS) Stat(path string) (*filesystem.FileInfo, error) {
 		name = "/"
 	}
 
+	meta := filesystem.MetaData{
+		Name: PluginName,
+		Type: fs.backend.GetDriverName(),
+	}
+	if isDir == 1 {
+		children, err := fs.countChildren(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		meta.Content = map[string]string{filesystem.MetaKeyChildren: strconv.Itoa(children)}
+	}
+
 	return &filesystem.FileInfo{
 		Name:    name,
 		Size:    size,
 		Mode:    mode,
 		ModTime: time.Unix(modTime, 0),
 		IsDir:   isDir == 1,
-		Meta: filesystem.MetaData{
-			Name: PluginName,
-			Type: fs.backend.GetDriverName(),
-		},
+		Meta:    meta,
 	}, nil
 }
 
+// countChildren returns the number of entries of the directory path, as listed by ReadDir
+func (fs *SQLFS) countChildren(ctx context.Context, path string) (int, error) {
+	pattern := path
+	if path != "/" {
+		pattern = path + "/"
+	}
+	var count int
+	err := fs.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM files WHERE path LIKE ? AND path != ? AND path NOT LIKE ?",
+		pattern+"%", path, pattern+"%/%",
+	).Scan(&count)
+	if path == "/" {
+		count++ // The maintenance file
+	}
+	return count, err
+}
+
 func (fs *SQLFS) Rename(oldPath, newPath string) error {
 	oldPath = filesystem.NormalizePath(oldPath)
 	newPath = filesystem.NormalizePath(newPath)
@@ -671,13 +963,19 @@ func (fs *SQLFS) Rename(oldPath, newPath string) error {
 	if oldPath == "/" || newPath == "/" {
 		return fmt.Errorf("cannot rename root directory")
 	}
+	if oldPath == MaintenanceFile || newPath == MaintenanceFile {
+		return maintenanceDenied("rename")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
 	// Check if old path exists
 	var exists int
-	err := fs.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ?", oldPath).Scan(&exists)
+	err := fs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE path = ?", oldPath).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -686,7 +984,7 @@ func (fs *SQLFS) Rename(oldPath, newPath string) error {
 	}
 
 	// Check if new path already exists
-	err = fs.db.QueryRow("SELECT COUNT(*) FROM files WHERE path = ?", newPath).Scan(&exists)
+	err = fs.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM files WHERE path = ?", newPath).Scan(&exists)
 	if err != nil {
 		return err
 	}
@@ -694,22 +992,38 @@ func (fs *SQLFS) Rename(oldPath, newPath string) error {
 		return filesystem.NewAlreadyExistsError("file", newPath)
 	}
 
+	// Check if new parent directory exists
+	parent := getParentPath(newPath)
+	if parent != "/" {
+		var isDir int
+		err := fs.db.QueryRowContext(ctx, "SELECT is_dir FROM files WHERE path = ?", parent).Scan(&isDir)
+		if err == sql.ErrNoRows {
+			return filesystem.NewNotFoundError("rename", parent)
+		} else if err != nil {
+			return err
+		}
+		if isDir == 0 {
+			return filesystem.NewNotDirectoryError(parent)
+		}
+	}
+
 	// Rename file/directory
-	_, err = fs.db.Exec("UPDATE files SET path = ? WHERE path = ?", newPath, oldPath)
+	_, err = fs.db.ExecContext(ctx, "UPDATE files SET path = ? WHERE path = ?", newPath, oldPath)
 	if err != nil {
 		return err
 	}
 
 	// If it's a directory, rename all children
-	_, err = fs.db.Exec(
+	_, err = fs.db.ExecContext(ctx,
 		"UPDATE files SET path = ? || SUBSTR(path, ?) WHERE path LIKE ?",
 		newPath, len(oldPath)+1, oldPath+"/%",
 	)
 
-	// Invalidate cache for old and new parent directories
+	// Update the old and new parent directories, which invalidates their cache
 	if err == nil {
-		fs.listCache.InvalidateParent(oldPath)
-		fs.listCache.InvalidateParent(newPath)
+		now := time.Now().Unix()
+		fs.touchParent(ctx, oldPath, now)
+		fs.touchParent(ctx, newPath, now)
 		fs.listCache.Invalidate(oldPath)
 		fs.listCache.InvalidatePrefix(oldPath)
 	}
@@ -719,11 +1033,17 @@ func (fs *SQLFS) Rename(oldPath, newPath string) error {
 
 func (fs *SQLFS) Chmod(path string, mode uint32) error {
 	path = filesystem.NormalizePath(path)
+	if path == MaintenanceFile {
+		return maintenanceDenied("chmod")
+	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	result, err := fs.db.Exec("UPDATE files SET mode = ? WHERE path = ?", mode, path)
+	ctx, cancel := fs.queryContext()
+	defer cancel()
+
+	result, err := fs.db.ExecContext(ctx, "UPDATE files SET mode = ? WHERE path = ?", mode, path)
 	if err != nil {
 		return err
 	}
@@ -781,6 +1101,15 @@ CONFIGURATION:
     cache_max_size = 1000       # Maximum number of cached entries (default: 1000)
     cache_ttl_seconds = 5       # Cache entry TTL in seconds (default: 5)
 
+    # Optional scheduled maintenance (disabled by default)
+    maintenance_interval = "24h"  # Sweep orphans, VACUUM and ANALYZE every 24 hours
+
+    # Queries of one operation are aborted after this long (default: "30s", 0 disables it)
+    query_timeout = "30s"
+
+    # Watches query the database for changes this often (default: "5s")
+    watch_interval = "5s"
+
   TiDB Backend (Production):
   [plugins.sqlfs]
   enabled = true
@@ -852,6 +1181,36 @@ EXAMPLES:
   config.txt
   logs/
 
+MAINTENANCE:
+
+  The /.maintenance control file at the mount root triggers and reports
+  maintenance runs. Write the operations to run (comma-separated, default all):
+    all       - Every operation below
+    orphans   - Delete entries whose parent directory is missing
+    vacuum    - Reclaim free space (SQLite: WAL checkpoint and VACUUM; TiDB: no-op)
+    analyze   - Refresh optimizer statistics (SQLite: ANALYZE; TiDB: ANALYZE TABLE)
+
+  Runs hold the write lock, so other operations wait until they finish.
+
+  agfs:/> echo vacuum,analyze > /sqlfs/.maintenance
+  agfs:/> cat /sqlfs/.maintenance
+  {
+    "state": "finished",
+    "operations": ["vacuum", "analyze"],
+    ...
+  }
+
+BULK INGEST:
+
+  POST /api/v1/ingest loads a tar stream or another mount into a sqlfs
+  directory in batches: each batch is one transaction of multi-row
+  INSERTs, written by parallel workers (one at a time on SQLite).
+  With defer_indexes=true the secondary index is dropped during the load
+  and rebuilt at the end. Mounts with checksum, trash, scan, cache or
+  write limit options are loaded file by file instead.
+
+  curl -X POST "localhost:8080/api/v1/ingest?path=/sqlfs/data&from=/local/data&defer_indexes=true"
+
 ADVANTAGES:
   - Data persists across server restarts
   - Efficient storage with database compression
@@ -890,4 +1249,6 @@ LIMITATIONS:
 
 // Ensure SQLFSPlugin implements ServicePlugin
 var _ plugin.ServicePlugin = (*SQLFSPlugin)(nil)
+var _ plugin.HealthChecker = (*SQLFSPlugin)(nil)
 var _ filesystem.FileSystem = (*SQLFS)(nil)
+var _ filesystem.Appender = (*SQLFS)(nil)
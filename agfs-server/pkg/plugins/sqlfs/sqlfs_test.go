@@ -0,0 +1,84 @@
+package sqlfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestDirectoryModTime(t *testing.T) {
+	fs, err := NewSQLFS(NewSQLiteBackend(), map[string]interface{}{
+		"db_path": filepath.Join(t.TempDir(), "sqlfs.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	fs.Mkdir("/dir", 0755)
+	fs.Mkdir("/dir/sub", 0755)
+
+	// Times are in seconds, so each step starts from an old directory
+	reset := func() {
+		if _, err := fs.db.Exec("UPDATE files SET mod_time = 0 WHERE is_dir = 1"); err != nil {
+			t.Fatal(err)
+		}
+		fs.listCache.Clear()
+	}
+	modified := func(path string) bool {
+		info, err := fs.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime.Unix() > 0
+	}
+
+	for _, step := range []struct {
+		name string
+		op   func() error
+	}{
+		{"create", func() error { return fs.Create("/dir/a") }},
+		{"write", func() error { _, err := fs.Write("/dir/a", []byte("data")); return err }},
+		{"append", func() error { _, err := fs.Append("/dir/a", []byte("more")); return err }},
+		{"rename", func() error { return fs.Rename("/dir/a", "/dir/b") }},
+		{"remove", func() error { return fs.Remove("/dir/b") }},
+		{"mkdir", func() error { return fs.Mkdir("/dir/c", 0755) }},
+		{"removeall", func() error { return fs.RemoveAll("/dir/c") }},
+		{"bulk", func() error {
+			s, err := fs.BeginBulk("/", filesystem.BulkOptions{})
+			if err != nil {
+				return err
+			}
+			defer s.Close()
+			return s.WriteBatch([]filesystem.BulkEntry{{Path: "/dir/new/file", Data: []byte("x")}})
+		}},
+	} {
+		reset()
+		if err := step.op(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !modified("/dir") {
+			t.Errorf("%s did not update the directory modification time", step.name)
+		}
+		if modified("/") || modified("/dir/sub") {
+			t.Errorf("%s updated a directory other than the parent", step.name)
+		}
+	}
+
+	// The listing of the parent of a changed directory shows its new time
+	fs.ReadDir("/")
+	reset()
+	fs.ReadDir("/")
+	fs.Write("/dir/d", nil)
+	entries, _ := fs.ReadDir("/")
+	for _, e := range entries {
+		if e.Name == "dir" && e.ModTime.Unix() == 0 {
+			t.Error("cached listing shows the old directory time")
+		}
+	}
+
+	info, _ := fs.Stat("/dir")
+	if n := info.Meta.Content[filesystem.MetaKeyChildren]; n != "3" {
+		t.Errorf("children = %q, want 3 (sub, new and d)", n)
+	}
+}
@@ -0,0 +1,71 @@
+package sqlfs
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// DefaultWatchInterval is how often watches query the database for changes
+const DefaultWatchInterval = 5 * time.Second
+
+// Watch implements filesystem.Watcher by polling; the database cannot notify of changes,
+// which other servers sharing it may also make, so the metadata of the watched files is
+// queried every watch_interval
+// Renames show as a removal and a creation, and writes within the same second that keep
+// the size of a file may go unnoticed.
+func (fs *SQLFS) Watch(ctx context.Context, path string, recursive bool) (<-chan filesystem.Event, error) {
+	path = filesystem.NormalizePath(path)
+	if _, err := fs.Stat(path); err != nil {
+		return nil, err
+	}
+	return filesystem.PollWatch(ctx, func() (map[string]filesystem.FileInfo, error) {
+		return fs.listTree(ctx, path, recursive)
+	}, fs.watchInterval)
+}
+
+// listTree returns the metadata of path and its entries, or its whole tree, in one query
+// that bypasses the listing cache
+func (fs *SQLFS) listTree(parent context.Context, path string, recursive bool) (map[string]filesystem.FileInfo, error) {
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	ctx, cancel := filesystem.OperationContext(parent, fs.queryTimeout)
+	defer cancel()
+
+	pattern := path + "/%"
+	if path == "/" {
+		pattern = "/%"
+	}
+	rows, err := fs.db.QueryContext(ctx,
+		"SELECT path, is_dir, mode, size, mod_time FROM files WHERE path = ? OR path LIKE ?",
+		path, pattern,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tree := make(map[string]filesystem.FileInfo)
+	for rows.Next() {
+		var p string
+		var isDir int
+		var info filesystem.FileInfo
+		var modTime int64
+		if err := rows.Scan(&p, &isDir, &info.Mode, &info.Size, &modTime); err != nil {
+			return nil, err
+		}
+		if !recursive && p != path && filepath.Dir(p) != path {
+			continue
+		}
+		info.Name = filepath.Base(p)
+		info.IsDir = isDir == 1
+		info.ModTime = time.Unix(modTime, 0)
+		tree[p] = info
+	}
+	return tree, rows.Err()
+}
+
+var _ filesystem.Watcher = (*SQLFS)(nil)
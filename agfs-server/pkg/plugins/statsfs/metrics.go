@@ -0,0 +1,309 @@
+package statsfs
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketSeconds is the width of the buckets counter rates are computed from
+	bucketSeconds = 10
+
+	// rateBuckets covers the longest rate window, 15 minutes
+	rateBuckets = 15 * 60 / bucketSeconds
+)
+
+// counter is a running total with per-bucket increments for rates
+type counter struct {
+	value   float64
+	buckets [rateBuckets]float64
+	epochs  [rateBuckets]int64 // Bucket number each slot holds
+}
+
+func (c *counter) add(v float64, now time.Time) {
+	c.value += v
+	epoch := now.Unix() / bucketSeconds
+	slot := epoch % rateBuckets
+	if c.epochs[slot] != epoch {
+		c.epochs[slot] = epoch
+		c.buckets[slot] = 0
+	}
+	c.buckets[slot] += v
+}
+
+// rate returns the increase per second over the last window
+func (c *counter) rate(window time.Duration, now time.Time) float64 {
+	epoch := now.Unix() / bucketSeconds
+	n := int64(window.Seconds()) / bucketSeconds
+	var sum float64
+	for slot := range c.buckets {
+		if e := c.epochs[slot]; e > epoch-n && e <= epoch {
+			sum += c.buckets[slot]
+		}
+	}
+	return sum / window.Seconds()
+}
+
+// timer aggregates durations in milliseconds; percentiles cover the most recent samples
+type timer struct {
+	count    int64
+	sum      float64
+	min, max float64
+	samples  []float64 // Ring of the last maxSamples durations
+	next     int
+}
+
+func (t *timer) add(ms float64, maxSamples int) {
+	if t.count == 0 || ms < t.min {
+		t.min = ms
+	}
+	if t.count == 0 || ms > t.max {
+		t.max = ms
+	}
+	t.count++
+	t.sum += ms
+	if len(t.samples) < maxSamples {
+		t.samples = append(t.samples, ms)
+		return
+	}
+	t.samples[t.next] = ms
+	t.next = (t.next + 1) % maxSamples
+}
+
+// CounterStats is the rollup of a counter
+type CounterStats struct {
+	Value   float64 `json:"value"`
+	Rate1m  float64 `json:"rate1m"` // Increase per second over the last minute
+	Rate5m  float64 `json:"rate5m"`
+	Rate15m float64 `json:"rate15m"`
+}
+
+// TimerStats is the rollup of a timer, durations are in milliseconds
+type TimerStats struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"` // Percentiles of the most recent samples
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// Stats is the rollup of every metric
+type Stats struct {
+	Counters map[string]CounterStats `json:"counters"`
+	Timers   map[string]TimerStats   `json:"timers"`
+}
+
+// registry holds the metrics of a mount
+type registry struct {
+	mu         sync.Mutex
+	counters   map[string]*counter
+	timers     map[string]*timer
+	modified   map[string]time.Time // Last update of each metric, by kind/name
+	maxSamples int
+	now        func() time.Time
+}
+
+func newRegistry(maxSamples int) *registry {
+	return &registry{
+		counters:   make(map[string]*counter),
+		timers:     make(map[string]*timer),
+		modified:   make(map[string]time.Time),
+		maxSamples: maxSamples,
+		now:        time.Now,
+	}
+}
+
+func (r *registry) addCounter(name string, values []float64) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	c := r.counters[name]
+	if c == nil {
+		c = &counter{}
+		r.counters[name] = c
+	}
+	for _, v := range values {
+		c.add(v, now)
+	}
+	r.modified[kindCounters+"/"+name] = now
+	return c.value
+}
+
+func (r *registry) addTimer(name string, values []float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.timers[name]
+	if t == nil {
+		t = &timer{}
+		r.timers[name] = t
+	}
+	for _, v := range values {
+		t.add(v, r.maxSamples)
+	}
+	r.modified[kindTimers+"/"+name] = r.now()
+}
+
+// remove deletes a metric and reports whether it existed
+func (r *registry) remove(kind, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.modified[kind+"/"+name]; !ok {
+		return false
+	}
+	delete(r.modified, kind+"/"+name)
+	if kind == kindCounters {
+		delete(r.counters, name)
+	} else {
+		delete(r.timers, name)
+	}
+	return true
+}
+
+func (r *registry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = make(map[string]*counter)
+	r.timers = make(map[string]*timer)
+	r.modified = make(map[string]time.Time)
+}
+
+// names returns the sorted names of the metrics of a kind
+func (r *registry) names(kind string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	if kind == kindCounters {
+		for name := range r.counters {
+			names = append(names, name)
+		}
+	} else {
+		for name := range r.timers {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lastModified returns the last update of a metric, ok is false if it does not exist
+func (r *registry) lastModified(kind, name string) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.modified[kind+"/"+name]
+	return t, ok
+}
+
+func (r *registry) counterStats(name string) (CounterStats, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		return CounterStats{}, false
+	}
+	return c.stats(r.now()), true
+}
+
+func (c *counter) stats(now time.Time) CounterStats {
+	return CounterStats{
+		Value:   c.value,
+		Rate1m:  c.rate(time.Minute, now),
+		Rate5m:  c.rate(5*time.Minute, now),
+		Rate15m: c.rate(15*time.Minute, now),
+	}
+}
+
+func (r *registry) timerStats(name string) (TimerStats, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.timers[name]
+	if !ok {
+		return TimerStats{}, false
+	}
+	return t.stats(), true
+}
+
+func (t *timer) stats() TimerStats {
+	s := TimerStats{Count: t.count, Sum: t.sum, Min: t.min, Max: t.max}
+	if t.count == 0 {
+		return s
+	}
+	s.Mean = t.sum / float64(t.count)
+	sorted := append([]float64(nil), t.samples...)
+	sort.Float64s(sorted)
+	s.P50 = percentile(sorted, 0.5)
+	s.P90 = percentile(sorted, 0.9)
+	s.P99 = percentile(sorted, 0.99)
+	return s
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+func (r *registry) snapshot() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	s := Stats{Counters: make(map[string]CounterStats, len(r.counters)), Timers: make(map[string]TimerStats, len(r.timers))}
+	for name, c := range r.counters {
+		s.Counters[name] = c.stats(now)
+	}
+	for name, t := range r.timers {
+		s.Timers[name] = t.stats()
+	}
+	return s
+}
+
+// prometheus renders the metrics in the Prometheus text exposition format
+// Metric names become labels, so they need no escaping beyond label values
+func (s Stats) prometheus(prefix string) []byte {
+	var b strings.Builder
+	if len(s.Counters) > 0 {
+		fmt.Fprintf(&b, "# HELP %scounter Counters written to statsfs\n# TYPE %scounter counter\n", prefix, prefix)
+		for _, name := range sortedKeys(s.Counters) {
+			fmt.Fprintf(&b, "%scounter{name=%s} %s\n", prefix, strconv.Quote(name), formatFloat(s.Counters[name].Value))
+		}
+	}
+	if len(s.Timers) > 0 {
+		fmt.Fprintf(&b, "# HELP %stimer_seconds Durations written to statsfs\n# TYPE %stimer_seconds summary\n", prefix, prefix)
+		for _, name := range sortedKeys(s.Timers) {
+			t := s.Timers[name]
+			label := strconv.Quote(name)
+			for _, q := range []struct {
+				quantile string
+				ms       float64
+			}{{"0.5", t.P50}, {"0.9", t.P90}, {"0.99", t.P99}} {
+				fmt.Fprintf(&b, "%stimer_seconds{name=%s,quantile=%q} %s\n", prefix, label, q.quantile, formatFloat(q.ms/1000))
+			}
+			fmt.Fprintf(&b, "%stimer_seconds_sum{name=%s} %s\n", prefix, label, formatFloat(t.Sum/1000))
+			fmt.Fprintf(&b, "%stimer_seconds_count{name=%s} %d\n", prefix, label, t.Count)
+		}
+	}
+	return []byte(b.String())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,403 @@
+package statsfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "statsfs"
+
+	// DefaultMaxSamples is the number of recent durations timer percentiles are computed from
+	DefaultMaxSamples = 1024
+
+	// DefaultPrefix is prepended to the Prometheus metric names
+	DefaultPrefix = "statsfs_"
+)
+
+// Metric kinds, the directories holding them
+const (
+	kindCounters = "counters"
+	kindTimers   = "timers"
+)
+
+// Files at the root of the mount
+const (
+	fileReadme  = "README"
+	fileStats   = "stats.json"
+	fileMetrics = "metrics"
+	fileReset   = "reset"
+)
+
+// validName restricts metric names to what statsd clients use
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,200}$`)
+
+// validPrefix accepts the prefixes that keep Prometheus metric names valid
+var validPrefix = regexp.MustCompile(`^[a-zA-Z_:]*$`)
+
+// StatsFSPlugin aggregates counters and timers written as files
+type StatsFSPlugin struct {
+	fs *statsFS
+}
+
+// NewStatsFSPlugin creates a new StatsFS plugin
+func NewStatsFSPlugin() *StatsFSPlugin {
+	return &StatsFSPlugin{}
+}
+
+func (p *StatsFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *StatsFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"max_samples", "prefix", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "max_samples"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "max_samples", DefaultMaxSamples) < 1 {
+		return fmt.Errorf("max_samples must be at least 1")
+	}
+	if err := config.ValidateStringType(cfg, "prefix"); err != nil {
+		return err
+	}
+	if prefix := config.GetStringConfig(cfg, "prefix", DefaultPrefix); !validPrefix.MatchString(prefix) {
+		return fmt.Errorf("prefix must consist of letters, underscores and colons: %s", prefix)
+	}
+	return nil
+}
+
+func (p *StatsFSPlugin) Initialize(cfg map[string]interface{}) error {
+	p.fs = &statsFS{
+		metrics: newRegistry(config.GetIntConfig(cfg, "max_samples", DefaultMaxSamples)),
+		prefix:  config.GetStringConfig(cfg, "prefix", DefaultPrefix),
+		started: time.Now(),
+	}
+	return nil
+}
+
+func (p *StatsFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *StatsFSPlugin) GetReadme() string {
+	return `StatsFS Plugin - File-based statsd
+
+Aggregates counters and timers in memory, so shell scripts and agents
+can report metrics by writing files.
+
+STRUCTURE:
+  /counters/<name>  - Write a number to add it (empty adds 1), read the total
+  /timers/<name>    - Write durations to record them, read the rollup (JSON)
+  /stats.json       - Rollups of every metric (JSON)
+  /metrics          - Every metric in the Prometheus text format
+  /reset            - Write anything to drop every metric
+  /README           - This file
+
+Writes may hold several values separated by whitespace or newlines.
+Durations are milliseconds, or Go durations such as 1.5s or 250us.
+Counter rollups are the total and the rates per second over the last
+1, 5 and 15 minutes; timer rollups are count, sum, min, max and mean
+over all samples and p50/p90/p99 over the most recent max_samples.
+Names may use letters, digits and _ . : -
+
+CONFIGURATION:
+  max_samples  - Recent durations kept per timer (default: 1024)
+  prefix       - Prefix of the Prometheus metric names (default: statsfs_)
+
+EXAMPLES:
+  agfs:/> echo > /statsfs/counters/jobs.done
+  agfs:/> echo 5 > /statsfs/counters/bytes.sent
+  agfs:/> echo 125 > /statsfs/timers/build
+  agfs:/> echo 1.5s > /statsfs/timers/build
+  agfs:/> cat /statsfs/timers/build
+  agfs:/> cat /statsfs/metrics
+`
+}
+
+func (p *StatsFSPlugin) Shutdown() error {
+	return nil
+}
+
+// statsFS implements the FileSystem interface over the metrics
+type statsFS struct {
+	metrics *registry
+	prefix  string
+	started time.Time
+}
+
+// split returns the kind and name of a metric path; name is empty for a kind directory
+func split(p string) (kind, name string, ok bool) {
+	rel := strings.TrimPrefix(filesystem.NormalizePath(p), "/")
+	kind, name, _ = strings.Cut(rel, "/")
+	if kind != kindCounters && kind != kindTimers {
+		return "", "", false
+	}
+	return kind, name, true
+}
+
+func checkName(name string) error {
+	if !validName.MatchString(name) {
+		return filesystem.NewInvalidArgumentError("name", name, "metric names may use letters, digits and _ . : -")
+	}
+	return nil
+}
+
+// parseValues parses the whitespace separated values of a write
+func parseValues(kind string, data []byte) ([]float64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		if kind == kindCounters {
+			return []float64{1}, nil
+		}
+		return nil, filesystem.NewInvalidArgumentError("value", "", "a duration is required")
+	}
+	values := make([]float64, 0, len(fields))
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil && kind == kindTimers {
+			var d time.Duration
+			if d, err = time.ParseDuration(f); err == nil {
+				v = float64(d) / float64(time.Millisecond)
+			}
+		}
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, filesystem.NewInvalidArgumentError("value", f, "expected a number")
+		}
+		if kind == kindTimers && v < 0 {
+			return nil, filesystem.NewInvalidArgumentError("value", f, "durations must not be negative")
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// content returns the content of a file
+func (fs *statsFS) content(p string) ([]byte, error) {
+	rel := filesystem.NormalizePath(p)
+	switch rel {
+	case "/" + fileReadme:
+		return []byte((&StatsFSPlugin{}).GetReadme()), nil
+	case "/" + fileReset:
+		return []byte{}, nil
+	case "/" + fileStats:
+		return marshal(fs.metrics.snapshot())
+	case "/" + fileMetrics:
+		return fs.metrics.snapshot().prometheus(fs.prefix), nil
+	}
+
+	kind, name, ok := split(rel)
+	if !ok || name == "" {
+		return nil, filesystem.NewNotFoundError("read", rel)
+	}
+	if kind == kindCounters {
+		stats, ok := fs.metrics.counterStats(name)
+		if !ok {
+			return nil, filesystem.NewNotFoundError("read", rel)
+		}
+		return []byte(formatFloat(stats.Value) + "\n"), nil
+	}
+	stats, ok := fs.metrics.timerStats(name)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", rel)
+	}
+	return marshal(stats)
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (fs *statsFS) Create(p string) error {
+	kind, name, ok := split(p)
+	if !ok || name == "" {
+		return filesystem.NewPermissionDeniedError("create", p, "metrics are created in /counters and /timers")
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, exists := fs.metrics.lastModified(kind, name); exists {
+		return filesystem.NewAlreadyExistsError("file", p)
+	}
+	if kind == kindCounters {
+		fs.metrics.addCounter(name, nil)
+	} else {
+		fs.metrics.addTimer(name, nil)
+	}
+	return nil
+}
+
+func (fs *statsFS) Mkdir(p string, perm uint32) error {
+	return filesystem.NewNotSupportedError("mkdir", p)
+}
+
+func (fs *statsFS) Remove(p string) error {
+	kind, name, ok := split(p)
+	if !ok || name == "" {
+		return filesystem.NewPermissionDeniedError("remove", p, "only metrics can be removed")
+	}
+	if !fs.metrics.remove(kind, name) {
+		return filesystem.NewNotFoundError("remove", p)
+	}
+	return nil
+}
+
+// RemoveAll removes a metric, or every metric of a kind
+func (fs *statsFS) RemoveAll(p string) error {
+	kind, name, ok := split(p)
+	if !ok {
+		return filesystem.NewPermissionDeniedError("remove", p, "only metrics can be removed")
+	}
+	if name != "" {
+		return fs.Remove(p)
+	}
+	for _, name := range fs.metrics.names(kind) {
+		fs.metrics.remove(kind, name)
+	}
+	return nil
+}
+
+func (fs *statsFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *statsFS) Write(p string, data []byte) ([]byte, error) {
+	if filesystem.NormalizePath(p) == "/"+fileReset {
+		fs.metrics.reset()
+		return []byte("metrics reset\n"), nil
+	}
+	kind, name, ok := split(p)
+	if !ok || name == "" {
+		return nil, filesystem.NewPermissionDeniedError("write", p, "metrics are written in /counters and /timers")
+	}
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	values, err := parseValues(kind, data)
+	if err != nil {
+		return nil, err
+	}
+	if kind == kindCounters {
+		return []byte(formatFloat(fs.metrics.addCounter(name, values)) + "\n"), nil
+	}
+	fs.metrics.addTimer(name, values)
+	return nil, nil
+}
+
+func (fs *statsFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		var infos []filesystem.FileInfo
+		for _, name := range []string{kindCounters, kindTimers, fileReadme, fileMetrics, fileReset, fileStats} {
+			info, err := fs.Stat("/" + name)
+			if err != nil {
+				return nil, err
+			}
+			infos = append(infos, *info)
+		}
+		return infos, nil
+	}
+	kind, name, ok := split(rel)
+	if !ok {
+		if _, err := fs.Stat(rel); err == nil {
+			return nil, filesystem.NewNotDirectoryError(rel)
+		}
+		return nil, filesystem.NewNotFoundError("readdir", rel)
+	}
+	if name != "" {
+		return nil, filesystem.NewNotDirectoryError(rel)
+	}
+	infos := []filesystem.FileInfo{}
+	for _, name := range fs.metrics.names(kind) {
+		if info, err := fs.Stat(path.Join(rel, name)); err == nil {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+func (fs *statsFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	dir := func(name string) *filesystem.FileInfo {
+		return &filesystem.FileInfo{Name: name, Mode: 0755, ModTime: fs.started, IsDir: true, Meta: filesystem.MetaData{Name: PluginName}}
+	}
+	if rel == "/" {
+		return dir("/"), nil
+	}
+
+	kind, name, ok := split(rel)
+	if ok && name == "" {
+		return dir(kind), nil
+	}
+	mode := uint32(0444)
+	modTime := time.Now()
+	switch {
+	case ok:
+		if strings.Contains(name, "/") {
+			return nil, filesystem.NewNotFoundError("stat", rel)
+		}
+		var exists bool
+		if modTime, exists = fs.metrics.lastModified(kind, name); !exists {
+			return nil, filesystem.NewNotFoundError("stat", rel)
+		}
+		mode = 0644
+	case rel == "/"+fileReset:
+		mode = 0222
+	}
+	data, err := fs.content(rel)
+	if err != nil {
+		return nil, err
+	}
+	return &filesystem.FileInfo{
+		Name:    path.Base(rel),
+		Size:    int64(len(data)),
+		Mode:    mode,
+		ModTime: modTime,
+		Meta:    filesystem.MetaData{Name: PluginName},
+	}, nil
+}
+
+func (fs *statsFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *statsFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *statsFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (fs *statsFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(p, fs.Write), nil
+}
+
+// Ensure StatsFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*StatsFSPlugin)(nil)
+var _ filesystem.FileSystem = (*statsFS)(nil)
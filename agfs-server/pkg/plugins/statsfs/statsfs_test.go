@@ -0,0 +1,98 @@
+package statsfs
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) *statsFS {
+	t.Helper()
+	p := NewStatsFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return p.fs
+}
+
+func read(t *testing.T, fs *statsFS, p string) string {
+	t.Helper()
+	data, err := fs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCounters(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{})
+	now := time.Unix(1000000, 0)
+	fs.metrics.now = func() time.Time { return now }
+
+	for _, data := range []string{"", "5\n", "1 2\n3"} {
+		if _, err := fs.Write("/counters/jobs.done", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := fs.Write("/counters/jobs.done", []byte("-2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, fs, "/counters/jobs.done"); got != "10\n" {
+		t.Errorf("counter = %q", got)
+	}
+	stats, _ := fs.metrics.counterStats("jobs.done")
+	if stats.Rate1m != -2.0/60 || stats.Rate5m != 10.0/300 {
+		t.Errorf("rates = %+v", stats)
+	}
+
+	for _, bad := range []string{"/counters/a b", "/counters/x/y", "/gauges/x"} {
+		if _, err := fs.Write(bad, []byte("1")); err == nil {
+			t.Errorf("write %s succeeded", bad)
+		}
+	}
+	if _, err := fs.Write("/counters/x", []byte("abc")); err == nil {
+		t.Errorf("non-numeric write succeeded")
+	}
+}
+
+func TestTimers(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{"max_samples": 4})
+	if _, err := fs.Write("/timers/build", []byte("1000 1s\n2s 100ms 300 400")); err != nil {
+		t.Fatal(err)
+	}
+	var stats TimerStats
+	if err := json.Unmarshal([]byte(read(t, fs, "/timers/build")), &stats); err != nil {
+		t.Fatal(err)
+	}
+	// Percentiles cover the last 4 samples: 100, 300, 400 and 2000
+	if stats.Count != 6 || stats.Min != 100 || stats.Max != 2000 || stats.Sum != 4800 || stats.P50 != 300 || stats.P99 != 2000 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if _, err := fs.Write("/timers/build", []byte("-1")); err == nil {
+		t.Errorf("negative duration accepted")
+	}
+
+	metrics := read(t, fs, "/metrics")
+	for _, want := range []string{
+		"# TYPE statsfs_timer_seconds summary\n",
+		`statsfs_timer_seconds{name="build",quantile="0.5"} 0.3` + "\n",
+		`statsfs_timer_seconds_count{name="build"} 6` + "\n",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, metrics)
+		}
+	}
+
+	if err := fs.Remove("/timers/build"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := fs.ReadDir("/timers"); err != nil || len(entries) != 0 {
+		t.Errorf("timers after remove = %v, %v", entries, err)
+	}
+}
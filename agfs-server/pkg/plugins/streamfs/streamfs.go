@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-server/pkg/bufpool"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
@@ -72,7 +73,7 @@ func formatSize(bytes int64) string {
 // Reader represents a single reader with its channel and metadata
 type Reader struct {
 	id           string
-	ch           chan []byte
+	ch           chan *bufpool.Chunk // Each queued chunk holds a reference for this reader
 	registered   time.Time
 	droppedCount int64 // Number of chunks dropped due to slow consumption
 	readIndex    int64 // Index of next chunk to read from ringBuffer (int64 to prevent overflow)
@@ -82,20 +83,36 @@ type Reader struct {
 type streamReader struct {
 	sf       *StreamFile
 	readerID string
-	ch       <-chan []byte
+	ch       <-chan *bufpool.Chunk
+	current  *bufpool.Chunk // Chunk returned by the last ReadChunk, released by the next one
 }
 
 // ReadChunk implements filesystem.StreamReader
+// The returned data is shared with the other readers and recycled on the next call
 func (sr *streamReader) ReadChunk(timeout time.Duration) ([]byte, bool, error) {
-	return sr.sf.ReadChunk(sr.readerID, sr.ch, timeout)
+	sr.releaseCurrent()
+	chunk, eof, err := sr.sf.ReadChunk(sr.readerID, sr.ch, timeout)
+	if chunk == nil {
+		return nil, eof, err
+	}
+	sr.current = chunk
+	return chunk.Bytes(), eof, err
 }
 
 // Close implements filesystem.StreamReader
 func (sr *streamReader) Close() error {
+	sr.releaseCurrent()
 	sr.sf.UnregisterReader(sr.readerID)
 	return nil
 }
 
+func (sr *streamReader) releaseCurrent() {
+	if sr.current != nil {
+		sr.current.Release()
+		sr.current = nil
+	}
+}
+
 // StreamFile represents a streaming file that supports multiple readers and writers
 type StreamFile struct {
 	name          string
@@ -108,10 +125,10 @@ type StreamFile struct {
 	channelBuffer int                // Buffer size for each reader channel
 
 	// Ring buffer for storing recent chunks (even when no readers)
-	ringBuffer  [][]byte // Circular buffer for recent chunks
-	ringSize    int      // Max number of chunks to keep
-	writeIndex  int64    // Current write position in ring buffer (int64 to prevent overflow)
-	totalChunks int64    // Total chunks written (for readIndex tracking)
+	ringBuffer  []*bufpool.Chunk // Circular buffer for recent chunks, each holding a reference
+	ringSize    int              // Max number of chunks to keep
+	writeIndex  int64            // Current write position in ring buffer (int64 to prevent overflow)
+	totalChunks int64            // Total chunks written (for readIndex tracking)
 }
 
 // NewStreamFile creates a new stream file
@@ -128,7 +145,7 @@ func NewStreamFile(name string, channelBuffer int, ringSize int) *StreamFile {
 		readers:       make(map[string]*Reader),
 		nextReaderID:  0,
 		channelBuffer: channelBuffer,
-		ringBuffer:    make([][]byte, ringSize),
+		ringBuffer:    make([]*bufpool.Chunk, ringSize),
 		ringSize:      ringSize,
 		writeIndex:    0,
 		totalChunks:   0,
@@ -138,7 +155,7 @@ func NewStreamFile(name string, channelBuffer int, ringSize int) *StreamFile {
 
 // RegisterReader registers a new reader and returns reader ID and channel
 // New readers will receive ALL available historical data from ring buffer
-func (sf *StreamFile) RegisterReader() (string, <-chan []byte) {
+func (sf *StreamFile) RegisterReader() (string, <-chan *bufpool.Chunk) {
 	sf.mu.Lock()
 	defer sf.mu.Unlock()
 
@@ -154,7 +171,7 @@ func (sf *StreamFile) RegisterReader() (string, <-chan []byte) {
 	// New readers start from the beginning of available history
 	reader := &Reader{
 		id:           readerID,
-		ch:           make(chan []byte, sf.channelBuffer),
+		ch:           make(chan *bufpool.Chunk, sf.channelBuffer),
 		registered:   time.Now(),
 		droppedCount: 0,
 		readIndex:    historyStart, // Start from oldest available data
@@ -175,6 +192,11 @@ func (sf *StreamFile) sendHistoricalData(reader *Reader) {
 	sf.mu.RLock()
 	defer sf.mu.RUnlock()
 
+	// The reader may have been unregistered, and its channel closed, before this ran
+	if _, ok := sf.readers[reader.id]; !ok {
+		return
+	}
+
 	// Calculate how many historical chunks are available
 	historyStart := sf.totalChunks - int64(sf.ringSize)
 	if historyStart < 0 {
@@ -189,12 +211,14 @@ func (sf *StreamFile) sendHistoricalData(reader *Reader) {
 		// Send available historical chunks
 		for i := historyStart; i < sf.totalChunks; i++ {
 			ringIdx := int(i % int64(sf.ringSize))
-			if sf.ringBuffer[ringIdx] != nil {
+			if chunk := sf.ringBuffer[ringIdx]; chunk != nil {
+				chunk.Retain()
 				select {
-				case reader.ch <- sf.ringBuffer[ringIdx]:
+				case reader.ch <- chunk:
 					// Sent successfully
 				default:
 					// Channel full, will catch up with live data
+					chunk.Release()
 					log.Warnf("[streamfs] Reader %s channel full during historical data send", reader.id)
 					return
 				}
@@ -210,63 +234,70 @@ func (sf *StreamFile) UnregisterReader(readerID string) {
 
 	if reader, exists := sf.readers[readerID]; exists {
 		close(reader.ch)
+		releaseQueued(reader.ch)
 		delete(sf.readers, readerID)
 		log.Infof("[streamfs] Unregistered reader %s for stream %s (dropped: %d chunks, total readers: %d)",
 			readerID, sf.name, reader.droppedCount, len(sf.readers))
 	}
 }
 
+// releaseQueued releases the chunks left in a closed reader channel
+func releaseQueued(ch chan *bufpool.Chunk) {
+	for chunk := range ch {
+		chunk.Release()
+	}
+}
+
 // Write appends data to the stream and fanout to all readers
+// The data is copied once into a pooled chunk shared by the ring buffer and all readers
 func (sf *StreamFile) Write(data []byte) error {
 	sf.mu.Lock()
+	defer sf.mu.Unlock()
 
 	if sf.closed {
-		sf.mu.Unlock()
 		return fmt.Errorf("stream is closed")
 	}
 
-	// Copy data to avoid external modification
-	chunk := make([]byte, len(data))
-	copy(chunk, data)
+	// Copy data to avoid external modification; the ring buffer holds the initial reference
+	chunk := bufpool.NewChunk(data)
 
 	sf.offset += int64(len(data))
 	sf.modTime = time.Now()
 
 	// Store in ring buffer (always, even if no readers)
 	ringIdx := int(sf.writeIndex % int64(sf.ringSize))
+	if old := sf.ringBuffer[ringIdx]; old != nil {
+		old.Release()
+	}
 	sf.ringBuffer[ringIdx] = chunk
 	sf.writeIndex++
 	sf.totalChunks++
 
-	// Take a snapshot of all reader channels to avoid holding lock during send
-	readerSnapshot := make([]*Reader, 0, len(sf.readers))
-	for _, reader := range sf.readers {
-		readerSnapshot = append(readerSnapshot, reader)
-	}
-
-	sf.mu.Unlock()
-
 	// Fanout to all readers (non-blocking)
+	// Sends happen under the lock so that a reader cannot be unregistered, and its
+	// channel closed, while a chunk is being sent to it
 	successCount := 0
 	dropCount := 0
-	for _, reader := range readerSnapshot {
+	for _, reader := range sf.readers {
+		chunk.Retain()
 		select {
 		case reader.ch <- chunk:
 			successCount++
 		default:
 			// Channel is full - slow consumer, drop the chunk
+			chunk.Release()
 			reader.droppedCount++
 			dropCount++
 			log.Warnf("[streamfs] Reader %s is slow, dropped chunk (total dropped: %d)", reader.id, reader.droppedCount)
 		}
 	}
 
-	if len(readerSnapshot) == 0 {
+	if len(sf.readers) == 0 {
 		log.Debugf("[streamfs] Buffered %d bytes to ring (no readers, total chunks: %d)",
 			len(data), sf.totalChunks)
 	} else {
 		log.Debugf("[streamfs] Fanout %d bytes to %d readers (success: %d, dropped: %d, total chunks: %d)",
-			len(data), len(readerSnapshot), successCount, dropCount, sf.totalChunks)
+			len(data), len(sf.readers), successCount, dropCount, sf.totalChunks)
 	}
 
 	return nil
@@ -275,14 +306,15 @@ func (sf *StreamFile) Write(data []byte) error {
 // ReadChunk reads data from a reader's channel (blocking with timeout)
 // Returns (data, eof, error)
 // This method should be called after RegisterReader
-func (sf *StreamFile) ReadChunk(readerID string, ch <-chan []byte, timeout time.Duration) ([]byte, bool, error) {
+// The caller owns the reference of the returned chunk and must release it
+func (sf *StreamFile) ReadChunk(readerID string, ch <-chan *bufpool.Chunk, timeout time.Duration) (*bufpool.Chunk, bool, error) {
 	select {
-	case data, ok := <-ch:
+	case chunk, ok := <-ch:
 		if !ok {
 			// Channel closed - stream is closed or reader was unregistered
 			return nil, true, io.EOF
 		}
-		return data, false, nil
+		return chunk, false, nil
 	case <-time.After(timeout):
 		// Check if stream is closed
 		sf.mu.RLock()
@@ -303,7 +335,7 @@ func (sf *StreamFile) Close() error {
 
 	sf.closed = true
 
-	// Close all reader channels
+	// Close all reader channels; readers get EOF once they have consumed what is queued
 	for id, reader := range sf.readers {
 		close(reader.ch)
 		log.Infof("[streamfs] Closed reader %s for stream %s (dropped: %d chunks)", id, sf.name, reader.droppedCount)
@@ -637,6 +669,14 @@ func (p *StreamFSPlugin) Validate(cfg map[string]interface{}) error {
 	return nil
 }
 
+// ConfigSchema implements plugin.ConfigSchemaProvider
+func (p *StreamFSPlugin) ConfigSchema() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "channel_buffer_size", Type: plugin.ParamSize, Default: "6MB", Description: "Buffer of each reader"},
+		{Name: "ring_buffer_size", Type: plugin.ParamSize, Default: "6MB", Description: "Recent data kept for readers joining a stream"},
+	}
+}
+
 func (p *StreamFSPlugin) Initialize(config map[string]interface{}) error {
 	const defaultChunkSize = 64 * 1024 // 64KB per chunk
 
@@ -720,6 +760,8 @@ ARCHITECTURE:
   - Each stream maintains a ring buffer of recent chunks (default: last 1000 chunks)
   - New readers automatically receive all available historical data from ring buffer
   - Writers fanout data to all active readers via buffered channels
+  - Each written chunk is copied once into a pooled buffer shared by the ring buffer
+    and all readers; the buffer is recycled when the last of them releases it
   - Readers wait indefinitely for new data (30s check interval, but never disconnect)
   - Slow readers may drop chunks if their channel buffer fills up
 
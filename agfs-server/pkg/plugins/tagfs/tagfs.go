@@ -0,0 +1,418 @@
+package tagfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+)
+
+const (
+	PluginName = "tagfs"
+
+	// MetaValueLink marks an entry that refers to a tagged path on another mount
+	MetaValueLink = "link"
+
+	// MetaKeyTarget holds the path a link entry refers to
+	MetaKeyTarget = "target"
+)
+
+// TagFSPlugin exposes tagged paths as /<tag>/<name> entries that refer to the tagged files
+type TagFSPlugin struct {
+	rootFS filesystem.FileSystem
+}
+
+// NewTagFSPlugin creates a new TagFS plugin
+func NewTagFSPlugin() *TagFSPlugin {
+	return &TagFSPlugin{}
+}
+
+func (p *TagFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *TagFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"mount_path"}
+	return config.ValidateOnlyKnownKeys(cfg, allowedKeys)
+}
+
+// InitializeContext takes the root filesystem used to resolve tagged paths from ictx
+func (p *TagFSPlugin) InitializeContext(ictx *plugin.InitContext) error {
+	p.rootFS = ictx.RootFS
+	return nil
+}
+
+// Initialize leaves the plugin without a root filesystem, so tagged paths cannot be resolved
+func (p *TagFSPlugin) Initialize(cfg map[string]interface{}) error {
+	return p.InitializeContext(plugin.LegacyInitContext(PluginName, cfg))
+}
+
+func (p *TagFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &tagFS{plugin: p}
+}
+
+func (p *TagFSPlugin) GetReadme() string {
+	return `TagFS Plugin - Virtual Views by Tag
+
+Lists tagged files from every mount, grouped by tag, without moving data.
+Tags are managed with the /api/v1/tags API.
+
+STRUCTURE:
+  /README              - This file
+  /<tag>/              - One directory per tag in use
+  /<tag>/<name>        - Entry referring to a tagged file or directory
+
+Entries behave like symlinks: reading, writing and listing an entry goes to
+the tagged path, whose full path is in the "target" metadata of Stat.
+Entries are named after the base name of the tagged path; duplicates get a
+"~2", "~3", ... suffix.
+
+OPERATIONS:
+  rm /tagfs/<tag>/<name>   Remove the tag from the file (the file is kept)
+  rm -r /tagfs/<tag>       Remove the tag from every file
+
+EXAMPLES:
+  $ curl -X POST "http://localhost:8080/api/v1/tags?path=/s3fs/q3.pdf&tag=finance,2024"
+  agfs:/> ls /tagfs/finance
+  q3.pdf
+  agfs:/> cat /tagfs/finance/q3.pdf
+`
+}
+
+func (p *TagFSPlugin) Shutdown() error {
+	return nil
+}
+
+// tagFS implements the FileSystem interface over the tag store
+type tagFS struct {
+	plugin *TagFSPlugin
+}
+
+// link is an entry of a tag directory
+type link struct {
+	name   string
+	target string
+}
+
+func (fs *tagFS) store() (*tags.Store, error) {
+	store := tags.Default()
+	if store == nil {
+		return nil, fmt.Errorf("tagging is not enabled")
+	}
+	if fs.plugin.rootFS == nil {
+		return nil, fmt.Errorf("tagfs is not mounted")
+	}
+	return store, nil
+}
+
+// links returns the entries of the tag directory, named uniquely after their targets
+func (fs *tagFS) links(tag string) ([]link, error) {
+	store, err := fs.store()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := store.Paths(tag)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool, len(paths))
+	result := make([]link, 0, len(paths))
+	for _, p := range paths {
+		name := path.Base(p)
+		if name == "/" {
+			name = "root"
+		}
+		for i := 2; used[name]; i++ {
+			name = path.Base(p) + "~" + strconv.Itoa(i)
+		}
+		used[name] = true
+		result = append(result, link{name: name, target: p})
+	}
+	return result, nil
+}
+
+// split breaks a path into tag, entry name and the remainder below the entry
+func split(p string) (tag, name, rest string) {
+	parts := strings.SplitN(strings.TrimPrefix(filesystem.NormalizePath(p), "/"), "/", 3)
+	tag = parts[0]
+	if len(parts) > 1 {
+		name = parts[1]
+	}
+	if len(parts) > 2 {
+		rest = parts[2]
+	}
+	return tag, name, rest
+}
+
+// resolve maps a path inside an entry to the path on the root filesystem
+func (fs *tagFS) resolve(p string) (string, error) {
+	tag, name, rest := split(p)
+	if tag == "" || name == "" {
+		return "", filesystem.NewPermissionDeniedError("resolve", p, "not a tagged entry")
+	}
+	links, err := fs.links(tag)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range links {
+		if l.name == name {
+			if rest == "" {
+				return l.target, nil
+			}
+			return path.Join(l.target, rest), nil
+		}
+	}
+	return "", filesystem.NewNotFoundError("resolve", p)
+}
+
+func dirInfo(name string) *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    name,
+		Mode:    0755,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName},
+	}
+}
+
+// linkInfo returns the info of the link target, renamed to the entry name
+func (fs *tagFS) linkInfo(l link) (*filesystem.FileInfo, error) {
+	info, err := fs.plugin.rootFS.Stat(l.target)
+	if err != nil {
+		return nil, err
+	}
+	linked := *info
+	linked.Name = l.name
+	content := map[string]string{MetaKeyTarget: l.target}
+	for k, v := range info.Meta.Content {
+		content[k] = v
+	}
+	linked.Meta = filesystem.MetaData{Name: PluginName, Type: MetaValueLink, Content: content}
+	return &linked, nil
+}
+
+func (fs *tagFS) Stat(p string) (*filesystem.FileInfo, error) {
+	tag, name, rest := split(p)
+	switch {
+	case tag == "":
+		return dirInfo("/"), nil
+	case tag == "README" && name == "":
+		return &filesystem.FileInfo{
+			Name:    "README",
+			Size:    int64(len(fs.plugin.GetReadme())),
+			Mode:    0444,
+			ModTime: time.Now(),
+			Meta:    filesystem.MetaData{Name: PluginName, Type: "doc"},
+		}, nil
+	case name == "":
+		links, err := fs.links(tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(links) == 0 {
+			return nil, filesystem.NewNotFoundError("stat", p)
+		}
+		return dirInfo(tag), nil
+	case rest == "":
+		links, err := fs.links(tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range links {
+			if l.name == name {
+				return fs.linkInfo(l)
+			}
+		}
+		return nil, filesystem.NewNotFoundError("stat", p)
+	default:
+		target, err := fs.resolve(p)
+		if err != nil {
+			return nil, err
+		}
+		return fs.plugin.rootFS.Stat(target)
+	}
+}
+
+func (fs *tagFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	tag, name, _ := split(p)
+	if tag == "README" && name == "" {
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+
+	if tag == "" {
+		store, err := fs.store()
+		if err != nil {
+			return nil, err
+		}
+		counts, err := store.List()
+		if err != nil {
+			return nil, err
+		}
+		readme, _ := fs.Stat("/README")
+		result := []filesystem.FileInfo{*readme}
+		for _, tc := range counts {
+			result = append(result, *dirInfo(tc.Tag))
+		}
+		return result, nil
+	}
+
+	if name == "" {
+		links, err := fs.links(tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(links) == 0 {
+			return nil, filesystem.NewNotFoundError("readdir", p)
+		}
+		result := make([]filesystem.FileInfo, 0, len(links))
+		for _, l := range links {
+			info, err := fs.linkInfo(l)
+			if err != nil {
+				// The target is unreachable, e.g. its mount is gone
+				continue
+			}
+			result = append(result, *info)
+		}
+		return result, nil
+	}
+
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.ReadDir(target)
+}
+
+func (fs *tagFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if tag, name, _ := split(p); tag == "README" && name == "" {
+		return plugin.ApplyRangeRead([]byte(fs.plugin.GetReadme()), offset, size)
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.Read(target, offset, size)
+}
+
+func (fs *tagFS) Open(p string) (io.ReadCloser, error) {
+	if tag, name, _ := split(p); tag == "README" && name == "" {
+		return io.NopCloser(strings.NewReader(fs.plugin.GetReadme())), nil
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.Open(target)
+}
+
+func (fs *tagFS) Write(p string, data []byte) ([]byte, error) {
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.Write(target, data)
+}
+
+func (fs *tagFS) OpenWrite(p string) (io.WriteCloser, error) {
+	target, err := fs.resolve(p)
+	if err != nil {
+		return nil, err
+	}
+	return fs.plugin.rootFS.OpenWrite(target)
+}
+
+// resolveParent resolves a path to be created inside a tagged directory
+func (fs *tagFS) resolveParent(op, p string) (string, error) {
+	if _, _, rest := split(p); rest == "" {
+		return "", filesystem.NewPermissionDeniedError(op, p, "tag directories only hold tagged entries, use the tags API")
+	}
+	parent, err := fs.resolve(path.Dir(filesystem.NormalizePath(p)))
+	if err != nil {
+		return "", err
+	}
+	return path.Join(parent, path.Base(p)), nil
+}
+
+func (fs *tagFS) Create(p string) error {
+	target, err := fs.resolveParent("create", p)
+	if err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Create(target)
+}
+
+func (fs *tagFS) Mkdir(p string, perm uint32) error {
+	target, err := fs.resolveParent("mkdir", p)
+	if err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Mkdir(target, perm)
+}
+
+func (fs *tagFS) Chmod(p string, mode uint32) error {
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	return fs.plugin.rootFS.Chmod(target, mode)
+}
+
+// Remove untags an entry; below an entry it removes the file on its mount
+func (fs *tagFS) Remove(p string) error {
+	tag, name, rest := split(p)
+	if name == "" {
+		return filesystem.NewPermissionDeniedError("remove", p, "use rm -r to remove a tag from every file")
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if rest == "" {
+		store, err := fs.store()
+		if err != nil {
+			return err
+		}
+		return store.Remove(target, tag)
+	}
+	return fs.plugin.rootFS.Remove(target)
+}
+
+// RemoveAll removes a tag from every file, untags an entry, or removes a subtree below an entry
+func (fs *tagFS) RemoveAll(p string) error {
+	tag, name, rest := split(p)
+	if tag == "" || (tag == "README" && name == "") {
+		return filesystem.NewPermissionDeniedError("removeall", p, "not a tag")
+	}
+	store, err := fs.store()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return store.RemoveTag(tag)
+	}
+	target, err := fs.resolve(p)
+	if err != nil {
+		return err
+	}
+	if rest == "" {
+		return store.Remove(target, tag)
+	}
+	return fs.plugin.rootFS.RemoveAll(target)
+}
+
+func (fs *tagFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewPermissionDeniedError("rename", oldPath, "tagfs entries cannot be renamed, rename the tagged file instead")
+}
+
+// Ensure TagFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*TagFSPlugin)(nil)
+var _ plugin.ContextInitializer = (*TagFSPlugin)(nil)
+var _ filesystem.FileSystem = (*tagFS)(nil)
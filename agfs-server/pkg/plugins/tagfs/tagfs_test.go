@@ -0,0 +1,111 @@
+package tagfs
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/tags"
+)
+
+// Tag directories list the tagged paths as entries that read, write and list through to
+// their targets, and removing an entry only untags it
+func TestTagViews(t *testing.T) {
+	root := memfs.NewMemoryFS()
+	for _, dir := range []string{"/a", "/b", "/proj"} {
+		if err := root.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p, data := range map[string]string{"/a/q3.pdf": "A", "/b/q3.pdf": "B", "/proj/main.go": "package main"} {
+		if _, err := root.Write(p, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewTagFSPlugin()
+	fs := p.GetFileSystem()
+	if _, err := fs.ReadDir("/"); err == nil {
+		t.Error("readdir without a tag store: no error")
+	}
+	store, err := tags.Open(filepath.Join(t.TempDir(), "tags.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	tags.SetDefault(store)
+	defer tags.SetDefault(nil)
+	if _, err := fs.ReadDir("/"); err == nil {
+		t.Error("readdir before the plugin is mounted: no error")
+	}
+	if err := p.InitializeContext(&plugin.InitContext{Name: PluginName, RootFS: root}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/a/q3.pdf", "/b/q3.pdf", "/proj"} {
+		if err := store.Add(path, "finance"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := fs.ReadDir("/")
+	if err != nil || len(entries) != 2 || entries[0].Name != "README" || entries[1].Name != "finance" {
+		t.Fatalf("root = %+v, %v", entries, err)
+	}
+	entries, err = fs.ReadDir("/finance")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("/finance = %+v, %v", entries, err)
+	}
+	targets := make(map[string]string)
+	for _, e := range entries {
+		targets[e.Name] = e.Meta.Content[MetaKeyTarget]
+	}
+	if targets["q3.pdf"] != "/a/q3.pdf" || targets["q3.pdf~2"] != "/b/q3.pdf" || targets["proj"] != "/proj" {
+		t.Errorf("entries = %v", targets)
+	}
+
+	if data, err := fs.Read("/finance/q3.pdf~2", 0, -1); (err != nil && err != io.EOF) || string(data) != "B" {
+		t.Errorf("read entry = %q, %v", data, err)
+	}
+	if _, err := fs.Write("/finance/proj/go.mod", []byte("module x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/finance/proj/cmd", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := root.ReadDir("/proj"); err != nil || len(list) != 3 {
+		t.Errorf("/proj after writes through the entry = %+v, %v", list, err)
+	}
+	for name, err := range map[string]error{
+		"create in a tag":  fs.Create("/finance/new.txt"),
+		"mkdir a tag":      fs.Mkdir("/tax", 0755),
+		"rename":           fs.Rename("/finance/proj", "/finance/p"),
+		"remove a tag dir": fs.Remove("/finance"),
+	} {
+		if !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := fs.Stat("/finance/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("stat of a missing entry: %v", err)
+	}
+
+	if err := fs.Remove("/finance/q3.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Stat("/a/q3.pdf"); err != nil {
+		t.Errorf("untagged file: %v", err)
+	}
+	if paths, _ := store.Paths("finance"); len(paths) != 2 {
+		t.Errorf("finance paths after untagging = %v", paths)
+	}
+	if err := fs.RemoveAll("/finance"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/finance"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("stat of a removed tag: %v", err)
+	}
+}
@@ -0,0 +1,514 @@
+package tmpfs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "tmpfs" // Name of this plugin
+
+	// DefaultTTL is how long a top-level directory lives unless configured otherwise
+	DefaultTTL = time.Hour
+
+	// DefaultGCInterval is how often expired directories are removed
+	DefaultGCInterval = time.Minute
+
+	// TTLFile is the control file in each top-level directory
+	// Reading it returns the remaining lifetime, writing a duration sets it
+	TTLFile = ".ttl"
+
+	// MetaValueTTLControl identifies the TTL control file in Stat metadata
+	MetaValueTTLControl = "ttl-control"
+)
+
+// Keys added to the Stat metadata of top-level directories
+const (
+	MetaKeyExpiresAt = "expires_at" // RFC 3339 expiry time
+	MetaKeyTTL       = "ttl"        // Remaining lifetime, e.g. "59m30s"
+)
+
+// TmpFSPlugin is an in-memory filesystem whose top-level directories expire
+type TmpFSPlugin struct {
+	fs *tmpFS
+}
+
+// NewTmpFSPlugin creates a new TmpFS plugin
+func NewTmpFSPlugin() *TmpFSPlugin {
+	return &TmpFSPlugin{
+		fs: newTmpFS(DefaultTTL, false),
+	}
+}
+
+func (p *TmpFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *TmpFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"default_ttl", "gc_interval", "sliding", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if err := config.ValidateBoolType(cfg, "sliding"); err != nil {
+		return err
+	}
+	if _, err := config.GetDurationConfig(cfg, "default_ttl", DefaultTTL); err != nil {
+		return err
+	}
+	interval, err := config.GetDurationConfig(cfg, "gc_interval", DefaultGCInterval)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return fmt.Errorf("gc_interval must be positive")
+	}
+	return nil
+}
+
+func (p *TmpFSPlugin) Initialize(cfg map[string]interface{}) error {
+	ttl, err := config.GetDurationConfig(cfg, "default_ttl", DefaultTTL)
+	if err != nil {
+		return err
+	}
+	interval, err := config.GetDurationConfig(cfg, "gc_interval", DefaultGCInterval)
+	if err != nil {
+		return err
+	}
+
+	p.fs.defaultTTL = ttl
+	p.fs.sliding = config.GetBoolConfig(cfg, "sliding", false)
+
+	// Create README file, the only file allowed at the top level
+	_, _ = p.fs.MemoryFS.Write("/README", []byte(p.GetReadme()))
+	_ = p.fs.MemoryFS.Chmod("/README", 0444)
+
+	p.fs.startGC(interval)
+	return nil
+}
+
+func (p *TmpFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *TmpFSPlugin) GetReadme() string {
+	return `TmpFS Plugin - Expiring Scratch Space
+
+An in-memory filesystem where every top-level directory has a time to live.
+Expired directories are removed automatically with everything in them.
+
+CONFIGURATION:
+  default_ttl  - Lifetime of new top-level directories (default "1h")
+  gc_interval  - How often expired directories are removed (default "1m")
+  sliding      - Reset the lifetime on every write inside the directory (default false)
+
+STRUCTURE:
+  /README            - This file
+  /<dir>/            - Scratch directory, expires after its TTL
+  /<dir>/.ttl        - Control file: read the remaining TTL, write a new one
+
+Only directories can be created at the top level.
+
+EXAMPLES:
+  agfs:/> mkdir /tmpfs/ci-build-1234
+  agfs:/> cat /tmpfs/ci-build-1234/.ttl
+  59m58s (expires 2025-01-01T13:00:00Z)
+  agfs:/> echo 6h > /tmpfs/ci-build-1234/.ttl     # extend
+  agfs:/> echo 0 > /tmpfs/ci-build-1234/.ttl      # expire at the next GC
+`
+}
+
+func (p *TmpFSPlugin) Shutdown() error {
+	p.fs.stopGC()
+	return nil
+}
+
+// tmpFS wraps MemoryFS with per top-level directory expiry
+type tmpFS struct {
+	*memfs.MemoryFS
+	defaultTTL time.Duration
+	sliding    bool
+
+	mu      sync.Mutex
+	expires map[string]time.Time // Top-level directory name -> expiry
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTmpFS(defaultTTL time.Duration, sliding bool) *tmpFS {
+	return &tmpFS{
+		MemoryFS:   memfs.NewMemoryFSWithPlugin(PluginName),
+		defaultTTL: defaultTTL,
+		sliding:    sliding,
+		expires:    make(map[string]time.Time),
+	}
+}
+
+// split returns the top-level directory of p and the rest of the path
+func split(p string) (top, rest string) {
+	p = strings.TrimPrefix(filesystem.NormalizePath(p), "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		return p[:i], p[i+1:]
+	}
+	return p, ""
+}
+
+func isTTLFile(p string) bool {
+	top, rest := split(p)
+	return top != "" && rest == TTLFile
+}
+
+// checkNested rejects files at the top level, where only directories live
+func checkNested(op, p string) error {
+	if top, rest := split(p); top != "" && rest == "" {
+		return filesystem.NewPermissionDeniedError(op, p, "only directories can be created at the top level")
+	}
+	return nil
+}
+
+// touched resets the lifetime of the directory containing p in sliding mode
+func (fs *tmpFS) touched(p string) {
+	if !fs.sliding {
+		return
+	}
+	top, _ := split(p)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.expires[top]; ok {
+		fs.expires[top] = time.Now().Add(fs.defaultTTL)
+	}
+}
+
+func (fs *tmpFS) expiry(top string) (time.Time, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.expires[top]
+	return t, ok
+}
+
+func (fs *tmpFS) Create(p string) error {
+	if err := checkNested("create", p); err != nil {
+		return err
+	}
+	if isTTLFile(p) {
+		return filesystem.NewPermissionDeniedError("create", p, "reserved control file")
+	}
+	if err := fs.MemoryFS.Create(p); err != nil {
+		return err
+	}
+	fs.touched(p)
+	return nil
+}
+
+func (fs *tmpFS) Mkdir(p string, perm uint32) error {
+	if isTTLFile(p) {
+		return filesystem.NewPermissionDeniedError("mkdir", p, "reserved control file")
+	}
+	if err := fs.MemoryFS.Mkdir(p, perm); err != nil {
+		return err
+	}
+	top, rest := split(p)
+	fs.mu.Lock()
+	if rest == "" {
+		fs.expires[top] = time.Now().Add(fs.defaultTTL)
+	}
+	fs.mu.Unlock()
+	fs.touched(p)
+	return nil
+}
+
+func (fs *tmpFS) Write(p string, data []byte) ([]byte, error) {
+	if isTTLFile(p) {
+		return nil, fs.setTTL(p, string(data))
+	}
+	if err := checkNested("write", p); err != nil {
+		return nil, err
+	}
+	resp, err := fs.MemoryFS.Write(p, data)
+	if err != nil {
+		return nil, err
+	}
+	fs.touched(p)
+	return resp, nil
+}
+
+func (fs *tmpFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if isTTLFile(p) {
+		return nil, filesystem.NewNotSupportedError("openwrite", p)
+	}
+	if err := checkNested("openwrite", p); err != nil {
+		return nil, err
+	}
+	w, err := fs.MemoryFS.OpenWrite(p)
+	if err != nil {
+		return nil, err
+	}
+	return &tmpWriter{WriteCloser: w, fs: fs, path: p}, nil
+}
+
+// tmpWriter resets the directory lifetime once a streamed write is committed
+type tmpWriter struct {
+	io.WriteCloser
+	fs   *tmpFS
+	path string
+}
+
+func (w *tmpWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.fs.touched(w.path)
+	return nil
+}
+
+// Abort implements filesystem.Aborter
+func (w *tmpWriter) Abort() error {
+	return filesystem.AbortWrite(w.WriteCloser)
+}
+
+// setTTL parses a duration and sets the expiry of the directory containing p
+func (fs *tmpFS) setTTL(p, value string) error {
+	ttl, err := config.ParseDuration(strings.TrimSpace(value))
+	if err != nil || ttl < 0 {
+		return filesystem.NewInvalidArgumentError("ttl", strings.TrimSpace(value), "expected a duration such as 30m, 6h or 7d")
+	}
+	top, _ := split(p)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.expires[top]; !ok {
+		return filesystem.NewNotFoundError("write", p)
+	}
+	fs.expires[top] = time.Now().Add(ttl)
+	return nil
+}
+
+// ttlContent renders the TTL control file of top
+func (fs *tmpFS) ttlContent(p string) ([]byte, error) {
+	top, _ := split(p)
+	expires, ok := fs.expiry(top)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", p)
+	}
+	remaining := time.Until(expires).Round(time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return []byte(fmt.Sprintf("%s (expires %s)\n", remaining, expires.UTC().Format(time.RFC3339))), nil
+}
+
+func (fs *tmpFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	if isTTLFile(p) {
+		data, err := fs.ttlContent(p)
+		if err != nil {
+			return nil, err
+		}
+		return plugin.ApplyRangeRead(data, offset, size)
+	}
+	return fs.MemoryFS.Read(p, offset, size)
+}
+
+func (fs *tmpFS) Open(p string) (io.ReadCloser, error) {
+	if isTTLFile(p) {
+		data, err := fs.ttlContent(p)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(string(data))), nil
+	}
+	return fs.MemoryFS.Open(p)
+}
+
+func (fs *tmpFS) Stat(p string) (*filesystem.FileInfo, error) {
+	if isTTLFile(p) {
+		data, err := fs.ttlContent(p)
+		if err != nil {
+			return nil, err
+		}
+		return &filesystem.FileInfo{
+			Name:    TTLFile,
+			Size:    int64(len(data)),
+			Mode:    0644,
+			ModTime: time.Now(),
+			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueTTLControl},
+		}, nil
+	}
+
+	info, err := fs.MemoryFS.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if top, rest := split(p); top != "" && rest == "" {
+		if expires, ok := fs.expiry(top); ok {
+			fs.addExpiryMeta(info, expires)
+		}
+	}
+	return info, nil
+}
+
+func (fs *tmpFS) addExpiryMeta(info *filesystem.FileInfo, expires time.Time) {
+	remaining := time.Until(expires).Round(time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	info.Meta.Content = map[string]string{
+		MetaKeyExpiresAt: expires.UTC().Format(time.RFC3339),
+		MetaKeyTTL:       remaining.String(),
+	}
+}
+
+func (fs *tmpFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	entries, err := fs.MemoryFS.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		for i := range entries {
+			if expires, ok := fs.expiry(entries[i].Name); ok {
+				fs.addExpiryMeta(&entries[i], expires)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (fs *tmpFS) Remove(p string) error {
+	if isTTLFile(p) {
+		return filesystem.NewPermissionDeniedError("remove", p, "control file")
+	}
+	if err := fs.MemoryFS.Remove(p); err != nil {
+		return err
+	}
+	fs.forget(p)
+	fs.touched(p)
+	return nil
+}
+
+func (fs *tmpFS) RemoveAll(p string) error {
+	if isTTLFile(p) {
+		return filesystem.NewPermissionDeniedError("removeall", p, "control file")
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		// Keep the README, which is the only top-level file
+		entries, err := fs.MemoryFS.ReadDir("/")
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.IsDir {
+				if err := fs.RemoveAll("/" + entry.Name); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := fs.MemoryFS.RemoveAll(p); err != nil {
+		return err
+	}
+	fs.forget(p)
+	fs.touched(p)
+	return nil
+}
+
+// forget drops the expiry of p if it is a top-level directory
+func (fs *tmpFS) forget(p string) {
+	if top, rest := split(p); rest == "" {
+		fs.mu.Lock()
+		delete(fs.expires, top)
+		fs.mu.Unlock()
+	}
+}
+
+func (fs *tmpFS) Rename(oldPath, newPath string) error {
+	if isTTLFile(oldPath) || isTTLFile(newPath) {
+		return filesystem.NewPermissionDeniedError("rename", oldPath, "control file")
+	}
+	oldTop, oldRest := split(oldPath)
+	newTop, newRest := split(newPath)
+	// Top-level directories can only be renamed to top-level directories, and files
+	// cannot be moved to the top level
+	if (oldRest == "") != (newRest == "") {
+		return filesystem.NewPermissionDeniedError("rename", oldPath, "top-level directories cannot be moved into or out of the top level")
+	}
+	if err := fs.MemoryFS.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if oldRest == "" {
+		fs.mu.Lock()
+		if expires, ok := fs.expires[oldTop]; ok {
+			delete(fs.expires, oldTop)
+			fs.expires[newTop] = expires
+		}
+		fs.mu.Unlock()
+	}
+	fs.touched(newPath)
+	return nil
+}
+
+func (fs *tmpFS) Chmod(p string, mode uint32) error {
+	if isTTLFile(p) {
+		return filesystem.NewPermissionDeniedError("chmod", p, "control file")
+	}
+	return fs.MemoryFS.Chmod(p, mode)
+}
+
+// startGC removes expired top-level directories every interval
+func (fs *tmpFS) startGC(interval time.Duration) {
+	fs.done = make(chan struct{})
+	fs.wg.Add(1)
+	go func() {
+		defer fs.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-fs.done:
+				return
+			case now := <-ticker.C:
+				fs.gc(now)
+			}
+		}
+	}()
+}
+
+func (fs *tmpFS) stopGC() {
+	if fs.done != nil {
+		close(fs.done)
+		fs.wg.Wait()
+		fs.done = nil
+	}
+}
+
+// gc removes the top-level directories that expired before now
+func (fs *tmpFS) gc(now time.Time) {
+	fs.mu.Lock()
+	var expired []string
+	for top, expires := range fs.expires {
+		if !expires.After(now) {
+			expired = append(expired, top)
+			delete(fs.expires, top)
+		}
+	}
+	fs.mu.Unlock()
+
+	for _, top := range expired {
+		if err := fs.MemoryFS.RemoveAll("/" + top); err != nil {
+			log.Warnf("[tmpfs] failed to remove expired directory /%s: %v", top, err)
+			continue
+		}
+		log.Infof("[tmpfs] removed expired directory /%s", top)
+	}
+}
+
+// Ensure TmpFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*TmpFSPlugin)(nil)
+var _ filesystem.FileSystem = (*tmpFS)(nil)
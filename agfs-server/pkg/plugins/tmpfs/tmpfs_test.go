@@ -0,0 +1,126 @@
+package tmpfs
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestValidate(t *testing.T) {
+	p := NewTmpFSPlugin()
+	for _, cfg := range []map[string]interface{}{
+		{"default_ttl": "soon"},
+		{"gc_interval": "0s"},
+		{"sliding": "yes"},
+		{"size": 10},
+	} {
+		if err := p.Validate(cfg); err == nil {
+			t.Errorf("Validate(%v): no error", cfg)
+		}
+	}
+	if err := p.Validate(map[string]interface{}{"default_ttl": "7d", "gc_interval": "10s", "sliding": true}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Only directories live at the top level, each with a TTL control file, and the garbage
+// collector removes them once they expire
+func TestExpiry(t *testing.T) {
+	p := NewTmpFSPlugin()
+	if err := p.Initialize(map[string]interface{}{"default_ttl": "1h", "gc_interval": "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Shutdown()
+	fs := p.fs
+
+	if _, err := fs.Write("/file", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("top-level file: %v", err)
+	}
+	if err := fs.Mkdir("/build", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/build/out.log", []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.Read("/build/.ttl", 0, -1)
+	if (err != nil && err != io.EOF) || !strings.Contains(string(data), "(expires ") {
+		t.Errorf(".ttl = %q, %v", data, err)
+	}
+	info, err := fs.Stat("/build")
+	if err != nil || info.Meta.Content[MetaKeyExpiresAt] == "" {
+		t.Errorf("stat /build = %+v, %v", info, err)
+	}
+
+	if _, err := fs.Write("/build/.ttl", []byte("later")); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("invalid TTL: %v", err)
+	}
+	if err := fs.Remove("/build/.ttl"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("remove .ttl: %v", err)
+	}
+	if err := fs.Rename("/build/out.log", "/out.log"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("move a file to the top level: %v", err)
+	}
+
+	// Renamed directories keep their expiry, which the control file can shorten
+	if err := fs.Rename("/build", "/ci"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/ci/.ttl", []byte("0\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/keep", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fs.gc(time.Now())
+	if _, err := fs.Stat("/ci/out.log"); err == nil {
+		t.Error("expired directory is still there")
+	}
+	if _, err := fs.Stat("/keep"); err != nil {
+		t.Errorf("unexpired directory: %v", err)
+	}
+	if _, err := fs.Read("/ci/.ttl", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf(".ttl of an expired directory: %v", err)
+	}
+
+	// RemoveAll of the root keeps the README
+	if err := fs.RemoveAll("/"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil || len(entries) != 1 || entries[0].Name != "README" {
+		t.Errorf("root after RemoveAll = %+v, %v", entries, err)
+	}
+}
+
+// In sliding mode writes push the expiry back
+func TestSliding(t *testing.T) {
+	fs := newTmpFS(time.Hour, true)
+	if err := fs.Mkdir("/s", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/s/.ttl", []byte("1m")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/s/a", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if expires, _ := fs.expiry("s"); time.Until(expires) < 59*time.Minute {
+		t.Errorf("expiry after a write in %s, want about an hour", time.Until(expires))
+	}
+	w, err := fs.OpenWrite("/s/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.setTTL("/s/.ttl", "1m")
+	w.Write([]byte("y"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if expires, _ := fs.expiry("s"); time.Until(expires) < 59*time.Minute {
+		t.Errorf("expiry after a streamed write in %s, want about an hour", time.Until(expires))
+	}
+}
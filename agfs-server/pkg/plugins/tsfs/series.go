@@ -0,0 +1,267 @@
+package tsfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Point is a value at a time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// series holds the points of a time series sorted by time
+type series struct {
+	points  []Point
+	created time.Time
+}
+
+// insert adds points, keeping the series sorted; points of equal times keep their order
+func (s *series) insert(points []Point) {
+	for _, p := range points {
+		i := len(s.points)
+		if i > 0 && p.Time.Before(s.points[i-1].Time) {
+			i = sort.Search(len(s.points), func(j int) bool { return s.points[j].Time.After(p.Time) })
+		}
+		s.points = append(s.points, Point{})
+		copy(s.points[i+1:], s.points[i:])
+		s.points[i] = p
+	}
+}
+
+// trim drops the points before cutoff and the oldest points beyond maxPoints
+func (s *series) trim(cutoff time.Time, maxPoints int) {
+	drop := 0
+	if !cutoff.IsZero() {
+		drop = sort.Search(len(s.points), func(j int) bool { return !s.points[j].Time.Before(cutoff) })
+	}
+	if maxPoints > 0 && len(s.points)-drop > maxPoints {
+		drop = len(s.points) - maxPoints
+	}
+	if drop > 0 {
+		s.points = append([]Point(nil), s.points[drop:]...)
+	}
+}
+
+// parsePoints parses the lines of a write: "<value>" is a point at now, "<time> <value>" a
+// point at a Unix timestamp in seconds or an RFC 3339 time
+func parsePoints(data []byte, now time.Time) ([]Point, error) {
+	var points []Point
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		p := Point{Time: now}
+		var err error
+		switch len(fields) {
+		case 1:
+			p.Value, err = parseValue(fields[0])
+		case 2:
+			if p.Time, err = parseTime(fields[0], now); err == nil {
+				p.Value, err = parseValue(fields[1])
+			}
+		default:
+			err = fmt.Errorf("expected \"[time] value\"")
+		}
+		if err != nil {
+			return nil, filesystem.NewInvalidArgumentError("point", strings.TrimSpace(line), err.Error())
+		}
+		points = append(points, p)
+	}
+	if len(points) == 0 {
+		return nil, filesystem.NewInvalidArgumentError("point", "", "a value is required")
+	}
+	return points, nil
+}
+
+func parseValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// parseTime parses a Unix timestamp in seconds, an RFC 3339 time, "now", or a duration
+// relative to now such as -1h
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		if d, err := pluginconfig.ParseDuration(s[1:]); err == nil {
+			return now.Add(-d), nil
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected Unix seconds, RFC 3339, now or -<duration>", s)
+}
+
+// formatLine formats a point as a line of the series file, as written by the store
+func formatLine(p Point) string {
+	return strconv.FormatFloat(float64(p.Time.UnixNano())/1e9, 'f', -1, 64) + " " + strconv.FormatFloat(p.Value, 'f', -1, 64) + "\n"
+}
+
+// Aggregations of downsampled reads
+var aggregations = map[string]func([]float64) float64{
+	"avg": func(v []float64) float64 {
+		var sum float64
+		for _, x := range v {
+			sum += x
+		}
+		return sum / float64(len(v))
+	},
+	"sum": func(v []float64) float64 {
+		var sum float64
+		for _, x := range v {
+			sum += x
+		}
+		return sum
+	},
+	"min": func(v []float64) float64 {
+		m := v[0]
+		for _, x := range v[1:] {
+			m = math.Min(m, x)
+		}
+		return m
+	},
+	"max": func(v []float64) float64 {
+		m := v[0]
+		for _, x := range v[1:] {
+			m = math.Max(m, x)
+		}
+		return m
+	},
+	"count": func(v []float64) float64 { return float64(len(v)) },
+	"first": func(v []float64) float64 { return v[0] },
+	"last":  func(v []float64) float64 { return v[len(v)-1] },
+}
+
+// query is a range read of a series, given after "?" in the file name
+type query struct {
+	from, to   time.Time // Inclusive bounds, zero if unbounded
+	downsample time.Duration
+	agg        string
+	format     string // "text" or "json"
+}
+
+// parseQuery parses from, to, downsample, agg and format
+func parseQuery(raw string, now time.Time) (query, error) {
+	q := query{agg: "avg", format: "text"}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return q, filesystem.NewInvalidArgumentError("query", raw, err.Error())
+	}
+	for key := range values {
+		switch key {
+		case "from", "to", "downsample", "agg", "format":
+		default:
+			return q, filesystem.NewInvalidArgumentError("query", key, "expected from, to, downsample, agg or format")
+		}
+	}
+	for key, dst := range map[string]*time.Time{"from": &q.from, "to": &q.to} {
+		if v := values.Get(key); v != "" {
+			if *dst, err = parseTime(v, now); err != nil {
+				return q, filesystem.NewInvalidArgumentError(key, v, err.Error())
+			}
+		}
+	}
+	if v := values.Get("downsample"); v != "" {
+		if q.downsample, err = pluginconfig.ParseDuration(v); err != nil || q.downsample <= 0 {
+			return q, filesystem.NewInvalidArgumentError("downsample", v, "expected a positive duration")
+		}
+	}
+	if v := values.Get("agg"); v != "" {
+		if _, ok := aggregations[v]; !ok {
+			return q, filesystem.NewInvalidArgumentError("agg", v, "expected avg, sum, min, max, count, first or last")
+		}
+		q.agg = v
+	}
+	if v := values.Get("format"); v != "" {
+		if v != "text" && v != "json" {
+			return q, filesystem.NewInvalidArgumentError("format", v, "expected text or json")
+		}
+		q.format = v
+	}
+	return q, nil
+}
+
+// run selects the points of the query; downsampled points are at the start of their bucket
+func (q query) run(points []Point) []Point {
+	start := 0
+	if !q.from.IsZero() {
+		start = sort.Search(len(points), func(j int) bool { return !points[j].Time.Before(q.from) })
+	}
+	end := len(points)
+	if !q.to.IsZero() {
+		end = sort.Search(len(points), func(j int) bool { return points[j].Time.After(q.to) })
+	}
+	if start >= end {
+		return nil
+	}
+	selected := points[start:end]
+	if q.downsample == 0 {
+		return selected
+	}
+
+	agg := aggregations[q.agg]
+	var out []Point
+	var bucket time.Time
+	var values []float64
+	for _, p := range selected {
+		b := p.Time.Truncate(q.downsample)
+		if len(values) > 0 && !b.Equal(bucket) {
+			out = append(out, Point{Time: bucket, Value: agg(values)})
+			values = values[:0]
+		}
+		bucket = b
+		values = append(values, p.Value)
+	}
+	return append(out, Point{Time: bucket, Value: agg(values)})
+}
+
+// jsonPoint is a point of a JSON read
+type jsonPoint struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// render formats points as "<RFC 3339 time> <value>" lines or a JSON array
+func (q query) render(points []Point) ([]byte, error) {
+	if q.format == "json" {
+		out := make([]jsonPoint, len(points))
+		for i, p := range points {
+			out[i] = jsonPoint{Time: p.Time.UTC(), Value: p.Value}
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var b strings.Builder
+	for _, p := range points {
+		b.WriteString(p.Time.UTC().Format(time.RFC3339Nano))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(p.Value, 'f', -1, 64))
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
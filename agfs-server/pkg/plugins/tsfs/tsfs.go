@@ -0,0 +1,496 @@
+package tsfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "tsfs"
+
+	// DefaultMaxPoints is the number of points kept per series
+	DefaultMaxPoints = 100000
+
+	// compactMinLines is the size a store file has to reach before it is compacted
+	compactMinLines = 1000
+)
+
+// validName restricts series names to one path element
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,200}$`)
+
+// TSFSPlugin stores append-only time series, one per file
+type TSFSPlugin struct {
+	rootFS filesystem.FileSystem
+	fs     *tsFS
+}
+
+// NewTSFSPlugin creates a new TSFS plugin
+func NewTSFSPlugin() *TSFSPlugin {
+	return &TSFSPlugin{}
+}
+
+// SetRootFS sets the root filesystem holding the optional store
+func (p *TSFSPlugin) SetRootFS(rootFS filesystem.FileSystem) {
+	p.rootFS = rootFS
+}
+
+func (p *TSFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *TSFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"store", "retention", "max_points", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	if err := config.ValidateStringType(cfg, "store"); err != nil {
+		return err
+	}
+	if store := config.GetStringConfig(cfg, "store", ""); store != "" {
+		if !strings.HasPrefix(store, "/") || filesystem.NormalizePath(store) == "/" {
+			return fmt.Errorf("store must be an absolute path below the root: %s", store)
+		}
+		mountPath := config.GetStringConfig(cfg, "mount_path", "")
+		if mountPath != "" && (within(filesystem.NormalizePath(store), mountPath) || within(mountPath, filesystem.NormalizePath(store))) {
+			return fmt.Errorf("store %s overlaps the mount path %s", store, mountPath)
+		}
+	}
+	if _, err := config.GetDurationConfig(cfg, "retention", 0); err != nil {
+		return err
+	}
+	if err := config.ValidateIntType(cfg, "max_points"); err != nil {
+		return err
+	}
+	if config.GetIntConfig(cfg, "max_points", DefaultMaxPoints) < 1 {
+		return fmt.Errorf("max_points must be at least 1")
+	}
+	return nil
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || dir == "/" || strings.HasPrefix(p, dir+"/")
+}
+
+func (p *TSFSPlugin) Initialize(cfg map[string]interface{}) error {
+	retention, err := config.GetDurationConfig(cfg, "retention", 0)
+	if err != nil {
+		return err
+	}
+	store := config.GetStringConfig(cfg, "store", "")
+	if store != "" {
+		store = filesystem.NormalizePath(store)
+	}
+	p.fs = &tsFS{
+		root: p.rootFS,
+		state: &state{
+			series:    make(map[string]*series),
+			stored:    make(map[string]int),
+			store:     store,
+			retention: retention,
+			maxPoints: config.GetIntConfig(cfg, "max_points", DefaultMaxPoints),
+			loaded:    store == "",
+			now:       time.Now,
+		},
+	}
+	return nil
+}
+
+func (p *TSFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *TSFSPlugin) GetReadme() string {
+	return `TSFS Plugin - Time Series
+
+Each file is an append-only time series. Writing appends points,
+reading returns them, and a query after "?" in the file name reads
+a range, optionally downsampled.
+
+WRITING:
+  <value>          - A point at the current time
+  <time> <value>   - A point at a Unix timestamp (seconds) or RFC 3339 time
+One point per line; points may arrive out of order.
+
+READING:
+  /<series>                              - Every point, "<RFC 3339 time> <value>" lines
+  /<series>?from=-1h                     - Points of the last hour
+  /<series>?from=-1d&downsample=5m&agg=max&format=json
+
+  from, to    - Unix seconds, RFC 3339, now or -<duration> (inclusive)
+  downsample  - Bucket width, points are aggregated per bucket
+  agg         - avg (default), sum, min, max, count, first or last
+  format      - text (default) or json: [{"t": ..., "v": ...}]
+
+CONFIGURATION:
+  store       - Optional path of another mount (e.g. sqlfs) persisting the series
+  retention   - Drop points older than this, e.g. "30d" (default: keep)
+  max_points  - Points kept per series (default: 100000)
+
+Without a store the series are kept in memory only. With one, every
+write is appended to <store>/<series> and the series are loaded from
+there on first use after a restart.
+
+EXAMPLES:
+  agfs:/> echo 21.5 > /tsfs/temperature
+  agfs:/> echo "1760000000 20.9" > /tsfs/temperature
+  agfs:/> cat "/tsfs/temperature?from=-1h&downsample=10m"
+`
+}
+
+func (p *TSFSPlugin) Shutdown() error {
+	return nil
+}
+
+// state holds the series of a mount, shared with the views returned by WithContext
+type state struct {
+	mu        sync.Mutex
+	series    map[string]*series
+	stored    map[string]int // Lines of each store file
+	store     string
+	retention time.Duration
+	maxPoints int
+	loaded    bool
+	now       func() time.Time
+}
+
+// tsFS implements the FileSystem interface over the series
+type tsFS struct {
+	root  filesystem.FileSystem
+	state *state
+}
+
+// WithContext implements filesystem.ContextBinder by binding the root file system
+func (fs *tsFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.root = filesystem.WithContext(fs.root, ctx)
+	return &bound
+}
+
+// splitQuery returns the series name of a path and the query after "?"
+func splitQuery(p string) (name, rawQuery string, err error) {
+	rel := strings.TrimPrefix(filesystem.NormalizePath(p), "/")
+	name, rawQuery, _ = strings.Cut(rel, "?")
+	if !validName.MatchString(name) {
+		return "", "", filesystem.NewNotFoundError("open", p)
+	}
+	return name, rawQuery, nil
+}
+
+// cutoff returns the time before which points are dropped, zero without retention
+func (s *state) cutoff() time.Time {
+	if s.retention == 0 {
+		return time.Time{}
+	}
+	return s.now().Add(-s.retention)
+}
+
+// load reads the series from the store once; s.mu must be held
+func (fs *tsFS) load() error {
+	s := fs.state
+	if s.loaded {
+		return nil
+	}
+	if fs.root == nil {
+		return fmt.Errorf("tsfs is not mounted")
+	}
+	entries, err := fs.root.ReadDir(s.store)
+	if err != nil && !filesystem.IsNotFound(err) {
+		return fmt.Errorf("failed to load the store: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir || !validName.MatchString(entry.Name) {
+			continue
+		}
+		file := path.Join(s.store, entry.Name)
+		data, err := fs.root.Read(file, 0, -1)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		sr := &series{created: entry.ModTime}
+		lines := 0
+		if len(strings.TrimSpace(string(data))) > 0 {
+			points, err := parsePoints(data, s.now())
+			if err != nil {
+				return fmt.Errorf("failed to load %s: %w", file, err)
+			}
+			lines = len(points)
+			sr.insert(points)
+		}
+		sr.trim(s.cutoff(), s.maxPoints)
+		s.series[entry.Name] = sr
+		s.stored[entry.Name] = lines
+		if err := fs.compact(entry.Name); err != nil {
+			log.Warnf("[tsfs] failed to compact %s: %v", file, err)
+		}
+	}
+	s.loaded = true
+	return nil
+}
+
+// compact rewrites the store file of a series that holds many dropped points; s.mu must be held
+func (fs *tsFS) compact(name string) error {
+	s := fs.state
+	points := s.series[name].points
+	if s.store == "" || s.stored[name] < compactMinLines || s.stored[name] <= 2*len(points) {
+		return nil
+	}
+	var b strings.Builder
+	for _, p := range points {
+		b.WriteString(formatLine(p))
+	}
+	if _, err := fs.root.Write(path.Join(s.store, name), []byte(b.String())); err != nil {
+		return err
+	}
+	s.stored[name] = len(points)
+	return nil
+}
+
+// lookup returns a series after loading the store
+func (fs *tsFS) lookup(op, p string) (*series, string, string, error) {
+	name, rawQuery, err := splitQuery(p)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if err := fs.load(); err != nil {
+		return nil, "", "", err
+	}
+	sr, ok := fs.state.series[name]
+	if !ok {
+		return nil, "", "", filesystem.NewNotFoundError(op, p)
+	}
+	return sr, name, rawQuery, nil
+}
+
+// content renders a series, or the range given by the query in its path
+func (fs *tsFS) content(p string) ([]byte, error) {
+	if filesystem.NormalizePath(p) == "/README" {
+		return []byte((&TSFSPlugin{}).GetReadme()), nil
+	}
+	s := fs.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sr, _, rawQuery, err := fs.lookup("read", p)
+	if err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(rawQuery, s.now())
+	if err != nil {
+		return nil, err
+	}
+	sr.trim(s.cutoff(), s.maxPoints)
+	return q.render(q.run(sr.points))
+}
+
+// add appends points to a series, creating it if needed
+func (fs *tsFS) add(p string, data []byte) error {
+	s := fs.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, rawQuery, err := splitQuery(p)
+	if err != nil {
+		return err
+	}
+	if rawQuery != "" {
+		return filesystem.NewPermissionDeniedError("write", p, "queries are read-only")
+	}
+	if err := fs.load(); err != nil {
+		return err
+	}
+	var points []Point
+	if data != nil {
+		if points, err = parsePoints(data, s.now()); err != nil {
+			return err
+		}
+	}
+
+	if s.store != "" {
+		var b strings.Builder
+		for _, pt := range points {
+			b.WriteString(formatLine(pt))
+		}
+		if err := filesystem.MkdirAll(fs.root, s.store, 0755); err != nil {
+			return err
+		}
+		if _, err := filesystem.Append(fs.root, path.Join(s.store, name), []byte(b.String())); err != nil {
+			return fmt.Errorf("failed to persist %s: %w", name, err)
+		}
+		s.stored[name] += len(points)
+	}
+
+	sr, ok := s.series[name]
+	if !ok {
+		sr = &series{created: s.now()}
+		s.series[name] = sr
+	}
+	sr.insert(points)
+	sr.trim(s.cutoff(), s.maxPoints)
+	if err := fs.compact(name); err != nil {
+		log.Warnf("[tsfs] failed to compact %s: %v", name, err)
+	}
+	return nil
+}
+
+func (fs *tsFS) Create(p string) error {
+	s := fs.state
+	s.mu.Lock()
+	name, _, err := splitQuery(p)
+	if err == nil {
+		err = fs.load()
+	}
+	_, exists := s.series[name]
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return filesystem.NewAlreadyExistsError("file", p)
+	}
+	return fs.add(p, nil)
+}
+
+func (fs *tsFS) Mkdir(p string, perm uint32) error {
+	return filesystem.NewNotSupportedError("mkdir", p)
+}
+
+func (fs *tsFS) Remove(p string) error {
+	s := fs.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, name, rawQuery, err := fs.lookup("remove", p)
+	if err != nil {
+		return err
+	}
+	if rawQuery != "" {
+		return filesystem.NewPermissionDeniedError("remove", p, "queries are read-only")
+	}
+	if s.store != "" {
+		if err := fs.root.Remove(path.Join(s.store, name)); err != nil && !filesystem.IsNotFound(err) {
+			return err
+		}
+		delete(s.stored, name)
+	}
+	delete(s.series, name)
+	return nil
+}
+
+func (fs *tsFS) RemoveAll(p string) error {
+	return fs.Remove(p)
+}
+
+func (fs *tsFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+func (fs *tsFS) Write(p string, data []byte) ([]byte, error) {
+	if data == nil {
+		data = []byte{}
+	}
+	if err := fs.add(p, data); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (fs *tsFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	if filesystem.NormalizePath(p) != "/" {
+		if _, err := fs.Stat(p); err != nil {
+			return nil, err
+		}
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+	s := fs.state
+	s.mu.Lock()
+	err := fs.load()
+	names := make([]string, 0, len(s.series))
+	for name := range s.series {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	infos := []filesystem.FileInfo{}
+	for _, name := range append([]string{"README"}, names...) {
+		if info, err := fs.Stat("/" + name); err == nil {
+			infos = append(infos, *info)
+		}
+	}
+	return infos, nil
+}
+
+func (fs *tsFS) Stat(p string) (*filesystem.FileInfo, error) {
+	rel := filesystem.NormalizePath(p)
+	if rel == "/" {
+		return &filesystem.FileInfo{Name: "/", Mode: 0755, ModTime: time.Now(), IsDir: true, Meta: filesystem.MetaData{Name: PluginName}}, nil
+	}
+	data, err := fs.content(rel)
+	if err != nil {
+		return nil, err
+	}
+	info := &filesystem.FileInfo{
+		Name:    path.Base(rel),
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: time.Now(),
+		Meta:    filesystem.MetaData{Name: PluginName},
+	}
+	if rel == "/README" {
+		info.Mode = 0444
+		return info, nil
+	}
+
+	s := fs.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sr, _, _, err := fs.lookup("stat", rel); err == nil {
+		info.ModTime = sr.created
+		if n := len(sr.points); n > 0 {
+			info.ModTime = sr.points[n-1].Time
+			info.Meta.Content = map[string]string{"points": fmt.Sprint(n)}
+		}
+	}
+	return info, nil
+}
+
+func (fs *tsFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewNotSupportedError("rename", oldPath)
+}
+
+func (fs *tsFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *tsFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (fs *tsFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(p, fs.Write), nil
+}
+
+// Ensure TSFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*TSFSPlugin)(nil)
+var _ filesystem.FileSystem = (*tsFS)(nil)
@@ -0,0 +1,88 @@
+package tsfs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func read(t *testing.T, mfs *mountablefs.MountableFS, p string) string {
+	t.Helper()
+	data, err := mfs.Read(p, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSeries(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewTSFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/store", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.MountPlugin(PluginName, "/ts", map[string]interface{}{"store": "/store/ts"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Points may arrive out of order
+	for _, data := range []string{"100 1\n160 3", "130 2\n", "220 10\n"} {
+		if _, err := mfs.Write("/ts/temp", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mfs.Write("/ts/temp", []byte("abc")); err == nil {
+		t.Errorf("non-numeric write succeeded")
+	}
+	if got, want := read(t, mfs, "/ts/temp?from=130&to=200"), "1970-01-01T00:02:10Z 2\n1970-01-01T00:02:40Z 3\n"; got != want {
+		t.Errorf("range = %q, want %q", got, want)
+	}
+	if got, want := read(t, mfs, "/ts/temp?downsample=1m&agg=sum&format=json"), `[{"t":"1970-01-01T00:01:00Z","v":1},{"t":"1970-01-01T00:02:00Z","v":5},{"t":"1970-01-01T00:03:00Z","v":10}]`+"\n"; got != want {
+		t.Errorf("downsampled = %q, want %q", got, want)
+	}
+
+	// A second mount over the same store loads the persisted points
+	if err := mfs.MountPlugin(PluginName, "/ts2", map[string]interface{}{"store": "/store/ts", "max_points": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(t, mfs, "/ts2/temp?format=json"), `[{"t":"1970-01-01T00:02:40Z","v":3},{"t":"1970-01-01T00:03:40Z","v":10}]`+"\n"; got != want {
+		t.Errorf("reloaded = %q, want %q", got, want)
+	}
+
+	if err := mfs.Remove("/ts/temp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/store/ts/temp"); err == nil {
+		t.Errorf("store file survived remove")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	p := NewTSFSPlugin()
+	cfg := map[string]interface{}{"retention": "1h"}
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(100000, 0)
+	p.fs.state.now = func() time.Time { return now }
+
+	if _, err := p.fs.Write("/load", []byte("-2h 1\n-30m 2\n3")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(45 * time.Minute)
+	data, err := p.fs.Read("/load?format=text", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "1970-01-02T03:46:40Z 3\n"; got != want {
+		t.Errorf("after retention = %q, want %q", got, want)
+	}
+}
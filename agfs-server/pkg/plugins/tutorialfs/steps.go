@@ -0,0 +1,215 @@
+package tutorialfs
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// step is one lesson of the tour: a numbered directory with a README describing what to do
+// and a checkpoint verifying that the user did it
+type step struct {
+	dir   string // Directory of the step, e.g. "01-welcome"
+	title string
+	// lesson returns the README of the step, the "what to do" part ending with how to check
+	lesson func(fs *tutorialFS) string
+	// check verifies the action of the step, returning a hint of what is missing if it fails
+	check func(fs *tutorialFS) (bool, string)
+}
+
+// steps are the lessons of the tour, in order
+var steps = []step{
+	{
+		dir:   "01-welcome",
+		title: "Everything is a file",
+		lesson: func(fs *tutorialFS) string {
+			return fmt.Sprintf(`Every service of the server - storage, queues, key-value stores - is a
+plugin mounted at a path, and is used by reading and writing files. This
+tour is a plugin too: its steps are directories, its checkpoints are files.
+
+Each step has a README (this file) and a "check" file. Reading "check"
+verifies that you did what the step asks and records your progress, which
+%[1]s/progress summarizes.
+
+TRY IT:
+  Write your name to the "name" file of this step:
+
+    agfs:/> echo "Ada" > %[1]s/01-welcome/name
+
+  Files of a plugin do what the plugin decides: this one just remembers it.
+
+CHECK:
+    agfs:/> cat %[1]s/01-welcome/check
+`, fs.plugin.mountPath)
+		},
+		check: func(fs *tutorialFS) (bool, string) {
+			if fs.plugin.userName() == "" {
+				return false, "nobody has written a name to " + fs.plugin.mountPath + "/01-welcome/name yet"
+			}
+			return true, "hello, " + fs.plugin.userName()
+		},
+	},
+	{
+		dir:   "02-files",
+		title: "Files and directories",
+		lesson: func(fs *tutorialFS) string {
+			return fmt.Sprintf(`memfs, mounted at %[1]s, keeps ordinary files and directories in memory.
+The usual commands work on it: mkdir, echo >, cat, ls, stat.
+
+TRY IT:
+  Create a directory and write a file in it:
+
+    agfs:/> mkdir %[1]s/tutorial
+    agfs:/> echo "hello" > %[1]s/tutorial/hello.txt
+    agfs:/> cat %[1]s/tutorial/hello.txt
+    agfs:/> ls -l %[1]s/tutorial
+
+  The same works over HTTP:
+
+    curl -X PUT "http://localhost:8080/api/v1/files?path=%[1]s/tutorial/hello.txt" -d "hello"
+
+CHECK:
+    agfs:/> cat %[2]s/02-files/check
+`, fs.plugin.memfsPath, fs.plugin.mountPath)
+		},
+		check: func(fs *tutorialFS) (bool, string) {
+			file := fs.plugin.memfsPath + "/tutorial/hello.txt"
+			data, err := fs.plugin.read(file)
+			if err != nil {
+				return false, file + " does not exist yet"
+			}
+			if !strings.Contains(string(data), "hello") {
+				return false, file + ` exists but does not contain "hello"`
+			}
+			return true, file + " holds your greeting"
+		},
+	},
+	{
+		dir:   "03-queues",
+		title: "Queues",
+		lesson: func(fs *tutorialFS) string {
+			return fmt.Sprintf(`queuefs, mounted at %[1]s, turns directories into message queues. Writing
+to a queue's "enqueue" file adds a message, reading its "dequeue" file takes
+the oldest one out, and "size" counts the pending messages.
+
+TRY IT:
+  Create a queue and enqueue a job:
+
+    agfs:/> mkdir %[1]s/tutorial
+    agfs:/> echo "resize photo.jpg" > %[1]s/tutorial/enqueue
+    agfs:/> cat %[1]s/tutorial/size
+    agfs:/> ls %[1]s/tutorial/messages
+
+  Leave the message in the queue for now, step 05 takes it out.
+
+CHECK:
+    agfs:/> cat %[2]s/03-queues/check
+`, fs.plugin.queuefsPath, fs.plugin.mountPath)
+		},
+		check: func(fs *tutorialFS) (bool, string) {
+			queue := fs.plugin.queuefsPath + "/tutorial"
+			n, err := fs.plugin.queueSize(queue)
+			if err != nil {
+				return false, "there is no queue " + queue + " yet"
+			}
+			if n == 0 {
+				return false, "the queue " + queue + " exists but is empty, enqueue a message"
+			}
+			return true, fmt.Sprintf("%s holds %d message(s)", queue, n)
+		},
+	},
+	{
+		dir:   "04-kv",
+		title: "Key-value store",
+		lesson: func(fs *tutorialFS) string {
+			return fmt.Sprintf(`kvfs, mounted at %[1]s, is a key-value store: each file under %[1]s/keys
+is a key, and its content is the value.
+
+TRY IT:
+  Store a setting and read it back:
+
+    agfs:/> echo "dark" > %[1]s/keys/tutorial-theme
+    agfs:/> cat %[1]s/keys/tutorial-theme
+    agfs:/> ls %[1]s/keys
+
+CHECK:
+    agfs:/> cat %[2]s/04-kv/check
+`, fs.plugin.kvfsPath, fs.plugin.mountPath)
+		},
+		check: func(fs *tutorialFS) (bool, string) {
+			key := fs.plugin.kvfsPath + "/keys/tutorial-theme"
+			data, err := fs.plugin.read(key)
+			if err != nil {
+				return false, "the key " + key + " does not exist yet"
+			}
+			return true, fmt.Sprintf("%s = %q", key, strings.TrimSpace(string(data)))
+		},
+	},
+	{
+		dir:   "05-moving-on",
+		title: "Moving data between services",
+		lesson: func(fs *tutorialFS) string {
+			return fmt.Sprintf(`Because everything is a file, the shell moves data between services with
+the commands you already know.
+
+TRY IT:
+  Take the job out of the queue into a file, then rename the greeting:
+
+    agfs:/> cat %[1]s/tutorial/dequeue > %[2]s/tutorial/job.json
+    agfs:/> mv %[2]s/tutorial/hello.txt %[2]s/tutorial/done.txt
+
+CHECK:
+    agfs:/> cat %[3]s/05-moving-on/check
+
+  Then read %[3]s/progress. Every plugin has a README at the root of its
+  mount (cat /<mount>/README) - the best place to go next.
+`, fs.plugin.queuefsPath, fs.plugin.memfsPath, fs.plugin.mountPath)
+		},
+		check: func(fs *tutorialFS) (bool, string) {
+			dir := fs.plugin.memfsPath + "/tutorial"
+			queue := fs.plugin.queuefsPath + "/tutorial"
+			if n, err := fs.plugin.queueSize(queue); err == nil && n > 0 {
+				return false, fmt.Sprintf("the queue %s still holds %d message(s)", queue, n)
+			}
+			if _, err := fs.plugin.read(dir + "/job.json"); err != nil {
+				return false, dir + "/job.json does not exist yet"
+			}
+			if _, err := fs.plugin.read(dir + "/hello.txt"); err == nil {
+				return false, dir + "/hello.txt has not been renamed to done.txt yet"
+			}
+			if _, err := fs.plugin.read(dir + "/done.txt"); err != nil {
+				return false, dir + "/done.txt does not exist yet"
+			}
+			return true, "the job left the queue and the greeting was renamed"
+		},
+	},
+}
+
+// findStep returns the index of the step in dir, -1 if there is none
+func findStep(dir string) int {
+	for i, s := range steps {
+		if s.dir == dir {
+			return i
+		}
+	}
+	return -1
+}
+
+// read reads a whole file of the root file system
+func (p *TutorialFSPlugin) read(path string) ([]byte, error) {
+	data, err := p.rootFS.Read(path, 0, -1)
+	if err == io.EOF {
+		err = nil
+	}
+	return data, err
+}
+
+// queueSize returns the number of pending messages of a queuefs queue
+func (p *TutorialFSPlugin) queueSize(queue string) (int, error) {
+	data, err := p.read(queue + "/size")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,459 @@
+package tutorialfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "tutorialfs"
+
+	// Default mount paths of the plugins the steps use, as in the sample configuration
+	DefaultMemFSPath   = "/memfs"
+	DefaultQueueFSPath = "/queuefs"
+	DefaultKVFSPath    = "/kvfs"
+
+	fileReadme   = "README"
+	fileProgress = "progress"
+	fileCheck    = "check"
+	fileName     = "name" // Answer file of the first step
+
+	// MetaValueStep and MetaValueCheckpoint mark the step directories and their check files
+	MetaValueStep       = "step"
+	MetaValueCheckpoint = "checkpoint"
+)
+
+// TutorialFSPlugin is a guided tour for new users: numbered step directories explain a
+// concept each, and checkpoints verify on the other mounts that the user tried it
+type TutorialFSPlugin struct {
+	rootFS      filesystem.FileSystem
+	mountPath   string
+	memfsPath   string
+	queuefsPath string
+	kvfsPath    string
+
+	mu        sync.Mutex // protects the fields below
+	name      string
+	completed map[string]time.Time // Time each step's checkpoint first passed, by directory
+	modTime   time.Time            // Last change to the progress
+}
+
+// NewTutorialFSPlugin creates a new TutorialFS plugin
+func NewTutorialFSPlugin() *TutorialFSPlugin {
+	return &TutorialFSPlugin{completed: make(map[string]time.Time), modTime: time.Now()}
+}
+
+func (p *TutorialFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *TutorialFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"memfs_path", "queuefs_path", "kvfs_path", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"memfs_path", "queuefs_path", "kvfs_path"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+		if v := config.GetStringConfig(cfg, key, ""); v != "" && !strings.HasPrefix(v, "/") {
+			return fmt.Errorf("%s must be an absolute path: %s", key, v)
+		}
+	}
+	return nil
+}
+
+// InitializeContext takes the mount paths of the plugins the checkpoints look at and the
+// root file system they are verified on
+func (p *TutorialFSPlugin) InitializeContext(ictx *plugin.InitContext) error {
+	p.rootFS = ictx.RootFS
+	p.mountPath = ictx.MountPath
+	if p.mountPath == "" {
+		p.mountPath = "/" + PluginName
+	}
+	p.memfsPath = filesystem.NormalizePath(config.GetStringConfig(ictx.Config, "memfs_path", DefaultMemFSPath))
+	p.queuefsPath = filesystem.NormalizePath(config.GetStringConfig(ictx.Config, "queuefs_path", DefaultQueueFSPath))
+	p.kvfsPath = filesystem.NormalizePath(config.GetStringConfig(ictx.Config, "kvfs_path", DefaultKVFSPath))
+	return nil
+}
+
+// Initialize leaves the plugin without a root filesystem, so checkpoints cannot be verified
+func (p *TutorialFSPlugin) Initialize(cfg map[string]interface{}) error {
+	return p.InitializeContext(plugin.LegacyInitContext(PluginName, cfg))
+}
+
+func (p *TutorialFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &tutorialFS{plugin: p}
+}
+
+func (p *TutorialFSPlugin) GetReadme() string {
+	var list strings.Builder
+	for _, s := range steps {
+		fmt.Fprintf(&list, "  /%-16s - %s\n", s.dir+"/", s.title)
+	}
+	return fmt.Sprintf(`TutorialFS Plugin - Guided Tour
+
+A hands-on introduction to the server, one step per directory. Each step
+explains a concept and asks you to try it on another mount; its checkpoint
+verifies that you did and records your progress.
+
+STRUCTURE:
+  /README            - This file
+  /progress          - Steps done and the next one (write "reset" to start over)
+%s
+  Each step directory holds:
+    README           - What the step is about and what to do
+    check            - Read to verify the step: PASSED or NOT YET with a hint
+
+START:
+  agfs:/> cat %[2]s/01-welcome/README
+
+The steps use memfs at %[3]s, queuefs at %[4]s and kvfs at %[5]s; the
+memfs_path, queuefs_path and kvfs_path options point them elsewhere.
+Progress is kept in memory and starts over when the server restarts.
+
+FORMATS:
+  progress is text by default and JSON with format=json or
+  Accept: application/json.
+`, list.String(), p.mountPath, p.memfsPath, p.queuefsPath, p.kvfsPath)
+}
+
+func (p *TutorialFSPlugin) Shutdown() error {
+	return nil
+}
+
+// userName returns the name written in the first step
+func (p *TutorialFSPlugin) userName() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.name
+}
+
+// runCheck verifies step i and records it as done when it passes
+func (p *TutorialFSPlugin) runCheck(i int) (bool, string) {
+	if p.rootFS == nil {
+		return false, "the checkpoint cannot look at other mounts, the plugin has no root file system"
+	}
+	passed, hint := steps[i].check(&tutorialFS{plugin: p})
+	if passed {
+		p.mu.Lock()
+		if _, ok := p.completed[steps[i].dir]; !ok {
+			p.completed[steps[i].dir] = time.Now()
+			p.modTime = time.Now()
+		}
+		p.mu.Unlock()
+	}
+	return passed, hint
+}
+
+// StepProgress is the state of one step in /progress
+type StepProgress struct {
+	Step        string     `json:"step"`
+	Title       string     `json:"title"`
+	Done        bool       `json:"done"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// Progress is the content of /progress
+type Progress struct {
+	Completed int            `json:"completed"`
+	Total     int            `json:"total"`
+	Next      string         `json:"next,omitempty"` // First step not done, empty when all are
+	Steps     []StepProgress `json:"steps"`
+}
+
+// progress returns the steps done so far
+func (p *TutorialFSPlugin) progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr := Progress{Total: len(steps), Steps: make([]StepProgress, 0, len(steps))}
+	for _, s := range steps {
+		sp := StepProgress{Step: s.dir, Title: s.title}
+		if at, ok := p.completed[s.dir]; ok {
+			sp.Done, sp.CompletedAt = true, &at
+			pr.Completed++
+		} else if pr.Next == "" {
+			pr.Next = s.dir
+		}
+		pr.Steps = append(pr.Steps, sp)
+	}
+	return pr
+}
+
+// reset forgets the progress and the name
+func (p *TutorialFSPlugin) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.name = ""
+	p.completed = make(map[string]time.Time)
+	p.modTime = time.Now()
+}
+
+// tutorialFS serves the README, /progress and the step directories
+type tutorialFS struct {
+	plugin *TutorialFSPlugin
+}
+
+// parsePath splits a path into its step index (-1 for none) and the file name within the
+// step directory ("" for the directory itself); ok is false for paths that do not exist
+func parsePath(path string) (stepIndex int, name string, ok bool) {
+	parts := strings.Split(strings.Trim(filesystem.NormalizePath(path), "/"), "/")
+	if len(parts) > 2 {
+		return -1, "", false
+	}
+	i := findStep(parts[0])
+	if i < 0 {
+		return -1, "", false
+	}
+	if len(parts) == 1 {
+		return i, "", true
+	}
+	switch parts[1] {
+	case fileReadme, fileCheck:
+		return i, parts[1], true
+	case fileName:
+		return i, parts[1], i == 0
+	}
+	return -1, "", false
+}
+
+// renderProgress renders /progress as JSON or as text
+func (fs *tutorialFS) renderProgress(format string) ([]byte, error) {
+	pr := fs.plugin.progress()
+	if format == filesystem.FormatJSON {
+		data, err := json.MarshalIndent(pr, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Progress: %d of %d steps\n\n", pr.Completed, pr.Total)
+	for _, s := range pr.Steps {
+		mark := " "
+		if s.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&buf, "  [%s] %-14s %s\n", mark, s.Step, s.Title)
+	}
+	if pr.Next != "" {
+		fmt.Fprintf(&buf, "\nNext: cat %s/%s/README\n", fs.plugin.mountPath, pr.Next)
+	} else {
+		buf.WriteString("\nAll steps done - well done!\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// renderCheck runs the checkpoint of step i
+func (fs *tutorialFS) renderCheck(i int) []byte {
+	passed, hint := fs.plugin.runCheck(i)
+	if !passed {
+		return []byte(fmt.Sprintf("NOT YET: %s\n\nWhat to do: cat %s/%s/README\n", hint, fs.plugin.mountPath, steps[i].dir))
+	}
+	next := "All steps done: cat " + fs.plugin.mountPath + "/progress"
+	if i+1 < len(steps) {
+		next = fmt.Sprintf("Next step: cat %s/%s/README", fs.plugin.mountPath, steps[i+1].dir)
+	}
+	return []byte(fmt.Sprintf("PASSED: %s\n\n%s\n", hint, next))
+}
+
+// content returns the content of the file at path, for the formats offering files in format
+func (fs *tutorialFS) content(path, format string) ([]byte, error) {
+	switch path {
+	case "/" + fileReadme:
+		return []byte(fs.plugin.GetReadme()), nil
+	case "/" + fileProgress:
+		return fs.renderProgress(format)
+	}
+	i, name, ok := parsePath(path)
+	if !ok || path == "/" {
+		return nil, filesystem.NewNotFoundError("read", path)
+	}
+	switch name {
+	case "":
+		return nil, fmt.Errorf("is a directory: %s", path)
+	case fileReadme:
+		return []byte(steps[i].lesson(fs)), nil
+	case fileCheck:
+		return fs.renderCheck(i), nil
+	default:
+		if n := fs.plugin.userName(); n != "" {
+			return []byte(n + "\n"), nil
+		}
+		return []byte{}, nil
+	}
+}
+
+func (fs *tutorialFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(filesystem.NormalizePath(path), filesystem.FormatText)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// ReadFormat implements filesystem.FormatReader: /progress is also offered as JSON
+func (fs *tutorialFS) ReadFormat(path string, offset int64, size int64, format string) ([]byte, string, error) {
+	path = filesystem.NormalizePath(path)
+	if path != "/"+fileProgress || (format != filesystem.FormatJSON && format != filesystem.FormatText) {
+		data, err := fs.Read(path, offset, size)
+		if path == "/"+fileProgress {
+			return data, filesystem.FormatText, err
+		}
+		return data, "", err
+	}
+	data, err := fs.content(path, format)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err = plugin.ApplyRangeRead(data, offset, size)
+	return data, format, err
+}
+
+func (fs *tutorialFS) Write(path string, data []byte) ([]byte, error) {
+	path = filesystem.NormalizePath(path)
+	if path == "/"+fileProgress {
+		if strings.TrimSpace(string(data)) != "reset" {
+			return nil, filesystem.NewInvalidArgumentError("progress", strings.TrimSpace(string(data)), `write "reset" to start over`)
+		}
+		fs.plugin.reset()
+		return []byte("Progress reset, start again with cat " + fs.plugin.mountPath + "/01-welcome/README"), nil
+	}
+	if _, name, ok := parsePath(path); ok && name == fileName {
+		fs.plugin.mu.Lock()
+		fs.plugin.name = strings.TrimSpace(string(data))
+		fs.plugin.mu.Unlock()
+		return []byte("Nice to meet you! Now read the check file of this step"), nil
+	}
+	return nil, filesystem.NewPermissionDeniedError("write", path, "tutorialfs files are read-only")
+}
+
+func (fs *tutorialFS) Stat(path string) (*filesystem.FileInfo, error) {
+	path = filesystem.NormalizePath(path)
+	fs.plugin.mu.Lock()
+	modTime := fs.plugin.modTime
+	fs.plugin.mu.Unlock()
+
+	switch path {
+	case "/":
+		return &filesystem.FileInfo{Name: "/", Mode: 0555, ModTime: modTime, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "directory"}}, nil
+	case "/" + fileReadme:
+		return &filesystem.FileInfo{Name: fileReadme, Size: int64(len(fs.plugin.GetReadme())), Mode: 0444,
+			ModTime: modTime, Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case "/" + fileProgress:
+		data, _ := fs.renderProgress(filesystem.FormatText)
+		return &filesystem.FileInfo{Name: fileProgress, Size: int64(len(data)), Mode: 0644, ModTime: modTime,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "progress",
+				Content: filesystem.FormatMeta(filesystem.FormatText, filesystem.FormatText, filesystem.FormatJSON)}}, nil
+	}
+
+	i, name, ok := parsePath(path)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	s := steps[i]
+	switch name {
+	case "":
+		done := fs.plugin.progress().Steps[i].Done
+		return &filesystem.FileInfo{Name: s.dir, Mode: 0555, ModTime: modTime, IsDir: true,
+			Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueStep,
+				Content: map[string]string{"title": s.title, "done": fmt.Sprint(done)}}}, nil
+	case fileReadme:
+		return &filesystem.FileInfo{Name: fileReadme, Size: int64(len(s.lesson(fs))), Mode: 0444, ModTime: modTime,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case fileCheck:
+		// The size is unknown until the checkpoint runs, which reading does
+		return &filesystem.FileInfo{Name: fileCheck, Mode: 0444, ModTime: modTime,
+			Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueCheckpoint,
+				Content: map[string]string{"description": "Read to verify the step"}}}, nil
+	default:
+		return &filesystem.FileInfo{Name: fileName, Size: int64(len(fs.plugin.userName())), Mode: 0644, ModTime: modTime,
+			Meta: filesystem.MetaData{Name: PluginName, Type: "file"}}, nil
+	}
+}
+
+func (fs *tutorialFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	path = filesystem.NormalizePath(path)
+	var names []string
+	if path == "/" {
+		names = []string{"/" + fileReadme, "/" + fileProgress}
+		for _, s := range steps {
+			names = append(names, "/"+s.dir)
+		}
+	} else if i, name, ok := parsePath(path); ok && name == "" {
+		names = []string{path + "/" + fileReadme, path + "/" + fileCheck}
+		if i == 0 {
+			names = append(names, path+"/"+fileName)
+		}
+	} else if ok {
+		return nil, filesystem.NewNotDirectoryError(path)
+	} else {
+		return nil, filesystem.NewNotFoundError("readdir", path)
+	}
+
+	files := make([]filesystem.FileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *info)
+	}
+	return files, nil
+}
+
+func (fs *tutorialFS) Create(path string) error {
+	if _, name, ok := parsePath(path); ok && name == fileName {
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("create", path, "tutorialfs files are read-only")
+}
+
+func (fs *tutorialFS) Mkdir(path string, perm uint32) error {
+	return filesystem.NewPermissionDeniedError("mkdir", path, "tutorialfs is read-only")
+}
+
+func (fs *tutorialFS) Remove(path string) error {
+	return filesystem.NewPermissionDeniedError("remove", path, "tutorialfs is read-only")
+}
+
+func (fs *tutorialFS) RemoveAll(path string) error {
+	return filesystem.NewPermissionDeniedError("remove", path, "tutorialfs is read-only")
+}
+
+func (fs *tutorialFS) Rename(oldPath, newPath string) error {
+	return filesystem.NewPermissionDeniedError("rename", oldPath, "tutorialfs is read-only")
+}
+
+func (fs *tutorialFS) Chmod(path string, mode uint32) error {
+	return filesystem.NewPermissionDeniedError("chmod", path, "tutorialfs is read-only")
+}
+
+func (fs *tutorialFS) Open(path string) (io.ReadCloser, error) {
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *tutorialFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return filesystem.NewBufferedWriter(path, fs.Write), nil
+}
+
+var (
+	_ plugin.ServicePlugin      = (*TutorialFSPlugin)(nil)
+	_ plugin.ContextInitializer = (*TutorialFSPlugin)(nil)
+	_ filesystem.FileSystem     = (*tutorialFS)(nil)
+	_ filesystem.FormatReader   = (*tutorialFS)(nil)
+)
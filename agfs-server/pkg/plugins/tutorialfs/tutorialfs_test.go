@@ -0,0 +1,119 @@
+package tutorialfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/kvfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+)
+
+func read(t *testing.T, fs filesystem.FileSystem, path string) string {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func write(t *testing.T, fs filesystem.FileSystem, path, data string) {
+	t.Helper()
+	if _, err := fs.Write(path, []byte(data)); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// The tour is walked through on real memfs, queuefs and kvfs mounts
+func TestTour(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() })
+	mfs.RegisterPluginFactory("kvfs", func() plugin.ServicePlugin { return kvfs.NewKVFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewTutorialFSPlugin() })
+	for _, m := range []struct{ plugin, path string }{
+		{"memfs", "/memfs"}, {"queuefs", "/queuefs"}, {"kvfs", "/kvfs"}, {PluginName, "/tour"},
+	} {
+		if err := mfs.MountPlugin(m.plugin, m.path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := mfs.ReadDir("/tour")
+	if err != nil || len(entries) != 2+len(steps) || entries[2].Name != "01-welcome" || !entries[2].IsDir {
+		t.Fatalf("root listing = %+v, %v", entries, err)
+	}
+	if lesson := read(t, mfs, "/tour/01-welcome/README"); !strings.Contains(lesson, "/tour/01-welcome/name") {
+		t.Errorf("lesson does not use the mount path:\n%s", lesson)
+	}
+
+	check := func(step string, want string) {
+		t.Helper()
+		if got := read(t, mfs, "/tour/"+step+"/check"); !strings.HasPrefix(got, want) {
+			t.Errorf("%s check = %q, want %s", step, got, want)
+		}
+	}
+	check("01-welcome", "NOT YET")
+	write(t, mfs, "/tour/01-welcome/name", "Ada\n")
+	check("01-welcome", "PASSED: hello, Ada")
+
+	check("02-files", "NOT YET")
+	if err := mfs.Mkdir("/memfs/tutorial", 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, mfs, "/memfs/tutorial/hello.txt", "hello")
+	check("02-files", "PASSED")
+
+	if err := mfs.Mkdir("/queuefs/tutorial", 0755); err != nil {
+		t.Fatal(err)
+	}
+	check("03-queues", "NOT YET: the queue /queuefs/tutorial exists but is empty")
+	write(t, mfs, "/queuefs/tutorial/enqueue", "resize photo.jpg")
+	check("03-queues", "PASSED")
+
+	write(t, mfs, "/kvfs/keys/tutorial-theme", "dark")
+	check("04-kv", "PASSED")
+
+	check("05-moving-on", "NOT YET: the queue /queuefs/tutorial still holds 1 message(s)")
+	write(t, mfs, "/memfs/tutorial/job.json", read(t, mfs, "/queuefs/tutorial/dequeue"))
+	if err := mfs.Rename("/memfs/tutorial/hello.txt", "/memfs/tutorial/done.txt"); err != nil {
+		t.Fatal(err)
+	}
+	check("05-moving-on", "PASSED")
+
+	if got := read(t, mfs, "/tour/progress"); !strings.Contains(got, "5 of 5") || !strings.Contains(got, "All steps done") {
+		t.Errorf("progress =\n%s", got)
+	}
+
+	// Resetting starts over; the files of the steps stay, so a check passes again at once
+	write(t, mfs, "/tour/progress", "reset")
+	data, format, err := mfs.ReadFormat("/tour/progress", 0, -1, filesystem.FormatJSON)
+	var pr Progress
+	if (err != nil && err != io.EOF) || format != filesystem.FormatJSON || json.Unmarshal(data, &pr) != nil {
+		t.Fatalf("progress as JSON = %q, %q, %v", data, format, err)
+	}
+	if pr.Completed != 0 || pr.Next != "01-welcome" || len(pr.Steps) != len(steps) {
+		t.Errorf("progress after reset = %+v", pr)
+	}
+	check("04-kv", "PASSED")
+	if info, err := mfs.Stat("/tour/04-kv"); err != nil || info.Meta.Content["done"] != "true" {
+		t.Errorf("step metadata = %+v, %v", info, err)
+	}
+
+	if _, err := mfs.Write("/tour/progress", []byte("oops")); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("invalid progress write: %v", err)
+	}
+	if _, err := mfs.Write("/tour/02-files/check", []byte("x")); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("check write: %v", err)
+	}
+	if _, err := mfs.Stat("/tour/02-files/name"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("name outside the first step: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package vectorfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// Embedder turns texts into vectors; similar texts get vectors with a high cosine similarity
+type Embedder interface {
+	// Name identifies the embedder and its model in collection info
+	Name() string
+
+	// Embed returns one vector per text, all of the same dimension
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderFactory creates an embedder from the plugin configuration
+type EmbedderFactory func(cfg map[string]interface{}) (Embedder, error)
+
+var (
+	embeddersMu sync.RWMutex
+	embedders   = map[string]EmbedderFactory{
+		"hash":   newHashEmbedder,
+		"openai": newOpenAIEmbedder,
+	}
+)
+
+// RegisterEmbedder makes an embedder available to the embedder config key
+func RegisterEmbedder(name string, factory EmbedderFactory) {
+	embeddersMu.Lock()
+	defer embeddersMu.Unlock()
+	embedders[name] = factory
+}
+
+// newEmbedder creates the embedder selected by cfg
+func newEmbedder(cfg map[string]interface{}) (Embedder, error) {
+	name := config.GetStringConfig(cfg, "embedder", DefaultEmbedder)
+	embeddersMu.RLock()
+	factory, ok := embedders[name]
+	var names []string
+	for n := range embedders {
+		names = append(names, n)
+	}
+	embeddersMu.RUnlock()
+	if !ok {
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown embedder %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return factory(cfg)
+}
+
+// normalize scales v to unit length, so that the dot product is the cosine similarity
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// DefaultHashDims is the dimension of hash embeddings
+const DefaultHashDims = 256
+
+// hashEmbedder hashes words and word pairs into a fixed number of dimensions. It needs
+// no model and matches texts sharing words, not meaning
+type hashEmbedder struct {
+	dims int
+}
+
+func newHashEmbedder(cfg map[string]interface{}) (Embedder, error) {
+	dims := config.GetIntConfig(cfg, "dims", DefaultHashDims)
+	if dims < 8 {
+		return nil, fmt.Errorf("dims must be at least 8")
+	}
+	return &hashEmbedder{dims: dims}, nil
+}
+
+func (e *hashEmbedder) Name() string {
+	return fmt.Sprintf("hash/%d", e.dims)
+}
+
+func (e *hashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e *hashEmbedder) embed(text string) []float32 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	counts := make(map[string]int)
+	for i, w := range words {
+		counts[w]++
+		if i > 0 {
+			counts[words[i-1]+" "+w]++
+		}
+	}
+	v := make([]float32, e.dims)
+	for feature, n := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		weight := float32(1 + math.Log(float64(n)))
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		v[sum%uint64(e.dims)] += weight
+	}
+	normalize(v)
+	return v
+}
+
+// DefaultEmbeddingTimeout bounds one request to an embedding API
+const DefaultEmbeddingTimeout = 30 * time.Second
+
+// openAIEmbedder calls an OpenAI-compatible embeddings API
+type openAIEmbedder struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+func newOpenAIEmbedder(cfg map[string]interface{}) (Embedder, error) {
+	url, err := config.RequireString(cfg, "embedding_url")
+	if err != nil {
+		return nil, err
+	}
+	model, err := config.RequireString(cfg, "embedding_model")
+	if err != nil {
+		return nil, err
+	}
+	url = strings.TrimSuffix(url, "/")
+	if !strings.HasSuffix(url, "/embeddings") {
+		url += "/embeddings"
+	}
+	return &openAIEmbedder{
+		url:    url,
+		model:  model,
+		apiKey: config.GetStringConfig(cfg, "api_key", ""),
+		client: &http.Client{Timeout: DefaultEmbeddingTimeout},
+	}, nil
+}
+
+func (e *openAIEmbedder) Name() string {
+	return "openai/" + e.model
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("embedding API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid embedding response: %v", err)
+	}
+	if len(result.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding API returned %d vectors for %d texts", len(result.Data), len(texts))
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(texts) || len(d.Embedding) == 0 {
+			return nil, fmt.Errorf("invalid embedding at index %d", d.Index)
+		}
+		normalize(d.Embedding)
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if v == nil {
+			return nil, fmt.Errorf("embedding API returned no vector for text %d", i)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,667 @@
+package vectorfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "vectorfs" // Name of this plugin
+
+	// DefaultEmbedder needs no external service
+	DefaultEmbedder = "hash"
+
+	// DefaultTopK is the number of search results unless the query sets k
+	DefaultTopK = 5
+
+	// DefaultMaxDocSize bounds the size of a document
+	DefaultMaxDocSize = 1024 * 1024
+
+	// snippetSize bounds the document text in search results
+	snippetSize = 200
+)
+
+// Files of a collection
+const (
+	DirDocs    = "docs"   // Documents, one file each
+	FileSearch = "search" // Write-only, writing a query returns the top matches
+	FileInfo   = "info"   // Read-only collection statistics
+)
+
+// Meta values for VectorFS plugin
+const (
+	MetaValueCollection = "collection"
+	MetaValueDocument   = "document"
+	MetaValueControl    = "control"
+)
+
+// VectorFSPlugin indexes documents by embedding and finds the most similar ones to a query
+type VectorFSPlugin struct {
+	fs *vectorFS
+}
+
+// NewVectorFSPlugin creates a new VectorFS plugin
+func NewVectorFSPlugin() *VectorFSPlugin {
+	p := &VectorFSPlugin{}
+	p.fs = &vectorFS{store: &store{
+		plugin:      p,
+		topK:        DefaultTopK,
+		maxDocSize:  DefaultMaxDocSize,
+		collections: make(map[string]*collection),
+	}}
+	return p
+}
+
+func (p *VectorFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *VectorFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"embedder", "dims", "embedding_url", "embedding_model", "api_key", "top_k",
+		"max_doc_size", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	for _, key := range []string{"embedder", "embedding_url", "embedding_model", "api_key"} {
+		if err := config.ValidateStringType(cfg, key); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"dims", "top_k"} {
+		if err := config.ValidateIntType(cfg, key); err != nil {
+			return err
+		}
+	}
+	if config.GetIntConfig(cfg, "top_k", DefaultTopK) < 1 {
+		return fmt.Errorf("top_k must be at least 1")
+	}
+	if size, err := config.GetSizeConfig(cfg, "max_doc_size", DefaultMaxDocSize); err != nil {
+		return err
+	} else if size <= 0 {
+		return fmt.Errorf("max_doc_size must be positive")
+	}
+	_, err := newEmbedder(cfg)
+	return err
+}
+
+func (p *VectorFSPlugin) Initialize(cfg map[string]interface{}) error {
+	embedder, err := newEmbedder(cfg)
+	if err != nil {
+		return err
+	}
+	maxDocSize, err := config.GetSizeConfig(cfg, "max_doc_size", DefaultMaxDocSize)
+	if err != nil {
+		return err
+	}
+	p.fs.embedder = embedder
+	p.fs.topK = config.GetIntConfig(cfg, "top_k", DefaultTopK)
+	p.fs.maxDocSize = maxDocSize
+	log.Infof("[vectorfs] using embedder %s", embedder.Name())
+	return nil
+}
+
+func (p *VectorFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *VectorFSPlugin) GetReadme() string {
+	return `VectorFS Plugin - Embedding Search
+
+Documents written to a collection are embedded and indexed; writing a query to the
+search file of the collection returns the most similar documents as JSON.
+
+CONFIGURATION:
+  embedder         - "hash" (default, local word hashing, no model) or "openai"
+  dims             - Dimensions of hash embeddings (default 256)
+  embedding_url    - OpenAI-compatible API of the openai embedder,
+                     e.g. "https://api.openai.com/v1"
+  embedding_model  - Model of the openai embedder, e.g. "text-embedding-3-small"
+  api_key          - API key of embedding_url
+  top_k            - Results of a query without k (default 5)
+  max_doc_size     - Largest document (default "1MB")
+
+STRUCTURE:
+  /README
+  /collections/<c>/docs/<id>  - Documents; writing one embeds and indexes it
+  /collections/<c>/search     - Write-only: write a query, get the top matches
+  /collections/<c>/info       - Number of documents, dimensions and embedder
+
+Collections are created by mkdir or by writing their first document. Queries are plain
+text or JSON: {"query": "...", "k": 10}. The index is kept in memory.
+
+EXAMPLES:
+  agfs:/> echo "Rust has no garbage collector" > /vectorfs/collections/notes/docs/rust
+  agfs:/> echo "Go is garbage collected" > /vectorfs/collections/notes/docs/go
+  agfs:/> echo "garbage collection" > /vectorfs/collections/notes/search
+  [{"id":"go","score":0.61,"snippet":"Go is garbage collected\n"}, ...]
+  agfs:/> rm -r /vectorfs/collections/notes
+`
+}
+
+func (p *VectorFSPlugin) Shutdown() error {
+	return nil
+}
+
+// document is an indexed text
+type document struct {
+	text    []byte
+	vector  []float32
+	modTime time.Time
+}
+
+// collection holds documents whose vectors have the same dimension
+type collection struct {
+	name    string
+	created time.Time
+	modTime time.Time
+	dims    int // Dimension of the vectors, 0 until the first document
+	docs    map[string]*document
+}
+
+func newCollection(name string) *collection {
+	now := time.Now()
+	return &collection{name: name, created: now, modTime: now, docs: make(map[string]*document)}
+}
+
+// store holds the collections shared by all views of the file system
+type store struct {
+	plugin     *VectorFSPlugin
+	embedder   Embedder
+	topK       int
+	maxDocSize int64
+
+	mu          sync.RWMutex
+	collections map[string]*collection
+}
+
+// vectorFS is the file system of a store; views bound to a context embed under it
+type vectorFS struct {
+	*store
+	ctx context.Context
+}
+
+// WithContext implements filesystem.ContextBinder, so embedding API calls stop with the request
+func (fs *vectorFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &vectorFS{store: fs.store, ctx: ctx}
+}
+
+func (fs *vectorFS) context() context.Context {
+	if fs.ctx != nil {
+		return fs.ctx
+	}
+	return context.Background()
+}
+
+// vfsPath is a parsed path; empty fields are not part of it
+type vfsPath struct {
+	collection string
+	file       string // DirDocs, FileSearch or FileInfo
+	doc        string
+}
+
+// parsePath parses p, returning ok false for paths that cannot exist
+func parsePath(p string) (parts []string, vp vfsPath, ok bool) {
+	p = strings.Trim(filesystem.NormalizePath(p), "/")
+	if p != "" {
+		parts = strings.Split(p, "/")
+	}
+	switch {
+	case len(parts) == 0:
+		return parts, vp, true
+	case len(parts) == 1:
+		return parts, vp, parts[0] == "README" || parts[0] == "collections"
+	case parts[0] != "collections" || len(parts) > 4:
+		return parts, vp, false
+	}
+	vp.collection = parts[1]
+	if len(parts) >= 3 {
+		vp.file = parts[2]
+		switch {
+		case len(parts) == 4 && vp.file == DirDocs:
+			vp.doc = parts[3]
+		case len(parts) == 3 && (vp.file == DirDocs || vp.file == FileSearch || vp.file == FileInfo):
+		default:
+			return parts, vp, false
+		}
+	}
+	return parts, vp, true
+}
+
+// snippet returns the start of text, cut at a character boundary
+func snippet(text []byte) string {
+	if len(text) <= snippetSize {
+		return string(text)
+	}
+	cut := snippetSize
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return string(text[:cut]) + "..."
+}
+
+// searchResult is a match of a query
+type searchResult struct {
+	ID      string  `json:"id"`
+	Score   float32 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchQuery is a JSON query; any other query is the text to match
+type searchQuery struct {
+	Query string `json:"query"`
+	K     int    `json:"k"`
+}
+
+// search returns the documents of a collection most similar to the query in data
+func (fs *vectorFS) search(p, name string, data []byte) ([]byte, error) {
+	q := searchQuery{Query: string(data)}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &q); err != nil {
+			return nil, filesystem.NewInvalidArgumentError("query", string(trimmed), err.Error())
+		}
+	}
+	if strings.TrimSpace(q.Query) == "" {
+		return nil, filesystem.NewInvalidArgumentError("query", q.Query, "query is empty")
+	}
+	if q.K <= 0 {
+		q.K = fs.topK
+	}
+	vectors, err := fs.embedder.Embed(fs.context(), []string{q.Query})
+	if err != nil {
+		return nil, err
+	}
+	query := vectors[0]
+
+	fs.mu.RLock()
+	c, ok := fs.collections[name]
+	if !ok {
+		fs.mu.RUnlock()
+		return nil, filesystem.NewNotFoundError("search", p)
+	}
+	results := []searchResult{}
+	if len(query) == c.dims {
+		for id, doc := range c.docs {
+			var score float32
+			for i, x := range query {
+				score += x * doc.vector[i]
+			}
+			results = append(results, searchResult{ID: id, Score: score, Snippet: snippet(doc.text)})
+		}
+	} else if c.dims != 0 {
+		fs.mu.RUnlock()
+		return nil, fmt.Errorf("query has %d dimensions, collection %s has %d", len(query), name, c.dims)
+	}
+	fs.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > q.K {
+		results = results[:q.K]
+	}
+	return json.Marshal(results)
+}
+
+// collectionInfo is the content of the info file
+type collectionInfo struct {
+	Name     string    `json:"name"`
+	Docs     int       `json:"docs"`
+	Dims     int       `json:"dims"`
+	Embedder string    `json:"embedder"`
+	Created  time.Time `json:"created"`
+}
+
+func (fs *vectorFS) Create(p string) error {
+	_, vp, ok := parsePath(p)
+	if !ok || vp.doc == "" {
+		return filesystem.NewPermissionDeniedError("create", p, "only documents can be created, in /collections/<c>/docs")
+	}
+	fs.mu.RLock()
+	c, exists := fs.collections[vp.collection]
+	if exists {
+		_, exists = c.docs[vp.doc]
+	}
+	fs.mu.RUnlock()
+	if exists {
+		return nil
+	}
+	_, err := fs.Write(p, nil)
+	return err
+}
+
+func (fs *vectorFS) Mkdir(p string, perm uint32) error {
+	parts, vp, ok := parsePath(p)
+	if !ok || len(parts) != 2 {
+		if ok && len(parts) == 3 && vp.file == DirDocs {
+			return filesystem.NewAlreadyExistsError("directory", p)
+		}
+		return filesystem.NewPermissionDeniedError("mkdir", p, "only collections can be created, in /collections")
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, exists := fs.collections[vp.collection]; exists {
+		return filesystem.NewAlreadyExistsError("collection", p)
+	}
+	fs.collections[vp.collection] = newCollection(vp.collection)
+	return nil
+}
+
+func (fs *vectorFS) Remove(p string) error {
+	return fs.remove(p, false)
+}
+
+func (fs *vectorFS) RemoveAll(p string) error {
+	return fs.remove(p, true)
+}
+
+func (fs *vectorFS) remove(p string, recursive bool) error {
+	parts, vp, ok := parsePath(p)
+	if !ok || vp.collection == "" {
+		return filesystem.NewPermissionDeniedError("remove", p, "only collections and documents can be removed")
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	c, exists := fs.collections[vp.collection]
+	if !exists {
+		return filesystem.NewNotFoundError("remove", p)
+	}
+	switch {
+	case vp.doc != "":
+		if _, exists := c.docs[vp.doc]; !exists {
+			return filesystem.NewNotFoundError("remove", p)
+		}
+		delete(c.docs, vp.doc)
+		c.modTime = time.Now()
+		return nil
+	case len(parts) == 2:
+		if len(c.docs) > 0 && !recursive {
+			return fmt.Errorf("directory not empty: %s", p)
+		}
+		delete(fs.collections, vp.collection)
+		return nil
+	case vp.file == DirDocs && recursive:
+		c.docs = make(map[string]*document)
+		c.modTime = time.Now()
+		return nil
+	}
+	return filesystem.NewPermissionDeniedError("remove", p, "remove the collection instead")
+}
+
+func (fs *vectorFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// content returns the whole content of a file
+func (fs *vectorFS) content(p string) ([]byte, error) {
+	parts, vp, ok := parsePath(p)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("read", p)
+	}
+	if len(parts) == 1 && parts[0] == "README" {
+		return []byte(fs.plugin.GetReadme()), nil
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	if vp.collection == "" {
+		return nil, fmt.Errorf("is a directory: %s", p)
+	}
+	c, exists := fs.collections[vp.collection]
+	if !exists {
+		return nil, filesystem.NewNotFoundError("read", p)
+	}
+	switch {
+	case vp.doc != "":
+		doc, exists := c.docs[vp.doc]
+		if !exists {
+			return nil, filesystem.NewNotFoundError("read", p)
+		}
+		return doc.text, nil
+	case vp.file == FileInfo:
+		data, err := json.MarshalIndent(collectionInfo{Name: c.name, Docs: len(c.docs), Dims: c.dims,
+			Embedder: fs.embedder.Name(), Created: c.created}, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case vp.file == FileSearch:
+		return nil, filesystem.NewPermissionDeniedError("read", p, "write a query to search")
+	}
+	return nil, fmt.Errorf("is a directory: %s", p)
+}
+
+func (fs *vectorFS) Write(p string, data []byte) ([]byte, error) {
+	_, vp, ok := parsePath(p)
+	switch {
+	case ok && vp.file == FileSearch:
+		return fs.search(p, vp.collection, data)
+	case !ok || vp.doc == "":
+		return nil, filesystem.NewPermissionDeniedError("write", p, "only documents and search can be written")
+	case int64(len(data)) > fs.maxDocSize:
+		return nil, filesystem.NewQuotaExceededError(p, fs.maxDocSize, 0)
+	}
+
+	// Embed before locking, the embedder may call a remote API
+	vectors, err := fs.embedder.Embed(fs.context(), []string{string(data)})
+	if err != nil {
+		return nil, err
+	}
+	vector := vectors[0]
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	c, exists := fs.collections[vp.collection]
+	if !exists {
+		c = newCollection(vp.collection)
+		fs.collections[vp.collection] = c
+	}
+	_, replacing := c.docs[vp.doc]
+	if len(c.docs) == 0 || (replacing && len(c.docs) == 1) {
+		c.dims = len(vector)
+	} else if len(vector) != c.dims {
+		return nil, fmt.Errorf("embedding has %d dimensions, collection %s has %d", len(vector), vp.collection, c.dims)
+	}
+	now := time.Now()
+	c.docs[vp.doc] = &document{text: append([]byte(nil), data...), vector: vector, modTime: now}
+	c.modTime = now
+	return nil, nil
+}
+
+func dirInfo(name, metaType string, modTime time.Time) *filesystem.FileInfo {
+	return &filesystem.FileInfo{
+		Name:    name,
+		Mode:    0755,
+		ModTime: modTime,
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: metaType},
+	}
+}
+
+// collectionFiles lists the entries of a collection directory
+func collectionFiles(c *collection) []filesystem.FileInfo {
+	return []filesystem.FileInfo{
+		*dirInfo(DirDocs, "dir", c.modTime),
+		{Name: FileSearch, Mode: 0222, ModTime: c.modTime, Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueControl}},
+		{Name: FileInfo, Mode: 0444, ModTime: c.modTime, Meta: filesystem.MetaData{Name: PluginName, Type: MetaValueControl}},
+	}
+}
+
+func docInfo(id string, doc *document) filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    id,
+		Size:    int64(len(doc.text)),
+		Mode:    0644,
+		ModTime: doc.modTime,
+		Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueDocument},
+	}
+}
+
+func (fs *vectorFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	parts, vp, ok := parsePath(p)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("readdir", p)
+	}
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	switch {
+	case len(parts) == 0:
+		readme := fs.plugin.GetReadme()
+		return []filesystem.FileInfo{
+			{Name: "README", Size: int64(len(readme)), Mode: 0444, ModTime: time.Now(),
+				Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}},
+			*dirInfo("collections", "dir", time.Now()),
+		}, nil
+	case len(parts) == 1 && parts[0] == "collections":
+		infos := make([]filesystem.FileInfo, 0, len(fs.collections))
+		for _, c := range fs.collections {
+			infos = append(infos, *dirInfo(c.name, MetaValueCollection, c.modTime))
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		return infos, nil
+	case len(parts) == 1:
+		return nil, filesystem.NewNotDirectoryError(p)
+	}
+
+	c, exists := fs.collections[vp.collection]
+	if !exists {
+		return nil, filesystem.NewNotFoundError("readdir", p)
+	}
+	switch {
+	case len(parts) == 2:
+		return collectionFiles(c), nil
+	case vp.file == DirDocs && vp.doc == "":
+		infos := make([]filesystem.FileInfo, 0, len(c.docs))
+		for id, doc := range c.docs {
+			infos = append(infos, docInfo(id, doc))
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+		return infos, nil
+	}
+	return nil, filesystem.NewNotDirectoryError(p)
+}
+
+func (fs *vectorFS) Stat(p string) (*filesystem.FileInfo, error) {
+	parts, vp, ok := parsePath(p)
+	if !ok {
+		return nil, filesystem.NewNotFoundError("stat", p)
+	}
+	switch {
+	case len(parts) == 0:
+		return dirInfo("/", "root", time.Now()), nil
+	case len(parts) == 1 && parts[0] == "README":
+		return &filesystem.FileInfo{Name: "README", Size: int64(len(fs.plugin.GetReadme())), Mode: 0444,
+			ModTime: time.Now(), Meta: filesystem.MetaData{Name: PluginName, Type: "doc"}}, nil
+	case len(parts) == 1:
+		return dirInfo("collections", "dir", time.Now()), nil
+	}
+
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+	c, exists := fs.collections[vp.collection]
+	if !exists {
+		return nil, filesystem.NewNotFoundError("stat", p)
+	}
+	if len(parts) == 2 {
+		info := dirInfo(c.name, MetaValueCollection, c.modTime)
+		info.Meta.Content = map[string]string{"docs": fmt.Sprint(len(c.docs)), "embedder": fs.embedder.Name()}
+		return info, nil
+	}
+	if vp.doc != "" {
+		doc, exists := c.docs[vp.doc]
+		if !exists {
+			return nil, filesystem.NewNotFoundError("stat", p)
+		}
+		info := docInfo(vp.doc, doc)
+		return &info, nil
+	}
+	for _, info := range collectionFiles(c) {
+		if info.Name == vp.file {
+			return &info, nil
+		}
+	}
+	return nil, filesystem.NewNotFoundError("stat", p)
+}
+
+// Rename moves a document within its collection, keeping its vector
+func (fs *vectorFS) Rename(oldPath, newPath string) error {
+	_, from, okFrom := parsePath(oldPath)
+	_, to, okTo := parsePath(newPath)
+	if !okFrom || !okTo || from.doc == "" || to.doc == "" || from.collection != to.collection {
+		return filesystem.NewNotSupportedError("rename", oldPath)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	c, exists := fs.collections[from.collection]
+	if !exists {
+		return filesystem.NewNotFoundError("rename", oldPath)
+	}
+	doc, exists := c.docs[from.doc]
+	if !exists {
+		return filesystem.NewNotFoundError("rename", oldPath)
+	}
+	delete(c.docs, from.doc)
+	c.docs[to.doc] = doc
+	c.modTime = time.Now()
+	return nil
+}
+
+func (fs *vectorFS) Chmod(p string, mode uint32) error {
+	return filesystem.NewNotSupportedError("chmod", p)
+}
+
+func (fs *vectorFS) Open(p string) (io.ReadCloser, error) {
+	data, err := fs.content(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fs *vectorFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return &vectorWriter{fs: fs, path: p}, nil
+}
+
+// vectorWriter embeds the whole document on close
+type vectorWriter struct {
+	fs   *vectorFS
+	path string
+	buf  bytes.Buffer
+}
+
+func (w *vectorWriter) Write(p []byte) (int, error) {
+	if int64(w.buf.Len()+len(p)) > w.fs.maxDocSize {
+		return 0, filesystem.NewQuotaExceededError(w.path, w.fs.maxDocSize, int64(w.buf.Len()))
+	}
+	return w.buf.Write(p)
+}
+
+func (w *vectorWriter) Close() error {
+	_, err := w.fs.Write(w.path, w.buf.Bytes())
+	return err
+}
+
+// Ensure VectorFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*VectorFSPlugin)(nil)
+var _ filesystem.FileSystem = (*vectorFS)(nil)
+var _ filesystem.ContextBinder = (*vectorFS)(nil)
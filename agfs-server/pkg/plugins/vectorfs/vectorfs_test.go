@@ -0,0 +1,94 @@
+package vectorfs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestFS(t *testing.T, cfg map[string]interface{}) *vectorFS {
+	t.Helper()
+	p := NewVectorFSPlugin()
+	if err := p.Validate(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Initialize(cfg); err != nil {
+		t.Fatal(err)
+	}
+	return p.fs
+}
+
+func search(t *testing.T, fs *vectorFS, collection, query string) []searchResult {
+	t.Helper()
+	data, err := fs.Write("/collections/"+collection+"/search", []byte(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []searchResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestHashSearch(t *testing.T) {
+	fs := newTestFS(t, map[string]interface{}{})
+	docs := map[string]string{
+		"go":     "Go is a garbage collected language with goroutines",
+		"rust":   "Rust manages memory with ownership and borrowing",
+		"recipe": "Whisk the eggs with sugar and bake the cake",
+	}
+	for id, text := range docs {
+		if _, err := fs.Write("/collections/notes/docs/"+id, []byte(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := search(t, fs, "notes", `{"query": "bake a cake with eggs", "k": 2}`)
+	if len(results) != 2 || results[0].ID != "recipe" {
+		t.Errorf("results = %+v", results)
+	}
+
+	if err := fs.Remove("/collections/notes/docs/recipe"); err != nil {
+		t.Fatal(err)
+	}
+	if results := search(t, fs, "notes", "goroutines"); len(results) != 2 || results[0].ID != "go" {
+		t.Errorf("results after remove = %+v", results)
+	}
+	if _, err := fs.Write("/collections/missing/search", []byte("x")); err == nil {
+		t.Errorf("search of a missing collection succeeded")
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []item
+		for i, text := range req.Input {
+			// Two dimensions: texts about cats point one way, the others the other way
+			v := []float32{0, 1}
+			if text == "cats" || text == "kittens" {
+				v = []float32{3, 0}
+			}
+			data = append(data, item{Index: i, Embedding: v})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	fs := newTestFS(t, map[string]interface{}{"embedder": "openai", "embedding_url": server.URL, "embedding_model": "m"})
+	fs.Write("/collections/c/docs/a", []byte("cats"))
+	fs.Write("/collections/c/docs/b", []byte("dogs"))
+	results := search(t, fs, "c", "kittens")
+	if len(results) != 2 || results[0].ID != "a" || results[0].Score < 0.99 {
+		t.Errorf("results = %+v", results)
+	}
+}
@@ -0,0 +1,519 @@
+// Package queuearchive drains queuefs queues into batched NDJSON files on another mount,
+// e.g. s3fs or sqlfs, on a schedule or once a queue holds enough messages. Messages are
+// removed from the queue only after their file is written, and the batch in flight is
+// checkpointed locally so that a failed or interrupted drain is retried without losing or
+// duplicating messages
+package queuearchive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the archiver configuration
+const (
+	DefaultStateDir      = "queue-archive"
+	DefaultInterval      = 5 * time.Minute
+	DefaultPollInterval  = 10 * time.Second
+	DefaultRetryInterval = 30 * time.Second
+	DefaultBatchSize     = 1000
+)
+
+// FileLayout names each batch file under the target (UTC), followed by its sequence number
+const FileLayout = "20060102-150405"
+
+// Archiver states reported in Status
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StateOK      = "ok"
+	StateFailed  = "failed" // The last drain failed and is retried with a backoff
+)
+
+// messagesDir is the directory of queuefs listing the pending messages of a queue
+const messagesDir = "messages"
+
+// ErrRunning is returned when an archiver is run while its previous drain has not finished
+var ErrRunning = errors.New("queue archiver already running")
+
+// Status reports the configuration, totals and last drain of an archiver
+type Status struct {
+	Name      string     `json:"name"`
+	Queue     string     `json:"queue"`
+	Target    string     `json:"target"`
+	Interval  string     `json:"interval"`
+	Threshold int        `json:"threshold,omitempty"`
+	BatchSize int        `json:"batchSize"`
+	State     string     `json:"state"`
+	Queued    int64      `json:"queued"` // Size of the queue when last checked
+	LastRun   *time.Time `json:"lastRun,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	LastFile  string     `json:"lastFile,omitempty"`
+	Archived  int        `json:"archived"` // Messages archived by the last drain
+	Error     string     `json:"error,omitempty"`
+	Failures  int        `json:"failures,omitempty"` // Failed drains in a row
+	NextRun   *time.Time `json:"nextRun,omitempty"`
+	Files     int64      `json:"files"`    // Files written since the archiver was created
+	Messages  int64      `json:"messages"` // Messages archived since the archiver was created
+	Bytes     int64      `json:"bytes"`
+	Pending   string     `json:"pending,omitempty"` // File of the batch in flight, retried by the next drain
+}
+
+// batch is a file being written and the IDs of the messages it holds
+type batch struct {
+	File string   `json:"file"`
+	IDs  []string `json:"ids"`
+}
+
+// checkpoint is the state of an archiver kept in the state directory across restarts
+type checkpoint struct {
+	Seq      int64  `json:"seq"` // Sequence number of the last file
+	Files    int64  `json:"files"`
+	Messages int64  `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+	LastFile string `json:"lastFile,omitempty"`
+	Pending  *batch `json:"pending,omitempty"`
+}
+
+// archiver is a configured archiver with its runtime state
+type archiver struct {
+	cfg           config.QueueArchiverConfig
+	interval      time.Duration
+	pollInterval  time.Duration
+	retryInterval time.Duration
+	batchSize     int
+	stateFile     string
+
+	runMu  sync.Mutex // held while the archiver drains its queue
+	mu     sync.Mutex // protects status and cp
+	status Status
+	cp     checkpoint
+}
+
+// Manager runs the archivers of the configuration
+type Manager struct {
+	fs        filesystem.FileSystem
+	archivers []*archiver
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewManager validates the configuration and loads the checkpoints of the archivers
+// Queues are read and batch files written through fs, so targets may live on any mount
+func NewManager(fs filesystem.FileSystem, cfg config.QueueArchiveConfig) (*Manager, error) {
+	m := &Manager{fs: fs, done: make(chan struct{})}
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+
+	names := make(map[string]bool)
+	for _, acfg := range cfg.Archivers {
+		if !acfg.Enabled {
+			continue
+		}
+		a, err := newArchiver(acfg, stateDir)
+		if err != nil {
+			return nil, err
+		}
+		if names[acfg.Name] {
+			return nil, fmt.Errorf("duplicate queue archiver name: %s", acfg.Name)
+		}
+		names[acfg.Name] = true
+		if err := a.load(); err != nil {
+			return nil, fmt.Errorf("queue archiver %s: %w", acfg.Name, err)
+		}
+		m.archivers = append(m.archivers, a)
+	}
+	return m, nil
+}
+
+func newArchiver(cfg config.QueueArchiverConfig, stateDir string) (*archiver, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("queue archiver name is required")
+	}
+	if strings.ContainsAny(cfg.Name, `/\`) {
+		return nil, fmt.Errorf("queue archiver %s: name must not contain slashes", cfg.Name)
+	}
+	if cfg.Queue == "" || cfg.Target == "" {
+		return nil, fmt.Errorf("queue archiver %s: queue and target are required", cfg.Name)
+	}
+	cfg.Queue = filesystem.NormalizePath(cfg.Queue)
+	cfg.Target = filesystem.NormalizePath(cfg.Target)
+	if cfg.Target == cfg.Queue || strings.HasPrefix(cfg.Target, cfg.Queue+"/") {
+		return nil, fmt.Errorf("queue archiver %s: target must not be inside the queue", cfg.Name)
+	}
+	if cfg.Threshold < 0 || cfg.BatchSize < 0 {
+		return nil, fmt.Errorf("queue archiver %s: threshold and batch_size must not be negative", cfg.Name)
+	}
+
+	a := &archiver{
+		cfg:       cfg,
+		batchSize: cfg.BatchSize,
+		stateFile: filepath.Join(stateDir, cfg.Name+".json"),
+	}
+	if a.batchSize == 0 {
+		a.batchSize = DefaultBatchSize
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		def   time.Duration
+		dst   *time.Duration
+	}{
+		{"interval", cfg.Interval, DefaultInterval, &a.interval},
+		{"poll_interval", cfg.PollInterval, DefaultPollInterval, &a.pollInterval},
+		{"retry_interval", cfg.RetryInterval, DefaultRetryInterval, &a.retryInterval},
+	} {
+		*d.dst = d.def
+		if d.value == "" {
+			continue
+		}
+		v, err := pluginconfig.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("queue archiver %s: invalid %s: %w", cfg.Name, d.name, err)
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("queue archiver %s: %s must be positive", cfg.Name, d.name)
+		}
+		*d.dst = v
+	}
+
+	a.status = Status{
+		Name:      cfg.Name,
+		Queue:     cfg.Queue,
+		Target:    cfg.Target,
+		Interval:  a.interval.String(),
+		Threshold: cfg.Threshold,
+		BatchSize: a.batchSize,
+		State:     StateIdle,
+	}
+	return a, nil
+}
+
+// load reads the checkpoint of the archiver, if it has one
+func (a *archiver) load() error {
+	data, err := os.ReadFile(a.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &a.cp); err != nil {
+		return fmt.Errorf("invalid checkpoint %s: %w", a.stateFile, err)
+	}
+	a.syncStatus()
+	return nil
+}
+
+// save writes the checkpoint of the archiver; the caller must hold a.mu
+func (a *archiver) save() error {
+	data, err := json.MarshalIndent(a.cp, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(a.stateFile), 0755)
+	}
+	if err == nil {
+		if err = os.WriteFile(a.stateFile+".tmp", data, 0644); err == nil {
+			err = os.Rename(a.stateFile+".tmp", a.stateFile)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	a.syncStatus()
+	return nil
+}
+
+// syncStatus copies the totals of the checkpoint to the status; the caller must hold a.mu
+// or own a
+func (a *archiver) syncStatus() {
+	a.status.Files = a.cp.Files
+	a.status.Messages = a.cp.Messages
+	a.status.Bytes = a.cp.Bytes
+	a.status.LastFile = a.cp.LastFile
+	a.status.Pending = ""
+	if a.cp.Pending != nil {
+		a.status.Pending = a.cp.Pending.File
+	}
+}
+
+// Start launches the loop of every archiver
+func (m *Manager) Start() {
+	for _, a := range m.archivers {
+		m.wg.Add(1)
+		go m.loop(a)
+	}
+	if len(m.archivers) > 0 {
+		log.Infof("[queuearchive] started %d archiver(s)", len(m.archivers))
+	}
+}
+
+// Stop stops the loops and waits for them to exit
+// A drain that is already running is allowed to finish
+func (m *Manager) Stop() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// loop drains the queue of a every interval, when it reaches the threshold of a or, after
+// a failure, with a backoff doubling from the retry interval up to the interval
+func (m *Manager) loop(a *archiver) {
+	defer m.wg.Done()
+
+	next := time.Now().Add(a.interval)
+	// A batch left in flight by a previous run of the server is retried right away
+	if a.snapshotStatus().Pending != "" {
+		next = time.Now()
+	}
+	retry := a.retryInterval
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
+	for {
+		a.setNextRun(next)
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		due := !now.Before(next)
+		if !due && a.cfg.Threshold > 0 && a.snapshotStatus().Failures == 0 {
+			if size, err := m.queueSize(a); err == nil && size >= int64(a.cfg.Threshold) {
+				due = true
+			}
+		}
+		if !due {
+			continue
+		}
+
+		_, err := m.run(a)
+		switch {
+		case errors.Is(err, ErrRunning):
+			continue
+		case err != nil:
+			log.Errorf("[queuearchive] archiver %s failed, retrying in %s: %v", a.cfg.Name, retry, err)
+			next = time.Now().Add(retry)
+			retry = min(retry*2, a.interval)
+		default:
+			next = time.Now().Add(a.interval)
+			retry = a.retryInterval
+		}
+	}
+}
+
+func (a *archiver) setNextRun(t time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.NextRun = &t
+}
+
+func (a *archiver) snapshotStatus() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+// Run drains a queue immediately and waits for it to finish; an empty name runs every
+// archiver
+func (m *Manager) Run(name string) ([]Status, error) {
+	var results []Status
+	found := false
+	for _, a := range m.archivers {
+		if name != "" && a.cfg.Name != name {
+			continue
+		}
+		found = true
+		status, err := m.run(a)
+		if err != nil && name != "" {
+			return []Status{status}, err
+		}
+		results = append(results, status)
+	}
+	if !found && name != "" {
+		return nil, filesystem.NewNotFoundError("queue archiver", name)
+	}
+	return results, nil
+}
+
+// Status returns the status of every archiver
+func (m *Manager) Status() []Status {
+	statuses := make([]Status, 0, len(m.archivers))
+	for _, a := range m.archivers {
+		statuses = append(statuses, a.snapshotStatus())
+	}
+	return statuses
+}
+
+// queueSize returns the number of messages in the queue of a
+func (m *Manager) queueSize(a *archiver) (int64, error) {
+	data, err := m.fs.Read(a.cfg.Queue+"/size", 0, -1)
+	if err != nil && len(data) == 0 {
+		return 0, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a queue: %w", a.cfg.Queue, err)
+	}
+	a.mu.Lock()
+	a.status.Queued = size
+	a.mu.Unlock()
+	return size, nil
+}
+
+// run finishes the batch left in flight, if any, then archives the messages of the queue
+// in batches until it holds no more than it did when the drain started
+func (m *Manager) run(a *archiver) (Status, error) {
+	if !a.runMu.TryLock() {
+		return a.snapshotStatus(), ErrRunning
+	}
+	defer a.runMu.Unlock()
+
+	start := time.Now()
+	a.mu.Lock()
+	a.status.State = StateRunning
+	a.status.LastRun = &start
+	pending := a.cp.Pending
+	a.mu.Unlock()
+
+	// The size includes the messages of the batch in flight, still in the queue
+	archived := 0
+	size, err := m.queueSize(a)
+	if err == nil && pending != nil {
+		archived, err = m.finish(a, pending)
+	}
+	for err == nil && int64(archived) < size {
+		var n int
+		n, err = m.archiveBatch(a)
+		if n == 0 {
+			break
+		}
+		archived += n
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.Duration = time.Since(start).Round(time.Millisecond).String()
+	a.status.Archived = archived
+	if err != nil {
+		a.status.State = StateFailed
+		a.status.Failures++
+		a.status.Error = err.Error()
+		return a.status, err
+	}
+	a.status.State = StateOK
+	a.status.Failures = 0
+	a.status.Error = ""
+	if archived > 0 {
+		log.Infof("[queuearchive] archiver %s: archived %d message(s) of %s in %s", a.cfg.Name, archived, a.cfg.Queue, a.status.Duration)
+	}
+	return a.status, nil
+}
+
+// archiveBatch writes the oldest messages of the queue, at most a batch of them, to a new
+// file and returns how many it archived
+func (m *Manager) archiveBatch(a *archiver) (int, error) {
+	dir := a.cfg.Queue + "/" + messagesDir
+	infos, _, err := filesystem.ReadDirPage(m.fs, dir, filesystem.ListPage{Limit: a.batchSize})
+	if err != nil {
+		return 0, err
+	}
+	if len(infos) > a.batchSize {
+		infos = infos[:a.batchSize]
+	}
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir {
+			ids = append(ids, info.Name)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	a.mu.Lock()
+	a.cp.Seq++
+	b := &batch{
+		File: path.Join(a.cfg.Target, fmt.Sprintf("%s-%06d.ndjson", time.Now().UTC().Format(FileLayout), a.cp.Seq)),
+		IDs:  ids,
+	}
+	a.cp.Pending = b
+	err = a.save()
+	a.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return m.finish(a, b)
+}
+
+// finish writes the file of b, unless a previous attempt already did, removes its messages
+// from the queue and clears the checkpointed batch; it returns how many messages it archived
+func (m *Manager) finish(a *archiver, b *batch) (int, error) {
+	dir := a.cfg.Queue + "/" + messagesDir
+	var size int64
+	if info, err := m.fs.Stat(b.File); err == nil {
+		size = info.Size
+	} else {
+		var buf bytes.Buffer
+		ids := make([]string, 0, len(b.IDs))
+		for _, id := range b.IDs {
+			data, err := m.fs.Read(dir+"/"+id, 0, -1)
+			if errors.Is(err, filesystem.ErrNotFound) {
+				// Consumed by someone else since it was listed
+				continue
+			}
+			if err != nil && len(data) == 0 {
+				return 0, fmt.Errorf("read message %s: %w", id, err)
+			}
+			buf.Write(bytes.TrimRight(data, "\n"))
+			buf.WriteByte('\n')
+			ids = append(ids, id)
+		}
+		b.IDs = ids
+		if len(ids) > 0 {
+			if err := filesystem.MkdirAll(m.fs, a.cfg.Target, 0755); err != nil {
+				return 0, err
+			}
+			if _, err := m.fs.Write(b.File, buf.Bytes()); err != nil {
+				return 0, fmt.Errorf("write %s: %w", b.File, err)
+			}
+			size = int64(buf.Len())
+		}
+	}
+
+	for _, id := range b.IDs {
+		if err := m.fs.Remove(dir + "/" + id); err != nil && !errors.Is(err, filesystem.ErrNotFound) {
+			return 0, fmt.Errorf("remove message %s: %w", id, err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cp.Pending = nil
+	if len(b.IDs) > 0 {
+		a.cp.Files++
+		a.cp.Messages += int64(len(b.IDs))
+		a.cp.Bytes += size
+		a.cp.LastFile = b.File
+		a.status.LastFile = b.File
+	}
+	if err := a.save(); err != nil {
+		return 0, err
+	}
+	return len(b.IDs), nil
+}
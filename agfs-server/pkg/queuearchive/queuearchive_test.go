@@ -0,0 +1,132 @@
+package queuearchive
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+)
+
+func TestArchiver(t *testing.T) {
+	mfs := mountablefs.NewMountableFS()
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory("queuefs", func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() })
+	if err := mfs.MountPlugin("queuefs", "/queuefs", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Mkdir("/queuefs/events", 0755); err != nil {
+		t.Fatal(err)
+	}
+	enqueue := func(msgs ...string) {
+		t.Helper()
+		for _, msg := range msgs {
+			if _, err := mfs.Write("/queuefs/events/enqueue", []byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	stateDir := t.TempDir()
+	cfg := config.QueueArchiveConfig{StateDir: stateDir, Archivers: []config.QueueArchiverConfig{
+		{Name: "events", Enabled: true, Queue: "/queuefs/events", Target: "/archive/events", BatchSize: 2},
+	}}
+
+	for _, bad := range []config.QueueArchiverConfig{
+		{Name: "a/b", Enabled: true, Queue: "/q", Target: "/t"},
+		{Name: "x", Enabled: true, Queue: "/q", Target: "/q/archive"},
+		{Name: "x", Enabled: true, Queue: "/q", Target: "/t", Interval: "-1s"},
+	} {
+		if _, err := NewManager(mfs, config.QueueArchiveConfig{StateDir: stateDir, Archivers: []config.QueueArchiverConfig{bad}}); err == nil {
+			t.Errorf("configuration accepted: %+v", bad)
+		}
+	}
+
+	m, err := NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue("one", "two", "three", "four", "five")
+
+	// The target is not mounted yet: the batch stays in the queue and in the checkpoint
+	statuses, err := m.Run("events")
+	if err == nil || statuses[0].State != StateFailed || statuses[0].Failures != 1 || statuses[0].Pending == "" {
+		t.Fatalf("run without target = %+v, %v", statuses, err)
+	}
+	pending := statuses[0].Pending
+	if size := readString(t, mfs, "/queuefs/events/size"); size != "5" {
+		t.Fatalf("queue size after failure = %s", size)
+	}
+
+	// A new manager resumes the batch in flight once the target is available
+	if err := mfs.MountPlugin("memfs", "/archive", nil); err != nil {
+		t.Fatal(err)
+	}
+	m, err = NewManager(mfs, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := m.Status()[0]; status.Pending != pending {
+		t.Fatalf("checkpoint not loaded: %+v", status)
+	}
+	statuses, err = m.Run("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := statuses[0]
+	if status.State != StateOK || status.Archived != 5 || status.Files != 3 || status.Messages != 5 || status.Pending != "" || status.Failures != 0 {
+		t.Fatalf("status = %+v", status)
+	}
+	if size := readString(t, mfs, "/queuefs/events/size"); size != "0" {
+		t.Errorf("queue size after drain = %s", size)
+	}
+
+	entries, err := mfs.ReadDir("/archive/events")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("batch files = %+v, %v", entries, err)
+	}
+	// Not every backend lists in name order; batch files sort by time and sequence
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	var got []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name, ".ndjson") {
+			t.Errorf("unexpected file %s", entry.Name)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(readString(t, mfs, "/archive/events/"+entry.Name)), "\n") {
+			var msg queuefs.QueueMessage
+			if err := json.Unmarshal([]byte(line), &msg); err != nil {
+				t.Fatalf("line %q: %v", line, err)
+			}
+			got = append(got, msg.Data)
+		}
+	}
+	if strings.Join(got, ",") != "one,two,three,four,five" {
+		t.Errorf("archived messages = %v", got)
+	}
+	if pending != "/archive/events/"+entries[0].Name {
+		t.Errorf("retried batch written to %s, want %s", entries[0].Name, pending)
+	}
+
+	// An empty queue writes nothing
+	if statuses, err := m.Run("events"); err != nil || statuses[0].Archived != 0 || statuses[0].Files != 3 {
+		t.Errorf("empty run = %+v, %v", statuses, err)
+	}
+	if _, err := m.Run("nope"); err == nil {
+		t.Error("unknown archiver: no error")
+	}
+}
+
+func readString(t *testing.T, fs filesystem.FileSystem, path string) string {
+	t.Helper()
+	data, err := fs.Read(path, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,321 @@
+// Package recorder captures API traffic to a file or a directory of the server, and replays it
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the recording configuration
+const (
+	DefaultMaxBody       = 1024 * 1024
+	DefaultFlushInterval = 5 * time.Second
+)
+
+// maxPending bounds the records waiting to be written; further records are dropped
+// rather than slowing requests down
+const maxPending = 10000
+
+// recordedHeaders are the request headers kept in records; credentials are never recorded
+var recordedHeaders = []string{"Content-Type"}
+
+// Record is one request and its response, stored as a JSON line
+type Record struct {
+	Seq               int64             `json:"seq"`
+	Time              time.Time         `json:"time"`
+	Method            string            `json:"method"`
+	URL               string            `json:"url"` // Path and query
+	Header            map[string]string `json:"header,omitempty"`
+	Body              []byte            `json:"body,omitempty"`
+	BodyTruncated     bool              `json:"bodyTruncated,omitempty"` // Such requests are not replayed
+	Status            int               `json:"status"`
+	Response          []byte            `json:"response,omitempty"`
+	ResponseTruncated bool              `json:"responseTruncated,omitempty"`
+	DurationMs        float64           `json:"durationMs"`
+}
+
+// sink stores batches of encoded records
+type sink interface {
+	write(batch []byte) error
+	close() error
+}
+
+// fileSink appends records to a local file
+type fileSink struct {
+	file *os.File
+}
+
+func (s *fileSink) write(batch []byte) error {
+	_, err := s.file.Write(batch)
+	return err
+}
+
+func (s *fileSink) close() error {
+	return s.file.Close()
+}
+
+// dirSink writes each batch to a new segment file in a directory of the server,
+// since filesystems cannot append in general
+type dirSink struct {
+	fs     filesystem.FileSystem
+	dir    string
+	prefix string
+	seq    int
+}
+
+func (s *dirSink) write(batch []byte) error {
+	s.seq++
+	name := path.Join(s.dir, fmt.Sprintf("%s-%06d.jsonl", s.prefix, s.seq))
+	_, err := s.fs.Write(name, batch)
+	return err
+}
+
+func (s *dirSink) close() error {
+	return nil
+}
+
+// Recorder is a middleware recording the requests it serves
+type Recorder struct {
+	sink          sink
+	maxBody       int64
+	flushInterval time.Duration
+	exclude       []string
+
+	seq     atomic.Int64
+	dropped atomic.Int64
+	records chan *Record
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates a recorder from cfg; fs receives the segment files when cfg.Dir is set
+func New(cfg config.RecordingConfig, fs filesystem.FileSystem) (*Recorder, error) {
+	maxBody := int64(DefaultMaxBody)
+	if cfg.MaxBody != "" {
+		var err error
+		if maxBody, err = pluginconfig.ParseSize(cfg.MaxBody); err != nil {
+			return nil, fmt.Errorf("recording: invalid max_body: %w", err)
+		}
+	}
+	flushInterval := DefaultFlushInterval
+	if cfg.FlushInterval != "" {
+		var err error
+		if flushInterval, err = pluginconfig.ParseDuration(cfg.FlushInterval); err != nil {
+			return nil, fmt.Errorf("recording: invalid flush_interval: %w", err)
+		}
+		if flushInterval <= 0 {
+			return nil, fmt.Errorf("recording: flush_interval must be positive")
+		}
+	}
+	exclude := cfg.Exclude
+	if exclude == nil {
+		exclude = []string{"/api/v1/health", "/livez", "/readyz"}
+	}
+
+	var s sink
+	switch {
+	case cfg.File != "" && cfg.Dir != "":
+		return nil, fmt.Errorf("recording: set either file or dir")
+	case cfg.File != "":
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("recording: %w", err)
+		}
+		s = &fileSink{file: f}
+	case cfg.Dir != "":
+		dir := filesystem.NormalizePath(cfg.Dir)
+		if _, err := fs.Stat(dir); err != nil {
+			if err := fs.Mkdir(dir, 0755); err != nil {
+				return nil, fmt.Errorf("recording: failed to create %s: %w", dir, err)
+			}
+		}
+		s = &dirSink{fs: fs, dir: dir, prefix: "traffic-" + time.Now().UTC().Format("20060102T150405")}
+	default:
+		return nil, fmt.Errorf("recording: file or dir is required")
+	}
+
+	return &Recorder{
+		sink:          s,
+		maxBody:       maxBody,
+		flushInterval: flushInterval,
+		exclude:       exclude,
+		records:       make(chan *Record, maxPending),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Start starts writing records in the background
+func (rec *Recorder) Start() {
+	rec.wg.Add(1)
+	go rec.writeLoop()
+}
+
+// Close writes the pending records and closes the sink
+func (rec *Recorder) Close() error {
+	close(rec.done)
+	rec.wg.Wait()
+	return rec.sink.close()
+}
+
+// Status reports the number of recorded and dropped requests
+func (rec *Recorder) Status() map[string]int64 {
+	return map[string]int64{"recorded": rec.seq.Load(), "dropped": rec.dropped.Load()}
+}
+
+func (rec *Recorder) writeLoop() {
+	defer rec.wg.Done()
+	ticker := time.NewTicker(rec.flushInterval)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	encoder := json.NewEncoder(&batch)
+	flush := func() {
+		if batch.Len() == 0 {
+			return
+		}
+		if err := rec.sink.write(batch.Bytes()); err != nil {
+			log.Errorf("[recorder] failed to write records: %v", err)
+		}
+		batch.Reset()
+	}
+	for {
+		select {
+		case r := <-rec.records:
+			encoder.Encode(r)
+			if batch.Len() >= 4*1024*1024 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-rec.done:
+			for {
+				select {
+				case r := <-rec.records:
+					encoder.Encode(r)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (rec *Recorder) excluded(p string) bool {
+	for _, prefix := range rec.exclude {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware records the requests served by next
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := &Record{
+			Time:   time.Now(),
+			Method: r.Method,
+			URL:    r.URL.RequestURI(),
+		}
+		for _, h := range recordedHeaders {
+			if v := r.Header.Get(h); v != "" {
+				if record.Header == nil {
+					record.Header = make(map[string]string)
+				}
+				record.Header[h] = v
+			}
+		}
+		body := &capture{limit: rec.maxBody}
+		if r.Body != nil {
+			r.Body = &teeBody{ReadCloser: r.Body, capture: body}
+		}
+		rw := &responseRecorder{ResponseWriter: w, status: http.StatusOK, body: capture{limit: rec.maxBody}}
+
+		next.ServeHTTP(rw, r)
+
+		record.Body, record.BodyTruncated = body.buf.Bytes(), body.truncated
+		record.Status = rw.status
+		record.Response, record.ResponseTruncated = rw.body.buf.Bytes(), rw.body.truncated
+		record.DurationMs = float64(time.Since(record.Time).Microseconds()) / 1000
+		record.Seq = rec.seq.Add(1)
+		select {
+		case rec.records <- record:
+		default:
+			if rec.dropped.Add(1)%1000 == 1 {
+				log.Warnf("[recorder] dropping records, the sink cannot keep up")
+			}
+		}
+	})
+}
+
+// capture keeps the first limit bytes written to it
+type capture struct {
+	limit     int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (c *capture) add(p []byte) {
+	if room := c.limit - int64(c.buf.Len()); int64(len(p)) > room {
+		c.buf.Write(p[:room])
+		c.truncated = true
+		return
+	}
+	c.buf.Write(p)
+}
+
+// teeBody captures the request body as the handler reads it
+type teeBody struct {
+	io.ReadCloser
+	capture *capture
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.add(p[:n])
+	return n, err
+}
+
+// responseRecorder captures the status and the body of the response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   capture
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	w.body.add(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses working
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
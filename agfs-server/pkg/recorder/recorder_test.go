@@ -0,0 +1,68 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	rec, err := New(config.RecordingConfig{Dir: "/traffic", MaxBody: "8"}, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Start()
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		w.Write(body)
+	})
+	server := httptest.NewServer(rec.Middleware(echo))
+	defer server.Close()
+
+	for _, req := range []struct{ url, body string }{
+		{"/api/v1/files?path=/a", "short"},
+		{"/api/v1/files?path=/b&fail=1", "tea"},
+		{"/api/v1/files?path=/c", "longer than eight bytes"},
+		{"/api/v1/health", ""},
+	} {
+		resp, err := http.Post(server.URL+req.url, "text/plain", strings.NewReader(req.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := fs.ReadDir("/traffic")
+	if err != nil || len(infos) != 1 {
+		t.Fatalf("got %d segments, err %v", len(infos), err)
+	}
+	data, err := fs.Read("/traffic/"+infos[0].Name, 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+
+	result, err := Replay(context.Background(), bytes.NewReader(data),
+		ReplayOptions{Server: server.URL, CompareBody: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The health check is excluded and the long body is skipped
+	if result.Total != 3 || result.Skipped != 1 || result.Matched != 2 || result.Mismatched != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
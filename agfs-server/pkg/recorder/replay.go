@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxMismatches bounds the mismatches kept in a replay result
+const maxMismatches = 100
+
+// ReplayOptions configures a replay
+type ReplayOptions struct {
+	Server      string  // Base URL of the server, e.g. http://localhost:8080
+	Token       string  // Bearer token sent with every request, if any
+	Speed       float64 // 1 keeps the recorded pacing, 2 replays twice as fast, 0 without pauses
+	CompareBody bool    // Also compare response bodies, not only status codes
+}
+
+// Mismatch describes a replayed request whose response differs from the recording
+type Mismatch struct {
+	Seq      int64  `json:"seq"`
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Recorded int    `json:"recorded"`
+	Replayed int    `json:"replayed"`
+	Reason   string `json:"reason"`
+}
+
+// ReplayResult summarizes a replay
+type ReplayResult struct {
+	Total      int        `json:"total"`
+	Replayed   int        `json:"replayed"`
+	Skipped    int        `json:"skipped"` // Requests recorded with a truncated body
+	Matched    int        `json:"matched"`
+	Mismatched int        `json:"mismatched"`
+	Errors     int        `json:"errors"` // Requests that failed without a response, also mismatched
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+}
+
+// Replay re-executes the records read from r, in order, against opts.Server
+func Replay(ctx context.Context, r io.Reader, opts ReplayOptions) (*ReplayResult, error) {
+	server := strings.TrimSuffix(opts.Server, "/")
+	client := &http.Client{}
+	result := &ReplayResult{}
+
+	mismatch := func(rec *Record, replayed int, reason string) {
+		result.Mismatched++
+		if len(result.Mismatches) < maxMismatches {
+			result.Mismatches = append(result.Mismatches, Mismatch{
+				Seq: rec.Seq, Method: rec.Method, URL: rec.URL,
+				Recorded: rec.Status, Replayed: replayed, Reason: reason,
+			})
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var first time.Time
+	start := time.Now()
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return result, fmt.Errorf("line %d: invalid record: %w", line, err)
+		}
+		result.Total++
+		if rec.BodyTruncated {
+			result.Skipped++
+			continue
+		}
+
+		// Keep the recorded pacing, scaled by the speed
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / opts.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return result, ctx.Err()
+				}
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, rec.Method, server+rec.URL, bytes.NewReader(rec.Body))
+		if err != nil {
+			return result, fmt.Errorf("record %d: %w", rec.Seq, err)
+		}
+		for k, v := range rec.Header {
+			req.Header.Set(k, v)
+		}
+		if opts.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+opts.Token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			result.Errors++
+			mismatch(&rec, 0, err.Error())
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		result.Replayed++
+
+		switch {
+		case err != nil:
+			result.Errors++
+			mismatch(&rec, resp.StatusCode, err.Error())
+		case resp.StatusCode != rec.Status:
+			mismatch(&rec, resp.StatusCode, "status differs")
+		case opts.CompareBody && !rec.ResponseTruncated && !bytes.Equal(body, rec.Response):
+			mismatch(&rec, resp.StatusCode, "body differs")
+		default:
+			result.Matched++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, nil
+}
@@ -0,0 +1,145 @@
+// Package scaffold generates the skeleton of a new plugin: a package with the plugin, an
+// in-memory FileSystem to replace with the real backend, and a test running the
+// filesystemtest conformance suite against it
+package scaffold
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
+
+// validName is the form of plugin names, which are also the package names
+var validName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+
+// Options describe the plugin to generate
+type Options struct {
+	Name  string // Plugin and package name, e.g. "weatherfs"
+	Dir   string // Directory the package directory is created in, e.g. "pkg/plugins"
+	Force bool   // Overwrite the files of an existing package
+}
+
+// Plugin is a generated plugin
+type Plugin struct {
+	Name       string   // Plugin and package name
+	Package    string   // Package name
+	Type       string   // Name of the file system type, e.g. "WeatherFS"
+	ImportPath string   // Import path of the package, "" if the directory is not in a Go module
+	Files      []string // Paths of the generated files
+}
+
+// TypeName derives the Go type name of a plugin: "weatherfs" becomes "WeatherFS"
+func TypeName(name string) string {
+	base := strings.TrimSuffix(name, "fs")
+	if base == "" {
+		base = name
+	}
+	return strings.ToUpper(base[:1]) + base[1:] + "FS"
+}
+
+// Generate writes the package of a new plugin to <Dir>/<Name>
+func Generate(opts Options) (*Plugin, error) {
+	if !validName.MatchString(opts.Name) {
+		return nil, fmt.Errorf("invalid plugin name %q: use lower case letters and digits, e.g. weatherfs", opts.Name)
+	}
+	dir, err := filepath.Abs(filepath.Join(opts.Dir, opts.Name))
+	if err != nil {
+		return nil, err
+	}
+	p := &Plugin{Name: opts.Name, Package: opts.Name, Type: TypeName(opts.Name)}
+	p.ImportPath, err = importPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{
+		opts.Name + ".go":      "plugin.go.tmpl",
+		opts.Name + "_test.go": "plugin_test.go.tmpl",
+	}
+	rendered := make(map[string][]byte, len(files))
+	for file, tmpl := range files {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err == nil && !opts.Force {
+			return nil, fmt.Errorf("%s already exists, pass -force to overwrite it", path)
+		}
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, tmpl, p); err != nil {
+			return nil, err
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("generated %s is invalid: %w", file, err)
+		}
+		rendered[path] = src
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, file := range []string{opts.Name + ".go", opts.Name + "_test.go"} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, rendered[path], 0644); err != nil {
+			return nil, err
+		}
+		p.Files = append(p.Files, path)
+	}
+	return p, nil
+}
+
+// importPath returns the import path of dir from the go.mod of the module containing it
+func importPath(dir string) (string, error) {
+	for root := dir; ; root = filepath.Dir(root) {
+		data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "module" {
+					rel, err := filepath.Rel(root, dir)
+					if err != nil {
+						return "", err
+					}
+					return strings.Trim(fields[1], `"`) + "/" + filepath.ToSlash(rel), nil
+				}
+			}
+			return "", fmt.Errorf("no module path in %s", filepath.Join(root, "go.mod"))
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if filepath.Dir(root) == root {
+			return "", nil
+		}
+	}
+}
+
+// Registration returns the snippets registering the plugin as a built-in: the import and
+// factory for pkg/embed/plugins.go, and a block for the sample configuration in main.go
+func (p *Plugin) Registration() string {
+	importPath := p.ImportPath
+	if importPath == "" {
+		importPath = "<module>/" + p.Package
+	}
+	return fmt.Sprintf(`// pkg/embed/plugins.go, in the imports
+	"%s"
+
+// pkg/embed/plugins.go, in BuiltinPlugins
+		"%s": func() plugin.ServicePlugin { return %s.New%sPlugin() },
+
+# cmd/server/main.go, in the plugins section of sampleConfig
+  %s:
+    enabled: false
+    path: "/%s"
+    config:
+      read_only: false
+`, importPath, p.Name, p.Package, p.Type, p.Name, p.Name)
+}
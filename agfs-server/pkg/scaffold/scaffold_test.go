@@ -0,0 +1,70 @@
+package scaffold
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeName(t *testing.T) {
+	for name, want := range map[string]string{"weatherfs": "WeatherFS", "cache": "CacheFS", "fs": "FsFS", "s3fs": "S3FS"} {
+		if got := TypeName(name); got != want {
+			t.Errorf("TypeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	// The package is generated inside the module so that it builds; testdata is skipped by ./...
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("testdata", "gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		os.Remove("testdata")
+	})
+
+	for _, name := range []string{"", "WeatherFS", "weather-fs", "1fs"} {
+		if _, err := Generate(Options{Name: name, Dir: dir}); err == nil {
+			t.Errorf("name %q accepted", name)
+		}
+	}
+
+	p, err := Generate(Options{Name: "samplefs", Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Files) != 2 || p.Type != "SampleFS" {
+		t.Fatalf("generated %+v", p)
+	}
+	if want := "github.com/c4pt0r/agfs/agfs-server/pkg/scaffold/" + filepath.ToSlash(dir) + "/samplefs"; p.ImportPath != want {
+		t.Errorf("import path = %s, want %s", p.ImportPath, want)
+	}
+	if reg := p.Registration(); !strings.Contains(reg, `"samplefs": func() plugin.ServicePlugin { return samplefs.NewSampleFSPlugin() },`) ||
+		!strings.Contains(reg, p.ImportPath) {
+		t.Errorf("registration:\n%s", reg)
+	}
+	if _, err := Generate(Options{Name: "samplefs", Dir: dir}); err == nil {
+		t.Error("existing package overwritten without force")
+	}
+	if _, err := Generate(Options{Name: "samplefs", Dir: dir, Force: true}); err != nil {
+		t.Errorf("generate with force: %v", err)
+	}
+
+	if testing.Short() {
+		t.Skip("skipping the build of the generated package in short mode")
+	}
+	// The skeleton passes vet and its own conformance tests
+	for _, args := range [][]string{{"vet"}, {"test", "-count=1"}} {
+		cmd := exec.Command("go", append(args, "./"+filepath.ToSlash(filepath.Join(dir, "samplefs")))...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s: %v\n%s", args[0], err, out)
+		}
+	}
+}
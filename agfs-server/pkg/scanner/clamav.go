@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of INSTREAM chunks sent to clamd
+const clamdChunkSize = 64 * 1024
+
+// clamavScanner talks to clamd over TCP using the INSTREAM command
+type clamavScanner struct {
+	addr    string
+	timeout time.Duration
+}
+
+func newClamAVScanner(addr string, timeout time.Duration) (*clamavScanner, error) {
+	addr = strings.TrimPrefix(addr, "tcp://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid clamd address %s: %w", addr, err)
+	}
+	return &clamavScanner{addr: addr, timeout: timeout}, nil
+}
+
+func (s *clamavScanner) Scan(path string, data []byte) (Result, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("clamd scan failed: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > clamdChunkSize {
+			n = clamdChunkSize
+		}
+		binary.BigEndian.PutUint32(size[:], uint32(n))
+		w.Write(size[:])
+		w.Write(data[:n])
+		data = data[n:]
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return Result{}, fmt.Errorf("clamd scan failed: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return Result{}, fmt.Errorf("clamd scan failed: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+// parseClamdReply parses replies such as "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (Result, error) {
+	reply = strings.TrimRight(reply, "\x00\n")
+	status := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case status == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Clean: false, Reason: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd scan failed: %s", reply)
+	}
+}
@@ -0,0 +1,105 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// icapDefaultPort is the standard ICAP port
+const icapDefaultPort = "1344"
+
+// icapScanner submits content to an ICAP server (RFC 3507) as an encapsulated HTTP response
+// A 204 reply means the content is clean; any modification is treated as a detection
+type icapScanner struct {
+	url     *url.URL
+	addr    string
+	timeout time.Duration
+}
+
+func newICAPScanner(addr string, timeout time.Duration) (*icapScanner, error) {
+	u, err := url.Parse(addr)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP URL: %s", addr)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), icapDefaultPort)
+	}
+	return &icapScanner{url: u, addr: host, timeout: timeout}, nil
+}
+
+func (s *icapScanner) Scan(path string, data []byte) (Result, error) {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return Result{}, fmt.Errorf("ICAP scan failed: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout))
+
+	reqHdr := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: agfs\r\n\r\n", (&url.URL{Path: path}).EscapedPath())
+	resHdr := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\nContent-Length: %d\r\n\r\n", len(data))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "RESPMOD %s ICAP/1.0\r\n", s.url.String())
+	fmt.Fprintf(&buf, "Host: %s\r\n", s.url.Host)
+	buf.WriteString("Allow: 204\r\n")
+	fmt.Fprintf(&buf, "Encapsulated: req-hdr=0, res-hdr=%d, res-body=%d\r\n\r\n", len(reqHdr), len(reqHdr)+len(resHdr))
+	buf.WriteString(reqHdr)
+	buf.WriteString(resHdr)
+	if len(data) > 0 {
+		fmt.Fprintf(&buf, "%x\r\n", len(data))
+		buf.Write(data)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("0\r\n\r\n")
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return Result{}, fmt.Errorf("ICAP scan failed: %w", err)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	statusLine, err := tp.ReadLine()
+	if err != nil {
+		return Result{}, fmt.Errorf("ICAP scan failed: %w", err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return Result{}, fmt.Errorf("ICAP scan failed: %w", err)
+	}
+	return parseICAPReply(statusLine, header)
+}
+
+// parseICAPReply interprets an ICAP status line and headers
+func parseICAPReply(statusLine string, header textproto.MIMEHeader) (Result, error) {
+	parts := strings.SplitN(statusLine, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "ICAP/") {
+		return Result{}, fmt.Errorf("ICAP scan failed: invalid status line %q", statusLine)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return Result{}, fmt.Errorf("ICAP scan failed: invalid status line %q", statusLine)
+	}
+
+	switch {
+	case code == 204:
+		return Result{Clean: true}, nil
+	case code == 200:
+		reason := header.Get("X-Infection-Found")
+		if reason == "" {
+			reason = header.Get("X-Violations-Found")
+		}
+		if reason == "" {
+			reason = "content modified by ICAP server"
+		}
+		return Result{Clean: false, Reason: reason}, nil
+	default:
+		return Result{}, fmt.Errorf("ICAP scan failed: %s", statusLine)
+	}
+}
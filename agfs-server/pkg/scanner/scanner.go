@@ -0,0 +1,57 @@
+package scanner
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Supported scanner kinds
+const (
+	KindWebhook = "webhook" // POST the content to an HTTP endpoint that returns a JSON verdict
+	KindClamAV  = "clamav"  // Stream the content to clamd using the INSTREAM command
+	KindICAP    = "icap"    // Send the content to an ICAP server using RESPMOD
+)
+
+// DefaultTimeout bounds a single scan when no timeout is configured
+const DefaultTimeout = 30 * time.Second
+
+// Result is the verdict of a scan
+type Result struct {
+	Clean  bool   `json:"clean"`
+	Reason string `json:"reason,omitempty"` // Why the content was flagged, e.g. the signature name
+}
+
+// Scanner inspects file content before it is written
+type Scanner interface {
+	// Scan inspects data that is about to be written to path
+	// An error means the scan itself failed, not that the content was flagged
+	Scan(path string, data []byte) (Result, error)
+}
+
+// New creates a scanner of the given kind
+// addr is an http(s) URL for webhooks, "host:port" or "tcp://host:port" for clamd
+// and "icap://host[:port]/service" for ICAP
+func New(kind, addr string, timeout time.Duration) (Scanner, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("scanner address is required")
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	switch kind {
+	case KindWebhook:
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid webhook URL: %s", addr)
+		}
+		return newWebhookScanner(addr, timeout), nil
+	case KindClamAV:
+		return newClamAVScanner(addr, timeout)
+	case KindICAP:
+		return newICAPScanner(addr, timeout)
+	default:
+		return nil, fmt.Errorf("unknown scanner: %s (supported: %s, %s, %s)", kind, KindWebhook, KindClamAV, KindICAP)
+	}
+}
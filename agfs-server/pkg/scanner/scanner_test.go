@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Header.Get("X-AGFS-Path") == "/broken":
+			http.Error(w, "scanner overloaded", http.StatusServiceUnavailable)
+		case r.Header.Get("X-AGFS-Path") == "/garbled":
+			w.Write([]byte("not json"))
+		case strings.Contains(string(body), "EICAR"):
+			w.Write([]byte(`{"clean": false, "reason": "Eicar-Test-Signature"}`))
+		default:
+			w.Write([]byte(`{"clean": true}`))
+		}
+	}))
+	defer server.Close()
+
+	s, err := New(KindWebhook, server.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := s.Scan("/a.txt", []byte("hello")); err != nil || !result.Clean {
+		t.Errorf("clean content = %+v, %v", result, err)
+	}
+	if result, err := s.Scan("/a.txt", []byte("X5O!P%@AP EICAR")); err != nil || result.Clean || result.Reason != "Eicar-Test-Signature" {
+		t.Errorf("flagged content = %+v, %v", result, err)
+	}
+	for _, p := range []string{"/broken", "/garbled"} {
+		if _, err := s.Scan(p, []byte("hello")); err == nil {
+			t.Errorf("%s: no error", p)
+		}
+	}
+
+	// A scanner that cannot be reached is an error, not a verdict
+	server.Close()
+	if _, err := s.Scan("/a.txt", []byte("hello")); err == nil {
+		t.Error("unreachable scanner: no error")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, c := range []struct {
+		kind, addr string
+		ok         bool
+	}{
+		{KindWebhook, "https://scanner.example.com/scan", true},
+		{KindWebhook, "ftp://scanner.example.com", false},
+		{KindClamAV, "tcp://127.0.0.1:3310", true},
+		{KindClamAV, "127.0.0.1", false},
+		{KindICAP, "icap://127.0.0.1/avscan", true},
+		{KindICAP, "http://127.0.0.1/avscan", false},
+		{KindWebhook, "", false},
+		{"antivirus", "http://127.0.0.1", false},
+	} {
+		if _, err := New(c.kind, c.addr, 0); (err == nil) != c.ok {
+			t.Errorf("New(%s, %q): %v", c.kind, c.addr, err)
+		}
+	}
+}
+
+func TestReplies(t *testing.T) {
+	if r, err := parseClamdReply("stream: OK\x00"); err != nil || !r.Clean {
+		t.Errorf("clamd OK = %+v, %v", r, err)
+	}
+	if r, err := parseClamdReply("stream: Eicar-Signature FOUND\x00"); err != nil || r.Clean || r.Reason != "Eicar-Signature" {
+		t.Errorf("clamd FOUND = %+v, %v", r, err)
+	}
+	if _, err := parseClamdReply("INSTREAM size limit exceeded. ERROR\x00"); err == nil {
+		t.Error("clamd error: no error")
+	}
+
+	if r, err := parseICAPReply("ICAP/1.0 204 No Content", nil); err != nil || !r.Clean {
+		t.Errorf("ICAP 204 = %+v, %v", r, err)
+	}
+	header := textproto.MIMEHeader{"X-Infection-Found": {"Type=0; Threat=Eicar;"}}
+	if r, err := parseICAPReply("ICAP/1.0 200 OK", header); err != nil || r.Clean || r.Reason != "Type=0; Threat=Eicar;" {
+		t.Errorf("ICAP 200 = %+v, %v", r, err)
+	}
+	if _, err := parseICAPReply("ICAP/1.0 500 Server Error", nil); err == nil {
+		t.Error("ICAP 500: no error")
+	}
+}
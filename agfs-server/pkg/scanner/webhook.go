@@ -0,0 +1,54 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookScanner posts content to an HTTP endpoint
+// The endpoint receives the raw content with the target path in the X-AGFS-Path header
+// and answers with a JSON Result, e.g. {"clean": false, "reason": "contains secrets"}
+type webhookScanner struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookScanner(url string, timeout time.Duration) *webhookScanner {
+	return &webhookScanner{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *webhookScanner) Scan(path string, data []byte) (Result, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-AGFS-Path", path)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("webhook scan failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return Result{}, fmt.Errorf("webhook scan failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("webhook scan failed: HTTP %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	var result Result
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Result{}, fmt.Errorf("webhook scan failed: invalid response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,23 @@
+package search
+
+import "sync"
+
+var (
+	defaultMu      sync.RWMutex
+	defaultIndexer *Indexer
+)
+
+// SetDefault sets the indexer used by components that cannot be handed one
+// directly, such as plugins created from a factory (e.g. searchfs)
+func SetDefault(x *Indexer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultIndexer = x
+}
+
+// Default returns the indexer set with SetDefault, or nil if search is disabled
+func Default() *Indexer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultIndexer
+}
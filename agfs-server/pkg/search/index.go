@@ -0,0 +1,267 @@
+// Package search indexes the names and text content of files for the search API and
+// searchfs. The index is an in-memory inverted index of lower-cased words, without stemming
+// or language analysis; hits are scored by occurrences, with a boost for file names.
+package search
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// nameMatchBoost is the score of a query term found in the file name
+// relative to one occurrence in the content
+const nameMatchBoost = 5.0
+
+// Hit is a search result
+type Hit struct {
+	Path        string    `json:"path"`
+	Score       float64   `json:"score"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	ContentType string    `json:"contentType,omitempty"`
+}
+
+// Stats summarizes the index
+type Stats struct {
+	Documents int `json:"documents"`
+	Terms     int `json:"terms"`
+}
+
+// document is an indexed file
+type document struct {
+	path        string
+	size        int64
+	modTime     time.Time
+	contentType string
+	nameTerms   []string
+	terms       map[string]int // content term -> frequency
+}
+
+// Index is an in-memory inverted index over file names and text content
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]struct{} // term -> paths containing it in the name or content
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]struct{}),
+	}
+}
+
+// Tokenize splits text into lower-case terms of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add indexes or re-indexes the file at p
+// content may be nil for files that are only indexed by name
+func (idx *Index) Add(p string, size int64, modTime time.Time, contentType string, content []byte) {
+	doc := &document{
+		path:        p,
+		size:        size,
+		modTime:     modTime,
+		contentType: contentType,
+		terms:       make(map[string]int),
+	}
+	base := strings.ToLower(path.Base(p))
+	doc.nameTerms = append(Tokenize(base), base)
+	for _, term := range Tokenize(string(content)) {
+		doc.terms[term]++
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(p)
+	idx.docs[p] = doc
+	for _, term := range doc.nameTerms {
+		idx.post(term, p)
+	}
+	for term := range doc.terms {
+		idx.post(term, p)
+	}
+}
+
+func (idx *Index) post(term, p string) {
+	paths, ok := idx.postings[term]
+	if !ok {
+		paths = make(map[string]struct{})
+		idx.postings[term] = paths
+	}
+	paths[p] = struct{}{}
+}
+
+// Remove drops p and everything below it from the index
+func (idx *Index) Remove(p string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(p)
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for docPath := range idx.docs {
+		if strings.HasPrefix(docPath, prefix) {
+			idx.removeLocked(docPath)
+		}
+	}
+}
+
+// removeUnseen drops documents at or below p that are not in seen
+func (idx *Index) removeUnseen(p string, seen map[string]bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	prefix := strings.TrimSuffix(p, "/") + "/"
+	for docPath := range idx.docs {
+		if (docPath == p || strings.HasPrefix(docPath, prefix)) && !seen[docPath] {
+			idx.removeLocked(docPath)
+		}
+	}
+}
+
+func (idx *Index) removeLocked(p string) {
+	doc, ok := idx.docs[p]
+	if !ok {
+		return
+	}
+	delete(idx.docs, p)
+	unpost := func(term string) {
+		if paths, ok := idx.postings[term]; ok {
+			delete(paths, p)
+			if len(paths) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	for _, term := range doc.nameTerms {
+		unpost(term)
+	}
+	for term := range doc.terms {
+		unpost(term)
+	}
+}
+
+// Search returns files matching every term of query, best matches first
+// Terms may contain "*" wildcards ("repo*", "*.pdf"), "name:term" only matches file names.
+// Results are restricted to paths under within (use "/" for everything); limit <= 0 means no limit
+func (idx *Index) Search(query, within string, limit int) []Hit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	type clause struct {
+		terms    []string // Alternatives, any of them satisfies the clause
+		nameOnly bool
+	}
+	var clauses []clause
+	for _, field := range strings.Fields(query) {
+		nameOnly := false
+		if strings.HasPrefix(strings.ToLower(field), "name:") {
+			nameOnly = true
+			field = field[len("name:"):]
+		}
+		field = strings.ToLower(field)
+
+		if !strings.Contains(field, "*") {
+			// Every word of a plain field must match, "q3-report" means "q3 report"
+			for _, term := range Tokenize(field) {
+				clauses = append(clauses, clause{terms: []string{term}, nameOnly: nameOnly})
+			}
+			continue
+		}
+
+		// Wildcards match whole terms, including the full lower-case file name
+		c := clause{nameOnly: nameOnly}
+		for term := range idx.postings {
+			if ok, _ := path.Match(field, term); ok {
+				c.terms = append(c.terms, term)
+			}
+		}
+		if len(c.terms) == 0 {
+			// A clause that cannot match anything makes the whole query empty
+			return []Hit{}
+		}
+		clauses = append(clauses, c)
+	}
+	if len(clauses) == 0 {
+		return []Hit{}
+	}
+
+	within = strings.TrimSuffix(within, "/")
+	scores := make(map[string]float64)
+	for i, c := range clauses {
+		matched := make(map[string]float64)
+		for _, term := range c.terms {
+			for p := range idx.postings[term] {
+				if within != "" && p != within && !strings.HasPrefix(p, within+"/") {
+					continue
+				}
+				doc := idx.docs[p]
+				score := 0.0
+				if containsTerm(doc.nameTerms, term) {
+					score += nameMatchBoost
+				}
+				if !c.nameOnly {
+					score += float64(doc.terms[term])
+				}
+				if score > 0 {
+					matched[p] += score
+				}
+			}
+		}
+		if i == 0 {
+			scores = matched
+			continue
+		}
+		for p := range scores {
+			if s, ok := matched[p]; ok {
+				scores[p] += s
+			} else {
+				delete(scores, p)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for p, score := range scores {
+		doc := idx.docs[p]
+		hits = append(hits, Hit{
+			Path:        p,
+			Score:       score,
+			Size:        doc.size,
+			ModTime:     doc.modTime,
+			ContentType: doc.contentType,
+		})
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Score != hits[b].Score {
+			return hits[a].Score > hits[b].Score
+		}
+		return hits[a].Path < hits[b].Path
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func containsTerm(terms []string, term string) bool {
+	for _, t := range terms {
+		if t == term {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats returns the size of the index
+func (idx *Index) Stats() Stats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return Stats{Documents: len(idx.docs), Terms: len(idx.postings)}
+}
@@ -0,0 +1,44 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex()
+	now := time.Now()
+	idx.Add("/docs/q3-report.md", 12, now, "text/markdown", []byte("revenue grew"))
+	idx.Add("/docs/notes.txt", 6, now, "text/plain", []byte("report report"))
+	idx.Add("/scans/invoice.pdf", 100, now, "application/pdf", nil)
+
+	tests := []struct {
+		query  string
+		within string
+		want   []string
+	}{
+		{"report", "/", []string{"/docs/q3-report.md", "/docs/notes.txt"}}, // name matches rank first
+		{"q3-report", "/", []string{"/docs/q3-report.md"}},
+		{"rev* report", "/", []string{"/docs/q3-report.md"}},
+		{"name:report", "/", []string{"/docs/q3-report.md"}},
+		{"name:*.pdf", "/", []string{"/scans/invoice.pdf"}},
+		{"report", "/scans", nil},
+		{"report missing", "/", nil},
+	}
+	for _, tt := range tests {
+		hits := idx.Search(tt.query, tt.within, 0)
+		if len(hits) != len(tt.want) {
+			t.Fatalf("Search(%q): got %d hits %v, want %v", tt.query, len(hits), hits, tt.want)
+		}
+		for i, hit := range hits {
+			if hit.Path != tt.want[i] {
+				t.Errorf("Search(%q)[%d] = %s, want %s", tt.query, i, hit.Path, tt.want[i])
+			}
+		}
+	}
+
+	idx.Remove("/docs")
+	if hits := idx.Search("report", "/", 0); len(hits) != 0 {
+		t.Fatalf("expected no hits after removing /docs, got %v", hits)
+	}
+}
@@ -0,0 +1,298 @@
+package search
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/archive"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/contentmeta"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultMaxFileSize is the largest file whose content is indexed by default
+	DefaultMaxFileSize = 1024 * 1024
+
+	// DefaultReindexInterval is how often the configured paths are fully recrawled
+	DefaultReindexInterval = time.Hour
+
+	// changeQueueSize bounds the changes waiting to be indexed
+	changeQueueSize = 4096
+)
+
+// ChangeSource publishes mutations, implemented by MountableFS
+type ChangeSource interface {
+	Subscribe(fn func(mountablefs.Change)) func()
+}
+
+// IndexerStatus reports the state of the indexer
+type IndexerStatus struct {
+	Paths       []string   `json:"paths"`
+	Documents   int        `json:"documents"`
+	Terms       int        `json:"terms"`
+	Crawling    bool       `json:"crawling"`
+	LastCrawl   *time.Time `json:"lastCrawl,omitempty"`
+	CrawlErrors int        `json:"crawlErrors"`
+	Pending     int        `json:"pending"` // Changes waiting to be indexed
+}
+
+// Indexer keeps an Index up to date with the configured paths
+// It crawls them at startup and periodically, and applies changes from the
+// MountableFS change journal in between
+type Indexer struct {
+	fs          filesystem.FileSystem
+	index       *Index
+	paths       []string
+	filter      *archive.Filter
+	maxFileSize int64
+	interval    time.Duration
+
+	changes     chan mountablefs.Change
+	unsubscribe func()
+	done        chan struct{}
+	wg          sync.WaitGroup
+
+	mu          sync.Mutex // protects the fields below
+	crawling    bool
+	lastCrawl   *time.Time
+	crawlErrors int
+	dirty       bool // changes were dropped, the next crawl catches up
+}
+
+// NewIndexer validates cfg and creates an indexer reading from fs
+func NewIndexer(fs filesystem.FileSystem, cfg config.SearchConfig) (*Indexer, error) {
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("search: at least one path is required")
+	}
+
+	x := &Indexer{
+		fs:          fs,
+		index:       NewIndex(),
+		maxFileSize: cfg.MaxFileSize,
+		interval:    DefaultReindexInterval,
+		changes:     make(chan mountablefs.Change, changeQueueSize),
+		done:        make(chan struct{}),
+	}
+	for _, p := range cfg.Paths {
+		x.paths = append(x.paths, filesystem.NormalizePath(p))
+	}
+	if x.maxFileSize <= 0 {
+		x.maxFileSize = DefaultMaxFileSize
+	}
+	if cfg.ReindexInterval != "" {
+		d, err := pluginconfig.ParseDuration(cfg.ReindexInterval)
+		if err != nil {
+			return nil, fmt.Errorf("search: invalid reindex_interval: %w", err)
+		}
+		x.interval = d
+	}
+	filter, err := archive.NewFilter(strings.Join(cfg.Include, ","), strings.Join(cfg.Exclude, ","))
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	x.filter = filter
+
+	return x, nil
+}
+
+// Index returns the index maintained by the indexer
+func (x *Indexer) Index() *Index {
+	return x.index
+}
+
+// Start subscribes to source and begins crawling in the background
+func (x *Indexer) Start(source ChangeSource) {
+	if source != nil {
+		x.unsubscribe = source.Subscribe(func(c mountablefs.Change) {
+			select {
+			case x.changes <- c:
+			default:
+				// Never block the writer, the next crawl picks the change up
+				x.mu.Lock()
+				x.dirty = true
+				x.mu.Unlock()
+			}
+		})
+	}
+
+	x.wg.Add(1)
+	go x.run()
+}
+
+// Stop stops indexing and waits for the background worker to exit
+func (x *Indexer) Stop() {
+	if x.unsubscribe != nil {
+		x.unsubscribe()
+	}
+	close(x.done)
+	x.wg.Wait()
+}
+
+// Status returns the current indexer status
+func (x *Indexer) Status() IndexerStatus {
+	stats := x.index.Stats()
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return IndexerStatus{
+		Paths:       x.paths,
+		Documents:   stats.Documents,
+		Terms:       stats.Terms,
+		Crawling:    x.crawling,
+		LastCrawl:   x.lastCrawl,
+		CrawlErrors: x.crawlErrors,
+		Pending:     len(x.changes),
+	}
+}
+
+func (x *Indexer) run() {
+	defer x.wg.Done()
+
+	x.crawlAll()
+
+	var tick <-chan time.Time
+	if x.interval > 0 {
+		ticker := time.NewTicker(x.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-x.done:
+			return
+		case c := <-x.changes:
+			x.apply(c)
+			if len(x.changes) == 0 && x.isDirty() {
+				x.crawlAll()
+			}
+		case <-tick:
+			x.crawlAll()
+		}
+	}
+}
+
+func (x *Indexer) isDirty() bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.dirty
+}
+
+// crawlAll rebuilds the index entries of every configured path
+func (x *Indexer) crawlAll() {
+	x.mu.Lock()
+	x.crawling = true
+	x.dirty = false
+	x.mu.Unlock()
+
+	start := time.Now()
+	errors := 0
+	for _, root := range x.paths {
+		errors += x.crawl(root, root)
+	}
+
+	x.mu.Lock()
+	x.crawling = false
+	x.lastCrawl = &start
+	x.crawlErrors = errors
+	x.mu.Unlock()
+
+	stats := x.index.Stats()
+	log.Infof("[search] indexed %d files (%d terms) in %s", stats.Documents, stats.Terms, time.Since(start).Round(time.Millisecond))
+}
+
+// crawl indexes every file under p, which lies within the configured root
+// Entries that disappeared since the last crawl are dropped; it returns the number of errors
+func (x *Indexer) crawl(root, p string) int {
+	seen := make(map[string]bool)
+	errors := 0
+	filesystem.Walk(x.fs, p, func(fp string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			errors++
+			return nil
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(fp, root), "/")
+		if x.filter.Excluded(rel) {
+			if info.IsDir {
+				return filesystem.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir || !x.filter.Included(rel) {
+			return nil
+		}
+		seen[fp] = true
+		if err := x.indexFile(fp, info); err != nil {
+			errors++
+		}
+		return nil
+	})
+
+	x.index.removeUnseen(p, seen)
+	return errors
+}
+
+// indexFile indexes a single file, reading its content if it is small enough and textual
+func (x *Indexer) indexFile(p string, info *filesystem.FileInfo) error {
+	meta := contentmeta.Detect(x.fs, p, info)
+	contentType := meta[contentmeta.MetaKeyContentType]
+
+	var content []byte
+	if info.Size <= x.maxFileSize && (meta[contentmeta.MetaKeyLines] != "" || contentmeta.IsTextType(contentType)) {
+		data, err := x.fs.Read(p, 0, -1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		content = data
+	}
+
+	x.index.Add(p, info.Size, info.ModTime, contentType, content)
+	return nil
+}
+
+// rootOf returns the configured root containing p
+func (x *Indexer) rootOf(p string) (string, bool) {
+	for _, root := range x.paths {
+		if root == "/" || p == root || strings.HasPrefix(p, root+"/") {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+// apply updates the index after a change
+func (x *Indexer) apply(c mountablefs.Change) {
+	switch c.Op {
+	case mountablefs.ChangeRemove, mountablefs.ChangeRemoveAll:
+		x.index.Remove(c.Path)
+	case mountablefs.ChangeRename:
+		x.index.Remove(c.Path)
+		if root, ok := x.rootOf(c.NewPath); ok {
+			x.crawl(root, c.NewPath)
+		}
+	case mountablefs.ChangeMkdir:
+		// Nothing to index until files are written
+	default:
+		root, ok := x.rootOf(c.Path)
+		if !ok {
+			return
+		}
+		info, err := x.fs.Stat(c.Path)
+		if err != nil || info.IsDir {
+			return
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(c.Path, root), "/")
+		if !x.filter.Included(rel) {
+			return
+		}
+		if err := x.indexFile(c.Path, info); err != nil {
+			log.Debugf("[search] failed to index %s: %v", c.Path, err)
+		}
+	}
+}
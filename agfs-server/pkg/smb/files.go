@@ -0,0 +1,635 @@
+package smb
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// open is a file or directory a session opened
+// Files opened for writing are buffered whole, like the handles of the HTTP API: the first
+// write or resize reads the content, which is written back on flush and close.
+type open struct {
+	id            uint64
+	tree          *tree
+	path          string
+	dir           bool
+	access        uint32 // Granted
+	deleteOnClose bool
+
+	data   []byte // Content, once loaded
+	loaded bool
+	dirty  bool
+
+	// Directory enumeration
+	entries []filesystem.FileInfo
+	pattern string
+	pos     int
+	listed  bool
+}
+
+// fileID encodes the persistent and volatile IDs of an open, which are the same
+func (o *open) fileID() [16]byte {
+	var id [16]byte
+	binary.LittleEndian.PutUint64(id[:], o.id)
+	binary.LittleEndian.PutUint64(id[8:], o.id)
+	return id
+}
+
+// name returns the path of an open in its share, with backslashes
+func (o *open) name() string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(o.path, o.tree.share.path), "/")
+	return strings.ReplaceAll(rel, "/", `\`)
+}
+
+// lookup returns the open of the file ID at offset of a request; a related request
+// passes the ID of the previous create as all ones
+func (c *conn) lookup(r *request, offset int) (*open, uint32) {
+	if len(r.msg) < offset+16 {
+		return nil, statusInvalidParameter
+	}
+	var id [16]byte
+	copy(id[:], r.msg[offset:])
+	if id == [16]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF} && r.Flags&flagRelated != 0 {
+		id = r.c.fileID
+	}
+	le := binary.LittleEndian
+	o := r.sess.opens[le.Uint64(id[8:])]
+	if o == nil || o.tree != r.tree || le.Uint64(id[:]) != o.id {
+		return nil, statusFileClosed
+	}
+	return o, statusOK
+}
+
+// grantedAccess maps the access a create requests to the access granted on a share
+func grantedAccess(requested uint32, readOnly bool) (uint32, bool) {
+	limit := uint32(accessAll)
+	if readOnly {
+		limit = accessReadOnly
+		if requested&accessMaximumAllowed == 0 && requested&accessWriting != 0 {
+			return 0, false
+		}
+	}
+	granted := requested
+	if requested&(accessMaximumAllowed|accessGenericAll) != 0 {
+		granted |= accessAll
+	}
+	if requested&accessGenericRead != 0 {
+		granted |= accessReadOnly
+	}
+	if requested&accessGenericWrite != 0 {
+		granted |= accessWriteData | accessAppendData | accessWriteEA | accessWriteAttributes | accessReadControl | accessSynchronize
+	}
+	if requested&accessGenericExecute != 0 {
+		granted |= accessExecute | accessReadAttributes | accessReadControl | accessSynchronize
+	}
+	return granted & limit, true
+}
+
+func (c *conn) create(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+56 {
+		return statusInvalidParameter, nil
+	}
+	sh := r.tree.share
+	if sh == nil {
+		return statusObjectNameNotFound, nil // No pipes on IPC$
+	}
+	le := binary.LittleEndian
+	requested := le.Uint32(r.msg[88:])
+	disposition := le.Uint32(r.msg[100:])
+	options := le.Uint32(r.msg[104:])
+	name := decodeUTF16(body(r.msg, int(le.Uint16(r.msg[108:])), int(le.Uint16(r.msg[110:]))))
+	p, rel, status := sharePath(sh, name)
+	if status != statusOK {
+		return status, nil
+	}
+	if disposition > fileOverwriteIf || options&optionDirectory != 0 && options&optionNonDirectory != 0 {
+		return statusInvalidParameter, nil
+	}
+	readOnly := r.tree.readOnly
+	access, ok := grantedAccess(requested, readOnly)
+	if !ok || readOnly && disposition != fileOpen && disposition != fileOpenIf {
+		return statusAccessDenied, nil
+	}
+	deleteOnClose := options&optionDeleteOnClose != 0
+	if deleteOnClose && (access&accessDelete == 0 || rel == "") {
+		return statusAccessDenied, nil
+	}
+
+	fs := r.sess.fs(c.s)
+	info, err := fs.Stat(p)
+	if err != nil && !filesystem.IsNotFound(err) {
+		return errorStatus(err), nil
+	}
+	var action uint32
+	if err == nil {
+		switch {
+		case disposition == fileCreate:
+			return statusObjectNameCollision, nil
+		case options&optionDirectory != 0 && !info.IsDir:
+			return statusNotADirectory, nil
+		case options&optionNonDirectory != 0 && info.IsDir:
+			return statusFileIsADirectory, nil
+		}
+		action = actionOpened
+		switch disposition {
+		case fileSupersede, fileOverwrite, fileOverwriteIf:
+			if info.IsDir {
+				return statusFileIsADirectory, nil
+			}
+			if _, err := fs.Write(p, []byte{}); err != nil {
+				return errorStatus(err), nil
+			}
+			action = actionOverwritten
+			if disposition == fileSupersede {
+				action = actionSuperseded
+			}
+		}
+	} else {
+		if disposition == fileOpen || disposition == fileOverwrite {
+			return c.missingStatus(fs, p), nil
+		}
+		if readOnly {
+			return statusAccessDenied, nil
+		}
+		if parent, err := fs.Stat(path.Dir(p)); err != nil || !parent.IsDir {
+			return statusObjectPathNotFound, nil
+		}
+		if options&optionDirectory != 0 {
+			err = fs.Mkdir(p, 0755)
+		} else {
+			err = fs.Create(p)
+		}
+		if err != nil {
+			return errorStatus(err), nil
+		}
+		action = actionCreated
+	}
+	if info, err = fs.Stat(p); err != nil {
+		return errorStatus(err), nil
+	}
+
+	r.sess.nextOpen++
+	o := &open{
+		id:            r.sess.nextOpen,
+		tree:          r.tree,
+		path:          p,
+		dir:           info.IsDir,
+		access:        access,
+		deleteOnClose: deleteOnClose,
+	}
+	r.sess.opens[o.id] = o
+	r.c.fileID = o.fileID()
+
+	var w buffer
+	w.u16(89)
+	w.u8(0) // No oplock
+	w.u8(0)
+	w.u32(action)
+	putTimes(&w, info)
+	w.u64(allocationSize(info.Size))
+	w.u64(uint64(info.Size))
+	w.u32(attributes(info, readOnly))
+	w.u32(0)
+	w.bytes(r.c.fileID[:])
+	w.u32(0) // No create contexts
+	w.u32(0)
+	w.u8(0)
+	return statusOK, w.b
+}
+
+// missingStatus distinguishes a missing file from a missing directory on its path
+func (c *conn) missingStatus(fs filesystem.FileSystem, p string) uint32 {
+	if parent, err := fs.Stat(path.Dir(p)); err != nil || !parent.IsDir {
+		return statusObjectPathNotFound
+	}
+	return statusObjectNameNotFound
+}
+
+// putTimes writes the creation, access, write and change times of a file, which are all
+// its modification time
+func putTimes(w *buffer, info *filesystem.FileInfo) {
+	for i := 0; i < 4; i++ {
+		w.time(info.ModTime)
+	}
+}
+
+// allocationSize rounds a size up to clusters of 4KB
+func allocationSize(size int64) uint64 {
+	return uint64((size + 4095) &^ 4095)
+}
+
+// attributes returns the file attributes of a file on a share
+func attributes(info *filesystem.FileInfo, readOnly bool) uint32 {
+	switch {
+	case info.IsDir:
+		return attrDirectory
+	case readOnly:
+		return attrReadOnly
+	default:
+		return attrNormal
+	}
+}
+
+// indexNumber is the file ID clients see, a hash of the path
+func indexNumber(p string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	return h.Sum64()
+}
+
+// stat returns the information of an open file, whose size is the buffered one
+func (c *conn) stat(r *request, o *open) (*filesystem.FileInfo, uint32) {
+	info, err := r.sess.fs(c.s).Stat(o.path)
+	if err != nil {
+		return nil, errorStatus(err)
+	}
+	stat := *info
+	if o.loaded {
+		stat.Size = int64(len(o.data))
+	}
+	return &stat, statusOK
+}
+
+// load reads the content of an open file into its buffer
+func (c *conn) load(r *request, o *open) uint32 {
+	if o.loaded {
+		return statusOK
+	}
+	fs := r.sess.fs(c.s)
+	info, err := fs.Stat(o.path)
+	if err != nil {
+		return errorStatus(err)
+	}
+	if info.Size > c.s.maxFileSize {
+		return statusDiskFull
+	}
+	data, err := fs.Read(o.path, 0, -1)
+	if err != nil && err != io.EOF {
+		return errorStatus(err)
+	}
+	// Copy, plugins may return their own memory
+	o.data = append(make([]byte, 0, len(data)), data...)
+	o.loaded = true
+	return statusOK
+}
+
+// writeBack writes the buffer of an open file to the file system if it changed
+func (c *conn) writeBack(sess *session, o *open) uint32 {
+	if !o.dirty {
+		return statusOK
+	}
+	if _, err := sess.fs(c.s).Write(o.path, o.data); err != nil {
+		return errorStatus(err)
+	}
+	o.dirty = false
+	return statusOK
+}
+
+// release writes back an open file and deletes it if it is to be deleted on close
+func (c *conn) release(sess *session, o *open) uint32 {
+	status := c.writeBack(sess, o)
+	delete(sess.opens, o.id)
+	if o.deleteOnClose {
+		if err := sess.fs(c.s).Remove(o.path); err != nil && !filesystem.IsNotFound(err) {
+			return errorStatus(err)
+		}
+	}
+	return status
+}
+
+// closeOpens releases the opens of a session on a tree, on all trees if it is nil
+func (c *conn) closeOpens(sess *session, t *tree) {
+	for _, o := range sess.opens {
+		if t != nil && o.tree != t {
+			continue
+		}
+		if status := c.release(sess, o); status != statusOK {
+			log.Warnf("[smb] %s: writing back %s: status %#x", c.nc.RemoteAddr(), o.path, status)
+		}
+	}
+}
+
+func (c *conn) close(r *request) (uint32, []byte) {
+	o, status := c.lookup(r, 72)
+	if status != statusOK {
+		return status, nil
+	}
+	postQuery := binary.LittleEndian.Uint16(r.msg[66:])&0x0001 != 0
+	var info *filesystem.FileInfo
+	if postQuery && !o.deleteOnClose {
+		info, _ = c.stat(r, o)
+	}
+	if status := c.release(r.sess, o); status != statusOK {
+		return status, nil
+	}
+
+	var w buffer
+	w.u16(60)
+	if info == nil {
+		w.zeros(58)
+		return statusOK, w.b
+	}
+	w.u16(0x0001)
+	w.u32(0)
+	putTimes(&w, info)
+	w.u64(allocationSize(info.Size))
+	w.u64(uint64(info.Size))
+	w.u32(attributes(info, o.tree.readOnly))
+	return statusOK, w.b
+}
+
+func (c *conn) flush(r *request) (uint32, []byte) {
+	o, status := c.lookup(r, 72)
+	if status != statusOK {
+		return status, nil
+	}
+	if status := c.writeBack(r.sess, o); status != statusOK {
+		return status, nil
+	}
+	return statusOK, []byte{4, 0, 0, 0}
+}
+
+func (c *conn) read(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+48 {
+		return statusInvalidParameter, nil
+	}
+	o, status := c.lookup(r, 80)
+	if status != statusOK {
+		return status, nil
+	}
+	if o.dir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.access&(accessReadData|accessExecute) == 0 {
+		return statusAccessDenied, nil
+	}
+	le := binary.LittleEndian
+	length := int64(le.Uint32(r.msg[68:]))
+	offset := int64(le.Uint64(r.msg[72:]))
+	minCount := int(le.Uint32(r.msg[96:]))
+	if length > maxReadSize || offset < 0 {
+		return statusInvalidParameter, nil
+	}
+
+	var data []byte
+	if o.loaded {
+		if offset < int64(len(o.data)) {
+			data = o.data[offset:min(offset+length, int64(len(o.data)))]
+		}
+	} else {
+		fs := r.sess.fs(c.s)
+		info, err := fs.Stat(o.path)
+		if err != nil {
+			return errorStatus(err), nil
+		}
+		if offset < info.Size && length > 0 {
+			data, err = fs.Read(o.path, offset, length)
+			if err != nil && err != io.EOF {
+				return errorStatus(err), nil
+			}
+		}
+	}
+	if len(data) == 0 && length > 0 || len(data) < minCount {
+		return statusEndOfFile, nil
+	}
+
+	var w buffer
+	w.u16(17)
+	w.u8(headerSize + 16) // Data offset
+	w.u8(0)
+	w.u32(uint32(len(data)))
+	w.u32(0)
+	w.u32(0)
+	w.bytes(data)
+	if len(data) == 0 {
+		w.u8(0)
+	}
+	return statusOK, w.b
+}
+
+func (c *conn) write(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+48 {
+		return statusInvalidParameter, nil
+	}
+	o, status := c.lookup(r, 80)
+	if status != statusOK {
+		return status, nil
+	}
+	if o.dir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.access&(accessWriteData|accessAppendData) == 0 {
+		return statusAccessDenied, nil
+	}
+	le := binary.LittleEndian
+	length := int(le.Uint32(r.msg[68:]))
+	data := body(r.msg, int(le.Uint16(r.msg[66:])), length)
+	if len(data) != length || length > maxWriteSize {
+		return statusInvalidParameter, nil
+	}
+	if status := c.load(r, o); status != statusOK {
+		return status, nil
+	}
+	offset := le.Uint64(r.msg[72:])
+	if offset == 0xFFFFFFFFFFFFFFFF {
+		offset = uint64(len(o.data)) // Append
+	}
+	end := offset + uint64(length)
+	if offset > uint64(c.s.maxFileSize) || end > uint64(c.s.maxFileSize) {
+		return statusDiskFull, nil
+	}
+	if end > uint64(len(o.data)) {
+		o.data = append(o.data, make([]byte, int(end)-len(o.data))...)
+	}
+	copy(o.data[offset:], data)
+	if length > 0 {
+		o.dirty = true
+	}
+
+	var w buffer
+	w.u16(17)
+	w.u16(0)
+	w.u32(uint32(length))
+	w.u32(0)
+	w.u16(0)
+	w.u16(0)
+	w.u8(0)
+	return statusOK, w.b
+}
+
+// dirEntry is an entry of a directory enumeration
+type dirEntry struct {
+	name string
+	info filesystem.FileInfo
+}
+
+func (c *conn) queryDirectory(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+32 {
+		return statusInvalidParameter, nil
+	}
+	o, status := c.lookup(r, 72)
+	if status != statusOK {
+		return status, nil
+	}
+	if !o.dir {
+		return statusInvalidParameter, nil
+	}
+	if o.access&accessReadData == 0 {
+		return statusAccessDenied, nil
+	}
+	le := binary.LittleEndian
+	class := r.msg[66]
+	flags := r.msg[67]
+	pattern := decodeUTF16(body(r.msg, int(le.Uint16(r.msg[88:])), int(le.Uint16(r.msg[90:]))))
+	outLen := int(le.Uint32(r.msg[92:]))
+	switch class {
+	case fileDirectoryInformation, fileFullDirectoryInformation, fileBothDirectoryInformation,
+		fileNamesInformation, fileIdBothDirectoryInformation, fileIdFullDirectoryInformation:
+	default:
+		return statusInvalidInfoClass, nil
+	}
+
+	first := !o.listed || flags&(queryRestartScans|queryReopen) != 0
+	if first {
+		if pattern == "" {
+			pattern = "*"
+		}
+		entries, err := r.sess.fs(c.s).ReadDir(o.path)
+		if err != nil {
+			return errorStatus(err), nil
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		o.entries, o.pattern, o.pos, o.listed = entries, pattern, 0, true
+		if pattern == "*" {
+			self, status := c.stat(r, o)
+			if status != statusOK {
+				return status, nil
+			}
+			dots := []filesystem.FileInfo{*self, *self}
+			dots[0].Name, dots[1].Name = ".", ".."
+			o.entries = append(dots, o.entries...)
+		}
+	}
+
+	var out buffer
+	last := -1 // Start of the last entry written
+	matched := false
+	for ; o.pos < len(o.entries); o.pos++ {
+		info := &o.entries[o.pos]
+		if !match(o.pattern, info.Name) {
+			continue
+		}
+		matched = true
+		entry := dirEntryBytes(class, info, indexNumber(path.Join(o.path, info.Name)), o.tree.readOnly)
+		start := len(out.b)
+		if last >= 0 {
+			start = (start + 7) &^ 7
+		}
+		if start+len(entry) > outLen {
+			break
+		}
+		if last >= 0 {
+			out.align(8)
+			le.PutUint32(out.b[last:], uint32(start-last))
+		}
+		last = start
+		out.bytes(entry)
+		if flags&querySingleEntry != 0 {
+			o.pos++
+			break
+		}
+	}
+	if last < 0 {
+		switch {
+		case matched:
+			return statusInfoLengthMismatch, nil
+		case first:
+			return statusNoSuchFile, nil
+		default:
+			return statusNoMoreFiles, nil
+		}
+	}
+
+	var w buffer
+	w.u16(9)
+	w.u16(headerSize + 8)
+	w.u32(uint32(len(out.b)))
+	w.bytes(out.b)
+	return statusOK, w.b
+}
+
+// dirEntryBytes encodes an entry of a directory in an information class of query
+// directory, see MS-FSCC 2.4
+func dirEntryBytes(class byte, info *filesystem.FileInfo, index uint64, readOnly bool) []byte {
+	name := encodeUTF16(info.Name)
+	var w buffer
+	w.u32(0) // Next entry offset, set when the next entry is added
+	w.u32(0) // File index
+	if class == fileNamesInformation {
+		w.u32(uint32(len(name)))
+		w.bytes(name)
+		return w.b
+	}
+	putTimes(&w, info)
+	w.u64(uint64(info.Size))
+	w.u64(allocationSize(info.Size))
+	w.u32(attributes(info, readOnly))
+	w.u32(uint32(len(name)))
+	switch class {
+	case fileFullDirectoryInformation:
+		w.u32(0) // EA size
+	case fileIdFullDirectoryInformation:
+		w.u32(0)
+		w.u32(0)
+		w.u64(index)
+	case fileBothDirectoryInformation:
+		w.u32(0)
+		w.zeros(26) // No short name
+	case fileIdBothDirectoryInformation:
+		w.u32(0)
+		w.zeros(26)
+		w.u16(0)
+		w.u64(index)
+	}
+	w.bytes(name)
+	return w.b
+}
+
+// match reports whether name matches a pattern of query directory, case-insensitively;
+// the DOS wildcards < > and " are taken as * ? and .
+func match(pattern, name string) bool {
+	if pattern == "*" || pattern == "*.*" {
+		return true
+	}
+	pattern = strings.NewReplacer("<", "*", ">", "?", `"`, ".").Replace(pattern)
+	p, n := []rune(pattern), []rune(name)
+	var star, next = -1, 0
+	for i, j := 0, 0; j < len(n) || i < len(p); {
+		if i < len(p) {
+			switch {
+			case p[i] == '*':
+				star, next = i, j
+				i++
+				continue
+			case j < len(n) && (p[i] == '?' || unicode.ToUpper(p[i]) == unicode.ToUpper(n[j])):
+				i++
+				j++
+				continue
+			}
+		}
+		if star < 0 || next >= len(n) {
+			return false
+		}
+		next++
+		i, j = star+1, next
+	}
+	return true
+}
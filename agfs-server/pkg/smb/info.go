@@ -0,0 +1,374 @@
+package smb
+
+import (
+	"encoding/binary"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Sizes reported for shares, whose backends rarely know their capacity: 1TB free in
+// clusters of 4KB
+const (
+	volumeClusters   = 1 << 28
+	sectorsPerUnit   = 8
+	bytesPerSector   = 512
+	deviceTypeDisk   = 0x07
+	maxComponentName = 255
+)
+
+// File system attributes: case preserving and sensitive names, Unicode names
+const fsAttributes = 0x00000001 | 0x00000002 | 0x00000004
+
+// Security information of query info
+const (
+	securityOwner = 0x00000001
+	securityGroup = 0x00000002
+	securityDACL  = 0x00000004
+)
+
+// sidEveryone is S-1-1-0, the owner and group of every file
+var sidEveryone = []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+// errorBody is the body of an error response carrying data
+func errorBody(data []byte) []byte {
+	var w buffer
+	w.u16(9)
+	w.u8(0)
+	w.u8(0)
+	w.u32(uint32(len(data)))
+	w.bytes(data)
+	if len(data) == 0 {
+		w.u8(0)
+	}
+	return w.b
+}
+
+func (c *conn) queryInfo(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+40 {
+		return statusInvalidParameter, nil
+	}
+	o, status := c.lookup(r, 88)
+	if status != statusOK {
+		return status, nil
+	}
+	le := binary.LittleEndian
+	infoType, class := r.msg[66], r.msg[67]
+	outLen := int(le.Uint32(r.msg[68:]))
+
+	var out []byte
+	variable := false // Whether the class may be truncated
+	switch infoType {
+	case infoFile:
+		if o.access&accessReadAttributes == 0 {
+			return statusAccessDenied, nil
+		}
+		info, status := c.stat(r, o)
+		if status != statusOK {
+			return status, nil
+		}
+		out, variable, status = c.fileInfo(o, info, class)
+		if status != statusOK {
+			return status, nil
+		}
+	case infoFilesystem:
+		out, variable, status = c.filesystemInfo(class)
+		if status != statusOK {
+			return status, nil
+		}
+	case infoSecurity:
+		out = securityDescriptor(le.Uint32(r.msg[80:]))
+		if len(out) > outLen {
+			needed := make([]byte, 4)
+			le.PutUint32(needed, uint32(len(out)))
+			return statusBufferTooSmall, errorBody(needed)
+		}
+	default:
+		return statusInvalidParameter, nil
+	}
+
+	status = statusOK
+	if len(out) > outLen {
+		if !variable {
+			return statusInfoLengthMismatch, nil
+		}
+		out, status = out[:outLen], statusBufferOverflow
+	}
+	var w buffer
+	w.u16(9)
+	w.u16(headerSize + 8)
+	w.u32(uint32(len(out)))
+	w.bytes(out)
+	if len(out) == 0 {
+		w.u8(0)
+	}
+	return status, w.b
+}
+
+// fileInfo encodes an information class of a file, see MS-FSCC 2.4
+func (c *conn) fileInfo(o *open, info *filesystem.FileInfo, class byte) ([]byte, bool, uint32) {
+	readOnly := o.tree.readOnly
+	var w buffer
+	basic := func() {
+		putTimes(&w, info)
+		w.u32(attributes(info, readOnly))
+		w.u32(0)
+	}
+	standard := func() {
+		w.u64(allocationSize(info.Size))
+		w.u64(uint64(info.Size))
+		w.u32(1) // Links
+		w.u8(boolByte(o.deleteOnClose))
+		w.u8(boolByte(info.IsDir))
+		w.u16(0)
+	}
+	switch class {
+	case fileBasicInformation:
+		basic()
+	case fileStandardInformation:
+		standard()
+	case fileInternalInformation:
+		w.u64(indexNumber(o.path))
+	case fileEaInformation, fileModeInformation, fileAlignmentInformation:
+		w.u32(0)
+	case fileAccessInformation:
+		w.u32(o.access)
+	case filePositionInformation:
+		w.u64(0)
+	case fileAllInformation:
+		basic()
+		standard()
+		w.u64(indexNumber(o.path))
+		w.u32(0) // EA size
+		w.u32(o.access)
+		w.u64(0) // Position
+		w.u32(0) // Mode
+		w.u32(0) // Alignment
+		name := encodeUTF16(`\` + o.name())
+		w.u32(uint32(len(name)))
+		w.bytes(name)
+		return w.b, true, statusOK
+	case fileStreamInformation:
+		if !info.IsDir {
+			name := encodeUTF16("::$DATA")
+			w.u32(0)
+			w.u32(uint32(len(name)))
+			w.u64(uint64(info.Size))
+			w.u64(allocationSize(info.Size))
+			w.bytes(name)
+		}
+		return w.b, true, statusOK
+	case fileNetworkOpenInformation:
+		putTimes(&w, info)
+		w.u64(allocationSize(info.Size))
+		w.u64(uint64(info.Size))
+		w.u32(attributes(info, readOnly))
+		w.u32(0)
+	case fileAttributeTagInformation:
+		w.u32(attributes(info, readOnly))
+		w.u32(0) // No reparse tag
+	default:
+		return nil, false, statusInvalidInfoClass
+	}
+	return w.b, false, statusOK
+}
+
+// filesystemInfo encodes an information class of the file system, see MS-FSCC 2.5
+func (c *conn) filesystemInfo(class byte) ([]byte, bool, uint32) {
+	var w buffer
+	switch class {
+	case fsVolumeInformation:
+		label := encodeUTF16(c.s.name)
+		w.time(c.s.started)
+		w.u32(binary.LittleEndian.Uint32(c.s.guid[:])) // Serial number
+		w.u32(uint32(len(label)))
+		w.u8(0)
+		w.u8(0)
+		w.bytes(label)
+		return w.b, true, statusOK
+	case fsSizeInformation:
+		w.u64(volumeClusters)
+		w.u64(volumeClusters)
+		w.u32(sectorsPerUnit)
+		w.u32(bytesPerSector)
+	case fsDeviceInformation:
+		w.u32(deviceTypeDisk)
+		w.u32(0)
+	case fsAttributeInformation:
+		// Windows expects a name it knows to enable the features of its file systems
+		name := encodeUTF16("NTFS")
+		w.u32(fsAttributes)
+		w.u32(maxComponentName)
+		w.u32(uint32(len(name)))
+		w.bytes(name)
+		return w.b, true, statusOK
+	case fsFullSizeInformation:
+		w.u64(volumeClusters)
+		w.u64(volumeClusters)
+		w.u64(volumeClusters)
+		w.u32(sectorsPerUnit)
+		w.u32(bytesPerSector)
+	default:
+		return nil, false, statusInvalidInfoClass
+	}
+	return w.b, false, statusOK
+}
+
+// securityDescriptor returns a self-relative security descriptor with the requested parts:
+// Everyone owns every file, and a NULL DACL grants everybody all access, which the access
+// control of the file system restricts
+func securityDescriptor(requested uint32) []byte {
+	const size = 20
+	control := uint16(0x8000) // Self-relative
+	if requested&securityDACL != 0 {
+		control |= 0x0004 // DACL present, NULL
+	}
+	var owner, group uint32
+	offset := uint32(size)
+	if requested&securityOwner != 0 {
+		owner = offset
+		offset += uint32(len(sidEveryone))
+	}
+	if requested&securityGroup != 0 {
+		group = offset
+	}
+	var w buffer
+	w.u8(1) // Revision
+	w.u8(0)
+	w.u16(control)
+	w.u32(owner)
+	w.u32(group)
+	w.u32(0) // SACL
+	w.u32(0) // DACL
+	if owner != 0 {
+		w.bytes(sidEveryone)
+	}
+	if group != 0 {
+		w.bytes(sidEveryone)
+	}
+	return w.b
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *conn) setInfo(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+32 {
+		return statusInvalidParameter, nil
+	}
+	o, status := c.lookup(r, 80)
+	if status != statusOK {
+		return status, nil
+	}
+	le := binary.LittleEndian
+	infoType, class := r.msg[66], r.msg[67]
+	length := int(le.Uint32(r.msg[68:]))
+	buf := body(r.msg, int(le.Uint16(r.msg[72:])), length)
+	if len(buf) != length {
+		return statusInvalidParameter, nil
+	}
+	if infoType != infoFile {
+		return statusNotSupported, nil
+	}
+
+	fs := r.sess.fs(c.s)
+	switch class {
+	case fileBasicInformation, fileAllocationInformation:
+		// Times and attributes are not kept, allocation follows the size
+		if o.access&(accessWriteAttributes|accessWriteData) == 0 {
+			return statusAccessDenied, nil
+		}
+	case fileEndOfFileInformation:
+		if len(buf) < 8 {
+			return statusInfoLengthMismatch, nil
+		}
+		if o.dir {
+			return statusInvalidParameter, nil
+		}
+		if o.access&accessWriteData == 0 {
+			return statusAccessDenied, nil
+		}
+		size := le.Uint64(buf)
+		if size > uint64(c.s.maxFileSize) {
+			return statusDiskFull, nil
+		}
+		if status := c.load(r, o); status != statusOK {
+			return status, nil
+		}
+		if size != uint64(len(o.data)) {
+			if size < uint64(len(o.data)) {
+				o.data = o.data[:size]
+			} else {
+				o.data = append(o.data, make([]byte, int(size)-len(o.data))...)
+			}
+			o.dirty = true
+		}
+	case fileDispositionInformation:
+		if len(buf) < 1 {
+			return statusInfoLengthMismatch, nil
+		}
+		if o.access&accessDelete == 0 || o.path == o.tree.share.path {
+			return statusAccessDenied, nil
+		}
+		if buf[0] != 0 && o.dir {
+			entries, err := fs.ReadDir(o.path)
+			if err != nil {
+				return errorStatus(err), nil
+			}
+			if len(entries) > 0 {
+				return statusDirectoryNotEmpty, nil
+			}
+		}
+		o.deleteOnClose = buf[0] != 0
+	case fileRenameInformation:
+		if len(buf) < 20 {
+			return statusInfoLengthMismatch, nil
+		}
+		if o.access&accessDelete == 0 || o.path == o.tree.share.path {
+			return statusAccessDenied, nil
+		}
+		replace := buf[0] != 0
+		n := int(le.Uint32(buf[16:]))
+		if n > len(buf)-20 {
+			return statusInvalidParameter, nil
+		}
+		name := decodeUTF16(buf[20 : 20+n])
+		target, _, status := sharePath(o.tree.share, name)
+		if status != statusOK {
+			return status, nil
+		}
+		if target == o.path {
+			break
+		}
+		if status := c.writeBack(r.sess, o); status != statusOK {
+			return status, nil
+		}
+		if info, err := fs.Stat(target); err == nil {
+			if !replace {
+				return statusObjectNameCollision, nil
+			}
+			if info.IsDir {
+				return statusAccessDenied, nil
+			}
+			if err := fs.Remove(target); err != nil {
+				return errorStatus(err), nil
+			}
+		} else if !filesystem.IsNotFound(err) {
+			return errorStatus(err), nil
+		}
+		if err := fs.Rename(o.path, target); err != nil {
+			if filesystem.IsNotFound(err) {
+				return c.missingStatus(fs, target), nil
+			}
+			return errorStatus(err), nil
+		}
+		o.path = target
+		o.entries, o.listed = nil, false
+	default:
+		return statusNotSupported, nil
+	}
+	return statusOK, []byte{2, 0}
+}
@@ -0,0 +1,382 @@
+package smb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"strings"
+)
+
+// Logons are NTLMv2 (MS-NLMP) challenge-responses, wrapped in SPNEGO (RFC 4178) tokens.
+// The NT hash is the MD4 of the password, which is the API key of the user.
+
+// NTLM message types
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLM negotiate flags
+const (
+	ntlmFlagUnicode         = 0x00000001
+	ntlmFlagRequestTarget   = 0x00000004
+	ntlmFlagSign            = 0x00000010
+	ntlmFlagSeal            = 0x00000020
+	ntlmFlagNTLM            = 0x00000200
+	ntlmFlagAlwaysSign      = 0x00008000
+	ntlmFlagTargetServer    = 0x00020000
+	ntlmFlagExtendedSession = 0x00080000
+	ntlmFlagTargetInfo      = 0x00800000
+	ntlmFlagVersion         = 0x02000000
+	ntlmFlag128             = 0x20000000
+	ntlmFlagKeyExchange     = 0x40000000
+	ntlmFlag56              = 0x80000000
+
+	// Flags of the client kept in the challenge
+	ntlmClientFlags = ntlmFlagUnicode | ntlmFlagSign | ntlmFlagSeal | ntlmFlagAlwaysSign |
+		ntlmFlagExtendedSession | ntlmFlagVersion | ntlmFlag128 | ntlmFlagKeyExchange | ntlmFlag56
+)
+
+// Attribute IDs of the target info of the challenge
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// OIDs of SPNEGO and NTLMSSP, DER encoded with their tag
+var (
+	oidSPNEGO  = []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLMSSP = []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+// SPNEGO negotiation states
+const (
+	negAcceptCompleted  = 0
+	negAcceptIncomplete = 1
+	negReject           = 2
+)
+
+var errBadToken = errors.New("malformed security token")
+
+// der encodes a DER element of tag with content
+func der(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+// parseDER splits the first DER element of b into its tag and content and returns the rest
+func parseDER(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBadToken
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return 0, nil, nil, errBadToken
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if len(b) < n {
+		return 0, nil, nil, errBadToken
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// spnegoInit is the token of the negotiate response, offering NTLMSSP
+func spnegoInit() []byte {
+	mechTypes := der(0xa0, der(0x30, oidNTLMSSP))
+	return der(0x60, oidSPNEGO, der(0xa0, der(0x30, mechTypes)))
+}
+
+// spnegoResponse wraps an NTLM message of the server, nil for none, in a NegTokenResp
+func spnegoResponse(state byte, token []byte, first bool) []byte {
+	fields := [][]byte{der(0xa0, der(0x0a, []byte{state}))}
+	if first {
+		fields = append(fields, der(0xa1, oidNTLMSSP))
+	}
+	if token != nil {
+		fields = append(fields, der(0xa2, der(0x04, token)))
+	}
+	return der(0xa1, der(0x30, fields...))
+}
+
+// unwrapToken returns the NTLM message of a security token of the client, either raw or the
+// mechanism token of a SPNEGO NegTokenInit or NegTokenResp, and whether it was wrapped; a
+// token for another mechanism returns a nil message
+func unwrapToken(token []byte) (msg []byte, spnego bool, err error) {
+	if bytes.HasPrefix(token, ntlmSignature) {
+		return token, false, nil
+	}
+	tag, content, _, err := parseDER(token)
+	if err != nil {
+		return nil, false, err
+	}
+	switch tag {
+	case 0x60: // NegTokenInit after the SPNEGO OID
+		if !bytes.HasPrefix(content, oidSPNEGO) {
+			return nil, false, errBadToken
+		}
+		if tag, content, _, err = parseDER(content[len(oidSPNEGO):]); err != nil || tag != 0xa0 {
+			return nil, false, errBadToken
+		}
+	case 0xa1: // NegTokenResp
+	default:
+		return nil, false, errBadToken
+	}
+	tag, seq, _, err := parseDER(content)
+	if err != nil || tag != 0x30 {
+		return nil, false, errBadToken
+	}
+	for len(seq) > 0 {
+		var field []byte
+		if tag, field, seq, err = parseDER(seq); err != nil {
+			return nil, false, err
+		}
+		if tag != 0xa2 { // mechToken, or responseToken
+			continue
+		}
+		t, octets, _, err := parseDER(field)
+		if err != nil || t != 0x04 {
+			return nil, false, errBadToken
+		}
+		if !bytes.HasPrefix(octets, ntlmSignature) {
+			return nil, true, nil
+		}
+		return octets, true, nil
+	}
+	return nil, true, nil
+}
+
+// ntlmType returns the message type of an NTLM message, 0 if it is not one
+func ntlmType(msg []byte) uint32 {
+	if len(msg) < 12 || !bytes.HasPrefix(msg, ntlmSignature) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(msg[8:])
+}
+
+// ntlmField returns the payload of the field of msg whose length, maximum length and
+// offset are at pos
+func ntlmField(msg []byte, pos int) ([]byte, error) {
+	if len(msg) < pos+8 {
+		return nil, errBadToken
+	}
+	n := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if n == 0 {
+		return nil, nil
+	}
+	if offset+n > len(msg) || offset+n < offset {
+		return nil, errBadToken
+	}
+	return msg[offset : offset+n], nil
+}
+
+// ntlmServer runs the server side of an NTLM logon
+type ntlmServer struct {
+	name      string // NetBIOS name of the server, reported in the challenge
+	challenge [8]byte
+	flags     uint32
+}
+
+// challengeMessage answers the negotiate message of the client
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if ntlmType(negotiate) != ntlmNegotiate || len(negotiate) < 16 {
+		return nil, errBadToken
+	}
+	clientFlags := binary.LittleEndian.Uint32(negotiate[12:])
+	n.flags = clientFlags&ntlmClientFlags | ntlmFlagRequestTarget | ntlmFlagNTLM | ntlmFlagTargetServer | ntlmFlagTargetInfo
+	if _, err := rand.Read(n.challenge[:]); err != nil {
+		return nil, err
+	}
+
+	// Without a timestamp in the target info clients send no MIC, which would require
+	// signing the SPNEGO mechanism list
+	name := encodeUTF16(n.name)
+	var info buffer
+	for _, id := range []uint16{avNbDomainName, avNbComputerName, avDNSDomainName, avDNSComputerName} {
+		info.u16(id)
+		info.u16(uint16(len(name)))
+		info.bytes(name)
+	}
+	info.u16(avEOL)
+	info.u16(0)
+
+	const fixed = 56
+	var msg buffer
+	msg.bytes(ntlmSignature)
+	msg.u32(ntlmChallenge)
+	msg.u16(uint16(len(name)))
+	msg.u16(uint16(len(name)))
+	msg.u32(fixed)
+	msg.u32(n.flags)
+	msg.bytes(n.challenge[:])
+	msg.zeros(8)
+	msg.u16(uint16(len(info.b)))
+	msg.u16(uint16(len(info.b)))
+	msg.u32(uint32(fixed + len(name)))
+	msg.bytes([]byte{6, 3, 0x80, 0x25, 0, 0, 0, 15}) // Version 6.3 build 9600, NTLM revision 15
+	msg.bytes(name)
+	msg.bytes(info.b)
+	return msg.b, nil
+}
+
+// authentication is the identity an authenticate message claims
+type authentication struct {
+	user, domain string
+	anonymous    bool
+	ntResponse   []byte
+	sessionKey   []byte // Encrypted random session key, with key exchange
+}
+
+// parseAuthenticate decodes the authenticate message of the client
+func (n *ntlmServer) parseAuthenticate(msg []byte) (*authentication, error) {
+	if ntlmType(msg) != ntlmAuthenticate || len(msg) < 64 {
+		return nil, errBadToken
+	}
+	var fields [6][]byte
+	for i := range fields {
+		f, err := ntlmField(msg, 12+8*i)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = f
+	}
+	flags := binary.LittleEndian.Uint32(msg[60:])
+	str := func(b []byte) string {
+		if flags&ntlmFlagUnicode != 0 {
+			return decodeUTF16(b)
+		}
+		return string(b)
+	}
+	a := &authentication{
+		domain:     str(fields[2]),
+		user:       str(fields[3]),
+		ntResponse: fields[1],
+	}
+	if flags&ntlmFlagKeyExchange != 0 {
+		a.sessionKey = fields[5]
+	}
+	lm := fields[0]
+	a.anonymous = a.user == "" && len(a.ntResponse) == 0 && (len(lm) == 0 || bytes.Equal(lm, []byte{0}))
+	return a, nil
+}
+
+// verify checks the NTLMv2 response of a against password and returns the exported session
+// key; NTLMv1 responses are refused
+func (n *ntlmServer) verify(a *authentication, password []byte) ([]byte, bool) {
+	if len(a.ntResponse) <= 24 {
+		return nil, false
+	}
+	hash := md4(encodeUTF16(string(password)))
+	proof, blob := a.ntResponse[:16], a.ntResponse[16:]
+	// Clients differ in the case of the domain they hash
+	for _, domain := range []string{a.domain, strings.ToUpper(a.domain), ""} {
+		mac := hmac.New(md5.New, hash[:])
+		mac.Write(encodeUTF16(strings.ToUpper(a.user) + domain))
+		ntowf := mac.Sum(nil)
+
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(n.challenge[:])
+		mac.Write(blob)
+		expected := mac.Sum(nil)
+		if !hmac.Equal(expected, proof) {
+			continue
+		}
+
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(expected)
+		key := mac.Sum(nil)
+		if len(a.sessionKey) == 16 {
+			cipher, err := rc4.NewCipher(key)
+			if err != nil {
+				return nil, false
+			}
+			exported := make([]byte, 16)
+			cipher.XORKeyStream(exported, a.sessionKey)
+			key = exported
+		}
+		return key, true
+	}
+	return nil, false
+}
+
+// md4 returns the MD4 digest of data (RFC 1320), which NT hashes are made of
+func md4(data []byte) [16]byte {
+	msg := append([]byte(nil), data...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	f := func(x, y, z uint32) uint32 { return x&y | ^x&z }
+	g := func(x, y, z uint32) uint32 { return x&y | x&z | y&z }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	rotl := bits.RotateLeft32
+
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+	var x [16]uint32
+	for len(msg) > 0 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[4*i:])
+		}
+		msg = msg[64:]
+		aa, bb, cc, dd := a, b, c, d
+		for _, i := range []int{0, 4, 8, 12} {
+			a = rotl(a+f(b, c, d)+x[i], 3)
+			d = rotl(d+f(a, b, c)+x[i+1], 7)
+			c = rotl(c+f(d, a, b)+x[i+2], 11)
+			b = rotl(b+f(c, d, a)+x[i+3], 19)
+		}
+		for i := 0; i < 4; i++ {
+			a = rotl(a+g(b, c, d)+x[i]+0x5a827999, 3)
+			d = rotl(d+g(a, b, c)+x[i+4]+0x5a827999, 5)
+			c = rotl(c+g(d, a, b)+x[i+8]+0x5a827999, 9)
+			b = rotl(b+g(c, d, a)+x[i+12]+0x5a827999, 13)
+		}
+		for _, i := range []int{0, 2, 1, 3} {
+			a = rotl(a+h(b, c, d)+x[i]+0x6ed9eba1, 3)
+			d = rotl(d+h(a, b, c)+x[i+8]+0x6ed9eba1, 9)
+			c = rotl(c+h(d, a, b)+x[i+4]+0x6ed9eba1, 11)
+			b = rotl(b+h(c, d, a)+x[i+12]+0x6ed9eba1, 15)
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+	var sum [16]byte
+	for i, v := range []uint32{a, b, c, d} {
+		binary.LittleEndian.PutUint32(sum[4*i:], v)
+	}
+	return sum
+}
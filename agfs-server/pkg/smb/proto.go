@@ -0,0 +1,342 @@
+package smb
+
+import (
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+// Dialects the server speaks, see MS-SMB2 2.2.3
+const (
+	dialect202      = 0x0202
+	dialect210      = 0x0210
+	dialectWildcard = 0x02FF // Answer to an SMB1 negotiate offering "SMB 2.???"
+)
+
+// Commands
+const (
+	cmdNegotiate      = 0x00
+	cmdSessionSetup   = 0x01
+	cmdLogoff         = 0x02
+	cmdTreeConnect    = 0x03
+	cmdTreeDisconnect = 0x04
+	cmdCreate         = 0x05
+	cmdClose          = 0x06
+	cmdFlush          = 0x07
+	cmdRead           = 0x08
+	cmdWrite          = 0x09
+	cmdLock           = 0x0A
+	cmdIoctl          = 0x0B
+	cmdCancel         = 0x0C
+	cmdEcho           = 0x0D
+	cmdQueryDirectory = 0x0E
+	cmdChangeNotify   = 0x0F
+	cmdQueryInfo      = 0x10
+	cmdSetInfo        = 0x11
+)
+
+// Header flags
+const (
+	flagResponse = 0x00000001
+	flagAsync    = 0x00000002
+	flagRelated  = 0x00000004
+	flagSigned   = 0x00000008
+)
+
+// NTSTATUS codes of responses
+const (
+	statusOK                    = 0x00000000
+	statusBufferOverflow        = 0x80000005
+	statusNoMoreFiles           = 0x80000006
+	statusNotImplemented        = 0xC0000002
+	statusInvalidInfoClass      = 0xC0000003
+	statusInfoLengthMismatch    = 0xC0000004
+	statusInvalidHandle         = 0xC0000008
+	statusInvalidParameter      = 0xC000000D
+	statusNoSuchFile            = 0xC000000F
+	statusInvalidDeviceRequest  = 0xC0000010
+	statusEndOfFile             = 0xC0000011
+	statusMoreProcessing        = 0xC0000016
+	statusAccessDenied          = 0xC0000022
+	statusBufferTooSmall        = 0xC0000023
+	statusObjectNameInvalid     = 0xC0000033
+	statusObjectNameNotFound    = 0xC0000034
+	statusObjectNameCollision   = 0xC0000035
+	statusObjectPathNotFound    = 0xC000003A
+	statusDeletePending         = 0xC0000056
+	statusLogonFailure          = 0xC000006D
+	statusDiskFull              = 0xC000007F
+	statusInsufficientResources = 0xC000009A
+	statusFileIsADirectory      = 0xC00000BA
+	statusNotSupported          = 0xC00000BB
+	statusNetworkNameDeleted    = 0xC00000C9
+	statusBadNetworkName        = 0xC00000CC
+	statusDirectoryNotEmpty     = 0xC0000101
+	statusNotADirectory         = 0xC0000103
+	statusFileClosed            = 0xC0000128
+	statusFSDriverRequired      = 0xC000019C
+	statusUserSessionDeleted    = 0xC0000203
+	statusIOTimeout             = 0xC00000B5
+	statusUnexpectedIOError     = 0xC00000E9
+)
+
+// Security modes of negotiate and session setup
+const (
+	signingEnabled  = 0x0001
+	signingRequired = 0x0002
+)
+
+// Session flags of session setup responses
+const (
+	sessionFlagGuest = 0x0001
+	sessionFlagNull  = 0x0002
+)
+
+// Access masks, see MS-SMB2 2.2.13.1
+const (
+	accessReadData        = 0x00000001
+	accessWriteData       = 0x00000002
+	accessAppendData      = 0x00000004
+	accessReadEA          = 0x00000008
+	accessWriteEA         = 0x00000010
+	accessExecute         = 0x00000020
+	accessReadAttributes  = 0x00000080
+	accessWriteAttributes = 0x00000100
+	accessDelete          = 0x00010000
+	accessReadControl     = 0x00020000
+	accessWriteDAC        = 0x00040000
+	accessWriteOwner      = 0x00080000
+	accessSynchronize     = 0x00100000
+	accessMaximumAllowed  = 0x02000000
+	accessGenericAll      = 0x10000000
+	accessGenericExecute  = 0x20000000
+	accessGenericWrite    = 0x40000000
+	accessGenericRead     = 0x80000000
+
+	// Access granted on writable and read-only shares
+	accessAll      = 0x001F01FF
+	accessReadOnly = 0x001200A9
+
+	// Accesses that change a file or its attributes
+	accessWriting = accessWriteData | accessAppendData | accessWriteEA | accessWriteAttributes |
+		accessDelete | accessWriteDAC | accessWriteOwner | accessGenericWrite | accessGenericAll
+)
+
+// Create dispositions
+const (
+	fileSupersede   = 0
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOpenIf      = 3
+	fileOverwrite   = 4
+	fileOverwriteIf = 5
+)
+
+// Create options
+const (
+	optionDirectory     = 0x00000001
+	optionNonDirectory  = 0x00000040
+	optionDeleteOnClose = 0x00001000
+)
+
+// Create actions
+const (
+	actionSuperseded  = 0
+	actionOpened      = 1
+	actionCreated     = 2
+	actionOverwritten = 3
+)
+
+// File attributes
+const (
+	attrReadOnly  = 0x00000001
+	attrDirectory = 0x00000010
+	attrNormal    = 0x00000080
+)
+
+// Info types of query and set info
+const (
+	infoFile       = 0x01
+	infoFilesystem = 0x02
+	infoSecurity   = 0x03
+)
+
+// File information classes, see MS-FSCC 2.4
+const (
+	fileDirectoryInformation       = 1
+	fileFullDirectoryInformation   = 2
+	fileBothDirectoryInformation   = 3
+	fileBasicInformation           = 4
+	fileStandardInformation        = 5
+	fileInternalInformation        = 6
+	fileEaInformation              = 7
+	fileAccessInformation          = 8
+	fileRenameInformation          = 10
+	fileNamesInformation           = 12
+	fileDispositionInformation     = 13
+	filePositionInformation        = 14
+	fileModeInformation            = 16
+	fileAlignmentInformation       = 17
+	fileAllInformation             = 18
+	fileAllocationInformation      = 19
+	fileEndOfFileInformation       = 20
+	fileStreamInformation          = 22
+	fileNetworkOpenInformation     = 34
+	fileAttributeTagInformation    = 35
+	fileIdBothDirectoryInformation = 37
+	fileIdFullDirectoryInformation = 38
+)
+
+// File system information classes, see MS-FSCC 2.5
+const (
+	fsVolumeInformation    = 1
+	fsSizeInformation      = 3
+	fsDeviceInformation    = 4
+	fsAttributeInformation = 5
+	fsFullSizeInformation  = 7
+)
+
+// Query directory flags
+const (
+	queryRestartScans = 0x01
+	querySingleEntry  = 0x02
+	queryReopen       = 0x10
+)
+
+// IO controls
+const (
+	fsctlDFSGetReferrals = 0x00060194
+)
+
+// headerSize is the size of the SMB2 header
+const headerSize = 64
+
+// protocolID starts every SMB2 message
+var protocolID = []byte{0xFE, 'S', 'M', 'B'}
+
+// header is the SMB2 header of a message, see MS-SMB2 2.2.1
+type header struct {
+	CreditCharge uint16
+	Status       uint32
+	Command      uint16
+	Credits      uint16 // Requested by the client, granted by the server
+	Flags        uint32
+	NextCommand  uint32
+	MessageID    uint64
+	AsyncID      uint64 // Only with flagAsync
+	TreeID       uint32
+	SessionID    uint64
+	Signature    [16]byte
+}
+
+func decodeHeader(b []byte) header {
+	le := binary.LittleEndian
+	h := header{
+		CreditCharge: le.Uint16(b[6:]),
+		Status:       le.Uint32(b[8:]),
+		Command:      le.Uint16(b[12:]),
+		Credits:      le.Uint16(b[14:]),
+		Flags:        le.Uint32(b[16:]),
+		NextCommand:  le.Uint32(b[20:]),
+		MessageID:    le.Uint64(b[24:]),
+		SessionID:    le.Uint64(b[40:]),
+	}
+	if h.Flags&flagAsync != 0 {
+		h.AsyncID = le.Uint64(b[32:])
+	} else {
+		h.TreeID = le.Uint32(b[36:])
+	}
+	copy(h.Signature[:], b[48:64])
+	return h
+}
+
+func (h *header) encode(b []byte) {
+	le := binary.LittleEndian
+	copy(b, protocolID)
+	le.PutUint16(b[4:], headerSize)
+	le.PutUint16(b[6:], h.CreditCharge)
+	le.PutUint32(b[8:], h.Status)
+	le.PutUint16(b[12:], h.Command)
+	le.PutUint16(b[14:], h.Credits)
+	le.PutUint32(b[16:], h.Flags)
+	le.PutUint32(b[20:], h.NextCommand)
+	le.PutUint64(b[24:], h.MessageID)
+	if h.Flags&flagAsync != 0 {
+		le.PutUint64(b[32:], h.AsyncID)
+	} else {
+		le.PutUint32(b[32:], 0xFEFF) // Process ID, reserved
+		le.PutUint32(b[36:], h.TreeID)
+	}
+	le.PutUint64(b[40:], h.SessionID)
+	copy(b[48:64], h.Signature[:])
+}
+
+// buffer builds the body of a response
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) u8(v uint8) { w.b = append(w.b, v) }
+
+func (w *buffer) u16(v uint16) { w.b = binary.LittleEndian.AppendUint16(w.b, v) }
+
+func (w *buffer) u32(v uint32) { w.b = binary.LittleEndian.AppendUint32(w.b, v) }
+
+func (w *buffer) u64(v uint64) { w.b = binary.LittleEndian.AppendUint64(w.b, v) }
+
+func (w *buffer) bytes(v []byte) { w.b = append(w.b, v...) }
+
+func (w *buffer) zeros(n int) { w.b = append(w.b, make([]byte, n)...) }
+
+// align pads the buffer to a multiple of n
+func (w *buffer) align(n int) {
+	for len(w.b)%n != 0 {
+		w.b = append(w.b, 0)
+	}
+}
+
+func (w *buffer) time(t time.Time) { w.u64(filetime(t)) }
+
+// body returns the bytes of a request body b from offset to offset+length, nil if they
+// are out of range; offsets are relative to the start of the SMB2 header
+func body(msg []byte, offset, length int) []byte {
+	if length == 0 {
+		return nil
+	}
+	if offset < headerSize || offset+length > len(msg) || offset+length < offset {
+		return nil
+	}
+	return msg[offset : offset+length]
+}
+
+// filetimeEpoch is 1601-01-01, the epoch of Windows file times
+var filetimeEpoch = time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// filetime returns t in 100 nanosecond intervals since 1601
+func filetime(t time.Time) uint64 {
+	if t.IsZero() || t.Before(filetimeEpoch) {
+		return 0
+	}
+	// Durations overflow after 292 years, count in seconds first
+	secs := t.Unix() - filetimeEpoch.Unix()
+	return uint64(secs)*10000000 + uint64(t.Nanosecond()/100)
+}
+
+// encodeUTF16 returns s in UTF-16LE
+func encodeUTF16(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(codes))
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// decodeUTF16 decodes UTF-16LE b
+func decodeUTF16(b []byte) string {
+	codes := make([]uint16, len(b)/2)
+	for i := range codes {
+		codes[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(codes))
+}
@@ -0,0 +1,830 @@
+// Package smb serves subtrees of the file system to Windows machines as SMB2 shares, which
+// they map as network drives
+// It speaks the SMB 2.0.2 and 2.1 dialects with NTLMv2 logons of the API keys of auth, or
+// guest logons, and signing; files written are buffered until they are closed or flushed.
+// Oplocks, leases, byte-range locks, change notifications, named streams and the share
+// list of the IPC$ pipes are not supported.
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/auth"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the SMB configuration
+const (
+	DefaultAddress     = ":445"
+	DefaultMaxFileSize = 64 * 1024 * 1024
+)
+
+// Sizes the server negotiates; without multi-credit requests one read or write is 64KB
+const (
+	maxTransactSize = 65536
+	maxReadSize     = 65536
+	maxWriteSize    = 65536
+	maxMessageSize  = 1024 * 1024 // Largest message, with compounded requests, read from a client
+	maxCredits      = 128         // Credits granted per response
+)
+
+// GuestPrincipal is the principal of guest logons when auth is enabled, which access
+// control rules can name; these guests only read
+const GuestPrincipal = "guest"
+
+// ipcShare is the share of named pipes clients connect to first
+const ipcShare = "IPC$"
+
+// Server serves the shares of an SMB configuration
+type Server struct {
+	fs             filesystem.FileSystem
+	users          *auth.Authenticator // nil without auth, then only guests log on
+	guest          bool
+	requireSigning bool
+	maxFileSize    int64
+	shares         map[string]*share // By upper-cased name
+	name           string            // NetBIOS name reported to clients
+	guid           [16]byte
+	started        time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex // protects the fields below
+	listeners map[net.Listener]bool
+	conns     map[*conn]bool
+	closed    bool
+}
+
+// share is a subtree of the file system served under a name
+type share struct {
+	name     string
+	path     string
+	readOnly bool
+}
+
+// New creates a server of the shares of cfg on fs; users authenticates the logons, nil
+// for guest logons only
+func New(cfg config.SMBConfig, fs filesystem.FileSystem, users *auth.Authenticator) (*Server, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		fs:             fs,
+		users:          users,
+		guest:          cfg.Guest,
+		requireSigning: cfg.RequireSigning,
+		maxFileSize:    DefaultMaxFileSize,
+		shares:         make(map[string]*share),
+		name:           "AGFS",
+		started:        time.Now(),
+		ctx:            ctx,
+		cancel:         cancel,
+		listeners:      make(map[net.Listener]bool),
+		conns:          make(map[*conn]bool),
+	}
+	if users == nil && !cfg.Guest {
+		cancel()
+		return nil, fmt.Errorf("smb: nobody can log on, enable auth or guest")
+	}
+	if len(cfg.Shares) == 0 {
+		cancel()
+		return nil, fmt.Errorf("smb: no shares configured")
+	}
+	for _, sc := range cfg.Shares {
+		if sc.Name == "" || strings.ContainsAny(sc.Name, `\/:*?"<>|`) || strings.EqualFold(sc.Name, ipcShare) {
+			cancel()
+			return nil, fmt.Errorf("smb: invalid share name %q", sc.Name)
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			cancel()
+			return nil, fmt.Errorf("smb: share %s needs an absolute path", sc.Name)
+		}
+		key := strings.ToUpper(sc.Name)
+		if s.shares[key] != nil {
+			cancel()
+			return nil, fmt.Errorf("smb: share %s is configured twice", sc.Name)
+		}
+		s.shares[key] = &share{name: sc.Name, path: filesystem.NormalizePath(sc.Path), readOnly: sc.ReadOnly}
+	}
+	if cfg.MaxFileSize != "" {
+		size, err := pluginconfig.ParseSize(cfg.MaxFileSize)
+		if err != nil || size <= 0 {
+			cancel()
+			return nil, fmt.Errorf("smb: invalid max_file_size: %s", cfg.MaxFileSize)
+		}
+		s.maxFileSize = size
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		host, _, _ = strings.Cut(host, ".")
+		s.name = strings.ToUpper(host)
+		if len(s.name) > 15 {
+			s.name = s.name[:15]
+		}
+	}
+	if _, err := rand.Read(s.guid[:]); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Shares returns the names of the shares, sorted
+func (s *Server) Shares() []string {
+	names := make([]string, 0, len(s.shares))
+	for _, sh := range s.shares {
+		names = append(names, sh.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListenAndServe listens on addr, DefaultAddress if empty, and serves the connections
+func (s *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = DefaultAddress
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves the connections accepted on ln until the server is closed
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return net.ErrClosed
+	}
+	s.listeners[ln] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, ln)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		c := &conn{s: s, nc: nc, sessions: make(map[uint64]*session)}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return net.ErrClosed
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// Close stops the listeners and closes the connections, writing back the files they
+// have open
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+	s.cancel()
+	return nil
+}
+
+// conn is a client connection
+type conn struct {
+	s       *Server
+	nc      net.Conn
+	dialect uint16
+	signing bool // The client requires signing
+
+	sessions    map[uint64]*session
+	nextSession uint64
+}
+
+// session is a logon of a connection
+type session struct {
+	id        uint64
+	valid     bool            // Set once the logon completed
+	ntlm      *ntlmServer     // Logon in progress
+	spnego    bool            // The client wraps its NTLM messages in SPNEGO
+	principal *auth.Principal // nil for guests without auth
+	guest     bool
+	readOnly  bool   // Guests of servers with auth only read
+	key       []byte // Signing key, nil for guests
+	signing   bool   // Requests must be signed
+	ctx       context.Context
+
+	trees    map[uint32]*tree
+	nextTree uint32
+	opens    map[uint64]*open
+	nextOpen uint64
+}
+
+// fs returns the file system bound to the session, whose principal the access control of
+// the file system checks
+func (sess *session) fs(s *Server) filesystem.FileSystem {
+	return filesystem.WithContext(s.fs, sess.ctx)
+}
+
+// tree is a share a session connected to
+type tree struct {
+	id       uint32
+	share    *share // nil for IPC$
+	readOnly bool   // The share is read-only, or the session only reads
+}
+
+// request is a request of a message, with the state a compounded request inherits from
+// the previous one
+type request struct {
+	header
+	msg  []byte // The request, from its header
+	sess *session
+	tree *tree
+	c    *compound
+}
+
+// compound is the state of the requests of a message
+type compound struct {
+	sessionID uint64
+	treeID    uint32
+	fileID    [16]byte // Of the last create, for related requests
+	status    uint32   // Of the last request
+}
+
+func (c *conn) serve() {
+	defer func() {
+		for _, sess := range c.sessions {
+			c.logoff(sess)
+		}
+		c.nc.Close()
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+	}()
+	remote := c.nc.RemoteAddr()
+	log.Debugf("[smb] connection from %s", remote)
+
+	var frame [4]byte
+	for {
+		if _, err := io.ReadFull(c.nc, frame[:]); err != nil {
+			return
+		}
+		n := int(frame[1])<<16 | int(frame[2])<<8 | int(frame[3])
+		if frame[0] != 0 || n > maxMessageSize {
+			log.Debugf("[smb] %s: invalid frame", remote)
+			return
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(c.nc, msg); err != nil {
+			return
+		}
+		var resp []byte
+		var err error
+		if bytes.HasPrefix(msg, []byte{0xFF, 'S', 'M', 'B'}) {
+			resp, err = c.negotiateSMB1(msg)
+		} else {
+			resp, err = c.handle(msg)
+		}
+		if err != nil {
+			log.Debugf("[smb] %s: %v", remote, err)
+			return
+		}
+		if resp == nil {
+			continue
+		}
+		out := make([]byte, 4, 4+len(resp))
+		out[1], out[2], out[3] = byte(len(resp)>>16), byte(len(resp)>>8), byte(len(resp))
+		if _, err := c.nc.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// negotiateSMB1 answers the SMB1 negotiate clients start with, offering SMB2
+func (c *conn) negotiateSMB1(msg []byte) ([]byte, error) {
+	if len(msg) < 35 || msg[4] != 0x72 {
+		return nil, fmt.Errorf("SMB1 is not supported")
+	}
+	pos := 33 + 2*int(msg[32])
+	if len(msg) < pos+2 {
+		return nil, fmt.Errorf("malformed SMB1 negotiate")
+	}
+	var dialect uint16
+	for _, name := range bytes.Split(msg[pos+2:], []byte{0}) {
+		switch string(bytes.TrimPrefix(name, []byte{0x02})) {
+		case "SMB 2.???":
+			dialect = dialectWildcard
+		case "SMB 2.002":
+			if dialect == 0 {
+				dialect = dialect202
+			}
+		}
+	}
+	if dialect == 0 {
+		return nil, fmt.Errorf("SMB1 is not supported")
+	}
+	if dialect == dialect202 {
+		c.dialect = dialect
+	}
+	resp := make([]byte, headerSize)
+	h := header{Command: cmdNegotiate, Credits: 1, Flags: flagResponse}
+	h.encode(resp)
+	return append(resp, c.negotiateBody(dialect)...), nil
+}
+
+// handle answers the requests of a message
+func (c *conn) handle(msg []byte) ([]byte, error) {
+	state := &compound{}
+	var out []byte
+	var last int // Start of the last response in out
+	for {
+		if len(msg) < headerSize+2 || !bytes.HasPrefix(msg, protocolID) {
+			return nil, fmt.Errorf("malformed request")
+		}
+		h := decodeHeader(msg)
+		end := len(msg)
+		if h.NextCommand != 0 {
+			if h.NextCommand%8 != 0 || int(h.NextCommand) < headerSize || int(h.NextCommand) > len(msg) {
+				return nil, fmt.Errorf("malformed compound request")
+			}
+			end = int(h.NextCommand)
+		}
+		r := &request{header: h, msg: msg[:end], c: state}
+		if h.Flags&flagRelated != 0 {
+			r.SessionID, r.TreeID = state.sessionID, state.treeID
+		} else {
+			state.fileID = [16]byte{}
+			state.status = statusOK
+		}
+
+		resp, ok := c.dispatch(r)
+		if ok {
+			if len(out) > 0 {
+				for len(out)%8 != 0 {
+					out = append(out, 0)
+				}
+				binary.LittleEndian.PutUint32(out[last+20:], uint32(len(out)-last))
+				c.sign(out[last:])
+			}
+			last = len(out)
+			out = append(out, resp...)
+		}
+		state.sessionID, state.treeID = r.SessionID, r.TreeID
+		if h.NextCommand == 0 {
+			break
+		}
+		msg = msg[h.NextCommand:]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	c.sign(out[last:])
+	return out, nil
+}
+
+// sign signs a response of a session with a key if the request was signed or the session
+// requires signing
+func (c *conn) sign(resp []byte) {
+	h := decodeHeader(resp)
+	sess := c.sessions[h.SessionID]
+	if sess == nil || sess.key == nil || h.Flags&flagSigned == 0 {
+		return
+	}
+	copy(resp[48:64], make([]byte, 16))
+	mac := hmac.New(sha256.New, sess.key)
+	mac.Write(resp)
+	copy(resp[48:64], mac.Sum(nil))
+}
+
+// verify checks the signature of a signed request
+func verify(key, msg []byte) bool {
+	signed := append([]byte(nil), msg...)
+	copy(signed[48:64], make([]byte, 16))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(signed)
+	return hmac.Equal(mac.Sum(nil)[:16], msg[48:64])
+}
+
+// dispatch runs a request and returns its response, false for requests without one
+func (c *conn) dispatch(r *request) ([]byte, bool) {
+	if r.Command == cmdCancel {
+		return nil, false
+	}
+
+	status, body := c.run(r)
+	r.c.status = status
+	if body == nil {
+		// Error response
+		body = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0}
+	}
+	credits := r.Credits
+	if credits == 0 {
+		credits = 1
+	}
+	if credits > maxCredits {
+		credits = maxCredits
+	}
+	resp := make([]byte, headerSize, headerSize+len(body))
+	h := header{
+		CreditCharge: r.CreditCharge,
+		Status:       status,
+		Command:      r.Command,
+		Credits:      credits,
+		Flags:        flagResponse | r.Flags&flagRelated,
+		MessageID:    r.MessageID,
+		TreeID:       r.TreeID,
+		SessionID:    r.SessionID,
+	}
+	if sess := c.sessions[r.SessionID]; sess != nil && sess.key != nil && sess.valid &&
+		(r.Flags&flagSigned != 0 || sess.signing) {
+		h.Flags |= flagSigned
+	}
+	h.encode(resp)
+	return append(resp, body...), true
+}
+
+// run runs a request on its session and tree, and returns the status and body of its
+// response, a nil body for an error response
+func (c *conn) run(r *request) (uint32, []byte) {
+	switch r.Command {
+	case cmdNegotiate:
+		return c.negotiate(r)
+	case cmdEcho:
+		return statusOK, []byte{4, 0, 0, 0}
+	}
+	if c.dialect == 0 {
+		return statusInvalidParameter, nil
+	}
+	if r.Command == cmdSessionSetup {
+		return c.sessionSetup(r)
+	}
+
+	r.sess = c.sessions[r.SessionID]
+	if r.sess == nil || !r.sess.valid {
+		return statusUserSessionDeleted, nil
+	}
+	if r.Flags&flagSigned != 0 && r.sess.key != nil {
+		if !verify(r.sess.key, r.msg) {
+			log.Warnf("[smb] %s: request with an invalid signature", c.nc.RemoteAddr())
+			return statusAccessDenied, nil
+		}
+	} else if r.sess.signing {
+		return statusAccessDenied, nil
+	}
+	// A related request fails with the request before it
+	if r.Flags&flagRelated != 0 && r.c.status != statusOK && r.c.status != statusBufferOverflow {
+		return r.c.status, nil
+	}
+
+	switch r.Command {
+	case cmdLogoff:
+		c.logoff(r.sess)
+		delete(c.sessions, r.sess.id)
+		return statusOK, []byte{4, 0, 0, 0}
+	case cmdTreeConnect:
+		return c.treeConnect(r)
+	}
+
+	r.tree = r.sess.trees[r.TreeID]
+	if r.tree == nil {
+		return statusNetworkNameDeleted, nil
+	}
+	switch r.Command {
+	case cmdTreeDisconnect:
+		c.closeOpens(r.sess, r.tree)
+		delete(r.sess.trees, r.tree.id)
+		return statusOK, []byte{4, 0, 0, 0}
+	case cmdCreate:
+		return c.create(r)
+	case cmdClose:
+		return c.close(r)
+	case cmdFlush:
+		return c.flush(r)
+	case cmdRead:
+		return c.read(r)
+	case cmdWrite:
+		return c.write(r)
+	case cmdLock:
+		// Byte-range locks are not enforced
+		if _, status := c.lookup(r, 72); status != statusOK {
+			return status, nil
+		}
+		return statusOK, []byte{4, 0, 0, 0}
+	case cmdIoctl:
+		return c.ioctl(r)
+	case cmdQueryDirectory:
+		return c.queryDirectory(r)
+	case cmdQueryInfo:
+		return c.queryInfo(r)
+	case cmdSetInfo:
+		return c.setInfo(r)
+	default:
+		return statusNotSupported, nil
+	}
+}
+
+// negotiateBody is the body of negotiate responses
+func (c *conn) negotiateBody(dialect uint16) []byte {
+	token := spnegoInit()
+	mode := uint16(signingEnabled)
+	if c.s.requireSigning {
+		mode |= signingRequired
+	}
+	var w buffer
+	w.u16(65)
+	w.u16(mode)
+	w.u16(dialect)
+	w.u16(0)
+	w.bytes(c.s.guid[:])
+	w.u32(0) // Capabilities
+	w.u32(maxTransactSize)
+	w.u32(maxReadSize)
+	w.u32(maxWriteSize)
+	w.time(time.Now())
+	w.time(c.s.started)
+	w.u16(headerSize + 64)
+	w.u16(uint16(len(token)))
+	w.u32(0)
+	w.bytes(token)
+	return w.b
+}
+
+func (c *conn) negotiate(r *request) (uint32, []byte) {
+	if c.dialect != 0 || len(r.msg) < headerSize+36 {
+		return statusInvalidParameter, nil
+	}
+	le := binary.LittleEndian
+	count := int(le.Uint16(r.msg[66:]))
+	dialects := body(r.msg, headerSize+36, 2*count)
+	for i := 0; i < len(dialects); i += 2 {
+		switch d := le.Uint16(dialects[i:]); d {
+		case dialect202, dialect210:
+			c.dialect = max(c.dialect, d)
+		}
+	}
+	if c.dialect == 0 {
+		return statusNotSupported, nil
+	}
+	c.signing = le.Uint16(r.msg[68:])&signingRequired != 0
+	return statusOK, c.negotiateBody(c.dialect)
+}
+
+// sessionSetup runs the rounds of a logon: the NTLM negotiate message of the client is
+// answered with a challenge, its authenticate message completes the logon
+func (c *conn) sessionSetup(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+24 {
+		return statusInvalidParameter, nil
+	}
+	le := binary.LittleEndian
+	token := body(r.msg, int(le.Uint16(r.msg[76:])), int(le.Uint16(r.msg[78:])))
+
+	sess := c.sessions[r.SessionID]
+	if r.SessionID == 0 {
+		c.nextSession++
+		sess = &session{
+			id:    c.nextSession,
+			ntlm:  &ntlmServer{name: c.s.name},
+			trees: make(map[uint32]*tree),
+			opens: make(map[uint64]*open),
+		}
+		c.sessions[sess.id] = sess
+		r.SessionID = sess.id
+	} else if sess == nil {
+		return statusUserSessionDeleted, nil
+	} else if sess.valid {
+		return statusNotSupported, nil // Reauthentication
+	}
+	fail := func(status uint32) (uint32, []byte) {
+		delete(c.sessions, sess.id)
+		return status, nil
+	}
+
+	msg, spnego, err := unwrapToken(token)
+	if err != nil {
+		return fail(statusInvalidParameter)
+	}
+	if spnego {
+		sess.spnego = true
+	}
+	switch ntlmType(msg) {
+	case 0:
+		if !spnego {
+			return fail(statusInvalidParameter)
+		}
+		// The token is for another mechanism, ask for NTLM
+		return statusMoreProcessing, sessionSetupBody(0, spnegoResponse(negAcceptIncomplete, nil, true))
+	case ntlmNegotiate:
+		challenge, err := sess.ntlm.challengeMessage(msg)
+		if err != nil {
+			return fail(statusInvalidParameter)
+		}
+		if sess.spnego {
+			challenge = spnegoResponse(negAcceptIncomplete, challenge, true)
+		}
+		return statusMoreProcessing, sessionSetupBody(0, challenge)
+	case ntlmAuthenticate:
+	default:
+		return fail(statusInvalidParameter)
+	}
+
+	a, err := sess.ntlm.parseAuthenticate(msg)
+	if err != nil {
+		return fail(statusInvalidParameter)
+	}
+	var flags uint16
+	var principal *auth.Principal
+	var password []byte
+	if c.s.users != nil && !a.anonymous {
+		principal, password, _ = c.s.users.Credential(a.user)
+	}
+	switch {
+	case principal != nil:
+		key, ok := sess.ntlm.verify(a, password)
+		if !ok {
+			log.Infof("[smb] %s: logon of %s failed", c.nc.RemoteAddr(), a.user)
+			return fail(statusLogonFailure)
+		}
+		sess.key = key
+		sess.signing = c.signing || c.s.requireSigning
+	case c.s.guest:
+		// Anonymous logons and unknown users are guests; when there are users, the access
+		// control of the file system checks them as GuestPrincipal and they only read
+		sess.guest = true
+		if c.s.users != nil {
+			principal = &auth.Principal{Name: GuestPrincipal, Method: "guest", Role: auth.RoleUser}
+			sess.readOnly = true
+		}
+		flags = sessionFlagGuest
+		if a.anonymous {
+			flags = sessionFlagNull
+		}
+	default:
+		log.Infof("[smb] %s: logon of unknown user %q", c.nc.RemoteAddr(), a.user)
+		return fail(statusLogonFailure)
+	}
+	sess.ntlm = nil
+	sess.valid = true
+	sess.ctx = c.s.ctx
+	sess.principal = principal
+	if principal != nil {
+		sess.ctx = auth.WithPrincipal(c.s.ctx, principal)
+	}
+	if sess.guest {
+		log.Infof("[smb] %s: guest logged on", c.nc.RemoteAddr())
+	} else {
+		log.Infof("[smb] %s: %s logged on", c.nc.RemoteAddr(), principal.Name)
+	}
+	var resp []byte
+	if sess.spnego {
+		resp = spnegoResponse(negAcceptCompleted, nil, false)
+	}
+	return statusOK, sessionSetupBody(flags, resp)
+}
+
+func sessionSetupBody(flags uint16, token []byte) []byte {
+	var w buffer
+	w.u16(9)
+	w.u16(flags)
+	w.u16(headerSize + 8)
+	w.u16(uint16(len(token)))
+	w.bytes(token)
+	if len(token) == 0 {
+		w.u8(0)
+	}
+	return w.b
+}
+
+// logoff closes the opens of a session
+func (c *conn) logoff(sess *session) {
+	c.closeOpens(sess, nil)
+	sess.trees = make(map[uint32]*tree)
+}
+
+func (c *conn) treeConnect(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+8 {
+		return statusInvalidParameter, nil
+	}
+	le := binary.LittleEndian
+	unc := decodeUTF16(body(r.msg, int(le.Uint16(r.msg[68:])), int(le.Uint16(r.msg[70:]))))
+	name := unc[strings.LastIndex(unc, `\`)+1:]
+
+	t := &tree{}
+	access := uint32(accessAll)
+	shareType := byte(0x01) // Disk
+	if strings.EqualFold(name, ipcShare) {
+		shareType = 0x02 // Pipe
+	} else if t.share = c.s.shares[strings.ToUpper(name)]; t.share == nil {
+		return statusBadNetworkName, nil
+	} else if t.share.readOnly || r.sess.readOnly {
+		t.readOnly = true
+		access = accessReadOnly
+	}
+	r.sess.nextTree++
+	t.id = r.sess.nextTree
+	r.sess.trees[t.id] = t
+	r.TreeID = t.id
+
+	var w buffer
+	w.u16(16)
+	w.u8(shareType)
+	w.u8(0)
+	w.u32(0) // Share flags: manual caching
+	w.u32(0) // Capabilities
+	w.u32(access)
+	return statusOK, w.b
+}
+
+// ioctl answers IO controls; DFS referrals are refused the way servers without DFS do
+func (c *conn) ioctl(r *request) (uint32, []byte) {
+	if len(r.msg) < headerSize+56 {
+		return statusInvalidParameter, nil
+	}
+	if binary.LittleEndian.Uint32(r.msg[68:]) == fsctlDFSGetReferrals {
+		return statusFSDriverRequired, nil
+	}
+	return statusNotSupported, nil
+}
+
+// sharePath maps a name in a share, with backslashes, to a path of the file system; it
+// refuses names with wildcards, named streams, and components leaving the share
+func sharePath(sh *share, name string) (string, string, uint32) {
+	name = strings.TrimSuffix(strings.Trim(name, `\`), "::$DATA")
+	if strings.ContainsAny(name, `/*?"<>|`) {
+		return "", "", statusObjectNameInvalid
+	}
+	if strings.Contains(name, ":") {
+		return "", "", statusObjectNameNotFound // Named streams
+	}
+	if name == "" {
+		return sh.path, "", statusOK
+	}
+	parts := strings.Split(name, `\`)
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			return "", "", statusObjectNameInvalid
+		}
+	}
+	return path.Join(sh.path, strings.Join(parts, "/")), name, statusOK
+}
+
+// errorStatus maps an error of the file system to an NTSTATUS
+func errorStatus(err error) uint32 {
+	switch {
+	case filesystem.IsNotFound(err):
+		return statusObjectNameNotFound
+	case errors.Is(err, filesystem.ErrPermissionDenied):
+		return statusAccessDenied
+	case errors.Is(err, filesystem.ErrAlreadyExists):
+		return statusObjectNameCollision
+	case errors.Is(err, filesystem.ErrNotDirectory):
+		return statusNotADirectory
+	case errors.Is(err, filesystem.ErrInvalidArgument):
+		return statusInvalidParameter
+	case errors.Is(err, filesystem.ErrNotSupported):
+		return statusNotSupported
+	case errors.Is(err, filesystem.ErrQuotaExceeded):
+		return statusDiskFull
+	case errors.Is(err, filesystem.ErrTimeout):
+		return statusIOTimeout
+	default:
+		return statusUnexpectedIOError
+	}
+}
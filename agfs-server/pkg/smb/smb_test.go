@@ -0,0 +1,684 @@
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/acl"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/auth"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/pfstest"
+)
+
+func TestMD4(t *testing.T) {
+	for in, want := range map[string]string{
+		"":               "31d6cfe0d16ae931b73c59d7e0c089c0",
+		"abc":            "a448017aaf21d8525fc10ae87aa6729d",
+		"message digest": "d9130a8164549fe818874806e1c7014b",
+	} {
+		if got := md4([]byte(in)); hex.EncodeToString(got[:]) != want {
+			t.Errorf("md4(%q) = %x, want %s", in, got, want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*", "a.txt", true},
+		{"*.TXT", "a.txt", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*b*", "abc", true},
+		{"*.txt", "a.txt.bak", false},
+		{`<"TXT`, "a.txt", true},
+	} {
+		if got := match(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("match(%q, %q) = %v", tc.pattern, tc.name, got)
+		}
+	}
+}
+
+// testClient speaks enough SMB2 to drive the server
+type testClient struct {
+	t         *testing.T
+	nc        net.Conn
+	messageID uint64
+	sessionID uint64
+	treeID    uint32
+	key       []byte // Signs the requests once set
+}
+
+func serve(t *testing.T, cfg config.SMBConfig, srv *pfstest.Server, users *auth.Authenticator) string {
+	t.Helper()
+	s, err := New(cfg, srv.FS, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	return &testClient{t: t, nc: nc}
+}
+
+// roundTrip sends a message and returns the response
+func (c *testClient) roundTrip(msg []byte) []byte {
+	c.t.Helper()
+	frame := []byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}
+	if _, err := c.nc.Write(append(frame, msg...)); err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := io.ReadFull(c.nc, frame); err != nil {
+		c.t.Fatal(err)
+	}
+	resp := make([]byte, int(frame[1])<<16|int(frame[2])<<8|int(frame[3]))
+	if _, err := io.ReadFull(c.nc, resp); err != nil {
+		c.t.Fatal(err)
+	}
+	return resp
+}
+
+// message builds a request, signed if the client has a key
+func (c *testClient) message(cmd uint16, flags uint32, body []byte) []byte {
+	h := header{Command: cmd, Credits: 1, Flags: flags, MessageID: c.messageID, TreeID: c.treeID, SessionID: c.sessionID}
+	c.messageID++
+	if c.key != nil {
+		h.Flags |= flagSigned
+	}
+	msg := make([]byte, headerSize)
+	h.encode(msg)
+	msg = append(msg, body...)
+	if c.key != nil {
+		mac := hmac.New(sha256.New, c.key)
+		mac.Write(msg)
+		copy(msg[48:64], mac.Sum(nil))
+	}
+	return msg
+}
+
+// call sends a request and returns the status and the response, whose signature it checks
+func (c *testClient) call(cmd uint16, body []byte) (uint32, []byte) {
+	c.t.Helper()
+	resp := c.roundTrip(c.message(cmd, 0, body))
+	h := decodeHeader(resp)
+	if c.key != nil && !verify(c.key, resp) {
+		c.t.Fatalf("command %#x: response not signed", cmd)
+	}
+	return h.Status, resp
+}
+
+// must sends a request that has to succeed
+func (c *testClient) must(cmd uint16, body []byte) []byte {
+	c.t.Helper()
+	status, resp := c.call(cmd, body)
+	if status != statusOK {
+		c.t.Fatalf("command %#x: status %#x", cmd, status)
+	}
+	return resp
+}
+
+func (c *testClient) negotiate() uint16 {
+	var w buffer
+	w.u16(36)
+	w.u16(2)
+	w.u16(signingEnabled)
+	w.u16(0)
+	w.u32(0)
+	w.zeros(16 + 8)
+	w.u16(dialect202)
+	w.u16(dialect210)
+	resp := c.must(cmdNegotiate, w.b)
+	return binary.LittleEndian.Uint16(resp[68:])
+}
+
+// logon runs an NTLMv2 logon, in SPNEGO tokens if spnego; an empty user logs on
+// anonymously; it returns the status and the session flags
+func (c *testClient) logon(user, password string, spnego bool) (uint32, uint16) {
+	c.t.Helper()
+	setup := func(token []byte) (uint32, []byte) {
+		var w buffer
+		w.u16(25)
+		w.u8(0)
+		w.u8(signingEnabled)
+		w.u32(0)
+		w.u32(0)
+		w.u16(headerSize + 24)
+		w.u16(uint16(len(token)))
+		w.u64(0)
+		w.bytes(token)
+		status, resp := c.call(cmdSessionSetup, w.b)
+		c.sessionID = decodeHeader(resp).SessionID
+		return status, resp
+	}
+	const flags = ntlmFlagUnicode | ntlmFlagNTLM | ntlmFlagExtendedSession | ntlmFlagKeyExchange | ntlmFlag128 | ntlmFlagSign | ntlmFlagAlwaysSign
+	var negotiate buffer
+	negotiate.bytes(ntlmSignature)
+	negotiate.u32(ntlmNegotiate)
+	negotiate.u32(flags)
+	negotiate.zeros(16)
+	token := negotiate.b
+	if spnego {
+		token = der(0x60, oidSPNEGO, der(0xa0, der(0x30, der(0xa0, der(0x30, oidNTLMSSP)), der(0xa2, der(0x04, token)))))
+	}
+	c.sessionID = 0
+	status, resp := setup(token)
+	if status != statusMoreProcessing {
+		c.t.Fatalf("negotiate: status %#x", status)
+	}
+	le := binary.LittleEndian
+	challenge := resp[le.Uint16(resp[68:]):]
+	challenge = challenge[bytes.Index(challenge, ntlmSignature):]
+	info := challenge[le.Uint32(challenge[44:]):][:le.Uint16(challenge[40:])]
+
+	var nt, encryptedKey, exportedKey []byte
+	if user != "" {
+		hash := md4(encodeUTF16(password))
+		mac := hmac.New(md5.New, hash[:])
+		mac.Write(encodeUTF16(strings.ToUpper(user) + "WORKGROUP"))
+		ntowf := mac.Sum(nil)
+		var blob buffer
+		blob.bytes([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+		blob.u64(0x01d9000000000000)
+		blob.bytes([]byte("clientch"))
+		blob.u32(0)
+		blob.bytes(info)
+		blob.u32(0)
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(challenge[24:32])
+		mac.Write(blob.b)
+		proof := mac.Sum(nil)
+		nt = append(proof, blob.b...)
+		mac = hmac.New(md5.New, ntowf)
+		mac.Write(proof)
+		exportedKey = []byte("0123456789abcdef")
+		encryptedKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(mac.Sum(nil))
+		cipher.XORKeyStream(encryptedKey, exportedKey)
+	}
+	fields := [][]byte{nil, nt, encodeUTF16("WORKGROUP"), encodeUTF16(user), nil, encryptedKey}
+	var authenticate buffer
+	authenticate.bytes(ntlmSignature)
+	authenticate.u32(ntlmAuthenticate)
+	offset := 72
+	for _, f := range fields {
+		authenticate.u16(uint16(len(f)))
+		authenticate.u16(uint16(len(f)))
+		authenticate.u32(uint32(offset))
+		offset += len(f)
+	}
+	authenticate.u32(flags)
+	authenticate.zeros(8)
+	for _, f := range fields {
+		authenticate.bytes(f)
+	}
+	token = authenticate.b
+	if spnego {
+		token = der(0xa1, der(0x30, der(0xa2, der(0x04, token))))
+	}
+	status, resp = setup(token)
+	sessionFlags := le.Uint16(resp[66:])
+	if status == statusOK && sessionFlags == 0 {
+		c.key = exportedKey
+	}
+	return status, sessionFlags
+}
+
+func (c *testClient) connect(share string) uint32 {
+	c.t.Helper()
+	unc := encodeUTF16(`\\server\` + share)
+	var w buffer
+	w.u16(9)
+	w.u16(0)
+	w.u16(headerSize + 8)
+	w.u16(uint16(len(unc)))
+	w.bytes(unc)
+	status, resp := c.call(cmdTreeConnect, w.b)
+	c.treeID = decodeHeader(resp).TreeID
+	return status
+}
+
+func createBody(name string, access, disposition, options uint32) []byte {
+	n := encodeUTF16(name)
+	var w buffer
+	w.u16(57)
+	w.u8(0)
+	w.u8(0)
+	w.u32(2) // Impersonation
+	w.zeros(16)
+	w.u32(access)
+	w.u32(0)
+	w.u32(7) // Share all
+	w.u32(disposition)
+	w.u32(options)
+	w.u16(headerSize + 56)
+	w.u16(uint16(len(n)))
+	w.u64(0)
+	w.bytes(n)
+	if len(n) == 0 {
+		w.u8(0)
+	}
+	return w.b
+}
+
+func (c *testClient) create(name string, access, disposition, options uint32) ([16]byte, uint32) {
+	c.t.Helper()
+	status, resp := c.call(cmdCreate, createBody(name, access, disposition, options))
+	var id [16]byte
+	if status == statusOK {
+		copy(id[:], resp[headerSize+64:])
+	}
+	return id, status
+}
+
+// fileBody is the body of close and flush requests
+func fileBody(id [16]byte) []byte {
+	var w buffer
+	w.u16(24)
+	w.u16(0)
+	w.u32(0)
+	w.bytes(id[:])
+	return w.b
+}
+
+func readBody(id [16]byte, offset uint64, length uint32) []byte {
+	var w buffer
+	w.u16(49)
+	w.u8(0x50)
+	w.u8(0)
+	w.u32(length)
+	w.u64(offset)
+	w.bytes(id[:])
+	w.zeros(17)
+	return w.b
+}
+
+func (c *testClient) read(id [16]byte, offset uint64) (string, uint32) {
+	c.t.Helper()
+	status, resp := c.call(cmdRead, readBody(id, offset, 4096))
+	if status != statusOK {
+		return "", status
+	}
+	le := binary.LittleEndian
+	return string(resp[resp[66]:][:le.Uint32(resp[68:])]), status
+}
+
+func (c *testClient) write(id [16]byte, offset uint64, data string) uint32 {
+	var w buffer
+	w.u16(49)
+	w.u16(headerSize + 48)
+	w.u32(uint32(len(data)))
+	w.u64(offset)
+	w.bytes(id[:])
+	w.zeros(16)
+	w.bytes([]byte(data))
+	status, _ := c.call(cmdWrite, w.b)
+	return status
+}
+
+// list returns the names query directory returns for pattern, one call each until the end
+func (c *testClient) list(id [16]byte, pattern string) []string {
+	c.t.Helper()
+	n := encodeUTF16(pattern)
+	var names []string
+	for flags := byte(queryRestartScans); ; flags = 0 {
+		var w buffer
+		w.u16(33)
+		w.u8(fileIdBothDirectoryInformation)
+		w.u8(flags)
+		w.u32(0)
+		w.bytes(id[:])
+		w.u16(headerSize + 32)
+		w.u16(uint16(len(n)))
+		w.u32(65536)
+		w.bytes(n)
+		status, resp := c.call(cmdQueryDirectory, w.b)
+		if status == statusNoMoreFiles || status == statusNoSuchFile {
+			sort.Strings(names)
+			return names
+		}
+		if status != statusOK {
+			c.t.Fatalf("query directory: status %#x", status)
+		}
+		le := binary.LittleEndian
+		out := resp[le.Uint16(resp[66:]):][:le.Uint32(resp[68:])]
+		for {
+			nameLen := le.Uint32(out[60:])
+			names = append(names, decodeUTF16(out[104:104+nameLen]))
+			next := le.Uint32(out)
+			if next == 0 {
+				break
+			}
+			out = out[next:]
+		}
+	}
+}
+
+func (c *testClient) queryInfo(id [16]byte, infoType, class byte) (uint32, []byte) {
+	var w buffer
+	w.u16(41)
+	w.u8(infoType)
+	w.u8(class)
+	w.u32(4096)
+	w.zeros(16)
+	w.bytes(id[:])
+	w.u8(0)
+	status, resp := c.call(cmdQueryInfo, w.b)
+	if status != statusOK {
+		return status, nil
+	}
+	le := binary.LittleEndian
+	return status, resp[le.Uint16(resp[66:]):][:le.Uint32(resp[68:])]
+}
+
+func (c *testClient) setInfo(id [16]byte, class byte, info []byte) uint32 {
+	var w buffer
+	w.u16(33)
+	w.u8(infoFile)
+	w.u8(class)
+	w.u32(uint32(len(info)))
+	w.u16(headerSize + 32)
+	w.u16(0)
+	w.u32(0)
+	w.bytes(id[:])
+	w.bytes(info)
+	status, _ := c.call(cmdSetInfo, w.b)
+	return status
+}
+
+func TestShares(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{"/memfs/docs/a.txt": "hello", "/memfs/docs/sub/b.txt": "b"})
+	users, err := auth.New(config.AuthConfig{Enabled: true, APIKeys: []config.APIKeyConfig{
+		{Name: "alice", Key: "alice-secret"},
+		{Name: "bob", Key: "bob-secret"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessList, err := acl.New(config.ACLConfig{Rules: []config.ACLRuleConfig{
+		{Principal: "bob", Path: "/memfs/docs", Allow: []string{"read"}},
+	}}, func(ctx context.Context) (string, bool) {
+		if p := auth.FromContext(ctx); p != nil {
+			return p.Name, true
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.FS.SetAccessControl(accessList)
+	addr := serve(t, config.SMBConfig{Shares: []config.SMBShareConfig{
+		{Name: "docs", Path: "/memfs/docs"},
+		{Name: "archive", Path: "/memfs/docs", ReadOnly: true},
+	}}, srv, users)
+
+	c := dial(t, addr)
+	if dialect := c.negotiate(); dialect != dialect210 {
+		t.Fatalf("dialect %#x", dialect)
+	}
+	if status, _ := c.logon("alice", "wrong", true); status != statusLogonFailure {
+		t.Fatalf("wrong password: status %#x", status)
+	}
+	if status, _ := c.logon("", "", false); status != statusLogonFailure {
+		t.Fatalf("anonymous logon without guests: status %#x", status)
+	}
+	if status, flags := c.logon("ALICE", "alice-secret", true); status != statusOK || flags != 0 {
+		t.Fatalf("logon: status %#x, flags %#x", status, flags)
+	}
+	if status := c.connect("nope"); status != statusBadNetworkName {
+		t.Errorf("unknown share: status %#x", status)
+	}
+	if status := c.connect("DOCS"); status != statusOK {
+		t.Fatalf("tree connect: status %#x", status)
+	}
+
+	// Files written are buffered until closed
+	id, status := c.create(`dir\new.txt`, accessAll, fileOverwriteIf, optionNonDirectory)
+	if status != statusObjectPathNotFound {
+		t.Errorf("create in a missing directory: status %#x", status)
+	}
+	id, status = c.create("new.txt", accessAll, fileOverwriteIf, optionNonDirectory)
+	if status != statusOK {
+		t.Fatalf("create: status %#x", status)
+	}
+	if status := c.write(id, 0, "hi there"); status != statusOK {
+		t.Fatalf("write: status %#x", status)
+	}
+	if data, _ := c.read(id, 3); data != "there" {
+		t.Errorf("read of the buffer = %q", data)
+	}
+	c.must(cmdClose, fileBody(id))
+	srv.AssertFile("/memfs/docs/new.txt", "hi there")
+
+	id, _ = c.create("a.txt", accessReadOnly, fileOpen, 0)
+	if data, status := c.read(id, 0); data != "hello" || status != statusOK {
+		t.Errorf("read = %q, status %#x", data, status)
+	}
+	if _, status := c.read(id, 5); status != statusEndOfFile {
+		t.Errorf("read at the end: status %#x", status)
+	}
+	if status, info := c.queryInfo(id, infoFile, fileStandardInformation); status != statusOK || binary.LittleEndian.Uint64(info[8:]) != 5 {
+		t.Errorf("standard information: status %#x, %x", status, info)
+	}
+	if status, info := c.queryInfo(id, infoFilesystem, fsAttributeInformation); status != statusOK || decodeUTF16(info[12:]) != "NTFS" {
+		t.Errorf("file system attributes: status %#x, %x", status, info)
+	}
+	if status := c.write(id, 0, "x"); status != statusAccessDenied {
+		t.Errorf("write to a file opened for reading: status %#x", status)
+	}
+	c.must(cmdClose, fileBody(id))
+	if _, status := c.create(`..\escape.txt`, accessAll, fileCreate, 0); status != statusObjectNameInvalid {
+		t.Errorf("create outside the share: status %#x", status)
+	}
+
+	root, _ := c.create("", accessReadOnly, fileOpen, optionDirectory)
+	if names := c.list(root, "*"); strings.Join(names, ",") != ".,..,a.txt,new.txt,sub" {
+		t.Errorf("listing = %v", names)
+	}
+	if names := c.list(root, "*.TXT"); strings.Join(names, ",") != "a.txt,new.txt" {
+		t.Errorf("listing of *.TXT = %v", names)
+	}
+	if names := c.list(root, "none*"); len(names) != 0 {
+		t.Errorf("listing of none* = %v", names)
+	}
+	c.must(cmdClose, fileBody(root))
+
+	// Rename, then delete on close
+	id, _ = c.create("new.txt", accessAll, fileOpen, 0)
+	target := encodeUTF16("renamed.txt")
+	var rename buffer
+	rename.u8(0)
+	rename.zeros(15)
+	rename.u32(uint32(len(target)))
+	rename.bytes(target)
+	if status := c.setInfo(id, fileRenameInformation, rename.b); status != statusOK {
+		t.Fatalf("rename: status %#x", status)
+	}
+	c.must(cmdClose, fileBody(id))
+	srv.AssertFile("/memfs/docs/renamed.txt", "hi there")
+	srv.AssertNotExist("/memfs/docs/new.txt")
+	id, _ = c.create("renamed.txt", accessAll, fileOpen, optionDeleteOnClose)
+	c.must(cmdClose, fileBody(id))
+	srv.AssertNotExist("/memfs/docs/renamed.txt")
+	id, _ = c.create("sub", accessAll, fileOpen, optionDirectory)
+	if status := c.setInfo(id, fileDispositionInformation, []byte{1}); status != statusDirectoryNotEmpty {
+		t.Errorf("deleting a directory with files: status %#x", status)
+	}
+	c.must(cmdClose, fileBody(id))
+
+	// A create, read and close compounded, the related ones on the file of the create
+	all := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	key := c.key
+	c.key = nil // Signed below, once chained
+	msgs := [][]byte{
+		c.message(cmdCreate, 0, createBody("a.txt", accessReadOnly, fileOpen, 0)),
+		c.message(cmdRead, flagRelated, readBody([16]byte(all), 0, 100)),
+		c.message(cmdClose, flagRelated, fileBody([16]byte(all))),
+	}
+	c.key = key
+	var compound []byte
+	for i, msg := range msgs {
+		for len(msg)%8 != 0 && i < len(msgs)-1 {
+			msg = append(msg, 0)
+		}
+		if i < len(msgs)-1 {
+			binary.LittleEndian.PutUint32(msg[20:], uint32(len(msg)))
+		}
+		binary.LittleEndian.PutUint32(msg[16:], binary.LittleEndian.Uint32(msg[16:])|flagSigned)
+		mac := hmac.New(sha256.New, key)
+		mac.Write(msg)
+		copy(msg[48:64], mac.Sum(nil))
+		compound = append(compound, msg...)
+	}
+	resp := c.roundTrip(compound)
+	var statuses []uint32
+	for {
+		h := decodeHeader(resp)
+		statuses = append(statuses, h.Status)
+		end := len(resp)
+		if h.NextCommand != 0 {
+			end = int(h.NextCommand)
+		}
+		if !verify(key, resp[:end]) {
+			t.Errorf("compounded response %d not signed", len(statuses))
+		}
+		if h.Command == cmdRead && h.Status == statusOK && string(resp[resp[66]:][:5]) != "hello" {
+			t.Errorf("compounded read = %q", resp[resp[66]:end])
+		}
+		if h.NextCommand == 0 {
+			break
+		}
+		resp = resp[h.NextCommand:]
+	}
+	if len(statuses) != 3 || statuses[0] != statusOK || statuses[1] != statusOK || statuses[2] != statusOK {
+		t.Errorf("compounded statuses %#x", statuses)
+	}
+
+	// A request with a bad signature
+	msg := c.message(cmdTreeDisconnect, 0, []byte{4, 0, 0, 0})
+	msg[50] ^= 0xFF
+	if status := decodeHeader(c.roundTrip(msg)).Status; status != statusAccessDenied {
+		t.Errorf("bad signature: status %#x", status)
+	}
+
+	// Read-only shares refuse writes
+	if status := c.connect("archive"); status != statusOK {
+		t.Fatalf("tree connect: status %#x", status)
+	}
+	if _, status := c.create("a.txt", accessAll&^accessMaximumAllowed, fileOpen, 0); status != statusAccessDenied {
+		t.Errorf("open for writing on a read-only share: status %#x", status)
+	}
+	if _, status := c.create("x.txt", accessReadOnly, fileCreate, 0); status != statusAccessDenied {
+		t.Errorf("create on a read-only share: status %#x", status)
+	}
+	if _, status := c.create("a.txt", accessMaximumAllowed, fileOpen, 0); status != statusOK {
+		t.Errorf("open on a read-only share: status %#x", status)
+	}
+
+	// The ACL lets bob only read the share
+	b := dial(t, addr)
+	b.negotiate()
+	if status, _ := b.logon("bob", "bob-secret", false); status != statusOK {
+		t.Fatalf("logon: status %#x", status)
+	}
+	b.connect("docs")
+	if _, status := b.create("bob.txt", accessAll, fileCreate, 0); status != statusAccessDenied {
+		t.Errorf("create denied by the ACL: status %#x", status)
+	}
+	id, _ = b.create("a.txt", accessReadOnly, fileOpen, 0)
+	if data, _ := b.read(id, 0); data != "hello" {
+		t.Errorf("read allowed by the ACL = %q", data)
+	}
+}
+
+func TestGuest(t *testing.T) {
+	srv := pfstest.NewServer(t)
+	srv.Seed(map[string]string{"/memfs/pub/a.txt": "hello"})
+	cfg := config.SMBConfig{Shares: []config.SMBShareConfig{{Name: "pub", Path: "/memfs/pub"}}}
+	if _, err := New(cfg, srv.FS, nil); err == nil {
+		t.Error("no error without auth and guests")
+	}
+	cfg.Guest = true
+	addr := serve(t, cfg, srv, nil)
+
+	// Clients start with an SMB1 negotiate offering SMB2
+	c := dial(t, addr)
+	var smb1 buffer
+	smb1.bytes([]byte{0xFF, 'S', 'M', 'B', 0x72})
+	smb1.zeros(27)
+	smb1.u8(0)
+	dialects := []byte("\x02NT LM 0.12\x00\x02SMB 2.002\x00\x02SMB 2.???\x00")
+	smb1.u16(uint16(len(dialects)))
+	smb1.bytes(dialects)
+	resp := c.roundTrip(smb1.b)
+	if !bytes.HasPrefix(resp, protocolID) || binary.LittleEndian.Uint16(resp[68:]) != dialectWildcard {
+		t.Fatalf("SMB1 negotiate answered with %x", resp[:min(len(resp), 72)])
+	}
+	c.negotiate()
+	if status, flags := c.logon("", "", false); status != statusOK || flags != sessionFlagNull {
+		t.Fatalf("anonymous logon: status %#x, flags %#x", status, flags)
+	}
+	if status := c.connect("IPC$"); status != statusOK {
+		t.Errorf("IPC$: status %#x", status)
+	}
+	if _, status := c.create("srvsvc", accessAll, fileOpen, 0); status != statusObjectNameNotFound {
+		t.Errorf("pipe: status %#x", status)
+	}
+	c.connect("pub")
+	id, status := c.create("b.txt", accessAll, fileCreate, 0)
+	if status != statusOK {
+		t.Fatalf("create: status %#x", status)
+	}
+	c.write(id, 0, "guest")
+	c.must(cmdFlush, fileBody(id))
+	srv.AssertFile("/memfs/pub/b.txt", "guest")
+	c.must(cmdClose, fileBody(id))
+
+	// With auth, guests only read
+	users, err := auth.New(config.AuthConfig{Enabled: true, APIKeys: []config.APIKeyConfig{{Name: "alice", Key: "alice-secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := dial(t, serve(t, cfg, srv, users))
+	g.negotiate()
+	if status, flags := g.logon("mallory", "guess", false); status != statusOK || flags != sessionFlagGuest {
+		t.Fatalf("guest logon: status %#x, flags %#x", status, flags)
+	}
+	g.connect("pub")
+	if _, status := g.create("c.txt", accessAll, fileCreate, 0); status != statusAccessDenied {
+		t.Errorf("create by a guest: status %#x", status)
+	}
+	if _, status := g.create("a.txt", accessAll&^accessMaximumAllowed, fileOpen, 0); status != statusAccessDenied {
+		t.Errorf("open for writing by a guest: status %#x", status)
+	}
+	id, _ = g.create("a.txt", accessReadOnly, fileOpen, 0)
+	if data, _ := g.read(id, 0); data != "hello" {
+		t.Errorf("read by a guest = %q", data)
+	}
+}
@@ -0,0 +1,261 @@
+package tags
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDBPath is the tag database used when none is configured
+const DefaultDBPath = "tags.db"
+
+// maxTagLength bounds the length of a single tag
+const maxTagLength = 128
+
+// TagCount is a tag and the number of paths carrying it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// ChangeSource publishes mutations, implemented by MountableFS
+type ChangeSource interface {
+	Subscribe(fn func(mountablefs.Change)) func()
+}
+
+// Store keeps the tags attached to paths in a SQLite database
+// Paths are global AGFS paths, so one store covers every mount
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (or creates) the tag database at dbPath
+// ":memory:" keeps tags for the lifetime of the process only
+func Open(dbPath string) (*Store, error) {
+	if dbPath == "" {
+		dbPath = DefaultDBPath
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tag database: %w", err)
+	}
+	// A single connection serializes writers and keeps ":memory:" databases shared
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS tags (
+			path TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			created INTEGER NOT NULL,
+			PRIMARY KEY (path, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_tags_tag ON tags(tag)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize tag database: %w", err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// ValidateTag checks that tag can be used as a tagfs directory name
+func ValidateTag(tag string) error {
+	if tag == "" || tag == "." || tag == ".." {
+		return filesystem.NewInvalidArgumentError("tag", tag, "must not be empty, '.' or '..'")
+	}
+	if len(tag) > maxTagLength {
+		return filesystem.NewInvalidArgumentError("tag", tag, fmt.Sprintf("must be at most %d bytes", maxTagLength))
+	}
+	for _, r := range tag {
+		if r == '/' || r == ',' || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return filesystem.NewInvalidArgumentError("tag", tag, "must not contain '/', ',' or whitespace")
+		}
+	}
+	return nil
+}
+
+// ParseTags splits a comma-separated tag list and validates each tag
+func ParseTags(s string) ([]string, error) {
+	var result []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if err := ValidateTag(tag); err != nil {
+			return nil, err
+		}
+		result = append(result, tag)
+	}
+	return result, nil
+}
+
+// Add attaches tags to path
+func (s *Store) Add(path string, tags ...string) error {
+	path = filesystem.NormalizePath(path)
+	for _, tag := range tags {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO tags (path, tag, created) VALUES (?, ?, ?)`, path, tag, now); err != nil {
+			return fmt.Errorf("failed to add tag: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Remove detaches tags from path, or every tag if none are given
+func (s *Store) Remove(path string, tags ...string) error {
+	path = filesystem.NormalizePath(path)
+	if len(tags) == 0 {
+		_, err := s.db.Exec(`DELETE FROM tags WHERE path = ?`, path)
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, tag := range tags {
+		if _, err := tx.Exec(`DELETE FROM tags WHERE path = ? AND tag = ?`, path, tag); err != nil {
+			return fmt.Errorf("failed to remove tag: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveTag detaches tag from every path
+func (s *Store) RemoveTag(tag string) error {
+	_, err := s.db.Exec(`DELETE FROM tags WHERE tag = ?`, tag)
+	return err
+}
+
+// Tags returns the tags attached to path, sorted
+func (s *Store) Tags(path string) ([]string, error) {
+	return s.queryStrings(`SELECT tag FROM tags WHERE path = ? ORDER BY tag`, filesystem.NormalizePath(path))
+}
+
+// Paths returns the paths carrying tag, sorted
+func (s *Store) Paths(tag string) ([]string, error) {
+	return s.queryStrings(`SELECT path FROM tags WHERE tag = ? ORDER BY path`, tag)
+}
+
+// List returns every tag in use with its number of paths
+func (s *Store) List() ([]TagCount, error) {
+	rows, err := s.db.Query(`SELECT tag, COUNT(*) FROM tags GROUP BY tag ORDER BY tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		result = append(result, tc)
+	}
+	return result, rows.Err()
+}
+
+func (s *Store) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// Move re-keys the tags of oldPath and everything below it to newPath
+func (s *Store) Move(oldPath, newPath string) error {
+	oldPath = filesystem.NormalizePath(oldPath)
+	newPath = filesystem.NormalizePath(newPath)
+	prefix := strings.TrimSuffix(oldPath, "/") + "/"
+	// SQLite substr counts characters, not bytes
+	_, err := s.db.Exec(
+		`UPDATE OR REPLACE tags SET path = ? || substr(path, ?)
+		 WHERE path = ? OR substr(path, 1, ?) = ?`,
+		newPath, utf8.RuneCountInString(oldPath)+1, oldPath, utf8.RuneCountInString(prefix), prefix)
+	return err
+}
+
+// Drop removes the tags of path and everything below it
+func (s *Store) Drop(path string) error {
+	path = filesystem.NormalizePath(path)
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	_, err := s.db.Exec(`DELETE FROM tags WHERE path = ? OR substr(path, 1, ?) = ?`, path, utf8.RuneCountInString(prefix), prefix)
+	return err
+}
+
+// Follow keeps tags attached to their files as they are renamed and removed through source
+// The returned function stops following
+func (s *Store) Follow(source ChangeSource) func() {
+	return source.Subscribe(func(c mountablefs.Change) {
+		var err error
+		switch c.Op {
+		case mountablefs.ChangeRename:
+			err = s.Move(c.Path, c.NewPath)
+		case mountablefs.ChangeRemove, mountablefs.ChangeRemoveAll:
+			err = s.Drop(c.Path)
+		}
+		if err != nil {
+			log.Warnf("[tags] failed to update tags after %s %s: %v", c.Op, c.Path, err)
+		}
+	})
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore *Store
+)
+
+// SetDefault sets the store used by components that cannot be handed one
+// directly, such as plugins created from a factory (e.g. tagfs)
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Default returns the store set with SetDefault, or nil if tagging is disabled
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
@@ -0,0 +1,45 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStoreMoveAndDrop(t *testing.T) {
+	s, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for p, tags := range map[string][]string{
+		"/s3fs/docs":           {"work"},
+		"/s3fs/docs/résumé.md": {"work", "cv"},
+		"/s3fs/docs2/a.txt":    {"work"},
+	} {
+		if err := s.Add(p, tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Move("/s3fs/docs", "/memfs/archive"); err != nil {
+		t.Fatal(err)
+	}
+	paths, _ := s.Paths("work")
+	want := []string{"/memfs/archive", "/memfs/archive/résumé.md", "/s3fs/docs2/a.txt"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("after move: got %v, want %v", paths, want)
+	}
+
+	if err := s.Drop("/memfs/archive"); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := s.List()
+	if !reflect.DeepEqual(list, []TagCount{{Tag: "work", Count: 1}}) {
+		t.Fatalf("after drop: got %v", list)
+	}
+
+	if err := s.Add("/x", "bad/tag"); err == nil {
+		t.Fatal("expected invalid tag error")
+	}
+}
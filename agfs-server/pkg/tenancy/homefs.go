@@ -0,0 +1,321 @@
+package tenancy
+
+import (
+	"context"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/throttle"
+)
+
+// homeFS confines a tenant to its home directory and enforces its quota and read-only flag
+// Paths are cleaned before they are joined to the home, so ".." cannot escape it
+type homeFS struct {
+	root       filesystem.FileSystem
+	home       string
+	quota      int64 // 0 means unlimited
+	readOnly   bool
+	*homeUsage // Shared with the views returned by WithContext
+}
+
+// homeUsage tracks the bytes stored in a home
+type homeUsage struct {
+	sizeRoot filesystem.FileSystem // Not bound to a request, so a canceled one cannot skew sizes
+
+	mu     sync.Mutex // protects the fields below
+	used   int64
+	loaded bool // used has been computed from the home directory
+}
+
+func newHomeFS(root filesystem.FileSystem, home string, quota int64, readOnly bool) *homeFS {
+	return &homeFS{root: root, home: home, quota: quota, readOnly: readOnly, homeUsage: &homeUsage{sizeRoot: root}}
+}
+
+// WithContext implements filesystem.ContextBinder by binding the global namespace
+func (fs *homeFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	bound := *fs
+	bound.root = filesystem.WithContext(fs.root, ctx)
+	return &bound
+}
+
+// resolve maps a tenant path to the global namespace
+func (fs *homeFS) resolve(p string) string {
+	return path.Join(fs.home, filesystem.NormalizePath(p))
+}
+
+// treeSize returns the total size of the files at or below p in the global namespace
+func (fs *homeFS) treeSize(p string) int64 {
+	var total int64
+	filesystem.Walk(fs.sizeRoot, p, func(_ string, info *filesystem.FileInfo, err error) error {
+		if err == nil && !info.IsDir {
+			total += info.Size
+		}
+		return nil
+	})
+	return total
+}
+
+// usage returns the bytes stored in the home, computing it on first use
+func (fs *homeFS) usage() int64 {
+	fs.mu.Lock()
+	loaded := fs.loaded
+	fs.mu.Unlock()
+	if !loaded {
+		size := fs.treeSize(fs.home)
+		fs.mu.Lock()
+		if !fs.loaded {
+			fs.used = size
+			fs.loaded = true
+		}
+		fs.mu.Unlock()
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.used
+}
+
+// reserve checks that growing the home by delta bytes stays within the quota
+func (fs *homeFS) reserve(p string, delta int64) error {
+	if fs.quota <= 0 || delta <= 0 {
+		return nil
+	}
+	if used := fs.usage(); used+delta > fs.quota {
+		return filesystem.NewQuotaExceededError(p, fs.quota, used)
+	}
+	return nil
+}
+
+// account records that the home grew by delta bytes (negative when it shrank)
+func (fs *homeFS) account(delta int64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.loaded {
+		fs.used += delta
+		if fs.used < 0 {
+			fs.used = 0
+		}
+	}
+}
+
+// fileSize returns the size of an existing file, 0 if it does not exist
+func (fs *homeFS) fileSize(full string) int64 {
+	info, err := fs.root.Stat(full)
+	if err != nil || info.IsDir {
+		return 0
+	}
+	return info.Size
+}
+
+func (fs *homeFS) checkWritable(op, p string) error {
+	if fs.readOnly {
+		return filesystem.NewPermissionDeniedError(op, p, "read-only home")
+	}
+	return nil
+}
+
+func (fs *homeFS) Create(p string) error {
+	if err := fs.checkWritable("create", p); err != nil {
+		return err
+	}
+	return fs.root.Create(fs.resolve(p))
+}
+
+func (fs *homeFS) Mkdir(p string, perm uint32) error {
+	if err := fs.checkWritable("mkdir", p); err != nil {
+		return err
+	}
+	return fs.root.Mkdir(fs.resolve(p), perm)
+}
+
+func (fs *homeFS) Remove(p string) error {
+	if err := fs.checkWritable("remove", p); err != nil {
+		return err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		return filesystem.NewPermissionDeniedError("remove", p, "cannot remove home directory")
+	}
+	full := fs.resolve(p)
+	size := fs.fileSize(full)
+	if err := fs.root.Remove(full); err != nil {
+		return err
+	}
+	fs.account(-size)
+	return nil
+}
+
+func (fs *homeFS) RemoveAll(p string) error {
+	if err := fs.checkWritable("removeall", p); err != nil {
+		return err
+	}
+	full := fs.resolve(p)
+	size := fs.treeSize(full)
+	if filesystem.NormalizePath(p) == "/" {
+		// Empty the home but keep it
+		entries, err := fs.root.ReadDir(full)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := fs.root.RemoveAll(path.Join(full, entry.Name)); err != nil {
+				fs.account(-(size - fs.treeSize(full)))
+				return err
+			}
+		}
+	} else if err := fs.root.RemoveAll(full); err != nil {
+		return err
+	}
+	fs.account(-size)
+	return nil
+}
+
+func (fs *homeFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	return fs.root.Read(fs.resolve(p), offset, size)
+}
+
+func (fs *homeFS) Write(p string, data []byte) ([]byte, error) {
+	if err := fs.checkWritable("write", p); err != nil {
+		return nil, err
+	}
+	full := fs.resolve(p)
+	oldSize := fs.fileSize(full)
+	delta := int64(len(data)) - oldSize
+	if err := fs.reserve(p, delta); err != nil {
+		return nil, err
+	}
+	resp, err := fs.root.Write(full, data)
+	if err != nil {
+		return nil, err
+	}
+	// Some plugins do not store what is written (e.g. control files), trust Stat
+	fs.account(fs.fileSize(full) - oldSize)
+	return resp, nil
+}
+
+func (fs *homeFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	return fs.root.ReadDir(fs.resolve(p))
+}
+
+// ReadDirFields implements filesystem.FieldLister interface
+func (fs *homeFS) ReadDirFields(p string, fields filesystem.ListFields) ([]filesystem.FileInfo, error) {
+	return filesystem.ReadDirFields(fs.root, fs.resolve(p), fields)
+}
+
+// ReadDirPage implements filesystem.PageLister interface
+func (fs *homeFS) ReadDirPage(p string, page filesystem.ListPage) ([]filesystem.FileInfo, bool, error) {
+	return filesystem.ReadDirPage(fs.root, fs.resolve(p), page)
+}
+
+func (fs *homeFS) Stat(p string) (*filesystem.FileInfo, error) {
+	info, err := fs.root.Stat(fs.resolve(p))
+	if err != nil {
+		return nil, err
+	}
+	if filesystem.NormalizePath(p) == "/" {
+		home := *info
+		home.Name = "/"
+		return &home, nil
+	}
+	return info, nil
+}
+
+func (fs *homeFS) Rename(oldPath, newPath string) error {
+	if err := fs.checkWritable("rename", oldPath); err != nil {
+		return err
+	}
+	if filesystem.NormalizePath(oldPath) == "/" {
+		return filesystem.NewPermissionDeniedError("rename", oldPath, "cannot rename home directory")
+	}
+	// Replacing an existing file frees its space
+	full := fs.resolve(newPath)
+	size := fs.fileSize(full)
+	if err := fs.root.Rename(fs.resolve(oldPath), full); err != nil {
+		return err
+	}
+	fs.account(-size)
+	return nil
+}
+
+func (fs *homeFS) Chmod(p string, mode uint32) error {
+	if err := fs.checkWritable("chmod", p); err != nil {
+		return err
+	}
+	return fs.root.Chmod(fs.resolve(p), mode)
+}
+
+func (fs *homeFS) Open(p string) (io.ReadCloser, error) {
+	return fs.root.Open(fs.resolve(p))
+}
+
+func (fs *homeFS) OpenWrite(p string) (io.WriteCloser, error) {
+	if err := fs.checkWritable("openwrite", p); err != nil {
+		return nil, err
+	}
+	full := fs.resolve(p)
+	oldSize := fs.fileSize(full)
+	w, err := fs.root.OpenWrite(full)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaWriter{WriteCloser: w, fs: fs, path: p, full: full, oldSize: oldSize}, nil
+}
+
+// Touch updates the modification time if the underlying filesystem supports it
+func (fs *homeFS) Touch(p string) error {
+	if err := fs.checkWritable("touch", p); err != nil {
+		return err
+	}
+	if toucher, ok := fs.root.(filesystem.Toucher); ok {
+		return toucher.Touch(fs.resolve(p))
+	}
+	return filesystem.NewNotSupportedError("touch", p)
+}
+
+// PathLimiters returns the bandwidth limits of the mount holding p, if the root filesystem has any
+func (fs *homeFS) PathLimiters(p string) (read, write *throttle.Limiter) {
+	if pl, ok := fs.root.(throttle.PathLimiter); ok {
+		return pl.PathLimiters(fs.resolve(p))
+	}
+	return nil, nil
+}
+
+// quotaWriter rejects streamed writes once the file would exceed the quota
+type quotaWriter struct {
+	io.WriteCloser
+	fs      *homeFS
+	path    string
+	full    string
+	oldSize int64
+	written int64
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	// The file is replaced, so only growth beyond its previous size counts
+	if err := w.fs.reserve(w.path, w.written+int64(len(p))-w.oldSize); err != nil {
+		return 0, err
+	}
+	n, err := w.WriteCloser.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *quotaWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.fs.account(w.fs.fileSize(w.full) - w.oldSize)
+	return err
+}
+
+// Abort implements filesystem.Aborter, accounting for what the file system kept
+func (w *quotaWriter) Abort() error {
+	err := filesystem.AbortWrite(w.WriteCloser)
+	w.fs.account(w.fs.fileSize(w.full) - w.oldSize)
+	return err
+}
+
+var _ filesystem.FileSystem = (*homeFS)(nil)
+var _ filesystem.Toucher = (*homeFS)(nil)
+var _ filesystem.FieldLister = (*homeFS)(nil)
+var _ filesystem.PageLister = (*homeFS)(nil)
+var _ throttle.PathLimiter = (*homeFS)(nil)
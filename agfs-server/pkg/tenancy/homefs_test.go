@@ -0,0 +1,41 @@
+package tenancy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestHomeFSQuotaAndConfinement(t *testing.T) {
+	root := memfs.NewMemoryFS()
+	if err := filesystem.MkdirAll(root, "/home/alice", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := root.Write("/secret", []byte("s")); err != nil {
+		t.Fatal(err)
+	}
+	fs := newHomeFS(root, "/home/alice", 10, false)
+
+	if _, err := fs.Write("/a", []byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/b", []byte("123")); !errors.Is(err, filesystem.ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	// Shrinking a file frees space
+	if _, err := fs.Write("/a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Write("/b", []byte("123")); err != nil {
+		t.Fatal(err)
+	}
+	if used := fs.usage(); used != 4 {
+		t.Fatalf("expected 4 bytes used, got %d", used)
+	}
+
+	if data, err := fs.Read("/../../secret", 0, -1); err == nil {
+		t.Fatalf("read outside the home directory: %q", data)
+	}
+}
@@ -0,0 +1,235 @@
+package tenancy
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultHomeRoot is the parent of the home directories when none is configured
+const DefaultHomeRoot = "/home"
+
+// userPlaceholder is replaced with the user name in home plugin config values
+const userPlaceholder = "{user}"
+
+// ErrUnauthorized is returned for requests with a missing or unknown token
+var ErrUnauthorized = fmt.Errorf("unauthorized")
+
+// Mounter mounts plugin instances, implemented by MountableFS
+type Mounter interface {
+	MountPlugin(fstype string, path string, config map[string]interface{}) error
+}
+
+// Tenant is an authenticated user confined to a home directory
+type Tenant struct {
+	Name     string
+	Home     string // Home directory in the global namespace
+	ReadOnly bool
+	fs       *homeFS
+
+	mu    sync.Mutex
+	ready bool // The home directory exists
+}
+
+// FileSystem returns the tenant's view of the namespace, rooted at the home directory
+func (t *Tenant) FileSystem() filesystem.FileSystem {
+	return t.fs
+}
+
+// Usage returns the bytes stored in the home directory and the quota (0 means unlimited)
+func (t *Tenant) Usage() (used, quota int64) {
+	return t.fs.usage(), t.fs.quota
+}
+
+// TenantStatus reports a tenant's home and usage
+type TenantStatus struct {
+	Name     string `json:"name"`
+	Home     string `json:"home"`
+	ReadOnly bool   `json:"readOnly"`
+	Used     int64  `json:"used"`
+	Quota    int64  `json:"quota"` // 0 means unlimited
+}
+
+// Manager authenticates requests and maps users to their home directories
+type Manager struct {
+	root       filesystem.FileSystem
+	mounter    Mounter
+	homeRoot   string
+	homePlugin *config.HomePluginConfig
+	adminToken string
+	byToken    map[string]*Tenant
+	tenants    []*Tenant // Sorted by name
+}
+
+// NewManager validates cfg and creates a manager whose homes live in root
+// mounter is only used when cfg has a home plugin
+func NewManager(root filesystem.FileSystem, mounter Mounter, cfg config.TenancyConfig) (*Manager, error) {
+	m := &Manager{
+		root:       root,
+		mounter:    mounter,
+		homeRoot:   DefaultHomeRoot,
+		homePlugin: cfg.HomePlugin,
+		adminToken: cfg.AdminToken,
+		byToken:    make(map[string]*Tenant),
+	}
+	if cfg.HomeRoot != "" {
+		m.homeRoot = filesystem.NormalizePath(cfg.HomeRoot)
+	}
+	if m.homePlugin != nil && m.homePlugin.Type == "" {
+		return nil, fmt.Errorf("tenancy: home_plugin requires a type")
+	}
+
+	defaultQuota := int64(0)
+	if cfg.DefaultQuota != "" {
+		q, err := pluginconfig.ParseSize(cfg.DefaultQuota)
+		if err != nil {
+			return nil, fmt.Errorf("tenancy: invalid default_quota: %w", err)
+		}
+		defaultQuota = q
+	}
+
+	names := make(map[string]bool)
+	for _, u := range cfg.Users {
+		if u.Name == "" || strings.ContainsAny(u.Name, "/\\") || u.Name == "." || u.Name == ".." {
+			return nil, fmt.Errorf("tenancy: invalid user name %q", u.Name)
+		}
+		if names[u.Name] {
+			return nil, fmt.Errorf("tenancy: duplicate user %q", u.Name)
+		}
+		names[u.Name] = true
+		if u.Token == "" {
+			return nil, fmt.Errorf("tenancy: user %q has no token", u.Name)
+		}
+		if _, exists := m.byToken[u.Token]; exists || u.Token == cfg.AdminToken {
+			return nil, fmt.Errorf("tenancy: user %q reuses another token", u.Name)
+		}
+
+		quota := defaultQuota
+		if u.Quota != "" {
+			q, err := pluginconfig.ParseSize(u.Quota)
+			if err != nil {
+				return nil, fmt.Errorf("tenancy: invalid quota for user %q: %w", u.Name, err)
+			}
+			quota = q
+		}
+
+		home := path.Join(m.homeRoot, u.Name)
+		t := &Tenant{Name: u.Name, Home: home, ReadOnly: u.ReadOnly}
+		t.fs = newHomeFS(root, home, quota, u.ReadOnly)
+		m.byToken[u.Token] = t
+		m.tenants = append(m.tenants, t)
+	}
+	sort.Slice(m.tenants, func(i, j int) bool { return m.tenants[i].Name < m.tenants[j].Name })
+
+	return m, nil
+}
+
+// EnsureHome creates the tenant's home directory, or mounts its plugin instance, if missing
+// Homes are set up on first use because plugins are mounted asynchronously at startup
+func (m *Manager) EnsureHome(t *Tenant) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ready {
+		return nil
+	}
+
+	if _, err := m.root.Stat(t.Home); err != nil {
+		if m.homePlugin != nil {
+			if err := m.mounter.MountPlugin(m.homePlugin.Type, t.Home, expandUser(m.homePlugin.Config, t.Name)); err != nil {
+				return fmt.Errorf("failed to mount home of %s: %w", t.Name, err)
+			}
+			log.Infof("[tenancy] mounted %s home for %s at %s", m.homePlugin.Type, t.Name, t.Home)
+		} else {
+			if err := filesystem.MkdirAll(m.root, t.Home, 0755); err != nil {
+				return fmt.Errorf("failed to create home of %s: %w", t.Name, err)
+			}
+			log.Infof("[tenancy] created home for %s at %s", t.Name, t.Home)
+		}
+	}
+	t.ready = true
+	return nil
+}
+
+// expandUser copies cfg replacing the user placeholder in string values
+func expandUser(cfg map[string]interface{}, user string) map[string]interface{} {
+	result := make(map[string]interface{}, len(cfg))
+	for k, v := range cfg {
+		if s, ok := v.(string); ok {
+			v = strings.ReplaceAll(s, userPlaceholder, user)
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// Authenticate identifies the caller from the bearer token of r
+// It returns the tenant, or nil for unrestricted (admin) access
+func (m *Manager) Authenticate(r *http.Request) (*Tenant, error) {
+	token := bearerToken(r)
+	if token == "" {
+		if m.adminToken == "" {
+			return nil, nil
+		}
+		return nil, ErrUnauthorized
+	}
+	if m.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.adminToken)) == 1 {
+		return nil, nil
+	}
+	if t, ok := m.byToken[token]; ok {
+		return t, nil
+	}
+	return nil, ErrUnauthorized
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// Tenants returns every configured tenant, sorted by name
+func (m *Manager) Tenants() []*Tenant {
+	return m.tenants
+}
+
+// Status returns the home and usage of every tenant
+func (m *Manager) Status() []TenantStatus {
+	result := make([]TenantStatus, 0, len(m.tenants))
+	for _, t := range m.tenants {
+		used, quota := t.Usage()
+		result = append(result, TenantStatus{
+			Name:     t.Name,
+			Home:     t.Home,
+			ReadOnly: t.ReadOnly,
+			Used:     used,
+			Quota:    quota,
+		})
+	}
+	return result
+}
+
+type contextKey struct{}
+
+// WithTenant returns a copy of ctx carrying t
+func WithTenant(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of a request, or nil for unrestricted access
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
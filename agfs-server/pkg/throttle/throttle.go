@@ -0,0 +1,255 @@
+// Package throttle limits the bandwidth of file transfers
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+// chunkSize bounds the bytes transferred per wait, so throttled transfers progress smoothly
+const chunkSize = 32 * 1024
+
+// Limiter is a token bucket limiting the combined rate of every transfer it is applied to
+// A nil Limiter does not limit anything
+type Limiter struct {
+	rate  float64 // Bytes per second
+	burst float64 // Bytes that may pass at once after an idle period
+
+	mu     sync.Mutex // protects the fields below
+	tokens float64    // Negative while transfers are waiting for their turn
+	last   time.Time
+}
+
+// NewLimiter creates a limiter allowing bps bytes per second, or nil if bps is not positive
+func NewLimiter(bps int64) *Limiter {
+	if bps <= 0 {
+		return nil
+	}
+	burst := float64(bps)
+	if burst < chunkSize {
+		burst = chunkSize
+	}
+	return &Limiter{rate: float64(bps), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Rate returns the limit in bytes per second, 0 for a nil limiter
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	return int64(l.rate)
+}
+
+// WaitN blocks until n bytes may pass or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Take the tokens now; a negative balance makes later callers queue behind this one
+	l.tokens -= float64(n)
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// compact removes nil limiters
+func compact(limiters []*Limiter) []*Limiter {
+	var result []*Limiter
+	for _, l := range limiters {
+		if l != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+func waitAll(ctx context.Context, limiters []*Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewReader returns a reader that passes data at the rate allowed by all limiters
+// r is returned as is when no limiter applies
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	limiters = compact(limiters)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiters: limiters}
+}
+
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := r.r.Read(p)
+	if waitErr := waitAll(r.ctx, r.limiters, n); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
+
+// NewWriter returns a writer that passes data at the rate allowed by all limiters
+// w is returned as is when no limiter applies
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	limiters = compact(limiters)
+	if len(limiters) == 0 {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, limiters: limiters}
+}
+
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + chunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := waitAll(w.ctx, w.limiters, end-written); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// PathLimiter is implemented by filesystems that limit transfers per path, e.g. per mount
+type PathLimiter interface {
+	// PathLimiters returns the read and write limiters of path, nil where unlimited
+	PathLimiters(path string) (read, write *Limiter)
+}
+
+// clientIdleTimeout is how long an idle client keeps its limiters
+const clientIdleTimeout = 10 * time.Minute
+
+// ClientLimits gives every client its own read and write limiters
+type ClientLimits struct {
+	readBPS  int64
+	writeBPS int64
+
+	mu        sync.Mutex // protects the fields below
+	clients   map[string]*clientLimiters
+	lastSweep time.Time
+}
+
+type clientLimiters struct {
+	read     *Limiter
+	write    *Limiter
+	lastUsed time.Time
+}
+
+// NewClientLimits creates per-client limits of readBPS and writeBPS bytes per second
+// It returns nil if neither limit is positive
+func NewClientLimits(readBPS, writeBPS int64) *ClientLimits {
+	if readBPS <= 0 && writeBPS <= 0 {
+		return nil
+	}
+	return &ClientLimits{
+		readBPS:   readBPS,
+		writeBPS:  writeBPS,
+		clients:   make(map[string]*clientLimiters),
+		lastSweep: time.Now(),
+	}
+}
+
+// ClientLimitsFromConfig creates the per-client limits of cfg, nil if none is configured
+func ClientLimitsFromConfig(cfg config.TrafficConfig) (*ClientLimits, error) {
+	parse := func(key, value string) (int64, error) {
+		if value == "" {
+			return 0, nil
+		}
+		bps, err := pluginconfig.ParseSize(value)
+		if err != nil {
+			return 0, fmt.Errorf("traffic: invalid %s: %w", key, err)
+		}
+		if bps < 0 {
+			return 0, fmt.Errorf("traffic: %s must not be negative", key)
+		}
+		return bps, nil
+	}
+	readBPS, err := parse("client_read_bps", cfg.ClientReadBPS)
+	if err != nil {
+		return nil, err
+	}
+	writeBPS, err := parse("client_write_bps", cfg.ClientWriteBPS)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientLimits(readBPS, writeBPS), nil
+}
+
+// Limiters returns the read and write limiters of client, nil where unlimited
+func (c *ClientLimits) Limiters(client string) (read, write *Limiter) {
+	if c == nil {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastSweep) > clientIdleTimeout {
+		for key, cl := range c.clients {
+			if now.Sub(cl.lastUsed) > clientIdleTimeout {
+				delete(c.clients, key)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	cl, ok := c.clients[client]
+	if !ok {
+		cl = &clientLimiters{read: NewLimiter(c.readBPS), write: NewLimiter(c.writeBPS)}
+		c.clients[client] = cl
+	}
+	cl.lastUsed = now
+	return cl.read, cl.write
+}
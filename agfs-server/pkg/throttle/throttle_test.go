@@ -0,0 +1,52 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriterRate(t *testing.T) {
+	// The first second's worth passes as a burst, the next 128KB take about one second
+	l := NewLimiter(128 * 1024)
+	var buf bytes.Buffer
+	w := NewWriter(context.Background(), &buf, l, nil)
+
+	start := time.Now()
+	if _, err := w.Write(make([]byte, 256*1024)); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("256KB at 128KB/s took %v", elapsed)
+	}
+	if buf.Len() != 256*1024 {
+		t.Fatalf("wrote %d bytes", buf.Len())
+	}
+}
+
+func TestReaderCancel(t *testing.T) {
+	l := NewLimiter(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(ctx, bytes.NewReader(make([]byte, 1024*1024)), l)
+	cancel()
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	var buf bytes.Buffer
+	if w := NewWriter(context.Background(), &buf, nil); w != &buf {
+		t.Fatal("expected the writer itself without limiters")
+	}
+	if NewClientLimits(0, 0) != nil {
+		t.Fatal("expected nil client limits")
+	}
+	var c *ClientLimits
+	if r, w := c.Limiters("x"); r != nil || w != nil {
+		t.Fatal("expected no limiters")
+	}
+}
@@ -0,0 +1,527 @@
+// Package usage meters the requests of each principal and the bytes they read and wrote,
+// keeps daily rollups on a directory of the server for chargeback, and enforces monthly
+// quotas
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	pluginconfig "github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFlushInterval is how often rollups are written by default
+const DefaultFlushInterval = time.Minute
+
+// DefaultExclude are the URL path prefixes not metered by default
+var DefaultExclude = []string{"/api/v1/health", "/livez", "/readyz"}
+
+// Path is the route of the usage API, served by the middleware
+const Path = "/api/v1/usage"
+
+// maxReportDays bounds the days of a report
+const maxReportDays = 366
+
+// dateFormat is the format of the days of rollups, which are UTC
+const dateFormat = "2006-01-02"
+
+// Counters are the requests of a principal and the bytes they moved; reads are GET and HEAD
+// requests, writes the others
+type Counters struct {
+	Reads        int64 `json:"reads"`
+	Writes       int64 `json:"writes"`
+	BytesRead    int64 `json:"bytesRead"`    // Bytes of the responses
+	BytesWritten int64 `json:"bytesWritten"` // Bytes of the request bodies
+}
+
+func (c *Counters) add(o Counters) {
+	c.Reads += o.Reads
+	c.Writes += o.Writes
+	c.BytesRead += o.BytesRead
+	c.BytesWritten += o.BytesWritten
+}
+
+// Quota bounds the usage of a principal per calendar month, 0 for unlimited
+type Quota struct {
+	Bytes    int64 `json:"bytes,omitempty"`    // Bytes read and written
+	Requests int64 `json:"requests,omitempty"` // Reads and writes
+}
+
+// exceeded reports whether month reached the quota
+func (q Quota) exceeded(month Counters) bool {
+	return (q.Bytes > 0 && month.BytesRead+month.BytesWritten >= q.Bytes) ||
+		(q.Requests > 0 && month.Reads+month.Writes >= q.Requests)
+}
+
+// DayUsage is the usage of a principal on a day
+type DayUsage struct {
+	Date string `json:"date"`
+	Counters
+}
+
+// PrincipalUsage is the usage of a principal over the days of a report
+type PrincipalUsage struct {
+	Principal string     `json:"principal"`
+	Total     Counters   `json:"total"` // Days of the report
+	Month     Counters   `json:"month"` // Current month, which the quota bounds
+	Quota     *Quota     `json:"quota,omitempty"`
+	Days      []DayUsage `json:"days"`
+}
+
+// Report is the usage of principals from one day to another, both included
+type Report struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Principals []PrincipalUsage `json:"principals"`
+}
+
+// Identity returns the principal making a request, or "" for anonymous requests, which are
+// not metered, and whether it may see the usage of others; an error rejects the request
+type Identity func(r *http.Request) (principal string, admin bool, err error)
+
+// Meter counts the usage of principals per day
+type Meter struct {
+	fs            filesystem.FileSystem
+	dir           string
+	flushInterval time.Duration
+	exclude       []string
+	defaultQuota  Quota
+	quotas        map[string]Quota
+	identify      Identity
+	now           func() time.Time
+
+	mu    sync.Mutex
+	days  map[string]map[string]*Counters // By day, then principal; the current month and unwritten days
+	dirty map[string]bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a meter from cfg; fs keeps the rollups when cfg.Dir is set, and the rollups
+// of the current month are loaded from it so quotas last across restarts
+func New(cfg config.UsageConfig, fs filesystem.FileSystem, identify Identity) (*Meter, error) {
+	flushInterval := DefaultFlushInterval
+	if cfg.FlushInterval != "" {
+		var err error
+		if flushInterval, err = pluginconfig.ParseDuration(cfg.FlushInterval); err != nil {
+			return nil, fmt.Errorf("usage: invalid flush_interval: %w", err)
+		}
+		if flushInterval <= 0 {
+			return nil, fmt.Errorf("usage: flush_interval must be positive")
+		}
+	}
+	exclude := cfg.Exclude
+	if exclude == nil {
+		exclude = DefaultExclude
+	}
+	defaultQuota, err := parseQuota(cfg.DefaultQuota)
+	if err != nil {
+		return nil, fmt.Errorf("usage: invalid default_quota: %w", err)
+	}
+	quotas := make(map[string]Quota, len(cfg.Quotas))
+	for _, q := range cfg.Quotas {
+		if q.Principal == "" {
+			return nil, fmt.Errorf("usage: quotas need a principal")
+		}
+		if quotas[q.Principal], err = parseQuota(q); err != nil {
+			return nil, fmt.Errorf("usage: invalid quota of %s: %w", q.Principal, err)
+		}
+	}
+
+	m := &Meter{
+		fs:            fs,
+		flushInterval: flushInterval,
+		exclude:       exclude,
+		defaultQuota:  defaultQuota,
+		quotas:        quotas,
+		identify:      identify,
+		now:           time.Now,
+		days:          make(map[string]map[string]*Counters),
+		dirty:         make(map[string]bool),
+		done:          make(chan struct{}),
+	}
+	if cfg.Dir != "" {
+		m.dir = filesystem.NormalizePath(cfg.Dir)
+		if _, err := fs.Stat(m.dir); err != nil {
+			if err := fs.Mkdir(m.dir, 0755); err != nil {
+				return nil, fmt.Errorf("usage: failed to create %s: %w", m.dir, err)
+			}
+		}
+		if err := m.loadMonth(); err != nil {
+			return nil, fmt.Errorf("usage: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func parseQuota(cfg config.UsageQuotaConfig) (Quota, error) {
+	q := Quota{Requests: cfg.Requests}
+	if cfg.Bytes != "" {
+		var err error
+		if q.Bytes, err = pluginconfig.ParseSize(cfg.Bytes); err != nil {
+			return q, err
+		}
+	}
+	if q.Bytes < 0 || q.Requests < 0 {
+		return q, fmt.Errorf("quotas cannot be negative")
+	}
+	return q, nil
+}
+
+// loadMonth loads the rollups of the current month
+func (m *Meter) loadMonth() error {
+	entries, err := m.fs.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", m.dir, err)
+	}
+	month := m.now().UTC().Format("2006-01")
+	for _, e := range entries {
+		day := strings.TrimSuffix(e.Name, ".json")
+		if e.IsDir || !strings.HasPrefix(day, month) {
+			continue
+		}
+		counters, err := m.readDay(day)
+		if err != nil {
+			return err
+		}
+		m.days[day] = counters
+	}
+	return nil
+}
+
+// readDay reads the rollup of a day, empty if it was not written
+func (m *Meter) readDay(day string) (map[string]*Counters, error) {
+	counters := make(map[string]*Counters)
+	if m.dir == "" {
+		return counters, nil
+	}
+	data, err := m.fs.Read(path.Join(m.dir, day+".json"), 0, -1)
+	if err != nil && err != io.EOF {
+		if filesystem.IsNotFound(err) {
+			return counters, nil
+		}
+		return nil, fmt.Errorf("failed to read the usage of %s: %w", day, err)
+	}
+	if err := json.Unmarshal(data, &counters); err != nil {
+		return nil, fmt.Errorf("invalid usage of %s: %w", day, err)
+	}
+	return counters, nil
+}
+
+// Start starts writing the rollups in the background, if they are kept on a directory
+func (m *Meter) Start() {
+	if m.dir == "" {
+		return
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Flush()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops writing in the background and writes the pending rollups
+func (m *Meter) Stop() {
+	close(m.done)
+	m.wg.Wait()
+	if m.dir != "" {
+		m.Flush()
+	}
+}
+
+// Flush writes the rollups changed since the last flush, and forgets those of past months
+func (m *Meter) Flush() {
+	m.mu.Lock()
+	pending := make(map[string][]byte, len(m.dirty))
+	for day := range m.dirty {
+		data, err := json.MarshalIndent(m.days[day], "", "  ")
+		if err != nil {
+			continue
+		}
+		pending[day] = data
+	}
+	m.dirty = make(map[string]bool)
+	m.mu.Unlock()
+
+	failed := make(map[string]bool)
+	for day, data := range pending {
+		if _, err := m.fs.Write(path.Join(m.dir, day+".json"), data); err != nil {
+			log.Errorf("[usage] failed to write the usage of %s: %v", day, err)
+			failed[day] = true
+		}
+	}
+
+	month := m.now().UTC().Format("2006-01")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for day := range failed {
+		m.dirty[day] = true
+	}
+	for day := range m.days {
+		if !strings.HasPrefix(day, month) && !m.dirty[day] {
+			delete(m.days, day)
+		}
+	}
+}
+
+// Record adds a request of principal to the usage of its day
+func (m *Meter) Record(principal string, at time.Time, c Counters) {
+	day := at.UTC().Format(dateFormat)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counters, ok := m.days[day]
+	if !ok {
+		counters = make(map[string]*Counters)
+		m.days[day] = counters
+	}
+	total, ok := counters[principal]
+	if !ok {
+		total = &Counters{}
+		counters[principal] = total
+	}
+	total.add(c)
+	m.dirty[day] = true
+}
+
+// Quota returns the monthly quota of principal
+func (m *Meter) Quota(principal string) Quota {
+	if q, ok := m.quotas[principal]; ok {
+		return q
+	}
+	return m.defaultQuota
+}
+
+// month returns the usage of principal in the current month
+func (m *Meter) month(principal string) Counters {
+	prefix := m.now().UTC().Format("2006-01")
+	var month Counters
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for day, counters := range m.days {
+		if c, ok := counters[principal]; ok && strings.HasPrefix(day, prefix) {
+			month.add(*c)
+		}
+	}
+	return month
+}
+
+// Report returns the usage from one day to another, both included, of principal or with ""
+// of every principal; the days not kept in memory are read from the directory
+func (m *Meter) Report(principal string, from, to time.Time) (Report, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) {
+		return Report{}, fmt.Errorf("to is before from")
+	}
+	if to.Sub(from) >= maxReportDays*24*time.Hour {
+		return Report{}, fmt.Errorf("reports cover %d days at most", maxReportDays)
+	}
+
+	usages := make(map[string]*PrincipalUsage)
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		day := d.Format(dateFormat)
+		m.mu.Lock()
+		counters, ok := m.days[day]
+		copied := make(map[string]Counters, len(counters))
+		for p, c := range counters {
+			copied[p] = *c
+		}
+		m.mu.Unlock()
+		if !ok {
+			read, err := m.readDay(day)
+			if err != nil {
+				return Report{}, err
+			}
+			for p, c := range read {
+				copied[p] = *c
+			}
+		}
+		for p, c := range copied {
+			if principal != "" && p != principal {
+				continue
+			}
+			u, ok := usages[p]
+			if !ok {
+				u = &PrincipalUsage{Principal: p, Days: []DayUsage{}}
+				usages[p] = u
+			}
+			u.Total.add(c)
+			u.Days = append(u.Days, DayUsage{Date: day, Counters: c})
+		}
+	}
+	if principal != "" && usages[principal] == nil {
+		usages[principal] = &PrincipalUsage{Principal: principal, Days: []DayUsage{}}
+	}
+
+	report := Report{From: from.Format(dateFormat), To: to.Format(dateFormat), Principals: []PrincipalUsage{}}
+	for p, u := range usages {
+		u.Month = m.month(p)
+		if q := m.Quota(p); q != (Quota{}) {
+			u.Quota = &q
+		}
+		report.Principals = append(report.Principals, *u)
+	}
+	sort.Slice(report.Principals, func(i, j int) bool {
+		return report.Principals[i].Principal < report.Principals[j].Principal
+	})
+	return report, nil
+}
+
+func (m *Meter) excluded(p string) bool {
+	for _, prefix := range m.exclude {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware meters the requests served by next, rejects those of principals beyond their
+// quota with 429, and serves the usage API
+// Requests are counted when they end, so a long stream counts at once when it closes.
+func (m *Meter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == Path {
+			m.serveReport(w, r)
+			return
+		}
+		if m.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, _, err := m.identify(r)
+		if err != nil || principal == "" {
+			next.ServeHTTP(w, r) // Rejected or served anonymously further on
+			return
+		}
+		if m.Quota(principal).exceeded(m.month(principal)) {
+			writeError(w, http.StatusTooManyRequests, "monthly usage quota exceeded")
+			return
+		}
+
+		start := m.now()
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		c := Counters{BytesRead: rw.n, BytesWritten: body.n}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			c.Reads = 1
+		} else {
+			c.Writes = 1
+		}
+		m.Record(principal, start, c)
+	})
+}
+
+// serveReport handles GET /api/v1/usage?principal=<name>&from=<YYYY-MM-DD>&to=<YYYY-MM-DD>
+// The days default to the current month; principals other than admins only see their own usage.
+func (m *Meter) serveReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	principal, admin, err := m.identify(r)
+	if err != nil || (principal == "" && !admin) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agfs"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	q := r.URL.Query()
+	wanted := q.Get("principal")
+	if !admin {
+		if wanted != "" && wanted != principal {
+			writeError(w, http.StatusForbidden, "only admins can see the usage of others")
+			return
+		}
+		wanted = principal
+	}
+
+	now := m.now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := now
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			day, err := time.Parse(dateFormat, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+name+": want YYYY-MM-DD")
+				return
+			}
+			*t = day
+		}
+	}
+	report, err := m.Report(wanted, from, to)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// countingBody counts the bytes of the request body the handler reads
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of the response
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,135 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// identity takes the principal from the X-User header, "root" being the admin
+func identity(r *http.Request) (string, bool, error) {
+	user := r.Header.Get("X-User")
+	if user == "bad" {
+		return "", false, fmt.Errorf("invalid token")
+	}
+	return user, user == "root", nil
+}
+
+func TestMeter(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	cfg := config.UsageConfig{
+		Dir:    "/usage",
+		Quotas: []config.UsageQuotaConfig{{Principal: "bob", Requests: 2}},
+	}
+	m, err := New(cfg, fs, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	m.Record("alice", now.AddDate(0, -1, 0), Counters{Reads: 7}) // Last month
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The metered writer unwraps to the connection's
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			t.Errorf("write deadline through the meter: %v", err)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+		w.Write([]byte("ok"))
+	})
+	server := httptest.NewServer(m.Middleware(echo))
+	defer server.Close()
+	do := func(method, url, user, body string) (int, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+url, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	do(http.MethodPut, "/api/v1/files?path=/a", "alice", "hello")
+	do(http.MethodGet, "/api/v1/files?path=/a", "alice", "")
+	do(http.MethodGet, "/api/v1/files?path=/a", "", "") // Anonymous
+	do(http.MethodGet, "/api/v1/health", "alice", "")   // Excluded
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if status, _ := do(http.MethodGet, "/api/v1/stat?path=/", "bob", ""); status != want {
+			t.Errorf("bob's request %d: status %d, want %d", i, status, want)
+		}
+	}
+
+	report := func(user, query string) (int, Report) {
+		t.Helper()
+		status, data := do(http.MethodGet, Path+query, user, "")
+		var r Report
+		if status == http.StatusOK {
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return status, r
+	}
+	status, r := report("root", "")
+	if status != http.StatusOK || r.From != "2024-03-01" || r.To != "2024-03-15" || len(r.Principals) != 2 {
+		t.Fatalf("report = %d %+v", status, r)
+	}
+	alice := r.Principals[0]
+	if want := (Counters{Reads: 1, Writes: 1, BytesRead: 9, BytesWritten: 5}); alice.Principal != "alice" || alice.Total != want || alice.Month != want {
+		t.Errorf("alice's usage = %+v, want %+v", alice, want)
+	}
+	if bob := r.Principals[1]; bob.Total.Reads != 2 || bob.Quota == nil || bob.Quota.Requests != 2 {
+		t.Errorf("bob's usage = %+v", bob)
+	}
+
+	// Principals only see their own usage
+	if status, r := report("alice", "?from=2024-02-01"); status != http.StatusOK || len(r.Principals) != 1 || r.Principals[0].Total.Reads != 8 {
+		t.Errorf("alice's own report = %d %+v", status, r)
+	}
+	for _, tc := range []struct {
+		user, query string
+		status      int
+	}{
+		{"alice", "?principal=bob", http.StatusForbidden},
+		{"bad", "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+		{"root", "?from=2024-03-16&to=2024-03-01", http.StatusBadRequest},
+		{"root", "?from=March", http.StatusBadRequest},
+	} {
+		if status, _ := report(tc.user, tc.query); status != tc.status {
+			t.Errorf("report of %q%s: status %d, want %d", tc.user, tc.query, status, tc.status)
+		}
+	}
+
+	// Rollups are written per day, and the current month is loaded back with its quotas
+	m.Stop()
+	if _, err := fs.Stat("/usage/2024-03-15.json"); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := New(cfg, fs, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.now = m.now
+	if err := restarted.loadMonth(); err != nil { // That of the test's clock
+		t.Fatal(err)
+	}
+	if month := restarted.month("bob"); month.Reads != 2 {
+		t.Errorf("bob's month after a restart = %+v", month)
+	}
+	if r, err := restarted.Report("alice", now.AddDate(0, -1, 0), now); err != nil || r.Principals[0].Total.Reads != 8 {
+		t.Errorf("alice's report after a restart = %+v, %v", r, err)
+	}
+}
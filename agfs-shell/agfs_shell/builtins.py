@@ -44,10 +44,40 @@ def cmd_cat(process: Process) -> int:
     """
     Concatenate and print files or stdin (streaming mode)
 
-    Usage: cat [file...]
+    Usage: cat [--progress] [--limit-rate=RATE] [--offset=N] [--chunk-size=SIZE]
+               [--verify] [file...]
+
+    Options:
+        --progress          Show a progress bar on stderr
+        --limit-rate=RATE   Read at most RATE bytes per second, e.g. 512K or 10M
+        --offset=N          Start at byte N, e.g. to resume an interrupted download
+        --chunk-size=SIZE   Bytes per request (default: 4M)
+        --verify            Compare the MD5 of the bytes read with the server's
+
+    With any of these options files are read in chunks with Range requests.
+    Control files declaring JSON content in their metadata, e.g. queue messages, are
+    pretty-printed when the output is a terminal.
     """
     import sys
 
+    try:
+        options, files = _parse_transfer_options(process.args, _CAT_OPTIONS, attached_only=True)
+    except ValueError as e:
+        process.stderr.write(f"cat: {e}\n")
+        return 2
+    if options:
+        if not files:
+            process.stderr.write("cat: transfer options need a file\n")
+            return 2
+        if options.get('verify') and options.get('offset'):
+            process.stderr.write("cat: --verify needs the whole file, not --offset\n")
+            return 2
+        for filename in files:
+            status = _cat_chunked(process, filename, options)
+            if status != 0:
+                return status
+        return 0
+
     if not process.args:
         # Read from stdin in chunks
         # Check if process.stdin has real data or if we should read from real stdin
@@ -73,7 +103,10 @@ def cmd_cat(process: Process) -> int:
         # Read from files in streaming mode
         for filename in process.args:
             try:
-                if process.filesystem:
+                if process.filesystem and _is_terminal(process.stdout) and _declared_format(process, filename) == 'json':
+                    process.stdout.write(_pretty_json(process.filesystem.read_file(filename)))
+                    process.stdout.flush()
+                elif process.filesystem:
                     # Stream file in chunks
                     stream = process.filesystem.read_file(filename, stream=True)
                     try:
@@ -104,6 +137,301 @@ def cmd_cat(process: Process) -> int:
     return 0
 
 
+def _is_terminal(stream) -> bool:
+    """Check whether an output stream writes to a terminal"""
+    fd = stream.fileno()
+    return fd is not None and os.isatty(fd)
+
+
+def _declared_format(process: Process, path: str):
+    """Return the format a file declares in its metadata ('json' or 'text'), None if none"""
+    try:
+        info = process.filesystem.get_file_info(path)
+    except Exception:
+        return None
+    return (_meta_field(info or {}, 'content') or {}).get('format')
+
+
+def _pretty_json(data: bytes) -> bytes:
+    """Indent a JSON document for reading, returning other data unchanged"""
+    import json
+    try:
+        doc = json.loads(data)
+    except ValueError:
+        return data
+    return (json.dumps(doc, indent=2, ensure_ascii=False) + '\n').encode('utf-8')
+
+
+_CAT_OPTIONS = {'progress': False, 'verify': False, 'limit-rate': True, 'offset': True, 'chunk-size': True}
+_WRITE_OPTIONS = {'progress': False, 'resume': False, 'no-verify': False, 'limit-rate': True, 'chunk-size': True}
+_DEFAULT_CHUNK_SIZE = 4 * 1024 * 1024
+
+
+def _parse_byte_size(text: str) -> int:
+    """Parse a byte count such as 512, 64K, 1.5M or 2GB"""
+    match = re.fullmatch(r'\s*(\d+(?:\.\d+)?)\s*([kmgt]?)i?b?\s*', text, re.IGNORECASE)
+    if not match:
+        raise ValueError(f"invalid size: {text}")
+    factor = 1024 ** ' kmgt'.index(match.group(2).lower() or ' ')
+    return int(float(match.group(1)) * factor)
+
+
+def _parse_transfer_options(args: List[str], known: dict, attached_only: bool = False):
+    """
+    Split the --name and --name=value options of a transfer command from its arguments
+
+    known maps option names to whether they take a value. Sizes and rates are
+    converted to byte counts. With attached_only, values must be given as
+    --name=value, for commands whose other arguments are resolved as paths.
+    Returns the options and the remaining arguments; raises ValueError.
+    """
+    options = {}
+    rest = []
+    args = list(args)
+    while args:
+        arg = args.pop(0)
+        if arg == '--':
+            rest.extend(args)
+            break
+        if not arg.startswith('--') or len(arg) == 2:
+            rest.append(arg)
+            continue
+        name, sep, value = arg[2:].partition('=')
+        if name not in known:
+            raise ValueError(f"unrecognized option '--{name}'")
+        if not known[name]:
+            if sep:
+                raise ValueError(f"option '--{name}' doesn't allow an argument")
+            options[name] = True
+            continue
+        if not sep:
+            if attached_only or not args:
+                raise ValueError(f"option '--{name}' requires an argument, as --{name}=VALUE")
+            value = args.pop(0)
+        options[name] = _parse_byte_size(value)
+    for name in ('limit-rate', 'chunk-size'):
+        if name in options and options[name] <= 0:
+            raise ValueError(f"--{name} must be positive")
+    return options, rest
+
+
+class _RateLimiter:
+    """Sleeps so that the bytes passed through stay under a rate, in bytes per second"""
+
+    def __init__(self, rate=None):
+        import time
+        self.rate = rate
+        self.start = time.monotonic()
+        self.sent = 0
+
+    def wait(self, size: int):
+        import time
+        if not self.rate:
+            return
+        self.sent += size
+        delay = self.sent / self.rate - (time.monotonic() - self.start)
+        if delay > 0:
+            time.sleep(delay)
+
+
+class _Progress:
+    """A one-line progress bar on stderr, redrawn at most ten times a second"""
+
+    WIDTH = 30
+
+    def __init__(self, process: Process, label: str, total: int, done: int = 0, enabled: bool = True):
+        import time
+        self.process = process
+        self.label = label
+        self.total = total
+        self.done = done
+        self.enabled = enabled
+        self.start = time.monotonic()
+        self.first = done
+        self.drawn = 0.0
+
+    def update(self, size: int, final: bool = False):
+        import time
+        self.done += size
+        now = time.monotonic()
+        if not self.enabled or (not final and now - self.drawn < 0.1):
+            return
+        self.drawn = now
+        elapsed = max(now - self.start, 1e-6)
+        rate = _human_readable_size(int((self.done - self.first) / elapsed))
+        if self.total:
+            filled = min(self.WIDTH, self.WIDTH * self.done // self.total)
+            percent = min(100, 100 * self.done // self.total)
+        else:
+            filled, percent = self.WIDTH, 100
+        bar = '#' * filled + '-' * (self.WIDTH - filled)
+        line = (f"\r{self.label} [{bar}] {percent:3d}% "
+                f"{_human_readable_size(self.done)}/{_human_readable_size(self.total)} {rate}/s")
+        self.process.stderr.write(line + ('\n' if final else ''))
+
+    def finish(self):
+        self.update(0, final=True)
+
+
+def _cat_chunked(process: Process, filename: str, options: dict) -> int:
+    """Helper: Print a file of AGFS in chunks, for cat's transfer options"""
+    import hashlib
+
+    if not process.filesystem:
+        process.stderr.write("cat: transfer options need the AGFS filesystem\n")
+        return 1
+    client = process.filesystem.client
+    chunk_size = options.get('chunk-size', _DEFAULT_CHUNK_SIZE)
+    limiter = _RateLimiter(options.get('limit-rate'))
+    md5 = hashlib.md5() if options.get('verify') else None
+    position = options.get('offset', 0)
+    progress = None
+    try:
+        while True:
+            data, total = client.read_range(filename, position, chunk_size)
+            if progress is None:
+                progress = _Progress(process, os.path.basename(filename), total, position,
+                                     enabled=options.get('progress', False))
+            if data:
+                process.stdout.write(data)
+                process.stdout.flush()
+                if md5:
+                    md5.update(data)
+                position += len(data)
+                progress.update(len(data))
+                limiter.wait(len(data))
+            if not data or position >= total:
+                break
+        progress.finish()
+        if md5:
+            remote = client.digest(filename, "md5").get('digest', '')
+            if remote != md5.hexdigest():
+                process.stderr.write(f"cat: {filename}: checksum mismatch (read {md5.hexdigest()}, server {remote})\n")
+                return 1
+        return 0
+    except KeyboardInterrupt:
+        process.stderr.write(f"\ncat: interrupted at byte {position}, resume with --offset={position}\n")
+        return 130
+    except Exception as e:
+        error_msg = str(e)
+        if "No such file or directory" in error_msg or "not found" in error_msg.lower():
+            process.stderr.write(f"cat: {filename}: No such file or directory\n")
+        else:
+            process.stderr.write(f"cat: {filename}: {error_msg}\n")
+        return 1
+
+
+@command()
+def cmd_write(process: Process) -> int:
+    """
+    Write stdin or a local file to an AGFS file in chunks
+
+    Usage: write [--resume] [--progress] [--limit-rate RATE] [--chunk-size SIZE]
+                 [--no-verify] PATH [LOCAL_FILE]
+
+    Options:
+        --resume            Continue an interrupted write from the size of PATH
+                            instead of replacing it
+        --progress          Show a progress bar on stderr
+        --limit-rate RATE   Send at most RATE bytes per second, e.g. 512K or 10M
+        --chunk-size SIZE   Bytes per request (default: 4M)
+        --no-verify         Skip comparing the MD5 of PATH with the data written
+
+    Examples:
+        write --progress /s3fs/backup.tar ./backup.tar
+        write --resume --progress /s3fs/backup.tar ./backup.tar
+        cat /memfs/report.csv | write --limit-rate 1M /s3fs/report.csv
+    """
+    import hashlib
+    import io
+
+    try:
+        options, args = _parse_transfer_options(process.args, _WRITE_OPTIONS)
+    except ValueError as e:
+        process.stderr.write(f"write: {e}\n")
+        return 2
+    if len(args) not in (1, 2):
+        process.stderr.write("Usage: write [--resume] [--progress] [--limit-rate RATE] [--chunk-size SIZE] "
+                             "[--no-verify] PATH [LOCAL_FILE]\n")
+        return 2
+    if not process.filesystem:
+        process.stderr.write("write: filesystem not available\n")
+        return 1
+    client = process.filesystem.client
+
+    path = args[0]
+    if not path.startswith('/'):
+        path = os.path.normpath(os.path.join(getattr(process, 'cwd', '/'), path))
+    try:
+        if len(args) == 2:
+            source = open(args[1], 'rb')
+        else:
+            source = io.BytesIO(process.stdin.read())
+    except OSError as e:
+        process.stderr.write(f"write: {args[1]}: {e.strerror}\n")
+        return 1
+
+    chunk_size = options.get('chunk-size', _DEFAULT_CHUNK_SIZE)
+    limiter = _RateLimiter(options.get('limit-rate'))
+    md5 = None if options.get('no-verify') else hashlib.md5()
+    position = 0
+    with source:
+        source.seek(0, os.SEEK_END)
+        total = source.tell()
+        source.seek(0)
+        try:
+            if options.get('resume'):
+                try:
+                    info = client.stat(path)
+                    if info.get('isDir'):
+                        process.stderr.write(f"write: {path}: Is a directory\n")
+                        return 1
+                    position = info.get('size', 0)
+                except Exception:
+                    position = 0
+                if position > total:
+                    process.stderr.write(f"write: {path}: cannot resume, it is larger than the data ({position} > {total} bytes)\n")
+                    return 1
+                # The data already written is part of the checksum too
+                while md5 and source.tell() < position:
+                    md5.update(source.read(min(chunk_size, position - source.tell())))
+                source.seek(position)
+
+            progress = _Progress(process, os.path.basename(path), total, position,
+                                 enabled=options.get('progress', False))
+            if position == 0:
+                # The first chunk replaces the file, the others are written at their offsets
+                chunk = source.read(chunk_size)
+                client.write(path, chunk)
+                position = len(chunk)
+                if md5:
+                    md5.update(chunk)
+                progress.update(len(chunk))
+                limiter.wait(len(chunk))
+            while position < total:
+                chunk = source.read(chunk_size)
+                client.write_at(path, chunk, position)
+                position += len(chunk)
+                if md5:
+                    md5.update(chunk)
+                progress.update(len(chunk))
+                limiter.wait(len(chunk))
+            progress.finish()
+
+            if md5:
+                remote = client.digest(path, "md5").get('digest', '')
+                if remote != md5.hexdigest():
+                    process.stderr.write(f"write: {path}: checksum mismatch (local {md5.hexdigest()}, server {remote})\n")
+                    return 1
+            return 0
+        except KeyboardInterrupt:
+            process.stderr.write(f"\nwrite: interrupted at byte {position}, continue with --resume\n")
+            return 130
+        except Exception as e:
+            process.stderr.write(f"write: {path}: {e}\n")
+            return 1
+
+
 @command(supports_streaming=True)
 def cmd_grep(process: Process) -> int:
     """
@@ -496,24 +824,64 @@ def _human_readable_size(size: int) -> str:
         return f"{size_float:.1f}{units[unit_index]}"
 
 
+def _meta_field(file_info: dict, key: str):
+    """Return a field of the plugin metadata of a file info, e.g. 'name' or 'type'"""
+    meta = file_info.get('meta') or {}
+    return meta.get(key, meta.get(key.capitalize()))
+
+
+def _format_mtime(mtime: str) -> str:
+    """Format a modification time as YYYY-MM-DD HH:MM:SS"""
+    if not mtime:
+        return '0000-00-00 00:00:00'
+    if 'T' in mtime:
+        # ISO format: 2025-11-18T22:00:25Z
+        return mtime.replace('T', ' ').replace('Z', '').split('.')[0].split('+')[0]
+    return mtime[:19]
+
+
+def _ls_entry(path: str, file_info: dict) -> dict:
+    """Normalize a file info of the server for ls --json"""
+    is_dir = file_info.get('isDir', False) or file_info.get('type') == 'directory'
+    mode = file_info.get('mode', 0)
+    return {
+        'name': file_info.get('name', ''),
+        'path': os.path.join(path, file_info.get('name', '')),
+        'isDir': is_dir,
+        'size': file_info.get('size', 0),
+        'mode': f"{mode & 0o7777:04o}" if isinstance(mode, int) else mode,
+        'modTime': file_info.get('modTime', file_info.get('mtime', '')),
+        'plugin': _meta_field(file_info, 'name') or '',
+        'type': _meta_field(file_info, 'type') or '',
+        'meta': _meta_field(file_info, 'content') or {},
+    }
+
+
 @command(needs_path_resolution=True)
 def cmd_ls(process: Process) -> int:
     """
     List directory contents
 
-    Usage: ls [-l] [-h] [path]
+    Usage: ls [-l] [-h] [--json] [path]
 
     Options:
-        -l    Use long listing format
-        -h    Print human-readable sizes (e.g., 1K, 234M, 2G)
+        -l      Use long listing format: mode, plugin, metadata type, size,
+                modification time and name in aligned columns
+        -h      Print human-readable sizes (e.g., 1K, 234M, 2G)
+        --json  Print the entries as a JSON array, with their metadata
     """
+    import json
+
     # Parse arguments
     long_format = False
     human_readable = False
+    as_json = False
     path = None
 
     for arg in process.args:
-        if arg.startswith('-') and arg != '-':
+        if arg == '--json':
+            as_json = True
+        elif arg.startswith('-') and arg != '-':
             # Handle combined flags like -lh
             if 'l' in arg:
                 long_format = True
@@ -532,68 +900,11 @@ def cmd_ls(process: Process) -> int:
         return 1
 
     try:
-        files = process.filesystem.list_directory(path)
-
-        for file_info in files:
-            name = file_info.get('name', '')
-            is_dir = file_info.get('isDir', False) or file_info.get('type') == 'directory'
-            size = file_info.get('size', 0)
-
-            if long_format:
-                # Long format output similar to ls -l
-                file_type = 'd' if is_dir else '-'
-
-                # Get mode/permissions
-                mode_str = file_info.get('mode', '')
-                if mode_str and isinstance(mode_str, str) and len(mode_str) >= 9:
-                    # Already in rwxr-xr-x format
-                    perms = mode_str[:9]
-                elif mode_str and isinstance(mode_str, int):
-                    # Convert octal mode to rwx format
-                    perms = _mode_to_rwx(mode_str)
-                else:
-                    # Default permissions
-                    perms = 'rwxr-xr-x' if is_dir else 'rw-r--r--'
-
-                # Get modification time
-                mtime = file_info.get('modTime', file_info.get('mtime', ''))
-                if mtime:
-                    # Format timestamp (YYYY-MM-DD HH:MM:SS)
-                    if 'T' in mtime:
-                        # ISO format: 2025-11-18T22:00:25Z
-                        mtime = mtime.replace('T', ' ').replace('Z', '').split('.')[0]
-                    elif len(mtime) > 19:
-                        # Truncate to 19 chars if too long
-                        mtime = mtime[:19]
-                else:
-                    mtime = '0000-00-00 00:00:00'
-
-                # Format: permissions size date time name
-                # Add color for directories (blue)
-                if is_dir:
-                    # Blue color for directories
-                    colored_name = f"\033[1;34m{name}/\033[0m"
-                else:
-                    colored_name = name
-
-                # Format size based on human_readable flag
-                if human_readable:
-                    size_str = f"{_human_readable_size(size):>8}"
-                else:
-                    size_str = f"{size:>8}"
-
-                output = f"{file_type}{perms} {size_str} {mtime} {colored_name}\n"
-            else:
-                # Simple formatting
-                if is_dir:
-                    # Blue color for directories
-                    output = f"\033[1;34m{name}/\033[0m\n"
-                else:
-                    output = f"{name}\n"
-
-            process.stdout.write(output.encode('utf-8'))
-
-        return 0
+        if long_format or as_json:
+            files = process.filesystem.list_directory(path)
+        else:
+            # Names are enough, so backends can skip the metadata of each entry
+            files = process.filesystem.list_directory(path, fields='name')
     except Exception as e:
         error_msg = str(e)
         if "No such file or directory" in error_msg or "not found" in error_msg.lower():
@@ -602,6 +913,60 @@ def cmd_ls(process: Process) -> int:
             process.stderr.write(f"ls: {path}: {error_msg}\n")
         return 1
 
+    if as_json:
+        entries = [_ls_entry(path, file_info) for file_info in files]
+        process.stdout.write((json.dumps(entries, indent=2) + '\n').encode('utf-8'))
+        return 0
+
+    if not long_format:
+        for file_info in files:
+            name = file_info.get('name', '')
+            if file_info.get('isDir', False) or file_info.get('type') == 'directory':
+                # Blue color for directories
+                output = f"\033[1;34m{name}/\033[0m\n"
+            else:
+                output = f"{name}\n"
+            process.stdout.write(output.encode('utf-8'))
+        return 0
+
+    # Long format output similar to ls -l, with the plugin metadata
+    rows = []
+    for file_info in files:
+        name = file_info.get('name', '')
+        is_dir = file_info.get('isDir', False) or file_info.get('type') == 'directory'
+        size = file_info.get('size', 0)
+
+        # Get mode/permissions
+        mode_str = file_info.get('mode', '')
+        if mode_str and isinstance(mode_str, str) and len(mode_str) >= 9:
+            # Already in rwxr-xr-x format
+            perms = mode_str[:9]
+        elif mode_str and isinstance(mode_str, int):
+            # Convert octal mode to rwx format
+            perms = _mode_to_rwx(mode_str)
+        else:
+            # Default permissions
+            perms = 'rwxr-xr-x' if is_dir else 'rw-r--r--'
+
+        # Add color for directories (blue)
+        colored_name = f"\033[1;34m{name}/\033[0m" if is_dir else name
+        rows.append([
+            ('d' if is_dir else '-') + perms,
+            _meta_field(file_info, 'name') or '-',
+            _meta_field(file_info, 'type') or '-',
+            _human_readable_size(size) if human_readable else str(size),
+            _format_mtime(file_info.get('modTime', file_info.get('mtime', ''))),
+            colored_name,
+        ])
+
+    if rows:
+        widths = [max(len(row[i]) for row in rows) for i in range(4)]
+        for row in rows:
+            output = (f"{row[0]} {row[1]:<{widths[1]}} {row[2]:<{widths[2]}} "
+                      f"{row[3]:>{max(widths[3], 8)}} {row[4]} {row[5]}\n")
+            process.stdout.write(output.encode('utf-8'))
+    return 0
+
 
 @command()
 def cmd_pwd(process: Process) -> int:
@@ -1632,6 +1997,292 @@ def _cp_agfs_dir(process: Process, source_path: str, dest_path: str) -> int:
         return 1
 
 
+_DIFF_TEXT_LIMIT = 1024 * 1024  # Larger files are compared by digest only
+
+
+class _DiffTree:
+    """One side of diff: an AGFS path, or a local one with the local: prefix"""
+
+    def __init__(self, process: Process, spec: str):
+        self.local = spec.startswith('local:')
+        if self.local:
+            self.root = os.path.expanduser(spec[6:])
+        else:
+            self.root = spec
+            if not spec.startswith('/'):
+                self.root = os.path.normpath(os.path.join(getattr(process, 'cwd', '/'), spec))
+            self.client = process.filesystem.client
+
+    def label(self, path: str) -> str:
+        return f"local:{path}" if self.local else path
+
+    def join(self, path: str, name: str) -> str:
+        return os.path.join(path, name)
+
+    def stat(self, path: str) -> dict:
+        if self.local:
+            st = os.stat(path)
+            return {'isDir': os.path.isdir(path), 'size': st.st_size}
+        info = self.client.stat(path)
+        return {'isDir': info.get('isDir', False), 'size': info.get('size', 0)}
+
+    def list(self, path: str) -> dict:
+        if self.local:
+            return {name: self.stat(os.path.join(path, name)) for name in os.listdir(path)}
+        return {f['name']: {'isDir': f.get('isDir', False), 'size': f.get('size', 0)}
+                for f in self.client.ls(path)}
+
+    def read(self, path: str) -> bytes:
+        if self.local:
+            with open(path, 'rb') as f:
+                return f.read()
+        return self.client.cat(path)
+
+    def digest(self, path: str, algorithm: str) -> str:
+        if not self.local:
+            # Computed by the server, the file is not downloaded
+            return self.client.digest(path, algorithm).get('digest', '')
+        import hashlib
+        md5 = hashlib.md5()
+        with open(path, 'rb') as f:
+            for chunk in iter(lambda: f.read(1024 * 1024), b''):
+                md5.update(chunk)
+        return md5.hexdigest()
+
+
+def _diff_text(data: bytes):
+    """Return data as lines if it is text small enough to diff, otherwise None"""
+    if len(data) > _DIFF_TEXT_LIMIT or b'\0' in data:
+        return None
+    try:
+        return data.decode('utf-8').splitlines(keepends=True)
+    except UnicodeDecodeError:
+        return None
+
+
+def _diff_files(process: Process, a: _DiffTree, path_a: str, info_a: dict,
+                b: _DiffTree, path_b: str, info_b: dict, options: dict) -> int:
+    """Helper: Compare two files by size, then digest, and print how they differ"""
+    import difflib
+
+    label_a, label_b = a.label(path_a), b.label(path_b)
+    if info_a['size'] == info_b['size']:
+        # Both remote: xxh3 digests computed by the server. Otherwise md5, which
+        # the server can compute too
+        algorithm = 'md5' if a.local or b.local else 'xxh3'
+        if a.digest(path_a, algorithm) == b.digest(path_b, algorithm):
+            return 0
+
+    if options['brief'] or max(info_a['size'], info_b['size']) > _DIFF_TEXT_LIMIT:
+        process.stdout.write(f"Files {label_a} and {label_b} differ\n")
+        return 1
+    lines_a = _diff_text(a.read(path_a))
+    lines_b = _diff_text(b.read(path_b))
+    if lines_a is None or lines_b is None:
+        process.stdout.write(f"Binary files {label_a} and {label_b} differ\n")
+        return 1
+    for line in difflib.unified_diff(lines_a, lines_b, label_a, label_b, n=options['context']):
+        process.stdout.write(line if line.endswith('\n') else line + '\n\\ No newline at end of file\n')
+    return 1
+
+
+def _diff_trees(process: Process, a: _DiffTree, path_a: str, b: _DiffTree, path_b: str, options: dict) -> int:
+    """Helper: Compare the entries of two directories"""
+    entries_a = a.list(path_a)
+    entries_b = b.list(path_b)
+    status = 0
+    for name in sorted(set(entries_a) | set(entries_b)):
+        if name not in entries_b:
+            process.stdout.write(f"Only in {a.label(path_a)}: {name}\n")
+            status = 1
+            continue
+        if name not in entries_a:
+            process.stdout.write(f"Only in {b.label(path_b)}: {name}\n")
+            status = 1
+            continue
+        child_a, child_b = a.join(path_a, name), b.join(path_b, name)
+        info_a, info_b = entries_a[name], entries_b[name]
+        if info_a['isDir'] and info_b['isDir']:
+            if options['recursive']:
+                status = max(status, _diff_trees(process, a, child_a, b, child_b, options))
+            else:
+                process.stdout.write(f"Common subdirectories: {a.label(child_a)} and {b.label(child_b)}\n")
+        elif info_a['isDir'] or info_b['isDir']:
+            kinds = ['directory' if info['isDir'] else 'regular file' for info in (info_a, info_b)]
+            process.stdout.write(f"File {a.label(child_a)} is a {kinds[0]} while file {b.label(child_b)} is a {kinds[1]}\n")
+            status = 1
+        else:
+            status = max(status, _diff_files(process, a, child_a, info_a, b, child_b, info_b, options))
+    return status
+
+
+@command(no_pipeline=True)
+def cmd_edit(process: Process) -> int:
+    """
+    Edit an AGFS file with $EDITOR
+
+    Usage: edit PATH
+
+    The file is downloaded to a temporary file and opened with $VISUAL, $EDITOR
+    or vi. It is written back only if it was changed, and only if nobody else
+    changed it meanwhile; otherwise the edited copy is kept and its path printed.
+    A missing file is created.
+
+    Examples:
+        edit /kvfs/keys/config
+        EDITOR=nano edit /sqlfs/settings.yaml
+    """
+    import shlex
+    import subprocess
+    import tempfile
+
+    if len(process.args) != 1:
+        process.stderr.write("Usage: edit PATH\n")
+        return 2
+    if not process.filesystem:
+        process.stderr.write("edit: filesystem not available\n")
+        return 1
+    client = process.filesystem.client
+    path = process.args[0]
+    if not path.startswith('/'):
+        path = os.path.normpath(os.path.join(getattr(process, 'cwd', '/'), path))
+
+    try:
+        data, etag = client.read_with_etag(path)
+    except Exception as e:
+        if "No such file or directory" not in str(e) and "not found" not in str(e).lower():
+            process.stderr.write(f"edit: {path}: {e}\n")
+            return 1
+        # New file, created only if it still does not exist when saving
+        data, etag = b"", None
+
+    editor = (process.env.get('VISUAL') or process.env.get('EDITOR')
+              or os.environ.get('VISUAL') or os.environ.get('EDITOR') or 'vi')
+    fd, local_path = tempfile.mkstemp(prefix='agfs-edit-', suffix='-' + os.path.basename(path))
+    with os.fdopen(fd, 'wb') as f:
+        f.write(data)
+    keep = False
+    try:
+        try:
+            status = subprocess.call(shlex.split(editor) + [local_path])
+        except OSError as e:
+            process.stderr.write(f"edit: cannot run {editor}: {e.strerror}\n")
+            return 1
+        if status != 0:
+            process.stderr.write(f"edit: {editor} exited with status {status}, {path} not saved\n")
+            return 1
+        with open(local_path, 'rb') as f:
+            edited = f.read()
+        if edited == data:
+            process.stderr.write(f"edit: {path} unchanged\n")
+            return 0
+
+        try:
+            if etag is None:
+                client.write_conditional(path, edited, if_none_match='*')
+            else:
+                client.write_conditional(path, edited, if_match=etag)
+        except Exception as e:
+            keep = True
+            if getattr(e, 'status_code', None) == 412:
+                process.stderr.write(f"edit: {path} was changed by someone else while editing, not saved\n")
+            else:
+                process.stderr.write(f"edit: {path}: {e}\n")
+            process.stderr.write(f"edit: your version is in {local_path}\n")
+            return 1
+        return 0
+    finally:
+        if not keep:
+            os.unlink(local_path)
+
+
+@command()
+def cmd_diff(process: Process) -> int:
+    """
+    Compare files or directories of AGFS or the local filesystem
+
+    Usage: diff [-r] [-q] [-U LINES] A B
+
+    Options:
+        -r, --recursive  Compare subdirectories too
+        -q, --brief      Only report which files differ
+        -U LINES         Lines of context in the unified diff (default: 3)
+
+    A and B are AGFS paths, or local paths with the local: prefix. Files of the
+    same size are compared by digest, computed by the server for AGFS files, so
+    equal files are not downloaded. Text files that differ are shown as a
+    unified diff, other files are reported as differing.
+    Exit status is 0 if the inputs are the same, 1 if they differ, 2 on trouble.
+
+    Examples:
+        diff /s3fs/config.yaml /local/config.yaml
+        diff -r local:~/project/docs /memfs/docs
+        diff -rq /s3fs/backup /sqlfs/backup
+    """
+    options = {'recursive': False, 'brief': False, 'context': 3}
+    paths = []
+    args = list(process.args)
+    try:
+        while args:
+            arg = args.pop(0)
+            if arg in ('--recursive', '--brief'):
+                options[arg[2:]] = True
+            elif arg.startswith('-U'):
+                value = arg[2:] or (args.pop(0) if args else '')
+                options['context'] = int(value)
+            elif arg.startswith('-') and len(arg) > 1 and not paths:
+                for flag in arg[1:]:
+                    if flag == 'r':
+                        options['recursive'] = True
+                    elif flag == 'q':
+                        options['brief'] = True
+                    elif flag != 'u':
+                        process.stderr.write(f"diff: invalid option -- '{flag}'\n")
+                        return 2
+            else:
+                paths.append(arg)
+    except ValueError:
+        process.stderr.write("diff: invalid context length\n")
+        return 2
+    if len(paths) != 2:
+        process.stderr.write("Usage: diff [-r] [-q] [-U LINES] A B\n")
+        return 2
+    if not process.filesystem and not all(p.startswith('local:') for p in paths):
+        process.stderr.write("diff: filesystem not available\n")
+        return 2
+
+    a, b = _DiffTree(process, paths[0]), _DiffTree(process, paths[1])
+    path_a, path_b = a.root, b.root
+    try:
+        info_a = a.stat(path_a)
+    except Exception:
+        process.stderr.write(f"diff: {paths[0]}: No such file or directory\n")
+        return 2
+    try:
+        info_b = b.stat(path_b)
+    except Exception:
+        process.stderr.write(f"diff: {paths[1]}: No such file or directory\n")
+        return 2
+
+    try:
+        if info_a['isDir'] and info_b['isDir']:
+            return _diff_trees(process, a, path_a, b, path_b, options)
+        # A file and a directory: compare with the file of the same name in the directory
+        if info_a['isDir']:
+            path_a = a.join(path_a, os.path.basename(path_b))
+            info_a = a.stat(path_a)
+        elif info_b['isDir']:
+            path_b = b.join(path_b, os.path.basename(path_a))
+            info_b = b.stat(path_b)
+        if info_a['isDir'] or info_b['isDir']:
+            process.stderr.write("diff: cannot compare a file with a directory\n")
+            return 2
+        return _diff_files(process, a, path_a, info_a, b, path_b, info_b, options)
+    except Exception as e:
+        process.stderr.write(f"diff: {e}\n")
+        return 2
+
+
 @command()
 def cmd_sleep(process: Process) -> int:
     """
@@ -1667,6 +2318,26 @@ def cmd_sleep(process: Process) -> int:
         return 130
 
 
+@command()
+def cmd_true(process: Process) -> int:
+    """
+    Do nothing, successfully
+
+    Usage: true
+    """
+    return 0
+
+
+@command()
+def cmd_false(process: Process) -> int:
+    """
+    Do nothing, unsuccessfully
+
+    Usage: false
+    """
+    return 1
+
+
 @command()
 def cmd_plugins(process: Process) -> int:
     """
@@ -2086,6 +2757,9 @@ def cmd_tree(process: Process) -> int:
         -L level    Descend only level directories deep
         -d          List directories only
         -a          Show all files (including hidden files starting with .)
+        -s          Print the size of each file in bytes
+        -h          Print the size of each file in human-readable format
+        --json      Print the tree as JSON, with the counts as a last "report" element
         --noreport  Don't print file and directory count at the end
 
     Examples:
@@ -2094,12 +2768,17 @@ def cmd_tree(process: Process) -> int:
         tree -L 2           # Show tree with max depth of 2
         tree -d             # Show only directories
         tree -a             # Show all files including hidden ones
+        tree -h -L 1 /s3fs  # Show the sizes of the top-level entries
     """
+    import json
+
     # Parse arguments
     max_depth = None
     dirs_only = False
     show_hidden = False
     show_report = True
+    as_json = False
+    sizes = None
     path = None
 
     args = process.args[:]
@@ -2125,6 +2804,12 @@ def cmd_tree(process: Process) -> int:
         elif args[i] == '--noreport':
             show_report = False
             i += 1
+        elif args[i] == '--json':
+            as_json = True
+            i += 1
+        elif args[i] in ('-s', '-h'):
+            sizes = 'human' if args[i] == '-h' or sizes == 'human' else 'bytes'
+            i += 1
         elif args[i].startswith('-'):
             # Handle combined flags
             if args[i] == '-L':
@@ -2165,15 +2850,25 @@ def cmd_tree(process: Process) -> int:
             process.stderr.write(f"tree: {path}: {error_msg}\n")
         return 1
 
-    # Print the root path
-    process.stdout.write(f"{path}\n".encode('utf-8'))
-
     # Track statistics
     stats = {'dirs': 0, 'files': 0}
 
+    if as_json:
+        root = {'type': 'directory', 'name': path,
+                'contents': _tree_json(process, path, max_depth, 0, dirs_only, show_hidden, stats)}
+        report = {'type': 'report', 'directories': stats['dirs']}
+        if not dirs_only:
+            report['files'] = stats['files']
+        output = [root, report] if show_report else [root]
+        process.stdout.write((json.dumps(output, indent=2) + '\n').encode('utf-8'))
+        return 0
+
+    # Print the root path
+    process.stdout.write(f"{path}\n".encode('utf-8'))
+
     # Build and print the tree
     try:
-        _print_tree(process, path, "", True, max_depth, 0, dirs_only, show_hidden, stats)
+        _print_tree(process, path, "", True, max_depth, 0, dirs_only, show_hidden, stats, sizes)
     except Exception as e:
         process.stderr.write(f"tree: error traversing {path}: {e}\n")
         return 1
@@ -2189,7 +2884,45 @@ def cmd_tree(process: Process) -> int:
     return 0
 
 
-def _print_tree(process, path, prefix, is_last, max_depth, current_depth, dirs_only, show_hidden, stats):
+def _tree_entries(process, path, dirs_only, show_hidden):
+    """List the entries of a directory shown by tree, directories first, then by name"""
+    entries = []
+    for entry in process.filesystem.list_directory(path):
+        name = entry.get('name', '')
+        is_dir = entry.get('isDir', False) or entry.get('type') == 'directory'
+        # Skip hidden files unless show_hidden is True, and files if dirs_only is True
+        if (not show_hidden and name.startswith('.')) or (dirs_only and not is_dir):
+            continue
+        entries.append(entry)
+    entries.sort(key=lambda e: (not (e.get('isDir', False) or e.get('type') == 'directory'), e.get('name', '')))
+    return entries
+
+
+def _tree_json(process, path, max_depth, current_depth, dirs_only, show_hidden, stats):
+    """Build the contents of a directory for tree --json, in the layout of tree -J"""
+    if max_depth is not None and current_depth >= max_depth:
+        return []
+    contents = []
+    try:
+        entries = _tree_entries(process, path, dirs_only, show_hidden)
+    except Exception as e:
+        return [{'type': 'error', 'message': str(e)}]
+    for entry in entries:
+        name = entry.get('name', '')
+        is_dir = entry.get('isDir', False) or entry.get('type') == 'directory'
+        node = {'type': 'directory' if is_dir else 'file', 'name': name, 'size': entry.get('size', 0)}
+        if is_dir:
+            stats['dirs'] += 1
+            subdir_path = os.path.normpath(os.path.join(path, name))
+            node['contents'] = _tree_json(process, subdir_path, max_depth, current_depth + 1,
+                                          dirs_only, show_hidden, stats)
+        else:
+            stats['files'] += 1
+        contents.append(node)
+    return contents
+
+
+def _print_tree(process, path, prefix, is_last, max_depth, current_depth, dirs_only, show_hidden, stats, sizes=None):
     """
     Recursively print directory tree
 
@@ -2203,34 +2936,15 @@ def _print_tree(process, path, prefix, is_last, max_depth, current_depth, dirs_o
         dirs_only: Only show directories
         show_hidden: Show hidden files
         stats: Dictionary to track file/dir counts
+        sizes: 'bytes' or 'human' to print the size of each entry, None not to
     """
     # Check depth limit
     if max_depth is not None and current_depth >= max_depth:
         return
 
     try:
-        # List directory contents
-        entries = process.filesystem.list_directory(path)
-
-        # Filter entries
-        filtered_entries = []
-        for entry in entries:
-            name = entry.get('name', '')
-
-            # Skip hidden files unless show_hidden is True
-            if not show_hidden and name.startswith('.'):
-                continue
-
-            is_dir = entry.get('isDir', False) or entry.get('type') == 'directory'
-
-            # Skip files if dirs_only is True
-            if dirs_only and not is_dir:
-                continue
-
-            filtered_entries.append(entry)
-
-        # Sort entries: directories first, then by name
-        filtered_entries.sort(key=lambda e: (not (e.get('isDir', False) or e.get('type') == 'directory'), e.get('name', '')))
+        # List, filter and sort directory contents
+        filtered_entries = _tree_entries(process, path, dirs_only, show_hidden)
 
         # Process each entry
         for idx, entry in enumerate(filtered_entries):
@@ -2259,7 +2973,11 @@ def _print_tree(process, path, prefix, is_last, max_depth, current_depth, dirs_o
             else:
                 display_name = name
 
-            # Print the entry
+            # Print the entry, with its size if requested
+            if sizes == 'human':
+                display_name = f"[{_human_readable_size(entry.get('size', 0)):>5}]  {display_name}"
+            elif sizes == 'bytes':
+                display_name = f"[{entry.get('size', 0):>11}]  {display_name}"
             line = f"{prefix}{connector}{display_name}\n"
             process.stdout.write(line.encode('utf-8'))
 
@@ -2278,7 +2996,8 @@ def _print_tree(process, path, prefix, is_last, max_depth, current_depth, dirs_o
                     current_depth + 1,
                     dirs_only,
                     show_hidden,
-                    stats
+                    stats,
+                    sizes
                 )
 
     except Exception as e:
@@ -2614,11 +3333,11 @@ def cmd_help(process: Process) -> int:
 
         # Group commands by category for better organization
         categories = {
-            'File Operations': ['ls', 'tree', 'cat', 'mkdir', 'rm', 'mv', 'cp', 'stat', 'upload', 'download'],
+            'File Operations': ['ls', 'tree', 'cat', 'write', 'edit', 'mkdir', 'rm', 'mv', 'cp', 'diff', 'stat', 'watch', 'upload', 'download'],
             'Text Processing': ['grep', 'wc', 'head', 'tail', 'sort', 'uniq', 'tr', 'rev', 'cut', 'jq'],
             'System': ['pwd', 'cd', 'echo', 'env', 'export', 'unset', 'sleep'],
-            'Testing': ['test'],
-            'AGFS Management': ['mount', 'plugins'],
+            'Testing': ['test', 'true', 'false'],
+            'AGFS Management': ['mount', 'plugins', 'status', 'ctx'],
         }
 
         # Display categorized commands
@@ -2712,6 +3431,178 @@ def cmd_help(process: Process) -> int:
     process.stdout.write("\n")
     return 0
 
+class _WizardAborted(Exception):
+    """Raised when the answers to the mount wizard run out"""
+
+
+class _WizardInput:
+    """Answers to the mount wizard: lines piped to the command, or the terminal"""
+
+    def __init__(self, process: Process):
+        import sys
+        self.process = process
+        data = process.stdin.read()
+        self.lines = data.decode('utf-8').splitlines() if data else None
+        self.interactive = self.lines is None and sys.stdin.isatty()
+
+    def ask(self, prompt: str, secret: bool = False) -> str:
+        if self.lines is not None:
+            self.process.stdout.write(prompt)
+            if not self.lines:
+                self.process.stdout.write("\n")
+                raise _WizardAborted()
+            answer = self.lines.pop(0).strip()
+            self.process.stdout.write(("***" if secret and answer else answer) + "\n")
+            return answer
+        self.process.stdout.flush()
+        try:
+            if secret:
+                import getpass
+                return getpass.getpass(prompt).strip()
+            return input(prompt).strip()
+        except EOFError:
+            raise _WizardAborted()
+
+
+def _coerce_config_value(param: dict, value: str):
+    """Convert an answer to the type of a schema parameter, raising ValueError if invalid"""
+    import json
+    kind = param.get('type', 'string')
+    choices = param.get('choices') or []
+    if choices and value not in choices:
+        raise ValueError(f"must be one of: {', '.join(choices)}")
+    if kind == 'int':
+        return int(value)
+    if kind == 'bool':
+        if value.lower() in ('y', 'yes', 'true', '1', 'on'):
+            return True
+        if value.lower() in ('n', 'no', 'false', '0', 'off'):
+            return False
+        raise ValueError("must be yes or no")
+    if kind == 'strings':
+        return [item.strip() for item in value.split(',') if item.strip()]
+    if kind == 'map':
+        parsed = json.loads(value)
+        if not isinstance(parsed, dict):
+            raise ValueError("must be a JSON object")
+        return parsed
+    return value
+
+
+def _parse_option_value(value: str):
+    """Interpret a free-form option value as JSON (true, 10, [...]), else as a string"""
+    import json
+    try:
+        return json.loads(value)
+    except ValueError:
+        return value
+
+
+def _mount_wizard(process: Process) -> int:
+    """Choose a plugin, answer its configuration keys, validate and mount"""
+    client = process.filesystem.client
+    answers = _WizardInput(process)
+    if answers.lines is None and not answers.interactive:
+        process.stderr.write("mount: --wizard needs a terminal or answers on stdin\n")
+        return 1
+    out = process.stdout
+
+    try:
+        types = client.plugin_types()
+    except Exception as e:
+        process.stderr.write(f"mount: {e}\n")
+        return 1
+    if not types:
+        process.stderr.write("mount: no plugin types registered\n")
+        return 1
+    by_name = {t['name']: t for t in types}
+
+    try:
+        # Plugin type, from the arguments or chosen from the list
+        fstype = process.args[1] if len(process.args) > 1 else None
+        while fstype not in by_name:
+            if fstype:
+                out.write(f"Unknown plugin type: {fstype}\n")
+            out.write("Plugin types (* prompts for each configuration key):\n")
+            for i, t in enumerate(types, 1):
+                out.write(f"  {i:2}) {t['name']}{' *' if t.get('hasSchema') else ''}\n")
+            fstype = answers.ask("Plugin: ")
+            if fstype.isdigit() and 1 <= int(fstype) <= len(types):
+                fstype = types[int(fstype) - 1]['name']
+        schema = by_name[fstype].get('schema') or []
+
+        path = process.args[2] if len(process.args) > 2 else ""
+        if not path:
+            path = answers.ask(f"Mount path [/{fstype}]: ") or f"/{fstype}"
+
+        while True:
+            config = {}
+            if schema:
+                out.write(f"Configuration of {fstype} (leave empty for the default):\n")
+            for param in schema:
+                hint = [param.get('type', 'string')]
+                if param.get('choices'):
+                    hint.append('|'.join(param['choices']))
+                if param.get('required'):
+                    hint.append('required')
+                elif param.get('default'):
+                    hint.append(f"default {param['default']}")
+                if param.get('description'):
+                    out.write(f"  # {param['description']}\n")
+                while True:
+                    value = answers.ask(f"  {param['name']} ({', '.join(hint)}): ", secret=param.get('secret', False))
+                    if not value:
+                        if not param.get('required'):
+                            break
+                        out.write(f"  {param['name']} is required\n")
+                        continue
+                    try:
+                        config[param['name']] = _coerce_config_value(param, value)
+                        break
+                    except ValueError as e:
+                        out.write(f"  invalid {param['name']}: {e}\n")
+
+            # Mount options, and the whole configuration of plugins without a schema
+            if schema:
+                out.write("Other options, e.g. trash=true (empty line to finish):\n")
+            else:
+                out.write(f"{fstype} does not describe its configuration; enter key=value (empty line to finish):\n")
+            while True:
+                option = answers.ask("  option: ")
+                if not option:
+                    break
+                if '=' not in option:
+                    out.write("  expected key=value\n")
+                    continue
+                key, value = option.split('=', 1)
+                config[key.strip()] = _parse_option_value(value.strip())
+
+            try:
+                client.mount(fstype, path, config, dry_run=True)
+                break
+            except Exception as e:
+                out.write(f"Invalid configuration: {e}\n")
+                if answers.ask("Enter the configuration again? [Y/n] ").lower() in ('n', 'no'):
+                    return 1
+
+        secrets = {p['name'] for p in schema if p.get('secret')}
+        options = " ".join(f"{k}={'***' if k in secrets else v}" for k, v in config.items())
+        out.write(f"mount {fstype} {path} {options}".rstrip() + "\n")
+        if answers.ask("Mount? [Y/n] ").lower() in ('n', 'no'):
+            out.write("Not mounted\n")
+            return 1
+    except _WizardAborted:
+        process.stderr.write("mount: wizard aborted\n")
+        return 1
+
+    try:
+        client.mount(fstype, path, config)
+    except Exception as e:
+        process.stderr.write(f"mount: {e}\n")
+        return 1
+    out.write(f"Mounted {fstype} at {path}\n")
+    return 0
+
 
 @command()
 def cmd_mount(process: Process) -> int:
@@ -2719,12 +3610,17 @@ def cmd_mount(process: Process) -> int:
     Mount a plugin dynamically or list mounted filesystems
 
     Usage: mount [<fstype> <path> [key=value ...]]
+           mount --wizard [<fstype> [<path>]]
 
     Without arguments: List all mounted filesystems
     With arguments: Mount a new filesystem
+    With --wizard: Choose a plugin and answer a prompt for each of its
+    configuration keys; the server validates the answers before mounting
 
     Examples:
         mount                    # List all mounted filesystems
+        mount --wizard           # Mount interactively
+        mount --wizard sqlfs /db # Prompt for the configuration of sqlfs
         mount memfs /test/mem
         mount sqlfs /test/db backend=sqlite db_path=/tmp/test.db
         mount s3fs /test/s3 bucket=my-bucket region=us-west-1 access_key_id=xxx secret_access_key=yyy
@@ -2733,6 +3629,9 @@ def cmd_mount(process: Process) -> int:
         process.stderr.write("mount: filesystem not available\n")
         return 1
 
+    if process.args and process.args[0] == "--wizard":
+        return _mount_wizard(process)
+
     # No arguments - list mounted filesystems
     if len(process.args) == 0:
         try:
@@ -2810,10 +3709,349 @@ def cmd_mount(process: Process) -> int:
         return 1
 
 
+def _format_uptime(seconds: int) -> str:
+    """Format a duration in seconds as e.g. 2d 3h 4m 5s"""
+    days, rest = divmod(int(seconds), 86400)
+    hours, rest = divmod(rest, 3600)
+    minutes, secs = divmod(rest, 60)
+    parts = []
+    if days:
+        parts.append(f"{days}d")
+    if days or hours:
+        parts.append(f"{hours}h")
+    if days or hours or minutes:
+        parts.append(f"{minutes}m")
+    parts.append(f"{secs}s")
+    return " ".join(parts)
+
+
+@command()
+def cmd_ctx(process: Process) -> int:
+    """
+    Manage the client contexts: named servers with their token and TLS settings
+
+    Usage:
+        ctx                      List the contexts, * marks the current one
+        ctx use NAME             Make NAME the current context and connect to its server
+        ctx show [NAME]          Print the settings of a context (default: current)
+        ctx set NAME [--server URL] [--token TOKEN] [--ca-cert FILE]
+                     [--insecure | --secure] [--timeout SECONDS]
+                                 Add a context, or change the given settings of one
+        ctx rm NAME              Remove a context
+
+    Contexts are kept in ~/.agfs/config (or $AGFS_CONFIG). agfs-shell starts
+    with the current context unless $AGFS_API_URL is set; --context NAME or
+    $AGFS_CONTEXT pick another one for a single run.
+
+    Examples:
+        ctx set prod --server https://agfs.example.com --token $PROD_TOKEN
+        ctx set dev --server http://localhost:8080
+        ctx use prod
+        agfs-shell --context dev -c 'ls /'
+    """
+    from .config import Context, ContextStore
+
+    args = list(process.args)
+    action = args.pop(0) if args else 'list'
+    try:
+        store = ContextStore.load()
+    except ValueError as e:
+        process.stderr.write(f"ctx: {e}\n")
+        return 1
+
+    if action in ('list', 'ls'):
+        if not store.contexts:
+            process.stdout.write(f"No contexts in {store.path}, add one with: ctx set NAME --server URL\n")
+            return 0
+        width = max(len(name) for name in store.contexts)
+        for name in sorted(store.contexts):
+            marker = '*' if name == store.current else ' '
+            process.stdout.write(f"{marker} {name:<{width}}  {store.contexts[name].server}\n")
+        return 0
+
+    if action == 'show':
+        name = args[0] if args else (getattr(process, 'context', None) or store.current)
+        if not name:
+            process.stderr.write("ctx: no current context\n")
+            return 1
+        try:
+            ctx = store.get(name)
+        except ValueError as e:
+            process.stderr.write(f"ctx: {e}\n")
+            return 1
+        token = f"****{ctx.token[-4:]}" if ctx.token and len(ctx.token) > 8 else ('****' if ctx.token else '-')
+        process.stdout.write(f"name:     {ctx.name}{' (current)' if ctx.name == store.current else ''}\n")
+        process.stdout.write(f"server:   {ctx.server}\n")
+        process.stdout.write(f"token:    {token}\n")
+        process.stdout.write(f"tls:      {'insecure, not verified' if ctx.insecure else (ctx.ca_cert or 'system CAs')}\n")
+        process.stdout.write(f"timeout:  {ctx.timeout if ctx.timeout is not None else 'default'}\n")
+        return 0
+
+    if action == 'use':
+        if len(args) != 1:
+            process.stderr.write("Usage: ctx use NAME\n")
+            return 2
+        try:
+            ctx = store.get(args[0])
+        except ValueError as e:
+            process.stderr.write(f"ctx: {e}\n")
+            return 1
+        store.current = ctx.name
+        store.save()
+        use_context = getattr(process, 'use_context', None)
+        if use_context:
+            use_context(ctx)
+        process.stdout.write(f"Switched to context {ctx.name} ({ctx.server})\n")
+        return 0
+
+    if action == 'set':
+        if not args or args[0].startswith('-'):
+            process.stderr.write("Usage: ctx set NAME [--server URL] [--token TOKEN] [--ca-cert FILE] "
+                                 "[--insecure | --secure] [--timeout SECONDS]\n")
+            return 2
+        name = args.pop(0)
+        ctx = store.contexts.get(name) or Context(name, '')
+        try:
+            while args:
+                option = args.pop(0)
+                if option == '--insecure':
+                    ctx.insecure = True
+                elif option == '--secure':
+                    ctx.insecure = False
+                elif option in ('--server', '--token', '--ca-cert', '--timeout'):
+                    if not args:
+                        process.stderr.write(f"ctx: option '{option}' requires an argument\n")
+                        return 2
+                    value = args.pop(0)
+                    if option == '--server':
+                        ctx.server = value.rstrip('/')
+                    elif option == '--token':
+                        ctx.token = value or None
+                    elif option == '--ca-cert':
+                        ctx.ca_cert = value or None
+                    else:
+                        ctx.timeout = int(value)
+                else:
+                    process.stderr.write(f"ctx: unrecognized option '{option}'\n")
+                    return 2
+        except ValueError:
+            process.stderr.write("ctx: --timeout must be a number of seconds\n")
+            return 2
+        if not ctx.server:
+            process.stderr.write(f"ctx: context {name} needs --server\n")
+            return 2
+        store.contexts[name] = ctx
+        store.save()
+        if getattr(process, 'context', None) == name and getattr(process, 'use_context', None):
+            process.use_context(ctx)
+        return 0
+
+    if action in ('rm', 'remove'):
+        if len(args) != 1 or args[0] not in store.contexts:
+            process.stderr.write(f"ctx: context {args[0] if args else ''} not found in {store.path}\n")
+            return 1
+        del store.contexts[args[0]]
+        if store.current == args[0]:
+            store.current = None
+        store.save()
+        return 0
+
+    process.stderr.write(f"ctx: unknown action '{action}' (list, use, show, set, rm)\n")
+    return 2
+
+
+@command()
+def cmd_status(process: Process) -> int:
+    """
+    Show the status of the AGFS server
+
+    Usage: status [--json]
+
+    Shows the server version, uptime, listeners, external plugins, and the
+    state of the mounts from the server configuration and of all active mounts.
+
+    Options:
+        --json    Print the status as returned by the server
+
+    Examples:
+        status                                   # Show server status
+        status --json | jq '.summary.failed'     # Count failed mounts
+    """
+    import json
+
+    if not process.filesystem:
+        process.stderr.write("status: filesystem not available\n")
+        return 1
+
+    as_json = False
+    for arg in process.args:
+        if arg == "--json":
+            as_json = True
+        else:
+            process.stderr.write(f"status: unknown option: {arg}\n")
+            process.stderr.write("Usage: status [--json]\n")
+            return 1
+
+    try:
+        status = process.filesystem.client.status()
+    except Exception as e:
+        process.stderr.write(f"status: {e}\n")
+        return 1
+
+    if as_json:
+        process.stdout.write(json.dumps(status, indent=2) + "\n")
+        return 0
+
+    out = process.stdout
+    out.write(f"AGFS server {status.get('version', 'unknown')} "
+              f"(commit {status.get('gitCommit', 'unknown')}, built {status.get('buildTime', 'unknown')})\n")
+    out.write(f"Status:     {status.get('status', 'unknown')}\n")
+    out.write(f"Uptime:     {_format_uptime(status.get('uptimeSeconds', 0))} (since {status.get('startedAt', '?')})\n")
+
+    listeners = status.get('listeners') or []
+    listening = ", ".join(f"{l.get('protocol')} {l.get('address')}" for l in listeners) or "-"
+    out.write(f"Listening:  {listening}\n")
+
+    plugins = status.get('externalPlugins') or []
+    out.write(f"Plugins:    {', '.join(os.path.basename(p) for p in plugins) if plugins else 'no external plugins'}\n")
+
+    summary = status.get('summary', {})
+    out.write(f"Mounts:     {summary.get('active', 0)} active, {summary.get('failed', 0)} failed, "
+              f"{summary.get('pending', 0)} pending ({summary.get('configured', 0)} configured)\n")
+
+    # Configured mounts that are not served, then every active mount
+    configured = status.get('configuredMounts') or []
+    problems = [m for m in configured if m.get('state') in ('failed', 'pending', 'unmounted')]
+    rows = [(m.get('path', ''), m.get('plugin', ''), 'disabled (crashes)' if m.get('disabled') else 'active', '')
+            for m in status.get('activeMounts') or []]
+    rows += [(m.get('path', ''), m.get('plugin', ''), m.get('state', ''), m.get('error', '')) for m in problems]
+    if rows:
+        out.write("\n")
+        path_width = max(len(r[0]) for r in rows)
+        plugin_width = max(len(r[1]) for r in rows)
+        for path, plugin, state, error in sorted(rows):
+            line = f"  {path.ljust(path_width)}  {plugin.ljust(plugin_width)}  {state}"
+            if error:
+                line += f": {error}"
+            out.write(line + "\n")
+
+    # Like a health check, fail when configured mounts failed
+    return 1 if summary.get('failed', 0) else 0
+
+
+@command()
+def cmd_watch(process: Process) -> int:
+    """
+    Watch a file or directory and print its changes, or run a command for each
+
+    Usage: watch [-r] [-t TYPES] [-c COUNT] [-n SECONDS] [--json] PATH [COMMAND]
+
+    Options:
+        -r, --recursive  Watch the whole tree under PATH
+        -t TYPES         Only report these event types, comma separated
+                         (create, write, remove, rename)
+        -c COUNT         Exit after COUNT events
+        -n SECONDS       Poll interval for servers without the watch endpoint (default: 1)
+        --json           Print the events as JSON lines
+
+    COMMAND runs for each event, with $WATCH_EVENT, $WATCH_PATH and, for renames,
+    $WATCH_OLD_PATH set. Quote it to pass a pipeline or a command list.
+
+    Examples:
+        watch -r /memfs/inbox
+        watch -c 1 -t create /queuefs/done          # Wait for the first new file
+        watch -r /local/src 'cp $WATCH_PATH /s3/mirror/'
+    """
+    import json
+
+    if not process.filesystem:
+        process.stderr.write("watch: filesystem not available\n")
+        return 1
+
+    recursive = False
+    as_json = False
+    types = None
+    count = None
+    interval = 1.0
+    positional = []
+    args = list(process.args)
+    try:
+        while args:
+            arg = args.pop(0)
+            if arg in ('-r', '-R', '--recursive'):
+                recursive = True
+            elif arg == '--json':
+                as_json = True
+            elif arg in ('-t', '-c', '-n'):
+                if not args:
+                    process.stderr.write(f"watch: option requires an argument -- '{arg[1]}'\n")
+                    return 2
+                value = args.pop(0)
+                if arg == '-t':
+                    types = {t.strip() for t in value.split(',') if t.strip()}
+                elif arg == '-c':
+                    count = int(value)
+                else:
+                    interval = float(value)
+            elif arg.startswith('-') and not positional:
+                process.stderr.write(f"watch: invalid option -- '{arg}'\n")
+                return 2
+            else:
+                positional.append(arg)
+    except ValueError as e:
+        process.stderr.write(f"watch: invalid number: {e}\n")
+        return 2
+
+    if not positional:
+        process.stderr.write("watch: missing path\n")
+        process.stderr.write("Usage: watch [-r] [-t TYPES] [-c COUNT] [-n SECONDS] [--json] PATH [COMMAND]\n")
+        return 2
+    path = positional[0]
+    if not path.startswith('/'):
+        path = os.path.normpath(os.path.join(getattr(process, 'cwd', '/'), path))
+    action = ' '.join(positional[1:])
+    run_command = getattr(process, 'run_command', None)
+    if action and run_command is None:
+        process.stderr.write("watch: running a command needs the shell\n")
+        return 1
+
+    seen = 0
+    status = 0
+    try:
+        events = process.filesystem.client.watch(path, recursive=recursive, poll_interval=interval)
+        for event in events:
+            kind = event.get('type', '')
+            if types and kind not in types:
+                continue
+            if action:
+                process.env['WATCH_EVENT'] = kind
+                process.env['WATCH_PATH'] = event.get('path', '')
+                process.env['WATCH_OLD_PATH'] = event.get('oldPath', '')
+                status = run_command(action)
+            elif as_json:
+                process.stdout.write(json.dumps(event) + "\n")
+            else:
+                target = event.get('path', '')
+                if event.get('oldPath'):
+                    target = f"{event['oldPath']} -> {target}"
+                process.stdout.write(f"{event.get('time', '')} {kind} {target}\n")
+            process.stdout.flush()
+            seen += 1
+            if count is not None and seen >= count:
+                break
+    except KeyboardInterrupt:
+        return 130
+    except Exception as e:
+        process.stderr.write(f"watch: {path}: {e}\n")
+        return 1
+    return status
+
+
 # Registry of built-in commands
 BUILTINS = {
     'echo': cmd_echo,
     'cat': cmd_cat,
+    'write': cmd_write,
     'grep': cmd_grep,
     'wc': cmd_wc,
     'head': cmd_head,
@@ -2836,14 +4074,21 @@ BUILTINS = {
     'unset': cmd_unset,
     'test': cmd_test,
     '[': cmd_test,  # [ is an alias for test
+    'true': cmd_true,
+    'false': cmd_false,
     'stat': cmd_stat,
     'jq': cmd_jq,
     'upload': cmd_upload,
     'download': cmd_download,
     'cp': cmd_cp,
+    'edit': cmd_edit,
+    'diff': cmd_diff,
     'sleep': cmd_sleep,
     'plugins': cmd_plugins,
     'mount': cmd_mount,
+    'status': cmd_status,
+    'ctx': cmd_ctx,
+    'watch': cmd_watch,
     '?': cmd_help,
     'help': cmd_help,
 }
@@ -12,85 +12,24 @@ def execute_script_file(shell, script_path):
     try:
         with open(script_path, 'r') as f:
             lines = f.readlines()
-
-        exit_code = 0
-        i = 0
-        while i < len(lines):
-            line = lines[i].strip()
-            line_num = i + 1
-
-            # Skip empty lines and comments
-            if not line or line.startswith('#'):
-                i += 1
-                continue
-
-            # Execute the command
-            try:
-                exit_code = shell.execute(line)
-
-                # Check if for-loop needs to be collected
-                if exit_code == -997:
-                    # Collect for/do/done loop
-                    for_lines = [line]
-                    for_depth = 1  # Track nesting depth
-                    i += 1
-                    while i < len(lines):
-                        next_line = lines[i].strip()
-                        for_lines.append(next_line)
-                        # Count nested for loops
-                        if next_line.startswith('for '):
-                            for_depth += 1
-                        elif next_line == 'done':
-                            for_depth -= 1
-                            if for_depth == 0:
-                                break
-                        i += 1
-
-                    # Execute the for loop
-                    exit_code = shell.execute_for_loop(for_lines)
-                    if exit_code != 0:
-                        sys.stderr.write(f"Error at line {line_num}: for loop failed with exit code {exit_code}\n")
-                        return exit_code
-                # Check if if-statement needs to be collected
-                elif exit_code == -998:
-                    # Collect if/then/else/fi statement with depth tracking
-                    if_lines = [line]
-                    if_depth = 1  # Track nesting depth
-                    i += 1
-                    while i < len(lines):
-                        next_line = lines[i].strip()
-                        if_lines.append(next_line)
-                        # Track nested if statements
-                        if next_line.startswith('if '):
-                            if_depth += 1
-                        elif next_line == 'fi':
-                            if_depth -= 1
-                            if if_depth == 0:
-                                break
-                        i += 1
-
-                    # Execute the if statement
-                    exit_code = shell.execute_if_statement(if_lines)
-                    if exit_code != 0:
-                        sys.stderr.write(f"Error at line {line_num}: if statement failed with exit code {exit_code}\n")
-                        return exit_code
-                # If a command fails, stop execution
-                elif exit_code != 0:
-                    sys.stderr.write(f"Error at line {line_num}: command failed with exit code {exit_code}\n")
-                    return exit_code
-            except Exception as e:
-                sys.stderr.write(f"Error at line {line_num}: {str(e)}\n")
-                return 1
-
-            i += 1
-
-        return exit_code
     except FileNotFoundError:
         sys.stderr.write(f"agfs-shell: {script_path}: No such file or directory\n")
         return 127
     except Exception as e:
         sys.stderr.write(f"agfs-shell: {script_path}: {str(e)}\n")
         return 1
+    return shell.run_script(lines)
+
+
+def read_piped_stdin(command):
+    """Read stdin if data was piped to the shell and the command does not redirect it"""
+    import re
+    import select
+    has_input_redir = bool(re.search(r'\s<\s', command))
+    if not sys.stdin.isatty() and not has_input_redir:
+        if select.select([sys.stdin], [], [], 0.0)[0]:
+            return sys.stdin.buffer.read()
+    return None
 
 
 def main():
@@ -104,6 +43,14 @@ def main():
                         dest='agfs_api_url',
                         help='AGFS API URL (default: http://localhost:8080 or $AGFS_API_URL)',
                         default=None)
+    parser.add_argument('--context',
+                        dest='context',
+                        help='Client context of the config file to use (default: its current context or $AGFS_CONTEXT)',
+                        default=None)
+    parser.add_argument('--token',
+                        dest='token',
+                        help='Bearer token for the server (default: $AGFS_TOKEN or the context\'s)',
+                        default=None)
     parser.add_argument('--timeout',
                         dest='timeout',
                         type=int,
@@ -113,6 +60,14 @@ def main():
                         dest='command_string',
                         help='Execute command string',
                         default=None)
+    parser.add_argument('-f',
+                        dest='script_file',
+                        help='Execute script file',
+                        default=None)
+    parser.add_argument('-e',
+                        dest='errexit',
+                        action='store_true',
+                        help='Exit as soon as a command fails (like set -e)')
     parser.add_argument('--help', '-h', action='store_true',
                         help='Show this help message')
     parser.add_argument('script', nargs='?', help='Script file to execute')
@@ -126,107 +81,44 @@ def main():
         sys.exit(0)
 
     # Create configuration
-    config = Config.from_args(server_url=args.agfs_api_url, timeout=args.timeout)
+    try:
+        config = Config.from_args(server_url=args.agfs_api_url, timeout=args.timeout,
+                                  context=args.context, token=args.token)
+    except ValueError as e:
+        sys.stderr.write(f"agfs-shell: {e}\n")
+        sys.exit(2)
 
     # Initialize shell with configuration
-    shell = Shell(server_url=config.server_url, timeout=config.timeout)
+    shell = Shell(server_url=config.server_url, timeout=config.timeout, token=config.token,
+                  verify=config.verify, context=config.context)
+
+    shell.errexit = args.errexit
 
     # Determine mode of execution
-    # Priority: -c flag > script file > command args > interactive
+    # Priority: -c flag > -f flag > script file > command args > interactive
+    # The exit code is that of the last command, or the one given to exit
 
     if args.command_string:
-        # Mode 1: -c "command string"
+        # Mode 1: -c "command string", which may hold several commands
         command = args.command_string
-        stdin_data = None
-        import re
-        import select
-        has_input_redir = bool(re.search(r'\s<\s', command))
-        if not sys.stdin.isatty() and not has_input_redir:
-            if select.select([sys.stdin], [], [], 0.0)[0]:
-                stdin_data = sys.stdin.buffer.read()
-
-        # Check if command contains semicolons (multiple commands)
-        # Split intelligently: respect if/then/else/fi and for/do/done blocks
-        if ';' in command:
-            # Split by semicolons but preserve control flow statements as single units
-            commands = []
-            current_cmd = []
-            in_control_flow = False
-            control_flow_type = None  # 'if' or 'for'
-
-            for part in command.split(';'):
-                part = part.strip()
-                if not part:
-                    continue
-
-                # Check if this part starts a control flow statement
-                if part.startswith('if '):
-                    in_control_flow = True
-                    control_flow_type = 'if'
-                    current_cmd.append(part)
-                elif part.startswith('for '):
-                    in_control_flow = True
-                    control_flow_type = 'for'
-                    current_cmd.append(part)
-                # Check if we're in a control flow statement
-                elif in_control_flow:
-                    current_cmd.append(part)
-                    # Check if this part ends the control flow statement
-                    if (control_flow_type == 'if' and 'fi' in part) or \
-                       (control_flow_type == 'for' and 'done' in part):
-                        # Complete control flow statement
-                        commands.append('; '.join(current_cmd))
-                        current_cmd = []
-                        in_control_flow = False
-                        control_flow_type = None
-                else:
-                    # Regular command
-                    if current_cmd:
-                        commands.append('; '.join(current_cmd))
-                        current_cmd = []
-                    commands.append(part)
-
-            # Add any remaining command
-            if current_cmd:
-                commands.append('; '.join(current_cmd))
-
-            # Execute each command in sequence
-            exit_code = 0
-            for cmd in commands:
-                exit_code = shell.execute(cmd, stdin_data=stdin_data)
-                stdin_data = None  # Only first command gets stdin
-                if exit_code != 0 and exit_code not in [-997, -998, -999]:
-                    # Stop on error (unless it's a special code)
-                    break
-            sys.exit(exit_code)
-        else:
-            # Single command
-            exit_code = shell.execute(command, stdin_data=stdin_data)
-            sys.exit(exit_code)
-
-    elif args.script and os.path.isfile(args.script):
+        exit_code = shell.run_script(command.splitlines(), stdin_data=read_piped_stdin(command))
+        sys.exit(exit_code)
+
+    elif args.script_file or (args.script and os.path.isfile(args.script)):
         # Mode 2: script file
-        exit_code = execute_script_file(shell, args.script)
+        exit_code = execute_script_file(shell, args.script_file or args.script)
         sys.exit(exit_code)
 
     elif args.script:
         # Mode 3: command with arguments
         command_parts = [args.script] + args.args
         command = ' '.join(command_parts)
-        stdin_data = None
-        import re
-        import select
-        has_input_redir = bool(re.search(r'\s<\s', command))
-        if not sys.stdin.isatty() and not has_input_redir:
-            if select.select([sys.stdin], [], [], 0.0)[0]:
-                stdin_data = sys.stdin.buffer.read()
-        exit_code = shell.execute(command, stdin_data=stdin_data)
+        exit_code = shell.run_script([command], stdin_data=read_piped_stdin(command))
         sys.exit(exit_code)
 
     else:
         # Mode 4: Interactive REPL
-        shell.repl()
-
+        sys.exit(shell.repl())
 
 if __name__ == '__main__':
     main()
@@ -91,7 +91,7 @@ class ShellCompleter:
 
         # Get directory listing from AGFS
         try:
-            entries = self.filesystem.list_directory(directory)
+            entries = self.filesystem.list_directory(directory, fields='name')
 
             # Determine if we should return relative or absolute paths
             return_relative = not text.startswith('/')
@@ -1,6 +1,113 @@
 """Configuration management for agfs-shell"""
 
+import configparser
 import os
+from typing import Dict, Optional
+
+
+def default_config_path() -> str:
+    """Return the client config file: $AGFS_CONFIG or ~/.agfs/config"""
+    return os.getenv('AGFS_CONFIG') or os.path.expanduser(os.path.join('~', '.agfs', 'config'))
+
+
+class Context:
+    """A named server to connect to, with its credentials and TLS settings"""
+
+    def __init__(self, name: str, server: str, token: Optional[str] = None, ca_cert: Optional[str] = None,
+                 insecure: bool = False, timeout: Optional[int] = None):
+        self.name = name
+        self.server = server
+        self.token = token
+        self.ca_cert = ca_cert
+        self.insecure = insecure
+        self.timeout = timeout
+
+    @property
+    def verify(self):
+        """TLS verification for requests: False, a CA bundle path, or True"""
+        if self.insecure:
+            return False
+        return os.path.expanduser(self.ca_cert) if self.ca_cert else True
+
+    def __repr__(self):
+        return f"Context(name={self.name}, server={self.server})"
+
+
+class ContextStore:
+    """
+    The contexts of the client config file, an INI file such as
+
+        [settings]
+        current-context = staging
+
+        [context staging]
+        server = https://agfs.staging.example.com
+        token = ...
+        ca-cert = ~/certs/staging-ca.pem
+
+        [context dev]
+        server = http://localhost:8080
+        insecure = true
+        timeout = 60
+    """
+
+    def __init__(self, path: Optional[str] = None):
+        self.path = path or default_config_path()
+        self.contexts: Dict[str, Context] = {}
+        self.current: Optional[str] = None
+
+    @classmethod
+    def load(cls, path: Optional[str] = None) -> 'ContextStore':
+        """Read the config file; a missing file has no contexts"""
+        store = cls(path)
+        parser = configparser.ConfigParser(interpolation=None)
+        try:
+            parser.read(store.path)
+        except configparser.Error as e:
+            raise ValueError(f"{store.path}: {e}")
+        store.current = parser.get('settings', 'current-context', fallback=None) or None
+        for section in parser.sections():
+            if not section.startswith('context '):
+                continue
+            name = section[len('context '):].strip()
+            values = parser[section]
+            try:
+                timeout = values.getint('timeout') if 'timeout' in values else None
+                insecure = values.getboolean('insecure', fallback=False)
+            except ValueError as e:
+                raise ValueError(f"{store.path}: context {name}: {e}")
+            store.contexts[name] = Context(name, values.get('server', ''), values.get('token'),
+                                           values.get('ca-cert'), insecure, timeout)
+        return store
+
+    def get(self, name: str) -> Context:
+        if name not in self.contexts:
+            raise ValueError(f"context {name} not found in {self.path}")
+        return self.contexts[name]
+
+    def save(self):
+        """Write the config file, readable by the user only as it holds tokens"""
+        parser = configparser.ConfigParser(interpolation=None)
+        if self.current:
+            parser['settings'] = {'current-context': self.current}
+        for name in sorted(self.contexts):
+            ctx = self.contexts[name]
+            values = {'server': ctx.server}
+            if ctx.token:
+                values['token'] = ctx.token
+            if ctx.ca_cert:
+                values['ca-cert'] = ctx.ca_cert
+            if ctx.insecure:
+                values['insecure'] = 'true'
+            if ctx.timeout is not None:
+                values['timeout'] = str(ctx.timeout)
+            parser[f'context {name}'] = values
+        directory = os.path.dirname(self.path)
+        if directory:
+            os.makedirs(directory, mode=0o700, exist_ok=True)
+        fd = os.open(self.path, os.O_WRONLY | os.O_CREAT | os.O_TRUNC, 0o600)
+        with os.fdopen(fd, 'w') as f:
+            parser.write(f)
 
 
 class Config:
@@ -20,20 +127,50 @@ class Config:
         except ValueError:
             self.timeout = 30
 
+        # Bearer token for servers with tenants, and TLS verification
+        self.token = os.getenv('AGFS_TOKEN') or None
+        self.verify = True
+        self.context = None  # Name of the context in use, if any
+
     @classmethod
     def from_env(cls):
         """Create configuration from environment variables"""
         return cls()
 
     @classmethod
-    def from_args(cls, server_url: str = None, timeout: int = None):
-        """Create configuration from command line arguments"""
+    def from_args(cls, server_url: str = None, timeout: int = None, context: str = None,
+                  token: str = None, config_path: str = None):
+        """
+        Create configuration from command line arguments
+
+        A context named by --context or $AGFS_CONTEXT overrides the environment; the
+        current context of the config file applies unless $AGFS_API_URL is set.
+        Explicit --agfs-api-url, --timeout and --token override both.
+        Raises ValueError if the named context does not exist.
+        """
         config = cls()
+        store = ContextStore.load(config_path)
+        name = context or os.getenv('AGFS_CONTEXT')
+        if not name and not (os.getenv('AGFS_API_URL') or os.getenv('AGFS_SERVER_URL')):
+            name = store.current if store.current in store.contexts else None
+        if name:
+            config.use(store.get(name))
         if server_url:
             config.server_url = server_url
         if timeout is not None:
             config.timeout = timeout
+        if token:
+            config.token = token
         return config
 
+    def use(self, ctx: Context):
+        """Take the server and settings of a context"""
+        self.context = ctx.name
+        self.server_url = ctx.server
+        if ctx.timeout is not None:
+            self.timeout = ctx.timeout
+        self.token = ctx.token
+        self.verify = ctx.verify
+
     def __repr__(self):
-        return f"Config(server_url={self.server_url}, timeout={self.timeout})"
+        return f"Config(server_url={self.server_url}, timeout={self.timeout}, context={self.context})"
@@ -8,7 +8,8 @@ from pyagfs import AGFSClient, AGFSClientError
 class AGFSFileSystem:
     """Abstraction layer for AGFS file system operations"""
 
-    def __init__(self, server_url: str = "http://localhost:8080", timeout: int = 30):
+    def __init__(self, server_url: str = "http://localhost:8080", timeout: int = 30,
+                 token: Optional[str] = None, verify=True):
         """
         Initialize AGFS file system
 
@@ -17,9 +18,15 @@ class AGFSFileSystem:
             timeout: Request timeout in seconds (default: 30)
                     - Increased from 5 to 30 for better support of large file transfers
                     - Each 8KB chunk upload/download should complete within this time
+            token: Bearer token for servers with tenants
+            verify: TLS verification: True, False, or the path of a CA bundle
         """
+        self.connect(server_url, timeout=timeout, token=token, verify=verify)
+
+    def connect(self, server_url: str, timeout: int = 30, token: Optional[str] = None, verify=True):
+        """Switch to another server, e.g. for another client context"""
         self.server_url = server_url
-        self.client = AGFSClient(server_url, timeout=timeout)
+        self.client = AGFSClient(server_url, timeout=timeout, token=token, verify=verify)
         self._connected = False
 
     def check_connection(self) -> bool:
@@ -166,12 +173,13 @@ class AGFSFileSystem:
         except AGFSClientError:
             return False
 
-    def list_directory(self, path: str):
+    def list_directory(self, path: str, fields: Optional[str] = None):
         """
         List directory contents
 
         Args:
             path: Directory path in AGFS
+            fields: Comma-separated entry fields to fetch (default: all), e.g. "name"
 
         Returns:
             List of file info dicts
@@ -180,6 +188,8 @@ class AGFSFileSystem:
             AGFSClientError: If directory cannot be listed
         """
         try:
+            if fields:
+                return self.client.ls(path, fields=fields)
             return self.client.ls(path)
         except AGFSClientError as e:
             # SDK error already includes path, don't duplicate it
@@ -35,6 +35,73 @@ class CommandParser:
 
         return commands, redirections
 
+    @staticmethod
+    def split_command_list(command_line: str) -> List[Tuple[str, str]]:
+        """
+        Split a command line into the pipelines of a command list
+
+        Pipelines are separated by ';', '&&' or '||'. Separators inside quotes,
+        $(...) and if/fi or for/done blocks do not split the line.
+
+        Args:
+            command_line: Command line string (e.g., "cp /a /b && rm /a; ls")
+
+        Returns:
+            List of (operator, pipeline) tuples, where operator is the separator
+            before the pipeline ('' for the first one)
+
+        Example:
+            >>> parser.split_command_list("cp /a /b && rm /a; ls")
+            [('', 'cp /a /b'), ('&&', 'rm /a'), (';', 'ls')]
+        """
+        parts = []
+        operator = ''
+        start = 0
+        quote = None
+        parens = 0
+        blocks = 0
+        i = 0
+        while i < len(command_line):
+            c = command_line[i]
+            if quote:
+                if c == '\\' and quote == '"':
+                    i += 1
+                elif c == quote:
+                    quote = None
+                i += 1
+                continue
+            if c == '\\':
+                i += 2
+                continue
+            if c in ('"', "'"):
+                quote = c
+            elif command_line.startswith('$(', i):
+                parens += 1
+                i += 1
+            elif c == ')' and parens:
+                parens -= 1
+            elif not parens:
+                # Keywords only count where a command starts
+                word = re.match(r'(if|for|fi|done)\b', command_line[i:])
+                if word and re.search(r'(^|[;&|\n]|\b(then|do|else))\s*$', command_line[:i]):
+                    blocks = blocks + 1 if word.group(1) in ('if', 'for') else max(blocks - 1, 0)
+                    i += len(word.group(1))
+                    continue
+                separator = None
+                if command_line.startswith(('&&', '||'), i):
+                    separator = command_line[i:i + 2]
+                elif c == ';':
+                    separator = c
+                if separator and not blocks:
+                    parts.append((operator, command_line[start:i].strip()))
+                    operator = separator
+                    i += len(separator)
+                    start = i
+                    continue
+            i += 1
+        parts.append((operator, command_line[start:].strip()))
+        return [(op, part) for op, part in parts if part]
+
     @staticmethod
     def parse_pipeline(command_line: str) -> List[Tuple[str, List[str]]]:
         """
@@ -53,8 +120,15 @@ class CommandParser:
         if not command_line.strip():
             return []
 
-        # Split by pipe symbol
-        pipeline_parts = command_line.split('|')
+        # Split by pipe symbol, outside quotes
+        masked = CommandParser._mask_quoted(command_line)
+        pipeline_parts = []
+        start = 0
+        for i, c in enumerate(masked):
+            if c == '|':
+                pipeline_parts.append(command_line[start:i])
+                start = i + 1
+        pipeline_parts.append(command_line[start:])
 
         commands = []
         for part in pipeline_parts:
@@ -101,12 +175,14 @@ class CommandParser:
             (r'\s+>\s+(\S+)', 'stdout', 'write'),           # > file (output)
         ]
 
+        # Operators are looked for outside quotes, the spans found apply to both lines
         cleaned_line = command_line
+        masked_line = CommandParser._mask_quoted(command_line)
 
         for pattern, redirect_type, mode in patterns:
-            match = re.search(pattern, cleaned_line)
+            match = re.search(pattern, masked_line)
             if match:
-                filename = match.group(1)
+                filename = cleaned_line[match.start(1):match.end(1)]
                 # Remove quotes if present
                 if (filename.startswith('"') and filename.endswith('"')) or \
                    (filename.startswith("'") and filename.endswith("'")):
@@ -118,9 +194,29 @@ class CommandParser:
 
                 # Remove the redirection from the command line
                 cleaned_line = cleaned_line[:match.start()] + cleaned_line[match.end():]
+                masked_line = masked_line[:match.start()] + masked_line[match.end():]
 
         return cleaned_line.strip(), redirections
 
+    @staticmethod
+    def _mask_quoted(command_line: str) -> str:
+        """
+        Return command_line with the characters between quotes replaced by '_', so
+        that the pipes and redirections they contain are not taken for operators
+        """
+        masked = []
+        quote = None
+        for c in command_line:
+            if quote and c != quote:
+                masked.append('_')
+                continue
+            if quote:
+                quote = None
+            elif c in ('"', "'"):
+                quote = c
+            masked.append(c)
+        return ''.join(masked)
+
     @staticmethod
     def quote_arg(arg: str) -> str:
         """Quote an argument if it contains spaces or special characters"""
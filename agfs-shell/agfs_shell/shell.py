@@ -14,14 +14,25 @@ from .command_decorators import CommandMetadata
 from pyagfs import AGFSClientError
 
 
+class ShellExit(Exception):
+    """Raised by exit, or by a failing command under set -e, to end the shell"""
+
+    def __init__(self, code: int):
+        super().__init__(code)
+        self.code = code
+
+
 class Shell:
     """Simple shell with pipeline support"""
 
-    def __init__(self, server_url: str = "http://localhost:8080", timeout: int = 30):
+    def __init__(self, server_url: str = "http://localhost:8080", timeout: int = 30,
+                 token: str = None, verify=True, context: str = None):
         self.parser = CommandParser()
         self.running = True
-        self.filesystem = AGFSFileSystem(server_url, timeout=timeout)
+        self.filesystem = AGFSFileSystem(server_url, timeout=timeout, token=token, verify=verify)
         self.server_url = server_url
+        self.timeout = timeout
+        self.context = context  # Name of the client context in use, if any
         self.cwd = '/'  # Current working directory
         self.console = Console(highlight=False)  # Rich console for output
         self.multiline_buffer = []  # Buffer for multiline input
@@ -34,6 +45,8 @@ class Shell:
         self.env['HISTFILE'] = os.path.join(home, ".agfs_shell_history")
 
         self.interactive = False  # Flag to indicate if running in interactive REPL mode
+        self.errexit = False  # set -e: exit when a command fails
+        self._conditions = 0  # Depth of conditions being evaluated, exempt from set -e
 
     def _execute_command_substitution(self, command: str) -> str:
         """
@@ -107,9 +120,21 @@ class Shell:
         """
         Expand environment variables and command substitutions in text
         Supports: $VAR, ${VAR}, $(command), `command`, and $? (exit code)
+
+        Like in sh, text in single quotes is left as is, so that commands such as
+        watch get the variables they set themselves, e.g. 'cp $WATCH_PATH /bak'
         """
         import re
 
+        # Command substitutions and double quotes are matched first, so that the
+        # single quotes they contain do not count
+        parts = re.split(r"""(\$\([^)]*\)|`[^`]*`|"[^"]*"|'[^']*')""", text)
+        return ''.join(part if part.startswith("'") else self._expand_text(part) for part in parts)
+
+    def _expand_text(self, text: str) -> str:
+        """Expand the variables and command substitutions of text outside single quotes"""
+        import re
+
         # First, expand special variables like $?
         # $? - exit code of last command
         text = text.replace('$?', self.env.get('?', '0'))
@@ -484,8 +509,12 @@ class Shell:
 
         # Evaluate conditions in order
         for condition_cmd, commands_block in parsed['conditions']:
-            # Execute the condition command
-            exit_code = self.execute(condition_cmd)
+            # Execute the condition command, which may fail under set -e
+            self._conditions += 1
+            try:
+                exit_code = self.execute(condition_cmd)
+            finally:
+                self._conditions -= 1
 
             # If condition is true (exit code 0), execute this block
             if exit_code == 0:
@@ -601,7 +630,7 @@ class Shell:
 
     def execute(self, command_line: str, stdin_data: Optional[bytes] = None, heredoc_data: Optional[bytes] = None) -> int:
         """
-        Execute a command line (possibly with pipelines and redirections)
+        Execute a command line (possibly a command list of pipelines with redirections)
 
         Args:
             command_line: Command string to execute
@@ -609,8 +638,57 @@ class Shell:
             heredoc_data: Optional heredoc data (for << redirections)
 
         Returns:
-            Exit code of the pipeline
+            Exit code of the last pipeline executed
+
+        Raises:
+            ShellExit: On exit, or when a command fails under set -e
+        """
+        parts = self.parser.split_command_list(command_line)
+        if len(parts) > 1:
+            return self.execute_list(parts, stdin_data, heredoc_data)
+
+        exit_code = self._execute_pipeline(command_line, stdin_data, heredoc_data)
+        if self.errexit and exit_code > 0 and not self._conditions:
+            raise ShellExit(exit_code)
+        return exit_code
+
+    def execute_list(self, parts: List[tuple], stdin_data: Optional[bytes] = None, heredoc_data: Optional[bytes] = None) -> int:
+        """
+        Execute a command list such as: cp /a /b && rm /a; ls
+
+        Like in sh, the pipeline after && only runs if the previous one succeeded and the
+        one after || only if it failed. Under set -e, only the failure of the last pipeline
+        of an && / || chain exits.
+
+        Args:
+            parts: (operator, pipeline) tuples from CommandParser.split_command_list
+            stdin_data: Optional stdin data to provide to the first pipeline
+            heredoc_data: Optional heredoc data to provide to the first pipeline
+
+        Returns:
+            Exit code of the last pipeline executed
         """
+        exit_code = 0
+        for i, (operator, pipeline) in enumerate(parts):
+            if (operator == '&&' and exit_code != 0) or (operator == '||' and exit_code == 0):
+                continue
+            chained = i + 1 < len(parts) and parts[i + 1][0] in ('&&', '||')
+            if chained:
+                self._conditions += 1
+            try:
+                exit_code = self.execute(pipeline, stdin_data=stdin_data, heredoc_data=heredoc_data)
+            finally:
+                if chained:
+                    self._conditions -= 1
+            stdin_data = heredoc_data = None
+            if exit_code < 0:
+                # More input is needed (for, if or heredoc)
+                return exit_code
+            self.env['?'] = str(exit_code)
+        return exit_code
+
+    def _execute_pipeline(self, command_line: str, stdin_data: Optional[bytes] = None, heredoc_data: Optional[bytes] = None) -> int:
+        """Execute a single pipeline with redirections, or a for loop or if statement"""
         # Check for for loop (special handling required)
         if command_line.strip().startswith('for '):
             # Check if it's a complete single-line for loop
@@ -650,16 +728,15 @@ class Shell:
                 var_name = parts[0].strip()
                 # Check if it's a valid variable name (not a command with = in args)
                 if var_name and var_name.replace('_', '').isalnum() and not ' ' in var_name:
-                    var_value = parts[1].strip()
+                    # Expand variables before removing the quotes, which keep
+                    # single-quoted values as they are
+                    var_value = self._expand_variables(parts[1].strip())
 
                     # Remove outer quotes if present (both single and double)
                     if len(var_value) >= 2:
                         if (var_value[0] == '"' and var_value[-1] == '"') or \
                            (var_value[0] == "'" and var_value[-1] == "'"):
                             var_value = var_value[1:-1]
-
-                    # Expand variables after removing quotes
-                    var_value = self._expand_variables(var_value)
                     self.env[var_name] = var_value
                     return 0
 
@@ -685,6 +762,18 @@ class Shell:
         if not commands:
             return 0
 
+        # exit and set change the state of the shell itself
+        if len(commands) == 1 and commands[0][0] == 'exit':
+            args = commands[0][1]
+            try:
+                code = int(args[0]) if args else int(self.env.get('?', '0'))
+            except ValueError:
+                self.console.print(f"[red]exit: {args[0]}: numeric argument required[/red]", highlight=False)
+                code = 2
+            raise ShellExit(code & 0xFF)
+        if len(commands) == 1 and commands[0][0] == 'set':
+            return self._set_options(commands[0][1])
+
         # Special handling for cd command (must be a single command, not in pipeline)
         # Using metadata instead of hardcoded check
         if len(commands) == 1 and CommandMetadata.changes_cwd(commands[0][0]):
@@ -768,6 +857,10 @@ class Shell:
             )
             # Pass cwd to process for pwd command
             process.cwd = self.cwd
+            # Commands such as watch run command lines of their own, ctx switches servers
+            process.run_command = self.run_command
+            process.use_context = self.use_context
+            process.context = self.context
             processes.append(process)
 
         # Special case: direct streaming from stdin to file
@@ -908,10 +1001,123 @@ class Shell:
 
         return exit_code
 
-    def repl(self):
-        """Run interactive REPL"""
+    def use_context(self, ctx) -> None:
+        """Connect to the server of a client context, see config.Context"""
+        timeout = ctx.timeout if ctx.timeout is not None else self.timeout
+        self.filesystem.connect(ctx.server, timeout=timeout, token=ctx.token, verify=ctx.verify)
+        self.server_url = ctx.server
+        self.context = ctx.name
+
+    def run_command(self, command_line: str) -> int:
+        """
+        Execute a command line on behalf of a builtin, such as the per-event command of watch
+
+        A failure or exit is returned as exit code instead of ending the shell.
+        """
+        self._conditions += 1
+        try:
+            return self.execute(command_line)
+        except ShellExit as e:
+            return e.code
+        finally:
+            self._conditions -= 1
+
+    def _set_options(self, args: List[str]) -> int:
+        """
+        Set shell options: set -e / set +e, or set -o errexit / set +o errexit
+
+        Without arguments, print the options that are set.
+        """
+        if not args:
+            if self.errexit:
+                self.console.print("set -e", highlight=False)
+            return 0
+        i = 0
+        while i < len(args):
+            arg = args[i]
+            if arg in ('-o', '+o') and i + 1 < len(args) and args[i + 1] == 'errexit':
+                self.errexit = arg == '-o'
+                i += 2
+                continue
+            if len(arg) > 1 and arg[0] in '-+' and set(arg[1:]) == {'e'}:
+                self.errexit = arg[0] == '-'
+                i += 1
+                continue
+            self.console.print(f"[red]set: {arg}: invalid option[/red]", highlight=False)
+            self.console.print("usage: set [-e|+e] [-o errexit|+o errexit]", highlight=False)
+            return 2
+        return 0
+
+    def run_script(self, lines: List[str], stop_on_error: bool = False, stdin_data: Optional[bytes] = None) -> int:
+        """
+        Run the lines of a script, collecting multi-line for loops and if statements
+
+        Args:
+            lines: Lines of the script
+            stop_on_error: Stop at the first failing command, like sh -e
+            stdin_data: Optional stdin data to provide to the first command
+
+        Returns:
+            Exit code of the last command, or the code given to exit
+        """
+        self.errexit = self.errexit or stop_on_error
+        exit_code = 0
+        i = 0
+        try:
+            while i < len(lines):
+                line = lines[i].strip()
+                line_num = i + 1
+                i += 1
+
+                # Skip empty lines and comments
+                if not line or line.startswith('#'):
+                    continue
+
+                exit_code = self.execute(line, stdin_data=stdin_data)
+                stdin_data = None
+                if exit_code == -997 or exit_code == -998:
+                    # Collect the rest of the for/do/done loop or if/then/fi statement
+                    opening, closing = ('for ', 'done') if exit_code == -997 else ('if ', 'fi')
+                    block = [line]
+                    depth = 1
+                    while i < len(lines) and depth:
+                        next_line = lines[i].strip()
+                        block.append(next_line)
+                        if next_line.startswith(opening):
+                            depth += 1
+                        elif next_line == closing:
+                            depth -= 1
+                        i += 1
+                    if exit_code == -997:
+                        exit_code = self.execute_for_loop(block)
+                    else:
+                        exit_code = self.execute_if_statement(block)
+                    if self.errexit and exit_code > 0:
+                        raise ShellExit(exit_code)
+                elif exit_code == -999:
+                    # Heredoc: the content follows up to the delimiter
+                    commands, redirections = self.parser.parse_command_line(line)
+                    delimiter = redirections.get('heredoc_delimiter')
+                    content = []
+                    while i < len(lines) and lines[i].strip() != delimiter:
+                        content.append(lines[i].rstrip('\n'))
+                        i += 1
+                    i += 1
+                    heredoc = ''.join(c + '\n' for c in content)
+                    exit_code = self.execute(line, heredoc_data=heredoc.encode('utf-8'))
+                self.env['?'] = str(exit_code)
+        except ShellExit as e:
+            return e.code
+        except Exception as e:
+            sys.stderr.write(f"Error at line {line_num}: {str(e)}\n")
+            return 1
+        return exit_code
+
+    def repl(self) -> int:
+        """Run interactive REPL, returning the exit code given to exit"""
         # Set interactive mode flag
         self.interactive = True
+        exit_status = 0
         self.console.print("""     __  __ __ 
  /\\ / _ |_ (_  
 /--\\\\__)|  __) 
@@ -924,7 +1130,8 @@ class Shell:
             self.console.print("Make sure the server is running.", highlight=False)
             sys.exit(1)
 
-        self.console.print(f"Connected to AGFS server at [green]{self.server_url}[/green]", highlight=False)
+        context = f" (context [cyan]{self.context}[/cyan])" if self.context else ""
+        self.console.print(f"Connected to AGFS server at [green]{self.server_url}[/green]{context}", highlight=False)
         self.console.print("Type [cyan]'help'[/cyan] for help, [cyan]Ctrl+D[/cyan] or [cyan]'exit'[/cyan] to quit", highlight=False)
         self.console.print(highlight=False)
 
@@ -1076,7 +1283,7 @@ class Shell:
                     continue
 
                 # Handle special commands
-                if command in ('exit', 'quit'):
+                if command == 'quit':
                     break
                 elif command == 'help':
                     self.show_help()
@@ -1180,6 +1387,9 @@ class Shell:
                         if exit_code not in [-998, -999]:
                             self.env['?'] = str(exit_code)
 
+                except ShellExit as e:
+                    exit_status = e.code
+                    break
                 except KeyboardInterrupt:
                     # Ctrl+C during command execution - interrupt command
                     self.console.print("\n^C", highlight=False)
@@ -1205,6 +1415,7 @@ class Shell:
                 self.console.print(f"[yellow]Warning: Could not save history: {e}[/yellow]", highlight=False)
 
         self.console.print("[cyan]Goodbye![/cyan]", highlight=False)
+        return exit_status
 
     def show_help(self):
         """Show help message"""
@@ -32,6 +32,36 @@ class TestBuiltins(unittest.TestCase):
         self.assertEqual(cmd(proc), 0)
         self.assertEqual(proc.get_stdout(), input_data.encode('utf-8'))
 
+    def test_cat_pretty_json(self):
+        from unittest import mock
+        from agfs_shell import builtins
+
+        class FileSystem:
+            def get_file_info(self, path):
+                formats = {"/q/dequeue": "json", "/q/size": "text"}
+                return {"name": path, "meta": {"Name": "queuefs", "Content": {"format": formats[path]}}}
+
+            def read_file(self, path, stream=False):
+                data = b'{"id":"1","data":"hi"}' if path == "/q/dequeue" else b"3"
+                return iter([data]) if stream else data
+
+        with mock.patch.object(builtins, '_is_terminal', return_value=True):
+            proc = self.create_process("cat", ["/q/dequeue"])
+            proc.filesystem = FileSystem()
+            self.assertEqual(BUILTINS['cat'](proc), 0)
+            self.assertEqual(proc.get_stdout(), b'{\n  "id": "1",\n  "data": "hi"\n}\n')
+
+            proc = self.create_process("cat", ["/q/size"])
+            proc.filesystem = FileSystem()
+            self.assertEqual(BUILTINS['cat'](proc), 0)
+            self.assertEqual(proc.get_stdout(), b"3")
+
+        # Output to pipes and files stays as the server returns it
+        proc = self.create_process("cat", ["/q/dequeue"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['cat'](proc), 0)
+        self.assertEqual(proc.get_stdout(), b'{"id":"1","data":"hi"}')
+
     def test_cat_file(self):
         cmd = BUILTINS['cat']
         with tempfile.TemporaryDirectory() as tmpdir:
@@ -143,5 +173,426 @@ class TestBuiltins(unittest.TestCase):
         self.assertEqual(cmd(proc), 1)
         self.assertIn(b"missing operand", proc.get_stderr())
 
+    def test_status(self):
+        cmd = BUILTINS['status']
+        status = {
+            "status": "degraded", "version": "1.2.0", "gitCommit": "abc123", "buildTime": "today",
+            "startedAt": "2026-01-01T00:00:00Z", "uptimeSeconds": 3725,
+            "summary": {"configured": 2, "active": 1, "failed": 1, "pending": 0},
+            "configuredMounts": [
+                {"path": "/memfs", "plugin": "memfs", "instance": "memfs", "state": "active"},
+                {"path": "/sqlfs", "plugin": "sqlfs", "instance": "db", "state": "failed", "error": "no backend"},
+            ],
+            "activeMounts": [{"path": "/memfs", "plugin": "memfs"}],
+            "externalPlugins": [],
+            "listeners": [{"protocol": "http", "address": ":8080"}],
+        }
+
+        class Client:
+            def status(self):
+                return status
+
+        class FileSystem:
+            client = Client()
+
+        proc = self.create_process("status", [])
+        proc.filesystem = FileSystem()
+        self.assertEqual(cmd(proc), 1)  # A configured mount failed
+        out = proc.get_stdout().decode()
+        self.assertIn("AGFS server 1.2.0 (commit abc123", out)
+        self.assertIn("Uptime:     1h 2m 5s", out)
+        self.assertIn("Listening:  http :8080", out)
+        self.assertIn("1 active, 1 failed, 0 pending (2 configured)", out)
+        self.assertIn("/sqlfs  sqlfs  failed: no backend", out)
+
+        proc = self.create_process("status", ["--json"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(cmd(proc), 0)
+        self.assertIn(b'"failed": 1', proc.get_stdout())
+
+    def test_mount_wizard(self):
+        cmd = BUILTINS['mount']
+        mounts = []
+
+        class Client:
+            def plugin_types(self):
+                return [
+                    {"name": "memfs", "hasSchema": False},
+                    {"name": "sqlfs", "hasSchema": True, "schema": [
+                        {"name": "backend", "type": "string", "choices": ["sqlite", "tidb"], "default": "sqlite"},
+                        {"name": "password", "type": "string", "secret": True},
+                        {"name": "cache_max_size", "type": "int", "default": "1000"},
+                    ]},
+                ]
+
+            def mount(self, fstype, path, config, dry_run=False):
+                if config.get("cache_max_size", 1) < 1:
+                    raise Exception("cache_max_size must be positive")
+                mounts.append((fstype, path, config, dry_run))
+
+        class FileSystem:
+            client = Client()
+
+        # Choice by number, an invalid answer, a rejected configuration entered again
+        answers = "2\n/db\nmysql\ntidb\nhunter2\n0\n\n\ny\n\nsecret\nten\n5\nstats=true\n\n\n"
+        proc = self.create_process("mount", ["--wizard"], answers)
+        proc.filesystem = FileSystem()
+        self.assertEqual(cmd(proc), 0)
+        out = proc.get_stdout().decode()
+        self.assertIn("2) sqlfs *", out)
+        self.assertIn("invalid backend: must be one of: sqlite, tidb", out)
+        self.assertIn("Invalid configuration: cache_max_size must be positive", out)
+        self.assertIn("invalid cache_max_size", out)
+        self.assertIn("mount sqlfs /db password=*** cache_max_size=5 stats=True", out)
+        self.assertNotIn("secret", out)
+        self.assertEqual(mounts[-1], ("sqlfs", "/db", {"password": "secret", "cache_max_size": 5, "stats": True}, False))
+        self.assertEqual([m[3] for m in mounts], [True, False])  # Validated before mounting
+
+        # Running out of answers aborts without mounting
+        mounts.clear()
+        proc = self.create_process("mount", ["--wizard", "memfs"], "/mem\n")
+        proc.filesystem = FileSystem()
+        self.assertEqual(cmd(proc), 1)
+        self.assertIn(b"wizard aborted", proc.get_stderr())
+        self.assertEqual(mounts, [])
+
+    def test_watch(self):
+        cmd = BUILTINS['watch']
+        events = [
+            {"type": "create", "path": "/mem/a", "time": "t1"},
+            {"type": "write", "path": "/mem/a", "time": "t2"},
+            {"type": "rename", "path": "/mem/b", "oldPath": "/mem/a", "time": "t3"},
+        ]
+        calls = []
+
+        class Client:
+            def watch(self, path, recursive=False, poll_interval=1.0):
+                calls.append((path, recursive, poll_interval))
+                return iter(events)
+
+        class FileSystem:
+            client = Client()
+
+        proc = self.create_process("watch", ["-r", "-n", "0.5", "mem"])
+        proc.filesystem = FileSystem()
+        proc.cwd = "/"
+        self.assertEqual(cmd(proc), 0)
+        self.assertEqual(calls[-1], ("/mem", True, 0.5))
+        self.assertEqual(proc.get_stdout(), b"t1 create /mem/a\nt2 write /mem/a\nt3 rename /mem/a -> /mem/b\n")
+
+        # Filtered, limited, with a command per event
+        ran = []
+        proc = self.create_process("watch", ["-t", "write,rename", "-c", "1", "/mem", "cp", "$WATCH_PATH", "/bak"])
+        proc.filesystem = FileSystem()
+        proc.run_command = lambda line: ran.append((line, proc.env["WATCH_EVENT"], proc.env["WATCH_PATH"])) or 0
+        self.assertEqual(cmd(proc), 0)
+        self.assertEqual(ran, [("cp $WATCH_PATH /bak", "write", "/mem/a")])
+
+        proc = self.create_process("watch", [])
+        proc.filesystem = FileSystem()
+        self.assertEqual(cmd(proc), 2)
+
+    def test_chunked_write_and_cat(self):
+        import hashlib
+        files = {}
+        requests = []
+
+        class Client:
+            def stat(self, path):
+                if path not in files:
+                    raise Exception(f"{path}: not found")
+                return {"size": len(files[path]), "isDir": False}
+
+            def write(self, path, data):
+                requests.append(("write", 0, len(data)))
+                files[path] = bytes(data)
+
+            def write_at(self, path, data, offset):
+                requests.append(("write_at", offset, len(data)))
+                current = files.get(path, b"")
+                files[path] = current[:offset] + data + current[offset + len(data):]
+                return {"size": len(files[path])}
+
+            def read_range(self, path, offset, size=-1):
+                data = files[path]
+                return data[offset:offset + size], len(data)
+
+            def digest(self, path, algorithm):
+                return {"digest": hashlib.md5(files[path]).hexdigest()}
+
+        class FileSystem:
+            client = Client()
+
+        data = bytes(range(256)) * 40
+        with tempfile.TemporaryDirectory() as tmpdir:
+            local = os.path.join(tmpdir, "data.bin")
+            with open(local, "wb") as f:
+                f.write(data)
+
+            proc = self.create_process("write", ["--chunk-size", "4K", "--progress", "big", local])
+            proc.filesystem = FileSystem()
+            proc.cwd = "/mem"
+            self.assertEqual(BUILTINS['write'](proc), 0)
+            self.assertEqual(files["/mem/big"], data)
+            self.assertEqual(requests, [("write", 0, 4096), ("write_at", 4096, 4096), ("write_at", 8192, 2048)])
+            self.assertIn(b"100%", proc.get_stderr())
+
+            # Resume after an interruption
+            files["/mem/big"] = data[:5000]
+            requests.clear()
+            proc = self.create_process("write", ["--resume", "--chunk-size=4K", "/mem/big", local])
+            proc.filesystem = FileSystem()
+            self.assertEqual(BUILTINS['write'](proc), 0)
+            self.assertEqual(files["/mem/big"], data)
+            self.assertEqual(requests, [("write_at", 5000, 4096), ("write_at", 9096, 1144)])
+
+            # The checksum catches a corrupted prefix
+            files["/mem/big"] = b"x" + data[1:5000]
+            proc = self.create_process("write", ["--resume", "/mem/big", local])
+            proc.filesystem = FileSystem()
+            self.assertEqual(BUILTINS['write'](proc), 1)
+            self.assertIn(b"checksum mismatch", proc.get_stderr())
+
+        proc = self.create_process("write", ["/mem/small"], "from stdin")
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['write'](proc), 0)
+        self.assertEqual(files["/mem/small"], b"from stdin")
+
+        files["/mem/big"] = data
+        proc = self.create_process("cat", ["--chunk-size=3K", "--verify", "--limit-rate=100M", "/mem/big"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['cat'](proc), 0)
+        self.assertEqual(proc.get_stdout(), data)
+
+        proc = self.create_process("cat", ["--offset=10000", "/mem/big"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['cat'](proc), 0)
+        self.assertEqual(proc.get_stdout(), data[10000:])
+
+        proc = self.create_process("cat", ["--limit-rate", "/mem/big"])
+        self.assertEqual(BUILTINS['cat'](proc), 2)
+
+    def test_diff(self):
+        import hashlib
+        files = {
+            "/a/same.txt": b"same\n",
+            "/a/text.txt": b"one\ntwo\nthree\n",
+            "/a/only_a": b"",
+            "/a/bin": b"\0\1\2",
+            "/b/same.txt": b"same\n",
+            "/b/text.txt": b"one\n2\nthree\n",
+            "/b/bin": b"\0\1\3",
+        }
+        digests = []
+
+        class Client:
+            def stat(self, path):
+                if path in files:
+                    return {"size": len(files[path]), "isDir": False}
+                if any(f.startswith(path + "/") for f in files):
+                    return {"size": 0, "isDir": True}
+                raise Exception(f"{path}: not found")
+
+            def ls(self, path):
+                return [{"name": f[len(path) + 1:], "size": len(data), "isDir": False}
+                        for f, data in files.items() if os.path.dirname(f) == path]
+
+            def cat(self, path):
+                return files[path]
+
+            def digest(self, path, algorithm):
+                digests.append(algorithm)
+                return {"digest": hashlib.md5(files[path]).hexdigest()}
+
+        class FileSystem:
+            client = Client()
+
+        def run(*args):
+            proc = self.create_process("diff", list(args))
+            proc.filesystem = FileSystem()
+            proc.cwd = "/"
+            return BUILTINS['diff'](proc), proc.get_stdout().decode()
+
+        self.assertEqual(run("/a/same.txt", "b/same.txt"), (0, ""))
+        self.assertEqual(digests, ["xxh3", "xxh3"])
+        status, out = run("/a/text.txt", "/b/text.txt")
+        self.assertEqual(status, 1)
+        self.assertIn("--- /a/text.txt\n+++ /b/text.txt\n", out)
+        self.assertIn("-two\n+2\n", out)
+        self.assertEqual(run("/a/bin", "/b/bin"), (1, "Binary files /a/bin and /b/bin differ\n"))
+
+        status, out = run("-q", "/a", "/b")
+        self.assertEqual(status, 1)
+        self.assertEqual(out, "Files /a/bin and /b/bin differ\nOnly in /a: only_a\nFiles /a/text.txt and /b/text.txt differ\n")
+
+        # Local against AGFS, by md5
+        with tempfile.TemporaryDirectory() as tmpdir:
+            for name in ("same.txt", "text.txt", "bin"):
+                with open(os.path.join(tmpdir, name), "wb") as f:
+                    f.write(files["/b/" + name])
+            digests.clear()
+            self.assertEqual(run(f"local:{tmpdir}/same.txt", "/a"), (0, ""))
+            self.assertEqual(digests, ["md5"])
+            self.assertEqual(run("-rq", f"local:{tmpdir}", "/b"), (0, ""))
+
+        self.assertEqual(run("/a/same.txt", "/missing")[0], 2)
+
+    def test_ls_long_and_tree(self):
+        import json
+        tree = {
+            "/d": [
+                {"name": "notes.txt", "size": 12, "mode": 0o644, "modTime": "2025-11-18T22:00:25Z", "isDir": False,
+                 "meta": {"Name": "memfs", "Type": "file", "Content": {"content_type": "text/plain"}}},
+                {"name": "sub", "size": 0, "mode": 0o755, "modTime": "2025-11-18T22:00:25.5+08:00", "isDir": True,
+                 "meta": {"Name": "memfs", "Type": "directory"}},
+            ],
+            "/d/sub": [
+                {"name": "big.bin", "size": 3 * 1024 * 1024, "mode": 0o600, "modTime": "", "isDir": False},
+            ],
+        }
+
+        class FileSystem:
+            def list_directory(self, path):
+                return tree[path]
+
+            def get_file_info(self, path):
+                return {"isDir": True}
+
+        proc = self.create_process("ls", ["-l", "/d"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['ls'](proc), 0)
+        lines = proc.get_stdout().decode().splitlines()
+        self.assertEqual(lines[0], "-rw-r--r-- memfs file            12 2025-11-18 22:00:25 notes.txt")
+        self.assertTrue(lines[1].startswith("drwxr-xr-x memfs directory        0 2025-11-18 22:00:25 "))
+
+        proc = self.create_process("ls", ["--json", "/d"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['ls'](proc), 0)
+        entries = json.loads(proc.get_stdout())
+        self.assertEqual(entries[0]["path"], "/d/notes.txt")
+        self.assertEqual((entries[0]["plugin"], entries[0]["type"], entries[0]["mode"]), ("memfs", "file", "0644"))
+        self.assertEqual(entries[0]["meta"], {"content_type": "text/plain"})
+
+        proc = self.create_process("tree", ["-h", "/d"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['tree'](proc), 0)
+        out = proc.get_stdout().decode()
+        self.assertIn("[ 3.0M]  big.bin", out)
+        self.assertTrue(out.endswith("\n1 directories, 2 files\n"))
+
+        proc = self.create_process("tree", ["--json", "-L", "1", "/d"])
+        proc.filesystem = FileSystem()
+        self.assertEqual(BUILTINS['tree'](proc), 0)
+        root, report = json.loads(proc.get_stdout())
+        self.assertEqual([e["name"] for e in root["contents"]], ["sub", "notes.txt"])
+        self.assertEqual(root["contents"][0]["contents"], [])
+        self.assertEqual(report, {"type": "report", "directories": 1, "files": 1})
+
+    def test_edit(self):
+        import sys
+
+        class Conflict(Exception):
+            status_code = 412
+
+        files = {"/kv/config": b"a: 1\n"}
+        writes = []
+
+        class Client:
+            def read_with_etag(self, path):
+                if path not in files:
+                    raise Exception("No such file or directory")
+                return files[path], f'"{len(files[path])}"'
+
+            def write_conditional(self, path, data, if_match=None, if_none_match=None):
+                writes.append((path, data, if_match, if_none_match))
+                current = f'"{len(files[path])}"' if path in files else None
+                if (if_match and if_match != current) or (if_none_match and current):
+                    raise Conflict("precondition failed")
+                files[path] = data
+
+        class FileSystem:
+            client = Client()
+
+        def edit(path, script):
+            proc = self.create_process("edit", [path])
+            proc.filesystem = FileSystem()
+            proc.cwd = "/kv"
+            proc.env = {"EDITOR": f"{sys.executable} -c \"{script}\""}
+            return BUILTINS['edit'](proc), proc.get_stderr().decode()
+
+        append = "import sys; open(sys.argv[1], 'a').write('b: 2')"
+        self.assertEqual(edit("config", append), (0, ""))
+        self.assertEqual(files["/kv/config"], b"a: 1\nb: 2")
+        self.assertEqual(writes[-1][2:], ('"5"', None))
+
+        # Unchanged files are not written
+        writes.clear()
+        self.assertEqual(edit("/kv/config", "pass"), (0, "edit: /kv/config unchanged\n"))
+        self.assertEqual(writes, [])
+
+        # A concurrent change is not overwritten, the edited copy is kept
+        change = "import sys; open(sys.argv[1], 'a').write('!')"
+        original = Client.read_with_etag
+        Client.read_with_etag = lambda self, path: (files[path], '"stale"')
+        try:
+            status, err = edit("/kv/config", change)
+        finally:
+            Client.read_with_etag = original
+        self.assertEqual(status, 1)
+        self.assertIn("changed by someone else", err)
+        kept = err.strip().split(" ")[-1]
+        with open(kept, "rb") as f:
+            self.assertEqual(f.read(), b"a: 1\nb: 2!")
+        os.unlink(kept)
+
+        # New files are created only if they still don't exist
+        self.assertEqual(edit("/kv/new", "import sys; open(sys.argv[1], 'w').write('x')")[0], 0)
+        self.assertEqual(writes[-1], ("/kv/new", b"x", None, "*"))
+
+        self.assertEqual(edit("/kv/config", "import sys; sys.exit(3)")[0], 1)
+
+    def test_ctx(self):
+        from unittest import mock
+        from agfs_shell.config import Config
+
+        with tempfile.TemporaryDirectory() as tmpdir:
+            path = os.path.join(tmpdir, "agfs", "config")
+            used = []
+
+            def ctx(*args):
+                proc = self.create_process("ctx", list(args))
+                proc.use_context = used.append
+                return BUILTINS['ctx'](proc), proc.get_stdout().decode(), proc.get_stderr().decode()
+
+            with mock.patch.dict(os.environ, {"AGFS_CONFIG": path}, clear=True):
+                self.assertEqual(ctx("set", "prod", "--server", "https://agfs.example.com/", "--token", "secret-token-1234")[0], 0)
+                self.assertEqual(ctx("set", "dev", "--server", "http://localhost:8080", "--insecure", "--timeout", "60")[0], 0)
+                self.assertEqual(os.stat(path).st_mode & 0o777, 0o600)
+                self.assertEqual(ctx("set", "broken")[0], 2)
+
+                status, out, _ = ctx("use", "prod")
+                self.assertEqual((status, out), (0, "Switched to context prod (https://agfs.example.com)\n"))
+                self.assertEqual(used[-1].token, "secret-token-1234")
+                self.assertEqual(ctx(), (0, "  dev   http://localhost:8080\n* prod  https://agfs.example.com\n", ""))
+                out = ctx("show")[1]
+                self.assertIn("token:    ****1234\n", out)
+                self.assertNotIn("secret", out)
+
+                # The current context applies unless the environment names a server
+                config = Config.from_args()
+                self.assertEqual((config.context, config.server_url, config.token), ("prod", "https://agfs.example.com", "secret-token-1234"))
+                config = Config.from_args(context="dev")
+                self.assertEqual((config.server_url, config.timeout, config.verify, config.token), ("http://localhost:8080", 60, False, None))
+                with mock.patch.dict(os.environ, {"AGFS_API_URL": "http://other:8080"}):
+                    self.assertEqual(Config.from_args().server_url, "http://other:8080")
+                    self.assertEqual(Config.from_args(context="prod").server_url, "https://agfs.example.com")
+                self.assertEqual(Config.from_args(server_url="http://flag:1").server_url, "http://flag:1")
+                with self.assertRaises(ValueError):
+                    Config.from_args(context="missing")
+
+                self.assertEqual(ctx("rm", "prod")[0], 0)
+                self.assertEqual(Config.from_args().context, None)
+                self.assertEqual(ctx("use", "prod")[0], 1)
+
 if __name__ == '__main__':
     unittest.main()
@@ -55,6 +55,15 @@ class TestCommandParser(unittest.TestCase):
         self.assertEqual(redirs["stderr"], "error.log")
         self.assertEqual(redirs["stderr_mode"], "write")
 
+    def test_parse_quoted_operators(self):
+        # Pipes and redirections inside quotes are arguments
+        commands, redirs = CommandParser.parse_command_line("watch /mem 'cat $WATCH_PATH | wc -l >> /log' > out")
+        self.assertEqual(commands, [("watch", ["/mem", "cat $WATCH_PATH | wc -l >> /log"])])
+        self.assertEqual(redirs, {"stdout": "out", "stdout_mode": "write"})
+        commands, redirs = CommandParser.parse_command_line('echo "a > b" >> "my log"')
+        self.assertEqual(commands, [("echo", ["a > b"])])
+        self.assertEqual(redirs["stdout"], "my log")
+
     def test_quote_arg(self):
         self.assertEqual(CommandParser.quote_arg("simple"), "simple")
         self.assertEqual(CommandParser.quote_arg("hello world"), "'hello world'")
@@ -65,5 +74,22 @@ class TestCommandParser(unittest.TestCase):
         self.assertEqual(CommandParser.unquote_arg('"world"'), "world")
         self.assertEqual(CommandParser.unquote_arg("simple"), "simple")
 
+    def test_split_command_list(self):
+        self.assertEqual(CommandParser.split_command_list("cp /a /b && rm /a; ls"),
+                         [('', 'cp /a /b'), ('&&', 'rm /a'), (';', 'ls')])
+        self.assertEqual(CommandParser.split_command_list("cat a | grep b||echo none;"),
+                         [('', 'cat a | grep b'), ('||', 'echo none')])
+        self.assertEqual(CommandParser.split_command_list("ls"), [('', 'ls')])
+
+    def test_split_command_list_unsplit(self):
+        # Quotes, command substitutions and if/for blocks are kept whole
+        self.assertEqual(CommandParser.split_command_list('echo "a && b; c"'), [('', 'echo "a && b; c"')])
+        self.assertEqual(CommandParser.split_command_list("x=$(ls; pwd) && echo $x"),
+                         [('', 'x=$(ls; pwd)'), ('&&', 'echo $x')])
+        self.assertEqual(CommandParser.split_command_list("if test -f a; then echo y; fi || echo n"),
+                         [('', 'if test -f a; then echo y; fi'), ('||', 'echo n')])
+        self.assertEqual(CommandParser.split_command_list("for i in a b; do echo $i; done; echo for done"),
+                         [('', 'for i in a b; do echo $i; done'), (';', 'echo for done')])
+
 if __name__ == '__main__':
     unittest.main()
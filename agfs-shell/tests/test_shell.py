@@ -0,0 +1,241 @@
+import json
+import os
+import tempfile
+import unittest
+from unittest import mock
+from agfs_shell import cli
+from agfs_shell.shell import Shell, ShellExit
+
+
+class TestShellScripting(unittest.TestCase):
+    def setUp(self):
+        self.shell = Shell()
+
+    def test_command_list(self):
+        self.assertEqual(self.shell.execute("true && A=1 || B=1"), 0)
+        self.assertEqual(self.shell.execute("false && C=1 || D=1"), 0)
+        self.assertEqual(self.shell.execute("true; false"), 1)
+        self.assertEqual(self.shell.env.get('A'), '1')
+        self.assertNotIn('B', self.shell.env)
+        self.assertNotIn('C', self.shell.env)
+        self.assertEqual(self.shell.env.get('D'), '1')
+
+    def test_exit_code_of_script(self):
+        # Without -e, a failure does not stop the script
+        self.assertEqual(self.shell.run_script(["false", "STATUS=$?", "true"]), 0)
+        self.assertEqual(self.shell.env['STATUS'], '1')
+        self.assertEqual(self.shell.run_script(["true", "false"]), 1)
+        self.assertEqual(self.shell.run_script(["false || exit 3", "AFTER=1"]), 3)
+        self.assertNotIn('AFTER', self.shell.env)
+
+    def test_errexit(self):
+        lines = [
+            "set -e",
+            "false && NOT_RUN=1",  # Failures in && and || chains do not exit
+            "if false; then NOT_RUN=1; fi",  # Nor do failing conditions
+            "REACHED=1",
+            "false",
+            "NOT_RUN=1",
+        ]
+        self.assertEqual(self.shell.run_script(lines), 1)
+        self.assertEqual(self.shell.env.get('REACHED'), '1')
+        self.assertNotIn('NOT_RUN', self.shell.env)
+
+        shell = Shell()
+        self.assertEqual(shell.run_script(["false", "NOT_RUN=1"], stop_on_error=True), 1)
+        self.assertNotIn('NOT_RUN', shell.env)
+
+        shell.execute("set +o errexit")
+        self.assertFalse(shell.errexit)
+        self.assertEqual(shell.execute("set -x"), 2)
+
+    def test_exit(self):
+        with self.assertRaises(ShellExit) as ctx:
+            self.shell.execute("exit 300")
+        self.assertEqual(ctx.exception.code, 300 & 0xFF)
+
+
+class FileSystem:
+    """Stands in for AGFSFileSystem, keeping the files the shell writes"""
+
+    def __init__(self, client=None):
+        self.client = client
+        self.files = {}
+
+    def write_file(self, path, data, append=False):
+        self.files[path] = (self.files.get(path, b"") if append else b"") + data
+        return "OK"
+
+
+class TestShellQuoting(unittest.TestCase):
+    def setUp(self):
+        self.shell = Shell()
+        self.shell.filesystem = FileSystem()
+        self.shell.env['X'] = 'x'
+
+    def test_single_quotes(self):
+        self.shell.execute("echo '$X' \"$X\" $X > /out")
+        self.assertEqual(self.shell.filesystem.files['/out'], b"$X x x\n")
+        self.shell.execute("echo \"it's $X\" > /out")
+        self.assertEqual(self.shell.filesystem.files['/out'], b"it's x\n")
+        self.shell.execute("Y='$X'")
+        self.assertEqual(self.shell.env['Y'], '$X')
+
+    def test_watch_command(self):
+        # The command of watch sees the variables of each event, not the shell's at the start
+        class Client:
+            def watch(self, path, recursive=False, poll_interval=1.0):
+                return iter([{"type": "create", "path": "/mem/a"}, {"type": "write", "path": "/mem/b"}])
+
+        self.shell.filesystem.client = Client()
+        self.assertEqual(self.shell.execute("watch /mem 'echo $WATCH_EVENT $WATCH_PATH >> /log'"), 0)
+        self.assertEqual(self.shell.filesystem.files['/log'], b"create /mem/a\nwrite /mem/b\n")
+
+
+class TestShellCommands(unittest.TestCase):
+    """The commands of the shell, run as command lines with their output redirected"""
+
+    tree = {
+        "/a": [
+            {"name": "same.txt", "size": 5, "mode": 0o644, "modTime": "2025-11-18T22:00:25Z", "isDir": False,
+             "meta": {"Name": "memfs", "Type": "file"}},
+            {"name": "text.txt", "size": 14, "mode": 0o644, "modTime": "2025-11-18T22:00:25Z", "isDir": False,
+             "meta": {"Name": "memfs", "Type": "file"}},
+        ],
+        "/b": [
+            {"name": "same.txt", "size": 5, "mode": 0o644, "modTime": "", "isDir": False},
+            {"name": "text.txt", "size": 12, "mode": 0o644, "modTime": "", "isDir": False},
+        ],
+    }
+    contents = {
+        "/a/same.txt": b"same\n",
+        "/a/text.txt": b"one\ntwo\nthree\n",
+        "/b/same.txt": b"same\n",
+        "/b/text.txt": b"one\n2\nthree\n",
+    }
+
+    def setUp(self):
+        tree, contents = self.tree, self.contents
+
+        class Client:
+            def stat(self, path):
+                if path in tree:
+                    return {"size": 0, "isDir": True}
+                return {"size": len(contents[path]), "isDir": False}
+
+            def ls(self, path):
+                return tree[path]
+
+            def cat(self, path):
+                return contents[path]
+
+            def digest(self, path, algorithm):
+                return {"digest": str(hash(contents[path]))}
+
+        class TreeFileSystem(FileSystem):
+            def list_directory(self, path, fields=None):
+                return tree[path]
+
+            def get_file_info(self, path):
+                return {"isDir": path in tree}
+
+        self.shell = Shell()
+        self.shell.filesystem = TreeFileSystem(Client())
+
+    def output(self, command_line):
+        """Run command_line with its output in /out, and return its exit code and output"""
+        self.shell.filesystem.files.pop('/out', None)
+        code = self.shell.execute(command_line + " > /out")
+        return code, self.shell.filesystem.files.get('/out', b"").decode()
+
+    def test_true_false(self):
+        self.assertEqual(self.shell.execute("true"), 0)
+        self.assertEqual(self.shell.execute("false"), 1)
+        self.assertEqual(self.shell.execute("false; echo $? > /out"), 0)
+        self.assertEqual(self.shell.filesystem.files['/out'], b"1\n")
+
+    def test_diff(self):
+        self.assertEqual(self.output("diff /a/same.txt /b/same.txt"), (0, ""))
+        code, out = self.output("diff /a/text.txt /b/text.txt")
+        self.assertEqual(code, 1)
+        self.assertIn("-two\n+2\n", out)
+        self.assertEqual(self.output("diff -rq /a /b"), (1, "Files /a/text.txt and /b/text.txt differ\n"))
+
+        # The exit code drives command lists
+        self.shell.execute("diff -q /a/same.txt /b/same.txt > /out && SAME=1")
+        self.shell.execute("diff -q /a /b > /out || DIFFERENT=1")
+        self.assertEqual((self.shell.env.get('SAME'), self.shell.env.get('DIFFERENT')), ('1', '1'))
+        self.assertEqual(self.shell.execute("diff /a"), 2)
+
+    def test_ls_and_tree(self):
+        code, out = self.output("ls -l /a")
+        self.assertEqual(code, 0)
+        self.assertEqual(out.splitlines()[0], "-rw-r--r-- memfs file        5 2025-11-18 22:00:25 same.txt")
+        code, out = self.output("ls --json /a")
+        self.assertEqual([(e["path"], e["plugin"]) for e in json.loads(out)], [("/a/same.txt", "memfs"), ("/a/text.txt", "memfs")])
+
+        code, out = self.output("tree -s /a")
+        self.assertEqual(code, 0)
+        self.assertIn("[         14]  text.txt", out)
+        self.assertTrue(out.endswith("0 directories, 2 files\n"))
+        root, report = json.loads(self.output("tree --json /b")[1])
+        self.assertEqual([e["name"] for e in root["contents"]], ["same.txt", "text.txt"])
+        self.assertEqual(report, {"type": "report", "directories": 0, "files": 2})
+
+
+class TestShellContexts(unittest.TestCase):
+    def setUp(self):
+        tmpdir = tempfile.TemporaryDirectory()
+        self.addCleanup(tmpdir.cleanup)
+        self.env = mock.patch.dict(os.environ, {"AGFS_CONFIG": os.path.join(tmpdir.name, "config")}, clear=True)
+        self.env.start()
+        self.addCleanup(self.env.stop)
+
+    def test_ctx_use(self):
+        shell = Shell()
+        self.assertEqual(shell.execute("ctx set prod --server https://agfs.example.com --token secret --timeout 5"), 0)
+        self.assertEqual(shell.execute("ctx use prod"), 0)
+        self.assertEqual((shell.context, shell.server_url), ("prod", "https://agfs.example.com"))
+        self.assertEqual(shell.filesystem.server_url, "https://agfs.example.com")
+        self.assertEqual(shell.execute("ctx use missing"), 1)
+        self.assertEqual(shell.context, "prod")
+
+    def test_context_flag(self):
+        Shell().execute("ctx set dev --server http://dev:8080 --insecure")
+        with mock.patch.object(cli, 'Shell', wraps=Shell) as shell:
+            self.assertEqual(run_cli("--context", "dev", "-c", "true"), 0)
+            kwargs = shell.call_args.kwargs
+            self.assertEqual((kwargs['server_url'], kwargs['verify'], kwargs['context']), ("http://dev:8080", False, "dev"))
+        self.assertEqual(run_cli("--context", "missing", "-c", "true"), 2)
+
+
+def run_cli(*args):
+    """Run agfs-shell with args, and return its exit code"""
+    with mock.patch('sys.argv', ['agfs-shell'] + list(args)):
+        with mock.patch('sys.stdin.isatty', return_value=True):
+            try:
+                cli.main()
+            except SystemExit as e:
+                return e.code
+    return None
+
+
+class TestCLI(unittest.TestCase):
+    def test_command_string(self):
+        self.assertEqual(run_cli("-c", "true"), 0)
+        self.assertEqual(run_cli("-c", "true; false"), 1)
+        self.assertEqual(run_cli("-c", "false || exit 3"), 3)
+        self.assertEqual(run_cli("-c", "false\ntrue"), 0)
+        self.assertEqual(run_cli("-e", "-c", "false\ntrue"), 1)
+
+    def test_script_file(self):
+        with tempfile.NamedTemporaryFile('w', suffix='.agfs', delete=False) as f:
+            f.write("false\nexit 5\n")
+        self.addCleanup(os.unlink, f.name)
+        self.assertEqual(run_cli("-f", f.name), 5)
+        self.assertEqual(run_cli(f.name), 5)
+        self.assertEqual(run_cli("-e", "-f", f.name), 1)
+        self.assertEqual(run_cli("-f", f.name + ".missing"), 127)
+
+if __name__ == '__main__':
+    unittest.main()